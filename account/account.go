@@ -20,6 +20,8 @@
 package account
 
 import (
+	"bytes"
+	"context"
 	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -40,10 +42,10 @@ var FetchAssertionFromStore = func(modelType *asserts.AssertionType, headers []s
 
 // CacheAccountAssertions fetches the account/account-key assertions from the store and caches them in the database
 // (reads through the keypairs and refreshes the account/account-key assertions)
-func CacheAccountAssertions(env *datastore.Env) {
+func CacheAccountAssertions(ctx context.Context, env *datastore.Env) {
 
 	// Get the active signing-keys from the database. This operation is not filtered by authorization
-	keypairs, err := env.DB.ListAllowedKeypairs(datastore.User{})
+	keypairs, err := env.DB.ListAllowedKeypairs(ctx, datastore.User{})
 	if err != nil {
 		log.Fatalf("Error retrieving the keypairs: %v", err)
 	}
@@ -69,7 +71,7 @@ func CacheAccountAssertions(env *datastore.Env) {
 			Assertion:   string(asserts.Encode(accountAssert)),
 		}
 
-		_, err = env.DB.PutAccount(account, datastore.User{})
+		_, err = env.DB.PutAccount(ctx, account, datastore.User{})
 		if err != nil {
 			fmt.Printf("Error storing the account assertion from the store: %v\n", err)
 			continue
@@ -89,7 +91,7 @@ func CacheAccountAssertions(env *datastore.Env) {
 			Assertion:   string(asserts.Encode(accountKeyAssert)),
 		}
 
-		errorCode, err := env.DB.UpdateKeypairAssertion(keypair, datastore.User{})
+		errorCode, err := env.DB.UpdateKeypairAssertion(ctx, keypair, datastore.User{})
 		if err != nil {
 			fmt.Printf("Error on saving the account key assertion to the database: %v - %v\n", errorCode, err)
 		}
@@ -98,10 +100,10 @@ func CacheAccountAssertions(env *datastore.Env) {
 
 // CacheAccounts fetches the account assertions from the store and caches them in the database
 // (reads through the accounts and refreshes the account assertions)
-func CacheAccounts(env *datastore.Env) {
+func CacheAccounts(ctx context.Context, env *datastore.Env) {
 
 	// Get the accounts from the database. This operation is not filtered by authorization
-	accounts, err := env.DB.ListAllowedAccounts(datastore.User{})
+	accounts, err := env.DB.ListAllowedAccounts(ctx, datastore.User{})
 	if err != nil {
 		log.Fatalf("Error retrieving the keypairs: %v", err)
 	}
@@ -122,7 +124,7 @@ func CacheAccounts(env *datastore.Env) {
 			Assertion:   string(asserts.Encode(accountAssert)),
 		}
 
-		_, err = env.DB.PutAccount(account, datastore.User{})
+		_, err = env.DB.PutAccount(ctx, account, datastore.User{})
 		if err != nil {
 			fmt.Printf("Error storing the account assertion from the store: %v\n", err)
 			continue
@@ -130,3 +132,122 @@ func CacheAccounts(env *datastore.Env) {
 
 	}
 }
+
+// Refresh status of an account or account-key assertion
+const (
+	RefreshUnchanged = "unchanged"
+	RefreshChanged   = "changed"
+	RefreshFailed    = "failed"
+)
+
+// RefreshResult is the outcome of the refresh of an account or account-key assertion
+type RefreshResult struct {
+	Type        string `json:"type"`
+	AuthorityID string `json:"authorityID"`
+	KeyID       string `json:"keyID,omitempty"`
+	Status      string `json:"status"`
+	Error       string `json:"error,omitempty"`
+}
+
+// RefreshReport holds the outcome of the refresh of all the account assertions
+type RefreshReport struct {
+	Results []RefreshResult `json:"results"`
+	Changed int             `json:"changed"`
+	Failed  int             `json:"failed"`
+}
+
+func (r *RefreshReport) add(result RefreshResult) {
+	switch result.Status {
+	case RefreshChanged:
+		r.Changed++
+	case RefreshFailed:
+		r.Failed++
+	}
+	r.Results = append(r.Results, result)
+}
+
+// RefreshAccounts fetches the account assertions of all the accounts, and the account-key
+// assertions of their active signing-keys, from the store. The assertions that changed are
+// updated in the database, and the report lists the assertions that changed or failed
+func RefreshAccounts(ctx context.Context, env *datastore.Env) (RefreshReport, error) {
+	report := RefreshReport{Results: []RefreshResult{}}
+
+	// Get the accounts and keypairs from the database. This operation is not filtered by authorization
+	accounts, err := env.DB.ListAllowedAccounts(ctx, datastore.User{})
+	if err != nil {
+		return report, fmt.Errorf("error retrieving the accounts: %v", err)
+	}
+	keypairs, err := env.DB.ListAllowedKeypairs(ctx, datastore.User{})
+	if err != nil {
+		return report, fmt.Errorf("error retrieving the keypairs: %v", err)
+	}
+
+	for _, acc := range accounts {
+		report.add(refreshAccount(ctx, env, acc))
+
+		for _, k := range keypairs {
+			if k.AuthorityID != acc.AuthorityID || !k.Active {
+				continue
+			}
+			report.add(refreshAccountKey(ctx, env, k))
+		}
+	}
+
+	return report, nil
+}
+
+func refreshAccount(ctx context.Context, env *datastore.Env, acc datastore.Account) RefreshResult {
+	result := RefreshResult{Type: asserts.AccountType.Name, AuthorityID: acc.AuthorityID}
+
+	assertion, err := FetchAssertionFromStore(asserts.AccountType, []string{acc.AuthorityID})
+	if err != nil {
+		return result.failed(fmt.Errorf("error fetching the account assertion from the store: %v", err))
+	}
+
+	encoded := asserts.Encode(assertion)
+	if sameAssertion(encoded, acc.Assertion) {
+		result.Status = RefreshUnchanged
+		return result
+	}
+
+	account := datastore.Account{AuthorityID: acc.AuthorityID, Assertion: string(encoded)}
+	if _, err = env.DB.PutAccount(ctx, account, datastore.User{}); err != nil {
+		return result.failed(fmt.Errorf("error storing the account assertion: %v", err))
+	}
+
+	result.Status = RefreshChanged
+	return result
+}
+
+func refreshAccountKey(ctx context.Context, env *datastore.Env, k datastore.Keypair) RefreshResult {
+	result := RefreshResult{Type: asserts.AccountKeyType.Name, AuthorityID: k.AuthorityID, KeyID: k.KeyID}
+
+	assertion, err := FetchAssertionFromStore(asserts.AccountKeyType, []string{k.KeyID})
+	if err != nil {
+		return result.failed(fmt.Errorf("error fetching the account-key assertion from the store: %v", err))
+	}
+
+	encoded := asserts.Encode(assertion)
+	if sameAssertion(encoded, k.Assertion) {
+		result.Status = RefreshUnchanged
+		return result
+	}
+
+	keypair := datastore.Keypair{ID: k.ID, AuthorityID: k.AuthorityID, KeyID: k.KeyID, Assertion: string(encoded)}
+	if _, err = env.DB.UpdateKeypairAssertion(ctx, keypair, datastore.User{}); err != nil {
+		return result.failed(fmt.Errorf("error storing the account-key assertion: %v", err))
+	}
+
+	result.Status = RefreshChanged
+	return result
+}
+
+func (r RefreshResult) failed(err error) RefreshResult {
+	r.Status = RefreshFailed
+	r.Error = err.Error()
+	return r
+}
+
+func sameAssertion(encoded []byte, stored string) bool {
+	return bytes.Equal(bytes.TrimSpace(encoded), bytes.TrimSpace([]byte(stored)))
+}
@@ -20,6 +20,7 @@
 package account
 
 import (
+	"context"
 	"errors"
 	"testing"
 
@@ -58,7 +59,7 @@ func (s *AccountSuite) TestAccountAssertionsFromKeypairs(c *check.C) {
 			FetchAssertionFromStore = mockErrorFetchAssertionFromStore
 		}
 
-		CacheAccountAssertions(datastore.Environ)
+		CacheAccountAssertions(context.Background(), datastore.Environ)
 	}
 }
 
@@ -74,10 +75,53 @@ func (s *AccountSuite) TestAccountAssertions(c *check.C) {
 			FetchAssertionFromStore = mockErrorFetchAssertionFromStore
 		}
 
-		CacheAccounts(datastore.Environ)
+		CacheAccounts(context.Background(), datastore.Environ)
 	}
 }
 
+// refreshMockDB holds an account with the assertion from the store
+type refreshMockDB struct {
+	datastore.MockDB
+}
+
+func (mdb *refreshMockDB) ListAllowedAccounts(ctx context.Context, authorization datastore.User) ([]datastore.Account, error) {
+	assertion, _ := MockFetchAssertionFromStore(asserts.AccountType, []string{"system"})
+	return []datastore.Account{{ID: 1, AuthorityID: "system", Assertion: string(asserts.Encode(assertion))}}, nil
+}
+
+func (s *AccountSuite) TestRefreshAccounts(c *check.C) {
+	FetchAssertionFromStore = MockFetchAssertionFromStore
+	report, err := RefreshAccounts(context.Background(), datastore.Environ)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Results, check.HasLen, 3+2)
+	c.Assert(report.Changed, check.Equals, 5)
+	c.Assert(report.Failed, check.Equals, 0)
+	c.Assert(report.Results[0], check.DeepEquals, RefreshResult{Type: "account", AuthorityID: "system", Status: RefreshChanged})
+	c.Assert(report.Results[1].Type, check.Equals, "account-key")
+
+	// The account assertions fail to fetch
+	FetchAssertionFromStore = mockErrorFetchAssertionFromStore
+	report, err = RefreshAccounts(context.Background(), datastore.Environ)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Changed, check.Equals, 1)
+	c.Assert(report.Failed, check.Equals, 4)
+	c.Assert(report.Results[0].Status, check.Equals, RefreshFailed)
+	c.Assert(report.Results[0].Error, check.Matches, "error fetching the account assertion from the store: .*")
+
+	// The account assertion in the database is the latest
+	FetchAssertionFromStore = MockFetchAssertionFromStore
+	env := &datastore.Env{DB: &refreshMockDB{}}
+	report, err = RefreshAccounts(context.Background(), env)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Results[0].Status, check.Equals, RefreshUnchanged)
+	c.Assert(report.Changed, check.Equals, 2)
+
+	// The accounts cannot be listed
+	env = &datastore.Env{DB: &datastore.ErrorMockDB{}}
+	_, err = RefreshAccounts(context.Background(), env)
+	c.Assert(err, check.ErrorMatches, "error retrieving the accounts: .*")
+}
+
 // Mock the retrieval of the assertion from the store (with an error)
 func mockErrorFetchAssertionFromStore(modelType *asserts.AssertionType, headers []string) (asserts.Assertion, error) {
 
@@ -0,0 +1,245 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// SettingExportCheckpoint is the code of the setting that stores the checkpoint of the export
+const SettingExportCheckpoint = "signinglog-export"
+
+// defaultInterval is the interval of the export when it is not configured
+const defaultInterval = time.Hour
+
+// batchSize is the maximum number of signing logs in an exported object
+const batchSize = 10000
+
+// Config is the export of the signing logs from the config settings
+type Config struct {
+	Store    Store         // the object storage, nil when the export is not configured
+	Prefix   string        // prefix of the object keys
+	Interval time.Duration // interval between the exports
+}
+
+// Checkpoint is the position of the export in the signing logs
+type Checkpoint struct {
+	LastID   int       `json:"lastID"`
+	Key      string    `json:"key"`
+	Exported time.Time `json:"exported"`
+}
+
+// record is a signing log in the exported objects
+type record struct {
+	ID              int       `json:"id"`
+	Make            string    `json:"make"`
+	Model           string    `json:"model"`
+	SerialNumber    string    `json:"serialnumber"`
+	Fingerprint     string    `json:"fingerprint"`
+	Created         time.Time `json:"created"`
+	Revision        int       `json:"revision"`
+	TimestampSource string    `json:"timestampsource"`
+	SourceIP        string    `json:"sourceip"`
+	APIKeyID        string    `json:"apikeyid"`
+	RequestID       string    `json:"requestid"`
+	UserAgent       string    `json:"useragent"`
+}
+
+// NewConfig creates the export config from the config settings. The export is
+// only enabled when the bucket is configured
+func NewConfig(settings config.Settings) (Config, error) {
+	cfg := Config{Prefix: settings.SigningLogExportPrefix, Interval: defaultInterval}
+	if len(settings.SigningLogExportBucket) == 0 {
+		return cfg, nil
+	}
+
+	if len(settings.SigningLogExportAccessKey) == 0 || len(settings.SigningLogExportSecretKey) == 0 {
+		return Config{}, fmt.Errorf("the signing log export needs the access key and secret key of the bucket")
+	}
+	if len(cfg.Prefix) > 0 && !strings.HasSuffix(cfg.Prefix, "/") {
+		cfg.Prefix += "/"
+	}
+
+	if len(settings.SigningLogExportInterval) > 0 {
+		interval, err := time.ParseDuration(settings.SigningLogExportInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid signing log export interval: %v", err)
+		}
+		if interval <= 0 {
+			return Config{}, fmt.Errorf("the signing log export interval must be positive")
+		}
+		cfg.Interval = interval
+	}
+
+	cfg.Store = NewS3(settings.SigningLogExportEndpoint, settings.SigningLogExportRegion, settings.SigningLogExportBucket,
+		settings.SigningLogExportAccessKey, settings.SigningLogExportSecretKey)
+	return cfg, nil
+}
+
+// Enabled returns whether the export of the signing logs is configured
+func (cfg Config) Enabled() bool {
+	return cfg.Store != nil
+}
+
+// Job runs the export of the signing logs when the scheduler starts, and then at the interval of the config.
+// The export holds the lock of the signing logs, so it runs on one instance of the service at a time, and
+// not during the retention purge
+func Job(cfg Config) scheduler.Job {
+	return scheduler.Job{Name: "signinglog-export", Interval: cfg.Interval, RunAtStart: true, Run: func(ctx context.Context) error {
+		db := datastore.Environ.DB
+		locked, err := db.RunJobLocked(ctx, datastore.JobLockSigningLog, func(ctx context.Context) error {
+			_, err := Export(ctx, db, cfg, time.Now().UTC())
+			return err
+		})
+		if err == nil && !locked {
+			log.Infof("Signing log export: skipped, the signing logs are locked by another job")
+		}
+		return err
+	}}
+}
+
+// Export uploads the new signing logs to the object storage as gzipped NDJSON, in batches,
+// and returns the number of exported logs. The checkpoint is stored after each upload, so
+// each log is exported once. The logs are exported up to the watermark of the committed logs,
+// so a log that is committed after a log with a greater ID is not skipped. The object key is set
+// by the first log of the batch, so a batch that is uploaded again, after an error storing the
+// checkpoint, replaces the object
+func Export(ctx context.Context, db datastore.Datastore, cfg Config, now time.Time) (int, error) {
+	checkpoint, err := LoadCheckpoint(ctx, db)
+	if err != nil {
+		log.Errorf("Error loading the signing log export checkpoint: %v", err)
+		return 0, err
+	}
+
+	watermark, err := db.SigningLogWatermark(ctx, now)
+	if err != nil {
+		log.Errorf("Error exporting the signing logs: %v", err)
+		return 0, err
+	}
+
+	exported := 0
+	for {
+		logs, err := db.ExportSigningLog(ctx, checkpoint.LastID, watermark, batchSize)
+		if err != nil {
+			log.Errorf("Error exporting the signing logs: %v", err)
+			return exported, err
+		}
+		if len(logs) == 0 {
+			break
+		}
+
+		body, err := encode(logs)
+		if err != nil {
+			log.Errorf("Error exporting the signing logs: %v", err)
+			return exported, err
+		}
+
+		key := fmt.Sprintf("%ssigninglog-%012d.ndjson.gz", cfg.Prefix, logs[0].ID)
+		if err = cfg.Store.Put(ctx, key, "application/gzip", body); err != nil {
+			log.Errorf("Error exporting the signing logs: %v", err)
+			return exported, err
+		}
+
+		checkpoint = Checkpoint{LastID: logs[len(logs)-1].ID, Key: key, Exported: now}
+		if err = storeCheckpoint(ctx, db, checkpoint); err != nil {
+			log.Errorf("Error storing the signing log export checkpoint: %v", err)
+			return exported, err
+		}
+		exported += len(logs)
+
+		if len(logs) < batchSize {
+			break
+		}
+	}
+
+	log.Infof("Signing log export: %d signing logs exported", exported)
+	return exported, nil
+}
+
+// LoadCheckpoint returns the stored checkpoint of the export, or the start of the signing
+// logs when nothing has been exported
+func LoadCheckpoint(ctx context.Context, db datastore.Datastore) (Checkpoint, error) {
+	setting, err := db.GetSetting(ctx, SettingExportCheckpoint)
+	if err == sql.ErrNoRows {
+		return Checkpoint{}, nil
+	}
+	if err != nil {
+		return Checkpoint{}, err
+	}
+
+	checkpoint := Checkpoint{}
+	if err = json.Unmarshal([]byte(setting.Data), &checkpoint); err != nil {
+		return Checkpoint{}, fmt.Errorf("invalid signing log export checkpoint: %v", err)
+	}
+	return checkpoint, nil
+}
+
+func storeCheckpoint(ctx context.Context, db datastore.Datastore, checkpoint Checkpoint) error {
+	data, err := json.Marshal(checkpoint)
+	if err != nil {
+		return err
+	}
+	return db.PutSetting(ctx, datastore.Setting{Code: SettingExportCheckpoint, Data: string(data)})
+}
+
+// encode writes the signing logs as gzipped NDJSON, one record per line
+func encode(logs []datastore.SigningLog) ([]byte, error) {
+	var b bytes.Buffer
+	zw := gzip.NewWriter(&b)
+	encoder := json.NewEncoder(zw)
+
+	for _, l := range logs {
+		err := encoder.Encode(record{
+			ID:              l.ID,
+			Make:            l.Make,
+			Model:           l.Model,
+			SerialNumber:    l.SerialNumber,
+			Fingerprint:     l.Fingerprint,
+			Created:         l.Created.UTC(),
+			Revision:        l.Revision,
+			TimestampSource: l.TimestampSource,
+			SourceIP:        l.SourceIP,
+			APIKeyID:        l.APIKeyID,
+			RequestID:       l.RequestID,
+			UserAgent:       l.UserAgent,
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package archive
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// memoryStore holds the uploaded objects in memory
+type memoryStore struct {
+	objects map[string][]byte
+	err     error
+}
+
+func (m *memoryStore) Put(ctx context.Context, key, contentType string, body []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.objects[key] = body
+	return nil
+}
+
+func TestNewConfig(t *testing.T) {
+	bucket := config.Settings{SigningLogExportBucket: "logs", SigningLogExportAccessKey: "key", SigningLogExportSecretKey: "secret"}
+
+	tests := []struct {
+		prefix   string
+		interval string
+		secret   string
+		want     time.Duration
+		valid    bool
+	}{
+		{"", "", "secret", defaultInterval, true},
+		{"vault", "6h", "secret", 6 * time.Hour, true},
+		{"", "", "", 0, false},
+		{"", "invalid", "secret", 0, false},
+		{"", "0s", "secret", 0, false},
+	}
+
+	for _, tt := range tests {
+		settings := bucket
+		settings.SigningLogExportPrefix = tt.prefix
+		settings.SigningLogExportInterval = tt.interval
+		settings.SigningLogExportSecretKey = tt.secret
+
+		cfg, err := NewConfig(settings)
+		if (err == nil) != tt.valid {
+			t.Errorf("Export %q/%q: expected valid %v, got error: %v", tt.prefix, tt.interval, tt.valid, err)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if !cfg.Enabled() || cfg.Interval != tt.want {
+			t.Errorf("Export %q/%q: unexpected config: %v", tt.prefix, tt.interval, cfg)
+		}
+		if len(tt.prefix) > 0 && cfg.Prefix != tt.prefix+"/" {
+			t.Errorf("Export %q/%q: unexpected prefix: %q", tt.prefix, tt.interval, cfg.Prefix)
+		}
+	}
+
+	cfg, err := NewConfig(config.Settings{})
+	if err != nil || cfg.Enabled() {
+		t.Errorf("Export: expected the export to be disabled: %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	db := &datastore.MockDB{}
+	store := &memoryStore{objects: map[string][]byte{}}
+	cfg := Config{Store: store, Prefix: "vault/", Interval: defaultInterval}
+
+	exported, err := Export(context.Background(), db, cfg, time.Now())
+	if err != nil {
+		t.Fatalf("Export: unexpected error: %v", err)
+	}
+	if exported != 10 {
+		t.Errorf("Export: expected 10 signing logs, got %d", exported)
+	}
+
+	body, ok := store.objects["vault/signinglog-000000000001.ndjson.gz"]
+	if !ok {
+		t.Fatalf("Export: unexpected objects: %v", store.objects)
+	}
+	records := decode(t, body)
+	if len(records) != 10 || records[0].ID != 1 || records[9].ID != 10 {
+		t.Errorf("Export: unexpected records: %v", records)
+	}
+
+	checkpoint, err := LoadCheckpoint(context.Background(), db)
+	if err != nil {
+		t.Fatalf("Export: unexpected checkpoint error: %v", err)
+	}
+	if checkpoint.LastID != 10 || checkpoint.Key != "vault/signinglog-000000000001.ndjson.gz" {
+		t.Errorf("Export: unexpected checkpoint: %v", checkpoint)
+	}
+
+	// The signing logs are only exported once
+	exported, err = Export(context.Background(), db, cfg, time.Now())
+	if err != nil || exported != 0 || len(store.objects) != 1 {
+		t.Errorf("Export: expected nothing to export, got %d: %v", exported, err)
+	}
+}
+
+func TestExportError(t *testing.T) {
+	// The checkpoint is not moved when the upload fails
+	db := &datastore.MockDB{}
+	store := &memoryStore{objects: map[string][]byte{}, err: errors.New("MOCK upload error")}
+	cfg := Config{Store: store, Interval: defaultInterval}
+
+	if _, err := Export(context.Background(), db, cfg, time.Now()); err == nil {
+		t.Error("Export: expected an upload error")
+	}
+	checkpoint, err := LoadCheckpoint(context.Background(), db)
+	if err != nil || checkpoint.LastID != 0 {
+		t.Errorf("Export: unexpected checkpoint: %v: %v", checkpoint, err)
+	}
+
+	store.err = nil
+	if _, err = Export(context.Background(), &datastore.ErrorMockDB{}, cfg, time.Now()); err == nil {
+		t.Error("Export: expected a database error")
+	}
+}
+
+func decode(t *testing.T, body []byte) []record {
+	zr, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("Export: invalid gzip object: %v", err)
+	}
+
+	records := []record{}
+	scanner := bufio.NewScanner(zr)
+	for scanner.Scan() {
+		r := record{}
+		if err = json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			t.Fatalf("Export: invalid record: %v", err)
+		}
+		records = append(records, r)
+	}
+	return records
+}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package archive
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Store is the object storage that the signing logs are exported to
+type Store interface {
+	Put(ctx context.Context, key, contentType string, body []byte) error
+}
+
+// S3 stores the objects in an S3-compatible bucket, with path-style URLs and AWS
+// signature version 4, so it also works with the other S3-compatible services
+type S3 struct {
+	Endpoint  string
+	Region    string
+	Bucket    string
+	AccessKey string
+	SecretKey string
+	Client    *http.Client
+}
+
+// s3Timeout is the timeout of the upload of an object
+const s3Timeout = 5 * time.Minute
+
+// emptyPayloadHash is the hash of an empty request body
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// NewS3 creates the S3 store, defaulting the endpoint to AWS for the region
+func NewS3(endpoint, region, bucket, accessKey, secretKey string) *S3 {
+	if len(region) == 0 {
+		region = "us-east-1"
+	}
+	if len(endpoint) == 0 {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return &S3{
+		Endpoint:  strings.TrimSuffix(endpoint, "/"),
+		Region:    region,
+		Bucket:    bucket,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+		Client:    &http.Client{Timeout: s3Timeout},
+	}
+}
+
+// Put uploads the object to the bucket. The MD5 of the body is sent, as it is required
+// by the buckets with object lock, which keep an immutable copy of the objects
+func (s *S3) Put(ctx context.Context, key, contentType string, body []byte) error {
+	r, err := http.NewRequestWithContext(ctx, "PUT", s.Endpoint+"/"+s.Bucket+"/"+key, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	payload := sha256.Sum256(body)
+	digest := md5.Sum(body)
+	r.Header.Set("Content-Type", contentType)
+	r.Header.Set("Content-MD5", base64.StdEncoding.EncodeToString(digest[:]))
+	r.Header.Set("X-Amz-Content-Sha256", hex.EncodeToString(payload[:]))
+	s.sign(r, time.Now().UTC())
+
+	w, err := s.Client.Do(r)
+	if err != nil {
+		return fmt.Errorf("error uploading %s: %v", key, err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(w.Body)
+		return fmt.Errorf("error uploading %s: %s: %s", key, w.Status, strings.TrimSpace(string(message)))
+	}
+	return nil
+}
+
+// sign adds the AWS signature version 4 to the request. All the headers of the request
+// are signed, and the payload hash is taken from the X-Amz-Content-Sha256 header
+func (s *S3) sign(r *http.Request, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/s3/aws4_request", now.Format("20060102"), s.Region)
+
+	r.Header.Set("X-Amz-Date", amzDate)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if len(payloadHash) == 0 {
+		payloadHash = emptyPayloadHash
+		r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	}
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path),
+		r.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.SecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, s.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.AccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// uriEncode encodes the path of the object in the way of the signature, which
+// only leaves the unreserved characters and the separators
+func uriEncode(path string) string {
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		c := path[i]
+		if (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9') || strings.IndexByte("-_.~/", c) >= 0 {
+			b.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&b, "%%%02X", c)
+	}
+	return b.String()
+}
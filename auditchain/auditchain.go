@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package auditchain
+
+import (
+	"context"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// appendInterval is the interval between the appends of the new records to the audit chain
+const appendInterval = time.Minute
+
+// Job links the new records into the audit chain when the scheduler starts, and then at the interval
+func Job() scheduler.Job {
+	return scheduler.Job{Name: "audit-chain", Interval: appendInterval, RunAtStart: true, Run: func(ctx context.Context) error {
+		_, err := Append(ctx, datastore.Environ.DB)
+		return err
+	}}
+}
+
+// Append links the new records into the audit chain
+func Append(ctx context.Context, db datastore.Datastore) (int, error) {
+	appended, err := db.AppendAuditChain(ctx, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error appending to the audit chain: %v", err)
+		return appended, err
+	}
+
+	if appended > 0 {
+		log.Infof("Audit chain: %d records appended", appended)
+	}
+	return appended, nil
+}
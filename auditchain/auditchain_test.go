@@ -0,0 +1,39 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package auditchain
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestAppend(t *testing.T) {
+	appended, err := Append(context.Background(), &datastore.MockDB{})
+	if err != nil || appended != 2 {
+		t.Errorf("Append: expected 2 records appended, got %d: %v", appended, err)
+	}
+
+	if _, err = Append(context.Background(), &datastore.ErrorMockDB{}); err == nil {
+		t.Error("Append: expected an error")
+	}
+}
@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package canary
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
+)
+
+// defaultInterval is the interval of the keystore check when it is not configured
+const defaultInterval = time.Hour
+
+// status holds the result of the last check of the keystore canary
+var status struct {
+	sync.RWMutex
+	checked time.Time
+	err     error
+}
+
+// Interval returns the interval of the keystore check from the config settings
+func Interval(settings config.Settings) (time.Duration, error) {
+	if len(settings.KeystoreCheckInterval) == 0 {
+		return defaultInterval, nil
+	}
+
+	interval, err := time.ParseDuration(settings.KeystoreCheckInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid keystore check interval: %v", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("the keystore check interval must be positive")
+	}
+	return interval, nil
+}
+
+// Job checks the keystore canary when the scheduler starts, and then at the interval
+func Job(interval time.Duration) scheduler.Job {
+	return scheduler.Job{Name: "keystore-check", Interval: interval, RunAtStart: true, Local: true, Run: Check}
+}
+
+// Check verifies that the keystore secret still decrypts the keystore canary, recording the result.
+// A failure is alerted immediately, as the signing-keys can no longer be unsealed
+func Check(ctx context.Context) error {
+	err := datastore.CheckKeystoreCanary(ctx)
+
+	status.Lock()
+	status.checked = time.Now().UTC()
+	status.err = err
+	status.Unlock()
+
+	if err != nil {
+		log.Errorf("ALERT: keystore check failed: %v", err)
+		metric.KeystoreCanaryGauge.Set(0)
+		return err
+	}
+
+	metric.KeystoreCanaryGauge.Set(1)
+	return nil
+}
+
+// Status returns the time and the result of the last check of the keystore canary
+func Status() (time.Time, error) {
+	status.RLock()
+	defer status.RUnlock()
+	return status.checked, status.err
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package canary
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+		valid    bool
+	}{
+		{"", defaultInterval, true},
+		{"15m", 15 * time.Minute, true},
+		{"invalid", 0, false},
+		{"0s", 0, false},
+	}
+
+	for _, tt := range tests {
+		interval, err := Interval(config.Settings{KeystoreCheckInterval: tt.interval})
+		if (err == nil) != tt.valid {
+			t.Errorf("Interval %q: expected valid %v, got error: %v", tt.interval, tt.valid, err)
+			continue
+		}
+		if interval != tt.want {
+			t.Errorf("Interval %q: expected %v, got %v", tt.interval, tt.want, interval)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{KeyStoreType: "database", KeyStoreSecret: "secret"}}
+
+	// The mock settings return an invalid canary
+	if err := Check(context.Background()); err == nil {
+		t.Error("Check: expected an error")
+	}
+	checked, err := Status()
+	if checked.IsZero() || err == nil {
+		t.Errorf("Status: expected the failed check to be recorded, got %v, %v", checked, err)
+	}
+
+	datastore.Environ.Config.KeyStoreType = "filesystem"
+	if err := Check(context.Background()); err != nil {
+		t.Errorf("Check: unexpected error: %v", err)
+	}
+	if _, err = Status(); err != nil {
+		t.Errorf("Status: unexpected error: %v", err)
+	}
+}
@@ -20,18 +20,43 @@
 package main
 
 import (
-	"log"
+	"context"
+	"crypto/tls"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
+	"github.com/CanonicalLtd/serial-vault/archive"
+	"github.com/CanonicalLtd/serial-vault/auditchain"
+	"github.com/CanonicalLtd/serial-vault/canary"
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/fips"
+	"github.com/CanonicalLtd/serial-vault/ldapsync"
+	"github.com/CanonicalLtd/serial-vault/logpolicy"
+	"github.com/CanonicalLtd/serial-vault/notify"
+	"github.com/CanonicalLtd/serial-vault/retention"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
-	logging "github.com/op/go-logging"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
+	"github.com/CanonicalLtd/serial-vault/service/rpc"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+	"github.com/CanonicalLtd/serial-vault/shutdown"
+	"github.com/CanonicalLtd/serial-vault/sync"
+	"github.com/CanonicalLtd/serial-vault/timestamp"
+	"github.com/CanonicalLtd/serial-vault/tlscert"
+	"github.com/CanonicalLtd/serial-vault/webhook"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 )
 
 func init() {
-	svlog.InitLogger(logging.INFO)
+	svlog.InitLogger(svlog.INFO)
 }
 
 func main() {
@@ -42,9 +67,31 @@ func main() {
 	if err != nil {
 		svlog.Fatalf("Error parsing the config file: %v", err)
 	}
+	if err = svlog.SetFormat(datastore.Environ.Config.LogFormat); err != nil {
+		svlog.Fatalf("Error in the log config: %v", err)
+	}
+
+	// Send the log entries to the syslog endpoint as well, if it is configured
+	syslog, err := logpolicy.Syslog(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the syslog config: %v", err)
+	}
+	if syslog != nil {
+		svlog.SetSyslog(syslog)
+	}
+
+	// Refuse to start with crypto that is not approved, in FIPS mode
+	if err = fips.Check(datastore.Environ.Config); err != nil {
+		svlog.Fatalf("Error in the FIPS config: %v", err)
+	}
+	if fips.Enabled(datastore.Environ.Config) {
+		http.DefaultTransport.(*http.Transport).TLSClientConfig = fips.TLSConfig(&tls.Config{})
+		svlog.Infof("FIPS mode is enabled")
+	}
 
 	// Open the connection to the local database
 	datastore.OpenSysDatabase(datastore.Environ.Config.Driver, datastore.Environ.Config.DataSource)
+	metric.DatabaseStats = datastore.PoolStats
 
 	// Opening the keypair manager to create the signing database
 	err = datastore.OpenKeyStore(datastore.Environ.Config)
@@ -52,20 +99,289 @@ func main() {
 		svlog.Fatalf("Error initializing the signing-key database: %v", err)
 	}
 
+	// Check the time source for the assertion timestamps
+	if _, err = timestamp.NewSource(datastore.Environ.Config); err != nil {
+		svlog.Fatalf("Error in the timestamp source config: %v", err)
+	}
+
+	// Check the keystore secret still decrypts the keystore canary, at startup and periodically
+	interval, err := canary.Interval(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the keystore check config: %v", err)
+	}
+	schedule(canary.Job(interval))
+
+	// Export the traces of the requests to the OTLP collector, if it is configured
+	traces, err := tracing.NewConfig(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the tracing config: %v", err)
+	}
+	if err = tracing.Init(traces); err != nil {
+		svlog.Fatalf("Error initializing the tracing: %v", err)
+	}
+	http.DefaultTransport = tracing.Transport(http.DefaultTransport)
+
+	// Check the networks of the proxies, whose X-Forwarded-For header gives the source IP
+	if _, err = datastore.TrustedProxies(datastore.Environ.Config); err != nil {
+		svlog.Fatalf("Error in the trusted proxies config: %v", err)
+	}
+
+	// Check the middleware chain of the routers
+	if _, err = service.MiddlewareChain(datastore.Environ.Config); err != nil {
+		svlog.Fatalf("Error in the middleware config: %v", err)
+	}
+
+	// Time to wait for the requests in progress when the service is stopped
+	timeout, err := shutdown.Timeout(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the shutdown config: %v", err)
+	}
+
+	// Terminate TLS in the service, if the certificate is configured
+	var certs *tlscert.Reloader
+	var tlsConfig *tls.Config
+	useTLS, err := tlscert.Enabled(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the TLS config: %v", err)
+	}
+	if useTLS {
+		certs, err = tlscert.NewReloader(datastore.Environ.Config.TLSCertFile, datastore.Environ.Config.TLSKeyFile)
+		if err != nil {
+			svlog.Fatalf("Error in the TLS config: %v", err)
+		}
+		schedule(certs.Job())
+		tlsConfig = certs.TLSConfig()
+		if fips.Enabled(datastore.Environ.Config) {
+			tlsConfig = fips.TLSConfig(tlsConfig)
+		}
+	}
+
+	// Apply the logging policy, reloading the changes from the admin API
+	logpolicy.Init(context.Background(), datastore.Environ.Config)
+	schedule(logpolicy.Job(datastore.Environ.Config))
+
+	// Check the email notifications and the chat channels of the events, which the admin and signing
+	// services send
+	notifications, err := notify.NewConfig(datastore.Environ.Config)
+	if err != nil {
+		svlog.Fatalf("Error in the notification config: %v", err)
+	}
+	if _, err := webhook.NewChatConfig(datastore.Environ.Config); err != nil {
+		svlog.Fatalf("Error in the chat config: %v", err)
+	}
+
 	var handler http.Handler
 	var address string
+	var grpcServer *grpc.Server
 
 	switch config.ServiceMode {
 	case "admin":
 		// Create the admin web service router
 		handler = service.AdminRouter()
 		address = ":8081"
+
+		// Start the background purge of the signing logs
+		policy, err := retention.NewPolicy(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the signing log retention config: %v", err)
+		}
+		schedule(retention.Job(policy))
+
+		// Link the new signing logs and audit logs into the tamper-evident audit chain
+		schedule(auditchain.Job())
+
+		// Deliver the queued events to the webhooks of the accounts
+		interval, err := webhook.Interval(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the webhook config: %v", err)
+		}
+		schedule(webhook.Job(interval))
+
+		// Start the background export of the signing logs, if it is configured
+		export, err := archive.NewConfig(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the signing log export config: %v", err)
+		}
+		if export.Enabled() {
+			schedule(archive.Job(export))
+		}
+
+		// Start the background sync of the users from the LDAP groups, if it is configured
+		ldap, err := ldapsync.NewConfig(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the LDAP sync config: %v", err)
+		}
+		if ldap.Enabled() {
+			schedule(ldapsync.Job(ldap))
+		}
+
+		// Notify the signing-keys that are about to expire, if it is configured
+		if notify.Enabled(notifications, notify.EventKeyExpiry) {
+			schedule(notify.ExpiryJob(notifications))
+		}
+
+		// Start the gRPC admin service, if it is configured
+		if len(datastore.Environ.Config.GRPCAdminAddress) > 0 {
+			opts := []grpc.ServerOption{}
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			grpcServer = rpc.NewAdminServer(opts...)
+			go serveGRPC(grpcServer, datastore.Environ.Config.GRPCAdminAddress)
+		}
 	default:
 		// Create the user web service router
 		handler = service.SigningRouter()
 		address = ":8080"
+
+		// Delete the expired nonces of the serial requests
+		if _, err = datastore.NonceTTL(datastore.Environ.Config); err != nil {
+			svlog.Fatalf("Error in the nonce config: %v", err)
+		}
+		purge, err := datastore.NoncePurgeInterval(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the nonce config: %v", err)
+		}
+		schedule(scheduler.Job{Name: "nonce-purge", Interval: purge, RunAtStart: true,
+			Run: datastore.Environ.DB.DeleteExpiredDeviceNonces})
+
+		// Delete the expired signatures of the reseller API requests
+		schedule(scheduler.Job{Name: "signature-purge", Interval: purge,
+			Run: datastore.Environ.DB.DeleteExpiredRequestSignatures})
+
+		// Sign the stored model assertions again when the headers or the signing key of their model change
+		refresh, err := assertion.RefreshInterval(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the model assertion refresh config: %v", err)
+		}
+		schedule(assertion.RefreshJob(refresh))
+
+		// Write the signing logs in the background, when the buffer is configured
+		if datastore.Environ.Config.SigningLogBuffer {
+			datastore.Environ.DB.StartSigningLogBuffer(context.Background())
+		}
+
+		// Sync the factory with the cloud serial vault, in sync mode
+		factorySync, err := sync.NewConfig(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the sync config: %v", err)
+		}
+		if factorySync.Enabled() {
+			schedule(sync.Job(factorySync))
+		}
+
+		// Verify the client certificates of the factories, if mutual TLS is configured
+		clientAuth, clientCAs, err := tlscert.ClientAuth(datastore.Environ.Config)
+		if err != nil {
+			svlog.Fatalf("Error in the TLS config: %v", err)
+		}
+		if clientCAs != nil {
+			if tlsConfig == nil {
+				svlog.Fatalf("Error in the TLS config: the client CA needs the TLS certificate and key of the service")
+			}
+			tlsConfig.ClientAuth = clientAuth
+			tlsConfig.ClientCAs = clientCAs
+		}
+
+		// Start the gRPC signing service, if it is configured
+		if len(datastore.Environ.Config.GRPCAddress) > 0 {
+			opts := []grpc.ServerOption{}
+			if tlsConfig != nil {
+				opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+			}
+			grpcServer = rpc.NewServer(opts...)
+			go serveGRPC(grpcServer, datastore.Environ.Config.GRPCAddress)
+		}
 	}
 
+	// Run the recurring jobs of the service, each on one instance at a time
+	scheduler.Default.SetLocker(datastore.Environ.DB.RunJobLocked)
+	scheduler.Start()
+
+	server := &http.Server{Addr: address, Handler: handler}
+	exitCode := make(chan int)
+	go waitForShutdown(context.Background(), server, grpcServer, timeout, exitCode)
+
 	svlog.Infof("Starting service on port %s", address)
-	log.Fatal(http.ListenAndServe(address, handler))
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != http.ErrServerClosed {
+		svlog.Fatal(err)
+	}
+	code := <-exitCode
+
+	// Send the waiting log entries to the syslog endpoint before exiting
+	svlog.SetSyslog(nil)
+	os.Exit(code)
+}
+
+func serveGRPC(srv *grpc.Server, address string) {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		svlog.Fatalf("Error starting the gRPC service: %v", err)
+	}
+
+	svlog.Infof("Starting gRPC service on %s", address)
+	if err := srv.Serve(lis); err != nil {
+		svlog.Fatal(err)
+	}
+}
+
+// waitForShutdown stops accepting connections and drains the requests in progress, the keypair
+// generation and the scheduled jobs, then writes the buffered signing logs and the remaining traces
+// and closes the database
+func waitForShutdown(ctx context.Context, server *http.Server, grpcServer *grpc.Server, timeout time.Duration, exitCode chan<- int) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	sig := <-signals
+
+	svlog.Infof("Stopping service (%s), waiting up to %s for the requests in progress", sig, timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	code := 0
+
+	if err := server.Shutdown(ctx); err != nil {
+		svlog.Errorf("Error waiting for the requests in progress: %v", err)
+		code = 1
+	}
+	if grpcServer != nil {
+		if err := shutdown.StopGRPC(ctx, grpcServer); err != nil {
+			svlog.Errorf("Error waiting for the gRPC requests in progress: %v", err)
+			code = 1
+		}
+	}
+	if err := datastore.WaitForBackgroundJobs(ctx); err != nil {
+		svlog.Errorf("Error waiting for the keypair generation: %v", err)
+		code = 1
+	}
+	if err := scheduler.Stop(ctx); err != nil {
+		svlog.Errorf("Error waiting for the scheduled jobs: %v", err)
+		code = 1
+	}
+
+	// The signing logs of the drained requests are written before the database is closed
+	if err := datastore.Environ.DB.StopSigningLogBuffer(ctx); err != nil {
+		svlog.Errorf("Error writing the buffered signing logs: %v", err)
+		code = 1
+	}
+	if err := tracing.Shutdown(ctx); err != nil {
+		svlog.Errorf("Error exporting the traces: %v", err)
+	}
+	if err := datastore.Environ.DB.Close(); err != nil {
+		svlog.Errorf("Error closing the database: %v", err)
+	}
+
+	svlog.Infof("Stopped service")
+	exitCode <- code
+}
+
+// schedule adds a recurring job to the scheduler of the service
+func schedule(job scheduler.Job) {
+	if err := scheduler.Add(job); err != nil {
+		svlog.Fatalf("Error scheduling the job: %v", err)
+	}
 }
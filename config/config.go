@@ -21,8 +21,15 @@ package config
 
 import (
 	"flag"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
 
+	"github.com/CanonicalLtd/serial-vault/secrets"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"gopkg.in/yaml.v2"
@@ -33,24 +40,207 @@ const version = "2.5-0"
 
 // Settings defines the parsed config file settings.
 type Settings struct {
-	Version        string
-	Title          string `yaml:"title"`
-	Logo           string `yaml:"logo"`
-	DocRoot        string `yaml:"docRoot"`
-	Driver         string `yaml:"driver"`
-	DataSource     string `yaml:"datasource"`
-	KeyStoreType   string `yaml:"keystore"`
-	KeyStorePath   string `yaml:"keystorePath"`
-	KeyStoreSecret string `yaml:"keystoreSecret"`
-	Mode           string `yaml:"mode"`
-	CSRFAuthKey    string `yaml:"csrfAuthKey"`
-	URLHost        string `yaml:"urlHost"`
-	URLScheme      string `yaml:"urlScheme"`
-	EnableUserAuth bool   `yaml:"enableUserAuth"`
-	JwtSecret      string `yaml:"jwtSecret"`
-	SyncURL        string `yaml:"syncUrl"`
-	SyncUser       string `yaml:"syncUser"`
-	SyncAPIKey     string `yaml:"syncAPIKey"`
+	Version         string
+	Title           string `yaml:"title"`
+	Logo            string `yaml:"logo"`
+	DocRoot         string `yaml:"docRoot"`
+	Driver          string `yaml:"driver"`
+	DataSource      string `yaml:"datasource"`
+	KeyStoreType    string `yaml:"keystore"`
+	KeyStorePath    string `yaml:"keystorePath"`
+	KeyStoreSecret  string `yaml:"keystoreSecret"`
+	Mode            string `yaml:"mode"`
+	CSRFAuthKey     string `yaml:"csrfAuthKey"`
+	URLHost         string `yaml:"urlHost"`
+	URLScheme       string `yaml:"urlScheme"`
+	EnableUserAuth  bool   `yaml:"enableUserAuth"`
+	JwtSecret       string `yaml:"jwtSecret"`
+	RefreshTokenTTL string `yaml:"refreshTokenTTL"`
+	ServiceTokenTTL string `yaml:"serviceTokenTTL"`
+	SyncURL         string `yaml:"syncUrl"`
+	SyncUser        string `yaml:"syncUser"`
+	SyncAPIKey      string `yaml:"syncAPIKey"`
+	SyncInterval    string `yaml:"syncInterval"`
+	SyncMode        bool   `yaml:"syncMode"`
+	GRPCAddress     string `yaml:"grpcAddress"`
+	TimestampSource string `yaml:"timestampSource"`
+	TimestampServer string `yaml:"timestampServer"`
+	SerialHashSalt  string `yaml:"serialHashSalt"`
+
+	// CA certificate file of the Time-Stamping Authority, that the time-stamp tokens are verified with
+	TimestampCAFile string `yaml:"timestampCAFile"`
+
+	// Address of the gRPC admin service (admin mode only), for the account, keypair and model methods
+	GRPCAdminAddress string `yaml:"grpcAdminAddress"`
+
+	// Read-only replica of the database, for the signing log list and the model and account lookups.
+	// The other queries, and all the writes, use the primary datasource
+	ReadDataSource string `yaml:"readDatasource"`
+
+	// Limits of the database connection pool, and the time that a connection is reused for
+	DBMaxOpenConns    int    `yaml:"dbMaxOpenConns"`
+	DBMaxIdleConns    int    `yaml:"dbMaxIdleConns"`
+	DBConnMaxLifetime string `yaml:"dbConnMaxLifetime"`
+	DBConnMaxIdleTime string `yaml:"dbConnMaxIdleTime"`
+
+	// Duration of the database queries that are logged as slow, zero to disable the log
+	SlowQueryThreshold string `yaml:"slowQueryThreshold"`
+
+	// Cache of the model and API key lookups of the signing path, for the TTL (empty disables the
+	// cache). The cache is in Redis when the address is set, otherwise in the memory of the service
+	CacheTTL           string `yaml:"cacheTTL"`
+	CacheRedisAddress  string `yaml:"cacheRedisAddress"`
+	CacheRedisPassword string `yaml:"cacheRedisPassword"`
+
+	// Number of unsealed signing-keys that are held in memory by the database and TPM keystores
+	KeypairCacheSize int `yaml:"keypairCacheSize"`
+
+	// Restrict the crypto to the FIPS-approved algorithms, refusing to start with a keystore
+	// or a key that is not approved. The mode is always on when built with the fips tag
+	FIPSMode bool `yaml:"fipsMode"`
+
+	// Encrypt the account assertions at rest, as well as the named API keys. The keys of the
+	// column encryption are derived from the keystore secret
+	EncryptAssertions bool `yaml:"encryptAssertions"`
+
+	// Retention of the signing logs in months (zero keeps them forever), and the interval of the purge
+	SigningLogRetention     int    `yaml:"signingLogRetention"`
+	SigningLogPurgeInterval string `yaml:"signingLogPurgeInterval"`
+
+	// Interval of the check that the keystore secret still decrypts the keystore canary
+	KeystoreCheckInterval string `yaml:"keystoreCheckInterval"`
+
+	// Interval of the refresh of the stored model assertions, which are signed again when their headers change
+	ModelAssertionRefreshInterval string `yaml:"modelAssertionRefreshInterval"`
+
+	// Validity of the nonces of the serial requests, and the interval of the deletion of the expired nonces
+	NonceTTL           string `yaml:"nonceTTL"`
+	NoncePurgeInterval string `yaml:"noncePurgeInterval"`
+
+	// Interval of the deliveries of the queued webhook events
+	WebhookDeliveryInterval string `yaml:"webhookDeliveryInterval"`
+
+	// Incoming webhooks of the Slack or Microsoft Teams channels of the deployment, which are posted
+	// the events of all the accounts, and the events that are posted
+	SlackWebhookURL string   `yaml:"slackWebhookURL"`
+	TeamsWebhookURL string   `yaml:"teamsWebhookURL"`
+	ChatEvents      []string `yaml:"chatEvents"`
+
+	// Time that the previous key of a rotated API key stays valid
+	APIKeyGrace string `yaml:"apiKeyGrace"`
+
+	// Write the signing logs in the background, instead of before the signing response
+	SigningLogBuffer bool `yaml:"signingLogBuffer"`
+
+	// TLS certificate and key of the service, when it terminates TLS itself. The files are
+	// reloaded when they change, e.g. when the certificate is renewed
+	TLSCertFile string `yaml:"tlsCertFile"`
+	TLSKeyFile  string `yaml:"tlsKeyFile"`
+
+	// CA certificate file of the client certificates of the factories, to enable mutual TLS on the
+	// signing service. The client auth is "optional" (default), where a mapped certificate replaces
+	// the API key, or "required", where every connection must present a certificate
+	TLSClientCAFile string `yaml:"tlsClientCAFile"`
+	TLSClientAuth   string `yaml:"tlsClientAuth"`
+
+	// Names of the middlewares of the routers, in order from the outermost
+	Middlewares []string `yaml:"middlewares"`
+
+	// Smallest response in bytes that is compressed by the gzip middleware
+	GzipMinSize int `yaml:"gzipMinSize"`
+
+	// Sentry DSN to forward the panics of the handlers to, e.g. https://public-key@sentry.example.com/42
+	SentryDSN string `yaml:"sentryDSN"`
+
+	// Time to wait for the requests in progress and the background jobs when the service is stopped
+	ShutdownTimeout string `yaml:"shutdownTimeout"`
+
+	// Output format of the logs: text (default) or json, with one JSON object per line
+	LogFormat string `yaml:"logFormat"`
+
+	// Syslog endpoint that is sent the log entries besides the standard error, e.g.
+	// udp://syslog.example.com:514, tcp:// or tls://, with the facility of the entries (default daemon)
+	// and the CA of the TLS endpoint (default the system CAs)
+	LogSyslogURL      string `yaml:"logSyslogURL"`
+	LogSyslogFacility string `yaml:"logSyslogFacility"`
+	LogSyslogCAFile   string `yaml:"logSyslogCAFile"`
+
+	// Mask the serial numbers and key IDs in the logs, until the policy is changed at runtime
+	LogMaskSerials bool `yaml:"logMaskSerials"`
+	LogMaskKeyIDs  bool `yaml:"logMaskKeyIDs"`
+
+	// Export of the signing logs to an S3-compatible bucket, by the admin service
+	SigningLogExportEndpoint  string `yaml:"signingLogExportEndpoint"`
+	SigningLogExportRegion    string `yaml:"signingLogExportRegion"`
+	SigningLogExportBucket    string `yaml:"signingLogExportBucket"`
+	SigningLogExportPrefix    string `yaml:"signingLogExportPrefix"`
+	SigningLogExportAccessKey string `yaml:"signingLogExportAccessKey"`
+	SigningLogExportSecretKey string `yaml:"signingLogExportSecretKey"`
+	SigningLogExportInterval  string `yaml:"signingLogExportInterval"`
+
+	// Sync of the users and account memberships from LDAP groups, by the admin service
+	LDAPURL             string      `yaml:"ldapURL"`
+	LDAPStartTLS        bool        `yaml:"ldapStartTLS"`
+	LDAPBindDN          string      `yaml:"ldapBindDN"`
+	LDAPBindPassword    string      `yaml:"ldapBindPassword"`
+	LDAPBaseDN          string      `yaml:"ldapBaseDN"`
+	LDAPUserAttribute   string      `yaml:"ldapUserAttribute"`
+	LDAPMemberAttribute string      `yaml:"ldapMemberAttribute"`
+	LDAPSyncInterval    string      `yaml:"ldapSyncInterval"`
+	LDAPSyncDryRun      bool        `yaml:"ldapSyncDryRun"`
+	LDAPGroups          []LDAPGroup `yaml:"ldapGroups"`
+
+	// SMTP server of the emails sent by the vault, e.g. the user invitations
+	SMTPServer   string `yaml:"smtpServer"`
+	SMTPUsername string `yaml:"smtpUsername"`
+	SMTPPassword string `yaml:"smtpPassword"`
+	MailFrom     string `yaml:"mailFrom"`
+
+	// Email notifications of the events of the accounts: the events that are notified, the extra
+	// recipients besides the admins of the account, a directory of the templates that replace the
+	// default emails, and how long before the expiry of a signing-key it is notified
+	NotifyEvents     []string `yaml:"notifyEvents"`
+	NotifyRecipients []string `yaml:"notifyRecipients"`
+	NotifyTemplates  string   `yaml:"notifyTemplates"`
+	KeyExpiryWarning string   `yaml:"keyExpiryWarning"`
+
+	// Store credentials to register the account-keys of the generated signing keys: the root and the
+	// discharge macaroons of a store login with the modify_account_key permission
+	StoreMacaroon  string `yaml:"storeMacaroon"`
+	StoreDischarge string `yaml:"storeDischarge"`
+
+	// Lockout after failed authentications of a username or a source IP: the number of failures
+	// before the lockout, and the first lockout, which doubles with each further failure
+	LockoutThreshold int    `yaml:"lockoutThreshold"`
+	LockoutDuration  string `yaml:"lockoutDuration"`
+
+	// Networks of the reverse proxies in front of the service, in CIDR notation or single IP addresses.
+	// The X-Forwarded-For header of the requests is only used when they come from these networks
+	TrustedProxies []string `yaml:"trustedProxies"`
+
+	// Export of the OpenTelemetry traces to an OTLP collector (gRPC), with the ratio of the traces that are sampled.
+	// All the traces are sampled when the ratio is not set, and none when it is 0
+	TracingEndpoint    string   `yaml:"tracingEndpoint"`
+	TracingInsecure    bool     `yaml:"tracingInsecure"`
+	TracingServiceName string   `yaml:"tracingServiceName"`
+	TracingSampleRatio *float64 `yaml:"tracingSampleRatio"`
+
+	// External secret managers of the keystore secret, the datasource and the JWT secret, when they are
+	// references instead of values, e.g. "vault:secret/data/serial-vault#jwtSecret" for HashiCorp Vault
+	// or "awssm:serial-vault#jwtSecret" for AWS Secrets Manager. The references are resolved at startup
+	SecretsVaultAddress    string `yaml:"secretsVaultAddress"`
+	SecretsVaultToken      string `yaml:"secretsVaultToken"`
+	SecretsAWSRegion       string `yaml:"secretsAWSRegion"`
+	SecretsAWSAccessKey    string `yaml:"secretsAWSAccessKey"`
+	SecretsAWSSecretKey    string `yaml:"secretsAWSSecretKey"`
+	SecretsAWSSessionToken string `yaml:"secretsAWSSessionToken"`
+}
+
+// LDAPGroup maps the members of an LDAP group to a role in an account
+type LDAPGroup struct {
+	DN      string `yaml:"dn"`
+	Account string `yaml:"account"`
+	Role    string `yaml:"role"`
 }
 
 // SettingsFile is the path to the YAML configuration file
@@ -66,17 +256,31 @@ func ParseArgs() {
 	flag.Parse()
 }
 
-// ReadConfig parses the config file
+// EnvPrefix is the prefix of the environment variables that override the config settings
+const EnvPrefix = "SERIAL_VAULT_"
+
+// ReadConfig parses the config file, then overrides the settings from the environment variables.
+// The config file may be missing when the settings are supplied by the environment variables
 func ReadConfig(settings *Settings, filePath string) error {
 	source, err := ioutil.ReadFile(filePath)
-	if err != nil {
-		log.Println("Error opening the config file.")
+	if err != nil && !(os.IsNotExist(err) && hasEnvSettings()) {
+		log.Error("Error opening the config file.")
 		return err
 	}
 
 	err = yaml.Unmarshal(source, &settings)
 	if err != nil {
-		log.Println("Error parsing the config file.")
+		log.Error("Error parsing the config file.")
+		return err
+	}
+
+	if err = applyEnv(settings); err != nil {
+		log.Error("Error parsing the config environment variables.")
+		return err
+	}
+
+	if err = resolveSecrets(settings); err != nil {
+		log.Error("Error resolving the config secrets.")
 		return err
 	}
 
@@ -90,3 +294,126 @@ func ReadConfig(settings *Settings, filePath string) error {
 
 	return nil
 }
+
+// EnvName returns the environment variable of a config setting from its YAML key,
+// e.g. keystoreSecret is SERIAL_VAULT_KEYSTORE_SECRET and syncAPIKey is SERIAL_VAULT_SYNC_API_KEY
+func EnvName(key string) string {
+	runes := []rune(key)
+	name := EnvPrefix
+	for i, r := range runes {
+		if i > 0 && unicode.IsUpper(r) {
+			prev := runes[i-1]
+			// Split an acronym from the next word, but not from its plural (e.g. keyIDs)
+			nextWord := i+1 < len(runes) && unicode.IsLower(runes[i+1]) && !(runes[i+1] == 's' && i+2 == len(runes))
+			if unicode.IsLower(prev) || unicode.IsDigit(prev) || (unicode.IsUpper(prev) && nextWord) {
+				name += "_"
+			}
+		}
+		name += string(unicode.ToUpper(r))
+	}
+	return name
+}
+
+// hasEnvSettings checks if any of the config settings are set in the environment variables
+func hasEnvSettings() bool {
+	for _, e := range os.Environ() {
+		if strings.HasPrefix(e, EnvPrefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyEnv overrides the settings from the environment variables, so they take precedence over the config file
+func applyEnv(settings *Settings) error {
+	v := reflect.ValueOf(settings).Elem()
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("yaml")
+		if len(key) == 0 {
+			continue
+		}
+
+		name := EnvName(key)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+
+		field := v.Field(i)
+		switch field.Kind() {
+		case reflect.String:
+			field.SetString(value)
+		case reflect.Bool:
+			b, err := strconv.ParseBool(value)
+			if err != nil {
+				return fmt.Errorf("invalid boolean in %s: %v", name, err)
+			}
+			field.SetBool(b)
+		case reflect.Int:
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return fmt.Errorf("invalid integer in %s: %v", name, err)
+			}
+			field.SetInt(int64(n))
+		case reflect.Float64:
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number in %s: %v", name, err)
+			}
+			field.SetFloat(f)
+		case reflect.Ptr:
+			// The optional numbers are pointers, so an explicit 0 is not the default
+			if field.Type().Elem().Kind() != reflect.Float64 {
+				return fmt.Errorf("the %s setting cannot be set from %s", key, name)
+			}
+			f, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				return fmt.Errorf("invalid number in %s: %v", name, err)
+			}
+			field.Set(reflect.ValueOf(&f))
+		case reflect.Slice:
+			if field.Type().Elem().Kind() != reflect.String {
+				return fmt.Errorf("the %s setting cannot be set from %s", key, name)
+			}
+			// The list is comma-separated, e.g. "tracing,gzip"
+			items := []string{}
+			for _, item := range strings.Split(value, ",") {
+				if item = strings.TrimSpace(item); len(item) > 0 {
+					items = append(items, item)
+				}
+			}
+			field.Set(reflect.ValueOf(items))
+		default:
+			return fmt.Errorf("the %s setting cannot be set from %s", key, name)
+		}
+	}
+	return nil
+}
+
+// resolveSecrets replaces the references of the sensitive settings with the secrets
+func resolveSecrets(settings *Settings) error {
+	manager := secrets.NewManager(settings.SecretsVaultAddress, settings.SecretsVaultToken, settings.SecretsAWSRegion,
+		settings.SecretsAWSAccessKey, settings.SecretsAWSSecretKey, settings.SecretsAWSSessionToken)
+
+	fields := map[string]*string{
+		"keystoreSecret":     &settings.KeyStoreSecret,
+		"datasource":         &settings.DataSource,
+		"readDatasource":     &settings.ReadDataSource,
+		"jwtSecret":          &settings.JwtSecret,
+		"cacheRedisPassword": &settings.CacheRedisPassword,
+	}
+
+	for key, field := range fields {
+		if !secrets.IsReference(*field) {
+			continue
+		}
+		value, err := manager.Resolve(*field)
+		if err != nil {
+			return fmt.Errorf("error resolving the %s setting: %v", key, err)
+		}
+		*field = value
+	}
+	return nil
+}
@@ -19,7 +19,12 @@
 
 package config
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
 
 func TestReadConfig(t *testing.T) {
 	settings := Settings{}
@@ -44,3 +49,124 @@ func TestReadConfigInvalidFile(t *testing.T) {
 		t.Error("Expected an error with an invalid config file.")
 	}
 }
+
+func TestEnvName(t *testing.T) {
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{"title", "SERIAL_VAULT_TITLE"},
+		{"datasource", "SERIAL_VAULT_DATASOURCE"},
+		{"keystoreSecret", "SERIAL_VAULT_KEYSTORE_SECRET"},
+		{"syncAPIKey", "SERIAL_VAULT_SYNC_API_KEY"},
+		{"logMaskKeyIDs", "SERIAL_VAULT_LOG_MASK_KEY_IDS"},
+		{"signingLogExportS3Bucket", "SERIAL_VAULT_SIGNING_LOG_EXPORT_S3_BUCKET"},
+	}
+
+	for _, tt := range tests {
+		if got := EnvName(tt.key); got != tt.expected {
+			t.Errorf("EnvName(%s) = %s, expected %s", tt.key, got, tt.expected)
+		}
+	}
+}
+
+func TestEnvNamesUnique(t *testing.T) {
+	names := map[string]string{}
+	st := reflect.TypeOf(Settings{})
+	for i := 0; i < st.NumField(); i++ {
+		key := st.Field(i).Tag.Get("yaml")
+		if len(key) == 0 {
+			continue
+		}
+		name := EnvName(key)
+		if other, ok := names[name]; ok {
+			t.Errorf("The %s and %s settings have the same environment variable %s", key, other, name)
+		}
+		names[name] = key
+	}
+}
+
+func TestReadConfigEnvOverride(t *testing.T) {
+	t.Setenv("SERIAL_VAULT_TITLE", "Factory Vault")
+	t.Setenv("SERIAL_VAULT_ENABLE_USER_AUTH", "true")
+	t.Setenv("SERIAL_VAULT_SIGNING_LOG_RETENTION", "12")
+	t.Setenv("SERIAL_VAULT_TRACING_SAMPLE_RATIO", "0.25")
+	t.Setenv("SERIAL_VAULT_MIDDLEWARES", "tracing, custom")
+
+	settings := Settings{}
+	if err := ReadConfig(&settings, "../settings.yaml"); err != nil {
+		t.Fatalf("Error reading config file: %v", err)
+	}
+
+	if settings.Title != "Factory Vault" {
+		t.Errorf("Expected the title from the environment, got: %s", settings.Title)
+	}
+	if !settings.EnableUserAuth {
+		t.Error("Expected the user auth to be enabled from the environment")
+	}
+	if settings.SigningLogRetention != 12 {
+		t.Errorf("Expected the retention from the environment, got: %d", settings.SigningLogRetention)
+	}
+	if settings.TracingSampleRatio == nil || *settings.TracingSampleRatio != 0.25 {
+		t.Errorf("Expected the sample ratio from the environment, got: %v", settings.TracingSampleRatio)
+	}
+	if !reflect.DeepEqual(settings.Middlewares, []string{"tracing", "custom"}) {
+		t.Errorf("Expected the middlewares from the environment, got: %v", settings.Middlewares)
+	}
+	if len(settings.Driver) == 0 {
+		t.Error("Expected the driver from the config file")
+	}
+}
+
+func TestReadConfigEnvOnly(t *testing.T) {
+	t.Setenv("SERIAL_VAULT_DRIVER", "postgres")
+
+	settings := Settings{}
+	if err := ReadConfig(&settings, "not a good path"); err != nil {
+		t.Fatalf("Expected the settings from the environment, got: %v", err)
+	}
+	if settings.Driver != "postgres" {
+		t.Errorf("Expected the driver from the environment, got: %s", settings.Driver)
+	}
+}
+
+func TestReadConfigEnvInvalid(t *testing.T) {
+	t.Setenv("SERIAL_VAULT_SIGNING_LOG_BUFFER", "sometimes")
+
+	settings := Settings{}
+	if err := ReadConfig(&settings, "../settings.yaml"); err == nil {
+		t.Error("Expected an error with an invalid environment variable.")
+	}
+}
+
+func TestReadConfigSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/serial-vault" || r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(`{"data":{"data":{"jwtSecret":"vault-jwt","datasource":"dbname=vault"},"metadata":{}}}`))
+	}))
+	defer server.Close()
+
+	t.Setenv("SERIAL_VAULT_SECRETS_VAULT_ADDRESS", server.URL)
+	t.Setenv("SERIAL_VAULT_SECRETS_VAULT_TOKEN", "vault-token")
+	t.Setenv("SERIAL_VAULT_JWT_SECRET", "vault:secret/data/serial-vault#jwtSecret")
+	t.Setenv("SERIAL_VAULT_DATASOURCE", "vault:secret/data/serial-vault#datasource")
+
+	settings := Settings{}
+	if err := ReadConfig(&settings, "../settings.yaml"); err != nil {
+		t.Fatalf("Error reading config file: %v", err)
+	}
+	if settings.JwtSecret != "vault-jwt" || settings.DataSource != "dbname=vault" {
+		t.Errorf("Expected the secrets from Vault, got: %s, %s", settings.JwtSecret, settings.DataSource)
+	}
+	if settings.KeyStoreSecret != "secret code to encrypt the auth-key hash" {
+		t.Errorf("Expected the keystore secret from the config file, got: %s", settings.KeyStoreSecret)
+	}
+
+	t.Setenv("SERIAL_VAULT_KEYSTORE_SECRET", "vault:secret/data/serial-vault#keystoreSecret")
+	if err := ReadConfig(&Settings{}, "../settings.yaml"); err == nil {
+		t.Error("Expected an error with a missing secret.")
+	}
+}
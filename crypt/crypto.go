@@ -25,6 +25,7 @@ import (
 	"crypto/cipher"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/sha256"
 	"encoding/base64"
 	"errors"
 	"io"
@@ -35,6 +36,7 @@ import (
 	"github.com/snapcore/snapd/asserts"
 	"golang.org/x/crypto/openpgp/armor"
 	"golang.org/x/crypto/openpgp/packet"
+	"golang.org/x/crypto/pbkdf2"
 )
 
 // GenerateAuthKey generates an key from the signing key details
@@ -52,39 +54,91 @@ func CreateSecret(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(rb), nil
 }
 
-// EncryptKey uses symmetric encryption to encrypt the data for storage
+// sealedKeyPrefix marks the keys that are sealed with AES-256-GCM, using a key derived from
+// the password. The keys that were sealed before, with AES-CFB, are still unsealed
+var sealedKeyPrefix = []byte("sv2:")
+
+// ErrorWrongSecret is returned when the sealed key cannot be opened with the secret
+var ErrorWrongSecret = errors.New("The sealed key cannot be decrypted with the secret")
+
+// The derivation of the AES-256 key from the password, with PBKDF2-HMAC-SHA256 and a random salt
+const (
+	kdfIterations = 100000
+	kdfSaltSize   = 16
+	kdfKeySize    = 32
+)
+
+// EncryptKey uses symmetric encryption to encrypt the data for storage. The data is sealed
+// with AES-256-GCM, so a wrong password or a change of the sealed data is detected
 func EncryptKey(plainTextKey, keyText string) ([]byte, error) {
-	// The AES key needs to be 16 or 32 bytes i.e. AES-128 or AES-256
-	aesKey := padRight(keyText, "x", 32)
+	salt := make([]byte, kdfSaltSize)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		log.Errorf("Error creating the salt for the cipher: %v", err)
+		return nil, err
+	}
 
-	block, err := aes.NewCipher([]byte(aesKey))
+	aead, err := newKeyCipher(keyText, salt)
 	if err != nil {
-		log.Printf("Error creating the cipher block: %v", err)
 		return nil, err
 	}
 
-	// The IV needs to be unique, but not secure. Including it at the start of the plaintext
-	ciphertext := make([]byte, aes.BlockSize+len(plainTextKey))
-	iv := ciphertext[:aes.BlockSize]
-	if _, err = io.ReadFull(rand.Reader, iv); err != nil {
-		log.Printf("Error creating the IV for the cipher: %v", err)
+	// The nonce needs to be unique, but not secure. Including it after the salt
+	nonce := make([]byte, aead.NonceSize())
+	if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+		log.Errorf("Error creating the nonce for the cipher: %v", err)
 		return nil, err
 	}
 
-	// Use CFB mode for the encryption
-	cfb := cipher.NewCFBEncrypter(block, iv)
-	cfb.XORKeyStream(ciphertext[aes.BlockSize:], []byte(plainTextKey))
-
-	return ciphertext, nil
+	sealed := append(append(append([]byte{}, sealedKeyPrefix...), salt...), nonce...)
+	return aead.Seal(sealed, nonce, []byte(plainTextKey), nil), nil
 }
 
 // DecryptKey handles the decryption of a sealed signing key
 func DecryptKey(sealedKey []byte, keyText string) ([]byte, error) {
+	if !bytes.HasPrefix(sealedKey, sealedKeyPrefix) {
+		return decryptKeyCFB(sealedKey, keyText)
+	}
+	sealedKey = sealedKey[len(sealedKeyPrefix):]
+
+	if len(sealedKey) < kdfSaltSize {
+		return nil, errors.New("Cipher text too short")
+	}
+	aead, err := newKeyCipher(keyText, sealedKey[:kdfSaltSize])
+	if err != nil {
+		return nil, err
+	}
+	sealedKey = sealedKey[kdfSaltSize:]
+
+	if len(sealedKey) < aead.NonceSize() {
+		return nil, errors.New("Cipher text too short")
+	}
+	plainTextKey, err := aead.Open(nil, sealedKey[:aead.NonceSize()], sealedKey[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, ErrorWrongSecret
+	}
+	return plainTextKey, nil
+}
+
+// newKeyCipher creates the AES-256-GCM cipher with the key derived from the password
+func newKeyCipher(keyText string, salt []byte) (cipher.AEAD, error) {
+	aesKey := pbkdf2.Key([]byte(keyText), salt, kdfIterations, kdfKeySize, sha256.New)
+
+	block, err := aes.NewCipher(aesKey)
+	if err != nil {
+		log.Errorf("Error creating the cipher block: %v", err)
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// decryptKeyCFB decrypts a key that was sealed with AES-CFB, with the password padded to the
+// AES-256 key. It does not detect a wrong password
+func decryptKeyCFB(sealedKey []byte, keyText string) ([]byte, error) {
 	aesKey := padRight(keyText, "x", 32)
 
 	block, err := aes.NewCipher([]byte(aesKey))
 	if err != nil {
-		log.Printf("Error creating the cipher block: %v", err)
+		log.Errorf("Error creating the cipher block: %v", err)
 		return nil, err
 	}
 
@@ -114,6 +168,36 @@ func DeserializePrivateKey(base64PrivateKey string) (asserts.PrivateKey, string,
 	return privateKeyToAssertsKey(decodedPrivateKey)
 }
 
+// KeyBits returns the size of a base64 encoded, ascii-armored RSA private or public key
+func KeyBits(base64Key string) (int, error) {
+	decodedKey, err := base64.StdEncoding.DecodeString(base64Key)
+	if err != nil {
+		return 0, err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(decodedKey))
+	if err != nil {
+		return 0, err
+	}
+
+	pkt, err := packet.Read(block.Body)
+	if err != nil {
+		return 0, err
+	}
+
+	switch k := pkt.(type) {
+	case *packet.PrivateKey:
+		if rsaKey, ok := k.PrivateKey.(*rsa.PrivateKey); ok {
+			return rsaKey.N.BitLen(), nil
+		}
+	case *packet.PublicKey:
+		if rsaKey, ok := k.PublicKey.(*rsa.PublicKey); ok {
+			return rsaKey.N.BitLen(), nil
+		}
+	}
+	return 0, errors.New("Not an RSA key")
+}
+
 func privateKeyToAssertsKey(key []byte) (asserts.PrivateKey, string, error) {
 	const errorInvalidKey = "invalid-keypair"
 
@@ -148,3 +232,35 @@ func padRight(str, pad string, length int) string {
 		}
 	}
 }
+
+// DeserializePublicKey decodes a base64 encoded, ascii-armored public key file and
+// converts it to a public key that can be used to reference a signing-key that is
+// held outside of the keypair store e.g. on an OpenPGP card
+func DeserializePublicKey(base64PublicKey string) (asserts.PublicKey, string, error) {
+	const errorInvalidKey = "invalid-keypair"
+
+	// The public-key is base64 encoded, so we need to decode it
+	decodedPublicKey, err := base64.StdEncoding.DecodeString(base64PublicKey)
+	if err != nil {
+		return nil, "error-decode-key", err
+	}
+
+	block, err := armor.Decode(bytes.NewReader(decodedPublicKey))
+	if err != nil {
+		return nil, errorInvalidKey, err
+	}
+
+	pkt, err := packet.Read(block.Body)
+	if err != nil {
+		return nil, errorInvalidKey, err
+	}
+
+	pubk, ok := pkt.(*packet.PublicKey)
+	if !ok {
+		return nil, errorInvalidKey, errors.New("Not a public key")
+	}
+	if _, ok := pubk.PublicKey.(*rsa.PublicKey); !ok {
+		return nil, errorInvalidKey, errors.New("Not an RSA public key")
+	}
+	return asserts.RSAPublicKey(pubk.PublicKey.(*rsa.PublicKey)), "", nil
+}
@@ -20,6 +20,8 @@
 package crypt
 
 import (
+	"crypto/aes"
+	"crypto/cipher"
 	"encoding/base64"
 	"io/ioutil"
 	"testing"
@@ -46,6 +48,45 @@ func TestEncryptDecrypt(t *testing.T) {
 	}
 }
 
+func TestDecryptWrongSecret(t *testing.T) {
+	cipherText, err := EncryptKey("fake-hmac-ed-data", "this needs to be 32 bytes long!!")
+	if err != nil {
+		t.Fatalf("Error encrypting text: %v", err)
+	}
+
+	if _, err := DecryptKey(cipherText, "this is the wrong secret"); err == nil {
+		t.Error("Expected an error decrypting with the wrong secret")
+	}
+	cipherText[len(cipherText)-1] ^= 1
+	if _, err := DecryptKey(cipherText, "this needs to be 32 bytes long!!"); err == nil {
+		t.Error("Expected an error decrypting modified cipher text")
+	}
+}
+
+func TestDecryptCFB(t *testing.T) {
+	// Sealed with AES-CFB and the padded secret, before the keys were sealed with AES-GCM
+	cipherText := append(make([]byte, aes.BlockSize), legacyCipherText(t)...)
+
+	plainText, err := DecryptKey(cipherText, "secret")
+	if err != nil {
+		t.Fatalf("Error decrypting text: %v", err)
+	}
+	if string(plainText) != "fake-hmac-ed-data" {
+		t.Errorf("Invalid decryption: %q", plainText)
+	}
+}
+
+// legacyCipherText encrypts the text with AES-CFB, a zero IV and the secret padded with x
+func legacyCipherText(t *testing.T) []byte {
+	block, err := aes.NewCipher([]byte(padRight("secret", "x", 32)))
+	if err != nil {
+		t.Fatalf("Error creating the cipher: %v", err)
+	}
+	cipherText := make([]byte, len("fake-hmac-ed-data"))
+	cipher.NewCFBEncrypter(block, make([]byte, aes.BlockSize)).XORKeyStream(cipherText, []byte("fake-hmac-ed-data"))
+	return cipherText
+}
+
 func TestCreateSecretCLibCryptUser(t *testing.T) {
 	secret, err := CreateSecret(16)
 	if err != nil {
@@ -80,3 +121,33 @@ func TestDeserializePrivateKey(t *testing.T) {
 		t.Errorf("Error deserializing the test key: %v", err)
 	}
 }
+
+func TestDeserializePublicKey(t *testing.T) {
+	publicKey, err := ioutil.ReadFile("../keystore/TestKey.pub.asc")
+	if err != nil {
+		t.Errorf("Error reading the public-key file: %v", err)
+	}
+
+	pubKey, _, err := DeserializePublicKey(base64.StdEncoding.EncodeToString(publicKey))
+	if err != nil {
+		t.Errorf("Error deserializing the test public key: %v", err)
+	}
+
+	signingKey, err := ioutil.ReadFile("../keystore/TestKey.asc")
+	if err != nil {
+		t.Errorf("Error reading the signing-key file: %v", err)
+	}
+	privateKey, _, err := DeserializePrivateKey(base64.StdEncoding.EncodeToString(signingKey))
+	if err != nil {
+		t.Errorf("Error deserializing the test key: %v", err)
+	}
+	if pubKey.ID() != privateKey.PublicKey().ID() {
+		t.Errorf("Expected key ID %s, got %s", privateKey.PublicKey().ID(), pubKey.ID())
+	}
+
+	// A private key is not a valid public key
+	_, _, err = DeserializePublicKey(base64.StdEncoding.EncodeToString(signingKey))
+	if err == nil {
+		t.Error("Expected error deserializing a private key, got success")
+	}
+}
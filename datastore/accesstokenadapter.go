@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// Validity of the personal access tokens, when no expiry is given and at most
+const (
+	accessTokenDefaultValidity = 90 * 24 * time.Hour
+	accessTokenMaxValidity     = 365 * 24 * time.Hour
+)
+
+// Lengths of the random personal access token and of the prefix that identifies it
+const (
+	accessTokenLength       = 40
+	accessTokenPrefixLength = len(AccessTokenPrefix) + 4
+)
+
+// CreateAccessToken validates and creates a personal access token for the user, generating
+// the token. The token is only returned here, as only its hash is stored
+func (db *DB) CreateAccessToken(ctx context.Context, t AccessToken) (AccessToken, error) {
+	if t.Expires.IsZero() {
+		t.Expires = time.Now().Add(accessTokenDefaultValidity)
+	}
+
+	if err := validateAccessToken(t); err != nil {
+		return t, err
+	}
+
+	tokenHash, err := t.newToken()
+	if err != nil {
+		return t, err
+	}
+
+	t.ID, err = db.createAccessToken(ctx, t, tokenHash)
+	return t, err
+}
+
+func (t *AccessToken) newToken() (string, error) {
+	token, err := random.GenerateRandomString(accessTokenLength)
+	if err != nil {
+		return "", errors.New("Error in generating the access token")
+	}
+	t.Token = AccessTokenPrefix + token
+	t.Prefix = t.Token[:accessTokenPrefixLength]
+	return hashAccessToken(t.Token), nil
+}
+
+func validateAccessToken(t AccessToken) error {
+	if err := validateNotEmpty("Name", t.Name); err != nil {
+		return err
+	}
+	if len(t.Scopes) == 0 {
+		return errors.New("The access token must have at least one scope")
+	}
+	for _, s := range t.Scopes {
+		if !IsValidAdminScope(s) {
+			return fmt.Errorf("Invalid scope '%s' for the access token", s)
+		}
+	}
+	if t.Expires.Before(time.Now()) {
+		return errors.New("The expiry of the access token must be in the future")
+	}
+	if t.Expires.After(time.Now().Add(accessTokenMaxValidity)) {
+		return errors.New("The access token cannot be valid for more than a year")
+	}
+	return nil
+}
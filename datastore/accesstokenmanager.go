@@ -0,0 +1,160 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The personal access tokens of the users, used by scripts in place of the JWT of the
+// interactive login. Only the hash of the token is stored, with its prefix to identify it
+const createAccessTokenTableSQL = `
+	CREATE TABLE IF NOT EXISTS accesstoken (
+		id               serial primary key not null,
+		user_id          int references userinfo on delete cascade not null,
+		name             varchar(200) not null,
+		prefix           varchar(20) not null,
+		token_hash       varchar(200) not null unique,
+		scopes           varchar(200) not null default '',
+		expires          timestamp not null,
+		last_used        timestamp,
+		created          timestamp default current_timestamp
+	)
+`
+
+const listAccessTokensSQL = `
+	SELECT t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.expires, t.last_used, t.created
+	FROM accesstoken t
+	INNER JOIN userinfo u ON u.id=t.user_id
+	WHERE t.user_id=$1
+	ORDER BY t.name`
+
+const getAccessTokenByHashSQL = `
+	SELECT t.id, t.user_id, u.username, t.name, t.prefix, t.scopes, t.expires, t.last_used, t.created
+	FROM accesstoken t
+	INNER JOIN userinfo u ON u.id=t.user_id
+	WHERE t.token_hash=$1 AND u.active`
+
+const createAccessTokenSQL = "INSERT INTO accesstoken (user_id, name, prefix, token_hash, scopes, expires) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id"
+const updateAccessTokenLastUsedSQL = "UPDATE accesstoken SET last_used=current_timestamp WHERE id=$1"
+const deleteAccessTokenSQL = "DELETE FROM accesstoken WHERE id=$1 AND user_id=$2"
+
+// AccessTokenPrefix identifies a personal access token in the authorization header
+const AccessTokenPrefix = "svpat_"
+
+// AccessToken is a personal access token of a user. The token is only returned when it is
+// created, and the prefix identifies it afterwards
+type AccessToken struct {
+	ID       int        `json:"id"`
+	UserID   int        `json:"userID"`
+	Username string     `json:"username"`
+	Name     string     `json:"name"`
+	Prefix   string     `json:"prefix"`
+	Token    string     `json:"token,omitempty"`
+	Scopes   []string   `json:"scopes"`
+	Expires  time.Time  `json:"expires"`
+	LastUsed *time.Time `json:"lastUsed"`
+	Created  time.Time  `json:"created"`
+}
+
+// IsAccessToken checks whether the bearer token is a personal access token, rather than a JWT
+func IsAccessToken(token string) bool {
+	return strings.HasPrefix(token, AccessTokenPrefix)
+}
+
+// CreateAccessTokenTable creates the database table for the personal access tokens
+func (db *DB) CreateAccessTokenTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAccessTokenTableSQL)
+	return err
+}
+
+// ListAccessTokens returns the personal access tokens of a user
+func (db *DB) ListAccessTokens(ctx context.Context, userID int) ([]AccessToken, error) {
+	rows, err := db.QueryContext(ctx, listAccessTokensSQL, userID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the access tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := []AccessToken{}
+	for rows.Next() {
+		t := AccessToken{}
+		var scopes string
+		if err := rows.Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &scopes, &t.Expires, &t.LastUsed, &t.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the access tokens: %v", err)
+		}
+		t.Scopes = splitScopes(scopes)
+		tokens = append(tokens, t)
+	}
+
+	return tokens, rows.Err()
+}
+
+// AuthenticateAccessToken returns the personal access token, if it has not expired, and
+// records when it was used
+func (db *DB) AuthenticateAccessToken(ctx context.Context, token string) (AccessToken, error) {
+	t := AccessToken{}
+	var scopes string
+	err := db.QueryRowContext(ctx, getAccessTokenByHashSQL, hashAccessToken(token)).Scan(&t.ID, &t.UserID, &t.Username, &t.Name, &t.Prefix, &scopes, &t.Expires, &t.LastUsed, &t.Created)
+	if err != nil || time.Now().After(t.Expires) {
+		return AccessToken{}, errors.New("The access token is invalid or has expired")
+	}
+	t.Scopes = splitScopes(scopes)
+
+	if _, err := db.ExecContext(ctx, updateAccessTokenLastUsedSQL, t.ID); err != nil {
+		log.Errorf("Error recording the use of the access token %d: %v", t.ID, err)
+	}
+	return t, nil
+}
+
+func (db *DB) createAccessToken(ctx context.Context, t AccessToken, tokenHash string) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, createAccessTokenSQL, t.UserID, t.Name, t.Prefix, tokenHash, joinScopes(t.Scopes), t.Expires).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the access token: %v", err)
+	}
+	return id, nil
+}
+
+// DeleteAccessToken revokes a personal access token of a user
+func (db *DB) DeleteAccessToken(ctx context.Context, userID, tokenID int) error {
+	result, err := db.ExecContext(ctx, deleteAccessTokenSQL, tokenID, userID)
+	if err != nil {
+		return fmt.Errorf("error revoking the access token: %v", err)
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errors.New("Cannot find the access token of the user")
+	}
+	return nil
+}
+
+// hashAccessToken hashes the token, which is random, so a plain hash is enough
+func hashAccessToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
@@ -20,43 +20,44 @@
 package datastore
 
 import (
+	"context"
 	"errors"
 )
 
 // ListAllowedAccounts fetches the available accounts from the database that the user is allowed to see
-func (db *DB) ListAllowedAccounts(authorization User) ([]Account, error) {
+func (db *DB) ListAllowedAccounts(ctx context.Context, authorization User) ([]Account, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.listAllAccounts()
+		return db.listAllAccounts(ctx)
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listAccountsFilteredByUser(authorization.Username)
+		return db.listAllowedAccountsFilteredByUser(ctx, authorization.Username)
 	default:
 		return []Account{}, nil
 	}
 }
 
 // GetAllowedAccount fetches an account from the database that the user is allowed to see
-func (db *DB) GetAllowedAccount(authorityID string, authorization User) (Account, error) {
+func (db *DB) GetAllowedAccount(ctx context.Context, authorityID string, authorization User) (Account, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.GetAccount(authorityID)
+		return db.GetAccount(ctx, authorityID)
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.getAccountForUser(authorityID, authorization.Username)
+		return db.getAccountForUser(ctx, authorityID, authorization.Username)
 	default:
 		return Account{}, nil
 	}
 }
 
 // PutAccount validates permissions and stores an account in the database
-func (db *DB) PutAccount(account Account, authorization User) (string, error) {
+func (db *DB) PutAccount(ctx context.Context, account Account, authorization User) (string, error) {
 
 	err := validateAuthorityID(account.AuthorityID)
 	if err != nil {
@@ -65,47 +66,107 @@ func (db *DB) PutAccount(account Account, authorization User) (string, error) {
 
 	if authorization.Role == Admin {
 		// Check that the user has permissions for the account
-		if !db.CheckUserInAccount(authorization.Username, account.AuthorityID) {
+		if !db.CheckUserInAccount(ctx, authorization.Username, account.AuthorityID) {
 			return "error-auth", errors.New("You do not have permissions for that authority")
 		}
 	}
 
-	return db.putAccount(account)
+	return db.putAccount(ctx, account)
 }
 
 // GetAccountByID validates permissions and fetches an account from the database
-func (db *DB) GetAccountByID(accountID int, authorization User) (Account, error) {
+func (db *DB) GetAccountByID(ctx context.Context, accountID int, authorization User) (Account, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.getAccountByID(accountID)
+		return db.getAccountByID(ctx, accountID)
 	case Admin:
-		return db.getUserAccountByID(accountID, authorization.Username)
+		return db.getUserAccountByID(ctx, accountID, authorization.Username)
 	default:
 		return Account{}, nil
 	}
 }
 
 // UpdateAccount updates an account in the database
-func (db *DB) UpdateAccount(account Account, authorization User) error {
+func (db *DB) UpdateAccount(ctx context.Context, account Account, authorization User) error {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.updateAccount(account)
+		return db.updateAccount(ctx, account)
 	case Admin:
-		return db.updateUserAccount(account, authorization.Username)
+		return db.updateUserAccount(ctx, account, authorization.Username)
 	default:
 		return nil
 	}
 }
 
 // SyncAccount validates permissions and stores an account in the database
-func (db *DB) SyncAccount(account Account) error {
+func (db *DB) SyncAccount(ctx context.Context, account Account) error {
 	if err := validateAuthorityID(account.AuthorityID); err != nil {
 		return err
 	}
 
-	return db.syncAccount(account)
+	return db.syncAccount(ctx, account)
+}
+
+// UpdateAllowedAccountDefaultKeypair sets the default keypair of the account, if the user is authorized
+// to do it. The keypair must be an active key of the account, and zero clears the default
+func (db *DB) UpdateAllowedAccountDefaultKeypair(ctx context.Context, accountID, keypairID int, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return errors.New("You do not have permissions to this account")
+		}
+
+		if keypairID != 0 {
+			keypair, err := db.GetKeypair(ctx, keypairID)
+			if err != nil {
+				return errors.New("Cannot find the signing key")
+			}
+			if err := validateDefaultKeypair(acc, keypair); err != nil {
+				return err
+			}
+		}
+		return db.updateAccountDefaultKeypair(ctx, accountID, keypairID)
+	default:
+		return errors.New("You do not have permissions to update the default keypair of the account")
+	}
+}
+
+// defaultKeypair links a new model without a signing key to the default keypair of its account,
+// unless the keypair has been disabled since it was set
+func (db *DB) defaultKeypair(ctx context.Context, model Model) Model {
+	if model.KeypairID != 0 {
+		return model
+	}
+
+	acc, err := db.GetAccount(ctx, model.BrandID)
+	if err != nil || acc.DefaultKeypairID == 0 {
+		return model
+	}
+
+	keypair, err := db.GetKeypair(ctx, acc.DefaultKeypairID)
+	if err != nil || validateDefaultKeypair(acc, keypair) != nil {
+		return model
+	}
+
+	model.KeypairID = keypair.ID
+	return model
+}
+
+func validateDefaultKeypair(acc Account, keypair Keypair) error {
+	if keypair.AuthorityID != acc.AuthorityID {
+		return errors.New("The default keypair must be a signing key of the account")
+	}
+	if !keypair.Active {
+		return errors.New("The default keypair must be active")
+	}
+	return nil
 }
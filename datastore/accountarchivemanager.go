@@ -0,0 +1,383 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The export bundles of the deleted accounts, with the counts of the archived records.
+// The bundle is the gzipped JSON of the account, its keypairs, models and signing logs
+const createAccountArchiveTableSQL = `
+	CREATE TABLE IF NOT EXISTS accountarchive (
+		id               serial primary key not null,
+		authority_id     varchar(200) not null,
+		archived_by      varchar(200) not null,
+		keypairs         int not null,
+		models           int not null,
+		signing_logs     int not null,
+		users            int not null,
+		bundle           bytea not null,
+		created          timestamp not null
+	)
+`
+
+const createAccountArchiveSQL = `
+	INSERT INTO accountarchive (authority_id, archived_by, keypairs, models, signing_logs, users, bundle, created)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8)
+	RETURNING id`
+
+const listAccountArchivesSQL = `
+	SELECT id, authority_id, archived_by, keypairs, models, signing_logs, users, created
+	FROM accountarchive
+	ORDER BY id DESC`
+
+const getAccountArchiveBundleSQL = "SELECT authority_id, bundle FROM accountarchive WHERE id=$1"
+
+// The records of the account that is deleted. The account row is locked until the transaction ends
+const lockAccountSQL = "SELECT id, authority_id, assertion, resellerapi, hashserial FROM account WHERE id=$1 FOR UPDATE"
+const listArchiveKeypairsSQL = "SELECT id, authority_id, key_id, active, assertion, key_name FROM keypair WHERE authority_id=$1 ORDER BY id"
+const listArchiveModelsSQL = "SELECT id, brand_id, name, keypair_id, coalesce(user_keypair_id,0), serial_format, serial_headers FROM model WHERE brand_id=$1 ORDER BY id"
+const listArchiveModelAssertionsSQL = `
+	SELECT a.id, a.model_id, a.keypair_id, a.series, a.architecture, a.revision, a.gadget, a.kernel, coalesce(a.store,''),
+		a.required_snaps, a.base, a.classic, a.display_name, coalesce(a.grade,''), coalesce(a.storage_safety,''),
+		coalesce(a.snaps,''), coalesce(a.validation_sets,''), a.created, a.modified
+	FROM modelassertion a
+	INNER JOIN model m ON m.id=a.model_id
+	WHERE m.brand_id=$1`
+const listArchiveSubstoresSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers
+	FROM substore
+	WHERE account_id=$1 OR from_model_id IN (SELECT id FROM model WHERE brand_id=$2)
+	ORDER BY id`
+const listArchiveUsersSQL = `
+	SELECT u.username
+	FROM userinfo u
+	INNER JOIN useraccountlink l ON l.user_id=u.id
+	WHERE l.account_id=$1
+	ORDER BY u.username`
+const listArchiveAPIKeysSQL = "SELECT name FROM apikey WHERE account_id=$1 ORDER BY name"
+const listArchiveSigningLogSQL = "SELECT * FROM signinglog WHERE make=$1 ORDER BY id"
+
+// The statements that delete the account, in the order of the foreign keys. The keypairs
+// are disabled rather than deleted, as the models of other brands can be signed with them.
+// The signing logs are anonymized rather than deleted, so they stay linked in the audit chain
+var deleteAccountSQL = []string{
+	"DELETE FROM substorepivot WHERE from_model_id IN (SELECT id FROM model WHERE brand_id=$2) OR substore_id IN (SELECT id FROM substore WHERE account_id=$1)",
+	"DELETE FROM substore WHERE account_id=$1 OR from_model_id IN (SELECT id FROM model WHERE brand_id=$2)",
+	"DELETE FROM modelassertion WHERE model_id IN (SELECT id FROM model WHERE brand_id=$2)",
+	"DELETE FROM signedmodelassertion WHERE model_id IN (SELECT id FROM model WHERE brand_id=$2)",
+	"DELETE FROM model WHERE brand_id=$2",
+	"UPDATE signinglog SET serial_number='" + anonymizedPrefix + "' || id, fingerprint='" + anonymizedPrefix + "' || id WHERE make=$2",
+	"UPDATE keypair SET active=false WHERE authority_id=$2",
+	"DELETE FROM useraccountlink WHERE account_id=$1",
+	"DELETE FROM usersubstorelink WHERE account_id=$1",
+	"DELETE FROM apikey WHERE account_id=$1",
+	"DELETE FROM clientcert WHERE account_id=$1",
+	"DELETE FROM accounthmac WHERE account_id=$1",
+	"DELETE FROM accountquota WHERE account_id=$1",
+	"DELETE FROM accountretention WHERE account_id=$1",
+	"DELETE FROM accountnonce WHERE account_id=$1",
+	"DELETE FROM webhookdelivery WHERE webhook_id IN (SELECT id FROM webhook WHERE account_id=$1)",
+	"DELETE FROM webhook WHERE account_id=$1",
+	"DELETE FROM account WHERE id=$1 AND authority_id=$2",
+}
+
+// AccountArchive is the record of a deleted account, with the counts of the records in its bundle
+type AccountArchive struct {
+	ID          int       `json:"id"`
+	AuthorityID string    `json:"authorityID"`
+	ArchivedBy  string    `json:"archivedBy"`
+	Keypairs    int       `json:"keypairs"`
+	Models      int       `json:"models"`
+	SigningLogs int       `json:"signingLogs"`
+	Users       int       `json:"users"`
+	Created     time.Time `json:"created"`
+}
+
+// AccountBundle is the export of a deleted account. The sealed keys of the keypairs and the
+// API keys are not exported. The signing logs are the last field, as they are streamed into
+// the bundle
+type AccountBundle struct {
+	AuthorityID     string            `json:"authorityID"`
+	Assertion       string            `json:"assertion"`
+	ResellerAPI     bool              `json:"resellerAPI"`
+	HashSerial      bool              `json:"hashSerial"`
+	Keypairs        []ArchivedKeypair `json:"keypairs"`
+	Models          []ArchivedModel   `json:"models"`
+	ModelAssertions []ModelAssertion  `json:"modelAssertions"`
+	Substores       []Substore        `json:"substores"`
+	Users           []string          `json:"users"`
+	APIKeys         []string          `json:"apiKeys"`
+	ArchivedBy      string            `json:"archivedBy"`
+	Created         time.Time         `json:"created"`
+	SigningLogs     []SigningLog      `json:"signingLogs,omitempty"`
+}
+
+// ArchivedKeypair is a keypair in the export of a deleted account
+type ArchivedKeypair struct {
+	ID          int    `json:"id"`
+	AuthorityID string `json:"authorityID"`
+	KeyID       string `json:"keyID"`
+	Active      bool   `json:"active"`
+	Assertion   string `json:"assertion"`
+	KeyName     string `json:"keyName"`
+}
+
+// ArchivedModel is a model in the export of a deleted account
+type ArchivedModel struct {
+	ID            int    `json:"id"`
+	BrandID       string `json:"brandID"`
+	Name          string `json:"name"`
+	KeypairID     int    `json:"keypairID"`
+	KeypairIDUser int    `json:"keypairIDUser"`
+	SerialFormat  string `json:"serialFormat"`
+	SerialHeaders string `json:"serialHeaders"`
+}
+
+// CreateAccountArchiveTable creates the database table for the export bundles of the deleted accounts
+func (db *DB) CreateAccountArchiveTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAccountArchiveTableSQL)
+	return err
+}
+
+// DeleteAccount archives the account to an export bundle and deletes it, in a transaction:
+// the keypairs are disabled, the models and sub-stores are archived and deleted, the signing
+// logs are archived and anonymized, and the links of the users and the settings of the account
+// are removed. The
+// audit log entry is recorded in the same transaction, with the archive as its new value
+func (db *DB) DeleteAccount(ctx context.Context, accountID int, entry AuditLog) (AccountArchive, error) {
+	defer db.invalidateCache(cacheModels, cacheAPIKeys)
+
+	archive := AccountArchive{ArchivedBy: entry.Username, Created: time.Now().UTC()}
+
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		bundle, err := buildAccountBundle(ctx, tx, accountID)
+		if err != nil {
+			return err
+		}
+		bundle.ArchivedBy = archive.ArchivedBy
+		bundle.Created = archive.Created
+
+		archive.AuthorityID = bundle.AuthorityID
+		archive.Keypairs = len(bundle.Keypairs)
+		archive.Models = len(bundle.Models)
+		archive.Users = len(bundle.Users)
+
+		data, err := encodeAccountBundle(bundle, func(write func(SigningLog) error) error {
+			return queryArchiveRows(ctx, tx, listArchiveSigningLogSQL, []interface{}{bundle.AuthorityID}, func(rows *sql.Rows) error {
+				l, err := scanSigningLog(rows)
+				if err != nil {
+					return err
+				}
+				archive.SigningLogs++
+				return write(l)
+			})
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, s := range deleteAccountSQL {
+			if _, err := tx.ExecContext(ctx, s, accountID, bundle.AuthorityID); err != nil {
+				return err
+			}
+		}
+
+		err = tx.QueryRowContext(ctx, createAccountArchiveSQL, archive.AuthorityID, archive.ArchivedBy, archive.Keypairs, archive.Models,
+			archive.SigningLogs, archive.Users, data, archive.Created).Scan(&archive.ID)
+		if err != nil {
+			return err
+		}
+
+		after, _ := json.Marshal(archive)
+		if err = lockAuditRecord(ctx, tx); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, createAuditLogSQL, entry.Username, entry.Action, entry.Object, accountID, archive.AuthorityID, entry.Before, string(after))
+		return err
+	})
+	if err != nil {
+		log.Errorf("Error deleting account %v: %v", accountID, err)
+		return archive, fmt.Errorf("error deleting the account: %v", err)
+	}
+	return archive, nil
+}
+
+// ListAccountArchives returns the records of the deleted accounts, most recent first
+func (db *DB) ListAccountArchives(ctx context.Context) ([]AccountArchive, error) {
+	rows, err := db.QueryContext(ctx, listAccountArchivesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the account archives: %v", err)
+	}
+	defer rows.Close()
+
+	archives := []AccountArchive{}
+	for rows.Next() {
+		a := AccountArchive{}
+		if err := rows.Scan(&a.ID, &a.AuthorityID, &a.ArchivedBy, &a.Keypairs, &a.Models, &a.SigningLogs, &a.Users, &a.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the account archives: %v", err)
+		}
+		archives = append(archives, a)
+	}
+	return archives, rows.Err()
+}
+
+// GetAccountArchiveBundle returns the authority ID and the gzipped export bundle of a deleted account
+func (db *DB) GetAccountArchiveBundle(ctx context.Context, archiveID int) (string, []byte, error) {
+	var authorityID string
+	var bundle []byte
+	if err := db.QueryRowContext(ctx, getAccountArchiveBundleSQL, archiveID).Scan(&authorityID, &bundle); err != nil {
+		return "", nil, fmt.Errorf("error retrieving the account archive: %v", err)
+	}
+	return authorityID, bundle, nil
+}
+
+// buildAccountBundle reads the records of the account in the transaction, except for the signing
+// logs, which are read as the bundle is encoded
+func buildAccountBundle(ctx context.Context, tx *sql.Tx, accountID int) (AccountBundle, error) {
+	b := AccountBundle{Keypairs: []ArchivedKeypair{}, Models: []ArchivedModel{}, ModelAssertions: []ModelAssertion{},
+		Substores: []Substore{}, Users: []string{}, APIKeys: []string{}}
+
+	err := tx.QueryRowContext(ctx, lockAccountSQL, accountID).Scan(new(int), &b.AuthorityID, &b.Assertion, &b.ResellerAPI, &b.HashSerial)
+	if err != nil {
+		return b, err
+	}
+	if b.Assertion, err = decryptColumn(b.Assertion); err != nil {
+		return b, err
+	}
+
+	queries := []struct {
+		query string
+		args  []interface{}
+		scan  func(rows *sql.Rows) error
+	}{
+		{listArchiveKeypairsSQL, []interface{}{b.AuthorityID}, func(rows *sql.Rows) error {
+			k := ArchivedKeypair{}
+			err := rows.Scan(&k.ID, &k.AuthorityID, &k.KeyID, &k.Active, &k.Assertion, &k.KeyName)
+			b.Keypairs = append(b.Keypairs, k)
+			return err
+		}},
+		{listArchiveModelsSQL, []interface{}{b.AuthorityID}, func(rows *sql.Rows) error {
+			m := ArchivedModel{}
+			err := rows.Scan(&m.ID, &m.BrandID, &m.Name, &m.KeypairID, &m.KeypairIDUser, &m.SerialFormat, &m.SerialHeaders)
+			b.Models = append(b.Models, m)
+			return err
+		}},
+		{listArchiveModelAssertionsSQL, []interface{}{b.AuthorityID}, func(rows *sql.Rows) error {
+			m := ModelAssertion{}
+			var snaps, validationSets string
+			err := rows.Scan(&m.ID, &m.ModelID, &m.KeypairID, &m.Series, &m.Architecture, &m.Revision, &m.Gadget, &m.Kernel, &m.Store,
+				&m.RequiredSnaps, &m.Base, &m.Classic, &m.DisplayName, &m.Grade, &m.StorageSafety, &snaps, &validationSets, &m.Created, &m.Modified)
+			if err == nil {
+				err = m.decodeLists(snaps, validationSets)
+			}
+			b.ModelAssertions = append(b.ModelAssertions, m)
+			return err
+		}},
+		{listArchiveSubstoresSQL, []interface{}{accountID, b.AuthorityID}, func(rows *sql.Rows) error {
+			s := Substore{}
+			err := rows.Scan(&s.ID, &s.AccountID, &s.FromModelID, &s.Store, &s.SerialNumber, &s.ModelName, &s.MatchType, &s.SerialNumberEnd, &s.FromModelName, &s.OriginalHeaders)
+			b.Substores = append(b.Substores, s)
+			return err
+		}},
+		{listArchiveUsersSQL, []interface{}{accountID}, func(rows *sql.Rows) error {
+			var username string
+			err := rows.Scan(&username)
+			b.Users = append(b.Users, username)
+			return err
+		}},
+		{listArchiveAPIKeysSQL, []interface{}{accountID}, func(rows *sql.Rows) error {
+			var name string
+			err := rows.Scan(&name)
+			b.APIKeys = append(b.APIKeys, name)
+			return err
+		}},
+	}
+
+	for _, q := range queries {
+		if err := queryArchiveRows(ctx, tx, q.query, q.args, q.scan); err != nil {
+			return b, err
+		}
+	}
+	return b, nil
+}
+
+func queryArchiveRows(ctx context.Context, tx *sql.Tx, query string, args []interface{}, scan func(rows *sql.Rows) error) error {
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		if err := scan(rows); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// encodeAccountBundle returns the gzipped JSON of the bundle. The signing logs of the account are
+// written by the signingLogs function and compressed one at a time, so they are not held in memory
+func encodeAccountBundle(bundle AccountBundle, signingLogs func(write func(SigningLog) error) error) ([]byte, error) {
+	head, err := json.Marshal(bundle)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(append(head[:len(head)-1], `,"signingLogs":[`...)); err != nil {
+		return nil, err
+	}
+
+	enc := json.NewEncoder(zw)
+	written := 0
+	err = signingLogs(func(l SigningLog) error {
+		if written > 0 {
+			if _, err := zw.Write([]byte(",")); err != nil {
+				return err
+			}
+		}
+		written++
+		return enc.Encode(l)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := zw.Write([]byte("]}\n")); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
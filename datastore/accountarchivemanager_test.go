@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+func TestSQLiteDeleteAccount(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Username: "root", Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypairs, err := db.ListAllowedKeypairs(ctx, root)
+	if err != nil || len(keypairs) != 1 {
+		t.Fatalf("ListAllowedKeypairs() = %v, %v", keypairs, err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypairs[0].ID, KeypairIDUser: keypairs[0].ID}, User{})
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	if err := db.UpsertSignedModelAssert(ctx, SignedModelAssertion{ModelID: model.ID, Digest: "digest", Assertion: "signed", Signed: time.Now()}); err != nil {
+		t.Fatalf("UpsertSignedModelAssert() error = %v", err)
+	}
+	if _, err := db.createClientCert(ctx, ClientCert{AccountID: account.ID, Name: "factory", Fingerprint: "cert-fp"}); err != nil {
+		t.Fatalf("createClientCert() error = %v", err)
+	}
+	if _, err := db.rotateAccountHMAC(ctx, account.ID); err != nil {
+		t.Fatalf("rotateAccountHMAC() error = %v", err)
+	}
+	for _, serial := range []string{"A1", "A2"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+	if _, err := db.createAPIKey(ctx, APIKey{AccountID: account.ID, Name: "factory", Key: "alder-api-key", Active: true}); err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+
+	archive, err := db.DeleteAccount(ctx, account.ID, AuditLog{Username: "root", Action: "delete", Object: "account"})
+	if err != nil {
+		t.Fatalf("DeleteAccount() error = %v", err)
+	}
+	if archive.ID == 0 || archive.AuthorityID != "alder" || archive.Keypairs != 1 || archive.Models != 1 || archive.SigningLogs != 2 {
+		t.Errorf("DeleteAccount() = %v, want the counts of the archived records", archive)
+	}
+
+	// The account and its records are deleted, and the keypair is disabled
+	if _, err := db.GetAccount(ctx, "alder"); err == nil {
+		t.Error("GetAccount() expected an error for the deleted account")
+	}
+	models, err := db.ListAllowedModels(ctx, root, ModelFilter{})
+	if err != nil || len(models) != 0 {
+		t.Errorf("ListAllowedModels() = %v, %v, want no models", models, err)
+	}
+	if _, err := db.FindAccountAPIKey(ctx, "alder-api-key"); err == nil {
+		t.Error("FindAccountAPIKey() expected an error for the key of the deleted account")
+	}
+	keypairs, err = db.ListAllowedKeypairs(ctx, root)
+	if err != nil || len(keypairs) != 1 || keypairs[0].Active {
+		t.Errorf("ListAllowedKeypairs() = %v, %v, want the disabled keypair", keypairs, err)
+	}
+	for _, table := range []string{"signedmodelassertion", "clientcert", "accounthmac"} {
+		var count int
+		if err := db.QueryRow("SELECT COUNT(*) FROM " + table).Scan(&count); err != nil || count != 0 {
+			t.Errorf("%s = %d, %v, want the records of the account deleted", table, count, err)
+		}
+	}
+
+	// The signing logs are anonymized, so they stay in the audit chain
+	var anonymized int
+	if err := db.QueryRow("SELECT COUNT(*) FROM signinglog WHERE make='alder' AND serial_number LIKE 'anonymized:%'").Scan(&anonymized); err != nil || anonymized != 2 {
+		t.Errorf("anonymized signing logs = %d, %v, want 2", anonymized, err)
+	}
+
+	archives, err := db.ListAccountArchives(ctx)
+	if err != nil || len(archives) != 1 || archives[0].ID != archive.ID {
+		t.Fatalf("ListAccountArchives() = %v, %v", archives, err)
+	}
+
+	// The export bundle is the gzipped JSON of the records
+	authorityID, data, err := db.GetAccountArchiveBundle(ctx, archive.ID)
+	if err != nil || authorityID != "alder" {
+		t.Fatalf("GetAccountArchiveBundle() = %v, %v", authorityID, err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("gzip.NewReader() error = %v", err)
+	}
+	bundle := AccountBundle{}
+	if err := json.NewDecoder(zr).Decode(&bundle); err != nil {
+		t.Fatalf("decoding the bundle: %v", err)
+	}
+	if bundle.Assertion != "assertion" || len(bundle.Models) != 1 || len(bundle.SigningLogs) != 2 || len(bundle.APIKeys) != 1 {
+		t.Fatalf("bundle = %v, want the records of the account", bundle)
+	}
+	if bundle.SigningLogs[0].SerialNumber != "A1" || bundle.SigningLogs[1].SerialNumber != "A2" {
+		t.Errorf("bundle signing logs = %v, want the signing logs before they are anonymized", bundle.SigningLogs)
+	}
+}
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -30,39 +31,44 @@ const createAccountTableSQL = `
 		id            serial primary key not null,
 		authority_id  varchar(200) not null unique,
 		assertion     text default '',
-		resellerapi   bool default false
+		resellerapi   bool default false,
+		hashserial    bool default false,
+		default_keypair_id int default 0,
+		version       int not null default 1
 	)
 `
 
-const createAccountSQL = "INSERT INTO account (authority_id, assertion, resellerapi) VALUES ($1,$2,$3)"
-const listAccountsSQL = "select id, authority_id, assertion, resellerapi from account order by authority_id"
-const getAccountSQL = "select id, authority_id, assertion, resellerapi from account where authority_id=$1"
+const createAccountSQL = "INSERT INTO account (authority_id, assertion, resellerapi, hashserial) VALUES ($1,$2,$3,$4)"
+const listAccountsSQL = "select id, authority_id, assertion, resellerapi, hashserial, default_keypair_id, version from account order by authority_id"
+const getAccountSQL = "select id, authority_id, assertion, resellerapi, hashserial, default_keypair_id, version from account where authority_id=$1"
 const getUserAccountSQL = `
-	select a.id, a.authority_id, a.assertion, a.resellerapi 
+	select a.id, a.authority_id, a.assertion, a.resellerapi, a.hashserial, a.default_keypair_id, a.version
 	from account a
-	inner join useraccountlink l on a.id = l.account_id
-	inner join userinfo u on l.user_id = u.id
-	where a.authority_id=$1 and u.username=$2`
+	inner join useraccountlink ua on a.id = ua.account_id
+	inner join userinfo u on ua.user_id = u.id
+	where a.authority_id=$1 and u.username=$2` + accountRoleSyncUserSQL
 
-const getAccountByIDSQL = "select id, authority_id, assertion, resellerapi from account where id=$1"
+const getAccountByIDSQL = "select id, authority_id, assertion, resellerapi, hashserial, default_keypair_id, version from account where id=$1"
 const getUserAccountByIDSQL = `
-	select a.id, a.authority_id, a.assertion, a.resellerapi 
+	select a.id, a.authority_id, a.assertion, a.resellerapi, a.hashserial, a.default_keypair_id, a.version
 	from account a
-	inner join useraccountlink l on a.id = l.account_id
-	inner join userinfo u on l.user_id = u.id
-	where a.id=$1 and u.username=$2`
+	inner join useraccountlink ua on a.id = ua.account_id
+	inner join userinfo u on ua.user_id = u.id
+	where a.id=$1 and u.username=$2` + accountRoleAdminSQL
 
-const updateAccountSQL = "update account set authority_id=$2, assertion=$3, resellerapi=$4 where id=$1"
+const updateAccountSQL = `
+	update account set authority_id=$2, assertion=$3, resellerapi=$4, hashserial=$5, version=version+1
+	where id=$1 and ($6=0 or version=$6)`
 const updateUserAccountSQL = `
 	UPDATE account a
-	SET authority_id=$3, assertion=$4, resellerapi=$5 
-	INNER JOIN useraccountlink l on a.id = l.account_id
-	INNER JOIN userinfo u on l.user_id = u.id
-	WHERE a.id=$1 AND u.username=$2
-`
+	SET authority_id=$3, assertion=$4, resellerapi=$5, hashserial=$6, version=a.version+1
+	INNER JOIN useraccountlink ua on a.id = ua.account_id
+	INNER JOIN userinfo u on ua.user_id = u.id
+	WHERE a.id=$1 AND u.username=$2 AND ($7=0 OR a.version=$7)` + accountRoleAdminSQL
+
 const upsertAccountSQL = `
 	WITH upsert AS (
-		update account set authority_id=$1, assertion=$2
+		update account set authority_id=$1, assertion=$2, version=version+1
 		where authority_id=$1
 		RETURNING *
 	)
@@ -71,16 +77,32 @@ const upsertAccountSQL = `
 	where not exists (select * from upsert)
 `
 
+// sqlite3 syntax of the upsert, inserting the account when it is not updated
+const upsertAccountSQLite = `
+	UPDATE account SET authority_id=$1, assertion=$2, version=version+1 WHERE authority_id=$1;
+	INSERT INTO account (authority_id,assertion) SELECT $1, $2 WHERE changes()=0
+`
+
 const listUserAccountsSQL = `
-	select a.id, a.authority_id, a.assertion, a.resellerapi 
+	select a.id, a.authority_id, a.assertion, a.resellerapi, a.hashserial, a.default_keypair_id, a.version
 	from account a
 	inner join useraccountlink l on a.id = l.account_id
 	inner join userinfo u on l.user_id = u.id
 	where u.username=$1
 `
 
+// The accounts of the user, where the user has the role to see them
+const listAllowedUserAccountsSQL = `
+	select a.id, a.authority_id, a.assertion, a.resellerapi, a.hashserial, a.default_keypair_id, a.version
+	from account a
+	inner join useraccountlink ua on a.id = ua.account_id
+	inner join userinfo u on ua.user_id = u.id
+	where u.username=$1` + accountRoleSyncUserSQL + `
+	order by a.authority_id
+`
+
 const listNotUserAccountsSQL = `
-	select id, authority_id, assertion, resellerapi 
+	select id, authority_id, assertion, resellerapi, hashserial, default_keypair_id, version
 	from account
 	where id not in (
 		select a.id 
@@ -94,38 +116,69 @@ const listNotUserAccountsSQL = `
 // sqlite3 syntax for syncing data locally
 const syncUpsertAccountSQL = `
 	INSERT OR REPLACE INTO account
-	(id,authority_id,assertion,resellerapi)
-	VALUES ($1, $2, $3, $4)
+	(id,authority_id,assertion,resellerapi,hashserial)
+	VALUES ($1, $2, $3, $4, $5)
 `
 
 // Add the reseller API field to indicate whether the reseller functions are available for an account
 const alterAccountResellerAPI = "alter table account add column resellerapi bool default false"
 
+// Add the hash serial field to indicate whether the serial numbers of an account are stored as hashes
+const alterAccountHashSerial = "alter table account add column hashserial bool default false"
+
+// Add the default keypair field, the signing key of the new models of an account
+const alterAccountDefaultKeypair = "alter table account add column default_keypair_id int default 0"
+
+// Add the version field, which is incremented by each update of an account
+const alterAccountVersion = "alter table account add column version int not null default 1"
+
+const updateAccountDefaultKeypairSQL = "update account set default_keypair_id=$2, version=version+1 where id=$1"
+
 // Account holds the store account assertion in the local database
 type Account struct {
 	ID          int
 	AuthorityID string
 	Assertion   string
 	ResellerAPI bool
+	HashSerial  bool // the signing logs store a salted hash of the serial number
+
+	DefaultKeypairID int // the signing key of the new models, when they do not select one
+
+	Version int // incremented by each update, to detect conflicting updates
 }
 
 // CreateAccountTable creates the database table for an account.
-func (db *DB) CreateAccountTable() error {
-	_, err := db.Exec(createAccountTableSQL)
+func (db *DB) CreateAccountTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAccountTableSQL)
 	return err
 }
 
 // AlterAccountTable modifies the database table for an account.
-func (db *DB) AlterAccountTable() error {
-	db.Exec(alterAccountResellerAPI)
+func (db *DB) AlterAccountTable(ctx context.Context) error {
+	db.ExecContext(ctx, alterAccountResellerAPI)
+	db.ExecContext(ctx, alterAccountHashSerial)
+	db.ExecContext(ctx, alterAccountDefaultKeypair)
+	db.ExecContext(ctx, alterAccountVersion)
 	return nil
 }
 
-func (db *DB) listAllAccounts() ([]Account, error) {
-	return db.listAccountsFilteredByUser(anyUserFilter)
+func (db *DB) listAllAccounts(ctx context.Context) ([]Account, error) {
+	return db.listAccountsFilteredByUser(ctx, anyUserFilter)
+}
+
+// listAllowedAccountsFilteredByUser returns the accounts where the user has the role to see them
+func (db *DB) listAllowedAccountsFilteredByUser(ctx context.Context, username string) ([]Account, error) {
+	rows, err := db.QueryContext(ctx, listAllowedUserAccountsSQL, username)
+	if err != nil {
+		log.Errorf("Error retrieving database accounts: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	return rowsToAccounts(rows)
 }
 
-func (db *DB) listAccountsFilteredByUser(username string) ([]Account, error) {
+func (db *DB) listAccountsFilteredByUser(ctx context.Context, username string) ([]Account, error) {
 
 	var (
 		rows *sql.Rows
@@ -133,12 +186,12 @@ func (db *DB) listAccountsFilteredByUser(username string) ([]Account, error) {
 	)
 
 	if len(username) == 0 {
-		rows, err = db.Query(listAccountsSQL)
+		rows, err = db.QueryContext(ctx, listAccountsSQL)
 	} else {
-		rows, err = db.Query(listUserAccountsSQL, username)
+		rows, err = db.QueryContext(ctx, listUserAccountsSQL, username)
 	}
 	if err != nil {
-		log.Printf("Error retrieving database accounts: %v\n", err)
+		log.Errorf("Error retrieving database accounts: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -147,82 +200,124 @@ func (db *DB) listAccountsFilteredByUser(username string) ([]Account, error) {
 }
 
 // CreateAccount creates an account in the database
-func (db *DB) CreateAccount(account Account) error {
-	_, err := db.Exec(createAccountSQL, account.AuthorityID, account.Assertion, account.ResellerAPI)
+func (db *DB) CreateAccount(ctx context.Context, account Account) error {
+	assertion, err := encryptAssertion(account.Assertion)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, createAccountSQL, account.AuthorityID, assertion, account.ResellerAPI, account.HashSerial)
 	if err != nil {
-		log.Printf("Error creating the database account: %v\n", err)
+		log.Errorf("Error creating the database account: %v", err)
 		return err
 	}
 	return nil
 }
 
-func (db *DB) getAccountForUser(authorityID, username string) (Account, error) {
+func (db *DB) getAccountForUser(ctx context.Context, authorityID, username string) (Account, error) {
 	account := Account{}
 
-	err := db.QueryRow(getUserAccountSQL, authorityID, username).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI)
+	err := db.QueryRowContext(ctx, getUserAccountSQL, authorityID, username).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI, &account.HashSerial, &account.DefaultKeypairID, &account.Version)
 	if err != nil {
-		log.Printf("Error retrieving account: %v\n", err)
+		log.Errorf("Error retrieving account: %v", err)
 		return account, err
 	}
 
-	return account, nil
+	account.Assertion, err = decryptColumn(account.Assertion)
+	return account, err
 }
 
 // GetAccount fetches a single account from the database by the authority ID
-func (db *DB) GetAccount(authorityID string) (Account, error) {
+func (db *DB) GetAccount(ctx context.Context, authorityID string) (Account, error) {
+	return db.getAccount(ctx, authorityID)
+}
+
+// FindAccount fetches a single account by the authority ID for the signing requests, from the
+// replica when there is one. The replica lags the primary, so the reads that must see a change
+// of the account, such as the updates and their version checks, use GetAccount
+func (db *DB) FindAccount(ctx context.Context, authorityID string) (Account, error) {
+	return db.reader().getAccount(ctx, authorityID)
+}
+
+func (db *DB) getAccount(ctx context.Context, authorityID string) (Account, error) {
 	account := Account{}
 
-	err := db.QueryRow(getAccountSQL, authorityID).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI)
+	err := db.QueryRowContext(ctx, getAccountSQL, authorityID).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI, &account.HashSerial, &account.DefaultKeypairID, &account.Version)
 	if err != nil {
-		log.Printf("Error retrieving account: %v\n", err)
+		log.Errorf("Error retrieving account: %v", err)
 		return account, err
 	}
 
-	return account, nil
+	account.Assertion, err = decryptColumn(account.Assertion)
+	return account, err
 }
 
 // getAccountByID fetches a single account from the database by the ID
-func (db *DB) getAccountByID(accountID int) (Account, error) {
+func (db *DB) getAccountByID(ctx context.Context, accountID int) (Account, error) {
 	account := Account{}
 
-	err := db.QueryRow(getAccountByIDSQL, accountID).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI)
+	err := db.QueryRowContext(ctx, getAccountByIDSQL, accountID).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI, &account.HashSerial, &account.DefaultKeypairID, &account.Version)
 	if err != nil {
-		log.Printf("Error retrieving account: %v\n", err)
+		log.Errorf("Error retrieving account: %v", err)
 		return account, err
 	}
 
-	return account, nil
+	account.Assertion, err = decryptColumn(account.Assertion)
+	return account, err
 }
 
 // getUserAccountByID fetches a single account from the database by the ID
-func (db *DB) getUserAccountByID(accountID int, username string) (Account, error) {
+func (db *DB) getUserAccountByID(ctx context.Context, accountID int, username string) (Account, error) {
 	account := Account{}
 
-	err := db.QueryRow(getUserAccountByIDSQL, accountID, username).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI)
+	err := db.QueryRowContext(ctx, getUserAccountByIDSQL, accountID, username).Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI, &account.HashSerial, &account.DefaultKeypairID, &account.Version)
 	if err != nil {
-		log.Printf("Error retrieving account: %v\n", err)
+		log.Errorf("Error retrieving account: %v", err)
 		return account, err
 	}
 
-	return account, nil
+	account.Assertion, err = decryptColumn(account.Assertion)
+	return account, err
 }
 
-// updateAccount updates an account in the database
-func (db *DB) updateAccount(account Account) error {
-	_, err := db.Exec(updateAccountSQL, account.ID, account.AuthorityID, account.Assertion, account.ResellerAPI)
+// updateAccount updates an account in the database. When the account has a version, it is
+// only updated if it has not been changed since that version was read
+func (db *DB) updateAccount(ctx context.Context, account Account) error {
+	assertion, err := encryptAssertion(account.Assertion)
 	if err != nil {
-		log.Printf("Error updating the database account: %v\n", err)
 		return err
 	}
 
-	return nil
+	result, err := db.ExecContext(ctx, updateAccountSQL, account.ID, account.AuthorityID, assertion, account.ResellerAPI, account.HashSerial, account.Version)
+	if err != nil {
+		log.Errorf("Error updating the database account: %v", err)
+		return err
+	}
+
+	return db.checkVersion(ctx, result, "account", account.ID, account.Version)
 }
 
 // updateUserAccount updates an account in the database
-func (db *DB) updateUserAccount(account Account, username string) error {
-	_, err := db.Exec(updateUserAccountSQL, account.ID, username, account.AuthorityID, account.Assertion, account.ResellerAPI)
+func (db *DB) updateUserAccount(ctx context.Context, account Account, username string) error {
+	assertion, err := encryptAssertion(account.Assertion)
 	if err != nil {
-		log.Printf("Error updating the database account: %v\n", err)
+		return err
+	}
+
+	result, err := db.ExecContext(ctx, updateUserAccountSQL, account.ID, username, account.AuthorityID, assertion, account.ResellerAPI, account.HashSerial, account.Version)
+	if err != nil {
+		log.Errorf("Error updating the database account: %v", err)
+		return err
+	}
+
+	return db.checkVersion(ctx, result, "account", account.ID, account.Version)
+}
+
+// updateAccountDefaultKeypair sets the default keypair of an account, or clears it with zero
+func (db *DB) updateAccountDefaultKeypair(ctx context.Context, accountID, keypairID int) error {
+	_, err := db.ExecContext(ctx, updateAccountDefaultKeypairSQL, accountID, keypairID)
+	if err != nil {
+		log.Errorf("Error updating the default keypair of the account: %v", err)
 		return err
 	}
 
@@ -230,10 +325,15 @@ func (db *DB) updateUserAccount(account Account, username string) error {
 }
 
 // putAccount stores an account in the database
-func (db *DB) putAccount(account Account) (string, error) {
-	_, err := db.Exec(upsertAccountSQL, account.AuthorityID, account.Assertion)
+func (db *DB) putAccount(ctx context.Context, account Account) (string, error) {
+	assertion, err := encryptAssertion(account.Assertion)
+	if err != nil {
+		return "", err
+	}
+
+	_, err = db.ExecContext(ctx, upsertAccountSQL, account.AuthorityID, assertion)
 	if err != nil {
-		log.Printf("Error updating the database account: %v\n", err)
+		log.Errorf("Error updating the database account: %v", err)
 		return "", err
 	}
 
@@ -241,10 +341,15 @@ func (db *DB) putAccount(account Account) (string, error) {
 }
 
 // syncAccount stores an account in the database
-func (db *DB) syncAccount(account Account) error {
-	_, err := db.Exec(syncUpsertAccountSQL, account.ID, account.AuthorityID, account.Assertion, account.ResellerAPI)
+func (db *DB) syncAccount(ctx context.Context, account Account) error {
+	assertion, err := encryptAssertion(account.Assertion)
 	if err != nil {
-		log.Printf("Error updating the database account: %v\n", err)
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, syncUpsertAccountSQL, account.ID, account.AuthorityID, assertion, account.ResellerAPI, account.HashSerial)
+	if err != nil {
+		log.Errorf("Error updating the database account: %v", err)
 		return err
 	}
 
@@ -252,10 +357,10 @@ func (db *DB) syncAccount(account Account) error {
 }
 
 // ListUserAccounts returns a list of Account objects related with certain user
-func (db *DB) ListUserAccounts(username string) ([]Account, error) {
-	rows, err := db.Query(listUserAccountsSQL, username)
+func (db *DB) ListUserAccounts(ctx context.Context, username string) ([]Account, error) {
+	rows, err := db.QueryContext(ctx, listUserAccountsSQL, username)
 	if err != nil {
-		log.Printf("Error retrieving database accounts of certain user: %v\n", err)
+		log.Errorf("Error retrieving database accounts of certain user: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -264,10 +369,10 @@ func (db *DB) ListUserAccounts(username string) ([]Account, error) {
 }
 
 // ListNotUserAccounts returns a list of Account objects that are not related with certain user
-func (db *DB) ListNotUserAccounts(username string) ([]Account, error) {
-	rows, err := db.Query(listNotUserAccountsSQL, username)
+func (db *DB) ListNotUserAccounts(ctx context.Context, username string) ([]Account, error) {
+	rows, err := db.QueryContext(ctx, listNotUserAccountsSQL, username)
 	if err != nil {
-		log.Printf("Error retrieving database accounts not belonging to certain user: %v\n", err)
+		log.Errorf("Error retrieving database accounts not belonging to certain user: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -280,10 +385,13 @@ func rowsToAccounts(rows *sql.Rows) ([]Account, error) {
 
 	for rows.Next() {
 		account := Account{}
-		err := rows.Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI)
+		err := rows.Scan(&account.ID, &account.AuthorityID, &account.Assertion, &account.ResellerAPI, &account.HashSerial, &account.DefaultKeypairID, &account.Version)
 		if err != nil {
 			return nil, err
 		}
+		if account.Assertion, err = decryptColumn(account.Assertion); err != nil {
+			return nil, err
+		}
 		accounts = append(accounts, account)
 	}
 
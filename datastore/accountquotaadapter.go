@@ -0,0 +1,73 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// GetAllowedAccountQuota returns the quota of the account, if the user is authorized to see it
+func (db *DB) GetAllowedAccountQuota(ctx context.Context, accountID int, authorization User) (AccountQuota, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return AccountQuota{}, errors.New("You do not have permissions to this account")
+		}
+		return db.getAccountQuotaByID(ctx, accountID)
+	default:
+		return AccountQuota{}, errors.New("You do not have permissions to this account")
+	}
+}
+
+// UpdateAllowedAccountQuota validates and updates the quota of the account, if the user is
+// authorized to do it. Only the superuser sets the quotas, as they are set for the brand accounts
+func (db *DB) UpdateAllowedAccountQuota(ctx context.Context, quota AccountQuota, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		if err := validateAccountQuota(quota); err != nil {
+			return err
+		}
+		return db.upsertAccountQuota(ctx, quota)
+	default:
+		return errors.New("You do not have permissions to update the account quota")
+	}
+}
+
+func validateAccountQuota(quota AccountQuota) error {
+	if quota.Warning < 0 || quota.Limit < 0 || quota.Grace < 0 {
+		return errors.New("The quota levels must not be negative")
+	}
+	if quota.Limit == 0 && quota.Grace > 0 {
+		return errors.New("The grace overage needs an enforcement limit")
+	}
+	if quota.Limit > 0 && quota.Warning > quota.Limit {
+		return errors.New("The warning level must not be above the enforcement limit")
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// Quota state of an account, for the next device that is signed
+const (
+	QuotaOK       = "ok"       // below the warning level, or no quota is set
+	QuotaWarning  = "warning"  // at or above the warning level
+	QuotaGrace    = "grace"    // above the enforcement limit, but within the grace overage
+	QuotaExceeded = "exceeded" // above the enforcement limit and the grace overage
+)
+
+const createAccountQuotaTableSQL = `
+	CREATE TABLE IF NOT EXISTS accountquota (
+		id               serial primary key not null,
+		account_id       int references account not null unique,
+		warning          int not null default 0,
+		enforce          int not null default 0,
+		grace            int not null default 0,
+		notified         boolean not null default false,
+		used             int
+	)
+`
+
+// The usage of the quota is the number of serial assertions signed for the brand. It is counted
+// in the quota of the account, from the signing logs and the index of the purged signing logs when
+// the quota is first set, so the signing logs are not counted on each signing
+const alterAccountQuotaUsedSQL = "ALTER TABLE accountquota ADD COLUMN IF NOT EXISTS used int"
+
+const countAccountQuotaUsedSQL = `
+	UPDATE accountquota SET used=(
+		SELECT COUNT(*) FROM signinglog s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=accountquota.account_id) + (
+		SELECT COUNT(*) FROM signingserial s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=accountquota.account_id)
+	WHERE used IS NULL
+`
+
+// The accounts without a quota show the signing logs of the brand, with the purged signing logs
+const getAccountQuotaSQL = `
+	SELECT a.id, COALESCE(q.warning, 0), COALESCE(q.enforce, 0), COALESCE(q.grace, 0), COALESCE(q.notified, false),
+		COALESCE(q.used, (SELECT COUNT(*) FROM signinglog s WHERE s.make=a.authority_id) + (SELECT COUNT(*) FROM signingserial s WHERE s.make=a.authority_id))
+	FROM account a
+	LEFT JOIN accountquota q ON q.account_id=a.id
+`
+
+const getAccountQuotaByIDSQL = getAccountQuotaSQL + "WHERE a.id=$1"
+
+const getAccountQuotaByAuthorityIDSQL = `
+	SELECT q.account_id, q.warning, q.enforce, q.grace, q.notified, COALESCE(q.used, 0)
+	FROM accountquota q
+	INNER JOIN account a ON a.id=q.account_id
+	WHERE a.authority_id=$1
+`
+
+// The signed device is counted in the quota, unless the quota is exceeded. The quota is checked by
+// the update of its row, so the concurrent signing requests cannot go over the quota
+const useAccountQuotaSQL = `
+	UPDATE accountquota SET used=COALESCE(used, 0)+1
+	WHERE account_id=(SELECT id FROM account WHERE authority_id=$1)
+	AND (enforce=0 OR COALESCE(used, 0) < enforce+grace)
+	RETURNING account_id, warning, enforce, grace, notified, used
+`
+
+// The devices that are not signed, and the signing logs synced from the factories, update the count
+const releaseAccountQuotaSQL = `
+	UPDATE accountquota SET used=used-1
+	WHERE account_id=(SELECT id FROM account WHERE authority_id=$1) AND used > 0
+`
+
+const countAccountQuotaSyncSQL = `
+	UPDATE accountquota SET used=COALESCE(used, 0)+1
+	WHERE account_id=(SELECT id FROM account WHERE authority_id=$1)
+`
+
+// Changing the quota resets the notification, so the new warning level is notified
+const upsertAccountQuotaSQL = `
+	WITH upsert AS (
+		update accountquota set warning=$2, enforce=$3, grace=$4, notified=false
+		where account_id=$1
+		RETURNING *
+	)
+	insert into accountquota (account_id,warning,enforce,grace,used)
+	select $1, $2, $3, $4, (SELECT COUNT(*) FROM signinglog s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=$1) + (SELECT COUNT(*) FROM signingserial s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=$1)
+	where not exists (select * from upsert)
+`
+
+// sqlite3 syntax of the upsert, inserting the quota when it is not updated
+const upsertAccountQuotaSQLite = `
+	UPDATE accountquota SET warning=$2, enforce=$3, grace=$4, notified=false WHERE account_id=$1;
+	INSERT INTO accountquota (account_id,warning,enforce,grace,used)
+		SELECT $1, $2, $3, $4, (SELECT COUNT(*) FROM signinglog s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=$1) + (SELECT COUNT(*) FROM signingserial s INNER JOIN account a ON s.make=a.authority_id WHERE a.id=$1)
+		WHERE changes()=0
+`
+
+const notifyAccountQuotaSQL = "UPDATE accountquota SET notified=true WHERE account_id=$1 AND notified=false"
+
+// AccountQuota holds the signing quota of an account. The warning level notifies
+// that the quota is running out, and the enforcement limit stops the signing of
+// devices once the grace overage is used up. A zero level is not enforced
+type AccountQuota struct {
+	AccountID int  `json:"accountID"`
+	Warning   int  `json:"warning"`
+	Limit     int  `json:"limit"`
+	Grace     int  `json:"grace"`
+	Notified  bool `json:"notified"`
+	Used      int  `json:"used"`
+}
+
+// QuotaCheck is the result of checking the quota of an account before signing a device
+type QuotaCheck struct {
+	Quota  AccountQuota
+	State  string
+	Notify bool // the quota has reached the warning level since the last notification
+}
+
+// State returns the quota state when the given number of devices are signed
+func (q AccountQuota) State(signed int) string {
+	switch {
+	case q.Limit > 0 && signed > q.Limit+q.Grace:
+		return QuotaExceeded
+	case q.Limit > 0 && signed > q.Limit:
+		return QuotaGrace
+	case q.Warning > 0 && signed >= q.Warning:
+		return QuotaWarning
+	default:
+		return QuotaOK
+	}
+}
+
+// CreateAccountQuotaTable creates the database table for the account quotas
+func (db *DB) CreateAccountQuotaTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createAccountQuotaTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, alterAccountQuotaUsedSQL); err != nil {
+		return err
+	}
+
+	// The quotas that were set before the count are counted from the signing logs
+	_, err := db.ExecContext(ctx, countAccountQuotaUsedSQL)
+	return err
+}
+
+// CheckAccountQuota counts the next signed device in the quota of the account, unless the
+// quota is exceeded. The device is released from the quota when it is not signed. The first
+// check that reaches the warning level flags the quota as notified
+func (db *DB) CheckAccountQuota(ctx context.Context, authorityID string) (QuotaCheck, error) {
+	quota := AccountQuota{}
+	err := db.QueryRowContext(ctx, useAccountQuotaSQL, authorityID).Scan(&quota.AccountID, &quota.Warning, &quota.Limit, &quota.Grace, &quota.Notified, &quota.Used)
+	if err == sql.ErrNoRows {
+		return db.exceededAccountQuota(ctx, authorityID)
+	}
+	if err != nil {
+		return QuotaCheck{}, fmt.Errorf("error updating the account quota: %v", err)
+	}
+
+	check := QuotaCheck{Quota: quota, State: quota.State(quota.Used)}
+	if check.State == QuotaOK || quota.Notified {
+		return check, nil
+	}
+
+	result, err := db.ExecContext(ctx, notifyAccountQuotaSQL, quota.AccountID)
+	if err != nil {
+		return QuotaCheck{}, fmt.Errorf("error updating the account quota: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return QuotaCheck{}, fmt.Errorf("error updating the account quota: %v", err)
+	}
+
+	// Concurrent signing requests only notify once
+	check.Notify = rows > 0
+	return check, nil
+}
+
+// exceededAccountQuota checks the quota of an account that was not updated, as the account has
+// no quota or its quota is exceeded. A brand without an account has no quota
+func (db *DB) exceededAccountQuota(ctx context.Context, authorityID string) (QuotaCheck, error) {
+	quota, err := db.getAccountQuota(ctx, getAccountQuotaByAuthorityIDSQL, authorityID)
+	if err == sql.ErrNoRows {
+		return QuotaCheck{State: QuotaOK}, nil
+	}
+	if err != nil {
+		return QuotaCheck{}, fmt.Errorf("error retrieving the account quota: %v", err)
+	}
+	return QuotaCheck{Quota: quota, State: quota.State(quota.Used + 1)}, nil
+}
+
+// ReleaseAccountQuota releases a device that was counted in the quota of the account, when the
+// device is not signed
+func (db *DB) ReleaseAccountQuota(ctx context.Context, authorityID string) error {
+	if _, err := db.ExecContext(ctx, releaseAccountQuotaSQL, authorityID); err != nil {
+		return fmt.Errorf("error updating the account quota: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) getAccountQuotaByID(ctx context.Context, accountID int) (AccountQuota, error) {
+	quota, err := db.getAccountQuota(ctx, getAccountQuotaByIDSQL, accountID)
+	if err != nil {
+		return quota, fmt.Errorf("error retrieving the account quota: %v", err)
+	}
+	return quota, nil
+}
+
+func (db *DB) getAccountQuota(ctx context.Context, query string, arg interface{}) (AccountQuota, error) {
+	quota := AccountQuota{}
+	err := db.QueryRowContext(ctx, query, arg).Scan(&quota.AccountID, &quota.Warning, &quota.Limit, &quota.Grace, &quota.Notified, &quota.Used)
+	return quota, err
+}
+
+func (db *DB) upsertAccountQuota(ctx context.Context, quota AccountQuota) error {
+	_, err := db.ExecContext(ctx, upsertAccountQuotaSQL, quota.AccountID, quota.Warning, quota.Limit, quota.Grace)
+	if err != nil {
+		return fmt.Errorf("error updating the account quota: %v", err)
+	}
+	return nil
+}
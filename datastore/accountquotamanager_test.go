@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestAccountQuotaState(t *testing.T) {
+	tests := []struct {
+		name   string
+		quota  AccountQuota
+		signed int
+		state  string
+	}{
+		{"no quota", AccountQuota{}, 1000, QuotaOK},
+		{"below warning", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 79, QuotaOK},
+		{"at warning", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 80, QuotaWarning},
+		{"at limit", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 100, QuotaWarning},
+		{"in grace", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 101, QuotaGrace},
+		{"end of grace", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 110, QuotaGrace},
+		{"exceeded", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, 111, QuotaExceeded},
+		{"exceeded no grace", AccountQuota{Warning: 80, Limit: 100}, 101, QuotaExceeded},
+		{"warning only", AccountQuota{Warning: 80}, 1000, QuotaWarning},
+		{"limit only", AccountQuota{Limit: 100}, 100, QuotaOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.quota.State(tt.signed); got != tt.state {
+				t.Errorf("State() = %v, want %v", got, tt.state)
+			}
+		})
+	}
+}
+
+func TestValidateAccountQuota(t *testing.T) {
+	tests := []struct {
+		name    string
+		quota   AccountQuota
+		wantErr bool
+	}{
+		{"no quota", AccountQuota{}, false},
+		{"valid", AccountQuota{Warning: 80, Limit: 100, Grace: 10}, false},
+		{"warning only", AccountQuota{Warning: 80}, false},
+		{"warning at limit", AccountQuota{Warning: 100, Limit: 100}, false},
+		{"warning above limit", AccountQuota{Warning: 101, Limit: 100}, true},
+		{"grace without limit", AccountQuota{Warning: 80, Grace: 10}, true},
+		{"negative", AccountQuota{Limit: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAccountQuota(tt.quota); (err != nil) != tt.wantErr {
+				t.Errorf("validateAccountQuota() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSQLiteCheckAccountQuota(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+
+	// A brand without an account, or an account without a quota, has no quota
+	for _, brand := range []string{"unknown", "alder"} {
+		if brand == "alder" {
+			if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+				t.Fatalf("PutAccount() error = %v", err)
+			}
+		}
+		if check, err := db.CheckAccountQuota(ctx, brand); err != nil || check.State != QuotaOK {
+			t.Fatalf("CheckAccountQuota(%s) = %v, %v, want no quota", brand, check, err)
+		}
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+
+	// The quota is counted from the signing logs when it is set
+	if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-A1"}); err != nil {
+		t.Fatalf("CreateSigningLog() error = %v", err)
+	}
+	if err := db.UpdateAllowedAccountQuota(ctx, AccountQuota{AccountID: account.ID, Warning: 2, Limit: 2, Grace: 1}, root); err != nil {
+		t.Fatalf("UpdateAllowedAccountQuota() error = %v", err)
+	}
+
+	states := []string{QuotaWarning, QuotaGrace, QuotaExceeded, QuotaExceeded}
+	for i, state := range states {
+		check, err := db.CheckAccountQuota(ctx, "alder")
+		if err != nil || check.State != state {
+			t.Fatalf("CheckAccountQuota() %d = %v, %v, want %s", i, check, err, state)
+		}
+		if check.Notify != (i == 0) {
+			t.Errorf("CheckAccountQuota() %d notify = %v", i, check.Notify)
+		}
+	}
+
+	// The exceeded checks are not counted, and a device that is not signed is released
+	if err := db.ReleaseAccountQuota(ctx, "alder"); err != nil {
+		t.Fatalf("ReleaseAccountQuota() error = %v", err)
+	}
+	quota, err := db.GetAllowedAccountQuota(ctx, account.ID, root)
+	if err != nil || quota.Used != 2 {
+		t.Fatalf("GetAllowedAccountQuota() = %v, %v, want 2 used", quota, err)
+	}
+
+	// The signing logs of the factories are counted
+	if err := db.CreateSigningLogSync(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A9", Fingerprint: "fp-A9", Created: time.Now()}); err != nil {
+		t.Fatalf("CreateSigningLogSync() error = %v", err)
+	}
+	if quota, err = db.GetAllowedAccountQuota(ctx, account.ID, root); err != nil || quota.Used != 3 {
+		t.Fatalf("GetAllowedAccountQuota() = %v, %v, want 3 used", quota, err)
+	}
+}
@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// ListAllowedAPIKeys returns the named API keys of the account, if the user is authorized to see them
+func (db *DB) ListAllowedAPIKeys(ctx context.Context, accountID int, authorization User) ([]APIKey, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return nil, err
+	}
+	return db.listAPIKeys(ctx, accountID)
+}
+
+// GetAllowedAPIKey returns a named API key of the account, if the user is authorized to see it
+func (db *DB) GetAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) (APIKey, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return APIKey{}, err
+	}
+	return db.getAPIKey(ctx, keyID, accountID)
+}
+
+// CreateAllowedAPIKey validates and creates a named API key for the account, if the user is
+// authorized to do it. The key is generated when it is not supplied
+func (db *DB) CreateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) (APIKey, error) {
+	if err := db.checkAPIKeyAccount(ctx, k.AccountID, authorization); err != nil {
+		return k, err
+	}
+
+	key, err := buildValidOrDefaultAPIKey(k.Key)
+	if err != nil {
+		return k, errors.New("Error in generating a valid API key")
+	}
+	k.Key = key
+
+	if err := validateAPIKey(k); err != nil {
+		return k, err
+	}
+
+	k.ID, err = db.createAPIKey(ctx, k)
+	return k, err
+}
+
+// UpdateAllowedAPIKey validates and updates the name, scopes and status of a named API key,
+// if the user is authorized to do it. The key itself is not changed
+func (db *DB) UpdateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) error {
+	defer db.invalidateCache(cacheModels, cacheAPIKeys)
+
+	if err := db.checkAPIKeyAccount(ctx, k.AccountID, authorization); err != nil {
+		return err
+	}
+	if err := validateAPIKey(k); err != nil {
+		return err
+	}
+	return db.updateAPIKey(ctx, k)
+}
+
+// RotateAllowedAPIKey issues a new key for a named API key, if the user is authorized to do it.
+// The previous key stays valid for the grace period, so the factories can move to the new key
+func (db *DB) RotateAllowedAPIKey(ctx context.Context, keyID, accountID int, grace time.Duration, authorization User) (APIKey, error) {
+	defer db.invalidateCache(cacheModels, cacheAPIKeys)
+
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return APIKey{}, err
+	}
+
+	k, err := db.getAPIKey(ctx, keyID, accountID)
+	if err != nil {
+		return k, err
+	}
+
+	key, err := generateAPIKey()
+	if err != nil {
+		return k, errors.New("Error in generating a valid API key")
+	}
+
+	expires := time.Now().UTC().Add(grace)
+	k.PreviousKey, k.Key = k.Key, key
+	if err := db.rotateAPIKey(ctx, k, expires); err != nil {
+		return k, err
+	}
+	k.setPreviousExpires(expires)
+	return k, nil
+}
+
+// DeleteAllowedAPIKey deletes a named API key of the account, if the user is authorized to do it
+func (db *DB) DeleteAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) error {
+	defer db.invalidateCache(cacheModels, cacheAPIKeys)
+
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return err
+	}
+	return db.deleteAPIKey(ctx, keyID, accountID)
+}
+
+// checkAPIKeyAccount checks that the user is authorized to manage the API keys of the account
+func (db *DB) checkAPIKeyAccount(ctx context.Context, accountID int, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return errors.New("You do not have permissions to this account")
+		}
+		return nil
+	default:
+		return errors.New("You do not have permissions to this account")
+	}
+}
+
+// defaultAPIKeyGrace is the time that the previous key of a rotated API key stays valid
+const defaultAPIKeyGrace = 24 * time.Hour
+
+// maxAPIKeyGrace is the longest time that the previous key of a rotated API key stays valid
+const maxAPIKeyGrace = 30 * 24 * time.Hour
+
+// APIKeyGrace returns the time that the previous key of a rotated API key stays valid, from the config settings
+func APIKeyGrace(settings config.Settings) (time.Duration, error) {
+	if len(settings.APIKeyGrace) == 0 {
+		return defaultAPIKeyGrace, nil
+	}
+	return ParseAPIKeyGrace(settings.APIKeyGrace)
+}
+
+// ParseAPIKeyGrace parses the time that the previous key of a rotated API key stays valid
+func ParseAPIKeyGrace(value string) (time.Duration, error) {
+	grace, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid API key grace period: %v", err)
+	}
+	if grace < 0 || grace > maxAPIKeyGrace {
+		return 0, fmt.Errorf("the API key grace period must be between 0s and %v", maxAPIKeyGrace)
+	}
+	return grace, nil
+}
+
+func validateAPIKey(k APIKey) error {
+	if err := validateNotEmpty("Name", k.Name); err != nil {
+		return err
+	}
+	if len(k.Scopes) == 0 {
+		return errors.New("The API key must have at least one scope")
+	}
+	for _, s := range k.Scopes {
+		if !isValidAPIKeyScope(s) {
+			return fmt.Errorf("Invalid scope '%s' for the API key", s)
+		}
+	}
+	for _, c := range k.AllowedCIDRs {
+		if _, err := parseAllowedCIDR(c); err != nil {
+			return fmt.Errorf("Invalid network '%s' for the API key", c)
+		}
+	}
+	return nil
+}
+
+// AllowsSource checks whether the API key can be used from the source IP. The key can be used
+// from anywhere when it has no allowed networks
+func (k APIKey) AllowsSource(sourceIP string) bool {
+	if len(k.AllowedCIDRs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return false
+	}
+	for _, c := range k.AllowedCIDRs {
+		if network, err := parseAllowedCIDR(c); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseAllowedCIDR parses a network in CIDR notation, or a single IP address
+func parseAllowedCIDR(cidr string) (*net.IPNet, error) {
+	if !strings.Contains(cidr, "/") {
+		ip := net.ParseIP(cidr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid IP address: %s", cidr)
+		}
+		bits := 8 * net.IPv6len
+		if ip.To4() != nil {
+			ip, bits = ip.To4(), 8*net.IPv4len
+		}
+		return &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}, nil
+	}
+
+	_, network, err := net.ParseCIDR(cidr)
+	return network, err
+}
+
+func isValidAPIKeyScope(scope string) bool {
+	for _, s := range validAPIKeyScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
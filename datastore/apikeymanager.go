@@ -0,0 +1,313 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The named API keys of an account. Each key has its own scopes, so the keys of
+// the factories of a brand can be disabled individually
+const createAPIKeyTableSQL = `
+	CREATE TABLE IF NOT EXISTS apikey (
+		id               serial primary key not null,
+		account_id       int references account not null,
+		name             varchar(200) not null,
+		api_key          varchar(200) not null unique,
+		scopes           varchar(200) not null default '',
+		active           bool not null default true,
+		created          timestamp default current_timestamp,
+		unique (account_id, name)
+	)
+`
+
+// Add the previous key of a rotated API key, which stays valid until it expires
+const alterAPIKeyPreviousKey = "alter table apikey add column previous_key varchar(200) not null default ''"
+const alterAPIKeyPreviousExpires = "alter table apikey add column previous_expires timestamp not null default current_timestamp"
+
+// Add the networks that the API key can be used from, e.g. the egress IPs of a factory
+const alterAPIKeyAllowedCIDRs = "alter table apikey add column allowed_cidrs varchar(1000) not null default ''"
+
+const listAPIKeysSQL = `
+	SELECT id, account_id, name, api_key, scopes, active, created, previous_key, previous_expires, allowed_cidrs
+	FROM apikey
+	WHERE account_id=$1
+	ORDER BY name`
+
+const getAPIKeySQL = `
+	SELECT id, account_id, name, api_key, scopes, active, created, previous_key, previous_expires, allowed_cidrs
+	FROM apikey
+	WHERE id=$1 AND account_id=$2`
+
+const createAPIKeySQL = "INSERT INTO apikey (account_id, name, api_key, scopes, active, allowed_cidrs) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id"
+const updateAPIKeySQL = "UPDATE apikey SET name=$3, scopes=$4, active=$5, allowed_cidrs=$6 WHERE id=$1 AND account_id=$2"
+const deleteAPIKeySQL = "DELETE FROM apikey WHERE id=$1 AND account_id=$2"
+const rotateAPIKeySQL = "UPDATE apikey SET previous_key=api_key, previous_expires=$3, api_key=$4 WHERE id=$1 AND account_id=$2"
+
+// The scopes of an active API key of an account, including the previous key of a rotated key
+// until it expires
+const getAccountAPIKeyScopesSQL = `
+	SELECT k.scopes, k.api_key<>$1
+	FROM apikey k
+	INNER JOIN account a ON a.id=k.account_id
+	WHERE (k.api_key=$1 OR (k.previous_key=$1 AND k.previous_expires>$3)) AND a.authority_id=$2 AND k.active`
+
+// The active API key of an account by its value, including the previous key of a rotated key
+// until it expires, and whether it is the previous key
+const getAccountAPIKeySQL = `
+	SELECT k.id, k.account_id, k.name, a.authority_id, k.scopes, k.allowed_cidrs, k.api_key<>$1
+	FROM apikey k
+	INNER JOIN account a ON a.id=k.account_id
+	WHERE (k.api_key=$1 OR (k.previous_key=$1 AND k.previous_expires>$2)) AND k.active`
+
+// Scopes of the named API keys
+const (
+	ScopeSerialSigning = "serial-signing" // request nonces and sign serial assertions
+	ScopeReseller      = "reseller"       // the reseller API: model assertions and pivots
+	ScopeReadOnly      = "read-only"      // fetch the model assertions
+)
+
+var validAPIKeyScopes = []string{ScopeSerialSigning, ScopeReseller, ScopeReadOnly}
+
+// APIKey is a named API key of an account
+type APIKey struct {
+	ID        int       `json:"id"`
+	AccountID int       `json:"accountID"`
+	Name      string    `json:"name"`
+	Key       string    `json:"api-key"`
+	Scopes    []string  `json:"scopes"`
+	Active    bool      `json:"active"`
+	Created   time.Time `json:"created"`
+
+	// The previous key of a rotated API key stays valid until it expires
+	PreviousKey     string     `json:"-"`
+	PreviousExpires *time.Time `json:"previousExpires,omitempty"`
+
+	// The authority ID of the account, when the key is found by its value, and whether the
+	// value is the previous key of a rotated key
+	AuthorityID string `json:"authorityID,omitempty"`
+	Deprecated  bool   `json:"-"`
+
+	// The networks that the key can be used from, as CIDR ranges or IP addresses. The key
+	// can be used from anywhere when there are none
+	AllowedCIDRs []string `json:"allowedCIDRs"`
+}
+
+// HasScope checks whether the API key has one of the scopes
+func (k APIKey) HasScope(scopes ...string) bool {
+	for _, s := range k.Scopes {
+		for _, scope := range scopes {
+			if s == scope {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// CreateAPIKeyTable creates the database table for the named API keys
+func (db *DB) CreateAPIKeyTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAPIKeyTableSQL)
+	return err
+}
+
+// AlterAPIKeyTable adds the fields of the rotated API keys
+func (db *DB) AlterAPIKeyTable(ctx context.Context) error {
+	// Add the previous key fields, which are skipped if they already exist
+	db.ExecContext(ctx, alterAPIKeyPreviousKey)
+	db.ExecContext(ctx, alterAPIKeyPreviousExpires)
+	db.ExecContext(ctx, alterAPIKeyAllowedCIDRs)
+	return nil
+}
+
+// CheckAccountAPIKey checks that the API key is an active key of the account, with one of the scopes
+func (db *DB) CheckAccountAPIKey(ctx context.Context, apiKey, authorityID string, scopes ...string) bool {
+	allowed, _ := db.checkAccountAPIKey(ctx, apiKey, authorityID, scopes...)
+	return allowed
+}
+
+// checkAccountAPIKey checks the API key of the account, and returns whether it is the previous key
+// of a rotated key
+func (db *DB) checkAccountAPIKey(ctx context.Context, apiKey, authorityID string, scopes ...string) (bool, bool) {
+	if len(apiKey) == 0 {
+		return false, false
+	}
+
+	stored, err := encryptAPIKey(apiKey)
+	if err != nil {
+		return false, false
+	}
+
+	var s string
+	var deprecated bool
+	err = db.QueryRowContext(ctx, getAccountAPIKeyScopesSQL, stored, authorityID, time.Now().UTC()).Scan(&s, &deprecated)
+	if err != nil {
+		return false, false
+	}
+
+	return APIKey{Scopes: splitScopes(s)}.HasScope(scopes...), deprecated
+}
+
+func (db *DB) listAPIKeys(ctx context.Context, accountID int) ([]APIKey, error) {
+	rows, err := db.QueryContext(ctx, listAPIKeysSQL, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the API keys: %v", err)
+	}
+	defer rows.Close()
+
+	keys := []APIKey{}
+	for rows.Next() {
+		k := APIKey{}
+		var scopes, cidrs string
+		var expires time.Time
+		if err := rows.Scan(&k.ID, &k.AccountID, &k.Name, &k.Key, &scopes, &k.Active, &k.Created, &k.PreviousKey, &expires, &cidrs); err != nil {
+			return nil, fmt.Errorf("error retrieving the API keys: %v", err)
+		}
+		if err := k.decrypt(); err != nil {
+			return nil, fmt.Errorf("error retrieving the API keys: %v", err)
+		}
+		k.Scopes = splitScopes(scopes)
+		k.AllowedCIDRs = splitScopes(cidrs)
+		k.setPreviousExpires(expires)
+		keys = append(keys, k)
+	}
+
+	return keys, rows.Err()
+}
+
+func (db *DB) getAPIKey(ctx context.Context, keyID, accountID int) (APIKey, error) {
+	k := APIKey{}
+	var scopes, cidrs string
+	var expires time.Time
+	err := db.QueryRowContext(ctx, getAPIKeySQL, keyID, accountID).Scan(&k.ID, &k.AccountID, &k.Name, &k.Key, &scopes, &k.Active, &k.Created, &k.PreviousKey, &expires, &cidrs)
+	if err != nil {
+		return k, fmt.Errorf("error retrieving the API key: %v", err)
+	}
+	if err := k.decrypt(); err != nil {
+		return k, fmt.Errorf("error retrieving the API key: %v", err)
+	}
+	k.Scopes = splitScopes(scopes)
+	k.AllowedCIDRs = splitScopes(cidrs)
+	k.setPreviousExpires(expires)
+	return k, nil
+}
+
+func (db *DB) createAPIKey(ctx context.Context, k APIKey) (int, error) {
+	stored, err := encryptAPIKey(k.Key)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the API key: %v", err)
+	}
+
+	var id int
+	err = db.QueryRowContext(ctx, createAPIKeySQL, k.AccountID, k.Name, stored, joinScopes(k.Scopes), k.Active, joinScopes(k.AllowedCIDRs)).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the API key: %v", err)
+	}
+	return id, nil
+}
+
+func (db *DB) updateAPIKey(ctx context.Context, k APIKey) error {
+	_, err := db.ExecContext(ctx, updateAPIKeySQL, k.ID, k.AccountID, k.Name, joinScopes(k.Scopes), k.Active, joinScopes(k.AllowedCIDRs))
+	if err != nil {
+		return fmt.Errorf("error updating the API key: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) deleteAPIKey(ctx context.Context, keyID, accountID int) error {
+	_, err := db.ExecContext(ctx, deleteAPIKeySQL, keyID, accountID)
+	if err != nil {
+		return fmt.Errorf("error deleting the API key: %v", err)
+	}
+	return nil
+}
+
+// FindAccountAPIKey returns the active named API key of an account by its value, with its
+// scopes and the networks that it can be used from. The key is not found when it is the API key of a model.
+// The key is not cached when it is the previous key of a rotated key, as the previous key expires
+func (db *DB) FindAccountAPIKey(ctx context.Context, apiKey string) (APIKey, error) {
+	k := APIKey{}
+	key := cacheKey(apiKey)
+	if db.cacheGet(cacheAPIKeys, key, &k) {
+		return k, nil
+	}
+
+	stored, err := encryptAPIKey(apiKey)
+	if err != nil {
+		return k, err
+	}
+
+	var scopes, cidrs string
+	err = db.QueryRowContext(ctx, getAccountAPIKeySQL, stored, time.Now().UTC()).Scan(&k.ID, &k.AccountID, &k.Name, &k.AuthorityID, &scopes, &cidrs, &k.Deprecated)
+	k.Scopes = splitScopes(scopes)
+	k.AllowedCIDRs = splitScopes(cidrs)
+	if err == nil && !k.Deprecated {
+		db.cacheSet(cacheAPIKeys, key, k)
+	}
+	return k, err
+}
+
+// rotateAPIKey replaces the key, keeping the previous key valid until it expires
+func (db *DB) rotateAPIKey(ctx context.Context, k APIKey, expires time.Time) error {
+	stored, err := encryptAPIKey(k.Key)
+	if err != nil {
+		return fmt.Errorf("error rotating the API key: %v", err)
+	}
+
+	_, err = db.ExecContext(ctx, rotateAPIKeySQL, k.ID, k.AccountID, expires, stored)
+	if err != nil {
+		return fmt.Errorf("error rotating the API key: %v", err)
+	}
+	return nil
+}
+
+// decrypt decrypts the key and the previous key, when they are encrypted at rest
+func (k *APIKey) decrypt() (err error) {
+	if k.Key, err = decryptColumn(k.Key); err != nil {
+		return err
+	}
+	k.PreviousKey, err = decryptColumn(k.PreviousKey)
+	return err
+}
+
+// setPreviousExpires sets the expiry of the previous key, when there is a valid one
+func (k *APIKey) setPreviousExpires(expires time.Time) {
+	if len(k.PreviousKey) == 0 || !expires.After(time.Now().UTC()) {
+		k.PreviousKey = ""
+		return
+	}
+	k.PreviousExpires = &expires
+}
+
+// The scopes and the allowed networks are stored as a comma-separated list
+func joinScopes(scopes []string) string {
+	return strings.Join(scopes, ",")
+}
+
+func splitScopes(scopes string) []string {
+	if len(scopes) == 0 {
+		return []string{}
+	}
+	return strings.Split(scopes, ",")
+}
@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestValidateAPIKey(t *testing.T) {
+	tests := []struct {
+		name    string
+		key     APIKey
+		wantErr bool
+	}{
+		{"valid", APIKey{Name: "factory-1", Scopes: []string{ScopeSerialSigning}}, false},
+		{"valid-several-scopes", APIKey{Name: "reseller", Scopes: []string{ScopeReseller, ScopeReadOnly}}, false},
+		{"no-name", APIKey{Name: " ", Scopes: []string{ScopeSerialSigning}}, true},
+		{"no-scopes", APIKey{Name: "factory-1"}, true},
+		{"invalid-scope", APIKey{Name: "factory-1", Scopes: []string{"admin"}}, true},
+		{"valid-networks", APIKey{Name: "factory-1", Scopes: []string{ScopeSerialSigning}, AllowedCIDRs: []string{"192.0.2.0/24", "198.51.100.7", "2001:db8::/32"}}, false},
+		{"invalid-network", APIKey{Name: "factory-1", Scopes: []string{ScopeSerialSigning}, AllowedCIDRs: []string{"192.0.2.0/33"}}, true},
+		{"invalid-address", APIKey{Name: "factory-1", Scopes: []string{ScopeSerialSigning}, AllowedCIDRs: []string{"factory.example.com"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAPIKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("validateAPIKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	k := APIKey{Scopes: splitScopes(joinScopes([]string{ScopeReseller, ScopeReadOnly}))}
+	if !k.HasScope(ScopeReadOnly) {
+		t.Error("Expected the API key to have the read-only scope")
+	}
+	if !k.HasScope(ScopeSerialSigning, ScopeReseller) {
+		t.Error("Expected the API key to have one of the scopes")
+	}
+	if k.HasScope(ScopeSerialSigning) {
+		t.Error("Expected the API key not to have the serial-signing scope")
+	}
+	if len(splitScopes("")) != 0 {
+		t.Error("Expected no scopes for an empty list")
+	}
+}
+
+func TestAPIKeyAllowsSource(t *testing.T) {
+	k := APIKey{AllowedCIDRs: splitScopes(joinScopes([]string{"192.0.2.0/24", "198.51.100.7", "2001:db8::/32"}))}
+
+	tests := []struct {
+		sourceIP string
+		allowed  bool
+	}{
+		{"192.0.2.10", true},
+		{"198.51.100.7", true},
+		{"2001:db8::1", true},
+		{"198.51.100.8", false},
+		{"203.0.113.1", false},
+		{"", false},
+		{"not-an-ip", false},
+	}
+	for _, tt := range tests {
+		if allowed := k.AllowsSource(tt.sourceIP); allowed != tt.allowed {
+			t.Errorf("Expected the source IP '%s' to be allowed %v, got %v", tt.sourceIP, tt.allowed, allowed)
+		}
+	}
+
+	// A key without allowed networks can be used from anywhere
+	if !(APIKey{}).AllowsSource("203.0.113.1") {
+		t.Error("Expected the API key to be allowed from any source IP")
+	}
+}
+
+func TestAPIKeyGrace(t *testing.T) {
+	tests := []struct {
+		name    string
+		grace   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", "", 24 * time.Hour, false},
+		{"valid", "2h", 2 * time.Hour, false},
+		{"immediate", "0s", 0, false},
+		{"negative", "-1h", 0, true},
+		{"too-long", "721h", 0, true},
+		{"invalid", "soon", 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := APIKeyGrace(config.Settings{APIKeyGrace: tt.grace})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("APIKeyGrace() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("APIKeyGrace() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAPIKeyPreviousExpires(t *testing.T) {
+	k := APIKey{PreviousKey: "previous"}
+	k.setPreviousExpires(time.Now().UTC().Add(time.Hour))
+	if k.PreviousExpires == nil || k.PreviousKey != "previous" {
+		t.Error("Expected the previous key to be valid")
+	}
+
+	k = APIKey{PreviousKey: "previous"}
+	k.setPreviousExpires(time.Now().UTC().Add(-time.Hour))
+	if k.PreviousExpires != nil || k.PreviousKey != "" {
+		t.Error("Expected the previous key to have expired")
+	}
+}
+
+func TestSQLiteFindRotatedAPIKey(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+	db.cache = newMemoryCache(time.Minute)
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypairs, err := db.ListAllowedKeypairs(ctx, root)
+	if err != nil || len(keypairs) != 1 {
+		t.Fatalf("ListAllowedKeypairs() = %v, %v", keypairs, err)
+	}
+	if _, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypairs[0].ID, KeypairIDUser: keypairs[0].ID}, User{}); err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	id, err := db.createAPIKey(ctx, APIKey{AccountID: account.ID, Name: "factory", Key: "previous-key", Scopes: []string{ScopeSerialSigning}, Active: true})
+	if err != nil {
+		t.Fatalf("createAPIKey() error = %v", err)
+	}
+	if err := db.rotateAPIKey(ctx, APIKey{ID: id, AccountID: account.ID, Key: "current-key"}, time.Now().UTC().Add(time.Hour)); err != nil {
+		t.Fatalf("rotateAPIKey() error = %v", err)
+	}
+
+	// The previous key is found until it expires, but it is not cached
+	k, err := db.FindAccountAPIKey(ctx, "previous-key")
+	if err != nil || !k.Deprecated {
+		t.Fatalf("FindAccountAPIKey() = %v, %v, want the deprecated key", k, err)
+	}
+	model, err := db.FindModel(ctx, "alder", "alder-basic", "previous-key", ScopeSerialSigning)
+	if err != nil || model.DeprecatedKey {
+		t.Fatalf("FindModel() = %v, %v, want the model without its own key deprecated", model, err)
+	}
+	if db.cacheGet(cacheAPIKeys, cacheKey("previous-key"), &APIKey{}) {
+		t.Error("FindAccountAPIKey() cached the previous key")
+	}
+	if db.cacheGet(cacheModels, cacheKey("alder", "alder-basic", "previous-key", ScopeSerialSigning), &Model{}) {
+		t.Error("FindModel() cached the model of the previous key")
+	}
+
+	// The current key is cached
+	if k, err = db.FindAccountAPIKey(ctx, "current-key"); err != nil || k.Deprecated || !k.HasScope(ScopeSerialSigning) {
+		t.Fatalf("FindAccountAPIKey() = %v, %v, want the current key", k, err)
+	}
+	if model, err = db.FindModel(ctx, "alder", "alder-basic", "current-key", ScopeSerialSigning); err != nil || model.DeprecatedKey {
+		t.Fatalf("FindModel() = %v, %v, want the model of the current key", model, err)
+	}
+	if !db.cacheGet(cacheAPIKeys, cacheKey("current-key"), &APIKey{}) {
+		t.Error("FindAccountAPIKey() did not cache the current key")
+	}
+}
@@ -0,0 +1,39 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// VerifyAllowedAuditChain verifies the range of the audit chain, if the user is authorized to do it.
+// The chain covers all the brands, so only the superuser verifies it
+func (db *DB) VerifyAllowedAuditChain(ctx context.Context, authorization User, from, to int64) (AuditChainVerification, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.verifyAuditChain(ctx, from, to)
+	default:
+		return AuditChainVerification{}, errors.New("You do not have permissions to verify the audit chain")
+	}
+}
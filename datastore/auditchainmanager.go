@@ -0,0 +1,620 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Sources of the records that are linked into the audit chain
+const (
+	AuditSourceSigningLog = "signinglog"
+	AuditSourceAuditLog   = "auditlog"
+)
+
+// Reasons that an audit chain entry fails the verification
+const (
+	AuditChainBroken    = "broken-link"     // the entry is not linked to the previous entry, or entries are missing
+	AuditEntryModified  = "entry-modified"  // the hash of the entry does not match its contents
+	AuditRecordModified = "record-modified" // the record has been changed since it was linked into the chain
+	AuditRecordMissing  = "record-missing"  // the record has been removed, and was not released by a purge
+)
+
+// Reasons that a record is released from the audit chain, when it is deleted
+const (
+	AuditReleaseRetention = "retention"
+	AuditReleaseDataPurge = "data-purge"
+)
+
+// AuditChainMaxVerify is the largest range of the audit chain that is verified in a request
+const AuditChainMaxVerify = 100000
+
+// auditChainBatch is the number of records that are linked into the chain, or verified, at a time
+const auditChainBatch = 1000
+
+// auditChainMaxFailures is the number of failures that are returned by the verification
+const auditChainMaxFailures = 100
+
+// auditTombstone is the hash of a record that has been anonymized, as its hash cannot be checked
+const auditTombstone = "tombstone"
+
+// auditChainLock is the key of the advisory lock that serializes the appends to the chain. The
+// records of the audit sources are created holding the lock as shared, so an append waits for
+// the records that have been given an ID to be committed, and cannot skip them
+const auditChainLock = 0x7661756c74
+
+// Each entry of the chain holds the hash of the record and the hash of the previous entry,
+// so a record or an entry cannot be changed, removed or inserted without breaking the chain.
+// The sequence is assigned under the lock, so it has no gaps
+const createAuditChainTableSQL = `
+	CREATE TABLE IF NOT EXISTS auditchain (
+		seq              bigint primary key not null,
+		source           varchar(20) not null,
+		record_id        int not null,
+		record_hash      varchar(64) not null,
+		prev_hash        varchar(64) not null,
+		hash             varchar(64) not null,
+		created          timestamp not null,
+		unique (source, record_id)
+	)
+`
+
+// The records that are deleted by a purge are released from the chain in the same transaction,
+// so the verification can tell them from the records that are removed
+const createAuditChainReleaseTableSQL = `
+	CREATE TABLE IF NOT EXISTS auditchainrelease (
+		source           varchar(20) not null,
+		record_id        int not null,
+		reason           varchar(20) not null,
+		created          timestamp not null,
+		primary key (source, record_id)
+	)
+`
+
+const lockAuditChainSQL = "SELECT pg_advisory_xact_lock($1)"
+const lockAuditChainSharedSQL = "SELECT pg_advisory_xact_lock_shared($1)"
+
+// releaseSigningLogSQL releases the signing logs that match the condition of the purge, before they are deleted
+const releaseSigningLogSQL = "INSERT INTO auditchainrelease (source, record_id, reason, created) SELECT '" + AuditSourceSigningLog + "', id, $1, $2 FROM signinglog WHERE "
+const lastAuditChainSQL = "SELECT seq, hash FROM auditchain ORDER BY seq DESC LIMIT 1"
+const lastAuditChainRecordSQL = "SELECT COALESCE(MAX(record_id), 0) FROM auditchain WHERE source=$1"
+const createAuditChainSQL = "INSERT INTO auditchain (seq, source, record_id, record_hash, prev_hash, hash, created) VALUES ($1,$2,$3,$4,$5,$6,$7)"
+const maxAuditChainSQL = "SELECT COALESCE(MAX(seq), 0) FROM auditchain"
+const getAuditChainHashSQL = "SELECT hash FROM auditchain WHERE seq=$1"
+const getAuditChainLastSQL = "SELECT seq, source, record_id, record_hash, prev_hash, hash, created FROM auditchain ORDER BY seq DESC LIMIT 1"
+const listAuditChainSQL = `
+	SELECT seq, source, record_id, record_hash, prev_hash, hash, created
+	FROM auditchain
+	WHERE seq>=$1 AND seq<=$2
+	ORDER BY seq`
+
+// AuditChainEntry links a record into the tamper-evident audit chain
+type AuditChainEntry struct {
+	Seq        int64     `json:"seq"`
+	Source     string    `json:"source"`
+	RecordID   int       `json:"recordID"`
+	RecordHash string    `json:"recordHash"`
+	PrevHash   string    `json:"prevHash"`
+	Hash       string    `json:"hash"`
+	Created    time.Time `json:"created"`
+}
+
+// AuditChainProof proves that the records are linked into the audit chain. Each entry holds the record
+// as it is hashed, so the hash of the record and the hash of the entry can be checked offline. The last
+// entry of the chain when the proof is made can be compared with the hashes that the auditors keep, and
+// the entries in between are checked by the verification of the chain
+type AuditChainProof struct {
+	Entries []AuditChainProofEntry `json:"entries"`
+	Last    *AuditChainEntry       `json:"last"`
+}
+
+// AuditChainProofEntry is the entry of a record in the audit chain, with the record that is hashed
+type AuditChainProofEntry struct {
+	AuditChainEntry
+	Record json.RawMessage `json:"record"`
+}
+
+// AuditChainFailure is an entry of the audit chain that fails the verification
+type AuditChainFailure struct {
+	Seq      int64  `json:"seq"`
+	Source   string `json:"source"`
+	RecordID int    `json:"recordID"`
+	Reason   string `json:"reason"`
+}
+
+// AuditChainVerification is the result of the verification of a range of the audit chain.
+// The records that are deleted by the retention of the signing logs or by a data purge are
+// counted as purged, as the chain still proves their hash, and the anonymized signing logs are
+// counted as tombstones. Any other record that is missing fails the verification. The hash of
+// the last entry can be kept by the auditors, to check that the chain is not rebuilt later
+type AuditChainVerification struct {
+	From       int64               `json:"from"`
+	To         int64               `json:"to"`
+	Hash       string              `json:"hash"`
+	Entries    int                 `json:"entries"`
+	Purged     int                 `json:"purged"`
+	Tombstones int                 `json:"tombstones"`
+	Valid      bool                `json:"valid"`
+	Failures   []AuditChainFailure `json:"failures"`
+}
+
+// auditSource is a table of records that are linked into the audit chain
+type auditSource struct {
+	name string
+	next func(db *DB, afterID int, before time.Time, limit int) ([]auditRecord, error) // the records after the ID, in ID order
+	get  func(db *DB, ids []int) (map[int]string, error)                               // the hashes of the records by ID
+}
+
+// auditRecord is the hash of a record of an audit source
+type auditRecord struct {
+	id   int
+	hash string
+}
+
+var auditSources = []auditSource{
+	{AuditSourceSigningLog, nextSigningLogAuditRecords, getSigningLogAuditRecords},
+	{AuditSourceAuditLog, nextAuditLogAuditRecords, getAuditLogAuditRecords},
+}
+
+// CreateAuditChainTable creates the database tables for the audit chain and its released records
+func (db *DB) CreateAuditChainTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createAuditChainTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createAuditChainReleaseTableSQL)
+	return err
+}
+
+// lockAuditRecord takes the shared lock of the audit chain in the transaction that creates a
+// record of an audit source. CockroachDB has no advisory locks
+func lockAuditRecord(ctx context.Context, tx *sql.Tx) error {
+	if InFactory() || InCockroach() {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, lockAuditChainSharedSQL, auditChainLock)
+	return err
+}
+
+// releaseSigningLog releases the signing logs that match the condition from the audit chain,
+// in the transaction that deletes them
+func releaseSigningLog(ctx context.Context, tx *sql.Tx, reason string, now time.Time, condition string, args ...interface{}) error {
+	_, err := tx.ExecContext(ctx, releaseSigningLogSQL+condition, append([]interface{}{reason, now.UTC()}, args...)...)
+	return err
+}
+
+// AppendAuditChain links the new records of the audit sources into the audit chain, and
+// returns the number of records that are linked. The audit chain is not kept in the factory
+func (db *DB) AppendAuditChain(ctx context.Context, now time.Time) (int, error) {
+	if InFactory() {
+		return 0, nil
+	}
+
+	appended := 0
+	for _, source := range auditSources {
+		for {
+			n, err := db.appendAuditChain(ctx, source, now)
+			appended += n
+			if err != nil {
+				return appended, fmt.Errorf("error appending to the audit chain: %v", err)
+			}
+			if n < auditChainBatch {
+				break
+			}
+		}
+	}
+	return appended, nil
+}
+
+// appendAuditChain links a batch of the records of the source into the chain
+func (db *DB) appendAuditChain(ctx context.Context, source auditSource, now time.Time) (int, error) {
+	appended := 0
+	created := now.UTC().Truncate(time.Microsecond)
+
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		// CockroachDB has no advisory locks, and retries the transaction of a concurrent append instead
+		if !InCockroach() {
+			if _, err := tx.ExecContext(ctx, lockAuditChainSQL, auditChainLock); err != nil {
+				return err
+			}
+		}
+
+		var seq int64
+		var prev string
+		err := tx.QueryRowContext(ctx, lastAuditChainSQL).Scan(&seq, &prev)
+		if err != nil && err != sql.ErrNoRows {
+			return err
+		}
+
+		var afterID int
+		if err = tx.QueryRowContext(ctx, lastAuditChainRecordSQL, source.name).Scan(&afterID); err != nil {
+			return err
+		}
+
+		records, err := source.next(db, afterID, now, auditChainBatch)
+		if err != nil {
+			return err
+		}
+
+		for _, r := range records {
+			seq++
+			hash := auditChainHash(prev, source.name, r.id, r.hash, created)
+			if _, err = tx.ExecContext(ctx, createAuditChainSQL, seq, source.name, r.id, r.hash, prev, hash, created); err != nil {
+				return err
+			}
+			prev = hash
+		}
+		appended = len(records)
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return appended, nil
+}
+
+// verifyAuditChain checks the links of the entries in the range of the audit chain, and the hashes of
+// their records. A zero range verifies the start or the end of the chain
+func (db *DB) verifyAuditChain(ctx context.Context, from, to int64) (AuditChainVerification, error) {
+	var last int64
+	if err := db.QueryRowContext(ctx, maxAuditChainSQL).Scan(&last); err != nil {
+		return AuditChainVerification{}, fmt.Errorf("error verifying the audit chain: %v", err)
+	}
+
+	from, to, err := auditChainRange(from, to, last)
+	if err != nil {
+		return AuditChainVerification{}, err
+	}
+
+	v := &auditChainVerifier{result: AuditChainVerification{From: from, To: to, Failures: []AuditChainFailure{}}, seq: from}
+	if from > 1 {
+		err = db.QueryRowContext(ctx, getAuditChainHashSQL, from-1).Scan(&v.prev)
+		if err != nil && err != sql.ErrNoRows {
+			return AuditChainVerification{}, fmt.Errorf("error verifying the audit chain: %v", err)
+		}
+	}
+
+	for start := from; start <= to; start += auditChainBatch {
+		end := start + auditChainBatch - 1
+		if end > to {
+			end = to
+		}
+
+		entries, err := db.listAuditChain(ctx, start, end)
+		if err != nil {
+			return AuditChainVerification{}, err
+		}
+		if err = v.verify(db, entries); err != nil {
+			return AuditChainVerification{}, err
+		}
+	}
+
+	// Entries removed from the end of the range
+	if v.seq <= to {
+		v.fail(AuditChainFailure{Seq: v.seq, Reason: AuditChainBroken})
+	}
+
+	v.result.Hash = v.prev
+	v.result.Valid = len(v.result.Failures) == 0
+	return v.result, nil
+}
+
+// auditChainRange checks the range of the chain to verify, defaulting to the whole chain
+func auditChainRange(from, to, last int64) (int64, int64, error) {
+	if from <= 0 {
+		from = 1
+	}
+	if to <= 0 || to > last {
+		to = last
+	}
+	if to < from {
+		return from, to, nil
+	}
+	if to-from >= AuditChainMaxVerify {
+		return 0, 0, fmt.Errorf("the range of the audit chain must be up to %d entries", AuditChainMaxVerify)
+	}
+	return from, to, nil
+}
+
+func (db *DB) listAuditChain(ctx context.Context, from, to int64) ([]AuditChainEntry, error) {
+	rows, err := db.QueryContext(ctx, listAuditChainSQL, from, to)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the audit chain: %v", err)
+	}
+	defer rows.Close()
+
+	entries := []AuditChainEntry{}
+	for rows.Next() {
+		e := AuditChainEntry{}
+		if err := rows.Scan(&e.Seq, &e.Source, &e.RecordID, &e.RecordHash, &e.PrevHash, &e.Hash, &e.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the audit chain: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// SigningLogAuditChainProof returns the proof that the signing logs are linked into the audit chain.
+// The signing logs that are not linked yet, or are anonymized, have no entry in the proof
+func (db *DB) SigningLogAuditChainProof(ctx context.Context, ids []int) (AuditChainProof, error) {
+	proof := AuditChainProof{Entries: []AuditChainProofEntry{}}
+	if len(ids) == 0 {
+		return proof, nil
+	}
+
+	listSQL := sq.
+		Select("s.*").
+		From("signinglog s").
+		Where(sq.Eq{"s.id": ids}).
+		PlaceholderFormat(sq.Dollar)
+	logs, err := db.querySigningLog(ctx, listSQL)
+	if err != nil {
+		return proof, fmt.Errorf("error retrieving the audit chain proof: %v", err)
+	}
+	records := map[int][]byte{}
+	for _, l := range logs {
+		if !strings.HasPrefix(l.SerialNumber, anonymizedPrefix) {
+			records[l.ID] = signingLogAuditRecord(l)
+		}
+	}
+
+	entriesSQL := sq.
+		Select("seq", "source", "record_id", "record_hash", "prev_hash", "hash", "created").
+		From("auditchain").
+		Where(sq.Eq{"source": AuditSourceSigningLog, "record_id": ids}).
+		OrderBy("seq").
+		PlaceholderFormat(sq.Dollar)
+	rows, err := entriesSQL.RunWith(db).QueryContext(ctx)
+	if err != nil {
+		return proof, fmt.Errorf("error retrieving the audit chain proof: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		e := AuditChainProofEntry{}
+		if err := rows.Scan(&e.Seq, &e.Source, &e.RecordID, &e.RecordHash, &e.PrevHash, &e.Hash, &e.Created); err != nil {
+			return proof, fmt.Errorf("error retrieving the audit chain proof: %v", err)
+		}
+		record, ok := records[e.RecordID]
+		if !ok {
+			continue
+		}
+		e.Record = record
+		proof.Entries = append(proof.Entries, e)
+	}
+	if err := rows.Err(); err != nil {
+		return proof, fmt.Errorf("error retrieving the audit chain proof: %v", err)
+	}
+
+	last := AuditChainEntry{}
+	err = db.QueryRowContext(ctx, getAuditChainLastSQL).Scan(&last.Seq, &last.Source, &last.RecordID, &last.RecordHash, &last.PrevHash, &last.Hash, &last.Created)
+	if err == sql.ErrNoRows {
+		return proof, nil
+	}
+	if err != nil {
+		return proof, fmt.Errorf("error retrieving the audit chain proof: %v", err)
+	}
+	proof.Last = &last
+	return proof, nil
+}
+
+// auditChainVerifier holds the state of the verification of the audit chain
+type auditChainVerifier struct {
+	result AuditChainVerification
+	seq    int64  // the next expected sequence
+	prev   string // the hash of the previous entry
+}
+
+// verify checks a batch of the entries of the audit chain, in sequence order
+func (v *auditChainVerifier) verify(db *DB, entries []AuditChainEntry) error {
+	ids := map[string][]int{}
+	for _, e := range entries {
+		if e.Seq != v.seq || e.PrevHash != v.prev {
+			v.fail(AuditChainFailure{Seq: e.Seq, Source: e.Source, RecordID: e.RecordID, Reason: AuditChainBroken})
+		}
+		if e.Hash != auditChainHash(e.PrevHash, e.Source, e.RecordID, e.RecordHash, e.Created) {
+			v.fail(AuditChainFailure{Seq: e.Seq, Source: e.Source, RecordID: e.RecordID, Reason: AuditEntryModified})
+		}
+		v.seq = e.Seq + 1
+		v.prev = e.Hash
+		v.result.Entries++
+		ids[e.Source] = append(ids[e.Source], e.RecordID)
+	}
+
+	// Check the records have not been changed since they were linked into the chain
+	hashes := map[string]map[int]string{}
+	for _, source := range auditSources {
+		if len(ids[source.name]) == 0 {
+			continue
+		}
+		h, err := source.get(db, ids[source.name])
+		if err != nil {
+			return fmt.Errorf("error verifying the audit chain: %v", err)
+		}
+		hashes[source.name] = h
+	}
+
+	missing := map[string][]int{}
+	for _, e := range entries {
+		hash, ok := hashes[e.Source][e.RecordID]
+		switch {
+		case !ok:
+			missing[e.Source] = append(missing[e.Source], e.RecordID)
+		case hash == auditTombstone:
+			v.result.Tombstones++
+		case hash != e.RecordHash:
+			v.fail(AuditChainFailure{Seq: e.Seq, Source: e.Source, RecordID: e.RecordID, Reason: AuditRecordModified})
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// The missing records must have been released by a purge
+	released, err := db.listAuditChainReleases(missing)
+	if err != nil {
+		return fmt.Errorf("error verifying the audit chain: %v", err)
+	}
+	for _, e := range entries {
+		if _, ok := hashes[e.Source][e.RecordID]; ok {
+			continue
+		}
+		if released[e.Source][e.RecordID] {
+			v.result.Purged++
+			continue
+		}
+		v.fail(AuditChainFailure{Seq: e.Seq, Source: e.Source, RecordID: e.RecordID, Reason: AuditRecordMissing})
+	}
+	return nil
+}
+
+// listAuditChainReleases returns the records of the sources that have been released from the chain
+func (db *DB) listAuditChainReleases(ids map[string][]int) (map[string]map[int]bool, error) {
+	released := map[string]map[int]bool{}
+	for source, sourceIDs := range ids {
+		listSQL := sq.
+			Select("record_id").
+			From("auditchainrelease").
+			Where(sq.Eq{"source": source, "record_id": sourceIDs}).
+			PlaceholderFormat(sq.Dollar)
+
+		rows, err := listSQL.RunWith(db).QueryContext(context.Background())
+		if err != nil {
+			return nil, err
+		}
+
+		released[source] = map[int]bool{}
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return nil, err
+			}
+			released[source][id] = true
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return released, nil
+}
+
+func (v *auditChainVerifier) fail(f AuditChainFailure) {
+	if len(v.result.Failures) < auditChainMaxFailures {
+		v.result.Failures = append(v.result.Failures, f)
+	}
+}
+
+// auditChainHash is the hash of an entry of the audit chain
+func auditChainHash(prevHash, source string, recordID int, recordHash string, created time.Time) string {
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%s|%s|%d|%s|%s", prevHash, source, recordID, recordHash, created.UTC().Format(time.RFC3339Nano))))
+	return hex.EncodeToString(hash[:])
+}
+
+// auditSigningLog holds the fields of a signing log that are covered by the audit chain.
+// The synced flag of the factory is not covered, as it changes when the log is synced
+type auditSigningLog struct {
+	ID              int       `json:"id"`
+	Make            string    `json:"make"`
+	Model           string    `json:"model"`
+	SerialNumber    string    `json:"serialnumber"`
+	Fingerprint     string    `json:"fingerprint"`
+	Created         time.Time `json:"created"`
+	Revision        int       `json:"revision"`
+	TimestampSource string    `json:"timestampsource"`
+	SourceIP        string    `json:"sourceip"`
+	APIKeyID        string    `json:"apikeyid"`
+	RequestID       string    `json:"requestid"`
+	UserAgent       string    `json:"useragent"`
+	TimestampToken  []byte    `json:"timestamptoken,omitempty"`
+}
+
+// signingLogAuditHash is the hash of the signing log for the audit chain
+func signingLogAuditHash(l SigningLog) string {
+	hash := sha256.Sum256(signingLogAuditRecord(l))
+	return hex.EncodeToString(hash[:])
+}
+
+// signingLogAuditRecord is the JSON of the fields of the signing log that are hashed for the audit
+// chain. The token is omitted when there is none, so the hashes of the older signing logs are unchanged
+func signingLogAuditRecord(l SigningLog) []byte {
+	data, _ := json.Marshal(auditSigningLog{
+		ID:              l.ID,
+		Make:            l.Make,
+		Model:           l.Model,
+		SerialNumber:    l.SerialNumber,
+		Fingerprint:     l.Fingerprint,
+		Created:         l.Created.UTC(),
+		Revision:        l.Revision,
+		TimestampSource: l.TimestampSource,
+		SourceIP:        l.SourceIP,
+		APIKeyID:        l.APIKeyID,
+		RequestID:       l.RequestID,
+		UserAgent:       l.UserAgent,
+		TimestampToken:  l.TimestampToken,
+	})
+	return data
+}
+
+func nextSigningLogAuditRecords(db *DB, afterID int, before time.Time, limit int) ([]auditRecord, error) {
+	logs, err := db.querySigningLog(context.Background(), exportSigningLogSQLBuilder(afterID, limit).Where(sq.Lt{"s.created": before}))
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]auditRecord, 0, len(logs))
+	for _, l := range logs {
+		records = append(records, auditRecord{id: l.ID, hash: signingLogAuditHash(l)})
+	}
+	return records, nil
+}
+
+func getSigningLogAuditRecords(db *DB, ids []int) (map[int]string, error) {
+	listSQL := sq.
+		Select("s.*").
+		From("signinglog s").
+		Where(sq.Eq{"s.id": ids}).
+		PlaceholderFormat(sq.Dollar)
+
+	logs, err := db.querySigningLog(context.Background(), listSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[int]string{}
+	for _, l := range logs {
+		if strings.HasPrefix(l.SerialNumber, anonymizedPrefix) {
+			hashes[l.ID] = auditTombstone
+			continue
+		}
+		hashes[l.ID] = signingLogAuditHash(l)
+	}
+	return hashes, nil
+}
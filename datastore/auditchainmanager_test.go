@@ -0,0 +1,286 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testAuditChain creates a valid chain of entries, for records that are not in an audit source
+func testAuditChain(count int) []AuditChainEntry {
+	created := time.Date(2020, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	entries := []AuditChainEntry{}
+	prev := ""
+	for i := 1; i <= count; i++ {
+		e := AuditChainEntry{Seq: int64(i), Source: "test", RecordID: i, RecordHash: "record", PrevHash: prev, Created: created}
+		e.Hash = auditChainHash(e.PrevHash, e.Source, e.RecordID, e.RecordHash, e.Created)
+		entries = append(entries, e)
+		prev = e.Hash
+	}
+	return entries
+}
+
+// testAuditSource adds a source of records for the test chain, with the same hash for every record
+func testAuditSource(t *testing.T) {
+	saved := auditSources
+	t.Cleanup(func() { auditSources = saved })
+	auditSources = append(auditSources, auditSource{name: "test", get: func(db *DB, ids []int) (map[int]string, error) {
+		hashes := map[int]string{}
+		for _, id := range ids {
+			hashes[id] = "record"
+		}
+		return hashes, nil
+	}})
+}
+
+func TestAuditChainVerifier(t *testing.T) {
+	testAuditSource(t)
+
+	tests := []struct {
+		name   string
+		change func([]AuditChainEntry) []AuditChainEntry
+		reason string
+		seq    int64
+	}{
+		{"valid", func(e []AuditChainEntry) []AuditChainEntry { return e }, "", 0},
+		{"entry modified", func(e []AuditChainEntry) []AuditChainEntry { e[1].RecordHash = "changed"; return e }, AuditEntryModified, 2},
+		{"entry removed", func(e []AuditChainEntry) []AuditChainEntry { return append(e[:1], e[2:]...) }, AuditChainBroken, 3},
+		{"entry rehashed", func(e []AuditChainEntry) []AuditChainEntry {
+			e[1].RecordHash = "changed"
+			e[1].Hash = auditChainHash(e[1].PrevHash, e[1].Source, e[1].RecordID, e[1].RecordHash, e[1].Created)
+			return e
+		}, AuditChainBroken, 3},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &auditChainVerifier{result: AuditChainVerification{Failures: []AuditChainFailure{}}, seq: 1}
+			if err := v.verify(nil, tt.change(testAuditChain(3))); err != nil {
+				t.Fatalf("verify() unexpected error: %v", err)
+			}
+
+			if len(tt.reason) == 0 {
+				if len(v.result.Failures) != 0 {
+					t.Errorf("verify() unexpected failures: %v", v.result.Failures)
+				}
+				if v.result.Entries != 3 {
+					t.Errorf("verify() expected 3 entries, got %v", v.result)
+				}
+				return
+			}
+			if len(v.result.Failures) == 0 || v.result.Failures[0].Reason != tt.reason || v.result.Failures[0].Seq != tt.seq {
+				t.Errorf("verify() expected %s at %d, got %v", tt.reason, tt.seq, v.result.Failures)
+			}
+		})
+	}
+}
+
+func TestAuditChainRange(t *testing.T) {
+	tests := []struct {
+		from, to, last int64
+		wantFrom       int64
+		wantTo         int64
+		wantErr        bool
+	}{
+		{0, 0, 50, 1, 50, false},
+		{10, 20, 50, 10, 20, false},
+		{10, 100, 50, 10, 50, false},
+		{0, 0, 0, 1, 0, false},
+		{1, 0, 2 * AuditChainMaxVerify, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		from, to, err := auditChainRange(tt.from, tt.to, tt.last)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("auditChainRange(%d, %d, %d) error = %v, wantErr %v", tt.from, tt.to, tt.last, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (from != tt.wantFrom || to != tt.wantTo) {
+			t.Errorf("auditChainRange(%d, %d, %d) = %d, %d, want %d, %d", tt.from, tt.to, tt.last, from, to, tt.wantFrom, tt.wantTo)
+		}
+	}
+}
+
+func TestSigningLogAuditHash(t *testing.T) {
+	l := SigningLog{ID: 1, Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "a1", Created: time.Now(), Revision: 1}
+	hash := signingLogAuditHash(l)
+
+	if signingLogAuditHash(l) != hash {
+		t.Error("signingLogAuditHash() expected the same hash for the same signing log")
+	}
+
+	// The synced flag is not covered by the chain
+	synced := l
+	synced.Synced = 1
+	if signingLogAuditHash(synced) != hash {
+		t.Error("signingLogAuditHash() expected the synced flag to be ignored")
+	}
+
+	changed := l
+	changed.SerialNumber = "A2"
+	if signingLogAuditHash(changed) == hash {
+		t.Error("signingLogAuditHash() expected a different hash for a changed serial number")
+	}
+
+	// The time-stamp token is covered by the chain, and is left out of the record when there is none
+	stamped := l
+	stamped.TimestampToken = []byte("token")
+	if signingLogAuditHash(stamped) == hash {
+		t.Error("signingLogAuditHash() expected a different hash for a time-stamp token")
+	}
+	if strings.Contains(string(signingLogAuditRecord(l)), "timestamptoken") {
+		t.Error("signingLogAuditRecord() expected no token without a time-stamp token")
+	}
+}
+
+func TestSQLiteSigningLogAuditTombstone(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, serial := range []string{"A1", "A2"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, purgeSerialSQL[PurgeModeAnonymize], "A2"); err != nil {
+		t.Fatalf("anonymizing the signing log: %v", err)
+	}
+
+	// The anonymized signing log is a tombstone in the audit chain
+	hashes, err := getSigningLogAuditRecords(db, []int{1, 2})
+	if err != nil || len(hashes) != 2 {
+		t.Fatalf("getSigningLogAuditRecords() = %v, %v", hashes, err)
+	}
+	if hashes[1] == auditTombstone || hashes[2] != auditTombstone {
+		t.Errorf("getSigningLogAuditRecords() = %v, want the anonymized signing log as a tombstone", hashes)
+	}
+}
+
+func TestSQLiteAuditChainMissingRecords(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, serial := range []string{"A1", "A2", "A3"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	// Link the signing logs into a chain
+	records, err := nextSigningLogAuditRecords(db, 0, time.Now().Add(time.Hour), auditChainBatch)
+	if err != nil || len(records) != 3 {
+		t.Fatalf("nextSigningLogAuditRecords() = %v, %v", records, err)
+	}
+	created := time.Now().UTC()
+	entries := []AuditChainEntry{}
+	prev := ""
+	for i, r := range records {
+		e := AuditChainEntry{Seq: int64(i + 1), Source: AuditSourceSigningLog, RecordID: r.id, RecordHash: r.hash, PrevHash: prev, Created: created}
+		e.Hash = auditChainHash(e.PrevHash, e.Source, e.RecordID, e.RecordHash, e.Created)
+		entries = append(entries, e)
+		prev = e.Hash
+	}
+
+	// The purged signing log is released from the chain, the deleted signing log is missing
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		_, _, err := purgeSerial(ctx, tx, "A2", PurgeModeDelete)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("purgeSerial() error = %v", err)
+	}
+	if _, err := db.ExecContext(ctx, "DELETE FROM signinglog WHERE serial_number=$1", "A3"); err != nil {
+		t.Fatalf("deleting the signing log: %v", err)
+	}
+
+	v := &auditChainVerifier{result: AuditChainVerification{Failures: []AuditChainFailure{}}, seq: 1}
+	if err := v.verify(db, entries); err != nil {
+		t.Fatalf("verify() unexpected error: %v", err)
+	}
+	if v.result.Purged != 1 || len(v.result.Failures) != 1 {
+		t.Fatalf("verify() = %v, want a purged record and a failure", v.result)
+	}
+	if f := v.result.Failures[0]; f.Reason != AuditRecordMissing || f.RecordID != records[2].id {
+		t.Errorf("verify() failure = %v, want the missing record %d", f, records[2].id)
+	}
+}
+
+func TestSQLiteSigningLogAuditChainProof(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, serial := range []string{"A1", "A2", "A3"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	// Link the first two signing logs into the chain
+	records, err := nextSigningLogAuditRecords(db, 0, time.Now().Add(time.Hour), 2)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("nextSigningLogAuditRecords() = %v, %v", records, err)
+	}
+	created := time.Now().UTC().Truncate(time.Microsecond)
+	prev := ""
+	for i, r := range records {
+		hash := auditChainHash(prev, AuditSourceSigningLog, r.id, r.hash, created)
+		if _, err := db.ExecContext(ctx, createAuditChainSQL, i+1, AuditSourceSigningLog, r.id, r.hash, prev, hash, created); err != nil {
+			t.Fatalf("creating the audit chain: %v", err)
+		}
+		prev = hash
+	}
+
+	// The signing log that is not linked yet has no entry
+	proof, err := db.SigningLogAuditChainProof(ctx, []int{records[1].id, 3})
+	if err != nil || len(proof.Entries) != 1 || proof.Last == nil {
+		t.Fatalf("SigningLogAuditChainProof() = %v, %v", proof, err)
+	}
+	e := proof.Entries[0]
+	sum := sha256.Sum256(e.Record)
+	if e.RecordID != records[1].id || hex.EncodeToString(sum[:]) != e.RecordHash {
+		t.Errorf("SigningLogAuditChainProof() entry = %v, want the record that is hashed", e)
+	}
+	if e.Hash != auditChainHash(e.PrevHash, e.Source, e.RecordID, e.RecordHash, e.Created) {
+		t.Errorf("SigningLogAuditChainProof() entry = %v, want the hash of the entry", e)
+	}
+	if proof.Last.Seq != 2 || proof.Last.Hash != prev {
+		t.Errorf("SigningLogAuditChainProof() last = %v, want the last entry of the chain", proof.Last)
+	}
+
+	if proof, err = db.SigningLogAuditChainProof(ctx, nil); err != nil || len(proof.Entries) != 0 {
+		t.Errorf("SigningLogAuditChainProof() = %v, %v, want no entries", proof, err)
+	}
+}
@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// ListAllowedAuditLog returns a page of the filtered audit log that the user is authorized to see,
+// with the token of the next page (empty on the last page). The admins see the operations on
+// the objects of their accounts
+func (db *DB) ListAllowedAuditLog(ctx context.Context, authorization User, filter AuditLogFilter, page SigningLogPage) ([]AuditLog, string, error) {
+	fromID, limit, err := page.keyset()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var logs []AuditLog
+
+	// Fetch an extra record to check if there is a next page
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		logs, err = db.listAllAuditLog(ctx, fromID, limit+1, filter)
+	case Admin:
+		logs, err = db.listAuditLogFilteredByUser(ctx, authorization.Username, fromID, limit+1, filter)
+	default:
+		return nil, "", errors.New("You do not have permissions to see the audit log")
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	logs, next := nextAuditLogPage(logs, limit)
+	return logs, next, nil
+}
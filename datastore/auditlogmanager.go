@@ -0,0 +1,221 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// The audit log of the admin operations that change the vault, with the values of the
+// object before and after the change as JSON
+const createAuditLogTableSQL = `
+	CREATE TABLE IF NOT EXISTS auditlog (
+		id               serial primary key not null,
+		username         varchar(200) not null default '',
+		action           varchar(50) not null,
+		object           varchar(50) not null,
+		object_id        int not null default 0,
+		authority_id     varchar(200) not null default '',
+		before           text not null default '',
+		after            text not null default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createAuditLogCreatedIndexSQL = "CREATE INDEX IF NOT EXISTS auditlog_created_idx ON auditlog (created)"
+
+const createAuditLogSQL = `
+	INSERT INTO auditlog (username, action, object, object_id, authority_id, before, after)
+	VALUES ($1,$2,$3,$4,$5,$6,$7)`
+
+// AuditLog is the record of an admin operation
+type AuditLog struct {
+	ID          int       `json:"id"`
+	Username    string    `json:"username"`
+	Action      string    `json:"action"`
+	Object      string    `json:"object"`
+	ObjectID    int       `json:"objectID"`
+	AuthorityID string    `json:"authorityID"`
+	Before      string    `json:"before"`
+	After       string    `json:"after"`
+	Created     time.Time `json:"created"`
+}
+
+// AuditLogFilter holds the optional filters of the audit log list
+type AuditLogFilter struct {
+	From        time.Time // on or after
+	To          time.Time // before
+	Username    string
+	Action      string
+	Object      string
+	AuthorityID string
+}
+
+// CreateAuditLogTable creates the database table for the audit log
+func (db *DB) CreateAuditLogTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createAuditLogTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createAuditLogCreatedIndexSQL)
+	return err
+}
+
+// CreateAuditLog records an admin operation in the audit log
+func (db *DB) CreateAuditLog(ctx context.Context, entry AuditLog) error {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		if err := lockAuditRecord(ctx, tx); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, createAuditLogSQL, entry.Username, entry.Action, entry.Object, entry.ObjectID, entry.AuthorityID, entry.Before, entry.After)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("error recording the audit log: %v", err)
+	}
+	return nil
+}
+
+// nextAuditLogPage trims the extra record of the page, and returns the token of the next page
+func nextAuditLogPage(logs []AuditLog, limit int) ([]AuditLog, string) {
+	if len(logs) <= limit {
+		return logs, ""
+	}
+	logs = logs[:limit]
+	return logs, encodePageToken(logs[limit-1].ID)
+}
+
+func (db *DB) listAllAuditLog(ctx context.Context, fromID, limit int, filter AuditLogFilter) ([]AuditLog, error) {
+	return db.queryAuditLog(ctx, listAuditLogSQLBuilder(fromID, limit, filter))
+}
+
+func (db *DB) listAuditLogFilteredByUser(ctx context.Context, username string, fromID, limit int, filter AuditLogFilter) ([]AuditLog, error) {
+	listSQL := listAuditLogSQLBuilder(fromID, limit, filter).Where(sq.Expr(`EXISTS (
+			SELECT * FROM account acc
+			INNER JOIN useraccountlink ua on ua.account_id=acc.id
+			INNER JOIN userinfo u on ua.user_id=u.id
+			WHERE acc.authority_id=l.authority_id AND u.username=?`+accountRoleAdminSQL+`)`, username))
+
+	return db.queryAuditLog(ctx, listSQL)
+}
+
+// listAuditLogSQLBuilder creates the query for a page of the audit log, with the optional filters
+func listAuditLogSQLBuilder(fromID, limit int, filter AuditLogFilter) sq.SelectBuilder {
+	sql := selectAuditLogSQLBuilder().
+		Where(sq.Lt{"l.id": fromID}).
+		OrderBy("l.id DESC").
+		Limit(uint64(limit))
+
+	if !filter.From.IsZero() {
+		sql = sql.Where(sq.GtOrEq{"l.created": filter.From})
+	}
+	if !filter.To.IsZero() {
+		sql = sql.Where(sq.Lt{"l.created": filter.To})
+	}
+	if filter.Username != "" {
+		sql = sql.Where(sq.Eq{"l.username": filter.Username})
+	}
+	if filter.Action != "" {
+		sql = sql.Where(sq.Eq{"l.action": filter.Action})
+	}
+	if filter.Object != "" {
+		sql = sql.Where(sq.Eq{"l.object": filter.Object})
+	}
+	if filter.AuthorityID != "" {
+		sql = sql.Where(sq.Eq{"l.authority_id": filter.AuthorityID})
+	}
+	return sql
+}
+
+func selectAuditLogSQLBuilder() sq.SelectBuilder {
+	return sq.
+		Select("l.id, l.username, l.action, l.object, l.object_id, l.authority_id, l.before, l.after, l.created").
+		From("auditlog l").
+		PlaceholderFormat(sq.Dollar)
+}
+
+func (db *DB) queryAuditLog(ctx context.Context, listSQL sq.SelectBuilder) ([]AuditLog, error) {
+	rows, err := listSQL.RunWith(db).QueryContext(ctx)
+	if err != nil {
+		log.Errorf("Error retrieving the audit log: %v", err)
+		return nil, fmt.Errorf("error retrieving the audit log: %v", err)
+	}
+	defer rows.Close()
+
+	logs := []AuditLog{}
+	for rows.Next() {
+		l := AuditLog{}
+		err := rows.Scan(&l.ID, &l.Username, &l.Action, &l.Object, &l.ObjectID, &l.AuthorityID, &l.Before, &l.After, &l.Created)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving the audit log: %v", err)
+		}
+		logs = append(logs, l)
+	}
+	return logs, rows.Err()
+}
+
+// auditLogHash is the hash of the audit log for the audit chain
+func auditLogHash(l AuditLog) string {
+	l.Created = l.Created.UTC()
+	data, _ := json.Marshal(l)
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func nextAuditLogAuditRecords(db *DB, afterID int, before time.Time, limit int) ([]auditRecord, error) {
+	listSQL := selectAuditLogSQLBuilder().
+		Where(sq.Gt{"l.id": afterID}).
+		Where(sq.Lt{"l.created": before}).
+		OrderBy("l.id").
+		Limit(uint64(limit))
+
+	logs, err := db.queryAuditLog(context.Background(), listSQL)
+	if err != nil {
+		return nil, err
+	}
+
+	records := make([]auditRecord, 0, len(logs))
+	for _, l := range logs {
+		records = append(records, auditRecord{id: l.ID, hash: auditLogHash(l)})
+	}
+	return records, nil
+}
+
+func getAuditLogAuditRecords(db *DB, ids []int) (map[int]string, error) {
+	logs, err := db.queryAuditLog(context.Background(), selectAuditLogSQLBuilder().Where(sq.Eq{"l.id": ids}))
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := map[int]string{}
+	for _, l := range logs {
+		hashes[l.ID] = auditLogHash(l)
+	}
+	return hashes, nil
+}
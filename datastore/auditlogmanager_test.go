@@ -0,0 +1,76 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestListAuditLogSQLBuilder(t *testing.T) {
+	from := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	filter := AuditLogFilter{From: from, To: from.AddDate(0, 0, 1), Username: "sv", Object: "model", AuthorityID: "System"}
+
+	sql, params, err := listAuditLogSQLBuilder(100, 20, filter).ToSql()
+	if err != nil {
+		t.Fatalf("listAuditLogSQLBuilder() error = %v", err)
+	}
+
+	want := `^SELECT l.id, .* FROM auditlog l WHERE l.id < \$1 AND l.created >= \$2 AND l.created < \$3 AND l.username = \$4 ` +
+		`AND l.object = \$5 AND l.authority_id = \$6 ORDER BY l.id DESC LIMIT 20$`
+	if !regexp.MustCompile(want).MatchString(sql) {
+		t.Errorf("listAuditLogSQLBuilder() sql = %v", sql)
+	}
+	if len(params) != 6 || params[0] != 100 || params[3] != "sv" || params[5] != "System" {
+		t.Errorf("listAuditLogSQLBuilder() params = %v", params)
+	}
+}
+
+func TestNextAuditLogPage(t *testing.T) {
+	logs := []AuditLog{{ID: 5}, {ID: 4}, {ID: 3}}
+
+	page, next := nextAuditLogPage(logs, 2)
+	if len(page) != 2 || next != encodePageToken(4) {
+		t.Errorf("nextAuditLogPage() = %v, %v", page, next)
+	}
+
+	page, next = nextAuditLogPage(logs, 3)
+	if len(page) != 3 || next != "" {
+		t.Errorf("nextAuditLogPage() = %v, %v", page, next)
+	}
+}
+
+func TestAuditLogHash(t *testing.T) {
+	l := AuditLog{ID: 1, Username: "sv", Action: "update", Object: "model", ObjectID: 2, Before: "{}", After: `{"name":"alder"}`,
+		Created: time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)}
+
+	hash := auditLogHash(l)
+	if len(hash) != 64 {
+		t.Errorf("auditLogHash() = %v", hash)
+	}
+
+	modified := l
+	modified.After = `{"name":"ash"}`
+	if auditLogHash(modified) == hash {
+		t.Error("auditLogHash() must change when the record is modified")
+	}
+}
@@ -0,0 +1,102 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// defaultLockoutThreshold is the number of failed authentications before the lockout, when it is not configured
+const defaultLockoutThreshold = 5
+
+// defaultLockoutDuration is the first lockout, when it is not configured
+const defaultLockoutDuration = 30 * time.Second
+
+// maxLockoutDuration is the longest lockout, however many authentications fail
+const maxLockoutDuration = time.Hour
+
+// lockoutWindow is the time after which the failed authentications are forgotten
+const lockoutWindow = 24 * time.Hour
+
+// LockoutPolicy defines when a username or source IP is locked out after failed authentications
+type LockoutPolicy struct {
+	Threshold int
+	Duration  time.Duration
+	Window    time.Duration
+}
+
+// NewLockoutPolicy returns the lockout policy from the config settings
+func NewLockoutPolicy(settings config.Settings) (LockoutPolicy, error) {
+	policy := LockoutPolicy{Threshold: defaultLockoutThreshold, Duration: defaultLockoutDuration, Window: lockoutWindow}
+
+	if settings.LockoutThreshold < 0 {
+		return policy, fmt.Errorf("the lockout threshold must not be negative")
+	}
+	if settings.LockoutThreshold > 0 {
+		policy.Threshold = settings.LockoutThreshold
+	}
+
+	if len(settings.LockoutDuration) > 0 {
+		d, err := time.ParseDuration(settings.LockoutDuration)
+		if err != nil {
+			return policy, fmt.Errorf("invalid lockout duration: %v", err)
+		}
+		if d < time.Second || d > maxLockoutDuration {
+			return policy, fmt.Errorf("the lockout duration must be between 1s and %v", maxLockoutDuration)
+		}
+		policy.Duration = d
+	}
+	return policy, nil
+}
+
+// TrustedProxies returns the networks of the reverse proxies in front of the service from the
+// config settings, whose X-Forwarded-For header gives the address of the client
+func TrustedProxies(settings config.Settings) ([]*net.IPNet, error) {
+	proxies := []*net.IPNet{}
+	for _, c := range settings.TrustedProxies {
+		network, err := parseAllowedCIDR(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %v", err)
+		}
+		proxies = append(proxies, network)
+	}
+	return proxies, nil
+}
+
+// Lockout returns how long to lock out after the failed authentications: nothing before the
+// threshold, then the duration, doubled for each further failure, up to the longest lockout
+func (p LockoutPolicy) Lockout(failures int) time.Duration {
+	if failures < p.Threshold {
+		return 0
+	}
+
+	lockout := p.Duration
+	for i := p.Threshold; i < failures && lockout < maxLockoutDuration; i++ {
+		lockout *= 2
+	}
+	if lockout > maxLockoutDuration {
+		return maxLockoutDuration
+	}
+	return lockout
+}
@@ -0,0 +1,81 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestLockoutPolicy(t *testing.T) {
+	policy, err := NewLockoutPolicy(config.Settings{})
+	if err != nil {
+		t.Fatalf("Error in the default lockout policy: %v", err)
+	}
+
+	tests := []struct {
+		failures int
+		lockout  time.Duration
+	}{
+		{1, 0},
+		{4, 0},
+		{5, 30 * time.Second},
+		{6, time.Minute},
+		{8, 4 * time.Minute},
+		{12, time.Hour},
+		{100, time.Hour},
+	}
+	for _, tt := range tests {
+		if lockout := policy.Lockout(tt.failures); lockout != tt.lockout {
+			t.Errorf("Expected a lockout of %v after %d failures, got %v", tt.lockout, tt.failures, lockout)
+		}
+	}
+
+	policy, err = NewLockoutPolicy(config.Settings{LockoutThreshold: 3, LockoutDuration: "1m"})
+	if err != nil {
+		t.Fatalf("Error in the lockout policy: %v", err)
+	}
+	if lockout := policy.Lockout(4); lockout != 2*time.Minute {
+		t.Errorf("Expected a lockout of 2m, got %v", lockout)
+	}
+
+	for _, settings := range []config.Settings{{LockoutThreshold: -1}, {LockoutDuration: "invalid"}, {LockoutDuration: "2h"}} {
+		if _, err := NewLockoutPolicy(settings); err == nil {
+			t.Errorf("Expected an error for the lockout settings %v", settings)
+		}
+	}
+}
+
+func TestTrustedProxies(t *testing.T) {
+	proxies, err := TrustedProxies(config.Settings{TrustedProxies: []string{"10.0.0.0/24", "192.0.2.1"}})
+	if err != nil || len(proxies) != 2 {
+		t.Fatalf("Expected two trusted proxies, got %v: %v", proxies, err)
+	}
+	if !proxies[1].Contains(net.ParseIP("192.0.2.1")) || proxies[1].Contains(net.ParseIP("192.0.2.2")) {
+		t.Errorf("Expected the single address of the proxy, got %v", proxies[1])
+	}
+
+	if _, err := TrustedProxies(config.Settings{TrustedProxies: []string{"invalid"}}); err == nil {
+		t.Error("Expected an error for the invalid trusted proxy")
+	}
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+// The counters of the failed authentications, by username or by source IP, with the time
+// until which the username or the source IP is locked out. The times are stored in UTC
+const createAuthFailureTableSQL = `
+	CREATE TABLE IF NOT EXISTS authfailure (
+		auth_key         varchar(300) primary key not null,
+		failures         int not null default 0,
+		locked_until     timestamp,
+		last_failure     timestamp not null
+	)
+`
+
+const getAuthFailureSQL = "SELECT auth_key, failures, locked_until, last_failure FROM authfailure WHERE auth_key=$1"
+
+// The count restarts when the previous failure is older than the failure window
+const upsertAuthFailureSQL = `
+	INSERT INTO authfailure (auth_key, failures, last_failure) VALUES ($1, 1, $2)
+	ON CONFLICT (auth_key) DO UPDATE SET
+		failures=CASE WHEN authfailure.last_failure<$3 THEN 1 ELSE authfailure.failures+1 END,
+		last_failure=$2
+	RETURNING failures`
+
+const lockAuthFailureSQL = "UPDATE authfailure SET locked_until=$2 WHERE auth_key=$1"
+const resetAuthFailuresSQL = "DELETE FROM authfailure WHERE auth_key=$1"
+const deleteStaleAuthFailuresSQL = "DELETE FROM authfailure WHERE last_failure<$1 AND (locked_until IS NULL OR locked_until<$2)"
+
+// AuthFailure holds the failed authentications of a username or a source IP
+type AuthFailure struct {
+	Key         string     `json:"key"`
+	Failures    int        `json:"failures"`
+	LockedUntil *time.Time `json:"lockedUntil,omitempty"`
+	LastFailure time.Time  `json:"lastFailure"`
+}
+
+// Locked checks whether the username or source IP is locked out
+func (f AuthFailure) Locked() bool {
+	return f.LockedUntil != nil && f.LockedUntil.After(time.Now())
+}
+
+// CreateAuthFailureTable creates the database table for the failed authentications
+func (db *DB) CreateAuthFailureTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAuthFailureTableSQL)
+	return err
+}
+
+// GetAuthFailure returns the failed authentications of a username or source IP, which
+// are empty when there are none
+func (db *DB) GetAuthFailure(ctx context.Context, key string) (AuthFailure, error) {
+	f := AuthFailure{}
+	err := db.QueryRowContext(ctx, getAuthFailureSQL, key).Scan(&f.Key, &f.Failures, &f.LockedUntil, &f.LastFailure)
+	if err == sql.ErrNoRows {
+		return AuthFailure{Key: key}, nil
+	}
+	if err != nil {
+		return f, fmt.Errorf("error retrieving the failed authentications: %v", err)
+	}
+	return f, nil
+}
+
+// RecordAuthFailure counts a failed authentication of a username or source IP, and locks
+// it out when the policy says so
+func (db *DB) RecordAuthFailure(ctx context.Context, key string, policy LockoutPolicy) (AuthFailure, error) {
+	now := time.Now().UTC()
+
+	db.ExecContext(ctx, deleteStaleAuthFailuresSQL, now.Add(-policy.Window), now)
+
+	f := AuthFailure{Key: key, LastFailure: now}
+	if err := db.QueryRowContext(ctx, upsertAuthFailureSQL, key, now, now.Add(-policy.Window)).Scan(&f.Failures); err != nil {
+		return f, fmt.Errorf("error recording the failed authentication: %v", err)
+	}
+
+	lockout := policy.Lockout(f.Failures)
+	if lockout == 0 {
+		return f, nil
+	}
+
+	lockedUntil := now.Add(lockout)
+	if _, err := db.ExecContext(ctx, lockAuthFailureSQL, key, lockedUntil); err != nil {
+		return f, fmt.Errorf("error recording the failed authentication: %v", err)
+	}
+	f.LockedUntil = &lockedUntil
+	return f, nil
+}
+
+// ResetAuthFailures clears the failed authentications of a username or source IP
+func (db *DB) ResetAuthFailures(ctx context.Context, key string) error {
+	if _, err := db.ExecContext(ctx, resetAuthFailuresSQL, key); err != nil {
+		return fmt.Errorf("error clearing the failed authentications: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+)
+
+// backgroundJobs tracks the jobs that run after the response, such as the keypair generation
+var backgroundJobs sync.WaitGroup
+
+// RunBackgroundJob runs the job in the background, tracking it so the shutdown waits for it. The
+// job runs in a span of the trace of the request, with the logger of the request, but its context
+// is not cancelled when the request is complete
+func RunBackgroundJob(ctx context.Context, name string, job func(ctx context.Context)) {
+	jobCtx := log.NewContext(tracing.Detach(ctx), log.FromContext(ctx))
+
+	backgroundJobs.Add(1)
+	go func() {
+		defer backgroundJobs.Done()
+		jobCtx, span := tracing.Start(jobCtx, name)
+		defer span.End()
+		job(jobCtx)
+	}()
+}
+
+// WaitForBackgroundJobs waits until the background jobs are complete or the context is done
+func WaitForBackgroundJobs(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		backgroundJobs.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,51 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWaitForBackgroundJobs(t *testing.T) {
+	release := make(chan struct{})
+	finished := false
+	RunBackgroundJob(context.Background(), "test", func(ctx context.Context) {
+		<-release
+		finished = true
+	})
+
+	// The job is still running when the context times out
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := WaitForBackgroundJobs(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got: %v", err)
+	}
+
+	close(release)
+	if err := WaitForBackgroundJobs(context.Background()); err != nil {
+		t.Fatalf("Expected the jobs to complete, got: %v", err)
+	}
+	if !finished {
+		t.Error("Expected the job to be finished")
+	}
+}
@@ -0,0 +1,175 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Namespaces of the cached lookups, which are invalidated together
+const (
+	cacheModels  = "model"
+	cacheAPIKeys = "apikey"
+)
+
+// Cache holds the results of the hot lookups of the signing path, so a signing request does not
+// query the database for the model and the API key. The entries expire after the TTL of the cache
+type Cache interface {
+	Get(namespace, key string) ([]byte, bool)
+	Set(namespace, key string, value []byte)
+	Invalidate(namespace string)
+}
+
+// CacheTTL returns the time that the lookups are cached for from the config settings, zero
+// when the cache is disabled
+func CacheTTL(settings config.Settings) (time.Duration, error) {
+	if len(settings.CacheTTL) == 0 {
+		return 0, nil
+	}
+
+	ttl, err := time.ParseDuration(settings.CacheTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid cache TTL: %v", err)
+	}
+	if ttl < 0 {
+		return 0, fmt.Errorf("the cache TTL must not be negative")
+	}
+	return ttl, nil
+}
+
+// NewCache creates the cache of the config settings: in Redis when its address is set, so the
+// invalidations are shared by the services, otherwise in the memory of the service
+func NewCache(settings config.Settings) (Cache, error) {
+	ttl, err := CacheTTL(settings)
+	if err != nil || ttl == 0 {
+		return nil, err
+	}
+
+	if len(settings.CacheRedisAddress) > 0 {
+		return newRedisCache(settings.CacheRedisAddress, settings.CacheRedisPassword, ttl), nil
+	}
+	return newMemoryCache(ttl), nil
+}
+
+// cacheEntry is a cached value, until it expires
+type cacheEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+// memoryCache is the in-process cache. The invalidations are not seen by the other services,
+// so their entries are stale until they expire
+type memoryCache struct {
+	ttl        time.Duration
+	lock       sync.Mutex
+	namespaces map[string]map[string]cacheEntry
+}
+
+func newMemoryCache(ttl time.Duration) *memoryCache {
+	return &memoryCache{ttl: ttl, namespaces: map[string]map[string]cacheEntry{}}
+}
+
+// Get returns the value of a key, unless it has expired
+func (c *memoryCache) Get(namespace, key string) ([]byte, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	entry, ok := c.namespaces[namespace][key]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.namespaces[namespace], key)
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// Set stores the value of a key until the TTL expires
+func (c *memoryCache) Set(namespace, key string, value []byte) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.namespaces[namespace] == nil {
+		c.namespaces[namespace] = map[string]cacheEntry{}
+	}
+	c.namespaces[namespace][key] = cacheEntry{value: value, expires: time.Now().Add(c.ttl)}
+}
+
+// Invalidate removes all the keys of a namespace
+func (c *memoryCache) Invalidate(namespace string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	delete(c.namespaces, namespace)
+}
+
+// cacheKey returns the key of a lookup, which is a hash so the API keys are not part of it
+func cacheKey(parts ...string) string {
+	sum := sha256.Sum256([]byte(strings.Join(parts, "\x00")))
+	return hex.EncodeToString(sum[:])
+}
+
+// cacheGet decodes the cached result of a lookup, when there is one
+func (db *DB) cacheGet(namespace, key string, value interface{}) bool {
+	if db.cache == nil {
+		return false
+	}
+
+	data, ok := db.cache.Get(namespace, key)
+	if !ok {
+		return false
+	}
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(value) == nil
+}
+
+// cacheSet stores the result of a lookup. The results are encoded with gob, as the JSON of
+// the records leaves out the sealed keys
+func (db *DB) cacheSet(namespace, key string, value interface{}) {
+	if db.cache == nil {
+		return
+	}
+
+	var data bytes.Buffer
+	if err := gob.NewEncoder(&data).Encode(value); err != nil {
+		return
+	}
+	db.cache.Set(namespace, key, data.Bytes())
+}
+
+// invalidateCache removes the cached lookups of the namespaces, after a change of their records
+func (db *DB) invalidateCache(namespaces ...string) {
+	if db.cache == nil {
+		return
+	}
+	for _, n := range namespaces {
+		db.cache.Invalidate(n)
+	}
+}
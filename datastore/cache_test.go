@@ -0,0 +1,215 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestNewCache(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Settings
+		want     string
+		wantErr  bool
+	}{
+		{"disabled", config.Settings{}, "", false},
+		{"memory", config.Settings{CacheTTL: "30s"}, "memory", false},
+		{"redis", config.Settings{CacheTTL: "30s", CacheRedisAddress: "localhost:6379"}, "redis", false},
+		{"invalid", config.Settings{CacheTTL: "soon"}, "", true},
+		{"negative", config.Settings{CacheTTL: "-1s"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache, err := NewCache(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewCache() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			got := ""
+			switch cache.(type) {
+			case *memoryCache:
+				got = "memory"
+			case *redisCache:
+				got = "redis"
+			}
+			if got != tt.want {
+				t.Errorf("NewCache() = %T, want %s", cache, tt.want)
+			}
+		})
+	}
+}
+
+func TestMemoryCache(t *testing.T) {
+	testCache(t, newMemoryCache(50*time.Millisecond))
+}
+
+func TestRedisCache(t *testing.T) {
+	address := startFakeRedis(t, "secret")
+	testCache(t, newRedisCache(address, "secret", 50*time.Millisecond))
+}
+
+func testCache(t *testing.T, cache Cache) {
+	cache.Set(cacheModels, "a", []byte("model a"))
+	cache.Set(cacheAPIKeys, "a", []byte("key a"))
+	if v, ok := cache.Get(cacheModels, "a"); !ok || string(v) != "model a" {
+		t.Errorf("Get() = %q, %v", v, ok)
+	}
+
+	// The invalidation only removes the keys of the namespace
+	cache.Invalidate(cacheModels)
+	if _, ok := cache.Get(cacheModels, "a"); ok {
+		t.Error("Get() expected the key to be invalidated")
+	}
+	if _, ok := cache.Get(cacheAPIKeys, "a"); !ok {
+		t.Error("Get() expected the key of the other namespace")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, ok := cache.Get(cacheAPIKeys, "a"); ok {
+		t.Error("Get() expected the key to expire")
+	}
+}
+
+func TestFindModelCache(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+	db.cache = newMemoryCache(time.Minute)
+
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(context.Background(), Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(context.Background(), Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypairs, _ := db.ListAllowedKeypairs(context.Background(), root)
+	model, _, err := db.CreateAllowedModel(context.Background(), Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypairs[0].ID, KeypairIDUser: keypairs[0].ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	found, err := db.FindModel(context.Background(), "alder", "alder-basic", "model-api-key")
+	if err != nil || found.SealedKey != "sealed" {
+		t.Fatalf("FindModel() = %v, %v", found, err)
+	}
+
+	// The cached model is found without the database
+	if _, err := db.ExecContext(context.Background(), "UPDATE model SET serial_format='[0-9]+'"); err != nil {
+		t.Fatalf("updating the model: %v", err)
+	}
+	found, err = db.FindModel(context.Background(), "alder", "alder-basic", "model-api-key")
+	if err != nil || found.SerialFormat != "" || found.SealedKey != "sealed" {
+		t.Fatalf("FindModel() expected the cached model, got %v, %v", found, err)
+	}
+
+	// The update of the model invalidates the cache
+	model.SerialFormat = "[A-Z]+"
+	if _, err := db.UpdateAllowedModel(context.Background(), model, root); err != nil {
+		t.Fatalf("UpdateAllowedModel() error = %v", err)
+	}
+	found, err = db.FindModel(context.Background(), "alder", "alder-basic", "model-api-key")
+	if err != nil || found.SerialFormat != "[A-Z]+" {
+		t.Fatalf("FindModel() expected the updated model, got %v, %v", found, err)
+	}
+}
+
+// startFakeRedis starts a server of the Redis hash commands of the cache
+func startFakeRedis(t *testing.T, password string) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var lock sync.Mutex
+	hashes := map[string]map[string]string{}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				authenticated := false
+				for {
+					request, err := readRedisReply(r)
+					if err != nil {
+						return
+					}
+					args := []string{}
+					for _, a := range request.([]interface{}) {
+						args = append(args, string(a.([]byte)))
+					}
+
+					lock.Lock()
+					reply := "+OK\r\n"
+					switch {
+					case args[0] == "AUTH":
+						authenticated = args[1] == password
+						if !authenticated {
+							reply = "-WRONGPASS invalid password\r\n"
+						}
+					case !authenticated:
+						reply = "-NOAUTH Authentication required\r\n"
+					case args[0] == "HGET":
+						value, ok := hashes[args[1]][args[2]]
+						reply = "$-1\r\n"
+						if ok {
+							reply = "$" + strconv.Itoa(len(value)) + "\r\n" + value + "\r\n"
+						}
+					case args[0] == "HSET":
+						if hashes[args[1]] == nil {
+							hashes[args[1]] = map[string]string{}
+						}
+						hashes[args[1]][args[2]] = args[3]
+						reply = ":1\r\n"
+					case args[0] == "DEL":
+						delete(hashes, args[1])
+						reply = ":1\r\n"
+					case args[0] == "PEXPIRE":
+						reply = ":1\r\n"
+					}
+					lock.Unlock()
+
+					if _, err := conn.Write([]byte(reply)); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	return listener.Addr().String()
+}
@@ -0,0 +1,200 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// redisTimeout is the timeout of a Redis command, after which the lookup uses the database
+const redisTimeout = 500 * time.Millisecond
+
+// redisPrefix is the prefix of the Redis keys of the cache
+const redisPrefix = "serial-vault:cache:"
+
+// redisCache is the cache in a Redis server, shared by the services. A namespace is a Redis hash,
+// so it is invalidated by deleting the hash, and the entries hold their own expiry. The cache
+// uses a single connection, and the failed commands are treated as cache misses
+type redisCache struct {
+	address  string
+	password string
+	ttl      time.Duration
+
+	lock   sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+func newRedisCache(address, password string, ttl time.Duration) *redisCache {
+	return &redisCache{address: address, password: password, ttl: ttl}
+}
+
+// Get returns the value of a key, unless it has expired
+func (c *redisCache) Get(namespace, key string) ([]byte, bool) {
+	reply, err := c.command("HGET", redisPrefix+namespace, key)
+	if err != nil {
+		log.Warningf("Error reading the cache: %v", err)
+		return nil, false
+	}
+
+	data, ok := reply.([]byte)
+	if !ok || len(data) < 8 {
+		return nil, false
+	}
+	if time.Now().UnixNano() > int64(binary.BigEndian.Uint64(data[:8])) {
+		return nil, false
+	}
+	return data[8:], true
+}
+
+// Set stores the value of a key until the TTL expires. The hash of the namespace expires
+// when none of its keys are set within the TTL
+func (c *redisCache) Set(namespace, key string, value []byte) {
+	data := make([]byte, 8, 8+len(value))
+	binary.BigEndian.PutUint64(data, uint64(time.Now().Add(c.ttl).UnixNano()))
+	data = append(data, value...)
+
+	if _, err := c.command("HSET", redisPrefix+namespace, key, string(data)); err != nil {
+		log.Warningf("Error writing the cache: %v", err)
+		return
+	}
+	if _, err := c.command("PEXPIRE", redisPrefix+namespace, strconv.FormatInt(c.ttl.Milliseconds(), 10)); err != nil {
+		log.Warningf("Error writing the cache: %v", err)
+	}
+}
+
+// Invalidate removes all the keys of a namespace
+func (c *redisCache) Invalidate(namespace string) {
+	if _, err := c.command("DEL", redisPrefix+namespace); err != nil {
+		log.Errorf("Error invalidating the cache: %v", err)
+	}
+}
+
+// command sends a command to Redis and returns the reply, connecting when there is no connection.
+// The connection is closed after an error, so the next command reconnects
+func (c *redisCache) command(args ...string) (interface{}, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if c.conn == nil {
+		if err := c.connect(); err != nil {
+			return nil, err
+		}
+	}
+
+	reply, err := c.send(args...)
+	if err != nil {
+		c.conn.Close()
+		c.conn = nil
+	}
+	return reply, err
+}
+
+// connect opens the connection to Redis, and authenticates when there is a password
+func (c *redisCache) connect() error {
+	conn, err := net.DialTimeout("tcp", c.address, redisTimeout)
+	if err != nil {
+		return err
+	}
+	c.conn = conn
+	c.reader = bufio.NewReader(conn)
+
+	if len(c.password) > 0 {
+		if _, err = c.send("AUTH", c.password); err != nil {
+			conn.Close()
+			c.conn = nil
+			return err
+		}
+	}
+	return nil
+}
+
+// send writes the command as an array of bulk strings, and reads the reply
+func (c *redisCache) send(args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(redisTimeout))
+
+	buf := []byte("*" + strconv.Itoa(len(args)) + "\r\n")
+	for _, a := range args {
+		buf = append(buf, "$"+strconv.Itoa(len(a))+"\r\n"...)
+		buf = append(buf, a...)
+		buf = append(buf, "\r\n"...)
+	}
+	if _, err := c.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	return readRedisReply(c.reader)
+}
+
+// readRedisReply reads a reply of the Redis protocol: the bulk strings are returned as bytes,
+// and a missing value as nil
+func readRedisReply(r *bufio.Reader) (interface{}, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	if len(line) < 3 || line[len(line)-2] != '\r' {
+		return nil, errors.New("invalid Redis reply")
+	}
+	value := line[1 : len(line)-2]
+
+	switch line[0] {
+	case '+':
+		return value, nil
+	case '-':
+		return nil, fmt.Errorf("redis: %s", value)
+	case ':':
+		return strconv.ParseInt(value, 10, 64)
+	case '$':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		data := make([]byte, n+2)
+		if _, err = io.ReadFull(r, data); err != nil {
+			return nil, err
+		}
+		return data[:n], nil
+	case '*':
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return nil, err
+		}
+		items := make([]interface{}, n)
+		for i := range items {
+			if items[i], err = readRedisReply(r); err != nil {
+				return nil, err
+			}
+		}
+		return items, nil
+	default:
+		return nil, errors.New("invalid Redis reply")
+	}
+}
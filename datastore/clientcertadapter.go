@@ -0,0 +1,93 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"strings"
+)
+
+// ListAllowedClientCerts returns the client certificates of the account, if the user is authorized to see them
+func (db *DB) ListAllowedClientCerts(ctx context.Context, accountID int, authorization User) ([]ClientCert, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return nil, err
+	}
+	return db.listClientCerts(ctx, accountID)
+}
+
+// GetAllowedClientCert returns a client certificate of the account, if the user is authorized to see it
+func (db *DB) GetAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) (ClientCert, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return ClientCert{}, err
+	}
+	return db.getClientCert(ctx, certID, accountID)
+}
+
+// CreateAllowedClientCert validates and maps a client certificate to the account, if the user is
+// authorized to do it
+func (db *DB) CreateAllowedClientCert(ctx context.Context, c ClientCert, authorization User) (ClientCert, error) {
+	if err := db.checkAPIKeyAccount(ctx, c.AccountID, authorization); err != nil {
+		return c, err
+	}
+
+	c.Fingerprint = normalizeFingerprint(c.Fingerprint)
+	c.SAN = strings.TrimSpace(c.SAN)
+	if err := validateClientCert(c); err != nil {
+		return c, err
+	}
+
+	var err error
+	c.ID, err = db.createClientCert(ctx, c)
+	return c, err
+}
+
+// DeleteAllowedClientCert removes a client certificate of the account, if the user is authorized to do it
+func (db *DB) DeleteAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) error {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return err
+	}
+	return db.deleteClientCert(ctx, certID, accountID)
+}
+
+// normalizeFingerprint accepts the fingerprint in the formats of the common tools, e.g. the
+// colon-separated uppercase output of openssl
+func normalizeFingerprint(fingerprint string) string {
+	return strings.ToLower(strings.Replace(strings.TrimSpace(fingerprint), ":", "", -1))
+}
+
+func validateClientCert(c ClientCert) error {
+	if err := validateNotEmpty("Name", c.Name); err != nil {
+		return err
+	}
+
+	hasSAN, hasFingerprint := len(c.SAN) > 0, len(c.Fingerprint) > 0
+	if hasSAN == hasFingerprint {
+		return errors.New("The client certificate must have either a subject alternative name or a fingerprint")
+	}
+	if hasFingerprint {
+		if b, err := hex.DecodeString(c.Fingerprint); err != nil || len(b) != 32 {
+			return errors.New("The fingerprint must be the SHA-256 hash of the certificate")
+		}
+	}
+	return nil
+}
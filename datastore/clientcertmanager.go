@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// The client certificates of the factories of an account, for the mutual TLS of the signing
+// service. A certificate is mapped by the SHA-256 fingerprint, or by a subject alternative name
+// of a certificate that is issued by the trusted CA
+const createClientCertTableSQL = `
+	CREATE TABLE IF NOT EXISTS clientcert (
+		id               serial primary key not null,
+		account_id       int references account not null,
+		name             varchar(200) not null,
+		san              varchar(255) not null default '',
+		fingerprint      varchar(64) not null default '',
+		created          timestamp default current_timestamp,
+		unique (account_id, name)
+	)
+`
+
+const listClientCertsSQL = `
+	SELECT id, account_id, name, san, fingerprint, created
+	FROM clientcert
+	WHERE account_id=$1
+	ORDER BY name`
+
+const getClientCertSQL = `
+	SELECT id, account_id, name, san, fingerprint, created
+	FROM clientcert
+	WHERE id=$1 AND account_id=$2`
+
+const createClientCertSQL = "INSERT INTO clientcert (account_id, name, san, fingerprint) VALUES ($1,$2,$3,$4) RETURNING id"
+const deleteClientCertSQL = "DELETE FROM clientcert WHERE id=$1 AND account_id=$2"
+
+const findClientCertByFingerprintSQL = `
+	SELECT c.id, c.account_id, c.name, c.san, c.fingerprint, c.created, a.authority_id
+	FROM clientcert c
+	INNER JOIN account a ON a.id=c.account_id
+	WHERE c.fingerprint=$1 AND c.fingerprint<>''`
+
+const findClientCertBySANSQL = `
+	SELECT c.id, c.account_id, c.name, c.san, c.fingerprint, c.created, a.authority_id
+	FROM clientcert c
+	INNER JOIN account a ON a.id=c.account_id
+	WHERE c.san=$1 AND c.san<>''`
+
+// ClientCert maps a client certificate to an account, by its fingerprint or a subject alternative name
+type ClientCert struct {
+	ID          int       `json:"id"`
+	AccountID   int       `json:"accountID"`
+	Name        string    `json:"name"`
+	SAN         string    `json:"san"`
+	Fingerprint string    `json:"fingerprint"`
+	Created     time.Time `json:"created"`
+
+	// The authority ID of the account, when the certificate is found for a client
+	AuthorityID string `json:"authorityID,omitempty"`
+}
+
+// CreateClientCertTable creates the database table for the client certificates
+func (db *DB) CreateClientCertTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createClientCertTableSQL)
+	return err
+}
+
+// FindClientCert returns the mapping of a client certificate to its account, by the fingerprint
+// of the certificate or one of its subject alternative names
+func (db *DB) FindClientCert(ctx context.Context, fingerprint string, sans []string) (ClientCert, error) {
+	c, err := db.scanClientCert(db.QueryRowContext(ctx, findClientCertByFingerprintSQL, fingerprint))
+	if err != sql.ErrNoRows {
+		return c, err
+	}
+
+	for _, san := range sans {
+		c, err = db.scanClientCert(db.QueryRowContext(ctx, findClientCertBySANSQL, san))
+		if err != sql.ErrNoRows {
+			return c, err
+		}
+	}
+	return ClientCert{}, sql.ErrNoRows
+}
+
+func (db *DB) scanClientCert(row *sql.Row) (ClientCert, error) {
+	c := ClientCert{}
+	err := row.Scan(&c.ID, &c.AccountID, &c.Name, &c.SAN, &c.Fingerprint, &c.Created, &c.AuthorityID)
+	return c, err
+}
+
+func (db *DB) listClientCerts(ctx context.Context, accountID int) ([]ClientCert, error) {
+	rows, err := db.QueryContext(ctx, listClientCertsSQL, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the client certificates: %v", err)
+	}
+	defer rows.Close()
+
+	certs := []ClientCert{}
+	for rows.Next() {
+		c := ClientCert{}
+		if err := rows.Scan(&c.ID, &c.AccountID, &c.Name, &c.SAN, &c.Fingerprint, &c.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the client certificates: %v", err)
+		}
+		certs = append(certs, c)
+	}
+
+	return certs, rows.Err()
+}
+
+func (db *DB) getClientCert(ctx context.Context, certID, accountID int) (ClientCert, error) {
+	c := ClientCert{}
+	err := db.QueryRowContext(ctx, getClientCertSQL, certID, accountID).Scan(&c.ID, &c.AccountID, &c.Name, &c.SAN, &c.Fingerprint, &c.Created)
+	if err != nil {
+		return c, fmt.Errorf("error retrieving the client certificate: %v", err)
+	}
+	return c, nil
+}
+
+func (db *DB) createClientCert(ctx context.Context, c ClientCert) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, createClientCertSQL, c.AccountID, c.Name, c.SAN, c.Fingerprint).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the client certificate: %v", err)
+	}
+	return id, nil
+}
+
+func (db *DB) deleteClientCert(ctx context.Context, certID, accountID int) error {
+	_, err := db.ExecContext(ctx, deleteClientCertSQL, certID, accountID)
+	if err != nil {
+		return fmt.Errorf("error deleting the client certificate: %v", err)
+	}
+	return nil
+}
+
+// ClientCertIdentity returns the SHA-256 fingerprint and the subject alternative names of a
+// client certificate, which are mapped to the accounts
+func ClientCertIdentity(cert *x509.Certificate) (string, []string) {
+	hash := sha256.Sum256(cert.Raw)
+
+	sans := append([]string{}, cert.DNSNames...)
+	sans = append(sans, cert.EmailAddresses...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	return hex.EncodeToString(hash[:]), sans
+}
@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"net/url"
+	"testing"
+)
+
+func TestValidateClientCert(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    ClientCert
+		wantErr bool
+	}{
+		{"valid-san", ClientCert{Name: "factory-1", SAN: "factory.example.com"}, false},
+		{"valid-fingerprint", ClientCert{Name: "factory-1", Fingerprint: normalizeFingerprint("5F:1E:7D:3A:9B:2C:4E:6F:8A:0B:1C:2D:3E:4F:5A:6B:7C:8D:9E:0F:1A:2B:3C:4D:5E:6F:7A:8B:9C:0D:1E:2F")}, false},
+		{"no-name", ClientCert{Name: " ", SAN: "factory.example.com"}, true},
+		{"no-identity", ClientCert{Name: "factory-1"}, true},
+		{"both-identities", ClientCert{Name: "factory-1", SAN: "factory.example.com", Fingerprint: "5f1e7d3a9b2c4e6f8a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f"}, true},
+		{"short-fingerprint", ClientCert{Name: "factory-1", Fingerprint: "5f1e7d3a"}, true},
+		{"invalid-fingerprint", ClientCert{Name: "factory-1", Fingerprint: "not-a-sha256-fingerprint"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateClientCert(tt.cert); (err != nil) != tt.wantErr {
+				t.Errorf("validateClientCert() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestClientCertIdentity(t *testing.T) {
+	u, _ := url.Parse("spiffe://example.com/factory-1")
+	cert := &x509.Certificate{
+		Raw:            []byte("certificate"),
+		DNSNames:       []string{"factory.example.com"},
+		EmailAddresses: []string{"factory@example.com"},
+		URIs:           []*url.URL{u},
+	}
+
+	fingerprint, sans := ClientCertIdentity(cert)
+	hash := sha256.Sum256(cert.Raw)
+	if fingerprint != hex.EncodeToString(hash[:]) {
+		t.Errorf("Expected the SHA-256 fingerprint, got: %s", fingerprint)
+	}
+
+	expected := []string{"factory.example.com", "factory@example.com", "spiffe://example.com/factory-1"}
+	if len(sans) != len(expected) {
+		t.Fatalf("Expected %d subject alternative names, got: %v", len(expected), sans)
+	}
+	for i := range expected {
+		if sans[i] != expected[i] {
+			t.Errorf("Expected %s, got: %s", expected[i], sans[i])
+		}
+	}
+}
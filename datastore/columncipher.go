@@ -0,0 +1,301 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// columnCipherPrefix marks the encrypted values of a column, so the values that were stored
+// before the encryption are read unchanged until the database update encrypts them
+const columnCipherPrefix = "enc:v1:"
+
+// ColumnCipher encrypts the sensitive columns at rest with AES-256-GCM, using keys derived
+// from the keystore secret
+type ColumnCipher struct {
+	aead     cipher.AEAD
+	nonceKey []byte
+}
+
+// NewColumnCipher derives the keys of the column encryption from the keystore secret
+func NewColumnCipher(secret string) (*ColumnCipher, error) {
+	block, err := aes.NewCipher(deriveColumnKey(secret, "column-encryption"))
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &ColumnCipher{aead: aead, nonceKey: deriveColumnKey(secret, "column-nonce")}, nil
+}
+
+func deriveColumnKey(secret, purpose string) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(purpose))
+	return mac.Sum(nil)
+}
+
+// Encrypt encrypts a value with a random nonce
+func (c *ColumnCipher) Encrypt(value string) (string, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return c.seal(nonce, value), nil
+}
+
+// EncryptLookup encrypts a value with a nonce derived from the value, so the same value is
+// always encrypted the same way and a column can still be searched by its value
+func (c *ColumnCipher) EncryptLookup(value string) string {
+	mac := hmac.New(sha256.New, c.nonceKey)
+	mac.Write([]byte(value))
+	return c.seal(mac.Sum(nil)[:c.aead.NonceSize()], value)
+}
+
+func (c *ColumnCipher) seal(nonce []byte, value string) string {
+	sealed := c.aead.Seal(nonce, nonce, []byte(value), nil)
+	return columnCipherPrefix + base64.RawStdEncoding.EncodeToString(sealed)
+}
+
+// Decrypt decrypts an encrypted value. A value that is not encrypted is returned unchanged
+func (c *ColumnCipher) Decrypt(value string) (string, error) {
+	if !Encrypted(value) {
+		return value, nil
+	}
+
+	sealed, err := base64.RawStdEncoding.DecodeString(strings.TrimPrefix(value, columnCipherPrefix))
+	if err != nil || len(sealed) < c.aead.NonceSize() {
+		return "", errors.New("invalid encrypted value")
+	}
+
+	size := c.aead.NonceSize()
+	plain, err := c.aead.Open(nil, sealed[:size], sealed[size:], nil)
+	if err != nil {
+		return "", errors.New("the encrypted value cannot be decrypted with the keystore secret")
+	}
+	return string(plain), nil
+}
+
+// Encrypted checks whether a column value is encrypted
+func Encrypted(value string) bool {
+	return strings.HasPrefix(value, columnCipherPrefix)
+}
+
+const listAPIKeyColumnsSQL = "SELECT id, api_key, previous_key FROM apikey"
+const updateAPIKeyColumnsSQL = "UPDATE apikey SET api_key=$2, previous_key=$3 WHERE id=$1"
+const listWebhookSecretsSQL = "SELECT id, secret FROM webhook"
+const updateWebhookSecretSQL = "UPDATE webhook SET secret=$2 WHERE id=$1"
+const listAccountAssertionsSQL = "SELECT id, assertion FROM account"
+const updateAccountAssertionSQL = "UPDATE account SET assertion=$2 WHERE id=$1"
+
+// ColumnCipher returns the cipher of the sensitive columns, which is nil when the keystore
+// secret is not configured and the columns are stored in clear
+func (env *Env) ColumnCipher() (*ColumnCipher, error) {
+	if len(env.Config.KeyStoreSecret) == 0 {
+		return nil, nil
+	}
+	return NewColumnCipher(env.Config.KeyStoreSecret)
+}
+
+// encryptAPIKey returns the API key as it is stored, to find a named API key by its value
+func encryptAPIKey(apiKey string) (string, error) {
+	c, err := Environ.ColumnCipher()
+	if err != nil || c == nil || len(apiKey) == 0 {
+		return apiKey, err
+	}
+	return c.EncryptLookup(apiKey), nil
+}
+
+// encryptAssertion returns the account assertion as it is stored, which is only encrypted
+// when the encryption of the assertions is enabled
+func encryptAssertion(assertion string) (string, error) {
+	c, err := Environ.ColumnCipher()
+	if err != nil || c == nil || !Environ.Config.EncryptAssertions || len(assertion) == 0 {
+		return assertion, err
+	}
+	return c.Encrypt(assertion)
+}
+
+// encryptWebhookSecret returns the secret of a webhook as it is stored
+func encryptWebhookSecret(secret string) (string, error) {
+	c, err := Environ.ColumnCipher()
+	if err != nil || c == nil {
+		return secret, err
+	}
+	return c.Encrypt(secret)
+}
+
+// decryptColumn returns the value of an encrypted column
+func decryptColumn(value string) (string, error) {
+	if !Encrypted(value) {
+		return value, nil
+	}
+	c, err := Environ.ColumnCipher()
+	if err != nil {
+		return "", err
+	}
+	if c == nil {
+		return "", errors.New("the keystore secret is needed to decrypt the encrypted values")
+	}
+	return c.Decrypt(value)
+}
+
+// EncryptColumns encrypts the values of the sensitive columns that were stored before the
+// encryption: the named API keys, the webhook secrets and, when enabled, the account assertions. The encrypted
+// values are left unchanged, so the update can be run again
+func (db *DB) EncryptColumns(ctx context.Context) error {
+	c, err := Environ.ColumnCipher()
+	if err != nil || c == nil {
+		return err
+	}
+
+	if err := db.encryptAPIKeyColumns(ctx, c); err != nil {
+		return fmt.Errorf("error encrypting the API keys: %v", err)
+	}
+	if err := db.encryptWebhookSecrets(ctx, c); err != nil {
+		return fmt.Errorf("error encrypting the webhook secrets: %v", err)
+	}
+	if !Environ.Config.EncryptAssertions {
+		return nil
+	}
+	if err := db.encryptAccountAssertions(ctx, c); err != nil {
+		return fmt.Errorf("error encrypting the account assertions: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) encryptAPIKeyColumns(ctx context.Context, c *ColumnCipher) error {
+	type row struct {
+		id                  int
+		apiKey, previousKey string
+	}
+
+	rows, err := db.QueryContext(ctx, listAPIKeyColumnsSQL)
+	if err != nil {
+		return err
+	}
+	pending := []row{}
+	for rows.Next() {
+		r := row{}
+		if err := rows.Scan(&r.id, &r.apiKey, &r.previousKey); err != nil {
+			rows.Close()
+			return err
+		}
+		if !Encrypted(r.apiKey) || (len(r.previousKey) > 0 && !Encrypted(r.previousKey)) {
+			pending = append(pending, r)
+		}
+	}
+	rows.Close()
+
+	for _, r := range pending {
+		if !Encrypted(r.apiKey) {
+			r.apiKey = c.EncryptLookup(r.apiKey)
+		}
+		if len(r.previousKey) > 0 && !Encrypted(r.previousKey) {
+			r.previousKey = c.EncryptLookup(r.previousKey)
+		}
+		if _, err := db.ExecContext(ctx, updateAPIKeyColumnsSQL, r.id, r.apiKey, r.previousKey); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Encrypted %d API keys", len(pending))
+	return nil
+}
+
+func (db *DB) encryptWebhookSecrets(ctx context.Context, c *ColumnCipher) error {
+	rows, err := db.QueryContext(ctx, listWebhookSecretsSQL)
+	if err != nil {
+		return err
+	}
+	pending := map[int]string{}
+	for rows.Next() {
+		var id int
+		var secret string
+		if err := rows.Scan(&id, &secret); err != nil {
+			rows.Close()
+			return err
+		}
+		if !Encrypted(secret) {
+			pending[id] = secret
+		}
+	}
+	rows.Close()
+
+	for id, secret := range pending {
+		encrypted, err := c.Encrypt(secret)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, updateWebhookSecretSQL, id, encrypted); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Encrypted %d webhook secrets", len(pending))
+	return nil
+}
+
+func (db *DB) encryptAccountAssertions(ctx context.Context, c *ColumnCipher) error {
+	rows, err := db.QueryContext(ctx, listAccountAssertionsSQL)
+	if err != nil {
+		return err
+	}
+	pending := map[int]string{}
+	for rows.Next() {
+		var id int
+		var assertion string
+		if err := rows.Scan(&id, &assertion); err != nil {
+			rows.Close()
+			return err
+		}
+		if len(assertion) > 0 && !Encrypted(assertion) {
+			pending[id] = assertion
+		}
+	}
+	rows.Close()
+
+	for id, assertion := range pending {
+		encrypted, err := c.Encrypt(assertion)
+		if err != nil {
+			return err
+		}
+		if _, err := db.ExecContext(ctx, updateAccountAssertionSQL, id, encrypted); err != nil {
+			return err
+		}
+	}
+
+	log.Infof("Encrypted %d account assertions", len(pending))
+	return nil
+}
@@ -0,0 +1,71 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestColumnCipher(t *testing.T) {
+	c, err := NewColumnCipher("secret")
+	if err != nil {
+		t.Fatalf("NewColumnCipher() error = %v", err)
+	}
+
+	encrypted, err := c.Encrypt("assertion")
+	if err != nil {
+		t.Fatalf("Encrypt() error = %v", err)
+	}
+	if !Encrypted(encrypted) || strings.Contains(encrypted, "assertion") {
+		t.Errorf("Encrypt() = %v, expected an encrypted value", encrypted)
+	}
+	if again, _ := c.Encrypt("assertion"); again == encrypted {
+		t.Error("Encrypt() must use a random nonce")
+	}
+	if got, err := c.Decrypt(encrypted); err != nil || got != "assertion" {
+		t.Errorf("Decrypt() = %v, %v", got, err)
+	}
+
+	lookup := c.EncryptLookup("api-key")
+	if lookup != c.EncryptLookup("api-key") {
+		t.Error("EncryptLookup() must be repeatable to find the key by its value")
+	}
+	if lookup == c.EncryptLookup("other-key") {
+		t.Error("EncryptLookup() must differ between values")
+	}
+	if got, err := c.Decrypt(lookup); err != nil || got != "api-key" {
+		t.Errorf("Decrypt() = %v, %v", got, err)
+	}
+
+	// Values stored before the encryption are read unchanged
+	if got, err := c.Decrypt("api-key"); err != nil || got != "api-key" {
+		t.Errorf("Decrypt() = %v, %v", got, err)
+	}
+
+	other, _ := NewColumnCipher("other")
+	if _, err := other.Decrypt(encrypted); err == nil {
+		t.Error("Decrypt() must fail with another keystore secret")
+	}
+	if _, err := c.Decrypt(columnCipherPrefix + "!!"); err == nil {
+		t.Error("Decrypt() must fail for an invalid value")
+	}
+}
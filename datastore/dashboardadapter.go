@@ -0,0 +1,43 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// AllowedDashboard returns the summary of the accounts that the user is allowed to see
+func (db *DB) AllowedDashboard(ctx context.Context, authorization User, now time.Time) ([]DashboardAccount, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.listAllDashboard(ctx, now)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		return db.listDashboardFilteredByUser(ctx, authorization.Username, now)
+	default:
+		return nil, errors.New("You do not have permissions to see the dashboard")
+	}
+}
@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// DashboardRecentErrors is the number of recent signing errors of each account on the dashboard
+const DashboardRecentErrors = 5
+
+// The counts of each account are from the start of the last day ($1) and week ($2)
+const listDashboardSQL = `
+	SELECT a.id, a.authority_id,
+		(SELECT COUNT(*) FROM keypair k WHERE k.authority_id=a.authority_id AND k.active),
+		(SELECT COUNT(*) FROM model m WHERE m.brand_id=a.authority_id AND m.deleted_at IS NULL),
+		(SELECT COUNT(*) FROM signinglog s WHERE s.make=a.authority_id AND s.created>=$1),
+		(SELECT COUNT(*) FROM signinglog s WHERE s.make=a.authority_id AND s.created>=$2),
+		(SELECT COUNT(*) FROM keypairstatus ks WHERE ks.authority_id=a.authority_id AND ks.keypair_id IS NULL),
+		(SELECT COUNT(*) FROM signingerror e WHERE e.brand_id=a.authority_id AND e.created>=$1)
+	FROM account a`
+
+const listDashboardOrderSQL = `
+	ORDER BY a.authority_id`
+
+const listDashboardForUserSQL = listDashboardSQL + `
+	INNER JOIN useraccountlink ua on ua.account_id=a.id
+	INNER JOIN userinfo u on ua.user_id=u.id
+	WHERE u.username=$3` + accountRoleSyncUserSQL + listDashboardOrderSQL
+
+const listRecentSigningErrorsSQL = `
+	SELECT id, brand_id, model, code, message, created
+	FROM signingerror
+	WHERE brand_id=$1 AND created>=$2
+	ORDER BY id DESC
+	LIMIT $3`
+
+// DashboardAccount is the summary of an account for the home page
+type DashboardAccount struct {
+	AccountID       int            `json:"accountID"`
+	AuthorityID     string         `json:"authorityID"`
+	ActiveKeypairs  int            `json:"activeKeypairs"`
+	Models          int            `json:"models"`
+	SigningsDay     int            `json:"signingsDay"`  // signings in the last 24 hours
+	SigningsWeek    int            `json:"signingsWeek"` // signings in the last 7 days
+	PendingKeypairs int            `json:"pendingKeypairs"`
+	Errors          int            `json:"errors"` // failed signing requests in the last 24 hours
+	RecentErrors    []SigningError `json:"recentErrors"`
+}
+
+func (db *DB) listAllDashboard(ctx context.Context, now time.Time) ([]DashboardAccount, error) {
+	return db.listDashboard(ctx, now, listDashboardSQL+listDashboardOrderSQL)
+}
+
+func (db *DB) listDashboardFilteredByUser(ctx context.Context, username string, now time.Time) ([]DashboardAccount, error) {
+	return db.listDashboard(ctx, now, listDashboardForUserSQL, username)
+}
+
+func (db *DB) listDashboard(ctx context.Context, now time.Time, query string, args ...interface{}) ([]DashboardAccount, error) {
+	day := now.Add(-24 * time.Hour)
+	args = append([]interface{}{day, now.Add(-7 * 24 * time.Hour)}, args...)
+
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Errorf("Error retrieving the dashboard: %v", err)
+		return nil, fmt.Errorf("error retrieving the dashboard: %v", err)
+	}
+	defer rows.Close()
+
+	accounts := []DashboardAccount{}
+	for rows.Next() {
+		a := DashboardAccount{}
+		err := rows.Scan(&a.AccountID, &a.AuthorityID, &a.ActiveKeypairs, &a.Models, &a.SigningsDay, &a.SigningsWeek, &a.PendingKeypairs, &a.Errors)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving the dashboard: %v", err)
+		}
+		accounts = append(accounts, a)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving the dashboard: %v", err)
+	}
+
+	for i := range accounts {
+		accounts[i].RecentErrors = []SigningError{}
+		if accounts[i].Errors == 0 {
+			continue
+		}
+		if accounts[i].RecentErrors, err = db.listRecentSigningErrors(ctx, accounts[i].AuthorityID, day); err != nil {
+			return nil, err
+		}
+	}
+
+	return accounts, nil
+}
+
+func (db *DB) listRecentSigningErrors(ctx context.Context, authorityID string, since time.Time) ([]SigningError, error) {
+	rows, err := db.QueryContext(ctx, listRecentSigningErrorsSQL, authorityID, since, DashboardRecentErrors)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the signing errors: %v", err)
+	}
+	defer rows.Close()
+
+	errs := []SigningError{}
+	for rows.Next() {
+		e := SigningError{}
+		if err := rows.Scan(&e.ID, &e.Brand, &e.Model, &e.Code, &e.Message, &e.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the signing errors: %v", err)
+		}
+		errs = append(errs, e)
+	}
+	return errs, rows.Err()
+}
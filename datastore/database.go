@@ -21,9 +21,12 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/service/log"
 )
 
 const anyUserFilter = ""
@@ -36,128 +39,336 @@ type SigningLogParams struct {
 	Serialnumber string
 }
 
+// SigningLogFilter holds the optional filters for the SigningLog list
+type SigningLogFilter struct {
+	From        time.Time // signed on or after
+	To          time.Time // signed before
+	Model       string
+	Serial      string // substring of the serial number
+	Fingerprint string // device-key fingerprint
+	KeyID       string // signing-key of the model
+	Search      string // substring of the serial number or of the device-key fingerprint
+}
+
+// ModelFilter holds the optional filters and the page of the models list
+type ModelFilter struct {
+	BrandID   string
+	KeypairID int    // signing-key or system-user key of the model
+	Name      string // substring of the model name
+	Offset    int
+	Limit     int // 0 means all the models
+}
+
+// SubstoreFilter holds the optional search and the page of the sub-store models list
+type SubstoreFilter struct {
+	Serial string // substring of the serial number
+	Offset int
+	Limit  int // 0 means all the sub-store models
+}
+
+// SigningLogSearchMinLength is the minimum length of a signing log search, as the trigram
+// indexes of the search are not used for the shorter values
+const SigningLogSearchMinLength = 3
+
+// SigningLogPage holds the keyset pagination parameters for the SigningLog list, and the audit log list
+type SigningLogPage struct {
+	Token string // next-page token of the previous page, empty for the first page
+	Limit int    // 0 means the default page size
+}
+
 // Datastore interface for the database logic
 type Datastore interface {
-	ListAllowedModels(authorization User) ([]Model, error)
-	FindModel(brandID, modelName, apiKey string) (Model, error)
-	GetAllowedModel(modelID int, authorization User) (Model, error)
-	UpdateAllowedModel(model Model, authorization User) (string, error)
-	DeleteAllowedModel(model Model, authorization User) (string, error)
-	CreateAllowedModel(model Model, authorization User) (Model, string, error)
-	CreateModelTable() error
-	AlterModelTable() error
-	CheckAPIKey(apiKey string) bool
-	CheckModelExists(brandID, name string) bool
-
-	CreateModelAssertTable() error
-	AlterModelAssertTable() error
-	CreateModelAssert(m ModelAssertion) (int, error)
-	UpdateModelAssert(m ModelAssertion) error
-	GetModelAssert(modelID int) (ModelAssertion, error)
-	UpsertModelAssert(m ModelAssertion) error
-
-	ListAllowedKeypairs(authorization User) ([]Keypair, error)
-	GetKeypair(keypairID int) (Keypair, error)
-	GetKeypairByPublicID(authorityID, keyID string) (Keypair, error)
-	GetKeypairByName(authorityID, keyName string) (Keypair, error)
-	PutKeypair(keypair Keypair) (string, error)
-	UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error
-	UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error)
-	CreateKeypairTable() error
-	AlterKeypairTable() error
-	CheckKeypairKeynameExists(authorityID, name string) bool
-
-	CreateSettingsTable() error
-	PutSetting(setting Setting) error
-	GetSetting(code string) (Setting, error)
-
-	CreateSigningLogTable() error
-	CheckForDuplicate(signLog *SigningLog) (bool, int, error)
-	CreateSigningLog(signLog SigningLog) error
-	ListAllowedSigningLog(authorization User) ([]SigningLog, error)
-	ListAllowedSigningLogForAccount(authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error)
-	AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error)
-
-	CreateDeviceNonceTable() error
-	DeleteExpiredDeviceNonces() error
-	CreateDeviceNonce() (DeviceNonce, error)
-	ValidateDeviceNonce(nonce string) error
-
-	CreateAccountTable() error
-	AlterAccountTable() error
-	ListAllowedAccounts(authorization User) ([]Account, error)
-	GetAllowedAccount(authorityID string, authorization User) (Account, error)
-	GetAccount(authorityID string) (Account, error)
-	GetAccountByID(accountID int, authorization User) (Account, error)
-	CreateAccount(account Account) error
-	UpdateAccount(account Account, authorization User) error
-	PutAccount(account Account, authorization User) (string, error)
-
-	CreateOpenidNonceTable() error
-	CreateOpenidNonce(nonce OpenidNonce) error
-
-	CreateUser(user User) (int, error)
-	ListUsers() ([]User, error)
-	FindUsers(query string) ([]User, error)
-	GetUser(userID int) (User, error)
-	GetUserByUsername(username string) (User, error)
-	GetUserByAPIKey(apiKey, username string) (User, error)
-	UpdateUser(user User) error
-	DeleteUser(userID int) error
-	CreateUserTable() error
-	CreateAccountUserLinkTable() error
-	CheckUserInAccount(username, authorityID string) bool
-	AlterUserTable() error
-
-	ListUserAccounts(username string) ([]Account, error)
-	ListNotUserAccounts(username string) ([]Account, error)
-	ListAccountUsers(authorityID string) ([]User, error)
-
-	CreateKeypairStatusTable() error
-	AlterKeypairStatusTable() error
-	CreateKeypairStatus(ks KeypairStatus) (int, error)
-	UpdateKeypairStatus(ks KeypairStatus) error
-	DeleteKeypairStatus(ks KeypairStatus) error
-	GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error)
-	ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error)
-
-	CreateSubstoreTable() error
-	CreateAllowedSubstore(store Substore, authorization User) (Substore, error)
-	ListSubstores(accountID int, authorization User) ([]Substore, error)
-	UpdateAllowedSubstore(store Substore, authorization User) error
-	DeleteAllowedSubstore(storeID int, authorization User) (string, error)
-	GetAllowedSubstore(fromModelID int, serialNumber string, authorization User) (Substore, error)
-	GetSubstore(fromModelID int, serialNumber string) (Substore, error)
-	GetSubstoreModel(brand, model, serialNumber string) (Substore, error)
-
-	CreateTestLogTable() error
-	CreateTestLog(testLog TestLog) error
-	ListAllowedTestLog(authorization User) ([]TestLog, error)
-
-	HealthCheck() error
-
-	SyncAccount(account Account) error
-	SyncKeypair(keypair SyncKeypair) error
-	SyncModel(m Model) error
-	CheckForMatching(signLog SigningLog) (bool, error)
-	CreateSigningLogSync(signLog SigningLog) error
-	SyncSigningLog() ([]SigningLog, error)
-	SyncUpdateSigningLog(id int) error
-	SyncListTestLogs() ([]TestLog, error)
-	SyncDeleteTestLog(ID int) error
-	UpdateAllowedTestLog(ID int, authorization User) error
+	ListAllowedModels(ctx context.Context, authorization User, filter ModelFilter) ([]Model, error)
+	FindModel(ctx context.Context, brandID, modelName, apiKey string, scopes ...string) (Model, error)
+	FindAccountModel(ctx context.Context, brandID, modelName string) (Model, error)
+	GetAllowedModel(ctx context.Context, modelID int, authorization User) (Model, error)
+	UpdateAllowedModel(ctx context.Context, model Model, authorization User) (string, error)
+	DeleteAllowedModel(ctx context.Context, model Model, authorization User) (string, error)
+	ListAllowedDeletedModels(ctx context.Context, authorization User) ([]Model, error)
+	RestoreAllowedModel(ctx context.Context, modelID int, authorization User) (string, error)
+	CreateAllowedModel(ctx context.Context, model Model, authorization User) (Model, string, error)
+	BulkCreateAllowedModels(ctx context.Context, rows []BulkModel, authorization User) ([]BulkModelResult, error)
+	CreateModelTable(ctx context.Context) error
+	AlterModelTable(ctx context.Context) error
+	CheckAPIKey(ctx context.Context, apiKey string) bool
+	CheckModelExists(ctx context.Context, brandID, name string) bool
+	RotateAllowedModelAPIKey(ctx context.Context, modelID int, overlap time.Duration, authorization User) (ModelAPIKey, error)
+
+	CreateModelRevisionTable(ctx context.Context) error
+	ListAllowedModelHistory(ctx context.Context, modelID int, authorization User) ([]ModelRevision, error)
+
+	CreateModelAssertTable(ctx context.Context) error
+	AlterModelAssertTable(ctx context.Context) error
+	CreateModelAssert(ctx context.Context, m ModelAssertion) (int, error)
+	UpdateModelAssert(ctx context.Context, m ModelAssertion) error
+	GetModelAssert(ctx context.Context, modelID int) (ModelAssertion, error)
+	UpsertModelAssert(ctx context.Context, m ModelAssertion) error
+
+	CreateSignedModelAssertTable(ctx context.Context) error
+	GetSignedModelAssert(ctx context.Context, modelID, substoreID int) (SignedModelAssertion, error)
+	ListSignedModelAsserts(ctx context.Context) ([]SignedModelAssertion, error)
+	UpsertSignedModelAssert(ctx context.Context, s SignedModelAssertion) error
+	DeleteSignedModelAssert(ctx context.Context, id int) error
+
+	ListAllowedKeypairs(ctx context.Context, authorization User) ([]Keypair, error)
+	GetKeypair(ctx context.Context, keypairID int) (Keypair, error)
+	GetKeypairByPublicID(ctx context.Context, authorityID, keyID string) (Keypair, error)
+	GetKeypairByName(ctx context.Context, authorityID, keyName string) (Keypair, error)
+	PutKeypair(ctx context.Context, keypair Keypair) (string, error)
+	UpdateAllowedKeypairActive(ctx context.Context, keypairID int, active bool, authorization User) error
+	UpdateKeypairAssertion(ctx context.Context, keypair Keypair, authorization User) (string, error)
+	CreateKeypairTable(ctx context.Context) error
+	AlterKeypairTable(ctx context.Context) error
+	CheckKeypairKeynameExists(ctx context.Context, authorityID, name string) bool
+
+	CreateSettingsTable(ctx context.Context) error
+	PutSetting(ctx context.Context, setting Setting) error
+	GetSetting(ctx context.Context, code string) (Setting, error)
+
+	CreateSigningLogTable(ctx context.Context) error
+	CheckForDuplicate(ctx context.Context, signLog *SigningLog) (bool, int, error)
+	CreateSigningLog(ctx context.Context, signLog SigningLog) error
+	ListAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, page SigningLogPage) ([]SigningLog, string, error)
+	StreamAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, fn func(SigningLog) error) error
+	AllowedSigningReport(ctx context.Context, authorization User, filter SigningLogFilter, period string) (SigningReport, error)
+	ListAllowedSigningLogForFingerprint(ctx context.Context, authorization User, fingerprint string) ([]SigningLog, error)
+	ListAllowedDevicesForFingerprint(ctx context.Context, authorization User, fingerprint string, limit int) ([]Device, error)
+	SigningLogWatermark(ctx context.Context, now time.Time) (int, error)
+	RunJobLocked(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error)
+	ExportSigningLog(ctx context.Context, afterID, watermark, limit int) ([]SigningLog, error)
+	StartSigningLogBuffer(ctx context.Context)
+	StopSigningLogBuffer(ctx context.Context) error
+	ListAllowedSigningLogForAccount(ctx context.Context, authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error)
+	AllowedSigningLogFilterValues(ctx context.Context, authorization User, authorityID string) (SigningLogFilters, error)
+	HashSigningLogSerialNumbers(ctx context.Context, authorityID string) (int, error)
+
+	CreateDeviceNonceTable(ctx context.Context) error
+	AlterDeviceNonceTable(ctx context.Context) error
+	DeleteExpiredDeviceNonces(ctx context.Context) error
+	CreateDeviceNonce(ctx context.Context, ttl time.Duration) (DeviceNonce, error)
+	ValidateDeviceNonce(ctx context.Context, nonce string) error
+	DeviceNonceTTL(ctx context.Context, apiKey string, defaultTTL time.Duration) (time.Duration, error)
+	CreateAccountNonceTable(ctx context.Context) error
+	GetAllowedAccountNonceTTL(ctx context.Context, accountID int, authorization User) (AccountNonceTTL, error)
+	UpdateAllowedAccountNonceTTL(ctx context.Context, ttl AccountNonceTTL, authorization User) error
+
+	CreateAccountTable(ctx context.Context) error
+	AlterAccountTable(ctx context.Context) error
+	ListAllowedAccounts(ctx context.Context, authorization User) ([]Account, error)
+	GetAllowedAccount(ctx context.Context, authorityID string, authorization User) (Account, error)
+	GetAccount(ctx context.Context, authorityID string) (Account, error)
+	FindAccount(ctx context.Context, authorityID string) (Account, error)
+	GetAccountByID(ctx context.Context, accountID int, authorization User) (Account, error)
+	CreateAccount(ctx context.Context, account Account) error
+	UpdateAccount(ctx context.Context, account Account, authorization User) error
+	PutAccount(ctx context.Context, account Account, authorization User) (string, error)
+	UpdateAllowedAccountDefaultKeypair(ctx context.Context, accountID, keypairID int, authorization User) error
+
+	CreateAPIKeyTable(ctx context.Context) error
+	AlterAPIKeyTable(ctx context.Context) error
+	EncryptColumns(ctx context.Context) error
+	FindAccountAPIKey(ctx context.Context, apiKey string) (APIKey, error)
+	RotateAllowedAPIKey(ctx context.Context, keyID, accountID int, grace time.Duration, authorization User) (APIKey, error)
+	CheckAccountAPIKey(ctx context.Context, apiKey, authorityID string, scopes ...string) bool
+
+	ListAllowedAPIKeys(ctx context.Context, accountID int, authorization User) ([]APIKey, error)
+	GetAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) (APIKey, error)
+	CreateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) (APIKey, error)
+	UpdateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) error
+	DeleteAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) error
+
+	CreateClientCertTable(ctx context.Context) error
+	FindClientCert(ctx context.Context, fingerprint string, sans []string) (ClientCert, error)
+	ListAllowedClientCerts(ctx context.Context, accountID int, authorization User) ([]ClientCert, error)
+	GetAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) (ClientCert, error)
+	CreateAllowedClientCert(ctx context.Context, c ClientCert, authorization User) (ClientCert, error)
+	DeleteAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) error
+
+	CreateAccountHMACTable(ctx context.Context) error
+	GetAccountHMACSecret(ctx context.Context, authorityID string) (string, error)
+	UseRequestSignature(ctx context.Context, signature string, expires time.Time) error
+	DeleteExpiredRequestSignatures(ctx context.Context) error
+	GetAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error)
+	RotateAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error)
+	DeleteAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) error
+
+	CreateWebhookTable(ctx context.Context) error
+	AlterWebhookTable(ctx context.Context) error
+	QueueWebhookDeliveries(ctx context.Context, authorityID, event, payload string) (int, error)
+	ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error)
+	UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error
+	PurgeWebhookDeliveries(ctx context.Context, now time.Time) (int, error)
+	ListAllowedWebhooks(ctx context.Context, accountID int, authorization User) ([]Webhook, error)
+	CreateAllowedWebhook(ctx context.Context, w Webhook, authorization User) (Webhook, error)
+	DeleteAllowedWebhook(ctx context.Context, webhookID, accountID int, authorization User) error
+	ListAllowedWebhookDeliveries(ctx context.Context, webhookID, accountID int, status string, authorization User) ([]WebhookDelivery, error)
+
+	CreateNotificationTable(ctx context.Context) error
+	RecordNotification(ctx context.Context, event, reference string) (bool, error)
+
+	CreateServiceAccountTable(ctx context.Context) error
+	ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error)
+	GetServiceAccount(ctx context.Context, serviceAccountID int) (ServiceAccount, error)
+	CreateServiceAccount(ctx context.Context, sa ServiceAccount) (ServiceAccount, error)
+	UpdateServiceAccount(ctx context.Context, sa ServiceAccount) error
+	ResetServiceAccountSecret(ctx context.Context, serviceAccountID int) (ServiceAccount, error)
+	DeleteServiceAccount(ctx context.Context, serviceAccountID int) error
+	AuthenticateServiceAccount(ctx context.Context, clientID, secret string) (ServiceAccount, error)
+	CheckServiceAccount(ctx context.Context, clientID string) bool
+
+	CreateAccessTokenTable(ctx context.Context) error
+	ListAccessTokens(ctx context.Context, userID int) ([]AccessToken, error)
+	CreateAccessToken(ctx context.Context, t AccessToken) (AccessToken, error)
+	DeleteAccessToken(ctx context.Context, userID, tokenID int) error
+	AuthenticateAccessToken(ctx context.Context, token string) (AccessToken, error)
+
+	CreateInvitationTable(ctx context.Context) error
+	CreateInvitation(ctx context.Context, inv Invitation, user User) (Invitation, error)
+	AcceptInvitation(ctx context.Context, invitationID int, user User) (User, error)
+
+	CreateRevokedTokenTable(ctx context.Context) error
+	RevokeToken(ctx context.Context, username, tokenID string, expires time.Time) error
+	RevokeUserTokens(ctx context.Context, username string, expires time.Time) error
+	UseRefreshToken(ctx context.Context, username, tokenID string, expires time.Time) (bool, error)
+	IsTokenRevoked(ctx context.Context, username, tokenID string, issued time.Time) bool
+
+	CreateAuthFailureTable(ctx context.Context) error
+	GetAuthFailure(ctx context.Context, key string) (AuthFailure, error)
+	RecordAuthFailure(ctx context.Context, key string, policy LockoutPolicy) (AuthFailure, error)
+	ResetAuthFailures(ctx context.Context, key string) error
+
+	CreateOpenidNonceTable(ctx context.Context) error
+	CreateOpenidNonce(ctx context.Context, nonce OpenidNonce) error
+
+	CreateUser(ctx context.Context, user User) (int, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	FindUsers(ctx context.Context, query string) ([]User, error)
+	GetUser(ctx context.Context, userID int) (User, error)
+	GetUserByUsername(ctx context.Context, username string) (User, error)
+	GetUserByAPIKey(ctx context.Context, apiKey, username string) (User, error)
+	UpdateUser(ctx context.Context, user User) error
+	BulkProvisionUsers(ctx context.Context, users []User) ([]BulkUserResult, error)
+	DeleteUser(ctx context.Context, userID int) error
+	SetUserActive(ctx context.Context, userID int, active bool) error
+	IsUserActive(ctx context.Context, username string) bool
+	CreateUserTable(ctx context.Context) error
+	CreateAccountUserLinkTable(ctx context.Context) error
+	CheckUserInAccount(ctx context.Context, username, authorityID string) bool
+	AlterUserTable(ctx context.Context) error
+
+	ListUserAccounts(ctx context.Context, username string) ([]Account, error)
+	ListNotUserAccounts(ctx context.Context, username string) ([]Account, error)
+	ListAccountUsers(ctx context.Context, authorityID string) ([]User, error)
+
+	CreateKeypairStatusTable(ctx context.Context) error
+	AlterKeypairStatusTable(ctx context.Context) error
+	CreateKeypairStatus(ctx context.Context, ks KeypairStatus) (int, error)
+	UpdateKeypairStatus(ctx context.Context, ks KeypairStatus) error
+	DeleteKeypairStatus(ctx context.Context, ks KeypairStatus) error
+	GetKeypairStatus(ctx context.Context, authorityID, keyName string) (KeypairStatus, error)
+	ListAllowedKeypairStatus(ctx context.Context, authorization User) ([]KeypairStatus, error)
+
+	CreateSubstoreTable(ctx context.Context) error
+	AlterSubstoreTable(ctx context.Context) error
+	CreateAllowedSubstore(ctx context.Context, store Substore, authorization User) (Substore, error)
+	BulkCreateAllowedSubstores(ctx context.Context, accountID int, stores []Substore, authorization User) ([]BulkSubstoreResult, error)
+	ListSubstores(ctx context.Context, accountID int, authorization User, filter SubstoreFilter) ([]Substore, error)
+	UpdateAllowedSubstore(ctx context.Context, store Substore, authorization User) error
+	DeleteAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error)
+	ListDeletedSubstores(ctx context.Context, accountID int, authorization User) ([]Substore, error)
+	RestoreAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error)
+	GetAllowedSubstore(ctx context.Context, fromModelID int, serialNumber string, authorization User) (Substore, error)
+	GetSubstore(ctx context.Context, fromModelID int, serialNumber string) (Substore, error)
+	GetSubstoreByID(ctx context.Context, storeID int) (Substore, error)
+	GetSubstoreModel(ctx context.Context, brand, model, serialNumber string) (Substore, error)
+
+	CreateSubstorePivotTable(ctx context.Context) error
+	CreateSubstorePivot(ctx context.Context, pivot SubstorePivot) error
+	ListAllowedSubstorePivots(ctx context.Context, fromModelID int, serialNumber string, authorization User) ([]SubstorePivot, error)
+
+	CreateUserSubstoreLinkTable(ctx context.Context) error
+	ListAllowedSubstoreUsers(ctx context.Context, accountID int, authorization User) ([]SubstoreUser, error)
+	CreateAllowedSubstoreUser(ctx context.Context, accountID int, user SubstoreUser, authorization User) (int, error)
+	DeleteAllowedSubstoreUser(ctx context.Context, accountID, userID int, authorization User) error
+
+	ListAllowedDevices(ctx context.Context, serialNumber string, authorization User) ([]Device, error)
+
+	CreateDataPurgeTable(ctx context.Context) error
+	PurgeDeviceData(ctx context.Context, request DataPurgeRequest, entry AuditLog) (DeletionCertificate, error)
+	ListDeletionCertificates(ctx context.Context) ([]DeletionCertificate, error)
+
+	CreateAccountQuotaTable(ctx context.Context) error
+	GetAllowedAccountQuota(ctx context.Context, accountID int, authorization User) (AccountQuota, error)
+	UpdateAllowedAccountQuota(ctx context.Context, quota AccountQuota, authorization User) error
+	CheckAccountQuota(ctx context.Context, authorityID string) (QuotaCheck, error)
+	ReleaseAccountQuota(ctx context.Context, authorityID string) error
+
+	CreateAccountArchiveTable(ctx context.Context) error
+	DeleteAccount(ctx context.Context, accountID int, entry AuditLog) (AccountArchive, error)
+	ListAccountArchives(ctx context.Context) ([]AccountArchive, error)
+	GetAccountArchiveBundle(ctx context.Context, archiveID int) (string, []byte, error)
+
+	CreateAccountRetentionTable(ctx context.Context) error
+	CreateSigningLogPurgeTable(ctx context.Context) error
+	GetAllowedAccountRetention(ctx context.Context, accountID int, authorization User) (AccountRetention, error)
+	UpdateAllowedAccountRetention(ctx context.Context, retention AccountRetention, authorization User) error
+	PurgeSigningLog(ctx context.Context, defaultMonths int, now time.Time) ([]SigningLogPurge, error)
+	ListAllowedSigningLogPurges(ctx context.Context, authorization User) ([]SigningLogPurge, error)
+	CreateSigningLogPartitions(ctx context.Context, from time.Time, ahead int) ([]string, error)
+	PartitionSigningLogTable(ctx context.Context, now time.Time) error
+
+	CreateSigningErrorTable(ctx context.Context) error
+	CreateSigningError(ctx context.Context, e SigningError) error
+	PurgeSigningErrors(ctx context.Context, now time.Time) (int, error)
+	AllowedDashboard(ctx context.Context, authorization User, now time.Time) ([]DashboardAccount, error)
+
+	CreateAuditLogTable(ctx context.Context) error
+	CreateAuditLog(ctx context.Context, entry AuditLog) error
+	ListAllowedAuditLog(ctx context.Context, authorization User, filter AuditLogFilter, page SigningLogPage) ([]AuditLog, string, error)
+
+	CreateAuditChainTable(ctx context.Context) error
+	AppendAuditChain(ctx context.Context, now time.Time) (int, error)
+	VerifyAllowedAuditChain(ctx context.Context, authorization User, from, to int64) (AuditChainVerification, error)
+	SigningLogAuditChainProof(ctx context.Context, ids []int) (AuditChainProof, error)
+
+	CreateTestLogTable(ctx context.Context) error
+	CreateTestLog(ctx context.Context, testLog TestLog) error
+	ListAllowedTestLog(ctx context.Context, authorization User) ([]TestLog, error)
+
+	HealthCheck(ctx context.Context) error
+	Close() error
+
+	SyncAccount(ctx context.Context, account Account) error
+	SyncKeypair(ctx context.Context, keypair SyncKeypair) error
+	SyncModel(ctx context.Context, m Model) error
+	CheckForMatching(ctx context.Context, signLog SigningLog) (bool, error)
+	CreateSigningLogSync(ctx context.Context, signLog SigningLog) error
+	SyncSigningLog(ctx context.Context) ([]SigningLog, error)
+	SyncUpdateSigningLog(ctx context.Context, id int) error
+	SyncConflictSigningLog(ctx context.Context, id int) error
+	SyncListTestLogs(ctx context.Context) ([]TestLog, error)
+	SyncDeleteTestLog(ctx context.Context, ID int) error
+	UpdateAllowedTestLog(ctx context.Context, ID int, authorization User) error
+
+	CreateSyncStatusTable(ctx context.Context) error
+	PutSyncStatus(ctx context.Context, status SyncStatus) error
+	ListSyncStatus(ctx context.Context) ([]SyncStatus, error)
 }
 
 // DB local database interface with our custom methods.
 type DB struct {
 	*sql.DB
+	logBuffer *signingLogBuffer // write-behind buffer of the signing logs, nil for synchronous writes
+	replica   *sql.DB           // read-only replica of the list and lookup queries, nil to read from the primary
+	cache     Cache             // cache of the lookups of the signing path, nil without the cache
 }
 
 // Env Environment struct that holds the config and data store details.
 type Env struct {
-	Config    config.Settings
-	DB        Datastore
-	KeypairDB *KeypairDatabase
+	Config       config.Settings
+	DB           Datastore
+	KeypairDB    *KeypairDatabase
+	SerialHasher SerialHasher // overrides the default hashing of serial numbers
 }
 
 // Environ contains the parsed config file settings.
@@ -168,16 +379,80 @@ var OpenidNonceStore PgNonceStore
 
 // OpenSysDatabase return an open database connection
 func OpenSysDatabase(driver, dataSource string) {
+	pool, err := Pool(Environ.Config)
+	if err != nil {
+		log.Fatalf("Error in the database pool config: %v", err)
+	}
+	slowQueryThreshold, err = SlowQueryThreshold(Environ.Config)
+	if err != nil {
+		log.Fatalf("Error in the database config: %v", err)
+	}
+
 	// Open the database connection
-	if driver == "sqlite3" {
+	switch driver {
+	case "sqlite3":
 		openSQLiteDatabase(driver, dataSource)
-	} else {
+	case CockroachDriver:
+		openCockroachDatabase(dataSource)
+	default:
 		openPostgreSQLDatabase(driver, dataSource)
 	}
+
+	if len(Environ.Config.ReadDataSource) > 0 {
+		openReadReplica(driver, Environ.Config.ReadDataSource)
+	}
+
+	db := Environ.DB.(*DB)
+	pool.apply(db.DB)
+	if db.replica != nil {
+		pool.apply(db.replica)
+	}
+
+	db.cache, err = NewCache(Environ.Config)
+	if err != nil {
+		log.Fatalf("Error in the cache config: %v", err)
+	}
+}
+
+// reader returns the database of the read-heavy list and lookup queries, which is the
+// replica when there is one. The replica lags the primary, so the writes, and the reads
+// that must see them, stay on the primary
+func (db *DB) reader() *DB {
+	if db.replica == nil {
+		return db
+	}
+	r := *db
+	r.DB, r.replica = db.replica, nil
+	return &r
+}
+
+// Close closes the connections to the primary database and to the replica
+func (db *DB) Close() error {
+	if db.replica != nil {
+		db.replica.Close()
+	}
+	return db.DB.Close()
 }
 
-func (db *DB) transaction(txFunc func(*sql.Tx) error) error {
-	tx, err := db.Begin()
+// The retries of a transaction that conflicts with another transaction
+const (
+	transactionRetries    = 5
+	transactionRetryDelay = 20 * time.Millisecond
+)
+
+// transaction runs the function in a transaction, which is retried when it conflicts with
+// another transaction. The function must only change the database through the transaction
+func (db *DB) transaction(ctx context.Context, txFunc func(*sql.Tx) error) error {
+	err := db.runTransaction(ctx, txFunc)
+	for retry := 1; retry <= transactionRetries && retryableError(err); retry++ {
+		time.Sleep(time.Duration(retry) * transactionRetryDelay)
+		err = db.runTransaction(ctx, txFunc)
+	}
+	return err
+}
+
+func (db *DB) runTransaction(ctx context.Context, txFunc func(*sql.Tx) error) (err error) {
+	tx, err := db.BeginTx(ctx, nil)
 	if err != nil {
 		return err
 	}
@@ -195,6 +470,12 @@ func (db *DB) transaction(txFunc func(*sql.Tx) error) error {
 	return err
 }
 
+// InCockroach checks if the database is CockroachDB, which runs the Postgres statements
+// except for the table partitions and the advisory locks
+func InCockroach() bool {
+	return Environ.Config.Driver == CockroachDriver
+}
+
 // InFactory checks if we are running in the factory (with a sqlite database)
 func InFactory() bool {
 	if Environ.Config.Driver == "sqlite3" {
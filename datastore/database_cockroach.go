@@ -0,0 +1,85 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// CockroachDriver is the driver setting of a CockroachDB database, which is accessed
+// with the postgres driver
+const CockroachDriver = "cockroach"
+
+// cockroachRetryCode is the SQLSTATE of a transaction that CockroachDB aborted, and that is
+// to be retried by the client
+const cockroachRetryCode = "40001"
+
+// cockroachSessionSettings are the session variables that make CockroachDB behave like Postgres
+// for the statements of the datastore
+var cockroachSessionSettings = []string{
+	// The serial IDs are from sequences, instead of unique_rowid() that is too large for the web app
+	"serial_normalization=sql_sequence",
+	// The upserts update and insert into the same table
+	"enable_multiple_modifications_of_table=true",
+}
+
+// openCockroachDatabase return an open database connection for a CockroachDB database
+func openCockroachDatabase(dataSource string) {
+	openPostgreSQLDatabase("postgres", cockroachDataSource(dataSource))
+}
+
+// cockroachDataSource adds the session settings to the data source, which is a URL or
+// a list of key-value pairs. The data source is not changed when it has the options
+func cockroachDataSource(dataSource string) string {
+	if strings.Contains(dataSource, "options=") {
+		return dataSource
+	}
+	options := "-c " + strings.Join(cockroachSessionSettings, " -c ")
+
+	if strings.HasPrefix(dataSource, "postgres://") || strings.HasPrefix(dataSource, "postgresql://") {
+		separator := "?"
+		if strings.Contains(dataSource, "?") {
+			separator = "&"
+		}
+		return dataSource + separator + "options=" + url.QueryEscape(options)
+	}
+	return strings.TrimSpace(dataSource+" options='"+options) + "'"
+}
+
+// retryableError checks if the transaction failed due to a conflict with another transaction,
+// so it can be retried. CockroachDB reports a retry as a serialization failure
+func retryableError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == cockroachRetryCode
+	}
+
+	// The transactions can return the error as text
+	return strings.Contains(err.Error(), "restart transaction")
+}
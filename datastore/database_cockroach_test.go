@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/lib/pq"
+)
+
+func TestCockroachDataSource(t *testing.T) {
+	tests := []struct {
+		name       string
+		dataSource string
+		want       string
+	}{
+		{"url", "postgres://vault@localhost:26257/vault", "postgres://vault@localhost:26257/vault?options=-c+serial_normalization%3Dsql_sequence+-c+enable_multiple_modifications_of_table%3Dtrue"},
+		{"url params", "postgresql://vault@localhost:26257/vault?sslmode=disable", "postgresql://vault@localhost:26257/vault?sslmode=disable&options=-c+serial_normalization%3Dsql_sequence+-c+enable_multiple_modifications_of_table%3Dtrue"},
+		{"key-value", "dbname=vault sslmode=disable", "dbname=vault sslmode=disable options='-c serial_normalization=sql_sequence -c enable_multiple_modifications_of_table=true'"},
+		{"options", "dbname=vault options='-c serial_normalization=virtual_sequence'", "dbname=vault options='-c serial_normalization=virtual_sequence'"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := cockroachDataSource(tt.dataSource); got != tt.want {
+				t.Errorf("cockroachDataSource() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryableError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"none", nil, false},
+		{"serialization failure", &pq.Error{Code: "40001", Message: "restart transaction: TransactionRetryWithProtoRefreshError"}, true},
+		{"wrapped", fmt.Errorf("error creating the archive: %w", &pq.Error{Code: "40001"}), true},
+		{"text", fmt.Errorf("error creating the archive: %v", &pq.Error{Code: "40001", Message: "restart transaction: WriteTooOld"}), true},
+		{"unique violation", &pq.Error{Code: "23505", Message: "duplicate key value violates unique constraint"}, false},
+		{"other", errors.New("MOCK error"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := retryableError(tt.err); got != tt.want {
+				t.Errorf("retryableError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTransactionRetry(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	tests := []struct {
+		name     string
+		failures int
+		err      error
+		attempts int
+		wantErr  bool
+	}{
+		{"success", 0, nil, 1, false},
+		{"retried", 2, &pq.Error{Code: "40001"}, 3, false},
+		{"retries exhausted", transactionRetries + 1, &pq.Error{Code: "40001"}, transactionRetries + 1, true},
+		{"not retried", 1, errors.New("MOCK error"), 1, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := db.transaction(context.Background(), func(tx *sql.Tx) error {
+				attempts++
+				if _, err := tx.ExecContext(context.Background(), "INSERT INTO settings (code, data) VALUES ($1, $2)", tt.name, "data"); err != nil {
+					return err
+				}
+				if attempts <= tt.failures {
+					return tt.err
+				}
+				return nil
+			})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("transaction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if attempts != tt.attempts {
+				t.Errorf("transaction() attempts = %d, want %d", attempts, tt.attempts)
+			}
+
+			// Only the settings of the committed transaction are stored
+			var count int
+			if err := db.QueryRowContext(context.Background(), "SELECT COUNT(*) FROM settings WHERE code=$1", tt.name).Scan(&count); err != nil {
+				t.Fatalf("counting the settings: %v", err)
+			}
+			if want := map[bool]int{false: 1, true: 0}[tt.wantErr]; count != want {
+				t.Errorf("transaction() stored %d settings, want %d", count, want)
+			}
+		})
+	}
+}
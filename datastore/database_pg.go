@@ -21,8 +21,6 @@
 package datastore
 
 import (
-	"database/sql"
-
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	_ "github.com/lib/pq" // postgresql driver
 )
@@ -30,7 +28,7 @@ import (
 // openPostgreSQLDatabase return an open database connection for an postgresql database
 func openPostgreSQLDatabase(driver, dataSource string) {
 	// Open the database connection
-	db, err := sql.Open(driver, dataSource)
+	db, err := openInstrumentedDatabase(driver, dataSource)
 	if err != nil {
 		log.Fatalf("Error opening the database: %v", err)
 	}
@@ -41,6 +39,29 @@ func openPostgreSQLDatabase(driver, dataSource string) {
 		log.Fatalf("Error accessing the database: %v", err)
 	}
 
-	Environ.DB = &DB{db}
-	OpenidNonceStore.DB = &DB{db}
+	Environ.DB = &DB{DB: db}
+	OpenidNonceStore.DB = &DB{DB: db}
+}
+
+// openReadReplica opens the read-only replica of the list and lookup queries
+func openReadReplica(driver, dataSource string) {
+	switch driver {
+	case "sqlite3":
+		log.Warning("The read replica is not supported on SQLite, the queries use the primary database")
+		return
+	case CockroachDriver:
+		driver, dataSource = "postgres", cockroachDataSource(dataSource)
+	}
+
+	replica, err := openInstrumentedDatabase(driver, dataSource)
+	if err != nil {
+		log.Fatalf("Error opening the read replica: %v", err)
+	}
+
+	err = replica.Ping()
+	if err != nil {
+		log.Fatalf("Error accessing the read replica: %v", err)
+	}
+
+	Environ.DB.(*DB).replica = replica
 }
@@ -21,17 +21,35 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
-	_ "github.com/mattn/go-sqlite3" // sqlite driver
+	sqlite3 "github.com/mattn/go-sqlite3" // sqlite driver
 )
 
+// sqliteDriver is the registered driver that translates the Postgres statements of the
+// datastore to the SQLite dialect, so the same managers run on both databases
+const sqliteDriver = "sqlite3-serialvault"
+
+// sqliteBusyTimeout is the time in milliseconds that a connection waits for the write lock
+const sqliteBusyTimeout = 5000
+
+func init() {
+	sql.Register(sqliteDriver, &sqliteDialectDriver{driver: &sqlite3.SQLiteDriver{}})
+}
+
 // openSQLiteDatabase return an open database connection for an sqlite database
 func openSQLiteDatabase(driver, dataSource string) {
 	// Open the database connection
-	db, err := sql.Open(driver, dataSource)
+	db, err := openInstrumentedDatabase(sqliteDriver, sqliteDataSource(dataSource))
 	if err != nil {
 		log.Fatalf("Error opening the database: %v\n", err)
 	}
@@ -42,6 +60,229 @@ func openSQLiteDatabase(driver, dataSource string) {
 		log.Fatalf("Error accessing the database: %v\n", err)
 	}
 
-	Environ.DB = &DB{db}
-	OpenidNonceStore.DB = &DB{db}
+	Environ.DB = &DB{DB: db}
+	OpenidNonceStore.DB = &DB{DB: db}
+}
+
+// sqliteDataSource sets the busy timeout and takes the write lock at the start of a
+// transaction, unless they are in the data source. Otherwise, concurrent transactions
+// fail with 'database is locked' instead of waiting for each other
+func sqliteDataSource(dataSource string) string {
+	params := []string{}
+	if !strings.Contains(dataSource, "_busy_timeout=") && !strings.Contains(dataSource, "_timeout=") {
+		params = append(params, fmt.Sprintf("_busy_timeout=%d", sqliteBusyTimeout))
+	}
+	if !strings.Contains(dataSource, "_txlock=") {
+		params = append(params, "_txlock=immediate")
+	}
+	if len(params) == 0 {
+		return dataSource
+	}
+
+	separator := "?"
+	if strings.Contains(dataSource, "?") {
+		separator = "&"
+	}
+	return dataSource + separator + strings.Join(params, "&")
+}
+
+// sqliteOverrides are the SQLite statements of the Postgres statements that cannot be translated
+var sqliteOverrides = map[string]string{
+	upsertAccountSQL:            upsertAccountSQLite,
+	upsertAccountNonceTTLSQL:    upsertAccountNonceTTLSQLite,
+	upsertAccountHMACSQL:        upsertAccountHMACSQLite,
+	upsertAccountRetentionSQL:   upsertAccountRetentionSQLite,
+	upsertAccountQuotaSQL:       upsertAccountQuotaSQLite,
+	upsertKeypairSQL:            upsertKeypairSQLite,
+	purgeFingerprintSubstoreSQL: purgeFingerprintSubstoreSQLite,
+	alterModelAssertUC18Fields:  alterModelAssertUC18FieldsSQLite,
+	alterModelAssertUC20Fields:  alterModelAssertUC20FieldsSQLite,
+}
+
+// sqliteRewrite is a translation of Postgres syntax to SQLite syntax
+type sqliteRewrite struct {
+	pattern     *regexp.Regexp
+	replacement string
+}
+
+var sqliteRewrites = []sqliteRewrite{
+	// The numbered parameters, as SQLite binds the $-parameters by name in order of appearance
+	{regexp.MustCompile(`\$(\d+)`), "?$1"},
+	// The auto-increment primary keys
+	{regexp.MustCompile(`(?i)\b(big)?serial\s+primary\s+key\b`), "integer primary key autoincrement"},
+	// SQLite only compares ASCII case-insensitively, like Postgres ILIKE
+	{regexp.MustCompile(`(?i)\bilike\b`), "LIKE"},
+	// SQLite locks the database for a write transaction, so the row locks are not needed
+	{regexp.MustCompile(`(?i)\s+for\s+update\b`), ""},
+	// The alias of the table that is updated needs the AS keyword
+	{regexp.MustCompile(`(?i)^(\s*update\s+\w+)\s+(\w+)(\s+set\b)`), "$1 AS $2$3"},
+	// The truncated timestamps of the reports
+	{regexp.MustCompile(`(?i)date_trunc\('day',\s*([\w.]+)\)`), "datetime($1, 'start of day')"},
+	{regexp.MustCompile(`(?i)date_trunc\('week',\s*([\w.]+)\)`), "datetime($1, 'weekday 0', '-6 days', 'start of day')"},
+	{regexp.MustCompile(`(?i)date_trunc\('month',\s*([\w.]+)\)`), "datetime($1, 'start of month')"},
+}
+
+var (
+	sqliteAlterColumn          = regexp.MustCompile(`(?i)\balter\s+column\b`)
+	sqliteAddColumnIfNotExists = regexp.MustCompile(`(?i)\badd\s+column\s+if\s+not\s+exists\b`)
+	sqliteDropColumnIfExists   = regexp.MustCompile(`(?i)\bdrop\s+column\s+if\s+exists\b`)
+	sqliteLimit                = regexp.MustCompile(`(?i)\blimit\b`)
+	sqliteOffset               = regexp.MustCompile(`(?i)\boffset\b`)
+)
+
+// sqliteStatement is a translated statement. The error is ignored when it contains the
+// tolerated message, so the schema changes can be repeated like on Postgres
+type sqliteStatement struct {
+	query    string
+	tolerate string
+}
+
+func (s sqliteStatement) ignore(err error) bool {
+	return len(s.tolerate) > 0 && strings.Contains(err.Error(), s.tolerate)
+}
+
+// sqliteTranslations caches the translated statements, as the statements are constants
+var sqliteTranslations sync.Map
+
+// sqliteTranslate translates a Postgres statement into SQLite statements. The statements of an override
+// are separated by semicolons, and each statement is executed with all the parameters
+func sqliteTranslate(query string) []sqliteStatement {
+	if cached, ok := sqliteTranslations.Load(query); ok {
+		return cached.([]sqliteStatement)
+	}
+
+	source := query
+	if override, ok := sqliteOverrides[query]; ok {
+		source = override
+	}
+
+	statements := []sqliteStatement{}
+	for _, q := range strings.Split(source, ";") {
+		if len(strings.TrimSpace(q)) == 0 {
+			continue
+		}
+
+		// SQLite cannot change the constraints of a column, they are only kept on Postgres
+		if sqliteAlterColumn.MatchString(q) {
+			continue
+		}
+
+		s := sqliteStatement{}
+		switch {
+		case sqliteAddColumnIfNotExists.MatchString(q):
+			q = sqliteAddColumnIfNotExists.ReplaceAllString(q, "ADD COLUMN")
+			s.tolerate = "duplicate column name"
+		case sqliteDropColumnIfExists.MatchString(q):
+			q = sqliteDropColumnIfExists.ReplaceAllString(q, "DROP COLUMN")
+			s.tolerate = "no such column"
+		}
+
+		// SQLite only has an offset after a limit
+		if sqliteOffset.MatchString(q) && !sqliteLimit.MatchString(q) {
+			q = sqliteOffset.ReplaceAllString(q, "LIMIT -1 OFFSET")
+		}
+
+		for _, r := range sqliteRewrites {
+			q = r.pattern.ReplaceAllString(q, r.replacement)
+		}
+		s.query = q
+		statements = append(statements, s)
+	}
+
+	sqliteTranslations.Store(query, statements)
+	return statements
+}
+
+// sqliteDialectDriver opens the SQLite connections that translate the statements
+type sqliteDialectDriver struct {
+	driver *sqlite3.SQLiteDriver
+}
+
+// Open returns a new connection to the SQLite database
+func (d *sqliteDialectDriver) Open(dataSource string) (driver.Conn, error) {
+	conn, err := d.driver.Open(dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &sqliteDialectConn{conn.(*sqlite3.SQLiteConn)}, nil
+}
+
+// sqliteDialectConn is an SQLite connection that translates the statements before they are run
+type sqliteDialectConn struct {
+	*sqlite3.SQLiteConn
+}
+
+// Prepare translates and prepares a statement
+func (c *sqliteDialectConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+// PrepareContext translates and prepares a statement. Only single statements can be prepared
+func (c *sqliteDialectConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	statements := sqliteTranslate(query)
+	if len(statements) != 1 {
+		return nil, fmt.Errorf("cannot prepare %d SQLite statements", len(statements))
+	}
+	return c.SQLiteConn.PrepareContext(ctx, statements[0].query)
+}
+
+// ExecContext translates and executes the statements, returning the result of the last statement
+func (c *sqliteDialectConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	var result driver.Result = driver.RowsAffected(0)
+	for _, s := range sqliteTranslate(query) {
+		r, err := c.SQLiteConn.ExecContext(ctx, s.query, args)
+		if err != nil {
+			if s.ignore(err) {
+				continue
+			}
+			return nil, err
+		}
+		result = r
+	}
+	return result, nil
+}
+
+// QueryContext translates the statements, and returns the rows of the last statement
+func (c *sqliteDialectConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	statements := sqliteTranslate(query)
+	if len(statements) == 0 {
+		return nil, fmt.Errorf("the statement is not supported by SQLite")
+	}
+
+	for _, s := range statements[:len(statements)-1] {
+		if _, err := c.SQLiteConn.ExecContext(ctx, s.query, args); err != nil && !s.ignore(err) {
+			return nil, err
+		}
+	}
+	return c.SQLiteConn.QueryContext(ctx, statements[len(statements)-1].query, args)
+}
+
+// timestampScanner scans a timestamp that is the result of a date function, which
+// SQLite returns as text
+type timestampScanner struct {
+	t *time.Time
+}
+
+// Scan converts the timestamp from the database
+func (s timestampScanner) Scan(value interface{}) error {
+	var text string
+	switch v := value.(type) {
+	case time.Time:
+		*s.t = v
+		return nil
+	case string:
+		text = v
+	case []byte:
+		text = string(v)
+	default:
+		return fmt.Errorf("cannot scan %T into a timestamp", value)
+	}
+
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.ParseInLocation(format, text, time.UTC); err == nil {
+			*s.t = t
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot parse the timestamp: %s", text)
 }
@@ -0,0 +1,263 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestSQLiteTranslate(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  []sqliteStatement
+	}{
+		{"parameters", "select id from model where brand_id=$2 and name=$1", []sqliteStatement{{"select id from model where brand_id=?2 and name=?1", ""}}},
+		{"serial", "CREATE TABLE IF NOT EXISTS t (id serial primary key not null)", []sqliteStatement{{"CREATE TABLE IF NOT EXISTS t (id integer primary key autoincrement not null)", ""}}},
+		{"ilike", "SELECT * FROM userinfo WHERE name ILIKE $1", []sqliteStatement{{"SELECT * FROM userinfo WHERE name LIKE ?1", ""}}},
+		{"for update", "SELECT id FROM account WHERE id=$1 FOR UPDATE", []sqliteStatement{{"SELECT id FROM account WHERE id=?1", ""}}},
+		{"update alias", "UPDATE keypair k SET active=$2 WHERE k.id=$1", []sqliteStatement{{"UPDATE keypair AS k SET active=?2 WHERE k.id=?1", ""}}},
+		{"offset", "SELECT * FROM signinglog ORDER BY id DESC OFFSET 10", []sqliteStatement{{"SELECT * FROM signinglog ORDER BY id DESC LIMIT -1 OFFSET 10", ""}}},
+		{"limit offset", "SELECT * FROM signinglog LIMIT 5 OFFSET 10", []sqliteStatement{{"SELECT * FROM signinglog LIMIT 5 OFFSET 10", ""}}},
+		{"date trunc", "SELECT date_trunc('week', s.created) AS period", []sqliteStatement{{"SELECT datetime(s.created, 'weekday 0', '-6 days', 'start of day') AS period", ""}}},
+		{"alter column", alterModelUserKeypairNotNullable, []sqliteStatement{}},
+		{"add column", alterUserActive, []sqliteStatement{{"alter table userinfo ADD COLUMN active bool not null default true", "duplicate column name"}}},
+		{"drop column", alterUserRemoveOpenIDIdentity, []sqliteStatement{{"alter table userinfo DROP COLUMN openid_identity", "no such column"}}},
+		{"override", upsertAccountRetentionSQL, []sqliteStatement{
+			{"\n\tUPDATE accountretention SET months=?2 WHERE account_id=?1", ""},
+			{"\n\tINSERT INTO accountretention (account_id,months) SELECT ?1, ?2 WHERE changes()=0\n", ""},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := sqliteTranslate(tt.query)
+			if len(got) != len(tt.want) {
+				t.Fatalf("sqliteTranslate() = %v, want %v", got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Errorf("sqliteTranslate() statement %d = %q, want %q", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestSQLiteDataSource(t *testing.T) {
+	tests := []struct {
+		dataSource string
+		want       string
+	}{
+		{"vault.db", "vault.db?_busy_timeout=5000&_txlock=immediate"},
+		{"file:vault.db?mode=rwc", "file:vault.db?mode=rwc&_busy_timeout=5000&_txlock=immediate"},
+		{"vault.db?_busy_timeout=100&_txlock=deferred", "vault.db?_busy_timeout=100&_txlock=deferred"},
+	}
+	for _, tt := range tests {
+		if got := sqliteDataSource(tt.dataSource); got != tt.want {
+			t.Errorf("sqliteDataSource(%q) = %q, want %q", tt.dataSource, got, tt.want)
+		}
+	}
+}
+
+// openSQLiteTestDatabase opens a new SQLite database with the schema of the datastore
+func openSQLiteTestDatabase(t *testing.T) *DB {
+	Environ = &Env{Config: config.Settings{Driver: "sqlite3", KeyStoreType: "database", KeyStoreSecret: "secret code to encrypt the auth-key hash"}}
+	OpenSysDatabase("sqlite3", filepath.Join(t.TempDir(), "serial-vault.db"))
+	db := Environ.DB.(*DB)
+
+	schema := []func(context.Context) error{
+		db.CreateKeypairTable, db.CreateModelTable, db.CreateSettingsTable, db.CreateSigningLogTable,
+		db.CreateAccountTable, db.AlterAccountTable, db.AlterModelTable, db.AlterKeypairTable,
+		db.CreateUserTable, db.CreateAccountUserLinkTable, db.AlterUserTable,
+		db.CreateModelAssertTable, db.AlterModelAssertTable, db.CreateSubstoreTable, db.AlterSubstoreTable, db.CreateSubstorePivotTable,
+		db.CreateSigningLogPurgeTable, db.CreateAccountQuotaTable, db.CreateAccountRetentionTable,
+		db.CreateModelRevisionTable, db.CreateSignedModelAssertTable, db.CreateWebhookTable,
+		db.AlterWebhookTable, db.CreateNotificationTable, db.CreateSyncStatusTable,
+		db.CreateUserSubstoreLinkTable, db.CreateAccountNonceTable, db.CreateAPIKeyTable, db.AlterAPIKeyTable,
+		db.CreateClientCertTable, db.CreateAccountHMACTable, db.CreateAccountArchiveTable, db.CreateAuditLogTable, db.CreateAuditChainTable,
+		db.CreateRevokedTokenTable,
+	}
+	// The schema updates are repeated, as on every start of the service
+	for i := 0; i < 2; i++ {
+		for _, create := range schema {
+			if err := create(context.Background()); err != nil {
+				t.Fatalf("creating the SQLite schema: %v", err)
+			}
+		}
+	}
+	return db
+}
+
+func TestSQLiteDatastore(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	root := User{Username: "root", Role: Superuser}
+
+	// The account upsert inserts and then updates the account
+	for _, assertion := range []string{"first", "second"} {
+		if _, err := db.PutAccount(context.Background(), Account{AuthorityID: "alder", Assertion: assertion}, root); err != nil {
+			t.Fatalf("PutAccount() error = %v", err)
+		}
+	}
+	account, err := db.GetAccount(context.Background(), "alder")
+	if err != nil || account.Assertion != "second" {
+		t.Fatalf("GetAccount() = %v, %v", account, err)
+	}
+
+	if _, err := db.CreateUser(context.Background(), User{Username: "sv", Name: "Steven Vault", Email: "sv@example.com", Role: Admin, Active: true, Accounts: []Account{account}}); err != nil {
+		t.Fatalf("CreateUser() error = %v", err)
+	}
+	admin, err := db.GetUserByUsername(context.Background(), "sv")
+	if err != nil {
+		t.Fatalf("GetUserByUsername() error = %v", err)
+	}
+	admin.Accounts = []Account{account}
+	if err := db.UpdateUser(context.Background(), admin); err != nil {
+		t.Fatalf("UpdateUser() error = %v", err)
+	}
+
+	for _, sealed := range []string{"sealed", "resealed"} {
+		if _, err := db.PutKeypair(context.Background(), Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: sealed, KeyName: "alder"}); err != nil {
+			t.Fatalf("PutKeypair() error = %v", err)
+		}
+	}
+	keypairs, err := db.ListAllowedKeypairs(context.Background(), admin)
+	if err != nil || len(keypairs) != 1 {
+		t.Fatalf("ListAllowedKeypairs() = %v, %v", keypairs, err)
+	}
+
+	// The new model ID is returned by the insert
+	model, _, err := db.CreateAllowedModel(context.Background(), Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypairs[0].ID, KeypairIDUser: keypairs[0].ID}, admin)
+	if err != nil || model.ID == 0 {
+		t.Fatalf("CreateAllowedModel() = %v, %v", model, err)
+	}
+	models, err := db.ListAllowedModels(context.Background(), admin, ModelFilter{})
+	if err != nil || len(models) != 1 {
+		t.Fatalf("ListAllowedModels() = %v, %v", models, err)
+	}
+
+	for _, serial := range []string{"A1", "A2"} {
+		if err := db.CreateSigningLog(context.Background(), SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial, TimestampToken: []byte("token-" + serial)}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+	logs, err := db.ListAllowedSigningLogForAccount(context.Background(), admin, "alder", &SigningLogParams{})
+	if err != nil || len(logs) != 2 {
+		t.Fatalf("ListAllowedSigningLogForAccount() = %v, %v", logs, err)
+	}
+	if string(logs[0].TimestampToken) != "token-A2" {
+		t.Errorf("ListAllowedSigningLogForAccount() token = %q, want the stored token", logs[0].TimestampToken)
+	}
+	logs, _, err = db.ListAllowedSigningLog(context.Background(), admin, SigningLogFilter{Search: "FP-a2"}, SigningLogPage{})
+	if err != nil || len(logs) != 1 || logs[0].SerialNumber != "A2" {
+		t.Fatalf("ListAllowedSigningLog() search = %v, %v", logs, err)
+	}
+
+	now := time.Now().UTC()
+	report, err := db.AllowedSigningReport(context.Background(), admin, SigningLogFilter{From: now.AddDate(0, 0, -7), To: now.Add(time.Hour)}, ReportPeriodDay)
+	if err != nil || report.Total != 2 || report.Rows[0].KeyID != "alder-key" {
+		t.Fatalf("AllowedSigningReport() = %v, %v", report, err)
+	}
+	if !report.Rows[0].Period.Equal(now.Truncate(24 * time.Hour)) {
+		t.Errorf("AllowedSigningReport() period = %v", report.Rows[0].Period)
+	}
+
+	for _, warning := range []int{10, 20} {
+		if err := db.UpdateAllowedAccountQuota(context.Background(), AccountQuota{AccountID: account.ID, Warning: warning, Limit: 100}, root); err != nil {
+			t.Fatalf("UpdateAllowedAccountQuota() error = %v", err)
+		}
+	}
+	quota, err := db.GetAllowedAccountQuota(context.Background(), account.ID, root)
+	if err != nil || quota.Warning != 20 || quota.Used != 2 {
+		t.Fatalf("GetAllowedAccountQuota() = %v, %v", quota, err)
+	}
+
+	// The model of the user is deleted
+	if _, err := db.DeleteAllowedModel(context.Background(), model, admin); err != nil {
+		t.Fatalf("DeleteAllowedModel() error = %v", err)
+	}
+	models, err = db.ListAllowedModels(context.Background(), admin, ModelFilter{})
+	if err != nil || len(models) != 0 {
+		t.Fatalf("ListAllowedModels() after delete = %v, %v", models, err)
+	}
+
+	// The deleted model is restored by the user
+	if _, err := db.RestoreAllowedModel(context.Background(), model.ID, admin); err != nil {
+		t.Fatalf("RestoreAllowedModel() error = %v", err)
+	}
+	models, err = db.ListAllowedModels(context.Background(), admin, ModelFilter{})
+	if err != nil || len(models) != 1 {
+		t.Fatalf("ListAllowedModels() after restore = %v, %v", models, err)
+	}
+}
+
+func TestReadReplica(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	replica := openSQLiteTestDatabase(t)
+	db := openSQLiteTestDatabase(t)
+	db.replica = replica.DB
+	defer db.Close()
+
+	// The writes go to the primary, and the lookups of the signing requests read from the replica
+	if err := db.CreateAccount(context.Background(), Account{AuthorityID: "alder", Assertion: "primary"}); err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	if _, err := db.FindAccount(context.Background(), "alder"); err == nil {
+		t.Fatal("FindAccount() expected an error, as the account is not in the replica")
+	}
+
+	// The account is read from the primary, so it sees its own writes
+	account, err := db.GetAccount(context.Background(), "alder")
+	if err != nil || account.Assertion != "primary" {
+		t.Fatalf("GetAccount() = %v, %v", account, err)
+	}
+
+	if err := replica.CreateAccount(context.Background(), Account{AuthorityID: "alder", Assertion: "replica"}); err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	account, err = db.FindAccount(context.Background(), "alder")
+	if err != nil || account.Assertion != "replica" {
+		t.Fatalf("FindAccount() = %v, %v", account, err)
+	}
+
+	// The reader keeps the cache of the database
+	db.cache = newMemoryCache(time.Minute)
+	if r := db.reader(); r.DB != replica.DB || r.cache != db.cache {
+		t.Errorf("reader() = %v, want the replica with the cache", r)
+	}
+
+	if err := replica.CreateSigningLog(context.Background(), SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-A1"}); err != nil {
+		t.Fatalf("CreateSigningLog() error = %v", err)
+	}
+	logs, _, err := db.ListAllowedSigningLog(context.Background(), User{Role: Superuser}, SigningLogFilter{}, SigningLogPage{})
+	if err != nil || len(logs) != 1 {
+		t.Fatalf("ListAllowedSigningLog() = %v, %v", logs, err)
+	}
+}
@@ -0,0 +1,271 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
+)
+
+// defaultSlowQueryThreshold is the duration of the queries that are logged, when it is not configured
+const defaultSlowQueryThreshold = time.Second
+
+// slowQueryThreshold is the duration of the queries that are logged, zero to disable the log
+var slowQueryThreshold = defaultSlowQueryThreshold
+
+// SlowQueryThreshold returns the duration of the queries that are logged from the config settings
+func SlowQueryThreshold(settings config.Settings) (time.Duration, error) {
+	if len(settings.SlowQueryThreshold) == 0 {
+		return defaultSlowQueryThreshold, nil
+	}
+
+	threshold, err := time.ParseDuration(settings.SlowQueryThreshold)
+	if err != nil {
+		return 0, fmt.Errorf("invalid slow query threshold: %v", err)
+	}
+	if threshold < 0 {
+		return 0, fmt.Errorf("the slow query threshold must not be negative")
+	}
+	return threshold, nil
+}
+
+// openInstrumentedDatabase opens a database where the queries are recorded in the metrics, and
+// the slow queries are logged. The connections of the driver are wrapped, so the queries of the
+// transactions are recorded as well
+func openInstrumentedDatabase(driverName, dataSource string) (*sql.DB, error) {
+	// Find the driver, as the open does not connect to the database
+	db, err := sql.Open(driverName, dataSource)
+	if err != nil {
+		return nil, err
+	}
+	d := db.Driver()
+	db.Close()
+
+	return sql.OpenDB(&instrumentedConnector{driver: d, dataSource: dataSource}), nil
+}
+
+// instrumentedConnector opens the connections that record the queries
+type instrumentedConnector struct {
+	driver     driver.Driver
+	dataSource string
+}
+
+// Connect returns a new connection to the database
+func (c *instrumentedConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	conn, err := c.driver.Open(c.dataSource)
+	if err != nil {
+		return nil, err
+	}
+	return &instrumentedConn{conn}, nil
+}
+
+// Driver returns the driver of the database
+func (c *instrumentedConnector) Driver() driver.Driver {
+	return c.driver
+}
+
+// instrumentedConn is a connection that records the duration, the rows and the errors of the
+// queries. The optional interfaces of the connection are passed through, or skipped so the
+// database/sql package falls back to the interfaces that the connection supports
+type instrumentedConn struct {
+	driver.Conn
+}
+
+// ExecContext executes and records a statement
+func (c *instrumentedConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	execer, ok := c.Conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	result, err := execer.ExecContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		return result, err
+	}
+
+	rows := int64(-1)
+	if err == nil {
+		if n, e := result.RowsAffected(); e == nil {
+			rows = n
+		}
+	}
+	recordQuery(ctx, query, time.Since(start), rows, err)
+	return result, err
+}
+
+// QueryContext runs a query and records it once its rows are closed
+func (c *instrumentedConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	queryer, ok := c.Conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+
+	start := time.Now()
+	rows, err := queryer.QueryContext(ctx, query, args)
+	if err == driver.ErrSkip {
+		return rows, err
+	}
+	if err != nil {
+		recordQuery(ctx, query, time.Since(start), -1, err)
+		return rows, err
+	}
+	return &instrumentedRows{Rows: rows, ctx: ctx, query: query, duration: time.Since(start)}, nil
+}
+
+// PrepareContext prepares a statement
+func (c *instrumentedConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+// BeginTx starts a transaction
+func (c *instrumentedConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return c.Conn.Begin()
+}
+
+// Ping checks the connection to the database
+func (c *instrumentedConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+// ResetSession resets the connection before it is reused
+func (c *instrumentedConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+// IsValid checks if the connection can be reused
+func (c *instrumentedConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok {
+		return validator.IsValid()
+	}
+	return true
+}
+
+// CheckNamedValue converts the arguments of the queries
+func (c *instrumentedConn) CheckNamedValue(v *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+// instrumentedRows counts the rows of a query, which is recorded when the rows are closed
+type instrumentedRows struct {
+	driver.Rows
+	ctx      context.Context
+	query    string
+	duration time.Duration
+	count    int64
+	err      error
+}
+
+// Next reads the next row
+func (r *instrumentedRows) Next(dest []driver.Value) error {
+	err := r.Rows.Next(dest)
+	switch err {
+	case nil:
+		r.count++
+	case io.EOF:
+	default:
+		r.err = err
+	}
+	return err
+}
+
+// Close closes the rows and records the query
+func (r *instrumentedRows) Close() error {
+	err := r.Rows.Close()
+	recordQuery(r.ctx, r.query, r.duration, r.count, r.err)
+	return err
+}
+
+// recordQuery records the query in the metrics, and logs it when it is slow. The rows are
+// negative when they are not known
+func recordQuery(ctx context.Context, query string, duration time.Duration, rows int64, err error) {
+	name := queryName(query)
+
+	metric.DatabaseQueryLatencyHistogramVec.WithLabelValues(name).Observe(float64(duration.Milliseconds()))
+	if rows >= 0 {
+		metric.DatabaseQueryRowsHistogramVec.WithLabelValues(name).Observe(float64(rows))
+	}
+	if err != nil {
+		metric.DatabaseQueryErrorsCounterVec.WithLabelValues(name).Inc()
+	}
+
+	if slowQueryThreshold > 0 && duration >= slowQueryThreshold {
+		log.FromContext(ctx).WithFields(log.Fields{"query": name, "duration": duration.String()}).Warningf("Slow database query: %s", name)
+	}
+}
+
+// identifierPattern matches the name of a table at the start of the text
+var identifierPattern = regexp.MustCompile(`^[a-z_][a-z0-9_.]*`)
+
+// queryName returns the identifier of a query for the metrics and the logs: the statement and the
+// first table, e.g. "select signinglog". The values of the query are never part of the identifier
+func queryName(query string) string {
+	fields := strings.Fields(strings.ToLower(query))
+	if len(fields) == 0 {
+		return "unknown"
+	}
+	statement := identifierPattern.FindString(fields[0])
+	if len(statement) == 0 {
+		return "unknown"
+	}
+
+	for i := 0; i < len(fields)-1; i++ {
+		switch fields[i] {
+		case "from", "into", "update", "table", "on":
+		default:
+			continue
+		}
+
+		next := i + 1
+		for next < len(fields)-1 && (fields[next] == "if" || fields[next] == "not" || fields[next] == "exists" || fields[next] == "only") {
+			next++
+		}
+		if table := identifierPattern.FindString(fields[next]); len(table) > 0 {
+			return statement + " " + table
+		}
+	}
+	return statement
+}
@@ -0,0 +1,119 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestQueryName(t *testing.T) {
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"SELECT * FROM signinglog WHERE id=$1", "select signinglog"},
+		{"select m.id from (select * from model) m", "select model"},
+		{"INSERT INTO model(brand_id, name) VALUES ('secret', $1)", "insert model"},
+		{"UPDATE account SET assertion=$1", "update account"},
+		{"DELETE FROM nonce WHERE expires < $1", "delete nonce"},
+		{"CREATE TABLE IF NOT EXISTS settings (code varchar(200))", "create settings"},
+		{"CREATE INDEX IF NOT EXISTS signinglog_idx ON signinglog (make)", "create signinglog"},
+		{"BEGIN", "begin"},
+		{"  ", "unknown"},
+	}
+
+	for _, tt := range tests {
+		if got := queryName(tt.query); got != tt.want {
+			t.Errorf("queryName(%q) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestSlowQueryThreshold(t *testing.T) {
+	tests := []struct {
+		name      string
+		threshold string
+		want      time.Duration
+		wantErr   bool
+	}{
+		{"default", "", time.Second, false},
+		{"configured", "250ms", 250 * time.Millisecond, false},
+		{"disabled", "0", 0, false},
+		{"invalid", "slow", 0, true},
+		{"negative", "-1s", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := SlowQueryThreshold(config.Settings{SlowQueryThreshold: tt.threshold})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SlowQueryThreshold() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("SlowQueryThreshold() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestInstrumentedDatabase(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	errors := testutil.ToFloat64(metric.DatabaseQueryErrorsCounterVec.WithLabelValues("select missing"))
+	if _, err := db.QueryContext(context.Background(), "SELECT * FROM missing"); err == nil {
+		t.Fatal("QueryContext() expected an error")
+	}
+	if got := testutil.ToFloat64(metric.DatabaseQueryErrorsCounterVec.WithLabelValues("select missing")); got != errors+1 {
+		t.Errorf("expected the query error in the metrics, got %v", got)
+	}
+
+	if err := db.CreateAccount(context.Background(), Account{AuthorityID: "alder"}); err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	if _, err := db.GetAccount(context.Background(), "alder"); err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(metric.DatabaseQueryRowsHistogramVec)
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() error = %v", err)
+	}
+	for _, f := range families {
+		for _, m := range f.GetMetric() {
+			if m.GetLabel()[0].GetValue() == "select account" && m.GetHistogram().GetSampleSum() > 0 {
+				return
+			}
+		}
+	}
+	t.Error("expected the rows of the account query in the metrics")
+}
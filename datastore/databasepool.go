@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Default limits of the connection pool, when they are not configured. Without a limit on the
+// open connections, a burst of signing requests from the factory lines exhausts the connections
+// of the database, and the connections are recycled so a failover of the database is picked up
+const (
+	defaultMaxOpenConns    = 50
+	defaultMaxIdleConns    = 10
+	defaultConnMaxLifetime = 30 * time.Minute
+	defaultConnMaxIdleTime = 5 * time.Minute
+)
+
+// PoolSettings are the limits of the database connection pool
+type PoolSettings struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+}
+
+// Pool returns the limits of the database connection pool from the config settings
+func Pool(settings config.Settings) (PoolSettings, error) {
+	pool := PoolSettings{
+		MaxOpenConns:    defaultMaxOpenConns,
+		MaxIdleConns:    defaultMaxIdleConns,
+		ConnMaxLifetime: defaultConnMaxLifetime,
+		ConnMaxIdleTime: defaultConnMaxIdleTime,
+	}
+
+	if settings.DBMaxOpenConns < 0 || settings.DBMaxIdleConns < 0 {
+		return pool, fmt.Errorf("the database connections must not be negative")
+	}
+	if settings.DBMaxOpenConns > 0 {
+		pool.MaxOpenConns = settings.DBMaxOpenConns
+	}
+	if settings.DBMaxIdleConns > 0 {
+		pool.MaxIdleConns = settings.DBMaxIdleConns
+	}
+	if pool.MaxIdleConns > pool.MaxOpenConns {
+		return pool, fmt.Errorf("the idle database connections must not be more than the open connections (%d)", pool.MaxOpenConns)
+	}
+
+	var err error
+	if pool.ConnMaxLifetime, err = poolDuration(settings.DBConnMaxLifetime, defaultConnMaxLifetime); err != nil {
+		return pool, fmt.Errorf("invalid database connection lifetime: %v", err)
+	}
+	if pool.ConnMaxIdleTime, err = poolDuration(settings.DBConnMaxIdleTime, defaultConnMaxIdleTime); err != nil {
+		return pool, fmt.Errorf("invalid database connection idle time: %v", err)
+	}
+	return pool, nil
+}
+
+// poolDuration parses a duration of the connections, where zero means that they are reused forever
+func poolDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if len(value) == 0 {
+		return defaultValue, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, err
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("the duration must not be negative")
+	}
+	return d, nil
+}
+
+// apply sets the limits of the connection pool of the database
+func (p PoolSettings) apply(db *sql.DB) {
+	db.SetMaxOpenConns(p.MaxOpenConns)
+	db.SetMaxIdleConns(p.MaxIdleConns)
+	db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	db.SetConnMaxIdleTime(p.ConnMaxIdleTime)
+}
+
+// PoolStats returns the statistics of the connection pool of the primary database, for the metrics
+func PoolStats() sql.DBStats {
+	if Environ == nil {
+		return sql.DBStats{}
+	}
+	db, ok := Environ.DB.(*DB)
+	if !ok {
+		return sql.DBStats{}
+	}
+	return db.Stats()
+}
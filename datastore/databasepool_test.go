@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestPool(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Settings
+		want     PoolSettings
+		wantErr  bool
+	}{
+		{"default", config.Settings{}, PoolSettings{50, 10, 30 * time.Minute, 5 * time.Minute}, false},
+		{"configured", config.Settings{DBMaxOpenConns: 200, DBMaxIdleConns: 100, DBConnMaxLifetime: "1h", DBConnMaxIdleTime: "0"}, PoolSettings{200, 100, time.Hour, 0}, false},
+		{"negative", config.Settings{DBMaxOpenConns: -1}, PoolSettings{}, true},
+		{"idle-over-open", config.Settings{DBMaxOpenConns: 5}, PoolSettings{}, true},
+		{"invalid-lifetime", config.Settings{DBConnMaxLifetime: "hourly"}, PoolSettings{}, true},
+		{"negative-idle-time", config.Settings{DBConnMaxIdleTime: "-1m"}, PoolSettings{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Pool(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Pool() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && got != tt.want {
+				t.Errorf("Pool() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,440 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The deletion certificates of the purged device data. The serial number or the fingerprint
+// is only kept as a keyed hash, so the certificate does not hold the personal data
+const createDataPurgeTableSQL = `
+	CREATE TABLE IF NOT EXISTS datapurge (
+		id               serial primary key not null,
+		subject          varchar(20) not null,
+		subject_hash     varchar(64) not null,
+		mode             varchar(20) not null,
+		signinglogs      int not null,
+		substores        int not null,
+		purged_by        varchar(200) not null,
+		signature        varchar(200) not null,
+		created          timestamp not null
+	)
+`
+
+// The ECDSA signature of the certificates is longer than the HMAC of the first certificates
+const alterDataPurgeSignatureSQL = "alter table datapurge alter column signature type varchar(200)"
+
+const createDataPurgeSQL = `
+	INSERT INTO datapurge (subject, subject_hash, mode, signinglogs, substores, purged_by, signature, created)
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8) RETURNING id`
+const updateDataPurgeSignatureSQL = "UPDATE datapurge SET signature=$2 WHERE id=$1"
+const listDataPurgesSQL = `
+	SELECT id, subject, subject_hash, mode, signinglogs, substores, purged_by, signature, created
+	FROM datapurge
+	ORDER BY id DESC`
+
+// anonymizedPrefix replaces the serial number and the fingerprint of the anonymized signing logs,
+// followed by the ID of the signing log
+const anonymizedPrefix = "anonymized:"
+
+// The purge of the records of a serial number. The device registry is built from the signing logs
+var purgeSerialSQL = map[string]string{
+	PurgeModeDelete:    "DELETE FROM signinglog WHERE serial_number=$1",
+	PurgeModeAnonymize: "UPDATE signinglog SET serial_number='" + anonymizedPrefix + "' || id, fingerprint='" + anonymizedPrefix + "' || id WHERE serial_number=$1",
+}
+
+// The serial number is also purged from the index of the signing logs that were purged by the retention
+var purgeSerialIndexSQL = map[string]string{
+	PurgeModeDelete:    "DELETE FROM signingserial WHERE serial_number=$1",
+	PurgeModeAnonymize: "UPDATE signingserial SET serial_number='" + anonymizedPrefix + "' || id, fingerprint='" + anonymizedPrefix + "' || id WHERE serial_number=$1",
+}
+
+const purgeSerialSubstoreSQL = "DELETE FROM substore WHERE serial_number=$1"
+
+const purgeSerialSubstorePivotSQL = "DELETE FROM substorepivot WHERE serial_number=$1"
+
+// The purge of the records of a device-key fingerprint. The sub-store mappings and the pivots of the
+// serial numbers that were signed for the device-key are deleted first
+var purgeFingerprintSQL = map[string]string{
+	PurgeModeDelete:    "DELETE FROM signinglog WHERE fingerprint=$1",
+	PurgeModeAnonymize: "UPDATE signinglog SET serial_number='" + anonymizedPrefix + "' || id, fingerprint='" + anonymizedPrefix + "' || id WHERE fingerprint=$1",
+}
+
+var purgeFingerprintIndexSQL = map[string]string{
+	PurgeModeDelete:    "DELETE FROM signingserial WHERE fingerprint=$1",
+	PurgeModeAnonymize: "UPDATE signingserial SET serial_number='" + anonymizedPrefix + "' || id, fingerprint='" + anonymizedPrefix + "' || id WHERE fingerprint=$1",
+}
+
+const purgeFingerprintSubstoreSQL = `
+	DELETE FROM substore ss
+	USING account a
+	WHERE a.id=ss.account_id AND EXISTS (
+		SELECT * FROM signinglog s
+		WHERE s.fingerprint=$1 AND s.make=a.authority_id AND s.serial_number IN (ss.serial_number, ss.serial_hash)
+	)`
+
+const purgeFingerprintSubstorePivotSQL = `
+	DELETE FROM substorepivot
+	WHERE serial_number IN (SELECT serial_number FROM signinglog WHERE fingerprint=$1)
+	OR substore_id IN (
+		SELECT ss.id FROM substore ss
+		INNER JOIN signinglog s ON s.serial_number=ss.serial_hash
+		WHERE s.fingerprint=$1 AND ss.serial_hash<>''
+	)`
+
+// sqlite3 syntax, as SQLite cannot join the deleted table
+const purgeFingerprintSubstoreSQLite = `
+	DELETE FROM substore
+	WHERE EXISTS (
+		SELECT * FROM account a
+		INNER JOIN signinglog s ON s.make=a.authority_id
+		WHERE a.id=substore.account_id AND s.fingerprint=$1 AND s.serial_number IN (substore.serial_number, substore.serial_hash)
+	)`
+
+// The subjects of a data purge
+const (
+	PurgeSubjectSerial      = "serial"
+	PurgeSubjectFingerprint = "fingerprint"
+)
+
+// The modes of a data purge: the signing logs are deleted, or the serial number and the fingerprint
+// are replaced, which keeps the signing logs in the quotas and the reports
+const (
+	PurgeModeDelete    = "delete"
+	PurgeModeAnonymize = "anonymize"
+)
+
+// DataPurgeRequest is the serial number or the device-key fingerprint of the device data to purge
+type DataPurgeRequest struct {
+	SerialNumber string `json:"serial"`
+	Fingerprint  string `json:"fingerprint"`
+	Mode         string `json:"mode"`
+}
+
+// DeletionCertificate records the purge of the data of a device, for the compliance records.
+// It is signed with an ECDSA key, so it can be verified offline with the published public key
+type DeletionCertificate struct {
+	ID          int       `json:"id"`
+	Subject     string    `json:"subject"`
+	SubjectHash string    `json:"subjectHash"`
+	Mode        string    `json:"mode"`
+	SigningLogs int       `json:"signingLogs"`
+	Substores   int       `json:"substores"`
+	PurgedBy    string    `json:"purgedBy"`
+	Created     time.Time `json:"created"`
+	Signature   string    `json:"signature"`
+}
+
+// Validate checks the purge request, defaulting to the deletion of the records
+func (p *DataPurgeRequest) Validate() error {
+	if (len(p.SerialNumber) == 0) == (len(p.Fingerprint) == 0) {
+		return errors.New("Either the serial number or the device-key fingerprint must be provided")
+	}
+	switch p.Mode {
+	case "":
+		p.Mode = PurgeModeDelete
+	case PurgeModeDelete, PurgeModeAnonymize:
+	default:
+		return fmt.Errorf("Invalid purge mode '%s'", p.Mode)
+	}
+	return nil
+}
+
+// subject returns the subject of the purge and its value
+func (p DataPurgeRequest) subject() (string, string) {
+	if len(p.SerialNumber) > 0 {
+		return PurgeSubjectSerial, p.SerialNumber
+	}
+	return PurgeSubjectFingerprint, p.Fingerprint
+}
+
+// CreateDataPurgeTable creates the database table for the deletion certificates
+func (db *DB) CreateDataPurgeTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createDataPurgeTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, alterDataPurgeSignatureSQL)
+	return err
+}
+
+// PurgeDeviceData deletes or anonymizes the signing logs and deletes the sub-store mappings of a
+// serial number or a device-key fingerprint, in a transaction, and returns the signed deletion
+// certificate. The audit log entry is recorded in the same transaction, with the certificate
+func (db *DB) PurgeDeviceData(ctx context.Context, request DataPurgeRequest, entry AuditLog) (DeletionCertificate, error) {
+	if err := request.Validate(); err != nil {
+		return DeletionCertificate{}, err
+	}
+
+	subject, value := request.subject()
+	subjectHash, err := Environ.PurgeSubjectHash(subject, value)
+	if err != nil {
+		return DeletionCertificate{}, err
+	}
+	cert := DeletionCertificate{Subject: subject, SubjectHash: subjectHash, Mode: request.Mode, PurgedBy: entry.Username,
+		Created: time.Now().UTC().Truncate(time.Second)}
+
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		var err error
+		if subject == PurgeSubjectSerial {
+			cert.SigningLogs, cert.Substores, err = purgeSerial(ctx, tx, value, request.Mode)
+		} else {
+			cert.SigningLogs, cert.Substores, err = purgeFingerprint(ctx, tx, value, request.Mode)
+		}
+		if err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, createDataPurgeSQL, cert.Subject, cert.SubjectHash, cert.Mode, cert.SigningLogs, cert.Substores,
+			cert.PurgedBy, "", cert.Created).Scan(&cert.ID)
+		if err != nil {
+			return err
+		}
+
+		// The ID is part of the signed certificate
+		if cert, err = Environ.SignDeletionCertificate(cert); err != nil {
+			return err
+		}
+		if _, err = tx.ExecContext(ctx, updateDataPurgeSignatureSQL, cert.ID, cert.Signature); err != nil {
+			return err
+		}
+
+		after, _ := json.Marshal(cert)
+		if err = lockAuditRecord(ctx, tx); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, createAuditLogSQL, entry.Username, entry.Action, entry.Object, cert.ID, "", entry.Before, string(after))
+		return err
+	})
+	if err != nil {
+		log.Errorf("Error purging the device data: %v", err)
+		return DeletionCertificate{}, fmt.Errorf("error purging the device data: %v", err)
+	}
+	return cert, nil
+}
+
+// purgeSerial purges the records of the serial number, including the hashed serial numbers
+// of the accounts that do not keep them in clear
+func purgeSerial(ctx context.Context, tx *sql.Tx, serialNumber, mode string) (int, int, error) {
+	values, err := storedSerialNumbers(ctx, tx.QueryContext, serialNumber)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	logs := 0
+	for _, v := range values {
+		if mode == PurgeModeDelete {
+			if err := releaseSigningLog(ctx, tx, AuditReleaseDataPurge, time.Now(), "serial_number=$3", v); err != nil {
+				return 0, 0, err
+			}
+		}
+		count, err := execCount(ctx, tx, purgeSerialSQL[mode], v)
+		if err != nil {
+			return 0, 0, err
+		}
+		logs += count
+
+		if _, err := tx.ExecContext(ctx, purgeSerialIndexSQL[mode], v); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, purgeSerialSubstorePivotSQL, serialNumber); err != nil {
+		return 0, 0, err
+	}
+
+	substores, err := execCount(ctx, tx, purgeSerialSubstoreSQL, serialNumber)
+	return logs, substores, err
+}
+
+func purgeFingerprint(ctx context.Context, tx *sql.Tx, fingerprint, mode string) (int, int, error) {
+	if _, err := tx.ExecContext(ctx, purgeFingerprintSubstorePivotSQL, fingerprint); err != nil {
+		return 0, 0, err
+	}
+
+	substores, err := execCount(ctx, tx, purgeFingerprintSubstoreSQL, fingerprint)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if mode == PurgeModeDelete {
+		if err := releaseSigningLog(ctx, tx, AuditReleaseDataPurge, time.Now(), "fingerprint=$3", fingerprint); err != nil {
+			return 0, 0, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, purgeFingerprintIndexSQL[mode], fingerprint); err != nil {
+		return 0, 0, err
+	}
+	logs, err := execCount(ctx, tx, purgeFingerprintSQL[mode], fingerprint)
+	return logs, substores, err
+}
+
+func execCount(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) (int, error) {
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
+
+// ListDeletionCertificates returns the deletion certificates, most recent first
+func (db *DB) ListDeletionCertificates(ctx context.Context) ([]DeletionCertificate, error) {
+	rows, err := db.QueryContext(ctx, listDataPurgesSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the deletion certificates: %v", err)
+	}
+	defer rows.Close()
+
+	certs := []DeletionCertificate{}
+	for rows.Next() {
+		c := DeletionCertificate{}
+		if err := rows.Scan(&c.ID, &c.Subject, &c.SubjectHash, &c.Mode, &c.SigningLogs, &c.Substores, &c.PurgedBy, &c.Signature, &c.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the deletion certificates: %v", err)
+		}
+		c.Created = c.Created.UTC()
+		certs = append(certs, c)
+	}
+	return certs, rows.Err()
+}
+
+// purgeKey derives the key of the deletion certificates from the keystore secret
+func (env *Env) purgeKey(purpose string) ([]byte, error) {
+	if len(env.Config.KeyStoreSecret) == 0 {
+		return nil, errors.New("the keystore secret is needed to sign the deletion certificates")
+	}
+	return deriveColumnKey(env.Config.KeyStoreSecret, purpose), nil
+}
+
+// PurgeSubjectHash returns the keyed hash of the serial number or the fingerprint of a purge,
+// so a certificate can be matched to a device without storing the personal data
+func (env *Env) PurgeSubjectHash(subject, value string) (string, error) {
+	key, err := env.purgeKey("deletion-subject")
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(subject + "/" + value))
+	return hex.EncodeToString(mac.Sum(nil)), nil
+}
+
+// certificateKey derives the ECDSA P-256 key of the deletion certificates from the keystore secret,
+// so every instance of the vault signs with the same key without storing it
+func (env *Env) certificateKey() (*ecdsa.PrivateKey, error) {
+	seed, err := env.purgeKey("deletion-certificate-key")
+	if err != nil {
+		return nil, err
+	}
+
+	// The private key is the seed reduced to the range [1, N-1] of the curve
+	curve := elliptic.P256()
+	d := new(big.Int).SetBytes(seed)
+	d.Mod(d, new(big.Int).Sub(curve.Params().N, big.NewInt(1)))
+	d.Add(d, big.NewInt(1))
+
+	key := &ecdsa.PrivateKey{PublicKey: ecdsa.PublicKey{Curve: curve}, D: d}
+	key.PublicKey.X, key.PublicKey.Y = curve.ScalarBaseMult(d.Bytes())
+	return key, nil
+}
+
+// DeletionCertificatePublicKey returns the PEM-encoded public key of the deletion certificates
+func (env *Env) DeletionCertificatePublicKey() (string, error) {
+	key, err := env.certificateKey()
+	if err != nil {
+		return "", err
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return "", err
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})), nil
+}
+
+// certificateDigest is the SHA-256 of the JSON of the certificate, without the signature
+func certificateDigest(cert DeletionCertificate) ([]byte, error) {
+	cert.Signature = ""
+	data, err := json.Marshal(cert)
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(data)
+	return digest[:], nil
+}
+
+// SignDeletionCertificate signs the deletion certificate with the ECDSA-SHA256 of its fields.
+// The signature is the base64 of the ASN.1 signature
+func (env *Env) SignDeletionCertificate(cert DeletionCertificate) (DeletionCertificate, error) {
+	key, err := env.certificateKey()
+	if err != nil {
+		return cert, err
+	}
+
+	digest, err := certificateDigest(cert)
+	if err != nil {
+		return cert, err
+	}
+	signature, err := ecdsa.SignASN1(rand.Reader, key, digest)
+	if err != nil {
+		return cert, err
+	}
+	cert.Signature = base64.StdEncoding.EncodeToString(signature)
+	return cert, nil
+}
+
+// VerifyDeletionCertificate checks the signature of a deletion certificate
+func (env *Env) VerifyDeletionCertificate(cert DeletionCertificate) (bool, error) {
+	key, err := env.certificateKey()
+	if err != nil {
+		return false, err
+	}
+	return VerifyDeletionCertificate(&key.PublicKey, cert)
+}
+
+// VerifyDeletionCertificate checks the signature of a deletion certificate with the public key,
+// so a certificate can be verified without the keystore secret
+func VerifyDeletionCertificate(publicKey *ecdsa.PublicKey, cert DeletionCertificate) (bool, error) {
+	signature, err := base64.StdEncoding.DecodeString(cert.Signature)
+	if err != nil {
+		return false, nil
+	}
+	digest, err := certificateDigest(cert)
+	if err != nil {
+		return false, err
+	}
+	return ecdsa.VerifyASN1(publicKey, digest, signature), nil
+}
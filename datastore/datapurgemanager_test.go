@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestDataPurgeRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		request DataPurgeRequest
+		mode    string
+		wantErr bool
+	}{
+		{"serial", DataPurgeRequest{SerialNumber: "A1"}, PurgeModeDelete, false},
+		{"fingerprint", DataPurgeRequest{Fingerprint: "a1", Mode: PurgeModeAnonymize}, PurgeModeAnonymize, false},
+		{"both", DataPurgeRequest{SerialNumber: "A1", Fingerprint: "a1"}, "", true},
+		{"none", DataPurgeRequest{}, "", true},
+		{"invalid-mode", DataPurgeRequest{SerialNumber: "A1", Mode: "shred"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.request.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && tt.request.Mode != tt.mode {
+				t.Errorf("Validate() mode = %v, want %v", tt.request.Mode, tt.mode)
+			}
+		})
+	}
+}
+
+func TestDeletionCertificate(t *testing.T) {
+	env := Env{Config: config.Settings{KeyStoreSecret: "secret"}}
+
+	hash, err := env.PurgeSubjectHash(PurgeSubjectSerial, "A1")
+	if err != nil {
+		t.Fatalf("PurgeSubjectHash() error = %v", err)
+	}
+	if other, _ := env.PurgeSubjectHash(PurgeSubjectFingerprint, "A1"); other == hash {
+		t.Error("PurgeSubjectHash() must depend on the subject")
+	}
+
+	cert, err := env.SignDeletionCertificate(DeletionCertificate{ID: 1, Subject: PurgeSubjectSerial, SubjectHash: hash, SigningLogs: 3})
+	if err != nil {
+		t.Fatalf("SignDeletionCertificate() error = %v", err)
+	}
+	if valid, err := env.VerifyDeletionCertificate(cert); !valid || err != nil {
+		t.Errorf("VerifyDeletionCertificate() = %v, %v", valid, err)
+	}
+
+	cert.SigningLogs = 1
+	if valid, _ := env.VerifyDeletionCertificate(cert); valid {
+		t.Error("VerifyDeletionCertificate() must fail for a modified certificate")
+	}
+
+	if _, err := (&Env{}).SignDeletionCertificate(cert); err == nil {
+		t.Error("SignDeletionCertificate() must fail without the keystore secret")
+	}
+}
+
+func TestDeletionCertificatePublicKey(t *testing.T) {
+	env := Env{Config: config.Settings{KeyStoreSecret: "secret"}}
+
+	cert, err := env.SignDeletionCertificate(DeletionCertificate{ID: 1, Subject: PurgeSubjectSerial, SubjectHash: "a1b2", SigningLogs: 3})
+	if err != nil {
+		t.Fatalf("SignDeletionCertificate() error = %v", err)
+	}
+
+	// The certificate is verified with the public key, without the keystore secret
+	publicKey, err := env.DeletionCertificatePublicKey()
+	if err != nil {
+		t.Fatalf("DeletionCertificatePublicKey() error = %v", err)
+	}
+	block, _ := pem.Decode([]byte(publicKey))
+	if block == nil {
+		t.Fatalf("DeletionCertificatePublicKey() = %q, want a PEM public key", publicKey)
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		t.Fatalf("ParsePKIXPublicKey() error = %v", err)
+	}
+	if valid, err := VerifyDeletionCertificate(key.(*ecdsa.PublicKey), cert); !valid || err != nil {
+		t.Errorf("VerifyDeletionCertificate() = %v, %v", valid, err)
+	}
+
+	// The key is derived from the keystore secret, so it is the same for every instance
+	if again, _ := env.DeletionCertificatePublicKey(); again != publicKey {
+		t.Error("DeletionCertificatePublicKey() must be the same for the same secret")
+	}
+	other := Env{Config: config.Settings{KeyStoreSecret: "other"}}
+	if valid, _ := other.VerifyDeletionCertificate(cert); valid {
+		t.Error("VerifyDeletionCertificate() must fail with the key of another secret")
+	}
+
+	cert.Signature = "invalid"
+	if valid, _ := env.VerifyDeletionCertificate(cert); valid {
+		t.Error("VerifyDeletionCertificate() must fail for an invalid signature")
+	}
+}
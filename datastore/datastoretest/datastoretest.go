@@ -0,0 +1,156 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package datastoretest provides the in-memory Datastore of the vault's own tests, so the
+// integrations and the admin CLI can be tested against the full Datastore interface without
+// Postgres. The in-memory Datastore is an SQLite database, like the database of the factory,
+// with the schema of the vault and a fixed set of accounts, keypairs, models and users
+package datastoretest
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// The fixtures of the in-memory Datastore
+const (
+	AuthorityID = "system"
+	KeyID       = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+	ModelName   = "alder"
+	Username    = "sv"
+)
+
+// The driver of the in-memory Datastore, that the datastore environment is set to
+const driver = "sqlite3"
+
+// databases numbers the in-memory databases, so each Datastore has its own database
+var databases int64
+
+// The Datastores of the package implement the full interface
+var (
+	_ datastore.Datastore = &datastore.DB{}
+	_ datastore.Datastore = &datastore.ErrorMockDB{}
+)
+
+// New returns the in-memory Datastore, with the schema of the vault and the fixtures of the
+// accounts, keypairs, models and users. The database is deleted when the Datastore is closed
+func New() (*datastore.DB, error) {
+	saved := datastore.Environ
+	defer func() { datastore.Environ = saved }()
+
+	// The memdb VFS shares the database between the connections of the pool, with the locking of a file
+	name := fmt.Sprintf("file:/datastoretest-%d?vfs=memdb", atomic.AddInt64(&databases, 1))
+	datastore.Environ = &datastore.Env{Config: config.Settings{Driver: driver, DataSource: name}}
+	datastore.OpenSysDatabase(driver, name)
+	db := datastore.Environ.DB.(*datastore.DB)
+
+	// The database is deleted when its last connection is closed, so the idle connections are kept
+	db.SetConnMaxLifetime(0)
+	db.SetConnMaxIdleTime(0)
+
+	if err := createSchema(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating the schema: %v", err)
+	}
+	if err := createFixtures(context.Background(), db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error creating the fixtures: %v", err)
+	}
+	return db, nil
+}
+
+// NewFailing returns a Datastore where the calls fail, to test the handling of the database errors
+func NewFailing() *datastore.ErrorMockDB {
+	return &datastore.ErrorMockDB{}
+}
+
+// Open sets the datastore environment to the Datastore and the settings, with an in-memory
+// keystore, and returns the function that restores the previous environment. The driver of
+// the settings is set to the driver of the in-memory Datastore
+func Open(db datastore.Datastore, settings config.Settings) (func(), error) {
+	keypairDB, err := datastore.GetMemoryKeyStore(settings)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, ok := db.(*datastore.DB); ok {
+		settings.Driver = driver
+	}
+
+	saved := datastore.Environ
+	datastore.Environ = &datastore.Env{DB: db, Config: settings, KeypairDB: keypairDB}
+	return func() { datastore.Environ = saved }, nil
+}
+
+// createSchema creates the tables of the database update
+func createSchema(ctx context.Context, db *datastore.DB) error {
+	schema := []func(context.Context) error{
+		db.CreateKeypairTable, db.CreateModelTable, db.CreateSettingsTable, db.CreateSigningLogTable,
+		db.CreateDeviceNonceTable, db.AlterDeviceNonceTable, db.CreateAccountTable, db.AlterAccountTable,
+		db.AlterModelTable, db.AlterKeypairTable, db.CreateOpenidNonceTable, db.CreateUserTable,
+		db.CreateAccountUserLinkTable, db.AlterUserTable, db.CreateKeypairStatusTable, db.AlterKeypairStatusTable,
+		db.CreateModelAssertTable, db.AlterModelAssertTable, db.CreateSubstoreTable, db.AlterSubstoreTable,
+		db.CreateSubstorePivotTable, db.CreateUserSubstoreLinkTable, db.CreateSigningLogPurgeTable,
+		db.CreateAccountQuotaTable, db.CreateAccountRetentionTable, db.CreateAccountNonceTable,
+		db.CreateAPIKeyTable, db.AlterAPIKeyTable, db.CreateClientCertTable, db.CreateAccountHMACTable,
+		db.CreateWebhookTable, db.AlterWebhookTable, db.CreateNotificationTable, db.CreateServiceAccountTable,
+		db.CreateAccessTokenTable, db.CreateInvitationTable, db.CreateRevokedTokenTable, db.CreateAuthFailureTable,
+		db.CreateAccountArchiveTable, db.CreateSigningErrorTable, db.CreateAuditLogTable, db.CreateAuditChainTable,
+		db.CreateModelRevisionTable, db.CreateSignedModelAssertTable, db.CreateDataPurgeTable,
+		db.CreateTestLogTable, db.CreateSyncStatusTable,
+	}
+	for _, create := range schema {
+		if err := create(ctx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// createFixtures creates the account, the keypair and the model of the brand, with a superuser
+func createFixtures(ctx context.Context, db *datastore.DB) error {
+	if err := db.CreateAccount(ctx, datastore.Account{AuthorityID: AuthorityID, Assertion: "account assertion"}); err != nil {
+		return err
+	}
+	account, err := db.GetAccount(ctx, AuthorityID)
+	if err != nil {
+		return err
+	}
+
+	if _, err := db.PutKeypair(ctx, datastore.Keypair{AuthorityID: AuthorityID, KeyID: KeyID, KeyName: AuthorityID, Assertion: "account-key assertion"}); err != nil {
+		return err
+	}
+	keypairs, err := db.ListAllowedKeypairs(ctx, datastore.User{})
+	if err != nil || len(keypairs) == 0 {
+		return fmt.Errorf("error retrieving the keypair: %v", err)
+	}
+
+	model := datastore.Model{BrandID: AuthorityID, Name: ModelName, KeypairID: keypairs[0].ID, KeypairIDUser: keypairs[0].ID}
+	if _, _, err := db.CreateAllowedModel(ctx, model, datastore.User{}); err != nil {
+		return err
+	}
+
+	_, err = db.CreateUser(ctx, datastore.User{Username: Username, Name: "Steven Vault", Email: "sv@example.com", Role: datastore.Superuser, Active: true, Accounts: []datastore.Account{account}})
+	return err
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastoretest_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/datastore/datastoretest"
+)
+
+func TestOpen(t *testing.T) {
+	saved := datastore.Environ
+
+	db, err := datastoretest.New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer db.Close()
+
+	restore, err := datastoretest.Open(db, config.Settings{KeyStoreType: "filesystem"})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if datastore.Environ.KeypairDB == nil {
+		t.Error("Open() expected the in-memory keystore")
+	}
+	if !datastore.InFactory() {
+		t.Error("Open() expected the driver of the in-memory Datastore")
+	}
+
+	ctx := context.Background()
+	account, err := datastore.Environ.DB.GetAccount(ctx, datastoretest.AuthorityID)
+	if err != nil || account.ID == 0 {
+		t.Errorf("GetAccount() = %v, %v", account, err)
+	}
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, datastore.User{}, datastore.ModelFilter{})
+	if err != nil || len(models) != 1 || models[0].Name != datastoretest.ModelName || models[0].KeyID != datastoretest.KeyID {
+		t.Errorf("ListAllowedModels() = %v, %v", models, err)
+	}
+	user, err := datastore.Environ.DB.GetUserByUsername(ctx, datastoretest.Username)
+	if err != nil || user.Role != datastore.Superuser {
+		t.Errorf("GetUserByUsername() = %v, %v", user, err)
+	}
+
+	// The writes are stored in the database
+	signLog := datastore.SigningLog{Make: datastoretest.AuthorityID, Model: datastoretest.ModelName, SerialNumber: "A1", Fingerprint: "fp-A1"}
+	if err := datastore.Environ.DB.CreateSigningLog(ctx, signLog); err != nil {
+		t.Fatalf("CreateSigningLog() error = %v", err)
+	}
+	if duplicate, _, err := datastore.Environ.DB.CheckForDuplicate(ctx, &signLog); err != nil || !duplicate {
+		t.Errorf("CheckForDuplicate() = %v, %v", duplicate, err)
+	}
+
+	restore()
+	if datastore.Environ != saved {
+		t.Error("expected the previous environment to be restored")
+	}
+}
+
+func TestNewDatabases(t *testing.T) {
+	first, err := datastoretest.New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer first.Close()
+	second, err := datastoretest.New()
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	defer second.Close()
+
+	// Each Datastore has its own database
+	restore, err := datastoretest.Open(first, config.Settings{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer restore()
+	if err := first.CreateAccount(context.Background(), datastore.Account{AuthorityID: "alder", Assertion: "assertion"}); err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	if _, err := second.GetAccount(context.Background(), "alder"); err == nil {
+		t.Error("GetAccount() expected the account to be in the first database only")
+	}
+}
+
+func TestNewFailing(t *testing.T) {
+	restore, err := datastoretest.Open(datastoretest.NewFailing(), config.Settings{})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	defer restore()
+
+	if _, err := datastore.Environ.DB.GetAccount(context.Background(), "system"); err == nil {
+		t.Error("GetAccount() expected an error")
+	}
+}
@@ -21,6 +21,7 @@
 package datastore
 
 import (
+	"context"
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
@@ -41,7 +42,7 @@ type DatabaseKeypairOperator struct{}
 //  * Encrypt the auth-key and store in the database
 func (dbStore *DatabaseKeypairOperator) ImportKeypair(authorityID, keyID, base64PrivateKey string) (string, error) {
 	// Generate an HMAC hash of the auth-key
-	authKeyHash, err := dbStore.generateEncryptionKey(authorityID, keyID)
+	authKeyHash, err := dbStore.generateEncryptionKey(context.Background(), authorityID, keyID)
 	if err != nil {
 		return "", err
 	}
@@ -55,7 +56,7 @@ func (dbStore *DatabaseKeypairOperator) ImportKeypair(authorityID, keyID, base64
 	return base64SealedSigningkey, err
 }
 
-func (dbStore *DatabaseKeypairOperator) generateEncryptionKey(authorityID, keyID string) (string, error) {
+func (dbStore *DatabaseKeypairOperator) generateEncryptionKey(ctx context.Context, authorityID, keyID string) (string, error) {
 	encryptionKey, err := generateEncryptionKey(authorityID, keyID, Environ.Config.KeyStoreSecret)
 	if err != nil {
 		return "", err
@@ -69,7 +70,7 @@ func (dbStore *DatabaseKeypairOperator) generateEncryptionKey(authorityID, keyID
 
 	// Encrypt the HMAC-ed auth-key for storage
 	base64AuthKeyHash := base64.StdEncoding.EncodeToString([]byte(encryptedAuthKeyHash))
-	Environ.DB.PutSetting(Setting{Code: crypt.GenerateAuthKey(authorityID, keyID), Data: base64AuthKeyHash})
+	Environ.DB.PutSetting(ctx, Setting{Code: crypt.GenerateAuthKey(authorityID, keyID), Data: base64AuthKeyHash})
 
 	return string(encryptionKey[:]), nil
 }
@@ -79,10 +80,10 @@ func (dbStore *DatabaseKeypairOperator) generateEncryptionKey(authorityID, keyID
 //  * Decrypt the signing key
 //  * Load into memory store
 func (dbStore *DatabaseKeypairOperator) UnsealKeypair(authorityID string, keyID string, base64SealedSigningKey string) error {
-	return unsealKeypair(authorityID, keyID, base64SealedSigningKey)
+	return unsealKeypair(context.Background(), authorityID, keyID, base64SealedSigningKey)
 }
 
-func unsealKeypair(authorityID string, keyID string, base64SealedSigningKey string) error {
+func unsealKeypair(ctx context.Context, authorityID string, keyID string, base64SealedSigningKey string) error {
 
 	// Check if we have already unsealed the key into the memory store
 	_, err := keypairDB.PublicKey(keyID)
@@ -90,23 +91,23 @@ func unsealKeypair(authorityID string, keyID string, base64SealedSigningKey stri
 	if err != nil {
 		// The key has not been unsealed and stored in the memory store
 
-		base64SigningKey, err := decryptKeypair(authorityID, keyID, base64SealedSigningKey)
+		base64SigningKey, err := decryptKeypair(ctx, authorityID, keyID, base64SealedSigningKey)
 		if err != nil {
-			log.Println("Could not decrypt the signing-key")
+			log.Error("Could not decrypt the signing-key")
 			return err
 		}
 
 		// Convert the byte array to an asserts key
 		privateKey, errorCode, err := crypt.DeserializePrivateKey(string(base64SigningKey[:]))
 		if err != nil {
-			log.Printf("Error generating the asserts private-key: %v", errorCode)
+			log.Errorf("Error generating the asserts private-key: %v", errorCode)
 			return err
 		}
 
 		// Add the private-key to the memory keypair store
 		err = keypairDB.ImportKey(privateKey)
 		if err != nil {
-			log.Println("Error importing the private-key to memory store")
+			log.Error("Error importing the private-key to memory store")
 			return err
 		}
 
@@ -116,11 +117,11 @@ func unsealKeypair(authorityID string, keyID string, base64SealedSigningKey stri
 }
 
 // ReEncryptKeypair unseals the existing private key and re-encrypts it with the new secret
-var ReEncryptKeypair = func(keypair Keypair, newSecret string) (string, string, error) {
+var ReEncryptKeypair = func(ctx context.Context, keypair Keypair, newSecret string) (string, string, error) {
 
 	// Decrypt the sealed key
 	// The existing signing-key in the database is encrypted, so it needs to be decrypted
-	base64SigningKey, err := decryptKeypair(keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
+	base64SigningKey, err := decryptKeypair(ctx, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
 		return "", "", err
 	}
@@ -157,37 +158,37 @@ var ReEncryptKeypair = func(keypair Keypair, newSecret string) (string, string,
 	return base64SealedSigningkey, base64AuthKeyHash, nil
 }
 
-func decryptKeypair(authorityID, keyID, base64SealedSigningKey string) ([]byte, error) {
+func decryptKeypair(ctx context.Context, authorityID, keyID, base64SealedSigningKey string) ([]byte, error) {
 	// Decode and decrypt the auth-key
-	authKeySetting, err := Environ.DB.GetSetting(crypt.GenerateAuthKey(authorityID, keyID))
+	authKeySetting, err := Environ.DB.GetSetting(ctx, crypt.GenerateAuthKey(authorityID, keyID))
 	if err != nil {
-		log.Println("Cannot find the auth-key for the signing-key")
+		log.Error("Cannot find the auth-key for the signing-key")
 		return nil, err
 	}
 
 	// Decode the auth-key from storage
 	encryptedAuthKey, err := base64.StdEncoding.DecodeString(authKeySetting.Data)
 	if err != nil {
-		log.Println("Could not decode the auth-key for the signing-key")
+		log.Error("Could not decode the auth-key for the signing-key")
 		return nil, err
 	}
 
 	// Decrypt the decoded auth-key
 	authKey, err := crypt.DecryptKey(encryptedAuthKey, Environ.Config.KeyStoreSecret)
 	if err != nil {
-		log.Println("Could not decrypt the auth-key for the signing-key")
+		log.Error("Could not decrypt the auth-key for the signing-key")
 		return nil, err
 	}
 
 	// Decode and decrypt the signing-key
 	sealedSigningKey, err := base64.StdEncoding.DecodeString(base64SealedSigningKey)
 	if err != nil {
-		log.Println("Could not decode the signing-key")
+		log.Error("Could not decode the signing-key")
 		return nil, err
 	}
 	base64SigningKey, err := crypt.DecryptKey(sealedSigningKey, string(authKey[:]))
 	if err != nil {
-		log.Println("Could not decrypt the signing-key")
+		log.Error("Could not decrypt the signing-key")
 		return nil, err
 	}
 
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"encoding/base64"
 	"io/ioutil"
 	"testing"
@@ -52,7 +53,7 @@ func TestDatabaseGenerateEncryptionKey(t *testing.T) {
 
 	dbOperator := DatabaseKeypairOperator{}
 
-	hashedAuthKey, err := dbOperator.generateEncryptionKey("System", "12345678abcdef")
+	hashedAuthKey, err := dbOperator.generateEncryptionKey(context.Background(), "System", "12345678abcdef")
 	if err != nil {
 		t.Errorf("Error encrypting the auth-key: %v", err)
 	}
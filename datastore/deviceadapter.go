@@ -0,0 +1,44 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "context"
+import "errors"
+
+// ListAllowedDevices returns the devices with the serial number that the user is authorized to see
+func (db *DB) ListAllowedDevices(ctx context.Context, serialNumber string, authorization User) ([]Device, error) {
+	if err := validateNotEmpty("Serial-number", serialNumber); err != nil {
+		return nil, errors.New("The serial number must be provided")
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listAllDevices(ctx, serialNumber)
+	case Admin:
+		return db.listDevicesFilteredByUser(ctx, serialNumber, authorization.Username)
+	case SubstoreAdmin:
+		return db.listDevicesForSubstoreUser(ctx, serialNumber, authorization.Username)
+	default:
+		return []Device{}, nil
+	}
+}
@@ -0,0 +1,211 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Pivot status of a device
+const (
+	PivotNone      = "none"      // there is no sub-store mapping for the device
+	PivotAvailable = "available" // signed for the original model, with a sub-store mapping
+	PivotPivoted   = "pivoted"   // signed for the pivoted model of the sub-store
+)
+
+// Revocation state of a device
+const (
+	DeviceActive       = "active"        // the signing-key of the model is active
+	DeviceKeyDisabled  = "key-disabled"  // the signing-key of the model has been disabled
+	DeviceModelDeleted = "model-deleted" // the model of the device no longer exists
+)
+
+// The device registry is built from the signing logs, joined with the model that signed
+// the serial assertion: the original model (m) or the sub-store model that was pivoted to (sp).
+// A deleted model is not joined, so its devices are shown as deleted, and a deleted sub-store
+// model is not available to pivot to
+const listDevicesSQL = `
+	SELECT s.id, s.make, s.model, s.serial_number, s.fingerprint, s.created, s.revision,
+		COALESCE(s.timestamp_source, 'system'),
+		COALESCE(m.id, 0), COALESCE(k.active, false), COALESCE(so.store, ''), COALESCE(so.model_name, ''),
+		COALESCE(fm.id, 0), COALESCE(kp.active, false), COALESCE(sp.store, ''), COALESCE(fm.name, '')
+	FROM signinglog s
+	LEFT JOIN model m ON m.brand_id=s.make AND m.name=s.model AND m.deleted_at IS NULL
+	LEFT JOIN keypair k ON k.id=m.keypair_id
+	LEFT JOIN substore so ON so.from_model_id=m.id AND s.serial_number IN (so.serial_number, so.serial_hash) AND so.deleted_at IS NULL
+	LEFT JOIN (substore sp INNER JOIN model fm ON fm.id=sp.from_model_id)
+		ON fm.brand_id=s.make AND sp.model_name=s.model AND s.serial_number IN (sp.serial_number, sp.serial_hash)
+	LEFT JOIN keypair kp ON kp.id=fm.keypair_id
+	WHERE s.serial_number=$1`
+
+const listDevicesOrderSQL = `
+	ORDER BY s.id DESC`
+
+const listDevicesFilteredByUserSQL = listDevicesSQL + `
+	AND EXISTS(
+		SELECT * FROM account acc
+		INNER JOIN useraccountlink ua on ua.account_id=acc.id
+		INNER JOIN userinfo u on ua.user_id=u.id
+		WHERE acc.authority_id=s.make and u.username=$2` + accountRoleAdminSQL + `
+	)` + listDevicesOrderSQL
+
+const listDevicesForSubstoreUserSQL = listDevicesSQL + `
+	AND EXISTS(` + substoreUserDeviceFilterSQL + ` AND u.username=$2)` + listDevicesOrderSQL
+
+// Device is the registry entry of a device, from the latest serial assertion that was signed for it
+type Device struct {
+	Brand           string    `json:"brand"`
+	Model           string    `json:"model"`
+	SerialNumber    string    `json:"serialnumber"`
+	Fingerprint     string    `json:"fingerprint"`
+	Revision        int       `json:"revision"`
+	Signed          time.Time `json:"signed"`
+	TimestampSource string    `json:"timestampsource"`
+	Substore        string    `json:"substore"`
+	PivotStatus     string    `json:"pivotstatus"`
+	PivotModel      string    `json:"pivotmodel"` // the pivoted model, or the original model of a pivoted device
+	RevocationState string    `json:"revocation"`
+}
+
+// deviceRow holds the signing log and the model details of the device from the database
+type deviceRow struct {
+	id            int
+	device        Device
+	modelID       int
+	keyActive     bool
+	substore      string
+	substoreModel string
+	fromModelID   int
+	fromKeyActive bool
+	pivotStore    string
+	fromModelName string
+}
+
+func (db *DB) listAllDevices(ctx context.Context, serialNumber string) ([]Device, error) {
+	return db.listDevices(ctx, listDevicesSQL+listDevicesOrderSQL, serialNumber)
+}
+
+func (db *DB) listDevicesFilteredByUser(ctx context.Context, serialNumber, username string) ([]Device, error) {
+	return db.listDevices(ctx, listDevicesFilteredByUserSQL, serialNumber, username)
+}
+
+func (db *DB) listDevicesForSubstoreUser(ctx context.Context, serialNumber, username string) ([]Device, error) {
+	return db.listDevices(ctx, listDevicesForSubstoreUserSQL, serialNumber, username)
+}
+
+// listDevices lists the devices of the serial number, which is hashed in the signing logs of the
+// accounts that hash the serial numbers. The device shows the serial number rather than its hash
+func (db *DB) listDevices(ctx context.Context, query, serialNumber string, args ...interface{}) ([]Device, error) {
+	values, err := storedSerialNumbers(ctx, db.QueryContext, serialNumber)
+	if err != nil {
+		log.Errorf("Error retrieving devices: %v", err)
+		return nil, fmt.Errorf("error retrieving devices: %v", err)
+	}
+
+	found := []deviceRow{}
+	for _, v := range values {
+		rows, err := db.queryDeviceRows(ctx, query, append([]interface{}{v}, args...)...)
+		if err != nil {
+			return nil, err
+		}
+		found = append(found, rows...)
+	}
+
+	// The latest log is the first for the brand and model
+	sort.SliceStable(found, func(i, j int) bool { return found[i].id > found[j].id })
+
+	devices := []Device{}
+	seen := map[string]bool{}
+	for _, r := range found {
+		key := r.device.Brand + "/" + r.device.Model
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+
+		r.device.SerialNumber = serialNumber
+		devices = append(devices, r.toDevice())
+	}
+
+	return devices, nil
+}
+
+func (db *DB) queryDeviceRows(ctx context.Context, query string, args ...interface{}) ([]deviceRow, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		log.Errorf("Error retrieving devices: %v", err)
+		return nil, fmt.Errorf("error retrieving devices: %v", err)
+	}
+	defer rows.Close()
+
+	found := []deviceRow{}
+	for rows.Next() {
+		r := deviceRow{}
+		err := rows.Scan(&r.id, &r.device.Brand, &r.device.Model, &r.device.SerialNumber, &r.device.Fingerprint, &r.device.Signed,
+			&r.device.Revision, &r.device.TimestampSource, &r.modelID, &r.keyActive, &r.substore, &r.substoreModel,
+			&r.fromModelID, &r.fromKeyActive, &r.pivotStore, &r.fromModelName)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving devices: %v", err)
+		}
+		found = append(found, r)
+	}
+
+	return found, rows.Err()
+}
+
+// toDevice sets the pivot status and revocation state of the device from the model details
+func (r deviceRow) toDevice() Device {
+	device := r.device
+
+	switch {
+	case r.fromModelID > 0:
+		device.PivotStatus = PivotPivoted
+		device.Substore = r.pivotStore
+		device.PivotModel = r.fromModelName
+		device.RevocationState = revocationState(true, r.fromKeyActive)
+	case len(r.substore) > 0:
+		device.PivotStatus = PivotAvailable
+		device.Substore = r.substore
+		device.PivotModel = r.substoreModel
+		device.RevocationState = revocationState(r.modelID > 0, r.keyActive)
+	default:
+		device.PivotStatus = PivotNone
+		device.RevocationState = revocationState(r.modelID > 0, r.keyActive)
+	}
+
+	return device
+}
+
+func revocationState(modelExists, keyActive bool) string {
+	switch {
+	case !modelExists:
+		return DeviceModelDeleted
+	case !keyActive:
+		return DeviceKeyDisabled
+	default:
+		return DeviceActive
+	}
+}
@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import "testing"
+
+func TestDeviceRowToDevice(t *testing.T) {
+	tests := []struct {
+		name       string
+		row        deviceRow
+		pivot      string
+		substore   string
+		pivotModel string
+		revocation string
+	}{
+		{"original", deviceRow{modelID: 1, keyActive: true}, PivotNone, "", "", DeviceActive},
+		{"key disabled", deviceRow{modelID: 1, keyActive: false}, PivotNone, "", "", DeviceKeyDisabled},
+		{"model deleted", deviceRow{}, PivotNone, "", "", DeviceModelDeleted},
+		{"pivot available", deviceRow{modelID: 1, keyActive: true, substore: "mybrand", substoreModel: "alder-mybrand"}, PivotAvailable, "mybrand", "alder-mybrand", DeviceActive},
+		{"pivoted", deviceRow{fromModelID: 1, fromKeyActive: true, pivotStore: "mybrand", fromModelName: "alder"}, PivotPivoted, "mybrand", "alder", DeviceActive},
+		{"pivoted key disabled", deviceRow{fromModelID: 1, pivotStore: "mybrand", fromModelName: "alder"}, PivotPivoted, "mybrand", "alder", DeviceKeyDisabled},
+	}
+
+	for _, tt := range tests {
+		device := tt.row.toDevice()
+		if device.PivotStatus != tt.pivot {
+			t.Errorf("%s: expected pivot status %q, got %q", tt.name, tt.pivot, device.PivotStatus)
+		}
+		if device.Substore != tt.substore {
+			t.Errorf("%s: expected sub-store %q, got %q", tt.name, tt.substore, device.Substore)
+		}
+		if device.PivotModel != tt.pivotModel {
+			t.Errorf("%s: expected pivot model %q, got %q", tt.name, tt.pivotModel, device.PivotModel)
+		}
+		if device.RevocationState != tt.revocation {
+			t.Errorf("%s: expected revocation state %q, got %q", tt.name, tt.revocation, device.RevocationState)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import "context"
+
+// GetAllowedAccountHMAC returns whether the reseller requests of the account are signed, if the user
+// is authorized to see it. The secret itself is not returned
+func (db *DB) GetAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return AccountHMAC{}, err
+	}
+	return db.getAccountHMAC(ctx, accountID)
+}
+
+// RotateAllowedAccountHMAC generates a new HMAC secret for the account, if the user is authorized to do
+// it. The previous secret is replaced straight away, so the reseller must be updated with the new one
+func (db *DB) RotateAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return AccountHMAC{}, err
+	}
+	return db.rotateAccountHMAC(ctx, accountID)
+}
+
+// DeleteAllowedAccountHMAC removes the HMAC secret of the account, if the user is authorized to do it.
+// The reseller requests of the account are then authenticated by the API key only
+func (db *DB) DeleteAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) error {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return err
+	}
+	return db.deleteAccountHMAC(ctx, accountID)
+}
@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// The HMAC secret of an account, which signs the requests of the reseller API
+const createAccountHMACTableSQL = `
+	CREATE TABLE IF NOT EXISTS accounthmac (
+		id               serial primary key not null,
+		account_id       int references account not null unique,
+		secret           varchar(200) not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+// The signatures of the reseller API requests that have been used, until they expire, so a
+// signed request cannot be replayed
+const createRequestSignatureTableSQL = `
+	CREATE TABLE IF NOT EXISTS requestsignature (
+		signature        varchar(64) primary key not null,
+		expires          int not null
+	)
+`
+
+const getAccountHMACSQL = "SELECT account_id, created FROM accounthmac WHERE account_id=$1"
+
+const getAccountHMACSecretSQL = `
+	SELECT h.secret
+	FROM accounthmac h
+	INNER JOIN account a ON a.id=h.account_id
+	WHERE a.authority_id=$1`
+
+const upsertAccountHMACSQL = `
+	WITH upsert AS (
+		update accounthmac set secret=$2, created=current_timestamp
+		where account_id=$1
+		RETURNING *
+	)
+	insert into accounthmac (account_id,secret)
+	select $1, $2
+	where not exists (select * from upsert)
+`
+
+// sqlite3 syntax of the upsert, inserting the secret when it is not updated
+const upsertAccountHMACSQLite = `
+	UPDATE accounthmac SET secret=$2, created=current_timestamp WHERE account_id=$1;
+	INSERT INTO accounthmac (account_id,secret) SELECT $1, $2 WHERE changes()=0
+`
+
+const deleteAccountHMACSQL = "DELETE FROM accounthmac WHERE account_id=$1"
+
+const createRequestSignatureSQL = "INSERT INTO requestsignature (signature, expires) VALUES ($1,$2) ON CONFLICT DO NOTHING"
+const deleteExpiredRequestSignatureSQL = "DELETE FROM requestsignature WHERE expires<$1"
+
+// hmacSecretLength is the number of random bytes of a generated HMAC secret
+const hmacSecretLength = 32
+
+// AccountHMAC holds the HMAC secret of an account. The secret is only returned when it is generated
+type AccountHMAC struct {
+	AccountID int       `json:"accountID"`
+	Enabled   bool      `json:"enabled"`
+	Secret    string    `json:"secret,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// CreateAccountHMACTable creates the database tables for the HMAC secrets of the accounts and
+// the used request signatures
+func (db *DB) CreateAccountHMACTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createAccountHMACTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createRequestSignatureTableSQL)
+	return err
+}
+
+// GetAccountHMACSecret returns the HMAC secret of the account, or sql.ErrNoRows when the
+// requests of the account are not signed
+func (db *DB) GetAccountHMACSecret(ctx context.Context, authorityID string) (string, error) {
+	var secret string
+	err := db.QueryRowContext(ctx, getAccountHMACSecretSQL, authorityID).Scan(&secret)
+	return secret, err
+}
+
+// UseRequestSignature records a request signature until it expires. An error is returned when
+// the signature has already been used
+func (db *DB) UseRequestSignature(ctx context.Context, signature string, expires time.Time) error {
+	result, err := db.ExecContext(ctx, createRequestSignatureSQL, signature, expires.Unix())
+	if err != nil {
+		return fmt.Errorf("error recording the request signature: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("error recording the request signature: %v", err)
+	}
+	if rows == 0 {
+		return fmt.Errorf("the request signature has already been used")
+	}
+	return nil
+}
+
+// DeleteExpiredRequestSignatures removes the request signatures that have expired, as the
+// requests are rejected by their timestamp
+func (db *DB) DeleteExpiredRequestSignatures(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, deleteExpiredRequestSignatureSQL, time.Now().Unix())
+	if err != nil {
+		return fmt.Errorf("error deleting the expired request signatures: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) getAccountHMAC(ctx context.Context, accountID int) (AccountHMAC, error) {
+	h := AccountHMAC{AccountID: accountID}
+	err := db.QueryRowContext(ctx, getAccountHMACSQL, accountID).Scan(&h.AccountID, &h.Created)
+	switch {
+	case err == sql.ErrNoRows:
+		return h, nil
+	case err != nil:
+		return h, fmt.Errorf("error retrieving the account HMAC secret: %v", err)
+	}
+	h.Enabled = true
+	return h, nil
+}
+
+func (db *DB) rotateAccountHMAC(ctx context.Context, accountID int) (AccountHMAC, error) {
+	secret, err := random.GenerateRandomString(hmacSecretLength)
+	if err != nil {
+		return AccountHMAC{}, fmt.Errorf("error generating the account HMAC secret: %v", err)
+	}
+
+	if _, err := db.ExecContext(ctx, upsertAccountHMACSQL, accountID, secret); err != nil {
+		return AccountHMAC{}, fmt.Errorf("error updating the account HMAC secret: %v", err)
+	}
+	return AccountHMAC{AccountID: accountID, Enabled: true, Secret: secret, Created: time.Now().UTC()}, nil
+}
+
+func (db *DB) deleteAccountHMAC(ctx context.Context, accountID int) error {
+	if _, err := db.ExecContext(ctx, deleteAccountHMACSQL, accountID); err != nil {
+		return fmt.Errorf("error deleting the account HMAC secret: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// InvitationValidity is the time that an invitation can be accepted
+const InvitationValidity = 7 * 24 * time.Hour
+
+// invitedUsernameLength is the length of the random part of the placeholder username
+const invitedUsernameLength = 16
+
+// CreateInvitation validates and creates the invitation of a pending user, with the role and
+// the accounts of the user. The name of the user defaults to the email
+func (db *DB) CreateInvitation(ctx context.Context, inv Invitation, user User) (Invitation, error) {
+	user, err := newInvitedUser(inv, user)
+	if err != nil {
+		return inv, err
+	}
+	inv.Expires = time.Now().Add(InvitationValidity)
+
+	return db.createInvitation(ctx, inv, user)
+}
+
+// newInvitedUser validates the pending user of an invitation, with a placeholder username
+func newInvitedUser(inv Invitation, user User) (User, error) {
+	if err := validateUserEmail(inv.Email); err != nil {
+		return user, err
+	}
+	if len(inv.InvitedBy) == 0 {
+		return user, errors.New("The invitation must have the user that invited")
+	}
+
+	suffix, err := random.GenerateRandomString(invitedUsernameLength)
+	if err != nil {
+		return user, errors.New("Error in generating the username of the invited user")
+	}
+	user.Username = InvitedUsernamePrefix + strings.ToLower(suffix)
+	user.Email = inv.Email
+	if len(user.Name) == 0 {
+		user.Name = inv.Email
+	}
+
+	user.APIKey, err = buildValidOrDefaultAPIKey("")
+	if err != nil {
+		return user, errors.New("Error in generating a valid API key")
+	}
+
+	return user, validateUser(user)
+}
@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The invitations of the users. The invited user is created as a pending user, with a
+// placeholder username, and gets the username of the first OpenID login with the invitation
+const createInvitationTableSQL = `
+	CREATE TABLE IF NOT EXISTS invitation (
+		id               serial primary key not null,
+		user_id          int references userinfo on delete cascade not null,
+		email            varchar(200) not null,
+		invited_by       varchar(200) not null,
+		expires          timestamp not null,
+		accepted         timestamp,
+		created          timestamp default current_timestamp
+	)
+`
+
+const createInvitationSQL = "INSERT INTO invitation (user_id, email, invited_by, expires) VALUES ($1,$2,$3,$4) RETURNING id"
+
+const getPendingInvitationSQL = `
+	SELECT user_id FROM invitation
+	WHERE id=$1 AND accepted IS NULL AND expires > current_timestamp
+	FOR UPDATE`
+
+const acceptInvitationSQL = "UPDATE invitation SET accepted=current_timestamp WHERE id=$1"
+const activateInvitedUserSQL = "UPDATE userinfo SET username=$2, name=$3, email=$4 WHERE id=$1"
+
+// InvitedUsernamePrefix is the prefix of the placeholder username of a pending user
+const InvitedUsernamePrefix = "invite:"
+
+// Invitation is the invitation of a pending user
+type Invitation struct {
+	ID        int        `json:"id"`
+	UserID    int        `json:"userID"`
+	Email     string     `json:"email"`
+	InvitedBy string     `json:"invitedBy"`
+	Expires   time.Time  `json:"expires"`
+	Accepted  *time.Time `json:"accepted"`
+	Created   time.Time  `json:"created"`
+}
+
+// IsPendingUser checks whether the user is invited and has not logged in yet
+func IsPendingUser(user User) bool {
+	return strings.HasPrefix(user.Username, InvitedUsernamePrefix)
+}
+
+// CreateInvitationTable creates the database table for the user invitations
+func (db *DB) CreateInvitationTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createInvitationTableSQL)
+	return err
+}
+
+// createInvitation creates the pending user, with its accounts, and the invitation
+func (db *DB) createInvitation(ctx context.Context, inv Invitation, user User) (Invitation, error) {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, createUserSQL, user.Username, user.Name, user.Email, user.Role, user.APIKey).Scan(&inv.UserID)
+		if err != nil {
+			log.Errorf("Error creating the invited user %v: %v", user.Email, err)
+			return err
+		}
+
+		if err = db.putUserAccounts(ctx, inv.UserID, user.Accounts, user.AccountRoles, tx); err != nil {
+			log.Errorf("Error creating the invited user %v: %v", user.Email, err)
+			return err
+		}
+
+		return tx.QueryRowContext(ctx, createInvitationSQL, inv.UserID, inv.Email, inv.InvitedBy, inv.Expires).Scan(&inv.ID)
+	})
+	if err != nil {
+		return inv, fmt.Errorf("error creating the invitation: %v", err)
+	}
+	return inv, nil
+}
+
+// AcceptInvitation activates the pending user of an invitation, that has not been accepted
+// and has not expired, with the username, name and email of the OpenID login
+func (db *DB) AcceptInvitation(ctx context.Context, invitationID int, user User) (User, error) {
+	if err := validateUser(user); err != nil {
+		return User{}, err
+	}
+
+	var userID int
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, getPendingInvitationSQL, invitationID).Scan(&userID); err != nil {
+			return errors.New("The invitation is invalid, expired or has already been accepted")
+		}
+
+		if _, err := tx.ExecContext(ctx, activateInvitedUserSQL, userID, user.Username, user.Name, user.Email); err != nil {
+			return fmt.Errorf("error activating the invited user: %v", err)
+		}
+
+		_, err := tx.ExecContext(ctx, acceptInvitationSQL, invitationID)
+		return err
+	})
+	if err != nil {
+		return User{}, err
+	}
+
+	return db.GetUser(ctx, userID)
+}
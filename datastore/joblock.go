@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"hash/fnv"
+)
+
+// Names of the advisory locks of the background jobs. The export and the retention purge of the
+// signing logs share a lock, so the logs are not deleted while they are being exported
+const (
+	JobLockSigningLog = "signinglog"
+)
+
+const tryJobLockSQL = "SELECT pg_try_advisory_lock($1)"
+const unlockJobLockSQL = "SELECT pg_advisory_unlock($1)"
+
+// RunJobLocked runs the job holding the advisory lock of the name, so the job does not run at the same
+// time on the other instances of the service. It returns false, without running the job, when the lock
+// is held elsewhere. The factory and CockroachDB have no advisory locks, so the job is always run there
+func (db *DB) RunJobLocked(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error) {
+	if InFactory() || InCockroach() {
+		return true, run(ctx)
+	}
+
+	// The session lock belongs to the connection, so the connection is kept until the lock is released
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	key := jobLockKey(name)
+	var locked bool
+	if err = conn.QueryRowContext(ctx, tryJobLockSQL, key).Scan(&locked); err != nil {
+		return false, err
+	}
+	if !locked {
+		return false, nil
+	}
+	defer conn.ExecContext(context.Background(), unlockJobLockSQL, key)
+
+	return true, run(ctx)
+}
+
+// jobLockKey returns the key of the advisory lock of the name
+func jobLockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("serial-vault/job/" + name))
+	return int64(h.Sum64())
+}
@@ -18,6 +18,7 @@
 package datastore
 
 import (
+	"context"
 	"encoding/base64"
 	"os/exec"
 
@@ -27,26 +28,26 @@ import (
 )
 
 // GenerateKeypair generates a new passwordless signing-key for signing assertions
-func GenerateKeypair(authorityID, passphrase, keyName string) error {
+func GenerateKeypair(ctx context.Context, authorityID, passphrase, keyName string) error {
 	// Create a new keypair status record to track progress
 	ks := KeypairStatus{AuthorityID: authorityID, KeyName: keyName}
 
-	base64PrivateKey, err := generateKeypair(&ks, passphrase)
+	base64PrivateKey, err := generateKeypair(ctx, &ks, passphrase)
 	if err != nil {
 		return err
 	}
 
-	publicID, sealedPrivateKey, err := importPrivateKey(&ks, base64PrivateKey)
+	publicID, sealedPrivateKey, err := importPrivateKey(ctx, &ks, base64PrivateKey)
 	if err != nil {
 		return err
 	}
 
-	err = storePrivateKey(&ks, publicID, sealedPrivateKey)
+	err = storePrivateKey(ctx, &ks, publicID, sealedPrivateKey)
 	if err != nil {
 		return err
 	}
 
-	err = Environ.DB.DeleteKeypairStatus(ks)
+	err = Environ.DB.DeleteKeypairStatus(ctx, ks)
 	if err != nil {
 		return err
 	}
@@ -55,13 +56,13 @@ func GenerateKeypair(authorityID, passphrase, keyName string) error {
 	manager := asserts.NewGPGKeypairManager()
 	err = manager.Delete(keyName)
 	if err != nil {
-		log.Printf("Error removing temporary key: %v", err)
+		log.Errorf("Error removing temporary key: %v", err)
 	}
 	return err
 }
 
-func generateKeypair(ks *KeypairStatus, passphrase string) (string, error) {
-	id, err := Environ.DB.CreateKeypairStatus(*ks)
+func generateKeypair(ctx context.Context, ks *KeypairStatus, passphrase string) (string, error) {
+	id, err := Environ.DB.CreateKeypairStatus(ctx, *ks)
 	if err != nil {
 		return "", err
 	}
@@ -71,44 +72,44 @@ func generateKeypair(ks *KeypairStatus, passphrase string) (string, error) {
 	manager := asserts.NewGPGKeypairManager()
 	err = manager.Generate(passphrase, ks.KeyName)
 	if err != nil {
-		log.Println("Error fetching the generated key", err)
+		log.Errorf("Error fetching the generated key %v", err)
 		return "", err
 	}
 
 	// Export the ascii-armored GPG key
 	ks.Status = KeypairStatusExporting
-	if err = Environ.DB.UpdateKeypairStatus(*ks); err != nil {
+	if err = Environ.DB.UpdateKeypairStatus(ctx, *ks); err != nil {
 		return "", err
 	}
 	out, err := exec.Command("gpg", "--homedir", "~/.snap/gnupg", "--armor", "--export-secret-key", ks.KeyName).Output()
 	if err != nil {
-		log.Println("Error exporting the generated key", err)
+		log.Errorf("Error exporting the generated key %v", err)
 		return "", err
 	}
 
 	return base64.StdEncoding.EncodeToString(out), nil
 }
 
-func importPrivateKey(ks *KeypairStatus, base64PrivateKey string) (string, string, error) {
+func importPrivateKey(ctx context.Context, ks *KeypairStatus, base64PrivateKey string) (string, string, error) {
 
 	// Store the signing-key in the keypair store using the asserts module
 	ks.Status = KeypairStatusEncrypting
-	if err := Environ.DB.UpdateKeypairStatus(*ks); err != nil {
+	if err := Environ.DB.UpdateKeypairStatus(ctx, *ks); err != nil {
 		return "", "", err
 	}
 	privateKey, sealedPrivateKey, err := Environ.KeypairDB.ImportSigningKey(ks.AuthorityID, base64PrivateKey)
 	if err != nil {
-		log.Printf("Error storing the private key: %v", err)
+		log.Errorf("Error storing the private key: %v", err)
 		return "", "", err
 	}
 
 	return privateKey.PublicKey().ID(), sealedPrivateKey, nil
 }
 
-func storePrivateKey(ks *KeypairStatus, publicID, sealedPrivateKey string) error {
+func storePrivateKey(ctx context.Context, ks *KeypairStatus, publicID, sealedPrivateKey string) error {
 	// Store the sealed signing-key in the database
 	ks.Status = KeypairStatusStoring
-	if err := Environ.DB.UpdateKeypairStatus(*ks); err != nil {
+	if err := Environ.DB.UpdateKeypairStatus(ctx, *ks); err != nil {
 		return err
 	}
 	keypair := Keypair{
@@ -117,9 +118,9 @@ func storePrivateKey(ks *KeypairStatus, publicID, sealedPrivateKey string) error
 		SealedKey:   sealedPrivateKey,
 		KeyName:     ks.KeyName,
 	}
-	_, err := Environ.DB.PutKeypair(keypair)
+	_, err := Environ.DB.PutKeypair(ctx, keypair)
 	if err != nil {
-		log.Printf("Error storing the private key: %v", err)
+		log.Errorf("Error storing the private key: %v", err)
 		return err
 	}
 
@@ -127,10 +128,10 @@ func storePrivateKey(ks *KeypairStatus, publicID, sealedPrivateKey string) error
 }
 
 // CreateKeyName assigns a key name to an existing key
-func CreateKeyName(k Keypair) error {
-	kp, err := Environ.DB.GetKeypairByPublicID(k.AuthorityID, k.KeyID)
+func CreateKeyName(ctx context.Context, k Keypair) error {
+	kp, err := Environ.DB.GetKeypairByPublicID(ctx, k.AuthorityID, k.KeyID)
 	if err != nil {
-		log.Printf("Error fetching the private key: %v", err)
+		log.Errorf("Error fetching the private key: %v", err)
 		return err
 	}
 
@@ -140,12 +141,12 @@ func CreateKeyName(k Keypair) error {
 	if ks.KeyName == "" {
 		ks.KeyName = k.AuthorityID
 	}
-	statusID, err := Environ.DB.CreateKeypairStatus(ks)
+	statusID, err := Environ.DB.CreateKeypairStatus(ctx, ks)
 	if err != nil {
 		return err
 	}
 	ks.ID = statusID
 
 	// Update the status and link to the generated keypair record
-	return Environ.DB.UpdateKeypairStatus(ks)
+	return Environ.DB.UpdateKeypairStatus(ctx, ks)
 }
@@ -20,63 +20,66 @@
 
 package datastore
 
+import "context"
 import "errors"
 
 // ListAllowedKeypairs return the list of keypairs allowed to the user
-func (db *DB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
+func (db *DB) ListAllowedKeypairs(ctx context.Context, authorization User) ([]Keypair, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.listAllKeypairs()
+		return db.listAllKeypairs(ctx)
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listKeypairsFilteredByUser(authorization.Username)
+		return db.listKeypairsFilteredByUser(ctx, authorization.Username)
 	default:
 		return []Keypair{}, nil
 	}
 }
 
 // UpdateAllowedKeypairActive updates active enable/disable flag if user is authorized
-func (db *DB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
+func (db *DB) UpdateAllowedKeypairActive(ctx context.Context, keypairID int, active bool, authorization User) error {
+	defer db.invalidateCache(cacheModels)
+
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.updateKeypairActive(keypairID, active)
+		return db.updateKeypairActive(ctx, keypairID, active)
 	case Admin:
-		return db.updateKeypairActiveFilteredByUser(keypairID, active, authorization.Username)
+		return db.updateKeypairActiveFilteredByUser(ctx, keypairID, active, authorization.Username)
 	default:
 		return nil
 	}
 }
 
 // UpdateKeypairAssertion validates user can update and sets the account-key assertion of a keypair
-func (db *DB) UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error) {
+func (db *DB) UpdateKeypairAssertion(ctx context.Context, keypair Keypair, authorization User) (string, error) {
 
 	err := validateAuthorityID(keypair.AuthorityID)
 	if err != nil {
 		return "invalid-assertion", err
 	}
 
-	err = db.validateAssertionHeaders(keypair)
+	err = db.validateAssertionHeaders(ctx, keypair)
 	if err != nil {
 		return "invalid-assertion", err
 	}
 
 	if authorization.Role == Admin {
 		// Check that the user has permissions for the account
-		if !db.CheckUserInAccount(authorization.Username, keypair.AuthorityID) {
+		if !db.CheckUserInAccount(ctx, authorization.Username, keypair.AuthorityID) {
 			return "error-auth", errors.New("You do not have permissions for that authority")
 		}
 	}
 
-	return "", db.updateKeypairAssertion(keypair.ID, keypair.Assertion)
+	return "", db.updateKeypairAssertion(ctx, keypair.ID, keypair.Assertion)
 }
 
-func (db *DB) validateAssertionHeaders(keypair Keypair) error {
-	oldKeypair, err := db.GetKeypair(keypair.ID)
+func (db *DB) validateAssertionHeaders(ctx context.Context, keypair Keypair) error {
+	oldKeypair, err := db.GetKeypair(ctx, keypair.ID)
 	if err != nil {
 		return err
 	}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"container/list"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// defaultKeypairCacheSize is the number of unsealed signing-keys that are held in memory, when it
+// is not configured
+const defaultKeypairCacheSize = 100
+
+// errKeypairNotCached is returned for a signing-key that has not been unsealed
+var errKeypairNotCached = errors.New("cannot find key pair")
+
+// unsealedKeypairs is the memory store of the unsealed signing-keys of the keystore
+var unsealedKeypairs *keypairCache
+
+// KeypairCacheSize returns the number of unsealed signing-keys that are held in memory from
+// the config settings
+func KeypairCacheSize(settings config.Settings) (int, error) {
+	if settings.KeypairCacheSize < 0 {
+		return 0, fmt.Errorf("the keypair cache size must not be negative")
+	}
+	if settings.KeypairCacheSize == 0 {
+		return defaultKeypairCacheSize, nil
+	}
+	return settings.KeypairCacheSize, nil
+}
+
+// keypairCache is the memory store of the unsealed signing-keys, so a signing-key is unsealed
+// once rather than for each signing request. The store is bounded, and the least recently used
+// key is evicted when it is full
+type keypairCache struct {
+	size  int
+	lock  sync.Mutex
+	keys  map[string]*list.Element
+	order *list.List // most recently used first
+}
+
+func newKeypairCache(size int) *keypairCache {
+	return &keypairCache{size: size, keys: map[string]*list.Element{}, order: list.New()}
+}
+
+// Put stores an unsealed signing-key, replacing the key with the same ID
+func (c *keypairCache) Put(privKey asserts.PrivateKey) error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	keyID := privKey.PublicKey().ID()
+	if e, ok := c.keys[keyID]; ok {
+		e.Value = privKey
+		c.order.MoveToFront(e)
+		return nil
+	}
+
+	c.keys[keyID] = c.order.PushFront(privKey)
+	for c.order.Len() > c.size {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.keys, oldest.Value.(asserts.PrivateKey).PublicKey().ID())
+	}
+	return nil
+}
+
+// Get returns an unsealed signing-key
+func (c *keypairCache) Get(keyID string) (asserts.PrivateKey, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	e, ok := c.keys[keyID]
+	if !ok {
+		return nil, errKeypairNotCached
+	}
+	c.order.MoveToFront(e)
+	return e.Value.(asserts.PrivateKey), nil
+}
+
+// Evict removes an unsealed signing-key, so it is unsealed again on its next use
+func (c *keypairCache) Evict(keyID string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if e, ok := c.keys[keyID]; ok {
+		c.order.Remove(e)
+		delete(c.keys, keyID)
+	}
+}
+
+// Len returns the number of unsealed signing-keys
+func (c *keypairCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	return c.order.Len()
+}
+
+// evictKeypair removes an unsealed signing-key from the keystore, after the keypair is disabled
+// or replaced
+func evictKeypair(keyID string) {
+	if unsealedKeypairs != nil {
+		unsealedKeypairs.Evict(keyID)
+	}
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/snapcore/snapd/asserts"
+)
+
+func generateTestKey(t *testing.T) asserts.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatalf("Error generating a key: %v", err)
+	}
+	return asserts.RSAPrivateKey(key)
+}
+
+func TestKeypairCacheSize(t *testing.T) {
+	tests := []struct {
+		size    int
+		want    int
+		wantErr bool
+	}{
+		{0, defaultKeypairCacheSize, false},
+		{5, 5, false},
+		{-1, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := KeypairCacheSize(config.Settings{KeypairCacheSize: tt.size})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("KeypairCacheSize(%d) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("KeypairCacheSize(%d) = %d, want %d", tt.size, got, tt.want)
+		}
+	}
+}
+
+func TestKeypairCache(t *testing.T) {
+	cache := newKeypairCache(2)
+	key1, key2, key3 := generateTestKey(t), generateTestKey(t), generateTestKey(t)
+
+	for _, k := range []asserts.PrivateKey{key1, key2, key1} {
+		if err := cache.Put(k); err != nil {
+			t.Fatalf("Error storing a key: %v", err)
+		}
+	}
+	if cache.Len() != 2 {
+		t.Errorf("Expected 2 keys, got: %d", cache.Len())
+	}
+
+	// Use the first key, so the second key is the least recently used
+	if _, err := cache.Get(key1.PublicKey().ID()); err != nil {
+		t.Errorf("Expected the first key, got: %v", err)
+	}
+	cache.Put(key3)
+
+	if _, err := cache.Get(key2.PublicKey().ID()); err == nil {
+		t.Error("Expected the second key to be evicted")
+	}
+	for _, k := range []asserts.PrivateKey{key1, key3} {
+		if _, err := cache.Get(k.PublicKey().ID()); err != nil {
+			t.Errorf("Expected the key %s, got: %v", k.PublicKey().ID(), err)
+		}
+	}
+
+	cache.Evict(key1.PublicKey().ID())
+	if _, err := cache.Get(key1.PublicKey().ID()); err == nil {
+		t.Error("Expected the evicted key to be removed")
+	}
+	if cache.Len() != 1 {
+		t.Errorf("Expected 1 key, got: %d", cache.Len())
+	}
+}
+
+func TestGetKeyStoreKeypairCache(t *testing.T) {
+	config := config.Settings{KeyStoreType: "database", KeyStoreSecret: "secret", KeypairCacheSize: 1}
+	Environ = &Env{Config: config}
+
+	if err := OpenKeyStore(config); err != nil {
+		t.Fatalf("Error setting up the database keystore: %v", err)
+	}
+	if unsealedKeypairs == nil || unsealedKeypairs.size != 1 {
+		t.Fatal("Expected the keystore to use the keypair cache")
+	}
+
+	key := generateTestKey(t)
+	if err := Environ.KeypairDB.ImportKey(key); err != nil {
+		t.Fatalf("Error importing the key: %v", err)
+	}
+	if _, err := Environ.KeypairDB.PublicKey(key.PublicKey().ID()); err != nil {
+		t.Errorf("Expected the unsealed key, got: %v", err)
+	}
+
+	evictKeypair(key.PublicKey().ID())
+	if _, err := Environ.KeypairDB.PublicKey(key.PublicKey().ID()); err == nil {
+		t.Error("Expected the key to be evicted")
+	}
+
+	config.KeypairCacheSize = -1
+	if err := OpenKeyStore(config); err == nil {
+		t.Error("Expected an error for an invalid cache size")
+	}
+}
@@ -21,6 +21,7 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
@@ -35,38 +36,39 @@ const createKeypairTableSQL = `
 		active        boolean default true,
 		sealed_key    text,
 		assertion     text default '',
-		key_name      varchar(200) default ''
+		key_name      varchar(200) default '',
+		version       int not null default 1
 	)
 `
 const listKeypairsSQL = `
-	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name
+	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name, k.version
 	FROM keypair k 
 	ORDER BY k.authority_id, k.key_id`
 const listKeypairsForUserSQL = `
-	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name 
+	SELECT k.id, k.authority_id, k.key_id, k.active, k.assertion, k.key_name, k.version 
 	FROM keypair k
 	INNER JOIN account acc ON acc.authority_id=k.authority_id
 	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
 	INNER JOIN userinfo u ON ua.user_id=u.id
-	WHERE u.username=$1
+	WHERE u.username=$1` + accountRoleSyncUserSQL + `
 	ORDER BY k.authority_id, k.key_id`
-const getKeypairSQL = "SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name FROM keypair WHERE id=$1"
-const getKeypairByPublicIDSQL = "SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name FROM keypair WHERE authority_id=$1 AND key_id=$2"
+const getKeypairSQL = "SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name, version FROM keypair WHERE id=$1"
+const getKeypairByPublicIDSQL = "SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name, version FROM keypair WHERE authority_id=$1 AND key_id=$2"
 const getKeypairByNameSQL = `
-	SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name
+	SELECT id, authority_id, key_id, active, sealed_key, assertion, key_name, version
 	FROM keypair
 	WHERE authority_id=$1 AND key_name=$2`
-const toggleKeypairSQL = "UPDATE keypair SET active=$2 WHERE id=$1"
+const toggleKeypairSQL = "UPDATE keypair SET active=$2, version=version+1 WHERE id=$1"
 const toggleKeypairForUserSQL = `
 	UPDATE keypair k
-	SET active=$2
+	SET active=$2, version=k.version+1
 	FROM account acc 
 	INNER JOIN useraccountlink ua ON ua.account_id=acc.id
 	INNER JOIN userinfo u ON ua.user_id=u.id
-	WHERE k.id=$1 AND u.username=$3 AND acc.authority_id=k.authority_id`
+	WHERE k.id=$1 AND u.username=$3 AND acc.authority_id=k.authority_id` + accountRoleAdminSQL
 const upsertKeypairSQL = `
 	WITH upsert AS (
-		UPDATE keypair SET authority_id=$1, key_id=$2, sealed_key=$3, assertion=$4, key_name=$5
+		UPDATE keypair SET authority_id=$1, key_id=$2, sealed_key=$3, assertion=$4, key_name=$5, version=version+1
 		WHERE authority_id=$1 AND key_id=$2
 		RETURNING *
 	)
@@ -75,6 +77,14 @@ const upsertKeypairSQL = `
 	WHERE NOT EXISTS (SELECT * FROM upsert)
 `
 
+// sqlite3 syntax of the upsert, inserting the keypair when it is not updated
+const upsertKeypairSQLite = `
+	UPDATE keypair SET authority_id=$1, key_id=$2, sealed_key=$3, assertion=$4, key_name=$5, version=version+1
+	WHERE authority_id=$1 AND key_id=$2;
+	INSERT INTO keypair (authority_id,key_id,sealed_key,assertion,key_name)
+	SELECT $1, $2, $3, $4, $5 WHERE changes()=0
+`
+
 const checkKeypairKeynameExistsSQL = `
 	select exists(
 		select * from keypair where authority_id=$1 and key_name=$2
@@ -88,7 +98,12 @@ const syncUpsertKeypairSQL = `
 	VALUES ($1, $2, $3, $4, $5, $6, $7)
 `
 
-const updateKeypairSQL = "UPDATE keypair SET assertion=$2 WHERE id=$1"
+const updateKeypairSQL = "UPDATE keypair SET assertion=$2, version=version+1 WHERE id=$1"
+
+// The update of a keypair that has not been changed since its version was read
+const updateKeypairVersionSQL = `
+	UPDATE keypair SET sealed_key=$3, assertion=$4, key_name=$5, version=version+1
+	WHERE authority_id=$1 AND key_id=$2 AND version=$6`
 
 // Add the assertion field to store the assertion for the account key to the table
 const alterKeypairAddAssertion = "ALTER TABLE keypair ADD COLUMN assertion TEXT DEFAULT ''"
@@ -108,6 +123,9 @@ const updateKeypairKeyNameDefault = `
 	WHERE key_name = ''
 `
 
+// Add the version field, which is incremented by each update of a keypair
+const alterKeypairAddVersion = "ALTER TABLE keypair ADD COLUMN version INT NOT NULL DEFAULT 1"
+
 // Keypair holds the keypair reference details in the local database
 type Keypair struct {
 	ID          int
@@ -117,6 +135,7 @@ type Keypair struct {
 	SealedKey   string
 	Assertion   string
 	KeyName     string
+	Version     int // incremented by each update, to detect conflicting updates
 }
 
 // SyncKeypair is the response to fetch keypairs
@@ -126,26 +145,27 @@ type SyncKeypair struct {
 }
 
 // CreateKeypairTable creates the database table for a keypair.
-func (db *DB) CreateKeypairTable() error {
-	_, err := db.Exec(createKeypairTableSQL)
+func (db *DB) CreateKeypairTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createKeypairTableSQL)
 	return err
 }
 
 // AlterKeypairTable adds extra fields to an existing keypair database table
-func (db *DB) AlterKeypairTable() error {
-	db.Exec(alterKeypairAddAssertion)
-	db.Exec(alterKeypairAddKeyName)
-	db.Exec(updateKeypairKeyNameFromStatus)
-	db.Exec(updateKeypairKeyNameDefault)
+func (db *DB) AlterKeypairTable(ctx context.Context) error {
+	db.ExecContext(ctx, alterKeypairAddAssertion)
+	db.ExecContext(ctx, alterKeypairAddKeyName)
+	db.ExecContext(ctx, updateKeypairKeyNameFromStatus)
+	db.ExecContext(ctx, updateKeypairKeyNameDefault)
+	db.ExecContext(ctx, alterKeypairAddVersion)
 	// Ignore errors as the field may already be added
 	return nil
 }
 
-func (db *DB) listAllKeypairs() ([]Keypair, error) {
-	return db.listKeypairsFilteredByUser(anyUserFilter)
+func (db *DB) listAllKeypairs(ctx context.Context) ([]Keypair, error) {
+	return db.listKeypairsFilteredByUser(ctx, anyUserFilter)
 }
 
-func (db *DB) listKeypairsFilteredByUser(username string) ([]Keypair, error) {
+func (db *DB) listKeypairsFilteredByUser(ctx context.Context, username string) ([]Keypair, error) {
 	var keypairs []Keypair
 
 	var (
@@ -154,19 +174,19 @@ func (db *DB) listKeypairsFilteredByUser(username string) ([]Keypair, error) {
 	)
 
 	if len(username) == 0 {
-		rows, err = db.Query(listKeypairsSQL)
+		rows, err = db.QueryContext(ctx, listKeypairsSQL)
 	} else {
-		rows, err = db.Query(listKeypairsForUserSQL, username)
+		rows, err = db.QueryContext(ctx, listKeypairsForUserSQL, username)
 	}
 	if err != nil {
-		log.Printf("Error retrieving database keypairs: %v\n", err)
+		log.Errorf("Error retrieving database keypairs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		keypair := Keypair{}
-		err := rows.Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.Assertion, &keypair.KeyName)
+		err := rows.Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.Assertion, &keypair.KeyName, &keypair.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -177,12 +197,12 @@ func (db *DB) listKeypairsFilteredByUser(username string) ([]Keypair, error) {
 }
 
 // GetKeypair fetches a single keypair from the database by ID
-func (db *DB) GetKeypair(keypairID int) (Keypair, error) {
+func (db *DB) GetKeypair(ctx context.Context, keypairID int) (Keypair, error) {
 	keypair := Keypair{}
 
-	err := db.QueryRow(getKeypairSQL, keypairID).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName)
+	err := db.QueryRowContext(ctx, getKeypairSQL, keypairID).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName, &keypair.Version)
 	if err != nil {
-		log.Printf("Error retrieving keypair by ID: %v\n", err)
+		log.Errorf("Error retrieving keypair by ID: %v", err)
 		return keypair, err
 	}
 
@@ -190,12 +210,12 @@ func (db *DB) GetKeypair(keypairID int) (Keypair, error) {
 }
 
 // GetKeypairByPublicID fetches a single keypair from the database by public ID
-func (db *DB) GetKeypairByPublicID(authorityID, keyID string) (Keypair, error) {
+func (db *DB) GetKeypairByPublicID(ctx context.Context, authorityID, keyID string) (Keypair, error) {
 	keypair := Keypair{}
 
-	err := db.QueryRow(getKeypairByPublicIDSQL, authorityID, keyID).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName)
+	err := db.QueryRowContext(ctx, getKeypairByPublicIDSQL, authorityID, keyID).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName, &keypair.Version)
 	if err != nil {
-		log.Printf("Error retrieving keypair by ID: %v\n", err)
+		log.Errorf("Error retrieving keypair by ID: %v", err)
 		return keypair, err
 	}
 
@@ -203,12 +223,12 @@ func (db *DB) GetKeypairByPublicID(authorityID, keyID string) (Keypair, error) {
 }
 
 // GetKeypairByName fetches a single keypair from the database by its name
-func (db *DB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
+func (db *DB) GetKeypairByName(ctx context.Context, authorityID, keyName string) (Keypair, error) {
 	keypair := Keypair{}
 
-	err := db.QueryRow(getKeypairByNameSQL, authorityID, keyName).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName)
+	err := db.QueryRowContext(ctx, getKeypairByNameSQL, authorityID, keyName).Scan(&keypair.ID, &keypair.AuthorityID, &keypair.KeyID, &keypair.Active, &keypair.SealedKey, &keypair.Assertion, &keypair.KeyName, &keypair.Version)
 	if err != nil {
-		log.Printf("Error retrieving keypair by name: %v\n", err)
+		log.Errorf("Error retrieving keypair by name: %v", err)
 		return keypair, err
 	}
 
@@ -216,7 +236,9 @@ func (db *DB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
 }
 
 // PutKeypair stores a keypair in the database
-func (db *DB) PutKeypair(keypair Keypair) (string, error) {
+func (db *DB) PutKeypair(ctx context.Context, keypair Keypair) (string, error) {
+	defer db.invalidateCache(cacheModels)
+
 	// Validate the data
 	if !validateStringsNotEmpty(keypair.AuthorityID, keypair.KeyID) {
 		return "error-validate-keypair", errors.New("The Authority ID and the Key ID must be entered")
@@ -226,56 +248,80 @@ func (db *DB) PutKeypair(keypair Keypair) (string, error) {
 		keypair.KeyName = keypair.AuthorityID
 	}
 
-	_, err := db.Exec(upsertKeypairSQL, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keypair.Assertion, keypair.KeyName)
-	if err != nil {
-		log.Printf("Error updating the database keypair: %v\n", err)
+	if keypair.Version > 0 {
+		// The keypair was read from the database, so it is only updated if it has not been changed since
+		result, err := db.ExecContext(ctx, updateKeypairVersionSQL, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keypair.Assertion, keypair.KeyName, keypair.Version)
+		if err != nil {
+			log.Errorf("Error updating the database keypair: %v", err)
+			return "", err
+		}
+		if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+			return "error-keypair-version", ErrVersionConflict
+		}
+	} else if _, err := db.ExecContext(ctx, upsertKeypairSQL, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keypair.Assertion, keypair.KeyName); err != nil {
+		log.Errorf("Error updating the database keypair: %v", err)
 		return "", err
 	}
 
+	// The signing-key is unsealed again, as the keypair may have been replaced
+	evictKeypair(keypair.KeyID)
+
 	return "", nil
 }
 
 // SyncKeypair stores a keypair in the database
-func (db *DB) SyncKeypair(keypair SyncKeypair) error {
+func (db *DB) SyncKeypair(ctx context.Context, keypair SyncKeypair) error {
+	defer db.invalidateCache(cacheModels)
+
 	// Validate the data
 	if !validateStringsNotEmpty(keypair.AuthorityID, keypair.KeyID) {
 		return errors.New("The Authority ID and the Key ID must be entered")
 	}
 
-	_, err := db.Exec(syncUpsertKeypairSQL, keypair.ID, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keypair.Assertion, keypair.Active, keypair.KeyName)
+	_, err := db.ExecContext(ctx, syncUpsertKeypairSQL, keypair.ID, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey, keypair.Assertion, keypair.Active, keypair.KeyName)
 	if err != nil {
-		log.Printf("Error updating the database keypair: %v\n", err)
+		log.Errorf("Error updating the database keypair: %v", err)
 		return err
 	}
 
+	// The signing-key is unsealed again, as the keypair may have been replaced
+	evictKeypair(keypair.KeyID)
+
 	return nil
 }
 
-func (db *DB) updateKeypairActive(keypairID int, active bool) error {
-	return db.updateKeypairActiveFilteredByUser(keypairID, active, anyUserFilter)
+func (db *DB) updateKeypairActive(ctx context.Context, keypairID int, active bool) error {
+	return db.updateKeypairActiveFilteredByUser(ctx, keypairID, active, anyUserFilter)
 }
 
-func (db *DB) updateKeypairActiveFilteredByUser(keypairID int, active bool, username string) error {
+func (db *DB) updateKeypairActiveFilteredByUser(ctx context.Context, keypairID int, active bool, username string) error {
 	var err error
 
 	if len(username) == 0 {
-		_, err = db.Exec(toggleKeypairSQL, keypairID, active)
+		_, err = db.ExecContext(ctx, toggleKeypairSQL, keypairID, active)
 	} else {
-		_, err = db.Exec(toggleKeypairForUserSQL, keypairID, active, username)
+		_, err = db.ExecContext(ctx, toggleKeypairForUserSQL, keypairID, active, username)
 	}
 	if err != nil {
-		log.Printf("Error updating the database keypair: %v\n", err)
+		log.Errorf("Error updating the database keypair: %v", err)
 		return err
 	}
 
+	// Remove the unsealed signing-key of a disabled keypair from memory
+	if !active {
+		if keypair, err := db.GetKeypair(ctx, keypairID); err == nil {
+			evictKeypair(keypair.KeyID)
+		}
+	}
+
 	return nil
 }
 
 // updateKeypairAssertion sets the account-key assertion of a keypair
-func (db *DB) updateKeypairAssertion(keypairID int, assertion string) error {
-	_, err := db.Exec(updateKeypairSQL, keypairID, assertion)
+func (db *DB) updateKeypairAssertion(ctx context.Context, keypairID int, assertion string) error {
+	_, err := db.ExecContext(ctx, updateKeypairSQL, keypairID, assertion)
 	if err != nil {
-		log.Printf("Error updating the database keypair assertion: %v\n", err)
+		log.Errorf("Error updating the database keypair assertion: %v", err)
 		return err
 	}
 
@@ -283,7 +329,7 @@ func (db *DB) updateKeypairAssertion(keypairID int, assertion string) error {
 }
 
 // CheckKeypairKeynameExists validates that there is a keypair for the brand and key name
-func (db *DB) CheckKeypairKeynameExists(authorityID, name string) bool {
-	row := db.QueryRow(checkKeypairKeynameExistsSQL, authorityID, name)
+func (db *DB) CheckKeypairKeynameExists(ctx context.Context, authorityID, name string) bool {
+	row := db.QueryRowContext(ctx, checkKeypairKeynameExistsSQL, authorityID, name)
 	return db.checkBoolQuery(row)
 }
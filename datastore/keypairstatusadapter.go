@@ -19,15 +19,17 @@
 
 package datastore
 
+import "context"
+
 // ListAllowedKeypairStatus return the list of keypairs that are in progress
-func (db *DB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error) {
+func (db *DB) ListAllowedKeypairStatus(ctx context.Context, authorization User) ([]KeypairStatus, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.listAllKeypairStatus()
+		return db.listAllKeypairStatus(ctx)
 	case Admin:
-		return db.listKeypairStatusFilteredByUser(authorization.Username)
+		return db.listKeypairStatusFilteredByUser(ctx, authorization.Username)
 	default:
 		return []KeypairStatus{}, nil
 	}
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -55,7 +56,7 @@ FROM keypairstatus ks
 INNER JOIN account acc on acc.authority_id=ks.authority_id
 INNER JOIN useraccountlink ua on ua.account_id=acc.id
 INNER JOIN userinfo u on ua.user_id=u.id
-WHERE u.username=$1 AND ks.keypair_id IS NULL
+WHERE u.username=$1 AND ks.keypair_id IS NULL` + accountRoleAdminSQL + `
 ORDER BY authority_id, key_name
 `
 
@@ -95,42 +96,42 @@ const (
 )
 
 // CreateKeypairStatusTable creates the database table for a keypair status.
-func (db *DB) CreateKeypairStatusTable() error {
-	_, err := db.Exec(createKeypairStatusTableSQL)
+func (db *DB) CreateKeypairStatusTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createKeypairStatusTableSQL)
 	return err
 }
 
 // AlterKeypairStatusTable adds indexes to the table
-func (db *DB) AlterKeypairStatusTable() error {
+func (db *DB) AlterKeypairStatusTable(ctx context.Context) error {
 	// Create the index on the auth / key
-	_, err := db.Exec(createKeypairStatusAuthKeyIndexSQL)
+	_, err := db.ExecContext(ctx, createKeypairStatusAuthKeyIndexSQL)
 	return err
 }
 
 // CreateKeypairStatus adds a keypair status record to track the generation of a keypair
-func (db *DB) CreateKeypairStatus(ks KeypairStatus) (int, error) {
+func (db *DB) CreateKeypairStatus(ctx context.Context, ks KeypairStatus) (int, error) {
 	// Create the keypair status in the database
 	var createdID int
-	err := db.QueryRow(createKeypairStatusSQL, ks.AuthorityID, ks.KeyName, KeypairStatusCreating).Scan(&createdID)
+	err := db.QueryRowContext(ctx, createKeypairStatusSQL, ks.AuthorityID, ks.KeyName, KeypairStatusCreating).Scan(&createdID)
 	if err != nil {
-		log.Printf("Error creating the keypair status: %v\n", err)
+		log.Errorf("Error creating the keypair status: %v", err)
 	}
 	return createdID, err
 }
 
 // UpdateKeypairStatus updates the status of generating
-func (db *DB) UpdateKeypairStatus(ks KeypairStatus) error {
+func (db *DB) UpdateKeypairStatus(ctx context.Context, ks KeypairStatus) error {
 
 	var err error
 
 	if ks.KeypairID > 0 {
-		_, err = db.Exec(updateKeypairStatusWithIDSQL, ks.AuthorityID, ks.KeyName, ks.KeypairID, ks.Status)
+		_, err = db.ExecContext(ctx, updateKeypairStatusWithIDSQL, ks.AuthorityID, ks.KeyName, ks.KeypairID, ks.Status)
 	} else {
-		_, err = db.Exec(updateKeypairStatusSQL, ks.AuthorityID, ks.KeyName, ks.Status)
+		_, err = db.ExecContext(ctx, updateKeypairStatusSQL, ks.AuthorityID, ks.KeyName, ks.Status)
 	}
 
 	if err != nil {
-		log.Printf("Error updating the keypair status: %v\n", err)
+		log.Errorf("Error updating the keypair status: %v", err)
 	}
 
 	return err
@@ -138,21 +139,21 @@ func (db *DB) UpdateKeypairStatus(ks KeypairStatus) error {
 }
 
 // DeleteKeypairStatus updates the status of generating
-func (db *DB) DeleteKeypairStatus(ks KeypairStatus) error {
-	_, err := db.Exec(deleteKeypairStatusSQL, ks.ID)
+func (db *DB) DeleteKeypairStatus(ctx context.Context, ks KeypairStatus) error {
+	_, err := db.ExecContext(ctx, deleteKeypairStatusSQL, ks.ID)
 	if err != nil {
-		log.Printf("Error deleting the keypair status: %v\n", err)
+		log.Errorf("Error deleting the keypair status: %v", err)
 	}
 	return err
 }
 
 // GetKeypairStatus fetches the keypair status
-func (db *DB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error) {
+func (db *DB) GetKeypairStatus(ctx context.Context, authorityID, keyName string) (KeypairStatus, error) {
 	var keypairID sql.NullInt64
 	ks := KeypairStatus{}
-	err := db.QueryRow(getKeypairStatusSQL, authorityID, keyName).Scan(&ks.ID, &ks.AuthorityID, &ks.KeyName, &keypairID, &ks.Status)
+	err := db.QueryRowContext(ctx, getKeypairStatusSQL, authorityID, keyName).Scan(&ks.ID, &ks.AuthorityID, &ks.KeyName, &keypairID, &ks.Status)
 	if err != nil {
-		log.Printf("Error fetching the keypair status: %v\n", err)
+		log.Errorf("Error fetching the keypair status: %v", err)
 		return ks, err
 	}
 
@@ -163,11 +164,11 @@ func (db *DB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, erro
 	return ks, err
 }
 
-func (db *DB) listAllKeypairStatus() ([]KeypairStatus, error) {
-	return db.listKeypairStatusFilteredByUser(anyUserFilter)
+func (db *DB) listAllKeypairStatus(ctx context.Context) ([]KeypairStatus, error) {
+	return db.listKeypairStatusFilteredByUser(ctx, anyUserFilter)
 }
 
-func (db *DB) listKeypairStatusFilteredByUser(username string) ([]KeypairStatus, error) {
+func (db *DB) listKeypairStatusFilteredByUser(ctx context.Context, username string) ([]KeypairStatus, error) {
 	keypairs := []KeypairStatus{}
 	var keypairID sql.NullInt64
 
@@ -177,12 +178,12 @@ func (db *DB) listKeypairStatusFilteredByUser(username string) ([]KeypairStatus,
 	)
 
 	if len(username) == 0 {
-		rows, err = db.Query(listKeypairStatusProgressSQL)
+		rows, err = db.QueryContext(ctx, listKeypairStatusProgressSQL)
 	} else {
-		rows, err = db.Query(listKeypairStatusProgressForUserSQL, username)
+		rows, err = db.QueryContext(ctx, listKeypairStatusProgressForUserSQL, username)
 	}
 	if err != nil {
-		log.Printf("Error retrieving database keypairs: %v\n", err)
+		log.Errorf("Error retrieving database keypairs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -21,9 +21,11 @@ package datastore
 
 import (
 	"errors"
+	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/fips"
 	"github.com/snapcore/snapd/asserts"
 )
 
@@ -37,11 +39,15 @@ var (
 	FilesystemStore = KeypairStoreType{"filesystem"}
 	DatabaseStore   = KeypairStoreType{"database"}
 	TPM20Store      = KeypairStoreType{"tpm2.0"}
+
+	// OpenPGPCardStore is selected per keypair, for signing-keys that are held on an OpenPGP card
+	OpenPGPCardStore = KeypairStoreType{"openpgp-card"}
 )
 
 // Common error messages.
 var (
 	ErrorInvalidKeystoreType = errors.New("Invalid keystore type specified")
+	ErrorFIPSKeystoreType    = errors.New("FIPS mode: the filesystem keystore does not seal the signing-keys")
 )
 
 // KeypairStore interface to wrap the signing-key store interactions for all store types
@@ -78,10 +84,17 @@ func OpenKeyStore(config config.Settings) error {
 }
 
 func getKeyStore(config config.Settings) (*KeypairDatabase, error) {
+	if fips.Enabled(config) && config.KeyStoreType == FilesystemStore.Name {
+		return nil, ErrorFIPSKeystoreType
+	}
+
 	switch config.KeyStoreType {
 	case DatabaseStore.Name:
 		// Prepare the memory store for the unsealed keys
-		memStore := asserts.NewMemoryKeypairManager()
+		memStore, err := openKeypairCache(config)
+		if err != nil {
+			return nil, err
+		}
 		db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
 			KeypairManager: memStore,
 		})
@@ -96,7 +109,10 @@ func getKeyStore(config config.Settings) (*KeypairDatabase, error) {
 		tpm20 := TPM20KeypairOperator{config.KeyStorePath, config.KeyStoreSecret, &tpm20Command{}}
 
 		// Prepare the memory store for the unsealed keys
-		memStore := asserts.NewMemoryKeypairManager()
+		memStore, err := openKeypairCache(config)
+		if err != nil {
+			return nil, err
+		}
 		db, err := asserts.OpenDatabase(&asserts.DatabaseConfig{
 			KeypairManager: memStore,
 		})
@@ -121,12 +137,26 @@ func getKeyStore(config config.Settings) (*KeypairDatabase, error) {
 	}
 }
 
+// openKeypairCache creates the memory store of the unsealed signing-keys of the keystore
+func openKeypairCache(config config.Settings) (*keypairCache, error) {
+	size, err := KeypairCacheSize(config)
+	if err != nil {
+		return nil, err
+	}
+
+	unsealedKeypairs = newKeypairCache(size)
+	return unsealedKeypairs, nil
+}
+
 // ImportSigningKey adds a new signing-key for an authority into the keypair store
 func (kdb *KeypairDatabase) ImportSigningKey(authorityID, base64PrivateKey string) (asserts.PrivateKey, string, error) {
 	privateKey, _, err := crypt.DeserializePrivateKey(base64PrivateKey)
 	if err != nil {
 		return nil, "", err
 	}
+	if err := checkFIPSKey(base64PrivateKey); err != nil {
+		return nil, "", err
+	}
 
 	switch kdb.KeyStoreType.Name {
 	case DatabaseStore.Name:
@@ -146,6 +176,11 @@ func (kdb *KeypairDatabase) ImportSigningKey(authorityID, base64PrivateKey strin
 // SignAssertion signs an assertion using the signing-key from the keypair store
 func (kdb *KeypairDatabase) SignAssertion(assertType *asserts.AssertionType, headers map[string]interface{}, body []byte, authorityID string, keyID string, sealedSigningKey string) (asserts.Assertion, error) {
 
+	// Signing-keys on an OpenPGP card are used in place of the configured keystore
+	if IsOpenPGPCardKey(sealedSigningKey) {
+		return openPGPCard.Sign(assertType, headers, body, keyID)
+	}
+
 	switch kdb.KeyStoreType.Name {
 
 	case DatabaseStore.Name:
@@ -169,6 +204,11 @@ func (kdb *KeypairDatabase) SignAssertion(assertType *asserts.AssertionType, hea
 
 // LoadKeypair checks if a keypair is in the memory store and (unseals and) loads it if it isn't
 func (kdb *KeypairDatabase) LoadKeypair(authorityID string, keyID string, sealedSigningKey string) error {
+	// Signing-keys on an OpenPGP card stay on the card, so this is a no-op
+	if IsOpenPGPCardKey(sealedSigningKey) {
+		return nil
+	}
+
 	switch kdb.KeyStoreType.Name {
 	case DatabaseStore.Name:
 		fallthrough
@@ -183,3 +223,19 @@ func (kdb *KeypairDatabase) LoadKeypair(authorityID string, keyID string, sealed
 		return nil
 	}
 }
+
+// checkFIPSKey checks that a signing-key is large enough, when the FIPS mode is on
+func checkFIPSKey(base64Key string) error {
+	if !fips.Enabled(Environ.Config) {
+		return nil
+	}
+
+	bits, err := crypt.KeyBits(base64Key)
+	if err != nil {
+		return err
+	}
+	if err := fips.CheckRSABits(bits); err != nil {
+		return fmt.Errorf("FIPS mode: %v", err)
+	}
+	return nil
+}
@@ -46,3 +46,13 @@ func TestGetKeyStoreInvalid(t *testing.T) {
 		t.Errorf("Expected error, but got success: %v", err)
 	}
 }
+
+func TestGetKeyStoreFilesystemFIPS(t *testing.T) {
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", FIPSMode: true}
+	Environ = &Env{Config: config}
+
+	err := OpenKeyStore(config)
+	if err != ErrorFIPSKeystoreType {
+		t.Errorf("Expected the FIPS keystore error, got: %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+)
+
+// SettingKeystoreCanary is the settings code of the canary that is sealed with the keystore secret
+var SettingKeystoreCanary = "keystore-canary"
+
+// ErrorKeystoreCanary is returned when the keystore secret no longer decrypts the canary
+var ErrorKeystoreCanary = errors.New("the keystore secret cannot decrypt the keystore canary: the secret has changed since the signing-keys were sealed")
+
+// ErrorKeystoreCanaryNotSealed is returned when the canary has not been sealed at the setup of the database
+var ErrorKeystoreCanaryNotSealed = errors.New("the keystore canary has not been sealed: run the database update")
+
+// SealKeystoreCanary seals the canary with the keystore secret at the setup of the database, so the
+// later checks are made against the secret that sealed the signing-keys. A canary that is already
+// sealed is checked, and not sealed again, so a changed secret is not accepted by a database update
+func SealKeystoreCanary(ctx context.Context) error {
+	if !keystoreUsesSecret() {
+		return nil
+	}
+
+	setting, err := Environ.DB.GetSetting(ctx, SettingKeystoreCanary)
+	if err == sql.ErrNoRows {
+		return sealKeystoreCanary(ctx, Environ.Config.KeyStoreSecret)
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving the keystore canary: %v", err)
+	}
+
+	return openKeystoreCanary(setting.Data, Environ.Config.KeyStoreSecret)
+}
+
+// CheckKeystoreCanary verifies that the configured keystore secret still decrypts the canary that
+// was sealed at setup, so a secret that was changed or rotated elsewhere is detected before a
+// signing-key is needed. The filesystem keystore does not use the secret, so there is nothing to check
+func CheckKeystoreCanary(ctx context.Context) error {
+	if !keystoreUsesSecret() {
+		return nil
+	}
+
+	setting, err := Environ.DB.GetSetting(ctx, SettingKeystoreCanary)
+	if err == sql.ErrNoRows {
+		return ErrorKeystoreCanaryNotSealed
+	}
+	if err != nil {
+		return fmt.Errorf("error retrieving the keystore canary: %v", err)
+	}
+
+	return openKeystoreCanary(setting.Data, Environ.Config.KeyStoreSecret)
+}
+
+// keystoreUsesSecret checks if the signing-keys of the keystore are sealed with the keystore secret
+func keystoreUsesSecret() bool {
+	switch Environ.Config.KeyStoreType {
+	case DatabaseStore.Name, TPM20Store.Name:
+		return true
+	default:
+		return false
+	}
+}
+
+// sealKeystoreCanary encrypts a random canary with the secret. The hash of the canary is
+// stored alongside it to check the decrypted value, as the canaries that were sealed with
+// AES-CFB do not detect the wrong key
+func sealKeystoreCanary(ctx context.Context, secret string) error {
+	canary, err := crypt.CreateSecret(32)
+	if err != nil {
+		return err
+	}
+
+	sealed, err := crypt.EncryptKey(canary, secret)
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256([]byte(canary))
+	data := base64.StdEncoding.EncodeToString(sealed) + ":" + hex.EncodeToString(hash[:])
+	return Environ.DB.PutSetting(ctx, Setting{Code: SettingKeystoreCanary, Data: data})
+}
+
+func openKeystoreCanary(data, secret string) error {
+	parts := strings.Split(data, ":")
+	if len(parts) != 2 {
+		return errors.New("invalid keystore canary")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[0])
+	if err != nil {
+		return fmt.Errorf("invalid keystore canary: %v", err)
+	}
+	expected, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid keystore canary: %v", err)
+	}
+
+	canary, err := crypt.DecryptKey(sealed, secret)
+	if err == crypt.ErrorWrongSecret {
+		return ErrorKeystoreCanary
+	}
+	if err != nil {
+		return err
+	}
+
+	hash := sha256.Sum256(canary)
+	if subtle.ConstantTimeCompare(hash[:], expected) != 1 {
+		return ErrorKeystoreCanary
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// canaryMockDB stores the settings, so the canary can be sealed and opened
+type canaryMockDB struct {
+	MockDB
+	settings map[string]string
+}
+
+func (mdb *canaryMockDB) GetSetting(ctx context.Context, code string) (Setting, error) {
+	data, ok := mdb.settings[code]
+	if !ok {
+		return Setting{}, sql.ErrNoRows
+	}
+	return Setting{Code: code, Data: data}, nil
+}
+
+func (mdb *canaryMockDB) PutSetting(ctx context.Context, setting Setting) error {
+	mdb.settings[setting.Code] = setting.Data
+	return nil
+}
+
+func TestCheckKeystoreCanary(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+
+	db := &canaryMockDB{settings: map[string]string{}}
+	Environ = &Env{DB: db, Config: config.Settings{KeyStoreType: "database", KeyStoreSecret: "secret"}}
+
+	// The canary is sealed at setup, and not by the check
+	if err := CheckKeystoreCanary(context.Background()); err != ErrorKeystoreCanaryNotSealed {
+		t.Fatalf("CheckKeystoreCanary() not sealed: expected %v, got %v", ErrorKeystoreCanaryNotSealed, err)
+	}
+	if err := SealKeystoreCanary(context.Background()); err != nil {
+		t.Fatalf("SealKeystoreCanary() unexpected error: %v", err)
+	}
+	sealed := db.settings[SettingKeystoreCanary]
+	if len(sealed) == 0 {
+		t.Fatal("SealKeystoreCanary() did not seal the canary")
+	}
+
+	if err := CheckKeystoreCanary(context.Background()); err != nil {
+		t.Errorf("CheckKeystoreCanary() same secret: unexpected error: %v", err)
+	}
+	if err := SealKeystoreCanary(context.Background()); err != nil || db.settings[SettingKeystoreCanary] != sealed {
+		t.Errorf("SealKeystoreCanary() sealed again: %v", err)
+	}
+
+	// A changed secret no longer decrypts the canary
+	Environ.Config.KeyStoreSecret = "rotated"
+	if err := CheckKeystoreCanary(context.Background()); err != ErrorKeystoreCanary {
+		t.Errorf("CheckKeystoreCanary() changed secret: expected %v, got %v", ErrorKeystoreCanary, err)
+	}
+	if err := SealKeystoreCanary(context.Background()); err != ErrorKeystoreCanary || db.settings[SettingKeystoreCanary] != sealed {
+		t.Errorf("SealKeystoreCanary() changed secret: expected %v, got %v", ErrorKeystoreCanary, err)
+	}
+
+	// The filesystem keystore does not use the secret
+	Environ.Config.KeyStoreType = "filesystem"
+	if err := CheckKeystoreCanary(context.Background()); err != nil {
+		t.Errorf("CheckKeystoreCanary() filesystem: unexpected error: %v", err)
+	}
+}
+
+func TestOpenKeystoreCanaryInvalid(t *testing.T) {
+	tests := []string{"", "invalid", "not-base64!:00", "AAAA:not-hex", "AAAA:00"}
+
+	for _, data := range tests {
+		if err := openKeystoreCanary(data, "secret"); err == nil {
+			t.Errorf("openKeystoreCanary(%q): expected an error", data)
+		}
+	}
+}
@@ -20,8 +20,11 @@
 package datastore
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -29,68 +32,81 @@ import (
 // MockDB holds the successful mocks for the database
 type MockDB struct {
 	encryptedAuthKeyHash string
+	logPolicy            string
+	logLevel             string
+	exportCheckpoint     string
+	schemaVersion        string
+	auditLogs            []AuditLog
+	webhookDeliveries    []WebhookDelivery
+	notifications        map[string]bool
+	syncStatus           []SyncStatus
 }
 
 // CreateModelTable mock for the create model table method
-func (mdb *MockDB) CreateModelTable() error {
+func (mdb *MockDB) CreateModelTable(ctx context.Context) error {
 	return nil
 }
 
 // AlterModelTable mock for the alter model table method
-func (mdb *MockDB) AlterModelTable() error {
+func (mdb *MockDB) AlterModelTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateKeypairTable mock for the create keypair table method
-func (mdb *MockDB) CreateKeypairTable() error {
+func (mdb *MockDB) CreateKeypairTable(ctx context.Context) error {
 	return nil
 }
 
 // AlterKeypairTable mock for the alter keypair table method
-func (mdb *MockDB) AlterKeypairTable() error {
+func (mdb *MockDB) AlterKeypairTable(ctx context.Context) error {
 	return nil
 }
 
 // UpdateKeypairAssertion mock to update the account-key assertion of a keypair
-func (mdb *MockDB) UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error) {
+func (mdb *MockDB) UpdateKeypairAssertion(ctx context.Context, keypair Keypair, authorization User) (string, error) {
 	return "", nil
 }
 
 // CreateSettingsTable mock for the create settings table method
-func (mdb *MockDB) CreateSettingsTable() error {
+func (mdb *MockDB) CreateSettingsTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateAccountTable mock for the create account table method
-func (mdb *MockDB) CreateAccountTable() error {
+func (mdb *MockDB) CreateAccountTable(ctx context.Context) error {
 	return nil
 }
 
 // AlterAccountTable mock for the create account table method
-func (mdb *MockDB) AlterAccountTable() error {
+func (mdb *MockDB) AlterAccountTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateAccount mock to create an account record
-func (mdb *MockDB) CreateAccount(account Account) error {
+func (mdb *MockDB) CreateAccount(ctx context.Context, account Account) error {
 	return nil
 }
 
+// FindAccount mock to return a single account for the signing requests
+func (mdb *MockDB) FindAccount(ctx context.Context, authorityID string) (Account, error) {
+	return mdb.GetAccount(ctx, authorityID)
+}
+
 // GetAccount mock to return a single account key
-func (mdb *MockDB) GetAccount(authorityID string) (Account, error) {
-	accounts, _ := mdb.ListAllowedAccounts(User{})
+func (mdb *MockDB) GetAccount(ctx context.Context, authorityID string) (Account, error) {
+	accounts, _ := mdb.ListAllowedAccounts(ctx, User{})
 
 	for _, acc := range accounts {
 		if acc.AuthorityID == authorityID {
 			return acc, nil
 		}
 	}
-	return Account{}, errors.New("Cannot found the account assertion")
+	return Account{}, sql.ErrNoRows
 }
 
 // GetAccountByID mock to return a single account key
-func (mdb *MockDB) GetAccountByID(ID int, user User) (Account, error) {
-	accounts, _ := mdb.ListAllowedAccounts(user)
+func (mdb *MockDB) GetAccountByID(ctx context.Context, ID int, user User) (Account, error) {
+	accounts, _ := mdb.ListAllowedAccounts(ctx, user)
 
 	for _, acc := range accounts {
 		if acc.ID == ID {
@@ -101,12 +117,12 @@ func (mdb *MockDB) GetAccountByID(ID int, user User) (Account, error) {
 }
 
 // GetAllowedAccount mock to fetch account
-func (mdb *MockDB) GetAllowedAccount(authorityID string, authorization User) (Account, error) {
-	return mdb.GetAccount(authorityID)
+func (mdb *MockDB) GetAllowedAccount(ctx context.Context, authorityID string, authorization User) (Account, error) {
+	return mdb.GetAccount(ctx, authorityID)
 }
 
 // ListAllowedAccounts mock to return a list of the available accounts
-func (mdb *MockDB) ListAllowedAccounts(authorization User) ([]Account, error) {
+func (mdb *MockDB) ListAllowedAccounts(ctx context.Context, authorization User) ([]Account, error) {
 	var accounts []Account
 	accounts = append(accounts, Account{ID: 1, AuthorityID: "system", Assertion: "assertion\n", ResellerAPI: true})
 	accounts = append(accounts, Account{ID: 2, AuthorityID: "vendor", Assertion: "assertion\n", ResellerAPI: false})
@@ -115,22 +131,469 @@ func (mdb *MockDB) ListAllowedAccounts(authorization User) ([]Account, error) {
 }
 
 // PutAccount mock to update abn account assertion
-func (mdb *MockDB) PutAccount(account Account, authorization User) (string, error) {
+func (mdb *MockDB) PutAccount(ctx context.Context, account Account, authorization User) (string, error) {
 	return "", nil
 }
 
+// CreateAPIKeyTable database mock
+func (mdb *MockDB) CreateAPIKeyTable(ctx context.Context) error {
+	return nil
+}
+
+// CheckAccountAPIKey mock to check a named API key of an account
+func (mdb *MockDB) CheckAccountAPIKey(ctx context.Context, apiKey, authorityID string, scopes ...string) bool {
+	return apiKey == "ReadOnlyAPIKey" && (APIKey{Scopes: scopes}).HasScope(ScopeReadOnly)
+}
+
+// ListAllowedAPIKeys mock to list the named API keys of an account
+func (mdb *MockDB) ListAllowedAPIKeys(ctx context.Context, accountID int, authorization User) ([]APIKey, error) {
+	return []APIKey{
+		{ID: 1, AccountID: accountID, Name: "factory-1", Key: "FactoryOneAPIKey", Scopes: []string{ScopeSerialSigning}, Active: true},
+		{ID: 2, AccountID: accountID, Name: "reseller", Key: "ReadOnlyAPIKey", Scopes: []string{ScopeReadOnly}, Active: false},
+	}, nil
+}
+
+// GetAllowedAPIKey mock to get a named API key of an account
+func (mdb *MockDB) GetAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) (APIKey, error) {
+	return APIKey{ID: keyID, AccountID: accountID, Name: "factory-1", Key: "FactoryOneAPIKey", Scopes: []string{ScopeSerialSigning}, Active: true}, nil
+}
+
+// CreateAllowedAPIKey mock to create a named API key for an account
+func (mdb *MockDB) CreateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) (APIKey, error) {
+	if err := validateAPIKey(k); err != nil {
+		return k, err
+	}
+	k.ID = 3
+	if len(k.Key) == 0 {
+		k.Key = "GeneratedAPIKey"
+	}
+	return k, nil
+}
+
+// UpdateAllowedAPIKey mock to update a named API key of an account
+func (mdb *MockDB) UpdateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) error {
+	return validateAPIKey(k)
+}
+
+// AlterAPIKeyTable database mock
+func (mdb *MockDB) AlterAPIKeyTable(ctx context.Context) error {
+	return nil
+}
+
+// EncryptColumns database mock
+func (mdb *MockDB) EncryptColumns(ctx context.Context) error {
+	return nil
+}
+
+// FindAccountAPIKey mock to find a named API key by its value. The key "RestrictedAPIKey"
+// can only be used from 192.0.2.0/24
+func (mdb *MockDB) FindAccountAPIKey(ctx context.Context, apiKey string) (APIKey, error) {
+	switch apiKey {
+	case "RestrictedAPIKey":
+		return APIKey{ID: 3, AccountID: 1, Name: "factory-3", AuthorityID: "system", Scopes: []string{ScopeSerialSigning}, AllowedCIDRs: []string{"192.0.2.0/24"}}, nil
+	case "ReadOnlyAPIKey":
+		return APIKey{ID: 2, AccountID: 1, Name: "reseller", AuthorityID: "system", Scopes: []string{ScopeReadOnly}}, nil
+	}
+	return APIKey{}, sql.ErrNoRows
+}
+
+// RotateAllowedAPIKey mock to rotate a named API key of an account
+func (mdb *MockDB) RotateAllowedAPIKey(ctx context.Context, keyID, accountID int, grace time.Duration, authorization User) (APIKey, error) {
+	expires := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC).Add(grace)
+	return APIKey{ID: keyID, AccountID: accountID, Name: "factory-1", Key: "RotatedAPIKey", Scopes: []string{ScopeSerialSigning}, Active: true, PreviousExpires: &expires}, nil
+}
+
+// DeleteAllowedAPIKey mock to delete a named API key of an account
+func (mdb *MockDB) DeleteAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) error {
+	return nil
+}
+
+// CreateClientCertTable database mock
+func (mdb *MockDB) CreateClientCertTable(ctx context.Context) error {
+	return nil
+}
+
+// FindClientCert mock to find the account of a client certificate. The certificates with
+// the SAN "factory.example.com" are mapped to the "system" account
+func (mdb *MockDB) FindClientCert(ctx context.Context, fingerprint string, sans []string) (ClientCert, error) {
+	for _, san := range sans {
+		if san == "factory.example.com" {
+			return ClientCert{ID: 1, AccountID: 1, Name: "factory-1", SAN: san, AuthorityID: "system"}, nil
+		}
+	}
+	return ClientCert{}, sql.ErrNoRows
+}
+
+// ListAllowedClientCerts mock to list the client certificates of an account
+func (mdb *MockDB) ListAllowedClientCerts(ctx context.Context, accountID int, authorization User) ([]ClientCert, error) {
+	return []ClientCert{
+		{ID: 1, AccountID: accountID, Name: "factory-1", SAN: "factory.example.com"},
+		{ID: 2, AccountID: accountID, Name: "factory-2", Fingerprint: "5f1e7d3a9b2c4e6f8a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f"},
+	}, nil
+}
+
+// GetAllowedClientCert mock to get a client certificate of an account
+func (mdb *MockDB) GetAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) (ClientCert, error) {
+	return ClientCert{ID: certID, AccountID: accountID, Name: "factory-1", SAN: "factory.example.com"}, nil
+}
+
+// CreateAllowedClientCert mock to map a client certificate to an account
+func (mdb *MockDB) CreateAllowedClientCert(ctx context.Context, c ClientCert, authorization User) (ClientCert, error) {
+	c.Fingerprint = normalizeFingerprint(c.Fingerprint)
+	c.SAN = strings.TrimSpace(c.SAN)
+	if err := validateClientCert(c); err != nil {
+		return c, err
+	}
+	c.ID = 3
+	return c, nil
+}
+
+// DeleteAllowedClientCert mock to remove a client certificate of an account
+func (mdb *MockDB) DeleteAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) error {
+	return nil
+}
+
+// CreateAccountHMACTable database mock
+func (mdb *MockDB) CreateAccountHMACTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAccountHMACSecret mock to get the HMAC secret of an account. The requests of the accounts are not signed
+func (mdb *MockDB) GetAccountHMACSecret(ctx context.Context, authorityID string) (string, error) {
+	return "", sql.ErrNoRows
+}
+
+// UseRequestSignature mock to record a request signature
+func (mdb *MockDB) UseRequestSignature(ctx context.Context, signature string, expires time.Time) error {
+	return nil
+}
+
+// DeleteExpiredRequestSignatures database mock
+func (mdb *MockDB) DeleteExpiredRequestSignatures(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountHMAC mock to get whether the requests of an account are signed
+func (mdb *MockDB) GetAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	return AccountHMAC{AccountID: accountID, Enabled: accountID == 2}, nil
+}
+
+// RotateAllowedAccountHMAC mock to generate the HMAC secret of an account
+func (mdb *MockDB) RotateAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	return AccountHMAC{AccountID: accountID, Enabled: true, Secret: "GeneratedHMACSecret"}, nil
+}
+
+// DeleteAllowedAccountHMAC mock to remove the HMAC secret of an account
+func (mdb *MockDB) DeleteAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) error {
+	return nil
+}
+
+// CreateWebhookTable database mock
+func (mdb *MockDB) CreateWebhookTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterWebhookTable database mock
+func (mdb *MockDB) AlterWebhookTable(ctx context.Context) error {
+	return nil
+}
+
+// QueueWebhookDeliveries mock to queue an event. The "system" account has a webhook for all the events
+func (mdb *MockDB) QueueWebhookDeliveries(ctx context.Context, authorityID, event, payload string) (int, error) {
+	if authorityID != "system" {
+		return 0, nil
+	}
+	mdb.webhookDeliveries = append(mdb.webhookDeliveries, WebhookDelivery{ID: len(mdb.webhookDeliveries) + 1, WebhookID: 1,
+		Event: event, Payload: payload, Status: WebhookDeliveryPending, NextAttempt: time.Now().UTC(), Created: time.Now().UTC()})
+	return 1, nil
+}
+
+// ClaimWebhookDeliveries mock to list the queued deliveries that are due
+func (mdb *MockDB) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	for _, d := range mdb.webhookDeliveries {
+		if d.Status == WebhookDeliveryPending && !d.NextAttempt.After(now) && len(deliveries) < limit {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// UpdateWebhookDelivery mock to record the attempt of a queued delivery
+func (mdb *MockDB) UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	for i := range mdb.webhookDeliveries {
+		if mdb.webhookDeliveries[i].ID == d.ID {
+			mdb.webhookDeliveries[i] = d
+			return nil
+		}
+	}
+	return errors.New("Cannot find the webhook delivery")
+}
+
+// PurgeWebhookDeliveries database mock
+func (mdb *MockDB) PurgeWebhookDeliveries(ctx context.Context, now time.Time) (int, error) {
+	return 0, nil
+}
+
+// ListAllowedWebhooks mock to list the webhooks of an account
+func (mdb *MockDB) ListAllowedWebhooks(ctx context.Context, accountID int, authorization User) ([]Webhook, error) {
+	return []Webhook{
+		{ID: 1, AccountID: accountID, URL: "https://hooks.example.com/vault", Events: validWebhookEvents, Format: WebhookFormatJSON},
+	}, nil
+}
+
+// CreateAllowedWebhook mock to register a webhook of an account
+func (mdb *MockDB) CreateAllowedWebhook(ctx context.Context, w Webhook, authorization User) (Webhook, error) {
+	if err := validateWebhook(&w); err != nil {
+		return w, err
+	}
+	w.ID = 2
+	w.Secret = "GeneratedWebhookSecret"
+	return w, nil
+}
+
+// DeleteAllowedWebhook mock to remove a webhook of an account
+func (mdb *MockDB) DeleteAllowedWebhook(ctx context.Context, webhookID, accountID int, authorization User) error {
+	return nil
+}
+
+// ListAllowedWebhookDeliveries mock to list the queued deliveries of a webhook
+func (mdb *MockDB) ListAllowedWebhookDeliveries(ctx context.Context, webhookID, accountID int, status string, authorization User) ([]WebhookDelivery, error) {
+	deliveries := []WebhookDelivery{}
+	for _, d := range mdb.webhookDeliveries {
+		if d.WebhookID == webhookID && (len(status) == 0 || d.Status == status) {
+			deliveries = append(deliveries, d)
+		}
+	}
+	return deliveries, nil
+}
+
+// CreateNotificationTable database mock
+func (mdb *MockDB) CreateNotificationTable(ctx context.Context) error {
+	return nil
+}
+
+// RecordNotification mock to record that an event is notified for a reference
+func (mdb *MockDB) RecordNotification(ctx context.Context, event, reference string) (bool, error) {
+	if mdb.notifications == nil {
+		mdb.notifications = map[string]bool{}
+	}
+	key := event + "/" + reference
+	if mdb.notifications[key] {
+		return false, nil
+	}
+	mdb.notifications[key] = true
+	return true, nil
+}
+
+// CreateServiceAccountTable database mock
+func (mdb *MockDB) CreateServiceAccountTable(ctx context.Context) error {
+	return nil
+}
+
+// ListServiceAccounts mock to list the service accounts
+func (mdb *MockDB) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	return []ServiceAccount{
+		{ID: 1, UserID: 3, Username: "sv", Name: "CI pipeline", ClientID: "sv-client", Scopes: []string{AdminScopeModels, AdminScopeKeypairs + AdminScopeReadSuffix}, Active: true},
+	}, nil
+}
+
+// GetServiceAccount mock to get a service account
+func (mdb *MockDB) GetServiceAccount(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	if serviceAccountID != 1 {
+		return ServiceAccount{}, errors.New("MOCK error retrieving the service account")
+	}
+	return ServiceAccount{ID: 1, UserID: 3, Username: "sv", Name: "CI pipeline", ClientID: "sv-client", Scopes: []string{AdminScopeModels, AdminScopeKeypairs + AdminScopeReadSuffix}, Active: true}, nil
+}
+
+// CreateServiceAccount mock to create a service account
+func (mdb *MockDB) CreateServiceAccount(ctx context.Context, sa ServiceAccount) (ServiceAccount, error) {
+	if _, err := mdb.GetUserByUsername(ctx, sa.Username); err != nil {
+		return sa, err
+	}
+	if err := validateServiceAccount(sa); err != nil {
+		return sa, err
+	}
+	sa.ID = 2
+	sa.ClientID = "sv-generated"
+	sa.Secret = "GeneratedSecret"
+	return sa, nil
+}
+
+// UpdateServiceAccount mock to update a service account
+func (mdb *MockDB) UpdateServiceAccount(ctx context.Context, sa ServiceAccount) error {
+	return validateServiceAccount(sa)
+}
+
+// ResetServiceAccountSecret mock to reset the secret of a service account
+func (mdb *MockDB) ResetServiceAccountSecret(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	sa, err := mdb.GetServiceAccount(ctx, serviceAccountID)
+	sa.Secret = "ResetSecret"
+	return sa, err
+}
+
+// DeleteServiceAccount mock to delete a service account
+func (mdb *MockDB) DeleteServiceAccount(ctx context.Context, serviceAccountID int) error {
+	_, err := mdb.GetServiceAccount(ctx, serviceAccountID)
+	return err
+}
+
+// AuthenticateServiceAccount mock to check the client credentials of a service account
+func (mdb *MockDB) AuthenticateServiceAccount(ctx context.Context, clientID, secret string) (ServiceAccount, error) {
+	if clientID != "sv-client" || secret != "ClientSecret" {
+		return ServiceAccount{}, errors.New("Invalid client credentials")
+	}
+	return mdb.GetServiceAccount(ctx, 1)
+}
+
+// CheckServiceAccount mock to check that a service account is active
+func (mdb *MockDB) CheckServiceAccount(ctx context.Context, clientID string) bool {
+	return clientID == "sv-client"
+}
+
+// CreateAccessTokenTable database mock
+func (mdb *MockDB) CreateAccessTokenTable(ctx context.Context) error {
+	return nil
+}
+
+// ListAccessTokens mock to list the personal access tokens of a user
+func (mdb *MockDB) ListAccessTokens(ctx context.Context, userID int) ([]AccessToken, error) {
+	if userID != 3 {
+		return []AccessToken{}, nil
+	}
+	t, _ := mdb.AuthenticateAccessToken(ctx, "svpat_SteveToken")
+	t.Token = ""
+	return []AccessToken{t}, nil
+}
+
+// CreateAccessToken mock to create a personal access token
+func (mdb *MockDB) CreateAccessToken(ctx context.Context, t AccessToken) (AccessToken, error) {
+	if t.Expires.IsZero() {
+		t.Expires = time.Now().Add(accessTokenDefaultValidity)
+	}
+	if err := validateAccessToken(t); err != nil {
+		return t, err
+	}
+	t.ID = 2
+	t.Token = "svpat_Generated"
+	t.Prefix = "svpat_Gene"
+	return t, nil
+}
+
+// DeleteAccessToken mock to revoke a personal access token
+func (mdb *MockDB) DeleteAccessToken(ctx context.Context, userID, tokenID int) error {
+	if userID != 3 || tokenID != 1 {
+		return errors.New("Cannot find the access token of the user")
+	}
+	return nil
+}
+
+// AuthenticateAccessToken mock to check a personal access token
+func (mdb *MockDB) AuthenticateAccessToken(ctx context.Context, token string) (AccessToken, error) {
+	if token != "svpat_SteveToken" {
+		return AccessToken{}, errors.New("The access token is invalid or has expired")
+	}
+	return AccessToken{ID: 1, UserID: 3, Username: "sv", Name: "Release script", Prefix: "svpat_Stev", Scopes: []string{AdminScopeModels, AdminScopeKeypairs + AdminScopeReadSuffix}, Expires: time.Now().Add(time.Hour)}, nil
+}
+
+// CreateInvitationTable database mock
+func (mdb *MockDB) CreateInvitationTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateInvitation mock to invite a pending user
+func (mdb *MockDB) CreateInvitation(ctx context.Context, inv Invitation, user User) (Invitation, error) {
+	user, err := newInvitedUser(inv, user)
+	if err != nil {
+		return inv, err
+	}
+	inv.ID = 1
+	inv.UserID = 7
+	inv.Expires = time.Now().Add(InvitationValidity)
+	return inv, nil
+}
+
+// AcceptInvitation mock to activate the pending user of an invitation
+func (mdb *MockDB) AcceptInvitation(ctx context.Context, invitationID int, user User) (User, error) {
+	if invitationID != 1 {
+		return User{}, errors.New("The invitation is invalid, expired or has already been accepted")
+	}
+	if err := validateUser(user); err != nil {
+		return User{}, err
+	}
+	user.ID = 7
+	user.Role = Standard
+	return user, nil
+}
+
+// CreateRevokedTokenTable database mock
+func (mdb *MockDB) CreateRevokedTokenTable(ctx context.Context) error {
+	return nil
+}
+
+// RevokeToken mock to add a session token to the denylist
+func (mdb *MockDB) RevokeToken(ctx context.Context, username, tokenID string, expires time.Time) error {
+	return nil
+}
+
+// RevokeUserTokens mock to revoke all the session tokens of a user
+func (mdb *MockDB) RevokeUserTokens(ctx context.Context, username string, expires time.Time) error {
+	return nil
+}
+
+// UseRefreshToken mock to record the use of a refresh token. The token with ID "used"
+// has been used before
+func (mdb *MockDB) UseRefreshToken(ctx context.Context, username, tokenID string, expires time.Time) (bool, error) {
+	return tokenID != "used", nil
+}
+
+// IsTokenRevoked mock to check the denylist of the session tokens. The token with ID
+// "revoked" is in the denylist
+func (mdb *MockDB) IsTokenRevoked(ctx context.Context, username, tokenID string, issued time.Time) bool {
+	return tokenID == "revoked"
+}
+
+// CreateAuthFailureTable database mock
+func (mdb *MockDB) CreateAuthFailureTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAuthFailure mock to fetch the failed authentications. The user "locked" from any
+// source IP and the source IP 192.0.2.66 are locked out
+func (mdb *MockDB) GetAuthFailure(ctx context.Context, key string) (AuthFailure, error) {
+	if strings.HasPrefix(key, "user:locked@") || key == "ip:192.0.2.66" {
+		lockedUntil := time.Now().Add(time.Minute)
+		return AuthFailure{Key: key, Failures: 5, LockedUntil: &lockedUntil, LastFailure: time.Now()}, nil
+	}
+	return AuthFailure{Key: key}, nil
+}
+
+// RecordAuthFailure mock to count a failed authentication, which is the first one
+func (mdb *MockDB) RecordAuthFailure(ctx context.Context, key string, policy LockoutPolicy) (AuthFailure, error) {
+	return AuthFailure{Key: key, Failures: 1, LastFailure: time.Now()}, nil
+}
+
+// ResetAuthFailures mock to clear the failed authentications
+func (mdb *MockDB) ResetAuthFailures(ctx context.Context, key string) error {
+	return nil
+}
+
 // UpdateAccountAssertion mock to update the account assertion
 func (mdb *MockDB) UpdateAccountAssertion(authorityID, assertion string, resellerAPI bool) error {
 	return nil
 }
 
 // UpdateAccount mock to update the account
-func (mdb *MockDB) UpdateAccount(account Account, authorization User) error {
+func (mdb *MockDB) UpdateAccount(ctx context.Context, account Account, authorization User) error {
+	// The mock records are at the first version, so a later version is a conflicting update
+	if account.Version > 1 {
+		return ErrVersionConflict
+	}
 	return nil
 }
 
 // ListAllowedModels Mock the database response for a list of models
-func (mdb *MockDB) ListAllowedModels(authorization User) ([]Model, error) {
+func (mdb *MockDB) ListAllowedModels(ctx context.Context, authorization User, filter ModelFilter) ([]Model, error) {
 
 	var models []Model
 	if authorization.Username == "" || authorization.Username == "sv" || authorization.Username == "sync" {
@@ -143,16 +606,37 @@ func (mdb *MockDB) ListAllowedModels(authorization User) ([]Model, error) {
 		models = append(models, Model{ID: 5, BrandID: "system", Name: "mahogany", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", SealedKey: "", KeyActive: true})
 		models = append(models, Model{ID: 6, BrandID: "system", Name: "maple", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", SealedKey: "", KeyActive: true})
 	}
-	return models, nil
+	return filterModels(models, filter), nil
+}
+
+// filterModels returns the page of the models that match the filter, like the models list query
+func filterModels(models []Model, filter ModelFilter) []Model {
+	filtered := []Model{}
+	for _, m := range models {
+		if (filter.BrandID != "" && filter.BrandID != m.BrandID) || !containsFold(m.Name, filter.Name) ||
+			(filter.KeypairID != 0 && filter.KeypairID != m.KeypairID && filter.KeypairID != m.KeypairIDUser) {
+			continue
+		}
+		filtered = append(filtered, m)
+	}
+
+	if filter.Offset >= len(filtered) {
+		return []Model{}
+	}
+	filtered = filtered[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(filtered) {
+		filtered = filtered[:filter.Limit]
+	}
+	return filtered
 }
 
 // SyncAccount mock to update the account
-func (mdb *MockDB) SyncAccount(account Account) error {
+func (mdb *MockDB) SyncAccount(ctx context.Context, account Account) error {
 	return nil
 }
 
 // FindModel mocks the database response for finding a model
-func (mdb *MockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
+func (mdb *MockDB) FindModel(ctx context.Context, brandID, modelName, apiKey string, scopes ...string) (Model, error) {
 	model := Model{ID: 1, BrandID: "system", Name: "alder", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, SealedKey: ""}
 	if modelName == "ash" {
 		model = Model{ID: 2, BrandID: "system", Name: "ash", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, SealedKey: ""}
@@ -169,17 +653,28 @@ func (mdb *MockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
 	if modelName == "inactive" {
 		model = Model{ID: 1, BrandID: "system", Name: "inactive", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: false, SealedKey: ""}
 	}
+	if modelName == "birch" {
+		model = Model{ID: 3, BrandID: "system", Name: "birch", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, SealedKey: "", SerialFormat: "B[0-9]{6}", SerialHeaders: "factory-line,hardware-revision"}
+	}
 	if model.BrandID != brandID || model.Name != modelName || modelName == "invalid" {
 		return model, errors.New("Cannot find a model for that brand and model")
 	}
 	if apiKey == "NoModelForApiKey" {
 		return model, errors.New("Cannot find a model for that brand and model for the API key")
 	}
+	if apiKey == "ReadOnlyAPIKey" && !(APIKey{Scopes: scopes}).HasScope(ScopeReadOnly) {
+		return model, errors.New("Cannot find a model for that brand and model for the API key")
+	}
 	return model, nil
 }
 
+// FindAccountModel mocks the database response for finding a model of the account
+func (mdb *MockDB) FindAccountModel(ctx context.Context, brandID, modelName string) (Model, error) {
+	return mdb.FindModel(ctx, brandID, modelName, "")
+}
+
 // CheckModelExists mocks the database response for finding a model
-func (mdb *MockDB) CheckModelExists(brandID, modelName string) bool {
+func (mdb *MockDB) CheckModelExists(ctx context.Context, brandID, modelName string) bool {
 	model := Model{ID: 1, BrandID: "system", Name: "alder", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, SealedKey: ""}
 	if model.BrandID != brandID || model.Name != modelName || modelName == "invalid" {
 		return false
@@ -188,7 +683,7 @@ func (mdb *MockDB) CheckModelExists(brandID, modelName string) bool {
 }
 
 // CheckAPIKey mocks the database response to check the API key
-func (mdb *MockDB) CheckAPIKey(apiKey string) bool {
+func (mdb *MockDB) CheckAPIKey(ctx context.Context, apiKey string) bool {
 	if apiKey == "InvalidAPIKey" {
 		return false
 	}
@@ -196,11 +691,11 @@ func (mdb *MockDB) CheckAPIKey(apiKey string) bool {
 }
 
 // GetAllowedModel mocks the model from the database by ID.
-func (mdb *MockDB) GetAllowedModel(modelID int, authorization User) (Model, error) {
+func (mdb *MockDB) GetAllowedModel(ctx context.Context, modelID int, authorization User) (Model, error) {
 
 	var model Model
 	found := false
-	models, _ := mdb.ListAllowedModels(authorization)
+	models, _ := mdb.ListAllowedModels(ctx, authorization, ModelFilter{})
 
 	for _, mdl := range models {
 		if mdl.ID == modelID {
@@ -218,14 +713,19 @@ func (mdb *MockDB) GetAllowedModel(modelID int, authorization User) (Model, erro
 }
 
 // UpdateAllowedModel mocks the model update.
-func (mdb *MockDB) UpdateAllowedModel(model Model, authorization User) (string, error) {
-	models, _ := mdb.ListAllowedModels(authorization)
+func (mdb *MockDB) UpdateAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
+	models, _ := mdb.ListAllowedModels(ctx, authorization, ModelFilter{})
 	found := false
 
 	if model.ID == 1 && model.Name == "ash" {
 		return "error-model-exists", errors.New("A device with the same Brand and Model already exists")
 	}
 
+	// The mock records are at the first version, so a later version is a conflicting update
+	if model.Version > 1 {
+		return "error-model-version", ErrVersionConflict
+	}
+
 	for _, mdl := range models {
 		if mdl.ID == model.ID {
 			found = true
@@ -240,8 +740,8 @@ func (mdb *MockDB) UpdateAllowedModel(model Model, authorization User) (string,
 }
 
 // DeleteAllowedModel mocks the model deletion.
-func (mdb *MockDB) DeleteAllowedModel(model Model, authorization User) (string, error) {
-	models, _ := mdb.ListAllowedModels(authorization)
+func (mdb *MockDB) DeleteAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
+	models, _ := mdb.ListAllowedModels(ctx, authorization, ModelFilter{})
 	found := false
 
 	for _, mdl := range models {
@@ -257,42 +757,132 @@ func (mdb *MockDB) DeleteAllowedModel(model Model, authorization User) (string,
 	return "", nil
 }
 
+// ListAllowedDeletedModels mocks the listing of the deleted models.
+func (mdb *MockDB) ListAllowedDeletedModels(ctx context.Context, authorization User) ([]Model, error) {
+	deletedAt := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	var models []Model
+	if authorization.Username == "" || authorization.Username == "sv" {
+		models = append(models, Model{ID: 7, BrandID: "system", Name: "walnut", KeypairID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", KeyActive: true, DeletedAt: &deletedAt})
+	}
+	return models, nil
+}
+
+// RestoreAllowedModel mocks the restore of a deleted model.
+func (mdb *MockDB) RestoreAllowedModel(ctx context.Context, modelID int, authorization User) (string, error) {
+	models, _ := mdb.ListAllowedDeletedModels(ctx, authorization)
+
+	for _, mdl := range models {
+		if mdl.ID == modelID {
+			return "", nil
+		}
+	}
+	return "error-model-not-found", errors.New("Cannot find the deleted model")
+}
+
+// RotateAllowedModelAPIKey mocks the rotation of the API key of a model
+func (mdb *MockDB) RotateAllowedModelAPIKey(ctx context.Context, modelID int, overlap time.Duration, authorization User) (ModelAPIKey, error) {
+	model, err := mdb.GetAllowedModel(ctx, modelID, authorization)
+	if err != nil {
+		return ModelAPIKey{}, err
+	}
+
+	k := ModelAPIKey{ModelID: model.ID, BrandID: model.BrandID, Name: model.Name, APIKey: "RotatedModelAPIKey"}
+	if overlap > 0 {
+		expires := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC).Add(overlap)
+		k.PreviousExpires = &expires
+	}
+	return k, nil
+}
+
+// CreateModelRevisionTable mock for the create model revision table method
+func (mdb *MockDB) CreateModelRevisionTable(ctx context.Context) error {
+	return nil
+}
+
+// ListAllowedModelHistory mocks the revisions of a model, where the signing key is changed
+func (mdb *MockDB) ListAllowedModelHistory(ctx context.Context, modelID int, authorization User) ([]ModelRevision, error) {
+	model, err := mdb.GetAllowedModel(ctx, modelID, authorization)
+	if err != nil || model.ID == 0 {
+		return nil, errors.New("error retrieving the model history: cannot find the model")
+	}
+
+	created := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	first := ModelSnapshot{BrandID: model.BrandID, Name: model.Name, KeypairID: 2, KeyID: "oldkey", KeypairIDUser: model.KeypairIDUser, KeyIDUser: model.KeyIDUser, SerialFormat: model.SerialFormat, SerialHeaders: model.SerialHeaders, APIKeyID: APIKeyID(model.APIKey)}
+	latest := modelSnapshot(model)
+	return []ModelRevision{
+		{ID: 2, ModelID: modelID, Version: 2, Username: "sv", Action: ModelRevisionUpdate, Created: created.Add(time.Hour), Model: latest, Changes: modelChanges(first, latest)},
+		{ID: 1, ModelID: modelID, Version: 1, Username: "sv", Action: ModelRevisionCreate, Created: created, Model: first, Changes: modelChanges(ModelSnapshot{}, first)},
+	}, nil
+}
+
 func keypairSystem() Keypair {
 	return Keypair{ID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
 }
 
 // CreateAllowedModel mocks creating a new model.
-func (mdb *MockDB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
+func (mdb *MockDB) CreateAllowedModel(ctx context.Context, model Model, authorization User) (Model, string, error) {
 	model = Model{ID: 7, BrandID: model.BrandID, Name: model.Name, KeypairID: model.KeypairID, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"}
 
 	return model, "", nil
 }
 
+// BulkCreateAllowedModels mocks the bulk import of models. The keys referenced by their key ID or name
+// are the system keypair, and the models of the system brand must not exist
+func (mdb *MockDB) BulkCreateAllowedModels(ctx context.Context, rows []BulkModel, authorization User) ([]BulkModelResult, error) {
+	results := make([]BulkModelResult, len(rows))
+	for i, row := range rows {
+		results[i] = BulkModelResult{Row: i + 1, BrandID: row.BrandID, Name: row.Name, KeypairID: row.KeypairID, KeypairIDUser: row.KeypairIDUser}
+		if len(row.SigningKey) > 0 {
+			results[i].KeypairID = keypairSystem().ID
+		}
+		if len(row.SystemUserKey) > 0 {
+			results[i].KeypairIDUser = keypairSystem().ID
+		}
+
+		model := Model{BrandID: row.BrandID, Name: row.Name, KeypairID: results[i].KeypairID, KeypairIDUser: results[i].KeypairIDUser, SerialFormat: row.SerialFormat, SerialHeaders: row.SerialHeaders}
+		if _, err := validateModel(model, ""); err != nil {
+			results[i].Error = err.Error()
+		} else if mdb.CheckModelExists(ctx, row.BrandID, row.Name) {
+			results[i].Error = "the model already exists"
+		}
+	}
+	if bulkModelsFailed(results) {
+		return results, ErrBulkModelsRejected
+	}
+
+	for i := range results {
+		results[i].ModelID = 700 + i
+	}
+	return results, nil
+}
+
 // SyncModel mocks creating a new model
-func (mdb *MockDB) SyncModel(model Model) error {
+func (mdb *MockDB) SyncModel(ctx context.Context, model Model) error {
 	return nil
 }
 
 // GetKeypair mocks getting a keypair by ID
-func (mdb *MockDB) GetKeypair(keypairID int) (Keypair, error) {
+func (mdb *MockDB) GetKeypair(ctx context.Context, keypairID int) (Keypair, error) {
 	keypair := keypairSystem()
 	return keypair, nil
 }
 
 // GetKeypairByPublicID mocks getting a keypair by key ID
-func (mdb *MockDB) GetKeypairByPublicID(auth, keyID string) (Keypair, error) {
+func (mdb *MockDB) GetKeypairByPublicID(ctx context.Context, auth, keyID string) (Keypair, error) {
 	keypair := keypairSystem()
+	keypair.Assertion = "account-key assertion\n"
 	return keypair, nil
 }
 
 // GetKeypairByName mocks getting a keypair by name
-func (mdb *MockDB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
+func (mdb *MockDB) GetKeypairByName(ctx context.Context, authorityID, keyName string) (Keypair, error) {
 	keypair := keypairSystem()
 	return keypair, nil
 }
 
 // ListAllowedKeypairs mocks listing the keypairs
-func (mdb *MockDB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
+func (mdb *MockDB) ListAllowedKeypairs(ctx context.Context, authorization User) ([]Keypair, error) {
 	var keypairs []Keypair
 	if authorization.Username == "" || authorization.Username == "sv" || authorization.Username == "sync" {
 		keypairs = append(keypairs, keypairSystem())
@@ -306,12 +896,16 @@ func (mdb *MockDB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
 }
 
 // PutKeypair database mock
-func (mdb *MockDB) PutKeypair(keypair Keypair) (string, error) {
+func (mdb *MockDB) PutKeypair(ctx context.Context, keypair Keypair) (string, error) {
+	// The mock records are at the first version, so a later version is a conflicting update
+	if keypair.Version > 1 {
+		return "error-keypair-version", ErrVersionConflict
+	}
 	return "", nil
 }
 
 // CheckKeypairKeynameExists mock checking for keypair by name
-func (mdb *MockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
+func (mdb *MockDB) CheckKeypairKeynameExists(ctx context.Context, authorityID, name string) bool {
 	if name == "invalid" {
 		return true
 	}
@@ -319,17 +913,17 @@ func (mdb *MockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
 }
 
 // SyncKeypair database mock
-func (mdb *MockDB) SyncKeypair(keypair SyncKeypair) error {
+func (mdb *MockDB) SyncKeypair(ctx context.Context, keypair SyncKeypair) error {
 	return nil
 }
 
 // UpdateAllowedKeypairActive database mock
-func (mdb *MockDB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
+func (mdb *MockDB) UpdateAllowedKeypairActive(ctx context.Context, keypairID int, active bool, authorization User) error {
 	return nil
 }
 
 // GetSetting database mock
-func (mdb *MockDB) GetSetting(code string) (Setting, error) {
+func (mdb *MockDB) GetSetting(ctx context.Context, code string) (Setting, error) {
 	switch code {
 	case "System/12345678abcdef":
 		// Returning the encrypted, base64 encoded HMAC-ed auth-key: fake-hmac-ed-data
@@ -344,31 +938,64 @@ func (mdb *MockDB) GetSetting(code string) (Setting, error) {
 	case "do-not-find":
 		return Setting{}, errors.New("Cannot find 'do-not-find'")
 
+	case "log-policy":
+		if len(mdb.logPolicy) == 0 {
+			return Setting{}, sql.ErrNoRows
+		}
+		return Setting{Code: code, Data: mdb.logPolicy}, nil
+
+	case "log-level":
+		if len(mdb.logLevel) == 0 {
+			return Setting{}, sql.ErrNoRows
+		}
+		return Setting{Code: code, Data: mdb.logLevel}, nil
+
+	case "signinglog-export":
+		if len(mdb.exportCheckpoint) == 0 {
+			return Setting{}, sql.ErrNoRows
+		}
+		return Setting{Code: code, Data: mdb.exportCheckpoint}, nil
+
+	case SettingSchemaVersion:
+		if len(mdb.schemaVersion) == 0 {
+			return Setting{Code: code, Data: strconv.Itoa(SchemaVersion)}, nil
+		}
+		return Setting{Code: code, Data: mdb.schemaVersion}, nil
+
 	default:
 		return Setting{Code: code, Data: code}, nil
 	}
 }
 
 // PutSetting database mock
-func (mdb *MockDB) PutSetting(setting Setting) error {
-	if setting.Code == "System/abcdef12345678" {
+func (mdb *MockDB) PutSetting(ctx context.Context, setting Setting) error {
+	switch setting.Code {
+	case "System/abcdef12345678":
 		mdb.encryptedAuthKeyHash = setting.Data
+	case "log-policy":
+		mdb.logPolicy = setting.Data
+	case "log-level":
+		mdb.logLevel = setting.Data
+	case "signinglog-export":
+		mdb.exportCheckpoint = setting.Data
+	case SettingSchemaVersion:
+		mdb.schemaVersion = setting.Data
 	}
 	return nil
 }
 
 // CreateSigningLogTable database mock
-func (mdb *MockDB) CreateSigningLogTable() error {
+func (mdb *MockDB) CreateSigningLogTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateTestLogTable error mock for the database
-func (mdb *MockDB) CreateTestLogTable() error {
+func (mdb *MockDB) CreateTestLogTable(ctx context.Context) error {
 	return nil
 }
 
 // CheckForDuplicate database mock
-func (mdb *MockDB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
+func (mdb *MockDB) CheckForDuplicate(ctx context.Context, signLog *SigningLog) (bool, int, error) {
 	switch signLog.SerialNumber {
 	case "Aduplicate":
 		return true, 3, nil
@@ -379,9 +1006,9 @@ func (mdb *MockDB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
 }
 
 // CheckForMatching database mock
-func (mdb *MockDB) CheckForMatching(signLog SigningLog) (bool, error) {
+func (mdb *MockDB) CheckForMatching(ctx context.Context, signLog SigningLog) (bool, error) {
 	switch signLog.SerialNumber {
-	case "Aduplicate":
+	case "Aduplicate", "Aconflict":
 		return true, nil
 	case "AnError":
 		return false, errors.New("Error in check for duplicate")
@@ -390,7 +1017,7 @@ func (mdb *MockDB) CheckForMatching(signLog SigningLog) (bool, error) {
 }
 
 // CreateSigningLog database mock
-func (mdb *MockDB) CreateSigningLog(signLog SigningLog) error {
+func (mdb *MockDB) CreateSigningLog(ctx context.Context, signLog SigningLog) error {
 	if signLog.SerialNumber == "AsigninglogError" {
 		return errors.New("Error in check for create signing log entry")
 	}
@@ -398,7 +1025,7 @@ func (mdb *MockDB) CreateSigningLog(signLog SigningLog) error {
 }
 
 // CreateSigningLogSync database mock
-func (mdb *MockDB) CreateSigningLogSync(signLog SigningLog) error {
+func (mdb *MockDB) CreateSigningLogSync(ctx context.Context, signLog SigningLog) error {
 	if signLog.SerialNumber == "AsigninglogError" {
 		return errors.New("Error in check for create signing log entry")
 	}
@@ -406,28 +1033,428 @@ func (mdb *MockDB) CreateSigningLogSync(signLog SigningLog) error {
 }
 
 // ListAllowedSigningLog database mock
-func (mdb *MockDB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
-	var fromID = 11
+func (mdb *MockDB) ListAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, page SigningLogPage) ([]SigningLog, string, error) {
+	var maxID = 10
 	signingLog := []SigningLog{}
 
 	if len(authorization.Username) > 0 {
-		fromID = 5
+		maxID = 4
 	}
 
-	for i := 1; i < fromID; i++ {
-		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: time.Now()})
+	fromID, limit, err := page.keyset()
+	if err != nil {
+		return nil, "", err
 	}
-	return signingLog, nil
+
+	for i := maxID; i > 0; i-- {
+		l := SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: time.Now()}
+		if i >= fromID || (filter.Model != "" && filter.Model != l.Model) ||
+			!strings.Contains(l.SerialNumber, filter.Serial) || (filter.Fingerprint != "" && filter.Fingerprint != l.Fingerprint) ||
+			!(containsFold(l.SerialNumber, filter.Search) || containsFold(l.Fingerprint, filter.Search)) {
+			continue
+		}
+		signingLog = append(signingLog, l)
+	}
+
+	signingLog, next := nextSigningLogPage(signingLog, limit)
+	return signingLog, next, nil
 }
 
-// ListAllowedSigningLogForAccount database mock
-func (mdb *MockDB) ListAllowedSigningLogForAccount(authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
-	return mdb.ListAllowedSigningLog(authorization)
+// containsFold checks if the value contains the substring, ignoring the case like ILIKE
+func containsFold(value, substr string) bool {
+	return strings.Contains(strings.ToLower(value), strings.ToLower(substr))
 }
 
-// SyncSigningLog database mock
-func (mdb *MockDB) SyncSigningLog() ([]SigningLog, error) {
-	signingLog := []SigningLog{}
+// StreamAllowedSigningLog database mock
+func (mdb *MockDB) StreamAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, fn func(SigningLog) error) error {
+	logs, _, err := mdb.ListAllowedSigningLog(ctx, authorization, filter, SigningLogPage{Limit: ListSigningLogMaxLimit})
+	if err != nil {
+		return err
+	}
+	for _, l := range logs {
+		if err = fn(l); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AllowedSigningReport database mock, counting the mock signing logs by model and period
+func (mdb *MockDB) AllowedSigningReport(ctx context.Context, authorization User, filter SigningLogFilter, period string) (SigningReport, error) {
+	if err := validateSigningReport(filter, period); err != nil {
+		return SigningReport{}, err
+	}
+
+	logs, _, err := mdb.ListAllowedSigningLog(ctx, authorization, SigningLogFilter{}, SigningLogPage{Limit: ListSigningLogMaxLimit})
+	if err != nil {
+		return SigningReport{}, err
+	}
+
+	report := SigningReport{Period: period, From: filter.From, To: filter.To, Rows: []SigningReportRow{}}
+	for _, l := range logs {
+		p := time.Date(l.Created.Year(), l.Created.Month(), l.Created.Day(), 0, 0, 0, 0, time.UTC)
+		if period == ReportPeriodWeek {
+			p = p.AddDate(0, 0, -(int(p.Weekday())+6)%7)
+		}
+
+		found := false
+		for i := range report.Rows {
+			if report.Rows[i].Period.Equal(p) && report.Rows[i].Brand == l.Make && report.Rows[i].Model == l.Model {
+				report.Rows[i].Count++
+				found = true
+			}
+		}
+		if !found {
+			report.Rows = append(report.Rows, SigningReportRow{Period: p, Brand: l.Make, Model: l.Model, KeyID: "61abf588e52be7a3", Count: 1})
+		}
+		report.Total++
+	}
+	return report, nil
+}
+
+// ListAllowedSigningLogForFingerprint database mock
+func (mdb *MockDB) ListAllowedSigningLogForFingerprint(ctx context.Context, authorization User, fingerprint string) ([]SigningLog, error) {
+	if len(fingerprint) == 0 {
+		return nil, errors.New("MOCK the device-key fingerprint must be provided")
+	}
+	logs, _, err := mdb.ListAllowedSigningLog(ctx, authorization, SigningLogFilter{Fingerprint: fingerprint}, SigningLogPage{})
+	return logs, err
+}
+
+// ListAllowedDevicesForFingerprint database mock
+func (mdb *MockDB) ListAllowedDevicesForFingerprint(ctx context.Context, authorization User, fingerprint string, limit int) ([]Device, error) {
+	logs, err := mdb.ListAllowedSigningLogForFingerprint(ctx, authorization, fingerprint)
+	if err != nil {
+		return nil, err
+	}
+
+	devices := []Device{}
+	for _, l := range logs {
+		if len(devices) < limit {
+			devices = append(devices, Device{Brand: l.Make, Model: l.Model, SerialNumber: l.SerialNumber, Fingerprint: l.Fingerprint, Revision: l.Revision})
+		}
+	}
+	return devices, nil
+}
+
+// SigningLogWatermark database mock, with ten committed signing logs
+func (mdb *MockDB) SigningLogWatermark(ctx context.Context, now time.Time) (int, error) {
+	return 10, nil
+}
+
+// RunJobLocked database mock, which runs the job
+func (mdb *MockDB) RunJobLocked(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error) {
+	return true, run(ctx)
+}
+
+// ExportSigningLog database mock
+func (mdb *MockDB) ExportSigningLog(ctx context.Context, afterID, watermark, limit int) ([]SigningLog, error) {
+	created := time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC)
+	signingLog := []SigningLog{}
+	for i := afterID + 1; i <= watermark && len(signingLog) < limit; i++ {
+		signingLog = append(signingLog, SigningLog{ID: i, Make: "System", Model: "Router 3400", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: created})
+	}
+	return signingLog, nil
+}
+
+// CreateSigningErrorTable database mock
+func (mdb *MockDB) CreateSigningErrorTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSigningError database mock
+func (mdb *MockDB) CreateSigningError(ctx context.Context, e SigningError) error {
+	return nil
+}
+
+// PurgeSigningErrors database mock
+func (mdb *MockDB) PurgeSigningErrors(ctx context.Context, now time.Time) (int, error) {
+	return 3, nil
+}
+
+// AllowedDashboard database mock
+func (mdb *MockDB) AllowedDashboard(ctx context.Context, authorization User, now time.Time) ([]DashboardAccount, error) {
+	switch authorization.Role {
+	case Invalid, Superuser, SyncUser, Admin:
+	default:
+		return nil, errors.New("MOCK you do not have permissions to see the dashboard")
+	}
+
+	return []DashboardAccount{
+		{AccountID: 1, AuthorityID: "System", ActiveKeypairs: 2, Models: 4, SigningsDay: 3, SigningsWeek: 10, PendingKeypairs: 1, Errors: 1,
+			RecentErrors: []SigningError{{ID: 1, Brand: "System", Model: "alder", Code: "invalid-nonce", Message: "Invalid nonce", Created: now.Add(-time.Hour)}}},
+	}, nil
+}
+
+// CreateAuditChainTable database mock
+func (mdb *MockDB) CreateAuditChainTable(ctx context.Context) error {
+	return nil
+}
+
+// AppendAuditChain database mock
+func (mdb *MockDB) AppendAuditChain(ctx context.Context, now time.Time) (int, error) {
+	return 2, nil
+}
+
+// VerifyAllowedAuditChain database mock, for a chain of 10 entries
+func (mdb *MockDB) VerifyAllowedAuditChain(ctx context.Context, authorization User, from, to int64) (AuditChainVerification, error) {
+	switch authorization.Role {
+	case Invalid, Superuser:
+	default:
+		return AuditChainVerification{}, errors.New("MOCK you do not have permissions to verify the audit chain")
+	}
+
+	from, to, err := auditChainRange(from, to, 10)
+	if err != nil {
+		return AuditChainVerification{}, err
+	}
+	return AuditChainVerification{From: from, To: to, Hash: "abc", Entries: int(to - from + 1), Valid: true, Failures: []AuditChainFailure{}}, nil
+}
+
+// SigningLogAuditChainProof database mock, with an entry for each signing log in a chain of 10 entries
+func (mdb *MockDB) SigningLogAuditChainProof(ctx context.Context, ids []int) (AuditChainProof, error) {
+	proof := AuditChainProof{Entries: []AuditChainProofEntry{}, Last: &AuditChainEntry{Seq: 10, Source: AuditSourceAuditLog, RecordID: 5, Hash: "abc"}}
+	for i, id := range ids {
+		l := SigningLog{ID: id, Make: "system", Model: "alder", SerialNumber: "A123456L", Fingerprint: "a123456l", Revision: 1}
+		e := AuditChainEntry{Seq: int64(i + 1), Source: AuditSourceSigningLog, RecordID: id, RecordHash: signingLogAuditHash(l), Hash: "def"}
+		proof.Entries = append(proof.Entries, AuditChainProofEntry{AuditChainEntry: e, Record: signingLogAuditRecord(l)})
+	}
+	return proof, nil
+}
+
+// CreateAuditLogTable database mock
+func (mdb *MockDB) CreateAuditLogTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateAuditLog database mock, that keeps the entries for the AuditLogs check
+func (mdb *MockDB) CreateAuditLog(ctx context.Context, entry AuditLog) error {
+	entry.ID = len(mdb.auditLogs) + 1
+	entry.Created = time.Now()
+	mdb.auditLogs = append(mdb.auditLogs, entry)
+	return nil
+}
+
+// AuditLogs returns the audit log entries that are recorded by the mock
+func (mdb *MockDB) AuditLogs() []AuditLog {
+	return mdb.auditLogs
+}
+
+// ListAllowedAuditLog database mock
+func (mdb *MockDB) ListAllowedAuditLog(ctx context.Context, authorization User, filter AuditLogFilter, page SigningLogPage) ([]AuditLog, string, error) {
+	var maxID = 10
+	logs := []AuditLog{}
+
+	switch authorization.Role {
+	case Invalid, Superuser:
+	case Admin:
+		maxID = 4
+	default:
+		return nil, "", errors.New("MOCK you do not have permissions to see the audit log")
+	}
+
+	fromID, limit, err := page.keyset()
+	if err != nil {
+		return nil, "", err
+	}
+
+	for i := maxID; i > 0; i-- {
+		l := AuditLog{ID: i, Username: "sv", Action: "update", Object: "model", ObjectID: i, AuthorityID: "System", Before: "{}", After: "{}", Created: time.Now()}
+		if i >= fromID || (filter.Username != "" && filter.Username != l.Username) ||
+			(filter.Action != "" && filter.Action != l.Action) || (filter.Object != "" && filter.Object != l.Object) {
+			continue
+		}
+		logs = append(logs, l)
+	}
+
+	logs, next := nextAuditLogPage(logs, limit)
+	return logs, next, nil
+}
+
+// StartSigningLogBuffer database mock
+func (mdb *MockDB) StartSigningLogBuffer(ctx context.Context) {}
+
+// StopSigningLogBuffer database mock
+func (mdb *MockDB) StopSigningLogBuffer(ctx context.Context) error {
+	return nil
+}
+
+// HashSigningLogSerialNumbers database mock
+func (mdb *MockDB) HashSigningLogSerialNumbers(ctx context.Context, authorityID string) (int, error) {
+	return 2, nil
+}
+
+// ListAllowedSigningLogForAccount database mock
+func (mdb *MockDB) ListAllowedSigningLogForAccount(ctx context.Context, authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+	logs, _, err := mdb.ListAllowedSigningLog(ctx, authorization, SigningLogFilter{}, SigningLogPage{})
+	return logs, err
+}
+
+// CreateAccountQuotaTable mock for the create account quota table method
+func (mdb *MockDB) CreateAccountQuotaTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountQuota mock to get the quota of an account
+func (mdb *MockDB) GetAllowedAccountQuota(ctx context.Context, accountID int, authorization User) (AccountQuota, error) {
+	return AccountQuota{AccountID: accountID, Warning: 80, Limit: 100, Grace: 10, Used: 50}, nil
+}
+
+// UpdateAllowedAccountQuota mock to update the quota of an account
+func (mdb *MockDB) UpdateAllowedAccountQuota(ctx context.Context, quota AccountQuota, authorization User) error {
+	return validateAccountQuota(quota)
+}
+
+// CheckAccountQuota mock to check the quota of an account
+func (mdb *MockDB) CheckAccountQuota(ctx context.Context, authorityID string) (QuotaCheck, error) {
+	return QuotaCheck{State: QuotaOK}, nil
+}
+
+// ReleaseAccountQuota mock to release a device from the quota of an account
+func (mdb *MockDB) ReleaseAccountQuota(ctx context.Context, authorityID string) error {
+	return nil
+}
+
+// CreateAccountArchiveTable mock for the create account archive table method
+func (mdb *MockDB) CreateAccountArchiveTable(ctx context.Context) error {
+	return nil
+}
+
+// DeleteAccount mock to archive and delete an account from a fixed list of accounts
+func (mdb *MockDB) DeleteAccount(ctx context.Context, accountID int, entry AuditLog) (AccountArchive, error) {
+	account, err := mdb.GetAccountByID(ctx, accountID, User{})
+	if err != nil {
+		return AccountArchive{}, err
+	}
+	return AccountArchive{ID: 1, AuthorityID: account.AuthorityID, ArchivedBy: entry.Username, Keypairs: 2, Models: 3, SigningLogs: 10, Users: 1, Created: time.Now()}, nil
+}
+
+// ListAccountArchives mock to list the deleted accounts
+func (mdb *MockDB) ListAccountArchives(ctx context.Context) ([]AccountArchive, error) {
+	return []AccountArchive{{ID: 1, AuthorityID: "retired", ArchivedBy: "root", Keypairs: 2, Models: 3, SigningLogs: 10, Users: 1, Created: time.Now()}}, nil
+}
+
+// GetAccountArchiveBundle mock to get the export bundle of a deleted account
+func (mdb *MockDB) GetAccountArchiveBundle(ctx context.Context, archiveID int) (string, []byte, error) {
+	if archiveID != 1 {
+		return "", nil, errors.New("Cannot find the account archive")
+	}
+	bundle, err := encodeAccountBundle(AccountBundle{AuthorityID: "retired", ArchivedBy: "root"}, func(write func(SigningLog) error) error {
+		return write(SigningLog{ID: 1, Make: "retired", Model: "retired-basic", SerialNumber: "R1", Fingerprint: "fp-R1"})
+	})
+	return "retired", bundle, err
+}
+
+// CreateAccountRetentionTable mock for the create account retention table method
+func (mdb *MockDB) CreateAccountRetentionTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSigningLogPurgeTable mock for the create signing log purge table method
+func (mdb *MockDB) CreateSigningLogPurgeTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountRetention mock to get the retention of an account
+func (mdb *MockDB) GetAllowedAccountRetention(ctx context.Context, accountID int, authorization User) (AccountRetention, error) {
+	return AccountRetention{AccountID: accountID, Override: true, Months: 24}, nil
+}
+
+// UpdateAllowedAccountRetention mock to update the retention of an account
+func (mdb *MockDB) UpdateAllowedAccountRetention(ctx context.Context, retention AccountRetention, authorization User) error {
+	return validateAccountRetention(retention)
+}
+
+// UpdateAllowedAccountDefaultKeypair mock to set the default keypair of an account
+func (mdb *MockDB) UpdateAllowedAccountDefaultKeypair(ctx context.Context, accountID, keypairID int, authorization User) error {
+	acc, err := mdb.GetAccountByID(ctx, accountID, authorization)
+	if err != nil {
+		return err
+	}
+	if keypairID == 0 {
+		return nil
+	}
+	keypair, _ := mdb.GetKeypair(ctx, keypairID)
+	return validateDefaultKeypair(acc, keypair)
+}
+
+// PurgeSigningLog mock to purge the signing logs
+func (mdb *MockDB) PurgeSigningLog(ctx context.Context, defaultMonths int, now time.Time) ([]SigningLogPurge, error) {
+	if defaultMonths == 0 {
+		return []SigningLogPurge{}, nil
+	}
+	return []SigningLogPurge{{ID: 1, AuthorityID: "system", Months: defaultMonths, Before: now.AddDate(0, -defaultMonths, 0), Purged: 5, Created: now}}, nil
+}
+
+// ListAllowedSigningLogPurges mock to list the signing log purges
+func (mdb *MockDB) ListAllowedSigningLogPurges(ctx context.Context, authorization User) ([]SigningLogPurge, error) {
+	return mdb.PurgeSigningLog(ctx, 12, time.Now())
+}
+
+// CreateSigningLogPartitions mock to create the signing log partitions
+func (mdb *MockDB) CreateSigningLogPartitions(ctx context.Context, from time.Time, ahead int) ([]string, error) {
+	partitions := []string{}
+	for i := 0; i <= ahead; i++ {
+		partitions = append(partitions, SigningLogPartitionName(from.AddDate(0, i, 0)))
+	}
+	return partitions, nil
+}
+
+// PartitionSigningLogTable mock to partition the signing log table
+func (mdb *MockDB) PartitionSigningLogTable(ctx context.Context, now time.Time) error {
+	return nil
+}
+
+// ListAllowedDevices database mock
+func (mdb *MockDB) ListAllowedDevices(ctx context.Context, serialNumber string, authorization User) ([]Device, error) {
+	if len(serialNumber) == 0 {
+		return nil, errors.New("MOCK the serial number must be provided")
+	}
+	if serialNumber == "A123456L" {
+		return []Device{
+			{Brand: "system", Model: "alder", SerialNumber: "A123456L", Fingerprint: "a123456l", Revision: 1, Signed: time.Now(), TimestampSource: "system",
+				PivotStatus: PivotNone, RevocationState: DeviceActive},
+		}, nil
+	}
+	if serialNumber != "A1" {
+		return []Device{}, nil
+	}
+
+	return []Device{
+		{Brand: "System", Model: "Router 3400", SerialNumber: "A1", Fingerprint: "a1", Revision: 1, Signed: time.Now(), TimestampSource: "system",
+			PivotStatus: PivotNone, RevocationState: DeviceActive},
+	}, nil
+}
+
+// CreateDataPurgeTable mock for the create data purge table method
+func (mdb *MockDB) CreateDataPurgeTable(ctx context.Context) error {
+	return nil
+}
+
+// PurgeDeviceData mock to purge the data of a device, signing the certificate with the keystore secret
+func (mdb *MockDB) PurgeDeviceData(ctx context.Context, request DataPurgeRequest, entry AuditLog) (DeletionCertificate, error) {
+	if err := request.Validate(); err != nil {
+		return DeletionCertificate{}, err
+	}
+	subject, value := request.subject()
+	subjectHash, err := Environ.PurgeSubjectHash(subject, value)
+	if err != nil {
+		return DeletionCertificate{}, err
+	}
+	cert := DeletionCertificate{ID: 1, Subject: subject, SubjectHash: subjectHash, Mode: request.Mode, SigningLogs: 2, Substores: 1,
+		PurgedBy: entry.Username, Created: time.Now().UTC().Truncate(time.Second)}
+	return Environ.SignDeletionCertificate(cert)
+}
+
+// ListDeletionCertificates mock to list the deletion certificates
+func (mdb *MockDB) ListDeletionCertificates(ctx context.Context) ([]DeletionCertificate, error) {
+	return []DeletionCertificate{{ID: 1, Subject: PurgeSubjectSerial, SubjectHash: "a1b2", Mode: PurgeModeDelete, SigningLogs: 2, Substores: 1,
+		PurgedBy: "root", Created: time.Now().UTC()}}, nil
+}
+
+// SyncSigningLog database mock
+func (mdb *MockDB) SyncSigningLog(ctx context.Context) ([]SigningLog, error) {
+	signingLog := []SigningLog{}
 	for i := 1; i < 5; i++ {
 		signingLog = append(signingLog, SigningLog{ID: i, Make: "system", Model: "alder", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("a%d", i), Created: time.Now()})
 	}
@@ -435,72 +1462,102 @@ func (mdb *MockDB) SyncSigningLog() ([]SigningLog, error) {
 }
 
 // SyncUpdateSigningLog database mock
-func (mdb *MockDB) SyncUpdateSigningLog(id int) error {
+func (mdb *MockDB) SyncUpdateSigningLog(ctx context.Context, id int) error {
+	return nil
+}
+
+// SyncConflictSigningLog database mock
+func (mdb *MockDB) SyncConflictSigningLog(ctx context.Context, id int) error {
 	return nil
 }
 
 // AllowedSigningLogFilterValues database mock
-func (mdb *MockDB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
+func (mdb *MockDB) AllowedSigningLogFilterValues(ctx context.Context, authorization User, authorityID string) (SigningLogFilters, error) {
 	return SigningLogFilters{Makes: []string{"System"}, Models: []string{"Router 3400"}}, nil
 }
 
 // CreateDeviceNonceTable database mock
-func (mdb *MockDB) CreateDeviceNonceTable() error {
+func (mdb *MockDB) CreateDeviceNonceTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterDeviceNonceTable database mock
+func (mdb *MockDB) AlterDeviceNonceTable(ctx context.Context) error {
 	return nil
 }
 
 // DeleteExpiredDeviceNonces database mock
-func (mdb *MockDB) DeleteExpiredDeviceNonces() error {
+func (mdb *MockDB) DeleteExpiredDeviceNonces(ctx context.Context) error {
 	return nil
 }
 
 // CreateDeviceNonce database mock
-func (mdb *MockDB) CreateDeviceNonce() (DeviceNonce, error) {
-	return DeviceNonce{Nonce: "1234567890", TimeStamp: 1234567890}, nil
+func (mdb *MockDB) CreateDeviceNonce(ctx context.Context, ttl time.Duration) (DeviceNonce, error) {
+	return DeviceNonce{Nonce: "1234567890", TimeStamp: 1234567890, Expires: 1234567890 + int64(ttl/time.Second)}, nil
 }
 
 // ValidateDeviceNonce database mock
-func (mdb *MockDB) ValidateDeviceNonce(nonce string) error {
+func (mdb *MockDB) ValidateDeviceNonce(ctx context.Context, nonce string) error {
+	return nil
+}
+
+// DeviceNonceTTL database mock
+func (mdb *MockDB) DeviceNonceTTL(ctx context.Context, apiKey string, defaultTTL time.Duration) (time.Duration, error) {
+	return defaultTTL, nil
+}
+
+// CreateAccountNonceTable database mock
+func (mdb *MockDB) CreateAccountNonceTable(ctx context.Context) error {
 	return nil
 }
 
+// GetAllowedAccountNonceTTL mock to get the nonce validity of an account
+func (mdb *MockDB) GetAllowedAccountNonceTTL(ctx context.Context, accountID int, authorization User) (AccountNonceTTL, error) {
+	return AccountNonceTTL{AccountID: accountID, Override: true, Seconds: 300}, nil
+}
+
+// UpdateAllowedAccountNonceTTL mock to update the nonce validity of an account
+func (mdb *MockDB) UpdateAllowedAccountNonceTTL(ctx context.Context, ttl AccountNonceTTL, authorization User) error {
+	return validateAccountNonceTTL(ttl)
+}
+
 // CreateOpenidNonceTable database mock
-func (mdb *MockDB) CreateOpenidNonceTable() error {
+func (mdb *MockDB) CreateOpenidNonceTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateOpenidNonce database mock
-func (mdb *MockDB) CreateOpenidNonce(nonce OpenidNonce) error {
+func (mdb *MockDB) CreateOpenidNonce(ctx context.Context, nonce OpenidNonce) error {
 	return nil
 }
 
 // CheckUserInAccount verifies that a user has permissions to a specific account
-func (mdb *MockDB) CheckUserInAccount(username, authorityID string) bool {
+func (mdb *MockDB) CheckUserInAccount(ctx context.Context, username, authorityID string) bool {
 	return true
 }
 
 // CreateUserTable §
-func (mdb *MockDB) CreateUserTable() error {
+func (mdb *MockDB) CreateUserTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateAccountUserLinkTable mock for creating database AccountUserLink table operation
-func (mdb *MockDB) CreateAccountUserLinkTable() error {
+func (mdb *MockDB) CreateAccountUserLinkTable(ctx context.Context) error {
 	return nil
 }
 
 // AlterUserTable mock for modifications on User table operation
-func (mdb *MockDB) AlterUserTable() error {
+func (mdb *MockDB) AlterUserTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateUser mock for create user operation
-func (mdb *MockDB) CreateUser(user User) (int, error) {
+func (mdb *MockDB) CreateUser(ctx context.Context, user User) (int, error) {
 	return 740, nil
 }
 
 // ListUsers mock returning a fixed list of users
-func (mdb *MockDB) ListUsers() ([]User, error) {
+func (mdb *MockDB) ListUsers(ctx context.Context) ([]User, error) {
 	var users []User
 	users = append(users, User{
 		ID:       1,
@@ -508,6 +1565,7 @@ func (mdb *MockDB) ListUsers() ([]User, error) {
 		Name:     "Rigoberto Picaporte",
 		Email:    "rigoberto.picaporte@ubuntu.com",
 		Role:     Standard,
+		Active:   true,
 		Accounts: []Account{
 			{
 				ID:          1,
@@ -521,6 +1579,7 @@ func (mdb *MockDB) ListUsers() ([]User, error) {
 		Name:     "Nancy Reagan",
 		Email:    "nancy.reagan@usa.gov",
 		Role:     Standard,
+		Active:   true,
 		Accounts: []Account{
 			{
 				ID:          2,
@@ -534,6 +1593,7 @@ func (mdb *MockDB) ListUsers() ([]User, error) {
 		Name:     "Steven Vault",
 		Email:    "sv@example.com",
 		Role:     Admin,
+		Active:   true,
 		Accounts: []Account{
 			{
 				ID:          3,
@@ -551,19 +1611,22 @@ func (mdb *MockDB) ListUsers() ([]User, error) {
 		Username: "a",
 		Name:     "A",
 		Email:    "a@example.com",
-		Role:     Standard})
+		Role:     Standard,
+		Active:   true})
 	users = append(users, User{
 		ID:       5,
 		Username: "root",
 		Name:     "Root User",
 		Email:    "the_root_user@thisdb.com",
-		Role:     Superuser})
+		Role:     Superuser,
+		Active:   true})
 	users = append(users, User{
 		ID:       6,
 		Username: "sync",
 		Name:     "Sync Vault",
 		Email:    "sync@example.com",
 		Role:     SyncUser,
+		Active:   true,
 		Accounts: []Account{
 			{
 				ID:          3,
@@ -581,9 +1644,9 @@ func (mdb *MockDB) ListUsers() ([]User, error) {
 }
 
 // FindUsers mock trying to find a user in a fixed list of users
-func (mdb *MockDB) FindUsers(query string) ([]User, error) {
-	users, _ := mdb.ListUsers()
-	returnArray := make([]User, 2)
+func (mdb *MockDB) FindUsers(ctx context.Context, query string) ([]User, error) {
+	users, _ := mdb.ListUsers(ctx)
+	returnArray := []User{}
 
 	for _, u := range users {
 		if strings.Contains(u.Username, query) || strings.Contains(u.Email, query) {
@@ -594,8 +1657,8 @@ func (mdb *MockDB) FindUsers(query string) ([]User, error) {
 }
 
 // GetUser mock returning the user if found by id in a fixed list of users
-func (mdb *MockDB) GetUser(userID int) (User, error) {
-	users, _ := mdb.ListUsers()
+func (mdb *MockDB) GetUser(ctx context.Context, userID int) (User, error) {
+	users, _ := mdb.ListUsers(ctx)
 	for _, u := range users {
 		if u.ID == userID {
 			return u, nil
@@ -605,8 +1668,8 @@ func (mdb *MockDB) GetUser(userID int) (User, error) {
 }
 
 // GetUserByUsername mock returning the user if found by username in a fixed list of users
-func (mdb *MockDB) GetUserByUsername(username string) (User, error) {
-	users, _ := mdb.ListUsers()
+func (mdb *MockDB) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	users, _ := mdb.ListUsers(ctx)
 	for _, u := range users {
 		if u.Username == username {
 			return u, nil
@@ -616,8 +1679,8 @@ func (mdb *MockDB) GetUserByUsername(username string) (User, error) {
 }
 
 // GetUserByAPIKey mock returning the user if found by username in a fixed list of users
-func (mdb *MockDB) GetUserByAPIKey(apiKey, username string) (User, error) {
-	users, _ := mdb.ListUsers()
+func (mdb *MockDB) GetUserByAPIKey(ctx context.Context, apiKey, username string) (User, error) {
+	users, _ := mdb.ListUsers(ctx)
 	for _, u := range users {
 		if u.Username == username {
 			return u, nil
@@ -627,58 +1690,85 @@ func (mdb *MockDB) GetUserByAPIKey(apiKey, username string) (User, error) {
 }
 
 // UpdateUser mock for update user operation. Returns error if user not found in a fixed list of users
-func (mdb *MockDB) UpdateUser(user User) error {
-	_, err := mdb.GetUser(user.ID)
+func (mdb *MockDB) UpdateUser(ctx context.Context, user User) error {
+	_, err := mdb.GetUser(ctx, user.ID)
 	return err
 }
 
+// BulkProvisionUsers mock to validate the users against a fixed list of users and accounts.
+// The new users get the IDs from 740
+func (mdb *MockDB) BulkProvisionUsers(ctx context.Context, users []User) ([]BulkUserResult, error) {
+	_, results := checkBulkUsers(ctx, users, mdb.GetUserByUsername, mdb.GetAccount)
+	if bulkUsersFailed(results) {
+		return results, ErrBulkUsersRejected
+	}
+	for i := range results {
+		if results[i].Action == BulkUserCreate {
+			results[i].UserID = 740 + i
+		}
+	}
+	return results, nil
+}
+
+// SetUserActive mock to deactivate or reactivate a user. Returns error if user not found in a fixed list of users
+func (mdb *MockDB) SetUserActive(ctx context.Context, userID int, active bool) error {
+	_, err := mdb.GetUser(ctx, userID)
+	return err
+}
+
+// IsUserActive mock to check the status of a user in a fixed list of users
+func (mdb *MockDB) IsUserActive(ctx context.Context, username string) bool {
+	u, err := mdb.GetUserByUsername(ctx, username)
+	return err == nil && u.Active
+}
+
 // DeleteUser mock for delete user operation. Returns error if user not found in a fixed list of users
-func (mdb *MockDB) DeleteUser(userID int) error {
-	_, err := mdb.GetUser(userID)
+func (mdb *MockDB) DeleteUser(ctx context.Context, userID int) error {
+	_, err := mdb.GetUser(ctx, userID)
 	return err
 }
 
 // ListUserAccounts mock returning a fixed list of accounts
-func (mdb *MockDB) ListUserAccounts(username string) ([]Account, error) {
+func (mdb *MockDB) ListUserAccounts(ctx context.Context, username string) ([]Account, error) {
 	var accounts []Account
 	accounts = append(accounts, Account{ID: 1, AuthorityID: "System", Assertion: "assertion\n"})
 	return accounts, nil
 }
 
 // ListNotUserAccounts mock returning a fixed list of accounts
-func (mdb *MockDB) ListNotUserAccounts(username string) ([]Account, error) {
+func (mdb *MockDB) ListNotUserAccounts(ctx context.Context, username string) ([]Account, error) {
 	var accounts []Account
 	accounts = append(accounts, Account{ID: 2, AuthorityID: "Other", Assertion: "other assertion\n"})
 	return accounts, nil
 }
 
 // ListAccountUsers mock returning a fixed list of users
-func (mdb *MockDB) ListAccountUsers(authorityID string) ([]User, error) {
-	return mdb.ListUsers()
+func (mdb *MockDB) ListAccountUsers(ctx context.Context, authorityID string) ([]User, error) {
+	return mdb.ListUsers(ctx)
 }
 
 // CreateKeypairStatusTable mock the database creation
-func (mdb *MockDB) CreateKeypairStatusTable() error {
+func (mdb *MockDB) CreateKeypairStatusTable(ctx context.Context) error {
 	return nil
 }
 
 // AlterKeypairStatusTable mock the database creation
-func (mdb *MockDB) AlterKeypairStatusTable() error {
+func (mdb *MockDB) AlterKeypairStatusTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateKeypairStatus mocks the creation of a keypair status record
-func (mdb *MockDB) CreateKeypairStatus(ks KeypairStatus) (int, error) {
+func (mdb *MockDB) CreateKeypairStatus(ctx context.Context, ks KeypairStatus) (int, error) {
 	return 4, nil
 }
 
 // UpdateKeypairStatus mocks the update of a keypair status record
-func (mdb *MockDB) UpdateKeypairStatus(ks KeypairStatus) error {
+func (mdb *MockDB) UpdateKeypairStatus(ctx context.Context, ks KeypairStatus) error {
 	return nil
 }
 
 // ListAllowedKeypairStatus lists the keypair statuses
-func (mdb *MockDB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error) {
+func (mdb *MockDB) ListAllowedKeypairStatus(ctx context.Context, authorization User) ([]KeypairStatus, error) {
 	ks := []KeypairStatus{}
 	ks = append(ks, KeypairStatus{ID: 1, AuthorityID: "system", KeyName: "key1", Status: KeypairStatusCreating})
 	ks = append(ks, KeypairStatus{ID: 2, AuthorityID: "system", KeyName: "key2", Status: KeypairStatusEncrypting})
@@ -687,9 +1777,9 @@ func (mdb *MockDB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus
 }
 
 // GetKeypairStatus fetches a single keypair status record
-func (mdb *MockDB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error) {
+func (mdb *MockDB) GetKeypairStatus(ctx context.Context, authorityID, keyName string) (KeypairStatus, error) {
 
-	kss, _ := mdb.ListAllowedKeypairStatus(User{})
+	kss, _ := mdb.ListAllowedKeypairStatus(ctx, User{})
 	for _, ks := range kss {
 		if ks.AuthorityID == authorityID && ks.KeyName == keyName {
 			return ks, nil
@@ -699,32 +1789,32 @@ func (mdb *MockDB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus,
 }
 
 // DeleteKeypairStatus removes a keypair status
-func (mdb *MockDB) DeleteKeypairStatus(ks KeypairStatus) error {
+func (mdb *MockDB) DeleteKeypairStatus(ctx context.Context, ks KeypairStatus) error {
 	return nil
 }
 
 // CreateModelAssertTable mock for creating database model assertion headers table
-func (mdb *MockDB) CreateModelAssertTable() error {
+func (mdb *MockDB) CreateModelAssertTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateModelAssert mock for creating model assertion record
-func (mdb *MockDB) CreateModelAssert(m ModelAssertion) (int, error) {
+func (mdb *MockDB) CreateModelAssert(ctx context.Context, m ModelAssertion) (int, error) {
 	return 1, nil
 }
 
 // AlterModelAssertTable mock for the alter model assertion table method
-func (mdb *MockDB) AlterModelAssertTable() error {
+func (mdb *MockDB) AlterModelAssertTable(ctx context.Context) error {
 	return nil
 }
 
 // UpdateModelAssert mock for updating model assertion record
-func (mdb *MockDB) UpdateModelAssert(m ModelAssertion) error {
+func (mdb *MockDB) UpdateModelAssert(ctx context.Context, m ModelAssertion) error {
 	return nil
 }
 
 // GetModelAssert mock for updating model assertion record
-func (mdb *MockDB) GetModelAssert(modelID int) (ModelAssertion, error) {
+func (mdb *MockDB) GetModelAssert(ctx context.Context, modelID int) (ModelAssertion, error) {
 	if modelID == 2 {
 		return ModelAssertion{
 			ID:            1,
@@ -763,46 +1853,137 @@ func (mdb *MockDB) GetModelAssert(modelID int) (ModelAssertion, error) {
 }
 
 // UpsertModelAssert mock for creating or updating model assertion record
-func (mdb *MockDB) UpsertModelAssert(m ModelAssertion) error {
+func (mdb *MockDB) UpsertModelAssert(ctx context.Context, m ModelAssertion) error {
+	return nil
+}
+
+// CreateSignedModelAssertTable mock for the create signed model assertion table method
+func (mdb *MockDB) CreateSignedModelAssertTable(ctx context.Context) error {
+	return nil
+}
+
+// GetSignedModelAssert mock to get a stored signed model assertion, where none are stored
+func (mdb *MockDB) GetSignedModelAssert(ctx context.Context, modelID, substoreID int) (SignedModelAssertion, error) {
+	return SignedModelAssertion{}, errors.New("Cannot find the signed model assertion")
+}
+
+// ListSignedModelAsserts mock to list the stored signed model assertions
+func (mdb *MockDB) ListSignedModelAsserts(ctx context.Context) ([]SignedModelAssertion, error) {
+	return []SignedModelAssertion{}, nil
+}
+
+// UpsertSignedModelAssert mock to store a signed model assertion
+func (mdb *MockDB) UpsertSignedModelAssert(ctx context.Context, s SignedModelAssertion) error {
+	return nil
+}
+
+// DeleteSignedModelAssert mock to delete a stored signed model assertion
+func (mdb *MockDB) DeleteSignedModelAssert(ctx context.Context, id int) error {
 	return nil
 }
 
 // CreateSubstoreTable mock for the create substore table method
-func (mdb *MockDB) CreateSubstoreTable() error {
+func (mdb *MockDB) CreateSubstoreTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterSubstoreTable mock for the alter substore table method
+func (mdb *MockDB) AlterSubstoreTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateAllowedSubstore mock to create a substore record
-func (mdb *MockDB) CreateAllowedSubstore(store Substore, authorization User) (Substore, error) {
+func (mdb *MockDB) CreateAllowedSubstore(ctx context.Context, store Substore, authorization User) (Substore, error) {
 	substore := Substore{ID: 1, AccountID: store.AccountID, FromModelID: store.FromModelID, Store: store.Store, SerialNumber: store.SerialNumber, ModelName: store.ModelName}
 
 	return substore, nil
 }
 
+// BulkCreateAllowedSubstores mock to create the substore records of a bulk upload, validating the rows
+func (mdb *MockDB) BulkCreateAllowedSubstores(ctx context.Context, accountID int, stores []Substore, authorization User) ([]BulkSubstoreResult, error) {
+	results := make([]BulkSubstoreResult, len(stores))
+	seen := map[string]int{}
+	for i, store := range stores {
+		results[i] = BulkSubstoreResult{Row: i + 1, SerialNumber: store.SerialNumber}
+		if _, err := validateSubstore(store, ""); err != nil {
+			results[i].Error = err.Error()
+		} else if r, ok := seen[store.SerialNumber]; ok {
+			results[i].Error = fmt.Sprintf("The serial numbers overlap with row %d", r)
+		}
+		seen[store.SerialNumber] = i + 1
+	}
+	if bulkSubstoresFailed(results) {
+		return results, ErrBulkSubstoresRejected
+	}
+
+	for i := range results {
+		results[i].ID = 100 + i
+	}
+	return results, nil
+}
+
 // ListSubstores mock to list substore records
-func (mdb *MockDB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
-	fromModel, _ := mdb.GetAllowedModel(1, authorization)
+func (mdb *MockDB) ListSubstores(ctx context.Context, accountID int, authorization User, filter SubstoreFilter) ([]Substore, error) {
+	fromModel, _ := mdb.GetAllowedModel(ctx, 1, authorization)
 
 	substores := []Substore{
 		{ID: 1, AccountID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc1234", ModelName: "alder-mybrand"},
 		{ID: 2, AccountID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc5678", ModelName: "alder-mybrand"},
 	}
 
-	return substores, nil
+	return filterSubstores(substores, filter), nil
+}
+
+// filterSubstores returns the page of the sub-store models that match the filter, like the sub-store list query
+func filterSubstores(stores []Substore, filter SubstoreFilter) []Substore {
+	filtered := []Substore{}
+	for _, s := range stores {
+		if containsFold(s.SerialNumber, filter.Serial) {
+			filtered = append(filtered, s)
+		}
+	}
+
+	if filter.Offset >= len(filtered) {
+		return []Substore{}
+	}
+	filtered = filtered[filter.Offset:]
+	if filter.Limit > 0 && filter.Limit < len(filtered) {
+		filtered = filtered[:filter.Limit]
+	}
+	return filtered
 }
 
 // UpdateAllowedSubstore mock to update a substore record
-func (mdb *MockDB) UpdateAllowedSubstore(store Substore, authorization User) error {
+func (mdb *MockDB) UpdateAllowedSubstore(ctx context.Context, store Substore, authorization User) error {
 	return nil
 }
 
 // DeleteAllowedSubstore mock to update a substore record
-func (mdb *MockDB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
+func (mdb *MockDB) DeleteAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
+	return "", nil
+}
+
+// ListDeletedSubstores mock to list the deleted substore records
+func (mdb *MockDB) ListDeletedSubstores(ctx context.Context, accountID int, authorization User) ([]Substore, error) {
+	fromModel, _ := mdb.GetAllowedModel(ctx, 1, authorization)
+
+	substores := []Substore{
+		{ID: 3, AccountID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc9999", ModelName: "alder-mybrand"},
+	}
+
+	return substores, nil
+}
+
+// RestoreAllowedSubstore mock to restore a deleted substore record
+func (mdb *MockDB) RestoreAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
+	if storeID != 3 {
+		return "error-store-not-found", errors.New("Cannot find the deleted sub-store model")
+	}
 	return "", nil
 }
 
 // GetSubstore mock to get a substore record
-func (mdb *MockDB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
+func (mdb *MockDB) GetSubstore(ctx context.Context, fromModelID int, serialNumber string) (Substore, error) {
 	if serialNumber == "XXX" {
 		return Substore{}, fmt.Errorf("error retrieving database substore for %s, from model %d", serialNumber, fromModelID)
 	}
@@ -816,156 +1997,564 @@ func (mdb *MockDB) GetSubstore(fromModelID int, serialNumber string) (Substore,
 	return Substore{ID: 1, AccountID: 1, FromModelID: 1, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc1234", ModelName: "alder-mybrand"}, nil
 }
 
-// GetSubstoreModel mock to get a substore record
-func (mdb *MockDB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
-	if model == "invalid" {
-		return Substore{}, errors.New("MOCK invalid model")
-	}
-	return mdb.GetSubstore(1, serialNumber)
+// GetSubstoreByID mock to get a substore record by its ID
+func (mdb *MockDB) GetSubstoreByID(ctx context.Context, storeID int) (Substore, error) {
+	if storeID == 2 {
+		return mdb.GetSubstore(ctx, 1, "abc1234X")
+	}
+	if storeID != 1 {
+		return Substore{}, fmt.Errorf("error retrieving database substore %d", storeID)
+	}
+	return mdb.GetSubstore(ctx, 1, "abc1234")
+}
+
+// GetSubstoreModel mock to get a substore record
+func (mdb *MockDB) GetSubstoreModel(ctx context.Context, brand, model, serialNumber string) (Substore, error) {
+	if model == "invalid" {
+		return Substore{}, errors.New("MOCK invalid model")
+	}
+	return mdb.GetSubstore(ctx, 1, serialNumber)
+}
+
+// CreateSubstorePivotTable mock for the create sub-store pivot table method
+func (mdb *MockDB) CreateSubstorePivotTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSubstorePivot mock to record a pivot
+func (mdb *MockDB) CreateSubstorePivot(ctx context.Context, pivot SubstorePivot) error {
+	return nil
+}
+
+// ListAllowedSubstorePivots mock to list the pivots of a device
+func (mdb *MockDB) ListAllowedSubstorePivots(ctx context.Context, fromModelID int, serialNumber string, authorization User) ([]SubstorePivot, error) {
+	if serialNumber == "invalid" {
+		return nil, errors.New("MOCK error listing the pivots")
+	}
+	pivots := []SubstorePivot{
+		{ID: 1, SubstoreID: 1, FromModelID: fromModelID, SerialNumber: serialNumber, ModelName: "alder-switch", Created: time.Now()},
+		{ID: 2, SubstoreID: 2, FromModelID: fromModelID, SerialNumber: serialNumber, FromModelName: "alder-switch", ModelName: "alder-gateway", Created: time.Now()},
+	}
+	return pivots, nil
+}
+
+// CreateUserSubstoreLinkTable mock for the create user sub-store link table method
+func (mdb *MockDB) CreateUserSubstoreLinkTable(ctx context.Context) error {
+	return nil
+}
+
+// ListAllowedSubstoreUsers mock to list the sub-store users
+func (mdb *MockDB) ListAllowedSubstoreUsers(ctx context.Context, accountID int, authorization User) ([]SubstoreUser, error) {
+	users := []SubstoreUser{
+		{User: User{ID: 4, Username: "mybrand-admin", Name: "Sub-store Admin", Email: "mybrand@example.com", Role: SubstoreAdmin}, Stores: []string{"mybrand"}},
+	}
+	return users, nil
+}
+
+// CreateAllowedSubstoreUser mock to create a sub-store user
+func (mdb *MockDB) CreateAllowedSubstoreUser(ctx context.Context, accountID int, user SubstoreUser, authorization User) (int, error) {
+	return 4, nil
+}
+
+// DeleteAllowedSubstoreUser mock to delete a sub-store user
+func (mdb *MockDB) DeleteAllowedSubstoreUser(ctx context.Context, accountID, userID int, authorization User) error {
+	return nil
+}
+
+// GetAllowedSubstore mock to get a substore record
+func (mdb *MockDB) GetAllowedSubstore(ctx context.Context, fromModelID int, serialNumber string, authorization User) (Substore, error) {
+	return mdb.GetSubstore(ctx, fromModelID, serialNumber)
+}
+
+// CreateTestLog mock to create a test log
+func (mdb *MockDB) CreateTestLog(ctx context.Context, testLog TestLog) error {
+	return nil
+}
+
+// ListAllowedTestLog database mock
+func (mdb *MockDB) ListAllowedTestLog(ctx context.Context, authorization User) ([]TestLog, error) {
+	logs := []TestLog{
+		{ID: 1, Brand: "system", Model: "alder", Filename: "test1.xml"},
+		{ID: 2, Brand: "system", Model: "alder", Filename: "test2.xml"},
+	}
+	return logs, nil
+}
+
+// SyncListTestLogs database mock
+func (mdb *MockDB) SyncListTestLogs(ctx context.Context) ([]TestLog, error) {
+	logs := []TestLog{
+		{ID: 1, Brand: "system", Model: "alder", Filename: "test1.xml"},
+		{ID: 2, Brand: "system", Model: "alder", Filename: "test2.xml"},
+	}
+	return logs, nil
+}
+
+// SyncDeleteTestLog database mock
+func (mdb *MockDB) SyncDeleteTestLog(ctx context.Context, ID int) error {
+	if ID > 2 {
+		return errors.New("MOCK error deleting the test log")
+	}
+	return nil
+}
+
+// UpdateAllowedTestLog database mock
+func (mdb *MockDB) UpdateAllowedTestLog(ctx context.Context, ID int, authorization User) error {
+	if authorization.Role >= SyncUser {
+		return nil
+	}
+	return errors.New("MOCK no permissions to update the test log")
+}
+
+// CreateSyncStatusTable database mock
+func (mdb *MockDB) CreateSyncStatusTable(ctx context.Context) error {
+	return nil
+}
+
+// PutSyncStatus mock to record the last sync of a resource
+func (mdb *MockDB) PutSyncStatus(ctx context.Context, status SyncStatus) error {
+	if len(status.Message) == 0 {
+		lastSuccess := status.LastRun
+		status.LastSuccess = &lastSuccess
+	}
+	for i, s := range mdb.syncStatus {
+		if s.Resource == status.Resource {
+			if status.LastSuccess == nil {
+				status.LastSuccess = s.LastSuccess
+			}
+			mdb.syncStatus[i] = status
+			return nil
+		}
+	}
+	mdb.syncStatus = append(mdb.syncStatus, status)
+	return nil
+}
+
+// ListSyncStatus mock to list the last sync of the resources
+func (mdb *MockDB) ListSyncStatus(ctx context.Context) ([]SyncStatus, error) {
+	return append([]SyncStatus{}, mdb.syncStatus...), nil
+}
+
+// HealthCheck mock for a healthy datastore
+func (mdb *MockDB) HealthCheck(ctx context.Context) error {
+	return nil
+}
+
+// Close database mock
+func (mdb *MockDB) Close() error {
+	return nil
+}
+
+// -----------------------------------------------------------------------------
+
+// ErrorMockDB holds the unsuccessful mocks for the database
+type ErrorMockDB struct{}
+
+// CreateModelTable mock for the create model table method
+func (mdb *ErrorMockDB) CreateModelTable(ctx context.Context) error {
+	return errors.New("Error creating the model table")
+}
+
+// AlterModelTable mock for the alter model table method
+func (mdb *ErrorMockDB) AlterModelTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateKeypairTable mock for the create keypair table method
+func (mdb *ErrorMockDB) CreateKeypairTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterKeypairTable mock for the alter keypair table method
+func (mdb *ErrorMockDB) AlterKeypairTable(ctx context.Context) error {
+	return nil
+}
+
+// UpdateKeypairAssertion mock to update the account-key assertion of a keypair
+func (mdb *ErrorMockDB) UpdateKeypairAssertion(ctx context.Context, keypair Keypair, authorization User) (string, error) {
+	return "invalid-assertion", errors.New("MOCK Error updating the keypair assertion")
+}
+
+// CreateSettingsTable mock for the create settings table method
+func (mdb *ErrorMockDB) CreateSettingsTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateAccountTable mock for the create account table method
+func (mdb *ErrorMockDB) CreateAccountTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterAccountTable mock for the create account table method
+func (mdb *ErrorMockDB) AlterAccountTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateAccount mock to create an account record
+func (mdb *ErrorMockDB) CreateAccount(ctx context.Context, account Account) error {
+	return errors.New("MOCK creating the account")
+}
+
+// FindAccount mock to return a single account for the signing requests
+func (mdb *ErrorMockDB) FindAccount(ctx context.Context, authorityID string) (Account, error) {
+	return mdb.GetAccount(ctx, authorityID)
+}
+
+// GetAccount mock to return a single account key
+func (mdb *ErrorMockDB) GetAccount(ctx context.Context, authorityID string) (Account, error) {
+
+	accounts, _ := mdb.ListAllowedAccounts(ctx, User{})
+
+	for _, acc := range accounts {
+		if acc.AuthorityID == authorityID {
+			return acc, nil
+		}
+	}
+	return Account{}, errors.New("Cannot found the account assertion")
+}
+
+// GetAccountByID mock to return a single account key
+func (mdb *ErrorMockDB) GetAccountByID(ctx context.Context, ID int, user User) (Account, error) {
+
+	accounts, _ := mdb.ListAllowedAccounts(ctx, user)
+
+	for _, acc := range accounts {
+		if acc.ID == ID {
+			return acc, nil
+		}
+	}
+	return Account{}, errors.New("Cannot found the account assertion")
+}
+
+// GetAllowedAccount mock to fetch account
+func (mdb *ErrorMockDB) GetAllowedAccount(ctx context.Context, authorityID string, authorization User) (Account, error) {
+	return Account{}, errors.New("MOCK error getting the account")
+}
+
+// ListAllowedAccounts mock to return a list of the available accounts
+func (mdb *ErrorMockDB) ListAllowedAccounts(ctx context.Context, authorization User) ([]Account, error) {
+	return nil, errors.New("Error getting the accounts")
+}
+
+// PutAccount mock to update an account assertion
+func (mdb *ErrorMockDB) PutAccount(ctx context.Context, account Account, authorization User) (string, error) {
+	return "", errors.New("MOCK error upserting the account")
+}
+
+// CreateServiceAccountTable error mock for the database
+func (mdb *ErrorMockDB) CreateServiceAccountTable(ctx context.Context) error {
+	return errors.New("Error creating the service account table")
+}
+
+// ListServiceAccounts error mock to list the service accounts
+func (mdb *ErrorMockDB) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	return nil, errors.New("MOCK error listing the service accounts")
+}
+
+// GetServiceAccount error mock to get a service account
+func (mdb *ErrorMockDB) GetServiceAccount(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	return ServiceAccount{}, errors.New("MOCK error retrieving the service account")
+}
+
+// CreateServiceAccount error mock to create a service account
+func (mdb *ErrorMockDB) CreateServiceAccount(ctx context.Context, sa ServiceAccount) (ServiceAccount, error) {
+	return sa, errors.New("MOCK error creating the service account")
+}
+
+// UpdateServiceAccount error mock to update a service account
+func (mdb *ErrorMockDB) UpdateServiceAccount(ctx context.Context, sa ServiceAccount) error {
+	return errors.New("MOCK error updating the service account")
+}
+
+// ResetServiceAccountSecret error mock to reset the secret of a service account
+func (mdb *ErrorMockDB) ResetServiceAccountSecret(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	return ServiceAccount{}, errors.New("MOCK error resetting the service account secret")
+}
+
+// DeleteServiceAccount error mock to delete a service account
+func (mdb *ErrorMockDB) DeleteServiceAccount(ctx context.Context, serviceAccountID int) error {
+	return errors.New("MOCK error deleting the service account")
+}
+
+// AuthenticateServiceAccount error mock to check the client credentials of a service account
+func (mdb *ErrorMockDB) AuthenticateServiceAccount(ctx context.Context, clientID, secret string) (ServiceAccount, error) {
+	return ServiceAccount{}, errors.New("Invalid client credentials")
+}
+
+// CheckServiceAccount error mock to check that a service account is active
+func (mdb *ErrorMockDB) CheckServiceAccount(ctx context.Context, clientID string) bool {
+	return false
+}
+
+// CreateAccessTokenTable error mock for the database
+func (mdb *ErrorMockDB) CreateAccessTokenTable(ctx context.Context) error {
+	return errors.New("Error creating the access token table")
+}
+
+// ListAccessTokens error mock to list the personal access tokens of a user
+func (mdb *ErrorMockDB) ListAccessTokens(ctx context.Context, userID int) ([]AccessToken, error) {
+	return nil, errors.New("MOCK error retrieving the access tokens")
+}
+
+// CreateAccessToken error mock to create a personal access token
+func (mdb *ErrorMockDB) CreateAccessToken(ctx context.Context, t AccessToken) (AccessToken, error) {
+	return t, errors.New("MOCK error creating the access token")
+}
+
+// DeleteAccessToken error mock to revoke a personal access token
+func (mdb *ErrorMockDB) DeleteAccessToken(ctx context.Context, userID, tokenID int) error {
+	return errors.New("MOCK error revoking the access token")
+}
+
+// AuthenticateAccessToken error mock to check a personal access token
+func (mdb *ErrorMockDB) AuthenticateAccessToken(ctx context.Context, token string) (AccessToken, error) {
+	return AccessToken{}, errors.New("The access token is invalid or has expired")
+}
+
+// CreateInvitationTable error mock for the database
+func (mdb *ErrorMockDB) CreateInvitationTable(ctx context.Context) error {
+	return errors.New("Error creating the invitation table")
+}
+
+// CreateInvitation error mock to invite a pending user
+func (mdb *ErrorMockDB) CreateInvitation(ctx context.Context, inv Invitation, user User) (Invitation, error) {
+	return inv, errors.New("MOCK error creating the invitation")
+}
+
+// AcceptInvitation error mock to activate the pending user of an invitation
+func (mdb *ErrorMockDB) AcceptInvitation(ctx context.Context, invitationID int, user User) (User, error) {
+	return User{}, errors.New("MOCK error accepting the invitation")
+}
+
+// CreateRevokedTokenTable error mock for the database
+func (mdb *ErrorMockDB) CreateRevokedTokenTable(ctx context.Context) error {
+	return errors.New("Error creating the revoked token table")
+}
+
+// RevokeToken error mock to add a session token to the denylist
+func (mdb *ErrorMockDB) RevokeToken(ctx context.Context, username, tokenID string, expires time.Time) error {
+	return errors.New("MOCK error revoking the token")
+}
+
+// RevokeUserTokens error mock to revoke all the session tokens of a user
+func (mdb *ErrorMockDB) RevokeUserTokens(ctx context.Context, username string, expires time.Time) error {
+	return errors.New("MOCK error revoking the sessions of the user")
+}
+
+// UseRefreshToken error mock to record the use of a refresh token
+func (mdb *ErrorMockDB) UseRefreshToken(ctx context.Context, username, tokenID string, expires time.Time) (bool, error) {
+	return false, errors.New("MOCK error using the refresh token")
+}
+
+// IsTokenRevoked error mock to check the denylist of the session tokens. The tokens are
+// not revoked, so the errors of the other methods are returned
+func (mdb *ErrorMockDB) IsTokenRevoked(ctx context.Context, username, tokenID string, issued time.Time) bool {
+	return false
+}
+
+// CreateAuthFailureTable error mock for the database
+func (mdb *ErrorMockDB) CreateAuthFailureTable(ctx context.Context) error {
+	return errors.New("Error creating the auth failure table")
+}
+
+// GetAuthFailure error mock to fetch the failed authentications. Nothing is locked out,
+// so the errors of the other methods are returned
+func (mdb *ErrorMockDB) GetAuthFailure(ctx context.Context, key string) (AuthFailure, error) {
+	return AuthFailure{Key: key}, nil
+}
+
+// RecordAuthFailure error mock to count a failed authentication
+func (mdb *ErrorMockDB) RecordAuthFailure(ctx context.Context, key string, policy LockoutPolicy) (AuthFailure, error) {
+	return AuthFailure{}, errors.New("MOCK error recording the failed authentication")
+}
+
+// ResetAuthFailures error mock to clear the failed authentications
+func (mdb *ErrorMockDB) ResetAuthFailures(ctx context.Context, key string) error {
+	return errors.New("MOCK error clearing the failed authentications")
+}
+
+// CreateAPIKeyTable error mock for the database
+func (mdb *ErrorMockDB) CreateAPIKeyTable(ctx context.Context) error {
+	return nil
+}
+
+// CheckAccountAPIKey error mock to check a named API key of an account
+func (mdb *ErrorMockDB) CheckAccountAPIKey(ctx context.Context, apiKey, authorityID string, scopes ...string) bool {
+	return false
+}
+
+// ListAllowedAPIKeys error mock to list the named API keys of an account
+func (mdb *ErrorMockDB) ListAllowedAPIKeys(ctx context.Context, accountID int, authorization User) ([]APIKey, error) {
+	return nil, errors.New("MOCK error retrieving the API keys")
+}
+
+// GetAllowedAPIKey error mock to get a named API key of an account
+func (mdb *ErrorMockDB) GetAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) (APIKey, error) {
+	return APIKey{}, errors.New("MOCK error retrieving the API key")
+}
+
+// CreateAllowedAPIKey error mock to create a named API key for an account
+func (mdb *ErrorMockDB) CreateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) (APIKey, error) {
+	return k, errors.New("MOCK error creating the API key")
+}
+
+// UpdateAllowedAPIKey error mock to update a named API key of an account
+func (mdb *ErrorMockDB) UpdateAllowedAPIKey(ctx context.Context, k APIKey, authorization User) error {
+	return errors.New("MOCK error updating the API key")
+}
+
+// AlterAPIKeyTable error mock for the database
+func (mdb *ErrorMockDB) AlterAPIKeyTable(ctx context.Context) error {
+	return nil
+}
+
+// EncryptColumns error mock for the database
+func (mdb *ErrorMockDB) EncryptColumns(ctx context.Context) error {
+	return nil
+}
+
+// FindAccountAPIKey error mock to find a named API key by its value. The key is not found,
+// so the errors of the other methods are returned
+func (mdb *ErrorMockDB) FindAccountAPIKey(ctx context.Context, apiKey string) (APIKey, error) {
+	return APIKey{}, sql.ErrNoRows
 }
 
-// GetAllowedSubstore mock to get a substore record
-func (mdb *MockDB) GetAllowedSubstore(fromModelID int, serialNumber string, authorization User) (Substore, error) {
-	return mdb.GetSubstore(fromModelID, serialNumber)
+// RotateAllowedAPIKey error mock to rotate a named API key of an account
+func (mdb *ErrorMockDB) RotateAllowedAPIKey(ctx context.Context, keyID, accountID int, grace time.Duration, authorization User) (APIKey, error) {
+	return APIKey{}, errors.New("MOCK error rotating the API key")
 }
 
-// CreateTestLog mock to create a test log
-func (mdb *MockDB) CreateTestLog(testLog TestLog) error {
-	return nil
+// DeleteAllowedAPIKey error mock to delete a named API key of an account
+func (mdb *ErrorMockDB) DeleteAllowedAPIKey(ctx context.Context, keyID, accountID int, authorization User) error {
+	return errors.New("MOCK error deleting the API key")
 }
 
-// ListAllowedTestLog database mock
-func (mdb *MockDB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
-	logs := []TestLog{
-		{ID: 1, Brand: "system", Model: "alder", Filename: "test1.xml"},
-		{ID: 2, Brand: "system", Model: "alder", Filename: "test2.xml"},
-	}
-	return logs, nil
+// CreateClientCertTable error mock for the database
+func (mdb *ErrorMockDB) CreateClientCertTable(ctx context.Context) error {
+	return errors.New("MOCK error creating the client certificate table")
 }
 
-// SyncListTestLogs database mock
-func (mdb *MockDB) SyncListTestLogs() ([]TestLog, error) {
-	logs := []TestLog{
-		{ID: 1, Brand: "system", Model: "alder", Filename: "test1.xml"},
-		{ID: 2, Brand: "system", Model: "alder", Filename: "test2.xml"},
-	}
-	return logs, nil
+// FindClientCert error mock to find the account of a client certificate
+func (mdb *ErrorMockDB) FindClientCert(ctx context.Context, fingerprint string, sans []string) (ClientCert, error) {
+	return ClientCert{}, errors.New("MOCK error finding the client certificate")
 }
 
-// SyncDeleteTestLog database mock
-func (mdb *MockDB) SyncDeleteTestLog(ID int) error {
-	if ID > 2 {
-		return errors.New("MOCK error deleting the test log")
-	}
-	return nil
+// ListAllowedClientCerts error mock to list the client certificates of an account
+func (mdb *ErrorMockDB) ListAllowedClientCerts(ctx context.Context, accountID int, authorization User) ([]ClientCert, error) {
+	return nil, errors.New("MOCK error listing the client certificates")
 }
 
-// UpdateAllowedTestLog database mock
-func (mdb *MockDB) UpdateAllowedTestLog(ID int, authorization User) error {
-	if authorization.Role >= SyncUser {
-		return nil
-	}
-	return errors.New("MOCK no permissions to update the test log")
+// GetAllowedClientCert error mock to get a client certificate of an account
+func (mdb *ErrorMockDB) GetAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) (ClientCert, error) {
+	return ClientCert{}, errors.New("MOCK error getting the client certificate")
 }
 
-// HealthCheck mock for a healthy datastore
-func (mdb *MockDB) HealthCheck() error {
-	return nil
+// CreateAllowedClientCert error mock to map a client certificate to an account
+func (mdb *ErrorMockDB) CreateAllowedClientCert(ctx context.Context, c ClientCert, authorization User) (ClientCert, error) {
+	return c, errors.New("MOCK error creating the client certificate")
 }
 
-// -----------------------------------------------------------------------------
+// DeleteAllowedClientCert error mock to remove a client certificate of an account
+func (mdb *ErrorMockDB) DeleteAllowedClientCert(ctx context.Context, certID, accountID int, authorization User) error {
+	return errors.New("MOCK error deleting the client certificate")
+}
 
-// ErrorMockDB holds the unsuccessful mocks for the database
-type ErrorMockDB struct{}
+// CreateAccountHMACTable error mock for the database
+func (mdb *ErrorMockDB) CreateAccountHMACTable(ctx context.Context) error {
+	return errors.New("MOCK error creating the account HMAC table")
+}
 
-// CreateModelTable mock for the create model table method
-func (mdb *ErrorMockDB) CreateModelTable() error {
-	return errors.New("Error creating the model table")
+// GetAccountHMACSecret error mock to get the HMAC secret of an account
+func (mdb *ErrorMockDB) GetAccountHMACSecret(ctx context.Context, authorityID string) (string, error) {
+	return "", errors.New("MOCK error retrieving the account HMAC secret")
 }
 
-// AlterModelTable mock for the alter model table method
-func (mdb *ErrorMockDB) AlterModelTable() error {
-	return nil
+// UseRequestSignature error mock to record a request signature
+func (mdb *ErrorMockDB) UseRequestSignature(ctx context.Context, signature string, expires time.Time) error {
+	return errors.New("MOCK error recording the request signature")
 }
 
-// CreateKeypairTable mock for the create keypair table method
-func (mdb *ErrorMockDB) CreateKeypairTable() error {
-	return nil
+// DeleteExpiredRequestSignatures error mock for the database
+func (mdb *ErrorMockDB) DeleteExpiredRequestSignatures(ctx context.Context) error {
+	return errors.New("MOCK error deleting the expired request signatures")
 }
 
-// AlterKeypairTable mock for the alter keypair table method
-func (mdb *ErrorMockDB) AlterKeypairTable() error {
-	return nil
+// GetAllowedAccountHMAC error mock to get whether the requests of an account are signed
+func (mdb *ErrorMockDB) GetAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	return AccountHMAC{}, errors.New("MOCK error retrieving the account HMAC secret")
 }
 
-// UpdateKeypairAssertion mock to update the account-key assertion of a keypair
-func (mdb *ErrorMockDB) UpdateKeypairAssertion(keypair Keypair, authorization User) (string, error) {
-	return "invalid-assertion", errors.New("MOCK Error updating the keypair assertion")
+// RotateAllowedAccountHMAC error mock to generate the HMAC secret of an account
+func (mdb *ErrorMockDB) RotateAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) (AccountHMAC, error) {
+	return AccountHMAC{}, errors.New("MOCK error updating the account HMAC secret")
 }
 
-// CreateSettingsTable mock for the create settings table method
-func (mdb *ErrorMockDB) CreateSettingsTable() error {
-	return nil
+// DeleteAllowedAccountHMAC error mock to remove the HMAC secret of an account
+func (mdb *ErrorMockDB) DeleteAllowedAccountHMAC(ctx context.Context, accountID int, authorization User) error {
+	return errors.New("MOCK error deleting the account HMAC secret")
 }
 
-// CreateAccountTable mock for the create account table method
-func (mdb *ErrorMockDB) CreateAccountTable() error {
-	return nil
+// CreateWebhookTable error mock for the database
+func (mdb *ErrorMockDB) CreateWebhookTable(ctx context.Context) error {
+	return errors.New("MOCK error creating the webhook table")
 }
 
-// AlterAccountTable mock for the create account table method
-func (mdb *ErrorMockDB) AlterAccountTable() error {
-	return nil
+// AlterWebhookTable error mock for the database
+func (mdb *ErrorMockDB) AlterWebhookTable(ctx context.Context) error {
+	return errors.New("MOCK error updating the webhook table")
 }
 
-// CreateAccount mock to create an account record
-func (mdb *ErrorMockDB) CreateAccount(account Account) error {
-	return errors.New("MOCK creating the account")
+// QueueWebhookDeliveries error mock to queue an event
+func (mdb *ErrorMockDB) QueueWebhookDeliveries(ctx context.Context, authorityID, event, payload string) (int, error) {
+	return 0, errors.New("MOCK error queueing the webhook delivery")
 }
 
-// GetAccount mock to return a single account key
-func (mdb *ErrorMockDB) GetAccount(authorityID string) (Account, error) {
+// ClaimWebhookDeliveries error mock to claim the queued deliveries that are due
+func (mdb *ErrorMockDB) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	return nil, errors.New("MOCK error retrieving the webhook deliveries")
+}
 
-	accounts, _ := mdb.ListAllowedAccounts(User{})
+// UpdateWebhookDelivery error mock to record the attempt of a queued delivery
+func (mdb *ErrorMockDB) UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	return errors.New("MOCK error updating the webhook delivery")
+}
 
-	for _, acc := range accounts {
-		if acc.AuthorityID == authorityID {
-			return acc, nil
-		}
-	}
-	return Account{}, errors.New("Cannot found the account assertion")
+// PurgeWebhookDeliveries error mock for the database
+func (mdb *ErrorMockDB) PurgeWebhookDeliveries(ctx context.Context, now time.Time) (int, error) {
+	return 0, errors.New("MOCK error purging the webhook deliveries")
 }
 
-// GetAccountByID mock to return a single account key
-func (mdb *ErrorMockDB) GetAccountByID(ID int, user User) (Account, error) {
+// ListAllowedWebhooks error mock to list the webhooks of an account
+func (mdb *ErrorMockDB) ListAllowedWebhooks(ctx context.Context, accountID int, authorization User) ([]Webhook, error) {
+	return nil, errors.New("MOCK error retrieving the webhooks")
+}
 
-	accounts, _ := mdb.ListAllowedAccounts(user)
+// CreateAllowedWebhook error mock to register a webhook of an account
+func (mdb *ErrorMockDB) CreateAllowedWebhook(ctx context.Context, w Webhook, authorization User) (Webhook, error) {
+	return w, errors.New("MOCK error creating the webhook")
+}
 
-	for _, acc := range accounts {
-		if acc.ID == ID {
-			return acc, nil
-		}
-	}
-	return Account{}, errors.New("Cannot found the account assertion")
+// DeleteAllowedWebhook error mock to remove a webhook of an account
+func (mdb *ErrorMockDB) DeleteAllowedWebhook(ctx context.Context, webhookID, accountID int, authorization User) error {
+	return errors.New("MOCK error deleting the webhook")
 }
 
-// GetAllowedAccount mock to fetch account
-func (mdb *ErrorMockDB) GetAllowedAccount(authorityID string, authorization User) (Account, error) {
-	return Account{}, errors.New("MOCK error getting the account")
+// ListAllowedWebhookDeliveries error mock to list the queued deliveries of a webhook
+func (mdb *ErrorMockDB) ListAllowedWebhookDeliveries(ctx context.Context, webhookID, accountID int, status string, authorization User) ([]WebhookDelivery, error) {
+	return nil, errors.New("MOCK error retrieving the webhook deliveries")
 }
 
-// ListAllowedAccounts mock to return a list of the available accounts
-func (mdb *ErrorMockDB) ListAllowedAccounts(authorization User) ([]Account, error) {
-	return nil, errors.New("Error getting the accounts")
+// CreateNotificationTable error mock for the database
+func (mdb *ErrorMockDB) CreateNotificationTable(ctx context.Context) error {
+	return errors.New("MOCK error creating the notification table")
 }
 
-// PutAccount mock to update an account assertion
-func (mdb *ErrorMockDB) PutAccount(account Account, authorization User) (string, error) {
-	return "", errors.New("MOCK error upserting the account")
+// RecordNotification error mock to record that an event is notified for a reference
+func (mdb *ErrorMockDB) RecordNotification(ctx context.Context, event, reference string) (bool, error) {
+	return false, errors.New("MOCK error recording the notification")
 }
 
 // SyncAccount mock to update the account
-func (mdb *ErrorMockDB) SyncAccount(account Account) error {
+func (mdb *ErrorMockDB) SyncAccount(ctx context.Context, account Account) error {
 	return errors.New("MOCK error syncing the account")
 }
 
@@ -975,86 +2564,121 @@ func (mdb *ErrorMockDB) UpdateAccountAssertion(authorityID, assertion string, re
 }
 
 // UpdateAccount mock to update the account
-func (mdb *ErrorMockDB) UpdateAccount(account Account, authorization User) error {
+func (mdb *ErrorMockDB) UpdateAccount(ctx context.Context, account Account, authorization User) error {
 	return nil
 }
 
 // ListAllowedModels ModelsList Mock the database response for a list of models
-func (mdb *ErrorMockDB) ListAllowedModels(authorization User) ([]Model, error) {
+func (mdb *ErrorMockDB) ListAllowedModels(ctx context.Context, authorization User, filter ModelFilter) ([]Model, error) {
 	return nil, errors.New("Error getting the models")
 }
 
 // FindModel mocks the database response for finding a model, returning an invalid signing-key
-func (mdb *ErrorMockDB) FindModel(brandID, modelName, apiKey string) (Model, error) {
+func (mdb *ErrorMockDB) FindModel(ctx context.Context, brandID, modelName, apiKey string, scopes ...string) (Model, error) {
+	return Model{}, errors.New("Error finding the model")
+}
+
+// FindAccountModel mocks the database response for finding a model of the account
+func (mdb *ErrorMockDB) FindAccountModel(ctx context.Context, brandID, modelName string) (Model, error) {
 	return Model{}, errors.New("Error finding the model")
 }
 
 // CheckModelExists mocks the database response for finding a model
-func (mdb *ErrorMockDB) CheckModelExists(brandID, modelName string) bool {
+func (mdb *ErrorMockDB) CheckModelExists(ctx context.Context, brandID, modelName string) bool {
 	return false
 }
 
 // CheckAPIKey mocks the database response to check the API key
-func (mdb *ErrorMockDB) CheckAPIKey(apiKey string) bool {
+func (mdb *ErrorMockDB) CheckAPIKey(ctx context.Context, apiKey string) bool {
 	return true
 }
 
 // GetAllowedModel mocks the model from the database by ID, returning an error.
-func (mdb *ErrorMockDB) GetAllowedModel(modelID int, authorization User) (Model, error) {
+func (mdb *ErrorMockDB) GetAllowedModel(ctx context.Context, modelID int, authorization User) (Model, error) {
 	return Model{}, errors.New("Error retrieving the model")
 }
 
 // UpdateAllowedModel mocks the model update, returning an error.
-func (mdb *ErrorMockDB) UpdateAllowedModel(model Model, authorization User) (string, error) {
+func (mdb *ErrorMockDB) UpdateAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
 	return "", errors.New("Error updating the database model")
 }
 
 // DeleteAllowedModel mocks the model deletion, returning an error.
-func (mdb *ErrorMockDB) DeleteAllowedModel(model Model, authorization User) (string, error) {
+func (mdb *ErrorMockDB) DeleteAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
 	return "", errors.New("Error deleting the database model")
 }
 
+// ListAllowedDeletedModels mocks the listing of the deleted models, returning an error.
+func (mdb *ErrorMockDB) ListAllowedDeletedModels(ctx context.Context, authorization User) ([]Model, error) {
+	return nil, errors.New("Error listing the deleted models")
+}
+
+// RestoreAllowedModel mocks the model restore, returning an error.
+func (mdb *ErrorMockDB) RestoreAllowedModel(ctx context.Context, modelID int, authorization User) (string, error) {
+	return "", errors.New("Error restoring the database model")
+}
+
+// RotateAllowedModelAPIKey mocks the rotation of the API key of a model, returning an error.
+func (mdb *ErrorMockDB) RotateAllowedModelAPIKey(ctx context.Context, modelID int, overlap time.Duration, authorization User) (ModelAPIKey, error) {
+	return ModelAPIKey{}, errors.New("Error rotating the API key of the model")
+}
+
+// CreateModelRevisionTable error mock for the database
+func (mdb *ErrorMockDB) CreateModelRevisionTable(ctx context.Context) error {
+	return errors.New("Error creating the model revision table")
+}
+
+// ListAllowedModelHistory mocks the revisions of a model, returning an error.
+func (mdb *ErrorMockDB) ListAllowedModelHistory(ctx context.Context, modelID int, authorization User) ([]ModelRevision, error) {
+	return nil, errors.New("Error retrieving the model history")
+}
+
 // CreateAllowedModel mocks creating a new model, returning an error.
-func (mdb *ErrorMockDB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
+func (mdb *ErrorMockDB) CreateAllowedModel(ctx context.Context, model Model, authorization User) (Model, string, error) {
 	return Model{}, "", errors.New("Error creating the database model")
 }
 
+// BulkCreateAllowedModels mocks the bulk import of models, returning an error.
+func (mdb *ErrorMockDB) BulkCreateAllowedModels(ctx context.Context, rows []BulkModel, authorization User) ([]BulkModelResult, error) {
+	return nil, errors.New("Error creating the database models")
+}
+
 // SyncModel mocks creating a new model, returning an error.
-func (mdb *ErrorMockDB) SyncModel(model Model) error {
+func (mdb *ErrorMockDB) SyncModel(ctx context.Context, model Model) error {
 	return errors.New("Error creating the database model")
 }
 
 // GetKeypair error mock for the database
-func (mdb *ErrorMockDB) GetKeypair(keypairID int) (Keypair, error) {
+func (mdb *ErrorMockDB) GetKeypair(ctx context.Context, keypairID int) (Keypair, error) {
 	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
 	return keypair, errors.New("Error fetching from the database")
 }
 
 // GetKeypairByPublicID error mock for the database
-func (mdb *ErrorMockDB) GetKeypairByPublicID(auth, keyID string) (Keypair, error) {
+func (mdb *ErrorMockDB) GetKeypairByPublicID(ctx context.Context, auth, keyID string) (Keypair, error) {
 	keypair := Keypair{AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
 	return keypair, errors.New("Error fetching from the database")
 }
 
 // GetKeypairByName mocks getting a keypair by name
-func (mdb *ErrorMockDB) GetKeypairByName(authorityID, keyName string) (Keypair, error) {
+func (mdb *ErrorMockDB) GetKeypairByName(ctx context.Context, authorityID, keyName string) (Keypair, error) {
 	keypair := Keypair{ID: 1, AuthorityID: "system", KeyID: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", Active: true}
 	return keypair, errors.New("Error fetching from the database")
 }
 
 // ListAllowedKeypairs error mock for the database
-func (mdb *ErrorMockDB) ListAllowedKeypairs(authorization User) ([]Keypair, error) {
+func (mdb *ErrorMockDB) ListAllowedKeypairs(ctx context.Context, authorization User) ([]Keypair, error) {
 	var keypairs []Keypair
 	return keypairs, errors.New("MOCK Error fetching from the database")
 }
 
 // PutKeypair error mock for the database
-func (mdb *ErrorMockDB) PutKeypair(keypair Keypair) (string, error) {
+func (mdb *ErrorMockDB) PutKeypair(ctx context.Context, keypair Keypair) (string, error) {
 	return "", errors.New("Error updating the database")
 }
 
 // CheckKeypairKeynameExists mock checking for keypair by name
-func (mdb *ErrorMockDB) CheckKeypairKeynameExists(authorityID, name string) bool {
+func (mdb *ErrorMockDB) CheckKeypairKeynameExists(ctx context.Context, authorityID, name string) bool {
 	if name == "invalid" {
 		return true
 	}
@@ -1062,265 +2686,560 @@ func (mdb *ErrorMockDB) CheckKeypairKeynameExists(authorityID, name string) bool
 }
 
 // SyncKeypair error mock for the database
-func (mdb *ErrorMockDB) SyncKeypair(keypair SyncKeypair) error {
+func (mdb *ErrorMockDB) SyncKeypair(ctx context.Context, keypair SyncKeypair) error {
 	return errors.New("Error updating the database")
 }
 
 // UpdateAllowedKeypairActive error mock for the database
-func (mdb *ErrorMockDB) UpdateAllowedKeypairActive(keypairID int, active bool, authorization User) error {
+func (mdb *ErrorMockDB) UpdateAllowedKeypairActive(ctx context.Context, keypairID int, active bool, authorization User) error {
 	return errors.New("Error updating the database")
 }
 
 // GetSetting error mock for the database
-func (mdb *ErrorMockDB) GetSetting(code string) (Setting, error) {
+func (mdb *ErrorMockDB) GetSetting(ctx context.Context, code string) (Setting, error) {
 	return Setting{Code: code, Data: code}, nil
 }
 
 // PutSetting error mock for the database
-func (mdb *ErrorMockDB) PutSetting(setting Setting) error {
-	return nil
+func (mdb *ErrorMockDB) PutSetting(ctx context.Context, setting Setting) error {
+	return errors.New("MOCK error storing the setting")
 }
 
 // CheckForDuplicate error mock for the database
-func (mdb *ErrorMockDB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
+func (mdb *ErrorMockDB) CheckForDuplicate(ctx context.Context, signLog *SigningLog) (bool, int, error) {
 	return false, 0, nil
 }
 
 // CheckForMatching error mock for the database
-func (mdb *ErrorMockDB) CheckForMatching(signLog SigningLog) (bool, error) {
+func (mdb *ErrorMockDB) CheckForMatching(ctx context.Context, signLog SigningLog) (bool, error) {
 	return false, nil
 }
 
 // CreateSigningLog error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLog(signLog SigningLog) error {
+func (mdb *ErrorMockDB) CreateSigningLog(ctx context.Context, signLog SigningLog) error {
 	return nil
 }
 
 // CreateSigningLogSync error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLogSync(signLog SigningLog) error {
+func (mdb *ErrorMockDB) CreateSigningLogSync(ctx context.Context, signLog SigningLog) error {
 	return nil
 }
 
 // CreateSigningLogTable error mock for the database
-func (mdb *ErrorMockDB) CreateSigningLogTable() error {
+func (mdb *ErrorMockDB) CreateSigningLogTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateTestLogTable error mock for the database
-func (mdb *ErrorMockDB) CreateTestLogTable() error {
+func (mdb *ErrorMockDB) CreateTestLogTable(ctx context.Context) error {
 	return nil
 }
 
 // ListAllowedSigningLog error mock for the database
-func (mdb *ErrorMockDB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
+func (mdb *ErrorMockDB) ListAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, page SigningLogPage) ([]SigningLog, string, error) {
 	var signingLog []SigningLog
-	return signingLog, errors.New("Error retrieving the signing logs")
+	return signingLog, "", errors.New("Error retrieving the signing logs")
+}
+
+// StreamAllowedSigningLog error mock for the database
+func (mdb *ErrorMockDB) StreamAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, fn func(SigningLog) error) error {
+	return errors.New("Error retrieving the signing logs")
+}
+
+// ListAllowedSigningLogForFingerprint error mock for the database
+func (mdb *ErrorMockDB) ListAllowedSigningLogForFingerprint(ctx context.Context, authorization User, fingerprint string) ([]SigningLog, error) {
+	return nil, errors.New("Error retrieving the signing logs")
+}
+
+// ListAllowedDevicesForFingerprint error mock for the database
+func (mdb *ErrorMockDB) ListAllowedDevicesForFingerprint(ctx context.Context, authorization User, fingerprint string, limit int) ([]Device, error) {
+	return nil, errors.New("Error retrieving the signing logs")
+}
+
+// SigningLogWatermark error mock for the database
+func (mdb *ErrorMockDB) SigningLogWatermark(ctx context.Context, now time.Time) (int, error) {
+	return 0, errors.New("Error retrieving the signing logs")
+}
+
+// RunJobLocked error mock for the database
+func (mdb *ErrorMockDB) RunJobLocked(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error) {
+	return false, errors.New("Error locking the job")
+}
+
+// ExportSigningLog error mock for the database
+func (mdb *ErrorMockDB) ExportSigningLog(ctx context.Context, afterID, watermark, limit int) ([]SigningLog, error) {
+	return nil, errors.New("Error retrieving the signing logs")
+}
+
+// CreateSigningErrorTable error mock for the database
+func (mdb *ErrorMockDB) CreateSigningErrorTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSigningError error mock for the database
+func (mdb *ErrorMockDB) CreateSigningError(ctx context.Context, e SigningError) error {
+	return errors.New("Error recording the signing error")
+}
+
+// PurgeSigningErrors error mock for the database
+func (mdb *ErrorMockDB) PurgeSigningErrors(ctx context.Context, now time.Time) (int, error) {
+	return 0, errors.New("Error purging the signing errors")
+}
+
+// AllowedDashboard error mock for the database
+func (mdb *ErrorMockDB) AllowedDashboard(ctx context.Context, authorization User, now time.Time) ([]DashboardAccount, error) {
+	return nil, errors.New("Error retrieving the dashboard")
+}
+
+// CreateAuditChainTable error mock for the database
+func (mdb *ErrorMockDB) CreateAuditChainTable(ctx context.Context) error {
+	return nil
+}
+
+// AppendAuditChain error mock for the database
+func (mdb *ErrorMockDB) AppendAuditChain(ctx context.Context, now time.Time) (int, error) {
+	return 0, errors.New("Error appending to the audit chain")
+}
+
+// VerifyAllowedAuditChain error mock for the database
+func (mdb *ErrorMockDB) VerifyAllowedAuditChain(ctx context.Context, authorization User, from, to int64) (AuditChainVerification, error) {
+	return AuditChainVerification{}, errors.New("Error verifying the audit chain")
+}
+
+// SigningLogAuditChainProof error mock for the database
+func (mdb *ErrorMockDB) SigningLogAuditChainProof(ctx context.Context, ids []int) (AuditChainProof, error) {
+	return AuditChainProof{}, errors.New("Error retrieving the audit chain proof")
+}
+
+// CreateAuditLogTable error mock for the database
+func (mdb *ErrorMockDB) CreateAuditLogTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateAuditLog error mock for the database
+func (mdb *ErrorMockDB) CreateAuditLog(ctx context.Context, entry AuditLog) error {
+	return errors.New("Error recording the audit log")
+}
+
+// ListAllowedAuditLog error mock for the database
+func (mdb *ErrorMockDB) ListAllowedAuditLog(ctx context.Context, authorization User, filter AuditLogFilter, page SigningLogPage) ([]AuditLog, string, error) {
+	return nil, "", errors.New("Error retrieving the audit log")
+}
+
+// StartSigningLogBuffer error mock for the database
+func (mdb *ErrorMockDB) StartSigningLogBuffer(ctx context.Context) {}
+
+// StopSigningLogBuffer error mock for the database
+func (mdb *ErrorMockDB) StopSigningLogBuffer(ctx context.Context) error {
+	return errors.New("MOCK error writing the buffered signing logs")
+}
+
+// AllowedSigningReport error mock for the database
+func (mdb *ErrorMockDB) AllowedSigningReport(ctx context.Context, authorization User, filter SigningLogFilter, period string) (SigningReport, error) {
+	return SigningReport{}, errors.New("Error retrieving the signing report")
+}
+
+// HashSigningLogSerialNumbers database mock
+func (mdb *ErrorMockDB) HashSigningLogSerialNumbers(ctx context.Context, authorityID string) (int, error) {
+	return 0, errors.New("MOCK error hashing the serial numbers")
 }
 
 // ListAllowedSigningLogForAccount database mock
-func (mdb *ErrorMockDB) ListAllowedSigningLogForAccount(authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
-	return mdb.ListAllowedSigningLog(authorization)
+func (mdb *ErrorMockDB) ListAllowedSigningLogForAccount(ctx context.Context, authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+	logs, _, err := mdb.ListAllowedSigningLog(ctx, authorization, SigningLogFilter{}, SigningLogPage{})
+	return logs, err
+}
+
+// CreateAccountQuotaTable mock for the create account quota table method
+func (mdb *ErrorMockDB) CreateAccountQuotaTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountQuota error mock to get the quota of an account
+func (mdb *ErrorMockDB) GetAllowedAccountQuota(ctx context.Context, accountID int, authorization User) (AccountQuota, error) {
+	return AccountQuota{}, errors.New("MOCK error retrieving the account quota")
+}
+
+// UpdateAllowedAccountQuota error mock to update the quota of an account
+func (mdb *ErrorMockDB) UpdateAllowedAccountQuota(ctx context.Context, quota AccountQuota, authorization User) error {
+	return errors.New("MOCK error updating the account quota")
+}
+
+// CheckAccountQuota error mock to check the quota of an account
+func (mdb *ErrorMockDB) CheckAccountQuota(ctx context.Context, authorityID string) (QuotaCheck, error) {
+	return QuotaCheck{}, errors.New("MOCK error checking the account quota")
+}
+
+// ReleaseAccountQuota error mock to release a device from the quota of an account
+func (mdb *ErrorMockDB) ReleaseAccountQuota(ctx context.Context, authorityID string) error {
+	return errors.New("MOCK error updating the account quota")
+}
+
+// CreateAccountArchiveTable mock for the create account archive table method
+func (mdb *ErrorMockDB) CreateAccountArchiveTable(ctx context.Context) error {
+	return errors.New("Cannot create the account archive table")
+}
+
+// DeleteAccount error mock to archive and delete an account
+func (mdb *ErrorMockDB) DeleteAccount(ctx context.Context, accountID int, entry AuditLog) (AccountArchive, error) {
+	return AccountArchive{}, errors.New("Cannot delete the account")
+}
+
+// ListAccountArchives error mock to list the deleted accounts
+func (mdb *ErrorMockDB) ListAccountArchives(ctx context.Context) ([]AccountArchive, error) {
+	return nil, errors.New("Cannot list the account archives")
+}
+
+// GetAccountArchiveBundle error mock to get the export bundle of a deleted account
+func (mdb *ErrorMockDB) GetAccountArchiveBundle(ctx context.Context, archiveID int) (string, []byte, error) {
+	return "", nil, errors.New("Cannot find the account archive")
+}
+
+// CreateAccountRetentionTable mock for the create account retention table method
+func (mdb *ErrorMockDB) CreateAccountRetentionTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSigningLogPurgeTable mock for the create signing log purge table method
+func (mdb *ErrorMockDB) CreateSigningLogPurgeTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountRetention error mock to get the retention of an account
+func (mdb *ErrorMockDB) GetAllowedAccountRetention(ctx context.Context, accountID int, authorization User) (AccountRetention, error) {
+	return AccountRetention{}, errors.New("MOCK error retrieving the account retention")
+}
+
+// UpdateAllowedAccountRetention error mock to update the retention of an account
+func (mdb *ErrorMockDB) UpdateAllowedAccountRetention(ctx context.Context, retention AccountRetention, authorization User) error {
+	return errors.New("MOCK error updating the account retention")
+}
+
+// UpdateAllowedAccountDefaultKeypair error mock to set the default keypair of an account
+func (mdb *ErrorMockDB) UpdateAllowedAccountDefaultKeypair(ctx context.Context, accountID, keypairID int, authorization User) error {
+	return errors.New("MOCK error updating the default keypair of the account")
+}
+
+// PurgeSigningLog error mock to purge the signing logs
+func (mdb *ErrorMockDB) PurgeSigningLog(ctx context.Context, defaultMonths int, now time.Time) ([]SigningLogPurge, error) {
+	return nil, errors.New("MOCK error purging the signing logs")
+}
+
+// CreateSigningLogPartitions error mock to create the signing log partitions
+func (mdb *ErrorMockDB) CreateSigningLogPartitions(ctx context.Context, from time.Time, ahead int) ([]string, error) {
+	return nil, errors.New("MOCK error creating the signing log partitions")
+}
+
+// PartitionSigningLogTable error mock to partition the signing log table
+func (mdb *ErrorMockDB) PartitionSigningLogTable(ctx context.Context, now time.Time) error {
+	return errors.New("MOCK error partitioning the signing log table")
+}
+
+// ListAllowedSigningLogPurges error mock to list the signing log purges
+func (mdb *ErrorMockDB) ListAllowedSigningLogPurges(ctx context.Context, authorization User) ([]SigningLogPurge, error) {
+	return nil, errors.New("MOCK error retrieving the signing log purges")
+}
+
+// ListAllowedDevices database mock
+func (mdb *ErrorMockDB) ListAllowedDevices(ctx context.Context, serialNumber string, authorization User) ([]Device, error) {
+	return nil, errors.New("MOCK error retrieving devices")
+}
+
+// CreateDataPurgeTable error mock for the database
+func (mdb *ErrorMockDB) CreateDataPurgeTable(ctx context.Context) error {
+	return nil
+}
+
+// PurgeDeviceData error mock to purge the data of a device
+func (mdb *ErrorMockDB) PurgeDeviceData(ctx context.Context, request DataPurgeRequest, entry AuditLog) (DeletionCertificate, error) {
+	return DeletionCertificate{}, errors.New("MOCK error purging the device data")
+}
+
+// ListDeletionCertificates error mock to list the deletion certificates
+func (mdb *ErrorMockDB) ListDeletionCertificates(ctx context.Context) ([]DeletionCertificate, error) {
+	return nil, errors.New("MOCK error retrieving the deletion certificates")
 }
 
 // SyncSigningLog error mock for the database
-func (mdb *ErrorMockDB) SyncSigningLog() ([]SigningLog, error) {
+func (mdb *ErrorMockDB) SyncSigningLog(ctx context.Context) ([]SigningLog, error) {
 	var signingLog []SigningLog
 	return signingLog, errors.New("Error retrieving the signing logs")
 }
 
 // SyncUpdateSigningLog database mock
-func (mdb *ErrorMockDB) SyncUpdateSigningLog(id int) error {
+func (mdb *ErrorMockDB) SyncUpdateSigningLog(ctx context.Context, id int) error {
+	return errors.New("Error updating the signing log")
+}
+
+// SyncConflictSigningLog error mock for the database
+func (mdb *ErrorMockDB) SyncConflictSigningLog(ctx context.Context, id int) error {
 	return errors.New("Error updating the signing log")
 }
 
 // AllowedSigningLogFilterValues error mock for the database
-func (mdb *ErrorMockDB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
+func (mdb *ErrorMockDB) AllowedSigningLogFilterValues(ctx context.Context, authorization User, authorityID string) (SigningLogFilters, error) {
 	return SigningLogFilters{}, errors.New("Error retrieving the signing log filters")
 }
 
 // CreateDeviceNonceTable error mock for the database
-func (mdb *ErrorMockDB) CreateDeviceNonceTable() error {
+func (mdb *ErrorMockDB) CreateDeviceNonceTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterDeviceNonceTable error mock for the database
+func (mdb *ErrorMockDB) AlterDeviceNonceTable(ctx context.Context) error {
 	return nil
 }
 
 // DeleteExpiredDeviceNonces error mock for the database
-func (mdb *ErrorMockDB) DeleteExpiredDeviceNonces() error {
+func (mdb *ErrorMockDB) DeleteExpiredDeviceNonces(ctx context.Context) error {
 	return nil
 }
 
 // CreateDeviceNonce error mock for the database
-func (mdb *ErrorMockDB) CreateDeviceNonce() (DeviceNonce, error) {
+func (mdb *ErrorMockDB) CreateDeviceNonce(ctx context.Context, ttl time.Duration) (DeviceNonce, error) {
 	return DeviceNonce{}, errors.New("MOCK error generating the nonce")
 }
 
 // ValidateDeviceNonce error mock for the database
-func (mdb *ErrorMockDB) ValidateDeviceNonce(nonce string) error {
+func (mdb *ErrorMockDB) ValidateDeviceNonce(ctx context.Context, nonce string) error {
 	return errors.New("MOCK error validating a nonce")
 }
 
+// DeviceNonceTTL error mock for the database
+func (mdb *ErrorMockDB) DeviceNonceTTL(ctx context.Context, apiKey string, defaultTTL time.Duration) (time.Duration, error) {
+	return 0, errors.New("MOCK error retrieving the nonce validity")
+}
+
+// CreateAccountNonceTable error mock for the database
+func (mdb *ErrorMockDB) CreateAccountNonceTable(ctx context.Context) error {
+	return nil
+}
+
+// GetAllowedAccountNonceTTL error mock to get the nonce validity of an account
+func (mdb *ErrorMockDB) GetAllowedAccountNonceTTL(ctx context.Context, accountID int, authorization User) (AccountNonceTTL, error) {
+	return AccountNonceTTL{}, errors.New("MOCK error retrieving the account nonce validity")
+}
+
+// UpdateAllowedAccountNonceTTL error mock to update the nonce validity of an account
+func (mdb *ErrorMockDB) UpdateAllowedAccountNonceTTL(ctx context.Context, ttl AccountNonceTTL, authorization User) error {
+	return errors.New("MOCK error updating the account nonce validity")
+}
+
 // CreateOpenidNonceTable database mock
-func (mdb *ErrorMockDB) CreateOpenidNonceTable() error {
+func (mdb *ErrorMockDB) CreateOpenidNonceTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateOpenidNonce database mock
-func (mdb *ErrorMockDB) CreateOpenidNonce(nonce OpenidNonce) error {
+func (mdb *ErrorMockDB) CreateOpenidNonce(ctx context.Context, nonce OpenidNonce) error {
 	return errors.New("MOCK error generating the nonce")
 }
 
 // CheckUserInAccount verifies that a user has permissions to a specific account
-func (mdb *ErrorMockDB) CheckUserInAccount(username, authorityID string) bool {
+func (mdb *ErrorMockDB) CheckUserInAccount(ctx context.Context, username, authorityID string) bool {
 	return true
 }
 
 // CreateUserTable mock for creating database User table operation
-func (mdb *ErrorMockDB) CreateUserTable() error {
+func (mdb *ErrorMockDB) CreateUserTable(ctx context.Context) error {
 	return errors.New("Could not create User table")
 }
 
 // CreateAccountUserLinkTable mock for creating database AccountUserLink table operation
-func (mdb *ErrorMockDB) CreateAccountUserLinkTable() error {
+func (mdb *ErrorMockDB) CreateAccountUserLinkTable(ctx context.Context) error {
 	return errors.New("Could not create AccountUserLink table")
 }
 
 // AlterUserTable mock for modifications on User table operation
-func (mdb *ErrorMockDB) AlterUserTable() error {
+func (mdb *ErrorMockDB) AlterUserTable(ctx context.Context) error {
 	return errors.New("Could not alter User table")
 }
 
 // CreateUser error mock for create user operation
-func (mdb *ErrorMockDB) CreateUser(user User) (int, error) {
+func (mdb *ErrorMockDB) CreateUser(ctx context.Context, user User) (int, error) {
 	return 0, errors.New("Cannot create user")
 }
 
 // ListUsers mock returning an error for list users operation
-func (mdb *ErrorMockDB) ListUsers() ([]User, error) {
+func (mdb *ErrorMockDB) ListUsers(ctx context.Context) ([]User, error) {
 	return []User{}, errors.New("Could not retrieve users list")
 }
 
 // FindUsers mock returning an error for find users operation
-func (mdb *ErrorMockDB) FindUsers(query string) ([]User, error) {
+func (mdb *ErrorMockDB) FindUsers(ctx context.Context, query string) ([]User, error) {
 	return []User{}, errors.New("Could not find any user")
 }
 
 // GetUser mock returning an error for get user operation
-func (mdb *ErrorMockDB) GetUser(userID int) (User, error) {
+func (mdb *ErrorMockDB) GetUser(ctx context.Context, userID int) (User, error) {
 	return User{}, errors.New("Cannot get the user")
 }
 
 // GetUserByUsername returns error for get user by username operation
-func (mdb *ErrorMockDB) GetUserByUsername(username string) (User, error) {
+func (mdb *ErrorMockDB) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	return User{}, errors.New("Cannot get the user")
 }
 
 // GetUserByAPIKey returns error for get user by username operation
-func (mdb *ErrorMockDB) GetUserByAPIKey(apiKey, username string) (User, error) {
+func (mdb *ErrorMockDB) GetUserByAPIKey(ctx context.Context, apiKey, username string) (User, error) {
 	return User{}, errors.New("Cannot get the user")
 }
 
 // UpdateUser mock returning an error for update user operation
-func (mdb *ErrorMockDB) UpdateUser(user User) error {
+func (mdb *ErrorMockDB) UpdateUser(ctx context.Context, user User) error {
 	return errors.New("Cannot update the user")
 }
 
+// BulkProvisionUsers error mock for the bulk provisioning of users
+func (mdb *ErrorMockDB) BulkProvisionUsers(ctx context.Context, users []User) ([]BulkUserResult, error) {
+	return nil, errors.New("Cannot provision the users")
+}
+
+// SetUserActive error mock to deactivate or reactivate a user
+func (mdb *ErrorMockDB) SetUserActive(ctx context.Context, userID int, active bool) error {
+	return errors.New("Cannot update the status of the user")
+}
+
+// IsUserActive error mock to check the status of a user. The users are active, so the
+// errors of the other methods are returned
+func (mdb *ErrorMockDB) IsUserActive(ctx context.Context, username string) bool {
+	return true
+}
+
 // DeleteUser mock returning an error for delete user operation
-func (mdb *ErrorMockDB) DeleteUser(userID int) error {
+func (mdb *ErrorMockDB) DeleteUser(ctx context.Context, userID int) error {
 	return errors.New("Cannot delete the user")
 }
 
 // ListUserAccounts mock returning an error for list user accounts operation
-func (mdb *ErrorMockDB) ListUserAccounts(username string) ([]Account, error) {
+func (mdb *ErrorMockDB) ListUserAccounts(ctx context.Context, username string) ([]Account, error) {
 	return []Account{}, errors.New("Could not get accounts for that user")
 }
 
 // ListNotUserAccounts mock returning an error for list non-user accounts operation
-func (mdb *ErrorMockDB) ListNotUserAccounts(username string) ([]Account, error) {
+func (mdb *ErrorMockDB) ListNotUserAccounts(ctx context.Context, username string) ([]Account, error) {
 	return []Account{}, errors.New("Could not get accounts not related to that user")
 }
 
 // ListAccountUsers mock returning an error for list account users operation
-func (mdb *ErrorMockDB) ListAccountUsers(authorityID string) ([]User, error) {
+func (mdb *ErrorMockDB) ListAccountUsers(ctx context.Context, authorityID string) ([]User, error) {
 	return []User{}, errors.New("Could not get any user for that account")
 }
 
 // CreateKeypairStatusTable mock the database creation with error
-func (mdb *ErrorMockDB) CreateKeypairStatusTable() error {
+func (mdb *ErrorMockDB) CreateKeypairStatusTable(ctx context.Context) error {
 	return errors.New("Could not create the Keypair Status table")
 }
 
 // AlterKeypairStatusTable mock the database creation
-func (mdb *ErrorMockDB) AlterKeypairStatusTable() error {
+func (mdb *ErrorMockDB) AlterKeypairStatusTable(ctx context.Context) error {
 	return errors.New("Could not update the Keypair Status table")
 }
 
 // CreateKeypairStatus mocks the creation of a keypair status record
-func (mdb *ErrorMockDB) CreateKeypairStatus(ks KeypairStatus) (int, error) {
+func (mdb *ErrorMockDB) CreateKeypairStatus(ctx context.Context, ks KeypairStatus) (int, error) {
 	return 0, errors.New("Cannot create keypair status record")
 }
 
 // UpdateKeypairStatus mocks the update of a keypair status record
-func (mdb *ErrorMockDB) UpdateKeypairStatus(ks KeypairStatus) error {
+func (mdb *ErrorMockDB) UpdateKeypairStatus(ctx context.Context, ks KeypairStatus) error {
 	return errors.New("Cannot update keypair status record")
 }
 
 // ListAllowedKeypairStatus lists the keypair statuses
-func (mdb *ErrorMockDB) ListAllowedKeypairStatus(authorization User) ([]KeypairStatus, error) {
+func (mdb *ErrorMockDB) ListAllowedKeypairStatus(ctx context.Context, authorization User) ([]KeypairStatus, error) {
 	return []KeypairStatus{}, errors.New("Cannot update keypair status record")
 }
 
 // GetKeypairStatus fetches a single keypair status record
-func (mdb *ErrorMockDB) GetKeypairStatus(authorityID, keyName string) (KeypairStatus, error) {
+func (mdb *ErrorMockDB) GetKeypairStatus(ctx context.Context, authorityID, keyName string) (KeypairStatus, error) {
 	return KeypairStatus{}, errors.New("Cannot find the keypair status")
 }
 
 // DeleteKeypairStatus removes a keypair status
-func (mdb *ErrorMockDB) DeleteKeypairStatus(ks KeypairStatus) error {
+func (mdb *ErrorMockDB) DeleteKeypairStatus(ctx context.Context, ks KeypairStatus) error {
 	return errors.New("Cannot delete the keypair status")
 }
 
 // CreateModelAssertTable mock for creating database model assertion headers table
-func (mdb *ErrorMockDB) CreateModelAssertTable() error {
+func (mdb *ErrorMockDB) CreateModelAssertTable(ctx context.Context) error {
 	return errors.New("Cannot create the model assertion table")
 }
 
 // CreateModelAssert mock for creating model assertion record
-func (mdb *ErrorMockDB) CreateModelAssert(m ModelAssertion) (int, error) {
+func (mdb *ErrorMockDB) CreateModelAssert(ctx context.Context, m ModelAssertion) (int, error) {
 	return 0, errors.New("Cannot create the model assertion record")
 }
 
 // AlterModelAssertTable mock for the alter model assertion table method
-func (mdb *ErrorMockDB) AlterModelAssertTable() error {
+func (mdb *ErrorMockDB) AlterModelAssertTable(ctx context.Context) error {
 	return errors.New("Cannot amend the model assertion table")
 }
 
 // UpdateModelAssert mock for updating model assertion record
-func (mdb *ErrorMockDB) UpdateModelAssert(m ModelAssertion) error {
+func (mdb *ErrorMockDB) UpdateModelAssert(ctx context.Context, m ModelAssertion) error {
 	return errors.New("Cannot update the model assertion record")
 }
 
 // GetModelAssert mock for updating model assertion record
-func (mdb *ErrorMockDB) GetModelAssert(modelID int) (ModelAssertion, error) {
+func (mdb *ErrorMockDB) GetModelAssert(ctx context.Context, modelID int) (ModelAssertion, error) {
 	return ModelAssertion{}, errors.New("Cannot find the model assertion record")
 }
 
 // UpsertModelAssert mock for creating or updating model assertion record
-func (mdb *ErrorMockDB) UpsertModelAssert(m ModelAssertion) error {
+func (mdb *ErrorMockDB) UpsertModelAssert(ctx context.Context, m ModelAssertion) error {
 	return errors.New("Cannot upsert the model assertion record")
 }
 
+// CreateSignedModelAssertTable mock for the create signed model assertion table method
+func (mdb *ErrorMockDB) CreateSignedModelAssertTable(ctx context.Context) error {
+	return errors.New("Cannot create the signed model assertion table")
+}
+
+// GetSignedModelAssert mock to get a stored signed model assertion
+func (mdb *ErrorMockDB) GetSignedModelAssert(ctx context.Context, modelID, substoreID int) (SignedModelAssertion, error) {
+	return SignedModelAssertion{}, errors.New("Cannot find the signed model assertion")
+}
+
+// ListSignedModelAsserts mock to list the stored signed model assertions
+func (mdb *ErrorMockDB) ListSignedModelAsserts(ctx context.Context) ([]SignedModelAssertion, error) {
+	return nil, errors.New("Cannot list the signed model assertions")
+}
+
+// UpsertSignedModelAssert mock to store a signed model assertion
+func (mdb *ErrorMockDB) UpsertSignedModelAssert(ctx context.Context, s SignedModelAssertion) error {
+	return errors.New("Cannot store the signed model assertion")
+}
+
+// DeleteSignedModelAssert mock to delete a stored signed model assertion
+func (mdb *ErrorMockDB) DeleteSignedModelAssert(ctx context.Context, id int) error {
+	return errors.New("Cannot delete the signed model assertion")
+}
+
 // CreateSubstoreTable mock for the create substore table method
-func (mdb *ErrorMockDB) CreateSubstoreTable() error {
+func (mdb *ErrorMockDB) CreateSubstoreTable(ctx context.Context) error {
+	return nil
+}
+
+// AlterSubstoreTable mock for the alter substore table method
+func (mdb *ErrorMockDB) AlterSubstoreTable(ctx context.Context) error {
 	return nil
 }
 
 // CreateAllowedSubstore mock to create a substore record
-func (mdb *ErrorMockDB) CreateAllowedSubstore(store Substore, authorization User) (Substore, error) {
+func (mdb *ErrorMockDB) CreateAllowedSubstore(ctx context.Context, store Substore, authorization User) (Substore, error) {
 	return store, errors.New("Cannot create the sub-store model")
 }
 
+// BulkCreateAllowedSubstores mock to create the substore records of a bulk upload
+func (mdb *ErrorMockDB) BulkCreateAllowedSubstores(ctx context.Context, accountID int, stores []Substore, authorization User) ([]BulkSubstoreResult, error) {
+	return nil, errors.New("Cannot create the sub-store models")
+}
+
 // ListSubstores mock to list substore records
-func (mdb *ErrorMockDB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
-	fromModel, _ := mdb.GetAllowedModel(1, authorization)
+func (mdb *ErrorMockDB) ListSubstores(ctx context.Context, accountID int, authorization User, filter SubstoreFilter) ([]Substore, error) {
+	fromModel, _ := mdb.GetAllowedModel(ctx, 1, authorization)
 
 	substores := []Substore{
 		{ID: 1, FromModelID: fromModel.ID, FromModel: fromModel, Store: "mybrand", SerialNumber: "abc1234", ModelName: "alder-mybrand"},
@@ -1331,56 +3250,126 @@ func (mdb *ErrorMockDB) ListSubstores(accountID int, authorization User) ([]Subs
 }
 
 // UpdateAllowedSubstore mock to update a substore record
-func (mdb *ErrorMockDB) UpdateAllowedSubstore(store Substore, authorization User) error {
+func (mdb *ErrorMockDB) UpdateAllowedSubstore(ctx context.Context, store Substore, authorization User) error {
 	return errors.New("Cannot update the sub-store model")
 }
 
 // DeleteAllowedSubstore mock to update a substore record
-func (mdb *ErrorMockDB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
+func (mdb *ErrorMockDB) DeleteAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
 	return "", errors.New("Cannot delete the sub-store model")
 }
 
+// ListDeletedSubstores mock to list the deleted substore records
+func (mdb *ErrorMockDB) ListDeletedSubstores(ctx context.Context, accountID int, authorization User) ([]Substore, error) {
+	return nil, errors.New("Cannot list the deleted sub-stores")
+}
+
+// RestoreAllowedSubstore mock to restore a deleted substore record
+func (mdb *ErrorMockDB) RestoreAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
+	return "", errors.New("Cannot restore the sub-store model")
+}
+
 // GetSubstore mock to get a substore record
-func (mdb *ErrorMockDB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
+func (mdb *ErrorMockDB) GetSubstore(ctx context.Context, fromModelID int, serialNumber string) (Substore, error) {
+	return Substore{}, errors.New("Cannot get the sub-store model")
+}
+
+// GetSubstoreByID mock to get a substore record by its ID
+func (mdb *ErrorMockDB) GetSubstoreByID(ctx context.Context, storeID int) (Substore, error) {
 	return Substore{}, errors.New("Cannot get the sub-store model")
 }
 
 // GetSubstoreModel mock to get a substore record
-func (mdb *ErrorMockDB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
+func (mdb *ErrorMockDB) GetSubstoreModel(ctx context.Context, brand, model, serialNumber string) (Substore, error) {
 	return Substore{}, errors.New("Cannot get the sub-store model")
 }
 
+// CreateSubstorePivotTable mock for the create sub-store pivot table method
+func (mdb *ErrorMockDB) CreateSubstorePivotTable(ctx context.Context) error {
+	return nil
+}
+
+// CreateSubstorePivot mock to record a pivot
+func (mdb *ErrorMockDB) CreateSubstorePivot(ctx context.Context, pivot SubstorePivot) error {
+	return errors.New("Cannot record the pivot")
+}
+
+// ListAllowedSubstorePivots mock to list the pivots of a device
+func (mdb *ErrorMockDB) ListAllowedSubstorePivots(ctx context.Context, fromModelID int, serialNumber string, authorization User) ([]SubstorePivot, error) {
+	return nil, errors.New("Cannot list the pivots")
+}
+
+// CreateUserSubstoreLinkTable mock for the create user sub-store link table method
+func (mdb *ErrorMockDB) CreateUserSubstoreLinkTable(ctx context.Context) error {
+	return nil
+}
+
+// ListAllowedSubstoreUsers mock to list the sub-store users
+func (mdb *ErrorMockDB) ListAllowedSubstoreUsers(ctx context.Context, accountID int, authorization User) ([]SubstoreUser, error) {
+	return nil, errors.New("Cannot list the sub-store users")
+}
+
+// CreateAllowedSubstoreUser mock to create a sub-store user
+func (mdb *ErrorMockDB) CreateAllowedSubstoreUser(ctx context.Context, accountID int, user SubstoreUser, authorization User) (int, error) {
+	return 0, errors.New("Cannot create the sub-store user")
+}
+
+// DeleteAllowedSubstoreUser mock to delete a sub-store user
+func (mdb *ErrorMockDB) DeleteAllowedSubstoreUser(ctx context.Context, accountID, userID int, authorization User) error {
+	return errors.New("Cannot delete the sub-store user")
+}
+
 // GetAllowedSubstore mock to get a substore record
-func (mdb *ErrorMockDB) GetAllowedSubstore(fromModelID int, serialNumber string, authorization User) (Substore, error) {
-	return mdb.GetSubstore(fromModelID, serialNumber)
+func (mdb *ErrorMockDB) GetAllowedSubstore(ctx context.Context, fromModelID int, serialNumber string, authorization User) (Substore, error) {
+	return mdb.GetSubstore(ctx, fromModelID, serialNumber)
 }
 
 // CreateTestLog mock to create a test log
-func (mdb *ErrorMockDB) CreateTestLog(testLog TestLog) error {
+func (mdb *ErrorMockDB) CreateTestLog(ctx context.Context, testLog TestLog) error {
 	return errors.New("MOCK Cannot create the test log")
 }
 
 // ListAllowedTestLog database mock
-func (mdb *ErrorMockDB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
+func (mdb *ErrorMockDB) ListAllowedTestLog(ctx context.Context, authorization User) ([]TestLog, error) {
 	return nil, errors.New("MOCK Cannot fetch the test logs")
 }
 
 // SyncListTestLogs database mock
-func (mdb *ErrorMockDB) SyncListTestLogs() ([]TestLog, error) {
+func (mdb *ErrorMockDB) SyncListTestLogs(ctx context.Context) ([]TestLog, error) {
 	return nil, errors.New("MOCK Cannot fetch the test logs")
 }
 
 // SyncDeleteTestLog database mock
-func (mdb *ErrorMockDB) SyncDeleteTestLog(ID int) error {
+func (mdb *ErrorMockDB) SyncDeleteTestLog(ctx context.Context, ID int) error {
 	return errors.New("MOCK error deleting the test log")
 }
 
 // UpdateAllowedTestLog database mock
-func (mdb *ErrorMockDB) UpdateAllowedTestLog(ID int, authorization User) error {
+func (mdb *ErrorMockDB) UpdateAllowedTestLog(ctx context.Context, ID int, authorization User) error {
 	return errors.New("MOCK error updating the test log")
 }
 
+// CreateSyncStatusTable error mock for the database
+func (mdb *ErrorMockDB) CreateSyncStatusTable(ctx context.Context) error {
+	return errors.New("MOCK error creating the sync status table")
+}
+
+// PutSyncStatus error mock to record the last sync of a resource
+func (mdb *ErrorMockDB) PutSyncStatus(ctx context.Context, status SyncStatus) error {
+	return errors.New("MOCK error recording the sync status")
+}
+
+// ListSyncStatus error mock to list the last sync of the resources
+func (mdb *ErrorMockDB) ListSyncStatus(ctx context.Context) ([]SyncStatus, error) {
+	return nil, errors.New("MOCK error retrieving the sync status")
+}
+
 // HealthCheck mock to simulate failed HealthCheck
-func (mdb *ErrorMockDB) HealthCheck() error {
+func (mdb *ErrorMockDB) HealthCheck(ctx context.Context) error {
 	return errors.New("Health check failed")
 }
+
+// Close error mock for the database
+func (mdb *ErrorMockDB) Close() error {
+	return errors.New("MOCK error closing the database")
+}
@@ -55,3 +55,20 @@ func GetErrorMockKeyStore(config config.Settings) (*KeypairDatabase, error) {
 	kdb := KeypairDatabase{FilesystemStore, db, nil}
 	return &kdb, err
 }
+
+type mockCardCommand struct{}
+
+func (ccmd *mockCardCommand) runCommand(input []byte, args ...string) ([]byte, error) {
+	return nil, nil
+}
+
+// MockOpenPGPCard replaces the GnuPG commands of the OpenPGP card with a mock,
+// so that a card key can be imported without a card. Returns a function that
+// restores the GnuPG commands
+func MockOpenPGPCard() func() {
+	card := openPGPCard
+	openPGPCard = &OpenPGPCardKeypairOperator{cardCommand: &mockCardCommand{}}
+	return func() {
+		openPGPCard = card
+	}
+}
@@ -20,56 +20,117 @@
 package datastore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/random"
 )
 
-// ListAllowedModels returns the models allowed to be seen to the authorization
-func (db *DB) ListAllowedModels(authorization User) ([]Model, error) {
+// ListModelsDefaultLimit is the default page size of the models list
+const ListModelsDefaultLimit = 50
+
+// ListModelsMaxLimit is the largest page size of the models list
+const ListModelsMaxLimit = 1000
+
+// MaxBulkModels is the largest number of models that are imported in one request
+const MaxBulkModels = 500
+
+// ErrBulkModelsRejected is returned when some rows of the bulk import are invalid
+var ErrBulkModelsRejected = errors.New("Some of the models are invalid, so none of the models have been created")
+
+// BulkModel is a row of a bulk import of models. The signing keys are referenced by their ID,
+// or by their key ID or their name in the brand account
+type BulkModel struct {
+	BrandID         string `json:"brand-id"`
+	Name            string `json:"model"`
+	APIKey          string `json:"api-key"`
+	KeypairID       int    `json:"keypair-id"`
+	KeypairIDUser   int    `json:"keypair-id-user"`
+	SigningKey      string `json:"signing-key"`     // key ID or name of the signing key
+	SystemUserKey   string `json:"system-user-key"` // key ID or name of the system-user key
+	SerialFormat    string `json:"serial-format"`
+	SerialHeaders   string `json:"serial-headers"`
+	SigningHours    string `json:"signing-hours"`
+	SigningTimezone string `json:"signing-timezone"`
+	AllowedCIDRs    string `json:"allowed-cidrs"`
+}
+
+// BulkModelResult is the outcome of a row of the bulk import of models. The rows are
+// numbered from 1, and the error is empty when the row is valid
+type BulkModelResult struct {
+	Row           int    `json:"row"`
+	BrandID       string `json:"brand-id"`
+	Name          string `json:"model"`
+	ModelID       int    `json:"id,omitempty"`
+	KeypairID     int    `json:"keypair-id,omitempty"`
+	KeypairIDUser int    `json:"keypair-id-user,omitempty"`
+	Error         string `json:"error,omitempty"`
+}
+
+// ListAllowedModels returns a page of the filtered models allowed to be seen to the authorization
+func (db *DB) ListAllowedModels(ctx context.Context, authorization User, filter ModelFilter) ([]Model, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.listAllModels()
+		return db.listAllModels(ctx, filter)
 	case Standard:
 		fallthrough
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listModelsFilteredByUser(authorization.Username)
+		return db.listModelsFilteredByUser(ctx, authorization.Username, filter)
+	default:
+		return []Model{}, nil
+	}
+}
+
+// ListAllowedDeletedModels returns the deleted models allowed to be seen to the authorization,
+// which can be restored
+func (db *DB) ListAllowedDeletedModels(ctx context.Context, authorization User) ([]Model, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.listAllDeletedModels(ctx)
+	case Admin:
+		return db.listDeletedModelsFilteredByUser(ctx, authorization.Username)
 	default:
 		return []Model{}, nil
 	}
 }
 
 // GetAllowedModel returns the model allowed to be seen by the authorization
-func (db *DB) GetAllowedModel(modelID int, authorization User) (Model, error) {
+func (db *DB) GetAllowedModel(ctx context.Context, modelID int, authorization User) (Model, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.getModel(modelID)
+		return db.getModel(ctx, modelID)
 	case Admin:
-		return db.getModelFilteredByUser(modelID, authorization.Username)
+		return db.getModelFilteredByUser(ctx, modelID, authorization.Username)
 	default:
 		return Model{}, nil
 	}
 }
 
 // UpdateAllowedModel updates the model if authorization is allowed to do it
-func (db *DB) UpdateAllowedModel(model Model, authorization User) (string, error) {
+func (db *DB) UpdateAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
+	defer db.invalidateCache(cacheModels)
+
 	errorSubcode, err := validateModel(model, "error-validate-model")
 	if err != nil {
 		return errorSubcode, fmt.Errorf("error updating the model: %v", err)
 	}
 
-	if !db.checkBrandsMatch(model.BrandID, model.KeypairID, model.KeypairIDUser) {
+	if !db.checkBrandsMatch(ctx, model.BrandID, model.KeypairID, model.KeypairIDUser) {
 		return "error-auth", errors.New("error updating the model: the model and the keys must have the same brand")
 	}
 
@@ -81,15 +142,18 @@ func (db *DB) UpdateAllowedModel(model Model, authorization User) (string, error
 	model.APIKey = apiKey
 
 	// Get the existing model using the ID
-	m, err := db.getModel(model.ID)
+	m, err := db.getModel(ctx, model.ID)
 	if err != nil {
 		return "error-model-not-found", fmt.Errorf("error updating the model: %v", err)
 	}
+	if m.DeletedAt != nil {
+		return "error-model-deleted", errors.New("error updating the model: the model is deleted and must be restored first")
+	}
 
 	// If the model name is different, check that the new name does not exist
 	if model.BrandID != m.BrandID || model.Name != m.Name {
 		// Check that the new model does not exist
-		if exists := db.CheckModelExists(model.BrandID, model.Name); exists {
+		if exists := db.CheckModelExists(ctx, model.BrandID, model.Name); exists {
 			return "error-model-exists", fmt.Errorf("error updating the model: a device with the same Brand (%s) and Model (%s) already exists", model.BrandID, model.Name)
 		}
 	}
@@ -98,40 +162,147 @@ func (db *DB) UpdateAllowedModel(model Model, authorization User) (string, error
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.updateModel(model)
+		errorSubcode, err = db.updateModel(ctx, model)
 	case Admin:
-		return db.updateModelFilteredByUser(model, authorization.Username)
+		errorSubcode, err = db.updateModelFilteredByUser(ctx, model, authorization.Username)
 	default:
 		return "", nil
 	}
+	if err != nil {
+		return errorSubcode, err
+	}
+
+	db.recordModelRevision(ctx, model.ID, ModelRevisionUpdate, authorization.Username)
+	return "", nil
 }
 
 // DeleteAllowedModel deletes model if allowed to authorization
-func (db *DB) DeleteAllowedModel(model Model, authorization User) (string, error) {
+func (db *DB) DeleteAllowedModel(ctx context.Context, model Model, authorization User) (string, error) {
+	defer db.invalidateCache(cacheModels)
+
+	var (
+		errorSubcode string
+		err          error
+	)
+
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.deleteModel(model)
+		errorSubcode, err = db.deleteModel(ctx, model)
 	case Admin:
-		return db.deleteModelFilteredByUser(model, authorization.Username)
+		errorSubcode, err = db.deleteModelFilteredByUser(ctx, model, authorization.Username)
 	default:
 		return "", nil
 	}
+	if err != nil {
+		return errorSubcode, err
+	}
+
+	db.recordModelRevision(ctx, model.ID, ModelRevisionDelete, authorization.Username)
+	return "", nil
 }
 
-// CreateAllowedModel creates a new model in case authorization is allowed to do it
-func (db *DB) CreateAllowedModel(model Model, authorization User) (Model, string, error) {
+// RestoreAllowedModel reverts the deletion of a model if allowed to authorization
+func (db *DB) RestoreAllowedModel(ctx context.Context, modelID int, authorization User) (string, error) {
+	defer db.invalidateCache(cacheModels)
+
+	var (
+		errorSubcode string
+		err          error
+	)
+
+	// The brand and name of the deleted model may have been reused by another model
+	if m, err := db.getModel(ctx, modelID); err == nil && m.DeletedAt != nil && db.CheckModelExists(ctx, m.BrandID, m.Name) {
+		return "error-model-exists", fmt.Errorf("error restoring the model: a device with the same Brand (%s) and Model (%s) already exists", m.BrandID, m.Name)
+	}
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		errorSubcode, err = db.restoreModel(ctx, modelID)
+	case Admin:
+		errorSubcode, err = db.restoreModelFilteredByUser(ctx, modelID, authorization.Username)
+	default:
+		return "", nil
+	}
+	if err != nil {
+		return errorSubcode, err
+	}
+
+	db.recordModelRevision(ctx, modelID, ModelRevisionRestore, authorization.Username)
+	return "", nil
+}
+
+// ModelAPIKey is the new API key of a model, with the expiry of its previous key
+type ModelAPIKey struct {
+	ModelID         int        `json:"id"`
+	BrandID         string     `json:"brand-id"`
+	Name            string     `json:"model"`
+	APIKey          string     `json:"api-key"`
+	PreviousExpires *time.Time `json:"previous-expires,omitempty"`
+}
+
+// RotateAllowedModelAPIKey issues a new API key for a model, if authorization is allowed to do it.
+// The previous key stays valid for the overlap, so the factories can move to the new key
+func (db *DB) RotateAllowedModelAPIKey(ctx context.Context, modelID int, overlap time.Duration, authorization User) (ModelAPIKey, error) {
+	defer db.invalidateCache(cacheModels)
+
+	if overlap < 0 || overlap > maxAPIKeyGrace {
+		return ModelAPIKey{}, fmt.Errorf("the API key overlap must be between 0s and %v", maxAPIKeyGrace)
+	}
+
+	apiKey, err := generateAPIKey()
+	if err != nil {
+		return ModelAPIKey{}, errors.New("error rotating the API key: error in generating a valid API key")
+	}
+	expires := time.Now().UTC().Add(overlap)
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		err = db.rotateModelAPIKey(ctx, modelID, apiKey, expires)
+	case Admin:
+		err = db.rotateModelAPIKeyFilteredByUser(ctx, modelID, apiKey, expires, authorization.Username)
+	default:
+		return ModelAPIKey{}, nil
+	}
+	if err != nil {
+		return ModelAPIKey{}, err
+	}
+
+	db.recordModelRevision(ctx, modelID, ModelRevisionRotate, authorization.Username)
+
+	model, err := db.getModel(ctx, modelID)
+	if err != nil {
+		return ModelAPIKey{}, fmt.Errorf("error rotating the API key: %v", err)
+	}
+	k := ModelAPIKey{ModelID: model.ID, BrandID: model.BrandID, Name: model.Name, APIKey: model.APIKey}
+	if overlap > 0 {
+		k.PreviousExpires = &expires
+	}
+	return k, nil
+}
+
+// CreateAllowedModel creates a new model in case authorization is allowed to do it. A model without
+// a signing key is linked to the default keypair of the account
+func (db *DB) CreateAllowedModel(ctx context.Context, model Model, authorization User) (Model, string, error) {
+	defer db.invalidateCache(cacheModels)
+
+	model = db.defaultKeypair(ctx, model)
+
 	errorSubcode, err := validateModel(model, "error-validate-new-model")
 	if err != nil {
 		return model, errorSubcode, fmt.Errorf("error creating the model: %v", err)
 	}
 
-	if !db.CheckUserInAccount(authorization.Username, model.BrandID) {
+	if !db.CheckUserInAccount(ctx, authorization.Username, model.BrandID) {
 		return model, "error-auth", errors.New("the user does not have permissions to create a model for this account")
 	}
 
-	if !db.checkBrandsMatch(model.BrandID, model.KeypairID, model.KeypairIDUser) {
+	if !db.checkBrandsMatch(ctx, model.BrandID, model.KeypairID, model.KeypairIDUser) {
 		return model, "error-auth", errors.New("error creating the model: the model and the keys must have the same brand")
 	}
 
@@ -143,20 +314,147 @@ func (db *DB) CreateAllowedModel(model Model, authorization User) (Model, string
 	model.APIKey = apiKey
 
 	// Check that the model does not exist
-	if found := db.CheckModelExists(model.BrandID, model.Name); found {
+	if found := db.CheckModelExists(ctx, model.BrandID, model.Name); found {
 		return model, "error-model-exists", fmt.Errorf("error creating the model: a device with the same Brand (%s) and Model (%s) already exists", model.BrandID, model.Name)
 	}
 
+	var created Model
+
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.createModel(model)
+		created, errorSubcode, err = db.createModel(ctx, model)
 	case Admin:
-		return db.createModelFilteredByUser(model, authorization.Username)
+		created, errorSubcode, err = db.createModelFilteredByUser(ctx, model, authorization.Username)
 	default:
 		return Model{}, "", nil
 	}
+	if err != nil {
+		return created, errorSubcode, err
+	}
+
+	db.recordModelRevision(ctx, created.ID, ModelRevisionCreate, authorization.Username)
+	return created, "", nil
+}
+
+// BulkCreateAllowedModels creates the models of a bulk import, e.g. for a brand onboarding its
+// devices, in a single transaction. All the rows are validated first, and nothing is created
+// when any of them is invalid
+func (db *DB) BulkCreateAllowedModels(ctx context.Context, rows []BulkModel, authorization User) ([]BulkModelResult, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+	case Superuser:
+	case Admin:
+	default:
+		return []BulkModelResult{}, nil
+	}
+
+	models, results := db.checkBulkModels(ctx, rows, authorization)
+	if bulkModelsFailed(results) {
+		return results, ErrBulkModelsRejected
+	}
+
+	defer db.invalidateCache(cacheModels)
+	if err := db.bulkCreateModels(ctx, models, results); err != nil {
+		return results, err
+	}
+
+	for _, r := range results {
+		db.recordModelRevision(ctx, r.ModelID, ModelRevisionCreate, authorization.Username)
+	}
+	return results, nil
+}
+
+// checkBulkModels validates the rows of a bulk import, returning the models to create and
+// the result of each row
+func (db *DB) checkBulkModels(ctx context.Context, rows []BulkModel, authorization User) ([]Model, []BulkModelResult) {
+	models := make([]Model, len(rows))
+	results := make([]BulkModelResult, len(rows))
+	seen := map[string]int{}
+
+	for i, row := range rows {
+		results[i] = BulkModelResult{Row: i + 1, BrandID: row.BrandID, Name: row.Name}
+
+		model, err := db.checkBulkModel(ctx, row, authorization)
+		key := row.BrandID + "/" + row.Name
+		if r, ok := seen[key]; ok {
+			err = fmt.Errorf("The model is also in row %d", r)
+		}
+		seen[key] = i + 1
+
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		results[i].KeypairID = model.KeypairID
+		results[i].KeypairIDUser = model.KeypairIDUser
+		models[i] = model
+	}
+	return models, results
+}
+
+func (db *DB) checkBulkModel(ctx context.Context, row BulkModel, authorization User) (Model, error) {
+	model := Model{BrandID: row.BrandID, Name: row.Name, APIKey: row.APIKey, SerialFormat: row.SerialFormat, SerialHeaders: row.SerialHeaders,
+		SigningHours: row.SigningHours, SigningTimezone: row.SigningTimezone, AllowedCIDRs: row.AllowedCIDRs}
+
+	var err error
+	if model.KeypairID, err = db.bulkModelKeypair(ctx, row.BrandID, row.KeypairID, row.SigningKey); err != nil {
+		return model, err
+	}
+	if model.KeypairIDUser, err = db.bulkModelKeypair(ctx, row.BrandID, row.KeypairIDUser, row.SystemUserKey); err != nil {
+		return model, err
+	}
+	model = db.defaultKeypair(ctx, model)
+
+	if _, err = validateModel(model, ""); err != nil {
+		return model, err
+	}
+
+	if !db.CheckUserInAccount(ctx, authorization.Username, model.BrandID) {
+		return model, errors.New("the user does not have permissions to create a model for this account")
+	}
+
+	if !db.checkBrandsMatch(ctx, model.BrandID, model.KeypairID, model.KeypairIDUser) {
+		return model, errors.New("the model and the keys must have the same brand")
+	}
+
+	if model.APIKey, err = buildValidOrDefaultAPIKey(model.APIKey); err != nil {
+		return model, errors.New("error in generating a valid API key")
+	}
+
+	if db.CheckModelExists(ctx, model.BrandID, model.Name) {
+		return model, fmt.Errorf("a device with the same Brand (%s) and Model (%s) already exists", model.BrandID, model.Name)
+	}
+	return model, nil
+}
+
+// bulkModelKeypair returns the ID of a key of a bulk import row, from its ID or from its
+// key ID or name in the brand account
+func (db *DB) bulkModelKeypair(ctx context.Context, brandID string, keypairID int, reference string) (int, error) {
+	if len(reference) == 0 {
+		return keypairID, nil
+	}
+	if keypairID > 0 {
+		return 0, fmt.Errorf("the key %s must be referenced by its ID or by its name, not both", reference)
+	}
+
+	if keypair, err := db.GetKeypairByPublicID(ctx, brandID, reference); err == nil {
+		return keypair.ID, nil
+	}
+	if keypair, err := db.GetKeypairByName(ctx, brandID, reference); err == nil {
+		return keypair.ID, nil
+	}
+	return 0, fmt.Errorf("cannot find the key %s of the account %s", reference, brandID)
+}
+
+func bulkModelsFailed(results []BulkModelResult) bool {
+	for _, r := range results {
+		if len(r.Error) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func validateModel(model Model, validateModelLabel string) (string, error) {
@@ -181,6 +479,21 @@ func validateModel(model Model, validateModelLabel string) (string, error) {
 		return "error-validate-userkey", fmt.Errorf(errTemplate, model.Name, err)
 	}
 
+	err = validateSerialFormat(model.SerialFormat)
+	if err != nil {
+		return "error-validate-serial-format", fmt.Errorf(errTemplate, model.Name, err)
+	}
+
+	err = validateSerialHeaders(model.SerialHeaders)
+	if err != nil {
+		return "error-validate-serial-headers", fmt.Errorf(errTemplate, model.Name, err)
+	}
+
+	err = validateSigningRestrictions(model)
+	if err != nil {
+		return "error-validate-signing-restrictions", fmt.Errorf(errTemplate, model.Name, err)
+	}
+
 	return "", nil
 }
 
@@ -207,6 +520,92 @@ func validateKeypairIDUser(keypairIDUser int) error {
 	return nil
 }
 
+// validateSerialFormat checks that the optional serial number format is a valid regular expression
+func validateSerialFormat(serialFormat string) error {
+	if len(serialFormat) == 0 {
+		return nil
+	}
+
+	if _, err := compileSerialFormat(serialFormat); err != nil {
+		return fmt.Errorf("the Serial Number Format must be a valid regular expression: %v", err)
+	}
+	return nil
+}
+
+// serialFormats holds the compiled serial number formats, so a format is compiled once when
+// it is validated or first used, and not on each signing request
+var serialFormats sync.Map
+
+// compileSerialFormat anchors the format, so the whole serial number has to match it. The
+// compiled format is stored for the next serial numbers, unless it is invalid
+func compileSerialFormat(serialFormat string) (*regexp.Regexp, error) {
+	if re, ok := serialFormats.Load(serialFormat); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	re, err := regexp.Compile("^(?:" + serialFormat + ")$")
+	if err != nil {
+		return nil, err
+	}
+	serialFormats.Store(serialFormat, re)
+	return re, nil
+}
+
+// MatchesSerialFormat checks that the serial number is valid for the model.
+// All serial numbers are valid when the model has no serial number format
+func (model Model) MatchesSerialFormat(serialNumber string) bool {
+	if len(model.SerialFormat) == 0 {
+		return true
+	}
+
+	re, err := compileSerialFormat(model.SerialFormat)
+	if err != nil {
+		log.Errorf("Invalid serial number format for model %s/%s: %v", model.BrandID, model.Name, err)
+		return false
+	}
+	return re.MatchString(serialNumber)
+}
+
+// serialHeaderNameRegexp is the format of an assertion header name
+var serialHeaderNameRegexp = regexp.MustCompile("^[a-z](?:-?[a-z0-9])*$")
+
+// reservedSerialHeaders are the headers of the serial assertion that are set by the signing service
+var reservedSerialHeaders = []string{
+	"type", "authority-id", "brand-id", "serial", "device-key", "sign-key-sha3-384",
+	"device-key-sha3-384", "model", "timestamp", "revision", "body-length",
+}
+
+// validateSerialHeaders checks the serial-request headers that are copied to the serial assertion
+func validateSerialHeaders(serialHeaders string) error {
+	for _, h := range splitSerialHeaders(serialHeaders) {
+		if !serialHeaderNameRegexp.MatchString(h) {
+			return fmt.Errorf("the serial header '%s' is not a valid header name", h)
+		}
+		for _, reserved := range reservedSerialHeaders {
+			if h == reserved {
+				return fmt.Errorf("the serial header '%s' is set by the Serial Vault and cannot be copied", h)
+			}
+		}
+	}
+	return nil
+}
+
+func splitSerialHeaders(serialHeaders string) []string {
+	headers := []string{}
+	for _, h := range strings.Split(serialHeaders, ",") {
+		h = strings.TrimSpace(h)
+		if len(h) > 0 {
+			headers = append(headers, h)
+		}
+	}
+	return headers
+}
+
+// SerialHeaderList returns the serial-request headers that are copied to the serial assertion
+func (model Model) SerialHeaderList() []string {
+	return splitSerialHeaders(model.SerialHeaders)
+}
+
 // buildValidOrDefaultAPIKey checks the API key and creates a default API key if the field is empty
 func buildValidOrDefaultAPIKey(apiKey string) (string, error) {
 	// Remove all whitespace from the API key
@@ -226,7 +625,7 @@ func generateAPIKey() (string, error) {
 	// Generate an random API key and update the record
 	apiKey, err := random.GenerateRandomString(40)
 	if err != nil {
-		log.Printf("Could not generate random string for the API key")
+		log.Errorf("Could not generate random string for the API key")
 		return "", errors.New("error generating random string for the API key")
 	}
 
@@ -20,7 +20,10 @@
 package datastore
 
 import (
+	"context"
+	"reflect"
 	"testing"
+	"time"
 )
 
 func TestModelName(t *testing.T) {
@@ -52,3 +55,382 @@ func TestModelNameUpperChar(t *testing.T) {
 		t.Error("Error happening is not the one searched for")
 	}
 }
+
+func TestValidateSerialFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		valid  bool
+	}{
+		{"", true},
+		{"A[0-9]{6}L", true},
+		{"A[0-9", false},
+	}
+
+	for _, tt := range tests {
+		err := validateSerialFormat(tt.format)
+		if (err == nil) != tt.valid {
+			t.Errorf("Serial number format %q: expected valid %v, got error: %v", tt.format, tt.valid, err)
+		}
+	}
+}
+
+func TestModelMatchesSerialFormat(t *testing.T) {
+	tests := []struct {
+		format string
+		serial string
+		match  bool
+	}{
+		{"", "A123456L", true},
+		{"A[0-9]{6}L", "A123456L", true},
+		{"A[0-9]{6}L", "A12345L", false},
+		{"A[0-9]{6}L", "XA123456LX", false},
+		{"A[0-9", "A123456L", false},
+	}
+
+	for _, tt := range tests {
+		model := Model{BrandID: "system", Name: "alder", SerialFormat: tt.format}
+		if model.MatchesSerialFormat(tt.serial) != tt.match {
+			t.Errorf("Serial number %q with format %q: expected match %v", tt.serial, tt.format, tt.match)
+		}
+	}
+}
+
+func TestCompileSerialFormatOnce(t *testing.T) {
+	first, err := compileSerialFormat("C[0-9]{4}")
+	if err != nil {
+		t.Fatalf("Expected a valid serial number format, got: %v", err)
+	}
+	second, _ := compileSerialFormat("C[0-9]{4}")
+	if first != second {
+		t.Error("Expected the serial number format to be compiled once")
+	}
+
+	// An invalid format is not stored
+	if _, err := compileSerialFormat("C[0-9"); err == nil {
+		t.Error("Expected an invalid serial number format")
+	}
+	if _, ok := serialFormats.Load("C[0-9"); ok {
+		t.Error("Expected the invalid serial number format not to be stored")
+	}
+}
+
+func TestValidateSerialHeaders(t *testing.T) {
+	tests := []struct {
+		headers string
+		valid   bool
+	}{
+		{"", true},
+		{"factory-line", true},
+		{"factory-line, hardware-revision", true},
+		{"Factory-Line", false},
+		{"factory_line", false},
+		{"factory-line,timestamp", false},
+		{"brand-id", false},
+	}
+
+	for _, tt := range tests {
+		err := validateSerialHeaders(tt.headers)
+		if (err == nil) != tt.valid {
+			t.Errorf("Serial headers %q: expected valid %v, got error: %v", tt.headers, tt.valid, err)
+		}
+	}
+}
+
+func TestModelSerialHeaderList(t *testing.T) {
+	model := Model{SerialHeaders: " factory-line,, hardware-revision "}
+	headers := model.SerialHeaderList()
+	if len(headers) != 2 || headers[0] != "factory-line" || headers[1] != "hardware-revision" {
+		t.Errorf("Unexpected serial headers: %v", headers)
+	}
+
+	model = Model{}
+	if len(model.SerialHeaderList()) != 0 {
+		t.Errorf("Expected no serial headers, got: %v", model.SerialHeaderList())
+	}
+}
+
+func TestValidateDefaultKeypair(t *testing.T) {
+	acc := Account{ID: 1, AuthorityID: "system"}
+	tests := []struct {
+		keypair Keypair
+		valid   bool
+	}{
+		{Keypair{ID: 1, AuthorityID: "system", Active: true}, true},
+		{Keypair{ID: 2, AuthorityID: "vendor", Active: true}, false},
+		{Keypair{ID: 3, AuthorityID: "system", Active: false}, false},
+	}
+
+	for _, tt := range tests {
+		err := validateDefaultKeypair(acc, tt.keypair)
+		if (err == nil) != tt.valid {
+			t.Errorf("Keypair %d: expected valid %v, got error: %v", tt.keypair.ID, tt.valid, err)
+		}
+	}
+}
+
+func TestDeleteRestoreModel(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-A1"}); err != nil {
+		t.Fatalf("CreateSigningLog() error = %v", err)
+	}
+
+	if _, err := db.DeleteAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("DeleteAllowedModel() error = %v", err)
+	}
+	if code, err := db.DeleteAllowedModel(ctx, model, root); err == nil || code != "error-model-not-found" {
+		t.Errorf("DeleteAllowedModel() of a deleted model = %q, %v", code, err)
+	}
+
+	// The deleted model is not signed for, but is kept with its signing logs
+	if _, err := db.FindModel(ctx, "alder", "alder-basic", "model-api-key"); err == nil {
+		t.Error("FindModel() expected an error for a deleted model")
+	}
+	if models, err := db.ListAllowedModels(ctx, root, ModelFilter{}); err != nil || len(models) != 0 {
+		t.Errorf("ListAllowedModels() = %v, %v", models, err)
+	}
+	deleted, err := db.ListAllowedDeletedModels(ctx, root)
+	if err != nil || len(deleted) != 1 || deleted[0].DeletedAt == nil {
+		t.Fatalf("ListAllowedDeletedModels() = %v, %v", deleted, err)
+	}
+	if db.CheckModelExists(ctx, "alder", "alder-basic") {
+		t.Error("CheckModelExists() expected the deleted model not to exist")
+	}
+	if code, err := db.UpdateAllowedModel(ctx, deleted[0], root); err == nil || code != "error-model-deleted" {
+		t.Errorf("UpdateAllowedModel() of a deleted model = %q, %v", code, err)
+	}
+	if code, err := db.updateModel(ctx, deleted[0]); err == nil || code != "error-model-not-found" {
+		t.Errorf("updateModel() of a deleted model = %q, %v", code, err)
+	}
+	now := time.Now().UTC()
+	report, err := db.AllowedSigningReport(ctx, root, SigningLogFilter{From: now.AddDate(0, 0, -1), To: now.Add(time.Hour)}, ReportPeriodDay)
+	if err != nil || len(report.Rows) != 1 || report.Rows[0].KeyID != "alder-key" {
+		t.Errorf("AllowedSigningReport() = %v, %v", report, err)
+	}
+
+	// The name of the deleted model is reused, so it cannot be restored until the new model is deleted
+	reused, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "reused-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() of a deleted name error = %v", err)
+	}
+	if code, err := db.RestoreAllowedModel(ctx, model.ID, root); err == nil || code != "error-model-exists" {
+		t.Errorf("RestoreAllowedModel() of a reused name = %q, %v", code, err)
+	}
+	if _, err := db.DeleteAllowedModel(ctx, reused, root); err != nil {
+		t.Fatalf("DeleteAllowedModel() error = %v", err)
+	}
+
+	if _, err := db.RestoreAllowedModel(ctx, model.ID, root); err != nil {
+		t.Fatalf("RestoreAllowedModel() error = %v", err)
+	}
+	if code, err := db.RestoreAllowedModel(ctx, model.ID, root); err == nil || code != "error-model-not-found" {
+		t.Errorf("RestoreAllowedModel() of an active model = %q, %v", code, err)
+	}
+	if m, err := db.FindModel(ctx, "alder", "alder-basic", "model-api-key"); err != nil || m.DeletedAt != nil {
+		t.Errorf("FindModel() = %v, %v", m, err)
+	}
+}
+
+func TestBulkCreateAllowedModels(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder-signing"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+
+	// A single invalid row rejects the import
+	rows := []BulkModel{
+		{BrandID: "alder", Name: "alder-basic", SigningKey: "alder-key", SystemUserKey: "alder-signing"},
+		{BrandID: "alder", Name: "alder-pro", SigningKey: "unknown", KeypairIDUser: keypair.ID},
+		{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID},
+	}
+	results, err := db.BulkCreateAllowedModels(ctx, rows, root)
+	if err != ErrBulkModelsRejected || len(results) != 3 {
+		t.Fatalf("BulkCreateAllowedModels() = %v, %v", results, err)
+	}
+	if results[0].Error != "" || results[1].Error == "" || results[2].Error != "The model is also in row 1" {
+		t.Errorf("BulkCreateAllowedModels() results = %v", results)
+	}
+	if models, _ := db.ListAllowedModels(ctx, root, ModelFilter{}); len(models) != 0 {
+		t.Errorf("ListAllowedModels() = %v, expected no models", models)
+	}
+
+	rows[1].SigningKey = "alder-signing"
+	rows[2].Name = "alder-max"
+	results, err = db.BulkCreateAllowedModels(ctx, rows, root)
+	if err != nil || len(results) != 3 {
+		t.Fatalf("BulkCreateAllowedModels() = %v, %v", results, err)
+	}
+	for _, r := range results {
+		if r.ModelID == 0 || r.KeypairID != keypair.ID || r.KeypairIDUser != keypair.ID {
+			t.Errorf("BulkCreateAllowedModels() result = %v", r)
+		}
+	}
+	if models, _ := db.ListAllowedModels(ctx, root, ModelFilter{}); len(models) != 3 {
+		t.Errorf("ListAllowedModels() = %v, expected 3 models", models)
+	}
+
+	// The existing models are not created again
+	results, err = db.BulkCreateAllowedModels(ctx, rows[:1], root)
+	if err != ErrBulkModelsRejected || results[0].Error == "" {
+		t.Errorf("BulkCreateAllowedModels() of an existing model = %v, %v", results, err)
+	}
+}
+
+func TestListAllowedModelsFilter(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	for _, brand := range []string{"alder", "birch"} {
+		if _, err := db.PutAccount(ctx, Account{AuthorityID: brand, Assertion: "assertion"}, root); err != nil {
+			t.Fatalf("PutAccount() error = %v", err)
+		}
+		if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: brand, KeyID: brand + "-key", SealedKey: "sealed", KeyName: brand}); err != nil {
+			t.Fatalf("PutKeypair() error = %v", err)
+		}
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "birch", "birch-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+
+	rows := []BulkModel{
+		{BrandID: "alder", Name: "alder-basic", SigningKey: "alder", SystemUserKey: "alder"},
+		{BrandID: "alder", Name: "alder-pro", SigningKey: "alder", SystemUserKey: "alder"},
+		{BrandID: "alder", Name: "alder-max", SigningKey: "alder", SystemUserKey: "alder"},
+		{BrandID: "birch", Name: "birch-pro", SigningKey: "birch", SystemUserKey: "birch"},
+	}
+	if results, err := db.BulkCreateAllowedModels(ctx, rows, root); err != nil {
+		t.Fatalf("BulkCreateAllowedModels() = %v, %v", results, err)
+	}
+
+	tests := []struct {
+		name   string
+		filter ModelFilter
+		want   []string
+	}{
+		{"all", ModelFilter{}, []string{"alder-basic", "alder-max", "alder-pro", "birch-pro"}},
+		{"brand", ModelFilter{BrandID: "birch"}, []string{"birch-pro"}},
+		{"keypair", ModelFilter{KeypairID: keypair.ID}, []string{"birch-pro"}},
+		{"name", ModelFilter{Name: "PRO"}, []string{"alder-pro", "birch-pro"}},
+		{"name wildcard", ModelFilter{Name: "%"}, []string{}},
+		{"first page", ModelFilter{Limit: 3}, []string{"alder-basic", "alder-max", "alder-pro"}},
+		{"last page", ModelFilter{Offset: 3, Limit: 3}, []string{"birch-pro"}},
+		{"offset", ModelFilter{BrandID: "alder", Offset: 1}, []string{"alder-max", "alder-pro"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			models, err := db.ListAllowedModels(ctx, root, tt.filter)
+			if err != nil {
+				t.Fatalf("ListAllowedModels() error = %v", err)
+			}
+			names := []string{}
+			for _, m := range models {
+				names = append(names, m.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("ListAllowedModels() = %v, want %v", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestRotateAllowedModelAPIKey(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	if _, err := db.RotateAllowedModelAPIKey(ctx, model.ID, 31*24*time.Hour, root); err == nil {
+		t.Error("RotateAllowedModelAPIKey() expected an error for an overlap that is too long")
+	}
+
+	// The previous key stays valid during the overlap, and its use can be audited
+	k, err := db.RotateAllowedModelAPIKey(ctx, model.ID, time.Hour, root)
+	if err != nil {
+		t.Fatalf("RotateAllowedModelAPIKey() error = %v", err)
+	}
+	if k.ModelID != model.ID || len(k.APIKey) == 0 || k.APIKey == "model-api-key" || k.PreviousExpires == nil {
+		t.Fatalf("RotateAllowedModelAPIKey() = %+v", k)
+	}
+	for apiKey, deprecated := range map[string]bool{k.APIKey: false, "model-api-key": true} {
+		if m, err := db.FindModel(ctx, "alder", "alder-basic", apiKey); err != nil || m.ID != model.ID || m.DeprecatedKey != deprecated {
+			t.Errorf("FindModel(%q) = %v, %v, want deprecated %v", apiKey, m, err, deprecated)
+		}
+	}
+
+	// Without an overlap, the previous key is not valid
+	previous := k.APIKey
+	k, err = db.RotateAllowedModelAPIKey(ctx, model.ID, 0, root)
+	if err != nil || k.PreviousExpires != nil {
+		t.Fatalf("RotateAllowedModelAPIKey() = %+v, %v", k, err)
+	}
+	if _, err := db.FindModel(ctx, "alder", "alder-basic", previous); err == nil {
+		t.Error("FindModel() expected an error for the previous key")
+	}
+
+	// The rotations are in the history of the model
+	history, err := db.ListAllowedModelHistory(ctx, model.ID, root)
+	if err != nil || len(history) != 3 || history[0].Action != ModelRevisionRotate {
+		t.Fatalf("ListAllowedModelHistory() = %v, %v", history, err)
+	}
+	if len(history[0].Changes) != 1 || history[0].Changes[0].Field != "api-key-id" || history[0].Changes[0].To != APIKeyID(k.APIKey) {
+		t.Errorf("ListAllowedModelHistory() changes = %v", history[0].Changes)
+	}
+
+	if _, err := db.RotateAllowedModelAPIKey(ctx, 9999, 0, root); err == nil {
+		t.Error("RotateAllowedModelAPIKey() expected an error for an unknown model")
+	}
+}
@@ -20,7 +20,12 @@
 package datastore
 
 import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"strings"
 	"time"
 )
 
@@ -39,23 +44,29 @@ const createModelAssertTableSQL = `
 		base             varchar(20) default '',
 		classic          varchar(10) default '',
 		display_name     varchar(200) default '',
+		grade            varchar(20) default '',
+		storage_safety   varchar(40) default '',
+		snaps            text default '',
+		validation_sets  text default '',
 		created          timestamp default current_timestamp,
 		modified         timestamp default current_timestamp
 	)
 `
 const createModelAssertSQL = `
 INSERT INTO modelassertion 
-(model_id,keypair_id,series,architecture,revision,gadget,kernel,store,required_snaps,base,classic,display_name) 
-VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12) 
+(model_id,keypair_id,series,architecture,revision,gadget,kernel,store,required_snaps,base,classic,display_name,grade,storage_safety,snaps,validation_sets) 
+VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,$12,$13,$14,$15,$16) 
 RETURNING id`
 
 const updateModelAssertSQL = `
 UPDATE modelassertion
-SET model_id=$2, keypair_id=$3, series=$4, architecture=$5, revision=$6, gadget=$7, kernel=$8, store=$9, modified=$10, required_snaps=$11, base=$12, classic=$13, display_name=$14 
+SET model_id=$2, keypair_id=$3, series=$4, architecture=$5, revision=$6, gadget=$7, kernel=$8, store=$9, modified=$10, required_snaps=$11, base=$12, classic=$13, display_name=$14,
+	grade=$15, storage_safety=$16, snaps=$17, validation_sets=$18
 WHERE id=$1`
 
 const getModelAssertSQL = `
-SELECT id,model_id,keypair_id,series,architecture,revision,gadget,kernel,store,required_snaps,base,classic,display_name,created,modified
+SELECT id,model_id,keypair_id,series,architecture,revision,gadget,kernel,store,required_snaps,base,classic,display_name,
+	coalesce(grade,''),coalesce(storage_safety,''),coalesce(snaps,''),coalesce(validation_sets,''),created,modified
 FROM modelassertion
 WHERE model_id=$1
 `
@@ -73,6 +84,40 @@ ADD COLUMN classic varchar(10) default '',
 ADD COLUMN display_name varchar(200) default ''
 `
 
+// sqlite3 syntax, as SQLite adds one column at a time
+const alterModelAssertUC18FieldsSQLite = `
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS base varchar(20) default '';
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS classic varchar(10) default '';
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS display_name varchar(200) default ''
+`
+
+// Add the Ubuntu Core 20 fields to the model assertion. The snaps and the validation sets are lists,
+// which are stored as JSON
+const alterModelAssertUC20Fields = `
+ALTER TABLE modelassertion 
+ADD COLUMN grade varchar(20) default '',
+ADD COLUMN storage_safety varchar(40) default '',
+ADD COLUMN snaps text default '',
+ADD COLUMN validation_sets text default ''
+`
+
+// sqlite3 syntax, as SQLite adds one column at a time
+const alterModelAssertUC20FieldsSQLite = `
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS grade varchar(20) default '';
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS storage_safety varchar(40) default '';
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS snaps text default '';
+ALTER TABLE modelassertion ADD COLUMN IF NOT EXISTS validation_sets text default ''
+`
+
+// Grades of the Ubuntu Core 20 models
+var validModelGrades = []string{"dangerous", "signed", "secured"}
+
+// Storage safety of the Ubuntu Core 20 models
+var validStorageSafety = []string{"prefer-unencrypted", "prefer-encrypted", "encrypted"}
+
+// Modes of the validation sets of the Ubuntu Core 20 models
+var validValidationSetModes = []string{"prefer-enforce", "enforce"}
+
 // ModelAssertion holds the model assertion details in the local database
 type ModelAssertion struct {
 	ID            int       `json:"id"`
@@ -90,26 +135,55 @@ type ModelAssertion struct {
 	DisplayName   string    `json:"display_name"`
 	Created       time.Time `json:"created"`
 	Modified      time.Time `json:"modified"`
+
+	// Ubuntu Core 20 headers, where the kernel, the gadget and the required snaps are in the snaps list
+	Grade          string               `json:"grade"`
+	StorageSafety  string               `json:"storage_safety"`
+	Snaps          []ModelSnap          `json:"snaps"`
+	ValidationSets []ModelValidationSet `json:"validation_sets"`
+}
+
+// ModelSnap is a snap of the snaps header of a Ubuntu Core 20 model assertion
+type ModelSnap struct {
+	Name           string   `json:"name"`
+	ID             string   `json:"id,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	DefaultChannel string   `json:"default-channel,omitempty"`
+	Modes          []string `json:"modes,omitempty"`
+	Presence       string   `json:"presence,omitempty"`
+}
+
+// ModelValidationSet is a validation set of the validation-sets header of a Ubuntu Core 20 model assertion
+type ModelValidationSet struct {
+	AccountID string `json:"account-id"`
+	Name      string `json:"name"`
+	Sequence  int    `json:"sequence,omitempty"`
+	Mode      string `json:"mode"`
 }
 
 // CreateModelAssertTable creates the database table for a model assertion
-func (db *DB) CreateModelAssertTable() error {
-	_, err := db.Exec(createModelAssertTableSQL)
+func (db *DB) CreateModelAssertTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createModelAssertTableSQL)
 	return err
 }
 
 // AlterModelAssertTable updates an existing database model assertion table with additional fields
-func (db *DB) AlterModelAssertTable() error {
+func (db *DB) AlterModelAssertTable(ctx context.Context) error {
 	// Ignore error as the fields may already exist
-	db.Exec(alterModelAssertUC18Fields)
+	db.ExecContext(ctx, alterModelAssertUC18Fields)
+	db.ExecContext(ctx, alterModelAssertUC20Fields)
 
 	return nil
 }
 
 // CreateModelAssert adds a model assertion record to allow generation of a signed assertion
-func (db *DB) CreateModelAssert(m ModelAssertion) (int, error) {
+func (db *DB) CreateModelAssert(ctx context.Context, m ModelAssertion) (int, error) {
+	defer db.invalidateCache(cacheModels)
+
 	var createdID int
-	err := db.QueryRow(createModelAssertSQL, m.ModelID, m.KeypairID, m.Series, m.Architecture, m.Revision, m.Gadget, m.Kernel, m.Store, m.RequiredSnaps, m.Base, m.Classic, m.DisplayName).Scan(&createdID)
+	snaps, validationSets := m.encodeLists()
+	err := db.QueryRowContext(ctx, createModelAssertSQL, m.ModelID, m.KeypairID, m.Series, m.Architecture, m.Revision, m.Gadget, m.Kernel, m.Store, m.RequiredSnaps, m.Base, m.Classic, m.DisplayName,
+		m.Grade, m.StorageSafety, snaps, validationSets).Scan(&createdID)
 	if err != nil {
 		return 0, fmt.Errorf("error creating the model assertion: %v", err)
 	}
@@ -118,10 +192,14 @@ func (db *DB) CreateModelAssert(m ModelAssertion) (int, error) {
 }
 
 // UpdateModelAssert updates the model assertion details
-func (db *DB) UpdateModelAssert(m ModelAssertion) error {
+func (db *DB) UpdateModelAssert(ctx context.Context, m ModelAssertion) error {
+	defer db.invalidateCache(cacheModels)
+
 	var err error
 
-	_, err = db.Exec(updateModelAssertSQL, m.ID, m.ModelID, m.KeypairID, m.Series, m.Architecture, m.Revision, m.Gadget, m.Kernel, m.Store, time.Now().UTC(), m.RequiredSnaps, m.Base, m.Classic, m.DisplayName)
+	snaps, validationSets := m.encodeLists()
+	_, err = db.ExecContext(ctx, updateModelAssertSQL, m.ID, m.ModelID, m.KeypairID, m.Series, m.Architecture, m.Revision, m.Gadget, m.Kernel, m.Store, time.Now().UTC(), m.RequiredSnaps, m.Base, m.Classic, m.DisplayName,
+		m.Grade, m.StorageSafety, snaps, validationSets)
 
 	if err != nil {
 		return fmt.Errorf("error updating the model assertion for %d: %v", m.ID, err)
@@ -131,27 +209,27 @@ func (db *DB) UpdateModelAssert(m ModelAssertion) error {
 }
 
 // UpsertModelAssert creates or updates the model assertion headers
-func (db *DB) UpsertModelAssert(m ModelAssertion) error {
+func (db *DB) UpsertModelAssert(ctx context.Context, m ModelAssertion) error {
 	var err error
 
-	if err = validateModelAssertion(m); err != nil {
+	if err = ValidateModelAssertion(m); err != nil {
 		return fmt.Errorf("error upserting the model assertion for model %d: %v", m.ModelID, err)
 	}
 
 	if m.ID > 0 {
-		err = db.UpdateModelAssert(m)
+		err = db.UpdateModelAssert(ctx, m)
 	} else {
-		_, err = db.CreateModelAssert(m)
+		_, err = db.CreateModelAssert(ctx, m)
 	}
 
 	return err
 }
 
 // deleteModelAssert deletes the model assertion details
-func (db *DB) deleteModelAssert(modelID int) error {
+func (db *DB) deleteModelAssert(ctx context.Context, modelID int, tx *sql.Tx) error {
 	var err error
 
-	_, err = db.Exec(deleteModelAssertSQL, modelID)
+	_, err = tx.ExecContext(ctx, deleteModelAssertSQL, modelID)
 	if err != nil {
 		return fmt.Errorf("error deleting the model assertion: %v", err)
 	}
@@ -159,9 +237,14 @@ func (db *DB) deleteModelAssert(modelID int) error {
 }
 
 // GetModelAssert fetches the model assertion
-func (db *DB) GetModelAssert(modelID int) (ModelAssertion, error) {
+func (db *DB) GetModelAssert(ctx context.Context, modelID int) (ModelAssertion, error) {
 	m := ModelAssertion{}
-	err := db.QueryRow(getModelAssertSQL, modelID).Scan(&m.ID, &m.ModelID, &m.KeypairID, &m.Series, &m.Architecture, &m.Revision, &m.Gadget, &m.Kernel, &m.Store, &m.RequiredSnaps, &m.Base, &m.Classic, &m.DisplayName, &m.Created, &m.Modified)
+	var snaps, validationSets string
+	err := db.QueryRowContext(ctx, getModelAssertSQL, modelID).Scan(&m.ID, &m.ModelID, &m.KeypairID, &m.Series, &m.Architecture, &m.Revision, &m.Gadget, &m.Kernel, &m.Store, &m.RequiredSnaps, &m.Base, &m.Classic, &m.DisplayName,
+		&m.Grade, &m.StorageSafety, &snaps, &validationSets, &m.Created, &m.Modified)
+	if err == nil {
+		err = m.decodeLists(snaps, validationSets)
+	}
 	if err != nil {
 		return m, fmt.Errorf("error fetching the model assertion for %d: %v", modelID, err)
 	}
@@ -169,7 +252,36 @@ func (db *DB) GetModelAssert(modelID int) (ModelAssertion, error) {
 	return m, nil
 }
 
-func validateModelAssertion(m ModelAssertion) error {
+// encodeLists returns the JSON of the snaps and the validation sets, which are empty when there are none
+func (m ModelAssertion) encodeLists() (string, string) {
+	var snaps, validationSets string
+	if len(m.Snaps) > 0 {
+		data, _ := json.Marshal(m.Snaps)
+		snaps = string(data)
+	}
+	if len(m.ValidationSets) > 0 {
+		data, _ := json.Marshal(m.ValidationSets)
+		validationSets = string(data)
+	}
+	return snaps, validationSets
+}
+
+// decodeLists sets the snaps and the validation sets from their JSON
+func (m *ModelAssertion) decodeLists(snaps, validationSets string) error {
+	if len(snaps) > 0 {
+		if err := json.Unmarshal([]byte(snaps), &m.Snaps); err != nil {
+			return err
+		}
+	}
+	if len(validationSets) > 0 {
+		return json.Unmarshal([]byte(validationSets), &m.ValidationSets)
+	}
+	return nil
+}
+
+// ValidateModelAssertion checks the model assertion headers before they are stored. The headers
+// are checked with the asserts package when the assertion is signed
+func ValidateModelAssertion(m ModelAssertion) error {
 	errTemplate := "invalid model assertion: %v "
 	if m.ModelID <= 0 {
 		return fmt.Errorf(errTemplate, "Model must be provided")
@@ -184,15 +296,90 @@ func validateModelAssertion(m ModelAssertion) error {
 	if err := validateNotEmpty("Architecture", m.Architecture); err != nil {
 		return fmt.Errorf(errTemplate, err)
 	}
+	if err := validateNotEmpty("Store", m.Store); err != nil {
+		return fmt.Errorf(errTemplate, err)
+	}
+
+	// The kernel and the gadget of a Ubuntu Core 20 model are in the snaps list
+	if len(m.Grade) > 0 {
+		if err := validateModelAssertionUC20(m); err != nil {
+			return fmt.Errorf(errTemplate, err)
+		}
+		return nil
+	}
+	if len(m.StorageSafety) > 0 || len(m.Snaps) > 0 || len(m.ValidationSets) > 0 {
+		return fmt.Errorf(errTemplate, "Storage Safety, Snaps and Validation Sets need a Grade")
+	}
+
 	if err := validateNotEmpty("Gadget", m.Gadget); err != nil {
 		return fmt.Errorf(errTemplate, err)
 	}
 	if err := validateNotEmpty("Kernel", m.Kernel); err != nil {
 		return fmt.Errorf(errTemplate, err)
 	}
-	if err := validateNotEmpty("Store", m.Store); err != nil {
-		return fmt.Errorf(errTemplate, err)
+
+	return nil
+}
+
+// validateModelAssertionUC20 checks the headers of a Ubuntu Core 20 model assertion
+func validateModelAssertionUC20(m ModelAssertion) error {
+	if !inList(validModelGrades, m.Grade) {
+		return fmt.Errorf("Grade must be one of %s", strings.Join(validModelGrades, ", "))
+	}
+	if strings.ToLower(m.Classic) == "true" {
+		return errors.New("Grade cannot be used for a classic model")
+	}
+	if err := validateNotEmpty("Base", m.Base); err != nil {
+		return err
+	}
+	if len(m.Gadget) > 0 || len(m.Kernel) > 0 || len(m.RequiredSnaps) > 0 {
+		return errors.New("Gadget, Kernel and Required Snaps must be in the Snaps list of a model with a Grade")
 	}
 
+	if len(m.StorageSafety) > 0 && !inList(validStorageSafety, m.StorageSafety) {
+		return fmt.Errorf("Storage Safety must be one of %s", strings.Join(validStorageSafety, ", "))
+	}
+	if m.Grade == "secured" && m.StorageSafety == "prefer-unencrypted" {
+		return errors.New("Storage Safety of a secured model must not be prefer-unencrypted")
+	}
+
+	if len(m.Snaps) == 0 {
+		return errors.New("Snaps must not be empty")
+	}
+	for _, snap := range m.Snaps {
+		if err := validateNotEmpty("Snap name", snap.Name); err != nil {
+			return err
+		}
+	}
+
+	seen := map[string]bool{}
+	for _, v := range m.ValidationSets {
+		if err := validateNotEmpty("Validation Set account", v.AccountID); err != nil {
+			return err
+		}
+		if err := validateNotEmpty("Validation Set name", v.Name); err != nil {
+			return err
+		}
+		if !inList(validValidationSetModes, v.Mode) {
+			return fmt.Errorf("Validation Set mode must be one of %s", strings.Join(validValidationSetModes, ", "))
+		}
+		if v.Sequence < 0 {
+			return errors.New("Validation Set sequence must not be negative")
+		}
+		if seen[v.AccountID+"/"+v.Name] {
+			return fmt.Errorf("Validation Set %s/%s must not be repeated", v.AccountID, v.Name)
+		}
+		seen[v.AccountID+"/"+v.Name] = true
+	}
 	return nil
 }
+
+// inList checks whether the value is one of the list
+func inList(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
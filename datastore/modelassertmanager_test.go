@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func uc20ModelAssertion() ModelAssertion {
+	return ModelAssertion{
+		ModelID: 1, KeypairID: 1, Series: 16, Architecture: "amd64", Store: "ubuntu",
+		Base: "core20", Grade: "signed", StorageSafety: "prefer-encrypted",
+		Snaps: []ModelSnap{
+			{Name: "pc", ID: "UqFziVZDHLSyO3TqSWgNBoAdHbLI4dAH", Type: "gadget", DefaultChannel: "20/stable"},
+			{Name: "pc-kernel", ID: "pYVQrBcKmBa0mZ4CCN7ExT6jH8rY1hza", Type: "kernel", DefaultChannel: "20/stable", Modes: []string{"run", "ephemeral"}},
+		},
+		ValidationSets: []ModelValidationSet{{AccountID: "alder", Name: "base-set", Sequence: 2, Mode: "enforce"}},
+	}
+}
+
+func TestValidateModelAssertion(t *testing.T) {
+	core := ModelAssertion{ModelID: 1, KeypairID: 1, Series: 16, Architecture: "amd64", Store: "ubuntu", Gadget: "pc", Kernel: "pc-kernel"}
+
+	tests := []struct {
+		name    string
+		update  func(m *ModelAssertion)
+		uc20    bool
+		wantErr bool
+	}{
+		{"core-valid", func(m *ModelAssertion) {}, false, false},
+		{"core-no-kernel", func(m *ModelAssertion) { m.Kernel = "" }, false, true},
+		{"core-snaps", func(m *ModelAssertion) { m.Snaps = []ModelSnap{{Name: "pc"}} }, false, true},
+		{"core-storage-safety", func(m *ModelAssertion) { m.StorageSafety = "encrypted" }, false, true},
+		{"uc20-valid", func(m *ModelAssertion) {}, true, false},
+		{"uc20-invalid-grade", func(m *ModelAssertion) { m.Grade = "invalid" }, true, true},
+		{"uc20-classic", func(m *ModelAssertion) { m.Classic = "true" }, true, true},
+		{"uc20-no-base", func(m *ModelAssertion) { m.Base = "" }, true, true},
+		{"uc20-kernel", func(m *ModelAssertion) { m.Kernel = "pc-kernel" }, true, true},
+		{"uc20-required-snaps", func(m *ModelAssertion) { m.RequiredSnaps = "htop" }, true, true},
+		{"uc20-invalid-storage-safety", func(m *ModelAssertion) { m.StorageSafety = "invalid" }, true, true},
+		{"uc20-secured-unencrypted", func(m *ModelAssertion) { m.Grade, m.StorageSafety = "secured", "prefer-unencrypted" }, true, true},
+		{"uc20-no-snaps", func(m *ModelAssertion) { m.Snaps = nil }, true, true},
+		{"uc20-snap-no-name", func(m *ModelAssertion) { m.Snaps[0].Name = "" }, true, true},
+		{"uc20-set-no-account", func(m *ModelAssertion) { m.ValidationSets[0].AccountID = "" }, true, true},
+		{"uc20-set-invalid-mode", func(m *ModelAssertion) { m.ValidationSets[0].Mode = "invalid" }, true, true},
+		{"uc20-set-negative-sequence", func(m *ModelAssertion) { m.ValidationSets[0].Sequence = -1 }, true, true},
+		{"uc20-set-repeated", func(m *ModelAssertion) { m.ValidationSets = append(m.ValidationSets, m.ValidationSets[0]) }, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := core
+			if tt.uc20 {
+				m = uc20ModelAssertion()
+			}
+			tt.update(&m)
+
+			if err := ValidateModelAssertion(m); (err != nil) != tt.wantErr {
+				t.Errorf("ValidateModelAssertion() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestModelAssertUC20(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder-key"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	m := uc20ModelAssertion()
+	m.ModelID = model.ID
+	m.KeypairID = keypair.ID
+	if err := db.UpsertModelAssert(ctx, m); err != nil {
+		t.Fatalf("UpsertModelAssert() error = %v", err)
+	}
+
+	got, err := db.GetModelAssert(ctx, model.ID)
+	if err != nil {
+		t.Fatalf("GetModelAssert() error = %v", err)
+	}
+	if got.Grade != m.Grade || got.StorageSafety != m.StorageSafety {
+		t.Errorf("GetModelAssert() grade = %v, storage-safety = %v", got.Grade, got.StorageSafety)
+	}
+	if !reflect.DeepEqual(got.Snaps, m.Snaps) {
+		t.Errorf("GetModelAssert() snaps = %v, want %v", got.Snaps, m.Snaps)
+	}
+	if !reflect.DeepEqual(got.ValidationSets, m.ValidationSets) {
+		t.Errorf("GetModelAssert() validation sets = %v, want %v", got.ValidationSets, m.ValidationSets)
+	}
+
+	// The lists are removed when the model is not graded anymore
+	m.ID = got.ID
+	m.Grade, m.StorageSafety, m.Snaps, m.ValidationSets = "", "", nil, nil
+	m.Gadget, m.Kernel = "pc", "pc-kernel"
+	if err := db.UpsertModelAssert(ctx, m); err != nil {
+		t.Fatalf("UpsertModelAssert() error = %v", err)
+	}
+	got, err = db.GetModelAssert(ctx, model.ID)
+	if err != nil {
+		t.Fatalf("GetModelAssert() error = %v", err)
+	}
+	if len(got.Grade) > 0 || len(got.Snaps) > 0 || len(got.ValidationSets) > 0 {
+		t.Errorf("GetModelAssert() expected no Ubuntu Core 20 headers, got %v", got)
+	}
+}
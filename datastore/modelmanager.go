@@ -20,11 +20,14 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"fmt"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
+	sq "github.com/Masterminds/squirrel"
 )
 
 const createModelTableSQL = `
@@ -34,72 +37,113 @@ const createModelTableSQL = `
 		name             varchar(200) not null,
 		keypair_id       int references keypair not null,
 		user_keypair_id  int references keypair not null,
-		api_key          varchar(200) not null
+		api_key          varchar(200) not null,
+		serial_format    varchar(200) not null default '',
+		serial_headers   text not null default '',
+		signing_hours    varchar(200) not null default '',
+		signing_timezone varchar(100) not null default '',
+		allowed_cidrs    varchar(1000) not null default '',
+		version          int not null default 1,
+		deleted_at       timestamp,
+		previous_api_key varchar(200) not null default '',
+		previous_api_key_expires timestamp
 	)
 `
-const listModelsSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion
+const listModelsColumnsSQL = "m.id, brand_id, m.name, m.keypair_id, m.api_key, m.serial_format, m.serial_headers, m.signing_hours, m.signing_timezone, m.allowed_cidrs, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion, m.version, m.deleted_at"
+const listModelsSelectSQL = `
+	select ` + listModelsColumnsSQL + `
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
-	order by name
 `
-const listModelsForUserSQL = `
-	select m.id, brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.assertion
-	from model m
-	inner join keypair k on k.id = m.keypair_id
-	inner join keypair ku on ku.id = m.user_keypair_id
+const listModelsForUserSelectSQL = listModelsSelectSQL + `
 	inner join account acc on acc.authority_id=m.brand_id
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
-	where u.username=$1
-	order by name
+	where u.username=$1` + accountRoleStandardSQL
+
+// The deleted models, which can be restored, with the latest deletion first
+const listDeletedModelsSQL = listModelsSelectSQL + `
+	where m.deleted_at is not null
+	order by m.deleted_at desc
 `
-const findModelSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+const listDeletedModelsForUserSQL = listModelsForUserSelectSQL + `
+	and m.deleted_at is not null
+	order by m.deleted_at desc
+`
+const findModelByNameSQL = `
+	select m.id, brand_id, name, m.keypair_id, m.api_key, m.serial_format, m.serial_headers, m.signing_hours, m.signing_timezone, m.allowed_cidrs, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.version, m.deleted_at
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
-	where brand_id=$1 and name=$2 and api_key=$3`
+	where brand_id=$1 and name=$2 and m.deleted_at is null`
+const findModelSQL = findModelByNameSQL + " and (api_key=$3 or (previous_api_key=$3 and previous_api_key_expires>$4))"
 const getModelSQL = `
-	select m.id, brand_id, name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+	select m.id, brand_id, name, m.keypair_id, m.api_key, m.serial_format, m.serial_headers, m.signing_hours, m.signing_timezone, m.allowed_cidrs, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.version, m.deleted_at
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
 	where m.id=$1`
 const getModelForUserSQL = `
-	select m.id, m.brand_id, m.name, m.keypair_id, m.api_key, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion
+	select m.id, m.brand_id, m.name, m.keypair_id, m.api_key, m.serial_format, m.serial_headers, m.signing_hours, m.signing_timezone, m.allowed_cidrs, k.authority_id, k.key_id, k.active, k.sealed_key, user_keypair_id, ku.authority_id, ku.key_id, ku.active, ku.sealed_key, ku.assertion, m.version, m.deleted_at
 	from model m
 	inner join keypair k on k.id = m.keypair_id
 	inner join keypair ku on ku.id = m.user_keypair_id
 	inner join account acc on acc.authority_id=m.brand_id
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
-	where m.id=$1 and u.username=$2`
-const updateModelSQL = "update model set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6 where id=$1"
+	where m.id=$1 and u.username=$2` + accountRoleAdminSQL
+const updateModelSQL = `
+	update model set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6, serial_format=$7, serial_headers=$8,
+		signing_hours=$9, signing_timezone=$10, allowed_cidrs=$11, version=version+1
+	where id=$1 and deleted_at is null and ($12=0 or version=$12)`
 const updateModelForUserSQL = `
-	update model m set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6
+	update model m set brand_id=$2, name=$3, keypair_id=$4, user_keypair_id=$5, api_key=$6, serial_format=$7, serial_headers=$8,
+		signing_hours=$9, signing_timezone=$10, allowed_cidrs=$11, version=m.version+1
 	from account acc
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
-	where acc.authority_id=m.brand_id and m.id=$1 and u.username=$7`
-const createModelSQL = "insert into model (brand_id,name,keypair_id,user_keypair_id,api_key) values ($1,$2,$3,$4,$5) RETURNING id"
+	where acc.authority_id=m.brand_id and m.id=$1 and m.deleted_at is null and u.username=$12 and ($13=0 or m.version=$13)` + accountRoleAdminSQL
+const createModelSQL = `
+	insert into model (brand_id,name,keypair_id,user_keypair_id,api_key,serial_format,serial_headers,signing_hours,signing_timezone,allowed_cidrs)
+	values ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10) RETURNING id`
 
 // sqlite3 syntax for syncing data locally
 const syncUpsertModelSQL = `
 	INSERT OR REPLACE INTO model
-	(id,brand_id,name,keypair_id,user_keypair_id,api_key)
-	VALUES ($1, $2, $3, $4, $5, $6)
+	(id,brand_id,name,keypair_id,user_keypair_id,api_key,serial_format,serial_headers,signing_hours,signing_timezone,allowed_cidrs)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)
 `
 
-const deleteModelSQL = "delete from model where id=$1"
+// The deletion of a model sets the time that it is deleted, so the signing logs of the model still
+// resolve it, and the deletion can be reverted by the restore of the model
+const deleteModelSQL = "update model set deleted_at=$2, version=version+1 where id=$1 and deleted_at is null"
 const deleteModelForUserSQL = `
-	delete from model m
-	using account acc
+	update model m set deleted_at=$3, version=m.version+1
+	from account acc
+	inner join useraccountlink ua on ua.account_id=acc.id
+	inner join userinfo u on ua.user_id=u.id
+	where m.id=$1 and m.deleted_at is null and acc.authority_id=m.brand_id and u.username=$2` + accountRoleAdminSQL
+const restoreModelSQL = "update model set deleted_at=null, version=version+1 where id=$1 and deleted_at is not null"
+const restoreModelForUserSQL = `
+	update model m set deleted_at=null, version=m.version+1
+	from account acc
+	inner join useraccountlink ua on ua.account_id=acc.id
+	inner join userinfo u on ua.user_id=u.id
+	where m.id=$1 and m.deleted_at is not null and acc.authority_id=m.brand_id and u.username=$2` + accountRoleAdminSQL
+
+// The rotation of the API key of a model keeps the previous key valid until it expires
+const rotateModelAPIKeySQL = `
+	update model set previous_api_key=api_key, previous_api_key_expires=$2, api_key=$3, version=version+1
+	where id=$1 and deleted_at is null`
+const rotateModelAPIKeyForUserSQL = `
+	update model m set previous_api_key=m.api_key, previous_api_key_expires=$3, api_key=$4, version=m.version+1
+	from account acc
 	inner join useraccountlink ua on ua.account_id=acc.id
 	inner join userinfo u on ua.user_id=u.id
-	where m.id=$1 and acc.authority_id=m.brand_id and u.username=$2`
+	where m.id=$1 and m.deleted_at is null and acc.authority_id=m.brand_id and u.username=$2` + accountRoleAdminSQL
 
+// The model of a previous API key that has not expired
 const checkBrandsMatchSQL = `
 	select count(*) from keypair k
 	inner join keypair ku on ku.authority_id = k.authority_id
@@ -108,13 +152,20 @@ const checkBrandsMatchSQL = `
 
 const checkAPIKeyExistsSQL = `
 	select exists(
-		select * from model where api_key=$1
+		select id from model where api_key=$1 and deleted_at is null
+		union all
+		select id from model where previous_api_key=$1 and previous_api_key_expires>$2 and deleted_at is null
+		union all
+		select id from apikey where api_key=$3 and active
+		union all
+		select id from apikey where previous_key=$3 and previous_expires>$2 and active
 	)
 `
 
+// The deleted models are excluded, so the brand and name of a deleted model can be reused
 const checkModelExistsSQL = `
 	select exists(
-		select * from model where brand_id=$1 and name=$2
+		select * from model where brand_id=$1 and name=$2 and deleted_at is null
 	)
 `
 
@@ -134,6 +185,28 @@ const alterModelAPIKeyNotNullable = `alter table model
 	alter column api_key drop default
 `
 
+// Add the serial number format field to the models table
+const alterModelSerialFormat = "alter table model add column serial_format varchar(200) not null default ''"
+
+// Add the serial-request headers that are copied to the serial assertion to the models table
+const alterModelSerialHeaders = "alter table model add column serial_headers text not null default ''"
+
+// Add the version field, which is incremented by each update of a model
+const alterModelVersion = "alter table model add column version int not null default 1"
+
+// Add the time that the model is deleted, which is null for the active models
+const alterModelDeletedAt = "alter table model add column deleted_at timestamp"
+
+// Add the signing restrictions of the model: the signing hours, in the time zone of the model, and
+// the networks that the model can be signed from
+const alterModelSigningHours = "alter table model add column signing_hours varchar(200) not null default ''"
+const alterModelSigningTimezone = "alter table model add column signing_timezone varchar(100) not null default ''"
+const alterModelAllowedCIDRs = "alter table model add column allowed_cidrs varchar(1000) not null default ''"
+
+// Add the previous API key of a model with a rotated key, which stays valid until it expires
+const alterModelPreviousAPIKey = "alter table model add column previous_api_key varchar(200) not null default ''"
+const alterModelPreviousAPIKeyExpires = "alter table model add column previous_api_key_expires timestamp"
+
 // Indexes
 const createModelAPIKeyIndexSQL = "CREATE INDEX IF NOT EXISTS api_key_idx ON model (api_key)"
 
@@ -146,6 +219,11 @@ type Model struct {
 	Name            string         `json:"model"`
 	KeypairID       int            `json:"keypair-id"`
 	APIKey          string         `json:"api-key"`
+	SerialFormat    string         `json:"serial-format"`     // regular expression that the serial numbers must match
+	SerialHeaders   string         `json:"serial-headers"`    // comma-separated serial-request headers to copy to the serial
+	SigningHours    string         `json:"signing-hours"`     // comma-separated hours that the model can be signed, e.g. 06:00-14:00
+	SigningTimezone string         `json:"signing-timezone"`  // time zone of the signing hours, UTC when it is empty
+	AllowedCIDRs    string         `json:"allowed-cidrs"`     // comma-separated networks that the model can be signed from
 	AuthorityID     string         `json:"authority-id"`      // from the signing keypair
 	KeyID           string         `json:"key-id"`            // from the signing keypair
 	KeyActive       bool           `json:"key-active"`        // from the signing keypair
@@ -157,28 +235,42 @@ type Model struct {
 	SealedKeyUser   string         `json:"-"`                 // from the system-user keypair
 	AssertionUser   string         `json:"-"`                 // from the system-user keypair
 	ModelAssertion  ModelAssertion `json:"assertion"`
+	Version         int            `json:"version"`              // incremented by each update, to detect conflicting updates
+	DeletedAt       *time.Time     `json:"deleted-at,omitempty"` // when the model was deleted, until it is restored
+	DeprecatedKey   bool           `json:"-"`                    // found by the previous API key of the model, after it was rotated
 }
 
 // CreateModelTable creates the database table for a model.
-func (db *DB) CreateModelTable() error {
-	_, err := db.Exec(createModelTableSQL)
+func (db *DB) CreateModelTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createModelTableSQL)
 	return err
 }
 
 // AlterModelTable updates an existing database model table with additional fields
-func (db *DB) AlterModelTable() error {
-	err := db.addUserKeypairFields()
+func (db *DB) AlterModelTable(ctx context.Context) error {
+	err := db.addUserKeypairFields(ctx)
 	if err != nil {
 		return err
 	}
 
-	err = db.addAPIKeyField()
+	err = db.addAPIKeyField(ctx)
 	if err != nil {
 		return err
 	}
 
+	// Add the serial number format and headers fields, which are skipped if they already exist
+	db.ExecContext(ctx, alterModelSerialFormat)
+	db.ExecContext(ctx, alterModelSerialHeaders)
+	db.ExecContext(ctx, alterModelVersion)
+	db.ExecContext(ctx, alterModelDeletedAt)
+	db.ExecContext(ctx, alterModelSigningHours)
+	db.ExecContext(ctx, alterModelSigningTimezone)
+	db.ExecContext(ctx, alterModelAllowedCIDRs)
+	db.ExecContext(ctx, alterModelPreviousAPIKey)
+	db.ExecContext(ctx, alterModelPreviousAPIKeyExpires)
+
 	// Create the index on the API key
-	_, err = db.Exec(createModelAPIKeyIndexSQL)
+	_, err = db.ExecContext(ctx, createModelAPIKeyIndexSQL)
 	if err != nil {
 		return err
 	}
@@ -187,40 +279,40 @@ func (db *DB) AlterModelTable() error {
 }
 
 // addUserKeypairFields adds the user keypair link to an existing model table.
-func (db *DB) addUserKeypairFields() error {
-	_, err := db.Exec(alterModelUserKeypairNullable)
+func (db *DB) addUserKeypairFields(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, alterModelUserKeypairNullable)
 	if err != nil {
 		// Field already exists so skip
 		return nil
 	}
 
 	// Default the user keypair
-	_, err = db.Exec(populateModelUserKeypair)
+	_, err = db.ExecContext(ctx, populateModelUserKeypair)
 	if err != nil {
-		log.Println("Error defaulting the user keypair")
+		log.Error("Error defaulting the user keypair")
 		return err
 	}
 
-	_, err = db.Exec(alterModelUserKeypairNotNullable)
+	_, err = db.ExecContext(ctx, alterModelUserKeypairNotNullable)
 	if err != nil {
-		log.Println("Error in making the user keypair not null")
+		log.Error("Error in making the user keypair not null")
 		return err
 	}
 	return nil
 }
 
 // addAPIKeyField adds and defaults the API key field to the model table
-func (db *DB) addAPIKeyField() error {
+func (db *DB) addAPIKeyField(ctx context.Context) error {
 
 	// Add the API key field to the model table
-	_, err := db.Exec(alterModelAPIKey)
+	_, err := db.ExecContext(ctx, alterModelAPIKey)
 	if err != nil {
 		// Field already exists so skip
 		return nil
 	}
 
 	// Default the API key for any records where it is empty
-	models, err := db.listAllModels()
+	models, err := db.listAllModels(ctx, ModelFilter{})
 	if err != nil {
 		return err
 	}
@@ -232,17 +324,17 @@ func (db *DB) addAPIKeyField() error {
 		// Generate an random API key and update the record
 		apiKey, err := generateAPIKey()
 		if err != nil {
-			log.Printf("Could not generate random string for the API key")
+			log.Errorf("Could not generate random string for the API key")
 			return errors.New("error generating random string for the API key")
 		}
 
 		// Update the API key on the model
 		model.APIKey = apiKey
-		db.updateModel(model)
+		db.updateModel(ctx, model)
 	}
 
 	// Add the constraints to the API key field
-	_, err = db.Exec(alterModelAPIKeyNotNullable)
+	_, err = db.ExecContext(ctx, alterModelAPIKeyNotNullable)
 	if err != nil {
 		return err
 	}
@@ -250,26 +342,83 @@ func (db *DB) addAPIKeyField() error {
 	return nil
 }
 
-func (db *DB) listAllModels() ([]Model, error) {
-	return db.listModelsFilteredByUser(anyUserFilter)
+func (db *DB) listAllModels(ctx context.Context, filter ModelFilter) ([]Model, error) {
+	return db.listModelsFilteredByUser(ctx, anyUserFilter, filter)
 }
 
-// listModels fetches the full catalogue of models from the database.
+// listModels fetches a page of the filtered catalogue of models from the database.
 // If a username is supplied, then only show the models for the user
 // [Permissions: Admin]
-func (db *DB) listModelsFilteredByUser(username string) ([]Model, error) {
-	models := []Model{}
+func (db *DB) listModelsFilteredByUser(ctx context.Context, username string, filter ModelFilter) ([]Model, error) {
+	listSQL := listModelsSQLBuilder(filter)
 
-	var (
-		rows *sql.Rows
-		err  error
-	)
+	if username != "" {
+		listSQL = listSQL.Where(userModelFilter(username))
+	}
 
+	query, args, err := listSQL.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving models: %v", err)
+	}
+	return db.queryModels(ctx, query, args...)
+}
+
+// userModelFilter restricts the models to the accounts of the user
+func userModelFilter(username string) sq.Sqlizer {
+	return sq.Expr(`EXISTS (
+			SELECT * FROM account acc
+			INNER JOIN useraccountlink ua on ua.account_id=acc.id
+			INNER JOIN userinfo u on ua.user_id=u.id
+			WHERE acc.authority_id=m.brand_id AND u.username=?`+accountRoleStandardSQL+`)`, username)
+}
+
+// listModelsSQLBuilder creates the query for a page of the models that are not deleted, with the optional filters
+func listModelsSQLBuilder(filter ModelFilter) sq.SelectBuilder {
+	sql := sq.
+		Select(listModelsColumnsSQL).
+		From("model m").
+		Join("keypair k on k.id = m.keypair_id").
+		Join("keypair ku on ku.id = m.user_keypair_id").
+		Where("m.deleted_at is null").
+		OrderBy("m.name", "m.id").
+		PlaceholderFormat(sq.Dollar)
+
+	if filter.BrandID != "" {
+		sql = sql.Where(sq.Eq{"m.brand_id": filter.BrandID})
+	}
+	if filter.KeypairID != 0 {
+		sql = sql.Where(sq.Or{sq.Eq{"m.keypair_id": filter.KeypairID}, sq.Eq{"m.user_keypair_id": filter.KeypairID}})
+	}
+	if filter.Name != "" {
+		sql = sql.Where(containsLike("m.name", "ILIKE", filter.Name))
+	}
+	if filter.Limit > 0 {
+		sql = sql.Limit(uint64(filter.Limit))
+	}
+	if filter.Offset > 0 {
+		sql = sql.Offset(uint64(filter.Offset))
+	}
+
+	return sql
+}
+
+func (db *DB) listAllDeletedModels(ctx context.Context) ([]Model, error) {
+	return db.listDeletedModelsFilteredByUser(ctx, anyUserFilter)
+}
+
+// listDeletedModelsFilteredByUser fetches the deleted models, which can be restored.
+// If a username is supplied, then only show the models of the accounts of the user
+func (db *DB) listDeletedModelsFilteredByUser(ctx context.Context, username string) ([]Model, error) {
 	if len(username) == 0 {
-		rows, err = db.Query(listModelsSQL)
-	} else {
-		rows, err = db.Query(listModelsForUserSQL, username)
+		return db.queryModels(ctx, listDeletedModelsSQL)
 	}
+	return db.queryModels(ctx, listDeletedModelsForUserSQL, username)
+}
+
+func (db *DB) queryModels(ctx context.Context, query string, args ...interface{}) ([]Model, error) {
+	models := []Model{}
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving models: %v", err)
 	}
@@ -277,14 +426,14 @@ func (db *DB) listModelsFilteredByUser(username string) ([]Model, error) {
 
 	for rows.Next() {
 		model := Model{}
-		err := rows.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive,
-			&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.AssertionUser)
+		err := rows.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.SerialFormat, &model.SerialHeaders, &model.SigningHours, &model.SigningTimezone, &model.AllowedCIDRs, &model.AuthorityID, &model.KeyID, &model.KeyActive,
+			&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.AssertionUser, &model.Version, &model.DeletedAt)
 		if err != nil {
 			return nil, fmt.Errorf("error retrieving models: %v", err)
 		}
 
 		// Get the linked model assertion headers
-		m, _ := db.GetModelAssert(model.ID)
+		m, _ := db.GetModelAssert(ctx, model.ID)
 		model.ModelAssertion = m
 
 		models = append(models, model)
@@ -293,104 +442,172 @@ func (db *DB) listModelsFilteredByUser(username string) ([]Model, error) {
 	return models, nil
 }
 
-// FindModel retrieves the model from the database.
-func (db *DB) FindModel(brandID, modelName, apiKey string) (Model, error) {
+// FindModel retrieves the model from the database. The API key is either the key of the model,
+// or a named API key of the brand account that has one of the scopes.
+func (db *DB) FindModel(ctx context.Context, brandID, modelName, apiKey string, scopes ...string) (Model, error) {
+	key := cacheKey(append([]string{brandID, modelName, apiKey}, scopes...)...)
+	model := Model{}
+	if db.cacheGet(cacheModels, key, &model) {
+		return model, nil
+	}
+
+	query, args := findModelSQL, []interface{}{brandID, modelName, apiKey, time.Now().UTC()}
+	allowed, deprecated := db.checkAccountAPIKey(ctx, apiKey, brandID, scopes...)
+	if allowed {
+		query, args = findModelByNameSQL, args[:2]
+	}
+
+	// The model is not cached for the previous key of a rotated API key, as the key expires
+	model, err := db.reader().findModel(ctx, query, args...)
+	if err != nil {
+		return model, err
+	}
+	model.DeprecatedKey = query == findModelSQL && model.APIKey != apiKey
+	if !model.DeprecatedKey && !deprecated {
+		db.cacheSet(cacheModels, key, model)
+	}
+	return model, nil
+}
+
+// FindAccountModel retrieves the model of the brand from the database, for a client that is
+// authenticated by a client certificate of the brand account
+func (db *DB) FindAccountModel(ctx context.Context, brandID, modelName string) (Model, error) {
+	return db.findModel(ctx, findModelByNameSQL, brandID, modelName)
+}
+
+func (db *DB) findModel(ctx context.Context, query string, args ...interface{}) (Model, error) {
 	model := Model{}
 
-	err := db.QueryRow(findModelSQL, brandID, modelName, apiKey).Scan(
-		&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
-		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser)
+	err := db.QueryRowContext(ctx, query, args...).Scan(
+		&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.SerialFormat, &model.SerialHeaders, &model.SigningHours, &model.SigningTimezone, &model.AllowedCIDRs, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
+		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser, &model.Version, &model.DeletedAt)
 	switch {
 	case err == sql.ErrNoRows:
 		return model, err
 	case err != nil:
-		log.Printf("Error retrieving database model: %v\n", err)
+		log.Errorf("Error retrieving database model: %v", err)
 		return model, err
 	}
 
 	// Get the linked model assertion headers
-	m, _ := db.GetModelAssert(model.ID)
+	m, _ := db.GetModelAssert(ctx, model.ID)
 	model.ModelAssertion = m
 
 	return model, nil
 }
 
-func (db *DB) getModel(modelID int) (Model, error) {
-	return db.getModelFilteredByUser(modelID, anyUserFilter)
+func (db *DB) getModel(ctx context.Context, modelID int) (Model, error) {
+	return db.getModelFilteredByUser(ctx, modelID, anyUserFilter)
 }
 
-func (db *DB) getModelFilteredByUser(modelID int, username string) (Model, error) {
+func (db *DB) getModelFilteredByUser(ctx context.Context, modelID int, username string) (Model, error) {
 	model := Model{}
 
 	var row *sql.Row
 
 	if len(username) == 0 {
-		row = db.QueryRow(getModelSQL, modelID)
+		row = db.QueryRowContext(ctx, getModelSQL, modelID)
 	} else {
-		row = db.QueryRow(getModelForUserSQL, modelID, username)
+		row = db.QueryRowContext(ctx, getModelForUserSQL, modelID, username)
 	}
 
-	err := row.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
-		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser)
+	err := row.Scan(&model.ID, &model.BrandID, &model.Name, &model.KeypairID, &model.APIKey, &model.SerialFormat, &model.SerialHeaders, &model.SigningHours, &model.SigningTimezone, &model.AllowedCIDRs, &model.AuthorityID, &model.KeyID, &model.KeyActive, &model.SealedKey,
+		&model.KeypairIDUser, &model.AuthorityIDUser, &model.KeyIDUser, &model.KeyActiveUser, &model.SealedKeyUser, &model.AssertionUser, &model.Version, &model.DeletedAt)
 	if err != nil {
 		return model, fmt.Errorf("error retrieving database model %d: %v", modelID, err)
 	}
 
 	// Get the linked model assertion headers
-	m, _ := db.GetModelAssert(model.ID)
+	m, _ := db.GetModelAssert(ctx, model.ID)
 	model.ModelAssertion = m
 
 	return model, nil
 }
 
-func (db *DB) updateModel(model Model) (string, error) {
-	return db.updateModelFilteredByUser(model, anyUserFilter)
+func (db *DB) updateModel(ctx context.Context, model Model) (string, error) {
+	return db.updateModelFilteredByUser(ctx, model, anyUserFilter)
 }
 
-func (db *DB) updateModelFilteredByUser(model Model, username string) (string, error) {
-	var err error
+// updateModelFilteredByUser updates a model. When the model has a version, it is only updated
+// if it has not been changed since that version was read
+func (db *DB) updateModelFilteredByUser(ctx context.Context, model Model, username string) (string, error) {
+	var (
+		result sql.Result
+		err    error
+	)
 
 	if len(username) == 0 {
-		_, err = db.Exec(updateModelSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey)
+		result, err = db.ExecContext(ctx, updateModelSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, model.SerialFormat, model.SerialHeaders, model.SigningHours, model.SigningTimezone, model.AllowedCIDRs, model.Version)
 	} else {
-		_, err = db.Exec(updateModelForUserSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, username)
+		result, err = db.ExecContext(ctx, updateModelForUserSQL, model.ID, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, model.SerialFormat, model.SerialHeaders, model.SigningHours, model.SigningTimezone, model.AllowedCIDRs, username, model.Version)
 	}
 	if err != nil {
 		return "", fmt.Errorf("error updating the database model for %s: %v", model.Name, err)
 	}
 
+	if err = db.checkVersion(ctx, result, "model", model.ID, model.Version); err != nil {
+		return "error-model-version", err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "error-model-not-found", fmt.Errorf("error updating the model %d: the model is not found or is deleted", model.ID)
+	}
 	return "", nil
 }
 
-func (db *DB) createModel(model Model) (Model, string, error) {
-	return db.createModelFilteredByUser(model, anyUserFilter)
+func (db *DB) createModel(ctx context.Context, model Model) (Model, string, error) {
+	return db.createModelFilteredByUser(ctx, model, anyUserFilter)
 }
 
-func (db *DB) createModelFilteredByUser(model Model, username string) (Model, string, error) {
+func (db *DB) createModelFilteredByUser(ctx context.Context, model Model, username string) (Model, string, error) {
 	// Create the model in the database
 	var createdModelID int
 
-	err := db.QueryRow(createModelSQL, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey).Scan(&createdModelID)
+	err := db.QueryRowContext(ctx, createModelSQL, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, model.SerialFormat, model.SerialHeaders, model.SigningHours, model.SigningTimezone, model.AllowedCIDRs).Scan(&createdModelID)
 	if err != nil {
 		return model, "", fmt.Errorf("error creating the model for %s: %v", model.Name, err)
 	}
 
 	// Return the created model
-	mdl, err := db.getModelFilteredByUser(createdModelID, username)
+	mdl, err := db.getModelFilteredByUser(ctx, createdModelID, username)
 	if err != nil {
 		return model, "", fmt.Errorf("error retrieving the created model for %s: %v", model.Name, err)
 	}
 	return mdl, "", nil
 }
 
+// bulkCreateModels creates the models of a bulk import in a transaction
+func (db *DB) bulkCreateModels(ctx context.Context, models []Model, results []BulkModelResult) error {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		for i, model := range models {
+			err := tx.QueryRowContext(ctx, createModelSQL, model.BrandID, model.Name, model.KeypairID, model.KeypairIDUser, model.APIKey, model.SerialFormat, model.SerialHeaders, model.SigningHours, model.SigningTimezone, model.AllowedCIDRs).Scan(&results[i].ModelID)
+			if err != nil {
+				log.Errorf("Error creating the model %s: %v", model.Name, err)
+				results[i].Error = err.Error()
+				return err
+			}
+		}
+		return nil
+	})
+
+	// The IDs of the new models are not kept when the transaction is rolled back
+	if err != nil {
+		for i := range results {
+			results[i].ModelID = 0
+		}
+	}
+	return err
+}
+
 // SyncModel creates a model for the factory sync
-func (db *DB) SyncModel(m Model) error {
+func (db *DB) SyncModel(ctx context.Context, m Model) error {
+	defer db.invalidateCache(cacheModels)
+
 	_, err := validateModel(m, "error-validate-new-model")
 	if err != nil {
 		return err
 	}
 
-	_, err = db.Exec(syncUpsertModelSQL, m.ID, m.BrandID, m.Name, m.KeypairID, m.KeypairIDUser, m.APIKey)
+	_, err = db.ExecContext(ctx, syncUpsertModelSQL, m.ID, m.BrandID, m.Name, m.KeypairID, m.KeypairIDUser, m.APIKey, m.SerialFormat, m.SerialHeaders, m.SigningHours, m.SigningTimezone, m.AllowedCIDRs)
 	if err != nil {
 		return err
 	}
@@ -398,42 +615,97 @@ func (db *DB) SyncModel(m Model) error {
 	return nil
 }
 
-func (db *DB) deleteModel(model Model) (string, error) {
-	return db.deleteModelFilteredByUser(model, anyUserFilter)
+func (db *DB) deleteModel(ctx context.Context, model Model) (string, error) {
+	return db.deleteModelFilteredByUser(ctx, model, anyUserFilter)
 }
 
-func (db *DB) deleteModelFilteredByUser(model Model, username string) (string, error) {
-	var err error
-	err = db.transaction(func(tx *sql.Tx) error {
+// deleteModelFilteredByUser marks the model as deleted. The model assertion is kept, so it is
+// part of the model when the model is restored
+func (db *DB) deleteModelFilteredByUser(ctx context.Context, model Model, username string) (string, error) {
+	var (
+		result sql.Result
+		err    error
+	)
 
-		// Delete the model assertion - log but ignore error as the assertion may not exist
-		if err := db.deleteModelAssert(model.ID); err != nil {
-			log.Println(err)
-		}
+	if len(username) == 0 {
+		result, err = db.ExecContext(ctx, deleteModelSQL, model.ID, time.Now().UTC())
+	} else {
+		result, err = db.ExecContext(ctx, deleteModelForUserSQL, model.ID, username, time.Now().UTC())
+	}
+	if err != nil {
+		log.Errorf("Error deleting the model %d: %v", model.ID, err)
+		return "", err
+	}
 
-		// Delete the model
-		if len(username) == 0 {
-			_, err = db.Exec(deleteModelSQL, model.ID)
-		} else {
-			_, err = db.Exec(deleteModelForUserSQL, model.ID, username)
-		}
-		if err != nil {
-			log.Printf("Error deleting the model %d: %v\n", model.ID, err)
-		}
-		return err
-	})
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "error-model-not-found", fmt.Errorf("error deleting the model %d: the model is not found", model.ID)
+	}
+	return "", nil
+}
 
-	return "", err
+func (db *DB) restoreModel(ctx context.Context, modelID int) (string, error) {
+	return db.restoreModelFilteredByUser(ctx, modelID, anyUserFilter)
 }
 
-func (db *DB) checkBrandsMatch(brandID string, keypairID, keypairIDUser int) bool {
+// restoreModelFilteredByUser reverts the deletion of a model
+func (db *DB) restoreModelFilteredByUser(ctx context.Context, modelID int, username string) (string, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if len(username) == 0 {
+		result, err = db.ExecContext(ctx, restoreModelSQL, modelID)
+	} else {
+		result, err = db.ExecContext(ctx, restoreModelForUserSQL, modelID, username)
+	}
+	if err != nil {
+		log.Errorf("Error restoring the model %d: %v", modelID, err)
+		return "", err
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "error-model-not-found", fmt.Errorf("error restoring the model %d: the model is not found or is not deleted", modelID)
+	}
+	return "", nil
+}
+
+func (db *DB) rotateModelAPIKey(ctx context.Context, modelID int, apiKey string, expires time.Time) error {
+	return db.rotateModelAPIKeyFilteredByUser(ctx, modelID, apiKey, expires, anyUserFilter)
+}
+
+// rotateModelAPIKeyFilteredByUser replaces the API key of a model, keeping the previous key valid
+// until it expires
+func (db *DB) rotateModelAPIKeyFilteredByUser(ctx context.Context, modelID int, apiKey string, expires time.Time, username string) error {
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if len(username) == 0 {
+		result, err = db.ExecContext(ctx, rotateModelAPIKeySQL, modelID, expires, apiKey)
+	} else {
+		result, err = db.ExecContext(ctx, rotateModelAPIKeyForUserSQL, modelID, username, expires, apiKey)
+	}
+	if err != nil {
+		log.Errorf("Error rotating the API key of the model %d: %v", modelID, err)
+		return fmt.Errorf("error rotating the API key of the model %d: %v", modelID, err)
+	}
+
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return fmt.Errorf("error rotating the API key of the model %d: the model is not found", modelID)
+	}
+	return nil
+}
+
+func (db *DB) checkBrandsMatch(ctx context.Context, brandID string, keypairID, keypairIDUser int) bool {
 
 	var count int
 
-	row := db.QueryRow(checkBrandsMatchSQL, brandID, keypairID, keypairIDUser)
+	row := db.QueryRowContext(ctx, checkBrandsMatchSQL, brandID, keypairID, keypairIDUser)
 	err := row.Scan(&count)
 	if err != nil {
-		log.Printf("Error checking that the account matches for a model: %v\n", err)
+		log.Errorf("Error checking that the account matches for a model: %v", err)
 		return false
 	}
 
@@ -441,14 +713,19 @@ func (db *DB) checkBrandsMatch(brandID string, keypairID, keypairIDUser int) boo
 }
 
 // CheckAPIKey validates that there is a model for the supplied API key
-func (db *DB) CheckAPIKey(apiKey string) bool {
-	row := db.QueryRow(checkAPIKeyExistsSQL, apiKey)
+func (db *DB) CheckAPIKey(ctx context.Context, apiKey string) bool {
+	stored, err := encryptAPIKey(apiKey)
+	if err != nil {
+		return false
+	}
+
+	row := db.QueryRowContext(ctx, checkAPIKeyExistsSQL, apiKey, time.Now().UTC(), stored)
 	return db.checkBoolQuery(row)
 }
 
 // CheckModelExists validates that there is a model for the brand and name
-func (db *DB) CheckModelExists(brandID, name string) bool {
-	row := db.QueryRow(checkModelExistsSQL, brandID, name)
+func (db *DB) CheckModelExists(ctx context.Context, brandID, name string) bool {
+	row := db.QueryRowContext(ctx, checkModelExistsSQL, brandID, name)
 	return db.checkBoolQuery(row)
 }
 
@@ -457,7 +734,7 @@ func (db *DB) checkBoolQuery(row *sql.Row) bool {
 
 	err := row.Scan(&found)
 	if err != nil {
-		log.Printf("Error with the boolean query: %v\n", err)
+		log.Errorf("Error with the boolean query: %v", err)
 		return false
 	}
 
@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+)
+
+// signingHours is a range of the signing hours of a model, in minutes from midnight. The range
+// wraps around midnight when it ends before it starts, e.g. for a night shift
+type signingHours struct {
+	start, end int
+}
+
+// contains checks whether the minute of the day is in the range
+func (h signingHours) contains(minute int) bool {
+	if h.start < h.end {
+		return minute >= h.start && minute < h.end
+	}
+	return minute >= h.start || minute < h.end
+}
+
+// parseSigningHours parses the comma-separated ranges of the signing hours, e.g. "06:00-14:00,22:00-06:00"
+func parseSigningHours(value string) ([]signingHours, error) {
+	hours := []signingHours{}
+	for _, r := range splitSerialHeaders(value) {
+		parts := strings.Split(r, "-")
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid range of signing hours: %s", r)
+		}
+
+		start, err := parseMinuteOfDay(parts[0])
+		if err != nil {
+			return nil, err
+		}
+		end, err := parseMinuteOfDay(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		if start == end {
+			return nil, fmt.Errorf("the range of signing hours must not be empty: %s", r)
+		}
+		hours = append(hours, signingHours{start: start % (24 * 60), end: end % (24 * 60)})
+	}
+	return hours, nil
+}
+
+// parseMinuteOfDay parses a time of the day as HH:MM, where 24:00 is the end of the day
+func parseMinuteOfDay(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if value == "24:00" {
+		return 24 * 60, nil
+	}
+
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of the signing hours: %s", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// signingLocation returns the time zone of the signing hours, which is UTC by default
+func signingLocation(timezone string) (*time.Location, error) {
+	if len(timezone) == 0 {
+		return time.UTC, nil
+	}
+	return time.LoadLocation(timezone)
+}
+
+// validateSigningRestrictions checks the signing hours, their time zone and the allowed networks of a model
+func validateSigningRestrictions(model Model) error {
+	if _, err := parseSigningHours(model.SigningHours); err != nil {
+		return fmt.Errorf("the Signing Hours must be ranges of HH:MM-HH:MM: %v", err)
+	}
+	if _, err := signingLocation(model.SigningTimezone); err != nil {
+		return errors.New("the Signing Time Zone must be a valid time zone, e.g. Europe/London")
+	}
+	for _, c := range model.AllowedCIDRList() {
+		if _, err := parseAllowedCIDR(c); err != nil {
+			return fmt.Errorf("the Allowed Networks must be IP addresses or CIDR ranges: %s", c)
+		}
+	}
+	return nil
+}
+
+// AllowedCIDRList returns the networks that the model can be signed from
+func (model Model) AllowedCIDRList() []string {
+	return splitSerialHeaders(model.AllowedCIDRs)
+}
+
+// AllowsSigningTime checks whether the model can be signed at the time. The model can be
+// signed at any time when it has no signing hours
+func (model Model) AllowsSigningTime(t time.Time) bool {
+	hours, err := parseSigningHours(model.SigningHours)
+	if err != nil {
+		return false
+	}
+	if len(hours) == 0 {
+		return true
+	}
+
+	location, err := signingLocation(model.SigningTimezone)
+	if err != nil {
+		return false
+	}
+	t = t.In(location)
+	minute := t.Hour()*60 + t.Minute()
+
+	for _, h := range hours {
+		if h.contains(minute) {
+			return true
+		}
+	}
+	return false
+}
+
+// AllowsSource checks whether the model can be signed from the source IP. The model can be
+// signed from anywhere when it has no allowed networks
+func (model Model) AllowsSource(sourceIP string) bool {
+	cidrs := model.AllowedCIDRList()
+	if len(cidrs) == 0 {
+		return true
+	}
+
+	ip := net.ParseIP(sourceIP)
+	if ip == nil {
+		return false
+	}
+	for _, c := range cidrs {
+		if network, err := parseAllowedCIDR(c); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSigningHours(t *testing.T) {
+	tests := []struct {
+		value string
+		count int
+		valid bool
+	}{
+		{"", 0, true},
+		{"06:00-14:00", 1, true},
+		{"06:00-14:00, 22:00-06:00", 2, true},
+		{"00:00-24:00", 1, true},
+		{"06:00", 0, false},
+		{"06:00-25:00", 0, false},
+		{"6am-2pm", 0, false},
+		{"06:00-06:00", 0, false},
+	}
+
+	for _, tt := range tests {
+		hours, err := parseSigningHours(tt.value)
+		if (err == nil) != tt.valid {
+			t.Errorf("Signing hours %q: expected valid %v, got error: %v", tt.value, tt.valid, err)
+			continue
+		}
+		if len(hours) != tt.count {
+			t.Errorf("Signing hours %q: expected %d ranges, got %d", tt.value, tt.count, len(hours))
+		}
+	}
+}
+
+func TestModelAllowsSigningTime(t *testing.T) {
+	tests := []struct {
+		hours    string
+		timezone string
+		time     string
+		allowed  bool
+	}{
+		{"", "", "2020-01-01T03:00:00Z", true},
+		{"06:00-14:00", "", "2020-01-01T06:00:00Z", true},
+		{"06:00-14:00", "", "2020-01-01T13:59:00Z", true},
+		{"06:00-14:00", "", "2020-01-01T14:00:00Z", false},
+		{"06:00-14:00", "", "2020-01-01T05:59:00Z", false},
+		{"06:00-14:00,22:00-06:00", "", "2020-01-01T23:30:00Z", true},
+		{"06:00-14:00,22:00-06:00", "", "2020-01-01T03:00:00Z", true},
+		{"06:00-14:00,22:00-06:00", "", "2020-01-01T18:00:00Z", false},
+		{"00:00-24:00", "", "2020-01-01T18:00:00Z", true},
+		{"09:00-17:00", "Asia/Taipei", "2020-01-01T02:00:00Z", true},
+		{"09:00-17:00", "Asia/Taipei", "2020-01-01T10:00:00Z", false},
+		{"09:00-17:00", "Invalid/Zone", "2020-01-01T10:00:00Z", false},
+	}
+
+	for _, tt := range tests {
+		signingTime, err := time.Parse(time.RFC3339, tt.time)
+		if err != nil {
+			t.Fatalf("Error parsing the time: %v", err)
+		}
+		model := Model{SigningHours: tt.hours, SigningTimezone: tt.timezone}
+		if model.AllowsSigningTime(signingTime) != tt.allowed {
+			t.Errorf("Signing hours %q (%s) at %s: expected allowed %v", tt.hours, tt.timezone, tt.time, tt.allowed)
+		}
+	}
+}
+
+func TestModelAllowsSource(t *testing.T) {
+	tests := []struct {
+		cidrs    string
+		sourceIP string
+		allowed  bool
+	}{
+		{"", "203.0.113.1", true},
+		{"192.0.2.0/24", "192.0.2.10", true},
+		{"192.0.2.0/24", "198.51.100.1", false},
+		{"10.0.0.0/8, 198.51.100.1", "198.51.100.1", true},
+		{"192.0.2.0/24", "invalid", false},
+	}
+
+	for _, tt := range tests {
+		model := Model{AllowedCIDRs: tt.cidrs}
+		if model.AllowsSource(tt.sourceIP) != tt.allowed {
+			t.Errorf("Allowed networks %q from %s: expected allowed %v", tt.cidrs, tt.sourceIP, tt.allowed)
+		}
+	}
+}
+
+func TestValidateSigningRestrictions(t *testing.T) {
+	tests := []struct {
+		model Model
+		valid bool
+	}{
+		{Model{}, true},
+		{Model{SigningHours: "06:00-14:00", SigningTimezone: "Europe/London", AllowedCIDRs: "192.0.2.0/24,198.51.100.1"}, true},
+		{Model{SigningHours: "06:00-"}, false},
+		{Model{SigningTimezone: "Invalid/Zone"}, false},
+		{Model{AllowedCIDRs: "192.0.2.0/33"}, false},
+	}
+
+	for _, tt := range tests {
+		err := validateSigningRestrictions(tt.model)
+		if (err == nil) != tt.valid {
+			t.Errorf("Signing restrictions %v: expected valid %v, got error: %v", tt.model, tt.valid, err)
+		}
+	}
+}
@@ -0,0 +1,254 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The actions that create a revision of a model
+const (
+	ModelRevisionCreate  = "create"
+	ModelRevisionUpdate  = "update"
+	ModelRevisionDelete  = "delete"
+	ModelRevisionRestore = "restore"
+	ModelRevisionRotate  = "rotate"
+)
+
+// The revisions of the models, with a JSON snapshot of the model after each change
+const createModelRevisionTableSQL = `
+	CREATE TABLE IF NOT EXISTS modelrevision (
+		id               serial primary key not null,
+		model_id         int not null,
+		version          int not null default 0,
+		username         varchar(200) not null default '',
+		action           varchar(50) not null,
+		model            text not null default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createModelRevisionModelIndexSQL = "CREATE INDEX IF NOT EXISTS modelrevision_model_idx ON modelrevision (model_id)"
+
+const createModelRevisionSQL = `
+	INSERT INTO modelrevision (model_id, version, username, action, model)
+	VALUES ($1,$2,$3,$4,$5)`
+
+const listModelRevisionSQL = `
+	SELECT id, model_id, version, username, action, model, created
+	FROM modelrevision
+	WHERE model_id=$1
+	ORDER BY id`
+
+// ModelSnapshot holds the fields of a model at a revision. The API key is identified by
+// its hash, so the key itself is not stored in the history
+type ModelSnapshot struct {
+	BrandID         string `json:"brand-id"`
+	Name            string `json:"model"`
+	KeypairID       int    `json:"keypair-id"`
+	KeyID           string `json:"key-id"`
+	KeypairIDUser   int    `json:"keypair-id-user"`
+	KeyIDUser       string `json:"key-id-user"`
+	SerialFormat    string `json:"serial-format"`
+	SerialHeaders   string `json:"serial-headers"`
+	SigningHours    string `json:"signing-hours"`
+	SigningTimezone string `json:"signing-timezone"`
+	AllowedCIDRs    string `json:"allowed-cidrs"`
+	APIKeyID        string `json:"api-key-id"`
+}
+
+// ModelChange is a field of a model that is changed by a revision
+type ModelChange struct {
+	Field string `json:"field"`
+	From  string `json:"from"`
+	To    string `json:"to"`
+}
+
+// ModelRevision is a change of a model, by a user, with the fields that it changed
+type ModelRevision struct {
+	ID       int           `json:"id"`
+	ModelID  int           `json:"model-id"`
+	Version  int           `json:"version"`
+	Username string        `json:"username"`
+	Action   string        `json:"action"`
+	Created  time.Time     `json:"created"`
+	Model    ModelSnapshot `json:"model"`
+	Changes  []ModelChange `json:"changes"`
+}
+
+// CreateModelRevisionTable creates the database table for the revisions of the models
+func (db *DB) CreateModelRevisionTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createModelRevisionTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createModelRevisionModelIndexSQL)
+	return err
+}
+
+// ListAllowedModelHistory returns the revisions of a model, the latest first, if the model is
+// allowed to authorization
+func (db *DB) ListAllowedModelHistory(ctx context.Context, modelID int, authorization User) ([]ModelRevision, error) {
+	var err error
+
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		_, err = db.getModel(ctx, modelID)
+	case Admin:
+		_, err = db.getModelFilteredByUser(ctx, modelID, authorization.Username)
+	default:
+		return []ModelRevision{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the model history: %v", err)
+	}
+
+	return db.listModelRevisions(ctx, modelID)
+}
+
+// recordModelRevision stores the model after a change as a revision. The change is already
+// made, so an error is logged rather than returned
+func (db *DB) recordModelRevision(ctx context.Context, modelID int, action, username string) {
+	model, err := db.getModel(ctx, modelID)
+	if err != nil {
+		log.Errorf("Error recording the revision of the model %d: %v", modelID, err)
+		return
+	}
+
+	data, err := json.Marshal(modelSnapshot(model))
+	if err != nil {
+		log.Errorf("Error recording the revision of the model %d: %v", modelID, err)
+		return
+	}
+
+	if _, err = db.ExecContext(ctx, createModelRevisionSQL, modelID, model.Version, username, action, string(data)); err != nil {
+		log.Errorf("Error recording the revision of the model %d: %v", modelID, err)
+	}
+}
+
+// listModelRevisions returns the revisions of a model with their changes, the latest first
+func (db *DB) listModelRevisions(ctx context.Context, modelID int) ([]ModelRevision, error) {
+	rows, err := db.QueryContext(ctx, listModelRevisionSQL, modelID)
+	if err != nil {
+		log.Errorf("Error retrieving the model history: %v", err)
+		return nil, fmt.Errorf("error retrieving the model history: %v", err)
+	}
+	defer rows.Close()
+
+	revisions := []ModelRevision{}
+	for rows.Next() {
+		r := ModelRevision{}
+		var data string
+		if err := rows.Scan(&r.ID, &r.ModelID, &r.Version, &r.Username, &r.Action, &data, &r.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the model history: %v", err)
+		}
+		if err := json.Unmarshal([]byte(data), &r.Model); err != nil {
+			return nil, fmt.Errorf("error retrieving the model history: %v", err)
+		}
+		revisions = append(revisions, r)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error retrieving the model history: %v", err)
+	}
+
+	return modelHistory(revisions), nil
+}
+
+// modelHistory sets the changes of each revision from the previous one, and returns the
+// revisions the latest first. The changes of the first revision of a model that existed
+// before its history was recorded are not known
+func modelHistory(revisions []ModelRevision) []ModelRevision {
+	history := make([]ModelRevision, len(revisions))
+	for i, r := range revisions {
+		switch {
+		case i > 0:
+			r.Changes = modelChanges(revisions[i-1].Model, r.Model)
+		case r.Action == ModelRevisionCreate:
+			r.Changes = modelChanges(ModelSnapshot{}, r.Model)
+		default:
+			r.Changes = []ModelChange{}
+		}
+		history[len(revisions)-1-i] = r
+	}
+	return history
+}
+
+// modelChanges returns the fields that differ between two revisions of a model
+func modelChanges(from, to ModelSnapshot) []ModelChange {
+	fields := []struct {
+		name     string
+		from, to string
+	}{
+		{"brand-id", from.BrandID, to.BrandID},
+		{"model", from.Name, to.Name},
+		{"keypair-id", keypairIDString(from.KeypairID), keypairIDString(to.KeypairID)},
+		{"key-id", from.KeyID, to.KeyID},
+		{"keypair-id-user", keypairIDString(from.KeypairIDUser), keypairIDString(to.KeypairIDUser)},
+		{"key-id-user", from.KeyIDUser, to.KeyIDUser},
+		{"serial-format", from.SerialFormat, to.SerialFormat},
+		{"serial-headers", from.SerialHeaders, to.SerialHeaders},
+		{"signing-hours", from.SigningHours, to.SigningHours},
+		{"signing-timezone", from.SigningTimezone, to.SigningTimezone},
+		{"allowed-cidrs", from.AllowedCIDRs, to.AllowedCIDRs},
+		{"api-key-id", from.APIKeyID, to.APIKeyID},
+	}
+
+	changes := []ModelChange{}
+	for _, f := range fields {
+		if f.from != f.to {
+			changes = append(changes, ModelChange{Field: f.name, From: f.from, To: f.to})
+		}
+	}
+	return changes
+}
+
+// keypairIDString formats the ID of a keypair for a change, where a missing keypair is empty
+func keypairIDString(keypairID int) string {
+	if keypairID == 0 {
+		return ""
+	}
+	return strconv.Itoa(keypairID)
+}
+
+// modelSnapshot returns the fields of a model that are kept in its history
+func modelSnapshot(model Model) ModelSnapshot {
+	return ModelSnapshot{
+		BrandID:         model.BrandID,
+		Name:            model.Name,
+		KeypairID:       model.KeypairID,
+		KeyID:           model.KeyID,
+		KeypairIDUser:   model.KeypairIDUser,
+		KeyIDUser:       model.KeyIDUser,
+		SerialFormat:    model.SerialFormat,
+		SerialHeaders:   model.SerialHeaders,
+		SigningHours:    model.SigningHours,
+		SigningTimezone: model.SigningTimezone,
+		AllowedCIDRs:    model.AllowedCIDRs,
+		APIKeyID:        APIKeyID(model.APIKey),
+	}
+}
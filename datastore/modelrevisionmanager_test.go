@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestListAllowedModelHistory(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	for _, keyID := range []string{"alder-key", "alder-new-key"} {
+		if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: keyID, SealedKey: "sealed", KeyName: keyID}); err != nil {
+			t.Fatalf("PutKeypair() error = %v", err)
+		}
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	newKeypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-new-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	model.KeypairID = newKeypair.ID
+	if _, err := db.UpdateAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("UpdateAllowedModel() error = %v", err)
+	}
+	if _, err := db.DeleteAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("DeleteAllowedModel() error = %v", err)
+	}
+
+	history, err := db.ListAllowedModelHistory(ctx, model.ID, root)
+	if err != nil {
+		t.Fatalf("ListAllowedModelHistory() error = %v", err)
+	}
+	if len(history) != 3 {
+		t.Fatalf("ListAllowedModelHistory() = %d revisions, want 3", len(history))
+	}
+
+	// The latest revision is first, and the delete does not change the fields
+	actions := []string{history[0].Action, history[1].Action, history[2].Action}
+	if !reflect.DeepEqual(actions, []string{ModelRevisionDelete, ModelRevisionUpdate, ModelRevisionCreate}) {
+		t.Errorf("ListAllowedModelHistory() actions = %v", actions)
+	}
+	if len(history[0].Changes) != 0 {
+		t.Errorf("delete revision changes = %v", history[0].Changes)
+	}
+	if history[1].Version != 2 || history[1].Model.KeyID != "alder-new-key" {
+		t.Errorf("update revision = %+v", history[1])
+	}
+
+	want := []ModelChange{
+		{Field: "keypair-id", From: keypairIDString(keypair.ID), To: keypairIDString(newKeypair.ID)},
+		{Field: "key-id", From: "alder-key", To: "alder-new-key"},
+	}
+	if !reflect.DeepEqual(history[1].Changes, want) {
+		t.Errorf("update revision changes = %v, want %v", history[1].Changes, want)
+	}
+
+	// The API key is identified by its hash
+	for _, c := range history[2].Changes {
+		if c.Field == "api-key-id" && c.To != APIKeyID("model-api-key") {
+			t.Errorf("create revision API key = %q", c.To)
+		}
+	}
+
+	if _, err := db.ListAllowedModelHistory(ctx, 9999, root); err == nil {
+		t.Error("ListAllowedModelHistory() expected an error for an unknown model")
+	}
+}
+
+func TestModelHistoryUnknownCreate(t *testing.T) {
+	snapshot := ModelSnapshot{BrandID: "alder", Name: "alder-basic", KeypairID: 1}
+	revisions := []ModelRevision{
+		{ID: 1, Action: ModelRevisionUpdate, Model: snapshot},
+		{ID: 2, Action: ModelRevisionUpdate, Model: ModelSnapshot{BrandID: "alder", Name: "alder-basic", KeypairID: 2}},
+	}
+
+	history := modelHistory(revisions)
+	if history[0].ID != 2 || !reflect.DeepEqual(history[0].Changes, []ModelChange{{Field: "keypair-id", From: "1", To: "2"}}) {
+		t.Errorf("modelHistory() latest = %+v", history[0])
+	}
+
+	// The changes are not known for a model that existed before its history was recorded
+	if history[1].ID != 1 || len(history[1].Changes) != 0 {
+		t.Errorf("modelHistory() first = %+v", history[1])
+	}
+}
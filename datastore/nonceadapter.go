@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// GetAllowedAccountNonceTTL returns the nonce validity of the account, if the user is authorized to see it
+func (db *DB) GetAllowedAccountNonceTTL(ctx context.Context, accountID int, authorization User) (AccountNonceTTL, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return AccountNonceTTL{}, errors.New("You do not have permissions to this account")
+		}
+		return db.getAccountNonceTTL(ctx, accountID)
+	default:
+		return AccountNonceTTL{}, errors.New("You do not have permissions to this account")
+	}
+}
+
+// UpdateAllowedAccountNonceTTL validates and updates the nonce validity override of the account,
+// if the user is authorized to do it. Only the superuser sets the nonce validity of the accounts
+func (db *DB) UpdateAllowedAccountNonceTTL(ctx context.Context, ttl AccountNonceTTL, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		if err := validateAccountNonceTTL(ttl); err != nil {
+			return err
+		}
+		return db.updateAccountNonceTTL(ctx, ttl)
+	default:
+		return errors.New("You do not have permissions to update the account nonce validity")
+	}
+}
+
+func validateAccountNonceTTL(ttl AccountNonceTTL) error {
+	if !ttl.Override {
+		return nil
+	}
+	if ttl.Seconds < 1 || time.Duration(ttl.Seconds)*time.Second > maxNonceTTL {
+		return fmt.Errorf("The nonce validity must be between 1 and %d seconds", int(maxNonceTTL/time.Second))
+	}
+	return nil
+}
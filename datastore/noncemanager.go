@@ -20,105 +20,228 @@
 package datastore
 
 import (
-	"crypto/sha1"
+	"context"
+	"crypto/sha256"
+	"database/sql"
 	"errors"
 	"fmt"
 	"io"
 	"strconv"
 	"time"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/random"
 )
 
-// Set the nonce expiry time
-const nonceMaximumAge = 600
+// Default validity of the nonces, and the interval of the deletion of the expired nonces
+// by the scheduler, when they are not configured
+const (
+	defaultNonceTTL           = 10 * time.Minute
+	defaultNoncePurgeInterval = 10 * time.Minute
+)
+
+// maxNonceTTL is the longest validity of a nonce, for the service and the account overrides
+const maxNonceTTL = 24 * time.Hour
 
 const createDeviceNonceTableSQL = `
 	CREATE TABLE IF NOT EXISTS devicenonce (
 		id             serial primary key not null,
 		nonce          varchar(200) not null,
 		timestamp      int not null,		
+		expires        int not null,
 		created        timestamp default current_timestamp
 	)
 `
 
+// The validity override of the nonces of an account, in seconds
+const createAccountNonceTableSQL = `
+	CREATE TABLE IF NOT EXISTS accountnonce (
+		id               serial primary key not null,
+		account_id       int references account not null unique,
+		ttl              int not null
+	)
+`
+
+// Add the expiry of the nonces, so each nonce keeps the validity of its account. The existing
+// nonces expire with the validity they were created with
+const alterDeviceNonceExpires = "ALTER TABLE devicenonce ADD COLUMN expires int"
+const updateDeviceNonceExpires = "UPDATE devicenonce SET expires=timestamp+600 WHERE expires IS NULL"
+
 // Indexes
 const createDeviceNonceNonceIndexSQL = "CREATE INDEX IF NOT EXISTS nonce_idx ON devicenonce (nonce)"
 const createDeviceNonceTimeStampIndexSQL = "CREATE INDEX IF NOT EXISTS timestamp_idx ON devicenonce (timestamp)"
+const createDeviceNonceExpiresIndexSQL = "CREATE INDEX IF NOT EXISTS expires_idx ON devicenonce (expires)"
 
 // Queries
 const maxIDDeviceNonceSQLite = "SELECT COUNT(*)+1 from devicenonce"
-const createDeviceNonceSQLite = "INSERT INTO devicenonce (id, nonce, timestamp) VALUES ($1, $2, $3)"
-const createDeviceNonceSQL = "INSERT INTO devicenonce (nonce, timestamp) VALUES ($1, $2)"
-const deleteExpiredDeviceNonceSQL = "DELETE FROM devicenonce where timestamp<$1"
-const deleteDeviceNonceSQL = "DELETE FROM devicenonce where nonce=$1"
+const createDeviceNonceSQLite = "INSERT INTO devicenonce (id, nonce, timestamp, expires) VALUES ($1, $2, $3, $4)"
+const createDeviceNonceSQL = "INSERT INTO devicenonce (nonce, timestamp, expires) VALUES ($1, $2, $3)"
+const deleteExpiredDeviceNonceSQL = "DELETE FROM devicenonce where expires<$1"
+const deleteDeviceNonceSQL = "DELETE FROM devicenonce where nonce=$1 and expires>=$2"
+const getDeviceNonceExpiresSQL = "SELECT expires FROM devicenonce where nonce=$1"
+
+// The validity override of the account of the model or named API key. When the key is shared
+// by the models of several accounts, the shortest validity applies
+const getDeviceNonceTTLSQL = `
+	SELECT MIN(n.ttl)
+	FROM account a
+	INNER JOIN accountnonce n ON n.account_id=a.id
+	WHERE a.authority_id IN (SELECT brand_id FROM model WHERE (api_key=$1 OR (previous_api_key=$1 AND previous_api_key_expires>$3)) AND deleted_at IS NULL)
+	OR a.id IN (SELECT account_id FROM apikey WHERE (api_key=$2 OR previous_key=$2) AND active)
+`
+
+const getAccountNonceTTLSQL = `
+	SELECT a.id, n.ttl IS NOT NULL, COALESCE(n.ttl, 0)
+	FROM account a
+	LEFT JOIN accountnonce n ON n.account_id=a.id
+	WHERE a.id=$1
+`
+
+const upsertAccountNonceTTLSQL = `
+	WITH upsert AS (
+		update accountnonce set ttl=$2
+		where account_id=$1
+		RETURNING *
+	)
+	insert into accountnonce (account_id,ttl)
+	select $1, $2
+	where not exists (select * from upsert)
+`
+
+// sqlite3 syntax of the upsert, inserting the TTL when it is not updated
+const upsertAccountNonceTTLSQLite = `
+	UPDATE accountnonce SET ttl=$2 WHERE account_id=$1;
+	INSERT INTO accountnonce (account_id,ttl) SELECT $1, $2 WHERE changes()=0
+`
+
+const deleteAccountNonceTTLSQL = "DELETE FROM accountnonce WHERE account_id=$1"
 
 // DeviceNonce holds the details of the nonce, combining a timestamp and random text
 type DeviceNonce struct {
 	ID        int
 	Nonce     string
 	TimeStamp int64
+	Expires   int64
 	Created   time.Time
 }
 
+// AccountNonceTTL holds the validity of the nonces of an account, in seconds.
+// Without an override, the default validity of the service is used
+type AccountNonceTTL struct {
+	AccountID int  `json:"accountID"`
+	Override  bool `json:"override"`
+	Seconds   int  `json:"seconds"`
+	Default   int  `json:"default"`
+}
+
+// NonceTTL returns the validity of the nonces from the config settings
+func NonceTTL(settings config.Settings) (time.Duration, error) {
+	if len(settings.NonceTTL) == 0 {
+		return defaultNonceTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(settings.NonceTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid nonce validity: %v", err)
+	}
+	if ttl < time.Second || ttl > maxNonceTTL {
+		return 0, fmt.Errorf("the nonce validity must be between 1s and %v", maxNonceTTL)
+	}
+	return ttl, nil
+}
+
+// NoncePurgeInterval returns the interval of the deletion of the expired nonces from the config settings
+func NoncePurgeInterval(settings config.Settings) (time.Duration, error) {
+	if len(settings.NoncePurgeInterval) == 0 {
+		return defaultNoncePurgeInterval, nil
+	}
+
+	interval, err := time.ParseDuration(settings.NoncePurgeInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid nonce purge interval: %v", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("the nonce purge interval must be positive")
+	}
+	return interval, nil
+}
+
 // CreateDeviceNonceTable creates the database table for nonces with its indexes.
-func (db *DB) CreateDeviceNonceTable() error {
+func (db *DB) CreateDeviceNonceTable(ctx context.Context) error {
 	// Create the table
-	_, err := db.Exec(createDeviceNonceTableSQL)
+	_, err := db.ExecContext(ctx, createDeviceNonceTableSQL)
 	if err != nil {
 		return err
 	}
 
 	// Create the indexes
-	_, err = db.Exec(createDeviceNonceNonceIndexSQL)
+	_, err = db.ExecContext(ctx, createDeviceNonceNonceIndexSQL)
+	if err != nil {
+		return err
+	}
+	_, err = db.ExecContext(ctx, createDeviceNonceTimeStampIndexSQL)
+	return err
+}
+
+// AlterDeviceNonceTable adds the expiry of the nonces, with its index
+func (db *DB) AlterDeviceNonceTable(ctx context.Context) error {
+	db.ExecContext(ctx, alterDeviceNonceExpires)
+
+	_, err := db.ExecContext(ctx, updateDeviceNonceExpires)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(createDeviceNonceTimeStampIndexSQL)
+	_, err = db.ExecContext(ctx, createDeviceNonceExpiresIndexSQL)
 	return err
 }
 
-// CreateDeviceNonce stores a new nonce entry
-func (db *DB) CreateDeviceNonce() (DeviceNonce, error) {
+// CreateAccountNonceTable creates the database table for the nonce validity overrides of the accounts
+func (db *DB) CreateAccountNonceTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAccountNonceTableSQL)
+	return err
+}
+
+// CreateDeviceNonce stores a new nonce entry, that is valid for the given time
+func (db *DB) CreateDeviceNonce(ctx context.Context, ttl time.Duration) (DeviceNonce, error) {
 	// Generate a nonce with a timestamp and random string
 	nonce, err := generateNonce()
 	if err != nil {
-		log.Printf("Error creating the nonce: %v\n", err)
+		log.Errorf("Error creating the nonce: %v", err)
 		return DeviceNonce{}, err
 	}
+	nonce.Expires = nonce.TimeStamp + int64(ttl/time.Second)
 
 	// Create the nonce in the database
 	if InFactory() {
 		// Need to generate our own ID
 		var nextID int
-		err = db.QueryRow(maxIDDeviceNonceSQLite).Scan(&nextID)
+		err = db.QueryRowContext(ctx, maxIDDeviceNonceSQLite).Scan(&nextID)
 		if err != nil {
-			log.Printf("Error retrieving next nonce ID: %v\n", err)
+			log.Errorf("Error retrieving next nonce ID: %v", err)
 			return nonce, err
 		}
 
-		_, err = db.Exec(createDeviceNonceSQLite, nextID, nonce.Nonce, nonce.TimeStamp)
+		_, err = db.ExecContext(ctx, createDeviceNonceSQLite, nextID, nonce.Nonce, nonce.TimeStamp, nonce.Expires)
 	} else {
-		_, err = db.Exec(createDeviceNonceSQL, nonce.Nonce, nonce.TimeStamp)
+		_, err = db.ExecContext(ctx, createDeviceNonceSQL, nonce.Nonce, nonce.TimeStamp, nonce.Expires)
 	}
 
 	if err != nil {
-		log.Printf("Error creating the nonce: %v\n", err)
+		log.Errorf("Error creating the nonce: %v", err)
 		return DeviceNonce{}, err
 	}
 
 	return nonce, nil
 }
 
-// DeleteExpiredDeviceNonces removes nonces with timestamp older than max allowed lifetime
-func (db *DB) DeleteExpiredDeviceNonces() error {
+// DeleteExpiredDeviceNonces removes the nonces that have expired
+func (db *DB) DeleteExpiredDeviceNonces(ctx context.Context) error {
 	// Remove expired nonces from the table
-	timestamp := time.Now().Unix() - nonceMaximumAge
-	_, err := db.Exec(deleteExpiredDeviceNonceSQL, timestamp)
+	_, err := db.ExecContext(ctx, deleteExpiredDeviceNonceSQL, time.Now().Unix())
 	if err != nil {
-		log.Printf("Error deleting expired nonces: %v\n", err)
+		log.Errorf("Error deleting expired nonces: %v", err)
 		return errors.New("Error communicating with the database")
 	}
 
@@ -126,41 +249,95 @@ func (db *DB) DeleteExpiredDeviceNonces() error {
 }
 
 // ValidateDeviceNonce checks that a device nonce is valid and has not expired
-func (db *DB) ValidateDeviceNonce(nonce string) error {
-	err := db.DeleteExpiredDeviceNonces()
+func (db *DB) ValidateDeviceNonce(ctx context.Context, nonce string) error {
+	// Find the nonce in the database to check that it is valid and has not expired. The expired
+	// nonces are deleted by the scheduler. Here we attempt to delete the nonce and check the number
+	// of rows affected. This makes sure that we do not allow a nonce to be re-used.
+	now := time.Now().Unix()
+	result, err := db.ExecContext(ctx, deleteDeviceNonceSQL, nonce, now)
 	if err != nil {
-		log.Printf("Error checking expired nonces: %v\n", err)
-		return err
-	}
-	// Find the nonce in the database to check that it is valid (we already deleted expired nonces)
-	// Here we attempt to delete the nonce and check the number of rows affected. This makes sure that
-	// we do not allow a nonce to be re-used.
-	result, err := db.Exec(deleteDeviceNonceSQL, nonce)
-	if err != nil {
-		log.Printf("Error checking nonce: %v\n", err)
+		log.Errorf("Error checking nonce: %v", err)
 		return errors.New("Error communicating with the database")
 	}
 	rows, err := result.RowsAffected()
 	if err != nil {
-		log.Printf("Error checking nonce delete row count: %v\n", err)
+		log.Errorf("Error checking nonce delete row count: %v", err)
 		return errors.New("Error communicating with the database")
 	}
-	if rows == 0 {
-		log.Println("Error invalid or expired nonce")
-		return errors.New("The nonce is invalid or expired")
+	if rows > 0 {
+		return nil
+	}
+
+	// The expired nonces stay in the table until the next purge, so the expiry can be reported
+	var expires int64
+	err = db.QueryRowContext(ctx, getDeviceNonceExpiresSQL, nonce).Scan(&expires)
+	if err != nil {
+		log.Error("Error invalid nonce")
+		return errors.New("The nonce is invalid or has already been used")
+	}
+	log.Error("Error expired nonce")
+	return nonceExpiredError(expires, now)
+}
+
+// nonceExpiredError reports how long ago the nonce expired, so the device requests a new one
+func nonceExpiredError(expires, now int64) error {
+	expired := time.Duration(now-expires) * time.Second
+	if expired <= 0 {
+		return errors.New("The nonce has expired, request a new nonce")
+	}
+	return fmt.Errorf("The nonce expired %v ago, request a new nonce", expired)
+}
+
+// DeviceNonceTTL returns the validity of the nonces for the model API key. The validity override
+// of the account of the models applies, otherwise the default validity of the service.
+// The named API keys are matched by their stored value, which may be encrypted
+func (db *DB) DeviceNonceTTL(ctx context.Context, apiKey string, defaultTTL time.Duration) (time.Duration, error) {
+	stored, err := encryptAPIKey(apiKey)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving the nonce validity: %v", err)
 	}
 
+	var ttl sql.NullInt64
+	err = db.QueryRowContext(ctx, getDeviceNonceTTLSQL, apiKey, stored, time.Now().UTC()).Scan(&ttl)
+	if err != nil {
+		return 0, fmt.Errorf("error retrieving the nonce validity: %v", err)
+	}
+	if !ttl.Valid {
+		return defaultTTL, nil
+	}
+	return time.Duration(ttl.Int64) * time.Second, nil
+}
+
+func (db *DB) getAccountNonceTTL(ctx context.Context, accountID int) (AccountNonceTTL, error) {
+	ttl := AccountNonceTTL{}
+	err := db.QueryRowContext(ctx, getAccountNonceTTLSQL, accountID).Scan(&ttl.AccountID, &ttl.Override, &ttl.Seconds)
+	if err != nil {
+		return ttl, fmt.Errorf("error retrieving the account nonce validity: %v", err)
+	}
+	return ttl, nil
+}
+
+func (db *DB) updateAccountNonceTTL(ctx context.Context, ttl AccountNonceTTL) error {
+	var err error
+	if ttl.Override {
+		_, err = db.ExecContext(ctx, upsertAccountNonceTTLSQL, ttl.AccountID, ttl.Seconds)
+	} else {
+		_, err = db.ExecContext(ctx, deleteAccountNonceTTLSQL, ttl.AccountID)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating the account nonce validity: %v", err)
+	}
 	return nil
 }
 
 func generateNonce() (DeviceNonce, error) {
 	token, err := random.GenerateRandomString(64)
 	if err != nil {
-		log.Printf("Could not generate random string for nonce")
+		log.Errorf("Could not generate random string for nonce")
 		return DeviceNonce{}, errors.New("Error generating nonce")
 	}
 
-	h := sha1.New()
+	h := sha256.New()
 	timestamp := time.Now().Unix()
 	io.WriteString(h, token)
 	io.WriteString(h, strconv.FormatInt(timestamp, 10))
@@ -19,7 +19,12 @@
 
 package datastore
 
-import "testing"
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
 
 func TestNonceGeneration(t *testing.T) {
 	// Generate some nonces
@@ -45,3 +50,98 @@ func TestNonceGeneration(t *testing.T) {
 		}
 	}
 }
+
+func TestNonceTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     string
+		purge   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"default", "", "", 10 * time.Minute, false},
+		{"configured", "2m", "1h", 2 * time.Minute, false},
+		{"invalid", "ten minutes", "", 0, true},
+		{"too-short", "500ms", "", 0, true},
+		{"too-long", "25h", "", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NonceTTL(config.Settings{NonceTTL: tt.ttl})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NonceTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NonceTTL() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNoncePurgeInterval(t *testing.T) {
+	tests := []struct {
+		name     string
+		interval string
+		want     time.Duration
+		wantErr  bool
+	}{
+		{"default", "", 10 * time.Minute, false},
+		{"configured", "1h", time.Hour, false},
+		{"invalid", "hourly", 0, true},
+		{"negative", "-1m", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NoncePurgeInterval(config.Settings{NoncePurgeInterval: tt.interval})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NoncePurgeInterval() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("NoncePurgeInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNonceExpiredError(t *testing.T) {
+	tests := []struct {
+		name    string
+		expires int64
+		now     int64
+		want    string
+	}{
+		{"expired", 1000, 1150, "The nonce expired 2m30s ago, request a new nonce"},
+		{"just-expired", 1000, 1000, "The nonce has expired, request a new nonce"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := nonceExpiredError(tt.expires, tt.now).Error(); got != tt.want {
+				t.Errorf("nonceExpiredError() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAccountNonceTTL(t *testing.T) {
+	tests := []struct {
+		name    string
+		ttl     AccountNonceTTL
+		wantErr bool
+	}{
+		{"override", AccountNonceTTL{Override: true, Seconds: 300}, false},
+		{"no-override", AccountNonceTTL{}, false},
+		{"zero", AccountNonceTTL{Override: true}, true},
+		{"too-long", AccountNonceTTL{Override: true, Seconds: 86401}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAccountNonceTTL(tt.ttl); (err != nil) != tt.wantErr {
+				t.Errorf("validateAccountNonceTTL() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
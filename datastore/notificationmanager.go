@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+)
+
+// The notifications that have been sent, so an event is only notified once for its reference,
+// e.g. the expiry of a signing-key
+const createNotificationTableSQL = `
+	CREATE TABLE IF NOT EXISTS notification (
+		id               serial primary key not null,
+		event            varchar(200) not null,
+		reference        varchar(500) not null,
+		created          timestamp default current_timestamp,
+		unique (event, reference)
+	)
+`
+
+const createNotificationSQL = "INSERT INTO notification (event, reference) VALUES ($1,$2) ON CONFLICT DO NOTHING"
+
+// CreateNotificationTable creates the database table for the sent notifications
+func (db *DB) CreateNotificationTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createNotificationTableSQL)
+	return err
+}
+
+// RecordNotification records that the event is notified for the reference. False is returned
+// when the event has already been notified for the reference
+func (db *DB) RecordNotification(ctx context.Context, event, reference string) (bool, error) {
+	result, err := db.ExecContext(ctx, createNotificationSQL, event, reference)
+	if err != nil {
+		return false, fmt.Errorf("error recording the notification: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error recording the notification: %v", err)
+	}
+	return rows > 0, nil
+}
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -58,32 +59,32 @@ type OpenidNonce struct {
 }
 
 // CreateOpenidNonceTable creates the database table for nonces with its indexes.
-func (db *DB) CreateOpenidNonceTable() error {
+func (db *DB) CreateOpenidNonceTable(ctx context.Context) error {
 	// Create the table
-	_, err := db.Exec(createOpenidNonceTableSQL)
+	_, err := db.ExecContext(ctx, createOpenidNonceTableSQL)
 	if err != nil {
 		return err
 	}
 
 	// Create the index
-	_, err = db.Exec(createOpenidNonceIndexSQL)
+	_, err = db.ExecContext(ctx, createOpenidNonceIndexSQL)
 	return err
 }
 
 // CreateOpenidNonce stores a new nonce entry
-func (db *DB) CreateOpenidNonce(nonce OpenidNonce) error {
+func (db *DB) CreateOpenidNonce(ctx context.Context, nonce OpenidNonce) error {
 
 	// Delete the expired nonces
-	err := db.deleteExpiredOpenidNonces()
+	err := db.deleteExpiredOpenidNonces(ctx)
 	if err != nil {
-		log.Printf("Error checking expired openid nonces: %v\n", err)
+		log.Errorf("Error checking expired openid nonces: %v", err)
 		return err
 	}
 
 	// Create the nonce in the database
-	_, err = db.Exec(createOpenidNonceSQL, nonce.Nonce, nonce.Endpoint, nonce.TimeStamp)
+	_, err = db.ExecContext(ctx, createOpenidNonceSQL, nonce.Nonce, nonce.Endpoint, nonce.TimeStamp)
 	if err != nil {
-		log.Printf("Error creating the openid nonce: %v\n", err)
+		log.Errorf("Error creating the openid nonce: %v", err)
 		return err
 	}
 
@@ -91,12 +92,12 @@ func (db *DB) CreateOpenidNonce(nonce OpenidNonce) error {
 }
 
 // deleteExpiredOpenidNonces removes nonces with timestamp older than max allowed lifetime
-func (db *DB) deleteExpiredOpenidNonces() error {
+func (db *DB) deleteExpiredOpenidNonces(ctx context.Context) error {
 	// Remove expired nonces from the table
 	timestamp := time.Now().Unix() - maxNonceAgeInSeconds
-	_, err := db.Exec(deleteExpiredOpenidNonceSQL, timestamp)
+	_, err := db.ExecContext(ctx, deleteExpiredOpenidNonceSQL, timestamp)
 	if err != nil {
-		log.Printf("Error deleting expired openid nonces: %v\n", err)
+		log.Errorf("Error deleting expired openid nonces: %v", err)
 		return errors.New("Error communicating with the database")
 	}
 
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"time"
 
 	"gopkg.in/errgo.v1"
@@ -32,12 +33,12 @@ type PgNonceStore struct {
 
 // Accept implements openid.NonceStore.Accept
 func (s *PgNonceStore) Accept(endpoint, nonce string) error {
-	return s.accept(endpoint, nonce, time.Now())
+	return s.accept(context.Background(), endpoint, nonce, time.Now())
 }
 
 // accept is the implementation of Accept. The third parameter is the
 // current time, useful for testing.
-func (s *PgNonceStore) accept(endpoint, nonce string, now time.Time) error {
+func (s *PgNonceStore) accept(ctx context.Context, endpoint, nonce string, now time.Time) error {
 	// From the openid specification:
 	//
 	// openid.response_nonce
@@ -72,6 +73,6 @@ func (s *PgNonceStore) accept(endpoint, nonce string, now time.Time) error {
 	}
 
 	openidNonce := OpenidNonce{Nonce: nonce, Endpoint: endpoint, TimeStamp: t.Unix()}
-	err = s.DB.CreateOpenidNonce(openidNonce)
+	err = s.DB.CreateOpenidNonce(ctx, openidNonce)
 	return errgo.Mask(err)
 }
@@ -0,0 +1,130 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// OpenPGPCardCommand is an interface for wrapping the GnuPG shell commands used to access an OpenPGP card
+type OpenPGPCardCommand interface {
+	runCommand(input []byte, args ...string) ([]byte, error)
+}
+
+type gpgCardCommand struct{}
+
+// runCommand runs gpg using the same home directory as the snapd GPG keypair manager,
+// so that the keys that are imported can be used for signing
+func (gcmd *gpgCardCommand) runCommand(input []byte, args ...string) ([]byte, error) {
+	homedir := os.Getenv("SNAP_GNUPG_HOME")
+	if homedir == "" {
+		homedir = filepath.Join(os.Getenv("HOME"), ".snap", "gnupg")
+	}
+	if err := os.MkdirAll(homedir, 0700); err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command("gpg", append([]string{"--homedir", homedir, "-q", "--batch"}, args...)...)
+	if len(input) > 0 {
+		cmd.Stdin = bytes.NewReader(input)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		log.Errorf("Error in GPG %v, %v", args, err)
+		return nil, err
+	}
+	return out, nil
+}
+
+// OpenPGPCardKeypairOperator handles the signing-keys that are held on an OpenPGP
+// card (e.g. a YubiKey). Only a reference to the key is held in the database, and
+// the signatures are generated by the card via the GnuPG agent
+type OpenPGPCardKeypairOperator struct {
+	cardCommand OpenPGPCardCommand
+	db          *asserts.Database
+	dbErr       error
+	dbOnce      sync.Once
+}
+
+var openPGPCard = &OpenPGPCardKeypairOperator{cardCommand: &gpgCardCommand{}}
+
+// IsOpenPGPCardKey checks if the sealed key of a keypair references a key on an OpenPGP card
+func IsOpenPGPCardKey(sealedKey string) bool {
+	return sealedKey == OpenPGPCardStore.Name
+}
+
+// ImportOpenPGPCardKey adds the reference to a signing-key on an OpenPGP card.
+// The public key is imported into the GnuPG keyring and the card status is
+// refreshed, so that the keyring links the private key to the card.
+// Returns the public key and the sealed key reference to store for the keypair
+func ImportOpenPGPCardKey(base64PublicKey string) (asserts.PublicKey, string, error) {
+	return openPGPCard.ImportPublicKey(base64PublicKey)
+}
+
+// ImportPublicKey adds the public key of the signing-key on the card to the keyring
+func (card *OpenPGPCardKeypairOperator) ImportPublicKey(base64PublicKey string) (asserts.PublicKey, string, error) {
+	publicKey, _, err := crypt.DeserializePublicKey(base64PublicKey)
+	if err != nil {
+		return nil, "", err
+	}
+	if err := checkFIPSKey(base64PublicKey); err != nil {
+		return nil, "", err
+	}
+
+	// The key has been validated, so decoding will succeed
+	decodedPublicKey, _ := base64.StdEncoding.DecodeString(base64PublicKey)
+
+	if _, err = card.cardCommand.runCommand(decodedPublicKey, "--import"); err != nil {
+		return nil, "", err
+	}
+
+	// Fetching the card status creates the private key stub that links the key to the card
+	if _, err = card.cardCommand.runCommand(nil, "--card-status"); err != nil {
+		return nil, "", err
+	}
+
+	return publicKey, OpenPGPCardStore.Name, nil
+}
+
+// Sign signs an assertion using the signing-key on the card
+func (card *OpenPGPCardKeypairOperator) Sign(assertType *asserts.AssertionType, headers map[string]interface{}, body []byte, keyID string) (asserts.Assertion, error) {
+	// The GnuPG keyring is only opened when the first card signature is needed
+	card.dbOnce.Do(func() {
+		card.db, card.dbErr = asserts.OpenDatabase(&asserts.DatabaseConfig{
+			KeypairManager: asserts.NewGPGKeypairManager(),
+		})
+	})
+	if card.dbErr != nil {
+		return nil, card.dbErr
+	}
+
+	return card.db.Sign(assertType, headers, body, keyID)
+}
@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+)
+
+type testCardCommand struct {
+	commands []string
+	fail     bool
+}
+
+func (ccmd *testCardCommand) runCommand(input []byte, args ...string) ([]byte, error) {
+	if ccmd.fail {
+		return nil, errors.New("MOCK error accessing the OpenPGP card")
+	}
+	ccmd.commands = append(ccmd.commands, args[len(args)-1])
+	return nil, nil
+}
+
+func readTestPublicKey(t *testing.T) string {
+	publicKey, err := ioutil.ReadFile("../keystore/TestKey.pub.asc")
+	if err != nil {
+		t.Fatalf("Error reading the public-key file: %v", err)
+	}
+	return base64.StdEncoding.EncodeToString(publicKey)
+}
+
+func TestOpenPGPCardImportPublicKey(t *testing.T) {
+	command := &testCardCommand{}
+	card := OpenPGPCardKeypairOperator{cardCommand: command}
+
+	publicKey, sealedKey, err := card.ImportPublicKey(readTestPublicKey(t))
+	if err != nil {
+		t.Fatalf("Error importing the public key: %v", err)
+	}
+	if !IsOpenPGPCardKey(sealedKey) {
+		t.Errorf("Expected an OpenPGP card reference, got: %s", sealedKey)
+	}
+
+	signingKey, _ := ioutil.ReadFile("../keystore/TestKey.asc")
+	privateKey, _, _ := crypt.DeserializePrivateKey(base64.StdEncoding.EncodeToString(signingKey))
+	if publicKey.ID() != privateKey.PublicKey().ID() {
+		t.Errorf("Expected key ID %s, got %s", privateKey.PublicKey().ID(), publicKey.ID())
+	}
+
+	if len(command.commands) != 2 || command.commands[0] != "--import" || command.commands[1] != "--card-status" {
+		t.Errorf("Unexpected GPG commands: %v", command.commands)
+	}
+}
+
+func TestOpenPGPCardImportPublicKeyErrors(t *testing.T) {
+	card := OpenPGPCardKeypairOperator{cardCommand: &testCardCommand{}}
+	_, _, err := card.ImportPublicKey("invalid")
+	if err == nil {
+		t.Error("Expected error with an invalid public key, got success")
+	}
+
+	card = OpenPGPCardKeypairOperator{cardCommand: &testCardCommand{fail: true}}
+	_, _, err = card.ImportPublicKey(readTestPublicKey(t))
+	if err == nil {
+		t.Error("Expected error with a card failure, got success")
+	}
+}
+
+func TestOpenPGPCardLoadKeypair(t *testing.T) {
+	config := config.Settings{KeyStoreType: "database", KeyStoreSecret: "secret code to encrypt the auth-key hash"}
+	Environ = &Env{Config: config, DB: &MockDB{}}
+
+	kdb, err := getKeyStore(config)
+	if err != nil {
+		t.Fatalf("Error opening the keystore: %v", err)
+	}
+
+	// The card reference is not a sealed key, so it is not unsealed
+	err = kdb.LoadKeypair("system", "key-id", OpenPGPCardStore.Name)
+	if err != nil {
+		t.Errorf("Error loading an OpenPGP card keypair: %v", err)
+	}
+}
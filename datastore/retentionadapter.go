@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+)
+
+// GetAllowedAccountRetention returns the signing log retention of the account, if the user is authorized to see it
+func (db *DB) GetAllowedAccountRetention(ctx context.Context, accountID int, authorization User) (AccountRetention, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		if err != nil || acc.ID == 0 {
+			return AccountRetention{}, errors.New("You do not have permissions to this account")
+		}
+		return db.getAccountRetention(ctx, accountID)
+	default:
+		return AccountRetention{}, errors.New("You do not have permissions to this account")
+	}
+}
+
+// UpdateAllowedAccountRetention validates and updates the retention override of the account,
+// if the user is authorized to do it. Only the superuser sets the retention of the accounts
+func (db *DB) UpdateAllowedAccountRetention(ctx context.Context, retention AccountRetention, authorization User) error {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		if err := validateAccountRetention(retention); err != nil {
+			return err
+		}
+		return db.updateAccountRetention(ctx, retention)
+	default:
+		return errors.New("You do not have permissions to update the account retention")
+	}
+}
+
+// ListAllowedSigningLogPurges returns the audit records of the signing log purges, if the user is authorized to see them
+func (db *DB) ListAllowedSigningLogPurges(ctx context.Context, authorization User) ([]SigningLogPurge, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.listSigningLogPurges(ctx)
+	default:
+		return nil, errors.New("You do not have permissions to view the signing log purges")
+	}
+}
+
+func validateAccountRetention(retention AccountRetention) error {
+	if retention.Months < 0 {
+		return errors.New("The retention must not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,289 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The retention override of an account, in months. Zero keeps the signing logs forever
+const createAccountRetentionTableSQL = `
+	CREATE TABLE IF NOT EXISTS accountretention (
+		id               serial primary key not null,
+		account_id       int references account not null unique,
+		months           int not null default 0
+	)
+`
+
+// The audit record of the signing logs that were purged
+const createSigningLogPurgeTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglogpurge (
+		id               serial primary key not null,
+		authority_id     varchar(200) not null,
+		months           int not null,
+		purge_before     timestamp not null,
+		purged           int not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+// The compact index of the purged signing logs. The purged serial numbers and device-keys are still
+// detected as duplicates, with their revisions, and the purged devices stay in the count of the quota
+const createSigningSerialTableSQL = `
+	CREATE TABLE IF NOT EXISTS signingserial (
+		id               serial primary key not null,
+		make             varchar(200) not null,
+		model            varchar(200) not null,
+		serial_number    varchar(200) not null,
+		fingerprint      varchar(200) not null,
+		revision         int default 1
+	)
+`
+
+const createSigningSerialIndexSQL = "CREATE INDEX IF NOT EXISTS signingserial_serial_idx ON signingserial (make, model, serial_number)"
+const createSigningSerialFingerprintIndexSQL = "CREATE INDEX IF NOT EXISTS signingserial_fingerprint_idx ON signingserial (fingerprint)"
+
+const getAccountRetentionSQL = `
+	SELECT a.id, r.months IS NOT NULL, COALESCE(r.months, 0)
+	FROM account a
+	LEFT JOIN accountretention r ON r.account_id=a.id
+	WHERE a.id=$1
+`
+
+const upsertAccountRetentionSQL = `
+	WITH upsert AS (
+		update accountretention set months=$2
+		where account_id=$1
+		RETURNING *
+	)
+	insert into accountretention (account_id,months)
+	select $1, $2
+	where not exists (select * from upsert)
+`
+
+// sqlite3 syntax of the upsert, inserting the retention when it is not updated
+const upsertAccountRetentionSQLite = `
+	UPDATE accountretention SET months=$2 WHERE account_id=$1;
+	INSERT INTO accountretention (account_id,months) SELECT $1, $2 WHERE changes()=0
+`
+
+const deleteAccountRetentionSQL = "DELETE FROM accountretention WHERE account_id=$1"
+
+// The brands that have signing logs, with the retention override of the account (-1 when not set)
+const listSigningLogRetentionSQL = `
+	SELECT b.make, COALESCE(r.months, -1)
+	FROM (SELECT DISTINCT make FROM signinglog) b
+	LEFT JOIN account a ON a.authority_id=b.make
+	LEFT JOIN accountretention r ON r.account_id=a.id
+`
+
+const indexSigningLogSQL = `
+	INSERT INTO signingserial (make, model, serial_number, fingerprint, revision)
+	SELECT make, model, serial_number, fingerprint, revision FROM signinglog WHERE make=$1 AND created<$2
+`
+
+const purgeSigningLogSQL = "DELETE FROM signinglog WHERE make=$1 AND created<$2"
+
+// signingLogPurgeLock is the key of the advisory lock that serializes the purges, so the purged
+// signing logs are indexed once. It is not the lock of the signing log jobs, that the purge job holds
+var signingLogPurgeLock = jobLockKey("signinglog-purge")
+
+const createSigningLogPurgeSQL = `
+	INSERT INTO signinglogpurge (authority_id, months, purge_before, purged, created)
+	VALUES ($1,$2,$3,$4,$5)
+	RETURNING id
+`
+
+const listSigningLogPurgeSQL = `
+	SELECT id, authority_id, months, purge_before, purged, created
+	FROM signinglogpurge
+	ORDER BY id DESC
+`
+
+// AccountRetention holds the retention of the signing logs of an account, in months.
+// Without an override, the default retention of the service is used
+type AccountRetention struct {
+	AccountID int  `json:"accountID"`
+	Override  bool `json:"override"`
+	Months    int  `json:"months"`
+	Default   int  `json:"default"`
+}
+
+// SigningLogPurge is the audit record of a purge of the signing logs of a brand
+type SigningLogPurge struct {
+	ID          int       `json:"id"`
+	AuthorityID string    `json:"authorityID"`
+	Months      int       `json:"months"`
+	Before      time.Time `json:"before"`
+	Purged      int       `json:"purged"`
+	Created     time.Time `json:"created"`
+}
+
+// CreateAccountRetentionTable creates the database table for the retention overrides of the accounts
+func (db *DB) CreateAccountRetentionTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createAccountRetentionTableSQL)
+	return err
+}
+
+// CreateSigningLogPurgeTable creates the database tables for the audit of the signing log purges
+// and for the index of the purged serial numbers
+func (db *DB) CreateSigningLogPurgeTable(ctx context.Context) error {
+	for _, query := range []string{createSigningLogPurgeTableSQL, createSigningSerialTableSQL, createSigningSerialIndexSQL, createSigningSerialFingerprintIndexSQL} {
+		if _, err := db.ExecContext(ctx, query); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PurgeSigningLog deletes the signing logs that are older than the retention of the brand,
+// recording an audit record for each brand that had logs purged. The default retention
+// applies to the brands without an override, and a zero retention keeps the logs forever
+func (db *DB) PurgeSigningLog(ctx context.Context, defaultMonths int, now time.Time) ([]SigningLogPurge, error) {
+	retention, err := db.listSigningLogRetention(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	purges := []SigningLogPurge{}
+	for authorityID, months := range retention {
+		if months < 0 {
+			months = defaultMonths
+		}
+		if months == 0 {
+			continue
+		}
+
+		purge := SigningLogPurge{AuthorityID: authorityID, Months: months, Before: now.AddDate(0, -months, 0), Created: now}
+		err := db.transaction(ctx, func(tx *sql.Tx) error {
+			return purgeSigningLogForBrand(ctx, tx, &purge)
+		})
+		if err != nil {
+			return purges, fmt.Errorf("error purging the signing logs of '%s': %v", authorityID, err)
+		}
+		if purge.Purged > 0 {
+			log.Infof("Purged %d signing logs of '%s' before %s", purge.Purged, authorityID, purge.Before.Format(time.RFC3339))
+			purges = append(purges, purge)
+		}
+	}
+
+	return purges, nil
+}
+
+func (db *DB) listSigningLogRetention(ctx context.Context) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, listSigningLogRetentionSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the signing log retention: %v", err)
+	}
+	defer rows.Close()
+
+	retention := map[string]int{}
+	for rows.Next() {
+		var authorityID string
+		var months int
+		if err := rows.Scan(&authorityID, &months); err != nil {
+			return nil, fmt.Errorf("error retrieving the signing log retention: %v", err)
+		}
+		retention[authorityID] = months
+	}
+
+	return retention, rows.Err()
+}
+
+// purgeSigningLogForBrand deletes the signing logs of the brand and records the audit in the same transaction.
+// The serial numbers of the deleted logs are kept in the index of the purged signing logs
+func purgeSigningLogForBrand(ctx context.Context, tx *sql.Tx, purge *SigningLogPurge) error {
+	// CockroachDB has no advisory locks, and retries the transaction of a concurrent purge instead
+	if !InFactory() && !InCockroach() {
+		if _, err := tx.ExecContext(ctx, lockAuditChainSQL, signingLogPurgeLock); err != nil {
+			return err
+		}
+	}
+
+	if _, err := tx.ExecContext(ctx, indexSigningLogSQL, purge.AuthorityID, purge.Before); err != nil {
+		return err
+	}
+	if err := releaseSigningLog(ctx, tx, AuditReleaseRetention, purge.Created, "make=$3 AND created<$4", purge.AuthorityID, purge.Before); err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, purgeSigningLogSQL, purge.AuthorityID, purge.Before)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	purge.Purged = int(rows)
+	if purge.Purged == 0 {
+		return nil
+	}
+
+	return tx.QueryRowContext(ctx, createSigningLogPurgeSQL, purge.AuthorityID, purge.Months, purge.Before, purge.Purged, purge.Created).Scan(&purge.ID)
+}
+
+func (db *DB) listSigningLogPurges(ctx context.Context) ([]SigningLogPurge, error) {
+	rows, err := db.QueryContext(ctx, listSigningLogPurgeSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the signing log purges: %v", err)
+	}
+	defer rows.Close()
+
+	purges := []SigningLogPurge{}
+	for rows.Next() {
+		p := SigningLogPurge{}
+		if err := rows.Scan(&p.ID, &p.AuthorityID, &p.Months, &p.Before, &p.Purged, &p.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the signing log purges: %v", err)
+		}
+		purges = append(purges, p)
+	}
+
+	return purges, rows.Err()
+}
+
+func (db *DB) getAccountRetention(ctx context.Context, accountID int) (AccountRetention, error) {
+	retention := AccountRetention{}
+	err := db.QueryRowContext(ctx, getAccountRetentionSQL, accountID).Scan(&retention.AccountID, &retention.Override, &retention.Months)
+	if err != nil {
+		return retention, fmt.Errorf("error retrieving the account retention: %v", err)
+	}
+	return retention, nil
+}
+
+func (db *DB) updateAccountRetention(ctx context.Context, retention AccountRetention) error {
+	var err error
+	if retention.Override {
+		_, err = db.ExecContext(ctx, upsertAccountRetentionSQL, retention.AccountID, retention.Months)
+	} else {
+		_, err = db.ExecContext(ctx, deleteAccountRetentionSQL, retention.AccountID)
+	}
+	if err != nil {
+		return fmt.Errorf("error updating the account retention: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+)
+
+func TestSQLitePurgeSigningLog(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	now := time.Now().UTC()
+	if err := db.CreateAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}); err != nil {
+		t.Fatalf("CreateAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	for _, serial := range []string{"A1", "A2"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial, Revision: 2}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+	if _, err := db.ExecContext(ctx, "UPDATE signinglog SET created=$1 WHERE serial_number='A1'", now.AddDate(-2, 0, 0)); err != nil {
+		t.Fatalf("ageing the signing log: %v", err)
+	}
+
+	purges, err := db.PurgeSigningLog(ctx, 12, now)
+	if err != nil || len(purges) != 1 || purges[0].Purged != 1 {
+		t.Fatalf("PurgeSigningLog() = %v, %v", purges, err)
+	}
+
+	// The purged serial number and device-key are still duplicates, with their revision
+	for _, l := range []SigningLog{
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-new"},
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A3", Fingerprint: "fp-A1"},
+	} {
+		duplicate, revision, err := db.CheckForDuplicate(ctx, &l)
+		if err != nil || !duplicate {
+			t.Errorf("CheckForDuplicate(%s) = %v, %v, want a duplicate", l.SerialNumber, duplicate, err)
+		}
+		if l.SerialNumber == "A1" && revision != 2 {
+			t.Errorf("CheckForDuplicate(%s) revision = %d, want 2", l.SerialNumber, revision)
+		}
+	}
+	if matching, err := db.CheckForMatching(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Revision: 2}); err != nil || !matching {
+		t.Errorf("CheckForMatching() = %v, %v, want the purged signing log", matching, err)
+	}
+
+	// The purged signing log stays in the count of the quota
+	quota, err := db.getAccountQuotaByID(ctx, account.ID)
+	if err != nil || quota.Used != 2 {
+		t.Errorf("getAccountQuotaByID() = %v, %v, want 2 used", quota, err)
+	}
+	if err := db.upsertAccountQuota(ctx, AccountQuota{AccountID: account.ID, Limit: 100}); err != nil {
+		t.Fatalf("upsertAccountQuota() error = %v", err)
+	}
+	if quota, err = db.getAccountQuotaByID(ctx, account.ID); err != nil || quota.Used != 2 {
+		t.Errorf("getAccountQuotaByID() = %v, %v, want 2 used", quota, err)
+	}
+
+	// The purge of the device data removes the serial number from the index
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		_, _, err := purgeSerial(ctx, tx, "A1", PurgeModeDelete)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("purgeSerial() error = %v", err)
+	}
+	if duplicate, _, err := db.CheckForDuplicate(ctx, &SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-new"}); err != nil || duplicate {
+		t.Errorf("CheckForDuplicate() = %v, %v, want the purged device data to be removed", duplicate, err)
+	}
+}
@@ -0,0 +1,140 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The denylist of the session tokens that were revoked before they expired. A row either
+// revokes a single token, by its ID, or all the tokens of the user that were issued before
+// the revocation, when the token ID is empty. The rows are only kept until the revoked
+// tokens would have expired anyway. The times are stored in UTC
+const createRevokedTokenTableSQL = `
+	CREATE TABLE IF NOT EXISTS revokedtoken (
+		id               serial primary key not null,
+		username         varchar(200) not null,
+		token_id         varchar(200) not null default '',
+		issued_before    timestamp,
+		expires          timestamp not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+const createRevokedTokenIndexSQL = "CREATE INDEX IF NOT EXISTS revokedtoken_username_idx ON revokedtoken (username)"
+
+// The refresh tokens that have been exchanged, as a refresh token is used once. The token ID
+// is the key, so only one of the concurrent exchanges of the token succeeds
+const createUsedRefreshTokenTableSQL = `
+	CREATE TABLE IF NOT EXISTS usedrefreshtoken (
+		token_id         varchar(200) primary key not null,
+		username         varchar(200) not null,
+		expires          timestamp not null,
+		created          timestamp default current_timestamp
+	)
+`
+
+const useRefreshTokenSQL = "INSERT INTO usedrefreshtoken (token_id, username, expires) VALUES ($1,$2,$3) ON CONFLICT (token_id) DO NOTHING"
+const deleteExpiredUsedRefreshTokensSQL = "DELETE FROM usedrefreshtoken WHERE expires<$1"
+
+const revokeTokenSQL = "INSERT INTO revokedtoken (username, token_id, expires) VALUES ($1,$2,$3)"
+const revokeUserTokensSQL = "INSERT INTO revokedtoken (username, issued_before, expires) VALUES ($1,$2,$3)"
+const deleteExpiredRevokedTokensSQL = "DELETE FROM revokedtoken WHERE expires<$1"
+
+const isTokenRevokedSQL = `
+	SELECT EXISTS(
+		SELECT 1 FROM revokedtoken
+		WHERE username=$1 AND expires>$4
+		AND ((token_id<>'' AND token_id=$2) OR (token_id='' AND issued_before>$3))
+	)`
+
+// CreateRevokedTokenTable creates the database tables for the denylist of the session tokens
+// and the refresh tokens that have been used
+func (db *DB) CreateRevokedTokenTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createRevokedTokenTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, createRevokedTokenIndexSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createUsedRefreshTokenTableSQL)
+	return err
+}
+
+// RevokeToken adds a session token of the user to the denylist, until it expires
+func (db *DB) RevokeToken(ctx context.Context, username, tokenID string, expires time.Time) error {
+	db.deleteExpiredRevokedTokens(ctx)
+
+	if _, err := db.ExecContext(ctx, revokeTokenSQL, username, tokenID, expires.UTC()); err != nil {
+		return fmt.Errorf("error revoking the token: %v", err)
+	}
+	return nil
+}
+
+// RevokeUserTokens revokes all the session tokens of the user that have been issued so far.
+// The expiry is the time when all those tokens will have expired
+func (db *DB) RevokeUserTokens(ctx context.Context, username string, expires time.Time) error {
+	db.deleteExpiredRevokedTokens(ctx)
+
+	// The tokens record the time they were issued in seconds
+	if _, err := db.ExecContext(ctx, revokeUserTokensSQL, username, time.Now().UTC().Truncate(time.Second), expires.UTC()); err != nil {
+		return fmt.Errorf("error revoking the sessions of the user: %v", err)
+	}
+	return nil
+}
+
+// UseRefreshToken records that the refresh token has been exchanged, until it expires. It returns
+// false when the token has been used before
+func (db *DB) UseRefreshToken(ctx context.Context, username, tokenID string, expires time.Time) (bool, error) {
+	if _, err := db.ExecContext(ctx, deleteExpiredUsedRefreshTokensSQL, time.Now().UTC()); err != nil {
+		log.Errorf("Error deleting the expired refresh tokens: %v", err)
+	}
+
+	result, err := db.ExecContext(ctx, useRefreshTokenSQL, tokenID, username, expires.UTC())
+	if err != nil {
+		return false, fmt.Errorf("error using the refresh token: %v", err)
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("error using the refresh token: %v", err)
+	}
+	return rows == 1, nil
+}
+
+// IsTokenRevoked checks whether the session token has been revoked, by its ID or by the
+// time it was issued. The token is treated as revoked when the denylist cannot be checked
+func (db *DB) IsTokenRevoked(ctx context.Context, username, tokenID string, issued time.Time) bool {
+	var revoked bool
+	if err := db.QueryRowContext(ctx, isTokenRevokedSQL, username, tokenID, issued.UTC(), time.Now().UTC()).Scan(&revoked); err != nil {
+		log.Errorf("Error checking the revoked tokens of user %v: %v", username, err)
+		return true
+	}
+	return revoked
+}
+
+func (db *DB) deleteExpiredRevokedTokens(ctx context.Context) {
+	if _, err := db.ExecContext(ctx, deleteExpiredRevokedTokensSQL, time.Now().UTC()); err != nil {
+		log.Errorf("Error deleting the expired revoked tokens: %v", err)
+	}
+}
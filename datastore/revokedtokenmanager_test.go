@@ -0,0 +1,48 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSQLiteUseRefreshToken(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	expires := time.Now().Add(time.Hour)
+
+	// The refresh token is used once
+	if unused, err := db.UseRefreshToken(ctx, "user1", "token1", expires); err != nil || !unused {
+		t.Fatalf("UseRefreshToken() = %v, %v", unused, err)
+	}
+	if unused, err := db.UseRefreshToken(ctx, "user1", "token1", expires); err != nil || unused {
+		t.Errorf("UseRefreshToken() of a used token = %v, %v", unused, err)
+	}
+	if unused, err := db.UseRefreshToken(ctx, "user1", "token2", expires); err != nil || !unused {
+		t.Errorf("UseRefreshToken() = %v, %v", unused, err)
+	}
+}
@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strconv"
+)
+
+// SettingSchemaVersion is the settings code that records the version of the database schema
+const SettingSchemaVersion = "schema-version"
+
+// SchemaVersion is the version of the database schema expected by this release.
+// It must be incremented when an operation is added to the database update
+const SchemaVersion = 36
+
+// SetSchemaVersion records that the database schema is at the expected version
+func SetSchemaVersion(ctx context.Context) error {
+	return Environ.DB.PutSetting(ctx, Setting{Code: SettingSchemaVersion, Data: strconv.Itoa(SchemaVersion)})
+}
+
+// CheckSchemaVersion returns an error when the database schema has migrations pending
+func CheckSchemaVersion(ctx context.Context) error {
+	setting, err := Environ.DB.GetSetting(ctx, SettingSchemaVersion)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("the database schema version is not recorded: run the database update")
+	}
+	if err != nil {
+		return err
+	}
+
+	version, err := strconv.Atoi(setting.Data)
+	if err != nil {
+		return fmt.Errorf("invalid database schema version '%s'", setting.Data)
+	}
+	if version < SchemaVersion {
+		return fmt.Errorf("the database schema is at version %d, expected %d: run the database update", version, SchemaVersion)
+	}
+	return nil
+}
@@ -0,0 +1,244 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// SerialHasher hashes the serial numbers that are stored in the signing logs of the
+// accounts that do not keep the serial numbers of their devices in clear
+type SerialHasher interface {
+	Hash(authorityID, serialNumber string) string
+	Hashed(value string) bool // the stored value is already a hash
+}
+
+// HMACSerialHasher is the default serial hasher, using an HMAC-SHA256 keyed with the
+// salt from the settings. The hash is specific to the brand, so it is not the same
+// for the same serial number across accounts
+type HMACSerialHasher struct {
+	Salt []byte
+}
+
+const hmacSerialHashPrefix = "hmac-sha256:"
+
+// Hash returns the hash of the serial number for the brand
+func (h HMACSerialHasher) Hash(authorityID, serialNumber string) string {
+	mac := hmac.New(sha256.New, h.Salt)
+	mac.Write([]byte(authorityID + "/" + serialNumber))
+	return hmacSerialHashPrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Hashed checks if the value is an HMAC hash of a serial number
+func (h HMACSerialHasher) Hashed(value string) bool {
+	return strings.HasPrefix(value, hmacSerialHashPrefix)
+}
+
+const listSigningLogSerialsSQL = "SELECT id, serial_number FROM signinglog WHERE make=$1"
+const updateSigningLogSerialSQL = "UPDATE signinglog SET serial_number=$2 WHERE id=$1"
+const listAccountAuthoritiesSQL = "SELECT authority_id FROM account"
+
+// The sub-store models keep the hash of the serial number for the brand of the account, so
+// they are joined with the signing logs whether the logs hold the serial number or its hash
+const getSubstoreSerialSQL = `
+	SELECT a.authority_id, s.serial_number FROM substore s
+	INNER JOIN account a ON a.id=s.account_id
+	WHERE s.id=$1`
+const listSubstoreSerialsSQL = `
+	SELECT s.id FROM substore s
+	INNER JOIN account a ON a.id=s.account_id
+	WHERE a.authority_id=$1`
+const updateSubstoreSerialHashSQL = "UPDATE substore SET serial_hash=$2 WHERE id=$1"
+
+// Hasher returns the serial hasher, defaulting to the HMAC hasher with the configured salt
+func (env *Env) Hasher() (SerialHasher, error) {
+	if env.SerialHasher != nil {
+		return env.SerialHasher, nil
+	}
+	if len(env.Config.SerialHashSalt) == 0 {
+		return nil, errors.New("the salt for hashing serial numbers is not configured")
+	}
+	return HMACSerialHasher{Salt: []byte(env.Config.SerialHashSalt)}, nil
+}
+
+// StoredSerialNumber returns the serial number as it is stored in the signing log for
+// the account, which is hashed when the account does not store the serial numbers
+func (env *Env) StoredSerialNumber(account Account, serialNumber string) (string, error) {
+	if !account.HashSerial {
+		return serialNumber, nil
+	}
+
+	hasher, err := env.Hasher()
+	if err != nil {
+		return "", err
+	}
+	return hasher.Hash(account.AuthorityID, serialNumber), nil
+}
+
+// LookupSerialNumbers returns the values that the signing logs of the account may hold for
+// the serial number. The stored value is first, followed by the value of the logs that were
+// signed before the hashing was enabled or disabled for the account
+func (env *Env) LookupSerialNumbers(account Account, serialNumber string) ([]string, error) {
+	stored, err := env.StoredSerialNumber(account, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+	if stored != serialNumber {
+		return []string{stored, serialNumber}, nil
+	}
+
+	hasher, err := env.Hasher()
+	if err != nil {
+		// Without a salt, the serial numbers of the account were never hashed
+		return []string{stored}, nil
+	}
+	return []string{stored, hasher.Hash(account.AuthorityID, serialNumber)}, nil
+}
+
+// HashSigningLogSerialNumbers replaces the serial numbers of the existing signing logs of
+// the account with their hashes. Logs that are already hashed are left unchanged, so the
+// migration can be run again
+func (db *DB) HashSigningLogSerialNumbers(ctx context.Context, authorityID string) (int, error) {
+	hasher, err := Environ.Hasher()
+	if err != nil {
+		return 0, err
+	}
+
+	count := 0
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, listSigningLogSerialsSQL, authorityID)
+		if err != nil {
+			return fmt.Errorf("error retrieving the signing log serial numbers: %v", err)
+		}
+
+		hashes := map[int]string{}
+		for rows.Next() {
+			var id int
+			var serialNumber string
+			if err = rows.Scan(&id, &serialNumber); err != nil {
+				rows.Close()
+				return fmt.Errorf("error retrieving the signing log serial numbers: %v", err)
+			}
+			if !hasher.Hashed(serialNumber) {
+				hashes[id] = hasher.Hash(authorityID, serialNumber)
+			}
+		}
+		rows.Close()
+
+		for id, hash := range hashes {
+			if _, err = tx.ExecContext(ctx, updateSigningLogSerialSQL, id, hash); err != nil {
+				return fmt.Errorf("error hashing the signing log serial number: %v", err)
+			}
+		}
+		count = len(hashes)
+
+		// The sub-store models that were created before the salt was configured have no hash
+		return hashAccountSubstoreSerials(ctx, tx, authorityID)
+	})
+	if err != nil {
+		log.Error(err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// queryFunc runs a query on the database or in a transaction
+type queryFunc func(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+
+// storedSerialNumbers returns the serial number as it may be stored in the signing logs: in clear,
+// and hashed for each account once the salt is configured. An account that stopped hashing the
+// serial numbers keeps the hashes of its earlier logs
+func storedSerialNumbers(ctx context.Context, query queryFunc, serialNumber string) ([]string, error) {
+	hasher, err := Environ.Hasher()
+	if err != nil {
+		// Without a salt, no serial number is hashed
+		return []string{serialNumber}, nil
+	}
+
+	rows, err := query(ctx, listAccountAuthoritiesSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{serialNumber}
+	for rows.Next() {
+		var authorityID string
+		if err := rows.Scan(&authorityID); err != nil {
+			return nil, err
+		}
+		values = append(values, hasher.Hash(authorityID, serialNumber))
+	}
+	return values, rows.Err()
+}
+
+// hashSubstoreSerial sets the hash of the serial number of the sub-store model. The hash
+// is left empty when the salt is not configured, as no serial number is hashed then
+func hashSubstoreSerial(ctx context.Context, tx *sql.Tx, storeID int) error {
+	hasher, err := Environ.Hasher()
+	if err != nil {
+		return nil
+	}
+
+	var authorityID, serialNumber string
+	if err := tx.QueryRowContext(ctx, getSubstoreSerialSQL, storeID).Scan(&authorityID, &serialNumber); err != nil {
+		return fmt.Errorf("error hashing the sub-store serial number: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, updateSubstoreSerialHashSQL, storeID, hasher.Hash(authorityID, serialNumber)); err != nil {
+		return fmt.Errorf("error hashing the sub-store serial number: %v", err)
+	}
+	return nil
+}
+
+// hashAccountSubstoreSerials sets the hash of the serial numbers of the sub-store models of the account
+func hashAccountSubstoreSerials(ctx context.Context, tx *sql.Tx, authorityID string) error {
+	rows, err := tx.QueryContext(ctx, listSubstoreSerialsSQL, authorityID)
+	if err != nil {
+		return fmt.Errorf("error retrieving the sub-store serial numbers: %v", err)
+	}
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err = rows.Scan(&id); err != nil {
+			rows.Close()
+			return fmt.Errorf("error retrieving the sub-store serial numbers: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if err := hashSubstoreSerial(ctx, tx, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestHMACSerialHasher(t *testing.T) {
+	h := HMACSerialHasher{Salt: []byte("salt")}
+
+	hash := h.Hash("system", "A123456L")
+	if !h.Hashed(hash) || h.Hashed("A123456L") {
+		t.Errorf("Hashed() does not detect the hash %s", hash)
+	}
+	if hash != h.Hash("system", "A123456L") {
+		t.Error("Hash() must be repeatable for duplicate detection")
+	}
+	if hash == h.Hash("vendor", "A123456L") {
+		t.Error("Hash() must differ between accounts")
+	}
+	if hash == (HMACSerialHasher{Salt: []byte("other")}).Hash("system", "A123456L") {
+		t.Error("Hash() must depend on the salt")
+	}
+	if strings.Contains(hash, "A123456L") {
+		t.Error("Hash() must not contain the serial number")
+	}
+}
+
+func TestStoredSerialNumber(t *testing.T) {
+	tests := []struct {
+		name    string
+		salt    string
+		account Account
+		hashed  bool
+		wantErr bool
+	}{
+		{"clear", "", Account{AuthorityID: "system"}, false, false},
+		{"hashed", "salt", Account{AuthorityID: "system", HashSerial: true}, true, false},
+		{"no salt", "", Account{AuthorityID: "system", HashSerial: true}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Env{Config: config.Settings{SerialHashSalt: tt.salt}}
+			got, err := env.StoredSerialNumber(tt.account, "A123456L")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("StoredSerialNumber() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && (got != "A123456L") != tt.hashed {
+				t.Errorf("StoredSerialNumber() = %v, hashed %v", got, tt.hashed)
+			}
+		})
+	}
+}
+
+func TestLookupSerialNumbers(t *testing.T) {
+	h := HMACSerialHasher{Salt: []byte("salt")}
+	hash := h.Hash("system", "A123456L")
+
+	tests := []struct {
+		name    string
+		salt    string
+		account Account
+		want    []string
+	}{
+		{"clear", "", Account{AuthorityID: "system"}, []string{"A123456L"}},
+		{"clear previously hashed", "salt", Account{AuthorityID: "system"}, []string{"A123456L", hash}},
+		{"hashed", "salt", Account{AuthorityID: "system", HashSerial: true}, []string{hash, "A123456L"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := Env{Config: config.Settings{SerialHashSalt: tt.salt}}
+			got, err := env.LookupSerialNumbers(tt.account, "A123456L")
+			if err != nil {
+				t.Fatalf("LookupSerialNumbers() error = %v", err)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("LookupSerialNumbers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSQLiteHashedSerialDevices(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+	Environ.Config.SerialHashSalt = "salt"
+	hash := HMACSerialHasher{Salt: []byte("salt")}.Hash("alder", "A1")
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion", HashSerial: true}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	if _, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A1", ModelName: "alder-mybrand"}, root); err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	// The device was signed before and after the hashing was enabled
+	for i, serial := range []string{"A1", hash} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-A1", Revision: i + 1}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	devices, err := db.ListAllowedDevices(ctx, "A1", root)
+	if err != nil || len(devices) != 1 {
+		t.Fatalf("ListAllowedDevices() = %v, %v", devices, err)
+	}
+	if d := devices[0]; d.Revision != 2 || d.SerialNumber != "A1" || d.PivotStatus != PivotAvailable || d.Substore != "mybrand" {
+		t.Errorf("ListAllowedDevices() = %+v", d)
+	}
+
+	// The migration hashes the clear log, which is still joined with the sub-store model
+	if count, err := db.HashSigningLogSerialNumbers(ctx, "alder"); err != nil || count != 1 {
+		t.Fatalf("HashSigningLogSerialNumbers() = %d, %v", count, err)
+	}
+	devices, err = db.ListAllowedDevices(ctx, "A1", root)
+	if err != nil || len(devices) != 1 || devices[0].PivotStatus != PivotAvailable {
+		t.Errorf("ListAllowedDevices() = %v, %v", devices, err)
+	}
+}
@@ -0,0 +1,111 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+)
+
+// Lengths of the random client ID and secret of the service accounts
+const (
+	serviceAccountClientIDLength = 12
+	serviceAccountSecretLength   = 32
+)
+
+// CreateServiceAccount validates and creates a service account for the user, generating the
+// client ID and secret. The secret is only returned here, as only its hash is stored
+func (db *DB) CreateServiceAccount(ctx context.Context, sa ServiceAccount) (ServiceAccount, error) {
+	user, err := db.GetUserByUsername(ctx, sa.Username)
+	if err != nil {
+		return sa, fmt.Errorf("Cannot find the user '%s' of the service account", sa.Username)
+	}
+	sa.UserID = user.ID
+
+	if err := validateServiceAccount(sa); err != nil {
+		return sa, err
+	}
+
+	clientID, err := random.GenerateRandomString(serviceAccountClientIDLength)
+	if err != nil {
+		return sa, errors.New("Error in generating the client ID")
+	}
+	sa.ClientID = "sv-" + clientID
+
+	if err = sa.newSecret(); err != nil {
+		return sa, err
+	}
+
+	sa.ID, err = db.createServiceAccount(ctx, sa)
+	return sa, err
+}
+
+// UpdateServiceAccount validates and updates the name, scopes and status of a service account.
+// The user and the credentials are not changed
+func (db *DB) UpdateServiceAccount(ctx context.Context, sa ServiceAccount) error {
+	if err := validateServiceAccount(sa); err != nil {
+		return err
+	}
+	return db.updateServiceAccount(ctx, sa)
+}
+
+// ResetServiceAccountSecret generates a new secret for a service account. The previous
+// secret is no longer accepted, but the tokens that were issued with it stay valid until
+// they expire
+func (db *DB) ResetServiceAccountSecret(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	sa, err := db.GetServiceAccount(ctx, serviceAccountID)
+	if err != nil {
+		return sa, err
+	}
+
+	if err = sa.newSecret(); err != nil {
+		return sa, err
+	}
+	return sa, db.updateServiceAccountSecret(ctx, sa.ID, sa.SecretHash)
+}
+
+func (sa *ServiceAccount) newSecret() error {
+	secret, err := random.GenerateRandomString(serviceAccountSecretLength)
+	if err != nil {
+		return errors.New("Error in generating the client secret")
+	}
+	sa.Secret = secret
+	sa.SecretHash = hashServiceAccountSecret(secret)
+	return nil
+}
+
+func validateServiceAccount(sa ServiceAccount) error {
+	if err := validateNotEmpty("Name", sa.Name); err != nil {
+		return err
+	}
+	if len(sa.Scopes) == 0 {
+		return errors.New("The service account must have at least one scope")
+	}
+	for _, s := range sa.Scopes {
+		if !IsValidAdminScope(s) {
+			return fmt.Errorf("Invalid scope '%s' for the service account", s)
+		}
+	}
+	return nil
+}
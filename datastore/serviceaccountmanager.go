@@ -0,0 +1,232 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The client credentials of the service accounts. A service account is a machine identity
+// for a user of the vault, e.g. a CI pipeline, which exchanges its credentials for a scoped
+// JWT of the admin API. Only the hash of the secret is stored
+const createServiceAccountTableSQL = `
+	CREATE TABLE IF NOT EXISTS serviceaccount (
+		id               serial primary key not null,
+		user_id          int references userinfo on delete cascade not null,
+		name             varchar(200) not null,
+		client_id        varchar(200) not null unique,
+		secret_hash      varchar(200) not null,
+		scopes           varchar(200) not null default '',
+		active           bool not null default true,
+		created          timestamp default current_timestamp
+	)
+`
+
+const listServiceAccountsSQL = `
+	SELECT s.id, s.user_id, u.username, s.name, s.client_id, s.secret_hash, s.scopes, s.active, s.created
+	FROM serviceaccount s
+	INNER JOIN userinfo u ON u.id=s.user_id
+	ORDER BY s.name`
+
+const getServiceAccountSQL = `
+	SELECT s.id, s.user_id, u.username, s.name, s.client_id, s.secret_hash, s.scopes, s.active, s.created
+	FROM serviceaccount s
+	INNER JOIN userinfo u ON u.id=s.user_id
+	WHERE s.id=$1`
+
+const getServiceAccountByClientIDSQL = `
+	SELECT s.id, s.user_id, u.username, s.name, s.client_id, s.secret_hash, s.scopes, s.active, s.created
+	FROM serviceaccount s
+	INNER JOIN userinfo u ON u.id=s.user_id
+	WHERE s.client_id=$1 AND u.active`
+
+const createServiceAccountSQL = "INSERT INTO serviceaccount (user_id, name, client_id, secret_hash, scopes, active) VALUES ($1,$2,$3,$4,$5,$6) RETURNING id"
+const updateServiceAccountSQL = "UPDATE serviceaccount SET name=$2, scopes=$3, active=$4 WHERE id=$1"
+const updateServiceAccountSecretSQL = "UPDATE serviceaccount SET secret_hash=$2 WHERE id=$1"
+const deleteServiceAccountSQL = "DELETE FROM serviceaccount WHERE id=$1"
+
+// Scopes of the admin API, for the tokens of the service accounts. Each scope is the
+// resource of the /api routes, and the read-only scope only allows the GET methods
+const (
+	AdminScopeAccounts   = "accounts"
+	AdminScopeAssertions = "assertions"
+	AdminScopeKeypairs   = "keypairs"
+	AdminScopeModels     = "models"
+	AdminScopeSigningLog = "signinglog"
+	AdminScopeTestLog    = "testlog"
+
+	// AdminScopeSCIM is the scope of the /scim/v2 routes, to provision the users
+	AdminScopeSCIM = "scim"
+
+	// AdminScopeReadSuffix restricts a scope to the GET methods, e.g. models:read
+	AdminScopeReadSuffix = ":read"
+)
+
+var validAdminScopes = []string{AdminScopeAccounts, AdminScopeAssertions, AdminScopeKeypairs, AdminScopeModels, AdminScopeSigningLog, AdminScopeTestLog, AdminScopeSCIM}
+
+// ServiceAccount is the client credentials of a machine identity. The secret is only
+// returned when the service account is created or its secret is reset
+type ServiceAccount struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"userID"`
+	Username   string    `json:"username"`
+	Name       string    `json:"name"`
+	ClientID   string    `json:"clientID"`
+	Secret     string    `json:"secret,omitempty"`
+	SecretHash string    `json:"-"`
+	Scopes     []string  `json:"scopes"`
+	Active     bool      `json:"active"`
+	Created    time.Time `json:"created"`
+}
+
+// AdminScopeAllows checks whether the scopes allow the method on the resource of the admin API
+func AdminScopeAllows(scopes []string, resource, method string) bool {
+	for _, s := range scopes {
+		if s == resource {
+			return true
+		}
+		if s == resource+AdminScopeReadSuffix && method == "GET" {
+			return true
+		}
+	}
+	return false
+}
+
+// IsValidAdminScope checks that the scope is a resource of the admin API, or its read-only scope
+func IsValidAdminScope(scope string) bool {
+	resource := strings.TrimSuffix(scope, AdminScopeReadSuffix)
+	for _, s := range validAdminScopes {
+		if s == resource {
+			return true
+		}
+	}
+	return false
+}
+
+// CreateServiceAccountTable creates the database table for the service accounts
+func (db *DB) CreateServiceAccountTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createServiceAccountTableSQL)
+	return err
+}
+
+// ListServiceAccounts returns the service accounts, without their secrets
+func (db *DB) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	rows, err := db.QueryContext(ctx, listServiceAccountsSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the service accounts: %v", err)
+	}
+	defer rows.Close()
+
+	accounts := []ServiceAccount{}
+	for rows.Next() {
+		sa := ServiceAccount{}
+		var scopes string
+		if err := rows.Scan(&sa.ID, &sa.UserID, &sa.Username, &sa.Name, &sa.ClientID, &sa.SecretHash, &scopes, &sa.Active, &sa.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the service accounts: %v", err)
+		}
+		sa.Scopes = splitScopes(scopes)
+		accounts = append(accounts, sa)
+	}
+
+	return accounts, rows.Err()
+}
+
+// GetServiceAccount returns a service account, without its secret
+func (db *DB) GetServiceAccount(ctx context.Context, serviceAccountID int) (ServiceAccount, error) {
+	return db.rowToServiceAccount(ctx, getServiceAccountSQL, serviceAccountID)
+}
+
+// CheckServiceAccount checks that the client ID is an active service account
+func (db *DB) CheckServiceAccount(ctx context.Context, clientID string) bool {
+	sa, err := db.rowToServiceAccount(ctx, getServiceAccountByClientIDSQL, clientID)
+	return err == nil && sa.Active
+}
+
+// AuthenticateServiceAccount returns the active service account of the client credentials
+func (db *DB) AuthenticateServiceAccount(ctx context.Context, clientID, secret string) (ServiceAccount, error) {
+	sa, err := db.rowToServiceAccount(ctx, getServiceAccountByClientIDSQL, clientID)
+	if err != nil || !sa.Active || !checkServiceAccountSecret(sa.SecretHash, secret) {
+		return ServiceAccount{}, errors.New("Invalid client credentials")
+	}
+	return sa, nil
+}
+
+func (db *DB) rowToServiceAccount(ctx context.Context, query string, args ...interface{}) (ServiceAccount, error) {
+	sa := ServiceAccount{}
+	var scopes string
+	err := db.QueryRowContext(ctx, query, args...).Scan(&sa.ID, &sa.UserID, &sa.Username, &sa.Name, &sa.ClientID, &sa.SecretHash, &scopes, &sa.Active, &sa.Created)
+	if err != nil {
+		return sa, fmt.Errorf("error retrieving the service account: %v", err)
+	}
+	sa.Scopes = splitScopes(scopes)
+	return sa, nil
+}
+
+func (db *DB) createServiceAccount(ctx context.Context, sa ServiceAccount) (int, error) {
+	var id int
+	err := db.QueryRowContext(ctx, createServiceAccountSQL, sa.UserID, sa.Name, sa.ClientID, sa.SecretHash, joinScopes(sa.Scopes), sa.Active).Scan(&id)
+	if err != nil {
+		return 0, fmt.Errorf("error creating the service account: %v", err)
+	}
+	return id, nil
+}
+
+func (db *DB) updateServiceAccount(ctx context.Context, sa ServiceAccount) error {
+	_, err := db.ExecContext(ctx, updateServiceAccountSQL, sa.ID, sa.Name, joinScopes(sa.Scopes), sa.Active)
+	if err != nil {
+		return fmt.Errorf("error updating the service account: %v", err)
+	}
+	return nil
+}
+
+func (db *DB) updateServiceAccountSecret(ctx context.Context, serviceAccountID int, secretHash string) error {
+	_, err := db.ExecContext(ctx, updateServiceAccountSecretSQL, serviceAccountID, secretHash)
+	if err != nil {
+		return fmt.Errorf("error resetting the service account secret: %v", err)
+	}
+	return nil
+}
+
+// DeleteServiceAccount deletes a service account, so its tokens are no longer accepted
+func (db *DB) DeleteServiceAccount(ctx context.Context, serviceAccountID int) error {
+	_, err := db.ExecContext(ctx, deleteServiceAccountSQL, serviceAccountID)
+	if err != nil {
+		return fmt.Errorf("error deleting the service account: %v", err)
+	}
+	return nil
+}
+
+// hashServiceAccountSecret hashes the secret, which is random, so a plain hash is enough
+func hashServiceAccountSecret(secret string) string {
+	hash := sha256.Sum256([]byte(secret))
+	return hex.EncodeToString(hash[:])
+}
+
+func checkServiceAccountSecret(secretHash, secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(secretHash), []byte(hashServiceAccountSecret(secret))) == 1
+}
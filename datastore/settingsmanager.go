@@ -20,6 +20,8 @@
 package datastore
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -71,13 +73,13 @@ type Setting struct {
 }
 
 // CreateSettingsTable creates the database table for a setting.
-func (db *DB) CreateSettingsTable() error {
-	_, err := db.Exec(createSettingsTableSQL)
+func (db *DB) CreateSettingsTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createSettingsTableSQL)
 	return err
 }
 
 // PutSetting stores a setting into the database
-func (db *DB) PutSetting(setting Setting) error {
+func (db *DB) PutSetting(ctx context.Context, setting Setting) error {
 	var err error
 	// Validate the data
 	if err := validateNotEmpty("code", setting.Code); err != nil {
@@ -88,19 +90,19 @@ func (db *DB) PutSetting(setting Setting) error {
 		// We only add new settings for the factory, we don't ever update a setting
 		// Need to generate our own ID
 		var nextID int
-		err = db.QueryRow(maxIDSettingsSQLite).Scan(&nextID)
+		err = db.QueryRowContext(ctx, maxIDSettingsSQLite).Scan(&nextID)
 		if err != nil {
-			log.Printf("Error retrieving next setting ID: %v\n", err)
+			log.Errorf("Error retrieving next setting ID: %v", err)
 			return err
 		}
 
-		_, err = db.Exec(upsertSettingsSQLite, nextID, setting.Code, setting.Data)
+		_, err = db.ExecContext(ctx, upsertSettingsSQLite, nextID, setting.Code, setting.Data)
 	} else {
-		_, err = db.Exec(upsertSettingsSQL, setting.Code, setting.Data)
+		_, err = db.ExecContext(ctx, upsertSettingsSQL, setting.Code, setting.Data)
 	}
 
 	if err != nil {
-		log.Printf("Error updating the database setting: %v\n", err)
+		log.Errorf("Error updating the database setting: %v", err)
 		return err
 	}
 
@@ -108,12 +110,16 @@ func (db *DB) PutSetting(setting Setting) error {
 }
 
 // GetSetting fetches a single setting from the database by code
-func (db *DB) GetSetting(code string) (Setting, error) {
+func (db *DB) GetSetting(ctx context.Context, code string) (Setting, error) {
 	setting := Setting{}
 
-	err := db.QueryRow(getSettingSQL, code).Scan(&setting.ID, &setting.Code, &setting.Data)
+	err := db.QueryRowContext(ctx, getSettingSQL, code).Scan(&setting.ID, &setting.Code, &setting.Data)
+	if err == sql.ErrNoRows {
+		// The setting has not been stored yet
+		return setting, err
+	}
 	if err != nil {
-		log.Printf("Error retrieving setting by code: %v\n", err)
+		log.Errorf("Error retrieving setting by code: %v", err)
 		return setting, err
 	}
 
@@ -0,0 +1,129 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const createSignedModelAssertTableSQL = `
+	CREATE TABLE IF NOT EXISTS signedmodelassertion (
+		id               serial primary key not null,
+		model_id         int references model not null,
+		substore_id      int not null default 0,
+		digest           varchar(100) not null,
+		assertion        text not null,
+		signed           timestamp not null
+	)
+`
+
+// Indexes. There is one signed assertion of the model, and one for each of its sub-store models
+const createSignedModelAssertUniqueIndexSQL = `
+	CREATE UNIQUE INDEX IF NOT EXISTS signedmodelassertion_idx ON signedmodelassertion (model_id, substore_id)`
+
+const getSignedModelAssertSQL = `
+	SELECT id, model_id, substore_id, digest, assertion, signed
+	FROM signedmodelassertion
+	WHERE model_id=$1 AND substore_id=$2`
+
+const listSignedModelAssertSQL = `
+	SELECT id, model_id, substore_id, digest, assertion, signed
+	FROM signedmodelassertion
+	ORDER BY id`
+
+const upsertSignedModelAssertSQL = `
+	INSERT INTO signedmodelassertion (model_id, substore_id, digest, assertion, signed)
+	VALUES ($1, $2, $3, $4, $5)
+	ON CONFLICT (model_id, substore_id) DO UPDATE SET
+		digest=$3, assertion=$4, signed=$5`
+
+const deleteSignedModelAssertSQL = "DELETE FROM signedmodelassertion WHERE id=$1"
+
+// SignedModelAssertion is a signed model assertion that is stored, so it is served without signing it
+// again. The digest is of the headers that the assertion was signed with, so the assertion is signed
+// again when the headers or the signing key of the model change. The sub-store is zero for the
+// assertion of the model, or the sub-store model of a pivoted assertion
+type SignedModelAssertion struct {
+	ID         int       `json:"id"`
+	ModelID    int       `json:"modelID"`
+	SubstoreID int       `json:"substoreID"`
+	Digest     string    `json:"digest"`
+	Assertion  string    `json:"assertion"`
+	Signed     time.Time `json:"signed"`
+}
+
+// CreateSignedModelAssertTable creates the database table for the signed model assertions
+func (db *DB) CreateSignedModelAssertTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createSignedModelAssertTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createSignedModelAssertUniqueIndexSQL)
+	return err
+}
+
+// GetSignedModelAssert fetches the stored signed assertion of the model or of its sub-store model
+func (db *DB) GetSignedModelAssert(ctx context.Context, modelID, substoreID int) (SignedModelAssertion, error) {
+	s := SignedModelAssertion{}
+	err := db.QueryRowContext(ctx, getSignedModelAssertSQL, modelID, substoreID).Scan(&s.ID, &s.ModelID, &s.SubstoreID, &s.Digest, &s.Assertion, &s.Signed)
+	if err != nil {
+		return s, fmt.Errorf("error fetching the signed model assertion for %d: %v", modelID, err)
+	}
+	return s, nil
+}
+
+// ListSignedModelAsserts fetches the stored signed model assertions, for their refresh
+func (db *DB) ListSignedModelAsserts(ctx context.Context) ([]SignedModelAssertion, error) {
+	rows, err := db.QueryContext(ctx, listSignedModelAssertSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the signed model assertions: %v", err)
+	}
+	defer rows.Close()
+
+	signed := []SignedModelAssertion{}
+	for rows.Next() {
+		s := SignedModelAssertion{}
+		if err := rows.Scan(&s.ID, &s.ModelID, &s.SubstoreID, &s.Digest, &s.Assertion, &s.Signed); err != nil {
+			return nil, fmt.Errorf("error retrieving the signed model assertions: %v", err)
+		}
+		signed = append(signed, s)
+	}
+	return signed, rows.Err()
+}
+
+// UpsertSignedModelAssert stores the signed assertion of the model or of its sub-store model,
+// replacing the assertion that was signed before
+func (db *DB) UpsertSignedModelAssert(ctx context.Context, s SignedModelAssertion) error {
+	_, err := db.ExecContext(ctx, upsertSignedModelAssertSQL, s.ModelID, s.SubstoreID, s.Digest, s.Assertion, s.Signed)
+	if err != nil {
+		return fmt.Errorf("error storing the signed model assertion for %d: %v", s.ModelID, err)
+	}
+	return nil
+}
+
+// DeleteSignedModelAssert deletes a stored signed model assertion, when its model is deleted
+func (db *DB) DeleteSignedModelAssert(ctx context.Context, id int) error {
+	if _, err := db.ExecContext(ctx, deleteSignedModelAssertSQL, id); err != nil {
+		return fmt.Errorf("error deleting the signed model assertion %d: %v", id, err)
+	}
+	return nil
+}
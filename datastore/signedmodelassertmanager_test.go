@@ -0,0 +1,90 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestUpsertSignedModelAssert(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder-key"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	if _, err := db.GetSignedModelAssert(ctx, model.ID, 0); err == nil {
+		t.Fatal("GetSignedModelAssert() expected an error when the assertion is not stored")
+	}
+
+	// The assertion of the model and of its sub-store model are stored separately, and replaced
+	signed := time.Now().UTC().Truncate(time.Second)
+	for _, s := range []SignedModelAssertion{
+		{ModelID: model.ID, Digest: "digest-1", Assertion: "assertion-1", Signed: signed},
+		{ModelID: model.ID, SubstoreID: 1, Digest: "digest-2", Assertion: "assertion-2", Signed: signed},
+		{ModelID: model.ID, Digest: "digest-3", Assertion: "assertion-3", Signed: signed},
+	} {
+		if err := db.UpsertSignedModelAssert(ctx, s); err != nil {
+			t.Fatalf("UpsertSignedModelAssert() error = %v", err)
+		}
+	}
+
+	got, err := db.GetSignedModelAssert(ctx, model.ID, 0)
+	if err != nil {
+		t.Fatalf("GetSignedModelAssert() error = %v", err)
+	}
+	if got.Digest != "digest-3" || got.Assertion != "assertion-3" {
+		t.Errorf("GetSignedModelAssert() = %v, want the replaced assertion", got)
+	}
+
+	list, err := db.ListSignedModelAsserts(ctx)
+	if err != nil {
+		t.Fatalf("ListSignedModelAsserts() error = %v", err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("ListSignedModelAsserts() = %d assertions, want 2", len(list))
+	}
+
+	if err := db.DeleteSignedModelAssert(ctx, got.ID); err != nil {
+		t.Fatalf("DeleteSignedModelAssert() error = %v", err)
+	}
+	if _, err := db.GetSignedModelAssert(ctx, model.ID, 0); err == nil {
+		t.Error("GetSignedModelAssert() expected an error after the delete")
+	}
+}
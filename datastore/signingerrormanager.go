@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// SigningErrorRetention is how long the failed signing requests are kept for the dashboard
+const SigningErrorRetention = 30 * 24 * time.Hour
+
+// The failed signing requests of the brands, once the serial-request has been parsed
+const createSigningErrorTableSQL = `
+	CREATE TABLE IF NOT EXISTS signingerror (
+		id               serial primary key not null,
+		brand_id         varchar(200) not null,
+		model            varchar(200) not null default '',
+		code             varchar(200) not null,
+		message          text not null default '',
+		created          timestamp default current_timestamp
+	)
+`
+
+const createSigningErrorBrandIndexSQL = "CREATE INDEX IF NOT EXISTS signingerror_brand_idx ON signingerror (brand_id, created)"
+
+const createSigningErrorSQL = "INSERT INTO signingerror (brand_id, model, code, message) VALUES ($1,$2,$3,$4)"
+
+const purgeSigningErrorSQL = "DELETE FROM signingerror WHERE created<$1"
+
+// SigningError is a failed signing request of a brand
+type SigningError struct {
+	ID      int       `json:"id"`
+	Brand   string    `json:"brand"`
+	Model   string    `json:"model"`
+	Code    string    `json:"code"`
+	Message string    `json:"message"`
+	Created time.Time `json:"created"`
+}
+
+// CreateSigningErrorTable creates the database table for the failed signing requests
+func (db *DB) CreateSigningErrorTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createSigningErrorTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createSigningErrorBrandIndexSQL)
+	return err
+}
+
+// CreateSigningError records a failed signing request
+func (db *DB) CreateSigningError(ctx context.Context, e SigningError) error {
+	_, err := db.ExecContext(ctx, createSigningErrorSQL, e.Brand, e.Model, e.Code, e.Message)
+	if err != nil {
+		return fmt.Errorf("error recording the signing error: %v", err)
+	}
+	return nil
+}
+
+// PurgeSigningErrors deletes the failed signing requests that are older than the retention
+func (db *DB) PurgeSigningErrors(ctx context.Context, now time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, purgeSigningErrorSQL, now.Add(-SigningErrorRetention))
+	if err != nil {
+		return 0, fmt.Errorf("error purging the signing errors: %v", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error purging the signing errors: %v", err)
+	}
+	return int(purged), nil
+}
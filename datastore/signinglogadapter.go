@@ -19,47 +19,146 @@
 
 package datastore
 
-// ListAllowedSigningLog return signing logs the user is authorized to see
-func (db *DB) ListAllowedSigningLog(authorization User) ([]SigningLog, error) {
+import "context"
+import "errors"
+
+// ListAllowedSigningLog return a page of the filtered signing logs the user is authorized to see,
+// with the token of the next page (empty on the last page)
+func (db *DB) ListAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, page SigningLogPage) ([]SigningLog, string, error) {
+	fromID, limit, err := page.keyset()
+	if err != nil {
+		return nil, "", err
+	}
+
+	var logs []SigningLog
+	reader := db.reader()
+
+	// Fetch an extra record to check if there is a next page
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.listAllSigningLog()
+		logs, err = reader.listAllSigningLog(ctx, fromID, limit+1, filter)
+	case SyncUser:
+		fallthrough
+	case Admin:
+		logs, err = reader.listSigningLogFilteredByUser(ctx, authorization.Username, fromID, limit+1, filter)
+	case SubstoreAdmin:
+		logs, err = reader.listSigningLogForSubstoreUser(ctx, authorization.Username, fromID, limit+1, filter)
+	default:
+		return []SigningLog{}, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	logs, next := nextSigningLogPage(logs, limit)
+	return logs, next, nil
+}
+
+// StreamAllowedSigningLog calls the function for each of the filtered signing logs the user
+// is authorized to see, in the order of the list, as the logs are read from the database
+func (db *DB) StreamAllowedSigningLog(ctx context.Context, authorization User, filter SigningLogFilter, fn func(SigningLog) error) error {
+	listSQL := streamSigningLogSQLBuilder(filter)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser: // All the signing logs
+	case SyncUser:
+		fallthrough
+	case Admin:
+		listSQL = listSQL.Where(userSigningLogFilter(authorization.Username))
+	case SubstoreAdmin:
+		listSQL = listSQL.Where(substoreUserSigningLogFilter(authorization.Username))
+	default:
+		return nil
+	}
+
+	return db.streamSigningLog(ctx, listSQL, fn)
+}
+
+// ListAllowedSigningLogForFingerprint returns the signing logs of a device-key fingerprint, across the
+// models the user is authorized to see. The lookup uses the fingerprint index, so it is not paged
+func (db *DB) ListAllowedSigningLogForFingerprint(ctx context.Context, authorization User, fingerprint string) ([]SigningLog, error) {
+	if len(fingerprint) == 0 {
+		return nil, errors.New("The device-key fingerprint must be provided")
+	}
+
+	listSQL := streamSigningLogSQLBuilder(SigningLogFilter{Fingerprint: fingerprint}).Limit(ListSigningLogMaxLimit)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser: // All the signing logs
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listSigningLogFilteredByUser(authorization.Username)
+		listSQL = listSQL.Where(userSigningLogFilter(authorization.Username))
+	case SubstoreAdmin:
+		listSQL = listSQL.Where(substoreUserSigningLogFilter(authorization.Username))
 	default:
 		return []SigningLog{}, nil
 	}
+
+	return db.querySigningLog(ctx, listSQL)
+}
+
+// ListAllowedDevicesForFingerprint returns the devices that were signed with a device-key fingerprint,
+// across the models the user is authorized to see, with the most recently signed device first
+func (db *DB) ListAllowedDevicesForFingerprint(ctx context.Context, authorization User, fingerprint string, limit int) ([]Device, error) {
+	if len(fingerprint) == 0 {
+		return nil, errors.New("The device-key fingerprint must be provided")
+	}
+
+	listSQL := fingerprintDevicesSQLBuilder(fingerprint, limit)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser: // All the signing logs
+	case SyncUser:
+		fallthrough
+	case Admin:
+		listSQL = listSQL.Where(userSigningLogFilter(authorization.Username))
+	case SubstoreAdmin:
+		listSQL = listSQL.Where(substoreUserSigningLogFilter(authorization.Username))
+	default:
+		return []Device{}, nil
+	}
+
+	return db.queryFingerprintDevices(ctx, listSQL, fingerprint)
 }
 
 // ListAllowedSigningLogForAccount return signing logs the user is authorized to see
-func (db *DB) ListAllowedSigningLogForAccount(authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+func (db *DB) ListAllowedSigningLogForAccount(ctx context.Context, authorization User, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.listAllSigningLogForAccount(authorityID, params)
+		return db.listAllSigningLogForAccount(ctx, authorityID, params)
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listSigningLogForAccountFilteredByUser(authorization.Username, authorityID, params)
+		return db.listSigningLogForAccountFilteredByUser(ctx, authorization.Username, authorityID, params)
+	case SubstoreAdmin:
+		return db.listSigningLogForAccountForSubstoreUser(ctx, authorization.Username, authorityID, params)
 	default:
 		return []SigningLog{}, nil
 	}
 }
 
 // AllowedSigningLogFilterValues return signing log filters authorized for the user
-func (db *DB) AllowedSigningLogFilterValues(authorization User, authorityID string) (SigningLogFilters, error) {
+func (db *DB) AllowedSigningLogFilterValues(ctx context.Context, authorization User, authorityID string) (SigningLogFilters, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.allSigningLogFilterValues(authorityID)
+		return db.allSigningLogFilterValues(ctx, authorityID)
 	case Admin:
-		return db.signingLogFilterValuesFilteredByUser(authorization.Username, authorityID)
+		return db.signingLogFilterValuesFilteredByUser(ctx, authorization.Username, authorityID)
+	case SubstoreAdmin:
+		return db.signingLogFilterValuesForSubstoreUser(ctx, authorization.Username, authorityID)
 	default:
 		return SigningLogFilters{}, nil
 	}
@@ -0,0 +1,233 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Write-behind of the signing logs
+const (
+	signingLogFlushInterval = 250 * time.Millisecond
+	signingLogBatchSize     = 100   // the buffer is flushed early when a batch is full
+	signingLogMaxPending    = 10000 // the logs are written synchronously when the buffer is full
+	signingLogMaxAttempts   = 20    // a batch that still fails is written a log at a time, dropping the logs that fail
+)
+
+// signingLogBuffer holds the signing logs that have not been written yet. The logs stay in the
+// buffer until their batch is committed, so the duplicate checks see them until they are in the
+// database. Only the writer removes the logs from the buffer
+type signingLogBuffer struct {
+	sync.Mutex
+	pending  []SigningLog
+	attempts int // the failed writes of the oldest batch, only used by the writer
+	stopped  bool
+	wake     chan struct{}
+	stop     chan struct{}
+	done     chan struct{}
+}
+
+// StartSigningLogBuffer starts writing the signing logs in the background, so the signing response
+// is not blocked on the insert. The factory database is always written synchronously
+func (db *DB) StartSigningLogBuffer(ctx context.Context) {
+	if InFactory() || db.logBuffer != nil {
+		return
+	}
+
+	db.logBuffer = &signingLogBuffer{
+		pending: []SigningLog{},
+		wake:    make(chan struct{}, 1),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go db.writeSigningLogs(context.Background(), db.logBuffer)
+}
+
+// StopSigningLogBuffer writes the buffered signing logs and stops the background writes.
+// The signing logs are written synchronously after that
+func (db *DB) StopSigningLogBuffer(ctx context.Context) error {
+	b := db.logBuffer
+	if b == nil {
+		return nil
+	}
+
+	b.Lock()
+	if b.stopped {
+		b.Unlock()
+		return nil
+	}
+	b.stopped = true
+	b.Unlock()
+
+	close(b.stop)
+	<-b.done
+
+	return db.flushSigningLogs(ctx, b, true)
+}
+
+// writeSigningLogs writes the buffered signing logs in batches, until the buffer is stopped.
+// A batch that fails is retried, as the logs are kept in the buffer, until it has failed too
+// many times, so a log that cannot be written does not block the logs after it
+func (db *DB) writeSigningLogs(ctx context.Context, b *signingLogBuffer) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(signingLogFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+		case <-b.wake:
+		}
+
+		if err := db.flushSigningLogs(ctx, b, false); err != nil {
+			log.Errorf("Error writing the buffered signing logs: %v", err)
+		}
+	}
+}
+
+// flushSigningLogs writes the buffered signing logs in batches, or a single batch. When a batch
+// has failed the maximum number of attempts, its logs are written one at a time and the logs
+// that still fail are dropped from the buffer and logged
+func (db *DB) flushSigningLogs(ctx context.Context, b *signingLogBuffer, all bool) error {
+	for {
+		batch := b.batch()
+		if len(batch) == 0 {
+			return nil
+		}
+
+		if err := db.writeSigningLogBatch(ctx, batch); err != nil {
+			b.attempts++
+			if b.attempts < signingLogMaxAttempts {
+				return err
+			}
+			log.Errorf("Error writing a batch of %d signing logs after %d attempts, writing the logs one at a time: %v", len(batch), b.attempts, err)
+			db.dropFailedSigningLogs(ctx, batch)
+		}
+
+		b.attempts = 0
+		b.remove(len(batch))
+		if !all {
+			return nil
+		}
+	}
+}
+
+// writeSigningLogBatch writes the signing logs in one transaction
+func (db *DB) writeSigningLogBatch(ctx context.Context, batch []SigningLog) error {
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		if err := lockAuditRecord(ctx, tx); err != nil {
+			return err
+		}
+		for _, l := range batch {
+			if _, err := tx.ExecContext(ctx, createSigningLogSQL, l.Make, l.Model, l.SerialNumber, l.Fingerprint, l.Revision, timestampSourceOrDefault(l.TimestampSource),
+				l.SourceIP, l.APIKeyID, l.RequestID, l.UserAgent, l.TimestampToken); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// dropFailedSigningLogs writes the signing logs of a failed batch one at a time, logging the
+// logs that cannot be written, as they are dropped
+func (db *DB) dropFailedSigningLogs(ctx context.Context, batch []SigningLog) {
+	for _, l := range batch {
+		if err := db.writeSigningLogBatch(ctx, []SigningLog{l}); err != nil {
+			log.Errorf("Dropped the signing log of %s/%s/%s revision %d, request %s: %v", l.Make, l.Model, log.MaskSerial(l.SerialNumber), l.Revision, l.RequestID, err)
+		}
+	}
+}
+
+// add buffers the signing log, returning false when it has to be written synchronously
+func (b *signingLogBuffer) add(signLog SigningLog) bool {
+	if b == nil {
+		return false
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	if b.stopped || len(b.pending) >= signingLogMaxPending {
+		return false
+	}
+	b.pending = append(b.pending, signLog)
+
+	if len(b.pending) >= signingLogBatchSize {
+		select {
+		case b.wake <- struct{}{}:
+		default:
+		}
+	}
+	return true
+}
+
+// batch returns the oldest buffered signing logs, up to the size of a batch
+func (b *signingLogBuffer) batch() []SigningLog {
+	b.Lock()
+	defer b.Unlock()
+
+	n := len(b.pending)
+	if n > signingLogBatchSize {
+		n = signingLogBatchSize
+	}
+	batch := make([]SigningLog, n)
+	copy(batch, b.pending)
+	return batch
+}
+
+// remove removes the oldest signing logs, once they are written
+func (b *signingLogBuffer) remove(n int) {
+	b.Lock()
+	defer b.Unlock()
+	b.pending = b.pending[n:]
+}
+
+// duplicate checks the buffered signing logs for the serial number or the device-key fingerprint,
+// returning the maximum revision of the serial number
+func (b *signingLogBuffer) duplicate(signLog SigningLog) (bool, int) {
+	if b == nil {
+		return false, 0
+	}
+
+	b.Lock()
+	defer b.Unlock()
+
+	exists := false
+	maxRevision := 0
+	for _, l := range b.pending {
+		sameSerial := l.Make == signLog.Make && l.Model == signLog.Model && l.SerialNumber == signLog.SerialNumber
+		if sameSerial || l.Fingerprint == signLog.Fingerprint {
+			exists = true
+		}
+		if sameSerial && l.Revision > maxRevision {
+			maxRevision = l.Revision
+		}
+	}
+	return exists, maxRevision
+}
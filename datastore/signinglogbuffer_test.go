@@ -0,0 +1,158 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+)
+
+func newTestSigningLogBuffer() *signingLogBuffer {
+	return &signingLogBuffer{pending: []SigningLog{}, wake: make(chan struct{}, 1)}
+}
+
+func TestSigningLogBufferDuplicate(t *testing.T) {
+	b := newTestSigningLogBuffer()
+	b.add(SigningLog{Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "f1", Revision: 1})
+	b.add(SigningLog{Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "f2", Revision: 2})
+
+	tests := []struct {
+		name     string
+		log      SigningLog
+		exists   bool
+		revision int
+	}{
+		{"same serial", SigningLog{Make: "System", Model: "alder", SerialNumber: "A1", Fingerprint: "f3"}, true, 2},
+		{"same fingerprint", SigningLog{Make: "System", Model: "alder", SerialNumber: "A2", Fingerprint: "f1"}, true, 0},
+		{"other model", SigningLog{Make: "System", Model: "birch", SerialNumber: "A1", Fingerprint: "f3"}, false, 0},
+		{"new device", SigningLog{Make: "System", Model: "alder", SerialNumber: "A2", Fingerprint: "f3"}, false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			exists, revision := b.duplicate(tt.log)
+			if exists != tt.exists || revision != tt.revision {
+				t.Errorf("duplicate() = %v, %d, want %v, %d", exists, revision, tt.exists, tt.revision)
+			}
+		})
+	}
+
+	// The synchronous writes have no buffer
+	var none *signingLogBuffer
+	if exists, revision := none.duplicate(tests[0].log); exists || revision != 0 {
+		t.Errorf("duplicate() without a buffer = %v, %d", exists, revision)
+	}
+	if none.add(tests[0].log) {
+		t.Error("add() without a buffer: expected a synchronous write")
+	}
+}
+
+func TestSigningLogBufferBatch(t *testing.T) {
+	b := newTestSigningLogBuffer()
+	for i := 0; i < signingLogBatchSize+10; i++ {
+		if !b.add(SigningLog{Make: "System", Model: "alder", SerialNumber: fmt.Sprintf("A%d", i), Fingerprint: fmt.Sprintf("f%d", i)}) {
+			t.Fatalf("add(): expected the log to be buffered")
+		}
+	}
+
+	// A full batch wakes the writer
+	select {
+	case <-b.wake:
+	default:
+		t.Error("add(): expected the writer to be woken")
+	}
+
+	batch := b.batch()
+	if len(batch) != signingLogBatchSize || batch[0].SerialNumber != "A0" {
+		t.Fatalf("batch(): unexpected batch of %d logs", len(batch))
+	}
+
+	// The logs stay in the buffer until they are written
+	if exists, _ := b.duplicate(batch[0]); !exists {
+		t.Error("duplicate(): expected the batch to be checked")
+	}
+	b.remove(len(batch))
+	if exists, _ := b.duplicate(batch[0]); exists {
+		t.Error("duplicate(): expected the written batch to be removed")
+	}
+	if len(b.batch()) != 10 {
+		t.Errorf("batch(): expected the remaining logs, got %d", len(b.batch()))
+	}
+}
+
+func TestSigningLogBufferFull(t *testing.T) {
+	b := newTestSigningLogBuffer()
+	b.pending = make([]SigningLog, signingLogMaxPending)
+	if b.add(SigningLog{}) {
+		t.Error("add(): expected a synchronous write when the buffer is full")
+	}
+
+	b = newTestSigningLogBuffer()
+	b.stopped = true
+	if b.add(SigningLog{}) {
+		t.Error("add(): expected a synchronous write when the buffer is stopped")
+	}
+}
+
+func TestSQLiteSigningLogBufferDropsFailedLogs(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	// The database always rejects one of the logs, as its serial number is already written
+	ctx := context.Background()
+	if _, err := db.ExecContext(ctx, "CREATE UNIQUE INDEX signinglog_serial_idx ON signinglog (serial_number)"); err != nil {
+		t.Fatalf("creating the index: %v", err)
+	}
+	if err := db.CreateSigningLog(ctx, SigningLog{Make: "System", Model: "alder", SerialNumber: "A2", Fingerprint: "f0", Revision: 1}); err != nil {
+		t.Fatalf("CreateSigningLog() error = %v", err)
+	}
+
+	b := newTestSigningLogBuffer()
+	for _, serial := range []string{"A1", "A2", "A3"} {
+		b.add(SigningLog{Make: "System", Model: "alder", SerialNumber: serial, Fingerprint: "f" + serial, Revision: 1})
+	}
+
+	// The batch is retried until the maximum number of attempts
+	for i := 1; i < signingLogMaxAttempts; i++ {
+		if err := db.flushSigningLogs(ctx, b, false); err == nil {
+			t.Fatalf("flushSigningLogs() attempt %d: expected an error", i)
+		}
+		if len(b.batch()) != 3 {
+			t.Fatalf("flushSigningLogs() attempt %d: expected the batch to stay in the buffer", i)
+		}
+	}
+
+	// The last attempt writes the logs one at a time, and drops the log that fails
+	if err := db.flushSigningLogs(ctx, b, false); err != nil {
+		t.Fatalf("flushSigningLogs() error = %v", err)
+	}
+	if len(b.batch()) != 0 || b.attempts != 0 {
+		t.Errorf("flushSigningLogs(): expected the batch to be removed, got %d logs", len(b.batch()))
+	}
+
+	var count int
+	if err := db.QueryRowContext(ctx, "SELECT count(*) FROM signinglog").Scan(&count); err != nil || count != 3 {
+		t.Errorf("Expected the other logs of the batch to be written, got %d logs: %v", count, err)
+	}
+}
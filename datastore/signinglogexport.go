@@ -0,0 +1,74 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// signingLogSettleDelay leaves the most recent signing logs for the next export on CockroachDB, which
+// has no advisory locks, so the logs that are still being written by concurrent transactions are not skipped
+const signingLogSettleDelay = time.Minute
+
+const maxSigningLogIDSQL = "SELECT COALESCE(MAX(id), 0) FROM signinglog"
+const maxSettledSigningLogIDSQL = "SELECT COALESCE(MAX(id), 0) FROM signinglog WHERE created<$1"
+
+// SigningLogWatermark returns the ID up to which the signing logs are committed. The signing logs are
+// created holding the shared lock of the audit chain, so the exclusive lock waits for the logs that have
+// been given an ID to be committed, and the later logs are given a greater ID
+func (db *DB) SigningLogWatermark(ctx context.Context, now time.Time) (int, error) {
+	var watermark int
+	if InCockroach() {
+		err := db.QueryRowContext(ctx, maxSettledSigningLogIDSQL, now.UTC().Add(-signingLogSettleDelay)).Scan(&watermark)
+		return watermark, err
+	}
+
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		if !InFactory() {
+			if _, err := tx.ExecContext(ctx, lockAuditChainSQL, auditChainLock); err != nil {
+				return err
+			}
+		}
+		return tx.QueryRowContext(ctx, maxSigningLogIDSQL).Scan(&watermark)
+	})
+	return watermark, err
+}
+
+// ExportSigningLog returns the signing logs after the ID and up to the watermark, in ID order, for the
+// export to object storage
+func (db *DB) ExportSigningLog(ctx context.Context, afterID, watermark, limit int) ([]SigningLog, error) {
+	return db.querySigningLog(ctx, exportSigningLogSQLBuilder(afterID, limit).Where(sq.LtOrEq{"s.id": watermark}))
+}
+
+// exportSigningLogSQLBuilder creates the query for the next batch of the signing logs after the ID
+func exportSigningLogSQLBuilder(afterID, limit int) sq.SelectBuilder {
+	return sq.
+		Select("s.*").
+		From("signinglog s").
+		Where(sq.Gt{"s.id": afterID}).
+		OrderBy("s.id").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Dollar)
+}
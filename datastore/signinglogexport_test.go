@@ -0,0 +1,59 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestSQLiteExportSigningLog(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for i := 1; i <= 3; i++ {
+		serial := fmt.Sprintf("A%d", i)
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	watermark, err := db.SigningLogWatermark(ctx, time.Now())
+	if err != nil || watermark != 3 {
+		t.Fatalf("SigningLogWatermark() = %d, %v", watermark, err)
+	}
+
+	// The logs after the watermark are left for the next export
+	logs, err := db.ExportSigningLog(ctx, 1, 2, 10)
+	if err != nil || len(logs) != 1 || logs[0].ID != 2 {
+		t.Errorf("ExportSigningLog() = %v, %v", logs, err)
+	}
+
+	locked, err := db.RunJobLocked(ctx, JobLockSigningLog, func(ctx context.Context) error { return nil })
+	if err != nil || !locked {
+		t.Errorf("RunJobLocked() = %v, %v", locked, err)
+	}
+}
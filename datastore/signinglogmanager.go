@@ -20,9 +20,15 @@
 package datastore
 
 import (
+	"context"
+	"crypto/sha256"
 	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -38,13 +44,29 @@ const createSigningLogTableSQL = `
 		fingerprint    varchar(200) not null,
 		created        timestamp default current_timestamp,
 		revision       int default 1,
-		synced         int default 0
+		synced         int default 0,
+		timestamp_source varchar(200) default 'system',
+		source_ip      varchar(200) not null default '',
+		api_key_id     varchar(200) not null default '',
+		request_id     varchar(200) not null default '',
+		user_agent     text not null default '',
+		timestamp_token bytea
 	)
 `
 
 // Additional columns
 const alterSigningLogAddRevisionSQL = "ALTER TABLE signinglog ADD COLUMN revision int default 1"
 const alterSigningLogAddSyncedSQL = "ALTER TABLE signinglog ADD COLUMN synced int default 0"
+const alterSigningLogAddTimestampSourceSQL = "ALTER TABLE signinglog ADD COLUMN timestamp_source varchar(200) default 'system'"
+
+// The request metadata of the signing, to correlate the signing logs with the factory hosts
+const alterSigningLogAddSourceIPSQL = "ALTER TABLE signinglog ADD COLUMN source_ip varchar(200) not null default ''"
+const alterSigningLogAddAPIKeyIDSQL = "ALTER TABLE signinglog ADD COLUMN api_key_id varchar(200) not null default ''"
+const alterSigningLogAddRequestIDSQL = "ALTER TABLE signinglog ADD COLUMN request_id varchar(200) not null default ''"
+const alterSigningLogAddUserAgentSQL = "ALTER TABLE signinglog ADD COLUMN user_agent text not null default ''"
+
+// The time-stamp token of the TSA, that proves the time of the assertion
+const alterSigningLogAddTimestampTokenSQL = "ALTER TABLE signinglog ADD COLUMN timestamp_token bytea"
 
 // MaxFromID is the maximum ID value
 const MaxFromID = 2147483647
@@ -52,30 +74,55 @@ const MaxFromID = 2147483647
 // ListSigningLogDefaultLimit is the default limit for the search queries in SigningLog
 const ListSigningLogDefaultLimit = 50
 
+// ListSigningLogMaxLimit is the maximum page size of the SigningLog list
+const ListSigningLogMaxLimit = 1000
+
 // Indexes
 const createSigningLogSerialNumberIndexSQL = "CREATE INDEX IF NOT EXISTS serialnumber_idx ON signinglog (make,model,serial_number)"
 const createSigningLogFingerprintIndexSQL = "CREATE INDEX IF NOT EXISTS fingerprint_idx ON signinglog (fingerprint)"
 const createSigningLogCreatedIndexSQL = "CREATE INDEX IF NOT EXISTS created_idx ON signinglog (created)"
 
+// The trigram indexes of the search of the serial numbers and the fingerprints, as a LIKE
+// with a leading wildcard cannot use the btree indexes. They need the pg_trgm extension.
+// The indexes are built concurrently, outside of a transaction, so the signing logs are
+// still written while the indexes of an existing table are built
+const createTrigramExtensionSQL = "CREATE EXTENSION IF NOT EXISTS pg_trgm"
+const createTrigramIndexSQL = "CREATE INDEX CONCURRENTLY IF NOT EXISTS %s ON %s USING gin (%s gin_trgm_ops)"
+
+// An index that failed to build concurrently is left invalid, so it is dropped to build it again
+const invalidIndexSQL = `
+	SELECT EXISTS(
+		SELECT * FROM pg_index i
+		INNER JOIN pg_class c ON c.oid=i.indexrelid
+		WHERE c.relname=$1 AND NOT i.indisvalid AND pg_table_is_visible(c.oid))`
+const dropIndexSQL = "DROP INDEX CONCURRENTLY IF EXISTS %s"
+
+// trigramIndex is the trigram index of a column of the signing log search
+type trigramIndex struct {
+	name   string
+	column string
+	legacy string // the index of the table before it was partitioned
+}
+
+var signingLogTrigramIndexes = []trigramIndex{{name: "serialnumber_trgm_idx", column: "serial_number"}, {name: "fingerprint_trgm_idx", column: "fingerprint"}}
+
 // Queries
-const findMatchingSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3 and revision=$4)"
-const findExistingSigningLogSQL = "SELECT EXISTS(SELECT * FROM signinglog where (make=$1 and model=$2 and serial_number=$3) or fingerprint=$4)"
-const findMaxRevisionSigningLogSQL = "SELECT COALESCE(MAX(revision), 0) FROM signinglog where make=$1 and model=$2 and serial_number=$3"
+// The signing logs that were purged are checked in the index of the purged serial numbers
+const findMatchingSigningLogSQL = `
+	SELECT EXISTS(SELECT * FROM signinglog where make=$1 and model=$2 and serial_number=$3 and revision=$4)
+		OR EXISTS(SELECT * FROM signingserial where make=$1 and model=$2 and serial_number=$3 and revision=$4)`
+const findExistingSigningLogSQL = `
+	SELECT EXISTS(SELECT * FROM signinglog where (make=$1 and model=$2 and serial_number=$3) or fingerprint=$4)
+		OR EXISTS(SELECT * FROM signingserial where (make=$1 and model=$2 and serial_number=$3) or fingerprint=$4)`
+const findMaxRevisionSigningLogSQL = `
+	SELECT COALESCE(MAX(revision), 0) FROM (
+		SELECT revision FROM signinglog where make=$1 and model=$2 and serial_number=$3
+		UNION ALL
+		SELECT revision FROM signingserial where make=$1 and model=$2 and serial_number=$3) r`
 const maxIDSigningLogSQLite = "SELECT COUNT(*)+1 from signinglog"
-const createSigningLogSQLite = "INSERT INTO signinglog (id, make, model, serial_number, fingerprint,revision) VALUES ($1, $2, $3, $4, $5, $6)"
-const createSigningLogSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision) VALUES ($1, $2, $3, $4, $5)"
-const createSigningLogSyncSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision,created) VALUES ($1, $2, $3, $4, $5, $6)"
-const listSigningLogSQL = "SELECT * FROM signinglog WHERE id < $1 ORDER BY id DESC LIMIT 10000"
-const listSigningLogForUserSQL = `
-	SELECT s.* FROM signinglog s
-	WHERE id < $1 and EXISTS(
-		SELECT * FROM account acc
-		INNER JOIN useraccountlink ua on ua.account_id=acc.id
-		INNER JOIN userinfo u on ua.user_id=u.id
-		WHERE acc.authority_id=s.make and u.username=$2
-	)
-	ORDER BY id DESC LIMIT 10000`
-
+const createSigningLogSQLite = "INSERT INTO signinglog (id, make, model, serial_number, fingerprint,revision,timestamp_source,source_ip,api_key_id,request_id,user_agent,timestamp_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
+const createSigningLogSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision,timestamp_source,source_ip,api_key_id,request_id,user_agent,timestamp_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)"
+const createSigningLogSyncSQL = "INSERT INTO signinglog (make, model, serial_number, fingerprint,revision,created,timestamp_source,source_ip,api_key_id,request_id,user_agent,timestamp_token) VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)"
 const deleteSigningLogSQL = "DELETE FROM signinglog WHERE id=$1"
 
 const filterValuesModelSigningLogSQL = "SELECT DISTINCT model FROM signinglog WHERE make=$1 ORDER BY model"
@@ -85,25 +132,35 @@ const filterValuesModelSigningLogForUserSQL = `
 		SELECT * FROM account acc
 		INNER JOIN useraccountlink ua on ua.account_id=acc.id
 		INNER JOIN userinfo u on ua.user_id=u.id
-		WHERE acc.authority_id=s.make and u.username=$1
+		WHERE acc.authority_id=s.make and u.username=$1` + accountRoleAdminSQL + `
 	)
 	AND s.make = $2
 	ORDER BY model`
 const syncSigningLogSQLite = "SELECT * FROM signinglog WHERE synced = 0"
 const syncSigningLogUpdateSQLite = "UPDATE signinglog SET synced=1 WHERE id = $1"
 
+// A signing log that conflicts with the cloud, as the cloud has its serial number and revision with
+// another device-key, is kept in the factory but is not sent again
+const syncSigningLogConflictSQLite = "UPDATE signinglog SET synced=2 WHERE id = $1"
+
 // SigningLog holds the details of the serial number and public key fingerprint that were supplied
 // in a serial assertion for signing. The details are stored in the local database,
 type SigningLog struct {
-	ID           int       `json:"id"`
-	Make         string    `json:"make"`
-	Model        string    `json:"model"`
-	SerialNumber string    `json:"serialnumber"`
-	Fingerprint  string    `json:"fingerprint"`
-	Created      time.Time `json:"created"`
-	Revision     int       `json:"revision"`
-	Synced       int       `json:"synced"`
-	Total        int
+	ID              int       `json:"id"`
+	Make            string    `json:"make"`
+	Model           string    `json:"model"`
+	SerialNumber    string    `json:"serialnumber"`
+	Fingerprint     string    `json:"fingerprint"`
+	Created         time.Time `json:"created"`
+	Revision        int       `json:"revision"`
+	Synced          int       `json:"synced"`
+	TimestampSource string    `json:"timestampsource"` // time source of the assertion timestamp
+	SourceIP        string    `json:"sourceip"`        // address of the host that requested the signing
+	APIKeyID        string    `json:"apikeyid"`        // identifier of the model API key, not the key itself
+	RequestID       string    `json:"requestid"`       // request-id nonce of the serial-request
+	UserAgent       string    `json:"useragent"`
+	TimestampToken  []byte    `json:"timestamptoken,omitempty"` // DER time-stamp token of the TSA source
+	Total           int
 }
 
 // SigningLogFilters holds the values of the filters for the searchable columns
@@ -112,60 +169,140 @@ type SigningLogFilters struct {
 	Models []string `json:"models"`
 }
 
+// APIKeyID returns an identifier of the model API key for the signing log, so the
+// signings can be traced to an API key without storing the key itself
+func APIKeyID(apiKey string) string {
+	if len(apiKey) == 0 {
+		return ""
+	}
+	hash := sha256.Sum256([]byte(apiKey))
+	return hex.EncodeToString(hash[:])[:16]
+}
+
 // CreateSigningLogTable creates the database table for a signing log with its indexes.
-func (db *DB) CreateSigningLogTable() error {
-	_, err := db.Exec(createSigningLogTableSQL)
+// The table is partitioned by month on Postgres, but not on the factory database or on CockroachDB
+func (db *DB) CreateSigningLogTable(ctx context.Context) error {
+	if InFactory() || InCockroach() {
+		return db.createSigningLogTableUnpartitioned(ctx)
+	}
+	return db.createSigningLogPartitionedTable(ctx)
+}
+
+func (db *DB) createSigningLogTableUnpartitioned(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createSigningLogTableSQL)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(createSigningLogSerialNumberIndexSQL)
+	_, err = db.ExecContext(ctx, createSigningLogSerialNumberIndexSQL)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(createSigningLogCreatedIndexSQL)
+	_, err = db.ExecContext(ctx, createSigningLogCreatedIndexSQL)
 	if err != nil {
 		return err
 	}
-	_, err = db.Exec(createSigningLogFingerprintIndexSQL)
+	_, err = db.ExecContext(ctx, createSigningLogFingerprintIndexSQL)
 	if err != nil {
 		return err
 	}
 
+	db.alterSigningLogTable(ctx)
+
+	if InFactory() || InCockroach() {
+		return nil
+	}
+	return db.createSigningLogSearchIndexes(ctx)
+}
+
+// alterSigningLogTable adds the columns that are missing from an existing signing log table. The
+// columns of a partitioned table are added to each of its partitions
+func (db *DB) alterSigningLogTable(ctx context.Context) {
 	// Ignoring the error when adding the column
-	db.Exec(alterSigningLogAddRevisionSQL)
-	db.Exec(alterSigningLogAddSyncedSQL)
+	db.ExecContext(ctx, alterSigningLogAddRevisionSQL)
+	db.ExecContext(ctx, alterSigningLogAddSyncedSQL)
+	db.ExecContext(ctx, alterSigningLogAddTimestampSourceSQL)
+	db.ExecContext(ctx, alterSigningLogAddSourceIPSQL)
+	db.ExecContext(ctx, alterSigningLogAddAPIKeyIDSQL)
+	db.ExecContext(ctx, alterSigningLogAddRequestIDSQL)
+	db.ExecContext(ctx, alterSigningLogAddUserAgentSQL)
+	db.ExecContext(ctx, alterSigningLogAddTimestampTokenSQL)
+}
+
+// createSigningLogSearchIndexes creates the trigram indexes of the signing log search
+func (db *DB) createSigningLogSearchIndexes(ctx context.Context) error {
+	if !db.createTrigramExtension(ctx) {
+		return nil
+	}
 
+	for _, index := range signingLogTrigramIndexes {
+		if err := db.createTrigramIndex(ctx, index.name, "signinglog", index.column); err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+// createTrigramExtension checks that the pg_trgm extension is available. The search still works
+// without it, e.g. when the database user cannot create it, but it scans the table
+func (db *DB) createTrigramExtension(ctx context.Context) bool {
+	if _, err := db.ExecContext(ctx, createTrigramExtensionSQL); err != nil {
+		log.Errorf("The pg_trgm extension is not available, so the signing log search is not indexed: %v", err)
+		return false
+	}
+	return true
+}
+
+// createTrigramIndex builds the trigram index of the column concurrently, dropping the index
+// that was left invalid by a build that failed
+func (db *DB) createTrigramIndex(ctx context.Context, name, table, column string) error {
+	invalid, err := db.queryBool(ctx, invalidIndexSQL, name)
+	if err != nil {
+		return err
+	}
+	if invalid {
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(dropIndexSQL, name)); err != nil {
+			return err
+		}
+	}
+
+	_, err = db.ExecContext(ctx, fmt.Sprintf(createTrigramIndexSQL, name, table, column))
+	return err
+}
+
 // CheckForDuplicate verifies that the serial number and the device-key fingerprint have not be used previously.
 // If a duplicate serial number does exist, it returns the maximum revision number for the serial number.
-func (db *DB) CheckForDuplicate(signLog *SigningLog) (bool, int, error) {
+func (db *DB) CheckForDuplicate(ctx context.Context, signLog *SigningLog) (bool, int, error) {
+	// The buffered logs are checked first, as they are removed from the buffer once they are written
+	pendingDuplicate, pendingRevision := db.logBuffer.duplicate(*signLog)
+
 	var duplicateExists bool
 	var maxRevision int
-	err := db.QueryRow(findExistingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint).Scan(&duplicateExists)
+	err := db.QueryRowContext(ctx, findExistingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint).Scan(&duplicateExists)
 	if err != nil {
-		log.Printf("Error checking signinglog for duplicate: %v\n", err)
+		log.Errorf("Error checking signinglog for duplicate: %v", err)
 		return false, 0, errors.New("Error communicating with the database")
 	}
 
 	// If we do have a duplicate, we need to find the maximum revision number
-	err = db.QueryRow(findMaxRevisionSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber).Scan(&maxRevision)
+	err = db.QueryRowContext(ctx, findMaxRevisionSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber).Scan(&maxRevision)
 	if err != nil {
-		log.Printf("Error checking signinglog for maximum revision number of the serial: %v\n", err)
+		log.Errorf("Error checking signinglog for maximum revision number of the serial: %v", err)
 		return false, 0, errors.New("Error communicating with the database")
 	}
 
-	return duplicateExists, maxRevision, nil
+	if pendingRevision > maxRevision {
+		maxRevision = pendingRevision
+	}
+	return duplicateExists || pendingDuplicate, maxRevision, nil
 }
 
 // CheckForMatching checks to see if a matching signing-log entry exists
 // (same brand, model, serial number and revision)
-func (db *DB) CheckForMatching(signLog SigningLog) (bool, error) {
+func (db *DB) CheckForMatching(ctx context.Context, signLog SigningLog) (bool, error) {
 	var duplicateExists bool
-	err := db.QueryRow(findMatchingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Revision).Scan(&duplicateExists)
+	err := db.QueryRowContext(ctx, findMatchingSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Revision).Scan(&duplicateExists)
 	if err != nil {
-		log.Printf("Error checking signinglog for matching record: %v\n", err)
+		log.Errorf("Error checking signinglog for matching record: %v", err)
 		return false, errors.New("Error communicating with the database")
 	}
 
@@ -173,31 +310,48 @@ func (db *DB) CheckForMatching(signLog SigningLog) (bool, error) {
 }
 
 // CreateSigningLog logs that a specific serial number has been used, along with the device-key fingerprint.
-func (db *DB) CreateSigningLog(signLog SigningLog) error {
-	var err error
+// The log is written in the background when the write-behind buffer is started
+func (db *DB) CreateSigningLog(ctx context.Context, signLog SigningLog) error {
 	// Validate the data
 	if !validateStringsNotEmpty(signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint) {
 		return errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
 	}
 
+	if db.logBuffer.add(signLog) {
+		return nil
+	}
+	return db.createSigningLog(ctx, signLog)
+}
+
+func (db *DB) createSigningLog(ctx context.Context, signLog SigningLog) error {
+	var err error
+
 	// Create the signing log in the database
 	if InFactory() {
 		// Need to generate our own ID
 		var nextID int
-		err = db.QueryRow(maxIDSigningLogSQLite).Scan(&nextID)
+		err = db.QueryRowContext(ctx, maxIDSigningLogSQLite).Scan(&nextID)
 		if err != nil {
-			log.Printf("Error retrieving next signing-log ID: %v\n", err)
+			log.Errorf("Error retrieving next signing-log ID: %v", err)
 			return err
 		}
 
-		_, err = db.Exec(createSigningLogSQLite, nextID, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision)
+		_, err = db.ExecContext(ctx, createSigningLogSQLite, nextID, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, timestampSourceOrDefault(signLog.TimestampSource),
+			signLog.SourceIP, signLog.APIKeyID, signLog.RequestID, signLog.UserAgent, signLog.TimestampToken)
 	} else {
-		_, err = db.Exec(createSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision)
+		err = db.transaction(ctx, func(tx *sql.Tx) error {
+			if err := lockAuditRecord(ctx, tx); err != nil {
+				return err
+			}
+			_, err := tx.ExecContext(ctx, createSigningLogSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, timestampSourceOrDefault(signLog.TimestampSource),
+				signLog.SourceIP, signLog.APIKeyID, signLog.RequestID, signLog.UserAgent, signLog.TimestampToken)
+			return err
+		})
 	}
 
 	// Create the log in the database
 	if err != nil {
-		log.Printf("Error creating the signing log: %v\n", err)
+		log.Errorf("Error creating the signing log: %v", err)
 		return err
 	}
 
@@ -205,49 +359,202 @@ func (db *DB) CreateSigningLog(signLog SigningLog) error {
 }
 
 // CreateSigningLogSync logs that a specific serial number has been used, along with the device-key fingerprint.
-func (db *DB) CreateSigningLogSync(signLog SigningLog) error {
+func (db *DB) CreateSigningLogSync(ctx context.Context, signLog SigningLog) error {
 	var err error
 	// Validate the data
 	if !validateStringsNotEmpty(signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint) {
 		return errors.New("The Make, Model, Serial Number and device-key Fingerprint must be supplied")
 	}
 
-	// Create the signing log in the database
-	_, err = db.Exec(createSigningLogSyncSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, signLog.Created)
+	// Create the signing log in the database. The devices signed by the factories are counted
+	// in the quota of the brand
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		if err := lockAuditRecord(ctx, tx); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, createSigningLogSyncSQL, signLog.Make, signLog.Model, signLog.SerialNumber, signLog.Fingerprint, signLog.Revision, signLog.Created, timestampSourceOrDefault(signLog.TimestampSource),
+			signLog.SourceIP, signLog.APIKeyID, signLog.RequestID, signLog.UserAgent, signLog.TimestampToken)
+		if err != nil {
+			log.Errorf("Error creating the signing log: %v", err)
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, countAccountQuotaSyncSQL, signLog.Make); err != nil {
+			return fmt.Errorf("error updating the account quota: %v", err)
+		}
+		return nil
+	})
+}
+
+// timestampSourceOrDefault defaults the time source for signing logs that do not record it
+func timestampSourceOrDefault(source string) string {
+	if len(source) == 0 {
+		return "system"
+	}
+	return source
+}
+
+// keyset returns the ID to list the signing logs from, and the page size
+func (page SigningLogPage) keyset() (int, int, error) {
+	limit := page.Limit
+	switch {
+	case limit <= 0:
+		limit = ListSigningLogDefaultLimit
+	case limit > ListSigningLogMaxLimit:
+		limit = ListSigningLogMaxLimit
+	}
+
+	if len(page.Token) == 0 {
+		return MaxFromID, limit, nil
+	}
+
+	fromID, err := decodePageToken(page.Token)
 	if err != nil {
-		log.Printf("Error creating the signing log: %v\n", err)
-		return err
+		return 0, 0, err
 	}
+	return fromID, limit, nil
+}
 
-	return nil
+// encodePageToken creates the opaque next-page token from the last ID of the page
+func encodePageToken(id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(id)))
 }
 
-func (db *DB) listAllSigningLog() ([]SigningLog, error) {
-	return db.listSigningLogFilteredByUser(anyUserFilter)
+func decodePageToken(token string) (int, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return 0, errors.New("invalid page token")
+	}
+	id, err := strconv.Atoi(string(decoded))
+	if err != nil || id <= 0 || id > MaxFromID {
+		return 0, errors.New("invalid page token")
+	}
+	return id, nil
 }
 
-func (db *DB) listSigningLogFilteredByUser(username string) ([]SigningLog, error) {
-	signingLogs := []SigningLog{}
+// nextSigningLogPage trims the logs to the page size and returns the next-page token.
+// The logs are fetched with one extra record to know if there is a next page
+func nextSigningLogPage(logs []SigningLog, limit int) ([]SigningLog, string) {
+	if len(logs) <= limit {
+		return logs, ""
+	}
+	logs = logs[:limit]
+	return logs, encodePageToken(logs[limit-1].ID)
+}
 
-	var (
-		rows *sql.Rows
-		err  error
-	)
+func (db *DB) listAllSigningLog(ctx context.Context, fromID, limit int, filter SigningLogFilter) ([]SigningLog, error) {
+	return db.listSigningLogFilteredByUser(ctx, anyUserFilter, fromID, limit, filter)
+}
 
-	if len(username) == 0 {
-		rows, err = db.Query(listSigningLogSQL, MaxFromID)
-	} else {
-		rows, err = db.Query(listSigningLogForUserSQL, MaxFromID, username)
+func (db *DB) listSigningLogFilteredByUser(ctx context.Context, username string, fromID, limit int, filter SigningLogFilter) ([]SigningLog, error) {
+	listSQL := listSigningLogSQLBuilder(fromID, limit, filter)
+
+	if username != "" {
+		listSQL = listSQL.Where(userSigningLogFilter(username))
 	}
+
+	return db.querySigningLog(ctx, listSQL)
+}
+
+// userSigningLogFilter restricts the signing logs to the accounts of the user
+func userSigningLogFilter(username string) sq.Sqlizer {
+	return sq.Expr(`EXISTS (
+			SELECT * FROM account acc
+			INNER JOIN useraccountlink ua on ua.account_id=acc.id
+			INNER JOIN userinfo u on ua.user_id=u.id
+			WHERE acc.authority_id=s.make AND u.username=?`+accountRoleSyncUserSQL+`)`, username)
+}
+
+// listSigningLogSQLBuilder creates the query for a page of the signing logs, with the optional filters
+func listSigningLogSQLBuilder(fromID, limit int, filter SigningLogFilter) sq.SelectBuilder {
+	sql := sq.
+		Select("s.*").
+		From("signinglog s").
+		Where(sq.Lt{"s.id": fromID}).
+		OrderBy("s.id DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Dollar)
+
+	return filterSigningLogSQL(sql, filter)
+}
+
+// streamSigningLogSQLBuilder creates the query for all the signing logs, with the optional filters
+func streamSigningLogSQLBuilder(filter SigningLogFilter) sq.SelectBuilder {
+	sql := sq.
+		Select("s.*").
+		From("signinglog s").
+		OrderBy("s.id DESC").
+		PlaceholderFormat(sq.Dollar)
+
+	return filterSigningLogSQL(sql, filter)
+}
+
+// filterSigningLogSQL adds the optional filters to a signing log query
+func filterSigningLogSQL(sql sq.SelectBuilder, filter SigningLogFilter) sq.SelectBuilder {
+
+	if !filter.From.IsZero() {
+		sql = sql.Where(sq.GtOrEq{"s.created": filter.From})
+	}
+	if !filter.To.IsZero() {
+		sql = sql.Where(sq.Lt{"s.created": filter.To})
+	}
+	if filter.Model != "" {
+		sql = sql.Where(sq.Eq{"s.model": filter.Model})
+	}
+	if filter.Serial != "" {
+		sql = sql.Where(containsLike("s.serial_number", "LIKE", filter.Serial))
+	}
+	if filter.Fingerprint != "" {
+		sql = sql.Where(sq.Eq{"s.fingerprint": filter.Fingerprint})
+	}
+	if filter.Search != "" {
+		sql = sql.Where(sq.Or{containsLike("s.serial_number", "ILIKE", filter.Search), containsLike("s.fingerprint", "ILIKE", filter.Search)})
+	}
+	if filter.KeyID != "" {
+		// The signing-key of the original model, or of the sub-store model for a pivoted device
+		sql = sql.Where(sq.Expr(`EXISTS (
+			SELECT * FROM model m
+			INNER JOIN keypair k ON k.id=m.keypair_id
+			LEFT JOIN substore ss ON ss.from_model_id=m.id AND s.serial_number IN (ss.serial_number, ss.serial_hash)
+			WHERE m.brand_id=s.make AND (m.name=s.model OR ss.model_name=s.model) AND k.key_id=?)`, filter.KeyID))
+	}
+
+	return sql
+}
+
+// fingerprintDevicesSQLBuilder creates the query for the devices signed with a device-key fingerprint.
+// The signing logs are grouped by device, so each device is returned once with its latest revision
+func fingerprintDevicesSQLBuilder(fingerprint string, limit int) sq.SelectBuilder {
+	return sq.
+		Select("s.make", "s.model", "s.serial_number", "MAX(s.revision)").
+		From("signinglog s").
+		Where(sq.Eq{"s.fingerprint": fingerprint}).
+		GroupBy("s.make", "s.model", "s.serial_number").
+		OrderBy("MAX(s.id) DESC").
+		Limit(uint64(limit)).
+		PlaceholderFormat(sq.Dollar)
+}
+
+// containsLike creates the LIKE (or ILIKE) condition of a column that contains the value. The
+// wildcards of the value are escaped, and the escape character is explicit, as SQLite has no default
+func containsLike(column, operator, value string) sq.Sqlizer {
+	return sq.Expr(fmt.Sprintf(`%s %s ? ESCAPE '\'`, column, operator), "%"+likeReplacer.Replace(value)+"%")
+}
+
+var likeReplacer = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (db *DB) querySigningLog(ctx context.Context, listSQL sq.SelectBuilder) ([]SigningLog, error) {
+	signingLogs := []SigningLog{}
+
+	rows, err := listSQL.RunWith(db).QueryContext(ctx)
 	if err != nil {
-		log.Printf("Error retrieving signing logs: %v\n", err)
+		log.Errorf("Error retrieving signing logs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		signingLog := SigningLog{}
-		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced)
+		signingLog, err := scanSigningLog(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -257,8 +564,59 @@ func (db *DB) listSigningLogFilteredByUser(username string) ([]SigningLog, error
 	return signingLogs, nil
 }
 
-func (db *DB) listAllSigningLogForAccount(authorityID string, params *SigningLogParams) ([]SigningLog, error) {
-	return db.listSigningLogForAccountFilteredByUser(anyUserFilter, authorityID, params)
+func (db *DB) queryFingerprintDevices(ctx context.Context, listSQL sq.SelectBuilder, fingerprint string) ([]Device, error) {
+	devices := []Device{}
+
+	rows, err := listSQL.RunWith(db).QueryContext(ctx)
+	if err != nil {
+		log.Errorf("Error retrieving the devices of a fingerprint: %v", err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		device := Device{Fingerprint: fingerprint}
+		if err := rows.Scan(&device.Brand, &device.Model, &device.SerialNumber, &device.Revision); err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, rows.Err()
+}
+
+// streamSigningLog calls the function for each signing log as it is read from the database,
+// so the full result is never held in memory. An error from the function stops the stream
+func (db *DB) streamSigningLog(ctx context.Context, listSQL sq.SelectBuilder, fn func(SigningLog) error) error {
+	rows, err := listSQL.RunWith(db).QueryContext(ctx)
+	if err != nil {
+		log.Errorf("Error retrieving signing logs: %v", err)
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		signingLog, err := scanSigningLog(rows)
+		if err != nil {
+			return err
+		}
+		if err = fn(signingLog); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+func scanSigningLog(rows *sql.Rows) (SigningLog, error) {
+	signingLog := SigningLog{}
+	err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced, &signingLog.TimestampSource,
+		&signingLog.SourceIP, &signingLog.APIKeyID, &signingLog.RequestID, &signingLog.UserAgent, &signingLog.TimestampToken)
+	return signingLog, err
+}
+
+func (db *DB) listAllSigningLogForAccount(ctx context.Context, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+	return db.listSigningLogForAccountFilteredByUser(ctx, anyUserFilter, authorityID, params)
 }
 
 func signingLogSQLBuilder(username, authorityID string, params *SigningLogParams) sq.SelectBuilder {
@@ -280,7 +638,7 @@ func signingLogSQLBuilder(username, authorityID string, params *SigningLogParams
 			From("account acc").
 			JoinClause("INNER JOIN useraccountlink ua on ua.account_id=acc.id").
 			JoinClause("INNER JOIN userinfo u on ua.user_id=u.id").
-			Where("acc.authority_id=s.make AND u.username=?", username).
+			Where("acc.authority_id=s.make AND u.username=?"+accountRoleSyncUserSQL, username).
 			Suffix(")").PlaceholderFormat(sq.Dollar)
 
 		sql = sql.Where(nestedBuilder)
@@ -296,13 +654,16 @@ func signingLogSQLBuilder(username, authorityID string, params *SigningLogParams
 	return sql
 }
 
-func (db *DB) listSigningLogForAccountFilteredByUser(username, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+func (db *DB) listSigningLogForAccountFilteredByUser(ctx context.Context, username, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+	return db.querySigningLogForAccount(ctx, signingLogSQLBuilder(username, authorityID, params))
+}
+
+func (db *DB) querySigningLogForAccount(ctx context.Context, listSQL sq.SelectBuilder) ([]SigningLog, error) {
 	signingLogs := []SigningLog{}
 
-	listSQL := signingLogSQLBuilder(username, authorityID, params)
-	rows, err := listSQL.RunWith(db).Query()
+	rows, err := listSQL.RunWith(db).QueryContext(ctx)
 	if err != nil {
-		log.Printf("Error retrieving signing logs: %v\n", err)
+		log.Errorf("Error retrieving signing logs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -311,9 +672,10 @@ func (db *DB) listSigningLogForAccountFilteredByUser(username, authorityID strin
 		signingLog := SigningLog{}
 		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model,
 			&signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created,
-			&signingLog.Revision, &signingLog.Synced, &signingLog.Total)
+			&signingLog.Revision, &signingLog.Synced, &signingLog.TimestampSource,
+			&signingLog.SourceIP, &signingLog.APIKeyID, &signingLog.RequestID, &signingLog.UserAgent, &signingLog.TimestampToken, &signingLog.Total)
 		if err != nil {
-			log.Printf("Error retrieving signing logs: %v\n", err)
+			log.Errorf("Error retrieving signing logs: %v", err)
 			return nil, err
 		}
 		signingLogs = append(signingLogs, signingLog)
@@ -322,11 +684,11 @@ func (db *DB) listSigningLogForAccountFilteredByUser(username, authorityID strin
 	return signingLogs, nil
 }
 
-func (db *DB) allSigningLogFilterValues(authorityID string) (SigningLogFilters, error) {
-	return db.signingLogFilterValuesFilteredByUser(anyUserFilter, authorityID)
+func (db *DB) allSigningLogFilterValues(ctx context.Context, authorityID string) (SigningLogFilters, error) {
+	return db.signingLogFilterValuesFilteredByUser(ctx, anyUserFilter, authorityID)
 }
 
-func (db *DB) signingLogFilterValuesFilteredByUser(username, authorityID string) (SigningLogFilters, error) {
+func (db *DB) signingLogFilterValuesFilteredByUser(ctx context.Context, username, authorityID string) (SigningLogFilters, error) {
 	filters := SigningLogFilters{}
 
 	var modelsSQL string
@@ -337,16 +699,16 @@ func (db *DB) signingLogFilterValuesFilteredByUser(username, authorityID string)
 		modelsSQL = filterValuesModelSigningLogForUserSQL
 	}
 
-	err := db.filterValuesForField(username, modelsSQL, authorityID, &filters.Models)
+	err := db.filterValuesForField(ctx, username, modelsSQL, authorityID, &filters.Models)
 	if err != nil {
-		log.Printf("Error retrieving filter values: %v\n", err)
+		log.Errorf("Error retrieving filter values: %v", err)
 		return filters, err
 	}
 
 	return filters, nil
 }
 
-func (db *DB) filterValuesForField(username, sqlQuery, authorityID string, fieldValues *[]string) error {
+func (db *DB) filterValuesForField(ctx context.Context, username, sqlQuery, authorityID string, fieldValues *[]string) error {
 
 	var (
 		rows *sql.Rows
@@ -355,13 +717,13 @@ func (db *DB) filterValuesForField(username, sqlQuery, authorityID string, field
 	values := []string{}
 
 	if len(username) == 0 {
-		rows, err = db.Query(sqlQuery, authorityID)
+		rows, err = db.QueryContext(ctx, sqlQuery, authorityID)
 	} else {
-		rows, err = db.Query(sqlQuery, username, authorityID)
+		rows, err = db.QueryContext(ctx, sqlQuery, username, authorityID)
 	}
 
 	if err != nil {
-		log.Printf("Error retrieving filter values: %v\n", err)
+		log.Errorf("Error retrieving filter values: %v", err)
 		return err
 	}
 	defer rows.Close()
@@ -381,19 +743,18 @@ func (db *DB) filterValuesForField(username, sqlQuery, authorityID string, field
 }
 
 // SyncSigningLog fetches the factory signing logs to sync with the cloud
-func (db *DB) SyncSigningLog() ([]SigningLog, error) {
+func (db *DB) SyncSigningLog(ctx context.Context) ([]SigningLog, error) {
 	signingLogs := []SigningLog{}
 
-	rows, err := db.Query(syncSigningLogSQLite)
+	rows, err := db.QueryContext(ctx, syncSigningLogSQLite)
 	if err != nil {
-		log.Printf("Error retrieving signing logs: %v\n", err)
+		log.Errorf("Error retrieving signing logs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		signingLog := SigningLog{}
-		err := rows.Scan(&signingLog.ID, &signingLog.Make, &signingLog.Model, &signingLog.SerialNumber, &signingLog.Fingerprint, &signingLog.Created, &signingLog.Revision, &signingLog.Synced)
+		signingLog, err := scanSigningLog(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -404,7 +765,13 @@ func (db *DB) SyncSigningLog() ([]SigningLog, error) {
 }
 
 // SyncUpdateSigningLog updates the synced status of a signing log
-func (db *DB) SyncUpdateSigningLog(id int) error {
-	_, err := db.Exec(syncSigningLogUpdateSQLite, id)
+func (db *DB) SyncUpdateSigningLog(ctx context.Context, id int) error {
+	_, err := db.ExecContext(ctx, syncSigningLogUpdateSQLite, id)
+	return err
+}
+
+// SyncConflictSigningLog marks a signing log that conflicts with the cloud, so it is not synced again
+func (db *DB) SyncConflictSigningLog(ctx context.Context, id int) error {
+	_, err := db.ExecContext(ctx, syncSigningLogConflictSQLite, id)
 	return err
 }
@@ -20,8 +20,11 @@
 package datastore
 
 import (
+	"context"
 	"testing"
+	"time"
 
+	sq "github.com/Masterminds/squirrel"
 	check "gopkg.in/check.v1"
 )
 
@@ -98,7 +101,7 @@ func (vs *sqlSuite) TestSigningLogSQLBuilder(c *check.C) {
 			authorityID: "admin",
 			username:    "bob",
 			params:      &SigningLogParams{},
-			wantSQL:     `SELECT *, count(*) OVER() AS total_count FROM signinglog s WHERE id < $1 AND make=$2 AND EXISTS ( SELECT * FROM account acc INNER JOIN useraccountlink ua on ua.account_id=acc.id INNER JOIN userinfo u on ua.user_id=u.id WHERE acc.authority_id=s.make AND u.username=$3 ) ORDER BY id DESC OFFSET 0`,
+			wantSQL:     `SELECT *, count(*) OVER() AS total_count FROM signinglog s WHERE id < $1 AND make=$2 AND EXISTS ( SELECT * FROM account acc INNER JOIN useraccountlink ua on ua.account_id=acc.id INNER JOIN userinfo u on ua.user_id=u.id WHERE acc.authority_id=s.make AND u.username=$3 and coalesce(ua.role, u.userrole) >= 150 ) ORDER BY id DESC OFFSET 0`,
 			wantParams:  []interface{}{2147483647, "admin", "bob"},
 		},
 		{
@@ -120,3 +123,190 @@ func (vs *sqlSuite) TestSigningLogSQLBuilder(c *check.C) {
 		c.Assert(args, check.DeepEquals, tt.wantParams)
 	}
 }
+
+func (vs *sqlSuite) TestSigningLogPageKeyset(c *check.C) {
+	tests := []struct {
+		page   SigningLogPage
+		fromID int
+		limit  int
+		valid  bool
+	}{
+		{SigningLogPage{}, MaxFromID, ListSigningLogDefaultLimit, true},
+		{SigningLogPage{Limit: 10}, MaxFromID, 10, true},
+		{SigningLogPage{Limit: 5000}, MaxFromID, ListSigningLogMaxLimit, true},
+		{SigningLogPage{Token: encodePageToken(123), Limit: 10}, 123, 10, true},
+		{SigningLogPage{Token: "invalid!"}, 0, 0, false},
+		{SigningLogPage{Token: encodePageToken(-1)}, 0, 0, false},
+	}
+
+	for _, t := range tests {
+		fromID, limit, err := t.page.keyset()
+		c.Assert(err == nil, check.Equals, t.valid)
+		c.Assert(fromID, check.Equals, t.fromID)
+		c.Assert(limit, check.Equals, t.limit)
+	}
+}
+
+func (vs *sqlSuite) TestNextSigningLogPage(c *check.C) {
+	logs := []SigningLog{{ID: 9}, {ID: 7}, {ID: 4}}
+
+	page, next := nextSigningLogPage(logs, 3)
+	c.Assert(page, check.HasLen, 3)
+	c.Assert(next, check.Equals, "")
+
+	page, next = nextSigningLogPage(logs, 2)
+	c.Assert(page, check.HasLen, 2)
+	id, err := decodePageToken(next)
+	c.Assert(err, check.IsNil)
+	c.Assert(id, check.Equals, 7)
+}
+
+func (vs *sqlSuite) TestListSigningLogSQLBuilder(c *check.C) {
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		filter     SigningLogFilter
+		wantSQL    string
+		wantParams []interface{}
+	}{
+		{
+			filter:     SigningLogFilter{},
+			wantSQL:    "SELECT s.* FROM signinglog s WHERE s.id < $1 ORDER BY s.id DESC LIMIT 51",
+			wantParams: []interface{}{MaxFromID},
+		},
+		{
+			filter:     SigningLogFilter{From: from, To: to},
+			wantSQL:    "SELECT s.* FROM signinglog s WHERE s.id < $1 AND s.created >= $2 AND s.created < $3 ORDER BY s.id DESC LIMIT 51",
+			wantParams: []interface{}{MaxFromID, from, to},
+		},
+		{
+			filter:     SigningLogFilter{Model: "alder", Serial: "R1_%", Fingerprint: "abc"},
+			wantSQL:    "SELECT s.* FROM signinglog s WHERE s.id < $1 AND s.model = $2 AND s.serial_number LIKE $3 ESCAPE '\\' AND s.fingerprint = $4 ORDER BY s.id DESC LIMIT 51",
+			wantParams: []interface{}{MaxFromID, "alder", `%R1\_\%%`, "abc"},
+		},
+		{
+			filter:     SigningLogFilter{Model: "alder", Search: "ab_c"},
+			wantSQL:    "SELECT s.* FROM signinglog s WHERE s.id < $1 AND s.model = $2 AND (s.serial_number ILIKE $3 ESCAPE '\\' OR s.fingerprint ILIKE $4 ESCAPE '\\') ORDER BY s.id DESC LIMIT 51",
+			wantParams: []interface{}{MaxFromID, "alder", `%ab\_c%`, `%ab\_c%`},
+		},
+	}
+
+	for _, t := range tests {
+		sql, params, err := listSigningLogSQLBuilder(MaxFromID, 51, t.filter).ToSql()
+		c.Assert(err, check.IsNil)
+		c.Assert(sql, check.Equals, t.wantSQL)
+		c.Assert(params, check.DeepEquals, t.wantParams)
+	}
+
+	sql, params, err := listSigningLogSQLBuilder(MaxFromID, 51, SigningLogFilter{KeyID: "key1"}).ToSql()
+	c.Assert(err, check.IsNil)
+	c.Assert(sql, check.Matches, "(?s).*EXISTS \\(.*k.key_id=\\$2\\).*")
+	c.Assert(params, check.DeepEquals, []interface{}{MaxFromID, "key1"})
+}
+
+func (vs *sqlSuite) TestStreamSigningLogSQLBuilder(c *check.C) {
+	sql, params, err := streamSigningLogSQLBuilder(SigningLogFilter{}).ToSql()
+	c.Assert(err, check.IsNil)
+	c.Assert(sql, check.Equals, "SELECT s.* FROM signinglog s ORDER BY s.id DESC")
+	c.Assert(params, check.HasLen, 0)
+
+	sql, params, err = streamSigningLogSQLBuilder(SigningLogFilter{Model: "alder", Fingerprint: "abc"}).
+		Where(userSigningLogFilter("sv")).ToSql()
+	c.Assert(err, check.IsNil)
+	c.Assert(sql, check.Matches, "(?s)SELECT s.\\* FROM signinglog s WHERE s.model = \\$1 AND s.fingerprint = \\$2 AND EXISTS \\(.*u.username=\\$3 and coalesce\\(ua.role, u.userrole\\) >= 150\\) ORDER BY s.id DESC")
+	c.Assert(params, check.DeepEquals, []interface{}{"alder", "abc", "sv"})
+}
+
+func (vs *sqlSuite) TestFingerprintSigningLogSQLBuilder(c *check.C) {
+	sql, params, err := streamSigningLogSQLBuilder(SigningLogFilter{Fingerprint: "abc"}).Limit(ListSigningLogMaxLimit).ToSql()
+	c.Assert(err, check.IsNil)
+	c.Assert(sql, check.Equals, "SELECT s.* FROM signinglog s WHERE s.fingerprint = $1 ORDER BY s.id DESC LIMIT 1000")
+	c.Assert(params, check.DeepEquals, []interface{}{"abc"})
+}
+
+func (vs *sqlSuite) TestExportSigningLogSQLBuilder(c *check.C) {
+	sql, params, err := exportSigningLogSQLBuilder(120, 500).Where(sq.LtOrEq{"s.id": 300}).ToSql()
+	c.Assert(err, check.IsNil)
+	c.Assert(sql, check.Equals, "SELECT s.* FROM signinglog s WHERE s.id > $1 AND s.id <= $2 ORDER BY s.id LIMIT 500")
+	c.Assert(params, check.DeepEquals, []interface{}{120, 300})
+}
+
+func (vs *sqlSuite) TestAPIKeyID(c *check.C) {
+	c.Assert(APIKeyID(""), check.Equals, "")
+
+	id := APIKeyID("ValidAPIKey")
+	c.Assert(id, check.HasLen, 16)
+	c.Assert(id, check.Equals, APIKeyID("ValidAPIKey"))
+	c.Assert(id, check.Not(check.Equals), APIKeyID("InbuiltAPIKey"))
+}
+
+func TestSQLiteListDevicesForFingerprint(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, l := range []SigningLog{
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-1", Revision: 1},
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A1", Fingerprint: "fp-1", Revision: 2},
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A2", Fingerprint: "fp-1", Revision: 1},
+		{Make: "alder", Model: "alder-basic", SerialNumber: "A3", Fingerprint: "fp-3", Revision: 1},
+	} {
+		if err := db.CreateSigningLog(ctx, l); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	// Each device is listed once, with the most recently signed first
+	devices, err := db.ListAllowedDevicesForFingerprint(ctx, User{}, "fp-1", 10)
+	if err != nil || len(devices) != 2 {
+		t.Fatalf("ListAllowedDevicesForFingerprint() = %v, %v, want 2 devices", devices, err)
+	}
+	if devices[0].SerialNumber != "A2" || devices[1].SerialNumber != "A1" || devices[1].Revision != 2 {
+		t.Errorf("ListAllowedDevicesForFingerprint() = %v", devices)
+	}
+
+	devices, err = db.ListAllowedDevicesForFingerprint(ctx, User{}, "fp-1", 1)
+	if err != nil || len(devices) != 1 {
+		t.Errorf("ListAllowedDevicesForFingerprint() = %v, %v, want 1 device", devices, err)
+	}
+
+	// The devices are only listed for the accounts of the user
+	devices, err = db.ListAllowedDevicesForFingerprint(ctx, User{Username: "sv", Role: Admin}, "fp-1", 10)
+	if err != nil || len(devices) != 0 {
+		t.Errorf("ListAllowedDevicesForFingerprint() = %v, %v, want no devices", devices, err)
+	}
+}
+
+func TestSQLiteListSigningLogEscapedFilter(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, serial := range []string{"AB_12", "ABX12", "C%1", `D\1`} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "alder-basic", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	// The wildcards of the filters only match themselves
+	tests := []struct {
+		filter SigningLogFilter
+		want   string
+	}{
+		{SigningLogFilter{Serial: "B_1"}, "AB_12"},
+		{SigningLogFilter{Search: "b_1"}, "AB_12"},
+		{SigningLogFilter{Serial: "%"}, "C%1"},
+		{SigningLogFilter{Serial: `\`}, `D\1`},
+	}
+	for _, tt := range tests {
+		logs, _, err := db.ListAllowedSigningLog(ctx, User{}, tt.filter, SigningLogPage{})
+		if err != nil || len(logs) != 1 || logs[0].SerialNumber != tt.want {
+			t.Errorf("ListAllowedSigningLog(%v) = %v, %v, want %s", tt.filter, logs, err, tt.want)
+		}
+	}
+}
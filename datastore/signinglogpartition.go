@@ -0,0 +1,363 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The signing logs are partitioned by month of the created date, so the queries on a date range
+// only scan the partitions of the range. The default partition holds the logs that are outside
+// of the monthly partitions, until the partition of their month is created
+const createSigningLogPartitionedTableSQL = `
+	CREATE TABLE IF NOT EXISTS signinglog (
+		id             %s,
+		make           varchar(200) not null,
+		model          varchar(200) not null,
+		serial_number  varchar(200) not null,
+		fingerprint    varchar(200) not null,
+		created        timestamp not null default current_timestamp,
+		revision       int default 1,
+		synced         int default 0,
+		timestamp_source varchar(200) default 'system',
+		source_ip      varchar(200) not null default '',
+		api_key_id     varchar(200) not null default '',
+		request_id     varchar(200) not null default '',
+		user_agent     text not null default '',
+		timestamp_token bytea,
+		primary key (id, created)
+	) PARTITION BY RANGE (created)
+`
+
+const signingLogNewID = "serial not null"
+const signingLogLegacyID = "int not null default nextval('signinglog_id_seq')"
+
+const createSigningLogDefaultPartitionSQL = "CREATE TABLE IF NOT EXISTS signinglog_default PARTITION OF signinglog DEFAULT"
+
+// The indexes of the partitioned table are created on each of the partitions
+const createSigningLogPartitionedIndexesSQL = `
+	CREATE INDEX IF NOT EXISTS signinglog_serialnumber_idx ON signinglog (make,model,serial_number);
+	CREATE INDEX IF NOT EXISTS signinglog_fingerprint_idx ON signinglog (fingerprint);
+	CREATE INDEX IF NOT EXISTS signinglog_created_idx ON signinglog (created);
+`
+
+// The index of a partitioned table cannot be built concurrently. The trigram index is created on
+// the partitioned table only, and the index of each partition is built concurrently and attached
+// to it. The index of the table is valid once the indexes of all its partitions are attached. The
+// partitions that are attached later get their index from the table
+const createSigningLogPartitionedTrigramIndexSQL = "CREATE INDEX IF NOT EXISTS %s ON ONLY signinglog USING gin (%s gin_trgm_ops)"
+const attachSigningLogPartitionIndexSQL = "ALTER INDEX %s ATTACH PARTITION %s"
+
+const validIndexSQL = `
+	SELECT EXISTS(
+		SELECT * FROM pg_index i
+		INNER JOIN pg_class c ON c.oid=i.indexrelid
+		WHERE c.relname=$1 AND i.indisvalid AND pg_table_is_visible(c.oid))`
+
+const listSigningLogPartitionsSQL = `
+	SELECT c.relname FROM pg_inherits i
+	INNER JOIN pg_class c ON c.oid=i.inhrelid
+	WHERE i.inhparent='signinglog'::regclass
+	ORDER BY c.relname`
+
+// The partition has an index that is attached to the index of the partitioned table
+const partitionIndexAttachedSQL = `
+	SELECT EXISTS(
+		SELECT * FROM pg_inherits i
+		INNER JOIN pg_index x ON x.indexrelid=i.inhrelid
+		WHERE i.inhparent=$1::regclass AND x.indrelid=$2::regclass)`
+
+// The index of the partition with a valid index of the name
+const partitionIndexSQL = `
+	SELECT EXISTS(
+		SELECT * FROM pg_index i
+		INNER JOIN pg_class c ON c.oid=i.indexrelid
+		WHERE c.relname=$1 AND i.indrelid=$2::regclass AND i.indisvalid)`
+
+var signingLogPartitionedTrigramIndexes = []trigramIndex{
+	{name: "signinglog_serialnumber_trgm_idx", column: "serial_number", legacy: "serialnumber_trgm_idx"},
+	{name: "signinglog_fingerprint_trgm_idx", column: "fingerprint", legacy: "fingerprint_trgm_idx"},
+}
+
+const signingLogPartitionedSQL = `
+	SELECT EXISTS(
+		SELECT * FROM pg_partitioned_table p
+		INNER JOIN pg_class c ON c.oid=p.partrelid
+		WHERE c.relname='signinglog' AND pg_table_is_visible(c.oid))`
+
+const signingLogTableExistsSQL = "SELECT to_regclass('signinglog') IS NOT NULL"
+
+const signingLogPartitionExistsSQL = "SELECT to_regclass($1) IS NOT NULL"
+
+// A new partition is filled with the logs of its month from the default partition, before it is attached
+const createSigningLogMonthSQL = "CREATE TABLE %s (LIKE signinglog INCLUDING DEFAULTS)"
+const moveSigningLogMonthSQL = `
+	WITH moved AS (
+		DELETE FROM signinglog_default WHERE created >= $1 AND created < $2
+		RETURNING *
+	)
+	INSERT INTO %s SELECT * FROM moved`
+const attachSigningLogMonthSQL = "ALTER TABLE signinglog ATTACH PARTITION %s FOR VALUES FROM ('%s') TO ('%s')"
+
+// The existing table becomes the partition of the logs before the first monthly partition
+const renameSigningLogLegacySQL = "ALTER TABLE signinglog RENAME TO signinglog_legacy"
+const renameSigningLogLegacyKeySQL = "ALTER TABLE signinglog_legacy RENAME CONSTRAINT signinglog_pkey TO signinglog_legacy_pkey"
+const fillSigningLogLegacyCreatedSQL = "UPDATE signinglog_legacy SET created='epoch' WHERE created IS NULL"
+const notNullSigningLogLegacyCreatedSQL = "ALTER TABLE signinglog_legacy ALTER COLUMN created SET NOT NULL"
+const attachSigningLogLegacySQL = "ALTER TABLE signinglog ATTACH PARTITION signinglog_legacy FOR VALUES FROM (MINVALUE) TO ('%s')"
+const ownSigningLogSequenceSQL = "ALTER SEQUENCE signinglog_id_seq OWNED BY signinglog.id"
+
+// SigningLogPartitionsAhead is the number of monthly partitions that are created ahead of the current month
+const SigningLogPartitionsAhead = 3
+
+const partitionDateFormat = "2006-01-02"
+
+// createSigningLogPartitionedTable creates the partitioned signing log table, with the partitions from the current month
+func (db *DB) createSigningLogPartitionedTable(ctx context.Context) error {
+	exists, err := db.queryBool(ctx, signingLogTableExistsSQL)
+	if err != nil {
+		return err
+	}
+	if exists {
+		partitioned, err := db.queryBool(ctx, signingLogPartitionedSQL)
+		if err != nil {
+			return err
+		}
+		if !partitioned {
+			log.Warning("The signinglog table is not partitioned. Use the 'database partition-signinglog' command to partition it")
+			return db.createSigningLogTableUnpartitioned(ctx)
+		}
+	}
+
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(createSigningLogPartitionedTableSQL, signingLogNewID)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, createSigningLogDefaultPartitionSQL); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, createSigningLogPartitionedIndexesSQL)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	db.alterSigningLogTable(ctx)
+
+	if err = db.createSigningLogPartitionedSearchIndexes(ctx); err != nil {
+		return err
+	}
+
+	_, err = db.CreateSigningLogPartitions(ctx, time.Now().UTC(), SigningLogPartitionsAhead)
+	return err
+}
+
+// createSigningLogPartitionedSearchIndexes creates the trigram indexes of the search on each of the partitions
+func (db *DB) createSigningLogPartitionedSearchIndexes(ctx context.Context) error {
+	if !db.createTrigramExtension(ctx) {
+		return nil
+	}
+
+	for _, index := range signingLogPartitionedTrigramIndexes {
+		valid, err := db.queryBool(ctx, validIndexSQL, index.name)
+		if err != nil {
+			return err
+		}
+		if valid {
+			continue
+		}
+
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(createSigningLogPartitionedTrigramIndexSQL, index.name, index.column)); err != nil {
+			return err
+		}
+		if err := db.attachSigningLogPartitionIndexes(ctx, index); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// attachSigningLogPartitionIndexes builds the index of each partition that does not have one, and attaches it
+func (db *DB) attachSigningLogPartitionIndexes(ctx context.Context, index trigramIndex) error {
+	partitions, err := db.listSigningLogPartitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	for _, partition := range partitions {
+		attached, err := db.queryBool(ctx, partitionIndexAttachedSQL, index.name, partition)
+		if err != nil {
+			return err
+		}
+		if attached {
+			continue
+		}
+
+		// The index of the table that was partitioned is attached, rather than built again
+		legacy, err := db.queryBool(ctx, partitionIndexSQL, index.legacy, partition)
+		if err != nil {
+			return err
+		}
+		name := index.legacy
+		if !legacy {
+			name = partition + strings.TrimPrefix(index.name, "signinglog")
+			if err := db.createTrigramIndex(ctx, name, partition, index.column); err != nil {
+				return err
+			}
+		}
+		if _, err := db.ExecContext(ctx, fmt.Sprintf(attachSigningLogPartitionIndexSQL, index.name, name)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (db *DB) listSigningLogPartitions(ctx context.Context) ([]string, error) {
+	rows, err := db.QueryContext(ctx, listSigningLogPartitionsSQL)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	partitions := []string{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		partitions = append(partitions, name)
+	}
+	return partitions, rows.Err()
+}
+
+// PartitionSigningLogTable converts an existing signing log table to a partitioned table. The existing
+// table is attached as the partition of the logs before the next month, and the monthly partitions are
+// created from the next month. The existing table is scanned to attach it, so this is run once on its own
+func (db *DB) PartitionSigningLogTable(ctx context.Context, now time.Time) error {
+	partitioned, err := db.queryBool(ctx, signingLogPartitionedSQL)
+	if err != nil {
+		return err
+	}
+	if partitioned {
+		return nil
+	}
+
+	next := monthStart(now).AddDate(0, 1, 0)
+
+	err = db.transaction(ctx, func(tx *sql.Tx) error {
+		statements := []string{
+			renameSigningLogLegacySQL,
+			renameSigningLogLegacyKeySQL,
+			fillSigningLogLegacyCreatedSQL,
+			notNullSigningLogLegacyCreatedSQL,
+			fmt.Sprintf(createSigningLogPartitionedTableSQL, signingLogLegacyID),
+			ownSigningLogSequenceSQL,
+			fmt.Sprintf(attachSigningLogLegacySQL, next.Format(partitionDateFormat)),
+			createSigningLogDefaultPartitionSQL,
+			createSigningLogPartitionedIndexesSQL,
+		}
+		for _, s := range statements {
+			if _, err := tx.ExecContext(ctx, s); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error partitioning the signinglog table: %v", err)
+	}
+	if err = db.createSigningLogPartitionedSearchIndexes(ctx); err != nil {
+		return err
+	}
+
+	_, err = db.CreateSigningLogPartitions(ctx, next, SigningLogPartitionsAhead)
+	return err
+}
+
+// CreateSigningLogPartitions creates the monthly partitions of the signing log from the month of the date,
+// and for the months ahead, returning the partitions that were created. Nothing is done when the table is not
+// partitioned, such as on the factory database. This is the maintenance that keeps the partitions ahead of time
+func (db *DB) CreateSigningLogPartitions(ctx context.Context, from time.Time, ahead int) ([]string, error) {
+	if InFactory() || InCockroach() {
+		return nil, nil
+	}
+	partitioned, err := db.queryBool(ctx, signingLogPartitionedSQL)
+	if err != nil || !partitioned {
+		return nil, err
+	}
+
+	created := []string{}
+	start := monthStart(from)
+	for i := 0; i <= ahead; i++ {
+		month := start.AddDate(0, i, 0)
+		name := SigningLogPartitionName(month)
+
+		exists, err := db.queryBool(ctx, signingLogPartitionExistsSQL, name)
+		if err != nil {
+			return created, err
+		}
+		if exists {
+			continue
+		}
+
+		if err = db.createSigningLogPartition(ctx, name, month, month.AddDate(0, 1, 0)); err != nil {
+			return created, fmt.Errorf("error creating the signinglog partition %s: %v", name, err)
+		}
+		created = append(created, name)
+	}
+
+	return created, nil
+}
+
+// createSigningLogPartition creates the partition for the month, moving the logs of the month from the default partition
+func (db *DB) createSigningLogPartition(ctx context.Context, name string, from, to time.Time) error {
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(createSigningLogMonthSQL, name)); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, fmt.Sprintf(moveSigningLogMonthSQL, name), from, to); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, fmt.Sprintf(attachSigningLogMonthSQL, name, from.Format(partitionDateFormat), to.Format(partitionDateFormat)))
+		return err
+	})
+}
+
+// SigningLogPartitionName is the name of the signing log partition for the month of the date
+func SigningLogPartitionName(t time.Time) string {
+	return fmt.Sprintf("signinglog_y%04dm%02d", t.Year(), int(t.Month()))
+}
+
+func monthStart(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+func (db *DB) queryBool(ctx context.Context, query string, args ...interface{}) (bool, error) {
+	var value bool
+	err := db.QueryRowContext(ctx, query, args...).Scan(&value)
+	return value, err
+}
@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// recordingConnector opens the connections of a database that records the statements. The
+// queries return a single row of true, e.g. the signing log table exists and is partitioned
+type recordingConnector struct {
+	statements []string
+}
+
+func (c *recordingConnector) Connect(ctx context.Context) (driver.Conn, error) { return c, nil }
+func (c *recordingConnector) Driver() driver.Driver                            { return nil }
+func (c *recordingConnector) Prepare(query string) (driver.Stmt, error)        { return nil, driver.ErrSkip }
+func (c *recordingConnector) Close() error                                     { return nil }
+func (c *recordingConnector) Begin() (driver.Tx, error)                        { return c, nil }
+func (c *recordingConnector) Commit() error                                    { return nil }
+func (c *recordingConnector) Rollback() error                                  { return nil }
+
+func (c *recordingConnector) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	c.statements = append(c.statements, query)
+	return driver.RowsAffected(0), nil
+}
+
+func (c *recordingConnector) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	return &recordingRows{}, nil
+}
+
+// recordingRows is the single row of true
+type recordingRows struct {
+	done bool
+}
+
+func (r *recordingRows) Columns() []string { return []string{"exists"} }
+func (r *recordingRows) Close() error      { return nil }
+func (r *recordingRows) Next(dest []driver.Value) error {
+	if r.done {
+		return io.EOF
+	}
+	r.done = true
+	dest[0] = true
+	return nil
+}
+
+// openRecordingDatabase opens a Postgres database that records the statements
+func openRecordingDatabase() (*DB, *recordingConnector) {
+	Environ = &Env{Config: config.Settings{Driver: "postgres"}}
+	connector := &recordingConnector{}
+	db := &DB{DB: sql.OpenDB(connector)}
+	Environ.DB = db
+	return db, connector
+}
+
+func TestCreateSigningLogPartitionedTable(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db, connector := openRecordingDatabase()
+	defer db.Close()
+
+	if err := db.CreateSigningLogTable(context.Background()); err != nil {
+		t.Fatalf("CreateSigningLogTable() error = %v", err)
+	}
+
+	// The columns are added to the existing partitioned table
+	for _, alter := range []string{alterSigningLogAddSourceIPSQL, alterSigningLogAddAPIKeyIDSQL, alterSigningLogAddRequestIDSQL, alterSigningLogAddUserAgentSQL, alterSigningLogAddTimestampTokenSQL} {
+		found := false
+		for _, s := range connector.statements {
+			found = found || s == alter
+		}
+		if !found {
+			t.Errorf("CreateSigningLogTable() statements = %v, want %q", connector.statements, alter)
+		}
+	}
+	if !strings.Contains(connector.statements[0], "PARTITION BY RANGE (created)") {
+		t.Errorf("CreateSigningLogTable() first statement = %q, want the partitioned table", connector.statements[0])
+	}
+}
+
+func TestSigningLogPartitionName(t *testing.T) {
+	tests := []struct {
+		date time.Time
+		want string
+	}{
+		{time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC), "signinglog_y2020m01"},
+		{time.Date(2020, time.December, 31, 23, 59, 59, 0, time.UTC), "signinglog_y2020m12"},
+		{time.Date(2021, time.March, 15, 12, 0, 0, 0, time.UTC), "signinglog_y2021m03"},
+	}
+
+	for _, tt := range tests {
+		if got := SigningLogPartitionName(tt.date); got != tt.want {
+			t.Errorf("SigningLogPartitionName(%v) = %v, want %v", tt.date, got, tt.want)
+		}
+	}
+}
+
+func TestMonthStart(t *testing.T) {
+	got := monthStart(time.Date(2020, time.November, 30, 18, 30, 0, 0, time.UTC))
+	if want := time.Date(2020, time.November, 1, 0, 0, 0, 0, time.UTC); !got.Equal(want) {
+		t.Errorf("monthStart() = %v, want %v", got, want)
+	}
+
+	// The month after the end of a month is the next month
+	if got := SigningLogPartitionName(got.AddDate(0, 2, 0)); got != "signinglog_y2021m01" {
+		t.Errorf("Next partition = %v, want signinglog_y2021m01", got)
+	}
+}
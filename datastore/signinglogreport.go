@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	sq "github.com/Masterminds/squirrel"
+)
+
+// Periods of the signing report
+const (
+	ReportPeriodDay  = "day"
+	ReportPeriodWeek = "week" // starting on Monday
+)
+
+// SigningReportRow is the number of devices signed for a model with a signing-key in a period
+type SigningReportRow struct {
+	Period time.Time `json:"period"`
+	Brand  string    `json:"brand"`
+	Model  string    `json:"model"`
+	KeyID  string    `json:"keyid"`
+	Count  int       `json:"count"`
+}
+
+// SigningReport holds the number of devices signed in a date range, grouped by model, signing-key and period
+type SigningReport struct {
+	Period string             `json:"period"`
+	From   time.Time          `json:"from"`
+	To     time.Time          `json:"to"`
+	Rows   []SigningReportRow `json:"rows"`
+	Total  int                `json:"total"`
+}
+
+// AllowedSigningReport returns the report of the signing logs the user is authorized to see
+func (db *DB) AllowedSigningReport(ctx context.Context, authorization User, filter SigningLogFilter, period string) (SigningReport, error) {
+	if err := validateSigningReport(filter, period); err != nil {
+		return SigningReport{}, err
+	}
+
+	reportSQL := signingReportSQLBuilder(filter, period)
+
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser: // All the signing logs
+	case SyncUser:
+		fallthrough
+	case Admin:
+		reportSQL = reportSQL.Where(userSigningLogFilter(authorization.Username))
+	case SubstoreAdmin:
+		reportSQL = reportSQL.Where(substoreUserSigningLogFilter(authorization.Username))
+	default:
+		return SigningReport{Period: period, From: filter.From, To: filter.To, Rows: []SigningReportRow{}}, nil
+	}
+
+	return db.signingReport(ctx, reportSQL, filter, period)
+}
+
+func validateSigningReport(filter SigningLogFilter, period string) error {
+	if period != ReportPeriodDay && period != ReportPeriodWeek {
+		return fmt.Errorf("invalid report period: %s", period)
+	}
+	if filter.From.IsZero() || filter.To.IsZero() {
+		return errors.New("the report needs a date range")
+	}
+	if !filter.To.After(filter.From) {
+		return errors.New("the end of the report must be after the start")
+	}
+	return nil
+}
+
+// signingReportSQLBuilder creates the query of the signing report. The signing-key is from the
+// model of the device, or from the original model for a device pivoted to a sub-store model. The
+// deleted models are joined as well, so the logs of a deleted model still report its signing-key
+func signingReportSQLBuilder(filter SigningLogFilter, period string) sq.SelectBuilder {
+	sql := sq.
+		Select(fmt.Sprintf("date_trunc('%s', s.created) AS period", period),
+			"s.make", "s.model", "COALESCE(k.key_id, kp.key_id, '') AS key_id", "COUNT(*)").
+		From("signinglog s").
+		LeftJoin("model m ON m.brand_id=s.make AND m.name=s.model").
+		LeftJoin("keypair k ON k.id=m.keypair_id").
+		LeftJoin("(substore sp INNER JOIN model fm ON fm.id=sp.from_model_id) ON fm.brand_id=s.make AND sp.model_name=s.model AND s.serial_number IN (sp.serial_number, sp.serial_hash)").
+		LeftJoin("keypair kp ON kp.id=fm.keypair_id").
+		GroupBy("1", "2", "3", "4").
+		OrderBy("1", "2", "3", "4").
+		PlaceholderFormat(sq.Dollar)
+
+	return filterSigningLogSQL(sql, filter)
+}
+
+func (db *DB) signingReport(ctx context.Context, reportSQL sq.SelectBuilder, filter SigningLogFilter, period string) (SigningReport, error) {
+	report := SigningReport{Period: period, From: filter.From, To: filter.To, Rows: []SigningReportRow{}}
+
+	rows, err := reportSQL.RunWith(db).QueryContext(ctx)
+	if err != nil {
+		return report, fmt.Errorf("error retrieving the signing report: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		r := SigningReportRow{}
+		if err := rows.Scan(timestampScanner{&r.Period}, &r.Brand, &r.Model, &r.KeyID, &r.Count); err != nil {
+			return report, fmt.Errorf("error retrieving the signing report: %v", err)
+		}
+		report.Rows = append(report.Rows, r)
+		report.Total += r.Count
+	}
+
+	return report, rows.Err()
+}
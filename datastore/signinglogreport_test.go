@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestValidateSigningReport(t *testing.T) {
+	from := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	tests := []struct {
+		name    string
+		filter  SigningLogFilter
+		period  string
+		wantErr bool
+	}{
+		{"day", SigningLogFilter{From: from, To: to}, ReportPeriodDay, false},
+		{"week", SigningLogFilter{From: from, To: to}, ReportPeriodWeek, false},
+		{"invalid period", SigningLogFilter{From: from, To: to}, "month'", true},
+		{"no start", SigningLogFilter{To: to}, ReportPeriodDay, true},
+		{"no end", SigningLogFilter{From: from}, ReportPeriodDay, true},
+		{"reversed", SigningLogFilter{From: to, To: from}, ReportPeriodDay, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSigningReport(tt.filter, tt.period); (err != nil) != tt.wantErr {
+				t.Errorf("validateSigningReport() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSigningReportSQLBuilder(t *testing.T) {
+	from := time.Date(2020, time.June, 1, 0, 0, 0, 0, time.UTC)
+	to := from.AddDate(0, 1, 0)
+
+	sql, params, err := signingReportSQLBuilder(SigningLogFilter{From: from, To: to, Model: "alder"}, ReportPeriodWeek).
+		Where(userSigningLogFilter("sv")).ToSql()
+	if err != nil {
+		t.Fatalf("signingReportSQLBuilder() error = %v", err)
+	}
+
+	want := `(?s)^SELECT date_trunc\('week', s.created\) AS period, s.make, s.model, COALESCE\(k.key_id, kp.key_id, ''\) AS key_id, COUNT\(\*\) ` +
+		`FROM signinglog s LEFT JOIN .* WHERE s.created >= \$1 AND s.created < \$2 AND s.model = \$3 AND EXISTS \(.*u.username=\$4 and coalesce\(ua.role, u.userrole\) >= 150\) ` +
+		`GROUP BY 1, 2, 3, 4 ORDER BY 1, 2, 3, 4$`
+	if !regexp.MustCompile(want).MatchString(sql) {
+		t.Errorf("signingReportSQLBuilder() sql = %v", sql)
+	}
+	if len(params) != 4 || params[2] != "alder" || params[3] != "sv" {
+		t.Errorf("signingReportSQLBuilder() params = %v", params)
+	}
+}
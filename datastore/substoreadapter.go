@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"regexp"
@@ -27,36 +28,76 @@ import (
 
 var validSerialNumberRegexp = regexp.MustCompile(defaultNicknamePattern)
 
-// ListSubstores return account sub-stores the user is authorized to see
-func (db *DB) ListSubstores(accountID int, authorization User) ([]Substore, error) {
+// ListSubstoresDefaultLimit is the default page size of the sub-store models list
+const ListSubstoresDefaultLimit = 50
+
+// ListSubstoresMaxLimit is the largest page size of the sub-store models list
+const ListSubstoresMaxLimit = 1000
+
+// MaxBulkSubstores is the largest number of sub-store models that are uploaded in one request
+const MaxBulkSubstores = 1000
+
+// ErrBulkSubstoresRejected is returned when some rows of the bulk upload are invalid
+var ErrBulkSubstoresRejected = errors.New("Some of the sub-store models are invalid, so none of the sub-store models have been created")
+
+// BulkSubstoreResult is the outcome of a row of the bulk upload of sub-store models. The rows are
+// numbered from 1, and the error is empty when the row is valid
+type BulkSubstoreResult struct {
+	Row          int    `json:"row"`
+	SerialNumber string `json:"serialnumber"`
+	ID           int    `json:"id,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+// ListSubstores return a page of the account sub-stores the user is authorized to see
+func (db *DB) ListSubstores(ctx context.Context, accountID int, authorization User, filter SubstoreFilter) ([]Substore, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.listSubstores(accountID)
+		return db.listSubstores(ctx, accountID, filter)
 	case Admin:
-		return db.listSubstoresFilteredByUser(accountID, authorization.Username)
+		return db.listSubstoresFilteredByUser(ctx, accountID, authorization.Username, filter)
+	case SubstoreAdmin:
+		return db.listSubstoresForSubstoreUser(ctx, accountID, authorization.Username, filter)
+	default:
+		return []Substore{}, nil
+	}
+}
+
+// ListDeletedSubstores return the deleted account sub-stores the user is authorized to see,
+// which can be restored
+func (db *DB) ListDeletedSubstores(ctx context.Context, accountID int, authorization User) ([]Substore, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listDeletedSubstores(ctx, accountID)
+	case Admin:
+		return db.listDeletedSubstoresFilteredByUser(ctx, accountID, authorization.Username)
 	default:
 		return []Substore{}, nil
 	}
 }
 
 // GetAllowedSubstore return the sub-store if the user is authorized to see it
-func (db *DB) GetAllowedSubstore(modelID int, serial string, authorization User) (Substore, error) {
+func (db *DB) GetAllowedSubstore(ctx context.Context, modelID int, serial string, authorization User) (Substore, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.GetSubstore(modelID, serial)
+		return db.GetSubstore(ctx, modelID, serial)
 	case Admin:
-		return db.GetSubstoreFilteredByUser(modelID, serial, authorization.Username)
+		return db.GetSubstoreFilteredByUser(ctx, modelID, serial, authorization.Username)
+	case SubstoreAdmin:
+		return db.getSubstoreForSubstoreUser(ctx, modelID, serial, authorization.Username)
 	default:
 		return Substore{}, nil
 	}
 }
 
 // UpdateAllowedSubstore updates the sub-store if authorization is allowed to do it
-func (db *DB) UpdateAllowedSubstore(store Substore, authorization User) error {
+func (db *DB) UpdateAllowedSubstore(ctx context.Context, store Substore, authorization User) error {
 
 	_, err := validateSubstore(store, "error-validate-store")
 	if err != nil {
@@ -67,16 +108,18 @@ func (db *DB) UpdateAllowedSubstore(store Substore, authorization User) error {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.updateSubstore(store)
+		return db.updateSubstore(ctx, store)
 	case Admin:
-		return db.updateSubstoreFilteredByUser(store, authorization.Username)
+		return db.updateSubstoreFilteredByUser(ctx, store, authorization.Username)
+	case SubstoreAdmin:
+		return db.updateSubstoreForSubstoreUser(ctx, store, authorization.Username)
 	default:
-		return nil
+		return errors.New("You do not have permissions to update the sub-store model")
 	}
 }
 
 // CreateAllowedSubstore creates a new model in case authorization is allowed to do it
-func (db *DB) CreateAllowedSubstore(store Substore, authorization User) (Substore, error) {
+func (db *DB) CreateAllowedSubstore(ctx context.Context, store Substore, authorization User) (Substore, error) {
 	// Validate the substore record
 	_, err := validateSubstore(store, "")
 	if err != nil {
@@ -84,7 +127,7 @@ func (db *DB) CreateAllowedSubstore(store Substore, authorization User) (Substor
 	}
 
 	// Validate that the user has access to the account
-	acc, err := db.GetAccountByID(store.AccountID, authorization)
+	acc, err := db.GetAccountByID(ctx, store.AccountID, authorization)
 	if err != nil || acc.ID == 0 {
 		return store, errors.New("You do not have permissions to this account")
 	}
@@ -95,21 +138,111 @@ func (db *DB) CreateAllowedSubstore(store Substore, authorization User) (Substor
 	case Superuser:
 		fallthrough
 	case Admin:
-		return db.createSubstore(store)
+		return db.createSubstore(ctx, store)
 	default:
 		return Substore{}, nil
 	}
 }
 
+// BulkCreateAllowedSubstores creates the sub-store models of a bulk upload of serial numbers for
+// an account, in a single transaction. All the rows are validated first, and nothing is created
+// when any of them is invalid
+func (db *DB) BulkCreateAllowedSubstores(ctx context.Context, accountID int, stores []Substore, authorization User) ([]BulkSubstoreResult, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+	case Superuser:
+	case Admin:
+	default:
+		return []BulkSubstoreResult{}, nil
+	}
+
+	// Validate that the user has access to the account
+	acc, err := db.GetAccountByID(ctx, accountID, authorization)
+	if err != nil || acc.ID == 0 {
+		return nil, errors.New("You do not have permissions to this account")
+	}
+
+	stores, results := db.checkBulkSubstores(ctx, accountID, stores)
+	if bulkSubstoresFailed(results) {
+		return results, ErrBulkSubstoresRejected
+	}
+
+	if err := db.bulkCreateSubstores(ctx, stores, results); err != nil {
+		return results, err
+	}
+	return results, nil
+}
+
+// checkBulkSubstores validates the rows of a bulk upload, returning the sub-store models to create
+// and the result of each row. The rows must not overlap with the sub-store models of the account,
+// nor with each other
+func (db *DB) checkBulkSubstores(ctx context.Context, accountID int, rows []Substore) ([]Substore, []BulkSubstoreResult) {
+	stores := make([]Substore, len(rows))
+	results := make([]BulkSubstoreResult, len(rows))
+
+	for i, row := range rows {
+		results[i] = BulkSubstoreResult{Row: i + 1, SerialNumber: row.SerialNumber}
+
+		store := row
+		store.ID = 0
+		store.AccountID = accountID
+		store.MatchType = store.matchType()
+		stores[i] = store
+
+		if _, err := validateSubstore(store, ""); err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		for j := 0; j < i; j++ {
+			if stores[j].FromModelID == store.FromModelID && stores[j].FromModelName == store.FromModelName && store.Overlaps(stores[j]) {
+				results[i].Error = fmt.Sprintf("The serial numbers overlap with row %d", j+1)
+				break
+			}
+		}
+		if len(results[i].Error) > 0 {
+			continue
+		}
+
+		// The pivots of a row can continue the chain of the previous rows
+		if err := db.checkSubstoreRules(ctx, store, stores[:i]...); err != nil {
+			results[i].Error = err.Error()
+		}
+	}
+	return stores, results
+}
+
+func bulkSubstoresFailed(results []BulkSubstoreResult) bool {
+	for _, r := range results {
+		if len(r.Error) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 // DeleteAllowedSubstore deletes sub-store model if allowed to authorization
-func (db *DB) DeleteAllowedSubstore(storeID int, authorization User) (string, error) {
+func (db *DB) DeleteAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication is disabled
 		fallthrough
 	case Superuser:
-		return db.deleteSubstore(storeID)
+		return db.deleteSubstore(ctx, storeID)
 	case Admin:
-		return db.deleteSubstoreFilteredByUser(storeID, authorization.Username)
+		return db.deleteSubstoreFilteredByUser(ctx, storeID, authorization.Username)
+	default:
+		return "", nil
+	}
+}
+
+// RestoreAllowedSubstore reverts the deletion of a sub-store model if allowed to authorization
+func (db *DB) RestoreAllowedSubstore(ctx context.Context, storeID int, authorization User) (string, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		return db.restoreSubstore(ctx, storeID)
+	case Admin:
+		return db.restoreSubstoreFilteredByUser(ctx, storeID, authorization.Username)
 	default:
 		return "", nil
 	}
@@ -133,11 +266,23 @@ func validateSubstore(store Substore, validateStoreLabel string) (string, error)
 		return validateStoreLabel, fmt.Errorf(errTemplate, store.ModelName, err)
 	}
 
+	err = validateSubstoreMatch(store)
+	if err != nil {
+		return validateStoreLabel, fmt.Errorf(errTemplate, store.ModelName, err)
+	}
+
 	err = validateModelName(store.ModelName)
 	if err != nil {
 		return validateStoreLabel, fmt.Errorf(errTemplate, store.ModelName, err)
 	}
 
+	if len(store.FromModelName) > 0 {
+		err = validateModelName(store.FromModelName)
+		if err != nil {
+			return validateStoreLabel, fmt.Errorf(errTemplate, store.ModelName, err)
+		}
+	}
+
 	return "", nil
 }
 
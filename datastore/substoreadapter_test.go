@@ -0,0 +1,432 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestDeleteRestoreSubstore(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	store, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A1", ModelName: "alder-mybrand"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	if _, err := db.DeleteAllowedSubstore(ctx, store.ID, root); err != nil {
+		t.Fatalf("DeleteAllowedSubstore() error = %v", err)
+	}
+
+	// The deleted sub-store model is not pivoted to, but can be restored
+	if _, err := db.GetSubstoreModel(ctx, "alder", "alder-mybrand", "A1"); err == nil {
+		t.Error("GetSubstoreModel() expected an error for a deleted sub-store model")
+	}
+	if stores, err := db.ListSubstores(ctx, account.ID, root, SubstoreFilter{}); err != nil || len(stores) != 0 {
+		t.Errorf("ListSubstores() = %v, %v", stores, err)
+	}
+	deleted, err := db.ListDeletedSubstores(ctx, account.ID, root)
+	if err != nil || len(deleted) != 1 || deleted[0].ID != store.ID {
+		t.Fatalf("ListDeletedSubstores() = %v, %v", deleted, err)
+	}
+
+	if _, err := db.RestoreAllowedSubstore(ctx, store.ID, root); err != nil {
+		t.Fatalf("RestoreAllowedSubstore() error = %v", err)
+	}
+	if code, err := db.RestoreAllowedSubstore(ctx, store.ID, root); err == nil || code != "error-store-not-found" {
+		t.Errorf("RestoreAllowedSubstore() of an active sub-store model = %q, %v", code, err)
+	}
+	if s, err := db.GetSubstoreModel(ctx, "alder", "alder-mybrand", "A1"); err != nil || s.ID != store.ID {
+		t.Errorf("GetSubstoreModel() = %v, %v", s, err)
+	}
+
+	// The sub-store model of a deleted model is not pivoted to
+	if _, err := db.DeleteAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("DeleteAllowedModel() error = %v", err)
+	}
+	if _, err := db.GetSubstoreModel(ctx, "alder", "alder-mybrand", "A1"); err == nil {
+		t.Error("GetSubstoreModel() expected an error for the sub-store model of a deleted model")
+	}
+}
+
+func TestSubstoreSerialMatches(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	exact, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A0150", ModelName: "alder-exact"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+	if exact.MatchType != SubstoreMatchExact {
+		t.Errorf("CreateAllowedSubstore() match = %q", exact.MatchType)
+	}
+	ranged, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A0200", SerialNumberEnd: "A0299", MatchType: SubstoreMatchRange, ModelName: "alder-range"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+	glob, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "LINE1-*", MatchType: SubstoreMatchGlob, ModelName: "alder-glob"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	// The overlapping sub-store models are refused
+	for _, s := range []Substore{
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0250", ModelName: "alder-other"},
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0100", SerialNumberEnd: "A0199", MatchType: SubstoreMatchRange, ModelName: "alder-other"},
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0290", SerialNumberEnd: "A0399", MatchType: SubstoreMatchRange, ModelName: "alder-other"},
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "LINE1-0001", ModelName: "alder-other"},
+	} {
+		if _, err := db.CreateAllowedSubstore(ctx, s, root); err == nil {
+			t.Errorf("CreateAllowedSubstore() expected an overlap error for %s-%s", s.SerialNumber, s.SerialNumberEnd)
+		}
+	}
+	ranged.SerialNumberEnd = "A0399"
+	if err := db.UpdateAllowedSubstore(ctx, ranged, root); err != nil {
+		t.Errorf("UpdateAllowedSubstore() error = %v", err)
+	}
+	ranged.SerialNumber = "A0100"
+	if err := db.UpdateAllowedSubstore(ctx, ranged, root); err == nil {
+		t.Error("UpdateAllowedSubstore() expected an overlap error")
+	}
+
+	tests := []struct {
+		serial string
+		want   int
+	}{
+		{"A0150", exact.ID},
+		{"A0250", ranged.ID},
+		{"A0399", ranged.ID},
+		{"LINE1-0042", glob.ID},
+		{"A0400", 0},
+		{"LINE2-0042", 0},
+	}
+	for _, tt := range tests {
+		s, err := db.GetSubstore(ctx, model.ID, tt.serial)
+		if (err == nil) != (tt.want > 0) || s.ID != tt.want {
+			t.Errorf("GetSubstore(%s) = %d, %v, want %d", tt.serial, s.ID, err, tt.want)
+		}
+		if tt.want > 0 && s.FromModel.ID != model.ID {
+			t.Errorf("GetSubstore(%s) from model = %d", tt.serial, s.FromModel.ID)
+		}
+	}
+
+	// The pivoted model is found for the serial numbers of the range
+	if s, err := db.GetSubstoreModel(ctx, "alder", "alder-range", "A0300"); err != nil || s.ID != ranged.ID {
+		t.Errorf("GetSubstoreModel() = %v, %v", s, err)
+	}
+	if _, err := db.GetSubstoreModel(ctx, "alder", "alder-range", "A0150"); err == nil {
+		t.Error("GetSubstoreModel() expected an error for a serial number outside of the range")
+	}
+
+	// A deleted sub-store model is not restored over a sub-store model that overlaps it
+	if _, err := db.DeleteAllowedSubstore(ctx, exact.ID, root); err != nil {
+		t.Fatalf("DeleteAllowedSubstore() error = %v", err)
+	}
+	if _, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A01*", MatchType: SubstoreMatchGlob, ModelName: "alder-other"}, root); err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+	if code, err := db.RestoreAllowedSubstore(ctx, exact.ID, root); err == nil || code != "error-store-overlap" {
+		t.Errorf("RestoreAllowedSubstore() = %q, %v", code, err)
+	}
+}
+
+func TestBulkCreateAllowedSubstores(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	if _, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A1", ModelName: "alder-mybrand"}, root); err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	// The rows that overlap are reported, and none of the rows are created
+	rows := []Substore{
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A2", ModelName: "alder-mybrand"},
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A1", ModelName: "alder-mybrand"},
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A2", ModelName: "alder-mybrand"},
+		{FromModelID: model.ID, Store: "mybrand", ModelName: "alder-mybrand"},
+	}
+	results, err := db.BulkCreateAllowedSubstores(ctx, account.ID, rows, root)
+	if err != ErrBulkSubstoresRejected {
+		t.Fatalf("BulkCreateAllowedSubstores() error = %v", err)
+	}
+	if len(results) != 4 || len(results[0].Error) > 0 || len(results[1].Error) == 0 || len(results[2].Error) == 0 || len(results[3].Error) == 0 {
+		t.Errorf("BulkCreateAllowedSubstores() results = %v", results)
+	}
+	if _, err := db.GetSubstore(ctx, model.ID, "A2"); err == nil {
+		t.Error("BulkCreateAllowedSubstores() created a sub-store model of a rejected upload")
+	}
+
+	rows = []Substore{
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A2", ModelName: "alder-mybrand"},
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A3", ModelName: "alder-mybrand"},
+	}
+	results, err = db.BulkCreateAllowedSubstores(ctx, account.ID, rows, root)
+	if err != nil {
+		t.Fatalf("BulkCreateAllowedSubstores() error = %v", err)
+	}
+	for _, r := range results {
+		s, err := db.GetSubstore(ctx, model.ID, r.SerialNumber)
+		if err != nil || s.ID != r.ID || s.AccountID != account.ID {
+			t.Errorf("GetSubstore(%s) = %v, %v, want ID %d", r.SerialNumber, s, err, r.ID)
+		}
+	}
+
+	if _, err := db.BulkCreateAllowedSubstores(ctx, 999, rows, User{Username: "sv", Role: Admin}); err == nil {
+		t.Error("BulkCreateAllowedSubstores() expected an error for an account of another user")
+	}
+}
+
+func TestListSubstoresPage(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	stores := []Substore{
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A0001", ModelName: "alder-pivot"},
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "A0002", ModelName: "alder-pivot"},
+		{FromModelID: model.ID, Store: "mybrand", SerialNumber: "B0001", ModelName: "alder-pivot"},
+	}
+	if _, err := db.BulkCreateAllowedSubstores(ctx, account.ID, stores, root); err != nil {
+		t.Fatalf("BulkCreateAllowedSubstores() error = %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		filter SubstoreFilter
+		want   []string
+	}{
+		{"all", SubstoreFilter{}, []string{"A0001", "A0002", "B0001"}},
+		{"first-page", SubstoreFilter{Limit: 2}, []string{"A0001", "A0002"}},
+		{"next-page", SubstoreFilter{Offset: 2, Limit: 2}, []string{"B0001"}},
+		{"search", SubstoreFilter{Serial: "a00"}, []string{"A0001", "A0002"}},
+		{"search-page", SubstoreFilter{Serial: "0001", Offset: 1}, []string{"B0001"}},
+		{"no-match", SubstoreFilter{Serial: "C"}, []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := db.ListSubstores(ctx, account.ID, root, tt.filter)
+			if err != nil {
+				t.Fatalf("ListSubstores() error = %v", err)
+			}
+			serials := []string{}
+			for _, s := range got {
+				serials = append(serials, s.SerialNumber)
+				if s.FromModel.ID != model.ID {
+					t.Errorf("ListSubstores() from model = %d, want %d", s.FromModel.ID, model.ID)
+				}
+			}
+			if strings.Join(serials, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("ListSubstores() = %v, want %v", serials, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstoreOriginalHeaders(t *testing.T) {
+	store := Substore{FromModel: Model{BrandID: "alder", Name: "alder-basic"}, ModelName: "alder-pivot"}
+
+	// The headers are removed when the sub-store model does not embed them
+	headers := map[string]interface{}{"model": "alder-pivot", OriginalBrandHeader: "other", OriginalModelHeader: "other-basic"}
+	store.SetOriginalHeaders(headers)
+	if _, ok := headers[OriginalBrandHeader]; ok {
+		t.Errorf("SetOriginalHeaders() expected no %s header", OriginalBrandHeader)
+	}
+	if _, ok := headers[OriginalModelHeader]; ok {
+		t.Errorf("SetOriginalHeaders() expected no %s header", OriginalModelHeader)
+	}
+
+	store.OriginalHeaders = true
+	store.SetOriginalHeaders(headers)
+	if headers[OriginalBrandHeader] != "alder" || headers[OriginalModelHeader] != "alder-basic" || headers["model"] != "alder-pivot" {
+		t.Errorf("SetOriginalHeaders() = %v", headers)
+	}
+}
+
+func TestSubstoreUserStores(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	store, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A1", ModelName: "alder-mybrand"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	// The sub-store admin is only delegated the stores of the account
+	user := SubstoreUser{User: User{Username: "mybrand-admin", Name: "Sub-store Admin", Email: "mybrand@example.com"}, Stores: []string{"otherbrand"}}
+	if _, err := db.CreateAllowedSubstoreUser(ctx, account.ID, user, root); err == nil {
+		t.Error("CreateAllowedSubstoreUser() expected an error for a store of another account")
+	}
+	user.Stores = []string{"mybrand"}
+	if _, err := db.CreateAllowedSubstoreUser(ctx, account.ID, user, root); err != nil {
+		t.Errorf("CreateAllowedSubstoreUser() error = %v", err)
+	}
+
+	// The users without a role for the sub-stores cannot update them
+	store.SerialNumber = "A2"
+	for _, role := range []int{Standard, SyncUser} {
+		if err := db.UpdateAllowedSubstore(ctx, store, User{Username: "factory", Role: role}); err == nil {
+			t.Errorf("UpdateAllowedSubstore() expected an error for the role %d", role)
+		}
+	}
+	if err := db.UpdateAllowedSubstore(ctx, store, root); err != nil {
+		t.Errorf("UpdateAllowedSubstore() error = %v", err)
+	}
+
+	// The sub-store admin can remap the devices, but not to a model of another brand
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "maple", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "maple", KeyID: "maple-key", SealedKey: "sealed", KeyName: "maple"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	mapleKeypair, err := db.GetKeypairByPublicID(ctx, "maple", "maple-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	other, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "maple", Name: "maple-basic", KeypairID: mapleKeypair.ID, KeypairIDUser: mapleKeypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+	substoreAdmin := User{Username: "mybrand-admin", Role: SubstoreAdmin}
+	store.FromModelID = other.ID
+	if err := db.UpdateAllowedSubstore(ctx, store, substoreAdmin); err == nil {
+		t.Error("UpdateAllowedSubstore() expected an error for a model of another brand")
+	}
+	store.FromModelID = model.ID
+	store.SerialNumber = "A3"
+	if err := db.UpdateAllowedSubstore(ctx, store, substoreAdmin); err != nil {
+		t.Errorf("UpdateAllowedSubstore() error = %v", err)
+	}
+}
@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// maxSubstoreChain is the largest number of pivots of a device that are followed, which guards the
+// lookup against a loop of pivots
+const maxSubstoreChain = 10
+
+// resolveSubstoreChain follows the pivots of a device, from the sub-store model of the original model
+// to the last pivot. The find function returns the sub-store model that pivots the devices of the
+// original or pivoted model, with sql.ErrNoRows when there is none
+func resolveSubstoreChain(find func(fromModelName string) (Substore, error)) (Substore, error) {
+	store, err := find("")
+	if err != nil {
+		return store, err
+	}
+
+	for i := 1; i < maxSubstoreChain; i++ {
+		next, err := find(store.ModelName)
+		if err == sql.ErrNoRows {
+			break
+		}
+		if err != nil {
+			return store, err
+		}
+		store = next
+	}
+	return store, nil
+}
+
+// checkSubstoreChain checks that the pivoted model that a sub-store model pivots the devices from is
+// the model of one of the other sub-store models of the original model, and that the pivots do not
+// lead back to it
+func checkSubstoreChain(store Substore, stores []Substore) error {
+	if len(store.FromModelName) == 0 {
+		return nil
+	}
+	if store.FromModelName == store.ModelName {
+		return fmt.Errorf("the sub-store model cannot pivot the devices of the model %s to the same model", store.ModelName)
+	}
+
+	// The pivots of each pivoted model
+	found := false
+	pivots := map[string][]string{}
+	for _, s := range stores {
+		if s.ModelName == store.FromModelName {
+			found = true
+		}
+		pivots[s.FromModelName] = append(pivots[s.FromModelName], s.ModelName)
+	}
+	if !found {
+		return fmt.Errorf("the model %s is not a pivoted model of the original model", store.FromModelName)
+	}
+
+	// Follow the pivots from the model of the sub-store model
+	visited := map[string]bool{}
+	next := []string{store.ModelName}
+	for len(next) > 0 {
+		name := next[0]
+		next = next[1:]
+		if name == store.FromModelName {
+			return fmt.Errorf("the pivots of the model %s lead back to the model %s", store.ModelName, store.FromModelName)
+		}
+		if visited[name] {
+			continue
+		}
+		visited[name] = true
+		next = append(next, pivots[name]...)
+	}
+	return nil
+}
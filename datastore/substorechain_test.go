@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckSubstoreChain(t *testing.T) {
+	stores := []Substore{
+		{ID: 1, ModelName: "alder-b"},
+		{ID: 2, FromModelName: "alder-b", ModelName: "alder-c"},
+	}
+
+	tests := []struct {
+		name    string
+		store   Substore
+		wantErr bool
+	}{
+		{"original", Substore{ModelName: "alder-d"}, false},
+		{"chained", Substore{FromModelName: "alder-c", ModelName: "alder-d"}, false},
+		{"same-model", Substore{FromModelName: "alder-b", ModelName: "alder-b"}, true},
+		{"unknown-model", Substore{FromModelName: "alder-x", ModelName: "alder-d"}, true},
+		{"loop", Substore{FromModelName: "alder-c", ModelName: "alder-b"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkSubstoreChain(tt.store, stores); (err != nil) != tt.wantErr {
+				t.Errorf("checkSubstoreChain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSubstoreChain(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil {
+		t.Fatalf("GetKeypairByPublicID() error = %v", err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-a", KeypairID: keypair.ID, KeypairIDUser: keypair.ID}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedModel() error = %v", err)
+	}
+
+	first, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "mybrand", SerialNumber: "A0100", ModelName: "alder-b"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+
+	// A single pivot resolves to the first sub-store model
+	store, err := db.GetSubstore(ctx, model.ID, "A0100")
+	if err != nil || store.ID != first.ID {
+		t.Errorf("GetSubstore() = %d, %v, want %d", store.ID, err, first.ID)
+	}
+
+	// The pivoted model is pivoted again, for the same serial number
+	second, err := db.CreateAllowedSubstore(ctx, Substore{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0100", FromModelName: "alder-b", ModelName: "alder-c"}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedSubstore() error = %v", err)
+	}
+	store, err = db.GetSubstore(ctx, model.ID, "A0100")
+	if err != nil || store.ID != second.ID {
+		t.Errorf("GetSubstore() = %d, %v, want %d", store.ID, err, second.ID)
+	}
+	if store.FromModel.Name != "alder-a" {
+		t.Errorf("GetSubstore() from model = %q", store.FromModel.Name)
+	}
+
+	// The devices on a pivoted model are still found
+	pivoted, err := db.GetSubstoreModel(ctx, "alder", "alder-b", "A0100")
+	if err != nil || pivoted.ID != first.ID {
+		t.Errorf("GetSubstoreModel() = %d, %v, want %d", pivoted.ID, err, first.ID)
+	}
+
+	// The pivots that are not chained to the original model, or that loop, are refused
+	for _, s := range []Substore{
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0100", FromModelName: "alder-x", ModelName: "alder-d"},
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0100", FromModelName: "alder-c", ModelName: "alder-b"},
+		{AccountID: account.ID, FromModelID: model.ID, Store: "other", SerialNumber: "A0100", FromModelName: "alder-b", ModelName: "alder-d"},
+	} {
+		if _, err := db.CreateAllowedSubstore(ctx, s, root); err == nil {
+			t.Errorf("CreateAllowedSubstore() expected an error for %s to %s", s.FromModelName, s.ModelName)
+		}
+	}
+
+	// The history of the pivots of the device
+	for _, p := range []SubstorePivot{
+		{SubstoreID: first.ID, FromModelID: model.ID, SerialNumber: "A0100", ModelName: "alder-b"},
+		{SubstoreID: second.ID, FromModelID: model.ID, SerialNumber: "A0100", FromModelName: "alder-b", ModelName: "alder-c"},
+		{SubstoreID: first.ID, FromModelID: model.ID, SerialNumber: "A0101", ModelName: "alder-b"},
+	} {
+		if err := db.CreateSubstorePivot(ctx, p); err != nil {
+			t.Fatalf("CreateSubstorePivot() error = %v", err)
+		}
+	}
+	pivots, err := db.ListAllowedSubstorePivots(ctx, model.ID, "A0100", root)
+	if err != nil {
+		t.Fatalf("ListAllowedSubstorePivots() error = %v", err)
+	}
+	if len(pivots) != 2 || pivots[0].ModelName != "alder-b" || pivots[1].FromModelName != "alder-b" || pivots[1].ModelName != "alder-c" {
+		t.Errorf("ListAllowedSubstorePivots() = %v", pivots)
+	}
+	if pivots, _ := db.ListAllowedSubstorePivots(ctx, model.ID, "A0100", User{Role: Standard}); len(pivots) != 0 {
+		t.Errorf("ListAllowedSubstorePivots() expected no pivots for a standard user, got %d", len(pivots))
+	}
+}
@@ -21,8 +21,13 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	sq "github.com/Masterminds/squirrel"
 	"github.com/lib/pq"
 )
 
@@ -33,110 +38,245 @@ const createSubstoreTableSQL = `
 		from_model_id    int references model not null,
 		store            varchar(200) not null,
 		serial_number    varchar(200) not null,
-		model_name       varchar(200) not null
+		model_name       varchar(200) not null,
+		deleted_at       timestamp,
+		match_type       varchar(10) not null default 'exact',
+		serial_end       varchar(200) not null default '',
+		from_model_name  varchar(200) not null default '',
+		original_headers boolean not null default false,
+		serial_hash      varchar(200) not null default ''
 	)
 `
 
-// Indexes
+// Add the time that the sub-store model is deleted, which is null for the active sub-store models
+const alterSubstoreDeletedAt = "alter table substore add column deleted_at timestamp"
+
+// Add the match of the serial numbers, so a sub-store model applies to a range or a pattern of serial
+// numbers. The serial number is the start of a range, or the pattern
+const alterSubstoreMatchType = "alter table substore add column match_type varchar(10) not null default 'exact'"
+const alterSubstoreSerialEnd = "alter table substore add column serial_end varchar(200) not null default ''"
+
+// Add the pivoted model that a sub-store model pivots the devices from, so the devices can be pivoted
+// again. It is empty for the original model
+const alterSubstoreFromModelName = "alter table substore add column from_model_name varchar(200) not null default ''"
+
+// Add the option to embed the original brand and model in the assertions of the pivoted model
+const alterSubstoreOriginalHeaders = "alter table substore add column original_headers boolean not null default false"
+
+// Add the hash of the serial number, so the signing logs of the accounts that hash the serial
+// numbers are joined with the sub-store model. It is empty when the salt is not configured
+const alterSubstoreSerialHash = "alter table substore add column serial_hash varchar(200) not null default ''"
+
+// Indexes. The deleted sub-store models are included, so a deleted mapping is restored rather than
+// created again. The index of the serial numbers is replaced by the index of the serial numbers of
+// each original or pivoted model, once the pivoted model column is added
+const dropSubstoreUniqueIndexSQL = "DROP INDEX IF EXISTS substore_idx"
 const createSubstoreUniqueIndexSQL = `
-	CREATE UNIQUE INDEX IF NOT EXISTS substore_idx ON substore 
-	(account_id, from_model_id, store, serial_number)`
+	CREATE UNIQUE INDEX IF NOT EXISTS substore_from_model_name_idx ON substore 
+	(account_id, from_model_id, from_model_name, store, serial_number)`
 
 const createSubstoreSQL = `
 	INSERT INTO substore 
-	(account_id, from_model_id, store, serial_number, model_name) 
-	VALUES ($1,$2,$3,$4,$5)`
+	(account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers) 
+	VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)
+	RETURNING id`
 
 const getSubstoreSQL = `
-	SELECT id, account_id, from_model_id, store, serial_number, model_name 
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
+	FROM substore 
+	WHERE from_model_id=$1 AND from_model_name=$2 AND serial_number=$3 AND match_type='exact' AND deleted_at IS NULL`
+
+// The ranges and the patterns of the serial numbers of the original or pivoted model, which are matched
+// when there is no sub-store model for the exact serial number
+const listSubstoreMatchesSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
+	FROM substore 
+	WHERE from_model_id=$1 AND from_model_name=$2 AND match_type<>'exact' AND deleted_at IS NULL`
+
+// The sub-store models of the original model, which are checked for the overlaps and the pivot chains
+// of a sub-store model
+const listFromModelSubstoresSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
+	FROM substore 
+	WHERE from_model_id=$1 AND id<>$2 AND deleted_at IS NULL`
+
+const getSubstoreByIDSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
 	FROM substore 
-	WHERE from_model_id=$1 AND serial_number=$2`
+	WHERE id=$1 AND deleted_at IS NULL`
 
 const getUserSubstoreSQL = `
-	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers
+	FROM substore s
+	INNER JOIN useraccountlink ua ON s.account_id = ua.account_id
+	INNER JOIN userinfo u ON ua.user_id = u.id
+	WHERE s.from_model_id=$1 AND s.from_model_name=$2 AND s.serial_number=$3 AND s.match_type='exact' AND s.deleted_at IS NULL
+	AND u.username=$4` + accountRoleAdminSQL
+
+const listUserSubstoreMatchesSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers
 	FROM substore s
-	INNER JOIN useraccountlink l ON s.account_id = l.account_id
-	INNER JOIN userinfo u ON l.user_id = u.id
-	WHERE s.from_model_id=$1 AND s.serial_number=$2 AND u.username=$3`
+	INNER JOIN useraccountlink ua ON s.account_id = ua.account_id
+	INNER JOIN userinfo u ON ua.user_id = u.id
+	WHERE s.from_model_id=$1 AND s.from_model_name=$2 AND s.match_type<>'exact' AND s.deleted_at IS NULL
+	AND u.username=$3` + accountRoleAdminSQL
 
 const getSubstoreModelSQL = `
-	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name 
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers 
 	FROM substore s
 	INNER JOIN model m ON m.id = s.from_model_id
-	WHERE m.brand_id=$1 AND s.model_name=$2 AND s.serial_number=$3`
+	WHERE m.brand_id=$1 AND s.model_name=$2 AND s.serial_number=$3 AND s.match_type='exact'
+	AND s.deleted_at IS NULL AND m.deleted_at IS NULL`
+
+const listSubstoreModelMatchesSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers 
+	FROM substore s
+	INNER JOIN model m ON m.id = s.from_model_id
+	WHERE m.brand_id=$1 AND s.model_name=$2 AND s.match_type<>'exact'
+	AND s.deleted_at IS NULL AND m.deleted_at IS NULL`
+
+// The columns of the sub-store models list
+const listSubstoresColumnsSQL = "s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers"
 
-const listSubstoreSQL = `
-	SELECT id, account_id, from_model_id, store, serial_number, model_name 
+// The deleted sub-store models of the account, which can be restored
+const listDeletedSubstoreSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
 	FROM substore 
-	WHERE account_id=$1`
+	WHERE account_id=$1 AND deleted_at IS NOT NULL`
 
-const listUserSubstoreSQL = `
-	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name 
+const listDeletedUserSubstoreSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers 
 	FROM substore s
-	INNER JOIN useraccountlink l ON s.account_id = l.account_id
-	INNER JOIN userinfo u ON l.user_id = u.id
-	WHERE s.account_id=$1 AND u.username=$2
-`
+	INNER JOIN useraccountlink ua ON s.account_id = ua.account_id
+	INNER JOIN userinfo u ON ua.user_id = u.id
+	WHERE s.account_id=$1 AND s.deleted_at IS NOT NULL AND u.username=$2` + accountRoleAdminSQL
 const updateSubstoreSQL = `
 	UPDATE substore 
-	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6 
-	WHERE id=$1`
+	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6, match_type=$7, serial_end=$8, from_model_name=$9, original_headers=$10 
+	WHERE id=$1 AND deleted_at IS NULL`
 const updateSubstoreForUserSQL = `
 	UPDATE substore s 
-	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6 
+	SET account_id=$2, from_model_id=$3, store=$4, serial_number=$5, model_name=$6, match_type=$7, serial_end=$8, from_model_name=$10, original_headers=$11 
 	FROM useraccountlink ua
 	INNER JOIN userinfo u ON ua.user_id=u.id
-	WHERE s.id=$1 AND u.username=$7
-	AND ua.account_id=s.account_id`
+	WHERE s.id=$1 AND s.deleted_at IS NULL AND u.username=$9
+	AND ua.account_id=s.account_id` + accountRoleAdminSQL
 
-const deleteSubstoreSQL = "delete from substore where id=$1"
+// The deletion of a sub-store model sets the time that it is deleted, so it can be restored
+const deleteSubstoreSQL = "UPDATE substore SET deleted_at=$2 WHERE id=$1 AND deleted_at IS NULL"
 const deleteSubstoreForUserSQL = `
-		DELETE FROM substore s
-		USING account acc
+		UPDATE substore s SET deleted_at=$3
+		FROM account acc
 		INNER JOIN useraccountlink ua ON ua.account_id=acc.id
 		INNER JOIN userinfo u ON ua.user_id=u.id
-		WHERE s.id=$1 AND acc.id=s.account_id AND u.username=$2`
+		WHERE s.id=$1 AND s.deleted_at IS NULL AND acc.id=s.account_id AND u.username=$2` + accountRoleAdminSQL
+const getDeletedSubstoreSQL = `
+	SELECT id, account_id, from_model_id, store, serial_number, model_name, match_type, serial_end, from_model_name, original_headers 
+	FROM substore 
+	WHERE id=$1 AND deleted_at IS NOT NULL`
+const restoreSubstoreSQL = "UPDATE substore SET deleted_at=NULL WHERE id=$1 AND deleted_at IS NOT NULL"
+const restoreSubstoreForUserSQL = `
+		UPDATE substore s SET deleted_at=NULL
+		FROM account acc
+		INNER JOIN useraccountlink ua ON ua.account_id=acc.id
+		INNER JOIN userinfo u ON ua.user_id=u.id
+		WHERE s.id=$1 AND s.deleted_at IS NOT NULL AND acc.id=s.account_id AND u.username=$2` + accountRoleAdminSQL
 
-// Substore holds the substore details for an account in the local database
+// Substore holds the substore details for an account in the local database. The serial number is
+// the start of the range, or the pattern, of the serial numbers that the sub-store model matches.
+// The from model name is the pivoted model of the original model that the devices are pivoted from,
+// when the devices are pivoted again, or empty for the original model. The original headers embed the
+// brand and the original model in the assertions of the pivoted model, so the device can be traced
 type Substore struct {
-	ID           int    `json:"id"`
-	AccountID    int    `json:"accountID"`
-	FromModelID  int    `json:"fromModelID"`
-	FromModel    Model  `json:"fromModel"`
-	Store        string `json:"store"`
-	SerialNumber string `json:"serialnumber"`
-	ModelName    string `json:"modelname"`
+	ID              int    `json:"id"`
+	AccountID       int    `json:"accountID"`
+	FromModelID     int    `json:"fromModelID"`
+	FromModel       Model  `json:"fromModel"`
+	Store           string `json:"store"`
+	SerialNumber    string `json:"serialnumber"`
+	ModelName       string `json:"modelname"`
+	MatchType       string `json:"matchtype"`
+	SerialNumberEnd string `json:"serialnumberend"`
+	FromModelName   string `json:"frommodelname"`
+	OriginalHeaders bool   `json:"originalheaders"`
 }
 
-// CreateSubstoreTable creates the database table for a sub-store
-func (db *DB) CreateSubstoreTable() error {
-	_, err := db.Exec(createSubstoreTableSQL)
-	if err != nil {
-		return err
+// Headers of the assertions of the pivoted model with the brand and the original model of the device
+const (
+	OriginalBrandHeader = "original-brand-id"
+	OriginalModelHeader = "original-model"
+)
+
+// SetOriginalHeaders embeds the brand and the original model in the headers of an assertion of the
+// pivoted model, or removes them when the sub-store model does not embed them
+func (s Substore) SetOriginalHeaders(headers map[string]interface{}) {
+	if !s.OriginalHeaders {
+		delete(headers, OriginalBrandHeader)
+		delete(headers, OriginalModelHeader)
+		return
 	}
+	headers[OriginalBrandHeader] = s.FromModel.BrandID
+	headers[OriginalModelHeader] = s.FromModel.Name
+}
+
+// CreateSubstoreTable creates the database table for a sub-store
+func (db *DB) CreateSubstoreTable(ctx context.Context) error {
+	// The unique index is created by the update of the table, once the pivoted model column exists
+	_, err := db.ExecContext(ctx, createSubstoreTableSQL)
+	return err
+}
+
+// AlterSubstoreTable updates an existing database sub-store table with additional fields
+func (db *DB) AlterSubstoreTable(ctx context.Context) error {
+	// Add the deleted time field, which is skipped if it already exists
+	db.ExecContext(ctx, alterSubstoreDeletedAt)
+
+	// Add the match fields, which are skipped if they already exist
+	db.ExecContext(ctx, alterSubstoreMatchType)
+	db.ExecContext(ctx, alterSubstoreSerialEnd)
+
+	// Add the original headers field, which is skipped if it already exists
+	db.ExecContext(ctx, alterSubstoreOriginalHeaders)
 
-	_, err = db.Exec(createSubstoreUniqueIndexSQL)
+	// Add the serial number hash field, which is skipped if it already exists
+	db.ExecContext(ctx, alterSubstoreSerialHash)
+
+	// Add the pivoted model field, and replace the unique index with the index of the pivoted models
+	db.ExecContext(ctx, alterSubstoreFromModelName)
+	db.ExecContext(ctx, dropSubstoreUniqueIndexSQL)
+	_, err := db.ExecContext(ctx, createSubstoreUniqueIndexSQL)
 	return err
 }
 
 // createSubstore creates a sub-store in the database
-func (db *DB) createSubstore(store Substore) (Substore, error) {
-	_, err := db.Exec(createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName)
+func (db *DB) createSubstore(ctx context.Context, store Substore) (Substore, error) {
+	store.MatchType = store.matchType()
+	if err := db.checkSubstoreRules(ctx, store); err != nil {
+		return store, err
+	}
+
+	var createdID int
+	err := db.QueryRowContext(ctx, createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, store.MatchType, store.SerialNumberEnd, store.FromModelName, store.OriginalHeaders).Scan(&createdID)
 	if err, ok := err.(*pq.Error); ok {
 		// This is a PostgreSQL error...
 		if err.Code.Name() == "unique_violation" {
 			// Output a more readable message
-			return store, fmt.Errorf("a sub-store mapping already exists for this from model, serial-number and "+
-				"sub-store (%d, %s, %s)", store.FromModelID, store.SerialNumber, store.Store)
+			return store, fmt.Errorf("a sub-store mapping already exists, or is deleted and can be restored, for this "+
+				"from model, serial-number and sub-store (%d, %s, %s)", store.FromModelID, store.SerialNumber, store.Store)
 		}
 	}
+	if err == nil {
+		err = db.transaction(ctx, func(tx *sql.Tx) error {
+			return hashSubstoreSerial(ctx, tx, createdID)
+		})
+	}
 	if err != nil {
 		return store, fmt.Errorf("error creating the database sub-store (from model, serial-number and sub-store "+
 			"(%d, %s, %s): %v", store.FromModelID, store.SerialNumber, store.Store, err)
 	}
 
 	// Return the created substore
-	substore, err := db.GetSubstore(store.FromModelID, store.SerialNumber)
+	substore, err := db.GetSubstoreByID(ctx, createdID)
 	if err != nil {
 		return store, fmt.Errorf("error creating the database sub-store (from model, serial-number and sub-store "+
 			"(%d, %s, %s): %v", store.FromModelID, store.SerialNumber, store.Store, err)
@@ -145,19 +285,17 @@ func (db *DB) createSubstore(store Substore) (Substore, error) {
 	return substore, nil
 }
 
-// GetSubstore fetches a sub-store in the database
-func (db *DB) GetSubstore(fromModelID int, serialNumber string) (Substore, error) {
-	store := Substore{}
-
-	var row *sql.Row
-
-	row = db.QueryRow(getSubstoreSQL, fromModelID, serialNumber)
-	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+// GetSubstore fetches a sub-store in the database. The pivots of the chain of the serial number are
+// followed, so the sub-store model is the last pivot of the device
+func (db *DB) GetSubstore(ctx context.Context, fromModelID int, serialNumber string) (Substore, error) {
+	store, err := resolveSubstoreChain(func(fromModelName string) (Substore, error) {
+		return db.findSubstore(ctx, getSubstoreSQL, listSubstoreMatchesSQL, fromModelID, fromModelName, serialNumber)
+	})
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database substore for %s, from model %d: %v", serialNumber, fromModelID, err)
 	}
 
-	store.FromModel, err = db.getModel(store.FromModelID)
+	store.FromModel, err = db.getModel(ctx, store.FromModelID)
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
 	}
@@ -165,19 +303,34 @@ func (db *DB) GetSubstore(fromModelID int, serialNumber string) (Substore, error
 	return store, nil
 }
 
-// GetSubstoreFilteredByUser fetches a sub-store in the database
-func (db *DB) GetSubstoreFilteredByUser(fromModelID int, serialNumber, username string) (Substore, error) {
+// GetSubstoreByID fetches a sub-store in the database by its ID
+func (db *DB) GetSubstoreByID(ctx context.Context, storeID int) (Substore, error) {
 	store := Substore{}
 
-	var row *sql.Row
+	row := db.QueryRowContext(ctx, getSubstoreByIDSQL, storeID)
+	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName, &store.MatchType, &store.SerialNumberEnd, &store.FromModelName, &store.OriginalHeaders)
+	if err != nil {
+		return store, fmt.Errorf("error retrieving database substore %d: %v", storeID, err)
+	}
+
+	store.FromModel, err = db.getModel(ctx, store.FromModelID)
+	if err != nil {
+		return store, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
+	}
 
-	row = db.QueryRow(getUserSubstoreSQL, fromModelID, serialNumber, username)
-	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+	return store, nil
+}
+
+// GetSubstoreFilteredByUser fetches a sub-store in the database, following the pivots of the chain
+func (db *DB) GetSubstoreFilteredByUser(ctx context.Context, fromModelID int, serialNumber, username string) (Substore, error) {
+	store, err := resolveSubstoreChain(func(fromModelName string) (Substore, error) {
+		return db.findSubstore(ctx, getUserSubstoreSQL, listUserSubstoreMatchesSQL, fromModelID, fromModelName, serialNumber, username)
+	})
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database substore for %s, from model %d: %v", serialNumber, fromModelID, err)
 	}
 
-	store.FromModel, err = db.getModel(store.FromModelID)
+	store.FromModel, err = db.getModel(ctx, store.FromModelID)
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
 	}
@@ -186,16 +339,19 @@ func (db *DB) GetSubstoreFilteredByUser(fromModelID int, serialNumber, username
 }
 
 // GetSubstoreModel fetches a sub-store in the database using the pivoted model name
-func (db *DB) GetSubstoreModel(brand, model, serialNumber string) (Substore, error) {
+func (db *DB) GetSubstoreModel(ctx context.Context, brand, model, serialNumber string) (Substore, error) {
 	store := Substore{}
 
-	row := db.QueryRow(getSubstoreModelSQL, brand, model, serialNumber)
-	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+	row := db.QueryRowContext(ctx, getSubstoreModelSQL, brand, model, serialNumber)
+	err := row.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName, &store.MatchType, &store.SerialNumberEnd, &store.FromModelName, &store.OriginalHeaders)
+	if err == sql.ErrNoRows {
+		store, err = db.matchSubstore(ctx, serialNumber, listSubstoreModelMatchesSQL, brand, model)
+	}
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database substore (model name %s, serial %s): %v", model, serialNumber, err)
 	}
 
-	store.FromModel, err = db.getModel(store.FromModelID)
+	store.FromModel, err = db.getModel(ctx, store.FromModelID)
 	if err != nil {
 		return store, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
 	}
@@ -203,67 +359,261 @@ func (db *DB) GetSubstoreModel(brand, model, serialNumber string) (Substore, err
 	return store, nil
 }
 
+// findSubstore returns the sub-store model of the original or pivoted model for the exact serial number,
+// or else for the ranges and the patterns that match the serial number. The queries have the original
+// and the pivoted model as their first parameters, and the filter as their last parameters
+func (db *DB) findSubstore(ctx context.Context, exactSQL, matchesSQL string, fromModelID int, fromModelName, serialNumber string, filter ...interface{}) (Substore, error) {
+	store := Substore{}
+
+	args := append([]interface{}{fromModelID, fromModelName, serialNumber}, filter...)
+	err := db.QueryRowContext(ctx, exactSQL, args...).Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName, &store.MatchType, &store.SerialNumberEnd, &store.FromModelName, &store.OriginalHeaders)
+	if err == sql.ErrNoRows {
+		args = append([]interface{}{fromModelID, fromModelName}, filter...)
+		store, err = db.matchSubstore(ctx, serialNumber, matchesSQL, args...)
+	}
+	return store, err
+}
+
+// matchSubstore returns the sub-store model of the ranges and the patterns of the query that matches
+// the serial number, with sql.ErrNoRows when none of them matches
+func (db *DB) matchSubstore(ctx context.Context, serialNumber, query string, args ...interface{}) (Substore, error) {
+	stores, err := db.querySubstoreMatches(ctx, query, args...)
+	if err != nil {
+		return Substore{}, err
+	}
+
+	store, ok := matchingSubstore(stores, serialNumber)
+	if !ok {
+		return store, sql.ErrNoRows
+	}
+	return store, nil
+}
+
+// querySubstoreMatches returns the sub-store models of the query, without their original model
+func (db *DB) querySubstoreMatches(ctx context.Context, query string, args ...interface{}) ([]Substore, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stores := []Substore{}
+	for rows.Next() {
+		store := Substore{}
+		err := rows.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName, &store.MatchType, &store.SerialNumberEnd, &store.FromModelName, &store.OriginalHeaders)
+		if err != nil {
+			return nil, err
+		}
+		stores = append(stores, store)
+	}
+	return stores, rows.Err()
+}
+
+// bulkCreateSubstores creates the sub-store models of a bulk upload in a transaction
+func (db *DB) bulkCreateSubstores(ctx context.Context, stores []Substore, results []BulkSubstoreResult) error {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		for i, store := range stores {
+			err := tx.QueryRowContext(ctx, createSubstoreSQL, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, store.MatchType, store.SerialNumberEnd, store.FromModelName, store.OriginalHeaders).Scan(&results[i].ID)
+			if err != nil {
+				log.Errorf("Error creating the sub-store model %s: %v", log.MaskSerial(store.SerialNumber), err)
+				results[i].Error = err.Error()
+				return err
+			}
+			if err := hashSubstoreSerial(ctx, tx, results[i].ID); err != nil {
+				results[i].Error = err.Error()
+				return err
+			}
+		}
+		return nil
+	})
+
+	// The IDs of the new sub-store models are not kept when the transaction is rolled back
+	if err != nil {
+		for i := range results {
+			results[i].ID = 0
+		}
+	}
+	return err
+}
+
+// checkSubstoreRules checks that a device of the original or pivoted model cannot match the sub-store
+// model and another sub-store model, as a device is pivoted to one sub-store model, and that the pivots
+// of the sub-store model continue a chain of pivots. The pending sub-store models are not stored yet
+func (db *DB) checkSubstoreRules(ctx context.Context, store Substore, pending ...Substore) error {
+	stores, err := db.querySubstoreMatches(ctx, listFromModelSubstoresSQL, store.FromModelID, store.ID)
+	if err != nil {
+		return fmt.Errorf("error checking the overlaps of the sub-store model: %v", err)
+	}
+
+	for _, s := range stores {
+		if store.FromModelName == s.FromModelName && store.Overlaps(s) {
+			return fmt.Errorf("the serial numbers of the sub-store model overlap with the sub-store model %d (%s, %s)", s.ID, s.Store, s.ModelName)
+		}
+	}
+	return checkSubstoreChain(store, append(stores, pending...))
+}
+
 // HealthCheck returns an error if there is a problem talking to the underlying Datastore
-func (db *DB) HealthCheck() error {
-	_, err := db.Exec("select 1;")
+func (db *DB) HealthCheck(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, "select 1;")
 	return err
 }
 
-// ListSubstores returns a list of sub-stores
-func (db *DB) listSubstores(accountID int) ([]Substore, error) {
-	rows, err := db.Query(listSubstoreSQL, accountID)
+// listSubstores returns a page of the sub-store models of the account
+func (db *DB) listSubstores(ctx context.Context, accountID int, filter SubstoreFilter) ([]Substore, error) {
+	return db.querySubstoresPage(ctx, listSubstoresSQLBuilder(accountID, filter))
+}
+
+// listSubstoresFilteredByUser returns a page of the sub-store models, if the account is one of the user's
+func (db *DB) listSubstoresFilteredByUser(ctx context.Context, accountID int, username string, filter SubstoreFilter) ([]Substore, error) {
+	listSQL := listSubstoresSQLBuilder(accountID, filter).Where(sq.Expr(`EXISTS (
+			SELECT * FROM useraccountlink ua
+			INNER JOIN userinfo u ON ua.user_id=u.id
+			WHERE ua.account_id=s.account_id AND u.username=?`+accountRoleAdminSQL+`)`, username))
+	return db.querySubstoresPage(ctx, listSQL)
+}
+
+// listSubstoresSQLBuilder creates the query for a page of the sub-store models of the account that
+// are not deleted, with the optional search of the serial numbers
+func listSubstoresSQLBuilder(accountID int, filter SubstoreFilter) sq.SelectBuilder {
+	sql := sq.
+		Select(listSubstoresColumnsSQL).
+		From("substore s").
+		Where(sq.Eq{"s.account_id": accountID}).
+		Where("s.deleted_at IS NULL").
+		OrderBy("s.id").
+		PlaceholderFormat(sq.Dollar)
+
+	if filter.Serial != "" {
+		sql = sql.Where(containsLike("s.serial_number", "ILIKE", filter.Serial))
+	}
+	if filter.Limit > 0 {
+		sql = sql.Limit(uint64(filter.Limit))
+	}
+	if filter.Offset > 0 {
+		sql = sql.Offset(uint64(filter.Offset))
+	}
+
+	return sql
+}
+
+func (db *DB) querySubstoresPage(ctx context.Context, listSQL sq.SelectBuilder) ([]Substore, error) {
+	query, args, err := listSQL.ToSql()
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving sub-stores: %v", err)
+	}
+
+	rows, err := db.QueryContext(ctx, query, args...)
 	if err != nil {
 		return nil, fmt.Errorf("error retrieving sub-stores: %v", err)
 	}
 	defer rows.Close()
 
-	return db.rowsToSubstores(rows)
+	return db.rowsToSubstores(ctx, rows)
+}
+
+func (db *DB) deleteSubstore(ctx context.Context, storeID int) (string, error) {
+	return db.deleteSubstoreFilteredByUser(ctx, storeID, anyUserFilter)
 }
 
-// listSubstoresFilteredByUser returns a list of sub-stores
-func (db *DB) listSubstoresFilteredByUser(accountID int, username string) ([]Substore, error) {
-	rows, err := db.Query(listUserSubstoreSQL, accountID, username)
+func (db *DB) deleteSubstoreFilteredByUser(ctx context.Context, storeID int, username string) (string, error) {
+	var (
+		result sql.Result
+		err    error
+	)
+
+	if len(username) == 0 {
+		result, err = db.ExecContext(ctx, deleteSubstoreSQL, storeID, time.Now().UTC())
+	} else {
+		result, err = db.ExecContext(ctx, deleteSubstoreForUserSQL, storeID, username, time.Now().UTC())
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error retrieving sub-stores of a user: %v", err)
+		return "", fmt.Errorf("error deleting the database sub-store model %d: %v", storeID, err)
 	}
-	defer rows.Close()
 
-	return db.rowsToSubstores(rows)
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "error-store-not-found", fmt.Errorf("error deleting the database sub-store model %d: the sub-store model is not found", storeID)
+	}
+	return "", nil
 }
 
-func (db *DB) deleteSubstore(storeID int) (string, error) {
-	return db.deleteSubstoreFilteredByUser(storeID, anyUserFilter)
+func (db *DB) restoreSubstore(ctx context.Context, storeID int) (string, error) {
+	return db.restoreSubstoreFilteredByUser(ctx, storeID, anyUserFilter)
 }
 
-func (db *DB) deleteSubstoreFilteredByUser(storeID int, username string) (string, error) {
-	var err error
+// restoreSubstoreFilteredByUser reverts the deletion of a sub-store model
+func (db *DB) restoreSubstoreFilteredByUser(ctx context.Context, storeID int, username string) (string, error) {
+	// The restored sub-store model must not overlap with the sub-store models that have been added since
+	stores, err := db.querySubstoreMatches(ctx, getDeletedSubstoreSQL, storeID)
+	if err == nil && len(stores) == 1 {
+		if err := db.checkSubstoreRules(ctx, stores[0]); err != nil {
+			return "error-store-overlap", err
+		}
+	}
 
+	var result sql.Result
 	if len(username) == 0 {
-		_, err = db.Exec(deleteSubstoreSQL, storeID)
+		result, err = db.ExecContext(ctx, restoreSubstoreSQL, storeID)
 	} else {
-		_, err = db.Exec(deleteSubstoreForUserSQL, storeID, username)
+		result, err = db.ExecContext(ctx, restoreSubstoreForUserSQL, storeID, username)
 	}
 	if err != nil {
-		return "", fmt.Errorf("error deleting the database sub-store model %d: %v", storeID, err)
+		return "", fmt.Errorf("error restoring the database sub-store model %d: %v", storeID, err)
 	}
 
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return "error-store-not-found", fmt.Errorf("error restoring the database sub-store model %d: the sub-store model is not found or is not deleted", storeID)
+	}
 	return "", nil
 }
 
-func (db *DB) rowsToSubstores(rows *sql.Rows) ([]Substore, error) {
+// listDeletedSubstores returns the deleted sub-stores of the account
+func (db *DB) listDeletedSubstores(ctx context.Context, accountID int) ([]Substore, error) {
+	return db.listDeletedSubstoresFilteredByUser(ctx, accountID, anyUserFilter)
+}
+
+// listDeletedSubstoresFilteredByUser returns the deleted sub-stores of the account of the user
+func (db *DB) listDeletedSubstoresFilteredByUser(ctx context.Context, accountID int, username string) ([]Substore, error) {
+	var (
+		rows *sql.Rows
+		err  error
+	)
+
+	if len(username) == 0 {
+		rows, err = db.QueryContext(ctx, listDeletedSubstoreSQL, accountID)
+	} else {
+		rows, err = db.QueryContext(ctx, listDeletedUserSubstoreSQL, accountID, username)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving deleted sub-stores: %v", err)
+	}
+	defer rows.Close()
+
+	return db.rowsToSubstores(ctx, rows)
+}
+
+// rowsToSubstores reads the sub-store models, with their original models. The sub-store models of
+// an account have few original models, so each of them is fetched once
+func (db *DB) rowsToSubstores(ctx context.Context, rows *sql.Rows) ([]Substore, error) {
 	stores := []Substore{}
+	models := map[int]Model{}
 
 	for rows.Next() {
 		store := Substore{}
-		err := rows.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName)
+		err := rows.Scan(&store.ID, &store.AccountID, &store.FromModelID, &store.Store, &store.SerialNumber, &store.ModelName, &store.MatchType, &store.SerialNumberEnd, &store.FromModelName, &store.OriginalHeaders)
 		if err != nil {
 			return nil, fmt.Errorf("error scanning for substore: %v", err)
 		}
 
-		store.FromModel, err = db.getModel(store.FromModelID)
-		if err != nil {
-			return nil, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
+		model, ok := models[store.FromModelID]
+		if !ok {
+			model, err = db.getModel(ctx, store.FromModelID)
+			if err != nil {
+				return nil, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
+			}
+			models[store.FromModelID] = model
 		}
+		store.FromModel = model
 
 		stores = append(stores, store)
 	}
@@ -271,17 +621,21 @@ func (db *DB) rowsToSubstores(rows *sql.Rows) ([]Substore, error) {
 	return stores, nil
 }
 
-func (db *DB) updateSubstore(store Substore) error {
-	return db.updateSubstoreFilteredByUser(store, anyUserFilter)
+func (db *DB) updateSubstore(ctx context.Context, store Substore) error {
+	return db.updateSubstoreFilteredByUser(ctx, store, anyUserFilter)
 }
 
-func (db *DB) updateSubstoreFilteredByUser(store Substore, username string) error {
-	var err error
+func (db *DB) updateSubstoreFilteredByUser(ctx context.Context, store Substore, username string) error {
+	store.MatchType = store.matchType()
+	err := db.checkSubstoreRules(ctx, store)
+	if err != nil {
+		return err
+	}
 
 	if len(username) == 0 {
-		_, err = db.Exec(updateSubstoreSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName)
+		_, err = db.ExecContext(ctx, updateSubstoreSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, store.MatchType, store.SerialNumberEnd, store.FromModelName, store.OriginalHeaders)
 	} else {
-		_, err = db.Exec(updateSubstoreForUserSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, username)
+		_, err = db.ExecContext(ctx, updateSubstoreForUserSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, store.MatchType, store.SerialNumberEnd, username, store.FromModelName, store.OriginalHeaders)
 	}
 	if err, ok := err.(*pq.Error); ok {
 		// This is a PostgreSQL error...
@@ -291,6 +645,11 @@ func (db *DB) updateSubstoreFilteredByUser(store Substore, username string) erro
 				"this model, serial-number and sub-store (%d, %s, %s)", store.FromModelID, store.SerialNumber, store.Store)
 		}
 	}
+	if err == nil {
+		err = db.transaction(ctx, func(tx *sql.Tx) error {
+			return hashSubstoreSerial(ctx, tx, store.ID)
+		})
+	}
 	if err != nil {
 		return fmt.Errorf("error updating the database sub-store with model, serial-number and sub-store (%d, %s, %s): %v",
 			store.FromModelID, store.SerialNumber, store.Store, err)
@@ -0,0 +1,210 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// The ways that a sub-store model matches the serial numbers of the devices. An exact serial
+// number is looked up before the ranges and the patterns
+const (
+	SubstoreMatchExact = "exact"
+	SubstoreMatchRange = "range"
+	SubstoreMatchGlob  = "glob"
+	SubstoreMatchRegex = "regex"
+)
+
+// substoreMatchOrder is the order that the sub-store models are matched in, when more than one matches
+var substoreMatchOrder = map[string]int{SubstoreMatchExact: 0, SubstoreMatchRange: 1, SubstoreMatchGlob: 2, SubstoreMatchRegex: 3}
+
+// serialRange is a range of serial numbers with the same prefix and a numeric suffix, e.g. A0001 to A0999.
+// When the bounds have the same number of digits, the serial numbers must have that many digits too
+type serialRange struct {
+	prefix   string
+	from, to uint64
+	width    int
+}
+
+// matchType returns the match of the sub-store model, which is an exact serial number by default
+func (s Substore) matchType() string {
+	if len(s.MatchType) == 0 {
+		return SubstoreMatchExact
+	}
+	return s.MatchType
+}
+
+// Matches checks whether the sub-store model applies to the serial number of a device
+func (s Substore) Matches(serialNumber string) bool {
+	switch s.matchType() {
+	case SubstoreMatchExact:
+		return s.SerialNumber == serialNumber
+	case SubstoreMatchRange:
+		r, err := parseSerialRange(s.SerialNumber, s.SerialNumberEnd)
+		return err == nil && r.contains(serialNumber)
+	case SubstoreMatchGlob:
+		matched, err := path.Match(s.SerialNumber, serialNumber)
+		return err == nil && matched
+	case SubstoreMatchRegex:
+		re, err := compileSerialRegex(s.SerialNumber)
+		return err == nil && re.MatchString(serialNumber)
+	default:
+		return false
+	}
+}
+
+// Overlaps checks whether a device could match both sub-store models. The ranges are compared with each
+// other, and the patterns are checked against the serial numbers and the bounds of the ranges, as two
+// patterns cannot be compared beyond being the same
+func (s Substore) Overlaps(other Substore) bool {
+	a, b := s, other
+	if substoreMatchOrder[a.matchType()] > substoreMatchOrder[b.matchType()] {
+		a, b = b, a
+	}
+
+	switch a.matchType() {
+	case SubstoreMatchExact:
+		return b.Matches(a.SerialNumber)
+	case SubstoreMatchRange:
+		if b.matchType() == SubstoreMatchRange {
+			return rangesOverlap(a, b)
+		}
+		return b.Matches(a.SerialNumber) || b.Matches(a.SerialNumberEnd)
+	default:
+		return a.matchType() == b.matchType() && a.SerialNumber == b.SerialNumber
+	}
+}
+
+// validateSubstoreMatch checks the serial number, or the range or pattern, of a sub-store model
+func validateSubstoreMatch(store Substore) error {
+	switch store.matchType() {
+	case SubstoreMatchExact:
+		return nil
+	case SubstoreMatchRange:
+		_, err := parseSerialRange(store.SerialNumber, store.SerialNumberEnd)
+		return err
+	case SubstoreMatchGlob:
+		if _, err := path.Match(store.SerialNumber, ""); err != nil {
+			return fmt.Errorf("invalid serial-number pattern '%s': %v", store.SerialNumber, err)
+		}
+		return nil
+	case SubstoreMatchRegex:
+		if _, err := compileSerialRegex(store.SerialNumber); err != nil {
+			return fmt.Errorf("invalid serial-number expression '%s': %v", store.SerialNumber, err)
+		}
+		return nil
+	default:
+		return fmt.Errorf("the match must be one of %s, %s, %s or %s", SubstoreMatchExact, SubstoreMatchRange, SubstoreMatchGlob, SubstoreMatchRegex)
+	}
+}
+
+// matchingSubstore returns the sub-store model that matches the serial number, in the order of the matches
+func matchingSubstore(stores []Substore, serialNumber string) (Substore, bool) {
+	sort.SliceStable(stores, func(i, j int) bool {
+		if stores[i].matchType() != stores[j].matchType() {
+			return substoreMatchOrder[stores[i].matchType()] < substoreMatchOrder[stores[j].matchType()]
+		}
+		return stores[i].ID < stores[j].ID
+	})
+
+	for _, s := range stores {
+		if s.Matches(serialNumber) {
+			return s, true
+		}
+	}
+	return Substore{}, false
+}
+
+// compileSerialRegex compiles the expression of a sub-store model, which has to match the whole serial number
+func compileSerialRegex(expr string) (*regexp.Regexp, error) {
+	return regexp.Compile("^(?:" + expr + ")$")
+}
+
+// parseSerialRange parses the bounds of a range of serial numbers
+func parseSerialRange(start, end string) (serialRange, error) {
+	prefix, fromDigits := splitSerialNumber(start)
+	endPrefix, toDigits := splitSerialNumber(end)
+	if len(fromDigits) == 0 || len(toDigits) == 0 {
+		return serialRange{}, fmt.Errorf("the serial-number range %s to %s must end with a number", start, end)
+	}
+	if prefix != endPrefix {
+		return serialRange{}, fmt.Errorf("the serial-number range %s to %s must have the same prefix", start, end)
+	}
+
+	from, err := strconv.ParseUint(fromDigits, 10, 64)
+	if err != nil {
+		return serialRange{}, fmt.Errorf("invalid serial-number range %s to %s: %v", start, end, err)
+	}
+	to, err := strconv.ParseUint(toDigits, 10, 64)
+	if err != nil {
+		return serialRange{}, fmt.Errorf("invalid serial-number range %s to %s: %v", start, end, err)
+	}
+	if from > to {
+		return serialRange{}, fmt.Errorf("the serial-number range %s to %s must not end before it starts", start, end)
+	}
+
+	r := serialRange{prefix: prefix, from: from, to: to}
+	if len(fromDigits) == len(toDigits) {
+		r.width = len(fromDigits)
+	}
+	return r, nil
+}
+
+// splitSerialNumber splits a serial number into its prefix and its numeric suffix
+func splitSerialNumber(serialNumber string) (string, string) {
+	i := len(serialNumber)
+	for i > 0 && serialNumber[i-1] >= '0' && serialNumber[i-1] <= '9' {
+		i--
+	}
+	return serialNumber[:i], serialNumber[i:]
+}
+
+// contains checks whether the serial number is in the range
+func (r serialRange) contains(serialNumber string) bool {
+	prefix, digits := splitSerialNumber(serialNumber)
+	if prefix != r.prefix || len(digits) == 0 || (r.width > 0 && len(digits) != r.width) {
+		return false
+	}
+
+	n, err := strconv.ParseUint(digits, 10, 64)
+	return err == nil && n >= r.from && n <= r.to
+}
+
+// rangesOverlap checks whether two ranges of serial numbers have a serial number in common
+func rangesOverlap(a, b Substore) bool {
+	ra, err := parseSerialRange(a.SerialNumber, a.SerialNumberEnd)
+	if err != nil {
+		return false
+	}
+	rb, err := parseSerialRange(b.SerialNumber, b.SerialNumberEnd)
+	if err != nil {
+		return false
+	}
+
+	if ra.prefix != rb.prefix || (ra.width > 0 && rb.width > 0 && ra.width != rb.width) {
+		return false
+	}
+	return ra.from <= rb.to && rb.from <= ra.to
+}
@@ -0,0 +1,117 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "testing"
+
+func TestSubstoreMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		store  Substore
+		serial string
+		want   bool
+	}{
+		{"exact", Substore{SerialNumber: "A1"}, "A1", true},
+		{"exact-other", Substore{MatchType: SubstoreMatchExact, SerialNumber: "A1"}, "A2", false},
+		{"range-start", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, "A0100", true},
+		{"range-end", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, "A0199", true},
+		{"range-after", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, "A0200", false},
+		{"range-width", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, "A150", false},
+		{"range-prefix", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, "B0150", false},
+		{"range-any-width", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A1", SerialNumberEnd: "A1000"}, "A150", true},
+		{"range-not-number", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A1", SerialNumberEnd: "A1000"}, "A15X", false},
+		{"glob", Substore{MatchType: SubstoreMatchGlob, SerialNumber: "LINE1-*"}, "LINE1-0042", true},
+		{"glob-other", Substore{MatchType: SubstoreMatchGlob, SerialNumber: "LINE1-*"}, "LINE2-0042", false},
+		{"regex", Substore{MatchType: SubstoreMatchRegex, SerialNumber: `R[0-9]{4}`}, "R1234", true},
+		{"regex-anchored", Substore{MatchType: SubstoreMatchRegex, SerialNumber: `R[0-9]{4}`}, "XR12345", false},
+		{"invalid-type", Substore{MatchType: "invalid", SerialNumber: "A1"}, "A1", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.store.Matches(tt.serial); got != tt.want {
+				t.Errorf("Substore.Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubstoreOverlaps(t *testing.T) {
+	exact := Substore{SerialNumber: "A0150"}
+	rangeA := Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}
+	glob := Substore{MatchType: SubstoreMatchGlob, SerialNumber: "A01*"}
+	regex := Substore{MatchType: SubstoreMatchRegex, SerialNumber: `B[0-9]+`}
+
+	tests := []struct {
+		name string
+		a, b Substore
+		want bool
+	}{
+		{"exact-same", exact, Substore{SerialNumber: "A0150"}, true},
+		{"exact-other", exact, Substore{SerialNumber: "A0151"}, false},
+		{"exact-range", exact, rangeA, true},
+		{"range-exact", rangeA, exact, true},
+		{"exact-outside-range", Substore{SerialNumber: "A0250"}, rangeA, false},
+		{"range-overlap", rangeA, Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0199", SerialNumberEnd: "A0299"}, true},
+		{"range-after", rangeA, Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0200", SerialNumberEnd: "A0299"}, false},
+		{"range-other-prefix", rangeA, Substore{MatchType: SubstoreMatchRange, SerialNumber: "B0100", SerialNumberEnd: "B0199"}, false},
+		{"range-other-width", rangeA, Substore{MatchType: SubstoreMatchRange, SerialNumber: "A100", SerialNumberEnd: "A199"}, false},
+		{"range-glob", rangeA, glob, true},
+		{"glob-range", glob, rangeA, true},
+		{"range-regex", rangeA, regex, false},
+		{"exact-regex", Substore{SerialNumber: "B12"}, regex, true},
+		{"glob-same", glob, Substore{MatchType: SubstoreMatchGlob, SerialNumber: "A01*"}, true},
+		{"glob-regex", glob, regex, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.a.Overlaps(tt.b); got != tt.want {
+				t.Errorf("Substore.Overlaps() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateSubstoreMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		store   Substore
+		wantErr bool
+	}{
+		{"exact", Substore{SerialNumber: "A1"}, false},
+		{"range", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "A0199"}, false},
+		{"range-no-end", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100"}, true},
+		{"range-no-number", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A", SerialNumberEnd: "B"}, true},
+		{"range-prefix", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0100", SerialNumberEnd: "B0199"}, true},
+		{"range-reversed", Substore{MatchType: SubstoreMatchRange, SerialNumber: "A0199", SerialNumberEnd: "A0100"}, true},
+		{"glob", Substore{MatchType: SubstoreMatchGlob, SerialNumber: "A*"}, false},
+		{"glob-invalid", Substore{MatchType: SubstoreMatchGlob, SerialNumber: "A["}, true},
+		{"regex", Substore{MatchType: SubstoreMatchRegex, SerialNumber: "A.+"}, false},
+		{"regex-invalid", Substore{MatchType: SubstoreMatchRegex, SerialNumber: "A(+"}, true},
+		{"invalid-type", Substore{MatchType: "invalid", SerialNumber: "A1"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateSubstoreMatch(tt.store); (err != nil) != tt.wantErr {
+				t.Errorf("validateSubstoreMatch() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+const createSubstorePivotTableSQL = `
+	CREATE TABLE IF NOT EXISTS substorepivot (
+		id               serial primary key not null,
+		substore_id      int not null,
+		from_model_id    int not null,
+		serial_number    varchar(200) not null,
+		from_model_name  varchar(200) not null,
+		model_name       varchar(200) not null,
+		created          timestamp not null
+	)
+`
+
+const createSubstorePivotIndexSQL = `
+	CREATE INDEX IF NOT EXISTS substorepivot_serial_idx ON substorepivot (from_model_id, serial_number)`
+
+const createSubstorePivotSQL = `
+	INSERT INTO substorepivot (substore_id, from_model_id, serial_number, from_model_name, model_name, created)
+	VALUES ($1, $2, $3, $4, $5, $6)`
+
+const listSubstorePivotSQL = `
+	SELECT p.id, p.substore_id, p.from_model_id, p.serial_number, p.from_model_name, p.model_name, p.created
+	FROM substorepivot p
+	WHERE p.from_model_id=$1 AND p.serial_number=$2
+	ORDER BY p.id`
+
+const listUserSubstorePivotSQL = `
+	SELECT p.id, p.substore_id, p.from_model_id, p.serial_number, p.from_model_name, p.model_name, p.created
+	FROM substorepivot p
+	INNER JOIN substore s ON s.id = p.substore_id
+	INNER JOIN useraccountlink ua ON s.account_id = ua.account_id
+	INNER JOIN userinfo u ON ua.user_id = u.id
+	WHERE p.from_model_id=$1 AND p.serial_number=$2 AND u.username=$3` + accountRoleAdminSQL + `
+	ORDER BY p.id`
+
+const listSubstorePivotForSubstoreUserSQL = `
+	SELECT p.id, p.substore_id, p.from_model_id, p.serial_number, p.from_model_name, p.model_name, p.created
+	FROM substorepivot p
+	INNER JOIN substore s ON s.id = p.substore_id
+	INNER JOIN usersubstorelink l ON s.account_id = l.account_id AND s.store = l.store
+	INNER JOIN userinfo u ON l.user_id = u.id
+	WHERE p.from_model_id=$1 AND p.serial_number=$2 AND u.username=$3
+	ORDER BY p.id`
+
+// SubstorePivot is a pivot of a device to a sub-store model, so the history of the pivots of a serial
+// number is kept when the device is pivoted again. The from model name is the model that the device
+// is pivoted from, which is the original model or a pivoted model
+type SubstorePivot struct {
+	ID            int       `json:"id"`
+	SubstoreID    int       `json:"substoreID"`
+	FromModelID   int       `json:"fromModelID"`
+	SerialNumber  string    `json:"serialnumber"`
+	FromModelName string    `json:"frommodelname"`
+	ModelName     string    `json:"modelname"`
+	Created       time.Time `json:"created"`
+}
+
+// CreateSubstorePivotTable creates the database table for the history of the pivots
+func (db *DB) CreateSubstorePivotTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createSubstorePivotTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createSubstorePivotIndexSQL)
+	return err
+}
+
+// CreateSubstorePivot records the pivot of a device to a sub-store model
+func (db *DB) CreateSubstorePivot(ctx context.Context, pivot SubstorePivot) error {
+	_, err := db.ExecContext(ctx, createSubstorePivotSQL, pivot.SubstoreID, pivot.FromModelID, pivot.SerialNumber, pivot.FromModelName, pivot.ModelName, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("error recording the pivot of %s to the sub-store model %d: %v", pivot.SerialNumber, pivot.SubstoreID, err)
+	}
+	return nil
+}
+
+// ListAllowedSubstorePivots returns the history of the pivots of a device of the original model, if
+// the user is authorized to see it
+func (db *DB) ListAllowedSubstorePivots(ctx context.Context, fromModelID int, serialNumber string, authorization User) ([]SubstorePivot, error) {
+	switch authorization.Role {
+	case Invalid: // Authentication disabled
+		fallthrough
+	case Superuser:
+		return db.listSubstorePivots(ctx, listSubstorePivotSQL, fromModelID, serialNumber)
+	case Admin:
+		return db.listSubstorePivots(ctx, listUserSubstorePivotSQL, fromModelID, serialNumber, authorization.Username)
+	case SubstoreAdmin:
+		return db.listSubstorePivots(ctx, listSubstorePivotForSubstoreUserSQL, fromModelID, serialNumber, authorization.Username)
+	default:
+		return []SubstorePivot{}, nil
+	}
+}
+
+func (db *DB) listSubstorePivots(ctx context.Context, query string, args ...interface{}) ([]SubstorePivot, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the pivots: %v", err)
+	}
+	defer rows.Close()
+
+	pivots := []SubstorePivot{}
+	for rows.Next() {
+		p := SubstorePivot{}
+		if err := rows.Scan(&p.ID, &p.SubstoreID, &p.FromModelID, &p.SerialNumber, &p.FromModelName, &p.ModelName, &p.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the pivots: %v", err)
+		}
+		pivots = append(pivots, p)
+	}
+	return pivots, rows.Err()
+}
@@ -0,0 +1,95 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ListAllowedSubstoreUsers returns the sub-store admins of the account, if the user is authorized to see them
+func (db *DB) ListAllowedSubstoreUsers(ctx context.Context, accountID int, authorization User) ([]SubstoreUser, error) {
+	if !db.substoreUserAccountAllowed(ctx, accountID, authorization) {
+		return []SubstoreUser{}, nil
+	}
+
+	return db.listSubstoreUsers(ctx, accountID)
+}
+
+// CreateAllowedSubstoreUser validates and creates a sub-store admin for the account,
+// if the user is authorized to do it. The role of the new user is always the sub-store admin
+func (db *DB) CreateAllowedSubstoreUser(ctx context.Context, accountID int, user SubstoreUser, authorization User) (int, error) {
+	if !db.substoreUserAccountAllowed(ctx, accountID, authorization) {
+		return 0, errors.New("You do not have permissions to this account")
+	}
+
+	user.Role = SubstoreAdmin
+
+	apiKey, err := buildValidOrDefaultAPIKey(user.APIKey)
+	if err != nil {
+		return 0, errors.New("Error in generating a valid API key")
+	}
+	user.APIKey = apiKey
+
+	err = validateUser(user.User)
+	if err != nil {
+		return 0, err
+	}
+
+	if len(user.Stores) == 0 {
+		return 0, errors.New("At least one sub-store must be selected")
+	}
+	for _, store := range user.Stores {
+		if err = validateNotEmpty("Sub-store name", store); err != nil {
+			return 0, err
+		}
+		if !db.checkAccountStore(ctx, accountID, store) {
+			return 0, fmt.Errorf("The sub-store %s is not a sub-store of the account", store)
+		}
+	}
+
+	return db.createSubstoreUser(ctx, accountID, user)
+}
+
+// DeleteAllowedSubstoreUser deletes a sub-store admin of the account, if the user is authorized to do it
+func (db *DB) DeleteAllowedSubstoreUser(ctx context.Context, accountID, userID int, authorization User) error {
+	if !db.substoreUserAccountAllowed(ctx, accountID, authorization) {
+		return errors.New("You do not have permissions to this account")
+	}
+
+	return db.deleteSubstoreUser(ctx, accountID, userID)
+}
+
+// substoreUserAccountAllowed checks that the user can manage the sub-store admins of the account
+func (db *DB) substoreUserAccountAllowed(ctx context.Context, accountID int, authorization User) bool {
+	switch authorization.Role {
+	case Invalid: // Authentication is disabled
+		fallthrough
+	case Superuser:
+		fallthrough
+	case Admin:
+		acc, err := db.GetAccountByID(ctx, accountID, authorization)
+		return err == nil && acc.ID != 0
+	default:
+		return false
+	}
+}
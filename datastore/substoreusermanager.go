@@ -0,0 +1,313 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	sq "github.com/Masterminds/squirrel"
+)
+
+const createUserSubstoreLinkTableSQL = `
+	CREATE TABLE IF NOT EXISTS usersubstorelink (
+		user_id          int references userinfo not null,
+		account_id       int references account not null,
+		store            varchar(200) not null
+	)
+`
+
+const createUserSubstoreLinkIndexSQL = `
+	CREATE UNIQUE INDEX IF NOT EXISTS usersubstorelink_idx ON usersubstorelink
+	(user_id, account_id, store)`
+
+const listSubstoreUsersSQL = `
+	SELECT DISTINCT u.id, u.username, u.name, u.email, u.userrole, u.api_key
+	FROM userinfo u
+	INNER JOIN usersubstorelink l ON l.user_id = u.id
+	WHERE l.account_id=$1
+	ORDER BY u.username`
+
+const listSubstoreUserStoresSQL = `
+	SELECT store FROM usersubstorelink
+	WHERE user_id=$1 AND account_id=$2
+	ORDER BY store`
+
+const linkSubstoreToUserSQL = "INSERT INTO usersubstorelink (user_id, account_id, store) VALUES ($1,$2,$3)"
+const deleteUserSubstoresSQL = "DELETE FROM usersubstorelink WHERE user_id=$1"
+
+const checkSubstoreUserSQL = `
+	SELECT EXISTS(
+		SELECT * FROM usersubstorelink l
+		INNER JOIN userinfo u ON l.user_id = u.id
+		WHERE u.id=$1 AND l.account_id=$2 AND u.userrole=$3
+	)`
+
+const checkAccountStoreSQL = `
+	SELECT EXISTS(
+		SELECT * FROM substore WHERE account_id=$1 AND store=$2 AND deleted_at IS NULL
+	)`
+
+// Queries for the sub-stores and signing logs of a sub-store admin
+const getSubstoreForSubstoreUserSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers
+	FROM substore s
+	INNER JOIN usersubstorelink l ON s.account_id = l.account_id AND s.store = l.store
+	INNER JOIN userinfo u ON l.user_id = u.id
+	WHERE s.from_model_id=$1 AND s.from_model_name=$2 AND s.serial_number=$3 AND s.match_type='exact' AND s.deleted_at IS NULL
+	AND u.username=$4`
+
+const listSubstoreMatchesForSubstoreUserSQL = `
+	SELECT s.id, s.account_id, s.from_model_id, s.store, s.serial_number, s.model_name, s.match_type, s.serial_end, s.from_model_name, s.original_headers
+	FROM substore s
+	INNER JOIN usersubstorelink l ON s.account_id = l.account_id AND s.store = l.store
+	INNER JOIN userinfo u ON l.user_id = u.id
+	WHERE s.from_model_id=$1 AND s.from_model_name=$2 AND s.match_type<>'exact' AND s.deleted_at IS NULL
+	AND u.username=$3`
+
+// The sub-store admin can remap the devices, but cannot move them to another sub-store,
+// nor to a model of another brand
+const updateSubstoreForSubstoreUserSQL = `
+	UPDATE substore s
+	SET from_model_id=$3, serial_number=$5, model_name=$6, match_type=$7, serial_end=$8, from_model_name=$10, original_headers=$11
+	FROM usersubstorelink l
+	INNER JOIN userinfo u ON l.user_id=u.id
+	WHERE s.id=$1 AND s.deleted_at IS NULL AND u.username=$9
+	AND s.account_id=$2 AND s.store=$4
+	AND l.account_id=s.account_id AND l.store=s.store
+	AND EXISTS (SELECT 1 FROM model m INNER JOIN account a ON a.authority_id=m.brand_id WHERE m.id=$3 AND a.id=s.account_id)`
+
+// substoreUserDeviceFilterSQL restricts the signing logs to the devices of the sub-stores of the user,
+// signed for either the original or the pivoted model. The devices of a deleted sub-store model are
+// hidden until it is restored
+const substoreUserDeviceFilterSQL = `
+	SELECT * FROM substore ss
+	INNER JOIN model m ON m.id = ss.from_model_id
+	INNER JOIN usersubstorelink l ON ss.account_id = l.account_id AND ss.store = l.store
+	INNER JOIN userinfo u ON l.user_id = u.id
+	WHERE m.brand_id=s.make AND (m.name=s.model OR ss.model_name=s.model) AND s.serial_number IN (ss.serial_number, ss.serial_hash)
+	AND ss.deleted_at IS NULL`
+
+const filterValuesModelSigningLogForSubstoreUserSQL = `
+	SELECT DISTINCT model FROM signinglog s
+	WHERE EXISTS(` + substoreUserDeviceFilterSQL + ` AND u.username=$1)
+	AND s.make = $2
+	ORDER BY model`
+
+// SubstoreUser is a user that is delegated the management of the devices of
+// specific sub-stores of an account
+type SubstoreUser struct {
+	User
+	Stores []string `json:"stores"`
+}
+
+// CreateUserSubstoreLinkTable creates the table to link the sub-store admins to the sub-stores
+func (db *DB) CreateUserSubstoreLinkTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createUserSubstoreLinkTableSQL)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.ExecContext(ctx, createUserSubstoreLinkIndexSQL)
+	return err
+}
+
+// listSubstoreUsers returns the sub-store admins of an account
+func (db *DB) listSubstoreUsers(ctx context.Context, accountID int) ([]SubstoreUser, error) {
+	rows, err := db.QueryContext(ctx, listSubstoreUsersSQL, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the sub-store users: %v", err)
+	}
+	defer rows.Close()
+
+	users := []SubstoreUser{}
+	for rows.Next() {
+		user := SubstoreUser{}
+		err := rows.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving the sub-store users: %v", err)
+		}
+		users = append(users, user)
+	}
+
+	for i := range users {
+		users[i].Stores, err = db.listSubstoreUserStores(ctx, users[i].ID, accountID)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return users, nil
+}
+
+func (db *DB) listSubstoreUserStores(ctx context.Context, userID, accountID int) ([]string, error) {
+	rows, err := db.QueryContext(ctx, listSubstoreUserStoresSQL, userID, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the stores of the sub-store user: %v", err)
+	}
+	defer rows.Close()
+
+	stores := []string{}
+	for rows.Next() {
+		var store string
+		if err := rows.Scan(&store); err != nil {
+			return nil, fmt.Errorf("error retrieving the stores of the sub-store user: %v", err)
+		}
+		stores = append(stores, store)
+	}
+	return stores, nil
+}
+
+// createSubstoreUser creates the sub-store admin and links it to the sub-stores of the account
+func (db *DB) createSubstoreUser(ctx context.Context, accountID int, user SubstoreUser) (int, error) {
+	createdUserID := -1
+
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, createUserSQL, user.Username, user.Name, user.Email, SubstoreAdmin, user.APIKey).Scan(&createdUserID)
+		if err != nil {
+			log.Errorf("Error creating sub-store user %v: %v", user.Username, err)
+			return err
+		}
+
+		for _, store := range user.Stores {
+			_, err = tx.ExecContext(ctx, linkSubstoreToUserSQL, createdUserID, accountID, store)
+			if err != nil {
+				log.Errorf("Error linking sub-store user %v to store %s: %v", user.Username, store, err)
+				return err
+			}
+		}
+		return nil
+	})
+
+	return createdUserID, err
+}
+
+// checkAccountStore checks that the store is a sub-store of the account, so a sub-store admin
+// is only delegated the devices of the account
+func (db *DB) checkAccountStore(ctx context.Context, accountID int, store string) bool {
+	row := db.QueryRowContext(ctx, checkAccountStoreSQL, accountID, store)
+	return db.checkBoolQuery(row)
+}
+
+// deleteSubstoreUser deletes the sub-store admin, when it is linked to the account
+func (db *DB) deleteSubstoreUser(ctx context.Context, accountID, userID int) error {
+	var found bool
+	err := db.QueryRowContext(ctx, checkSubstoreUserSQL, userID, accountID, SubstoreAdmin).Scan(&found)
+	if err != nil {
+		return fmt.Errorf("error retrieving the sub-store user %d: %v", userID, err)
+	}
+	if !found {
+		return fmt.Errorf("cannot find the sub-store user %d for the account", userID)
+	}
+
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, deleteUserSubstoresSQL, userID)
+		if err != nil {
+			log.Errorf("Error deleting the stores of sub-store user %d: %v", userID, err)
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, deleteUserSQL, userID)
+		if err != nil {
+			log.Errorf("Error deleting sub-store user %d: %v", userID, err)
+		}
+		return err
+	})
+}
+
+// listSubstoresForSubstoreUser returns a page of the sub-store models of the stores of the sub-store user
+func (db *DB) listSubstoresForSubstoreUser(ctx context.Context, accountID int, username string, filter SubstoreFilter) ([]Substore, error) {
+	listSQL := listSubstoresSQLBuilder(accountID, filter).Where(sq.Expr(`EXISTS (
+			SELECT * FROM usersubstorelink l
+			INNER JOIN userinfo u ON l.user_id=u.id
+			WHERE l.account_id=s.account_id AND l.store=s.store AND u.username=?)`, username))
+	return db.querySubstoresPage(ctx, listSQL)
+}
+
+func (db *DB) getSubstoreForSubstoreUser(ctx context.Context, fromModelID int, serialNumber, username string) (Substore, error) {
+	store, err := resolveSubstoreChain(func(fromModelName string) (Substore, error) {
+		return db.findSubstore(ctx, getSubstoreForSubstoreUserSQL, listSubstoreMatchesForSubstoreUserSQL, fromModelID, fromModelName, serialNumber, username)
+	})
+	if err != nil {
+		return store, fmt.Errorf("error retrieving database substore for %s, from model %d: %v", serialNumber, fromModelID, err)
+	}
+
+	store.FromModel, err = db.getModel(ctx, store.FromModelID)
+	if err != nil {
+		return store, fmt.Errorf("error retrieving database model %d: %v", store.FromModelID, err)
+	}
+
+	return store, nil
+}
+
+func (db *DB) updateSubstoreForSubstoreUser(ctx context.Context, store Substore, username string) error {
+	store.MatchType = store.matchType()
+	if err := db.checkSubstoreRules(ctx, store); err != nil {
+		return err
+	}
+
+	// The serial hash is updated with the serial number, so the sub-store never has a stale hash
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, updateSubstoreForSubstoreUserSQL, store.ID, store.AccountID, store.FromModelID, store.Store, store.SerialNumber, store.ModelName, store.MatchType, store.SerialNumberEnd, username, store.FromModelName, store.OriginalHeaders)
+		if err != nil {
+			return fmt.Errorf("error updating the database sub-store with model, serial-number and sub-store (%d, %s, %s): %v",
+				store.FromModelID, store.SerialNumber, store.Store, err)
+		}
+
+		if rows, _ := result.RowsAffected(); rows == 0 {
+			return fmt.Errorf("error updating the database sub-store %d: the sub-store is not delegated to the user, or the model is not in the account", store.ID)
+		}
+		return hashSubstoreSerial(ctx, tx, store.ID)
+	})
+}
+
+func (db *DB) listSigningLogForSubstoreUser(ctx context.Context, username string, fromID, limit int, filter SigningLogFilter) ([]SigningLog, error) {
+	listSQL := listSigningLogSQLBuilder(fromID, limit, filter).
+		Where(substoreUserSigningLogFilter(username))
+
+	return db.querySigningLog(ctx, listSQL)
+}
+
+// substoreUserSigningLogFilter restricts the signing logs to the devices of the sub-stores of the user
+func substoreUserSigningLogFilter(username string) sq.Sqlizer {
+	return sq.Expr("EXISTS ("+substoreUserDeviceFilterSQL+" AND u.username=?)", username)
+}
+
+func (db *DB) listSigningLogForAccountForSubstoreUser(ctx context.Context, username, authorityID string, params *SigningLogParams) ([]SigningLog, error) {
+	listSQL := signingLogSQLBuilder(anyUserFilter, authorityID, params).
+		Where(substoreUserSigningLogFilter(username))
+
+	return db.querySigningLogForAccount(ctx, listSQL)
+}
+
+func (db *DB) signingLogFilterValuesForSubstoreUser(ctx context.Context, username, authorityID string) (SigningLogFilters, error) {
+	filters := SigningLogFilters{}
+
+	err := db.filterValuesForField(ctx, username, filterValuesModelSigningLogForSubstoreUserSQL, authorityID, &filters.Models)
+	if err != nil {
+		log.Errorf("Error retrieving filter values: %v", err)
+		return filters, err
+	}
+
+	return filters, nil
+}
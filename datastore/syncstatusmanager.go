@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// The status of the last sync of each resource of the factory with the cloud serial vault. The
+// sync runs in another process, so the status is kept in the database for the status endpoint
+const createSyncStatusTableSQL = `
+	CREATE TABLE IF NOT EXISTS syncstatus (
+		resource         varchar(200) primary key not null,
+		last_run         timestamp not null,
+		last_success     timestamp,
+		synced           int not null default 0,
+		conflicts        int not null default 0,
+		message          text not null default ''
+	)
+`
+
+// The time of the last success is kept when the sync of the resource fails
+const upsertSyncStatusSQL = `
+	INSERT INTO syncstatus (resource, last_run, last_success, synced, conflicts, message)
+	VALUES ($1, $2, $3, $4, $5, $6)
+	ON CONFLICT (resource) DO UPDATE SET last_run=excluded.last_run,
+		last_success=coalesce(excluded.last_success, syncstatus.last_success),
+		synced=excluded.synced, conflicts=excluded.conflicts, message=excluded.message
+`
+
+const listSyncStatusSQL = "SELECT resource, last_run, last_success, synced, conflicts, message FROM syncstatus ORDER BY resource"
+
+// SyncStatus is the last sync of a resource, e.g. the models or the signing logs. The message is
+// the error of the last sync, empty when it succeeded
+type SyncStatus struct {
+	Resource    string     `json:"resource"`
+	LastRun     time.Time  `json:"lastRun"`
+	LastSuccess *time.Time `json:"lastSuccess"`
+	Synced      int        `json:"synced"`
+	Conflicts   int        `json:"conflicts"`
+	Message     string     `json:"message"`
+}
+
+// CreateSyncStatusTable creates the database table for the status of the factory sync
+func (db *DB) CreateSyncStatusTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createSyncStatusTableSQL)
+	return err
+}
+
+// PutSyncStatus records the last sync of a resource. The last success is set when there is no message
+func (db *DB) PutSyncStatus(ctx context.Context, status SyncStatus) error {
+	var lastSuccess *time.Time
+	if len(status.Message) == 0 {
+		lastSuccess = &status.LastRun
+	}
+
+	_, err := db.ExecContext(ctx, upsertSyncStatusSQL, status.Resource, status.LastRun, lastSuccess, status.Synced, status.Conflicts, status.Message)
+	if err != nil {
+		return fmt.Errorf("error recording the sync status: %v", err)
+	}
+	return nil
+}
+
+// ListSyncStatus returns the last sync of the resources
+func (db *DB) ListSyncStatus(ctx context.Context) ([]SyncStatus, error) {
+	rows, err := db.QueryContext(ctx, listSyncStatusSQL)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the sync status: %v", err)
+	}
+	defer rows.Close()
+
+	statuses := []SyncStatus{}
+	for rows.Next() {
+		s := SyncStatus{}
+		if err := rows.Scan(&s.Resource, &s.LastRun, &s.LastSuccess, &s.Synced, &s.Conflicts, &s.Message); err != nil {
+			return nil, fmt.Errorf("error retrieving the sync status: %v", err)
+		}
+		statuses = append(statuses, s)
+	}
+	return statuses, rows.Err()
+}
@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestSyncStatus(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	first := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	if err := db.PutSyncStatus(ctx, SyncStatus{Resource: "models", LastRun: first, Synced: 3, Conflicts: 1}); err != nil {
+		t.Fatalf("PutSyncStatus() error = %v", err)
+	}
+
+	// The last success is kept when the sync fails
+	second := first.Add(time.Hour)
+	if err := db.PutSyncStatus(ctx, SyncStatus{Resource: "models", LastRun: second, Message: "connection refused"}); err != nil {
+		t.Fatalf("PutSyncStatus() error = %v", err)
+	}
+
+	statuses, err := db.ListSyncStatus(ctx)
+	if err != nil || len(statuses) != 1 {
+		t.Fatalf("ListSyncStatus() = %v, %v, want 1 status", statuses, err)
+	}
+	s := statuses[0]
+	if !s.LastRun.Equal(second) || s.LastSuccess == nil || !s.LastSuccess.Equal(first) {
+		t.Errorf("ListSyncStatus() = %v, want the last run %v and the last success %v", s, second, first)
+	}
+	if s.Synced != 0 || s.Conflicts != 0 || s.Message != "connection refused" {
+		t.Errorf("ListSyncStatus() = %v, want the counts and the message of the last run", s)
+	}
+}
+
+func TestSyncConflictSigningLog(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	for _, serial := range []string{"A1", "A2", "A3"} {
+		if err := db.CreateSigningLog(ctx, SigningLog{Make: "alder", Model: "ash", SerialNumber: serial, Fingerprint: "fp-" + serial}); err != nil {
+			t.Fatalf("CreateSigningLog() error = %v", err)
+		}
+	}
+
+	logs, err := db.SyncSigningLog(ctx)
+	if err != nil || len(logs) != 3 {
+		t.Fatalf("SyncSigningLog() = %v, %v, want 3 logs", logs, err)
+	}
+	if err := db.SyncUpdateSigningLog(ctx, logs[0].ID); err != nil {
+		t.Fatalf("SyncUpdateSigningLog() error = %v", err)
+	}
+	if err := db.SyncConflictSigningLog(ctx, logs[1].ID); err != nil {
+		t.Fatalf("SyncConflictSigningLog() error = %v", err)
+	}
+
+	// The synced and the conflicting logs are not sent again
+	logs, err = db.SyncSigningLog(ctx)
+	if err != nil || len(logs) != 1 || logs[0].SerialNumber != "A3" {
+		t.Errorf("SyncSigningLog() = %v, %v, want the A3 log", logs, err)
+	}
+}
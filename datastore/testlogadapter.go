@@ -20,42 +20,43 @@
 
 package datastore
 
+import "context"
 import "errors"
 
 // ListAllowedTestLog return test logs the user is authorized to see
-func (db *DB) ListAllowedTestLog(authorization User) ([]TestLog, error) {
+func (db *DB) ListAllowedTestLog(ctx context.Context, authorization User) ([]TestLog, error) {
 	switch authorization.Role {
 	case Invalid: // Authentication disabled
 		fallthrough
 	case Superuser:
-		return db.listAllTestLog()
+		return db.listAllTestLog(ctx)
 	case SyncUser:
 		fallthrough
 	case Admin:
-		return db.listTestLogFilteredByUser(authorization.Username)
+		return db.listTestLogFilteredByUser(ctx, authorization.Username)
 	default:
 		return []TestLog{}, nil
 	}
 }
 
 // SyncListTestLogs fetches the test logs from the factory database
-func (db *DB) SyncListTestLogs() ([]TestLog, error) {
+func (db *DB) SyncListTestLogs(ctx context.Context) ([]TestLog, error) {
 	if Environ.Config.Driver != "sqlite3" {
 		return nil, errors.New("Only valid within a factory")
 	}
 
-	return db.listAllTestLog()
+	return db.listAllTestLog(ctx)
 }
 
 // UpdateAllowedTestLog marks a test log as synced
-func (db *DB) UpdateAllowedTestLog(ID int, authorization User) error {
+func (db *DB) UpdateAllowedTestLog(ctx context.Context, ID int, authorization User) error {
 	switch authorization.Role {
 	case Superuser:
 		fallthrough
 	case SyncUser:
 		fallthrough
 	case Admin:
-		_, err := db.Exec(updateTestLogSyncedSQL, ID, authorization.Username)
+		_, err := db.ExecContext(ctx, updateTestLogSyncedSQL, ID, authorization.Username)
 		return err
 	default:
 		return errors.New("Not authorized to update a testlog")
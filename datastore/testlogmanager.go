@@ -21,6 +21,7 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
@@ -50,7 +51,7 @@ const listTestLogForUserSQL = `
 		SELECT * FROM account acc
 		INNER JOIN useraccountlink ua on ua.account_id=acc.id
 		INNER JOIN userinfo u on ua.user_id=u.id
-		WHERE acc.authority_id=t.brand_id and u.username=$1
+		WHERE acc.authority_id=t.brand_id and u.username=$1` + accountRoleSyncUserSQL + `
 	) AND synced IS NULL
 `
 const maxIDTestLogSQLite = "SELECT COUNT(*)+1 from testlog"
@@ -61,7 +62,7 @@ const updateTestLogSyncedSQL = `
 		SELECT * FROM account acc
 		INNER JOIN useraccountlink ua on ua.account_id=acc.id
 		INNER JOIN userinfo u on ua.user_id=u.id
-		WHERE acc.authority_id=t.brand_id and u.username=$2
+		WHERE acc.authority_id=t.brand_id and u.username=$2` + accountRoleSyncUserSQL + `
 	) AND t.id = $1
 `
 
@@ -77,13 +78,13 @@ type TestLog struct {
 }
 
 // CreateTestLogTable creates the database table for a test log
-func (db *DB) CreateTestLogTable() error {
-	_, err := db.Exec(createTestLogTableSQL)
+func (db *DB) CreateTestLogTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createTestLogTableSQL)
 	return err
 }
 
 // CreateTestLog keeps a record of a test log
-func (db *DB) CreateTestLog(testLog TestLog) error {
+func (db *DB) CreateTestLog(ctx context.Context, testLog TestLog) error {
 	var err error
 	// Validate the data
 	if !validateStringsNotEmpty(testLog.Brand, testLog.Model, testLog.Filename, testLog.Data) {
@@ -94,31 +95,31 @@ func (db *DB) CreateTestLog(testLog TestLog) error {
 	if InFactory() {
 		// Need to generate our own ID
 		var nextID int
-		err = db.QueryRow(maxIDTestLogSQLite).Scan(&nextID)
+		err = db.QueryRowContext(ctx, maxIDTestLogSQLite).Scan(&nextID)
 		if err != nil {
-			log.Printf("Error retrieving next test log ID: %v\n", err)
+			log.Errorf("Error retrieving next test log ID: %v", err)
 			return err
 		}
 
-		_, err = db.Exec(createTestLogSQLite, nextID, testLog.Brand, testLog.Model, testLog.Filename, testLog.Data)
+		_, err = db.ExecContext(ctx, createTestLogSQLite, nextID, testLog.Brand, testLog.Model, testLog.Filename, testLog.Data)
 	} else {
-		_, err = db.Exec(createTestLogSQL, testLog.Brand, testLog.Model, testLog.Filename, testLog.Data)
+		_, err = db.ExecContext(ctx, createTestLogSQL, testLog.Brand, testLog.Model, testLog.Filename, testLog.Data)
 	}
 
 	// Create the log in the database
 	if err != nil {
-		log.Printf("Error creating the test log: %v\n", err)
+		log.Errorf("Error creating the test log: %v", err)
 		return err
 	}
 
 	return nil
 }
 
-func (db *DB) listAllTestLog() ([]TestLog, error) {
-	return db.listTestLogFilteredByUser(anyUserFilter)
+func (db *DB) listAllTestLog(ctx context.Context) ([]TestLog, error) {
+	return db.listTestLogFilteredByUser(ctx, anyUserFilter)
 }
 
-func (db *DB) listTestLogFilteredByUser(username string) ([]TestLog, error) {
+func (db *DB) listTestLogFilteredByUser(ctx context.Context, username string) ([]TestLog, error) {
 	testLogs := []TestLog{}
 
 	var (
@@ -127,12 +128,12 @@ func (db *DB) listTestLogFilteredByUser(username string) ([]TestLog, error) {
 	)
 
 	if len(username) == 0 {
-		rows, err = db.Query(listTestLogSQL)
+		rows, err = db.QueryContext(ctx, listTestLogSQL)
 	} else {
-		rows, err = db.Query(listTestLogForUserSQL, username)
+		rows, err = db.QueryContext(ctx, listTestLogForUserSQL, username)
 	}
 	if err != nil {
-		log.Printf("Error retrieving test logs: %v\n", err)
+		log.Errorf("Error retrieving test logs: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
@@ -150,11 +151,11 @@ func (db *DB) listTestLogFilteredByUser(username string) ([]TestLog, error) {
 }
 
 // SyncDeleteTestLog remove a test log from the factory
-func (db *DB) SyncDeleteTestLog(ID int) error {
+func (db *DB) SyncDeleteTestLog(ctx context.Context, ID int) error {
 	if Environ.Config.Driver != "sqlite3" {
 		return errors.New("Only valid within a factory")
 	}
 
-	_, err := db.Exec(deleteTestLogSQL, ID)
+	_, err := db.ExecContext(ctx, deleteTestLogSQL, ID)
 	return err
 }
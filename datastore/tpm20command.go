@@ -36,8 +36,8 @@ func (tcmd *tpm20Command) runCommand(command string, args ...string) error {
 	cmd := exec.Command(command, args...)
 	out, err := cmd.Output()
 	if err != nil {
-		log.Printf("Error in TPM %s, %v", command, err)
-		log.Println(string(out[:]))
+		log.Errorf("Error in TPM %s, %v", command, err)
+		log.Error(string(out[:]))
 		return err
 	}
 
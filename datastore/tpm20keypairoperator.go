@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"encoding/base64"
 	"io/ioutil"
 	"os"
@@ -57,19 +58,19 @@ type TPM20KeypairOperator struct {
 func (tpmStore *TPM20KeypairOperator) ImportKeypair(authorityID, keyID, base64PrivateKey string) (string, error) {
 
 	// Get the parent context from the database settings table
-	setting, err := Environ.DB.GetSetting("parent")
+	setting, err := Environ.DB.GetSetting(context.Background(), "parent")
 	if err != nil {
 		return "", nil
 	}
 
 	// Create a KeyedHash key to create the context for the hash key
-	err = tpmStore.createKey(setting.Data, algKeyedHash, "hash", handleHash)
+	err = tpmStore.createKey(context.Background(), setting.Data, algKeyedHash, "hash", handleHash)
 	if err != nil {
 		return "", err
 	}
 
 	// Generate an HMAC hash of the signing-key details
-	authKeyHash, err := tpmStore.generateEncryptionKey(authorityID, keyID)
+	authKeyHash, err := tpmStore.generateEncryptionKey(context.Background(), authorityID, keyID)
 	if err != nil {
 		return "", err
 	}
@@ -89,12 +90,12 @@ func (tpmStore *TPM20KeypairOperator) ImportKeypair(authorityID, keyID, base64Pr
 //  * Load into memory store
 func (tpmStore *TPM20KeypairOperator) UnsealKeypair(authorityID string, keyID string, base64SealedSigningKey string) error {
 
-	return unsealKeypair(authorityID, keyID, base64SealedSigningKey)
+	return unsealKeypair(context.Background(), authorityID, keyID, base64SealedSigningKey)
 }
 
 // generateEncryptionKey takes the authority and the key details and uses the TPM 2.0 module to create a HMAC hash of the data.
 // This hash is used as the key for symmetric encryption of the signing-key.
-func (tpmStore *TPM20KeypairOperator) generateEncryptionKey(authorityID, keyID string) (string, error) {
+func (tpmStore *TPM20KeypairOperator) generateEncryptionKey(ctx context.Context, authorityID, keyID string) (string, error) {
 	// Generate a file with the plain-text base of the symmetric encryption key
 	keyText := crypt.GenerateAuthKey(authorityID, keyID)
 	tmpfile, err := ioutil.TempFile("", "tmp")
@@ -134,7 +135,7 @@ func (tpmStore *TPM20KeypairOperator) generateEncryptionKey(authorityID, keyID s
 
 	// Encrypt the HMAC-ed auth-key for storage
 	base64AuthKeyHash := base64.StdEncoding.EncodeToString([]byte(encryptedAuthKeyHash))
-	Environ.DB.PutSetting(Setting{Code: crypt.GenerateAuthKey(authorityID, keyID), Data: base64AuthKeyHash})
+	Environ.DB.PutSetting(ctx, Setting{Code: crypt.GenerateAuthKey(authorityID, keyID), Data: base64AuthKeyHash})
 
 	// Remove the temporary files
 	os.Remove(tmpfile.Name())
@@ -144,13 +145,13 @@ func (tpmStore *TPM20KeypairOperator) generateEncryptionKey(authorityID, keyID s
 }
 
 // createKey uses the TPM 2.0 module to generate a key and load it into the TPM 2.0 module.
-func (tpmStore *TPM20KeypairOperator) createKey(primaryKeyContextPath, algorithm, prefix, handle string) error {
+func (tpmStore *TPM20KeypairOperator) createKey(ctx context.Context, primaryKeyContextPath, algorithm, prefix, handle string) error {
 
 	// Check if we've already created a key for this operation
-	_, err := Environ.DB.GetSetting(handle)
+	_, err := Environ.DB.GetSetting(ctx, handle)
 	if err == nil {
 		// Already created a key, so let's use it
-		log.Printf("Using the existing key for '%s'", prefix)
+		log.Infof("Using the existing key for '%s'", prefix)
 		return nil
 	}
 
@@ -199,7 +200,7 @@ func (tpmStore *TPM20KeypairOperator) createKey(primaryKeyContextPath, algorithm
 	}
 
 	// Store the handle so we know that it has been created
-	Environ.DB.PutSetting(Setting{Code: handle, Data: handle})
+	Environ.DB.PutSetting(ctx, Setting{Code: handle, Data: handle})
 
 	// Clean up the created files
 	os.Remove(keyContext.Name())
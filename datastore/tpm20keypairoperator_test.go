@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"encoding/base64"
 	"io/ioutil"
 	"testing"
@@ -100,7 +101,7 @@ func TestTPMCreateKey(t *testing.T) {
 
 	tpm20 := TPM20KeypairOperator{config.KeyStorePath, config.KeyStoreSecret, &mockTPM20Command{}}
 
-	err := tpm20.createKey("primaryKeyContextPath", algKeyedHash, "test", "do-not-find")
+	err := tpm20.createKey(context.Background(), "primaryKeyContextPath", algKeyedHash, "test", "do-not-find")
 	if err != nil {
 		t.Errorf("Error creating the TPM key: %v", err)
 	}
@@ -113,7 +114,7 @@ func TestTPMGenerateEncryptionKey(t *testing.T) {
 
 	tpm20 := TPM20KeypairOperator{config.KeyStorePath, config.KeyStoreSecret, &mockTPM20Command{}}
 
-	hashedAuthKey, err := tpm20.generateEncryptionKey("System", "12345678abcdef")
+	hashedAuthKey, err := tpm20.generateEncryptionKey(context.Background(), "System", "12345678abcdef")
 	if err != nil {
 		t.Errorf("Error encrypting the TPM auth-key: %v", err)
 	}
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"io/ioutil"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -31,14 +32,15 @@ import (
 // Main TPM 2.0 operations:
 //  * takeownership
 //  * createprimary
-func TPM2InitializeKeystore(command TPM20Command) error {
-	log.Println("Initialize the TPM Keystore...")
+func TPM2InitializeKeystore(ctx context.Context, command TPM20Command) error {
+	log.Info("Initialize the TPM Keystore...")
 
 	// Generate a unique file name to hold the primary key context
 	primaryKeyContext, err := ioutil.TempFile(Environ.Config.KeyStorePath, ".primary")
 	if err != nil {
 		return err
 	}
+	primaryKeyContext.Close()
 
 	if command == nil {
 		command = &tpm20Command{}
@@ -47,21 +49,21 @@ func TPM2InitializeKeystore(command TPM20Command) error {
 	// Take ownership of the TPM 2.0 module
 	err = command.runCommand("tpm2_takeownership", "-c")
 	if err != nil {
-		log.Printf("Error in TPM takeownership, %v", err)
+		log.Errorf("Error in TPM takeownership, %v", err)
 		return err
 	}
 
 	// Create the primary key in the hierarchy
 	err = command.runCommand("tpm2_createprimary", "-A", "o", "-g", algSHA256, "-G", algRSA, "-C", primaryKeyContext.Name())
 	if err != nil {
-		log.Printf("Error in TPM createprimary, %v", err)
+		log.Errorf("Error in TPM createprimary, %v", err)
 		return err
 	}
 
 	// Save the primary key context filepath in the database
-	err = Environ.DB.PutSetting(Setting{Code: "parent", Data: primaryKeyContext.Name()})
+	err = Environ.DB.PutSetting(ctx, Setting{Code: "parent", Data: primaryKeyContext.Name()})
 	if err != nil {
-		log.Printf("Error in saving the parent key path in settings, %v", err)
+		log.Errorf("Error in saving the parent key path in settings, %v", err)
 		return err
 	}
 
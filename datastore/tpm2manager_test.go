@@ -20,17 +20,18 @@
 package datastore
 
 import (
+	"context"
 	"testing"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 )
 
 func TestTPM2InitializeKeystore(t *testing.T) {
-	// Set up the environment variables
-	config := config.Settings{KeyStorePath: "../keystore", KeyStoreType: "tpm2.0", KeyStoreSecret: "this needs to be 32 bytes long!!"}
+	// Set up the environment variables, keeping the primary key context out of the source tree
+	config := config.Settings{KeyStorePath: t.TempDir(), KeyStoreType: "tpm2.0", KeyStoreSecret: "this needs to be 32 bytes long!!"}
 	Environ = &Env{Config: config, DB: &MockDB{}}
 
-	err := TPM2InitializeKeystore(&mockTPM20Command{})
+	err := TPM2InitializeKeystore(context.Background(), &mockTPM20Command{})
 	if err != nil {
 		t.Errorf("Error initializing the TPM keystore: %v", err)
 	}
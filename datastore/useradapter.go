@@ -20,7 +20,9 @@
 package datastore
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"regexp"
 )
 
@@ -30,8 +32,32 @@ const validEmailPattern = `^[_\.0-9a-zA-Z-+=]+@(([0-9a-zA-Z-]{1,}\.)*)[a-zA-Z]{2
 var validUsernameRegexp = regexp.MustCompile(validUsernamePattern)
 var validEmailRegexp = regexp.MustCompile(validEmailPattern)
 
+// MaxBulkUsers is the largest number of users that are provisioned in one request
+const MaxBulkUsers = 500
+
+// Actions of the bulk provisioning of users: a new user is created, and an existing user
+// is linked to the accounts of the row
+const (
+	BulkUserCreate = "create"
+	BulkUserLink   = "link"
+)
+
+// ErrBulkUsersRejected is returned when some rows of the bulk provisioning are invalid
+var ErrBulkUsersRejected = errors.New("Some of the users are invalid, so none of the users have been provisioned")
+
+// BulkUserResult is the outcome of a row of the bulk provisioning of users. The rows are
+// numbered from 1, and the error is empty when the row is valid
+type BulkUserResult struct {
+	Row      int      `json:"row"`
+	Username string   `json:"username"`
+	Action   string   `json:"action"`
+	UserID   int      `json:"userID,omitempty"`
+	Accounts []string `json:"accounts"`
+	Error    string   `json:"error,omitempty"`
+}
+
 // CreateUser validates and adds a new record to User database table, Returns new record identifier if success
-func (db *DB) CreateUser(user User) (int, error) {
+func (db *DB) CreateUser(ctx context.Context, user User) (int, error) {
 
 	// Check the API key and default it if it is invalid
 	apiKey, err := buildValidOrDefaultAPIKey(user.APIKey)
@@ -44,11 +70,11 @@ func (db *DB) CreateUser(user User) (int, error) {
 	if err != nil {
 		return 0, err
 	}
-	return db.createUser(user)
+	return db.createUser(ctx, user)
 }
 
 // UpdateUser validates and sets user new values for an existing record. Also updates useraccount link. All that in a transaction
-func (db *DB) UpdateUser(user User) error {
+func (db *DB) UpdateUser(ctx context.Context, user User) error {
 	// Check the API key and default it if it is invalid
 	apiKey, err := buildValidOrDefaultAPIKey(user.APIKey)
 	if err != nil {
@@ -61,7 +87,95 @@ func (db *DB) UpdateUser(user User) error {
 		return err
 	}
 
-	return db.updateUser(user)
+	return db.updateUser(ctx, user)
+}
+
+// BulkProvisionUsers creates the new users and links the existing users to the accounts of
+// their rows, in a single transaction. All the rows are validated first, and nothing is
+// provisioned when any of them is invalid
+func (db *DB) BulkProvisionUsers(ctx context.Context, users []User) ([]BulkUserResult, error) {
+	provisioned, results := checkBulkUsers(ctx, users, db.GetUserByUsername, db.GetAccount)
+	if bulkUsersFailed(results) {
+		return results, ErrBulkUsersRejected
+	}
+
+	return results, db.bulkPutUsers(ctx, provisioned, results)
+}
+
+// checkBulkUsers validates the rows of a bulk provisioning, returning the users to save
+// and the result of each row. An existing user keeps its details and its role, and gets
+// the accounts of the row added to its links
+func checkBulkUsers(ctx context.Context, users []User, getUser func(context.Context, string) (User, error), getAccount func(context.Context, string) (Account, error)) ([]User, []BulkUserResult) {
+	provisioned := make([]User, len(users))
+	results := make([]BulkUserResult, len(users))
+	seen := map[string]int{}
+
+	for i, user := range users {
+		results[i] = BulkUserResult{Row: i + 1, Username: user.Username, Action: BulkUserCreate, Accounts: []string{}}
+		for _, a := range user.Accounts {
+			results[i].Accounts = append(results[i].Accounts, a.AuthorityID)
+		}
+
+		u, err := checkBulkUser(ctx, user, getUser, getAccount)
+		if row, ok := seen[user.Username]; ok {
+			err = fmt.Errorf("The user is also in row %d", row)
+		}
+		seen[user.Username] = i + 1
+
+		if err != nil {
+			results[i].Error = err.Error()
+			continue
+		}
+		if u.ID > 0 {
+			results[i].Action = BulkUserLink
+			results[i].UserID = u.ID
+		}
+		provisioned[i] = u
+	}
+	return provisioned, results
+}
+
+func checkBulkUser(ctx context.Context, user User, getUser func(context.Context, string) (User, error), getAccount func(context.Context, string) (Account, error)) (User, error) {
+	for _, a := range user.Accounts {
+		if _, err := getAccount(ctx, a.AuthorityID); err != nil {
+			return user, fmt.Errorf("Cannot find the account %s", a.AuthorityID)
+		}
+	}
+
+	existing, err := getUser(ctx, user.Username)
+	if err != nil {
+		// A new user
+		apiKey, err := buildValidOrDefaultAPIKey(user.APIKey)
+		if err != nil {
+			return user, errors.New("Error in generating a valid API key")
+		}
+		user.APIKey = apiKey
+		user.Active = true
+		return user, validateUser(user)
+	}
+
+	// An existing user, with the accounts of the row added to its links
+	if existing.AccountRoles == nil {
+		existing.AccountRoles = map[string]int{}
+	}
+	for _, a := range user.Accounts {
+		if !userHasAccount(existing, a.AuthorityID) {
+			existing.Accounts = append(existing.Accounts, a)
+		}
+	}
+	for authorityID, role := range user.AccountRoles {
+		existing.AccountRoles[authorityID] = role
+	}
+	return existing, validateUserAccountRoles(existing)
+}
+
+func bulkUsersFailed(results []BulkUserResult) bool {
+	for _, r := range results {
+		if len(r.Error) > 0 {
+			return true
+		}
+	}
+	return false
 }
 
 func validateUser(user User) error {
@@ -83,12 +197,15 @@ func validateUser(user User) error {
 		return err
 	}
 
-	// Validate role; the rule is the role is 100, 200 or 300
+	// Validate role; the rule is the role is 100, 120, 150, 200 or 300
 	err = validateUserRole(user.Role)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	// Validate the per-account roles; the rule is that they are for linked
+	// accounts and do not exceed the user's role
+	return validateUserAccountRoles(user)
 }
 
 func validateUsername(username string) error {
@@ -96,12 +213,36 @@ func validateUsername(username string) error {
 }
 
 func validateUserRole(role int) error {
-	if role != Standard && role != SyncUser && role != Admin && role != Superuser {
+	if role != Standard && role != SubstoreAdmin && role != SyncUser && role != Admin && role != Superuser {
 		return errors.New("Role is not amongst valid ones")
 	}
 	return nil
 }
 
+func validateUserAccountRoles(user User) error {
+	for authorityID, role := range user.AccountRoles {
+		if role != Standard && role != SyncUser && role != Admin {
+			return fmt.Errorf("Role for account %s is not amongst valid ones", authorityID)
+		}
+		if role > user.Role {
+			return fmt.Errorf("Role for account %s cannot exceed the role of the user", authorityID)
+		}
+		if !userHasAccount(user, authorityID) {
+			return fmt.Errorf("Role for account %s is set but the account is not linked to the user", authorityID)
+		}
+	}
+	return nil
+}
+
+func userHasAccount(user User, authorityID string) bool {
+	for _, a := range user.Accounts {
+		if a.AuthorityID == authorityID {
+			return true
+		}
+	}
+	return false
+}
+
 func validateUserFullName(name string) error {
 	return validateNotEmpty("Name", name)
 }
@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"strings"
 	"testing"
 )
@@ -158,3 +159,104 @@ func TestValidEmails(t *testing.T) {
 		}
 	}
 }
+
+func TestUserAccountRoles(t *testing.T) {
+	user := User{
+		Role:         Admin,
+		Accounts:     []Account{{AuthorityID: "brand-a"}, {AuthorityID: "brand-b"}},
+		AccountRoles: map[string]int{"brand-a": Admin, "brand-b": Standard},
+	}
+	if err := validateUserAccountRoles(user); err != nil {
+		t.Errorf("Expected account roles to be valid: %v", err)
+	}
+}
+
+func TestUserAccountRolesInvalid(t *testing.T) {
+	tests := []struct {
+		role  int
+		roles map[string]int
+		want  string
+	}{
+		{Admin, map[string]int{"brand-a": Superuser}, "not amongst valid ones"},
+		{Admin, map[string]int{"brand-a": SubstoreAdmin}, "not amongst valid ones"},
+		{SyncUser, map[string]int{"brand-a": Admin}, "cannot exceed the role of the user"},
+		{Admin, map[string]int{"brand-c": Standard}, "not linked to the user"},
+	}
+
+	for _, tt := range tests {
+		user := User{
+			Role:         tt.role,
+			Accounts:     []Account{{AuthorityID: "brand-a"}, {AuthorityID: "brand-b"}},
+			AccountRoles: tt.roles,
+		}
+		err := validateUserAccountRoles(user)
+		if err == nil {
+			t.Errorf("Expected account roles %v to be invalid, but they are not", tt.roles)
+			continue
+		}
+		if !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("Expected error containing %q, got %q", tt.want, err.Error())
+		}
+	}
+}
+
+func TestNewInvitedUser(t *testing.T) {
+	user, err := newInvitedUser(Invitation{Email: "jane@example.com", InvitedBy: "root"}, User{Role: Standard})
+	if err != nil {
+		t.Fatalf("Error creating the invited user: %v", err)
+	}
+	if !IsPendingUser(user) {
+		t.Errorf("Expected a pending user, got username '%s'", user.Username)
+	}
+	if user.Name != "jane@example.com" || user.Email != "jane@example.com" || len(user.APIKey) == 0 {
+		t.Errorf("Unexpected invited user: %v", user)
+	}
+
+	if _, err := newInvitedUser(Invitation{Email: "invalid", InvitedBy: "root"}, User{Role: Standard}); err == nil {
+		t.Error("Expected an error inviting an invalid email")
+	}
+	if _, err := newInvitedUser(Invitation{Email: "jane@example.com", InvitedBy: "root"}, User{Role: 1}); err == nil {
+		t.Error("Expected an error inviting with an invalid role")
+	}
+}
+
+func TestCheckBulkUsers(t *testing.T) {
+	mdb := &MockDB{}
+	users := []User{
+		{Username: "jdoe", Name: "Jane Doe", Email: "jane@example.com", Role: Admin, Accounts: BuildAccountsFromAuthorityIDs([]string{"system"}), AccountRoles: map[string]int{"system": Standard}},
+		{Username: "user1", Accounts: BuildAccountsFromAuthorityIDs([]string{"vendor"})},
+		{Username: "jsmith", Name: "John Smith", Email: "john@example.com", Role: Standard, Accounts: BuildAccountsFromAuthorityIDs([]string{"unknown"})},
+		{Username: "Invalid User", Name: "Invalid", Email: "invalid@example.com", Role: Standard},
+		{Username: "jdoe", Name: "Jane Doe", Email: "jane@example.com", Role: Standard},
+		{Username: "user2", Accounts: BuildAccountsFromAuthorityIDs([]string{"system"}), AccountRoles: map[string]int{"system": Admin}},
+	}
+
+	provisioned, results := checkBulkUsers(context.Background(), users, mdb.GetUserByUsername, mdb.GetAccount)
+	if !bulkUsersFailed(results) {
+		t.Fatal("Expected the bulk users to fail")
+	}
+
+	tests := []struct {
+		action string
+		error  string
+	}{
+		{BulkUserCreate, ""},
+		{BulkUserLink, ""},
+		{BulkUserCreate, "Cannot find the account unknown"},
+		{BulkUserCreate, "Username must not contain uppercase characters"},
+		{BulkUserCreate, "The user is also in row 1"},
+		{BulkUserCreate, "cannot exceed the role of the user"},
+	}
+	for i, tt := range tests {
+		if results[i].Row != i+1 || results[i].Action != tt.action || !strings.Contains(results[i].Error, tt.error) || (len(tt.error) == 0) != (len(results[i].Error) == 0) {
+			t.Errorf("Row %d: expected %s '%s', got %v", i+1, tt.action, tt.error, results[i])
+		}
+	}
+
+	if len(provisioned[0].APIKey) == 0 || !provisioned[0].Active {
+		t.Errorf("Expected a new active user with an API key, got %v", provisioned[0])
+	}
+	if provisioned[1].ID != 1 || provisioned[1].Role != Standard || len(provisioned[1].Accounts) != 2 {
+		t.Errorf("Expected the existing user with the account added, got %v", provisioned[1])
+	}
+}
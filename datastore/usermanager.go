@@ -20,6 +20,7 @@
 package datastore
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 
@@ -33,28 +34,32 @@ const createUserTableSQL = `
 		name             varchar(200),
 		email            varchar(255) not null,
 		userrole         int not null,
-		api_key          varchar(200) not null
+		api_key          varchar(200) not null,
+		active           bool not null default true
 	)
 `
 
 const createAccountUserLinkTableSQL = `
 	CREATE TABLE IF NOT EXISTS useraccountlink (
 		user_id          int references userinfo not null,
-		account_id     	 int references account not null
+		account_id     	 int references account not null,
+		role             int
 	)
 `
 
-const listUsersSQL = "select id, username, name, email, userrole, api_key from userinfo order by username"
-const getUserSQL = "select id, username, name, email, userrole, api_key from userinfo where id=$1"
-const getUserByUsernameSQL = "select id, username, name, email, userrole, api_key from userinfo where username=$1"
-const getUserByAPIKeySQL = "select id, username, name, email, userrole, api_key from userinfo where api_key=$1 and username=$2"
-const findUsersSQL = "select id, username, name, email, userrole, api_key from userinfo where username like '%$1%' or name like '%$1%'"
+const listUsersSQL = "select id, username, name, email, userrole, api_key, active from userinfo order by username"
+const getUserSQL = "select id, username, name, email, userrole, api_key, active from userinfo where id=$1"
+const getUserByUsernameSQL = "select id, username, name, email, userrole, api_key, active from userinfo where username=$1"
+const getUserByAPIKeySQL = "select id, username, name, email, userrole, api_key, active from userinfo where api_key=$1 and username=$2 and active"
+const findUsersSQL = "select id, username, name, email, userrole, api_key, active from userinfo where username like '%' || $1 || '%' or name like '%' || $1 || '%'"
 const createUserSQL = "insert into userinfo (username, name, email, userrole, api_key) values ($1,$2,$3,$4,$5) RETURNING id"
 const updateUserSQL = "update userinfo set username=$1, name=$2, email=$3, userrole=$4, api_key=$6 where id=$5"
 const deleteUserSQL = "delete from userinfo where id=$1"
+const setUserActiveSQL = "update userinfo set active=$2 where id=$1"
+const isUserActiveSQL = "select active from userinfo where username=$1"
 
 const listAccountUsersSQL = `
-	select id, username, name, email, userrole, api_key
+	select id, username, name, email, userrole, api_key, active
 	from userinfo u
 	inner join useraccountlink l on u.id = l.user_id
 	inner join account a on l.account_id = a.id
@@ -64,13 +69,27 @@ const listAccountUsersSQL = `
 const findAccountUserSQL = `
 	select count(*) 
 	from userinfo u
-	inner join useraccountlink l on u.id = l.user_id
-	inner join account a on l.account_id = a.id
-	where u.username=$1 and a.authority_id=$2
+	inner join useraccountlink ua on u.id = ua.user_id
+	inner join account a on ua.account_id = a.id
+	where u.username=$1 and a.authority_id=$2` + accountRoleAdminSQL + `
 `
 
 const deleteUserAccountsSQL = "delete from useraccountlink where user_id=$1"
-const linkAccountToUserSQL = "insert into useraccountlink (user_id, account_id) values ($1,$2)"
+const linkAccountToUserSQL = "insert into useraccountlink (user_id, account_id, role) values ($1,$2,$3)"
+
+const listUserAccountRolesSQL = `
+	select a.authority_id, ua.role
+	from useraccountlink ua
+	inner join account a on ua.account_id = a.id
+	where ua.user_id=$1 and ua.role is not null
+`
+
+// The role of a user in an account is the role on the account link, falling
+// back to the user's role when the link has no role of its own. The filters
+// expect the link to be aliased as ua and the user as u.
+const accountRoleStandardSQL = " and coalesce(ua.role, u.userrole) >= 100"
+const accountRoleSyncUserSQL = " and coalesce(ua.role, u.userrole) >= 150"
+const accountRoleAdminSQL = " and coalesce(ua.role, u.userrole) >= 200"
 
 const alterUserRemoveOpenIDIdentity = "alter table userinfo drop column if exists openid_identity"
 
@@ -83,26 +102,48 @@ const alterUserAPIKeyNotNullable = `alter table userinfo
 	alter column api_key drop default
 `
 
+// Add the per-account role to the account-user link (nullable, to use the user's role)
+const alterAccountUserLinkRole = "alter table useraccountlink add column role int"
+
+// Add the active flag of the users, so they are deactivated instead of deleted
+const alterUserActive = "alter table userinfo add column if not exists active bool not null default true"
+
 // Available user roles:
 //
 // * Invalid:	default value set in case there is no authentication previous process for this user and thus not got a valid role.
 // * Standard:	role for regular users. This is the less privileged role
+// * SubstoreAdmin:	role for users delegated by a brand admin, limited to the devices of specific sub-stores
 // * SyncUser:	role for users that will used the Sync API
 // * Admin:		role for admin users, including standard role permissions but not superuser ones
 // * Superuser:	role for users having all the permissions
 const (
-	Invalid   = 0
-	Standard  = 100
-	SyncUser  = 150
-	Admin     = 200
-	Superuser = 300
+	Invalid       = 0
+	Standard      = 100
+	SubstoreAdmin = 120
+	SyncUser      = 150
+	Admin         = 200
+	Superuser     = 300
 )
 
+// RoleAllows checks if the role has the permissions of the minimum role. The roles rank by their
+// value, except for the delegated sub-store admin: the sub-store endpoints are only open to the
+// sub-store admins and the brand admins, and the sub-store admin has no other permissions
+func RoleAllows(role, minimumRole int) bool {
+	switch {
+	case minimumRole == SubstoreAdmin:
+		return role == SubstoreAdmin || role >= Admin
+	case role == SubstoreAdmin:
+		return minimumRole == Invalid
+	default:
+		return role >= minimumRole
+	}
+}
+
 // RoleName holds the names for each of the roles
-var RoleName = map[int]string{0: "", 100: "standard", 150: "syncuser", 200: "admin", 300: "superuser"}
+var RoleName = map[int]string{0: "", 100: "standard", 120: "substoreadmin", 150: "syncuser", 200: "admin", 300: "superuser"}
 
 // RoleID holds the ID for each of the named roles
-var RoleID = map[string]int{"": 0, "standard": 100, "syncuser": 150, "admin": 200, "superuser": 300}
+var RoleID = map[string]int{"": 0, "standard": 100, "substoreadmin": 120, "syncuser": 150, "admin": 200, "superuser": 300}
 
 // User holds user personal, authentication and authorization info
 type User struct {
@@ -113,41 +154,57 @@ type User struct {
 	APIKey   string
 	Role     int
 	Accounts []Account
+
+	// Active is false when the user is deactivated: the user cannot log in or use the
+	// API, but keeps the account links and the audit trail
+	Active bool
+
+	// AccountRoles overrides the role of the user for some of the accounts,
+	// keyed by the authority ID. Accounts not in the map use the user's role.
+	AccountRoles map[string]int `json:",omitempty"`
 }
 
 // CreateUserTable creates User table in database
-func (db *DB) CreateUserTable() error {
-	_, err := db.Exec(createUserTableSQL)
+func (db *DB) CreateUserTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, createUserTableSQL)
 	return err
 }
 
 // CreateAccountUserLinkTable creates table to link User and Account tables in a m-m relationship
-func (db *DB) CreateAccountUserLinkTable() error {
+func (db *DB) CreateAccountUserLinkTable(ctx context.Context) error {
 	// Add and populate the API key field (ignore error as it may already be there)
-	db.addUserAPIKeyField()
+	db.addUserAPIKeyField(ctx)
 
-	_, err := db.Exec(createAccountUserLinkTableSQL)
+	_, err := db.ExecContext(ctx, createAccountUserLinkTableSQL)
 	return err
 }
 
 // AlterUserTable includes all user table definition modifications
-func (db *DB) AlterUserTable() error {
-	_, err := db.Exec(alterUserRemoveOpenIDIdentity)
+func (db *DB) AlterUserTable(ctx context.Context) error {
+	_, err := db.ExecContext(ctx, alterUserRemoveOpenIDIdentity)
+	if err != nil {
+		return err
+	}
+
+	// Add the per-account role, which is skipped if it already exists
+	db.ExecContext(ctx, alterAccountUserLinkRole)
+
+	_, err = db.ExecContext(ctx, alterUserActive)
 	return err
 }
 
 // addUserAPIKeyField adds and defaults the API key field to the user table
-func (db *DB) addUserAPIKeyField() error {
+func (db *DB) addUserAPIKeyField(ctx context.Context) error {
 
 	// Add the API key field to the user table
-	_, err := db.Exec(alterUserAPIKey)
+	_, err := db.ExecContext(ctx, alterUserAPIKey)
 	if err != nil {
 		// Field already exists so skip
 		return nil
 	}
 
 	// Default the API key for any records where it is empty
-	users, err := db.ListUsers()
+	users, err := db.ListUsers(ctx)
 	if err != nil {
 		return err
 	}
@@ -159,17 +216,17 @@ func (db *DB) addUserAPIKeyField() error {
 		// Generate an random API key and update the record
 		apiKey, err := generateAPIKey()
 		if err != nil {
-			log.Printf("Could not generate random string for the API key")
+			log.Errorf("Could not generate random string for the API key")
 			return errors.New("Error generating random string for the API key")
 		}
 
 		// Update the API key on the model
 		user.APIKey = apiKey
-		db.updateUser(user)
+		db.updateUser(ctx, user)
 	}
 
 	// Add the constraints to the API key field
-	_, err = db.Exec(alterUserAPIKeyNotNullable)
+	_, err = db.ExecContext(ctx, alterUserAPIKeyNotNullable)
 	if err != nil {
 		return err
 	}
@@ -178,79 +235,79 @@ func (db *DB) addUserAPIKeyField() error {
 }
 
 // ListUsers returns current available users in database
-func (db *DB) ListUsers() ([]User, error) {
-	rows, err := db.Query(listUsersSQL)
+func (db *DB) ListUsers(ctx context.Context) ([]User, error) {
+	rows, err := db.QueryContext(ctx, listUsersSQL)
 	if err != nil {
-		log.Printf("Error retrieving database users: %v\n", err)
+		log.Errorf("Error retrieving database users: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	return db.rowsToUsers(rows)
+	return db.rowsToUsers(ctx, rows)
 }
 
 // FindUsers returns array of users matching query string in username or name
-func (db *DB) FindUsers(query string) ([]User, error) {
-	rows, err := db.Query(findUsersSQL, query)
+func (db *DB) FindUsers(ctx context.Context, query string) ([]User, error) {
+	rows, err := db.QueryContext(ctx, findUsersSQL, query)
 	if err != nil {
-		log.Printf("Error searching for database users: %v\n", err)
+		log.Errorf("Error searching for database users: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
-	return db.rowsToUsers(rows)
+	return db.rowsToUsers(ctx, rows)
 }
 
 // GetUser fetches a single user from database
-func (db *DB) GetUser(userID int) (User, error) {
-	row := db.QueryRow(getUserSQL, userID)
-	user, err := db.rowToUser(row)
+func (db *DB) GetUser(ctx context.Context, userID int) (User, error) {
+	row := db.QueryRowContext(ctx, getUserSQL, userID)
+	user, err := db.rowToUser(ctx, row)
 	if err != nil {
-		log.Printf("Error retrieving user %v: %v\n", userID, err)
+		log.Errorf("Error retrieving user %v: %v", userID, err)
 	}
 	return user, err
 }
 
 // GetUserByUsername fetches a single user from database
-func (db *DB) GetUserByUsername(username string) (User, error) {
-	row := db.QueryRow(getUserByUsernameSQL, username)
-	user, err := db.rowToUser(row)
+func (db *DB) GetUserByUsername(ctx context.Context, username string) (User, error) {
+	row := db.QueryRowContext(ctx, getUserByUsernameSQL, username)
+	user, err := db.rowToUser(ctx, row)
 	if err != nil {
-		log.Printf("Error retrieving user %v: %v\n", username, err)
+		log.Errorf("Error retrieving user %v: %v", username, err)
 	}
 	return user, err
 }
 
 // GetUserByAPIKey fetches a single user from database
-func (db *DB) GetUserByAPIKey(apiKey, username string) (User, error) {
+func (db *DB) GetUserByAPIKey(ctx context.Context, apiKey, username string) (User, error) {
 	if len(apiKey) == 0 || len(username) == 0 {
 		return User{}, errors.New("The 'user' and 'api-key' must be supplied")
 	}
 
-	row := db.QueryRow(getUserByAPIKeySQL, apiKey, username)
-	user, err := db.rowToUser(row)
+	row := db.QueryRowContext(ctx, getUserByAPIKeySQL, apiKey, username)
+	user, err := db.rowToUser(ctx, row)
 	if err != nil {
-		log.Printf("Error retrieving user %v: %v\n", username, err)
+		log.Errorf("Error retrieving user %v: %v", username, err)
 	}
 	return user, err
 }
 
 // createUser adds a new record to User database table, Returns new record identifier if success
-func (db *DB) createUser(user User) (int, error) {
+func (db *DB) createUser(ctx context.Context, user User) (int, error) {
 
 	createdUserID := -1
 
-	err := db.transaction(func(tx *sql.Tx) error {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
 
-		err := tx.QueryRow(createUserSQL, user.Username, user.Name, user.Email, user.Role, user.APIKey).Scan(&createdUserID)
+		err := tx.QueryRowContext(ctx, createUserSQL, user.Username, user.Name, user.Email, user.Role, user.APIKey).Scan(&createdUserID)
 		if err != nil {
-			log.Printf("Error creating user %v: %v\n", user.Username, err)
+			log.Errorf("Error creating user %v: %v", user.Username, err)
 			return err
 		}
 
-		err = db.putUserAccounts(createdUserID, user.Accounts, tx)
+		err = db.putUserAccounts(ctx, createdUserID, user.Accounts, user.AccountRoles, tx)
 		if err != nil {
-			log.Printf("Error creating user %v: %v\n", user.Username, err)
+			log.Errorf("Error creating user %v: %v", user.Username, err)
 			return err
 		}
 
@@ -261,19 +318,19 @@ func (db *DB) createUser(user User) (int, error) {
 }
 
 // updateUser sets user new values for an existing record. Also updates useraccount link. All that in a transaction
-func (db *DB) updateUser(user User) error {
+func (db *DB) updateUser(ctx context.Context, user User) error {
 
-	return db.transaction(func(tx *sql.Tx) error {
+	return db.transaction(ctx, func(tx *sql.Tx) error {
 
-		_, err := tx.Exec(updateUserSQL, user.Username, user.Name, user.Email, user.Role, user.ID, user.APIKey)
+		_, err := tx.ExecContext(ctx, updateUserSQL, user.Username, user.Name, user.Email, user.Role, user.ID, user.APIKey)
 		if err != nil {
-			log.Printf("Error updating database user %v: %v\n", user.ID, err)
+			log.Errorf("Error updating database user %v: %v", user.ID, err)
 			return err
 		}
 
-		err = db.putUserAccounts(user.ID, user.Accounts, tx)
+		err = db.putUserAccounts(ctx, user.ID, user.Accounts, user.AccountRoles, tx)
 		if err != nil {
-			log.Printf("Error creating user %v: %v\n", user.Username, err)
+			log.Errorf("Error creating user %v: %v", user.Username, err)
 			return err
 		}
 
@@ -281,20 +338,79 @@ func (db *DB) updateUser(user User) error {
 	})
 }
 
+// bulkPutUsers saves the users of a bulk provisioning in a transaction. The new users are
+// created, and the account links of all the users are replaced
+func (db *DB) bulkPutUsers(ctx context.Context, users []User, results []BulkUserResult) error {
+	err := db.transaction(ctx, func(tx *sql.Tx) error {
+		for i, user := range users {
+			if results[i].Action == BulkUserCreate {
+				err := tx.QueryRowContext(ctx, createUserSQL, user.Username, user.Name, user.Email, user.Role, user.APIKey).Scan(&user.ID)
+				if err != nil {
+					log.Errorf("Error creating user %v: %v", user.Username, err)
+					results[i].Error = err.Error()
+					return err
+				}
+				results[i].UserID = user.ID
+			}
+
+			err := db.putUserAccounts(ctx, user.ID, user.Accounts, user.AccountRoles, tx)
+			if err != nil {
+				log.Errorf("Error linking the accounts of user %v: %v", user.Username, err)
+				results[i].Error = err.Error()
+				return err
+			}
+		}
+		return nil
+	})
+
+	// The IDs of the new users are not kept when the transaction is rolled back
+	if err != nil {
+		for i := range results {
+			if results[i].Action == BulkUserCreate {
+				results[i].UserID = 0
+			}
+		}
+	}
+	return err
+}
+
+// SetUserActive deactivates or reactivates a user
+func (db *DB) SetUserActive(ctx context.Context, userID int, active bool) error {
+	result, err := db.ExecContext(ctx, setUserActiveSQL, userID, active)
+	if err != nil {
+		log.Errorf("Error updating the status of user %v: %v", userID, err)
+		return err
+	}
+	if rows, err := result.RowsAffected(); err == nil && rows == 0 {
+		return errors.New("Cannot find the user")
+	}
+	return nil
+}
+
+// IsUserActive checks that the user exists and has not been deactivated
+func (db *DB) IsUserActive(ctx context.Context, username string) bool {
+	var active bool
+	if err := db.QueryRowContext(ctx, isUserActiveSQL, username).Scan(&active); err != nil {
+		log.Errorf("Error checking the status of user %v: %v", username, err)
+		return false
+	}
+	return active
+}
+
 // DeleteUser deletes a user
-func (db *DB) DeleteUser(userID int) error {
+func (db *DB) DeleteUser(ctx context.Context, userID int) error {
 
-	return db.transaction(func(tx *sql.Tx) error {
+	return db.transaction(ctx, func(tx *sql.Tx) error {
 
-		_, err := tx.Exec(deleteUserSQL, userID)
+		_, err := tx.ExecContext(ctx, deleteUserSQL, userID)
 		if err != nil {
-			log.Printf("Error deleting database user %v: %v\n", userID, err)
+			log.Errorf("Error deleting database user %v: %v", userID, err)
 			return err
 		}
 
-		_, err = tx.Exec(deleteUserAccountsSQL, userID)
+		_, err = tx.ExecContext(ctx, deleteUserAccountsSQL, userID)
 		if err != nil {
-			log.Printf("Error deleting user accounts: %v", err)
+			log.Errorf("Error deleting user accounts: %v", err)
 			return err
 		}
 
@@ -303,19 +419,19 @@ func (db *DB) DeleteUser(userID int) error {
 }
 
 // ListAccountUsers returns list of User related with certain account
-func (db *DB) ListAccountUsers(authorityID string) ([]User, error) {
+func (db *DB) ListAccountUsers(ctx context.Context, authorityID string) ([]User, error) {
 	users := []User{}
 
-	rows, err := db.Query(listAccountUsersSQL, authorityID)
+	rows, err := db.QueryContext(ctx, listAccountUsersSQL, authorityID)
 	if err != nil {
-		log.Printf("Error retrieving database users of certain account: %v\n", err)
+		log.Errorf("Error retrieving database users of certain account: %v", err)
 		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
 		user := User{}
-		err := rows.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey)
+		err := rows.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey, &user.Active)
 		if err != nil {
 			return nil, err
 		}
@@ -326,28 +442,28 @@ func (db *DB) ListAccountUsers(authorityID string) ([]User, error) {
 }
 
 // CheckUserInAccount verifies that a user has permissions to a specific account
-func (db *DB) CheckUserInAccount(username, authorityID string) bool {
+func (db *DB) CheckUserInAccount(ctx context.Context, username, authorityID string) bool {
 	if username == "" {
 		return true
 	}
 
 	var count int
 
-	row := db.QueryRow(findAccountUserSQL, username, authorityID)
+	row := db.QueryRowContext(ctx, findAccountUserSQL, username, authorityID)
 	err := row.Scan(&count)
 	if err != nil {
-		log.Printf("Error retrieving database account of certain user: %v\n", err)
+		log.Errorf("Error retrieving database account of certain user: %v", err)
 		return false
 	}
 
 	return count > 0
 }
 
-func (db *DB) putUserAccounts(userID int, accounts []Account, tx *sql.Tx) error {
+func (db *DB) putUserAccounts(ctx context.Context, userID int, accounts []Account, roles map[string]int, tx *sql.Tx) error {
 	// first, delete previous registers if any
-	_, err := tx.Exec(deleteUserAccountsSQL, userID)
+	_, err := tx.ExecContext(ctx, deleteUserAccountsSQL, userID)
 	if err != nil {
-		log.Printf("Could not delete user accounts: %v", err)
+		log.Errorf("Could not delete user accounts: %v", err)
 		return err
 	}
 
@@ -356,16 +472,22 @@ func (db *DB) putUserAccounts(userID int, accounts []Account, tx *sql.Tx) error
 
 		// if account id is not a valid identifier, fetch Account from DB using autorithyID field
 		if account.ID == 0 {
-			account, err = db.GetAccount(account.AuthorityID)
+			account, err = db.GetAccount(ctx, account.AuthorityID)
 			if err != nil {
-				log.Printf("Invalid account: %v", err)
+				log.Errorf("Invalid account: %v", err)
 				return err
 			}
 		}
 
-		_, err := tx.Exec(linkAccountToUserSQL, userID, account.ID)
+		// a missing role is stored as null, so the user's role applies
+		var role sql.NullInt64
+		if r, ok := roles[account.AuthorityID]; ok {
+			role = sql.NullInt64{Int64: int64(r), Valid: true}
+		}
+
+		_, err := tx.ExecContext(ctx, linkAccountToUserSQL, userID, account.ID, role)
 		if err != nil {
-			log.Printf("Could not complete linking user to account transaction: %v", err)
+			log.Errorf("Could not complete linking user to account transaction: %v", err)
 			return err
 		}
 	}
@@ -373,15 +495,42 @@ func (db *DB) putUserAccounts(userID int, accounts []Account, tx *sql.Tx) error
 	return nil
 }
 
-func (db *DB) rowToUser(row *sql.Row) (User, error) {
+// listUserAccountRoles returns the per-account roles of a user, keyed by the authority ID
+func (db *DB) listUserAccountRoles(ctx context.Context, userID int) (map[string]int, error) {
+	rows, err := db.QueryContext(ctx, listUserAccountRolesSQL, userID)
+	if err != nil {
+		log.Errorf("Error retrieving the account roles of user %v: %v", userID, err)
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := map[string]int{}
+	for rows.Next() {
+		var authorityID string
+		var role int
+		if err := rows.Scan(&authorityID, &role); err != nil {
+			return nil, err
+		}
+		roles[authorityID] = role
+	}
+
+	return roles, rows.Err()
+}
+
+func (db *DB) rowToUser(ctx context.Context, row *sql.Row) (User, error) {
 	user := User{}
-	err := row.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey)
+	err := row.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey, &user.Active)
 	if err != nil {
 		return User{}, err
 	}
 
 	// Get related accounts and fill related User field
-	user.Accounts, err = db.listAccountsFilteredByUser(user.Username)
+	user.Accounts, err = db.listAccountsFilteredByUser(ctx, user.Username)
+	if err != nil {
+		return User{}, err
+	}
+
+	user.AccountRoles, err = db.listUserAccountRoles(ctx, user.ID)
 	if err != nil {
 		return User{}, err
 	}
@@ -389,29 +538,35 @@ func (db *DB) rowToUser(row *sql.Row) (User, error) {
 	return user, nil
 }
 
-func (db *DB) rowsToUser(rows *sql.Rows) (User, error) {
+func (db *DB) rowsToUser(ctx context.Context, rows *sql.Rows) (User, error) {
 	user := User{}
-	err := rows.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey)
+	err := rows.Scan(&user.ID, &user.Username, &user.Name, &user.Email, &user.Role, &user.APIKey, &user.Active)
 	if err != nil {
-		log.Printf("Error scanning user fields: %v", err)
+		log.Errorf("Error scanning user fields: %v", err)
 		return User{}, err
 	}
 
 	// Get related accounts and fill related User field
-	user.Accounts, err = db.listAccountsFilteredByUser(user.Username)
+	user.Accounts, err = db.listAccountsFilteredByUser(ctx, user.Username)
+	if err != nil {
+		log.Errorf("Error fetching user accounts: %v", err)
+		return User{}, err
+	}
+
+	user.AccountRoles, err = db.listUserAccountRoles(ctx, user.ID)
 	if err != nil {
-		log.Printf("Error fetching user accounts: %v", err)
+		log.Errorf("Error fetching user account roles: %v", err)
 		return User{}, err
 	}
 
 	return user, nil
 }
 
-func (db *DB) rowsToUsers(rows *sql.Rows) ([]User, error) {
+func (db *DB) rowsToUsers(ctx context.Context, rows *sql.Rows) ([]User, error) {
 	users := []User{}
 
 	for rows.Next() {
-		user, err := db.rowsToUser(rows)
+		user, err := db.rowsToUser(ctx, rows)
 		if err != nil {
 			return nil, err
 		}
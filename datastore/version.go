@@ -0,0 +1,53 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// ErrVersionConflict is returned for an update of a record that has been changed since the
+// version of the update was read
+var ErrVersionConflict = errors.New("the record has been changed since it was read")
+
+// checkVersion checks the result of an update of a record by its ID, which is made only when
+// the version of the record matches. When nothing is updated, the current version of the record
+// tells a conflicting change apart from a record that is missing or not allowed to the user
+func (db *DB) checkVersion(ctx context.Context, result sql.Result, table string, id, version int) error {
+	if version == 0 {
+		return nil
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil || rows > 0 {
+		return err
+	}
+
+	var current int
+	err = db.QueryRowContext(ctx, fmt.Sprintf("select version from %s where id=$1", table), id).Scan(&current)
+	if err == nil && current != version {
+		return ErrVersionConflict
+	}
+	return nil
+}
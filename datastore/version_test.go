@@ -0,0 +1,94 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+)
+
+func TestUpdateVersion(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, Keypair{AuthorityID: "alder", KeyID: "alder-key", SealedKey: "sealed", KeyName: "alder"}); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	keypair, err := db.GetKeypairByPublicID(ctx, "alder", "alder-key")
+	if err != nil || keypair.Version != 1 {
+		t.Fatalf("GetKeypairByPublicID() = %v, %v", keypair, err)
+	}
+	model, _, err := db.CreateAllowedModel(ctx, Model{BrandID: "alder", Name: "alder-basic", KeypairID: keypair.ID, KeypairIDUser: keypair.ID, APIKey: "model-api-key"}, root)
+	if err != nil || model.Version != 1 {
+		t.Fatalf("CreateAllowedModel() = %v, %v", model, err)
+	}
+
+	// The update of the version that was read increments the version
+	model.SerialFormat = "[A-Z]+"
+	if _, err := db.UpdateAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("UpdateAllowedModel() error = %v", err)
+	}
+	if m, _ := db.GetAllowedModel(ctx, model.ID, root); m.Version != 2 || m.SerialFormat != "[A-Z]+" {
+		t.Fatalf("GetAllowedModel() = %v, expected version 2", m)
+	}
+
+	// The update of the stale version conflicts, and is not made
+	model.SerialFormat = "[0-9]+"
+	if _, err := db.UpdateAllowedModel(ctx, model, root); err != ErrVersionConflict {
+		t.Fatalf("UpdateAllowedModel() expected a version conflict, got %v", err)
+	}
+	if m, _ := db.GetAllowedModel(ctx, model.ID, root); m.SerialFormat != "[A-Z]+" {
+		t.Fatalf("GetAllowedModel() expected the model to be unchanged, got %v", m)
+	}
+
+	// An update without a version is made whatever the version
+	model.Version = 0
+	if _, err := db.UpdateAllowedModel(ctx, model, root); err != nil {
+		t.Fatalf("UpdateAllowedModel() error = %v", err)
+	}
+
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+	account.ResellerAPI = true
+	if err := db.UpdateAccount(ctx, account, root); err != nil {
+		t.Fatalf("UpdateAccount() error = %v", err)
+	}
+	if err := db.UpdateAccount(ctx, account, root); err != ErrVersionConflict {
+		t.Fatalf("UpdateAccount() expected a version conflict, got %v", err)
+	}
+
+	keypair.KeyName = "renamed"
+	if _, err := db.PutKeypair(ctx, keypair); err != nil {
+		t.Fatalf("PutKeypair() error = %v", err)
+	}
+	if _, err := db.PutKeypair(ctx, keypair); err != ErrVersionConflict {
+		t.Fatalf("PutKeypair() expected a version conflict, got %v", err)
+	}
+}
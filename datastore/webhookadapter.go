@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import "context"
+
+// ListAllowedWebhooks returns the webhooks of the account, if the user is authorized to see them
+func (db *DB) ListAllowedWebhooks(ctx context.Context, accountID int, authorization User) ([]Webhook, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return nil, err
+	}
+	return db.listWebhooks(ctx, accountID)
+}
+
+// CreateAllowedWebhook validates and registers a webhook of the account, if the user is authorized
+// to do it. The generated secret of the webhook is returned
+func (db *DB) CreateAllowedWebhook(ctx context.Context, w Webhook, authorization User) (Webhook, error) {
+	if err := db.checkAPIKeyAccount(ctx, w.AccountID, authorization); err != nil {
+		return w, err
+	}
+	if err := validateWebhook(&w); err != nil {
+		return w, err
+	}
+	return db.createWebhook(ctx, w)
+}
+
+// DeleteAllowedWebhook removes a webhook of the account and its deliveries, if the user is authorized to do it
+func (db *DB) DeleteAllowedWebhook(ctx context.Context, webhookID, accountID int, authorization User) error {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return err
+	}
+	return db.deleteWebhook(ctx, webhookID, accountID)
+}
+
+// ListAllowedWebhookDeliveries returns the latest deliveries of a webhook of the account, with the
+// status, or all of them when the status is empty, if the user is authorized to see them
+func (db *DB) ListAllowedWebhookDeliveries(ctx context.Context, webhookID, accountID int, status string, authorization User) ([]WebhookDelivery, error) {
+	if err := db.checkAPIKeyAccount(ctx, accountID, authorization); err != nil {
+		return nil, err
+	}
+	return db.listWebhookDeliveries(ctx, webhookID, accountID, status)
+}
@@ -0,0 +1,434 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/random"
+	sq "github.com/Masterminds/squirrel"
+)
+
+// The event types that the webhooks of an account are registered for
+const (
+	WebhookEventKeypair        = "keypair"         // the signing-keys of the account are changed
+	WebhookEventAccount        = "account"         // the account, or its API keys, are changed
+	WebhookEventSigningAnomaly = "signing-anomaly" // a signing request is refused as suspicious
+)
+
+var validWebhookEvents = []string{WebhookEventKeypair, WebhookEventAccount, WebhookEventSigningAnomaly}
+
+// The formats of the events that are posted to a webhook: the signed JSON event, or a message
+// for the incoming webhook of a Slack or a Microsoft Teams channel
+const (
+	WebhookFormatJSON  = "json"
+	WebhookFormatSlack = "slack"
+	WebhookFormatTeams = "teams"
+)
+
+var validWebhookFormats = []string{WebhookFormatJSON, WebhookFormatSlack, WebhookFormatTeams}
+
+// The status of a webhook delivery. A pending delivery is retried until it is delivered, or
+// it fails after the last attempt
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliveryDelivered = "delivered"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookDeliveryRetention is how long the webhook deliveries are kept, for their status
+const WebhookDeliveryRetention = 30 * 24 * time.Hour
+
+// webhookDeliveryLease is how long a claimed delivery is held by the service that attempts it. The
+// delivery is attempted again after the lease, when the service stops before it records the attempt
+const webhookDeliveryLease = 30 * time.Minute
+
+// maxWebhookDeliveries is the number of the latest deliveries of a webhook that are listed
+const maxWebhookDeliveries = 100
+
+// webhookSecretLength is the number of random bytes of a generated webhook secret
+const webhookSecretLength = 32
+
+// The webhooks of an account, with the comma-separated events that are sent to the URL in the
+// format of the webhook. The deliveries are signed with the secret of the webhook, which is
+// encrypted with the keystore secret
+const createWebhookTableSQL = `
+	CREATE TABLE IF NOT EXISTS webhook (
+		id               serial primary key not null,
+		account_id       int references account not null,
+		url              varchar(2000) not null,
+		events           varchar(200) not null,
+		secret           varchar(200) not null,
+		format           varchar(20) not null default 'json',
+		created          timestamp default current_timestamp
+	)
+`
+
+// Add the format of the events of the webhook
+const alterWebhookFormatSQL = "alter table webhook add column format varchar(20) not null default 'json'"
+
+// The queued events of the webhooks, and the status of their delivery
+const createWebhookDeliveryTableSQL = `
+	CREATE TABLE IF NOT EXISTS webhookdelivery (
+		id               serial primary key not null,
+		webhook_id       int references webhook not null,
+		event            varchar(200) not null,
+		payload          text not null,
+		status           varchar(20) not null,
+		attempts         int not null default 0,
+		next_attempt     timestamp not null,
+		response_code    int not null default 0,
+		last_error       text not null default '',
+		created          timestamp default current_timestamp,
+		delivered        timestamp
+	)
+`
+
+const createWebhookDeliveryDueIndexSQL = "CREATE INDEX IF NOT EXISTS webhookdelivery_due_idx ON webhookdelivery (status, next_attempt)"
+
+const listWebhooksSQL = `
+	SELECT id, account_id, url, events, format, created
+	FROM webhook
+	WHERE account_id=$1
+	ORDER BY id`
+
+const listAccountWebhooksSQL = `
+	SELECT w.id, w.account_id, w.url, w.events, w.format, w.created
+	FROM webhook w
+	INNER JOIN account a ON a.id=w.account_id
+	WHERE a.authority_id=$1`
+
+const createWebhookSQL = "INSERT INTO webhook (account_id, url, events, secret, format) VALUES ($1,$2,$3,$4,$5) RETURNING id"
+const deleteWebhookDeliveriesSQL = "DELETE FROM webhookdelivery WHERE webhook_id IN (SELECT id FROM webhook WHERE id=$1 AND account_id=$2)"
+const deleteWebhookSQL = "DELETE FROM webhook WHERE id=$1 AND account_id=$2"
+
+const createWebhookDeliverySQL = "INSERT INTO webhookdelivery (webhook_id, event, payload, status, next_attempt) VALUES ($1,$2,$3,$4,$5)"
+
+const listWebhookDeliveriesSQL = `
+	SELECT d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt, d.response_code, d.last_error, d.created, d.delivered
+	FROM webhookdelivery d
+	INNER JOIN webhook w ON w.id=d.webhook_id
+	WHERE d.webhook_id=$1 AND w.account_id=$2 AND ($3='' OR d.status=$3)
+	ORDER BY d.id DESC
+	LIMIT $4`
+
+// The due deliveries are claimed by moving their next attempt to the end of the lease, so the
+// other services skip them. The condition is checked again on the claimed rows, so a delivery
+// that another service claimed first is not claimed twice
+const claimWebhookDeliveriesSQL = `
+	UPDATE webhookdelivery
+	SET next_attempt=$3
+	WHERE id IN (
+		SELECT id FROM webhookdelivery
+		WHERE status=$1 AND next_attempt<=$2
+		ORDER BY next_attempt
+		LIMIT $4)
+	AND status=$1 AND next_attempt<=$2
+	RETURNING id`
+
+const updateWebhookDeliverySQL = `
+	UPDATE webhookdelivery
+	SET status=$2, attempts=$3, next_attempt=$4, response_code=$5, last_error=$6, delivered=$7
+	WHERE id=$1`
+
+const purgeWebhookDeliveriesSQL = "DELETE FROM webhookdelivery WHERE status<>$1 AND created<$2"
+
+// Webhook is an endpoint of an account that is sent the events of the account. The secret is
+// only returned when the webhook is created
+type Webhook struct {
+	ID        int       `json:"id"`
+	AccountID int       `json:"accountID"`
+	URL       string    `json:"url"`
+	Events    []string  `json:"events"`
+	Format    string    `json:"format"`
+	Secret    string    `json:"secret,omitempty"`
+	Created   time.Time `json:"created"`
+}
+
+// WebhookDelivery is an event that is queued for a webhook, with the status of its delivery.
+// The URL, secret and format of the webhook are only set for the delivery
+type WebhookDelivery struct {
+	ID           int        `json:"id"`
+	WebhookID    int        `json:"webhookID"`
+	Event        string     `json:"event"`
+	Payload      string     `json:"payload"`
+	Status       string     `json:"status"`
+	Attempts     int        `json:"attempts"`
+	NextAttempt  time.Time  `json:"nextAttempt"`
+	ResponseCode int        `json:"responseCode"`
+	LastError    string     `json:"lastError"`
+	Created      time.Time  `json:"created"`
+	Delivered    *time.Time `json:"delivered,omitempty"`
+
+	URL    string `json:"-"`
+	Secret string `json:"-"`
+	Format string `json:"-"`
+}
+
+// CreateWebhookTable creates the database tables for the webhooks and their deliveries
+func (db *DB) CreateWebhookTable(ctx context.Context) error {
+	if _, err := db.ExecContext(ctx, createWebhookTableSQL); err != nil {
+		return err
+	}
+	if _, err := db.ExecContext(ctx, createWebhookDeliveryTableSQL); err != nil {
+		return err
+	}
+	_, err := db.ExecContext(ctx, createWebhookDeliveryDueIndexSQL)
+	return err
+}
+
+// AlterWebhookTable adds the format of the webhooks, which is skipped if it already exists
+func (db *DB) AlterWebhookTable(ctx context.Context) error {
+	db.ExecContext(ctx, alterWebhookFormatSQL)
+	return nil
+}
+
+// QueueWebhookDeliveries queues the event for the webhooks of the account that are registered
+// for it, and returns the number of deliveries
+func (db *DB) QueueWebhookDeliveries(ctx context.Context, authorityID, event, payload string) (int, error) {
+	webhooks, err := db.queryWebhooks(ctx, listAccountWebhooksSQL, authorityID)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	now := time.Now().UTC()
+	for _, w := range webhooks {
+		if !inList(w.Events, event) {
+			continue
+		}
+		if _, err := db.ExecContext(ctx, createWebhookDeliverySQL, w.ID, event, payload, WebhookDeliveryPending, now); err != nil {
+			return queued, fmt.Errorf("error queueing the webhook delivery: %v", err)
+		}
+		queued++
+	}
+	return queued, nil
+}
+
+// ClaimWebhookDeliveries claims the pending deliveries that are due to be attempted, with the URL
+// and secret of their webhook. A claimed delivery is not returned to the other services, until
+// its attempt is recorded or the lease expires
+func (db *DB) ClaimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]WebhookDelivery, error) {
+	ids, err := db.claimWebhookDeliveries(ctx, now, limit)
+	if err != nil {
+		return nil, fmt.Errorf("error claiming the webhook deliveries: %v", err)
+	}
+
+	deliveries := []WebhookDelivery{}
+	if len(ids) == 0 {
+		return deliveries, nil
+	}
+
+	rows, err := sq.
+		Select("d.id, d.webhook_id, d.event, d.payload, d.status, d.attempts, d.next_attempt, d.response_code, d.last_error, d.created, d.delivered, w.url, w.secret, w.format").
+		From("webhookdelivery d").
+		Join("webhook w ON w.id=d.webhook_id").
+		Where(sq.Eq{"d.id": ids}).
+		OrderBy("d.id").
+		PlaceholderFormat(sq.Dollar).
+		RunWith(db).QueryContext(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		d := WebhookDelivery{}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttempt,
+			&d.ResponseCode, &d.LastError, &d.Created, &d.Delivered, &d.URL, &d.Secret, &d.Format)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving the webhook deliveries: %v", err)
+		}
+		if d.Secret, err = decryptColumn(d.Secret); err != nil {
+			return nil, fmt.Errorf("error unsealing the webhook secret: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+func (db *DB) claimWebhookDeliveries(ctx context.Context, now time.Time, limit int) ([]int, error) {
+	rows, err := db.QueryContext(ctx, claimWebhookDeliveriesSQL, WebhookDeliveryPending, now.UTC(), now.UTC().Add(webhookDeliveryLease), limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// UpdateWebhookDelivery records the result of an attempt of a delivery
+func (db *DB) UpdateWebhookDelivery(ctx context.Context, d WebhookDelivery) error {
+	_, err := db.ExecContext(ctx, updateWebhookDeliverySQL, d.ID, d.Status, d.Attempts, d.NextAttempt.UTC(), d.ResponseCode, d.LastError, d.Delivered)
+	if err != nil {
+		return fmt.Errorf("error updating the webhook delivery: %v", err)
+	}
+	return nil
+}
+
+// PurgeWebhookDeliveries deletes the delivered and failed deliveries that are older than the retention
+func (db *DB) PurgeWebhookDeliveries(ctx context.Context, now time.Time) (int, error) {
+	result, err := db.ExecContext(ctx, purgeWebhookDeliveriesSQL, WebhookDeliveryPending, now.UTC().Add(-WebhookDeliveryRetention))
+	if err != nil {
+		return 0, fmt.Errorf("error purging the webhook deliveries: %v", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("error purging the webhook deliveries: %v", err)
+	}
+	return int(purged), nil
+}
+
+func (db *DB) queryWebhooks(ctx context.Context, query string, args ...interface{}) ([]Webhook, error) {
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the webhooks: %v", err)
+	}
+	defer rows.Close()
+
+	webhooks := []Webhook{}
+	for rows.Next() {
+		w := Webhook{}
+		var events string
+		if err := rows.Scan(&w.ID, &w.AccountID, &w.URL, &events, &w.Format, &w.Created); err != nil {
+			return nil, fmt.Errorf("error retrieving the webhooks: %v", err)
+		}
+		w.Events = strings.Split(events, ",")
+		webhooks = append(webhooks, w)
+	}
+	return webhooks, rows.Err()
+}
+
+func (db *DB) listWebhooks(ctx context.Context, accountID int) ([]Webhook, error) {
+	return db.queryWebhooks(ctx, listWebhooksSQL, accountID)
+}
+
+func (db *DB) createWebhook(ctx context.Context, w Webhook) (Webhook, error) {
+	secret, err := random.GenerateRandomString(webhookSecretLength)
+	if err != nil {
+		return w, fmt.Errorf("error generating the webhook secret: %v", err)
+	}
+
+	stored, err := encryptWebhookSecret(secret)
+	if err != nil {
+		return w, fmt.Errorf("error sealing the webhook secret: %v", err)
+	}
+
+	err = db.QueryRowContext(ctx, createWebhookSQL, w.AccountID, w.URL, strings.Join(w.Events, ","), stored, w.Format).Scan(&w.ID)
+	if err != nil {
+		return w, fmt.Errorf("error creating the webhook: %v", err)
+	}
+	w.Secret = secret
+	w.Created = time.Now().UTC()
+	return w, nil
+}
+
+// deleteWebhook removes a webhook of the account with its deliveries
+func (db *DB) deleteWebhook(ctx context.Context, webhookID, accountID int) error {
+	return db.transaction(ctx, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, deleteWebhookDeliveriesSQL, webhookID, accountID); err != nil {
+			return fmt.Errorf("error deleting the webhook deliveries: %v", err)
+		}
+		if _, err := tx.ExecContext(ctx, deleteWebhookSQL, webhookID, accountID); err != nil {
+			return fmt.Errorf("error deleting the webhook: %v", err)
+		}
+		return nil
+	})
+}
+
+func (db *DB) listWebhookDeliveries(ctx context.Context, webhookID, accountID int, status string) ([]WebhookDelivery, error) {
+	rows, err := db.QueryContext(ctx, listWebhookDeliveriesSQL, webhookID, accountID, status, maxWebhookDeliveries)
+	if err != nil {
+		return nil, fmt.Errorf("error retrieving the webhook deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	deliveries := []WebhookDelivery{}
+	for rows.Next() {
+		d := WebhookDelivery{}
+		err := rows.Scan(&d.ID, &d.WebhookID, &d.Event, &d.Payload, &d.Status, &d.Attempts, &d.NextAttempt,
+			&d.ResponseCode, &d.LastError, &d.Created, &d.Delivered)
+		if err != nil {
+			return nil, fmt.Errorf("error retrieving the webhook deliveries: %v", err)
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// validateWebhook checks the URL, the events and the format of a webhook, removing the duplicate
+// events. The events are sent as JSON, unless another format is set. The internal addresses are
+// refused when the events are delivered, as the host name can resolve to another address later
+func validateWebhook(w *Webhook) error {
+	u, err := url.Parse(strings.TrimSpace(w.URL))
+	if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+		return errors.New("The URL of the webhook must be an https URL")
+	}
+	w.URL = u.String()
+
+	events := []string{}
+	for _, e := range w.Events {
+		e = strings.TrimSpace(e)
+		if !inList(validWebhookEvents, e) {
+			return fmt.Errorf("The event must be one of: %s", strings.Join(validWebhookEvents, ", "))
+		}
+		if !inList(events, e) {
+			events = append(events, e)
+		}
+	}
+	if len(events) == 0 {
+		return errors.New("The webhook must have at least one event")
+	}
+	w.Events = events
+
+	w.Format = strings.TrimSpace(w.Format)
+	if len(w.Format) == 0 {
+		w.Format = WebhookFormatJSON
+	}
+	if !inList(validWebhookFormats, w.Format) {
+		return fmt.Errorf("The format must be one of: %s", strings.Join(validWebhookFormats, ", "))
+	}
+	return nil
+}
+
+// ValidWebhookDeliveryStatus checks the status of the deliveries that are listed, where empty
+// means all the deliveries
+func ValidWebhookDeliveryStatus(status string) bool {
+	switch status {
+	case "", WebhookDeliveryPending, WebhookDeliveryDelivered, WebhookDeliveryFailed:
+		return true
+	}
+	return false
+}
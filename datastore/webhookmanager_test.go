@@ -0,0 +1,154 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package datastore
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestWebhookDeliveries(t *testing.T) {
+	saved := Environ
+	defer func() { Environ = saved }()
+	db := openSQLiteTestDatabase(t)
+	defer db.Close()
+
+	ctx := context.Background()
+	root := User{Role: Superuser}
+	if _, err := db.PutAccount(ctx, Account{AuthorityID: "alder", Assertion: "assertion"}, root); err != nil {
+		t.Fatalf("PutAccount() error = %v", err)
+	}
+	account, err := db.GetAccount(ctx, "alder")
+	if err != nil {
+		t.Fatalf("GetAccount() error = %v", err)
+	}
+
+	if _, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "ftp://hooks.example.com", Events: []string{WebhookEventKeypair}}, root); err == nil {
+		t.Error("CreateAllowedWebhook() expected an error for an invalid URL")
+	}
+	if _, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "http://hooks.example.com", Events: []string{WebhookEventKeypair}}, root); err == nil {
+		t.Error("CreateAllowedWebhook() expected an error for an http URL")
+	}
+	if _, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "https://hooks.example.com", Events: []string{"invalid"}}, root); err == nil {
+		t.Error("CreateAllowedWebhook() expected an error for an invalid event")
+	}
+	if _, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "https://hooks.example.com", Events: []string{WebhookEventKeypair}, Format: "invalid"}, root); err == nil {
+		t.Error("CreateAllowedWebhook() expected an error for an invalid format")
+	}
+
+	w, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "https://hooks.example.com/vault", Events: []string{WebhookEventKeypair, WebhookEventKeypair}}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedWebhook() error = %v", err)
+	}
+	if len(w.Secret) == 0 || len(w.Events) != 1 || w.Format != WebhookFormatJSON {
+		t.Errorf("CreateAllowedWebhook() = %v, want a secret and the distinct events", w)
+	}
+
+	// The secret is stored encrypted
+	var stored string
+	if err := db.QueryRow("SELECT secret FROM webhook WHERE id=$1", w.ID).Scan(&stored); err != nil || !Encrypted(stored) {
+		t.Errorf("stored secret = %q, %v, want an encrypted secret", stored, err)
+	}
+
+	// The events are only queued for the webhooks that are registered for them
+	if n, err := db.QueueWebhookDeliveries(ctx, "alder", WebhookEventAccount, "{}"); err != nil || n != 0 {
+		t.Errorf("QueueWebhookDeliveries() = %d, %v, want no deliveries", n, err)
+	}
+	if n, err := db.QueueWebhookDeliveries(ctx, "alder", WebhookEventKeypair, `{"event":"keypair"}`); err != nil || n != 1 {
+		t.Fatalf("QueueWebhookDeliveries() = %d, %v, want 1 delivery", n, err)
+	}
+
+	now := time.Now()
+	due, err := db.ClaimWebhookDeliveries(ctx, now, 10)
+	if err != nil || len(due) != 1 {
+		t.Fatalf("ClaimWebhookDeliveries() = %v, %v, want 1 delivery", due, err)
+	}
+	d := due[0]
+	if d.URL != w.URL || d.Secret != w.Secret || d.Format != WebhookFormatJSON || d.Payload != `{"event":"keypair"}` {
+		t.Errorf("ClaimWebhookDeliveries() = %v, want the webhook and payload", d)
+	}
+
+	// The claimed delivery is not claimed again by another service, until the lease expires
+	if again, err := db.ClaimWebhookDeliveries(ctx, now, 10); err != nil || len(again) != 0 {
+		t.Errorf("ClaimWebhookDeliveries() = %v, %v, want no deliveries", again, err)
+	}
+	if again, err := db.ClaimWebhookDeliveries(ctx, now.Add(webhookDeliveryLease+time.Second), 10); err != nil || len(again) != 1 {
+		t.Errorf("ClaimWebhookDeliveries() after the lease = %v, %v, want 1 delivery", again, err)
+	}
+
+	// A retried delivery is not due until its next attempt
+	d.Attempts = 1
+	d.NextAttempt = now.Add(time.Minute)
+	d.ResponseCode = 500
+	d.LastError = "HTTP 500"
+	if err := db.UpdateWebhookDelivery(ctx, d); err != nil {
+		t.Fatalf("UpdateWebhookDelivery() error = %v", err)
+	}
+	if due, _ := db.ClaimWebhookDeliveries(ctx, now.Add(30*time.Second), 10); len(due) != 0 {
+		t.Errorf("ClaimWebhookDeliveries() = %v, want no due deliveries", due)
+	}
+
+	delivered := now.Add(2 * time.Minute).UTC()
+	d.Status = WebhookDeliveryDelivered
+	d.Attempts = 2
+	d.ResponseCode = 200
+	d.LastError = ""
+	d.Delivered = &delivered
+	if err := db.UpdateWebhookDelivery(ctx, d); err != nil {
+		t.Fatalf("UpdateWebhookDelivery() error = %v", err)
+	}
+
+	list, err := db.ListAllowedWebhookDeliveries(ctx, w.ID, account.ID, WebhookDeliveryDelivered, root)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("ListAllowedWebhookDeliveries() = %v, %v, want 1 delivery", list, err)
+	}
+	if list[0].Attempts != 2 || list[0].ResponseCode != 200 || list[0].Delivered == nil {
+		t.Errorf("ListAllowedWebhookDeliveries() = %v, want the delivered status", list[0])
+	}
+	if list, _ := db.ListAllowedWebhookDeliveries(ctx, w.ID, account.ID, WebhookDeliveryFailed, root); len(list) != 0 {
+		t.Errorf("ListAllowedWebhookDeliveries() = %v, want no failed deliveries", list)
+	}
+
+	// The deliveries are purged after the retention
+	if n, err := db.PurgeWebhookDeliveries(ctx, now.Add(WebhookDeliveryRetention+time.Hour)); err != nil || n != 1 {
+		t.Errorf("PurgeWebhookDeliveries() = %d, %v, want 1 purged", n, err)
+	}
+
+	// The events of a chat channel are posted as messages
+	slack, err := db.CreateAllowedWebhook(ctx, Webhook{AccountID: account.ID, URL: "https://hooks.slack.com/services/T/B/X", Events: []string{WebhookEventSigningAnomaly}, Format: WebhookFormatSlack}, root)
+	if err != nil {
+		t.Fatalf("CreateAllowedWebhook() error = %v", err)
+	}
+	if webhooks, err := db.ListAllowedWebhooks(ctx, account.ID, root); err != nil || len(webhooks) != 2 || webhooks[1].Format != WebhookFormatSlack {
+		t.Errorf("ListAllowedWebhooks() = %v, %v, want the slack webhook", webhooks, err)
+	}
+	if err := db.DeleteAllowedWebhook(ctx, slack.ID, account.ID, root); err != nil {
+		t.Fatalf("DeleteAllowedWebhook() error = %v", err)
+	}
+
+	if err := db.DeleteAllowedWebhook(ctx, w.ID, account.ID, root); err != nil {
+		t.Fatalf("DeleteAllowedWebhook() error = %v", err)
+	}
+	if webhooks, err := db.ListAllowedWebhooks(ctx, account.ID, root); err != nil || len(webhooks) != 0 {
+		t.Errorf("ListAllowedWebhooks() = %v, %v, want no webhooks", webhooks, err)
+	}
+}
@@ -0,0 +1,37 @@
+//go:build fips
+// +build fips
+
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fips
+
+// The fips build needs the BoringCrypto module, built with GOEXPERIMENT=boringcrypto, and
+// restricts the TLS of the crypto/tls package to the approved settings
+import (
+	"crypto/boring"
+	_ "crypto/tls/fipsonly"
+)
+
+// buildMode enables the FIPS mode, regardless of the config
+const buildMode = true
+
+// boringCrypto checks that the crypto is the FIPS validated BoringCrypto module
+var boringCrypto = boring.Enabled
@@ -0,0 +1,128 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package fips restricts the crypto of the vault to the FIPS-approved algorithms, for the
+// government manufacturing programs. The mode is enabled by the fipsMode setting, or by
+// building the service with the fips build tag
+package fips
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// MinRSABits is the smallest RSA key that is approved, for the signing keys and the TLS certificates
+const MinRSABits = 2048
+
+// minSecretLength is the smallest keystore and JWT secret in bytes. The keystore secret is the
+// AES-256 key of the sealed signing keys, so it must not be padded, and the JWT secret is the
+// HMAC-SHA256 key of the sessions
+const minSecretLength = 32
+
+// The cipher suites of TLS 1.2, with the approved key exchanges and AEAD ciphers. The cipher
+// suites of TLS 1.3 cannot be restricted and include ChaCha20-Poly1305, so TLS 1.2 is used
+var cipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+}
+
+// The curves of the key exchange, without X25519
+var curves = []tls.CurveID{tls.CurveP256, tls.CurveP384, tls.CurveP521}
+
+// Enabled checks if the FIPS mode is on, from the config or the build
+func Enabled(settings config.Settings) bool {
+	return buildMode || settings.FIPSMode
+}
+
+// Check returns an error when the config uses crypto that is not approved in FIPS mode. The
+// keystore type is checked when the keystore is opened
+func Check(settings config.Settings) error {
+	if !Enabled(settings) {
+		return nil
+	}
+
+	if !boringCrypto() {
+		return fmt.Errorf("FIPS mode: the service must be built with the BoringCrypto module: GOEXPERIMENT=boringcrypto go build -tags fips")
+	}
+
+	if len(settings.KeyStoreSecret) < minSecretLength {
+		return fmt.Errorf("FIPS mode: the keystore secret must be at least %d bytes", minSecretLength)
+	}
+	if len(settings.JwtSecret) > 0 && len(settings.JwtSecret) < minSecretLength {
+		return fmt.Errorf("FIPS mode: the JWT secret must be at least %d bytes", minSecretLength)
+	}
+
+	if len(settings.TLSCertFile) > 0 && len(settings.TLSKeyFile) > 0 {
+		cert, err := tls.LoadX509KeyPair(settings.TLSCertFile, settings.TLSKeyFile)
+		if err != nil {
+			return fmt.Errorf("FIPS mode: error loading the TLS certificate: %v", err)
+		}
+		leaf, err := x509.ParseCertificate(cert.Certificate[0])
+		if err != nil {
+			return fmt.Errorf("FIPS mode: error parsing the TLS certificate: %v", err)
+		}
+		if err := CheckPublicKey(leaf.PublicKey); err != nil {
+			return fmt.Errorf("FIPS mode: the TLS certificate: %v", err)
+		}
+	}
+	return nil
+}
+
+// CheckPublicKey returns an error when the key type or size is not approved: RSA keys must be
+// at least 2048 bits and ECDSA keys must be on a NIST curve
+func CheckPublicKey(key interface{}) error {
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		return CheckRSABits(k.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P256(), elliptic.P384(), elliptic.P521():
+			return nil
+		}
+		return fmt.Errorf("the ECDSA curve %s is not approved", k.Curve.Params().Name)
+	default:
+		return fmt.Errorf("the key type %T is not approved", key)
+	}
+}
+
+// CheckRSABits returns an error when an RSA key is too small
+func CheckRSABits(bits int) error {
+	if bits < MinRSABits {
+		return fmt.Errorf("the RSA key has %d bits, at least %d are needed", bits, MinRSABits)
+	}
+	return nil
+}
+
+// TLSConfig restricts the TLS config to the approved version, cipher suites and curves
+func TLSConfig(cfg *tls.Config) *tls.Config {
+	cfg.MinVersion = tls.VersionTLS12
+	cfg.MaxVersion = tls.VersionTLS12
+	cfg.CipherSuites = cipherSuites
+	cfg.CurvePreferences = curves
+	return cfg
+}
@@ -0,0 +1,126 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package fips
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+const secret = "0123456789abcdef0123456789abcdef"
+
+func TestCheck(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Settings
+		err      string
+	}{
+		{"disabled", config.Settings{KeyStoreSecret: "short"}, ""},
+		{"valid", config.Settings{FIPSMode: true, KeyStoreSecret: secret, JwtSecret: secret}, ""},
+		{"no-jwt-secret", config.Settings{FIPSMode: true, KeyStoreSecret: secret}, ""},
+		{"short-keystore-secret", config.Settings{FIPSMode: true, KeyStoreSecret: "short"}, "keystore secret"},
+		{"short-jwt-secret", config.Settings{FIPSMode: true, KeyStoreSecret: secret, JwtSecret: "short"}, "JWT secret"},
+		{"missing-certificate", config.Settings{FIPSMode: true, KeyStoreSecret: secret, TLSCertFile: "missing.pem", TLSKeyFile: "missing.key"}, "TLS certificate"},
+	}
+
+	saved := boringCrypto
+	defer func() { boringCrypto = saved }()
+	boringCrypto = func() bool { return true }
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if buildMode && tt.name == "disabled" {
+				t.Skip("FIPS mode is built in")
+			}
+			err := Check(tt.settings)
+			if len(tt.err) == 0 && err != nil {
+				t.Errorf("Check() error = %v", err)
+			}
+			if len(tt.err) > 0 && (err == nil || !strings.Contains(err.Error(), tt.err)) {
+				t.Errorf("Check() error = %v, expected %v", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCheckBoringCrypto(t *testing.T) {
+	saved := boringCrypto
+	defer func() { boringCrypto = saved }()
+	boringCrypto = func() bool { return false }
+
+	err := Check(config.Settings{FIPSMode: true, KeyStoreSecret: secret})
+	if err == nil || !strings.Contains(err.Error(), "BoringCrypto") {
+		t.Errorf("Check() error = %v, expected the BoringCrypto module", err)
+	}
+}
+
+func TestCheckPublicKey(t *testing.T) {
+	rsa1024, _ := rsa.GenerateKey(rand.Reader, 1024)
+	rsa2048, _ := rsa.GenerateKey(rand.Reader, 2048)
+	p256, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	p224, _ := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	edKey, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name    string
+		key     interface{}
+		wantErr bool
+	}{
+		{"rsa-2048", &rsa2048.PublicKey, false},
+		{"rsa-1024", &rsa1024.PublicKey, true},
+		{"ecdsa-p256", &p256.PublicKey, false},
+		{"ecdsa-p224", &p224.PublicKey, true},
+		{"ed25519", edKey, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := CheckPublicKey(tt.key); (err != nil) != tt.wantErr {
+				t.Errorf("CheckPublicKey() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	cfg := TLSConfig(&tls.Config{})
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.MaxVersion != tls.VersionTLS12 {
+		t.Errorf("TLSConfig() versions = %x-%x", cfg.MinVersion, cfg.MaxVersion)
+	}
+	for _, c := range cfg.CurvePreferences {
+		if c == tls.X25519 {
+			t.Error("TLSConfig() must not use X25519")
+		}
+	}
+	for _, s := range cfg.CipherSuites {
+		if !strings.Contains(tls.CipherSuiteName(s), "GCM") {
+			t.Errorf("TLSConfig() cipher suite %s is not approved", tls.CipherSuiteName(s))
+		}
+	}
+}
@@ -1,37 +1,69 @@
 module github.com/CanonicalLtd/serial-vault
 
-go 1.13
+go 1.19
 
 require (
 	github.com/Masterminds/squirrel v1.2.0
 	github.com/dgrijalva/jwt-go v3.2.0+incompatible
-	github.com/godbus/dbus v4.1.0+incompatible // indirect
+	github.com/go-asn1-ber/asn1-ber v1.5.4
+	github.com/go-ldap/ldap/v3 v3.4.4
 	github.com/gorilla/csrf v1.0.3-0.20161122164500-69581736821c
 	github.com/gorilla/mux v1.6.1
 	github.com/gorilla/securecookie v1.1.1
 	github.com/jessevdk/go-flags v1.4.0
-	github.com/juju/ratelimit v1.0.1 // indirect
 	github.com/juju/usso v0.0.0-20160418121039-5b79b358f4bb
 	github.com/lann/builder v0.0.0-20180802200727-47ae307949d0
 	github.com/lann/ps v0.0.0-20150810152359-62de8c46ede0
 	github.com/lib/pq v0.0.0-20180327071824-d34b9ff171c2
-	github.com/mattn/go-sqlite3 v1.6.0
+	github.com/mattn/go-sqlite3 v1.14.22
 	github.com/ojii/gettext.go v0.0.0-20170120061437-b6dae1d7af8a
-	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/pkg/errors v0.8.1-0.20180311214515-816c9085562c
 	github.com/prometheus/client_golang v1.1.0
-	github.com/snapcore/bolt v1.3.1 // indirect
-	github.com/snapcore/go-gettext v0.0.0-20191107141714-82bbea49e785 // indirect
-	github.com/snapcore/snapd v0.0.0-20200317200833-16631e228c07
+	github.com/snapcore/snapd v0.0.0-20210726143858-26a7ab7b6a92
 	github.com/yohcop/openid-go v0.0.0-20170901155220-cfc72ed89575
-	golang.org/x/crypto v0.0.0-20190308221718-c2843e01d9a2
-	golang.org/x/net v0.0.0-20190613194153-d28f0bde5980
-	golang.org/x/sys v0.0.0-20190801041406-cbf593c0f2f3
-	golang.org/x/xerrors v0.0.0-20191204190536-9bdfabe68543 // indirect
-	gopkg.in/check.v1 v1.0.0-20161208181325-20d25e280405
+	go.mozilla.org/pkcs7 v0.10.0
+	go.opentelemetry.io/otel v1.16.0
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc v1.16.0
+	go.opentelemetry.io/otel/sdk v1.16.0
+	go.opentelemetry.io/otel/trace v1.16.0
+	golang.org/x/crypto v0.10.0
+	golang.org/x/net v0.11.0
+	golang.org/x/sys v0.10.0
+	google.golang.org/grpc v1.56.3
+	google.golang.org/protobuf v1.31.0
+	gopkg.in/check.v1 v1.0.0-20180628173108-788fd7840127
 	gopkg.in/errgo.v1 v1.0.0-20161222125816-442357a80af5
 	gopkg.in/macaroon.v1 v1.0.0-20170816141150-ab101776739e
 	gopkg.in/retry.v1 v1.0.0
 	gopkg.in/tomb.v2 v2.0.0-20161208151619-d5d1b5820637
-	gopkg.in/yaml.v2 v2.2.1
+	gopkg.in/yaml.v2 v2.4.0
+)
+
+require (
+	github.com/Azure/go-ntlmssp v0.0.0-20220621081337-cb9428e4ac1e // indirect
+	github.com/beorn7/perks v1.0.1 // indirect
+	github.com/cenkalti/backoff/v4 v4.2.1 // indirect
+	github.com/go-logr/logr v1.2.4 // indirect
+	github.com/go-logr/stdr v1.2.2 // indirect
+	github.com/godbus/dbus v4.1.0+incompatible // indirect
+	github.com/golang/protobuf v1.5.3 // indirect
+	github.com/grpc-ecosystem/grpc-gateway/v2 v2.15.2 // indirect
+	github.com/juju/ratelimit v1.0.1 // indirect
+	github.com/kr/pretty v0.3.1 // indirect
+	github.com/kr/text v0.2.0 // indirect
+	github.com/matttproud/golang_protobuf_extensions v1.0.1 // indirect
+	github.com/prometheus/client_model v0.2.0 // indirect
+	github.com/prometheus/common v0.6.0 // indirect
+	github.com/prometheus/procfs v0.0.3 // indirect
+	github.com/rogpeppe/go-internal v1.9.0 // indirect
+	github.com/snapcore/bolt v1.3.1 // indirect
+	github.com/snapcore/go-gettext v0.0.0-20191107141714-82bbea49e785 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/internal/retry v1.16.0 // indirect
+	go.opentelemetry.io/otel/exporters/otlp/otlptrace v1.16.0 // indirect
+	go.opentelemetry.io/otel/metric v1.16.0 // indirect
+	go.opentelemetry.io/proto/otlp v0.19.0 // indirect
+	golang.org/x/term v0.10.0 // indirect
+	golang.org/x/text v0.13.0 // indirect
+	golang.org/x/xerrors v0.0.0-20220907171357-04be3eba64a2 // indirect
+	google.golang.org/genproto v0.0.0-20230410155749-daa745c078e1 // indirect
 )
@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ldapsync
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+// Directory is the source of the users and groups that are synced
+type Directory interface {
+	// Entry returns the attributes of the entry, keyed by the lowercase attribute name
+	Entry(dn string, attributes ...string) (map[string][]string, error)
+	// UserExists checks whether a user with the username is under the base DN of the directory
+	UserExists(baseDN, userAttribute, username string) (bool, error)
+	Close() error
+}
+
+// ErrNoSuchEntry is returned when the entry is not in the directory
+var ErrNoSuchEntry = errors.New("no such entry in the directory")
+
+// ldapTimeout is the timeout of each operation on the LDAP server
+const ldapTimeout = time.Minute
+
+// LDAP reads the entries from an LDAP server or Active Directory with simple bind
+type LDAP struct {
+	conn *ldap.Conn
+}
+
+// DialLDAP connects to the LDAP server, with ldap:// or ldaps:// URL, upgrades the ldap://
+// connection with StartTLS when it is needed, and binds with the DN and password. An empty
+// bind DN binds anonymously
+func DialLDAP(cfg Config) (*LDAP, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid LDAP URL: %v", err)
+	}
+
+	tlsConfig := cfg.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{}
+	}
+	tlsConfig = tlsConfig.Clone()
+	tlsConfig.ServerName = u.Hostname()
+
+	conn, err := ldap.DialURL(cfg.URL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to the LDAP server: %v", err)
+	}
+	conn.SetTimeout(ldapTimeout)
+
+	if cfg.StartTLS {
+		if err = conn.StartTLS(tlsConfig); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("error starting TLS on the LDAP connection: %v", err)
+		}
+	}
+
+	l := NewLDAP(conn)
+	if err = l.Bind(cfg.BindDN, cfg.BindPassword); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return l, nil
+}
+
+// NewLDAP creates the client on an open connection to the LDAP server
+func NewLDAP(conn *ldap.Conn) *LDAP {
+	return &LDAP{conn: conn}
+}
+
+// Bind authenticates the connection with the DN and password
+func (l *LDAP) Bind(dn, password string) error {
+	var err error
+	if len(dn) == 0 && len(password) == 0 {
+		err = l.conn.UnauthenticatedBind("")
+	} else {
+		err = l.conn.Bind(dn, password)
+	}
+	if err != nil {
+		return fmt.Errorf("error binding to the LDAP server: %v", err)
+	}
+	return nil
+}
+
+// Entry reads the attributes of the entry with a base-object search
+func (l *LDAP) Entry(dn string, attributes ...string) (map[string][]string, error) {
+	request := ldap.NewSearchRequest(dn, ldap.ScopeBaseObject, ldap.NeverDerefAliases, 1, int(ldapTimeout/time.Second), false,
+		"(objectClass=*)", attributes, nil)
+
+	result, err := l.conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultNoSuchObject) {
+		return nil, ErrNoSuchEntry
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading %s: %v", dn, err)
+	}
+	if len(result.Entries) == 0 {
+		return nil, ErrNoSuchEntry
+	}
+
+	entry := map[string][]string{}
+	for _, a := range result.Entries[0].Attributes {
+		name := strings.ToLower(a.Name)
+		entry[name] = append(entry[name], a.Values...)
+	}
+	return entry, nil
+}
+
+// UserExists searches the subtree of the base DN for the user with the username
+func (l *LDAP) UserExists(baseDN, userAttribute, username string) (bool, error) {
+	filter := fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(userAttribute), ldap.EscapeFilter(username))
+	request := ldap.NewSearchRequest(baseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 1, int(ldapTimeout/time.Second), false,
+		filter, []string{"1.1"}, nil) // 1.1 returns no attributes
+
+	result, err := l.conn.Search(request)
+	if ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		return true, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("error searching the user %s: %v", username, err)
+	}
+	return len(result.Entries) > 0, nil
+}
+
+// Close unbinds and closes the connection
+func (l *LDAP) Close() error {
+	if err := l.conn.Unbind(); err != nil {
+		l.conn.Close()
+	}
+	return nil
+}
@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ldapsync
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	ber "github.com/go-asn1-ber/asn1-ber"
+	"github.com/go-ldap/ldap/v3"
+)
+
+// fakeServer answers the LDAP requests on the connection with the responses of the operations
+func fakeServer(t *testing.T, conn net.Conn, respond func(op *ber.Packet) []*ber.Packet) {
+	for {
+		msg, err := ber.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		if len(msg.Children) < 2 {
+			t.Errorf("Invalid LDAP request: %v", msg)
+			return
+		}
+		op := msg.Children[1]
+		if op.Tag == ldap.ApplicationUnbindRequest {
+			conn.Close()
+			return
+		}
+
+		for _, response := range respond(op) {
+			envelope := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "LDAP Response")
+			envelope.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagInteger, msg.Children[0].Value, "Message ID"))
+			envelope.AppendChild(response)
+			conn.Write(envelope.Bytes())
+		}
+	}
+}
+
+func ldapResult(tag ber.Tag, code int, message string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, tag, nil, "Result")
+	p.AppendChild(ber.NewInteger(ber.ClassUniversal, ber.TypePrimitive, ber.TagEnumerated, code, "Result Code"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, "", "Matched DN"))
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, message, "Diagnostic Message"))
+	return p
+}
+
+func ldapEntry(dn string, attributes map[string][]string) *ber.Packet {
+	p := ber.Encode(ber.ClassApplication, ber.TypeConstructed, ldap.ApplicationSearchResultEntry, nil, "Entry")
+	p.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, dn, "DN"))
+	attrs := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attributes")
+	for name, values := range attributes {
+		attr := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSequence, nil, "Attribute")
+		attr.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, name, "Name"))
+		vals := ber.Encode(ber.ClassUniversal, ber.TypeConstructed, ber.TagSet, nil, "Values")
+		for _, v := range values {
+			vals.AppendChild(ber.NewString(ber.ClassUniversal, ber.TypePrimitive, ber.TagOctetString, v, "Value"))
+		}
+		attr.AppendChild(vals)
+		attrs.AppendChild(attr)
+	}
+	p.AppendChild(attrs)
+	return p
+}
+
+func testLDAP(t *testing.T) *LDAP {
+	client, server := net.Pipe()
+	go fakeServer(t, server, func(op *ber.Packet) []*ber.Packet {
+		switch op.Tag {
+		case ldap.ApplicationBindRequest:
+			if op.Children[2].Data.String() != "secret" {
+				return []*ber.Packet{ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultInvalidCredentials, "invalid credentials")}
+			}
+			return []*ber.Packet{ldapResult(ldap.ApplicationBindResponse, ldap.LDAPResultSuccess, "")}
+		case ldap.ApplicationSearchRequest:
+			done := ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultSuccess, "")
+			filter, _ := ldap.DecompileFilter(op.Children[6])
+			switch {
+			case op.Children[0].Value == "uid=jane,dc=example":
+				return []*ber.Packet{
+					ldapEntry("uid=jane,dc=example", map[string][]string{"uid": {"jane"}, "displayName": {"Jane Smith"}, "memberOf": {"cn=a", "cn=b"}}),
+					done,
+				}
+			case op.Children[0].Value == "ou=people,dc=example" && filter == "(uid=jane)":
+				return []*ber.Packet{ldapEntry("uid=jane,ou=people,dc=example", nil), done}
+			case op.Children[0].Value == "ou=people,dc=example":
+				return []*ber.Packet{done}
+			}
+			return []*ber.Packet{ldapResult(ldap.ApplicationSearchResultDone, ldap.LDAPResultNoSuchObject, "no such object")}
+		}
+		return nil
+	})
+
+	conn := ldap.NewConn(client, false)
+	conn.Start()
+	return NewLDAP(conn)
+}
+
+func TestLDAP(t *testing.T) {
+	l := testLDAP(t)
+	if err := l.Bind("cn=admin,dc=example", "invalid"); err == nil {
+		t.Error("Expected an error binding with invalid credentials")
+	}
+	if err := l.Bind("cn=admin,dc=example", "secret"); err != nil {
+		t.Fatalf("Error binding: %v", err)
+	}
+
+	entry, err := l.Entry("uid=jane,dc=example", "uid", "displayName", "memberOf")
+	if err != nil {
+		t.Fatalf("Error reading the entry: %v", err)
+	}
+	expected := map[string][]string{"uid": {"jane"}, "displayname": {"Jane Smith"}, "memberof": {"cn=a", "cn=b"}}
+	if !reflect.DeepEqual(entry, expected) {
+		t.Errorf("Expected %v, got %v", expected, entry)
+	}
+
+	if _, err = l.Entry("uid=nobody,dc=example"); err != ErrNoSuchEntry {
+		t.Errorf("Expected no such entry, got %v", err)
+	}
+
+	if err = l.Close(); err != nil {
+		t.Errorf("Error closing: %v", err)
+	}
+}
+
+func TestLDAPUserExists(t *testing.T) {
+	l := testLDAP(t)
+	defer l.Close()
+
+	if err := l.Bind("cn=admin,dc=example", "secret"); err != nil {
+		t.Fatalf("Error binding: %v", err)
+	}
+
+	exists, err := l.UserExists("ou=people,dc=example", "uid", "jane")
+	if err != nil || !exists {
+		t.Errorf("Expected the user to exist, got %v: %v", exists, err)
+	}
+	exists, err = l.UserExists("ou=people,dc=example", "uid", "nobody")
+	if err != nil || exists {
+		t.Errorf("Expected the user not to exist, got %v: %v", exists, err)
+	}
+	if _, err = l.UserExists("ou=missing,dc=example", "uid", "jane"); err == nil {
+		t.Error("Expected an error searching a missing base DN")
+	}
+}
+
+func TestDialLDAPInvalidURL(t *testing.T) {
+	if _, err := DialLDAP(Config{URL: "http://ldap.example.com"}); err == nil {
+		t.Error("Expected an error with an invalid scheme")
+	}
+}
@@ -0,0 +1,493 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ldapsync
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/fips"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// defaultInterval is the interval of the sync when it is not configured
+const defaultInterval = time.Hour
+
+// Default attributes of the users and groups, as in the inetOrgPerson and groupOfNames classes
+const (
+	defaultUserAttribute   = "uid"
+	defaultMemberAttribute = "member"
+)
+
+// syncUser is the user of the changes in the audit log
+var syncUser = datastore.User{Username: "ldap-sync"}
+
+// Config is the sync of the LDAP groups from the config settings
+type Config struct {
+	URL             string        // URL of the LDAP server, empty when the sync is not configured
+	StartTLS        bool          // upgrade the ldap:// connection with StartTLS
+	TLSConfig       *tls.Config   // TLS config of the ldaps:// and StartTLS connections
+	BindDN          string        // DN of the bind, empty for an anonymous bind
+	BindPassword    string        // password of the bind
+	BaseDN          string        // DN under which the users are searched, to find the users removed from the directory
+	UserAttribute   string        // attribute of the users with the username
+	MemberAttribute string        // attribute of the groups with the DNs of the members
+	Interval        time.Duration // interval between the syncs
+	DryRun          bool          // only report the changes, without applying them
+	Groups          []Group
+}
+
+// Group maps the members of an LDAP group to a role in an account
+type Group struct {
+	DN          string
+	AuthorityID string
+	Role        int
+}
+
+// Report holds the changes of a sync, and the conflicts that were not applied
+type Report struct {
+	DryRun    bool       `json:"dryRun"`
+	Created   []string   `json:"created"`
+	Updated   []string   `json:"updated"`
+	Disabled  []string   `json:"disabled"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+// Conflict is a user or group of the directory that could not be synced as it is
+type Conflict struct {
+	Group    string `json:"group"`
+	Account  string `json:"account"`
+	Username string `json:"username"`
+	Message  string `json:"message"`
+}
+
+// member is a user of the directory, with the roles of the groups in the accounts
+type member struct {
+	username string
+	name     string
+	email    string
+	roles    map[string]int
+	accounts map[string]datastore.Account
+}
+
+// NewConfig creates the sync config from the config settings. The sync is only
+// enabled when the URL of the LDAP server is configured
+func NewConfig(settings config.Settings) (Config, error) {
+	cfg := Config{
+		URL:             settings.LDAPURL,
+		StartTLS:        settings.LDAPStartTLS,
+		TLSConfig:       &tls.Config{},
+		BindDN:          settings.LDAPBindDN,
+		BindPassword:    settings.LDAPBindPassword,
+		BaseDN:          settings.LDAPBaseDN,
+		UserAttribute:   settings.LDAPUserAttribute,
+		MemberAttribute: settings.LDAPMemberAttribute,
+		Interval:        defaultInterval,
+		DryRun:          settings.LDAPSyncDryRun,
+	}
+	if len(cfg.URL) == 0 {
+		return cfg, nil
+	}
+
+	if !strings.HasPrefix(cfg.URL, "ldap://") && !strings.HasPrefix(cfg.URL, "ldaps://") {
+		return Config{}, fmt.Errorf("the LDAP URL must start with ldap:// or ldaps://")
+	}
+	if cfg.StartTLS && strings.HasPrefix(cfg.URL, "ldaps://") {
+		return Config{}, fmt.Errorf("the LDAP StartTLS needs an ldap:// URL, as ldaps:// is already TLS")
+	}
+	if len(cfg.BaseDN) == 0 {
+		return Config{}, fmt.Errorf("the LDAP sync needs the base DN of the users")
+	}
+	if fips.Enabled(settings) {
+		cfg.TLSConfig = fips.TLSConfig(cfg.TLSConfig)
+	}
+	if len(cfg.UserAttribute) == 0 {
+		cfg.UserAttribute = defaultUserAttribute
+	}
+	if len(cfg.MemberAttribute) == 0 {
+		cfg.MemberAttribute = defaultMemberAttribute
+	}
+
+	if len(settings.LDAPSyncInterval) > 0 {
+		interval, err := time.ParseDuration(settings.LDAPSyncInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid LDAP sync interval: %v", err)
+		}
+		if interval <= 0 {
+			return Config{}, fmt.Errorf("the LDAP sync interval must be positive")
+		}
+		cfg.Interval = interval
+	}
+
+	if len(settings.LDAPGroups) == 0 {
+		return Config{}, fmt.Errorf("the LDAP sync needs at least one group")
+	}
+	for _, g := range settings.LDAPGroups {
+		if len(g.DN) == 0 || len(g.Account) == 0 {
+			return Config{}, fmt.Errorf("the LDAP groups need the DN of the group and the account")
+		}
+		role, ok := datastore.RoleID[g.Role]
+		if !ok || (role != datastore.Standard && role != datastore.SyncUser && role != datastore.Admin) {
+			return Config{}, fmt.Errorf("invalid role '%s' of the LDAP group %s: the role must be standard, syncuser or admin", g.Role, g.DN)
+		}
+		cfg.Groups = append(cfg.Groups, Group{DN: g.DN, AuthorityID: g.Account, Role: role})
+	}
+	return cfg, nil
+}
+
+// Enabled returns whether the sync of the LDAP groups is configured
+func (cfg Config) Enabled() bool {
+	return len(cfg.URL) > 0
+}
+
+// Job runs the sync of the LDAP groups when the scheduler starts, and then at the interval of the config
+func Job(cfg Config) scheduler.Job {
+	return scheduler.Job{Name: "ldap-sync", Interval: cfg.Interval, RunAtStart: true, Run: func(ctx context.Context) error {
+		_, err := Run(ctx, datastore.Environ.DB, cfg, cfg.DryRun)
+		return err
+	}}
+}
+
+// Run connects to the LDAP server and syncs the groups
+func Run(ctx context.Context, db datastore.Datastore, cfg Config, dryRun bool) (Report, error) {
+	dir, err := DialLDAP(cfg)
+	if err != nil {
+		log.Errorf("Error in the LDAP sync: %v", err)
+		return Report{DryRun: dryRun}, err
+	}
+	defer dir.Close()
+
+	return Sync(ctx, db, dir, cfg, dryRun)
+}
+
+// Sync imports the members of the groups as users of the vault, linked to the accounts of the groups
+// with the role of the group. The users are created or updated, but the links and users that are not
+// in the groups are not removed, as they may have been set up by hand: they are reported as conflicts.
+// The users of the synced accounts that have been removed from the directory are deactivated, except
+// the superusers. When the user is in several groups of an account, the highest role applies. The
+// dry-run returns the report without applying the changes
+func Sync(ctx context.Context, db datastore.Datastore, dir Directory, cfg Config, dryRun bool) (Report, error) {
+	report := Report{DryRun: dryRun, Created: []string{}, Updated: []string{}, Disabled: []string{}, Conflicts: []Conflict{}}
+
+	members, synced, err := readGroups(ctx, db, dir, cfg, &report)
+	if err != nil {
+		log.Errorf("Error in the LDAP sync: %v", err)
+		return report, err
+	}
+
+	usernames := make([]string, 0, len(members))
+	for username := range members {
+		usernames = append(usernames, username)
+	}
+	sort.Strings(usernames)
+
+	for _, username := range usernames {
+		m := members[username]
+
+		existing, err := db.GetUserByUsername(ctx, username)
+		if err != nil {
+			user := newUser(m)
+			if !dryRun {
+				if user.ID, err = db.CreateUser(ctx, user); err != nil {
+					report.conflict("", "", username, fmt.Sprintf("error creating the user: %v", err))
+					continue
+				}
+				audit.Record(ctx, syncUser, audit.ActionCreate, audit.ObjectUser, user.ID, "", nil, user)
+			}
+			report.Created = append(report.Created, username)
+			continue
+		}
+
+		user, changed := mergeUser(existing, m)
+		if !changed {
+			continue
+		}
+		if !dryRun {
+			if err = db.UpdateUser(ctx, user); err != nil {
+				report.conflict("", "", username, fmt.Sprintf("error updating the user: %v", err))
+				continue
+			}
+			audit.Record(ctx, syncUser, audit.ActionUpdate, audit.ObjectUser, user.ID, "", existing, user)
+		}
+		report.Updated = append(report.Updated, username)
+	}
+
+	// The links of the synced accounts that are not in the groups are kept, but reported, and
+	// the users that are not in the directory any more are deactivated
+	checked := map[string]bool{}
+	for _, authorityID := range synced {
+		users, err := db.ListAccountUsers(ctx, authorityID)
+		if err != nil {
+			log.Errorf("Error in the LDAP sync: %v", err)
+			return report, err
+		}
+		for _, u := range users {
+			if m, ok := members[u.Username]; ok {
+				if _, ok := m.roles[authorityID]; ok {
+					continue
+				}
+			}
+
+			if _, ok := members[u.Username]; !ok && !checked[u.Username] {
+				checked[u.Username] = true
+				removed, err := removeUser(ctx, db, dir, cfg, u, dryRun)
+				if err != nil {
+					log.Errorf("Error in the LDAP sync: %v", err)
+					return report, err
+				}
+				if removed {
+					report.Disabled = append(report.Disabled, u.Username)
+					continue
+				}
+			}
+			if contains(report.Disabled, u.Username) {
+				continue
+			}
+			report.conflict("", authorityID, u.Username, "the user is linked to the account but is not in its groups, so the link is kept")
+		}
+	}
+
+	log.Infof("LDAP sync (dry-run %v): %d users created, %d users updated, %d users disabled, %d conflicts",
+		dryRun, len(report.Created), len(report.Updated), len(report.Disabled), len(report.Conflicts))
+	for _, c := range report.Conflicts {
+		log.Warningf("LDAP sync conflict: group '%s', account '%s', user '%s': %s", c.Group, c.Account, c.Username, c.Message)
+	}
+	return report, nil
+}
+
+// removeUser deactivates the active user when it is not in the directory any more. The superusers
+// are kept, as they are the break-glass users of the vault
+func removeUser(ctx context.Context, db datastore.Datastore, dir Directory, cfg Config, user datastore.User, dryRun bool) (bool, error) {
+	if !user.Active || user.Role >= datastore.Superuser {
+		return false, nil
+	}
+
+	exists, err := dir.UserExists(cfg.BaseDN, cfg.UserAttribute, user.Username)
+	if err != nil || exists {
+		return false, err
+	}
+
+	if !dryRun {
+		if err = db.SetUserActive(ctx, user.ID, false); err != nil {
+			return false, fmt.Errorf("error deactivating the user %s: %v", user.Username, err)
+		}
+		after := user
+		after.Active = false
+		audit.Record(ctx, syncUser, audit.ActionDisable, audit.ObjectUser, user.ID, "", user, after)
+	}
+	return true, nil
+}
+
+// readGroups reads the members of the groups from the directory, keyed by the username, and
+// returns the accounts of the groups that were read
+func readGroups(ctx context.Context, db datastore.Datastore, dir Directory, cfg Config, report *Report) (map[string]*member, []string, error) {
+	members := map[string]*member{}
+	synced := []string{}
+	memberAttribute := strings.ToLower(cfg.MemberAttribute)
+
+	for _, g := range cfg.Groups {
+		account, err := db.GetAccount(ctx, g.AuthorityID)
+		if err != nil {
+			report.conflict(g.DN, g.AuthorityID, "", "the account is not in the vault")
+			continue
+		}
+
+		group, err := dir.Entry(g.DN, cfg.MemberAttribute)
+		if err == ErrNoSuchEntry {
+			report.conflict(g.DN, g.AuthorityID, "", "the group is not in the directory")
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		if !contains(synced, g.AuthorityID) {
+			synced = append(synced, g.AuthorityID)
+		}
+
+		for _, dn := range group[memberAttribute] {
+			entry, err := dir.Entry(dn, cfg.UserAttribute, "cn", "displayName", "mail")
+			if err == ErrNoSuchEntry {
+				report.conflict(g.DN, g.AuthorityID, dn, "the member is not in the directory")
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+
+			m, err := newMember(entry, cfg.UserAttribute)
+			if err != nil {
+				report.conflict(g.DN, g.AuthorityID, dn, err.Error())
+				continue
+			}
+			if existing, ok := members[m.username]; ok {
+				m = existing
+			} else {
+				members[m.username] = m
+			}
+
+			if role, ok := m.roles[g.AuthorityID]; ok && role != g.Role {
+				report.conflict(g.DN, g.AuthorityID, m.username, "the user is in groups with different roles for the account, so the highest role applies")
+				if role > g.Role {
+					continue
+				}
+			}
+			m.roles[g.AuthorityID] = g.Role
+			m.accounts[g.AuthorityID] = account
+		}
+	}
+	return members, synced, nil
+}
+
+// newMember reads the user details from the entry of the directory
+func newMember(entry map[string][]string, userAttribute string) (*member, error) {
+	username := strings.ToLower(first(entry, strings.ToLower(userAttribute)))
+	if len(username) == 0 {
+		return nil, fmt.Errorf("the member has no %s attribute", userAttribute)
+	}
+
+	m := &member{
+		username: username,
+		name:     first(entry, "displayname"),
+		email:    strings.ToLower(first(entry, "mail")),
+		roles:    map[string]int{},
+		accounts: map[string]datastore.Account{},
+	}
+	if len(m.name) == 0 {
+		m.name = first(entry, "cn")
+	}
+	if len(m.name) == 0 {
+		m.name = username
+	}
+	return m, nil
+}
+
+// newUser creates the user of the vault for a member, with the highest role of its groups
+func newUser(m *member) datastore.User {
+	user := datastore.User{
+		Username:     m.username,
+		Name:         m.name,
+		Email:        m.email,
+		Accounts:     []datastore.Account{},
+		AccountRoles: map[string]int{},
+	}
+	for authorityID, role := range m.roles {
+		if role > user.Role {
+			user.Role = role
+		}
+		user.Accounts = append(user.Accounts, m.accounts[authorityID])
+		user.AccountRoles[authorityID] = role
+	}
+	return user
+}
+
+// mergeUser applies the details and the account roles of the member to an existing user. The role of
+// the user is raised when a group needs it, keeping the role of the user's other accounts as it was
+func mergeUser(existing datastore.User, m *member) (datastore.User, bool) {
+	user := existing
+	user.Accounts = append([]datastore.Account{}, existing.Accounts...)
+	user.AccountRoles = map[string]int{}
+	for authorityID, role := range existing.AccountRoles {
+		user.AccountRoles[authorityID] = role
+	}
+	changed := false
+
+	if user.Name != m.name || (len(m.email) > 0 && user.Email != m.email) {
+		user.Name = m.name
+		if len(m.email) > 0 {
+			user.Email = m.email
+		}
+		changed = true
+	}
+
+	for _, role := range m.roles {
+		if role <= user.Role {
+			continue
+		}
+		for _, a := range user.Accounts {
+			if _, ok := user.AccountRoles[a.AuthorityID]; !ok {
+				user.AccountRoles[a.AuthorityID] = accountRole(existing.Role)
+			}
+		}
+		user.Role = role
+		changed = true
+	}
+
+	for authorityID, role := range m.roles {
+		if !linked(user, authorityID) {
+			user.Accounts = append(user.Accounts, m.accounts[authorityID])
+			changed = true
+		}
+		if r, ok := user.AccountRoles[authorityID]; !ok || r != role {
+			user.AccountRoles[authorityID] = role
+			changed = true
+		}
+	}
+	return user, changed
+}
+
+// accountRole is the per-account role that keeps the access of a user role
+func accountRole(role int) int {
+	switch {
+	case role >= datastore.Admin:
+		return datastore.Admin
+	case role >= datastore.SyncUser:
+		return datastore.SyncUser
+	default:
+		return datastore.Standard
+	}
+}
+
+func linked(user datastore.User, authorityID string) bool {
+	for _, a := range user.Accounts {
+		if a.AuthorityID == authorityID {
+			return true
+		}
+	}
+	return false
+}
+
+func (report *Report) conflict(group, account, username, message string) {
+	report.Conflicts = append(report.Conflicts, Conflict{Group: group, Account: account, Username: username, Message: message})
+}
+
+func first(entry map[string][]string, attribute string) string {
+	if values := entry[attribute]; len(values) > 0 {
+		return strings.TrimSpace(values[0])
+	}
+	return ""
+}
+
+func contains(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
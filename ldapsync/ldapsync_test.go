@@ -0,0 +1,325 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package ldapsync
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// memoryDirectory holds the entries of the directory in memory
+type memoryDirectory struct {
+	entries map[string]map[string][]string
+	err     error
+}
+
+func (d *memoryDirectory) Entry(dn string, attributes ...string) (map[string][]string, error) {
+	if d.err != nil {
+		return nil, d.err
+	}
+	entry, ok := d.entries[dn]
+	if !ok {
+		return nil, ErrNoSuchEntry
+	}
+	return entry, nil
+}
+
+func (d *memoryDirectory) UserExists(baseDN, userAttribute, username string) (bool, error) {
+	if d.err != nil {
+		return false, d.err
+	}
+	for _, entry := range d.entries {
+		for _, v := range entry[userAttribute] {
+			if strings.EqualFold(v, username) {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+func (d *memoryDirectory) Close() error {
+	return nil
+}
+
+// recordingDB records the users that are created, updated and deactivated
+type recordingDB struct {
+	datastore.MockDB
+	users    []datastore.User
+	created  []datastore.User
+	updated  []datastore.User
+	disabled []int
+}
+
+func (db *recordingDB) ListAccountUsers(ctx context.Context, authorityID string) ([]datastore.User, error) {
+	if db.users != nil {
+		return db.users, nil
+	}
+	return db.MockDB.ListAccountUsers(ctx, authorityID)
+}
+
+func (db *recordingDB) SetUserActive(ctx context.Context, userID int, active bool) error {
+	if !active {
+		db.disabled = append(db.disabled, userID)
+	}
+	return nil
+}
+
+func (db *recordingDB) CreateUser(ctx context.Context, user datastore.User) (int, error) {
+	db.created = append(db.created, user)
+	return 740, nil
+}
+
+func (db *recordingDB) UpdateUser(ctx context.Context, user datastore.User) error {
+	db.updated = append(db.updated, user)
+	return nil
+}
+
+func testDirectory() *memoryDirectory {
+	return &memoryDirectory{entries: map[string]map[string][]string{
+		"cn=admins,dc=example": {"member": {"uid=user1,dc=example", "uid=jane,dc=example"}},
+		"cn=users,dc=example":  {"member": {"uid=jane,dc=example", "uid=nobody,dc=example", "cn=noname,dc=example"}},
+		"uid=user1,dc=example": {"uid": {"user1"}, "cn": {"Rigoberto Picaporte"}, "mail": {"rigoberto.picaporte@ubuntu.com"}},
+		"uid=jane,dc=example":  {"uid": {"Jane"}, "cn": {"Jane Smith"}, "displayname": {"Jane A. Smith"}, "mail": {"Jane@example.com"}},
+		"cn=noname,dc=example": {"cn": {"No Username"}},
+	}}
+}
+
+func testConfig() Config {
+	return Config{
+		URL:             "ldap://ldap.example.com",
+		BaseDN:          "dc=example",
+		UserAttribute:   "uid",
+		MemberAttribute: "member",
+		Groups: []Group{
+			{DN: "cn=admins,dc=example", AuthorityID: "system", Role: datastore.Admin},
+			{DN: "cn=users,dc=example", AuthorityID: "system", Role: datastore.Standard},
+			{DN: "cn=missing,dc=example", AuthorityID: "vendor", Role: datastore.Standard},
+			{DN: "cn=users,dc=example", AuthorityID: "unknown", Role: datastore.Standard},
+		},
+	}
+}
+
+func TestNewConfig(t *testing.T) {
+	groups := []config.LDAPGroup{{DN: "cn=admins,dc=example", Account: "system", Role: "admin"}}
+	base := "dc=example"
+
+	tests := []struct {
+		settings config.Settings
+		enabled  bool
+		fails    bool
+	}{
+		{config.Settings{}, false, false},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPGroups: groups}, true, false},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPStartTLS: true, LDAPBaseDN: base, LDAPGroups: groups}, true, false},
+		{config.Settings{LDAPURL: "ldaps://ldap.example.com", LDAPBaseDN: base, LDAPSyncInterval: "10m", LDAPGroups: groups}, true, false},
+		{config.Settings{LDAPURL: "ldaps://ldap.example.com", LDAPStartTLS: true, LDAPBaseDN: base, LDAPGroups: groups}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPGroups: groups}, false, true},
+		{config.Settings{LDAPURL: "http://ldap.example.com", LDAPBaseDN: base, LDAPGroups: groups}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPSyncInterval: "invalid", LDAPGroups: groups}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPSyncInterval: "-1h", LDAPGroups: groups}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPGroups: []config.LDAPGroup{{DN: "cn=admins,dc=example", Account: "system", Role: "superuser"}}}, false, true},
+		{config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPGroups: []config.LDAPGroup{{DN: "cn=admins,dc=example", Role: "admin"}}}, false, true},
+	}
+
+	for _, tt := range tests {
+		cfg, err := NewConfig(tt.settings)
+		if (err != nil) != tt.fails {
+			t.Errorf("NewConfig(%v): expected failure %v, got %v", tt.settings.LDAPURL, tt.fails, err)
+			continue
+		}
+		if cfg.Enabled() != tt.enabled {
+			t.Errorf("NewConfig(%v): expected enabled %v, got %v", tt.settings.LDAPURL, tt.enabled, cfg.Enabled())
+		}
+	}
+
+	cfg, _ := NewConfig(config.Settings{LDAPURL: "ldap://ldap.example.com", LDAPBaseDN: base, LDAPGroups: groups})
+	if cfg.UserAttribute != "uid" || cfg.MemberAttribute != "member" || cfg.Interval != defaultInterval {
+		t.Errorf("Expected the default attributes and interval, got: %v", cfg)
+	}
+	if !reflect.DeepEqual(cfg.Groups, []Group{{DN: "cn=admins,dc=example", AuthorityID: "system", Role: datastore.Admin}}) {
+		t.Errorf("Unexpected groups: %v", cfg.Groups)
+	}
+}
+
+func TestSync(t *testing.T) {
+	db := &recordingDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	report, err := Sync(context.Background(), db, testDirectory(), testConfig(), false)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+
+	if !reflect.DeepEqual(report.Created, []string{"jane"}) || !reflect.DeepEqual(report.Updated, []string{"user1"}) {
+		t.Errorf("Unexpected changes: %v", report)
+	}
+
+	// The new user has the highest role of the groups
+	if len(db.created) != 1 {
+		t.Fatalf("Expected 1 created user, got %d", len(db.created))
+	}
+	jane := db.created[0]
+	if jane.Name != "Jane A. Smith" || jane.Email != "jane@example.com" || jane.Role != datastore.Admin {
+		t.Errorf("Unexpected created user: %v", jane)
+	}
+	if !reflect.DeepEqual(jane.AccountRoles, map[string]int{"system": datastore.Admin}) || len(jane.Accounts) != 1 || jane.Accounts[0].ID != 1 {
+		t.Errorf("Unexpected accounts of the created user: %v %v", jane.Accounts, jane.AccountRoles)
+	}
+
+	// The existing user is raised to admin, keeping the standard role in its other account
+	if len(db.updated) != 1 {
+		t.Fatalf("Expected 1 updated user, got %d", len(db.updated))
+	}
+	user1 := db.updated[0]
+	if user1.Role != datastore.Admin || len(user1.Accounts) != 2 {
+		t.Errorf("Unexpected updated user: %v", user1)
+	}
+	if !reflect.DeepEqual(user1.AccountRoles, map[string]int{"authority1": datastore.Standard, "system": datastore.Admin}) {
+		t.Errorf("Unexpected account roles of the updated user: %v", user1.AccountRoles)
+	}
+
+	expected := []Conflict{
+		{Group: "cn=users,dc=example", Account: "system", Username: "jane", Message: "the user is in groups with different roles for the account, so the highest role applies"},
+		{Group: "cn=users,dc=example", Account: "system", Username: "uid=nobody,dc=example", Message: "the member is not in the directory"},
+		{Group: "cn=users,dc=example", Account: "system", Username: "cn=noname,dc=example", Message: "the member has no uid attribute"},
+		{Group: "cn=missing,dc=example", Account: "vendor", Username: "", Message: "the group is not in the directory"},
+		{Group: "cn=users,dc=example", Account: "unknown", Username: "", Message: "the account is not in the vault"},
+	}
+	if len(report.Conflicts) < len(expected) || !reflect.DeepEqual(report.Conflicts[:len(expected)], expected) {
+		t.Fatalf("Unexpected conflicts:\n%v\nexpected:\n%v", report.Conflicts, expected)
+	}
+
+	// The other users of the account are not in the directory any more, so they are deactivated,
+	// except the superusers that are kept and reported
+	for _, c := range report.Conflicts[len(expected):] {
+		if c.Username == "user1" || c.Username == "jane" || c.Message != "the user is linked to the account but is not in its groups, so the link is kept" {
+			t.Errorf("Unexpected conflict: %v", c)
+		}
+	}
+	if !contains(report.Disabled, "user2") || contains(report.Disabled, "user1") || contains(report.Disabled, "jane") || len(db.disabled) != len(report.Disabled) {
+		t.Errorf("Unexpected disabled users: %v %v", report.Disabled, db.disabled)
+	}
+}
+
+func TestSyncRemovedUsers(t *testing.T) {
+	db := &recordingDB{users: []datastore.User{
+		{ID: 11, Username: "inactive", Role: datastore.Standard},
+		{ID: 12, Username: "root", Role: datastore.Superuser, Active: true},
+		{ID: 13, Username: "moved", Role: datastore.Standard, Active: true},
+		{ID: 14, Username: "removed", Role: datastore.Admin, Active: true},
+	}}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	dir := &memoryDirectory{entries: map[string]map[string][]string{
+		"cn=users,dc=example":  {"member": {}},
+		"uid=moved,dc=example": {"uid": {"moved"}},
+	}}
+	cfg := Config{BaseDN: "dc=example", UserAttribute: "uid", MemberAttribute: "member", Groups: []Group{
+		{DN: "cn=users,dc=example", AuthorityID: "system", Role: datastore.Standard},
+	}}
+
+	// Only the active user that is not in the directory is deactivated; the superuser is kept
+	report, err := Sync(context.Background(), db, dir, cfg, true)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if !reflect.DeepEqual(report.Disabled, []string{"removed"}) || len(db.disabled) != 0 {
+		t.Errorf("Unexpected dry-run disabled users: %v %v", report.Disabled, db.disabled)
+	}
+	if len(report.Conflicts) != 3 {
+		t.Errorf("Expected the kept users to be reported, got %v", report.Conflicts)
+	}
+
+	report, err = Sync(context.Background(), db, dir, cfg, false)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if !reflect.DeepEqual(report.Disabled, []string{"removed"}) || !reflect.DeepEqual(db.disabled, []int{14}) {
+		t.Errorf("Unexpected disabled users: %v %v", report.Disabled, db.disabled)
+	}
+}
+
+func TestSyncDryRun(t *testing.T) {
+	db := &recordingDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	report, err := Sync(context.Background(), db, testDirectory(), testConfig(), true)
+	if err != nil {
+		t.Fatalf("Error syncing: %v", err)
+	}
+	if !report.DryRun || len(report.Created) != 1 || len(report.Updated) != 1 {
+		t.Errorf("Unexpected dry-run report: %v", report)
+	}
+	if len(db.created) != 0 || len(db.updated) != 0 {
+		t.Errorf("Expected no changes in the dry-run, got %v %v", db.created, db.updated)
+	}
+}
+
+func TestSyncUnchanged(t *testing.T) {
+	db := &recordingDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	dir := &memoryDirectory{entries: map[string]map[string][]string{
+		"cn=users,dc=example":  {"member": {"uid=user1,dc=example"}},
+		"uid=user1,dc=example": {"uid": {"user1"}, "cn": {"Rigoberto Picaporte"}, "mail": {"rigoberto.picaporte@ubuntu.com"}},
+	}}
+	cfg := Config{UserAttribute: "uid", MemberAttribute: "member", Groups: []Group{
+		{DN: "cn=users,dc=example", AuthorityID: "system", Role: datastore.Standard},
+	}}
+
+	// The mock user is not linked to the account, so the first sync links it
+	report, err := Sync(context.Background(), db, dir, cfg, false)
+	if err != nil || len(report.Updated) != 1 {
+		t.Fatalf("Expected the user to be updated, got %v: %v", report, err)
+	}
+
+	user, changed := mergeUser(db.updated[0], &member{
+		username: "user1",
+		name:     "Rigoberto Picaporte",
+		email:    "rigoberto.picaporte@ubuntu.com",
+		roles:    map[string]int{"system": datastore.Standard},
+		accounts: map[string]datastore.Account{"system": {ID: 1, AuthorityID: "system"}},
+	})
+	if changed {
+		t.Errorf("Expected the synced user to be unchanged, got %v", user)
+	}
+}
+
+func TestSyncDirectoryError(t *testing.T) {
+	db := &recordingDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	_, err := Sync(context.Background(), db, &memoryDirectory{err: errors.New("MOCK error")}, testConfig(), false)
+	if err == nil {
+		t.Error("Expected an error from the directory")
+	}
+	if len(db.created) != 0 || len(db.updated) != 0 {
+		t.Errorf("Expected no changes, got %v %v", db.created, db.updated)
+	}
+}
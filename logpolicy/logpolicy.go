@@ -0,0 +1,135 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logpolicy
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// SettingLogPolicy is the code of the setting that stores the logging policy, so it is
+// shared by the admin and signing services
+const SettingLogPolicy = "log-policy"
+
+// SettingLogLevel is the code of the setting that stores the logging level, changed at runtime
+const SettingLogLevel = "log-level"
+
+// defaultLevel is the logging level when it has not been changed at runtime
+const defaultLevel = log.INFO
+
+// refreshInterval is the interval of the reload of the stored logging policy
+const refreshInterval = time.Minute
+
+// Default returns the logging policy from the config settings
+func Default(settings config.Settings) log.Policy {
+	return log.Policy{MaskSerials: settings.LogMaskSerials, MaskKeyIDs: settings.LogMaskKeyIDs, Routes: []log.RouteDebug{}}
+}
+
+// Init applies the logging policy now, before the service starts
+func Init(ctx context.Context, settings config.Settings) {
+	log.SetPolicy(Default(settings))
+	Refresh(ctx, datastore.Environ.DB, settings)
+}
+
+// Job reloads the logging policy at the interval, so the changes from the admin API
+// are picked up by all the services
+func Job(settings config.Settings) scheduler.Job {
+	return scheduler.Job{Name: "logging-policy", Interval: refreshInterval, Local: true, Run: func(ctx context.Context) error {
+		return Refresh(ctx, datastore.Environ.DB, settings)
+	}}
+}
+
+// Refresh applies the stored logging policy and logging level
+func Refresh(ctx context.Context, db datastore.Datastore, settings config.Settings) error {
+	p, err := Load(ctx, db, settings)
+	if err != nil {
+		log.Errorf("Error loading the logging policy: %v", err)
+		return err
+	}
+	log.SetPolicy(p)
+
+	level, err := LoadLevel(ctx, db)
+	if err != nil {
+		log.Errorf("Error loading the logging level: %v", err)
+		return err
+	}
+	log.SetLevel(level)
+	return nil
+}
+
+// Load returns the stored logging policy, or the policy from the config settings when it is not stored
+func Load(ctx context.Context, db datastore.Datastore, settings config.Settings) (log.Policy, error) {
+	setting, err := db.GetSetting(ctx, SettingLogPolicy)
+	if err == sql.ErrNoRows {
+		return Default(settings), nil
+	}
+	if err != nil {
+		return log.Policy{}, err
+	}
+
+	p := log.Policy{}
+	if err = json.Unmarshal([]byte(setting.Data), &p); err != nil {
+		return log.Policy{}, fmt.Errorf("invalid logging policy: %v", err)
+	}
+	return p, nil
+}
+
+// Store stores the logging policy and applies it to this service
+func Store(ctx context.Context, db datastore.Datastore, p log.Policy) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+	if err = db.PutSetting(ctx, datastore.Setting{Code: SettingLogPolicy, Data: string(data)}); err != nil {
+		return fmt.Errorf("error storing the logging policy: %v", err)
+	}
+	log.SetPolicy(p)
+	return nil
+}
+
+// LoadLevel returns the stored logging level, or the default level when it is not stored
+func LoadLevel(ctx context.Context, db datastore.Datastore) (log.Level, error) {
+	setting, err := db.GetSetting(ctx, SettingLogLevel)
+	if err == sql.ErrNoRows {
+		return defaultLevel, nil
+	}
+	if err != nil {
+		return defaultLevel, err
+	}
+	return log.ParseLevel(setting.Data)
+}
+
+// StoreLevel stores the logging level and applies it to this service
+func StoreLevel(ctx context.Context, db datastore.Datastore, level log.Level) error {
+	if err := db.PutSetting(ctx, datastore.Setting{Code: SettingLogLevel, Data: level.String()}); err != nil {
+		return fmt.Errorf("error storing the logging level: %v", err)
+	}
+	log.SetLevel(level)
+	return nil
+}
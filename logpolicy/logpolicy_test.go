@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logpolicy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+func TestLoadStore(t *testing.T) {
+	defer log.SetPolicy(log.Policy{})
+
+	db := &datastore.MockDB{}
+	settings := config.Settings{LogMaskSerials: true}
+
+	// The config settings apply until a policy is stored
+	p, err := Load(context.Background(), db, settings)
+	if err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if !p.MaskSerials || p.MaskKeyIDs {
+		t.Errorf("Load: expected the default policy, got %v", p)
+	}
+
+	until := time.Now().Add(time.Hour).UTC().Truncate(time.Second)
+	stored := log.Policy{MaskKeyIDs: true, Routes: []log.RouteDebug{{Prefix: "/v1/serial", Until: until}}}
+	if err = Store(context.Background(), db, stored); err != nil {
+		t.Fatalf("Store: unexpected error: %v", err)
+	}
+	if !log.Verbose("/v1/serial") {
+		t.Error("Store: expected the policy to be applied")
+	}
+
+	p, err = Load(context.Background(), db, settings)
+	if err != nil {
+		t.Fatalf("Load: unexpected error: %v", err)
+	}
+	if p.MaskSerials || !p.MaskKeyIDs || len(p.Routes) != 1 || !p.Routes[0].Until.Equal(until) {
+		t.Errorf("Load: expected the stored policy, got %v", p)
+	}
+}
+
+func TestRefreshError(t *testing.T) {
+	if err := Refresh(context.Background(), &datastore.ErrorMockDB{}, config.Settings{}); err == nil {
+		t.Error("Refresh: expected an error")
+	}
+}
+
+func TestLoadStoreLevel(t *testing.T) {
+	defer log.SetLevel(log.INFO)
+
+	db := &datastore.MockDB{}
+
+	// The default level applies until a level is stored
+	level, err := LoadLevel(context.Background(), db)
+	if err != nil {
+		t.Fatalf("LoadLevel: unexpected error: %v", err)
+	}
+	if level != log.INFO {
+		t.Errorf("LoadLevel: expected the default level, got %v", level)
+	}
+
+	if err = StoreLevel(context.Background(), db, log.DEBUG); err != nil {
+		t.Fatalf("StoreLevel: unexpected error: %v", err)
+	}
+	if log.GetLevel() != log.DEBUG {
+		t.Error("StoreLevel: expected the level to be applied")
+	}
+
+	log.SetLevel(log.INFO)
+	if err = Refresh(context.Background(), db, config.Settings{}); err != nil {
+		t.Fatalf("Refresh: unexpected error: %v", err)
+	}
+	if log.GetLevel() != log.DEBUG {
+		t.Errorf("Refresh: expected the stored level, got %v", log.GetLevel())
+	}
+}
+
+func TestSyslog(t *testing.T) {
+	s, err := Syslog(config.Settings{})
+	if err != nil || s != nil {
+		t.Errorf("Syslog: expected no syslog, got %v: %v", s, err)
+	}
+
+	s, err = Syslog(config.Settings{LogSyslogURL: "tls://127.0.0.1:6514", LogSyslogFacility: "local3"})
+	if err != nil || s == nil {
+		t.Fatalf("Syslog: unexpected error: %v", err)
+	}
+	s.Close(time.Second)
+
+	if _, err = Syslog(config.Settings{LogSyslogURL: "tls://127.0.0.1:6514", LogSyslogCAFile: "/not/a/file"}); err == nil {
+		t.Error("Syslog: expected an error for a missing CA file")
+	}
+	if _, err = Syslog(config.Settings{LogSyslogURL: "syslog.example.com"}); err == nil {
+		t.Error("Syslog: expected an error for an invalid URL")
+	}
+}
@@ -0,0 +1,57 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logpolicy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/fips"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Syslog returns the syslog endpoint of the log entries from the config settings, or nil when
+// it is not configured. The TLS endpoint is verified with the CA file, when it is set
+func Syslog(settings config.Settings) (*log.Syslog, error) {
+	if len(settings.LogSyslogURL) == 0 {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{}
+	if len(settings.LogSyslogCAFile) > 0 {
+		pem, err := os.ReadFile(settings.LogSyslogCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading the syslog CA: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates in the syslog CA: %s", settings.LogSyslogCAFile)
+		}
+	}
+	if fips.Enabled(settings) {
+		tlsConfig = fips.TLSConfig(tlsConfig)
+	}
+
+	return log.NewSyslog(log.SyslogConfig{URL: settings.LogSyslogURL, Facility: settings.LogSyslogFacility, TLSConfig: tlsConfig})
+}
@@ -0,0 +1,113 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// sendMail sends the message with the SMTP server. It is a variable so it can be
+// overridden for testing
+var sendMail = smtp.SendMail
+
+// Config is the SMTP server from the config settings
+type Config struct {
+	Server   string // host:port of the SMTP server, empty when the emails are not configured
+	Username string // username of the SMTP authentication, empty to send without authentication
+	Password string
+	From     string // sender address of the emails
+}
+
+// NewConfig returns the SMTP server from the config settings
+func NewConfig(settings config.Settings) (Config, error) {
+	cfg := Config{
+		Server:   settings.SMTPServer,
+		Username: settings.SMTPUsername,
+		Password: settings.SMTPPassword,
+		From:     settings.MailFrom,
+	}
+	if len(cfg.Server) == 0 {
+		return cfg, nil
+	}
+
+	if _, _, err := net.SplitHostPort(cfg.Server); err != nil {
+		return Config{}, fmt.Errorf("the SMTP server must be host:port: %v", err)
+	}
+	if _, err := mail.ParseAddress(cfg.From); err != nil {
+		return Config{}, fmt.Errorf("invalid sender address of the emails: %v", err)
+	}
+	return cfg, nil
+}
+
+// Enabled checks whether the SMTP server is configured
+func Enabled(cfg Config) bool {
+	return len(cfg.Server) > 0
+}
+
+// Send sends a plain text email. The credentials are only sent when the server
+// supports TLS, which the smtp package enforces
+func Send(cfg Config, to, subject, body string) error {
+	if !Enabled(cfg) {
+		return errors.New("the SMTP server is not configured")
+	}
+
+	from, err := mail.ParseAddress(cfg.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address of the email: %v", err)
+	}
+	recipient, err := mail.ParseAddress(to)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address of the email: %v", err)
+	}
+	if strings.ContainsAny(subject, "\r\n") {
+		return errors.New("invalid subject of the email")
+	}
+
+	var auth smtp.Auth
+	if len(cfg.Username) > 0 {
+		host, _, _ := net.SplitHostPort(cfg.Server)
+		auth = smtp.PlainAuth("", cfg.Username, cfg.Password, host)
+	}
+
+	return sendMail(cfg.Server, auth, from.Address, []string{recipient.Address}, message(from, recipient, subject, body))
+}
+
+// message formats the headers and the body of the email
+func message(from, to *mail.Address, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from.String())
+	fmt.Fprintf(&b, "To: %s\r\n", to.String())
+	fmt.Fprintf(&b, "Subject: %s\r\n", subject)
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	return b.Bytes()
+}
@@ -0,0 +1,86 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package mailer
+
+import (
+	"net/smtp"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func TestNewConfig(t *testing.T) {
+	tests := []struct {
+		settings config.Settings
+		enabled  bool
+		fails    bool
+	}{
+		{config.Settings{}, false, false},
+		{config.Settings{SMTPServer: "smtp.example.com:587", MailFrom: "vault@example.com"}, true, false},
+		{config.Settings{SMTPServer: "smtp.example.com", MailFrom: "vault@example.com"}, false, true},
+		{config.Settings{SMTPServer: "smtp.example.com:587", MailFrom: "invalid"}, false, true},
+	}
+
+	for _, tt := range tests {
+		cfg, err := NewConfig(tt.settings)
+		if (err != nil) != tt.fails {
+			t.Errorf("Config %v: expected failure %v, got %v", tt.settings, tt.fails, err)
+		}
+		if Enabled(cfg) != tt.enabled {
+			t.Errorf("Config %v: expected enabled %v", tt.settings, tt.enabled)
+		}
+	}
+}
+
+func TestSend(t *testing.T) {
+	var server, from string
+	var to []string
+	var msg []byte
+	sendMail = func(addr string, a smtp.Auth, f string, t []string, m []byte) error {
+		server, from, to, msg = addr, f, t, m
+		return nil
+	}
+	defer func() { sendMail = smtp.SendMail }()
+
+	cfg := Config{Server: "smtp.example.com:587", Username: "vault", Password: "secret", From: "Serial Vault <vault@example.com>"}
+	if err := Send(cfg, "Jane <jane@example.com>", "Invitation", "Hello\nThere"); err != nil {
+		t.Fatalf("Error sending the email: %v", err)
+	}
+
+	if server != "smtp.example.com:587" || from != "vault@example.com" || len(to) != 1 || to[0] != "jane@example.com" {
+		t.Errorf("Unexpected envelope of the email: %s %s %v", server, from, to)
+	}
+	for _, expected := range []string{"From: \"Serial Vault\" <vault@example.com>\r\n", "To: \"Jane\" <jane@example.com>\r\n", "Subject: Invitation\r\n", "\r\n\r\nHello\r\nThere"} {
+		if !strings.Contains(string(msg), expected) {
+			t.Errorf("Expected '%s' in the email, got: %s", expected, msg)
+		}
+	}
+
+	if err := Send(cfg, "jane@example.com", "Invitation\r\nBcc: eve@example.com", "Hello"); err == nil {
+		t.Error("Expected an error with a subject with a new line")
+	}
+	if err := Send(cfg, "invalid", "Invitation", "Hello"); err == nil {
+		t.Error("Expected an error with an invalid recipient")
+	}
+	if err := Send(Config{}, "jane@example.com", "Invitation", "Hello"); err == nil {
+		t.Error("Expected an error without the SMTP server")
+	}
+}
@@ -21,5 +21,11 @@ package manage
 
 // AccountCommand is the main command for account management
 type AccountCommand struct {
-	Cache AccountCacheCommand `command:"cache" alias:"c" description:"Cache the account assertions from the store in the database"`
+	Apply      AccountApplyCommand      `command:"apply" description:"Apply the configuration of an account from a YAML file, through the admin API"`
+	Cache      AccountCacheCommand      `command:"cache" alias:"c" description:"Cache the account assertions from the store in the database"`
+	Create     AccountCreateCommand     `command:"create" description:"Create an account, through the admin API"`
+	Export     AccountExportCommand     `command:"export" description:"Export the configuration of an account as YAML, through the admin API"`
+	HashSerial AccountHashSerialCommand `command:"hash-serial" description:"Store the serial numbers of an account as salted hashes, including the existing signing logs"`
+	List       AccountListCommand       `command:"list" alias:"ls" alias:"l" description:"List the accounts, through the admin API"`
+	Refresh    AccountRefreshCommand    `command:"refresh" description:"Refresh the account and account-key assertions of all the accounts from the store, reporting the changes"`
 }
@@ -20,6 +20,9 @@
 package manage
 
 import (
+	"io/ioutil"
+	"path/filepath"
+
 	"github.com/CanonicalLtd/serial-vault/account"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"gopkg.in/check.v1"
@@ -40,10 +43,10 @@ func (s *AccountSuite) TestAccount(c *check.C) {
 	tests := []manTest{
 		{
 			Args:         []string{"serial-vault-admin", "account"},
-			ErrorMessage: "Please specify the cache command"},
+			ErrorMessage: "Please specify one command of: apply, cache, create, export, hash-serial, list or refresh"},
 		{
 			Args:         []string{"serial-vault-admin", "account", "invalid"},
-			ErrorMessage: "Unknown command `invalid'. You should use the cache command"},
+			ErrorMessage: "Unknown command `invalid'. Please specify one command of: apply, cache, create, export, hash-serial, list or refresh"},
 		{
 			Args:         []string{"serial-vault-admin", "account", "cache"},
 			ErrorMessage: ""},
@@ -53,3 +56,115 @@ func (s *AccountSuite) TestAccount(c *check.C) {
 		runTest(c, t.Args, t.ErrorMessage)
 	}
 }
+
+func (s *AccountSuite) TestAccountHashSerial(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "account", "hash-serial"},
+			ErrorMessage: "Hash-serial expects a single authority-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "hash-serial", "system"},
+			ErrorMessage: "Error hashing the serial numbers: the salt for hashing serial numbers is not configured"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+
+	datastore.Environ.Config.SerialHashSalt = "SomeSaltValue"
+
+	tests = []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "account", "hash-serial", "system"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "account", "hash-serial", "invalid"},
+			ErrorMessage: "Error finding the account 'invalid'"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	runTest(c, []string{"serial-vault-admin", "account", "hash-serial", "system"}, "Error finding the account 'system'")
+}
+
+func (s *AccountSuite) TestAccountRefresh(c *check.C) {
+	runTest(c, []string{"serial-vault-admin", "account", "refresh"}, "")
+
+	account.FetchAssertionFromStore = account.MockFetchAssertionFromStoreError
+	runTest(c, []string{"serial-vault-admin", "account", "refresh"}, "Error refreshing 5 account assertions")
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	runTest(c, []string{"serial-vault-admin", "account", "refresh"}, "Error refreshing the account assertions: error retrieving the accounts: Error getting the accounts")
+}
+
+func (s *AccountSuite) TestAccountCreateList(c *check.C) {
+	Manage = Command{}
+	server := mockAdminAPI()
+	defer server.Close()
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "account", "create", "--api-url", server.URL, "--api-user", "root", "--api-key", "ValidAPIKey"},
+			ErrorMessage: "Create account expects a single authority-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "create", "vendor", "--api-url", server.URL, "--api-user", "root", "--api-key", "ValidAPIKey"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "account", "create", "vendor", "--api-url", server.URL, "--api-user", "sv", "--api-key", "ValidAPIKey"},
+			ErrorMessage: "Error creating the account: error-auth"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "list", "--api-url", server.URL, "--api-user", "sync", "--api-key", "ValidAPIKey"},
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *AccountSuite) TestAccountExportApply(c *check.C) {
+	Manage = Command{}
+	server := mockAdminAPI()
+	defer server.Close()
+
+	path := filepath.Join(c.MkDir(), "system.yaml")
+	invalid := filepath.Join(c.MkDir(), "invalid.yaml")
+	err := ioutil.WriteFile(invalid, []byte("authority-id: system\nmodels:\n- name: yew\n  signing-key: unknown\n"), 0600)
+	c.Assert(err, check.IsNil)
+
+	api := []string{"--api-url", server.URL, "--api-user", "root", "--api-key", "ValidAPIKey"}
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "account", "export"},
+			ErrorMessage: "Export account expects a single authority-id argument"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "export", "unknown"},
+			ErrorMessage: "Error exporting the account: cannot find the account 'unknown'"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "export", "system", "-o", path},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "account", "apply"},
+			ErrorMessage: "Apply account expects a single configuration file argument"},
+		{
+			Args:         []string{"serial-vault-admin", "account", "apply", "--dry-run", path},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "account", "apply", path},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "account", "apply", invalid},
+			ErrorMessage: "Error applying the configuration: error-account-config: .*"},
+	}
+
+	for _, t := range tests {
+		runTest(c, append(t.Args, api...), t.ErrorMessage)
+	}
+
+	cfg, err := ioutil.ReadFile(path)
+	c.Assert(err, check.IsNil)
+	c.Assert(string(cfg), check.Matches, "(?s)authority-id: system\n.*")
+}
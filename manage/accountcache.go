@@ -20,6 +20,7 @@
 package manage
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/account"
@@ -38,12 +39,12 @@ func (cmd AccountCacheCommand) Execute(args []string) error {
 	// Cache the account/account-key assertions from the store in the database
 	// (reads through the accounts and refreshes the account assertions)
 	fmt.Println("Update account assertions from the Ubuntu store...")
-	account.CacheAccounts(datastore.Environ)
+	account.CacheAccounts(context.Background(), datastore.Environ)
 
 	// Cache the account/account-key assertions from the store in the database
 	// (This reads through the keypairs to get the accounts)
 	fmt.Println("\nUpdate account/account-key assertions from the Ubuntu store...")
-	account.CacheAccountAssertions(datastore.Environ)
+	account.CacheAccountAssertions(context.Background(), datastore.Environ)
 
 	return nil
 }
@@ -0,0 +1,139 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"gopkg.in/yaml.v2"
+)
+
+// AccountExportCommand exports the configuration of an account through the admin API
+type AccountExportCommand struct {
+	Output string `short:"o" long:"output" description:"The file to write the configuration to, instead of the standard output"`
+}
+
+// Execute the export of the configuration of an account
+func (cmd AccountExportCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Export account expects a single authority-id argument")
+	}
+
+	accountID, err := findAccountID(args[0])
+	if err != nil {
+		return fmt.Errorf("Error exporting the account: %v", err)
+	}
+
+	cfg, err := Manage.API.callYAML("GET", fmt.Sprintf("/api/accounts/%d/config", accountID), nil, nil)
+	if err != nil {
+		return fmt.Errorf("Error exporting the account: %v", err)
+	}
+
+	if len(cmd.Output) == 0 {
+		_, err = os.Stdout.Write(cfg)
+		return err
+	}
+	if err := ioutil.WriteFile(cmd.Output, cfg, 0600); err != nil {
+		return fmt.Errorf("Error writing the configuration: %v", err)
+	}
+	return nil
+}
+
+// AccountApplyCommand applies the configuration of an account through the admin API, from the
+// file of the argument. The account is the one of the authority-id of the configuration
+type AccountApplyCommand struct {
+	DryRun bool `short:"n" long:"dry-run" description:"Report the changes of the configuration, without applying them"`
+}
+
+// Execute the apply of the configuration of an account
+func (cmd AccountApplyCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Apply account expects a single configuration file argument")
+	}
+
+	data, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("Error reading the configuration: %v", err)
+	}
+	cfg := account.AccountConfig{}
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return fmt.Errorf("Error parsing the configuration: %v", err)
+	}
+
+	accountID, err := findAccountID(cfg.AuthorityID)
+	if err != nil {
+		return fmt.Errorf("Error applying the configuration: %v", err)
+	}
+
+	path := fmt.Sprintf("/api/accounts/%d/config", accountID)
+	if cmd.DryRun {
+		path += "?dryrun=true"
+	}
+
+	result := account.ConfigResponse{}
+	_, err = Manage.API.callYAML("PUT", path, data, &result)
+	printConfigChanges(result.Changes)
+	if err != nil {
+		return fmt.Errorf("Error applying the configuration: %v", err)
+	}
+
+	if cmd.DryRun {
+		fmt.Printf("Configuration of '%s' checked successfully, no change has been applied\n", cfg.AuthorityID)
+	} else {
+		fmt.Printf("Configuration of '%s' applied successfully\n", cfg.AuthorityID)
+	}
+	return nil
+}
+
+// findAccountID returns the ID of the account of the authority-id
+func findAccountID(authorityID string) (int, error) {
+	result := account.ListResponse{}
+	if err := Manage.API.call("GET", "/api/accounts", nil, &result); err != nil {
+		return 0, err
+	}
+
+	for _, a := range result.Accounts {
+		if a.AuthorityID == authorityID {
+			return a.ID, nil
+		}
+	}
+	return 0, fmt.Errorf("cannot find the account '%s'", authorityID)
+}
+
+func printConfigChanges(changes []account.ConfigChange) {
+	if len(changes) == 0 {
+		return
+	}
+
+	// Create a tabwriter to format the output
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 4, ' ', 0)
+
+	fmt.Fprintln(w, "Kind\tName\tAction\tError")
+	for _, c := range changes {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", c.Kind, c.Name, c.Action, c.Error)
+	}
+	w.Flush()
+}
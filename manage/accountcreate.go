@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// AccountCreateCommand creates an account through the admin API
+type AccountCreateCommand struct {
+	ResellerAPI bool `short:"r" long:"reseller-api" description:"Enable the reseller API for the sub-store models of the account"`
+}
+
+// Execute the creation of an account
+func (cmd AccountCreateCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Create account expects a single authority-id argument")
+	}
+
+	acct := datastore.Account{AuthorityID: args[0], ResellerAPI: cmd.ResellerAPI}
+	if err := Manage.API.call("POST", "/api/accounts", acct, nil); err != nil {
+		return fmt.Errorf("Error creating the account: %v", err)
+	}
+
+	fmt.Printf("Account '%s' created successfully\n", acct.AuthorityID)
+	return nil
+}
@@ -0,0 +1,66 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// AccountHashSerialCommand enables the hashing of serial numbers for an account,
+// and migrates the existing signing logs of the account
+type AccountHashSerialCommand struct{}
+
+// Execute the hashing of the serial numbers of an account
+func (cmd AccountHashSerialCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Hash-serial expects a single authority-id argument")
+	}
+
+	openDatabase()
+
+	if _, err := datastore.Environ.Hasher(); err != nil {
+		return fmt.Errorf("Error hashing the serial numbers: %v", err)
+	}
+
+	account, err := datastore.Environ.DB.GetAccount(context.Background(), args[0])
+	if err != nil {
+		return fmt.Errorf("Error finding the account '%s'", args[0])
+	}
+
+	// Enable the hashing first, so the devices signed during the migration are hashed
+	if !account.HashSerial {
+		account.HashSerial = true
+		if err = datastore.Environ.DB.UpdateAccount(context.Background(), account, datastore.User{}); err != nil {
+			return fmt.Errorf("Error updating the account: %v", err)
+		}
+	}
+
+	count, err := datastore.Environ.DB.HashSigningLogSerialNumbers(context.Background(), account.AuthorityID)
+	if err != nil {
+		return fmt.Errorf("Error hashing the serial numbers: %v", err)
+	}
+
+	fmt.Printf("Hashed the serial numbers of %d signing logs for '%s'\n", count, account.AuthorityID)
+	return nil
+}
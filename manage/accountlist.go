@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CanonicalLtd/serial-vault/service/account"
+)
+
+// AccountListCommand lists the accounts of the user through the admin API
+type AccountListCommand struct{}
+
+// Execute the list of accounts
+func (cmd AccountListCommand) Execute(args []string) error {
+	result := account.ListResponse{}
+	if err := Manage.API.call("GET", "/api/accounts", nil, &result); err != nil {
+		return fmt.Errorf("Error listing the accounts: %v", err)
+	}
+
+	// Create a tabwriter to format the output
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 4, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tAuthority ID\tReseller API")
+	for _, a := range result.Accounts {
+		fmt.Fprintf(w, "%d\t%s\t%t\n", a.ID, a.AuthorityID, a.ResellerAPI)
+	}
+	w.Flush()
+
+	return nil
+}
@@ -0,0 +1,57 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/account"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+// AccountRefreshCommand refreshes the account and account-key assertions of all the accounts
+// from the store, reporting the assertions that changed or failed.
+// This command would normally be run as a cron
+type AccountRefreshCommand struct{}
+
+// Execute the refresh of the account assertions
+func (cmd AccountRefreshCommand) Execute(args []string) error {
+	openDatabase()
+
+	report, err := account.RefreshAccounts(context.Background(), datastore.Environ)
+	if err != nil {
+		return fmt.Errorf("Error refreshing the account assertions: %v", err)
+	}
+
+	for _, r := range report.Results {
+		if r.Status == account.RefreshUnchanged {
+			continue
+		}
+		fmt.Printf("%-8s %-11s %s %s %s\n", r.Status, r.Type, r.AuthorityID, r.KeyID, r.Error)
+	}
+	fmt.Printf("Refreshed %d assertions: %d changed, %d failed\n", len(report.Results), report.Changed, report.Failed)
+
+	if report.Failed > 0 {
+		return fmt.Errorf("Error refreshing %d account assertions", report.Failed)
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// apiTimeout is the timeout of a call to the admin API
+const apiTimeout = 30 * time.Second
+
+// APIOptions are the options to call the admin API of the serial vault. The commands that
+// use them run against a remote service, without access to its database
+type APIOptions struct {
+	URL      string `long:"api-url" env:"SERIAL_VAULT_API_URL" description:"The base URL of the serial vault admin service e.g. https://serial-vault-admin.example.com"`
+	Username string `long:"api-user" env:"SERIAL_VAULT_API_USER" description:"The username for the admin API"`
+	APIKey   string `long:"api-key" env:"SERIAL_VAULT_API_KEY" description:"The API key of the user for the admin API"`
+	Token    string `long:"api-token" env:"SERIAL_VAULT_API_TOKEN" description:"The service account or personal access token for the admin API, instead of the username and API key"`
+}
+
+var apiClient = &http.Client{Timeout: apiTimeout}
+
+// call sends a request to the admin API and decodes the response into the result. The
+// failures of the API are returned as errors
+func (opts APIOptions) call(method, path string, body, result interface{}) error {
+	var data []byte
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		data = b
+	}
+
+	resp, raw, err := opts.send(method, path, "application/json", data)
+	if err != nil {
+		return err
+	}
+	return decodeResponse(resp, raw, result)
+}
+
+// callYAML sends a YAML document to the admin API. A YAML response is returned as it is,
+// otherwise the response is decoded into the result, which holds the details of a failure
+func (opts APIOptions) callYAML(method, path string, body []byte, result interface{}) ([]byte, error) {
+	resp, raw, err := opts.send(method, path, account.ConfigContentType, body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") == account.ConfigContentType {
+		return raw, nil
+	}
+
+	// The failures are decoded too, for their details
+	if result != nil {
+		json.Unmarshal(raw, result)
+	}
+	return nil, decodeResponse(resp, raw, nil)
+}
+
+// send sends a body of the content type to the admin API, and returns the raw response
+func (opts APIOptions) send(method, path, contentType string, body []byte) (*http.Response, []byte, error) {
+	if len(opts.URL) == 0 {
+		return nil, nil, errors.New("The URL of the admin API must be set with --api-url or SERIAL_VAULT_API_URL")
+	}
+
+	var data io.Reader
+	if body != nil {
+		data = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, strings.TrimSuffix(opts.URL, "/")+path, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if len(opts.Token) > 0 {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	} else {
+		req.Header.Set("user", opts.Username)
+		req.Header.Set("api-key", opts.APIKey)
+	}
+
+	resp, err := apiClient.Do(req)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error calling the admin API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading the response of the admin API: %v", err)
+	}
+	return resp, raw, nil
+}
+
+// decodeResponse decodes a response of the admin API into the result, returning its failure
+// as an error
+func decodeResponse(resp *http.Response, raw []byte, result interface{}) error {
+	// All the responses of the admin API hold the standard fields
+	std := response.StandardResponse{}
+	if err := json.Unmarshal(raw, &std); err != nil {
+		return fmt.Errorf("Error parsing the response of the admin API (%s)", resp.Status)
+	}
+	if !std.Success {
+		if len(std.ErrorMessage) == 0 {
+			return errors.New(std.ErrorCode)
+		}
+		return fmt.Errorf("%s: %s", std.ErrorCode, std.ErrorMessage)
+	}
+
+	if result == nil {
+		return nil
+	}
+	return json.Unmarshal(raw, result)
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"gopkg.in/check.v1"
+)
+
+type APISuite struct {
+	server *httptest.Server
+}
+
+var _ = check.Suite(&APISuite{})
+
+func (s *APISuite) SetUpTest(c *check.C) {
+	Manage = Command{}
+	s.server = mockAdminAPI()
+}
+
+func (s *APISuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+// mockAdminAPI starts the admin service, with the database and the keystore mocked
+func mockAdminAPI() *httptest.Server {
+	settings := config.Settings{KeyStoreType: "memory", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
+	datastore.Environ.KeypairDB, _ = datastore.GetMemoryKeyStore(settings)
+
+	return httptest.NewServer(service.AdminRouter())
+}
+
+func (s *APISuite) TestCall(c *check.C) {
+	result := struct {
+		Success bool `json:"success"`
+	}{}
+
+	err := APIOptions{}.call("GET", "/api/accounts", nil, &result)
+	c.Assert(err, check.ErrorMatches, "The URL of the admin API must be set with --api-url or SERIAL_VAULT_API_URL")
+
+	err = APIOptions{URL: s.server.URL + "/", Username: "root", APIKey: "ValidAPIKey"}.call("GET", "/api/accounts", nil, &result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+
+	err = APIOptions{URL: s.server.URL, Username: "invalid", APIKey: "ValidAPIKey"}.call("GET", "/api/accounts", nil, nil)
+	c.Assert(err, check.ErrorMatches, "error-auth: Cannot find the user")
+
+	err = APIOptions{URL: s.server.URL, Token: "InvalidToken"}.call("GET", "/api/accounts", nil, nil)
+	c.Assert(err, check.ErrorMatches, "error-auth: .*")
+
+	err = APIOptions{URL: s.server.URL, Username: "root", APIKey: "ValidAPIKey"}.call("GET", "/invalid", nil, nil)
+	c.Assert(err, check.ErrorMatches, "Error parsing the response of the admin API \\(404 Not Found\\)")
+}
+
+func (s *APISuite) TestCallHeaders(c *check.C) {
+	var headers http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers = r.Header
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	err := APIOptions{URL: server.URL, Username: "root", APIKey: "ValidAPIKey"}.call("GET", "/api/accounts", nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(headers.Get("user"), check.Equals, "root")
+	c.Assert(headers.Get("api-key"), check.Equals, "ValidAPIKey")
+	c.Assert(headers.Get("Authorization"), check.Equals, "")
+
+	err = APIOptions{URL: server.URL, Username: "root", Token: "SomeToken"}.call("GET", "/api/accounts", nil, nil)
+	c.Assert(err, check.IsNil)
+	c.Assert(headers.Get("Authorization"), check.Equals, "Bearer SomeToken")
+	c.Assert(headers.Get("api-key"), check.Equals, "")
+}
@@ -53,14 +53,14 @@ func (cmd ClientCommand) Execute(args []string) error {
 	// Create a serial-request assertion
 	serialRequest, err := cmd.generateSerialRequestAssertion()
 	if err != nil {
-		log.Println(err.Error())
+		log.Error(err.Error())
 		os.Exit(1)
 	}
 
 	// Send it to the serial vault via HTTPS
 	serialAssertion, err := cmd.getSerial(serialRequest)
 	if err != nil {
-		log.Println(err.Error())
+		log.Error(err.Error())
 		os.Exit(1)
 	}
 
@@ -135,7 +135,7 @@ var getRequestID = func(url, apiKey string) (string, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error fetching the request-id")
+		log.Error("Error fetching the request-id")
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -144,7 +144,7 @@ var getRequestID = func(url, apiKey string) (string, error) {
 	result := sign.RequestIDResponse{}
 	err = json.NewDecoder(resp.Body).Decode(&result)
 	if err != nil {
-		log.Println("Error parsing the request-id")
+		log.Error("Error parsing the request-id")
 		return "", err
 	}
 
@@ -159,7 +159,7 @@ var getSerial = func(serialRequest, url, apiKey string) (string, error) {
 	client := &http.Client{}
 	resp, err := client.Do(req)
 	if err != nil {
-		log.Println("Error fetching the serial assertion")
+		log.Error("Error fetching the serial assertion")
 		return "", err
 	}
 	defer resp.Body.Close()
@@ -170,7 +170,7 @@ var getSerial = func(serialRequest, url, apiKey string) (string, error) {
 		result := response.StandardResponse{}
 		err = json.NewDecoder(resp.Body).Decode(&result)
 		if err != nil {
-			log.Println("Error parsing the serial assertion error")
+			log.Error("Error parsing the serial assertion error")
 			return "", err
 		}
 		message := fmt.Sprintf("%s: %s", result.ErrorCode, result.ErrorMessage)
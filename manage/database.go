@@ -21,11 +21,15 @@
 package manage
 
 import (
+	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/webhook"
 )
 
 const (
@@ -34,14 +38,14 @@ const (
 )
 
 type operation struct {
-	method     func() error
+	method     func(context.Context) error
 	action     string
 	table      string
 	skipSqlite bool
 }
 
-func execOne(method func() error, action, tableName string) {
-	err := method()
+func execOne(ctx context.Context, method func(context.Context) error, action, tableName string) {
+	err := method(ctx)
 	if err != nil {
 		log.Fatal(err)
 	} else {
@@ -49,17 +53,19 @@ func execOne(method func() error, action, tableName string) {
 	}
 }
 
-func exec(operations []operation) {
+func exec(ctx context.Context, operations []operation) {
 	for _, op := range operations {
 		if op.skipSqlite && datastore.InFactory() {
 			continue
 		}
-		execOne(op.method, op.action, op.table)
+		execOne(ctx, op.method, op.action, op.table)
 	}
 }
 
 // DatabaseCommand is the main command for database management
-type DatabaseCommand struct{}
+type DatabaseCommand struct {
+	PartitionSigningLog DatabasePartitionCommand `command:"partition-signinglog" description:"Convert the signinglog table to monthly partitions"`
+}
 
 // Execute the database schema updates
 func (cmd DatabaseCommand) Execute(args []string) error {
@@ -67,13 +73,36 @@ func (cmd DatabaseCommand) Execute(args []string) error {
 
 	openDatabase()
 
-	UpdateDatabase()
+	UpdateDatabase(context.Background())
+
+	return nil
+}
+
+// DatabasePartitionCommand converts an existing signinglog table to monthly partitions.
+// The existing logs stay in a single partition, so this is only run once after an upgrade
+type DatabasePartitionCommand struct{}
 
+// Execute the partitioning of the signinglog table
+func (cmd DatabasePartitionCommand) Execute(args []string) error {
+	if datastore.InFactory() {
+		return fmt.Errorf("The signinglog table is not partitioned on the factory database")
+	}
+	if datastore.InCockroach() {
+		return fmt.Errorf("The signinglog table is not partitioned on CockroachDB")
+	}
+
+	openDatabase()
+
+	if err := datastore.Environ.DB.PartitionSigningLogTable(context.Background(), time.Now().UTC()); err != nil {
+		return err
+	}
+	fmt.Println("Partitioned the 'signinglog' table.")
 	return nil
 }
 
 // UpdateDatabase updates the database schema
-func UpdateDatabase() {
+func UpdateDatabase(ctx context.Context) {
+	previous := recordedSchemaVersion(ctx)
 
 	// Execute all create and alter table operations
 	operations := []operation{
@@ -91,6 +120,7 @@ func UpdateDatabase() {
 
 		// Create the nonce table, if it does not exist
 		{datastore.Environ.DB.CreateDeviceNonceTable, create, "nonce", false},
+		{datastore.Environ.DB.AlterDeviceNonceTable, update, "nonce", false},
 
 		// Create the account table, if it does not exist
 		{datastore.Environ.DB.CreateAccountTable, create, "account", false},
@@ -109,10 +139,10 @@ func UpdateDatabase() {
 		{datastore.Environ.DB.CreateUserTable, create, "userinfo", false},
 
 		// Create the AccountUserLink table, if it does not exist
-		{datastore.Environ.DB.CreateAccountUserLinkTable, create, "account-user link", true},
+		{datastore.Environ.DB.CreateAccountUserLinkTable, create, "account-user link", false},
 
 		// Update the User table, removing not needed openid_identity field
-		{datastore.Environ.DB.AlterUserTable, update, "userinfo", true},
+		{datastore.Environ.DB.AlterUserTable, update, "userinfo", false},
 
 		// Create the Keypair Status table, if it does not exist, and add indexes
 		{datastore.Environ.DB.CreateKeypairStatusTable, create, "keypair status", false},
@@ -124,23 +154,111 @@ func UpdateDatabase() {
 
 		// Create the Sub-store table, if it does not exist
 		{datastore.Environ.DB.CreateSubstoreTable, create, "sub-store", false},
+		{datastore.Environ.DB.AlterSubstoreTable, update, "sub-store", false},
+		{datastore.Environ.DB.CreateSubstorePivotTable, create, "sub-store pivots", false},
+		{datastore.Environ.DB.CreateUserSubstoreLinkTable, create, "sub-store users", false},
+
+		// Create the Signing Log Purge tables, if they do not exist. The quotas count the purged signing logs
+		{datastore.Environ.DB.CreateSigningLogPurgeTable, create, "signing log purge", false},
+
+		// Create the Account Quota table, if it does not exist
+		{datastore.Environ.DB.CreateAccountQuotaTable, create, "account quota", false},
+
+		// Create the Account Retention table, if it does not exist
+		{datastore.Environ.DB.CreateAccountRetentionTable, create, "account retention", false},
+
+		// Create the Account Nonce table, if it does not exist
+		{datastore.Environ.DB.CreateAccountNonceTable, create, "account nonce", false},
+
+		// Create the API Key table, if it does not exist
+		{datastore.Environ.DB.CreateAPIKeyTable, create, "api key", false},
+		{datastore.Environ.DB.AlterAPIKeyTable, update, "api key", false},
+
+		// Create the Client Certificate table, if it does not exist
+		{datastore.Environ.DB.CreateClientCertTable, create, "client certificate", false},
+
+		// Create the Account HMAC tables, if they do not exist
+		{datastore.Environ.DB.CreateAccountHMACTable, create, "account hmac", false},
+
+		// Create the Webhook tables, if they do not exist
+		{datastore.Environ.DB.CreateWebhookTable, create, "webhook", false},
+		{datastore.Environ.DB.AlterWebhookTable, update, "webhook", false},
+
+		// Create the Notification table, if it does not exist
+		{datastore.Environ.DB.CreateNotificationTable, create, "notification", false},
+
+		// Create the Service Account table, if it does not exist
+		{datastore.Environ.DB.CreateServiceAccountTable, create, "service account", false},
+
+		// Create the Access Token table, if it does not exist
+		{datastore.Environ.DB.CreateAccessTokenTable, create, "access token", false},
+
+		// Create the Invitation table, if it does not exist
+		{datastore.Environ.DB.CreateInvitationTable, create, "invitation", false},
+
+		// Create the Revoked Token table, if it does not exist
+		{datastore.Environ.DB.CreateRevokedTokenTable, create, "revoked token", false},
+
+		// Create the Auth Failure table, if it does not exist
+		{datastore.Environ.DB.CreateAuthFailureTable, create, "auth failure", false},
+
+		// Create the Account Archive table, if it does not exist
+		{datastore.Environ.DB.CreateAccountArchiveTable, create, "account archive", false},
+
+		// Create the Signing Error table, if it does not exist
+		{datastore.Environ.DB.CreateSigningErrorTable, create, "signing error", false},
+
+		// Create the Audit Log table, if it does not exist
+		{datastore.Environ.DB.CreateAuditLogTable, create, "audit log", false},
+
+		// Create the Audit Chain table, if it does not exist
+		{datastore.Environ.DB.CreateAuditChainTable, create, "audit chain", false},
+
+		// Create the Model Revision table, if it does not exist
+		{datastore.Environ.DB.CreateModelRevisionTable, create, "model revision", false},
+
+		// Create the Signed Model Assertion table, if it does not exist
+		{datastore.Environ.DB.CreateSignedModelAssertTable, create, "signed model assertion", false},
+
+		// Create the Data Purge table, if it does not exist
+		{datastore.Environ.DB.CreateDataPurgeTable, create, "data purge", false},
+
+		// Encrypt the API keys, the webhook secrets and the account assertions that were stored in clear
+		{datastore.Environ.DB.EncryptColumns, update, "api key, webhook and account", false},
+
+		// Seal the keystore canary with the keystore secret, if it is not sealed
+		{datastore.SealKeystoreCanary, update, "settings", false},
 
 		// Create the testlog table, if it does not exist
 		{datastore.Environ.DB.CreateTestLogTable, create, "testlog", false},
+
+		// Create the Sync Status table, if it does not exist
+		{datastore.Environ.DB.CreateSyncStatusTable, create, "sync status", false},
 	}
 
-	exec(operations)
+	exec(ctx, operations)
+
+	// Record the schema version, so the readiness check knows the migrations are applied
+	if err := datastore.SetSchemaVersion(ctx); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Printf("Updated the database schema to version %d.\n", datastore.SchemaVersion)
+
+	// Post the applied migrations to the chat channels of the deployment
+	if previous < datastore.SchemaVersion {
+		webhook.PublishDeployment(ctx, webhook.EventMigration, update, map[string]interface{}{"version": datastore.SchemaVersion, "from": previous})
+	}
 
 	// Create the test key (if the filesystem store is used)
 	if datastore.Environ.Config.KeyStoreType == "filesystem" {
 		// Create the test key as it is in the default filesystem keystore
-		datastore.Environ.DB.PutKeypair(datastore.Keypair{AuthorityID: "System", KeyID: "61abf588e52be7a3"})
+		datastore.Environ.DB.PutKeypair(ctx, datastore.Keypair{AuthorityID: "System", KeyID: "61abf588e52be7a3"})
 	}
 
 	// Initialize the TPM store, authenticating with the TPM 2.0 module
 	if datastore.Environ.Config.KeyStoreType == datastore.TPM20Store.Name {
 		fmt.Println("Initialize the TPM2.0 store")
-		err := datastore.TPM2InitializeKeystore(nil)
+		err := datastore.TPM2InitializeKeystore(ctx, nil)
 		if err != nil {
 			log.Fatal(err)
 		} else {
@@ -149,3 +267,14 @@ func UpdateDatabase() {
 	}
 
 }
+
+// recordedSchemaVersion returns the version of the database schema before the update, zero when
+// it is not recorded
+func recordedSchemaVersion(ctx context.Context) int {
+	setting, err := datastore.Environ.DB.GetSetting(ctx, datastore.SettingSchemaVersion)
+	if err != nil {
+		return 0
+	}
+	version, _ := strconv.Atoi(setting.Data)
+	return version
+}
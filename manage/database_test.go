@@ -20,6 +20,9 @@
 package manage
 
 import (
+	"context"
+	"path/filepath"
+
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/config"
@@ -41,17 +44,17 @@ func (s *databaseSuite) SetUpTest(c *check.C) {
 }
 
 func (s *databaseSuite) TestDoTable(c *check.C) {
-	m1 := func() error {
+	m1 := func(ctx context.Context) error {
 		log.Println("Successful execution 1")
 		return nil
 	}
 
-	m2 := func() error {
+	m2 := func(ctx context.Context) error {
 		log.Println("Successful execution 2")
 		return nil
 	}
 
-	exec([]operation{
+	exec(context.Background(), []operation{
 		{m1, create, "the table 1", false},
 		{m2, update, "the table 2", false},
 		{m1, create, "the table 1", true},
@@ -64,9 +67,31 @@ func (s *databaseSuite) TestDatabase(c *check.C) {
 		{
 			Args:         []string{"serial-vault-admin", "database"},
 			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "database", "partition-signinglog"},
+			ErrorMessage: ""},
 	}
 
 	for _, t := range tests {
 		runTest(c, t.Args, t.ErrorMessage)
 	}
 }
+
+func (s *databaseSuite) TestDatabasePartitionError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	runTest(c, []string{"serial-vault-admin", "database", "partition-signinglog"}, "MOCK error partitioning the signing log table")
+}
+
+func (s *databaseSuite) TestUpdateDatabaseSQLite(c *check.C) {
+	settings := config.Settings{Driver: "sqlite3", KeyStoreType: "filesystem", KeyStorePath: "../keystore", KeyStoreSecret: "secret code to encrypt the auth-key hash"}
+	datastore.Environ = &datastore.Env{Config: settings}
+	datastore.OpenSysDatabase(settings.Driver, filepath.Join(c.MkDir(), "serial-vault.db"))
+	defer datastore.Environ.DB.Close()
+
+	// The schema updates are repeated on an existing database
+	UpdateDatabase(context.Background())
+	UpdateDatabase(context.Background())
+
+	c.Assert(datastore.CheckSchemaVersion(context.Background()), check.IsNil)
+}
@@ -0,0 +1,89 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"net/http/httptest"
+
+	"gopkg.in/check.v1"
+)
+
+type KeypairSuite struct {
+	server *httptest.Server
+}
+
+var _ = check.Suite(&KeypairSuite{})
+
+func (s *KeypairSuite) SetUpTest(c *check.C) {
+	Manage = Command{}
+	s.server = mockAdminAPI()
+}
+
+func (s *KeypairSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *KeypairSuite) TestKeypair(c *check.C) {
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keypair"},
+			ErrorMessage: "Please specify one command of: import or list"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "list"},
+			ErrorMessage: "Error listing the signing-keys: The URL of the admin API must be set with --api-url or SERIAL_VAULT_API_URL"},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "list", "--api-url", s.server.URL, "--api-user", "sv", "--api-key", "ValidAPIKey"},
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "list", "--api-url", s.server.URL, "--api-user", "user1", "--api-key", "ValidAPIKey"},
+			ErrorMessage: "Error listing the signing-keys: error-auth"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *KeypairSuite) TestKeypairImport(c *check.C) {
+	api := []string{"--api-url", s.server.URL, "--api-user", "sv", "--api-key", "ValidAPIKey"}
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "keypair", "import"},
+			ErrorMessage: "the required flags `-a, --authority-id' and `-k, --key-name' were not specified"},
+		{
+			Args:         append([]string{"serial-vault-admin", "keypair", "import", "-a", "system", "-k", "serial-key"}, api...),
+			ErrorMessage: "Import keypair expects a single private key file argument"},
+		{
+			Args:         append([]string{"serial-vault-admin", "keypair", "import", "-a", "system", "-k", "serial-key", "../keystore/invalid.asc"}, api...),
+			ErrorMessage: "Error reading the private key: .*"},
+		{
+			Args:         append([]string{"serial-vault-admin", "keypair", "import", "-a", "system", "-k", "serial-key", "../README.md"}, api...),
+			ErrorMessage: "Error importing the signing-key: .*"},
+		{
+			Args:         append([]string{"serial-vault-admin", "keypair", "import", "-a", "system", "-k", "serial-key", "../keystore/TestKey.asc"}, api...),
+			ErrorMessage: ""},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
@@ -0,0 +1,60 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/CanonicalLtd/serial-vault/service/keypair"
+)
+
+// KeypairImportCommand imports a signing-key through the admin API. The private key is
+// read from the file of the argument, as exported by snap export-key
+type KeypairImportCommand struct {
+	AuthorityID string `short:"a" long:"authority-id" description:"The authority-id of the signing-key" required:"yes"`
+	KeyName     string `short:"k" long:"key-name" description:"The name of the signing-key" required:"yes"`
+}
+
+// Execute the import of a signing-key
+func (cmd KeypairImportCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Import keypair expects a single private key file argument")
+	}
+
+	privateKey, err := ioutil.ReadFile(args[0])
+	if err != nil {
+		return fmt.Errorf("Error reading the private key: %v", err)
+	}
+
+	k := keypair.WithPrivateKey{
+		AuthorityID: cmd.AuthorityID,
+		KeyName:     cmd.KeyName,
+		PrivateKey:  base64.StdEncoding.EncodeToString(privateKey),
+	}
+	if err := Manage.API.call("POST", "/api/keypairs", k, nil); err != nil {
+		return fmt.Errorf("Error importing the signing-key: %v", err)
+	}
+
+	fmt.Printf("Signing-key '%s' imported successfully for '%s'\n", k.KeyName, k.AuthorityID)
+	return nil
+}
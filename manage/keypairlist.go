@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CanonicalLtd/serial-vault/service/keypair"
+)
+
+// KeypairListCommand lists the signing-keys through the admin API
+type KeypairListCommand struct{}
+
+// Execute the list of signing-keys
+func (cmd KeypairListCommand) Execute(args []string) error {
+	result := keypair.ListResponse{}
+	if err := Manage.API.call("GET", "/api/keypairs", nil, &result); err != nil {
+		return fmt.Errorf("Error listing the signing-keys: %v", err)
+	}
+
+	// Create a tabwriter to format the output
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 4, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tAuthority ID\tKey Name\tKey ID\tActive")
+	for _, k := range result.Keypairs {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%t\n", k.ID, k.AuthorityID, k.KeyName, k.KeyID, k.Active)
+	}
+	w.Flush()
+
+	return nil
+}
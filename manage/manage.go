@@ -26,11 +26,14 @@ import (
 
 // Command defines the options for the serial-vault-admin command-line utility
 type Command struct {
-	SettingsFile string `short:"c" long:"config" description:"Path to the config file" default:"./settings.yaml"`
+	SettingsFile string     `short:"c" long:"config" description:"Path to the config file" default:"./settings.yaml"`
+	API          APIOptions `group:"Admin API Options"`
 
 	Account  AccountCommand  `command:"account" alias:"a" description:"Account management"`
 	Client   ClientCommand   `command:"client" alias:"c" description:"Serial-Vault Client to generate a test serial assertion request"`
-	Database DatabaseCommand `command:"database" alias:"d" description:"Database schema update"`
+	Database DatabaseCommand `command:"database" alias:"d" description:"Database schema update" subcommands-optional:"true"`
+	Keypair  KeypairCommand  `command:"keypair" alias:"k" description:"Signing-key management, through the admin API"`
+	Model    ModelCommand    `command:"model" alias:"m" description:"Model management, through the admin API"`
 	User     UserCommand     `command:"user" alias:"u" description:"User management"`
 }
 
@@ -0,0 +1,115 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+	"gopkg.in/check.v1"
+)
+
+type ModelSuite struct {
+	server *httptest.Server
+}
+
+var _ = check.Suite(&ModelSuite{})
+
+func (s *ModelSuite) SetUpTest(c *check.C) {
+	Manage = Command{}
+	s.server = mockAdminAPI()
+}
+
+func (s *ModelSuite) TearDownTest(c *check.C) {
+	s.server.Close()
+}
+
+func (s *ModelSuite) TestModel(c *check.C) {
+	api := []string{"--api-url", s.server.URL, "--api-user", "sv", "--api-key", "ValidAPIKey"}
+
+	tests := []manTest{
+		{
+			Args:         []string{"serial-vault-admin", "model"},
+			ErrorMessage: "Please specify one command of: create, link or list"},
+		{
+			Args:         append([]string{"serial-vault-admin", "model", "list"}, api...),
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "model", "create"},
+			ErrorMessage: "the required flags `-b, --brand', `-k, --keypair-id' and `-m, --model' were not specified"},
+		{
+			Args:         append([]string{"serial-vault-admin", "model", "create", "-b", "system", "-m", "alder", "-k", "1"}, api...),
+			ErrorMessage: ""},
+		{
+			Args:         []string{"serial-vault-admin", "model", "create", "-b", "system", "-m", "alder", "-k", "1", "--api-url", s.server.URL, "--api-user", "user1", "--api-key", "ValidAPIKey"},
+			ErrorMessage: "Error creating the model: error-auth"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+}
+
+func (s *ModelSuite) TestModelLink(c *check.C) {
+	api := []string{"--api-url", s.server.URL, "--api-user", "sv", "--api-key", "ValidAPIKey"}
+
+	tests := []manTest{
+		{
+			Args:         append([]string{"serial-vault-admin", "model", "link", "-k", "1"}, api...),
+			ErrorMessage: "Link model expects a single model ID argument"},
+		{
+			Args:         append([]string{"serial-vault-admin", "model", "link", "-k", "1", "invalid"}, api...),
+			ErrorMessage: "Invalid model ID 'invalid'"},
+		{
+			Args:         append([]string{"serial-vault-admin", "model", "link", "-k", "1", "999"}, api...),
+			ErrorMessage: "Error finding the model: .*"},
+	}
+
+	for _, t := range tests {
+		runTest(c, t.Args, t.ErrorMessage)
+	}
+
+	Manage = Command{}
+	runTest(c, append([]string{"serial-vault-admin", "model", "link", "1"}, api...), "Link model expects a --keypair-id or --keypair-id-user option")
+}
+
+func (s *ModelSuite) TestModelLinkUpdate(c *check.C) {
+	// The model is updated from the version that was read
+	var updated datastore.Model
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.URL.Path, check.Equals, "/api/models/1")
+		if r.Method == "PUT" {
+			json.NewDecoder(r.Body).Decode(&updated)
+			w.Write([]byte(`{"success": true}`))
+			return
+		}
+		json.NewEncoder(w).Encode(model.InstanceResponse{Success: true, Model: datastore.Model{ID: 1, BrandID: "system", Name: "alder", KeypairID: 1, KeypairIDUser: 1, Version: 2}})
+	}))
+	defer server.Close()
+
+	runTest(c, []string{"serial-vault-admin", "model", "link", "-k", "3", "1", "--api-url", server.URL}, "")
+	c.Assert(updated.KeypairID, check.Equals, 3)
+	c.Assert(updated.KeypairIDUser, check.Equals, 1)
+	c.Assert(updated.Version, check.Equals, 2)
+}
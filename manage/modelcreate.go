@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+)
+
+// ModelCreateCommand creates a model through the admin API
+type ModelCreateCommand struct {
+	BrandID       string `short:"b" long:"brand" description:"The brand-id of the model" required:"yes"`
+	Name          string `short:"m" long:"model" description:"The name of the model" required:"yes"`
+	KeypairID     int    `short:"k" long:"keypair-id" description:"The ID of the signing-key for the serial assertions" required:"yes"`
+	KeypairIDUser int    `short:"u" long:"keypair-id-user" description:"The ID of the signing-key for the system-user assertions"`
+	APIKey        string `short:"a" long:"model-api-key" description:"The API key of the model, generated when it is not given"`
+}
+
+// Execute the creation of a model
+func (cmd ModelCreateCommand) Execute(args []string) error {
+	mdl := datastore.Model{
+		BrandID:       cmd.BrandID,
+		Name:          cmd.Name,
+		KeypairID:     cmd.KeypairID,
+		KeypairIDUser: cmd.KeypairIDUser,
+		APIKey:        cmd.APIKey,
+	}
+
+	result := model.InstanceResponse{}
+	if err := Manage.API.call("POST", "/api/models", mdl, &result); err != nil {
+		return fmt.Errorf("Error creating the model: %v", err)
+	}
+
+	fmt.Printf("Model '%s/%s' created successfully with ID %d\n", mdl.BrandID, mdl.Name, result.Model.ID)
+	return nil
+}
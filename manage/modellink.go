@@ -0,0 +1,69 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/service/model"
+)
+
+// ModelLinkCommand links a model to its signing-keys through the admin API. The model is
+// updated from the version that was read, so a concurrent change of the model is rejected
+type ModelLinkCommand struct {
+	KeypairID     int `short:"k" long:"keypair-id" description:"The ID of the signing-key for the serial assertions"`
+	KeypairIDUser int `short:"u" long:"keypair-id-user" description:"The ID of the signing-key for the system-user assertions"`
+}
+
+// Execute the link of a model to its signing-keys
+func (cmd ModelLinkCommand) Execute(args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("Link model expects a single model ID argument")
+	}
+	modelID, err := strconv.Atoi(args[0])
+	if err != nil {
+		return fmt.Errorf("Invalid model ID '%s'", args[0])
+	}
+	if cmd.KeypairID == 0 && cmd.KeypairIDUser == 0 {
+		return fmt.Errorf("Link model expects a --keypair-id or --keypair-id-user option")
+	}
+
+	path := fmt.Sprintf("/api/models/%d", modelID)
+	result := model.InstanceResponse{}
+	if err := Manage.API.call("GET", path, nil, &result); err != nil {
+		return fmt.Errorf("Error finding the model: %v", err)
+	}
+
+	mdl := result.Model
+	if cmd.KeypairID > 0 {
+		mdl.KeypairID = cmd.KeypairID
+	}
+	if cmd.KeypairIDUser > 0 {
+		mdl.KeypairIDUser = cmd.KeypairIDUser
+	}
+	if err := Manage.API.call("PUT", path, mdl, nil); err != nil {
+		return fmt.Errorf("Error linking the model: %v", err)
+	}
+
+	fmt.Printf("Model '%s/%s' linked successfully\n", mdl.BrandID, mdl.Name)
+	return nil
+}
@@ -0,0 +1,52 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/CanonicalLtd/serial-vault/service/model"
+)
+
+// ModelListCommand lists the models through the admin API
+type ModelListCommand struct{}
+
+// Execute the list of models
+func (cmd ModelListCommand) Execute(args []string) error {
+	result := model.ListResponse{}
+	if err := Manage.API.call("GET", "/api/models?all=true", nil, &result); err != nil {
+		return fmt.Errorf("Error listing the models: %v", err)
+	}
+
+	// Create a tabwriter to format the output
+	w := new(tabwriter.Writer)
+	w.Init(os.Stdout, 5, 0, 4, ' ', 0)
+
+	fmt.Fprintln(w, "ID\tBrand\tModel\tKeypair ID\tKeypair ID (system-user)")
+	for _, m := range result.Models {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%d\t%d\n", m.ID, m.BrandID, m.Name, m.KeypairID, m.KeypairIDUser)
+	}
+	w.Flush()
+
+	return nil
+}
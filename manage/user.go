@@ -27,6 +27,8 @@ type UserCommand struct {
 	Add    UserAddCommand    `command:"add" alias:"a" description:"Add a new user"`
 	Update UserUpdateCommand `command:"update" alias:"a" description:"Update an existing user"`
 	Delete UserDeleteCommand `command:"delete" alias:"d" description:"Delete an existing user"`
+
+	LDAPSync UserLDAPSyncCommand `command:"ldap-sync" description:"Sync the users and their accounts from the LDAP groups"`
 }
 
 func checkUsernameArg(args []string, action string) error {
@@ -36,7 +36,7 @@ func (s *UserSuite) TestUser(c *check.C) {
 	tests := []manTest{
 		{
 			Args:         []string{"serial-vault-admin", "user"},
-			ErrorMessage: "Please specify one command of: add, delete, list or update"},
+			ErrorMessage: "Please specify one command of: add, delete, ldap-sync, list or update"},
 		{
 			Args:         []string{"serial-vault-admin", "user", "list"},
 			ErrorMessage: ""},
@@ -88,3 +88,13 @@ func (s *UserSuite) TestUser(c *check.C) {
 		runTest(c, t.Args, t.ErrorMessage)
 	}
 }
+
+func (s *UserSuite) TestUserLDAPSync(c *check.C) {
+	runTest(c, []string{"serial-vault-admin", "user", "ldap-sync", "--dry-run"}, "The LDAP sync is not configured")
+
+	datastore.Environ.Config.LDAPURL = "ldap://ldap.example.com"
+	runTest(c, []string{"serial-vault-admin", "user", "ldap-sync"}, "Error in the LDAP sync config: the LDAP sync needs the base DN of the users")
+
+	datastore.Environ.Config.LDAPBaseDN = "dc=example"
+	runTest(c, []string{"serial-vault-admin", "user", "ldap-sync"}, "Error in the LDAP sync config: the LDAP sync needs at least one group")
+}
@@ -20,6 +20,7 @@
 package manage
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -56,11 +57,16 @@ func (cmd UserAddCommand) Execute(args []string) error {
 		Accounts: []datastore.Account{},
 	}
 
-	_, err = datastore.Environ.DB.CreateUser(user)
+	_, err = datastore.Environ.DB.CreateUser(context.Background(), user)
 	if err != nil {
 		return fmt.Errorf("Error creating the user: %v", err)
 	}
 
 	fmt.Printf("User '%s' created successfully\n", user.Username)
+
+	// The API key is generated, and is needed to call the admin API as the user
+	if created, err := datastore.Environ.DB.GetUserByUsername(context.Background(), user.Username); err == nil {
+		fmt.Printf("API key: %s\n", created.APIKey)
+	}
 	return nil
 }
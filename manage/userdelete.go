@@ -20,6 +20,7 @@
 package manage
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -37,12 +38,12 @@ func (cmd UserDeleteCommand) Execute(args []string) error {
 
 	// Open the database and get the user from the database
 	openDatabase()
-	user, err := datastore.Environ.DB.GetUserByUsername(args[0])
+	user, err := datastore.Environ.DB.GetUserByUsername(context.Background(), args[0])
 	if err != nil {
 		return fmt.Errorf("Error finding the user '%s'", args[0])
 	}
 
-	err = datastore.Environ.DB.DeleteUser(user.ID)
+	err = datastore.Environ.DB.DeleteUser(context.Background(), user.ID)
 	if err != nil {
 		return fmt.Errorf("Error deleting the user: %v", err)
 	}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package manage
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/ldapsync"
+)
+
+// UserLDAPSyncCommand syncs the users and their account memberships from the LDAP groups
+// of the config, reporting the changes and the conflicts
+type UserLDAPSyncCommand struct {
+	DryRun bool `long:"dry-run" description:"Report the changes without applying them"`
+}
+
+// Execute the sync of the users from the LDAP groups
+func (cmd UserLDAPSyncCommand) Execute(args []string) error {
+	openDatabase()
+
+	cfg, err := ldapsync.NewConfig(datastore.Environ.Config)
+	if err != nil {
+		return fmt.Errorf("Error in the LDAP sync config: %v", err)
+	}
+	if !cfg.Enabled() {
+		return fmt.Errorf("The LDAP sync is not configured")
+	}
+
+	report, err := ldapsync.Run(context.Background(), datastore.Environ.DB, cfg, cmd.DryRun || cfg.DryRun)
+	if err != nil {
+		return fmt.Errorf("Error syncing the users from LDAP: %v", err)
+	}
+	printLDAPSyncReport(report)
+	return nil
+}
+
+func printLDAPSyncReport(report ldapsync.Report) {
+	for _, u := range report.Created {
+		fmt.Printf("created  %s\n", u)
+	}
+	for _, u := range report.Updated {
+		fmt.Printf("updated  %s\n", u)
+	}
+	for _, u := range report.Disabled {
+		fmt.Printf("disabled %s\n", u)
+	}
+	for _, c := range report.Conflicts {
+		fmt.Printf("conflict %s %s %s: %s\n", c.Group, c.Account, c.Username, c.Message)
+	}
+
+	mode := ""
+	if report.DryRun {
+		mode = " (dry-run, nothing applied)"
+	}
+	fmt.Printf("Synced the users from LDAP%s: %d created, %d updated, %d disabled, %d conflicts\n", mode, len(report.Created), len(report.Updated), len(report.Disabled), len(report.Conflicts))
+}
@@ -20,6 +20,7 @@
 package manage
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"text/tabwriter"
@@ -35,7 +36,7 @@ func (cmd UserListCommand) Execute(args []string) error {
 
 	// Get the list of users from the database
 	openDatabase()
-	users, err := datastore.Environ.DB.ListUsers()
+	users, err := datastore.Environ.DB.ListUsers(context.Background())
 	if err != nil {
 		fmt.Printf("Error list the users: %v\n", err)
 	}
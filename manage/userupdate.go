@@ -20,6 +20,7 @@
 package manage
 
 import (
+	"context"
 	"errors"
 	"fmt"
 
@@ -50,7 +51,7 @@ func (cmd UserUpdateCommand) Execute(args []string) error {
 
 	// Open the database and get the user from the database
 	openDatabase()
-	user, err := datastore.Environ.DB.GetUserByUsername(args[0])
+	user, err := datastore.Environ.DB.GetUserByUsername(context.Background(), args[0])
 	if err != nil {
 		return fmt.Errorf("Error finding the user '%s'", args[0])
 	}
@@ -74,7 +75,7 @@ func (cmd UserUpdateCommand) Execute(args []string) error {
 		user.Email = cmd.Email
 	}
 
-	err = datastore.Environ.DB.UpdateUser(user)
+	err = datastore.Environ.DB.UpdateUser(context.Background(), user)
 	if err != nil {
 		return fmt.Errorf("Error updating the user: %v", err)
 	}
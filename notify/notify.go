@@ -0,0 +1,350 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package notify sends the email notifications of the events of the accounts, e.g. a generated
+// signing-key or an exceeded signing quota, to the admins of the account. The notified events,
+// and the templates of the emails, are set in the config settings
+package notify
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/mailer"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// The events that are notified, when they are enabled in the config settings
+const (
+	EventKeypairGenerated = "keypair-generated" // a signing-key has been generated
+	EventKeypairFailed    = "keypair-failed"    // the generation of a signing-key has failed
+	EventKeyExpiry        = "key-expiry"        // the account-key of a signing-key is about to expire
+	EventQuotaWarning     = "quota-warning"     // the signing quota of an account has reached the warning level
+	EventQuotaExceeded    = "quota-exceeded"    // the signing quota of an account is used up
+)
+
+var validEvents = []string{EventKeypairGenerated, EventKeypairFailed, EventKeyExpiry, EventQuotaWarning, EventQuotaExceeded}
+
+// defaultTemplates are the emails of the events, unless a template replaces them. The first
+// line is the subject of the email, followed by a blank line and the body
+var defaultTemplates = map[string]string{
+	EventKeypairGenerated: `Subject: Signing-key {{.KeyName}} of {{.AuthorityID}} is ready
+
+The signing-key {{.KeyName}} of the account {{.AuthorityID}} has been generated.
+`,
+	EventKeypairFailed: `Subject: Signing-key {{.KeyName}} of {{.AuthorityID}} failed
+
+The generation of the signing-key {{.KeyName}} of the account {{.AuthorityID}} has failed:
+
+{{.Error}}
+`,
+	EventKeyExpiry: `Subject: Signing-key {{.KeyName}} of {{.AuthorityID}} expires on {{.Expires.Format "2006-01-02"}}
+
+The account-key of the signing-key {{.KeyName}} ({{.KeyID}}) of the account {{.AuthorityID}}
+expires on {{.Expires.Format "2006-01-02 15:04 MST"}}. The devices cannot be signed with the
+signing-key after it has expired.
+`,
+	EventQuotaWarning: `Subject: Signing quota of {{.AuthorityID}} is running out
+
+The signing quota of the account {{.AuthorityID}} has reached the warning level:
+{{.Used}} devices are signed (warning {{.Warning}}, limit {{.Limit}}, grace {{.Grace}}).
+`,
+	EventQuotaExceeded: `Subject: Signing quota of {{.AuthorityID}} is exceeded
+
+The signing quota of the account {{.AuthorityID}} is used up, and the devices of the account are
+no longer signed: {{.Used}} devices are signed (limit {{.Limit}}, grace {{.Grace}}).
+`,
+}
+
+// defaultExpiryWarning is how long before the expiry of a signing-key it is notified, when it is not configured
+const defaultExpiryWarning = 30 * 24 * time.Hour
+
+// expiryInterval is the interval of the check of the expiry of the signing-keys
+const expiryInterval = 24 * time.Hour
+
+// sendMail sends an email. It is a variable so it can be overridden for testing
+var sendMail = mailer.Send
+
+// Config is the email notifications from the config settings
+type Config struct {
+	Mail          mailer.Config
+	Events        map[string]*template.Template // the templates of the enabled events
+	Recipients    []string                      // the recipients of all the notifications, besides the admins of the account
+	ExpiryWarning time.Duration
+}
+
+// NewConfig returns the email notifications from the config settings. The templates of the
+// enabled events are read from the templates directory, when it has a template of the event
+func NewConfig(settings config.Settings) (Config, error) {
+	mail, err := mailer.NewConfig(settings)
+	if err != nil {
+		return Config{}, err
+	}
+	cfg := Config{Mail: mail, Events: map[string]*template.Template{}, Recipients: settings.NotifyRecipients, ExpiryWarning: defaultExpiryWarning}
+
+	for _, event := range settings.NotifyEvents {
+		if _, ok := defaultTemplates[event]; !ok {
+			return Config{}, fmt.Errorf("invalid notification event '%s', expected one of: %s", event, strings.Join(validEvents, ", "))
+		}
+		if cfg.Events[event], err = loadTemplate(settings.NotifyTemplates, event); err != nil {
+			return Config{}, err
+		}
+	}
+
+	if len(cfg.Events) > 0 && !mailer.Enabled(mail) {
+		return Config{}, errors.New("the notifications need the SMTP server to be configured")
+	}
+
+	if len(settings.KeyExpiryWarning) > 0 {
+		warning, err := time.ParseDuration(settings.KeyExpiryWarning)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid key expiry warning: %v", err)
+		}
+		if warning <= 0 {
+			return Config{}, fmt.Errorf("the key expiry warning must be positive")
+		}
+		cfg.ExpiryWarning = warning
+	}
+	return cfg, nil
+}
+
+// loadTemplate parses the template of the event, from the templates directory when it has one
+func loadTemplate(dir, event string) (*template.Template, error) {
+	text := defaultTemplates[event]
+	if len(dir) > 0 {
+		data, err := ioutil.ReadFile(filepath.Join(dir, event+".tmpl"))
+		switch {
+		case err == nil:
+			text = string(data)
+		case !os.IsNotExist(err):
+			return nil, fmt.Errorf("error reading the template of the %s notification: %v", event, err)
+		}
+	}
+
+	tmpl, err := template.New(event).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid template of the %s notification: %v", event, err)
+	}
+	return tmpl, nil
+}
+
+// Enabled checks whether the event is notified
+func Enabled(cfg Config, event string) bool {
+	return cfg.Events[event] != nil
+}
+
+// ExpiryJob checks the expiry of the signing-keys when the scheduler starts, and then daily
+func ExpiryJob(cfg Config) scheduler.Job {
+	return scheduler.Job{Name: "key-expiry", Interval: expiryInterval, RunAtStart: true, Run: func(ctx context.Context) error {
+		_, err := CheckExpiry(ctx, datastore.Environ.DB, cfg, time.Now())
+		return err
+	}}
+}
+
+// Send notifies the event of the account in the background, when the event is enabled. The
+// data is the fields of the template of the event. An error is logged, as the event has
+// already happened
+func Send(ctx context.Context, event, authorityID string, data map[string]interface{}) {
+	cfg, ok := enabled(ctx, event)
+	if !ok {
+		return
+	}
+	send(ctx, cfg, event, authorityID, data)
+}
+
+// SendOnce notifies the event of the account, unless it has already been notified for the
+// reference, e.g. the limit of an exceeded quota
+func SendOnce(ctx context.Context, event, reference, authorityID string, data map[string]interface{}) {
+	cfg, ok := enabled(ctx, event)
+	if !ok {
+		return
+	}
+
+	first, err := datastore.Environ.DB.RecordNotification(ctx, event, reference)
+	if err != nil {
+		log.FromContext(ctx).Errorf("Error sending the %s notification of %s: %v", event, authorityID, err)
+		return
+	}
+	if first {
+		send(ctx, cfg, event, authorityID, data)
+	}
+}
+
+// enabled returns the email notifications from the config settings, when the event is notified
+func enabled(ctx context.Context, event string) (Config, bool) {
+	cfg, err := NewConfig(datastore.Environ.Config)
+	if err != nil {
+		log.FromContext(ctx).Errorf("Error in the notification config: %v", err)
+		return cfg, false
+	}
+	return cfg, Enabled(cfg, event)
+}
+
+// send delivers the notification in the background, as the request of the event may be
+// complete before the email is sent
+func send(ctx context.Context, cfg Config, event, authorityID string, data map[string]interface{}) {
+	datastore.RunBackgroundJob(ctx, "notify "+event, func(ctx context.Context) {
+		if err := deliver(ctx, datastore.Environ.DB, cfg, event, authorityID, data); err != nil {
+			log.FromContext(ctx).Errorf("Error sending the %s notification of %s: %v", event, authorityID, err)
+		}
+	})
+}
+
+// CheckExpiry notifies the active signing-keys whose account-key expires within the warning
+// time, once for each expiry of a key. It returns the number of keys that were notified
+func CheckExpiry(ctx context.Context, db datastore.Datastore, cfg Config, now time.Time) (int, error) {
+	if !Enabled(cfg, EventKeyExpiry) {
+		return 0, nil
+	}
+
+	keypairs, err := db.ListAllowedKeypairs(ctx, datastore.User{})
+	if err != nil {
+		return 0, err
+	}
+
+	notified := 0
+	for _, k := range keypairs {
+		expires, ok := keyExpiry(k)
+		if !ok || expires.After(now.Add(cfg.ExpiryWarning)) {
+			continue
+		}
+
+		reference := fmt.Sprintf("%s/%s/%s", k.AuthorityID, k.KeyID, expires.UTC().Format(time.RFC3339))
+		first, err := db.RecordNotification(ctx, EventKeyExpiry, reference)
+		if err != nil {
+			return notified, err
+		}
+		if !first {
+			continue
+		}
+
+		data := map[string]interface{}{"KeyName": k.KeyName, "KeyID": k.KeyID, "Expires": expires.UTC()}
+		if err := deliver(ctx, db, cfg, EventKeyExpiry, k.AuthorityID, data); err != nil {
+			log.Errorf("Error sending the %s notification of %s: %v", EventKeyExpiry, k.AuthorityID, err)
+			continue
+		}
+		notified++
+	}
+	return notified, nil
+}
+
+// keyExpiry returns the expiry of the account-key of an active signing-key, when it expires
+func keyExpiry(k datastore.Keypair) (time.Time, bool) {
+	if !k.Active || len(k.Assertion) == 0 {
+		return time.Time{}, false
+	}
+
+	assertion, err := asserts.Decode([]byte(k.Assertion))
+	if err != nil {
+		return time.Time{}, false
+	}
+	accountKey, ok := assertion.(*asserts.AccountKey)
+	if !ok || accountKey.Until().IsZero() {
+		return time.Time{}, false
+	}
+	return accountKey.Until(), true
+}
+
+// deliver sends the email of the event to the admins of the account and the recipients of
+// all the notifications
+func deliver(ctx context.Context, db datastore.Datastore, cfg Config, event, authorityID string, data map[string]interface{}) error {
+	subject, body, err := render(cfg.Events[event], event, authorityID, data)
+	if err != nil {
+		return err
+	}
+
+	recipients, err := recipients(ctx, db, cfg, authorityID)
+	if err != nil {
+		return err
+	}
+
+	for _, to := range recipients {
+		if err := sendMail(cfg.Mail, to, subject, body); err != nil {
+			return fmt.Errorf("error sending the email to %s: %v", to, err)
+		}
+	}
+	return nil
+}
+
+// recipients returns the email addresses of the active admins of the account, and the recipients
+// of all the notifications, without duplicates
+func recipients(ctx context.Context, db datastore.Datastore, cfg Config, authorityID string) ([]string, error) {
+	users, err := db.ListAccountUsers(ctx, authorityID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[string]bool{}
+	addresses := []string{}
+	add := func(address string) {
+		if len(address) == 0 || seen[strings.ToLower(address)] {
+			return
+		}
+		seen[strings.ToLower(address)] = true
+		addresses = append(addresses, address)
+	}
+
+	for _, u := range users {
+		if u.Active && u.Role >= datastore.Admin {
+			add(u.Email)
+		}
+	}
+	for _, r := range cfg.Recipients {
+		add(strings.TrimSpace(r))
+	}
+	return addresses, nil
+}
+
+// render executes the template of the event, and splits the subject from the body
+func render(tmpl *template.Template, event, authorityID string, data map[string]interface{}) (string, string, error) {
+	if tmpl == nil {
+		return "", "", fmt.Errorf("the %s notification is not enabled", event)
+	}
+
+	fields := map[string]interface{}{}
+	for k, v := range data {
+		fields[k] = v
+	}
+	fields["Event"] = event
+	fields["AuthorityID"] = authorityID
+
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, fields); err != nil {
+		return "", "", fmt.Errorf("error rendering the %s notification: %v", event, err)
+	}
+
+	parts := strings.SplitN(strings.Replace(b.String(), "\r\n", "\n", -1), "\n\n", 2)
+	if len(parts) != 2 || !strings.HasPrefix(parts[0], "Subject:") || strings.Contains(parts[0], "\n") {
+		return "", "", fmt.Errorf("the %s notification must start with a subject line and a blank line", event)
+	}
+	return strings.TrimSpace(strings.TrimPrefix(parts[0], "Subject:")), parts[1], nil
+}
@@ -0,0 +1,246 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package notify
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/mailer"
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/asserts/assertstest"
+)
+
+// email is an email that is sent by the tests
+type email struct {
+	to, subject, body string
+}
+
+// mockSendMail records the emails that are sent, until the returned function restores the mailer
+func mockSendMail(sent *[]email) func() {
+	sendMail = func(cfg mailer.Config, to, subject, body string) error {
+		*sent = append(*sent, email{to, subject, body})
+		return nil
+	}
+	return func() { sendMail = mailer.Send }
+}
+
+var smtpSettings = config.Settings{SMTPServer: "smtp.example.com:587", MailFrom: "vault@example.com"}
+
+func TestNewConfig(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "quota-warning.tmpl"), []byte("Subject: Quota {{.AuthorityID}}\n\nUsed {{.Used}}\n"), 0600); err != nil {
+		t.Fatalf("Error writing the template: %v", err)
+	}
+	invalid := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(invalid, "quota-warning.tmpl"), []byte("Subject: {{.AuthorityID"), 0600); err != nil {
+		t.Fatalf("Error writing the template: %v", err)
+	}
+
+	withEvents := func(events []string, templates, warning string) config.Settings {
+		settings := smtpSettings
+		settings.NotifyEvents = events
+		settings.NotifyTemplates = templates
+		settings.KeyExpiryWarning = warning
+		return settings
+	}
+
+	tests := []struct {
+		name     string
+		settings config.Settings
+		events   []string
+		warning  time.Duration
+		fails    bool
+	}{
+		{"no notifications", config.Settings{}, nil, defaultExpiryWarning, false},
+		{"enabled events", withEvents([]string{EventKeypairGenerated, EventKeyExpiry}, "", "48h"), []string{EventKeypairGenerated, EventKeyExpiry}, 48 * time.Hour, false},
+		{"template directory", withEvents([]string{EventQuotaWarning, EventQuotaExceeded}, dir, ""), []string{EventQuotaWarning, EventQuotaExceeded}, defaultExpiryWarning, false},
+		{"invalid event", withEvents([]string{"invalid"}, "", ""), nil, 0, true},
+		{"invalid template", withEvents([]string{EventQuotaWarning}, invalid, ""), nil, 0, true},
+		{"invalid warning", withEvents([]string{EventKeyExpiry}, "", "invalid"), nil, 0, true},
+		{"negative warning", withEvents([]string{EventKeyExpiry}, "", "-1h"), nil, 0, true},
+		{"no SMTP server", config.Settings{NotifyEvents: []string{EventKeypairFailed}}, nil, 0, true},
+	}
+
+	for _, tt := range tests {
+		cfg, err := NewConfig(tt.settings)
+		if (err != nil) != tt.fails {
+			t.Errorf("%s: expected failure %v, got %v", tt.name, tt.fails, err)
+			continue
+		}
+		if tt.fails {
+			continue
+		}
+		if len(cfg.Events) != len(tt.events) {
+			t.Errorf("%s: expected events %v, got %v", tt.name, tt.events, cfg.Events)
+		}
+		for _, event := range tt.events {
+			if !Enabled(cfg, event) {
+				t.Errorf("%s: expected the %s event to be enabled", tt.name, event)
+			}
+		}
+		if cfg.ExpiryWarning != tt.warning {
+			t.Errorf("%s: expected warning %v, got %v", tt.name, tt.warning, cfg.ExpiryWarning)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	for event := range defaultTemplates {
+		tmpl, err := loadTemplate("", event)
+		if err != nil {
+			t.Fatalf("Error loading the %s template: %v", event, err)
+		}
+		data := map[string]interface{}{"KeyName": "my-key", "KeyID": "abc", "Error": "failed", "Expires": time.Now(), "Used": 90, "Warning": 80, "Limit": 100, "Grace": 5}
+		subject, body, err := render(tmpl, event, "brand", data)
+		if err != nil {
+			t.Errorf("Error rendering the %s template: %v", event, err)
+			continue
+		}
+		if !strings.Contains(subject, "brand") || !strings.Contains(body, "brand") {
+			t.Errorf("Expected the account in the %s email, got: %s\n%s", event, subject, body)
+		}
+	}
+
+	tmpl, err := loadTemplate(t.TempDir(), EventKeypairFailed)
+	if err != nil {
+		t.Fatalf("Error loading the default template: %v", err)
+	}
+	subject, body, err := render(tmpl, EventKeypairFailed, "brand", map[string]interface{}{"KeyName": "my-key", "Error": "no entropy"})
+	if err != nil {
+		t.Fatalf("Error rendering the template: %v", err)
+	}
+	if subject != "Signing-key my-key of brand failed" || !strings.Contains(body, "no entropy") {
+		t.Errorf("Unexpected email: %s\n%s", subject, body)
+	}
+
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, EventKeypairFailed+".tmpl"), []byte("No subject line\n"), 0600); err != nil {
+		t.Fatalf("Error writing the template: %v", err)
+	}
+	if tmpl, err = loadTemplate(dir, EventKeypairFailed); err != nil {
+		t.Fatalf("Error loading the template: %v", err)
+	}
+	if _, _, err := render(tmpl, EventKeypairFailed, "brand", nil); err == nil {
+		t.Error("Expected an error with a template without a subject")
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	var sent []email
+	defer mockSendMail(&sent)()
+
+	settings := smtpSettings
+	settings.NotifyEvents = []string{EventKeypairGenerated}
+	settings.NotifyRecipients = []string{"ops@example.com", "SV@example.com"}
+	cfg, err := NewConfig(settings)
+	if err != nil {
+		t.Fatalf("Error in the config: %v", err)
+	}
+
+	if err := deliver(context.Background(), &datastore.MockDB{}, cfg, EventKeypairGenerated, "authority3", map[string]interface{}{"KeyName": "my-key"}); err != nil {
+		t.Fatalf("Error delivering the notification: %v", err)
+	}
+
+	// The admins of the account, and the recipients without duplicates
+	recipients := []string{}
+	for _, e := range sent {
+		recipients = append(recipients, e.to)
+		if e.subject != "Signing-key my-key of authority3 is ready" {
+			t.Errorf("Unexpected subject: %s", e.subject)
+		}
+	}
+	if strings.Join(recipients, ",") != "sv@example.com,the_root_user@thisdb.com,ops@example.com" {
+		t.Errorf("Unexpected recipients: %v", recipients)
+	}
+
+	if err := deliver(context.Background(), &datastore.ErrorMockDB{}, cfg, EventKeypairGenerated, "authority3", nil); err == nil {
+		t.Error("Expected an error when the users cannot be listed")
+	}
+}
+
+// expiryDB returns the keypairs with their account-key assertions
+type expiryDB struct {
+	datastore.MockDB
+	keypairs []datastore.Keypair
+}
+
+func (db *expiryDB) ListAllowedKeypairs(ctx context.Context, authorization datastore.User) ([]datastore.Keypair, error) {
+	return db.keypairs, nil
+}
+
+func TestCheckExpiry(t *testing.T) {
+	var sent []email
+	defer mockSendMail(&sent)()
+
+	now := time.Now().UTC()
+	privKey, _ := assertstest.GenerateKey(752)
+	signingDB := assertstest.NewSigningDB("canonical", privKey)
+	account := assertstest.NewAccount(signingDB, "brand", map[string]interface{}{"account-id": "brand"}, "")
+
+	accountKey := func(until time.Time) string {
+		key, _ := assertstest.GenerateKey(752)
+		headers := map[string]interface{}{"since": now.Add(-time.Hour).Format(time.RFC3339)}
+		if !until.IsZero() {
+			headers["until"] = until.Format(time.RFC3339)
+		}
+		return string(asserts.Encode(assertstest.NewAccountKey(signingDB, account, headers, key.PublicKey(), "")))
+	}
+
+	db := &expiryDB{keypairs: []datastore.Keypair{
+		{ID: 1, AuthorityID: "authority3", KeyID: "expiring", KeyName: "expiring", Active: true, Assertion: accountKey(now.Add(24 * time.Hour))},
+		{ID: 2, AuthorityID: "authority3", KeyID: "later", KeyName: "later", Active: true, Assertion: accountKey(now.Add(90 * 24 * time.Hour))},
+		{ID: 3, AuthorityID: "authority3", KeyID: "forever", KeyName: "forever", Active: true, Assertion: accountKey(time.Time{})},
+		{ID: 4, AuthorityID: "authority3", KeyID: "inactive", KeyName: "inactive", Active: false, Assertion: accountKey(now.Add(time.Hour))},
+		{ID: 5, AuthorityID: "authority3", KeyID: "unregistered", KeyName: "unregistered", Active: true},
+	}}
+
+	settings := smtpSettings
+	settings.NotifyEvents = []string{EventKeyExpiry}
+	cfg, err := NewConfig(settings)
+	if err != nil {
+		t.Fatalf("Error in the config: %v", err)
+	}
+
+	notified, err := CheckExpiry(context.Background(), db, cfg, now)
+	if err != nil {
+		t.Fatalf("Error checking the expiry: %v", err)
+	}
+	if notified != 1 || len(sent) != 2 || !strings.Contains(sent[0].subject, "expiring") {
+		t.Fatalf("Expected the expiring key to be notified, got %d: %v", notified, sent)
+	}
+
+	// The expiry of a key is only notified once
+	if notified, err = CheckExpiry(context.Background(), db, cfg, now.Add(time.Hour)); err != nil || notified != 0 {
+		t.Errorf("Expected no notification, got %d: %v", notified, err)
+	}
+
+	// The keys are not checked when the event is not notified
+	if notified, err = CheckExpiry(context.Background(), db, Config{}, now.Add(60*24*time.Hour)); err != nil || notified != 0 {
+		t.Errorf("Expected no notification, got %d: %v", notified, err)
+	}
+}
@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package retention
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// defaultInterval is the interval of the purge when it is not configured
+const defaultInterval = 24 * time.Hour
+
+// Policy is the retention of the signing logs from the config settings
+type Policy struct {
+	Months   int           // default retention of the signing logs, zero keeps them forever
+	Interval time.Duration // interval between the purges
+}
+
+// NewPolicy creates the retention policy from the config settings
+func NewPolicy(settings config.Settings) (Policy, error) {
+	if settings.SigningLogRetention < 0 {
+		return Policy{}, fmt.Errorf("the signing log retention must not be negative")
+	}
+
+	policy := Policy{Months: settings.SigningLogRetention, Interval: defaultInterval}
+	if len(settings.SigningLogPurgeInterval) == 0 {
+		return policy, nil
+	}
+
+	interval, err := time.ParseDuration(settings.SigningLogPurgeInterval)
+	if err != nil {
+		return Policy{}, fmt.Errorf("invalid signing log purge interval: %v", err)
+	}
+	if interval <= 0 {
+		return Policy{}, fmt.Errorf("the signing log purge interval must be positive")
+	}
+	policy.Interval = interval
+	return policy, nil
+}
+
+// Job runs the maintenance of the signing logs when the scheduler starts, and then at the interval
+// of the policy. The purge runs even without a default retention, as the accounts can override it.
+// The purge holds the lock of the signing logs, so it runs on one instance of the service at a time,
+// and not during the export of the signing logs
+func Job(policy Policy) scheduler.Job {
+	return scheduler.Job{Name: "signinglog-retention", Interval: policy.Interval, RunAtStart: true, Run: func(ctx context.Context) error {
+		db := datastore.Environ.DB
+		_, errPartition := Partition(ctx, db)
+		locked, errPurge := db.RunJobLocked(ctx, datastore.JobLockSigningLog, func(ctx context.Context) error {
+			_, err := Purge(ctx, db, policy)
+			return err
+		})
+		if errPurge == nil && !locked {
+			log.Infof("Signing log purge: skipped, the signing logs are locked by another job")
+		}
+		_, errErrors := PurgeErrors(ctx, db)
+
+		for _, err := range []error{errPartition, errPurge, errErrors} {
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}}
+}
+
+// Partition creates the monthly partitions of the signing logs ahead of time
+func Partition(ctx context.Context, db datastore.Datastore) ([]string, error) {
+	partitions, err := db.CreateSigningLogPartitions(ctx, time.Now().UTC(), datastore.SigningLogPartitionsAhead)
+	if err != nil {
+		log.Errorf("Error creating the signing log partitions: %v", err)
+		return partitions, err
+	}
+
+	for _, p := range partitions {
+		log.Infof("Signing log partition created: %s", p)
+	}
+	return partitions, nil
+}
+
+// Purge deletes the signing logs that are older than their retention
+func Purge(ctx context.Context, db datastore.Datastore, policy Policy) ([]datastore.SigningLogPurge, error) {
+	purges, err := db.PurgeSigningLog(ctx, policy.Months, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error purging the signing logs: %v", err)
+		return purges, err
+	}
+
+	purged := 0
+	for _, p := range purges {
+		purged += p.Purged
+	}
+	log.Infof("Signing log purge: %d signing logs purged for %d brands", purged, len(purges))
+	return purges, nil
+}
+
+// PurgeErrors deletes the failed signing requests that are older than their retention
+func PurgeErrors(ctx context.Context, db datastore.Datastore) (int, error) {
+	purged, err := db.PurgeSigningErrors(ctx, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error purging the signing errors: %v", err)
+		return purged, err
+	}
+
+	log.Infof("Signing error purge: %d signing errors purged", purged)
+	return purged, nil
+}
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package retention
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestNewPolicy(t *testing.T) {
+	tests := []struct {
+		months   int
+		interval string
+		want     Policy
+		valid    bool
+	}{
+		{0, "", Policy{0, defaultInterval}, true},
+		{24, "", Policy{24, defaultInterval}, true},
+		{12, "6h", Policy{12, 6 * time.Hour}, true},
+		{-1, "", Policy{}, false},
+		{12, "invalid", Policy{}, false},
+		{12, "0s", Policy{}, false},
+		{12, "-1h", Policy{}, false},
+	}
+
+	for _, tt := range tests {
+		policy, err := NewPolicy(config.Settings{SigningLogRetention: tt.months, SigningLogPurgeInterval: tt.interval})
+		if (err == nil) != tt.valid {
+			t.Errorf("Retention %d/%q: expected valid %v, got error: %v", tt.months, tt.interval, tt.valid, err)
+			continue
+		}
+		if policy != tt.want {
+			t.Errorf("Retention %d/%q: expected %v, got %v", tt.months, tt.interval, tt.want, policy)
+		}
+	}
+}
+
+func TestPurge(t *testing.T) {
+	purges, err := Purge(context.Background(), &datastore.MockDB{}, Policy{Months: 6, Interval: defaultInterval})
+	if err != nil {
+		t.Fatalf("Purge: unexpected error: %v", err)
+	}
+	if len(purges) != 1 || purges[0].Months != 6 || purges[0].Purged != 5 {
+		t.Errorf("Purge: unexpected purges: %v", purges)
+	}
+
+	if _, err = Purge(context.Background(), &datastore.ErrorMockDB{}, Policy{Months: 6, Interval: defaultInterval}); err == nil {
+		t.Error("Purge: expected an error")
+	}
+}
+
+func TestPartition(t *testing.T) {
+	partitions, err := Partition(context.Background(), &datastore.MockDB{})
+	if err != nil {
+		t.Fatalf("Partition: unexpected error: %v", err)
+	}
+	if len(partitions) != datastore.SigningLogPartitionsAhead+1 {
+		t.Errorf("Partition: unexpected partitions: %v", partitions)
+	}
+
+	if _, err = Partition(context.Background(), &datastore.ErrorMockDB{}); err == nil {
+		t.Error("Partition: expected an error")
+	}
+}
+
+func TestPurgeErrors(t *testing.T) {
+	purged, err := PurgeErrors(context.Background(), &datastore.MockDB{})
+	if err != nil || purged != 3 {
+		t.Errorf("PurgeErrors: expected 3 signing errors purged, got %d: %v", purged, err)
+	}
+
+	if _, err = PurgeErrors(context.Background(), &datastore.ErrorMockDB{}); err == nil {
+		t.Error("PurgeErrors: expected an error")
+	}
+}
@@ -0,0 +1,261 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package scheduler
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+)
+
+// Job is a recurring task of the service, that runs at the interval. The context of a run
+// is cancelled when the scheduler is stopped and the job does not complete in time
+type Job struct {
+	Name       string
+	Interval   time.Duration
+	RunAtStart bool // run the job when the scheduler starts, instead of after the first interval
+	Local      bool // run the job on every instance of the service, without the lock of the job
+	Run        func(ctx context.Context) error
+}
+
+// Locker runs the job holding the lock of the name, so the job does not run at the same time on the
+// other instances of the service. It returns false, without running the job, when the lock is held elsewhere
+type Locker func(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error)
+
+// Status is the state of a job, for the admin API
+type Status struct {
+	Name         string    `json:"name"`
+	Interval     string    `json:"interval"`
+	Running      bool      `json:"running"`
+	Runs         int       `json:"runs"`
+	Failures     int       `json:"failures"`
+	Skipped      int       `json:"skipped"`
+	LastRun      time.Time `json:"lastRun"`
+	LastDuration string    `json:"lastDuration"`
+	LastError    string    `json:"lastError"`
+	NextRun      time.Time `json:"nextRun"`
+}
+
+// entry holds a job and its state
+type entry struct {
+	job    Job
+	status Status
+}
+
+// Scheduler owns the recurring tasks of the service. Each job runs in its own goroutine,
+// so a slow job does not delay the others, and the runs of a job never overlap
+type Scheduler struct {
+	lock    sync.RWMutex
+	jobs    map[string]*entry
+	started bool
+	stop    chan struct{}
+	running sync.WaitGroup
+	ctx     context.Context
+	cancel  context.CancelFunc
+	locker  Locker
+}
+
+// New creates an empty scheduler
+func New() *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{jobs: map[string]*entry{}, stop: make(chan struct{}), ctx: ctx, cancel: cancel}
+}
+
+// Default is the scheduler of the service
+var Default = New()
+
+// SetLocker sets the lock that the runs of the jobs hold, before the scheduler is started
+func (s *Scheduler) SetLocker(locker Locker) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.locker = locker
+}
+
+// Add registers a job, before the scheduler is started
+func (s *Scheduler) Add(job Job) error {
+	if job.Interval <= 0 {
+		return fmt.Errorf("the interval of the job '%s' must be positive", job.Name)
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started {
+		return fmt.Errorf("the scheduler is already started")
+	}
+	if _, ok := s.jobs[job.Name]; ok {
+		return fmt.Errorf("the job '%s' is already scheduled", job.Name)
+	}
+	s.jobs[job.Name] = &entry{job: job, status: Status{Name: job.Name, Interval: job.Interval.String()}}
+	return nil
+}
+
+// Start runs the jobs in the background, at their intervals
+func (s *Scheduler) Start() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.started {
+		return
+	}
+	s.started = true
+
+	now := time.Now().UTC()
+	for _, e := range s.jobs {
+		e.status.NextRun = now
+		if !e.job.RunAtStart {
+			e.status.NextRun = now.Add(e.job.Interval)
+		}
+		s.running.Add(1)
+		go s.loop(e)
+	}
+}
+
+// Stop stops the scheduling of the jobs, and waits for the jobs that are running
+// until the context is done. The jobs that are still running are then cancelled
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.lock.Lock()
+	if !s.started {
+		s.lock.Unlock()
+		return nil
+	}
+	select {
+	case <-s.stop:
+	default:
+		close(s.stop)
+	}
+	s.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		return ctx.Err()
+	}
+}
+
+// Status returns the state of the jobs, ordered by name
+func (s *Scheduler) Status() []Status {
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	statuses := []Status{}
+	for _, e := range s.jobs {
+		statuses = append(statuses, e.status)
+	}
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Name < statuses[j].Name })
+	return statuses
+}
+
+func (s *Scheduler) loop(e *entry) {
+	defer s.running.Done()
+
+	ticker := time.NewTicker(e.job.Interval)
+	defer ticker.Stop()
+
+	if e.job.RunAtStart {
+		s.run(e)
+	}
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.run(e)
+		}
+	}
+}
+
+// run runs the job once, recording the result. A panic of the job is a failure, so the job
+// keeps running at its interval. The run is skipped when another instance holds the lock of the job
+func (s *Scheduler) run(e *entry) {
+	start := time.Now().UTC()
+
+	s.lock.Lock()
+	e.status.Running = true
+	locker := s.locker
+	s.lock.Unlock()
+
+	// Each run is the root of a trace, so the calls of the job are traced
+	ctx, span := tracing.Start(s.ctx, "job "+e.job.Name)
+	var skipped bool
+	err := func() (err error) {
+		defer func() {
+			if p := recover(); p != nil {
+				err = fmt.Errorf("panic: %v", p)
+			}
+		}()
+		if locker == nil || e.job.Local {
+			return e.job.Run(ctx)
+		}
+		locked, err := locker(ctx, "job/"+e.job.Name, e.job.Run)
+		skipped = !locked && err == nil
+		return err
+	}()
+	tracing.End(span, err)
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	e.status.Running = false
+	e.status.NextRun = start.Add(e.job.Interval)
+	if skipped {
+		e.status.Skipped++
+		log.New("scheduler").With("job", e.job.Name).Debugf("Skipped the job, as it is running on another instance")
+		return
+	}
+	e.status.Runs++
+	e.status.LastRun = start
+	e.status.LastDuration = time.Since(start).String()
+	e.status.LastError = ""
+	if err != nil {
+		e.status.Failures++
+		e.status.LastError = err.Error()
+		log.New("scheduler").With("job", e.job.Name).Errorf("Error running the job: %v", err)
+	}
+}
+
+// Add registers a job with the default scheduler
+func Add(job Job) error {
+	return Default.Add(job)
+}
+
+// Start runs the jobs of the default scheduler
+func Start() {
+	Default.Start()
+}
+
+// Stop stops the default scheduler
+func Stop(ctx context.Context) error {
+	return Default.Stop(ctx)
+}
@@ -0,0 +1,195 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// waitFor polls the condition, as the jobs run in the background
+func waitFor(t *testing.T, condition func() bool) {
+	deadline := time.Now().Add(2 * time.Second)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the jobs")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := New()
+
+	var runs int32
+	if err := s.Add(Job{Name: "purge", Interval: 10 * time.Millisecond, RunAtStart: true, Run: func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}}); err != nil {
+		t.Fatalf("Error adding the job: %v", err)
+	}
+	if err := s.Add(Job{Name: "export", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		return errors.New("MOCK bucket not found")
+	}}); err != nil {
+		t.Fatalf("Error adding the job: %v", err)
+	}
+	if err := s.Add(Job{Name: "broken", Interval: 10 * time.Millisecond, Run: func(ctx context.Context) error {
+		panic("nil config")
+	}}); err != nil {
+		t.Fatalf("Error adding the job: %v", err)
+	}
+
+	s.Start()
+	waitFor(t, func() bool {
+		for _, st := range s.Status() {
+			if st.Runs < 2 {
+				return false
+			}
+		}
+		return true
+	})
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Error stopping the scheduler: %v", err)
+	}
+
+	statuses := s.Status()
+	if len(statuses) != 3 || statuses[0].Name != "broken" || statuses[1].Name != "export" || statuses[2].Name != "purge" {
+		t.Fatalf("Expected the jobs ordered by name, got: %v", statuses)
+	}
+	if statuses[0].Failures != statuses[0].Runs || statuses[0].LastError != "panic: nil config" {
+		t.Errorf("Expected the panics to be failures, got: %v", statuses[0])
+	}
+	if statuses[1].Failures != statuses[1].Runs || statuses[1].LastError != "MOCK bucket not found" {
+		t.Errorf("Expected the errors to be failures, got: %v", statuses[1])
+	}
+	if statuses[2].Failures != 0 || len(statuses[2].LastError) > 0 || statuses[2].Interval != "10ms" || statuses[2].LastRun.IsZero() {
+		t.Errorf("Expected successful runs, got: %v", statuses[2])
+	}
+
+	// The jobs do not run after the scheduler is stopped
+	stopped := atomic.LoadInt32(&runs)
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != stopped {
+		t.Error("Expected no runs after the scheduler is stopped")
+	}
+}
+
+func TestSchedulerAddInvalid(t *testing.T) {
+	s := New()
+	noop := func(ctx context.Context) error { return nil }
+
+	if err := s.Add(Job{Name: "zero", Run: noop}); err == nil {
+		t.Error("Expected an error with a zero interval")
+	}
+	if err := s.Add(Job{Name: "purge", Interval: time.Hour, Run: noop}); err != nil {
+		t.Fatalf("Error adding the job: %v", err)
+	}
+	if err := s.Add(Job{Name: "purge", Interval: time.Hour, Run: noop}); err == nil {
+		t.Error("Expected an error adding a job twice")
+	}
+
+	s.Start()
+	defer s.Stop(context.Background())
+	if err := s.Add(Job{Name: "late", Interval: time.Hour, Run: noop}); err == nil {
+		t.Error("Expected an error adding a job to a started scheduler")
+	}
+	if st := s.Status(); len(st) != 1 || st[0].NextRun.IsZero() || st[0].Runs != 0 {
+		t.Errorf("Expected the job to wait for the interval, got: %v", st)
+	}
+}
+
+func TestSchedulerLocker(t *testing.T) {
+	s := New()
+
+	var runs int32
+	names := make(chan string, 10)
+	s.SetLocker(func(ctx context.Context, name string, run func(ctx context.Context) error) (bool, error) {
+		names <- name
+		if name == "job/held" {
+			return false, nil
+		}
+		return true, run(ctx)
+	})
+	run := func(ctx context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		return nil
+	}
+	s.Add(Job{Name: "held", Interval: time.Hour, RunAtStart: true, Run: run})
+	s.Add(Job{Name: "free", Interval: time.Hour, RunAtStart: true, Run: run})
+	s.Add(Job{Name: "local", Interval: time.Hour, RunAtStart: true, Local: true, Run: run})
+
+	s.Start()
+	waitFor(t, func() bool {
+		st := s.Status()
+		return st[0].Runs == 1 && st[1].Skipped == 1 && st[2].Runs == 1
+	})
+	if err := s.Stop(context.Background()); err != nil {
+		t.Fatalf("Error stopping the scheduler: %v", err)
+	}
+
+	st := s.Status()
+	if st[1].Runs != 0 || st[1].NextRun.IsZero() || !st[1].LastRun.IsZero() {
+		t.Errorf("Expected the held job to be skipped, got: %v", st[1])
+	}
+	if atomic.LoadInt32(&runs) != 2 {
+		t.Errorf("Expected 2 runs, got: %d", runs)
+	}
+	close(names)
+	locked := map[string]bool{}
+	for name := range names {
+		locked[name] = true
+	}
+	if len(locked) != 2 || !locked["job/held"] || !locked["job/free"] {
+		t.Errorf("Expected the locks of the shared jobs, got: %v", locked)
+	}
+}
+
+func TestSchedulerStopTimeout(t *testing.T) {
+	s := New()
+	release := make(chan struct{})
+	var cancelled int32
+	s.Add(Job{Name: "slow", Interval: time.Hour, RunAtStart: true, Run: func(ctx context.Context) error {
+		<-release
+		if ctx.Err() == context.Canceled {
+			atomic.StoreInt32(&cancelled, 1)
+		}
+		return nil
+	}})
+	s.Start()
+	waitFor(t, func() bool { return s.Status()[0].Running })
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := s.Stop(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected the deadline to be exceeded, got: %v", err)
+	}
+
+	close(release)
+	if err := s.Stop(context.Background()); err != nil {
+		t.Errorf("Expected the running job to complete, got: %v", err)
+	}
+	if atomic.LoadInt32(&cancelled) != 1 {
+		t.Error("Expected the context of the running job to be cancelled")
+	}
+}
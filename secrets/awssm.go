@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secrets
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsService is the name of AWS Secrets Manager in the signature
+const awsService = "secretsmanager"
+
+// awsResponse is the response of the GetSecretValue action
+type awsResponse struct {
+	SecretString *string `json:"SecretString"`
+}
+
+// awsSecret reads an AWS secret. The secret string is returned when there is no key, else
+// it is parsed as the JSON object of the key/value pairs of the secret
+func (m *Manager) awsSecret(secretID, key string) (string, error) {
+	if len(m.AWSAccessKey) == 0 || len(m.AWSSecretKey) == 0 {
+		return "", errors.New("the AWS credentials are not configured")
+	}
+
+	body, _ := json.Marshal(map[string]string{"SecretId": secretID})
+	r, err := http.NewRequest("POST", m.AWSEndpoint+"/", bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+
+	payload := sha256.Sum256(body)
+	r.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	r.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	if len(m.AWSSessionToken) > 0 {
+		r.Header.Set("X-Amz-Security-Token", m.AWSSessionToken)
+	}
+	m.sign(r, hex.EncodeToString(payload[:]), time.Now().UTC())
+
+	w, err := m.Client.Do(r)
+	if err != nil {
+		return "", fmt.Errorf("error reading the AWS secret %s: %v", secretID, err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(w.Body)
+		return "", fmt.Errorf("error reading the AWS secret %s: %s: %s", secretID, w.Status, strings.TrimSpace(string(message)))
+	}
+
+	result := awsResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing the AWS secret %s: %v", secretID, err)
+	}
+	if result.SecretString == nil {
+		return "", fmt.Errorf("the AWS secret %s is not a string", secretID)
+	}
+	if len(key) == 0 {
+		return *result.SecretString, nil
+	}
+
+	values := map[string]interface{}{}
+	if err := json.Unmarshal([]byte(*result.SecretString), &values); err != nil {
+		return "", fmt.Errorf("the AWS secret %s is not a JSON object: %v", secretID, err)
+	}
+	value, ok := values[key].(string)
+	if !ok {
+		return "", fmt.Errorf("the AWS secret %s has no %s value", secretID, key)
+	}
+	return value, nil
+}
+
+// sign adds the AWS signature version 4 to the request, signing all the headers of the request
+func (m *Manager) sign(r *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.Format("20060102T150405Z")
+	scope := fmt.Sprintf("%s/%s/%s/aws4_request", now.Format("20060102"), m.AWSRegion, awsService)
+
+	r.Header.Set("X-Amz-Date", amzDate)
+
+	headers := map[string]string{"host": r.URL.Host}
+	for name, values := range r.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	canonicalHeaders := ""
+	for _, name := range names {
+		canonicalHeaders += name + ":" + headers[name] + "\n"
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		"/",
+		r.URL.Query().Encode(),
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(hash[:])}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+m.AWSSecretKey), now.Format("20060102"))
+	key = hmacSHA256(key, m.AWSRegion)
+	key = hmacSHA256(key, awsService)
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	r.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		m.AWSAccessKey, scope, signedHeaders, signature))
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
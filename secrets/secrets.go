@@ -0,0 +1,105 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package secrets resolves the references of the sensitive settings to an external secret
+// manager, so they are not stored in the config file. A reference is the secret manager, the
+// path of the secret and the key of its value, e.g. vault:secret/data/serial-vault#jwtSecret
+// for HashiCorp Vault or awssm:serial-vault#jwtSecret for AWS Secrets Manager
+package secrets
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// The prefixes of the references to the secret managers
+const (
+	PrefixVault = "vault:"
+	PrefixAWS   = "awssm:"
+)
+
+// timeout is the timeout of the requests to the secret managers
+const timeout = 30 * time.Second
+
+// Manager fetches the secrets from HashiCorp Vault and AWS Secrets Manager
+type Manager struct {
+	VaultAddress    string
+	VaultToken      string
+	AWSEndpoint     string
+	AWSRegion       string
+	AWSAccessKey    string
+	AWSSecretKey    string
+	AWSSessionToken string
+	Client          *http.Client
+}
+
+// NewManager creates the secret manager client, defaulting the AWS endpoint to the region
+func NewManager(vaultAddress, vaultToken, awsRegion, awsAccessKey, awsSecretKey, awsSessionToken string) *Manager {
+	if len(awsRegion) == 0 {
+		awsRegion = "us-east-1"
+	}
+	return &Manager{
+		VaultAddress:    strings.TrimSuffix(vaultAddress, "/"),
+		VaultToken:      vaultToken,
+		AWSEndpoint:     fmt.Sprintf("https://secretsmanager.%s.amazonaws.com", awsRegion),
+		AWSRegion:       awsRegion,
+		AWSAccessKey:    awsAccessKey,
+		AWSSecretKey:    awsSecretKey,
+		AWSSessionToken: awsSessionToken,
+		Client:          &http.Client{Timeout: timeout},
+	}
+}
+
+// IsReference checks whether a setting is a reference to a secret manager
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, PrefixVault) || strings.HasPrefix(value, PrefixAWS)
+}
+
+// Resolve fetches the secret of a reference. The value of a setting that is not a
+// reference is returned unchanged
+func (m *Manager) Resolve(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, PrefixVault):
+		path, key := splitReference(strings.TrimPrefix(value, PrefixVault))
+		if len(path) == 0 || len(key) == 0 {
+			return "", fmt.Errorf("the Vault reference must be vault:path#key")
+		}
+		return m.vaultSecret(path, key)
+	case strings.HasPrefix(value, PrefixAWS):
+		secretID, key := splitReference(strings.TrimPrefix(value, PrefixAWS))
+		if len(secretID) == 0 {
+			return "", fmt.Errorf("the AWS Secrets Manager reference must be awssm:secret-id or awssm:secret-id#key")
+		}
+		return m.awsSecret(secretID, key)
+	default:
+		return value, nil
+	}
+}
+
+// splitReference splits the path of the secret from the key of the value
+func splitReference(reference string) (string, string) {
+	i := strings.LastIndex(reference, "#")
+	if i < 0 {
+		return reference, ""
+	}
+	return reference[:i], reference[i+1:]
+}
@@ -0,0 +1,159 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secrets
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestResolveVault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Vault-Token") != "vault-token" {
+			http.Error(w, `{"errors":["permission denied"]}`, http.StatusForbidden)
+			return
+		}
+		switch r.URL.Path {
+		case "/v1/secret/data/serial-vault":
+			w.Write([]byte(`{"data":{"data":{"jwtSecret":"kv2-secret"},"metadata":{"version":3}}}`))
+		case "/v1/kv/serial-vault":
+			w.Write([]byte(`{"data":{"jwtSecret":"kv1-secret","retries":3}}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		token    string
+		value    string
+		expected string
+		err      bool
+	}{
+		{"vault-token", "vault:secret/data/serial-vault#jwtSecret", "kv2-secret", false},
+		{"vault-token", "vault:kv/serial-vault#jwtSecret", "kv1-secret", false},
+		{"vault-token", "vault:kv/serial-vault#retries", "", true},
+		{"vault-token", "vault:kv/serial-vault#missing", "", true},
+		{"vault-token", "vault:kv/unknown#jwtSecret", "", true},
+		{"vault-token", "vault:kv/serial-vault", "", true},
+		{"other-token", "vault:kv/serial-vault#jwtSecret", "", true},
+		{"", "vault:kv/serial-vault#jwtSecret", "", true},
+		{"vault-token", "not a reference", "not a reference", false},
+	}
+
+	for _, tt := range tests {
+		m := NewManager(server.URL+"/", tt.token, "", "", "", "")
+		value, err := m.Resolve(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("Resolve(%s): expected error %v, got: %v", tt.value, tt.err, err)
+		}
+		if value != tt.expected {
+			t.Errorf("Resolve(%s): expected %q, got %q", tt.value, tt.expected, value)
+		}
+	}
+}
+
+func TestResolveAWS(t *testing.T) {
+	var authorization, target, token string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization = r.Header.Get("Authorization")
+		target = r.Header.Get("X-Amz-Target")
+		token = r.Header.Get("X-Amz-Security-Token")
+
+		body, _ := ioutil.ReadAll(r.Body)
+		request := map[string]string{}
+		json.Unmarshal(body, &request)
+		switch request["SecretId"] {
+		case "serial-vault":
+			w.Write([]byte(`{"Name":"serial-vault","SecretString":"{\"jwtSecret\":\"aws-secret\"}"}`))
+		case "serial-vault/datasource":
+			w.Write([]byte(`{"Name":"serial-vault/datasource","SecretString":"dbname=serialvault password=pass"}`))
+		case "serial-vault/binary":
+			w.Write([]byte(`{"Name":"serial-vault/binary","SecretBinary":"AAEC"}`))
+		default:
+			http.Error(w, `{"__type":"ResourceNotFoundException"}`, http.StatusBadRequest)
+		}
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		value    string
+		expected string
+		err      bool
+	}{
+		{"awssm:serial-vault#jwtSecret", "aws-secret", false},
+		{"awssm:serial-vault/datasource", "dbname=serialvault password=pass", false},
+		{"awssm:serial-vault#missing", "", true},
+		{"awssm:serial-vault/datasource#jwtSecret", "", true},
+		{"awssm:serial-vault/binary", "", true},
+		{"awssm:unknown", "", true},
+		{"awssm:", "", true},
+	}
+
+	for _, tt := range tests {
+		m := NewManager("", "", "eu-west-1", "access-key", "secret-key", "session-token")
+		m.AWSEndpoint = server.URL
+		value, err := m.Resolve(tt.value)
+		if (err != nil) != tt.err {
+			t.Errorf("Resolve(%s): expected error %v, got: %v", tt.value, tt.err, err)
+		}
+		if value != tt.expected {
+			t.Errorf("Resolve(%s): expected %q, got %q", tt.value, tt.expected, value)
+		}
+	}
+
+	if !strings.HasPrefix(authorization, "AWS4-HMAC-SHA256 Credential=access-key/") || !strings.Contains(authorization, "/eu-west-1/secretsmanager/aws4_request") {
+		t.Errorf("Resolve: unexpected authorization: %q", authorization)
+	}
+	if !strings.Contains(authorization, "x-amz-security-token") || token != "session-token" {
+		t.Errorf("Resolve: expected the signed session token, got: %q", authorization)
+	}
+	if target != "secretsmanager.GetSecretValue" {
+		t.Errorf("Resolve: unexpected target: %q", target)
+	}
+
+	m := NewManager("", "", "", "", "", "")
+	if _, err := m.Resolve("awssm:serial-vault#jwtSecret"); err == nil {
+		t.Error("Resolve: expected an error without the AWS credentials")
+	}
+}
+
+func TestIsReference(t *testing.T) {
+	tests := []struct {
+		value    string
+		expected bool
+	}{
+		{"vault:secret/data/serial-vault#jwtSecret", true},
+		{"awssm:serial-vault", true},
+		{"dbname=serialvault sslmode=disable", false},
+		{"", false},
+	}
+
+	for _, tt := range tests {
+		if got := IsReference(tt.value); got != tt.expected {
+			t.Errorf("IsReference(%s) = %v, expected %v", tt.value, got, tt.expected)
+		}
+	}
+}
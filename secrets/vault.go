@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package secrets
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// vaultResponse is the response of a secret. The data of a KV version 2 secret is
+// nested in the data of the response, with the metadata of the version
+type vaultResponse struct {
+	Data map[string]interface{} `json:"data"`
+}
+
+// vaultSecret reads the value of a key of a Vault secret
+func (m *Manager) vaultSecret(path, key string) (string, error) {
+	if len(m.VaultAddress) == 0 || len(m.VaultToken) == 0 {
+		return "", errors.New("the Vault address and token are not configured")
+	}
+
+	r, err := http.NewRequest("GET", m.VaultAddress+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return "", err
+	}
+	r.Header.Set("X-Vault-Token", m.VaultToken)
+
+	w, err := m.Client.Do(r)
+	if err != nil {
+		return "", fmt.Errorf("error reading the Vault secret %s: %v", path, err)
+	}
+	defer w.Body.Close()
+
+	if w.StatusCode != http.StatusOK {
+		message, _ := ioutil.ReadAll(w.Body)
+		return "", fmt.Errorf("error reading the Vault secret %s: %s: %s", path, w.Status, strings.TrimSpace(string(message)))
+	}
+
+	result := vaultResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		return "", fmt.Errorf("error parsing the Vault secret %s: %v", path, err)
+	}
+
+	data := result.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, ok := data["metadata"]; ok {
+			data = nested
+		}
+	}
+
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("the Vault secret %s has no %s value", path, key)
+	}
+	return value, nil
+}
@@ -0,0 +1,612 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+)
+
+// The actions of the changes of an applied account configuration
+const (
+	ConfigCreate    = "create"
+	ConfigUpdate    = "update"
+	ConfigUnchanged = "unchanged"
+)
+
+// ErrConfigRejected is returned when a change of an account configuration is invalid, so none
+// of the changes is applied
+var ErrConfigRejected = errors.New("the account configuration is invalid, no change has been applied")
+
+// AccountConfig is the declarative configuration of an account, exported and applied as YAML.
+// The signing-keys are references to the keys that have been imported, by their name or key ID,
+// and the API keys and the secrets are never part of it
+type AccountConfig struct {
+	AuthorityID       string           `yaml:"authority-id" json:"authority-id"`
+	ResellerAPI       bool             `yaml:"reseller-api" json:"reseller-api"`
+	DefaultSigningKey string           `yaml:"default-signing-key,omitempty" json:"default-signing-key,omitempty"`
+	Keypairs          []ConfigKeypair  `yaml:"keypairs,omitempty" json:"keypairs,omitempty"`
+	Models            []ConfigModel    `yaml:"models,omitempty" json:"models,omitempty"`
+	Substores         []ConfigSubstore `yaml:"substores,omitempty" json:"substores,omitempty"`
+	Users             []ConfigUser     `yaml:"users,omitempty" json:"users,omitempty"`
+}
+
+// ConfigKeypair is a reference to a signing-key of the account, with its status. The status is
+// left as it is when it is not set
+type ConfigKeypair struct {
+	KeyName string `yaml:"key-name,omitempty" json:"key-name,omitempty"`
+	KeyID   string `yaml:"key-id,omitempty" json:"key-id,omitempty"`
+	Active  *bool  `yaml:"active,omitempty" json:"active,omitempty"`
+}
+
+// ConfigModel is a model of the account, with its signing-keys referenced by name or key ID
+type ConfigModel struct {
+	Name            string `yaml:"name" json:"name"`
+	SigningKey      string `yaml:"signing-key,omitempty" json:"signing-key,omitempty"`
+	SystemUserKey   string `yaml:"system-user-key,omitempty" json:"system-user-key,omitempty"`
+	SerialFormat    string `yaml:"serial-format,omitempty" json:"serial-format,omitempty"`
+	SerialHeaders   string `yaml:"serial-headers,omitempty" json:"serial-headers,omitempty"`
+	SigningHours    string `yaml:"signing-hours,omitempty" json:"signing-hours,omitempty"`
+	SigningTimezone string `yaml:"signing-timezone,omitempty" json:"signing-timezone,omitempty"`
+	AllowedCIDRs    string `yaml:"allowed-cidrs,omitempty" json:"allowed-cidrs,omitempty"`
+}
+
+// ConfigSubstore is a sub-store model of the account, keyed by its model and serial number
+type ConfigSubstore struct {
+	FromModel       string `yaml:"from-model" json:"from-model"`
+	SerialNumber    string `yaml:"serial-number" json:"serial-number"`
+	MatchType       string `yaml:"match-type,omitempty" json:"match-type,omitempty"`
+	SerialNumberEnd string `yaml:"serial-number-end,omitempty" json:"serial-number-end,omitempty"`
+	Store           string `yaml:"store" json:"store"`
+	ModelName       string `yaml:"model-name" json:"model-name"`
+	OriginalHeaders bool   `yaml:"original-headers,omitempty" json:"original-headers,omitempty"`
+}
+
+// ConfigUser is a user of the account, with the role of the user for the account. The name and
+// the email are only used to create the user, as a user is shared by its accounts
+type ConfigUser struct {
+	Username string `yaml:"username" json:"username"`
+	Name     string `yaml:"name,omitempty" json:"name,omitempty"`
+	Email    string `yaml:"email,omitempty" json:"email,omitempty"`
+	Role     string `yaml:"role" json:"role"`
+}
+
+// ConfigChange is the change of a record to apply an account configuration. The kind is the
+// audited object of the record
+type ConfigChange struct {
+	Kind   string `json:"kind"`
+	Name   string `json:"name"`
+	Action string `json:"action"`
+	Error  string `json:"error,omitempty"`
+
+	apply func(ctx context.Context, s *configState) error
+}
+
+// configState holds the records of the account that are compared with the configuration
+type configState struct {
+	account   datastore.Account
+	user      datastore.User
+	keypairs  []datastore.Keypair
+	models    map[string]datastore.Model
+	substores map[string]datastore.Substore
+	users     map[string]datastore.User
+}
+
+// loadConfigState reads the records of the account that the user is allowed to see
+func loadConfigState(ctx context.Context, acc datastore.Account, user datastore.User) (*configState, error) {
+	s := &configState{account: acc, user: user, models: map[string]datastore.Model{},
+		substores: map[string]datastore.Substore{}, users: map[string]datastore.User{}}
+
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+	for _, k := range keypairs {
+		if k.AuthorityID == acc.AuthorityID {
+			s.keypairs = append(s.keypairs, k)
+		}
+	}
+
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, user, datastore.ModelFilter{BrandID: acc.AuthorityID})
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range models {
+		if m.BrandID == acc.AuthorityID {
+			s.models[m.Name] = m
+		}
+	}
+
+	substores, err := datastore.Environ.DB.ListSubstores(ctx, acc.ID, user, datastore.SubstoreFilter{})
+	if err != nil {
+		return nil, err
+	}
+	for _, sub := range substores {
+		// The sub-stores of the models that the user cannot see are left out
+		if name := s.modelName(sub.FromModelID); len(name) > 0 {
+			s.substores[substoreKey(name, sub.SerialNumber)] = sub
+		}
+	}
+
+	users, err := datastore.Environ.DB.ListAccountUsers(ctx, acc.AuthorityID)
+	if err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		if full, err := datastore.Environ.DB.GetUserByUsername(ctx, u.Username); err == nil {
+			u = full
+		}
+		s.users[u.Username] = u
+	}
+	return s, nil
+}
+
+// exportConfig returns the configuration of the account, sorted so the exports can be compared
+func exportConfig(ctx context.Context, acc datastore.Account, user datastore.User) (AccountConfig, error) {
+	s, err := loadConfigState(ctx, acc, user)
+	if err != nil {
+		return AccountConfig{}, err
+	}
+
+	cfg := AccountConfig{AuthorityID: acc.AuthorityID, ResellerAPI: acc.ResellerAPI, DefaultSigningKey: s.keyReference(acc.DefaultKeypairID)}
+
+	for _, k := range s.keypairs {
+		active := k.Active
+		cfg.Keypairs = append(cfg.Keypairs, ConfigKeypair{KeyName: k.KeyName, KeyID: k.KeyID, Active: &active})
+	}
+
+	for _, m := range s.models {
+		cfg.Models = append(cfg.Models, ConfigModel{Name: m.Name, SigningKey: s.keyReference(m.KeypairID), SystemUserKey: s.keyReference(m.KeypairIDUser),
+			SerialFormat: m.SerialFormat, SerialHeaders: m.SerialHeaders, SigningHours: m.SigningHours, SigningTimezone: m.SigningTimezone, AllowedCIDRs: m.AllowedCIDRs})
+	}
+	sort.Slice(cfg.Models, func(i, j int) bool { return cfg.Models[i].Name < cfg.Models[j].Name })
+
+	for _, sub := range s.substores {
+		cfg.Substores = append(cfg.Substores, configSubstore(s.modelName(sub.FromModelID), sub))
+	}
+	sort.Slice(cfg.Substores, func(i, j int) bool {
+		return substoreKey(cfg.Substores[i].FromModel, cfg.Substores[i].SerialNumber) < substoreKey(cfg.Substores[j].FromModel, cfg.Substores[j].SerialNumber)
+	})
+
+	for _, u := range s.users {
+		cfg.Users = append(cfg.Users, ConfigUser{Username: u.Username, Name: u.Name, Email: u.Email, Role: datastore.RoleName[s.accountRole(u)]})
+	}
+	sort.Slice(cfg.Users, func(i, j int) bool { return cfg.Users[i].Username < cfg.Users[j].Username })
+
+	return cfg, nil
+}
+
+// planConfig compares the configuration with the records of the account, and returns the changes
+// to apply it. Nothing is deleted: the records that are not in the configuration are kept
+func planConfig(ctx context.Context, s *configState, cfg AccountConfig) ([]ConfigChange, error) {
+	if cfg.AuthorityID != s.account.AuthorityID {
+		return nil, fmt.Errorf("the configuration is for the account %q, not %q", cfg.AuthorityID, s.account.AuthorityID)
+	}
+
+	changes := []ConfigChange{s.planAccount(cfg)}
+	for _, k := range cfg.Keypairs {
+		changes = append(changes, s.planKeypair(k))
+	}
+
+	seen := map[string]bool{}
+	for _, m := range cfg.Models {
+		c := s.planModel(m)
+		if seen[m.Name] {
+			c.Error = "the model is in the configuration more than once"
+		}
+		seen[m.Name] = true
+		changes = append(changes, c)
+	}
+
+	seenSubstores := map[string]bool{}
+	for _, sub := range cfg.Substores {
+		c := s.planSubstore(sub, seen)
+		key := substoreKey(sub.FromModel, sub.SerialNumber)
+		if seenSubstores[key] {
+			c.Error = "the sub-store model is in the configuration more than once"
+		}
+		seenSubstores[key] = true
+		changes = append(changes, c)
+	}
+
+	seenUsers := map[string]bool{}
+	for _, u := range cfg.Users {
+		c := s.planUser(ctx, u)
+		if seenUsers[u.Username] {
+			c.Error = "the user is in the configuration more than once"
+		}
+		seenUsers[u.Username] = true
+		changes = append(changes, c)
+	}
+
+	for _, c := range changes {
+		if len(c.Error) > 0 {
+			return changes, ErrConfigRejected
+		}
+	}
+	return changes, nil
+}
+
+// applyConfig applies the changes in order, as the sub-stores need the models that are created
+// before them. It stops at the first change that fails, and returns the changes with its error
+func applyConfig(ctx context.Context, s *configState, changes []ConfigChange) ([]ConfigChange, error) {
+	for i := range changes {
+		if changes[i].apply == nil {
+			continue
+		}
+		if err := changes[i].apply(ctx, s); err != nil {
+			changes[i].Error = err.Error()
+			return changes, fmt.Errorf("error applying the %s %s: %v", changes[i].Kind, changes[i].Name, err)
+		}
+	}
+	return changes, nil
+}
+
+func (s *configState) planAccount(cfg AccountConfig) ConfigChange {
+	c := ConfigChange{Kind: audit.ObjectAccount, Name: s.account.AuthorityID, Action: ConfigUnchanged}
+
+	defaultKeypairID := 0
+	if len(cfg.DefaultSigningKey) > 0 {
+		k, ok := s.findKeypair(cfg.DefaultSigningKey)
+		if !ok {
+			c.Error = fmt.Sprintf("cannot find the default signing-key %s of the account", cfg.DefaultSigningKey)
+			return c
+		}
+		defaultKeypairID = k.ID
+	}
+
+	if cfg.ResellerAPI == s.account.ResellerAPI && defaultKeypairID == s.account.DefaultKeypairID {
+		return c
+	}
+	c.Action = ConfigUpdate
+	c.apply = func(ctx context.Context, s *configState) error {
+		before := s.account
+		after := s.account
+		after.ResellerAPI = cfg.ResellerAPI
+		after.DefaultKeypairID = defaultKeypairID
+
+		if after.ResellerAPI != before.ResellerAPI {
+			if err := datastore.Environ.DB.UpdateAccount(ctx, after, s.user); err != nil {
+				return err
+			}
+		}
+		if after.DefaultKeypairID != before.DefaultKeypairID {
+			if err := datastore.Environ.DB.UpdateAllowedAccountDefaultKeypair(ctx, after.ID, after.DefaultKeypairID, s.user); err != nil {
+				return err
+			}
+		}
+		audit.Record(ctx, s.user, audit.ActionUpdate, audit.ObjectAccount, after.ID, after.AuthorityID, before, after)
+		return nil
+	}
+	return c
+}
+
+func (s *configState) planKeypair(ck ConfigKeypair) ConfigChange {
+	reference := ck.KeyName
+	if len(reference) == 0 {
+		reference = ck.KeyID
+	}
+	c := ConfigChange{Kind: audit.ObjectKeypair, Name: reference, Action: ConfigUnchanged}
+
+	k, ok := s.findKeypair(reference)
+	if !ok {
+		c.Error = "cannot find the signing-key of the account, it must be imported first"
+		return c
+	}
+	if ck.Active == nil || *ck.Active == k.Active {
+		return c
+	}
+
+	c.Action = ConfigUpdate
+	active := *ck.Active
+	c.apply = func(ctx context.Context, s *configState) error {
+		if err := datastore.Environ.DB.UpdateAllowedKeypairActive(ctx, k.ID, active, s.user); err != nil {
+			return err
+		}
+		action := audit.ActionDisable
+		if active {
+			action = audit.ActionEnable
+		}
+		audit.Record(ctx, s.user, action, audit.ObjectKeypair, k.ID, k.AuthorityID, nil, nil)
+		return nil
+	}
+	return c
+}
+
+func (s *configState) planModel(cm ConfigModel) ConfigChange {
+	c := ConfigChange{Kind: audit.ObjectModel, Name: cm.Name, Action: ConfigUnchanged}
+
+	keypairID, keypairIDUser, err := s.modelKeypairs(cm)
+	if err != nil {
+		c.Error = err.Error()
+		return c
+	}
+
+	existing, ok := s.models[cm.Name]
+	if !ok {
+		c.Action = ConfigCreate
+		c.apply = func(ctx context.Context, s *configState) error {
+			m := datastore.Model{BrandID: s.account.AuthorityID, Name: cm.Name, KeypairID: keypairID, KeypairIDUser: keypairIDUser}
+			setModelConfig(&m, cm)
+
+			m, _, err := datastore.Environ.DB.CreateAllowedModel(ctx, m, s.user)
+			if err != nil {
+				return err
+			}
+			s.models[m.Name] = m
+			audit.Record(ctx, s.user, audit.ActionCreate, audit.ObjectModel, m.ID, m.BrandID, nil, m)
+			return nil
+		}
+		return c
+	}
+
+	// The signing-key of a model is only changed when it is set, as a new model gets the
+	// default signing-key of the account
+	if keypairID == 0 {
+		keypairID = existing.KeypairID
+	}
+	if keypairID == existing.KeypairID && keypairIDUser == existing.KeypairIDUser && cm.SerialFormat == existing.SerialFormat &&
+		cm.SerialHeaders == existing.SerialHeaders && cm.SigningHours == existing.SigningHours &&
+		cm.SigningTimezone == existing.SigningTimezone && cm.AllowedCIDRs == existing.AllowedCIDRs {
+		return c
+	}
+
+	c.Action = ConfigUpdate
+	c.apply = func(ctx context.Context, s *configState) error {
+		before, err := datastore.Environ.DB.GetAllowedModel(ctx, existing.ID, s.user)
+		if err != nil {
+			return err
+		}
+		m := before
+		m.KeypairID = keypairID
+		m.KeypairIDUser = keypairIDUser
+		setModelConfig(&m, cm)
+
+		if _, err := datastore.Environ.DB.UpdateAllowedModel(ctx, m, s.user); err != nil {
+			return err
+		}
+		audit.Record(ctx, s.user, audit.ActionUpdate, audit.ObjectModel, m.ID, m.BrandID, before, m)
+		return nil
+	}
+	return c
+}
+
+func (s *configState) planSubstore(cs ConfigSubstore, models map[string]bool) ConfigChange {
+	c := ConfigChange{Kind: audit.ObjectSubstore, Name: substoreKey(cs.FromModel, cs.SerialNumber), Action: ConfigUnchanged}
+
+	if _, ok := s.models[cs.FromModel]; !ok && !models[cs.FromModel] {
+		c.Error = fmt.Sprintf("cannot find the model %s of the account", cs.FromModel)
+		return c
+	}
+
+	existing, ok := s.substores[c.Name]
+	if !ok {
+		c.Action = ConfigCreate
+		c.apply = func(ctx context.Context, s *configState) error {
+			sub := datastore.Substore{AccountID: s.account.ID, FromModelID: s.models[cs.FromModel].ID}
+			setSubstoreConfig(&sub, cs)
+
+			sub, err := datastore.Environ.DB.CreateAllowedSubstore(ctx, sub, s.user)
+			if err != nil {
+				return err
+			}
+			audit.Record(ctx, s.user, audit.ActionCreate, audit.ObjectSubstore, sub.ID, s.account.AuthorityID, nil, sub)
+			return nil
+		}
+		return c
+	}
+
+	normalized := cs
+	if normalized.MatchType == datastore.SubstoreMatchExact {
+		normalized.MatchType = ""
+	}
+	if configSubstore(cs.FromModel, existing) == normalized {
+		return c
+	}
+
+	c.Action = ConfigUpdate
+	c.apply = func(ctx context.Context, s *configState) error {
+		sub := existing
+		setSubstoreConfig(&sub, cs)
+
+		if err := datastore.Environ.DB.UpdateAllowedSubstore(ctx, sub, s.user); err != nil {
+			return err
+		}
+		audit.Record(ctx, s.user, audit.ActionUpdate, audit.ObjectSubstore, sub.ID, s.account.AuthorityID, existing, sub)
+		return nil
+	}
+	return c
+}
+
+func (s *configState) planUser(ctx context.Context, cu ConfigUser) ConfigChange {
+	c := ConfigChange{Kind: audit.ObjectUser, Name: cu.Username, Action: ConfigUnchanged}
+
+	role, ok := datastore.RoleID[cu.Role]
+	if !ok || role == 0 {
+		c.Error = fmt.Sprintf("invalid role %q of the user", cu.Role)
+		return c
+	}
+
+	existing, linked := s.users[cu.Username]
+	if linked && s.accountRole(existing) == role {
+		return c
+	}
+
+	// The users are shared by the accounts, so they are managed by a superuser
+	if s.user.Role != datastore.Invalid && s.user.Role != datastore.Superuser {
+		c.Error = "only a superuser can change the users of the account"
+		return c
+	}
+
+	if !linked {
+		u, err := datastore.Environ.DB.GetUserByUsername(ctx, cu.Username)
+		if err != nil {
+			return s.planNewUser(c, cu, role)
+		}
+		existing = u
+	}
+
+	c.Action = ConfigUpdate
+	c.apply = func(ctx context.Context, s *configState) error {
+		u := existing
+		if !userInAccount(u, s.account.AuthorityID) {
+			u.Accounts = append(u.Accounts, s.account)
+		}
+
+		// The role of the user is overridden for the account when it is not the role of the user
+		roles := map[string]int{}
+		for a, r := range u.AccountRoles {
+			roles[a] = r
+		}
+		delete(roles, s.account.AuthorityID)
+		if role != u.Role {
+			roles[s.account.AuthorityID] = role
+		}
+		u.AccountRoles = roles
+
+		if err := datastore.Environ.DB.UpdateUser(ctx, u); err != nil {
+			return err
+		}
+		audit.Record(ctx, s.user, audit.ActionUpdate, audit.ObjectUser, u.ID, s.account.AuthorityID, existing, u)
+		return nil
+	}
+	return c
+}
+
+// planNewUser creates a user with the role of the account, and no other account
+func (s *configState) planNewUser(c ConfigChange, cu ConfigUser, role int) ConfigChange {
+	c.Action = ConfigCreate
+	c.apply = func(ctx context.Context, s *configState) error {
+		u := datastore.User{Username: cu.Username, Name: cu.Name, Email: cu.Email, Role: role, Accounts: []datastore.Account{s.account}}
+
+		var err error
+		if u.ID, err = datastore.Environ.DB.CreateUser(ctx, u); err != nil {
+			return err
+		}
+		audit.Record(ctx, s.user, audit.ActionCreate, audit.ObjectUser, u.ID, s.account.AuthorityID, nil, u)
+		return nil
+	}
+	return c
+}
+
+// modelKeypairs returns the IDs of the signing-key and the system-user key of the model
+func (s *configState) modelKeypairs(cm ConfigModel) (int, int, error) {
+	ids := []int{0, 0}
+	for i, reference := range []string{cm.SigningKey, cm.SystemUserKey} {
+		if len(reference) == 0 {
+			continue
+		}
+		k, ok := s.findKeypair(reference)
+		if !ok {
+			return 0, 0, fmt.Errorf("cannot find the signing-key %s of the account", reference)
+		}
+		ids[i] = k.ID
+	}
+	return ids[0], ids[1], nil
+}
+
+// findKeypair returns the signing-key of the account by its name or its key ID
+func (s *configState) findKeypair(reference string) (datastore.Keypair, bool) {
+	for _, k := range s.keypairs {
+		if (len(k.KeyName) > 0 && k.KeyName == reference) || k.KeyID == reference {
+			return k, true
+		}
+	}
+	return datastore.Keypair{}, false
+}
+
+// keyReference returns the name of a signing-key of the account, or its key ID when it has no name
+func (s *configState) keyReference(keypairID int) string {
+	if keypairID == 0 {
+		return ""
+	}
+	for _, k := range s.keypairs {
+		if k.ID == keypairID {
+			if len(k.KeyName) > 0 {
+				return k.KeyName
+			}
+			return k.KeyID
+		}
+	}
+	return ""
+}
+
+func (s *configState) modelName(modelID int) string {
+	for _, m := range s.models {
+		if m.ID == modelID {
+			return m.Name
+		}
+	}
+	return ""
+}
+
+// accountRole returns the role of the user for the account
+func (s *configState) accountRole(u datastore.User) int {
+	if role, ok := u.AccountRoles[s.account.AuthorityID]; ok {
+		return role
+	}
+	return u.Role
+}
+
+func userInAccount(u datastore.User, authorityID string) bool {
+	for _, a := range u.Accounts {
+		if a.AuthorityID == authorityID {
+			return true
+		}
+	}
+	return false
+}
+
+func substoreKey(fromModel, serialNumber string) string {
+	return fromModel + "/" + serialNumber
+}
+
+// configSubstore returns the configuration of a sub-store model, where the exact match is the default
+func configSubstore(fromModel string, sub datastore.Substore) ConfigSubstore {
+	cs := ConfigSubstore{FromModel: fromModel, SerialNumber: sub.SerialNumber, MatchType: sub.MatchType, SerialNumberEnd: sub.SerialNumberEnd,
+		Store: sub.Store, ModelName: sub.ModelName, OriginalHeaders: sub.OriginalHeaders}
+	if cs.MatchType == datastore.SubstoreMatchExact {
+		cs.MatchType = ""
+	}
+	return cs
+}
+
+func setSubstoreConfig(sub *datastore.Substore, cs ConfigSubstore) {
+	sub.SerialNumber = cs.SerialNumber
+	sub.MatchType = cs.MatchType
+	sub.SerialNumberEnd = cs.SerialNumberEnd
+	sub.Store = cs.Store
+	sub.ModelName = cs.ModelName
+	sub.OriginalHeaders = cs.OriginalHeaders
+}
+
+func setModelConfig(m *datastore.Model, cm ConfigModel) {
+	m.SerialFormat = cm.SerialFormat
+	m.SerialHeaders = cm.SerialHeaders
+	m.SigningHours = cm.SigningHours
+	m.SigningTimezone = cm.SigningTimezone
+	m.AllowedCIDRs = cm.AllowedCIDRs
+}
@@ -20,6 +20,7 @@
 package account
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -28,6 +29,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/snapcore/snapd/asserts"
@@ -52,7 +54,7 @@ type GetResponse struct {
 }
 
 // listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -61,7 +63,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	accounts, err := datastore.Environ.DB.ListAllowedAccounts(user)
+	accounts, err := datastore.Environ.DB.ListAllowedAccounts(ctx, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-models", "", err.Error(), w)
 		return
@@ -72,7 +74,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 	formatListResponse(accounts, w)
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
+func createHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -81,11 +83,12 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acc
 		return
 	}
 
-	err = datastore.Environ.DB.CreateAccount(acct)
+	err = datastore.Environ.DB.CreateAccount(ctx, acct)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-creating-account", "", "Error creating the account in the database", w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectAccount, acct.ID, acct.AuthorityID, nil, acct)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
@@ -93,7 +96,7 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acc
 }
 
 // getHandler is the API method to fetch the accounts
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -102,18 +105,19 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accoun
 		return
 	}
 
-	account, err := datastore.Environ.DB.GetAccountByID(accountID, user)
+	account, err := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-account", "", err.Error(), w)
 		return
 	}
 
 	// Return successful JSON response with the list of models
+	response.SetVersion(w, account.Version)
 	w.WriteHeader(http.StatusOK)
 	formatGetResponse(account, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, acct datastore.Account) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -122,24 +126,114 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, acc
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateAccount(acct, user)
+	// The update must be based on a version of the account, so a concurrent change is not overwritten
+	if acct.Version <= 0 {
+		response.FormatErrorResponse(response.ErrorVersionRequired, w)
+		return
+	}
+
+	// The account before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetAccountByID(ctx, acct.ID, user)
+
+	err = datastore.Environ.DB.UpdateAccount(ctx, acct, user)
+	if err == datastore.ErrVersionConflict {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
 	if err != nil {
-		log.Println("Error updating the account:", err)
+		log.Errorf("Error updating the account: %v", err)
 		response.FormatStandardResponse(false, "error-account", "", "Error updating the model", w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, acct.ID, acct.AuthorityID, before, acct)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
+// putHandler creates or updates the account of the authority ID, so a script does not have to find
+// the ID of the account first. An account that matches the request is not changed, and the hashing
+// of the serial numbers is only set when the account is created, as it is enabled by hash-serial
+func putHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, acct datastore.Account) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(acct.AuthorityID) > 0 && acct.AuthorityID != authorityID {
+		response.FormatStandardResponse(false, "error-account-data", "", "The authority IDs do not match", w)
+		return
+	}
+	acct.AuthorityID = authorityID
+
+	existing, err := datastore.Environ.DB.GetAccount(ctx, authorityID)
+	if err != nil {
+		err = datastore.Environ.DB.CreateAccount(ctx, acct)
+		if err == nil {
+			if created, err := datastore.Environ.DB.GetAccount(ctx, authorityID); err == nil {
+				acct = created
+			}
+			audit.Record(ctx, user, audit.ActionCreate, audit.ObjectAccount, acct.ID, acct.AuthorityID, nil, acct)
+
+			response.SetVersion(w, acct.Version)
+			w.WriteHeader(http.StatusCreated)
+			formatGetResponse(acct, w)
+			return
+		}
+
+		// The account may have been created by a concurrent request
+		if existing, err = datastore.Environ.DB.GetAccount(ctx, authorityID); err != nil {
+			response.FormatStandardResponse(false, "error-creating-account", "", "Error creating the account in the database", w)
+			return
+		}
+	}
+
+	// The version is only checked when the request is based on one
+	if acct.Version > 0 && acct.Version != existing.Version {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
+
+	after := existing
+	after.ResellerAPI = acct.ResellerAPI
+	if len(acct.Assertion) > 0 {
+		after.Assertion = acct.Assertion
+	}
+	if after == existing {
+		response.SetVersion(w, existing.Version)
+		w.WriteHeader(http.StatusOK)
+		formatGetResponse(existing, w)
+		return
+	}
+
+	err = datastore.Environ.DB.UpdateAccount(ctx, after, user)
+	if err == datastore.ErrVersionConflict {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
+	if err != nil {
+		log.Errorf("Error updating the account: %v", err)
+		response.FormatStandardResponse(false, "error-account", "", "Error updating the account", w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, after.ID, after.AuthorityID, existing, after)
+
+	after.Version++
+	response.SetVersion(w, after.Version)
+	w.WriteHeader(http.StatusOK)
+	formatGetResponse(after, w)
+}
+
+func uploadHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
 	if err != nil {
-		log.Println("Error checking user permissions:", err)
+		log.Errorf("Error checking user permissions: %v", err)
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
@@ -169,11 +263,12 @@ func uploadHandler(w http.ResponseWriter, user datastore.User, apiCall bool, ass
 		Assertion:   string(decodedAssertion),
 	}
 
-	errorCode, err := datastore.Environ.DB.PutAccount(account, user)
+	errorCode, err := datastore.Environ.DB.PutAccount(ctx, account, user)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, 0, account.AuthorityID, nil, account)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
@@ -185,7 +280,7 @@ func formatListResponse(accounts []datastore.Account, w http.ResponseWriter) err
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the accounts response.")
+		log.Error("Error forming the accounts response.")
 		return err
 	}
 	return nil
@@ -196,7 +291,7 @@ func formatGetResponse(account datastore.Account, w http.ResponseWriter) error {
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the account response.")
+		log.Error("Error forming the account response.")
 		return err
 	}
 	return nil
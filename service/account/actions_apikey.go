@@ -0,0 +1,201 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// APIKeysResponse is the JSON response from the API account API keys list method
+type APIKeysResponse struct {
+	Success      bool               `json:"success"`
+	ErrorCode    string             `json:"error_code"`
+	ErrorSubcode string             `json:"error_subcode"`
+	ErrorMessage string             `json:"message"`
+	APIKeys      []datastore.APIKey `json:"apiKeys"`
+}
+
+// APIKeyResponse is the JSON response from the API account API key creation method
+type APIKeyResponse struct {
+	Success      bool             `json:"success"`
+	ErrorCode    string           `json:"error_code"`
+	ErrorSubcode string           `json:"error_subcode"`
+	ErrorMessage string           `json:"message"`
+	APIKey       datastore.APIKey `json:"apiKey"`
+}
+
+func apiKeyListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	keys, err := datastore.Environ.DB.ListAllowedAPIKeys(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account API keys: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeAPIKeyResponse(APIKeysResponse{Success: true, APIKeys: keys}, w)
+}
+
+func apiKeyCreateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, k datastore.APIKey) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	k, err = datastore.Environ.DB.CreateAllowedAPIKey(ctx, k, user)
+	if err != nil {
+		log.Errorf("Error creating the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, k.AccountID, user)
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectAPIKey, k.ID, acc.AuthorityID, nil, k)
+
+	// The response includes the key, which is generated when it is not supplied
+	w.WriteHeader(http.StatusOK)
+	encodeAPIKeyResponse(APIKeyResponse{Success: true, APIKey: k}, w)
+}
+
+func apiKeyUpdateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, k datastore.APIKey) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The API key before the change, for the audit log
+	before, err := datastore.Environ.DB.GetAllowedAPIKey(ctx, k.ID, k.AccountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	err = datastore.Environ.DB.UpdateAllowedAPIKey(ctx, k, user)
+	if err != nil {
+		log.Errorf("Error updating the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, k.AccountID, user)
+	action := audit.ActionUpdate
+	if before.Active != k.Active {
+		action = audit.ActionDisable
+		if k.Active {
+			action = audit.ActionEnable
+		}
+	}
+	audit.Record(ctx, user, action, audit.ObjectAPIKey, k.ID, acc.AuthorityID, before, k)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func apiKeyRotateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keyID, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	grace, err := datastore.APIKeyGrace(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error rotating the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	k, err := datastore.Environ.DB.RotateAllowedAPIKey(ctx, keyID, accountID, grace, user)
+	if err != nil {
+		log.Errorf("Error rotating the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionRotate, audit.ObjectAPIKey, k.ID, acc.AuthorityID, nil, k)
+
+	// The response includes the new key and the expiry of the previous key
+	w.WriteHeader(http.StatusOK)
+	encodeAPIKeyResponse(APIKeyResponse{Success: true, APIKey: k}, w)
+}
+
+func apiKeyDeleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keyID, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	before, err := datastore.Environ.DB.GetAllowedAPIKey(ctx, keyID, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	err = datastore.Environ.DB.DeleteAllowedAPIKey(ctx, keyID, accountID, user)
+	if err != nil {
+		log.Errorf("Error deleting the account API key: %v", err)
+		response.FormatStandardResponse(false, "error-apikey", "", err.Error(), w)
+		return
+	}
+
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectAPIKey, keyID, acc.AuthorityID, before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func encodeAPIKeyResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account API key response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ArchiveResponse is the JSON response from the API account deletion method
+type ArchiveResponse struct {
+	Success      bool                     `json:"success"`
+	ErrorCode    string                   `json:"error_code"`
+	ErrorSubcode string                   `json:"error_subcode"`
+	ErrorMessage string                   `json:"message"`
+	Archive      datastore.AccountArchive `json:"archive"`
+}
+
+// ArchiveListResponse is the JSON response from the API account archives method
+type ArchiveListResponse struct {
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code"`
+	ErrorSubcode string                     `json:"error_subcode"`
+	ErrorMessage string                     `json:"message"`
+	Archives     []datastore.AccountArchive `json:"archives"`
+}
+
+// deleteHandler archives the account and deletes it, with its models and signing logs
+func deleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, confirm string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	acc, err := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-get-account", "", err.Error(), w)
+		return
+	}
+
+	// The deletion cannot be undone, so the account is named to confirm it
+	if confirm != acc.AuthorityID {
+		response.FormatStandardResponse(false, "error-delete-account", "", fmt.Sprintf("Confirm the deletion with the authority ID of the account: %s", acc.AuthorityID), w)
+		return
+	}
+
+	// The audit log is recorded in the transaction of the deletion
+	entry := audit.Entry(user, audit.ActionDelete, audit.ObjectAccount, accountID, acc.AuthorityID, acc, nil)
+
+	archive, err := datastore.Environ.DB.DeleteAccount(ctx, accountID, entry)
+	if err != nil {
+		log.Error("error-delete-account", err)
+		response.FormatStandardResponse(false, "error-delete-account", "", err.Error(), w)
+		return
+	}
+	log.Infof("Account '%s' deleted by %s, archive %d", archive.AuthorityID, user.Username, archive.ID)
+
+	w.WriteHeader(http.StatusOK)
+	formatArchiveResponse(ArchiveResponse{Success: true, Archive: archive}, w)
+}
+
+func archiveListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	archives, err := datastore.Environ.DB.ListAccountArchives(ctx)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-account-archives", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatArchiveResponse(ArchiveListResponse{Success: true, Archives: archives}, w)
+}
+
+// archiveDownloadHandler returns the gzipped JSON export bundle of a deleted account
+func archiveDownloadHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, archiveID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	authorityID, bundle, err := datastore.Environ.DB.GetAccountArchiveBundle(ctx, archiveID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-account-archive", "", err.Error(), w)
+		return
+	}
+
+	filename := fmt.Sprintf("account-%s-%d.json.gz", authorityID, archiveID)
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(bundle)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(bundle); err != nil {
+		log.Errorf("Error writing the account archive: %v", err)
+	}
+}
+
+func formatArchiveResponse(result interface{}, w http.ResponseWriter) {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("Error forming the account archive response.")
+	}
+}
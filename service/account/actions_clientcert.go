@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ClientCertsResponse is the JSON response from the API account client certificates list method
+type ClientCertsResponse struct {
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	ClientCerts  []datastore.ClientCert `json:"clientCerts"`
+}
+
+// ClientCertResponse is the JSON response from the API account client certificate creation method
+type ClientCertResponse struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	ClientCert   datastore.ClientCert `json:"clientCert"`
+}
+
+func clientCertListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	certs, err := datastore.Environ.DB.ListAllowedClientCerts(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account client certificates: %v", err)
+		response.FormatStandardResponse(false, "error-clientcert", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeClientCertResponse(ClientCertsResponse{Success: true, ClientCerts: certs}, w)
+}
+
+func clientCertCreateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, c datastore.ClientCert) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	c, err = datastore.Environ.DB.CreateAllowedClientCert(ctx, c, user)
+	if err != nil {
+		log.Errorf("Error creating the account client certificate: %v", err)
+		response.FormatStandardResponse(false, "error-clientcert", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, c.AccountID, user)
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectClientCert, c.ID, acc.AuthorityID, nil, c)
+
+	w.WriteHeader(http.StatusOK)
+	encodeClientCertResponse(ClientCertResponse{Success: true, ClientCert: c}, w)
+}
+
+func clientCertDeleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, certID, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	before, err := datastore.Environ.DB.GetAllowedClientCert(ctx, certID, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account client certificate: %v", err)
+		response.FormatStandardResponse(false, "error-clientcert", "", err.Error(), w)
+		return
+	}
+
+	err = datastore.Environ.DB.DeleteAllowedClientCert(ctx, certID, accountID, user)
+	if err != nil {
+		log.Errorf("Error deleting the account client certificate: %v", err)
+		response.FormatStandardResponse(false, "error-clientcert", "", err.Error(), w)
+		return
+	}
+
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectClientCert, certID, acc.AuthorityID, before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func encodeClientCertResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account client certificate response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"gopkg.in/yaml.v2"
+)
+
+// ConfigContentType is the content type of an exported account configuration
+const ConfigContentType = "application/x-yaml"
+
+// ConfigResponse is the JSON response from the API account configuration apply method, with the
+// change of each record of the configuration
+type ConfigResponse struct {
+	Success      bool           `json:"success"`
+	ErrorCode    string         `json:"error_code"`
+	ErrorSubcode string         `json:"error_subcode"`
+	ErrorMessage string         `json:"message"`
+	DryRun       bool           `json:"dryRun"`
+	Changes      []ConfigChange `json:"changes"`
+}
+
+func configExportHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	acc, err := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-get-account", "", err.Error(), w)
+		return
+	}
+
+	cfg, err := exportConfig(ctx, acc, user)
+	if err != nil {
+		log.Errorf("Error exporting the account configuration: %v", err)
+		response.FormatStandardResponse(false, "error-account-config", "", err.Error(), w)
+		return
+	}
+
+	data, err := yaml.Marshal(cfg)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-account-config", "", err.Error(), w)
+		return
+	}
+
+	w.Header().Set("Content-Type", ConfigContentType)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func configApplyHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, cfg AccountConfig, dryRun bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	acc, err := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-get-account", "", err.Error(), w)
+		return
+	}
+
+	state, err := loadConfigState(ctx, acc, user)
+	if err != nil {
+		log.Errorf("Error reading the account configuration: %v", err)
+		response.FormatStandardResponse(false, "error-account-config", "", err.Error(), w)
+		return
+	}
+
+	// All the changes are checked before any of them is applied
+	changes, err := planConfig(ctx, state, cfg)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		encodeConfigResponse(ConfigResponse{ErrorCode: "error-account-config", ErrorMessage: err.Error(), DryRun: dryRun, Changes: changes}, w)
+		return
+	}
+
+	if !dryRun {
+		changes, err = applyConfig(ctx, state, changes)
+		if err != nil {
+			log.Errorf("Error applying the account configuration: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			encodeConfigResponse(ConfigResponse{ErrorCode: "error-account-config", ErrorMessage: err.Error(), Changes: changes}, w)
+			return
+		}
+		log.Infof("Account configuration of '%s' applied by %s", acc.AuthorityID, user.Username)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeConfigResponse(ConfigResponse{Success: true, DryRun: dryRun, Changes: changes}, w)
+}
+
+func encodeConfigResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account configuration response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
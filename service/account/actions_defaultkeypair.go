@@ -0,0 +1,58 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+func defaultKeypairHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID, keypairID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The account before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+
+	err = datastore.Environ.DB.UpdateAllowedAccountDefaultKeypair(ctx, accountID, keypairID, user)
+	if err != nil {
+		log.Errorf("Error updating the default keypair of the account: %v", err)
+		response.FormatStandardResponse(false, "error-default-keypair", "", err.Error(), w)
+		return
+	}
+	after := before
+	after.DefaultKeypairID = keypairID
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, accountID, before.AuthorityID, before, after)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// HMACResponse is the JSON response from the API account HMAC secret methods
+type HMACResponse struct {
+	Success      bool                  `json:"success"`
+	ErrorCode    string                `json:"error_code"`
+	ErrorSubcode string                `json:"error_subcode"`
+	ErrorMessage string                `json:"message"`
+	HMAC         datastore.AccountHMAC `json:"hmac"`
+}
+
+func hmacGetHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	h, err := datastore.Environ.DB.GetAllowedAccountHMAC(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account HMAC secret: %v", err)
+		response.FormatStandardResponse(false, "error-hmac", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeHMACResponse(HMACResponse{Success: true, HMAC: h}, w)
+}
+
+func hmacRotateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	h, err := datastore.Environ.DB.RotateAllowedAccountHMAC(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error generating the account HMAC secret: %v", err)
+		response.FormatStandardResponse(false, "error-hmac", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionRotate, audit.ObjectAccountHMAC, accountID, acc.AuthorityID, nil, h)
+
+	// The response is the only time that the secret is returned
+	w.WriteHeader(http.StatusOK)
+	encodeHMACResponse(HMACResponse{Success: true, HMAC: h}, w)
+}
+
+func hmacDeleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	err = datastore.Environ.DB.DeleteAllowedAccountHMAC(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error deleting the account HMAC secret: %v", err)
+		response.FormatStandardResponse(false, "error-hmac", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectAccountHMAC, accountID, acc.AuthorityID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func encodeHMACResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account HMAC secret response.\n %v", err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// NonceTTLResponse is the JSON response from the API account nonce validity method
+type NonceTTLResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	NonceTTL     datastore.AccountNonceTTL `json:"nonceTTL"`
+}
+
+func nonceTTLGetHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	ttl, err := datastore.Environ.DB.GetAllowedAccountNonceTTL(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account nonce validity: %v", err)
+		response.FormatStandardResponse(false, "error-nonce-ttl", "", err.Error(), w)
+		return
+	}
+
+	// The default validity of the service applies when the account has no override
+	defaultTTL, err := datastore.NonceTTL(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error fetching the account nonce validity: %v", err)
+		response.FormatStandardResponse(false, "error-nonce-ttl", "", err.Error(), w)
+		return
+	}
+	ttl.Default = int(defaultTTL / time.Second)
+
+	w.WriteHeader(http.StatusOK)
+	formatNonceTTLResponse(ttl, w)
+}
+
+func nonceTTLUpdateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, ttl datastore.AccountNonceTTL) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The nonce validity before the change, for the audit log
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, ttl.AccountID, user)
+	before, _ := datastore.Environ.DB.GetAllowedAccountNonceTTL(ctx, ttl.AccountID, user)
+
+	err = datastore.Environ.DB.UpdateAllowedAccountNonceTTL(ctx, ttl, user)
+	if err != nil {
+		log.Errorf("Error updating the account nonce validity: %v", err)
+		response.FormatStandardResponse(false, "error-nonce-ttl", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, ttl.AccountID, acc.AuthorityID, before, ttl)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatNonceTTLResponse(ttl datastore.AccountNonceTTL, w http.ResponseWriter) error {
+	response := NonceTTLResponse{Success: true, NonceTTL: ttl}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account nonce validity response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// QuotaResponse is the JSON response from the API account quota method
+type QuotaResponse struct {
+	Success      bool                   `json:"success"`
+	ErrorCode    string                 `json:"error_code"`
+	ErrorSubcode string                 `json:"error_subcode"`
+	ErrorMessage string                 `json:"message"`
+	Quota        datastore.AccountQuota `json:"quota"`
+}
+
+func quotaGetHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	quota, err := datastore.Environ.DB.GetAllowedAccountQuota(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account quota: %v", err)
+		response.FormatStandardResponse(false, "error-quota", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatQuotaResponse(quota, w)
+}
+
+func quotaUpdateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, quota datastore.AccountQuota) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The quota before the change, for the audit log
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, quota.AccountID, user)
+	before, _ := datastore.Environ.DB.GetAllowedAccountQuota(ctx, quota.AccountID, user)
+
+	err = datastore.Environ.DB.UpdateAllowedAccountQuota(ctx, quota, user)
+	if err != nil {
+		log.Errorf("Error updating the account quota: %v", err)
+		response.FormatStandardResponse(false, "error-quota", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, quota.AccountID, acc.AuthorityID, before, quota)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatQuotaResponse(quota datastore.AccountQuota, w http.ResponseWriter) error {
+	response := QuotaResponse{Success: true, Quota: quota}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account quota response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	acc "github.com/CanonicalLtd/serial-vault/account"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// RefreshResponse is the JSON response from the API account assertions refresh method
+type RefreshResponse struct {
+	Success      bool              `json:"success"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorSubcode string            `json:"error_subcode"`
+	ErrorMessage string            `json:"message"`
+	Report       acc.RefreshReport `json:"report"`
+}
+
+func refreshHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	report, err := acc.RefreshAccounts(ctx, datastore.Environ)
+	if err != nil {
+		log.Errorf("Error refreshing the account assertions: %v", err)
+		response.FormatStandardResponse(false, "error-account-refresh", "", err.Error(), w)
+		return
+	}
+
+	// The failed assertions are in the report, so the refresh itself is successful
+	w.WriteHeader(http.StatusOK)
+	resp := RefreshResponse{Success: true, Report: report}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Errorf("Error forming the account refresh response (%v).\n %v", resp, err)
+	}
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// RetentionResponse is the JSON response from the API account retention method
+type RetentionResponse struct {
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code"`
+	ErrorSubcode string                     `json:"error_subcode"`
+	ErrorMessage string                     `json:"message"`
+	Retention    datastore.AccountRetention `json:"retention"`
+}
+
+func retentionGetHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	retention, err := datastore.Environ.DB.GetAllowedAccountRetention(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account retention: %v", err)
+		response.FormatStandardResponse(false, "error-retention", "", err.Error(), w)
+		return
+	}
+
+	// The default retention of the service applies when the account has no override
+	retention.Default = datastore.Environ.Config.SigningLogRetention
+
+	w.WriteHeader(http.StatusOK)
+	formatRetentionResponse(retention, w)
+}
+
+func retentionUpdateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, retention datastore.AccountRetention) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The retention before the change, for the audit log
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, retention.AccountID, user)
+	before, _ := datastore.Environ.DB.GetAllowedAccountRetention(ctx, retention.AccountID, user)
+
+	err = datastore.Environ.DB.UpdateAllowedAccountRetention(ctx, retention, user)
+	if err != nil {
+		log.Errorf("Error updating the account retention: %v", err)
+		response.FormatStandardResponse(false, "error-retention", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectAccount, retention.AccountID, acc.AuthorityID, before, retention)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatRetentionResponse(retention datastore.AccountRetention, w http.ResponseWriter) error {
+	response := RetentionResponse{Success: true, Retention: retention}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account retention response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// WebhooksResponse is the JSON response from the API account webhooks list method
+type WebhooksResponse struct {
+	Success      bool                `json:"success"`
+	ErrorCode    string              `json:"error_code"`
+	ErrorSubcode string              `json:"error_subcode"`
+	ErrorMessage string              `json:"message"`
+	Webhooks     []datastore.Webhook `json:"webhooks"`
+}
+
+// WebhookResponse is the JSON response from the API account webhook creation method, with the
+// secret that signs the deliveries
+type WebhookResponse struct {
+	Success      bool              `json:"success"`
+	ErrorCode    string            `json:"error_code"`
+	ErrorSubcode string            `json:"error_subcode"`
+	ErrorMessage string            `json:"message"`
+	Webhook      datastore.Webhook `json:"webhook"`
+}
+
+// WebhookDeliveriesResponse is the JSON response from the API webhook deliveries list method
+type WebhookDeliveriesResponse struct {
+	Success      bool                        `json:"success"`
+	ErrorCode    string                      `json:"error_code"`
+	ErrorSubcode string                      `json:"error_subcode"`
+	ErrorMessage string                      `json:"message"`
+	Deliveries   []datastore.WebhookDelivery `json:"deliveries"`
+}
+
+func webhookListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	webhooks, err := datastore.Environ.DB.ListAllowedWebhooks(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account webhooks: %v", err)
+		response.FormatStandardResponse(false, "error-webhook", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeWebhookResponse(WebhooksResponse{Success: true, Webhooks: webhooks}, w)
+}
+
+func webhookCreateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, webhook datastore.Webhook) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	webhook, err = datastore.Environ.DB.CreateAllowedWebhook(ctx, webhook, user)
+	if err != nil {
+		log.Errorf("Error creating the account webhook: %v", err)
+		response.FormatStandardResponse(false, "error-webhook", "", err.Error(), w)
+		return
+	}
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, webhook.AccountID, user)
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectWebhook, webhook.ID, acc.AuthorityID, nil, webhook)
+
+	w.WriteHeader(http.StatusOK)
+	encodeWebhookResponse(WebhookResponse{Success: true, Webhook: webhook}, w)
+}
+
+func webhookDeleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, webhookID, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	webhooks, err := datastore.Environ.DB.ListAllowedWebhooks(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account webhooks: %v", err)
+		response.FormatStandardResponse(false, "error-webhook", "", err.Error(), w)
+		return
+	}
+	before, ok := findWebhook(webhooks, webhookID)
+	if !ok {
+		response.FormatStandardResponse(false, "error-webhook", "", fmt.Sprintf("Cannot find the webhook %d of the account", webhookID), w)
+		return
+	}
+
+	err = datastore.Environ.DB.DeleteAllowedWebhook(ctx, webhookID, accountID, user)
+	if err != nil {
+		log.Errorf("Error deleting the account webhook: %v", err)
+		response.FormatStandardResponse(false, "error-webhook", "", err.Error(), w)
+		return
+	}
+
+	acc, _ := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectWebhook, webhookID, acc.AuthorityID, before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func webhookDeliveriesHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, webhookID, accountID int, status string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(status) > 0 && !datastore.ValidWebhookDeliveryStatus(status) {
+		response.FormatStandardResponse(false, "error-webhook-delivery", "", fmt.Sprintf("Invalid delivery status: %s", status), w)
+		return
+	}
+
+	deliveries, err := datastore.Environ.DB.ListAllowedWebhookDeliveries(ctx, webhookID, accountID, status, user)
+	if err != nil {
+		log.Errorf("Error fetching the webhook deliveries: %v", err)
+		response.FormatStandardResponse(false, "error-webhook-delivery", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeWebhookResponse(WebhookDeliveriesResponse{Success: true, Deliveries: deliveries}, w)
+}
+
+func findWebhook(webhooks []datastore.Webhook, webhookID int) (datastore.Webhook, bool) {
+	for _, w := range webhooks {
+		if w.ID == webhookID {
+			return w, true
+		}
+	}
+	return datastore.Webhook{}, false
+}
+
+func encodeWebhookResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the account webhook response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
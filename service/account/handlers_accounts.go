@@ -28,6 +28,7 @@ import (
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
@@ -46,7 +47,18 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false)
+	listHandler(r.Context(), w, authUser, false)
+}
+
+// Refresh is the API method to refresh the account assertions of all the accounts from the store
+func Refresh(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	refreshHandler(r.Context(), w, authUser, false)
 }
 
 // Create is the API method to create an account
@@ -73,7 +85,7 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, acct)
+	createHandler(r.Context(), w, authUser, false, acct)
 }
 
 // Get is the API method to fetch an account
@@ -91,7 +103,7 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	getHandler(r.Context(), w, authUser, false, id)
 }
 
 // Update is the API method to update a model
@@ -119,8 +131,47 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
 		return
 	}
+	// The update is based on the version of the account that was read
+	acct.Version = request.Version(r, acct.Version)
+
+	updateHandler(r.Context(), w, authUser, false, acct)
+}
+
+// Put is the API method to create or update the account of an authority ID
+func Put(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	acct, ok := decodePutRequest(w, r)
+	if !ok {
+		return
+	}
+
+	putHandler(r.Context(), w, authUser, false, mux.Vars(r)["authorityID"], acct)
+}
+
+// decodePutRequest decodes the account of a put request, with the version of the If-Match header
+func decodePutRequest(w http.ResponseWriter, r *http.Request) (datastore.Account, bool) {
+	defer r.Body.Close()
+
+	acct := datastore.Account{}
+	err := json.NewDecoder(r.Body).Decode(&acct)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-account-data", "", "No account data supplied", w)
+		return acct, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return acct, false
+	}
 
-	updateHandler(w, authUser, false, acct)
+	acct.Version = request.Version(r, acct.Version)
+	return acct, true
 }
 
 // Upload is the API method to upload an account assertion
@@ -147,5 +198,5 @@ func Upload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	uploadHandler(w, authUser, false, assertionRequest)
+	uploadHandler(r.Context(), w, authUser, false, assertionRequest)
 }
@@ -151,8 +151,10 @@ func (s *AccountSuite) TestAccountsHandler(c *check.C) {
 
 func (s *AccountSuite) TestCreateGetUpdateAccountHandlers(c *check.C) {
 
-	account := datastore.Account{ID: 2, AuthorityID: "vendor", ResellerAPI: false}
+	account := datastore.Account{ID: 2, AuthorityID: "vendor", ResellerAPI: false, Version: 1}
 	acc, _ := json.Marshal(account)
+	stale, _ := json.Marshal(datastore.Account{ID: 2, AuthorityID: "vendor", Version: 2})
+	unversioned, _ := json.Marshal(datastore.Account{ID: 2, AuthorityID: "vendor"})
 
 	tests := []AccountTest{
 		{"POST", "/v1/accounts", nil, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
@@ -175,6 +177,8 @@ func (s *AccountSuite) TestCreateGetUpdateAccountHandlers(c *check.C) {
 		{"PUT", "/v1/accounts/1", acc, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 0},
 		{"PUT", "/v1/accounts/1", acc, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, true, false, 0},
 		{"PUT", "/v1/accounts/1", acc, 400, "application/json; charset=UTF-8", 0, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1", stale, 409, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1", unversioned, 428, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
 	}
 
 	for _, t := range tests {
@@ -194,6 +198,53 @@ func (s *AccountSuite) TestCreateGetUpdateAccountHandlers(c *check.C) {
 	}
 }
 
+func (s *AccountSuite) TestPutAccountHandler(c *check.C) {
+	reseller, _ := json.Marshal(datastore.Account{ResellerAPI: true})
+	mismatch, _ := json.Marshal(datastore.Account{AuthorityID: "vendor"})
+	stale, _ := json.Marshal(datastore.Account{ResellerAPI: true, Version: 2})
+
+	tests := []struct {
+		URL         string
+		Data        []byte
+		Code        int
+		Permissions int
+		MockError   bool
+		ResellerAPI bool
+	}{
+		{"/v1/accounts/newbrand", reseller, 201, datastore.Superuser, false, true},
+		{"/v1/accounts/system", reseller, 200, datastore.Superuser, false, true},
+		{"/v1/accounts/vendor", reseller, 200, datastore.Superuser, false, true},
+		{"/v1/accounts/vendor", []byte("{}"), 200, datastore.Superuser, false, false},
+		{"/v1/accounts/system", mismatch, 400, datastore.Superuser, false, false},
+		{"/v1/accounts/vendor", stale, 409, datastore.Superuser, false, false},
+		{"/v1/accounts/vendor", nil, 400, datastore.Superuser, false, false},
+		{"/v1/accounts/vendor", reseller, 400, datastore.Admin, false, false},
+		{"/v1/accounts/newbrand", reseller, 400, datastore.Superuser, true, false},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = true
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest("PUT", t.URL, bytes.NewReader(t.Data), t.Permissions, false, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		if t.Code < 300 {
+			result := account.GetResponse{}
+			err := json.NewDecoder(w.Body).Decode(&result)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.Success, check.Equals, true)
+			c.Assert(result.Account.ResellerAPI, check.Equals, t.ResellerAPI)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
 func (s *AccountSuite) TestAccountsHandlerError(c *check.C) {
 	datastore.Environ.DB = &datastore.ErrorMockDB{}
 
@@ -21,10 +21,14 @@
 package account
 
 import (
+	"encoding/json"
+	"io"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
 )
 
 // APIList is the API method to fetch the sub-store models
@@ -37,5 +41,52 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	listHandler(r.Context(), w, user, true)
+}
+
+// APICreate is the API method to create an account
+func APICreate(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	acct := datastore.Account{}
+	err = json.NewDecoder(r.Body).Decode(&acct)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-account-data", "", "No account data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	createHandler(r.Context(), w, user, true, acct)
+}
+
+// APIPut is the API method to create or update the account of an authority ID
+func APIPut(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	acct, ok := decodePutRequest(w, r)
+	if !ok {
+		return
+	}
+
+	// Call the API with the user
+	putHandler(r.Context(), w, user, true, mux.Vars(r)["authorityID"], acct)
 }
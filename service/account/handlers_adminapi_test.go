@@ -22,12 +22,14 @@ package account_test
 
 import (
 	"bytes"
+	"encoding/json"
 	"io"
 	"net/http"
 	"net/http/httptest"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/response"
 	check "gopkg.in/check.v1"
 )
 
@@ -57,11 +59,59 @@ func (s *AccountSuite) TestAPIListHandler(c *check.C) {
 	}
 }
 
+func (s *AccountSuite) TestAPICreateHandler(c *check.C) {
+	acc, _ := json.Marshal(datastore.Account{AuthorityID: "vendor"})
+
+	tests := []AccountTest{
+		{"POST", "/api/accounts", acc, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
+		{"POST", "/api/accounts", acc, 200, "application/json; charset=UTF-8", datastore.Superuser, false, true, false, false, 0},
+		{"POST", "/api/accounts", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, false, false, false, false, 0},
+		{"POST", "/api/accounts", []byte("bad"), 400, "application/json; charset=UTF-8", datastore.Superuser, false, false, false, false, 0},
+		{"POST", "/api/accounts", acc, 400, "application/json; charset=UTF-8", datastore.SyncUser, false, false, false, false, 0},
+		{"POST", "/api/accounts", acc, 400, "application/json; charset=UTF-8", datastore.Standard, false, false, false, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *AccountSuite) TestAPIPutHandler(c *check.C) {
+	acc, _ := json.Marshal(datastore.Account{ResellerAPI: true})
+
+	tests := []AccountTest{
+		{"PUT", "/api/accounts/newbrand", acc, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
+		{"PUT", "/api/accounts/newbrand", acc, 201, "application/json; charset=UTF-8", datastore.Superuser, false, true, false, false, 0},
+		{"PUT", "/api/accounts/system", acc, 200, "application/json; charset=UTF-8", datastore.Superuser, false, true, false, false, 0},
+		{"PUT", "/api/accounts/system", []byte("bad"), 400, "application/json; charset=UTF-8", datastore.Superuser, false, false, false, false, 0},
+		{"PUT", "/api/accounts/system", acc, 400, "application/json; charset=UTF-8", datastore.Standard, false, false, false, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
 
 	switch permissions {
+	case datastore.Superuser:
+		r.Header.Set("user", "root")
+		r.Header.Set("api-key", "ValidAPIKey")
 	case datastore.SyncUser:
 		r.Header.Set("user", "sync")
 		r.Header.Set("api-key", "ValidAPIKey")
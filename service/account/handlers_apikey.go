@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// APIKeyList is the API method to fetch the named API keys of an account
+func APIKeyList(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	apiKeyListHandler(r.Context(), w, authUser, false, accountID)
+}
+
+// APIKeyCreate is the API method to create a named API key for an account
+func APIKeyCreate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	k, ok := decodeAPIKey(w, r)
+	if !ok {
+		return
+	}
+	k.AccountID = accountID
+
+	apiKeyCreateHandler(r.Context(), w, authUser, false, k)
+}
+
+// APIKeyUpdate is the API method to update the name, scopes and status of a named API key
+func APIKeyUpdate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+	keyID, err := strconv.Atoi(vars["keyid"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-apikey", "", err.Error(), w)
+		return
+	}
+
+	k, ok := decodeAPIKey(w, r)
+	if !ok {
+		return
+	}
+	k.ID = keyID
+	k.AccountID = accountID
+
+	apiKeyUpdateHandler(r.Context(), w, authUser, false, k)
+}
+
+// APIKeyDelete is the API method to delete a named API key of an account
+func APIKeyDelete(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+	keyID, err := strconv.Atoi(vars["keyid"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-apikey", "", err.Error(), w)
+		return
+	}
+
+	apiKeyDeleteHandler(r.Context(), w, authUser, false, keyID, accountID)
+}
+
+// APIKeyRotate is the API method to issue a new key for a named API key of an account.
+// The previous key stays valid for the grace period
+func APIKeyRotate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	// The body identifies the API key to rotate
+	k, ok := decodeAPIKey(w, r)
+	if !ok {
+		return
+	}
+
+	apiKeyRotateHandler(r.Context(), w, authUser, false, k.ID, accountID)
+}
+
+func decodeAPIKey(w http.ResponseWriter, r *http.Request) (datastore.APIKey, bool) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	k := datastore.APIKey{}
+	err := json.NewDecoder(r.Body).Decode(&k)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-apikey-data", "", "No API key data supplied", w)
+		return k, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return k, false
+	}
+	return k, true
+}
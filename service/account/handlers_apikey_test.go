@@ -0,0 +1,187 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestAPIKeyListHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/apikeys", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/apikeys", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/apikeys", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/apikeys", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.APIKeysResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.APIKeys, check.HasLen, 2)
+			c.Assert(result.APIKeys[0].Scopes, check.DeepEquals, []string{datastore.ScopeSerialSigning})
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestAPIKeyCreateHandler(c *check.C) {
+	valid, _ := json.Marshal(datastore.APIKey{Name: "factory-2", Scopes: []string{datastore.ScopeSerialSigning}, Active: true})
+	noScopes, _ := json.Marshal(datastore.APIKey{Name: "factory-2", Active: true})
+	badScope, _ := json.Marshal(datastore.APIKey{Name: "factory-2", Scopes: []string{"superuser"}, Active: true})
+	noName, _ := json.Marshal(datastore.APIKey{Scopes: []string{datastore.ScopeReadOnly}, Active: true})
+	badNetwork, _ := json.Marshal(datastore.APIKey{Name: "factory-2", Scopes: []string{datastore.ScopeSerialSigning}, Active: true, AllowedCIDRs: []string{"10.0.0.0/99"}})
+
+	tests := []AccountTest{
+		{"POST", "/v1/accounts/1/apikeys", valid, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", valid, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", valid, 400, "application/json; charset=UTF-8", datastore.SyncUser, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", noScopes, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", badScope, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", noName, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", badNetwork, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikeys", valid, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.APIKeyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.APIKey.AccountID, check.Equals, 1)
+			c.Assert(result.APIKey.Key, check.Equals, "GeneratedAPIKey")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestAPIKeyUpdateDeleteHandler(c *check.C) {
+	disable, _ := json.Marshal(datastore.APIKey{Name: "factory-1", Scopes: []string{datastore.ScopeSerialSigning}, Active: false})
+	noScopes, _ := json.Marshal(datastore.APIKey{Name: "factory-1", Active: true})
+
+	tests := []AccountTest{
+		{"PUT", "/v1/accounts/1/apikeys/1", disable, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"PUT", "/v1/accounts/1/apikeys/1", disable, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"PUT", "/v1/accounts/1/apikeys/1", disable, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/apikeys/1", noScopes, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/apikeys/1", disable, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+		{"DELETE", "/v1/accounts/1/apikeys/1", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1/apikeys/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1/apikeys/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestAPIKeyRotateHandler(c *check.C) {
+	key, _ := json.Marshal(datastore.APIKey{ID: 1})
+
+	tests := []AccountTest{
+		{"POST", "/v1/accounts/1/apikey/rotate", key, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"POST", "/v1/accounts/1/apikey/rotate", key, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"POST", "/v1/accounts/1/apikey/rotate", key, 400, "application/json; charset=UTF-8", datastore.SyncUser, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikey/rotate", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/apikey/rotate", key, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	datastore.Environ.Config.APIKeyGrace = "48h"
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.APIKeyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.APIKey.Key, check.Equals, "RotatedAPIKey")
+			c.Assert(result.APIKey.PreviousExpires.Format("2006-01-02"), check.Equals, "2020-01-03")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+
+	datastore.Environ.Config.APIKeyGrace = ""
+}
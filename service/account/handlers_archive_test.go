@@ -0,0 +1,123 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestDeleteHandler(c *check.C) {
+	tests := []AccountTest{
+		{"DELETE", "/v1/accounts/1?confirm=system", nil, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1?confirm=system", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1?confirm=system", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1?confirm=vendor", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/999?confirm=system", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1?confirm=system", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.ArchiveResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.Archive.AuthorityID, check.Equals, "system")
+			c.Assert(result.Archive.Keypairs, check.Equals, 2)
+			c.Assert(result.Archive.SigningLogs, check.Equals, 10)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestArchiveListHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/archives", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 1},
+		{"GET", "/v1/accounts/archives", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"GET", "/v1/accounts/archives", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.ArchiveListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.Archives, check.HasLen, t.Accounts)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestArchiveDownloadHandler(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	w := sendAdminRequest("GET", "/v1/accounts/archives/1", nil, datastore.Superuser, false, c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/gzip")
+	c.Assert(w.Header().Get("Content-Disposition"), check.Equals, `attachment; filename="account-retired-1.json.gz"`)
+
+	reader, err := gzip.NewReader(w.Body)
+	c.Assert(err, check.IsNil)
+	bundle := datastore.AccountBundle{}
+	err = json.NewDecoder(reader).Decode(&bundle)
+	c.Assert(err, check.IsNil)
+	c.Assert(bundle.AuthorityID, check.Equals, "retired")
+
+	w = sendAdminRequest("GET", "/v1/accounts/archives/2", nil, datastore.Superuser, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+	w = sendAdminRequest("GET", "/v1/accounts/archives/1", nil, datastore.Admin, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// ClientCertList is the API method to fetch the client certificates of an account
+func ClientCertList(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	clientCertListHandler(r.Context(), w, authUser, false, accountID)
+}
+
+// ClientCertCreate is the API method to map a client certificate to an account, by its
+// subject alternative name or fingerprint
+func ClientCertCreate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	c, ok := decodeClientCert(w, r)
+	if !ok {
+		return
+	}
+	c.AccountID = accountID
+
+	clientCertCreateHandler(r.Context(), w, authUser, false, c)
+}
+
+// ClientCertDelete is the API method to remove a client certificate of an account
+func ClientCertDelete(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+	certID, err := strconv.Atoi(vars["certid"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-clientcert", "", err.Error(), w)
+		return
+	}
+
+	clientCertDeleteHandler(r.Context(), w, authUser, false, certID, accountID)
+}
+
+func decodeClientCert(w http.ResponseWriter, r *http.Request) (datastore.ClientCert, bool) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	c := datastore.ClientCert{}
+	err := json.NewDecoder(r.Body).Decode(&c)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-clientcert-data", "", "No client certificate data supplied", w)
+		return c, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return c, false
+	}
+	return c, true
+}
@@ -0,0 +1,143 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestClientCertListHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/clientcerts", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/clientcerts", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/clientcerts", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/clientcerts", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.ClientCertsResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.ClientCerts, check.HasLen, 2)
+			c.Assert(result.ClientCerts[0].SAN, check.Equals, "factory.example.com")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestClientCertCreateHandler(c *check.C) {
+	san, _ := json.Marshal(datastore.ClientCert{Name: "factory-3", SAN: " factory-3.example.com "})
+	fingerprint, _ := json.Marshal(datastore.ClientCert{Name: "factory-3", Fingerprint: "5F:1E:7D:3A:9B:2C:4E:6F:8A:0B:1C:2D:3E:4F:5A:6B:7C:8D:9E:0F:1A:2B:3C:4D:5E:6F:7A:8B:9C:0D:1E:2F"})
+	noIdentity, _ := json.Marshal(datastore.ClientCert{Name: "factory-3"})
+	noName, _ := json.Marshal(datastore.ClientCert{SAN: "factory-3.example.com"})
+	badFingerprint, _ := json.Marshal(datastore.ClientCert{Name: "factory-3", Fingerprint: "5F:1E:7D:3A"})
+
+	tests := []AccountTest{
+		{"POST", "/v1/accounts/1/clientcerts", san, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", san, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", fingerprint, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", san, 400, "application/json; charset=UTF-8", datastore.SyncUser, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", noIdentity, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", noName, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", badFingerprint, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/clientcerts", san, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.ClientCertResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.ClientCert.AccountID, check.Equals, 1)
+			if len(result.ClientCert.SAN) > 0 {
+				c.Assert(result.ClientCert.SAN, check.Equals, "factory-3.example.com")
+			} else {
+				c.Assert(result.ClientCert.Fingerprint, check.Equals, "5f1e7d3a9b2c4e6f8a0b1c2d3e4f5a6b7c8d9e0f1a2b3c4d5e6f7a8b9c0d1e2f")
+			}
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestClientCertDeleteHandler(c *check.C) {
+	tests := []AccountTest{
+		{"DELETE", "/v1/accounts/1/clientcerts/1", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1/clientcerts/1", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1/clientcerts/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1/clientcerts/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
@@ -0,0 +1,141 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+	"gopkg.in/yaml.v2"
+)
+
+// maxConfigSize is the largest account configuration that is applied
+const maxConfigSize = 10 * 1024 * 1024
+
+// ConfigExport is the API method to export the configuration of an account as YAML
+func ConfigExport(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	configExportHandler(r.Context(), w, authUser, false, accountID)
+}
+
+// ConfigApply is the API method to apply a YAML configuration to an account. With the dryrun
+// parameter the changes are returned without applying them
+func ConfigApply(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	cfg, ok := decodeConfig(w, r)
+	if !ok {
+		return
+	}
+
+	configApplyHandler(r.Context(), w, authUser, false, accountID, cfg, r.URL.Query().Get("dryrun") == "true")
+}
+
+// APIConfigExport is the API method to export the configuration of an account as YAML
+func APIConfigExport(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	configExportHandler(r.Context(), w, user, true, accountID)
+}
+
+// APIConfigApply is the API method to apply a YAML configuration to an account
+func APIConfigApply(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	cfg, ok := decodeConfig(w, r)
+	if !ok {
+		return
+	}
+
+	configApplyHandler(r.Context(), w, user, true, accountID, cfg, r.URL.Query().Get("dryrun") == "true")
+}
+
+// decodeConfig reads the YAML configuration of the body. The unknown fields are rejected, so a
+// misspelt field is not ignored
+func decodeConfig(w http.ResponseWriter, r *http.Request) (AccountConfig, bool) {
+	defer r.Body.Close()
+
+	cfg := AccountConfig{}
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxConfigSize))
+	if err != nil {
+		response.FormatStandardResponse(false, "error-account-config", "", err.Error(), w)
+		return cfg, false
+	}
+	if len(data) == 0 {
+		response.FormatStandardResponse(false, "error-account-config", "", "No account configuration supplied", w)
+		return cfg, false
+	}
+
+	if err := yaml.UnmarshalStrict(data, &cfg); err != nil {
+		response.FormatStandardResponse(false, "error-decode-yaml", "", err.Error(), w)
+		return cfg, false
+	}
+	return cfg, true
+}
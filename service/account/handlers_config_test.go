@@ -0,0 +1,182 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	check "gopkg.in/check.v1"
+	"gopkg.in/yaml.v2"
+)
+
+func (s *AccountSuite) exportConfig(c *check.C) account.AccountConfig {
+	w := sendAdminRequest("GET", "/v1/accounts/1/config", nil, 0, false, c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, account.ConfigContentType)
+
+	cfg := account.AccountConfig{}
+	err := yaml.UnmarshalStrict(w.Body.Bytes(), &cfg)
+	c.Assert(err, check.IsNil)
+	return cfg
+}
+
+func (s *AccountSuite) applyConfig(url string, cfg account.AccountConfig, code int, c *check.C) account.ConfigResponse {
+	data, err := yaml.Marshal(cfg)
+	c.Assert(err, check.IsNil)
+
+	w := sendAdminRequest("PUT", url, bytes.NewReader(data), 0, false, c)
+	c.Assert(w.Code, check.Equals, code)
+
+	result := account.ConfigResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func (s *AccountSuite) TestConfigExportHandler(c *check.C) {
+	cfg := s.exportConfig(c)
+	c.Assert(cfg.AuthorityID, check.Equals, "system")
+	c.Assert(cfg.ResellerAPI, check.Equals, true)
+	c.Assert(cfg.Keypairs, check.HasLen, 3)
+	c.Assert(cfg.Models, check.HasLen, 6)
+	c.Assert(cfg.Models[0].Name, check.Equals, "alder")
+	c.Assert(cfg.Models[0].SigningKey, check.Equals, "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO")
+	c.Assert(cfg.Substores, check.HasLen, 2)
+	c.Assert(cfg.Substores[0].FromModel, check.Equals, "alder")
+	c.Assert(cfg.Substores[0].SerialNumber, check.Equals, "abc1234")
+
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/config", nil, 200, account.ConfigContentType, datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/config", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"GET", "/v1/accounts/99/config", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/config", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestConfigApplyUnchanged(c *check.C) {
+	// Applying an export does not change the account
+	cfg := s.exportConfig(c)
+
+	result := s.applyConfig("/v1/accounts/1/config", cfg, 200, c)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.DryRun, check.Equals, false)
+	c.Assert(len(result.Changes), check.Equals, 1+len(cfg.Keypairs)+len(cfg.Models)+len(cfg.Substores)+len(cfg.Users))
+	for _, change := range result.Changes {
+		c.Assert(change.Action, check.Equals, account.ConfigUnchanged, check.Commentf("%s %s", change.Kind, change.Name))
+	}
+}
+
+func (s *AccountSuite) TestConfigApplyChanges(c *check.C) {
+	inactive := false
+	cfg := account.AccountConfig{
+		AuthorityID: "system",
+		ResellerAPI: true,
+		Keypairs:    []account.ConfigKeypair{{KeyID: "invalidone", Active: &inactive}},
+		Models: []account.ConfigModel{
+			{Name: "alder", SigningKey: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", SystemUserKey: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", SerialFormat: "^A[0-9]+$"},
+			{Name: "yew", SigningKey: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"},
+		},
+		Substores: []account.ConfigSubstore{
+			{FromModel: "alder", SerialNumber: "abc1234", Store: "mybrand", ModelName: "alder-mybrand"},
+			{FromModel: "yew", SerialNumber: "yew1", Store: "mybrand", ModelName: "yew-mybrand"},
+		},
+		Users: []account.ConfigUser{{Username: "newuser", Name: "New User", Role: "admin"}},
+	}
+	want := []string{account.ConfigUnchanged, account.ConfigUpdate, account.ConfigUpdate, account.ConfigCreate,
+		account.ConfigUnchanged, account.ConfigCreate, account.ConfigCreate}
+
+	for _, url := range []string{"/v1/accounts/1/config?dryrun=true", "/v1/accounts/1/config"} {
+		result := s.applyConfig(url, cfg, 200, c)
+		c.Assert(result.Success, check.Equals, true)
+		c.Assert(result.Changes, check.HasLen, len(want))
+		for i, change := range result.Changes {
+			c.Assert(change.Action, check.Equals, want[i], check.Commentf("%s %s", change.Kind, change.Name))
+		}
+	}
+}
+
+func (s *AccountSuite) TestConfigApplyInvalid(c *check.C) {
+	tests := []struct {
+		cfg     account.AccountConfig
+		changes int
+	}{
+		{account.AccountConfig{AuthorityID: "vendor"}, 0},
+		{account.AccountConfig{AuthorityID: "system", DefaultSigningKey: "unknown"}, 1},
+		{account.AccountConfig{AuthorityID: "system", Keypairs: []account.ConfigKeypair{{KeyName: "unknown"}}}, 2},
+		{account.AccountConfig{AuthorityID: "system", Models: []account.ConfigModel{{Name: "yew", SigningKey: "unknown"}}}, 2},
+		{account.AccountConfig{AuthorityID: "system", Models: []account.ConfigModel{{Name: "yew"}, {Name: "yew"}}}, 3},
+		{account.AccountConfig{AuthorityID: "system", Substores: []account.ConfigSubstore{{FromModel: "unknown", SerialNumber: "a1", Store: "s", ModelName: "m"}}}, 2},
+		{account.AccountConfig{AuthorityID: "system", Users: []account.ConfigUser{{Username: "newuser", Role: "invalid"}}}, 2},
+	}
+
+	for _, t := range tests {
+		result := s.applyConfig("/v1/accounts/1/config", t.cfg, 400, c)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, "error-account-config")
+		c.Assert(result.Changes, check.HasLen, t.changes)
+	}
+
+	// The users are only changed by a superuser
+	datastore.Environ.Config.EnableUserAuth = true
+	data, _ := yaml.Marshal(account.AccountConfig{AuthorityID: "system", Users: []account.ConfigUser{{Username: "newuser", Role: "admin"}}})
+	w := sendAdminRequest("PUT", "/v1/accounts/1/config", bytes.NewReader(data), datastore.Admin, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	// The unknown fields are rejected
+	w = sendAdminRequest("PUT", "/v1/accounts/1/config", bytes.NewReader([]byte("authority-id: system\nmodel: []\n")), datastore.Admin, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+	w = sendAdminRequest("PUT", "/v1/accounts/1/config", bytes.NewReader([]byte{}), datastore.Admin, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+	w = sendAdminRequest("PUT", "/v1/accounts/1/config", bytes.NewReader(data), datastore.Standard, false, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+}
+
+func (s *AccountSuite) TestAPIConfigHandlers(c *check.C) {
+	w := sendAdminAPIRequest("GET", "/api/accounts/1/config", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, account.ConfigContentType)
+
+	w = sendAdminAPIRequest("PUT", "/api/accounts/1/config?dryrun=true", bytes.NewReader(w.Body.Bytes()), datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 200)
+
+	w = sendAdminAPIRequest("GET", "/api/accounts/1/config", nil, datastore.Standard, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
@@ -0,0 +1,108 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestNonceTTLGetHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/noncettl", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/noncettl", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/noncettl", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/noncettl", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	datastore.Environ.Config.NonceTTL = "2m"
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.NonceTTLResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.NonceTTL.AccountID, check.Equals, 1)
+			c.Assert(result.NonceTTL.Seconds, check.Equals, 300)
+			c.Assert(result.NonceTTL.Default, check.Equals, 120)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+
+	datastore.Environ.Config.NonceTTL = ""
+}
+
+func (s *AccountSuite) TestNonceTTLUpdateHandler(c *check.C) {
+	valid, _ := json.Marshal(datastore.AccountNonceTTL{Override: true, Seconds: 60})
+	remove, _ := json.Marshal(datastore.AccountNonceTTL{Override: false})
+	invalid, _ := json.Marshal(datastore.AccountNonceTTL{Override: true, Seconds: 0})
+	tooLong, _ := json.Marshal(datastore.AccountNonceTTL{Override: true, Seconds: 86401})
+
+	tests := []AccountTest{
+		{"PUT", "/v1/accounts/1/noncettl", valid, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", valid, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", valid, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", remove, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", invalid, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", tooLong, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/noncettl", valid, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
@@ -0,0 +1,99 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestQuotaGetHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/quota", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/quota", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/quota", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/quota", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.QuotaResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.Quota.AccountID, check.Equals, 1)
+			c.Assert(result.Quota.Limit, check.Equals, 100)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestQuotaUpdateHandler(c *check.C) {
+	valid, _ := json.Marshal(datastore.AccountQuota{Warning: 800, Limit: 1000, Grace: 50})
+	invalid, _ := json.Marshal(datastore.AccountQuota{Warning: 1200, Limit: 1000, Grace: 50})
+
+	tests := []AccountTest{
+		{"PUT", "/v1/accounts/1/quota", valid, 400, "application/json; charset=UTF-8", 0, false, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/quota", valid, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/quota", valid, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, false, false, 0},
+		{"PUT", "/v1/accounts/1/quota", invalid, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/quota", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, false, 0},
+		{"PUT", "/v1/accounts/1/quota", valid, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
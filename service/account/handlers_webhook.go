@@ -0,0 +1,142 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// WebhookList is the API method to fetch the webhooks of an account
+func WebhookList(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	webhookListHandler(r.Context(), w, authUser, false, accountID)
+}
+
+// WebhookCreate is the API method to register a webhook of an account for its events
+func WebhookCreate(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	webhook, ok := decodeWebhook(w, r)
+	if !ok {
+		return
+	}
+	webhook.AccountID = accountID
+
+	webhookCreateHandler(r.Context(), w, authUser, false, webhook)
+}
+
+// WebhookDelete is the API method to remove a webhook of an account
+func WebhookDelete(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, webhookID, ok := webhookVars(w, r)
+	if !ok {
+		return
+	}
+
+	webhookDeleteHandler(r.Context(), w, authUser, false, webhookID, accountID)
+}
+
+// WebhookDeliveries is the API method to fetch the latest deliveries of a webhook, optionally
+// with a delivery status
+func WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	accountID, webhookID, ok := webhookVars(w, r)
+	if !ok {
+		return
+	}
+
+	webhookDeliveriesHandler(r.Context(), w, authUser, false, webhookID, accountID, r.URL.Query().Get("status"))
+}
+
+func webhookVars(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return 0, 0, false
+	}
+	webhookID, err := strconv.Atoi(vars["webhookid"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-webhook", "", err.Error(), w)
+		return 0, 0, false
+	}
+	return accountID, webhookID, true
+}
+
+func decodeWebhook(w http.ResponseWriter, r *http.Request) (datastore.Webhook, bool) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	webhook := datastore.Webhook{}
+	err := json.NewDecoder(r.Body).Decode(&webhook)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-webhook-data", "", "No webhook data supplied", w)
+		return webhook, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return webhook, false
+	}
+	return webhook, true
+}
@@ -0,0 +1,172 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package account_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *AccountSuite) TestWebhookListHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/webhooks", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.WebhooksResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.Webhooks, check.HasLen, 1)
+			c.Assert(result.Webhooks[0].URL, check.Equals, "https://hooks.example.com/vault")
+			c.Assert(result.Webhooks[0].Secret, check.Equals, "")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestWebhookCreateHandler(c *check.C) {
+	valid, _ := json.Marshal(datastore.Webhook{URL: "https://hooks.example.com/factory", Events: []string{datastore.WebhookEventSigningAnomaly}})
+	noURL, _ := json.Marshal(datastore.Webhook{Events: []string{datastore.WebhookEventSigningAnomaly}})
+	badEvent, _ := json.Marshal(datastore.Webhook{URL: "https://hooks.example.com/factory", Events: []string{"invalid"}})
+
+	tests := []AccountTest{
+		{"POST", "/v1/accounts/1/webhooks", valid, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", valid, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", valid, 400, "application/json; charset=UTF-8", datastore.SyncUser, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", noURL, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", badEvent, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"POST", "/v1/accounts/1/webhooks", valid, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.WebhookResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.Webhook.AccountID, check.Equals, 1)
+			c.Assert(result.Webhook.URL, check.Equals, "https://hooks.example.com/factory")
+			c.Assert(result.Webhook.Secret, check.Equals, "GeneratedWebhookSecret")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestWebhookDeleteHandler(c *check.C) {
+	tests := []AccountTest{
+		{"DELETE", "/v1/accounts/1/webhooks/1", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1/webhooks/1", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"DELETE", "/v1/accounts/1/webhooks/99", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1/webhooks/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"DELETE", "/v1/accounts/1/webhooks/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *AccountSuite) TestWebhookDeliveriesHandler(c *check.C) {
+	tests := []AccountTest{
+		{"GET", "/v1/accounts/1/webhooks/1/deliveries", nil, 200, "application/json; charset=UTF-8", 0, false, true, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks/1/deliveries?status=failed", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks/1/deliveries?status=invalid", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks/1/deliveries", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, false, false, 0},
+		{"GET", "/v1/accounts/1/webhooks/1/deliveries", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, false, true, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := account.WebhookDeliveriesResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.Deliveries, check.NotNil)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
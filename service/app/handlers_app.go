@@ -45,7 +45,7 @@ func Index(w http.ResponseWriter, r *http.Request) {
 	path := []string{datastore.Environ.Config.DocRoot, IndexTemplate}
 	t, err := template.ParseFiles(strings.Join(path, ""))
 	if err != nil {
-		log.Printf("Error loading the application template: %v\n", err)
+		log.Errorf("Error loading the application template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -21,21 +21,25 @@
 package assertion
 
 import (
+	"context"
 	"fmt"
 	"net/http"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/CanonicalLtd/serial-vault/account"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/timestamp"
 	"github.com/snapcore/snapd/asserts"
 )
 
-func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAssertionRequest) response.ErrorResponse {
+func modelAssertionHandler(ctx context.Context, w http.ResponseWriter, apiKey string, request ModelAssertionRequest) response.ErrorResponse {
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(request.BrandID)
+	acc, err := datastore.Environ.DB.GetAccount(ctx, request.BrandID)
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -44,23 +48,24 @@ func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAs
 	}
 
 	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(request.BrandID, request.Name, apiKey)
+	model, err := datastore.Environ.DB.FindModel(ctx, request.BrandID, request.Name, apiKey, datastore.ScopeReseller, datastore.ScopeReadOnly)
 	if err != nil {
 		log.Message("MODEL", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
 		return response.ErrorInvalidModel
 	}
+	audit.DeprecatedModelKey(ctx, model)
 
 	assertions := []asserts.Assertion{}
 
 	// Build the model assertion headers
-	assertionHeaders, keypair, err := CreateModelAssertionHeaders(model)
+	assertionHeaders, keypair, err := CreateModelAssertionHeaders(ctx, model)
 	if err != nil {
 		log.Message("MODEL", response.ErrorCreateModelAssertion.Code, err.Error())
 		return response.ErrorCreateModelAssertion
 	}
 
-	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ModelType, assertionHeaders, []byte(""), model.BrandID, keypair.KeyID, keypair.SealedKey)
+	// Sign the assertion with the snapd assertions module, unless it is stored for the same headers
+	signedAssertion, err := SignModelAssertion(ctx, model, 0, assertionHeaders, keypair)
 	if err != nil {
 		log.Message("MODEL", response.ErrorSignAssertion.Code, err.Error())
 		return response.ErrorResponse{Success: false, Code: response.ErrorSignAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
@@ -80,21 +85,63 @@ func modelAssertionHandler(w http.ResponseWriter, apiKey string, request ModelAs
 	return response.ErrorResponse{Success: true}
 }
 
-// CreateModelAssertionHeaders returns the model assertion headers for a model
-func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, datastore.Keypair, error) {
+// CreateModelAssertionHeaders returns the model assertion headers for a model. The headers are
+// checked with the asserts package, so an invalid assertion is not signed
+func CreateModelAssertionHeaders(ctx context.Context, m datastore.Model) (map[string]interface{}, datastore.Keypair, error) {
 
 	// Get the assertion headers for the model
-	assert, err := datastore.Environ.DB.GetModelAssert(m.ID)
+	assert, err := datastore.Environ.DB.GetModelAssert(ctx, m.ID)
 	if err != nil {
 		return nil, datastore.Keypair{}, err
 	}
 
 	// Get the keypair for the model assertion
-	keypair, err := datastore.Environ.DB.GetKeypair(assert.KeypairID)
+	keypair, err := datastore.Environ.DB.GetKeypair(ctx, assert.KeypairID)
 	if err != nil {
 		return nil, keypair, err
 	}
 
+	// Get the assertion timestamp from the configured time source
+	signingTime, _, err := timestamp.Now(datastore.Environ.Config)
+	if err != nil {
+		return nil, keypair, err
+	}
+
+	headers := modelAssertionHeaders(m, assert, keypair.KeyID, signingTime)
+	if err := checkModelAssertionHeaders(headers); err != nil {
+		return nil, keypair, err
+	}
+	return headers, keypair, nil
+}
+
+// CheckModelAssertion checks the model assertion headers of a model before they are stored, with
+// the asserts package as they are checked when the assertion is signed
+func CheckModelAssertion(ctx context.Context, m datastore.Model, assert datastore.ModelAssertion) error {
+	if err := datastore.ValidateModelAssertion(assert); err != nil {
+		return err
+	}
+
+	keypair, err := datastore.Environ.DB.GetKeypair(ctx, assert.KeypairID)
+	if err != nil {
+		return err
+	}
+
+	headers := modelAssertionHeaders(m, assert, keypair.KeyID, time.Now().UTC())
+	if err := checkModelAssertionHeaders(headers); err != nil {
+		return fmt.Errorf("invalid model assertion: %v", err)
+	}
+	return nil
+}
+
+// checkModelAssertionHeaders assembles an unsigned model assertion from the headers, which checks
+// them with the asserts package
+func checkModelAssertionHeaders(headers map[string]interface{}) error {
+	_, err := asserts.Assemble(headers, nil, nil, []byte("unsigned"))
+	return err
+}
+
+// modelAssertionHeaders builds the model assertion headers of a model
+func modelAssertionHeaders(m datastore.Model, assert datastore.ModelAssertion, keyID string, signingTime time.Time) map[string]interface{} {
 	// Create the model assertion header
 	headers := map[string]interface{}{
 		"type":              asserts.ModelType.Name,
@@ -103,8 +150,8 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 		"series":            fmt.Sprintf("%d", assert.Series),
 		"model":             m.Name,
 		"store":             assert.Store,
-		"sign-key-sha3-384": keypair.KeyID,
-		"timestamp":         time.Now().Format(time.RFC3339),
+		"sign-key-sha3-384": keyID,
+		"timestamp":         signingTime.Format(time.RFC3339),
 	}
 
 	// Add the optional fields as needed
@@ -118,7 +165,8 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 	}
 
 	// Some headers are required for Ubuntu Core, whilst optional or invalid for Classic
-	if headers["classic"] == "true" {
+	switch {
+	case headers["classic"] == "true":
 		// Classic
 		if len(assert.Architecture) != 0 {
 			headers["architecture"] = assert.Architecture
@@ -126,7 +174,21 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 		if len(assert.Gadget) != 0 {
 			headers["gadget"] = assert.Gadget
 		}
-	} else {
+	case len(assert.Grade) != 0:
+		// Core 20, where the kernel, the gadget and the required snaps are in the snaps list
+		headers["architecture"] = assert.Architecture
+		headers["base"] = assert.Base
+		headers["grade"] = assert.Grade
+		headers["snaps"] = snapsHeader(assert.Snaps)
+
+		if len(assert.StorageSafety) != 0 {
+			headers["storage-safety"] = assert.StorageSafety
+		}
+		if len(assert.ValidationSets) != 0 {
+			headers["validation-sets"] = validationSetsHeader(assert.ValidationSets)
+		}
+		return headers
+	default:
 		// Core
 		headers["kernel"] = assert.Kernel
 		headers["architecture"] = assert.Architecture
@@ -139,7 +201,7 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 
 	// Check if the optional fields as needed
 	if len(assert.RequiredSnaps) == 0 {
-		return headers, keypair, nil
+		return headers
 	}
 
 	snapList := strings.Split(assert.RequiredSnaps, ",")
@@ -149,6 +211,62 @@ func CreateModelAssertionHeaders(m datastore.Model) (map[string]interface{}, dat
 	}
 	headers["required-snaps"] = reqdSnaps
 
+	return headers
+}
+
+// snapsHeader returns the snaps header of a Core 20 model assertion, where the values are strings or lists
+func snapsHeader(snaps []datastore.ModelSnap) []interface{} {
+	header := []interface{}{}
+	for _, s := range snaps {
+		snap := map[string]interface{}{"name": s.Name}
+		if len(s.ID) != 0 {
+			snap["id"] = s.ID
+		}
+		if len(s.Type) != 0 {
+			snap["type"] = s.Type
+		}
+		if len(s.DefaultChannel) != 0 {
+			snap["default-channel"] = s.DefaultChannel
+		}
+		if len(s.Modes) != 0 {
+			modes := []interface{}{}
+			for _, m := range s.Modes {
+				modes = append(modes, m)
+			}
+			snap["modes"] = modes
+		}
+		if len(s.Presence) != 0 {
+			snap["presence"] = s.Presence
+		}
+		header = append(header, snap)
+	}
+	return header
+}
+
+// validationSetsHeader returns the validation-sets header of a Core 20 model assertion
+func validationSetsHeader(sets []datastore.ModelValidationSet) []interface{} {
+	header := []interface{}{}
+	for _, v := range sets {
+		set := map[string]interface{}{"account-id": v.AccountID, "name": v.Name, "mode": v.Mode}
+		if v.Sequence > 0 {
+			set["sequence"] = strconv.Itoa(v.Sequence)
+		}
+		header = append(header, set)
+	}
+	return header
+}
+
+// CreatePivotModelAssertionHeaders returns the model assertion headers for a sub-store model, which are
+// the headers of the original model with the sub-store details
+func CreatePivotModelAssertionHeaders(ctx context.Context, substore datastore.Substore) (map[string]interface{}, datastore.Keypair, error) {
+	headers, keypair, err := CreateModelAssertionHeaders(ctx, substore.FromModel)
+	if err != nil {
+		return nil, keypair, err
+	}
+
+	headers["model"] = substore.ModelName
+	headers["store"] = substore.Store
+	substore.SetOriginalHeaders(headers)
 	return headers, keypair, nil
 }
 
@@ -21,6 +21,7 @@
 package assertion
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -38,7 +39,7 @@ const (
 )
 
 // validateAssertionAction is called by the API method to check a serial assertion
-func validateAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, assertion asserts.Assertion) {
+func validateAssertionAction(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, assertion asserts.Assertion) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(authUser, datastore.Admin, apiCall)
@@ -48,22 +49,22 @@ func validateAssertionAction(w http.ResponseWriter, authUser datastore.User, api
 	}
 
 	// Check that the account is accessbible by the user
-	if _, err = datastore.Environ.DB.GetAllowedAccount(assertion.HeaderString("brand-id"), authUser); err != nil {
+	if _, err = datastore.Environ.DB.GetAllowedAccount(ctx, assertion.HeaderString("brand-id"), authUser); err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidAccount.Code, "", response.ErrorInvalidAccount.Message, w)
 		return
 	}
 
 	// Assume this is an original (non-pivoted) serial assertion
 	// Validate the model by checking that it exists on the database
-	if modelFound := datastore.Environ.DB.CheckModelExists(assertion.HeaderString("brand-id"), assertion.HeaderString("model")); modelFound {
+	if modelFound := datastore.Environ.DB.CheckModelExists(ctx, assertion.HeaderString("brand-id"), assertion.HeaderString("model")); modelFound {
 		response.FormatStandardResponse(true, responseValidModel, "", "", w)
 		return
 	}
 
 	// Assume that this is a pivoted serial assertion
 	// Check for a sub-store model for the pivot
-	if _, err = datastore.Environ.DB.GetSubstoreModel(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial")); err != nil {
-		log.Println(err)
+	if _, err = datastore.Environ.DB.GetSubstoreModel(ctx, assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial")); err != nil {
+		log.Error(err)
 		log.Message("CHECK", "invalid-substore", "Cannot find sub-store model")
 		response.FormatStandardResponse(false, response.ErrorInvalidSubstore.Code, "", response.ErrorInvalidSubstore.Message, w)
 		return
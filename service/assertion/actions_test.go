@@ -0,0 +1,100 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion_test
+
+import (
+	"context"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+// uc20MockDB mocks the database with the headers of a Ubuntu Core 20 model assertion
+type uc20MockDB struct {
+	datastore.MockDB
+	assert datastore.ModelAssertion
+}
+
+func (mdb *uc20MockDB) GetModelAssert(ctx context.Context, modelID int) (datastore.ModelAssertion, error) {
+	return mdb.assert, nil
+}
+
+func uc20ModelAssertion() datastore.ModelAssertion {
+	return datastore.ModelAssertion{
+		ModelID: 1, KeypairID: 1, Series: 16, Architecture: "amd64", Store: "ubuntu",
+		Base: "core20", Grade: "secured", StorageSafety: "encrypted",
+		Snaps: []datastore.ModelSnap{
+			{Name: "pc", ID: "UqFziVZDHLSyO3TqSWgNBoAdHbLI4dAH", Type: "gadget", DefaultChannel: "20/stable"},
+			{Name: "pc-kernel", ID: "pYVQrBcKmBa0mZ4CCN7ExT6jH8rY1hza", Type: "kernel", DefaultChannel: "20/stable"},
+			{Name: "htop", ID: "hJmReLmgXSUj4SF7WhyTVRV6IzUa4QUZ", DefaultChannel: "latest/stable", Modes: []string{"run"}, Presence: "optional"},
+		},
+		ValidationSets: []datastore.ModelValidationSet{{AccountID: "system", Name: "base-set", Sequence: 3, Mode: "enforce"}},
+	}
+}
+
+func (s *AssertionSuite) TestCreateModelAssertionHeadersUC20(c *check.C) {
+	mdb := &uc20MockDB{assert: uc20ModelAssertion()}
+	datastore.Environ.DB = mdb
+	defer func() { datastore.Environ.DB = &datastore.MockDB{} }()
+	m := datastore.Model{ID: 1, BrandID: "system", Name: "alder"}
+
+	headers, _, err := assertion.CreateModelAssertionHeaders(context.Background(), m)
+	c.Assert(err, check.IsNil)
+	c.Assert(headers["grade"], check.Equals, "secured")
+	c.Assert(headers["storage-safety"], check.Equals, "encrypted")
+	c.Assert(headers["validation-sets"], check.HasLen, 1)
+	for _, h := range []string{"kernel", "gadget", "required-snaps"} {
+		_, ok := headers[h]
+		c.Assert(ok, check.Equals, false)
+	}
+
+	a, err := asserts.Assemble(headers, nil, nil, []byte("unsigned"))
+	c.Assert(err, check.IsNil)
+	model := a.(*asserts.Model)
+	c.Assert(string(model.Grade()), check.Equals, "secured")
+	c.Assert(model.Kernel(), check.Equals, "pc-kernel")
+	c.Assert(model.Gadget(), check.Equals, "pc")
+	c.Assert(model.EssentialSnaps(), check.HasLen, 3)
+	c.Assert(model.SnapsWithoutEssential(), check.HasLen, 1)
+	c.Assert(string(model.StorageSafety()), check.Equals, "encrypted")
+
+	// A secured model needs the IDs of the snaps
+	mdb.assert.Snaps[0].ID = ""
+	_, _, err = assertion.CreateModelAssertionHeaders(context.Background(), m)
+	c.Assert(err, check.NotNil)
+}
+
+func (s *AssertionSuite) TestCheckModelAssertionUC20(c *check.C) {
+	m := datastore.Model{ID: 1, BrandID: "system", Name: "alder"}
+	assert := uc20ModelAssertion()
+	c.Assert(assertion.CheckModelAssertion(context.Background(), m, assert), check.IsNil)
+
+	// The kernel is in the snaps list
+	assert.Kernel = "pc-kernel"
+	c.Assert(assertion.CheckModelAssertion(context.Background(), m, assert), check.NotNil)
+
+	// The gadget snap is missing
+	assert = uc20ModelAssertion()
+	assert.Snaps = assert.Snaps[1:]
+	c.Assert(assertion.CheckModelAssertion(context.Background(), m, assert), check.NotNil)
+}
@@ -21,6 +21,7 @@
 package assertion
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -39,7 +40,7 @@ import (
 )
 
 // systemUserAssertionAction is called by the API method to generate a system-user assertion
-func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, apiCall bool, user SystemUserRequest) {
+func systemUserAssertionAction(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, user SystemUserRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(authUser, datastore.Standard, apiCall)
@@ -49,16 +50,16 @@ func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, a
 	}
 
 	// Get the model:
-	model, err := datastore.Environ.DB.GetAllowedModel(user.ModelID, datastore.User{})
+	model, err := datastore.Environ.DB.GetAllowedModel(ctx, user.ModelID, datastore.User{})
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		log.Message("USER", response.ErrorInvalidModelID.Code, response.ErrorInvalidModelID.Message)
 		response.FormatStandardResponse(false, response.ErrorInvalidModelID.Code, "", response.ErrorInvalidModelID.Message, w)
 		return
 	}
 
 	// Generate the system-user assertion and return the response
-	resp := GenerateSystemUserAssertion(user, model)
+	resp := GenerateSystemUserAssertion(ctx, user, model)
 	if !resp.Success {
 		w.WriteHeader(http.StatusBadRequest)
 	}
@@ -70,7 +71,7 @@ func systemUserAssertionAction(w http.ResponseWriter, authUser datastore.User, a
 }
 
 // GenerateSystemUserAssertion creates a system-user assertion from the model and user details
-func GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model) SystemUserResponse {
+func GenerateSystemUserAssertion(ctx context.Context, user SystemUserRequest, model datastore.Model) SystemUserResponse {
 	// Check that the model has an active system-user keypair
 	if !model.KeyActiveUser {
 		log.Message("USER", response.ErrorInactiveModel.Code, response.ErrorInactiveModel.Message)
@@ -78,7 +79,7 @@ func GenerateSystemUserAssertion(user SystemUserRequest, model datastore.Model)
 	}
 
 	// Fetch the account assertion from the database
-	account, err := datastore.Environ.DB.GetAccount(model.AuthorityIDUser)
+	account, err := datastore.Environ.DB.GetAccount(ctx, model.AuthorityIDUser)
 	if err != nil {
 		log.Message("USER", response.ErrorAccountAssertion.Code, err.Error())
 		return SystemUserResponse{ErrorCode: response.ErrorAccountAssertion.Code, ErrorMessage: response.ErrorAccountAssertion.Message}
@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// maxSystemUserBatch is the most system-user assertions that are generated in one batch
+const maxSystemUserBatch = 200
+
+// validBundleName matches the usernames that are used as the file names of the bundle
+var validBundleName = regexp.MustCompile(`^[a-z0-9][-a-z0-9+._]*$`)
+
+// systemUserBatchAction generates the system-user assertions of a batch, and returns them as a zip
+// bundle with an assertion file per user. No bundle is returned when any of the assertions fails,
+// so a factory line is never given a partial batch
+func systemUserBatchAction(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, batch SystemUserBatchRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Standard, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	if failed := validateSystemUserBatch(batch); len(failed) > 0 {
+		log.Message("USER", response.ErrorSystemUserBatch.Code, response.ErrorSystemUserBatch.Message)
+		formatSystemUserBatchResponse(failed, w)
+		return
+	}
+
+	// Get the model:
+	model, err := datastore.Environ.DB.GetAllowedModel(ctx, batch.ModelID, datastore.User{})
+	if err != nil {
+		log.Error(err)
+		log.Message("USER", response.ErrorInvalidModelID.Code, response.ErrorInvalidModelID.Message)
+		response.FormatStandardResponse(false, response.ErrorInvalidModelID.Code, "", response.ErrorInvalidModelID.Message, w)
+		return
+	}
+
+	// Generate the system-user assertions, with the validity of the batch unless the user has their own
+	assertions := []string{}
+	failed := []SystemUserBatchResult{}
+	for _, user := range batch.Users {
+		user.ModelID = batch.ModelID
+		if len(user.Since) == 0 {
+			user.Since = batch.Since
+		}
+		if len(user.Until) == 0 {
+			user.Until = batch.Until
+		}
+
+		resp := GenerateSystemUserAssertion(ctx, user, model)
+		if !resp.Success {
+			failed = append(failed, SystemUserBatchResult{Username: user.Username, ErrorCode: resp.ErrorCode, ErrorMessage: resp.ErrorMessage})
+			continue
+		}
+		assertions = append(assertions, resp.Assertion)
+	}
+	if len(failed) > 0 {
+		log.Message("USER", response.ErrorSystemUserBatch.Code, response.ErrorSystemUserBatch.Message)
+		formatSystemUserBatchResponse(failed, w)
+		return
+	}
+
+	bundle, err := systemUserBundle(batch.Users, assertions)
+	if err != nil {
+		log.Message("USER", response.ErrorSystemUserBatch.Code, err.Error())
+		response.FormatStandardResponse(false, response.ErrorSystemUserBatch.Code, "", err.Error(), w)
+		return
+	}
+
+	filename := fmt.Sprintf("system-users-%s-%s-%s.zip", model.BrandID, model.Name, time.Now().UTC().Format("20060102-150405"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.Header().Set("Content-Length", strconv.Itoa(len(bundle)))
+	w.WriteHeader(http.StatusOK)
+	if _, err := w.Write(bundle); err != nil {
+		log.Errorf("Error writing the system-user bundle: %v", err)
+	}
+}
+
+// validateSystemUserBatch checks the users of a batch, returning the users that are not valid
+func validateSystemUserBatch(batch SystemUserBatchRequest) []SystemUserBatchResult {
+	if len(batch.Users) == 0 {
+		return []SystemUserBatchResult{{ErrorCode: response.ErrorEmptyData.Code, ErrorMessage: "The batch has no users"}}
+	}
+	if len(batch.Users) > maxSystemUserBatch {
+		return []SystemUserBatchResult{{ErrorCode: response.ErrorSystemUserBatch.Code, ErrorMessage: fmt.Sprintf("The batch must not have more than %d users", maxSystemUserBatch)}}
+	}
+
+	failed := []SystemUserBatchResult{}
+	usernames := map[string]bool{}
+	for _, user := range batch.Users {
+		var message string
+		switch {
+		case !validBundleName.MatchString(user.Username):
+			message = "The username is not valid"
+		case usernames[user.Username]:
+			message = "The username is repeated in the batch"
+		case len(user.Password) == 0 && len(user.SSHKeys) == 0:
+			message = "The password or the SSH keys must be provided"
+		}
+		usernames[user.Username] = true
+
+		if len(message) > 0 {
+			failed = append(failed, SystemUserBatchResult{Username: user.Username, ErrorCode: response.ErrorSystemUserBatch.Code, ErrorMessage: message})
+		}
+	}
+	return failed
+}
+
+// systemUserBundle returns the zip bundle of the system-user assertions, with a file per username
+func systemUserBundle(users []SystemUserRequest, assertions []string) ([]byte, error) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+
+	modified := time.Now().UTC()
+	for i, user := range users {
+		f, err := zw.CreateHeader(&zip.FileHeader{Name: user.Username + ".assert", Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, err
+		}
+		if _, err = f.Write([]byte(assertions[i])); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func formatSystemUserBatchResponse(failed []SystemUserBatchResult, w http.ResponseWriter) {
+	w.WriteHeader(http.StatusBadRequest)
+	resp := SystemUserBatchResponse{
+		ErrorCode:    response.ErrorSystemUserBatch.Code,
+		ErrorMessage: response.ErrorSystemUserBatch.Message,
+		Users:        failed,
+	}
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		log.Error("Error forming the system-user batch response.")
+	}
+}
@@ -0,0 +1,127 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/timestamp"
+	"github.com/snapcore/snapd/asserts"
+	"github.com/snapcore/snapd/release"
+)
+
+// validationSetAction composes and signs a validation-set assertion with a signing-key of the account,
+// which is the account of the validation set
+func validationSetAction(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, request ValidationSetRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "", w)
+		return
+	}
+
+	keypair, err := datastore.Environ.DB.GetKeypair(ctx, request.KeypairID)
+	if err != nil {
+		log.Message("VALIDATIONSET", response.ErrorFetchKeypair.Code, err.Error())
+		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", response.ErrorFetchKeypair.Message, w)
+		return
+	}
+
+	// Check that the user can use the signing-keys of the account
+	acc, err := datastore.Environ.DB.GetAllowedAccount(ctx, keypair.AuthorityID, authUser)
+	if err != nil || acc.AuthorityID != keypair.AuthorityID {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", response.ErrorAuth.Message, w)
+		return
+	}
+	if !keypair.Active {
+		response.FormatStandardResponse(false, response.ErrorInactiveKeypair.Code, "", response.ErrorInactiveKeypair.Message, w)
+		return
+	}
+
+	// Get the assertion timestamp from the configured time source
+	signingTime, _, err := timestamp.Now(datastore.Environ.Config)
+	if err != nil {
+		log.Message("VALIDATIONSET", response.ErrorTimestampSource.Code, err.Error())
+		response.FormatStandardResponse(false, response.ErrorTimestampSource.Code, "", response.ErrorTimestampSource.Message, w)
+		return
+	}
+
+	// Check the headers with the asserts package before they are signed
+	headers := validationSetHeaders(request, keypair, signingTime)
+	if _, err := asserts.Assemble(headers, nil, nil, []byte("unsigned")); err != nil {
+		log.Message("VALIDATIONSET", response.ErrorCreateValidationSet.Code, err.Error())
+		response.FormatStandardResponse(false, response.ErrorCreateValidationSet.Code, "", err.Error(), w)
+		return
+	}
+
+	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ValidationSetType, headers, nil, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
+	if err != nil {
+		log.Message("VALIDATIONSET", response.ErrorSignAssertion.Code, err.Error())
+		response.FormatStandardResponse(false, response.ErrorSignAssertion.Code, "", err.Error(), w)
+		return
+	}
+
+	audit.Record(ctx, authUser, audit.ActionSign, audit.ObjectValidationSet, keypair.ID, keypair.AuthorityID, nil, request)
+
+	// Return successful response with the signed assertion
+	formatAssertionResponse([]asserts.Assertion{signedAssertion}, w)
+}
+
+// validationSetHeaders builds the validation-set assertion headers, which are signed by the account
+// of the validation set
+func validationSetHeaders(request ValidationSetRequest, keypair datastore.Keypair, signingTime time.Time) map[string]interface{} {
+	snaps := []interface{}{}
+	for _, s := range request.Snaps {
+		snap := map[string]interface{}{"name": s.Name, "id": s.ID}
+		if len(s.Presence) != 0 {
+			snap["presence"] = s.Presence
+		}
+		if s.Revision != 0 {
+			snap["revision"] = strconv.Itoa(s.Revision)
+		}
+		snaps = append(snaps, snap)
+	}
+
+	headers := map[string]interface{}{
+		"type":              asserts.ValidationSetType.Name,
+		"authority-id":      keypair.AuthorityID,
+		"account-id":        keypair.AuthorityID,
+		"series":            release.Series,
+		"name":              request.Name,
+		"sequence":          strconv.Itoa(request.Sequence),
+		"snaps":             snaps,
+		"sign-key-sha3-384": keypair.KeyID,
+		"timestamp":         signingTime.Format(time.RFC3339),
+	}
+	if request.Revision != 0 {
+		headers["revision"] = strconv.Itoa(request.Revision)
+	}
+	return headers
+}
@@ -55,7 +55,24 @@ func APISystemUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	systemUserAssertionAction(w, authUser, true, user)
+	systemUserAssertionAction(r.Context(), w, authUser, true, user)
+}
+
+// APISystemUserBatch is the API method to generate the signed system-user assertions of a batch of users
+func APISystemUserBatch(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	authUser, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	batch, ok := decodeSystemUserBatch(w, r)
+	if !ok {
+		return
+	}
+
+	systemUserBatchAction(r.Context(), w, authUser, true, batch)
 }
 
 // APIValidateSerial is the API method to validate a serial assertion for a device
@@ -73,7 +90,7 @@ func APIValidateSerial(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	validateAssertionAction(w, authUser, true, assertion)
+	validateAssertionAction(r.Context(), w, authUser, true, assertion)
 }
 
 func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorResponse) {
@@ -98,3 +115,20 @@ func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorRes
 	}
 	return assertion, response.ErrorResponse{Success: true}
 }
+
+// APIValidationSet is the API method to compose and sign a validation-set assertion
+func APIValidationSet(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	authUser, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vs, ok := decodeValidationSet(w, r)
+	if !ok {
+		return
+	}
+
+	validationSetAction(r.Context(), w, authUser, true, vs)
+}
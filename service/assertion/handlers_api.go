@@ -21,10 +21,13 @@
 package assertion
 
 import (
+	"bytes"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
@@ -39,17 +42,25 @@ type ModelAssertionRequest struct {
 // ModelAssertion is the API method to generate a model assertion
 func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	// Validate the model API key
-	apiKey, err := request.CheckModelAPI(r)
+	k, err := request.CheckModelAPI(r)
 	if err != nil {
 		log.Message("MODEL", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
 		return response.ErrorInvalidAPIKey
 	}
+	audit.DeprecatedAPIKey(r.Context(), k)
+	apiKey := k.Key
 
 	defer r.Body.Close()
 
+	// The body is kept to check the signature of the request
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return response.ErrorResponse{Success: false, Code: response.ErrorDecodeJSON.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
 	// Decode the JSON body
-	request := ModelAssertionRequest{}
-	err = json.NewDecoder(r.Body).Decode(&request)
+	modelRequest := ModelAssertionRequest{}
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&modelRequest)
 	switch {
 	// Check we have some data
 	case err == io.EOF:
@@ -59,5 +70,10 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 		return response.ErrorResponse{Success: false, Code: response.ErrorDecodeJSON.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	return modelAssertionHandler(w, apiKey, request)
+	// Check the signature of the request, when the account signs its requests
+	if err := request.CheckSignature(r, body, modelRequest.BrandID); err != nil {
+		return response.ErrorInvalidSignature
+	}
+
+	return modelAssertionHandler(r.Context(), w, apiKey, modelRequest)
 }
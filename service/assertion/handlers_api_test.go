@@ -55,11 +55,17 @@ type AssertionTest struct {
 
 var expectedPrometheusData = []string{
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionAPISystemUser" > counter:<value:2 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionAPISystemUserBatch" > counter:<value:1 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionAPIValidateSerial" > counter:<value:1 > `,
-	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionModelAssertion" > counter:<value:2 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionAPIValidationSet" > counter:<value:1 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionModelAssertion" > counter:<value:3 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionSystemUserAssertion" > counter:<value:2 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionSystemUserBatch" > counter:<value:1 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"200" > label:<name:"view" value:"assertionValidationSet" > counter:<value:1 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionAPISystemUser" > counter:<value:3 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionAPISystemUserBatch" > counter:<value:6 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionAPIValidateSerial" > counter:<value:8 > `,
+	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionAPIValidationSet" > counter:<value:7 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionModelAssertion" > counter:<value:8 > `,
 	`label:<name:"method" value:"POST" > label:<name:"status" value:"400" > label:<name:"view" value:"assertionSystemUserAssertion" > counter:<value:5 > `,
 }
@@ -129,6 +135,7 @@ func (s *AssertionSuite) TestAssertionHandler(c *check.C) {
 		{nil, 400, response.JSONHeader, "ValidAPIKey"},
 		{[]byte{}, 400, response.JSONHeader, "ValidAPIKey"},
 		{validModel(), 200, asserts.MediaType, "ValidAPIKey"},
+		{validModel(), 200, asserts.MediaType, "ReadOnlyAPIKey"},
 		{validModel(), 400, response.JSONHeader, "InvalidAPIKey"},
 		{classicModel(), 200, asserts.MediaType, "ValidAPIKey"},
 		{classicModel(), 400, response.JSONHeader, "InvalidAPIKey"},
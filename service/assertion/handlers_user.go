@@ -63,6 +63,30 @@ type SystemUserResponse struct {
 	Assertion    string `json:"assertion"`
 }
 
+// SystemUserBatchRequest is the JSON version of the request to create the system-user assertions of
+// a batch of users. The validity of the batch is used for the users without their own
+type SystemUserBatchRequest struct {
+	ModelID int                 `json:"model"`
+	Since   string              `json:"since"`
+	Until   string              `json:"until"`
+	Users   []SystemUserRequest `json:"users"`
+}
+
+// SystemUserBatchResult is the error of a user of a batch
+type SystemUserBatchResult struct {
+	Username     string `json:"username"`
+	ErrorCode    string `json:"error_code"`
+	ErrorMessage string `json:"message"`
+}
+
+// SystemUserBatchResponse is the response from a failed batch, with the users that failed
+type SystemUserBatchResponse struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorMessage string                  `json:"message"`
+	Users        []SystemUserBatchResult `json:"users"`
+}
+
 // SystemUserAssertion is the API method to generate a signed system-user assertion for a device
 func SystemUserAssertion(w http.ResponseWriter, r *http.Request) {
 	authUser, err := auth.GetUserFromJWT(w, r)
@@ -90,5 +114,38 @@ func SystemUserAssertion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	systemUserAssertionAction(w, authUser, false, user)
+	systemUserAssertionAction(r.Context(), w, authUser, false, user)
+}
+
+// SystemUserBatch is the API method to generate the signed system-user assertions of a batch of users,
+// which are returned as a zip bundle
+func SystemUserBatch(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	batch, ok := decodeSystemUserBatch(w, r)
+	if !ok {
+		return
+	}
+
+	systemUserBatchAction(r.Context(), w, authUser, false, batch)
+}
+
+func decodeSystemUserBatch(w http.ResponseWriter, r *http.Request) (SystemUserBatchRequest, bool) {
+	batch := SystemUserBatchRequest{}
+	err := json.NewDecoder(r.Body).Decode(&batch)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, response.ErrorEmptyData.Code, "", response.ErrorEmptyData.Message, w)
+		return batch, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorDecodeJSON.Code, "", err.Error(), w)
+		return batch, false
+	}
+	return batch, true
 }
@@ -21,11 +21,15 @@
 package assertion_test
 
 import (
+	"archive/zip"
 	"bytes"
 	"encoding/json"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
 	check "gopkg.in/check.v1"
@@ -103,3 +107,80 @@ func generateSystemUserRequestInvalidAssertion() string {
 
 	return string(req)
 }
+
+func (s *AssertionSuite) TestSystemUserBatchHandler(c *check.C) {
+	batch := assertion.SystemUserBatchRequest{
+		ModelID: 1, Since: "2017-03-24T12:34:00Z", Until: "2018-03-24T12:34:00Z",
+		Users: []assertion.SystemUserRequest{
+			{Email: "tech1@example.com", Name: "Tech One", Username: "tech1", Password: "super"},
+			{Email: "tech2@example.com", Name: "Tech Two", Username: "tech2", SSHKeys: []string{"ssh-rsa AAAA tech2"}, Until: "2017-04-24T12:34:00Z"},
+		},
+	}
+
+	for _, url := range []string{"/v1/assertions/batch", "/api/assertions/batch"} {
+		w := sendSystemUserBatch(url, batch, c)
+		c.Assert(w.Code, check.Equals, http.StatusOK)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/zip")
+		c.Assert(strings.HasPrefix(w.Header().Get("Content-Disposition"), `attachment; filename="system-users-system-alder-`), check.Equals, true)
+
+		zr, err := zip.NewReader(bytes.NewReader(w.Body.Bytes()), int64(w.Body.Len()))
+		c.Assert(err, check.IsNil)
+		c.Assert(zr.File, check.HasLen, 2)
+		for i, f := range zr.File {
+			c.Assert(f.Name, check.Equals, batch.Users[i].Username+".assert")
+			r, err := f.Open()
+			c.Assert(err, check.IsNil)
+			data, err := ioutil.ReadAll(r)
+			c.Assert(err, check.IsNil)
+			c.Assert(strings.Contains(string(data), "type: system-user"), check.Equals, true)
+			c.Assert(strings.Contains(string(data), "username: "+batch.Users[i].Username), check.Equals, true)
+		}
+	}
+}
+
+func (s *AssertionSuite) TestSystemUserBatchHandlerInvalid(c *check.C) {
+	user := assertion.SystemUserRequest{Email: "tech1@example.com", Name: "Tech One", Username: "tech1", Password: "super"}
+	noPassword := assertion.SystemUserRequest{Email: "tech2@example.com", Name: "Tech Two", Username: "tech2"}
+	badUsername := assertion.SystemUserRequest{Email: "tech3@example.com", Name: "Tech Three", Username: "Tech 3", Password: "super"}
+	badEmail := assertion.SystemUserRequest{Email: "tech4", Name: "Tech Four", Username: "tech4", Password: "super"}
+
+	tests := []struct {
+		batch  assertion.SystemUserBatchRequest
+		code   string
+		failed []string
+	}{
+		{assertion.SystemUserBatchRequest{ModelID: 1}, "system-user-batch", []string{""}},
+		{assertion.SystemUserBatchRequest{ModelID: 1, Users: []assertion.SystemUserRequest{user, user}}, "system-user-batch", []string{"tech1"}},
+		{assertion.SystemUserBatchRequest{ModelID: 1, Users: []assertion.SystemUserRequest{user, noPassword, badUsername}}, "system-user-batch", []string{"tech2", "Tech 3"}},
+		{assertion.SystemUserBatchRequest{ModelID: 1, Users: []assertion.SystemUserRequest{user, badEmail}}, "system-user-batch", []string{"tech4"}},
+		{assertion.SystemUserBatchRequest{ModelID: 99, Users: []assertion.SystemUserRequest{user}}, "invalid-model", nil},
+		{assertion.SystemUserBatchRequest{ModelID: 2, Users: []assertion.SystemUserRequest{user}}, "system-user-batch", []string{"tech1"}},
+	}
+
+	for _, t := range tests {
+		w := sendSystemUserBatch("/api/assertions/batch", t.batch, c)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := assertion.SystemUserBatchResponse{}
+		c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.code)
+		c.Assert(result.Users, check.HasLen, len(t.failed))
+		for i, u := range t.failed {
+			c.Assert(result.Users[i].Username, check.Equals, u)
+		}
+	}
+}
+
+func sendSystemUserBatch(url string, batch assertion.SystemUserBatchRequest, c *check.C) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(batch)
+	if strings.HasPrefix(url, "/api") {
+		return sendAdminAPIRequest("POST", url, bytes.NewReader(data), datastore.Standard, c)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", url, bytes.NewReader(data))
+	service.AdminRouter().ServeHTTP(w, r)
+	return w
+}
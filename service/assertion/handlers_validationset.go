@@ -0,0 +1,80 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ValidationSetRequest is the JSON version of the request to sign a validation-set assertion
+// with a signing-key of the account
+type ValidationSetRequest struct {
+	KeypairID int                 `json:"keypair_id"`
+	Name      string              `json:"name"`
+	Sequence  int                 `json:"sequence"`
+	Revision  int                 `json:"revision"`
+	Snaps     []ValidationSetSnap `json:"snaps"`
+}
+
+// ValidationSetSnap is a snap of a validation set
+type ValidationSetSnap struct {
+	Name     string `json:"name"`
+	ID       string `json:"id"`
+	Presence string `json:"presence,omitempty"`
+	Revision int    `json:"revision,omitempty"`
+}
+
+// ValidationSet is the API method to compose and sign a validation-set assertion
+func ValidationSet(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	vs, ok := decodeValidationSet(w, r)
+	if !ok {
+		return
+	}
+
+	validationSetAction(r.Context(), w, authUser, false, vs)
+}
+
+func decodeValidationSet(w http.ResponseWriter, r *http.Request) (ValidationSetRequest, bool) {
+	vs := ValidationSetRequest{}
+	err := json.NewDecoder(r.Body).Decode(&vs)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, response.ErrorEmptyData.Code, "", response.ErrorEmptyData.Message, w)
+		return vs, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorDecodeJSON.Code, "", err.Error(), w)
+		return vs, false
+	}
+	return vs, true
+}
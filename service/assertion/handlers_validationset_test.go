@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+func validationSetRequest() assertion.ValidationSetRequest {
+	return assertion.ValidationSetRequest{
+		KeypairID: 1, Name: "base-set", Sequence: 2,
+		Snaps: []assertion.ValidationSetSnap{
+			{Name: "pc-kernel", ID: "pYVQrBcKmBa0mZ4CCN7ExT6jH8rY1hza", Revision: 42},
+			{Name: "htop", ID: "hJmReLmgXSUj4SF7WhyTVRV6IzUa4QUZ", Presence: "optional"},
+		},
+	}
+}
+
+func (s *AssertionSuite) TestValidationSetHandler(c *check.C) {
+	for _, url := range []string{"/v1/assertions/validationset", "/api/assertions/validationset"} {
+		w := sendValidationSet(url, validationSetRequest(), datastore.Admin, c)
+		c.Assert(w.Code, check.Equals, http.StatusOK)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, asserts.MediaType)
+
+		a, err := asserts.NewDecoder(w.Body).Decode()
+		c.Assert(err, check.IsNil)
+		c.Assert(a.Type(), check.Equals, asserts.ValidationSetType)
+		vs := a.(*asserts.ValidationSet)
+		c.Assert(vs.AccountID(), check.Equals, "system")
+		c.Assert(vs.Name(), check.Equals, "base-set")
+		c.Assert(vs.Sequence(), check.Equals, 2)
+		c.Assert(vs.Snaps(), check.HasLen, 2)
+		c.Assert(vs.Snaps()[0].Revision, check.Equals, 42)
+		c.Assert(vs.Snaps()[1].Presence, check.Equals, asserts.PresenceOptional)
+	}
+}
+
+func (s *AssertionSuite) TestValidationSetHandlerInvalid(c *check.C) {
+	invalidName := validationSetRequest()
+	invalidName.Name = "Base_Set"
+	invalidSequence := validationSetRequest()
+	invalidSequence.Sequence = 0
+	invalidSnapID := validationSetRequest()
+	invalidSnapID.Snaps[0].ID = "invalid"
+	repeatedSnap := validationSetRequest()
+	repeatedSnap.Snaps[1] = repeatedSnap.Snaps[0]
+	invalidPresence := validationSetRequest()
+	invalidPresence.Snaps[0].Presence = "invalid"
+
+	tests := []struct {
+		request     assertion.ValidationSetRequest
+		permissions int
+		code        string
+	}{
+		{validationSetRequest(), datastore.Standard, response.ErrorAuth.Code},
+		{invalidName, datastore.Admin, response.ErrorCreateValidationSet.Code},
+		{invalidSequence, datastore.Admin, response.ErrorCreateValidationSet.Code},
+		{invalidSnapID, datastore.Admin, response.ErrorCreateValidationSet.Code},
+		{repeatedSnap, datastore.Admin, response.ErrorCreateValidationSet.Code},
+		{invalidPresence, datastore.Admin, response.ErrorCreateValidationSet.Code},
+	}
+
+	for _, t := range tests {
+		w := sendValidationSet("/api/assertions/validationset", t.request, t.permissions, c)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.ErrorCode, check.Equals, t.code)
+	}
+}
+
+func (s *AssertionSuite) TestValidationSetHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	defer func() { datastore.Environ.DB = &datastore.MockDB{} }()
+
+	w := sendValidationSet("/api/assertions/validationset", validationSetRequest(), datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+}
+
+func sendValidationSet(url string, request assertion.ValidationSetRequest, permissions int, c *check.C) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(request)
+	if strings.HasPrefix(url, "/api") {
+		return sendAdminAPIRequest("POST", url, bytes.NewReader(data), permissions, c)
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", url, bytes.NewReader(data))
+	service.AdminRouter().ServeHTTP(w, r)
+	return w
+}
@@ -0,0 +1,180 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// defaultRefreshInterval is the interval of the refresh of the signed model assertions when it is not configured
+const defaultRefreshInterval = 10 * time.Minute
+
+// RefreshInterval returns the interval of the refresh of the signed model assertions from the config settings
+func RefreshInterval(settings config.Settings) (time.Duration, error) {
+	if len(settings.ModelAssertionRefreshInterval) == 0 {
+		return defaultRefreshInterval, nil
+	}
+
+	interval, err := time.ParseDuration(settings.ModelAssertionRefreshInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid model assertion refresh interval: %v", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("the model assertion refresh interval must be positive")
+	}
+	return interval, nil
+}
+
+// RefreshJob refreshes the signed model assertions when the scheduler starts, and then at the interval
+func RefreshJob(interval time.Duration) scheduler.Job {
+	return scheduler.Job{Name: "model-assertion-refresh", Interval: interval, RunAtStart: true, Run: Refresh}
+}
+
+// Refresh signs the stored model assertions again when the headers or the signing key of their
+// model have changed, so a pivoting device is not served a stale assertion. The assertions of the
+// deleted models and sub-store models are removed
+func Refresh(ctx context.Context) error {
+	stored, err := datastore.Environ.DB.ListSignedModelAsserts(ctx)
+	if err != nil {
+		return err
+	}
+
+	refreshed, failed := 0, 0
+	for _, s := range stored {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		model, headers, keypair, err := storedAssertionHeaders(ctx, s)
+		if err != nil {
+			log.Infof("Removing the signed model assertion of model %d (sub-store %d): %v", s.ModelID, s.SubstoreID, err)
+			if err := datastore.Environ.DB.DeleteSignedModelAssert(ctx, s.ID); err != nil {
+				return err
+			}
+			continue
+		}
+
+		digest := headersDigest(headers)
+		if digest == s.Digest {
+			continue
+		}
+		if _, err := signModelAssertion(ctx, model, s.SubstoreID, headers, keypair, digest); err != nil {
+			log.Errorf("Error refreshing the signed model assertion of model %d (sub-store %d): %v", s.ModelID, s.SubstoreID, err)
+			failed++
+			continue
+		}
+		refreshed++
+	}
+
+	if refreshed > 0 {
+		log.Infof("Refreshed %d signed model assertions", refreshed)
+	}
+	if failed > 0 {
+		return fmt.Errorf("error refreshing %d signed model assertions", failed)
+	}
+	return nil
+}
+
+// storedAssertionHeaders returns the current headers of a stored model assertion, from its model
+// or its sub-store model
+func storedAssertionHeaders(ctx context.Context, s datastore.SignedModelAssertion) (datastore.Model, map[string]interface{}, datastore.Keypair, error) {
+	if s.SubstoreID == 0 {
+		model, err := datastore.Environ.DB.GetAllowedModel(ctx, s.ModelID, datastore.User{})
+		if err != nil {
+			return model, nil, datastore.Keypair{}, err
+		}
+		if model.DeletedAt != nil {
+			return model, nil, datastore.Keypair{}, errors.New("the model is deleted")
+		}
+		headers, keypair, err := CreateModelAssertionHeaders(ctx, model)
+		return model, headers, keypair, err
+	}
+
+	substore, err := datastore.Environ.DB.GetSubstoreByID(ctx, s.SubstoreID)
+	if err != nil {
+		return substore.FromModel, nil, datastore.Keypair{}, err
+	}
+	if substore.FromModelID != s.ModelID || substore.FromModel.DeletedAt != nil {
+		return substore.FromModel, nil, datastore.Keypair{}, errors.New("the sub-store model is moved or deleted")
+	}
+	headers, keypair, err := CreatePivotModelAssertionHeaders(ctx, substore)
+	return substore.FromModel, headers, keypair, err
+}
+
+// SignModelAssertion returns the signed model assertion of the model, or of its sub-store model.
+// The stored assertion is returned when it was signed with the same headers and signing key,
+// otherwise the assertion is signed and stored
+func SignModelAssertion(ctx context.Context, model datastore.Model, substoreID int, headers map[string]interface{}, keypair datastore.Keypair) (asserts.Assertion, error) {
+	digest := headersDigest(headers)
+
+	stored, err := datastore.Environ.DB.GetSignedModelAssert(ctx, model.ID, substoreID)
+	if err == nil && stored.Digest == digest {
+		if assertion, err := asserts.Decode([]byte(stored.Assertion)); err == nil {
+			return assertion, nil
+		}
+	}
+
+	return signModelAssertion(ctx, model, substoreID, headers, keypair, digest)
+}
+
+// signModelAssertion signs the model assertion and stores it. The signed assertion is returned
+// when it cannot be stored, as it is signed again on the next request
+func signModelAssertion(ctx context.Context, model datastore.Model, substoreID int, headers map[string]interface{}, keypair datastore.Keypair, digest string) (asserts.Assertion, error) {
+	signed, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ModelType, headers, []byte(""), model.BrandID, keypair.KeyID, keypair.SealedKey)
+	if err != nil {
+		return nil, err
+	}
+
+	s := datastore.SignedModelAssertion{ModelID: model.ID, SubstoreID: substoreID, Digest: digest,
+		Assertion: string(asserts.Encode(signed)), Signed: time.Now().UTC()}
+	if err := datastore.Environ.DB.UpsertSignedModelAssert(ctx, s); err != nil {
+		log.Errorf("Error storing the signed model assertion: %v", err)
+	}
+	return signed, nil
+}
+
+// headersDigest returns the digest of the model assertion headers, without the timestamp as it
+// changes on each signing. The headers include the signing key
+func headersDigest(headers map[string]interface{}) string {
+	h := map[string]interface{}{}
+	for k, v := range headers {
+		if k != "timestamp" {
+			h[k] = v
+		}
+	}
+
+	// The keys of a map are sorted in the JSON
+	data, _ := json.Marshal(h)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package assertion_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+// signedMockDB mocks the database with the stored signed model assertions
+type signedMockDB struct {
+	datastore.MockDB
+	stored  map[[2]int]datastore.SignedModelAssertion
+	upserts int
+	deleted []int
+}
+
+func newSignedMockDB(stored ...datastore.SignedModelAssertion) *signedMockDB {
+	db := &signedMockDB{stored: map[[2]int]datastore.SignedModelAssertion{}}
+	for _, s := range stored {
+		db.stored[[2]int{s.ModelID, s.SubstoreID}] = s
+	}
+	return db
+}
+
+func (mdb *signedMockDB) GetSignedModelAssert(ctx context.Context, modelID, substoreID int) (datastore.SignedModelAssertion, error) {
+	s, ok := mdb.stored[[2]int{modelID, substoreID}]
+	if !ok {
+		return s, errors.New("MOCK not found")
+	}
+	return s, nil
+}
+
+func (mdb *signedMockDB) ListSignedModelAsserts(ctx context.Context) ([]datastore.SignedModelAssertion, error) {
+	list := []datastore.SignedModelAssertion{}
+	for _, s := range mdb.stored {
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+func (mdb *signedMockDB) UpsertSignedModelAssert(ctx context.Context, s datastore.SignedModelAssertion) error {
+	mdb.upserts++
+	if existing, ok := mdb.stored[[2]int{s.ModelID, s.SubstoreID}]; ok {
+		s.ID = existing.ID
+	}
+	mdb.stored[[2]int{s.ModelID, s.SubstoreID}] = s
+	return nil
+}
+
+func (mdb *signedMockDB) DeleteSignedModelAssert(ctx context.Context, id int) error {
+	mdb.deleted = append(mdb.deleted, id)
+	for k, s := range mdb.stored {
+		if s.ID == id {
+			delete(mdb.stored, k)
+		}
+	}
+	return nil
+}
+
+func (s *AssertionSuite) TestSignModelAssertion(c *check.C) {
+	db := newSignedMockDB()
+	datastore.Environ.DB = db
+
+	model, err := db.FindModel(context.Background(), "system", "alder", "")
+	c.Assert(err, check.IsNil)
+	headers, keypair, err := assertion.CreateModelAssertionHeaders(context.Background(), model)
+	c.Assert(err, check.IsNil)
+
+	// The assertion is signed and stored
+	signed, err := assertion.SignModelAssertion(context.Background(), model, 0, headers, keypair)
+	c.Assert(err, check.IsNil)
+	c.Assert(signed.Type(), check.Equals, asserts.ModelType)
+	c.Assert(db.upserts, check.Equals, 1)
+
+	// The stored assertion is served for the same headers, even with a new timestamp
+	headers["timestamp"] = "2030-01-01T00:00:00Z"
+	stored, err := assertion.SignModelAssertion(context.Background(), model, 0, headers, keypair)
+	c.Assert(err, check.IsNil)
+	c.Assert(asserts.Encode(stored), check.DeepEquals, asserts.Encode(signed))
+	c.Assert(db.upserts, check.Equals, 1)
+
+	// The assertion is signed again when the headers change
+	headers["display-name"] = "Alder Device"
+	changed, err := assertion.SignModelAssertion(context.Background(), model, 0, headers, keypair)
+	c.Assert(err, check.IsNil)
+	c.Assert(changed.HeaderString("display-name"), check.Equals, "Alder Device")
+	c.Assert(db.upserts, check.Equals, 2)
+}
+
+func (s *AssertionSuite) TestSignPivotModelAssertionOriginalHeaders(c *check.C) {
+	db := newSignedMockDB()
+	datastore.Environ.DB = db
+
+	model, err := db.FindModel(context.Background(), "system", "alder", "")
+	c.Assert(err, check.IsNil)
+	substore := datastore.Substore{ID: 1, FromModelID: model.ID, FromModel: model, Store: "mybrand", ModelName: "alder-mybrand", OriginalHeaders: true}
+
+	headers, keypair, err := assertion.CreatePivotModelAssertionHeaders(context.Background(), substore)
+	c.Assert(err, check.IsNil)
+	signed, err := assertion.SignModelAssertion(context.Background(), model, substore.ID, headers, keypair)
+	c.Assert(err, check.IsNil)
+	c.Assert(signed.HeaderString("model"), check.Equals, "alder-mybrand")
+	c.Assert(signed.HeaderString(datastore.OriginalBrandHeader), check.Equals, "system")
+	c.Assert(signed.HeaderString(datastore.OriginalModelHeader), check.Equals, "alder")
+
+	// The headers are not embedded unless the sub-store model is configured to
+	substore.OriginalHeaders = false
+	headers, _, err = assertion.CreatePivotModelAssertionHeaders(context.Background(), substore)
+	c.Assert(err, check.IsNil)
+	c.Assert(headers[datastore.OriginalModelHeader], check.IsNil)
+}
+
+func (s *AssertionSuite) TestRefresh(c *check.C) {
+	db := newSignedMockDB(
+		datastore.SignedModelAssertion{ID: 1, ModelID: 1, Digest: "stale"},
+		datastore.SignedModelAssertion{ID: 2, ModelID: 1, SubstoreID: 1, Digest: "stale"},
+		datastore.SignedModelAssertion{ID: 3, ModelID: 1, SubstoreID: 99, Digest: "stale"},
+		datastore.SignedModelAssertion{ID: 4, ModelID: 99, Digest: "stale"},
+	)
+	datastore.Environ.DB = db
+
+	// The stale assertions are signed again, and the assertions of the missing models are removed
+	c.Assert(assertion.Refresh(context.Background()), check.IsNil)
+	c.Assert(db.upserts, check.Equals, 2)
+	c.Assert(db.deleted, check.HasLen, 2)
+	c.Assert(db.stored, check.HasLen, 2)
+
+	pivoted, err := asserts.Decode([]byte(db.stored[[2]int{1, 1}].Assertion))
+	c.Assert(err, check.IsNil)
+	c.Assert(pivoted.HeaderString("model"), check.Equals, "alder-mybrand")
+	c.Assert(pivoted.HeaderString("store"), check.Equals, "mybrand")
+
+	// The assertions are not signed again when nothing has changed
+	c.Assert(assertion.Refresh(context.Background()), check.IsNil)
+	c.Assert(db.upserts, check.Equals, 2)
+}
+
+func (s *AssertionSuite) TestRefreshError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	c.Assert(assertion.Refresh(context.Background()), check.NotNil)
+}
+
+func (s *AssertionSuite) TestRefreshInterval(c *check.C) {
+	tests := []struct {
+		interval string
+		valid    bool
+	}{
+		{"", true},
+		{"5m", true},
+		{"0s", false},
+		{"invalid", false},
+	}
+
+	for _, t := range tests {
+		_, err := assertion.RefreshInterval(config.Settings{ModelAssertionRefreshInterval: t.interval})
+		c.Assert(err == nil, check.Equals, t.valid, check.Commentf("%s", t.interval))
+	}
+}
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// VerifyResponse is the JSON response from the API audit chain verification method
+type VerifyResponse struct {
+	Success      bool                             `json:"success"`
+	ErrorCode    string                           `json:"error_code"`
+	ErrorSubcode string                           `json:"error_subcode"`
+	ErrorMessage string                           `json:"message"`
+	Verification datastore.AuditChainVerification `json:"verification"`
+}
+
+// ListResponse is the JSON response from the API audit log method
+type ListResponse struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	AuditLog     []datastore.AuditLog `json:"logs"`
+	NextToken    string               `json:"next_token,omitempty"`
+}
+
+func errInvalidSequence(name string) error {
+	return fmt.Errorf("the '%s' sequence of the audit chain must be a positive number", name)
+}
+
+func verifyHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, from, to int64) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	verification, err := datastore.Environ.DB.VerifyAllowedAuditChain(ctx, user, from, to)
+	if err != nil {
+		log.Errorf("Error verifying the audit chain: %v", err)
+		response.FormatStandardResponse(false, "error-audit-verify", "", err.Error(), w)
+		return
+	}
+
+	// The failed verification is a successful request, the failures are in the response
+	w.WriteHeader(http.StatusOK)
+	formatVerifyResponse(verification, w)
+}
+
+func formatVerifyResponse(verification datastore.AuditChainVerification, w http.ResponseWriter) {
+	r := VerifyResponse{Success: true, Verification: verification}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		log.Error("Error forming the audit chain verification response.")
+	}
+}
+
+// listHandler is the API method to fetch a page of the filtered audit log
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, filter datastore.AuditLogFilter, page datastore.SigningLogPage) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	logs, next, err := datastore.Environ.DB.ListAllowedAuditLog(ctx, user, filter, page)
+	if err != nil {
+		log.Errorf("Error fetching the audit log: %v", err)
+		response.FormatStandardResponse(false, "error-fetch-auditlog", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(logs, next, w)
+}
+
+func formatListResponse(logs []datastore.AuditLog, next string, w http.ResponseWriter) {
+	r := ListResponse{Success: true, AuditLog: logs, NextToken: next}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		log.Error("Error forming the audit log response.")
+	}
+}
@@ -0,0 +1,101 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+)
+
+// List is the API method to fetch a page of the filtered audit log of the admin operations.
+// The dates are filtered in the same way as the signing logs
+func List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	page, err := signinglog.GetSigningLogPage(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auditlog-filter", "", err.Error(), w)
+		return
+	}
+
+	dates, err := signinglog.GetSigningLogFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auditlog-filter", "", err.Error(), w)
+		return
+	}
+
+	query := r.URL.Query()
+	filter := datastore.AuditLogFilter{
+		From:        dates.From,
+		To:          dates.To,
+		Username:    query.Get("username"),
+		Action:      query.Get("action"),
+		Object:      query.Get("object"),
+		AuthorityID: query.Get("authorityID"),
+	}
+
+	listHandler(r.Context(), w, authUser, false, filter, page)
+}
+
+// Verify is the API method to verify the integrity of a range of the audit chain.
+// The range is from and to the sequence of the entries, defaulting to the whole chain
+func Verify(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	from, err := sequenceParam(r, "from")
+	if err != nil {
+		response.FormatStandardResponse(false, "error-audit-range", "", err.Error(), w)
+		return
+	}
+	to, err := sequenceParam(r, "to")
+	if err != nil {
+		response.FormatStandardResponse(false, "error-audit-range", "", err.Error(), w)
+		return
+	}
+
+	verifyHandler(r.Context(), w, authUser, false, from, to)
+}
+
+func sequenceParam(r *http.Request, name string) (int64, error) {
+	value := r.URL.Query().Get(name)
+	if len(value) == 0 {
+		return 0, nil
+	}
+
+	seq, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || seq < 0 {
+		return 0, errInvalidSequence(name)
+	}
+	return seq, nil
+}
@@ -0,0 +1,187 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestAuditSuite(t *testing.T) { check.TestingT(t) }
+
+type AuditSuite struct{}
+
+var _ = check.Suite(&AuditSuite{})
+
+func (s *AuditSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *AuditSuite) TestVerifyHandler(c *check.C) {
+	tests := []struct {
+		url         string
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		entries     int
+	}{
+		{"/v1/audit/verify", 400, 0, false, false, 0},
+		{"/v1/audit/verify", 200, datastore.Superuser, true, true, 10},
+		{"/v1/audit/verify?from=3&to=5", 200, datastore.Superuser, true, true, 3},
+		{"/v1/audit/verify?from=3", 200, datastore.Superuser, true, true, 8},
+		{"/v1/audit/verify?from=invalid", 400, datastore.Superuser, true, false, 0},
+		{"/v1/audit/verify?to=-1", 400, datastore.Superuser, true, false, 0},
+		{"/v1/audit/verify", 400, datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.url, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := audit.VerifyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(result.Verification.Entries, check.Equals, t.entries)
+		c.Assert(result.Verification.Valid, check.Equals, t.success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *AuditSuite) TestVerifyHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = true
+
+	w := sendAdminRequest("/v1/audit/verify", datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+
+	result := audit.VerifyResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-audit-verify")
+}
+
+func (s *AuditSuite) TestListHandler(c *check.C) {
+	tests := []struct {
+		url         string
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		count       int
+		next        bool
+	}{
+		{"/v1/auditlog", 200, 0, false, true, 10, false},
+		{"/v1/auditlog", 200, datastore.Superuser, true, true, 10, false},
+		{"/v1/auditlog", 200, datastore.Admin, true, true, 4, false},
+		{"/v1/auditlog?limit=3", 200, datastore.Superuser, true, true, 3, true},
+		{"/v1/auditlog?action=update&object=model&username=sv&from=2020-06-01", 200, datastore.Superuser, true, true, 10, false},
+		{"/v1/auditlog?action=delete", 200, datastore.Superuser, true, true, 0, false},
+		{"/v1/auditlog?limit=invalid", 400, datastore.Superuser, true, false, 0, false},
+		{"/v1/auditlog?from=invalid", 400, datastore.Superuser, true, false, 0, false},
+		{"/v1/auditlog?token=invalid", 400, datastore.Superuser, true, false, 0, false},
+		{"/v1/auditlog", 400, datastore.Standard, true, false, 0, false},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.url, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := audit.ListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(len(result.AuditLog), check.Equals, t.count)
+		c.Assert(len(result.NextToken) > 0, check.Equals, t.next)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *AuditSuite) TestListHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = true
+
+	w := sendAdminRequest("/v1/auditlog", datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+
+	result := audit.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-fetch-auditlog")
+}
+
+func sendAdminRequest(url string, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
@@ -0,0 +1,225 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/webhook"
+)
+
+// Audited actions on the objects of the vault
+const (
+	ActionCreate  = "create"
+	ActionUpdate  = "update"
+	ActionDelete  = "delete"
+	ActionEnable  = "enable"
+	ActionDisable = "disable"
+	ActionRotate  = "rotate"
+	ActionInvite  = "invite"
+	ActionRevoke  = "revoke"
+	ActionPurge   = "purge"
+	ActionRestore = "restore"
+	ActionSign    = "sign"
+	ActionExport  = "export"
+
+	// The use of the previous key of a rotated API key, during the grace period
+	ActionUseDeprecated = "use-deprecated"
+)
+
+// Audited objects of the vault
+const (
+	ObjectKeypair = "keypair"
+	ObjectModel   = "model"
+	ObjectAccount = "account"
+	ObjectUser    = "user"
+	ObjectAPIKey  = "apikey"
+
+	ObjectClientCert     = "clientcert"
+	ObjectAccountHMAC    = "accounthmac"
+	ObjectServiceAccount = "serviceaccount"
+	ObjectAccessToken    = "accesstoken"
+	ObjectDevice         = "device"
+	ObjectValidationSet  = "validationset"
+	ObjectWebhook        = "webhook"
+	ObjectSubstore       = "substore"
+	ObjectSubstoreUser   = "substoreuser"
+)
+
+// webhookEvents are the webhook events of the audited objects
+var webhookEvents = map[string]string{
+	ObjectKeypair:     datastore.WebhookEventKeypair,
+	ObjectAccount:     datastore.WebhookEventAccount,
+	ObjectAPIKey:      datastore.WebhookEventAccount,
+	ObjectClientCert:  datastore.WebhookEventAccount,
+	ObjectAccountHMAC: datastore.WebhookEventAccount,
+}
+
+// maskedFields are the secrets that are not stored in the audit log
+var maskedFields = map[string]bool{
+	"api-key":     true,
+	"APIKey":      true,
+	"SealedKey":   true,
+	"AuthKeyHash": true,
+	"secret":      true,
+	"token":       true,
+}
+
+const maskedValue = "*****"
+
+// Record logs an admin operation in the audit log, with the values of the object before and
+// after the change, and publishes it to the webhooks of the account. An error is logged, as the
+// operation has already been completed
+func Record(ctx context.Context, user datastore.User, action, object string, id int, authorityID string, before, after interface{}) {
+	entry := Entry(user, action, object, id, authorityID, before, after)
+
+	if err := datastore.Environ.DB.CreateAuditLog(ctx, entry); err != nil {
+		log.Errorf("Error recording the audit log of the %s %s %d: %v", action, object, id, err)
+	}
+
+	if event, ok := webhookEvents[object]; ok {
+		webhook.Publish(ctx, authorityID, event, action, webhookData(entry))
+	}
+}
+
+// Entry builds the audit log entry of an admin operation, masking the secrets, for the
+// operations that record it in their own transaction
+func Entry(user datastore.User, action, object string, id int, authorityID string, before, after interface{}) datastore.AuditLog {
+	return datastore.AuditLog{
+		Username:    user.Username,
+		Action:      action,
+		Object:      object,
+		ObjectID:    id,
+		AuthorityID: authorityID,
+		Before:      auditValue(before),
+		After:       auditValue(after),
+	}
+}
+
+// DeprecatedAPIKey records the use of the previous key of a rotated named API key of an account,
+// during the grace period, to track the factories that have not moved to the new key. The key is
+// the match of the API key check, and its use is recorded at most once a day
+func DeprecatedAPIKey(ctx context.Context, k datastore.APIKey) {
+	if !k.Deprecated || !deprecated.first(ObjectAPIKey, k.ID) {
+		return
+	}
+	Record(ctx, datastore.User{Username: k.Name}, ActionUseDeprecated, ObjectAPIKey, k.ID, k.AuthorityID, nil, nil)
+}
+
+// DeprecatedModelKey records the use of the previous API key of a model, during the grace
+// period, at most once a day. The model is the match of the API key
+func DeprecatedModelKey(ctx context.Context, m datastore.Model) {
+	if !m.DeprecatedKey || !deprecated.first(ObjectModel, m.ID) {
+		return
+	}
+	Record(ctx, datastore.User{}, ActionUseDeprecated, ObjectModel, m.ID, m.BrandID, nil, nil)
+}
+
+// deprecatedUses keeps the deprecated keys that have been recorded on the current day, so the
+// factories that sign with a previous key do not flood the audit log
+type deprecatedUses struct {
+	lock sync.Mutex
+	day  string
+	seen map[string]bool
+}
+
+var deprecated = &deprecatedUses{}
+
+// first checks if it is the first use of the deprecated key of the object on the day
+func (d *deprecatedUses) first(object string, id int) bool {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+
+	day := time.Now().UTC().Format("2006-01-02")
+	if d.day != day {
+		d.day = day
+		d.seen = map[string]bool{}
+	}
+
+	key := fmt.Sprintf("%s/%d", object, id)
+	if d.seen[key] {
+		return false
+	}
+	d.seen[key] = true
+	return true
+}
+
+// webhookData is the data of the webhook event of an audited operation, with the masked values
+// of the object before and after the change
+func webhookData(entry datastore.AuditLog) map[string]interface{} {
+	data := map[string]interface{}{
+		"username": entry.Username,
+		"object":   entry.Object,
+		"objectID": entry.ObjectID,
+	}
+	if len(entry.Before) > 0 {
+		data["before"] = json.RawMessage(entry.Before)
+	}
+	if len(entry.After) > 0 {
+		data["after"] = json.RawMessage(entry.After)
+	}
+	return data
+}
+
+// auditValue converts the value to JSON, masking the secrets
+func auditValue(value interface{}) string {
+	if value == nil {
+		return ""
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		log.Errorf("Error converting the audit log value: %v", err)
+		return ""
+	}
+
+	var fields interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ""
+	}
+
+	data, _ = json.Marshal(mask(fields))
+	return string(data)
+}
+
+func mask(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for key, field := range v {
+			if maskedFields[key] {
+				v[key] = maskedValue
+				continue
+			}
+			v[key] = mask(field)
+		}
+	case []interface{}:
+		for i := range v {
+			v[i] = mask(v[i])
+		}
+	}
+	return value
+}
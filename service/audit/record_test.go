@@ -0,0 +1,132 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package audit
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestAuditValue(t *testing.T) {
+	tests := []struct {
+		name  string
+		value interface{}
+		want  string
+	}{
+		{"nil", nil, ""},
+		{"keypair", datastore.Keypair{ID: 1, AuthorityID: "System", SealedKey: "secret", KeyName: "key"},
+			`{"Active":false,"Assertion":"","AuthorityID":"System","ID":1,"KeyID":"","KeyName":"key","SealedKey":"*****","Version":0}`},
+		{"nested", datastore.User{Username: "sv", APIKey: "secret", Accounts: []datastore.Account{{AuthorityID: "System"}}},
+			`{"APIKey":"*****","Accounts":[{"Assertion":"","AuthorityID":"System","DefaultKeypairID":0,"HashSerial":false,"ID":0,"ResellerAPI":false,"Version":0}],"Active":false,"Email":"","ID":0,"Name":"","Role":0,"Username":"sv"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := auditValue(tt.value); got != tt.want {
+				t.Errorf("auditValue() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRecord(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	before := datastore.Model{ID: 1, BrandID: "System", Name: "alder", APIKey: "secret"}
+	after := before
+	after.Name = "ash"
+	Record(context.Background(), datastore.User{Username: "sv"}, ActionUpdate, ObjectModel, 1, "System", before, after)
+
+	logs := db.AuditLogs()
+	if len(logs) != 1 {
+		t.Fatalf("Record() entries = %d, want 1", len(logs))
+	}
+	l := logs[0]
+	if l.Username != "sv" || l.Action != ActionUpdate || l.Object != ObjectModel || l.ObjectID != 1 || l.AuthorityID != "System" {
+		t.Errorf("Record() entry = %v", l)
+	}
+	if strings.Contains(l.Before, "secret") || !strings.Contains(l.Before, `"alder"`) || !strings.Contains(l.After, `"ash"`) {
+		t.Errorf("Record() values = %v, %v", l.Before, l.After)
+	}
+}
+
+func TestRecordWebhook(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	Record(context.Background(), datastore.User{Username: "sv"}, ActionUpdate, ObjectModel, 1, "system", nil, nil)
+	Record(context.Background(), datastore.User{Username: "sv"}, ActionCreate, ObjectKeypair, 1, "system", nil, datastore.Keypair{ID: 1, SealedKey: "secret"})
+
+	deliveries, _ := db.ClaimWebhookDeliveries(context.Background(), time.Now(), 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("Record() webhook deliveries = %d, want 1", len(deliveries))
+	}
+	d := deliveries[0]
+	if d.Event != datastore.WebhookEventKeypair || strings.Contains(d.Payload, "secret") || !strings.Contains(d.Payload, `"action":"create"`) {
+		t.Errorf("Record() webhook delivery = %v", d)
+	}
+}
+
+func TestDeprecatedAPIKey(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+	deprecated = &deprecatedUses{}
+
+	DeprecatedAPIKey(context.Background(), datastore.APIKey{ID: 1, Name: "factory-1", AuthorityID: "system"})
+	DeprecatedModelKey(context.Background(), datastore.Model{ID: 1, BrandID: "system"})
+	if len(db.AuditLogs()) != 0 {
+		t.Fatalf("DeprecatedAPIKey() entries = %d, want 0", len(db.AuditLogs()))
+	}
+
+	k := datastore.APIKey{ID: 1, Name: "factory-1", AuthorityID: "system", Deprecated: true}
+	DeprecatedAPIKey(context.Background(), k)
+	logs := db.AuditLogs()
+	if len(logs) != 1 {
+		t.Fatalf("DeprecatedAPIKey() entries = %d, want 1", len(logs))
+	}
+	l := logs[0]
+	if l.Username != "factory-1" || l.Action != ActionUseDeprecated || l.Object != ObjectAPIKey || l.ObjectID != 1 || l.AuthorityID != "system" {
+		t.Errorf("DeprecatedAPIKey() entry = %v", l)
+	}
+
+	m := datastore.Model{ID: 1, BrandID: "system", DeprecatedKey: true}
+	DeprecatedModelKey(context.Background(), m)
+	logs = db.AuditLogs()
+	if len(logs) != 2 {
+		t.Fatalf("DeprecatedModelKey() entries = %d, want 2", len(logs))
+	}
+	l = logs[1]
+	if l.Action != ActionUseDeprecated || l.Object != ObjectModel || l.ObjectID != 1 || l.AuthorityID != "system" {
+		t.Errorf("DeprecatedModelKey() entry = %v", l)
+	}
+
+	// The uses of the same keys are recorded once a day
+	DeprecatedAPIKey(context.Background(), k)
+	DeprecatedModelKey(context.Background(), m)
+	if len(db.AuditLogs()) != 2 {
+		t.Errorf("DeprecatedAPIKey() entries = %d, want 2", len(db.AuditLogs()))
+	}
+}
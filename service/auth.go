@@ -41,7 +41,7 @@ func checkUserPermissions(user datastore.User, minimumAuthorizedRole int) error
 		return nil
 	}
 
-	if user.Role < minimumAuthorizedRole {
+	if !datastore.RoleAllows(user.Role, minimumAuthorizedRole) {
 		return errors.New("The user is not authorized")
 	}
 	return nil
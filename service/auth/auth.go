@@ -24,12 +24,20 @@ import (
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/usso"
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-// GetUserFromJWT retrieves the user details from the JSON Web Token
+// GetUserFromJWT retrieves the user details from the JSON Web Token, or from the personal
+// access token of the user
 func GetUserFromJWT(w http.ResponseWriter, r *http.Request) (datastore.User, error) {
+	if datastore.Environ.Config.EnableUserAuth {
+		if accessToken := request.BearerToken(r); datastore.IsAccessToken(accessToken) {
+			return request.CheckAccessToken(r, accessToken)
+		}
+	}
+
 	token, err := JWTCheck(w, r)
 	if err != nil {
 		return datastore.User{}, err
@@ -61,7 +69,7 @@ func CheckUserPermissions(user datastore.User, minimumAuthorizedRole int, apiCal
 		return nil
 	}
 
-	if user.Role < minimumAuthorizedRole {
+	if !datastore.RoleAllows(user.Role, minimumAuthorizedRole) {
 		return errors.New("The user is not authorized")
 	}
 	return nil
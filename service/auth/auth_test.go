@@ -111,6 +111,31 @@ func (s *authSuite) TestCheckStandardPermissionsWhenAuthEnabled(c *check.C) {
 	}
 }
 
+func (s *authSuite) TestCheckSubstoreAdminPermissions(c *check.C) {
+	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{Config: config}
+
+	standardUser := datastore.User{Username: "auser", Role: datastore.Standard}
+	substoreUser := datastore.User{Username: "auser", Role: datastore.SubstoreAdmin}
+	syncUser := datastore.User{Username: "auser", Role: datastore.SyncUser}
+	adminUser := datastore.User{Username: "auser", Role: datastore.Admin}
+
+	tests := []SuiteTest{
+		{standardUser, datastore.SubstoreAdmin, check.NotNil},
+		{substoreUser, datastore.SubstoreAdmin, check.IsNil},
+		{syncUser, datastore.SubstoreAdmin, check.NotNil},
+		{adminUser, datastore.SubstoreAdmin, check.IsNil},
+		{substoreUser, datastore.Standard, check.NotNil},
+		{substoreUser, datastore.SyncUser, check.NotNil},
+		{syncUser, datastore.SyncUser, check.IsNil},
+	}
+
+	for _, t := range tests {
+		err := auth.CheckUserPermissions(t.User, t.Permissions, false)
+		c.Assert(err, t.Check)
+	}
+}
+
 func (s *authSuite) TestCheckStandardPermissionsWhenAuthDisabled(c *check.C) {
 	config := config.Settings{EnableUserAuth: false, JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{Config: config}
@@ -41,22 +41,37 @@ func JWTCheck(w http.ResponseWriter, r *http.Request) (*jwt.Token, error) {
 	// Get the JWT from the header or cookie
 	jwtToken, err := usso.JWTExtractor(r)
 	if err != nil {
-		log.Println("Error in JWT extraction:", err.Error())
+		log.Errorf("Error in JWT extraction: %v", err.Error())
 		return nil, errors.New("Error in retrieving the authentication token")
 	}
 
 	// Verify the JWT string
 	token, err := usso.VerifyJWT(jwtToken)
 	if err != nil {
-		log.Printf("JWT fails verification: %v", err.Error())
+		log.Errorf("JWT fails verification: %v", err.Error())
 		return nil, errors.New("The authentication token is invalid")
 	}
 
-	if !token.Valid {
-		log.Println("Invalid JWT")
+	// The refresh tokens and the tokens of the service accounts are not a JWT of the admin UI
+	if !token.Valid || len(usso.TokenType(token)) > 0 {
+		log.Error("Invalid JWT")
 		return nil, errors.New("The authentication token is invalid")
 	}
 
+	// The denylist is checked on each request, so a revoked session is rejected before it expires
+	if usso.IsTokenRevoked(r.Context(), token) {
+		log.Info("Revoked JWT")
+		return nil, errors.New("The authentication token has been revoked")
+	}
+
+	// The user is checked on each request, so a deactivated user is rejected immediately
+	claims := token.Claims.(jwt.MapClaims)
+	username, _ := claims[usso.ClaimsUsername].(string)
+	if !datastore.Environ.DB.IsUserActive(r.Context(), username) {
+		log.Warningf("JWT of the inactive user %v", username)
+		return nil, errors.New("The user is not active")
+	}
+
 	// Set up the bearer token in the header
 	w.Header().Set("Authorization", "Bearer "+jwtToken)
 
@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/compress"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/recovery"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+	"github.com/gorilla/mux"
+)
+
+// MiddlewareFactory creates a middleware of the routers from the config settings
+type MiddlewareFactory func(settings config.Settings) (mux.MiddlewareFunc, error)
+
+// DefaultMiddlewares is the chain of the router middlewares when it is not configured
+var DefaultMiddlewares = []string{"tracing", "recovery"}
+
+// registry holds the middlewares that can be enabled in the config settings, by name
+var registry = struct {
+	sync.RWMutex
+	factories map[string]MiddlewareFactory
+}{
+	factories: map[string]MiddlewareFactory{
+		"tracing": func(config.Settings) (mux.MiddlewareFunc, error) { return tracing.Middleware, nil },
+		"recovery": func(settings config.Settings) (mux.MiddlewareFunc, error) {
+			reporter, err := recovery.NewReporter(settings)
+			return recovery.Middleware(reporter), err
+		},
+		"gzip": func(settings config.Settings) (mux.MiddlewareFunc, error) {
+			minSize, err := compress.MinSize(settings)
+			return compress.Middleware(minSize), err
+		},
+	},
+}
+
+// RegisterMiddleware adds a middleware to the registry, so a deployment can enable a custom
+// middleware in the config settings without changing the routers
+func RegisterMiddleware(name string, factory MiddlewareFactory) error {
+	registry.Lock()
+	defer registry.Unlock()
+
+	if _, ok := registry.factories[name]; ok {
+		return fmt.Errorf("the middleware '%s' is already registered", name)
+	}
+	registry.factories[name] = factory
+	return nil
+}
+
+// MiddlewareChain returns the router middlewares that are enabled in the config settings.
+// The first middleware is the outermost, so it sees the request first
+func MiddlewareChain(settings config.Settings) ([]mux.MiddlewareFunc, error) {
+	names := settings.Middlewares
+	if len(names) == 0 {
+		names = DefaultMiddlewares
+	}
+
+	registry.RLock()
+	defer registry.RUnlock()
+
+	chain := []mux.MiddlewareFunc{}
+	enabled := map[string]bool{}
+	for _, name := range names {
+		factory, ok := registry.factories[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown middleware '%s'", name)
+		}
+		if enabled[name] {
+			return nil, fmt.Errorf("the middleware '%s' is enabled more than once", name)
+		}
+		enabled[name] = true
+
+		mw, err := factory(settings)
+		if err != nil {
+			return nil, fmt.Errorf("error creating the middleware '%s': %v", name, err)
+		}
+		chain = append(chain, mw)
+	}
+	return chain, nil
+}
+
+// useMiddlewares adds the configured middleware chain to the router. The chain is checked
+// when the service starts, so an invalid chain falls back to the default middlewares
+func useMiddlewares(router *mux.Router) {
+	chain, err := MiddlewareChain(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error in the middleware chain, using the default middlewares: %v", err)
+		chain, _ = MiddlewareChain(config.Settings{})
+	}
+	for _, mw := range chain {
+		router.Use(mw)
+	}
+}
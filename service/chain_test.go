@@ -0,0 +1,107 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/gorilla/mux"
+)
+
+// headerMiddleware appends its name to a response header, to check the order of the chain
+func headerMiddleware(name string) MiddlewareFactory {
+	return func(config.Settings) (mux.MiddlewareFunc, error) {
+		return func(next http.Handler) http.Handler {
+			return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Add("X-Chain", name)
+				next.ServeHTTP(w, r)
+			})
+		}, nil
+	}
+}
+
+func TestMiddlewareChain(t *testing.T) {
+	if err := RegisterMiddleware("test-first", headerMiddleware("first")); err != nil {
+		t.Fatalf("Error registering the middleware: %v", err)
+	}
+	if err := RegisterMiddleware("test-second", headerMiddleware("second")); err != nil {
+		t.Fatalf("Error registering the middleware: %v", err)
+	}
+	if err := RegisterMiddleware("test-first", headerMiddleware("again")); err == nil {
+		t.Error("Expected an error registering a middleware twice")
+	}
+
+	chain, err := MiddlewareChain(config.Settings{Middlewares: []string{"test-second", "tracing", "test-first"}})
+	if err != nil {
+		t.Fatalf("Error creating the middleware chain: %v", err)
+	}
+
+	router := mux.NewRouter()
+	for _, mw := range chain {
+		router.Use(mw)
+	}
+	router.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {})
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/", nil))
+
+	if got := strings.Join(w.Header()["X-Chain"], ","); got != "second,first" {
+		t.Errorf("Expected the middlewares in the configured order, got: %s", got)
+	}
+}
+
+func TestMiddlewareChainDefault(t *testing.T) {
+	chain, err := MiddlewareChain(config.Settings{})
+	if err != nil {
+		t.Fatalf("Error creating the middleware chain: %v", err)
+	}
+	if len(chain) != len(DefaultMiddlewares) {
+		t.Errorf("Expected the default middlewares, got %d", len(chain))
+	}
+}
+
+func TestMiddlewareChainInvalid(t *testing.T) {
+	RegisterMiddleware("test-failing", func(config.Settings) (mux.MiddlewareFunc, error) {
+		return nil, errors.New("MOCK invalid settings")
+	})
+
+	tests := []struct {
+		name        string
+		middlewares []string
+	}{
+		{"unknown", []string{"tracing", "unknown"}},
+		{"duplicate", []string{"tracing", "tracing"}},
+		{"factory-error", []string{"test-failing"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := MiddlewareChain(config.Settings{Middlewares: tt.middlewares}); err == nil {
+				t.Error("Expected an error with an invalid middleware chain")
+			}
+		})
+	}
+}
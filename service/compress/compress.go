@@ -0,0 +1,224 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package compress
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/gorilla/mux"
+)
+
+// defaultMinSize is the smallest response that is compressed when it is not configured
+const defaultMinSize = 1024
+
+// compressibleTypes are the content types that are compressed, e.g. the JSON lists and the assertions
+var compressibleTypes = []string{
+	"application/json",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/x.ubuntu.assertion",
+	"application/xml",
+	"image/svg+xml",
+	"text/",
+}
+
+var writers = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+
+// MinSize returns the smallest response that is compressed from the config settings
+func MinSize(settings config.Settings) (int, error) {
+	if settings.GzipMinSize < 0 {
+		return 0, fmt.Errorf("the gzip minimum size must not be negative")
+	}
+	if settings.GzipMinSize == 0 {
+		return defaultMinSize, nil
+	}
+	return settings.GzipMinSize, nil
+}
+
+// Middleware compresses the responses of at least the minimum size with gzip, when the client accepts it
+func Middleware(minSize int) mux.MiddlewareFunc {
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("Vary", "Accept-Encoding")
+			if r.Method == "HEAD" || !acceptsGzip(r.Header.Get("Accept-Encoding")) {
+				inner.ServeHTTP(w, r)
+				return
+			}
+
+			// The response is not closed on a panic, so the recovery middleware can still send the error
+			gw := &gzipWriter{ResponseWriter: w, minSize: minSize, status: http.StatusOK}
+			inner.ServeHTTP(gw, r)
+			gw.Close()
+		})
+	}
+}
+
+// acceptsGzip checks the Accept-Encoding header of the request for gzip, without a zero quality
+func acceptsGzip(header string) bool {
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(fields[0]))
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		accepted := true
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				q, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64)
+				accepted = err == nil && q > 0
+			}
+		}
+		if accepted {
+			return true
+		}
+	}
+	return false
+}
+
+// compressible checks if the content type of the response is worth compressing
+func compressible(contentType string) bool {
+	contentType = strings.ToLower(contentType)
+	for _, t := range compressibleTypes {
+		if strings.HasPrefix(contentType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipWriter buffers the start of the response, until it knows the response is large enough to compress
+type gzipWriter struct {
+	http.ResponseWriter
+	minSize     int
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         bytes.Buffer
+	gz          *gzip.Writer
+}
+
+func (gw *gzipWriter) WriteHeader(status int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.wroteHeader = true
+	gw.status = status
+
+	// The responses without a body are not compressed
+	if status < http.StatusOK || status == http.StatusNoContent || status == http.StatusNotModified {
+		gw.decide(false)
+	}
+}
+
+func (gw *gzipWriter) Write(b []byte) (int, error) {
+	gw.wroteHeader = true
+	if !gw.decided {
+		gw.buf.Write(b)
+		if gw.buf.Len() < gw.minSize {
+			return len(b), nil
+		}
+		if err := gw.decide(gw.shouldCompress()); err != nil {
+			return 0, err
+		}
+		return len(b), nil
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.ResponseWriter.Write(b)
+}
+
+// Flush sends the buffered response, so the streamed responses are compressed as they are written
+func (gw *gzipWriter) Flush() {
+	if !gw.decided {
+		gw.decide(gw.shouldCompress())
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	if f, ok := gw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Close sends the rest of the response. A response that is still buffered is smaller than
+// the minimum size, so it is not compressed
+func (gw *gzipWriter) Close() error {
+	if !gw.decided {
+		if err := gw.decide(false); err != nil {
+			return err
+		}
+	}
+	if gw.gz == nil {
+		return nil
+	}
+	err := gw.gz.Close()
+	writers.Put(gw.gz)
+	gw.gz = nil
+	return err
+}
+
+func (gw *gzipWriter) shouldCompress() bool {
+	h := gw.Header()
+	if len(h.Get("Content-Encoding")) > 0 {
+		return false
+	}
+	contentType := h.Get("Content-Type")
+	if len(contentType) == 0 {
+		contentType = http.DetectContentType(gw.buf.Bytes())
+		h.Set("Content-Type", contentType)
+	}
+	return compressible(contentType)
+}
+
+// decide writes the header of the response, compressed or not, and the buffered start of the response
+func (gw *gzipWriter) decide(compress bool) error {
+	gw.decided = true
+	if compress {
+		gw.Header().Set("Content-Encoding", "gzip")
+		gw.Header().Del("Content-Length")
+		gw.gz = writers.Get().(*gzip.Writer)
+		gw.gz.Reset(gw.ResponseWriter)
+	}
+	gw.ResponseWriter.WriteHeader(gw.status)
+
+	if gw.buf.Len() == 0 {
+		return nil
+	}
+	var err error
+	if gw.gz != nil {
+		_, err = gw.gz.Write(gw.buf.Bytes())
+	} else {
+		_, err = gw.ResponseWriter.Write(gw.buf.Bytes())
+	}
+	gw.buf.Reset()
+	return err
+}
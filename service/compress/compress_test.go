@@ -0,0 +1,163 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package compress
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+func serve(handler http.HandlerFunc, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/signinglog", nil)
+	if len(acceptEncoding) > 0 {
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+	Middleware(100)(handler).ServeHTTP(w, r)
+	return w
+}
+
+func jsonHandler(body string, status int) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+		w.WriteHeader(status)
+		io.WriteString(w, body)
+	}
+}
+
+func decompress(t *testing.T, w *httptest.ResponseRecorder) string {
+	zr, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("Error reading the gzip response: %v", err)
+	}
+	b, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("Error decompressing the response: %v", err)
+	}
+	return string(b)
+}
+
+func TestMiddlewareCompress(t *testing.T) {
+	body := `{"logs":[` + strings.Repeat(`{"serialnumber":"R5000123"},`, 20) + `{}]}`
+
+	w := serve(jsonHandler(body, http.StatusOK), "deflate, gzip")
+
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected code 200, got %d", w.Code)
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" || w.Header().Get("Vary") != "Accept-Encoding" {
+		t.Errorf("Expected a gzip response, got headers: %v", w.Header())
+	}
+	if got := decompress(t, w); got != body {
+		t.Errorf("Expected the response body, got: %s", got)
+	}
+}
+
+func TestMiddlewareNotCompressed(t *testing.T) {
+	large := `{"logs":[` + strings.Repeat(`{"serialnumber":"R5000123"},`, 20) + `{}]}`
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		acceptEncoding string
+		code           int
+		body           string
+	}{
+		{"small", jsonHandler(`{"success":true}`, http.StatusOK), "gzip", 200, `{"success":true}`},
+		{"not-accepted", jsonHandler(large, http.StatusOK), "", 200, large},
+		{"zero-quality", jsonHandler(large, http.StatusOK), "gzip;q=0, identity", 200, large},
+		{"error-status", jsonHandler(`{"success":false}`, http.StatusBadRequest), "gzip", 400, `{"success":false}`},
+		{"binary", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/octet-stream")
+			io.WriteString(w, large)
+		}, "gzip", 200, large},
+		{"encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			w.Header().Set("Content-Encoding", "br")
+			io.WriteString(w, large)
+		}, "gzip", 200, large},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := serve(tt.handler, tt.acceptEncoding)
+
+			if w.Code != tt.code {
+				t.Errorf("Expected code %d, got %d", tt.code, w.Code)
+			}
+			if w.Header().Get("Content-Encoding") == "gzip" {
+				t.Error("Expected the response not to be compressed")
+			}
+			if w.Body.String() != tt.body {
+				t.Errorf("Expected the response body, got: %s", w.Body.String())
+			}
+		})
+	}
+}
+
+func TestMiddlewareFlush(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		io.WriteString(w, "{\"id\":1}\n")
+		w.(http.Flusher).Flush()
+		io.WriteString(w, "{\"id\":2}\n")
+	}
+
+	w := serve(handler, "gzip")
+
+	if !w.Flushed {
+		t.Error("Expected the response to be flushed")
+	}
+	if w.Header().Get("Content-Encoding") != "gzip" {
+		t.Errorf("Expected the streamed response to be compressed, got headers: %v", w.Header())
+	}
+	if got := decompress(t, w); got != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("Expected the streamed body, got: %s", got)
+	}
+}
+
+func TestMinSize(t *testing.T) {
+	tests := []struct {
+		size     int
+		expected int
+		wantErr  bool
+	}{
+		{0, defaultMinSize, false},
+		{256, 256, false},
+		{-1, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := MinSize(config.Settings{GzipMinSize: tt.size})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("MinSize(%d) error = %v, wantErr %v", tt.size, err, tt.wantErr)
+		}
+		if got != tt.expected {
+			t.Errorf("MinSize(%d) = %d, expected %d", tt.size, got, tt.expected)
+		}
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
 	"github.com/gorilla/csrf"
 )
 
@@ -62,7 +63,7 @@ func Version(w http.ResponseWriter, r *http.Request) {
 // Health is the API method to return if the app is up and db.Ping() doesn't return an error
 func Health(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", response.JSONHeader)
-	err := datastore.Environ.DB.HealthCheck()
+	err := datastore.Environ.DB.HealthCheck(r.Context())
 	var database string
 
 	if err != nil {
@@ -96,3 +97,40 @@ func Token(w http.ResponseWriter, r *http.Request) {
 		log.Message("TOKEN", "get-token", message)
 	}
 }
+
+// TokenRefresh exchanges the refresh token cookie for a new JWT, returned in the authorization
+// header and cookie. The refresh token is also renewed, so the session slides while it is in use
+func TokenRefresh(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+
+	if !datastore.Environ.Config.EnableUserAuth {
+		response.FormatStandardResponse(false, "error-auth", "", "User authentication is not enabled", w)
+		return
+	}
+
+	cookie, err := r.Cookie(usso.RefreshCookie)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "Cannot find the refresh token", w)
+		return
+	}
+
+	jwtToken, refreshToken, err := usso.RefreshJWTToken(r.Context(), cookie.Value)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	usso.AddJWTCookie(jwtToken, w)
+	if err := usso.AddRefreshCookie(refreshToken, w); err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	response := TokenResponse{EnableUserAuth: datastore.Environ.Config.EnableUserAuth}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		message := fmt.Sprintf("Error encoding the token response: %v", err)
+		log.Message("TOKEN", "refresh-token", message)
+	}
+}
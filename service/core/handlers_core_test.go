@@ -32,6 +32,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/core"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
 	check "gopkg.in/check.v1"
 )
 
@@ -57,6 +58,9 @@ func (s *CoreSuite) SetUpTest(c *check.C) {
 	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
 }
 
 func sendRequest(method, url string, data io.Reader, c *check.C) *httptest.ResponseRecorder {
@@ -114,6 +118,52 @@ func (s *CoreSuite) TestTokenHandler(c *check.C) {
 	}
 }
 
+func (s *CoreSuite) TestTokenRefreshHandler(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = true
+
+	refreshToken, err := usso.NewRefreshToken("user1", "id")
+	c.Assert(err, check.IsNil)
+	unknownToken, err := usso.NewRefreshToken("unknown", "id")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		cookie string
+		code   int
+	}{
+		{refreshToken, 200},
+		{"", 400},
+		{"invalid", 400},
+		{unknownToken, 400},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/token/refresh", nil)
+		if len(t.cookie) > 0 {
+			r.AddCookie(&http.Cookie{Name: usso.RefreshCookie, Value: t.cookie})
+		}
+		service.AdminRouter().ServeHTTP(w, r)
+
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, response.JSONHeader)
+		if t.code != 200 {
+			continue
+		}
+
+		// The new JWT is returned and the refresh token is renewed
+		c.Assert(w.Header().Get("Authorization"), check.Matches, "Bearer .+")
+		request := &http.Request{Header: http.Header{"Cookie": w.HeaderMap["Set-Cookie"]}}
+		cookie, err := request.Cookie(usso.RefreshCookie)
+		c.Assert(err, check.IsNil)
+		c.Assert(cookie.Value, check.Not(check.Equals), "")
+	}
+}
+
+func (s *CoreSuite) TestTokenRefreshHandlerAuthDisabled(c *check.C) {
+	w := sendAdminRequest("POST", "/v1/token/refresh", nil, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func (s *CoreSuite) TestHealthHandler(c *check.C) {
 	tests := []SuiteTest{
 		{false, "GET", "/v1/health", nil, 200, response.JSONHeader, "healthy", true},
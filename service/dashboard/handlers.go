@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dashboard
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Response is the JSON response from the API dashboard method
+type Response struct {
+	Success      bool                         `json:"success"`
+	ErrorCode    string                       `json:"error_code"`
+	ErrorSubcode string                       `json:"error_subcode"`
+	ErrorMessage string                       `json:"message"`
+	Accounts     []datastore.DashboardAccount `json:"accounts"`
+}
+
+// Get is the API method to fetch the summary of the accounts for the home page
+func Get(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	getHandler(r.Context(), w, authUser, false)
+}
+
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	accounts, err := datastore.Environ.DB.AllowedDashboard(ctx, user, time.Now().UTC())
+	if err != nil {
+		log.Errorf("Error fetching the dashboard: %v", err)
+		response.FormatStandardResponse(false, "error-dashboard", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(accounts, w)
+}
+
+func formatResponse(accounts []datastore.DashboardAccount, w http.ResponseWriter) {
+	r := Response{Success: true, Accounts: accounts}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(r); err != nil {
+		log.Error("Error forming the dashboard response.")
+	}
+}
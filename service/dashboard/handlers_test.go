@@ -0,0 +1,134 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package dashboard_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/dashboard"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestDashboardSuite(t *testing.T) { check.TestingT(t) }
+
+type DashboardSuite struct{}
+
+var _ = check.Suite(&DashboardSuite{})
+
+func (s *DashboardSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *DashboardSuite) TestDashboardHandler(c *check.C) {
+	tests := []struct {
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		accounts    int
+	}{
+		{200, 0, false, true, 1},
+		{200, datastore.Admin, true, true, 1},
+		{200, datastore.Superuser, true, true, 1},
+		{400, datastore.SubstoreAdmin, true, false, 0},
+		{400, datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := dashboard.Response{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(result.Accounts, check.HasLen, t.accounts)
+
+		if t.success {
+			a := result.Accounts[0]
+			c.Assert(a.AuthorityID, check.Equals, "System")
+			c.Assert(a.SigningsDay, check.Equals, 3)
+			c.Assert(a.SigningsWeek, check.Equals, 10)
+			c.Assert(a.PendingKeypairs, check.Equals, 1)
+			c.Assert(a.RecentErrors, check.HasLen, 1)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *DashboardSuite) TestDashboardHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest(0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result := dashboard.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-dashboard")
+}
+
+func sendAdminRequest(permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/dashboard", nil)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
@@ -0,0 +1,78 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// ListResponse is the JSON response from the API devices method
+type ListResponse struct {
+	Success      bool               `json:"success"`
+	ErrorCode    string             `json:"error_code"`
+	ErrorSubcode string             `json:"error_subcode"`
+	ErrorMessage string             `json:"message"`
+	Devices      []datastore.Device `json:"devices"`
+}
+
+// listHandler is the API method to fetch the devices with a serial number
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, serialNumber string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(serialNumber) == 0 {
+		response.FormatStandardResponse(false, "error-invalid-serial", "", "The serial number must be provided", w)
+		return
+	}
+
+	devices, err := datastore.Environ.DB.ListAllowedDevices(ctx, serialNumber, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-fetch-devices", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of devices
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(devices, w)
+}
+
+func formatListResponse(devices []datastore.Device, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Devices: devices}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the devices response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/snapcore/snapd/asserts"
+)
+
+// Bundle is the verification bundle of a device. The chain holds the assertions
+// that are needed to verify the serial and model assertions offline, with the store's
+// root keys. The proof links the signing logs of the device into the audit chain
+type Bundle struct {
+	Serial     string                    `json:"serial"`
+	Model      string                    `json:"model"`
+	Chain      string                    `json:"chain"`
+	SigningLog []datastore.SigningLog    `json:"signinglog"`
+	Proof      datastore.AuditChainProof `json:"proof"`
+	Created    time.Time                 `json:"created"`
+}
+
+// BundleResponse is the JSON response from the API device bundle method
+type BundleResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	Bundle       Bundle `json:"bundle"`
+}
+
+// bundleHandler verifies the serial assertion of a device and returns its verification bundle
+func bundleHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, serialAssertion []byte) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	assertion, err := asserts.Decode(serialAssertion)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorDecodeAssertion.Code, "", err.Error(), w)
+		return
+	}
+	serial, ok := assertion.(*asserts.Serial)
+	if !ok {
+		response.FormatStandardResponse(false, response.ErrorInvalidType.Code, "", response.ErrorInvalidType.Message, w)
+		return
+	}
+
+	bundle, err := buildBundle(ctx, serial, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-device-bundle", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatBundleResponse(bundle, w)
+}
+
+func buildBundle(ctx context.Context, serial *asserts.Serial, user datastore.User) (Bundle, error) {
+	// The user must be able to see the device in the registry
+	devices, err := datastore.Environ.DB.ListAllowedDevices(ctx, serial.Serial(), user)
+	if err != nil {
+		return Bundle{}, err
+	}
+	if !containsDevice(devices, serial) {
+		return Bundle{}, fmt.Errorf("cannot find the device %s/%s/%s", serial.BrandID(), serial.Model(), serial.Serial())
+	}
+
+	// Check that the serial assertion was signed by one of the signing-keys
+	keypair, err := datastore.Environ.DB.GetKeypairByPublicID(ctx, serial.AuthorityID(), serial.SignKeyID())
+	if err != nil {
+		return Bundle{}, fmt.Errorf("cannot find the signing-key of the serial assertion: %v", err)
+	}
+	publicKey, err := datastore.Environ.KeypairDB.PublicKey(keypair.KeyID)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("cannot find the public key of the signing-key: %v", err)
+	}
+	if err = asserts.SignatureCheck(serial, publicKey); err != nil {
+		return Bundle{}, fmt.Errorf("cannot verify the serial assertion: %v", err)
+	}
+
+	// The chain is only complete when the account and account-key assertions are stored
+	account, err := datastore.Environ.DB.GetAccount(ctx, serial.AuthorityID())
+	if err != nil || len(account.Assertion) == 0 {
+		return Bundle{}, fmt.Errorf("the account assertion for %s has not been uploaded", serial.AuthorityID())
+	}
+	if len(keypair.Assertion) == 0 {
+		return Bundle{}, fmt.Errorf("the account-key assertion for %s has not been uploaded", keypair.KeyID)
+	}
+	chain := []string{account.Assertion, keypair.Assertion}
+
+	// The model assertion can be signed by another signing-key, that is added to the chain
+	model, modelKeypair, err := modelAssertion(ctx, serial, user)
+	if err != nil {
+		return Bundle{}, fmt.Errorf("cannot sign the model assertion: %v", err)
+	}
+	if modelKeypair.KeyID != keypair.KeyID {
+		if len(modelKeypair.Assertion) == 0 {
+			return Bundle{}, fmt.Errorf("the account-key assertion for %s has not been uploaded", modelKeypair.KeyID)
+		}
+		chain = append(chain, modelKeypair.Assertion)
+	}
+
+	logs, err := signingLogExtract(ctx, serial, user)
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	ids := []int{}
+	for _, l := range logs {
+		ids = append(ids, l.ID)
+	}
+	proof, err := datastore.Environ.DB.SigningLogAuditChainProof(ctx, ids)
+	if err != nil {
+		return Bundle{}, err
+	}
+
+	return Bundle{
+		Serial:     string(asserts.Encode(serial)),
+		Model:      string(asserts.Encode(model)),
+		Chain:      assertionStream(chain...),
+		SigningLog: logs,
+		Proof:      proof,
+		Created:    time.Now().UTC(),
+	}, nil
+}
+
+// modelAssertion returns the signed model assertion of the device, for its model or for the sub-store
+// model that it has pivoted to, with the keypair that signed it
+func modelAssertion(ctx context.Context, serial *asserts.Serial, user datastore.User) (asserts.Assertion, datastore.Keypair, error) {
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, user, datastore.ModelFilter{BrandID: serial.BrandID(), Name: serial.Model()})
+	if err != nil {
+		return nil, datastore.Keypair{}, err
+	}
+	for _, m := range models {
+		if m.BrandID != serial.BrandID() || m.Name != serial.Model() {
+			continue
+		}
+		headers, keypair, err := assertion.CreateModelAssertionHeaders(ctx, m)
+		if err != nil {
+			return nil, keypair, err
+		}
+		signed, err := assertion.SignModelAssertion(ctx, m, 0, headers, keypair)
+		return signed, keypair, err
+	}
+
+	substore, err := datastore.Environ.DB.GetSubstoreModel(ctx, serial.BrandID(), serial.Model(), serial.Serial())
+	if err != nil {
+		return nil, datastore.Keypair{}, fmt.Errorf("cannot find the model %s/%s", serial.BrandID(), serial.Model())
+	}
+	headers, keypair, err := assertion.CreatePivotModelAssertionHeaders(ctx, substore)
+	if err != nil {
+		return nil, keypair, err
+	}
+	signed, err := assertion.SignModelAssertion(ctx, substore.FromModel, substore.ID, headers, keypair)
+	return signed, keypair, err
+}
+
+func containsDevice(devices []datastore.Device, serial *asserts.Serial) bool {
+	for _, d := range devices {
+		if d.Brand == serial.BrandID() && d.Model == serial.Model() {
+			return true
+		}
+	}
+	return false
+}
+
+// signingLogExtract returns the signing logs of the device, for its device-key
+func signingLogExtract(ctx context.Context, serial *asserts.Serial, user datastore.User) ([]datastore.SigningLog, error) {
+	filter := datastore.SigningLogFilter{Model: serial.Model(), Serial: serial.Serial(), Fingerprint: serial.DeviceKey().ID()}
+	page := datastore.SigningLogPage{Limit: datastore.ListSigningLogMaxLimit}
+
+	logs, _, err := datastore.Environ.DB.ListAllowedSigningLog(ctx, user, filter, page)
+	if err != nil {
+		return nil, err
+	}
+
+	// The serial number filter is a partial match
+	extract := []datastore.SigningLog{}
+	for _, l := range logs {
+		if l.Make == serial.BrandID() && l.SerialNumber == serial.Serial() {
+			extract = append(extract, l)
+		}
+	}
+	return extract, nil
+}
+
+// assertionStream joins the assertions in a stream that can be decoded or acked by snapd
+func assertionStream(assertions ...string) string {
+	stream := []string{}
+	for _, a := range assertions {
+		stream = append(stream, strings.TrimSpace(a))
+	}
+	return strings.Join(stream, "\n\n") + "\n"
+}
+
+func formatBundleResponse(bundle Bundle, w http.ResponseWriter) error {
+	response := BundleResponse{Success: true, Bundle: bundle}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the device bundle response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,183 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// PurgeResponse is the JSON response from the API device data purge method
+type PurgeResponse struct {
+	Success      bool                          `json:"success"`
+	ErrorCode    string                        `json:"error_code"`
+	ErrorSubcode string                        `json:"error_subcode"`
+	ErrorMessage string                        `json:"message"`
+	Certificate  datastore.DeletionCertificate `json:"certificate"`
+}
+
+// PurgeListResponse is the JSON response from the API deletion certificates method
+type PurgeListResponse struct {
+	Success      bool                            `json:"success"`
+	ErrorCode    string                          `json:"error_code"`
+	ErrorSubcode string                          `json:"error_subcode"`
+	ErrorMessage string                          `json:"message"`
+	Certificates []datastore.DeletionCertificate `json:"certificates"`
+}
+
+// VerifyPurgeRequest is the deletion certificate to verify, with the optional serial number or
+// fingerprint to match to the certificate
+type VerifyPurgeRequest struct {
+	Certificate  datastore.DeletionCertificate `json:"certificate"`
+	SerialNumber string                        `json:"serial"`
+	Fingerprint  string                        `json:"fingerprint"`
+}
+
+// VerifyPurgeResponse is the JSON response from the API deletion certificate verification method
+type VerifyPurgeResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	Valid        bool   `json:"valid"`
+	Matched      bool   `json:"matched"`
+}
+
+// PurgePublicKeyResponse is the JSON response from the API deletion certificate public key method
+type PurgePublicKeyResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	PublicKey    string `json:"publicKey"`
+}
+
+// purgeHandler purges the records of a serial number or a device-key fingerprint, returning
+// the signed deletion certificate
+func purgeHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, request datastore.DataPurgeRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err := request.Validate(); err != nil {
+		response.FormatStandardResponse(false, "error-invalid-purge", "", err.Error(), w)
+		return
+	}
+
+	// The audit log is recorded in the transaction of the purge, without the serial number
+	entry := audit.Entry(user, audit.ActionPurge, audit.ObjectDevice, 0, "", nil, nil)
+
+	cert, err := datastore.Environ.DB.PurgeDeviceData(ctx, request, entry)
+	if err != nil {
+		log.Error("error-purge-device", err)
+		response.FormatStandardResponse(false, "error-purge-device", "", err.Error(), w)
+		return
+	}
+	log.Infof("Device data purged by %s, certificate %d", user.Username, cert.ID)
+
+	w.WriteHeader(http.StatusOK)
+	formatPurgeResponse(PurgeResponse{Success: true, Certificate: cert}, w)
+}
+
+func purgeListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	certs, err := datastore.Environ.DB.ListDeletionCertificates(ctx)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-purge-certificates", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPurgeResponse(PurgeListResponse{Success: true, Certificates: certs}, w)
+}
+
+// purgeVerifyHandler checks the signature of a deletion certificate and, when it is given,
+// that the serial number or the fingerprint is the subject of the certificate
+func purgeVerifyHandler(w http.ResponseWriter, user datastore.User, apiCall bool, request VerifyPurgeRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	valid, err := datastore.Environ.VerifyDeletionCertificate(request.Certificate)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-verify-certificate", "", err.Error(), w)
+		return
+	}
+
+	result := VerifyPurgeResponse{Success: true, Valid: valid}
+	subject, value := datastore.PurgeSubjectSerial, request.SerialNumber
+	if len(request.Fingerprint) > 0 {
+		subject, value = datastore.PurgeSubjectFingerprint, request.Fingerprint
+	}
+	if len(value) > 0 {
+		hash, err := datastore.Environ.PurgeSubjectHash(subject, value)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-verify-certificate", "", err.Error(), w)
+			return
+		}
+		result.Matched = subject == request.Certificate.Subject && hash == request.Certificate.SubjectHash
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPurgeResponse(result, w)
+}
+
+// purgePublicKeyHandler returns the PEM-encoded public key of the deletion certificates
+func purgePublicKeyHandler(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	publicKey, err := datastore.Environ.DeletionCertificatePublicKey()
+	if err != nil {
+		response.FormatStandardResponse(false, "error-certificate-key", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPurgeResponse(PurgePublicKeyResponse{Success: true, PublicKey: publicKey}, w)
+}
+
+func formatPurgeResponse(result interface{}, w http.ResponseWriter) {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("Error forming the device purge response.")
+	}
+}
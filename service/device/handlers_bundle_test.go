@@ -0,0 +1,151 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/snapcore/snapd/asserts"
+	check "gopkg.in/check.v1"
+)
+
+const systemKeyID = "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"
+
+func (s *DeviceSuite) TestDeviceBundleHandler(c *check.C) {
+	// The serial assertions are signed with the test key of the filesystem keystore
+	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
+	datastore.OpenKeyStore(settings)
+
+	serial := signSerial("alder", "A123456L", c)
+	unknown := signSerial("alder", "A999", c)
+
+	tests := []struct {
+		data        []byte
+		code        int
+		permissions int
+		enableAuth  bool
+		errorCode   string
+	}{
+		{serial, 200, 0, false, ""},
+		{serial, 200, datastore.SubstoreAdmin, true, ""},
+		{serial, 400, datastore.Standard, true, "error-auth"},
+		{unknown, 400, 0, false, "error-device-bundle"},
+		{[]byte("invalid"), 400, 0, false, "decode-assertion"},
+		{nil, 400, 0, false, "empty-data"},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.enableAuth
+
+		w := sendBundleRequest(t.data, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := device.BundleResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.errorCode)
+
+		if t.code == 200 {
+			// The bundle holds the serial assertion and the assertions to verify it
+			a, err := asserts.Decode([]byte(result.Bundle.Serial))
+			c.Assert(err, check.IsNil)
+			c.Assert(a.HeaderString("serial"), check.Equals, "A123456L")
+			c.Assert(result.Bundle.Chain, check.Equals, "assertion\n\naccount-key assertion\n")
+
+			// The model assertion and the audit chain proof of the signing logs
+			m, err := asserts.Decode([]byte(result.Bundle.Model))
+			c.Assert(err, check.IsNil)
+			c.Assert(m.Type(), check.Equals, asserts.ModelType)
+			c.Assert(m.HeaderString("model"), check.Equals, "alder")
+			c.Assert(len(result.Bundle.Proof.Entries), check.Equals, len(result.Bundle.SigningLog))
+			c.Assert(result.Bundle.Proof.Last, check.NotNil)
+		}
+	}
+	datastore.Environ.Config.EnableUserAuth = false
+}
+
+func (s *DeviceSuite) TestDeviceBundleErrorHandler(c *check.C) {
+	settings := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: settings}
+	datastore.OpenKeyStore(settings)
+
+	serial := signSerial("alder", "A123456L", c)
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendBundleRequest(serial, 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result := device.BundleResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-device-bundle")
+}
+
+func signSerial(model, serial string, c *check.C) []byte {
+	signingKey, err := ioutil.ReadFile("../../keystore/TestDeviceKey.asc")
+	c.Assert(err, check.IsNil)
+	deviceKey, _, err := crypt.DeserializePrivateKey(base64.StdEncoding.EncodeToString(signingKey))
+	c.Assert(err, check.IsNil)
+	encodedPubKey, err := asserts.EncodePublicKey(deviceKey.PublicKey())
+	c.Assert(err, check.IsNil)
+
+	headers := map[string]interface{}{
+		"authority-id":        "system",
+		"brand-id":            "system",
+		"model":               model,
+		"serial":              serial,
+		"device-key":          string(encodedPubKey),
+		"device-key-sha3-384": deviceKey.PublicKey().ID(),
+		"timestamp":           time.Now().Format(time.RFC3339),
+	}
+	assertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, headers, nil, "system", systemKeyID, "")
+	c.Assert(err, check.IsNil)
+
+	return asserts.Encode(assertion)
+}
+
+func sendBundleRequest(data []byte, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/devices/bundle", bytes.NewReader(data))
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
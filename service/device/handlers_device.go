@@ -0,0 +1,116 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package device
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// List is the API method to fetch the devices with a serial number from the registry
+func List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false, r.URL.Query().Get("serial"))
+}
+
+// VerificationBundle is the API method to fetch the verification bundle of a device
+// from its serial assertion
+func VerificationBundle(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	serialAssertion, err := ioutil.ReadAll(r.Body)
+	if err != nil || len(serialAssertion) == 0 {
+		response.FormatStandardResponse(false, response.ErrorEmptyData.Code, "", "No serial assertion supplied", w)
+		return
+	}
+
+	bundleHandler(r.Context(), w, authUser, false, serialAssertion)
+}
+
+// Purge is the API method to purge the records of a serial number or a device-key fingerprint
+func Purge(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	request := datastore.DataPurgeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", "Invalid data purge request", w)
+		return
+	}
+
+	purgeHandler(r.Context(), w, authUser, false, request)
+}
+
+// PurgeList is the API method to list the deletion certificates of the purged devices
+func PurgeList(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	purgeListHandler(r.Context(), w, authUser, false)
+}
+
+// PurgePublicKey is the API method to fetch the public key of the deletion certificates, so they
+// can be verified offline. The key is public, so it is not restricted to a role
+func PurgePublicKey(w http.ResponseWriter, r *http.Request) {
+	purgePublicKeyHandler(w)
+}
+
+// PurgeVerify is the API method to verify a deletion certificate
+func PurgeVerify(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	request := VerifyPurgeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", "Invalid deletion certificate", w)
+		return
+	}
+
+	purgeVerifyHandler(w, authUser, false, request)
+}
@@ -0,0 +1,149 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package device_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestDeviceSuite(t *testing.T) { check.TestingT(t) }
+
+type DeviceSuite struct{}
+
+var _ = check.Suite(&DeviceSuite{})
+
+type DeviceTest struct {
+	URL         string
+	Code        int
+	Permissions int
+	EnableAuth  bool
+	Success     bool
+	List        int
+}
+
+func (s *DeviceSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *DeviceSuite) TestDeviceListHandler(c *check.C) {
+	tests := []DeviceTest{
+		{"/v1/devices?serial=A1", 200, 0, false, true, 1},
+		{"/v1/devices?serial=A1", 200, datastore.Admin, true, true, 1},
+		{"/v1/devices?serial=A1", 200, datastore.SubstoreAdmin, true, true, 1},
+		{"/v1/devices?serial=A1", 400, datastore.Standard, true, false, 0},
+		{"/v1/devices?serial=A1", 400, 0, true, false, 0},
+		{"/v1/devices?serial=B1", 200, datastore.Admin, true, true, 0},
+		{"/v1/devices", 400, datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.URL, t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Devices), check.Equals, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *DeviceSuite) TestDeviceListResponse(c *check.C) {
+	w := sendAdminRequest("/v1/devices?serial=A1", 0, c)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result, err := parseListResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Devices, check.HasLen, 1)
+	c.Assert(result.Devices[0].Brand, check.Equals, "System")
+	c.Assert(result.Devices[0].Model, check.Equals, "Router 3400")
+	c.Assert(result.Devices[0].Fingerprint, check.Equals, "a1")
+	c.Assert(result.Devices[0].PivotStatus, check.Equals, datastore.PivotNone)
+	c.Assert(result.Devices[0].RevocationState, check.Equals, datastore.DeviceActive)
+}
+
+func (s *DeviceSuite) TestDeviceListErrorHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("/v1/devices?serial=A1", 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result, err := parseListResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-fetch-devices")
+}
+
+func parseListResponse(w *httptest.ResponseRecorder) (device.ListResponse, error) {
+	// Check the JSON response
+	result := device.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func sendAdminRequest(url string, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
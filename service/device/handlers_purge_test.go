@@ -0,0 +1,177 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package device_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	check "gopkg.in/check.v1"
+)
+
+func (s *DeviceSuite) TestPurgeHandler(c *check.C) {
+	datastore.Environ.Config.KeyStoreSecret = "secret"
+
+	tests := []struct {
+		body        string
+		code        int
+		permissions int
+		enableAuth  bool
+		mockError   bool
+		subject     string
+	}{
+		{`{"serial":"A1"}`, 200, datastore.Superuser, true, false, datastore.PurgeSubjectSerial},
+		{`{"fingerprint":"a1","mode":"anonymize"}`, 200, datastore.Superuser, true, false, datastore.PurgeSubjectFingerprint},
+		{`{"serial":"A1"}`, 400, datastore.Admin, true, false, ""},
+		{`{"serial":"A1","fingerprint":"a1"}`, 400, datastore.Superuser, true, false, ""},
+		{`{}`, 400, datastore.Superuser, true, false, ""},
+		{`{"serial":"A1","mode":"shred"}`, 400, datastore.Superuser, true, false, ""},
+		{`invalid`, 400, datastore.Superuser, true, false, ""},
+		{`{"serial":"A1"}`, 400, datastore.Superuser, true, true, ""},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.enableAuth
+		if t.mockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendPurgeRequest("POST", "/v1/devices/purge", t.body, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf(t.body))
+
+		result := device.PurgeResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.code == 200)
+		if result.Success {
+			c.Assert(result.Certificate.Subject, check.Equals, t.subject)
+			c.Assert(result.Certificate.Signature, check.Not(check.Equals), "")
+			c.Assert(result.Certificate.PurgedBy, check.Equals, "sv")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *DeviceSuite) TestPurgeListHandler(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	w := sendPurgeRequest("GET", "/v1/devices/purge", "", datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 200)
+	result := device.PurgeListResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+	c.Assert(result.Certificates, check.HasLen, 1)
+
+	w = sendPurgeRequest("GET", "/v1/devices/purge", "", datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
+func (s *DeviceSuite) TestPurgeVerifyHandler(c *check.C) {
+	datastore.Environ.Config.KeyStoreSecret = "secret"
+
+	cert, err := datastore.Environ.DB.PurgeDeviceData(context.Background(), datastore.DataPurgeRequest{SerialNumber: "A1"}, datastore.AuditLog{Username: "sv"})
+	c.Assert(err, check.IsNil)
+
+	tampered := cert
+	tampered.SigningLogs = 1
+
+	tests := []struct {
+		request verifyCase
+		valid   bool
+		matched bool
+	}{
+		{verifyCase{cert, "A1", ""}, true, true},
+		{verifyCase{cert, "B1", ""}, true, false},
+		{verifyCase{cert, "", "a1"}, true, false},
+		{verifyCase{tampered, "A1", ""}, false, true},
+	}
+
+	datastore.Environ.Config.EnableUserAuth = true
+	defer func() { datastore.Environ.Config.EnableUserAuth = false }()
+
+	for _, t := range tests {
+		body, _ := json.Marshal(device.VerifyPurgeRequest{Certificate: t.request.cert, SerialNumber: t.request.serial, Fingerprint: t.request.fingerprint})
+		w := sendPurgeRequest("POST", "/v1/devices/purge/verify", string(body), datastore.Superuser, c)
+		c.Assert(w.Code, check.Equals, 200)
+
+		result := device.VerifyPurgeResponse{}
+		c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+		c.Assert(result.Valid, check.Equals, t.valid)
+		c.Assert(result.Matched, check.Equals, t.matched)
+	}
+}
+
+func (s *DeviceSuite) TestPurgePublicKeyHandler(c *check.C) {
+	datastore.Environ.Config.KeyStoreSecret = "secret"
+
+	// The certificate is verified offline with the published public key
+	w := sendPurgeRequest("GET", "/v1/devices/purge/publickey", "", 0, c)
+	c.Assert(w.Code, check.Equals, 200)
+	result := device.PurgePublicKeyResponse{}
+	c.Assert(json.NewDecoder(w.Body).Decode(&result), check.IsNil)
+
+	block, _ := pem.Decode([]byte(result.PublicKey))
+	c.Assert(block, check.NotNil)
+	publicKey, err := x509.ParsePKIXPublicKey(block.Bytes)
+	c.Assert(err, check.IsNil)
+
+	cert, err := datastore.Environ.DB.PurgeDeviceData(context.Background(), datastore.DataPurgeRequest{SerialNumber: "A1"}, datastore.AuditLog{Username: "sv"})
+	c.Assert(err, check.IsNil)
+	valid, err := datastore.VerifyDeletionCertificate(publicKey.(*ecdsa.PublicKey), cert)
+	c.Assert(err, check.IsNil)
+	c.Assert(valid, check.Equals, true)
+
+	datastore.Environ.Config.KeyStoreSecret = ""
+	w = sendPurgeRequest("GET", "/v1/devices/purge/publickey", "", 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
+// verifyCase is the certificate and the subject of a verification test
+type verifyCase struct {
+	cert        datastore.DeletionCertificate
+	serial      string
+	fingerprint string
+}
+
+func sendPurgeRequest(method, url, body string, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, bytes.NewBufferString(body))
+
+	if permissions > 0 {
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
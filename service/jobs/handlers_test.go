@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package jobs_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/jobs"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestJobsSuite(t *testing.T) { check.TestingT(t) }
+
+type JobsSuite struct{}
+
+var _ = check.Suite(&JobsSuite{})
+
+func (s *JobsSuite) SetUpSuite(c *check.C) {
+	scheduler.Add(scheduler.Job{Name: "nonce-purge", Interval: time.Hour, Run: func(ctx context.Context) error { return nil }})
+}
+
+func (s *JobsSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *JobsSuite) TestListHandler(c *check.C) {
+	tests := []struct {
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		jobs        int
+	}{
+		{400, 0, false, false, 0},
+		{400, datastore.Admin, true, false, 0},
+		{200, datastore.Superuser, true, true, 1},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.enableAuth
+
+		w := sendAdminRequest("GET", "/v1/jobs", t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := jobs.ListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(len(result.Jobs), check.Equals, t.jobs)
+		if t.jobs > 0 {
+			c.Assert(result.Jobs[0].Name, check.Equals, "nonce-purge")
+			c.Assert(result.Jobs[0].Interval, check.Equals, "1h0m0s")
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func sendAdminRequest(method, url string, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, nil)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
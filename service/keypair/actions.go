@@ -21,6 +21,7 @@
 package keypair
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"fmt"
@@ -30,8 +31,11 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/notify"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/webhook"
 	"github.com/snapcore/snapd/asserts"
 )
 
@@ -63,7 +67,7 @@ type ProgressResponse struct {
 }
 
 // listHandler is the API method to fetch the signing keys
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -72,7 +76,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(user)
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(ctx, user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypairs.Code, "", err.Error(), w)
 		return
@@ -84,7 +88,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // getHandler is the API method to fetch a signing key
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keypairID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -93,19 +97,20 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypai
 		return
 	}
 
-	keypair, err := datastore.Environ.DB.GetKeypair(keypairID)
+	keypair, err := datastore.Environ.DB.GetKeypair(ctx, keypairID)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
 		return
 	}
 
 	// Return successful JSON response with the keypair
+	response.SetVersion(w, keypair.Version)
 	w.WriteHeader(http.StatusOK)
 	formatGetResponse(keypair, w)
 }
 
 // updateHandler is the API method to update a signing key
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypair datastore.Keypair) {
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keypair datastore.Keypair) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -114,20 +119,33 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 		return
 	}
 
-	k, err := datastore.Environ.DB.GetKeypair(keypair.ID)
+	// The update must be based on a version of the keypair, so a concurrent change is not overwritten
+	if keypair.Version <= 0 {
+		response.FormatErrorResponse(response.ErrorVersionRequired, w)
+		return
+	}
+
+	k, err := datastore.Environ.DB.GetKeypair(ctx, keypair.ID)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", err.Error(), w)
 		return
 	}
 
-	// Update the key name
+	// Update the key name, unless the keypair has been changed since the version of the update
+	before := k
 	k.KeyName = keypair.KeyName
+	k.Version = keypair.Version
 
-	errorCode, err := datastore.Environ.DB.PutKeypair(k)
+	errorCode, err := datastore.Environ.DB.PutKeypair(ctx, k)
+	if err == datastore.ErrVersionConflict {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectKeypair, k.ID, k.AuthorityID, before, k)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
@@ -135,7 +153,7 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 }
 
 // createHandler is the API method to create a signing key
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
+func createHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
@@ -161,11 +179,51 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 		SealedKey:   sealedPrivateKey,
 		KeyName:     keypairWithKey.KeyName,
 	}
-	errorCode, err := datastore.Environ.DB.PutKeypair(keypair)
+	errorCode, err := datastore.Environ.DB.PutKeypair(ctx, keypair)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
 	}
+	recordCreated(ctx, user, keypair)
+
+	// Return success response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// cardHandler is the API method to add a signing key that is held on an OpenPGP card
+func cardHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPublicKey) {
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	if len(strings.TrimSpace(keypairWithKey.KeyName)) == 0 {
+		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "The key name must be supplied", w)
+		return
+	}
+
+	// Import the public key and link it to the signing-key on the card
+	publicKey, sealedKey, err := datastore.ImportOpenPGPCardKey(keypairWithKey.PublicKey)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
+		return
+	}
+
+	// Store the reference to the signing-key in the database
+	keypair := datastore.Keypair{
+		AuthorityID: keypairWithKey.AuthorityID,
+		KeyID:       publicKey.ID(),
+		SealedKey:   sealedKey,
+		KeyName:     keypairWithKey.KeyName,
+	}
+	errorCode, err := datastore.Environ.DB.PutKeypair(ctx, keypair)
+	if err != nil {
+		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
+		return
+	}
+	recordCreated(ctx, user, keypair)
 
 	// Return success response
 	w.WriteHeader(http.StatusOK)
@@ -173,7 +231,7 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, key
 }
 
 // generateHandler is the API method to generate a signing key
-func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
+func generateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keypairWithKey WithPrivateKey) {
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
@@ -185,7 +243,20 @@ func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, k
 		return
 	}
 
-	go datastore.GenerateKeypair(keypairWithKey.AuthorityID, "", keypairWithKey.KeyName)
+	// Generate the keypair in the background, so the shutdown waits for it, and register it with the store
+	datastore.RunBackgroundJob(ctx, "generate keypair", func(ctx context.Context) {
+		generated := map[string]interface{}{"object": audit.ObjectKeypair, "after": map[string]string{"AuthorityID": keypairWithKey.AuthorityID, "KeyName": keypairWithKey.KeyName}}
+		if err := datastore.GenerateKeypair(ctx, keypairWithKey.AuthorityID, "", keypairWithKey.KeyName); err != nil {
+			notify.Send(ctx, notify.EventKeypairFailed, keypairWithKey.AuthorityID, map[string]interface{}{"KeyName": keypairWithKey.KeyName, "Error": err.Error()})
+			generated["error"] = err.Error()
+			webhook.Publish(ctx, keypairWithKey.AuthorityID, datastore.WebhookEventKeypair, webhook.ActionGenerateFailed, generated)
+			return
+		}
+		notify.Send(ctx, notify.EventKeypairGenerated, keypairWithKey.AuthorityID, map[string]interface{}{"KeyName": keypairWithKey.KeyName})
+		webhook.Publish(ctx, keypairWithKey.AuthorityID, datastore.WebhookEventKeypair, webhook.ActionGenerated, generated)
+		recordCreated(ctx, user, datastore.Keypair{AuthorityID: keypairWithKey.AuthorityID, KeyName: keypairWithKey.KeyName})
+		registerGenerated(ctx, user, keypairWithKey.AuthorityID, keypairWithKey.KeyName)
+	})
 
 	// Return the URL to watch for the response
 	statusURL := fmt.Sprintf("/v1/keypairs/status/%s/%s", keypairWithKey.AuthorityID, keypairWithKey.KeyName)
@@ -194,28 +265,47 @@ func generateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, k
 	response.FormatStandardResponse(true, "", "", statusURL, w)
 }
 
+// recordCreated logs the new keypair in the audit log, with the ID that the database has given it
+func recordCreated(ctx context.Context, user datastore.User, keypair datastore.Keypair) {
+	if k, err := datastore.Environ.DB.GetKeypairByName(ctx, keypair.AuthorityID, keypair.KeyName); err == nil {
+		keypair = k
+	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectKeypair, keypair.ID, keypair.AuthorityID, nil, keypair)
+}
+
 // enableDisableHandler is the API method to enable/disable a signing key
-func enableDisableHandler(w http.ResponseWriter, user datastore.User, apiCall bool, enabled bool, keypairID int) {
+func enableDisableHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, enabled bool, keypairID int) {
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
 		return
 	}
 
+	// The keypair before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetKeypair(ctx, keypairID)
+
 	// Update the keypair in the local database
-	err = datastore.Environ.DB.UpdateAllowedKeypairActive(keypairID, enabled, user)
+	err = datastore.Environ.DB.UpdateAllowedKeypairActive(ctx, keypairID, enabled, user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
 		return
 	}
 
+	action := audit.ActionDisable
+	if enabled {
+		action = audit.ActionEnable
+	}
+	after := before
+	after.Active = enabled
+	audit.Record(ctx, user, action, audit.ObjectKeypair, keypairID, before.AuthorityID, before, after)
+
 	// Return success response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
 // assertionHandler is the API method to update a key assertion
-func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
+func assertionHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, assertionRequest AssertionRequest) {
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
@@ -255,11 +345,12 @@ func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool,
 		Assertion:   string(decodedAssertion),
 	}
 
-	errorCode, err := datastore.Environ.DB.UpdateKeypairAssertion(keypair, user)
+	errorCode, err := datastore.Environ.DB.UpdateKeypairAssertion(ctx, keypair, user)
 	if err != nil {
 		response.FormatStandardResponse(false, errorCode, "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectKeypair, keypair.ID, keypair.AuthorityID, nil, keypair)
 
 	// Return success response
 	w.WriteHeader(http.StatusOK)
@@ -267,7 +358,7 @@ func assertionHandler(w http.ResponseWriter, user datastore.User, apiCall bool,
 }
 
 // statusHandler is the API method to fetch the status of a signing key
-func statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, keyName string) {
+func statusHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID, keyName string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -277,12 +368,12 @@ func statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, aut
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(user.Username, authorityID) {
+	if !datastore.Environ.DB.CheckUserInAccount(ctx, user.Username, authorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
 		return
 	}
 
-	ks, err := datastore.Environ.DB.GetKeypairStatus(authorityID, keyName)
+	ks, err := datastore.Environ.DB.GetKeypairStatus(ctx, authorityID, keyName)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "Cannot find the status of the keypair", w)
 		return
@@ -294,7 +385,7 @@ func statusHandler(w http.ResponseWriter, user datastore.User, apiCall bool, aut
 }
 
 // progressHandler is the API method to fetch the progress of signing key generation
-func progressHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func progressHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -303,7 +394,7 @@ func progressHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	ks, err := datastore.Environ.DB.ListAllowedKeypairStatus(user)
+	ks, err := datastore.Environ.DB.ListAllowedKeypairStatus(ctx, user)
 	if err != nil {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "Cannot find the status of the keypairs", w)
 		return
@@ -317,7 +408,7 @@ func formatListResponse(success bool, errorCode, errorSubcode, message string, k
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the keypairs response.")
+		log.Error("Error forming the keypairs response.")
 		return err
 	}
 	return nil
@@ -328,7 +419,7 @@ func formatGetResponse(keypair datastore.Keypair, w http.ResponseWriter) error {
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the keypair response.")
+		log.Error("Error forming the keypair response.")
 		return err
 	}
 	return nil
@@ -339,7 +430,7 @@ func formatProgressResponse(status []datastore.KeypairStatus, w http.ResponseWri
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the keypair status response.")
+		log.Error("Error forming the keypair status response.")
 		return err
 	}
 	return nil
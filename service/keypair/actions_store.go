@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keypair
+
+import (
+	"context"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/store"
+)
+
+// RegisterWithStore registers the account-key of a keypair with the store, using the configured
+// store credentials, and stores the account-key assertion that the store issues
+func RegisterWithStore(ctx context.Context, user datastore.User, keypair datastore.Keypair) error {
+	assertion, err := store.RegisterKeyWithCredentials(keypair)
+	if err != nil {
+		log.Message("KEYPAIR", response.ErrorStoreKeypair.Code, err.Error())
+		return err
+	}
+
+	before := keypair
+	keypair.Assertion = assertion
+	if _, err = datastore.Environ.DB.UpdateKeypairAssertion(ctx, keypair, datastore.User{}); err != nil {
+		log.Message("KEYPAIR", response.ErrorStoreKeypair.Code, err.Error())
+		return err
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectKeypair, keypair.ID, keypair.AuthorityID, before, keypair)
+
+	log.Infof("Account-key of '%s' registered with the store", keypair.KeyName)
+	return nil
+}
+
+// registerGenerated registers a generated keypair with the store, when the store credentials are
+// configured. A failed registration leaves the keypair, which can be registered again
+func registerGenerated(ctx context.Context, user datastore.User, authorityID, keyName string) {
+	if !store.HasCredentials() {
+		return
+	}
+
+	keypair, err := datastore.Environ.DB.GetKeypairByName(ctx, authorityID, keyName)
+	if err != nil {
+		log.Message("KEYPAIR", response.ErrorFetchKeypair.Code, err.Error())
+		return
+	}
+
+	RegisterWithStore(ctx, user, keypair)
+}
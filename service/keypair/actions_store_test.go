@@ -0,0 +1,56 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package keypair_test
+
+import (
+	"context"
+	"errors"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/keypair"
+	"github.com/CanonicalLtd/serial-vault/store"
+	check "gopkg.in/check.v1"
+)
+
+func (s *KeypairSuite) TestRegisterWithStore(c *check.C) {
+	register := store.RegisterKeyWithCredentials
+	defer func() { store.RegisterKeyWithCredentials = register }()
+
+	store.RegisterKeyWithCredentials = func(k datastore.Keypair) (string, error) {
+		return "account-key assertion\n", nil
+	}
+	user := datastore.User{Username: "sv", Role: datastore.Admin}
+	k := datastore.Keypair{ID: 1, AuthorityID: "system", KeyName: "system"}
+
+	err := keypair.RegisterWithStore(context.Background(), user, k)
+	c.Assert(err, check.IsNil)
+
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	err = keypair.RegisterWithStore(context.Background(), user, k)
+	c.Assert(err, check.NotNil)
+	datastore.Environ.DB = &datastore.MockDB{}
+
+	store.RegisterKeyWithCredentials = func(k datastore.Keypair) (string, error) {
+		return "", errors.New("MOCK error registering the key")
+	}
+	err = keypair.RegisterWithStore(context.Background(), user, k)
+	c.Assert(err, check.NotNil)
+}
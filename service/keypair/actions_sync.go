@@ -21,10 +21,12 @@
 package keypair
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
@@ -39,7 +41,7 @@ type SyncResponse struct {
 // syncHandler fetches the signing-keys accessible by a user
 // A encryption secret is provided and the keypairs are decrypted and re-encrypted
 // using the supplied keystore secret
-func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, request SyncRequest) {
+func syncHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, request SyncRequest) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -56,7 +58,7 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 	}
 
 	// Get the keypairs that the user can access (does not include the sealed key)
-	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(user)
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(ctx, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-sync-keypairs", "", err.Error(), w)
 		return
@@ -66,14 +68,19 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 
 	for _, k := range keypairs {
 		// Get the keypair with the sealed key
-		keypair, err := datastore.Environ.DB.GetKeypair(k.ID)
+		keypair, err := datastore.Environ.DB.GetKeypair(ctx, k.ID)
 		if err != nil {
 			response.FormatStandardResponse(false, "error-sync-keypair", "", err.Error(), w)
 			return
 		}
 
+		// Signing-keys on an OpenPGP card cannot be copied to the factory
+		if datastore.IsOpenPGPCardKey(keypair.SealedKey) {
+			continue
+		}
+
 		// Decrypt and re-encrypt the keypair with the supplied keystore secret
-		base64SealedSigningkey, base64AuthKeyHash, err := datastore.ReEncryptKeypair(keypair, request.Secret)
+		base64SealedSigningkey, base64AuthKeyHash, err := datastore.ReEncryptKeypair(ctx, keypair, request.Secret)
 		if err != nil {
 			response.FormatStandardResponse(false, "error-sync-encrypt", "", err.Error(), w)
 			return
@@ -86,6 +93,11 @@ func syncHandler(w http.ResponseWriter, user datastore.User, apiCall bool, reque
 		syncKeypairs = append(syncKeypairs, skp)
 	}
 
+	// The signing-keys leave the vault, sealed with the secret of the factory
+	for _, k := range syncKeypairs {
+		audit.Record(ctx, user, audit.ActionExport, audit.ObjectKeypair, k.ID, k.AuthorityID, nil, nil)
+	}
+
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
 	formatSyncResponse(syncKeypairs, w)
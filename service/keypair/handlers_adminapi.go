@@ -45,7 +45,25 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	listHandler(r.Context(), w, user, true)
+}
+
+// APICreate is the API method to upload a signing-key
+func APICreate(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	keypairWithKey, ok := verifyKeypair(w, r, user)
+	if !ok {
+		return
+	}
+
+	// Call the API with the user
+	createHandler(r.Context(), w, user, true, keypairWithKey)
 }
 
 // APISyncKeypairs fetches the signing-keys accessible by a user
@@ -73,5 +91,5 @@ func APISyncKeypairs(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	syncHandler(w, user, true, request)
+	syncHandler(r.Context(), w, user, true, request)
 }
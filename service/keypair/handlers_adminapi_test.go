@@ -21,14 +21,19 @@ package keypair_test
 
 import (
 	"bytes"
+	"context"
+	"encoding/base64"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 
+	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
+	"github.com/CanonicalLtd/serial-vault/service/response"
 	check "gopkg.in/check.v1"
 )
 
@@ -59,6 +64,39 @@ func (s *KeypairSuite) TestAPIKeypairsHandler(c *check.C) {
 	}
 }
 
+func (s *KeypairSuite) TestAPICreateHandler(c *check.C) {
+	// Mock the database and the keystore
+	config := config.Settings{KeyStoreType: "memory", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	signingKey, err := ioutil.ReadFile("../../keystore/TestKey.asc")
+	c.Assert(err, check.IsNil)
+	k := keypair.WithPrivateKey{PrivateKey: base64.StdEncoding.EncodeToString(signingKey), AuthorityID: "system", KeyName: "serial-key"}
+	data, _ := json.Marshal(k)
+
+	tests := []KeypairTest{
+		{"POST", "/api/keypairs", data, 400, response.JSONHeader, 0, false, false, 0},
+		{"POST", "/api/keypairs", data, 200, response.JSONHeader, datastore.Admin, false, true, 0},
+		{"POST", "/api/keypairs", []byte(""), 400, response.JSONHeader, datastore.Admin, false, false, 0},
+		{"POST", "/api/keypairs", []byte("bad"), 400, response.JSONHeader, datastore.Admin, false, false, 0},
+		{"POST", "/api/keypairs", []byte("{}"), 400, response.JSONHeader, datastore.Admin, false, false, 0},
+		{"POST", "/api/keypairs", data, 400, response.JSONHeader, datastore.SyncUser, false, false, 0},
+		{"POST", "/api/keypairs", data, 400, response.JSONHeader, datastore.Standard, false, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.KeypairDB, _ = datastore.GetMemoryKeyStore(config)
+
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
 func (s *KeypairSuite) TestAPISyncKeypairsHandler(c *check.C) {
 	datastore.ReEncryptKeypair = mockReEncryptKeypair
 
@@ -120,6 +158,6 @@ func parseSyncResponse(w *httptest.ResponseRecorder) (keypair.SyncResponse, erro
 	return result, err
 }
 
-func mockReEncryptKeypair(keypair datastore.Keypair, newSecret string) (string, string, error) {
+func mockReEncryptKeypair(ctx context.Context, keypair datastore.Keypair, newSecret string) (string, string, error) {
 	return "Base64SealedKey", "Base64SAuthKey", nil
 }
@@ -21,6 +21,7 @@
 package keypair
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
@@ -30,6 +31,7 @@ import (
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
@@ -42,6 +44,14 @@ type WithPrivateKey struct {
 	KeyName     string `json:"key-name"`
 }
 
+// WithPublicKey is the JSON version of a keypair that is held on an OpenPGP card,
+// including the base64 armored public key of the signing-key on the card
+type WithPublicKey struct {
+	AuthorityID string `json:"authority-id"`
+	PublicKey   string `json:"public-key"`
+	KeyName     string `json:"key-name"`
+}
+
 // AssertionRequest is the JSON version of a account assertion
 type AssertionRequest struct {
 	ID        int    `json:"id"`
@@ -57,7 +67,7 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false)
+	listHandler(r.Context(), w, authUser, false)
 }
 
 // Create is the API method to create a keypair
@@ -77,7 +87,42 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, keypairWithKey)
+	createHandler(r.Context(), w, authUser, false, keypairWithKey)
+}
+
+// Card is the API method to add a reference to a signing-key that is held on an
+// OpenPGP card (e.g. a YubiKey). The private key never leaves the card, so the
+// assertions are signed by the card rather than by the configured keystore.
+func Card(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	keypairWithKey := WithPublicKey{}
+	err = json.NewDecoder(r.Body).Decode(&keypairWithKey)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, response.ErrorInvalidData.Code, "", response.ErrorInvalidData.Message, w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, response.ErrorDecodeJSON.Code, "", err.Error(), w)
+		return
+	}
+
+	// Validate the keypair
+	k := WithPrivateKey{AuthorityID: keypairWithKey.AuthorityID, KeyName: keypairWithKey.KeyName}
+	if ok := validateKeypair(r.Context(), w, &k, authUser); !ok {
+		return
+	}
+	keypairWithKey.AuthorityID = k.AuthorityID
+
+	cardHandler(r.Context(), w, authUser, false, keypairWithKey)
 }
 
 // Get is the API method to fetch a keypair
@@ -95,7 +140,7 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	getHandler(r.Context(), w, authUser, false, id)
 }
 
 // Update is the API method to update a keypair name
@@ -133,11 +178,13 @@ func Update(w http.ResponseWriter, r *http.Request) {
 
 	// Validate the keypair
 	k := WithPrivateKey{AuthorityID: keypair.AuthorityID, KeyName: keypair.KeyName}
-	if ok := validateKeypair(w, &k, authUser); !ok {
+	if ok := validateKeypair(r.Context(), w, &k, authUser); !ok {
 		return
 	}
+	// The update is based on the version of the keypair that was read
+	keypair.Version = request.Version(r, keypair.Version)
 
-	updateHandler(w, authUser, false, keypair)
+	updateHandler(r.Context(), w, authUser, false, keypair)
 }
 
 // Generate is the API method to generate a new keypair that can be used
@@ -156,7 +203,7 @@ func Generate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	generateHandler(w, authUser, false, keypairWithKey)
+	generateHandler(r.Context(), w, authUser, false, keypairWithKey)
 }
 
 // Disable disables an existing keypair, which will mean that any
@@ -177,7 +224,7 @@ func Disable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enableDisableHandler(w, authUser, false, false, keypairID)
+	enableDisableHandler(r.Context(), w, authUser, false, false, keypairID)
 }
 
 // Enable enables an existing keypair, which will mean that any
@@ -198,7 +245,7 @@ func Enable(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enableDisableHandler(w, authUser, false, true, keypairID)
+	enableDisableHandler(r.Context(), w, authUser, false, true, keypairID)
 }
 
 // Assertion updates the account key assertion on a keypair
@@ -224,7 +271,7 @@ func Assertion(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHandler(w, authUser, false, assertionRequest)
+	assertionHandler(r.Context(), w, authUser, false, assertionRequest)
 }
 
 // Status returns the creation status of a keypair
@@ -237,7 +284,7 @@ func Status(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	statusHandler(w, authUser, false, vars["authorityID"], vars["keyName"])
+	statusHandler(r.Context(), w, authUser, false, vars["authorityID"], vars["keyName"])
 }
 
 // Progress returns the status of keypairs that are being generated
@@ -248,7 +295,7 @@ func Progress(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	progressHandler(w, authUser, false)
+	progressHandler(r.Context(), w, authUser, false)
 }
 
 func verifyKeypair(w http.ResponseWriter, r *http.Request, authUser datastore.User) (WithPrivateKey, bool) {
@@ -271,14 +318,14 @@ func verifyKeypair(w http.ResponseWriter, r *http.Request, authUser datastore.Us
 	}
 
 	// Validate the keypair
-	if ok := validateKeypair(w, &keypairWithKey, authUser); !ok {
+	if ok := validateKeypair(r.Context(), w, &keypairWithKey, authUser); !ok {
 		return keypairWithKey, false
 	}
 
 	return keypairWithKey, true
 }
 
-func validateKeypair(w http.ResponseWriter, keypairWithKey *WithPrivateKey, authUser datastore.User) bool {
+func validateKeypair(ctx context.Context, w http.ResponseWriter, keypairWithKey *WithPrivateKey, authUser datastore.User) bool {
 	// Validate the authority-id
 	keypairWithKey.AuthorityID = strings.TrimSpace(keypairWithKey.AuthorityID)
 	if len(keypairWithKey.AuthorityID) == 0 {
@@ -287,13 +334,13 @@ func validateKeypair(w http.ResponseWriter, keypairWithKey *WithPrivateKey, auth
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(authUser.Username, keypairWithKey.AuthorityID) {
+	if !datastore.Environ.DB.CheckUserInAccount(ctx, authUser.Username, keypairWithKey.AuthorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", "Your user does not have permissions for the Signing Authority", w)
 		return false
 	}
 
 	// Check that the key-name does not already exist for the authority-id
-	if datastore.Environ.DB.CheckKeypairKeynameExists(keypairWithKey.AuthorityID, keypairWithKey.KeyName) {
+	if datastore.Environ.DB.CheckKeypairKeynameExists(ctx, keypairWithKey.AuthorityID, keypairWithKey.KeyName) {
 		response.FormatStandardResponse(false, response.ErrorInvalidKeypair.Code, "", "A key with this name already exists for this Signing Authority", w)
 		return false
 	}
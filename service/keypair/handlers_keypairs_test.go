@@ -151,8 +151,12 @@ func (s *KeypairSuite) TestCreateGenerateEnableDisableHandler(c *check.C) {
 	k = keypair.WithPrivateKey{PrivateKey: string(encodedSigningKey), AuthorityID: "system"}
 	dataBad, _ := json.Marshal(k)
 
-	kp := datastore.Keypair{ID: 1, AuthorityID: "system", KeyName: "serial-key"}
+	kp := datastore.Keypair{ID: 1, AuthorityID: "system", KeyName: "serial-key", Version: 1}
 	keypair, _ := json.Marshal(kp)
+	kp.Version = 2
+	keypairStale, _ := json.Marshal(kp)
+	kp.Version = 0
+	keypairUnversioned, _ := json.Marshal(kp)
 
 	tests := []KeypairTest{
 		{"GET", "/v1/keypairs/1", nil, 200, response.JSONHeader, 0, false, true, 0},
@@ -172,6 +176,8 @@ func (s *KeypairSuite) TestCreateGenerateEnableDisableHandler(c *check.C) {
 
 		{"PUT", "/v1/keypairs/1", keypair, 200, response.JSONHeader, 0, false, true, 0},
 		{"PUT", "/v1/keypairs/1", keypair, 200, response.JSONHeader, datastore.Admin, true, true, 0},
+		{"PUT", "/v1/keypairs/1", keypairStale, 409, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"PUT", "/v1/keypairs/1", keypairUnversioned, 428, response.JSONHeader, datastore.Admin, true, false, 0},
 		{"PUT", "/v1/keypairs/1", []byte(""), 400, response.JSONHeader, datastore.Admin, true, false, 0},
 		{"PUT", "/v1/keypairs/1", []byte("bad"), 400, response.JSONHeader, datastore.Admin, true, false, 0},
 		{"PUT", "/v1/keypairs/1", []byte("{}"), 400, response.JSONHeader, datastore.Admin, true, false, 0},
@@ -260,6 +266,52 @@ func (s *KeypairSuite) TestCreateKeyStoreError(c *check.C) {
 	}
 }
 
+func (s *KeypairSuite) TestCardHandler(c *check.C) {
+	// A private key is not a valid public key for a card
+	signingKey, err := ioutil.ReadFile("../../keystore/TestKey.asc")
+	c.Assert(err, check.IsNil)
+	k := keypair.WithPublicKey{PublicKey: base64.StdEncoding.EncodeToString(signingKey), AuthorityID: "system", KeyName: "card-key"}
+	dataBad, _ := json.Marshal(k)
+
+	k = keypair.WithPublicKey{PublicKey: base64.StdEncoding.EncodeToString(signingKey), AuthorityID: "system"}
+	dataNoName, _ := json.Marshal(k)
+
+	// The public key is imported without a card
+	restore := datastore.MockOpenPGPCard()
+	defer restore()
+	publicKey, err := ioutil.ReadFile("../../keystore/TestKey.pub.asc")
+	c.Assert(err, check.IsNil)
+	k = keypair.WithPublicKey{PublicKey: base64.StdEncoding.EncodeToString(publicKey), AuthorityID: "system", KeyName: "card-key"}
+	data, _ := json.Marshal(k)
+
+	tests := []KeypairTest{
+		{"POST", "/v1/keypairs/card", data, 200, response.JSONHeader, 0, false, true, 0},
+		{"POST", "/v1/keypairs/card", data, 200, response.JSONHeader, datastore.Admin, true, true, 0},
+		{"POST", "/v1/keypairs/card", data, 400, response.JSONHeader, datastore.Standard, true, false, 0},
+		{"POST", "/v1/keypairs/card", dataBad, 400, response.JSONHeader, 0, false, false, 0},
+		{"POST", "/v1/keypairs/card", dataBad, 400, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"POST", "/v1/keypairs/card", dataNoName, 400, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"POST", "/v1/keypairs/card", []byte(""), 400, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"POST", "/v1/keypairs/card", []byte("bad"), 400, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"POST", "/v1/keypairs/card", []byte("{}"), 400, response.JSONHeader, datastore.Admin, true, false, 0},
+		{"POST", "/v1/keypairs/card", dataBad, 400, response.JSONHeader, datastore.Standard, true, false, 0},
+		{"POST", "/v1/keypairs/card", dataBad, 400, response.JSONHeader, 0, true, false, 0},
+	}
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
 func (s *KeypairSuite) TestAssertionHandler(c *check.C) {
 	// Create the account key assertion
 	assertAcc, err := generateAccountAssertion(asserts.AccountKeyType, "alder", "maple-inc")
@@ -0,0 +1,181 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package lockout protects the logins and the API key authentications from brute-force
+// attacks, by locking out a username or a source IP after repeated failures
+package lockout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// Audited actions of the failed authentications. The audit log is written directly, as the
+// audit package depends on the authentication
+const (
+	ActionAuthFailure = "auth-failure"
+	ActionLockout     = "lockout"
+)
+
+// Audited objects of the failed authentications
+const (
+	ObjectLogin  = "login"
+	ObjectAPIKey = "apikey"
+)
+
+// ErrLockedOut is returned for the authentications of a username or source IP that is locked out
+var ErrLockedOut = errors.New("Too many failed authentication attempts. Please try again later")
+
+// Check returns an error when the source IP, or the username from the source IP, is locked out.
+// The username is empty when it is not known, e.g. for the API key of a model
+func Check(ctx context.Context, username, sourceIP string) error {
+	for _, key := range keys(username, sourceIP) {
+		f, err := datastore.Environ.DB.GetAuthFailure(ctx, key)
+		if err != nil {
+			// The authentication is not blocked when the counters cannot be read
+			log.Errorf("Error checking the lockout of %s: %v", key, err)
+			continue
+		}
+		if f.Locked() {
+			return ErrLockedOut
+		}
+	}
+	return nil
+}
+
+// Failure counts a failed authentication of the username and the source IP, locking them
+// out when there are too many. The username is only locked out from the source IP of the
+// failures, so the failures from another address cannot lock out the user. The counters
+// are recorded in the audit log
+func Failure(ctx context.Context, object, username, sourceIP string) {
+	policy, err := datastore.NewLockoutPolicy(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error in the lockout settings, using the defaults: %v", err)
+	}
+
+	action := ActionAuthFailure
+	counters := map[string]interface{}{"sourceIP": sourceIP}
+	for _, key := range keys(username, sourceIP) {
+		f, err := datastore.Environ.DB.RecordAuthFailure(ctx, key, policy)
+		if err != nil {
+			log.Errorf("Error recording the failed authentication of %s: %v", key, err)
+			continue
+		}
+		counters[key] = f.Failures
+		if f.LockedUntil != nil {
+			action = ActionLockout
+			counters["lockedUntil"] = f.LockedUntil.Format(time.RFC3339)
+		}
+	}
+
+	after, _ := json.Marshal(counters)
+	entry := datastore.AuditLog{Username: username, Action: action, Object: object, After: string(after)}
+	if err := datastore.Environ.DB.CreateAuditLog(ctx, entry); err != nil {
+		log.Errorf("Error recording the audit log of the failed authentication: %v", err)
+	}
+}
+
+// Success clears the failed authentications of the username from the source IP. The failures
+// of the source IP are kept, as a valid user must not reset the counters of the other usernames
+// tried from it
+func Success(ctx context.Context, username, sourceIP string) {
+	if len(username) == 0 {
+		return
+	}
+	if err := datastore.Environ.DB.ResetAuthFailures(ctx, userKey(username, sourceIP)); err != nil {
+		log.Errorf("Error clearing the failed authentications of %s: %v", username, err)
+	}
+}
+
+// SourceIP gets the address of the client from the HTTP request. The X-Forwarded-For header
+// is only used when the request comes from a trusted proxy, and the client is the last address
+// of the header that is not a trusted proxy, as the addresses before it can be forged
+func SourceIP(r *http.Request) string {
+	sourceIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		sourceIP = host
+	}
+
+	proxies := trustedProxies()
+	if !isTrustedProxy(sourceIP, proxies) {
+		return sourceIP
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		address := strings.TrimSpace(forwarded[i])
+		if len(address) == 0 {
+			continue
+		}
+		sourceIP = address
+		if !isTrustedProxy(address, proxies) {
+			break
+		}
+	}
+	return sourceIP
+}
+
+func trustedProxies() []*net.IPNet {
+	if datastore.Environ == nil {
+		return nil
+	}
+	proxies, err := datastore.TrustedProxies(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error in the trusted proxies, the X-Forwarded-For header is ignored: %v", err)
+	}
+	return proxies
+}
+
+func isTrustedProxy(address string, proxies []*net.IPNet) bool {
+	ip := net.ParseIP(address)
+	if ip == nil {
+		return false
+	}
+	for _, network := range proxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func keys(username, sourceIP string) []string {
+	k := []string{}
+	if len(username) > 0 {
+		k = append(k, userKey(username, sourceIP))
+	}
+	if len(sourceIP) > 0 {
+		k = append(k, "ip:"+sourceIP)
+	}
+	return k
+}
+
+// userKey is the key of the failures of the username from the source IP
+func userKey(username, sourceIP string) string {
+	return "user:" + username + "@" + sourceIP
+}
@@ -0,0 +1,131 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package lockout
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestCheck(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+
+	tests := []struct {
+		username string
+		sourceIP string
+		locked   bool
+	}{
+		{"sv", "192.0.2.1", false},
+		{"", "192.0.2.1", false},
+		{"locked", "192.0.2.1", true},
+		{"sv", "192.0.2.66", true},
+		{"", "192.0.2.66", true},
+	}
+	for _, tt := range tests {
+		err := Check(context.Background(), tt.username, tt.sourceIP)
+		if (err == ErrLockedOut) != tt.locked {
+			t.Errorf("Expected the lockout of %s from %s to be %v, got %v", tt.username, tt.sourceIP, tt.locked, err)
+		}
+	}
+}
+
+func TestKeys(t *testing.T) {
+	// The username is counted from its source IP, so another address cannot lock it out
+	if k := keys("sv", "192.0.2.1"); strings.Join(k, " ") != "user:sv@192.0.2.1 ip:192.0.2.1" {
+		t.Errorf("Expected the keys of the username from the source IP, got %v", k)
+	}
+	if k := keys("", "192.0.2.1"); strings.Join(k, " ") != "ip:192.0.2.1" {
+		t.Errorf("Expected the key of the source IP, got %v", k)
+	}
+}
+
+func TestFailure(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	Failure(context.Background(), ObjectAPIKey, "sv", "192.0.2.1")
+
+	logs := db.AuditLogs()
+	if len(logs) != 1 {
+		t.Fatalf("Expected one audit log, got %d", len(logs))
+	}
+	if logs[0].Username != "sv" || logs[0].Action != ActionAuthFailure || logs[0].Object != ObjectAPIKey {
+		t.Errorf("Unexpected audit log: %v", logs[0])
+	}
+	for _, counter := range []string{`"user:sv@192.0.2.1":1`, `"ip:192.0.2.1":1`, `"sourceIP":"192.0.2.1"`} {
+		if !strings.Contains(logs[0].After, counter) {
+			t.Errorf("Expected %s in the audit log, got %s", counter, logs[0].After)
+		}
+	}
+
+	// The counters cannot be recorded, but the failure is still audited
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	Failure(context.Background(), ObjectLogin, "", "192.0.2.1")
+	Success(context.Background(), "sv", "192.0.2.1")
+}
+
+func TestSourceIP(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{TrustedProxies: []string{"10.0.0.0/24", "2001:db8::1"}}}
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  []string
+		sourceIP   string
+	}{
+		{"10.0.0.5:41234", nil, "10.0.0.5"},
+		{"[2001:db8::1]:41234", nil, "2001:db8::1"},
+		{"10.0.0.5", nil, "10.0.0.5"},
+		{"10.0.0.5:41234", []string{"192.168.1.20, 10.0.0.1"}, "192.168.1.20"},
+		{"[2001:db8::1]:41234", []string{"192.168.1.20"}, "192.168.1.20"},
+		// The addresses before the last untrusted address can be forged by the client
+		{"10.0.0.5:41234", []string{"198.51.100.1, 192.168.1.20"}, "192.168.1.20"},
+		{"10.0.0.5:41234", []string{"198.51.100.1", "192.168.1.20, 10.0.0.2"}, "192.168.1.20"},
+		// The header is ignored when the request is not from a trusted proxy
+		{"203.0.113.7:41234", []string{"192.168.1.20"}, "203.0.113.7"},
+		{"[2001:db8::2]:41234", []string{"192.168.1.20"}, "2001:db8::2"},
+	}
+
+	for _, tt := range tests {
+		r, _ := http.NewRequest("POST", "/v1/serial", nil)
+		r.RemoteAddr = tt.remoteAddr
+		for _, f := range tt.forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+
+		if sourceIP := SourceIP(r); sourceIP != tt.sourceIP {
+			t.Errorf("Expected the source IP %s, got %s", tt.sourceIP, sourceIP)
+		}
+	}
+
+	// Without trusted proxies, the header is ignored
+	datastore.Environ.Config = config.Settings{}
+	r, _ := http.NewRequest("POST", "/v1/serial", nil)
+	r.RemoteAddr = "10.0.0.5:41234"
+	r.Header.Set("X-Forwarded-For", "192.0.2.66")
+	if sourceIP := SourceIP(r); sourceIP != "10.0.0.5" {
+		t.Errorf("Expected the source IP 10.0.0.5, got %s", sourceIP)
+	}
+}
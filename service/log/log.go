@@ -20,94 +20,344 @@
 package log
 
 import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is the severity of a log entry
+type Level int
 
-	logging "github.com/op/go-logging"
+// Log levels, in increasing severity
+const (
+	DEBUG Level = iota
+	INFO
+	WARNING
+	ERROR
+	FATAL
 )
 
-// Message logs a message in a fixed format so it can be analyzed by log handlers
+var levelNames = []string{"DEBUG", "INFO", "WARNING", "ERROR", "FATAL"}
+
+func (l Level) String() string {
+	if l < DEBUG || l > FATAL {
+		return fmt.Sprintf("LEVEL%d", int(l))
+	}
+	return levelNames[l]
+}
+
+// Output formats of the log entries
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// The standard fields of the log entries
+const (
+	FieldComponent = "component"
+	FieldRequestID = "request-id"
+	FieldAccount   = "account"
+)
+
+const defaultComponent = "serialvault"
+
+// Fields are the structured fields of a log entry
+type Fields map[string]interface{}
+
+// Logger writes the log entries with its fields. The loggers are immutable, so a logger
+// with the fields of a request can be shared by the handlers of the request
+type Logger struct {
+	fields Fields
+}
+
+var (
+	output      io.Writer = os.Stderr
+	outputLevel           = INFO
+	outputJSON  bool
+	outputLock  sync.Mutex
+)
+
+var root = &Logger{fields: Fields{}}
+
+// InitLogger initializes the logger with the minimum level of the entries that are written.
+// The text format is e.g. 2016-07-14 01:02:03Z INFO serialvault "hello" request-id=4bf92f35
+func InitLogger(level Level) {
+	SetLevel(level)
+}
+
+// SetLevel changes the minimum level of the entries that are written, e.g. at runtime from the admin API
+func SetLevel(level Level) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	outputLevel = level
+}
+
+// GetLevel returns the minimum level of the entries that are written
+func GetLevel() Level {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+	return outputLevel
+}
+
+// ParseLevel returns the log level from its name, e.g. "debug"
+func ParseLevel(name string) (Level, error) {
+	for i, n := range levelNames {
+		if strings.EqualFold(name, n) {
+			return Level(i), nil
+		}
+	}
+	return INFO, fmt.Errorf("invalid log level '%s', expected one of %s", name, strings.Join(levelNames, ", "))
+}
+
+// SetFormat sets the output format of the entries, text or JSON
+func SetFormat(format string) error {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	switch strings.ToLower(format) {
+	case "", FormatText:
+		outputJSON = false
+	case FormatJSON:
+		outputJSON = true
+	default:
+		return fmt.Errorf("invalid log format '%s', expected '%s' or '%s'", format, FormatText, FormatJSON)
+	}
+	return nil
+}
+
+// New creates a logger for a component
+func New(component string) *Logger {
+	return root.With(FieldComponent, component)
+}
+
+// With returns a logger that adds the field to the entries
+func (l *Logger) With(key string, value interface{}) *Logger {
+	return l.WithFields(Fields{key: value})
+}
+
+// WithFields returns a logger that adds the fields to the entries
+func (l *Logger) WithFields(fields Fields) *Logger {
+	f := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		f[k] = v
+	}
+	for k, v := range fields {
+		f[k] = v
+	}
+	return &Logger{fields: f}
+}
+
+type contextKey struct{}
+
+// NewContext returns a context that holds the logger, for the handlers of a request
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger of the request, or the default logger
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+		return l
+	}
+	return root
+}
+
+// NewRequestID generates a random ID to correlate the log entries of a request
+func NewRequestID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%x", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
+
+// Message logs an error in a fixed format so it can be analyzed by log handlers
 // e.g. "METHOD CODE descriptive reason"
-func Message(method, code, reason string) {
-	msg := fmt.Sprintf("%s: method=%s, code=%s", reason, method, code)
-	Error(msg)
+func (l *Logger) Message(method, code, reason string) {
+	l.WithFields(Fields{"method": method, "code": code}).log(ERROR, reason)
+}
+
+// Fatalf logs in fatal level with format, and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(FATAL, fmt.Sprintf(format, args...))
+	closeSyslog()
+	os.Exit(1)
+}
+
+// Errorf logs in error level with format
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ERROR, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs in warning level with format
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(WARNING, fmt.Sprintf(format, args...))
+}
+
+// Infof logs in info level with format
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(INFO, fmt.Sprintf(format, args...))
+}
+
+// Debugf logs in debug level with format
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DEBUG, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) log(level Level, message string) {
+	outputLock.Lock()
+	defer outputLock.Unlock()
+
+	if level < outputLevel {
+		return
+	}
+
+	now := time.Now().UTC()
+	var line []byte
+	if outputJSON {
+		line = formatJSON(now, level, message, l.fields)
+	} else {
+		line = formatText(now, level, message, l.fields)
+	}
+	output.Write(line)
+
+	if syslogOutput != nil {
+		component := defaultComponent
+		if c, ok := l.fields[FieldComponent]; ok {
+			component = fmt.Sprint(c)
+		}
+		syslogOutput.send(now, level, component, line)
+	}
 }
 
-var l = logging.MustGetLogger("serialvault")
+// formatText formats the entry as text, with the fields as key=value pairs
+func formatText(now time.Time, level Level, message string, fields Fields) []byte {
+	component := defaultComponent
+	if c, ok := fields[FieldComponent]; ok {
+		component = fmt.Sprint(c)
+	}
 
-// InitLogger initializes logger for backend with the specified level
-// format = '%(asctime)s.%(msecs)03dZ %(levelname)s %(name)s "%(message)s"'
-// datefmt = "%Y-%m-%d %H:%M:%S"
-// 2016-07-14 01:02:03.456Z INFO app "hello"
-func InitLogger(level logging.Level) {
-	backend := logging.NewLogBackend(os.Stderr, "", 0)
+	var b strings.Builder
+	fmt.Fprintf(&b, "%s %s %s %q", now.Format("2006-01-02 15:04:05Z"), level, component, strings.TrimSpace(message))
+	for _, k := range sortedKeys(fields) {
+		if k == FieldComponent {
+			continue
+		}
+		v := fmt.Sprint(fields[k])
+		if strings.ContainsAny(v, " \t\n\"=") {
+			v = fmt.Sprintf("%q", v)
+		}
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+	b.WriteString("\n")
+	return []byte(b.String())
+}
+
+// formatJSON formats the entry as a JSON object on a line
+func formatJSON(now time.Time, level Level, message string, fields Fields) []byte {
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["message"] = strings.TrimSpace(message)
+	if _, ok := entry[FieldComponent]; !ok {
+		entry[FieldComponent] = defaultComponent
+	}
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		line, _ = json.Marshal(map[string]interface{}{"time": entry["time"], "level": entry["level"], "message": entry["message"],
+			"error": fmt.Sprintf("error formatting the log fields: %v", err)})
+	}
+	return append(line, '\n')
+}
 
-	format := logging.MustStringFormatter(
-		`%{color}%{time:2006-01-02 15:04:05Z} %{level} %{module}%{color:reset} %{message:q}`,
-	)
-	backendFormatter := logging.NewBackendFormatter(backend, format)
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
 
-	backendLeveled := logging.AddModuleLevel(backendFormatter)
-	backendLeveled.SetLevel(level, "")
-	logging.SetBackend(backendLeveled)
+// Message logs a message in a fixed format so it can be analyzed by log handlers
+// e.g. "METHOD CODE descriptive reason"
+func Message(method, code, reason string) {
+	root.Message(method, code, reason)
 }
 
 // Fatalf calls logger in fatal level with format
 func Fatalf(format string, args ...interface{}) {
-	l.Fatalf(format, args...)
+	root.Fatalf(format, args...)
 }
 
 // Fatal calls logger in fatal level
 func Fatal(args ...interface{}) {
-	l.Fatal(args...)
+	root.log(FATAL, fmt.Sprint(args...))
+	closeSyslog()
+	os.Exit(1)
 }
 
 // Errorf calls logger in eror level with format
 func Errorf(format string, args ...interface{}) {
-	l.Errorf(format, args...)
+	root.Errorf(format, args...)
 }
 
 // Error calls logger in error level
 func Error(args ...interface{}) {
-	l.Error(args...)
+	root.log(ERROR, fmt.Sprint(args...))
 }
 
 // Warningf calls logger in warning level with format
 func Warningf(format string, args ...interface{}) {
-	l.Warningf(format, args...)
+	root.Warningf(format, args...)
 }
 
 // Warning calls logger in warning level
 func Warning(args ...interface{}) {
-	l.Warning(args...)
+	root.log(WARNING, fmt.Sprint(args...))
 }
 
 // Infof calls logger in info level with format
 func Infof(format string, args ...interface{}) {
-	l.Infof(format, args...)
+	root.Infof(format, args...)
 }
 
 // Info calls logger in info level
 func Info(args ...interface{}) {
-	l.Info(args...)
+	root.log(INFO, fmt.Sprint(args...))
 }
 
 // Debugf calls logger in debug level with format
 func Debugf(format string, args ...interface{}) {
-	l.Debugf(format, args...)
+	root.Debugf(format, args...)
 }
 
 // Debug calls logger in debug level
 func Debug(args ...interface{}) {
-	l.Debug(args...)
+	root.log(DEBUG, fmt.Sprint(args...))
 }
 
-// Printf calls logger in info level with format
+// Printf calls logger in info level with format, like the Printf of the standard logger
 func Printf(format string, args ...interface{}) {
-	Errorf(format, args...)
+	Infof(format, args...)
 }
 
-// Println calls logger in info level
+// Println calls logger in info level, like the Println of the standard logger
 func Println(args ...interface{}) {
-	Error(args...)
+	root.log(INFO, strings.TrimSuffix(fmt.Sprintln(args...), "\n"))
 }
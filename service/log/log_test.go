@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"os"
+	"regexp"
+	"testing"
+)
+
+func captureOutput(t *testing.T) *bytes.Buffer {
+	buf := &bytes.Buffer{}
+	output = buf
+	t.Cleanup(func() {
+		output = os.Stderr
+		InitLogger(INFO)
+		SetFormat(FormatText)
+	})
+	return buf
+}
+
+func TestTextFormat(t *testing.T) {
+	buf := captureOutput(t)
+
+	New("sign").WithFields(Fields{FieldRequestID: "abc123", FieldAccount: "System", "reason": "not found"}).Errorf("Error signing %s", "A1")
+
+	want := `^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}Z ERROR sign "Error signing A1" account=System reason="not found" request-id=abc123\n$`
+	if !regexp.MustCompile(want).MatchString(buf.String()) {
+		t.Errorf("text entry = %q", buf.String())
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	buf := captureOutput(t)
+	if err := SetFormat("JSON"); err != nil {
+		t.Fatalf("SetFormat() error = %v", err)
+	}
+
+	Message("SIGN", "invalid-nonce", "Invalid nonce")
+
+	entry := map[string]interface{}{}
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("JSON entry %q: %v", buf.String(), err)
+	}
+	for k, v := range map[string]string{"level": "ERROR", "component": "serialvault", "message": "Invalid nonce", "method": "SIGN", "code": "invalid-nonce"} {
+		if entry[k] != v {
+			t.Errorf("JSON entry %s = %v, want %v", k, entry[k], v)
+		}
+	}
+	if _, ok := entry["time"]; !ok {
+		t.Errorf("JSON entry without time: %v", entry)
+	}
+}
+
+func TestSetFormat(t *testing.T) {
+	captureOutput(t)
+
+	for _, format := range []string{"", "text", "json"} {
+		if err := SetFormat(format); err != nil {
+			t.Errorf("SetFormat(%s) error = %v", format, err)
+		}
+	}
+	if err := SetFormat("xml"); err == nil {
+		t.Error("SetFormat(xml) expected an error")
+	}
+}
+
+func TestLevel(t *testing.T) {
+	buf := captureOutput(t)
+
+	InitLogger(WARNING)
+	Infof("hidden")
+	Debug("hidden")
+	Printf("hidden")
+	Println("hidden")
+	Warningf("shown")
+	Errorf("also %s", "shown")
+
+	if got := buf.String(); regexp.MustCompile("hidden").MatchString(got) || len(regexp.MustCompile("shown").FindAllString(got, -1)) != 2 {
+		t.Errorf("level filtered entries = %q", got)
+	}
+}
+
+func TestContext(t *testing.T) {
+	buf := captureOutput(t)
+
+	if FromContext(context.Background()) != root {
+		t.Error("FromContext() without a logger must return the default logger")
+	}
+
+	l := New("http").With(FieldRequestID, "abc123")
+	ctx := NewContext(context.Background(), l)
+	FromContext(ctx).With(FieldAccount, "System").Infof("request")
+	FromContext(ctx).Infof("request")
+
+	// The fields of a derived logger do not change the logger of the request
+	want := `(?m)^.* INFO http "request" account=System request-id=abc123\n.* INFO http "request" request-id=abc123\n$`
+	if !regexp.MustCompile(want).MatchString(buf.String()) {
+		t.Errorf("context entries = %q", buf.String())
+	}
+
+	if a, b := NewRequestID(), NewRequestID(); len(a) != 16 || a == b {
+		t.Errorf("NewRequestID() = %v, %v", a, b)
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for name, want := range map[string]Level{"debug": DEBUG, "INFO": INFO, "Warning": WARNING, "error": ERROR, "fatal": FATAL} {
+		got, err := ParseLevel(name)
+		if err != nil || got != want {
+			t.Errorf("ParseLevel(%s) = %v, %v, want %v", name, got, err, want)
+		}
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("ParseLevel(verbose) expected an error")
+	}
+}
+
+func TestSetLevel(t *testing.T) {
+	buf := captureOutput(t)
+
+	Debugf("hidden")
+	SetLevel(DEBUG)
+	if GetLevel() != DEBUG {
+		t.Errorf("GetLevel() = %v, want DEBUG", GetLevel())
+	}
+	Debugf("shown")
+
+	if !bytes.Contains(buf.Bytes(), []byte(`"shown"`)) || bytes.Contains(buf.Bytes(), []byte(`"hidden"`)) {
+		t.Errorf("output = %q", buf.String())
+	}
+}
@@ -0,0 +1,169 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maskVisible is the number of trailing characters that are not masked
+const maskVisible = 4
+
+// Policy controls the masking of the serial numbers and key IDs in the logs, and the routes
+// that are logged with more detail. The policy is adjustable at runtime
+type Policy struct {
+	MaskSerials bool         `json:"maskSerials"`
+	MaskKeyIDs  bool         `json:"maskKeyIDs"`
+	Routes      []RouteDebug `json:"routes"`
+}
+
+// RouteDebug raises the logging verbosity of the routes with the path prefix, until it expires
+type RouteDebug struct {
+	Prefix string    `json:"prefix"`
+	Until  time.Time `json:"until"`
+}
+
+// The request parameters that hold serial numbers and key IDs
+var (
+	serialParams = []string{"serial", "serialnumber", "serial_number"}
+	keyIDParams  = []string{"key-id", "keyid", "key_id"}
+)
+
+var (
+	policy     Policy
+	policyLock sync.RWMutex
+)
+
+// SetPolicy replaces the logging policy
+func SetPolicy(p Policy) {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+	policy = p
+}
+
+// GetPolicy returns the logging policy, without the expired routes
+func GetPolicy() Policy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return policy.Active(time.Now())
+}
+
+// Active returns the policy without the routes that have expired
+func (p Policy) Active(now time.Time) Policy {
+	active := Policy{MaskSerials: p.MaskSerials, MaskKeyIDs: p.MaskKeyIDs, Routes: []RouteDebug{}}
+	for _, r := range p.Routes {
+		if r.Until.After(now) {
+			active.Routes = append(active.Routes, r)
+		}
+	}
+	return active
+}
+
+// Verbose checks if the requests to the path are logged with more detail
+func Verbose(path string) bool {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	now := time.Now()
+	for _, r := range policy.Routes {
+		if strings.HasPrefix(path, r.Prefix) && r.Until.After(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// MaskSerial masks a serial number for the logs, when the policy requires it
+func MaskSerial(serial string) string {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	if !policy.MaskSerials {
+		return serial
+	}
+	return mask(serial)
+}
+
+// MaskKeyID masks a key ID for the logs, when the policy requires it
+func MaskKeyID(keyID string) string {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	if !policy.MaskKeyIDs {
+		return keyID
+	}
+	return mask(keyID)
+}
+
+// MaskPath masks the serial number in the path of a request, from the route variables
+func MaskPath(path string, vars map[string]string) string {
+	for _, name := range serialParams {
+		if v := vars[name]; len(v) > 0 {
+			path = strings.Replace(path, v, MaskSerial(v), -1)
+		}
+	}
+	return path
+}
+
+// MaskQuery masks the serial numbers and key IDs in the query parameters of a request
+func MaskQuery(query url.Values) string {
+	masked := url.Values{}
+	for k, values := range query {
+		for _, v := range values {
+			switch {
+			case contains(serialParams, strings.ToLower(k)):
+				v = MaskSerial(v)
+			case contains(keyIDParams, strings.ToLower(k)):
+				v = MaskKeyID(v)
+			}
+			masked.Add(k, v)
+		}
+	}
+	return masked.Encode()
+}
+
+// Request logs the details of a request to a route with raised verbosity
+func Request(r *http.Request, vars map[string]string, status, size int, duration time.Duration) {
+	FromContext(r.Context()).WithFields(Fields{
+		"status": status, "bytes": size, "remote": r.RemoteAddr, "user-agent": r.UserAgent(), "query": MaskQuery(r.URL.Query()),
+	}).Infof("%s %s %s", r.Method, MaskPath(r.URL.Path, vars), duration)
+}
+
+// mask replaces all but the last characters of the value
+func mask(value string) string {
+	if len(value) <= maskVisible {
+		return strings.Repeat("*", len(value))
+	}
+	return strings.Repeat("*", len(value)-maskVisible) + value[len(value)-maskVisible:]
+}
+
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
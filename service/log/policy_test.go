@@ -0,0 +1,92 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMask(t *testing.T) {
+	defer SetPolicy(Policy{})
+
+	SetPolicy(Policy{})
+	if got := MaskSerial("R5000123"); got != "R5000123" {
+		t.Errorf("MaskSerial() without masking = %v", got)
+	}
+
+	SetPolicy(Policy{MaskSerials: true, MaskKeyIDs: true})
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"R5000123", "****0123"},
+		{"A1", "**"},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		if got := MaskSerial(tt.value); got != tt.want {
+			t.Errorf("MaskSerial(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+	if got := MaskKeyID("UytTqTvREVhx0tSfYC6K"); got != "****************YC6K" {
+		t.Errorf("MaskKeyID() = %v", got)
+	}
+
+	if got := MaskPath("/api/accounts/stores/1/R5000123", map[string]string{"serial": "R5000123"}); got != "/api/accounts/stores/1/****0123" {
+		t.Errorf("MaskPath() = %v", got)
+	}
+
+	query := url.Values{"serial": {"R5000123"}, "key-id": {"abcdefgh"}, "model": {"alder"}}
+	if got := MaskQuery(query); got != "key-id=%2A%2A%2A%2Aefgh&model=alder&serial=%2A%2A%2A%2A0123" {
+		t.Errorf("MaskQuery() = %v", got)
+	}
+}
+
+func TestVerbose(t *testing.T) {
+	defer SetPolicy(Policy{})
+
+	now := time.Now()
+	SetPolicy(Policy{Routes: []RouteDebug{
+		{Prefix: "/v1/serial", Until: now.Add(time.Hour)},
+		{Prefix: "/v1/models", Until: now.Add(-time.Hour)},
+	}})
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"/v1/serial", true},
+		{"/v1/serial/other", true},
+		{"/v1/models", false},
+		{"/v1/accounts", false},
+	}
+	for _, tt := range tests {
+		if got := Verbose(tt.path); got != tt.want {
+			t.Errorf("Verbose(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	if p := GetPolicy(); len(p.Routes) != 1 || p.Routes[0].Prefix != "/v1/serial" {
+		t.Errorf("GetPolicy() routes = %v", p.Routes)
+	}
+}
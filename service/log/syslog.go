@@ -0,0 +1,269 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// The transports of the syslog endpoint: UDP, TCP, or TCP with TLS (RFC 5425)
+const (
+	SyslogUDP = "udp"
+	SyslogTCP = "tcp"
+	SyslogTLS = "tls"
+)
+
+// defaultSyslogFacility is the facility of the entries, when it is not configured
+const defaultSyslogFacility = "daemon"
+
+// defaultSyslogAppName is the application name of the entries, when it is not configured
+const defaultSyslogAppName = "serial-vault"
+
+// syslogQueueSize is the number of entries that wait to be sent, after which the entries are dropped
+const syslogQueueSize = 1000
+
+// syslogTimeout is the longest time to connect to the syslog endpoint, or to send an entry
+const syslogTimeout = 5 * time.Second
+
+// syslogRetry is the wait before connecting again to the syslog endpoint, after a failed connection
+const syslogRetry = 10 * time.Second
+
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5, "lpr": 6, "news": 7,
+	"uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19, "local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// syslogSeverities are the severities of the log levels
+var syslogSeverities = map[Level]int{DEBUG: 7, INFO: 6, WARNING: 4, ERROR: 3, FATAL: 2}
+
+var syslogOutput *Syslog
+
+// SyslogConfig is the syslog endpoint of the log entries
+type SyslogConfig struct {
+	URL       string // the transport, host and port, e.g. udp://syslog.example.com:514
+	Facility  string // e.g. local0, daemon when it is empty
+	AppName   string
+	TLSConfig *tls.Config // of the tls transport, the system CAs when it is nil
+}
+
+// Syslog sends the log entries to a syslog endpoint in the RFC 5424 format. The entries are sent
+// in the background, so the logging does not wait for the endpoint, and they are dropped when the
+// endpoint is not reachable or too many entries are waiting
+type Syslog struct {
+	network   string
+	address   string
+	tlsConfig *tls.Config
+	facility  int
+	appName   string
+	hostname  string
+	pid       string
+
+	queue   chan []byte
+	done    chan struct{}
+	dropped int64
+
+	conn       net.Conn
+	lastFailed time.Time
+}
+
+// NewSyslog checks the syslog endpoint, and starts the sending of the entries to it
+func NewSyslog(cfg SyslogConfig) (*Syslog, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid syslog URL: %v", err)
+	}
+	switch u.Scheme {
+	case SyslogUDP, SyslogTCP, SyslogTLS:
+	default:
+		return nil, fmt.Errorf("the syslog URL must be udp://, tcp:// or tls://, not '%s'", u.Scheme)
+	}
+	if _, _, err := net.SplitHostPort(u.Host); err != nil {
+		return nil, fmt.Errorf("the syslog URL must have the host and port: %v", err)
+	}
+
+	facility := cfg.Facility
+	if len(facility) == 0 {
+		facility = defaultSyslogFacility
+	}
+	code, ok := syslogFacilities[strings.ToLower(facility)]
+	if !ok {
+		return nil, fmt.Errorf("invalid syslog facility '%s'", facility)
+	}
+
+	s := &Syslog{
+		network:   u.Scheme,
+		address:   u.Host,
+		tlsConfig: cfg.TLSConfig,
+		facility:  code,
+		appName:   syslogName(cfg.AppName, defaultSyslogAppName, 48),
+		hostname:  "-",
+		pid:       strconv.Itoa(os.Getpid()),
+		queue:     make(chan []byte, syslogQueueSize),
+		done:      make(chan struct{}),
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		s.hostname = syslogName(hostname, "-", 255)
+	}
+	if s.tlsConfig == nil && s.network == SyslogTLS {
+		s.tlsConfig = &tls.Config{}
+	}
+
+	go s.run()
+	return s, nil
+}
+
+// SetSyslog sends the log entries to the syslog endpoint as well as the standard error, or stops
+// sending them when it is nil. The previous endpoint is closed
+func SetSyslog(s *Syslog) {
+	outputLock.Lock()
+	previous := syslogOutput
+	syslogOutput = s
+	outputLock.Unlock()
+
+	if previous != nil && previous != s {
+		previous.Close(syslogTimeout)
+	}
+}
+
+// closeSyslog stops sending the log entries to the syslog endpoint, after the waiting entries
+// are sent, e.g. before the service exits
+func closeSyslog() {
+	SetSyslog(nil)
+}
+
+// Dropped returns the number of entries that could not be sent
+func (s *Syslog) Dropped() int64 {
+	return atomic.LoadInt64(&s.dropped)
+}
+
+// Close stops the sending of the entries, after the waiting entries are sent or the timeout
+func (s *Syslog) Close(timeout time.Duration) {
+	close(s.queue)
+	select {
+	case <-s.done:
+	case <-time.After(timeout):
+	}
+}
+
+// send formats the entry and queues it, without waiting for the endpoint
+func (s *Syslog) send(now time.Time, level Level, component string, line []byte) {
+	message := s.format(now, level, component, strings.TrimRight(string(line), "\n"))
+	select {
+	case s.queue <- message:
+	default:
+		atomic.AddInt64(&s.dropped, 1)
+	}
+}
+
+// format formats the entry as an RFC 5424 message, with the log entry as the message
+func (s *Syslog) format(now time.Time, level Level, component, entry string) []byte {
+	severity, ok := syslogSeverities[level]
+	if !ok {
+		severity = syslogSeverities[INFO]
+	}
+
+	return []byte(fmt.Sprintf("<%d>1 %s %s %s %s %s - %s", s.facility*8+severity, now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		s.hostname, s.appName, s.pid, syslogName(component, "-", 32), entry))
+}
+
+// run sends the queued entries until the syslog is closed
+func (s *Syslog) run() {
+	defer close(s.done)
+	for message := range s.queue {
+		if err := s.write(message); err != nil {
+			atomic.AddInt64(&s.dropped, 1)
+		}
+	}
+	if s.conn != nil {
+		s.conn.Close()
+	}
+}
+
+// write sends an entry, connecting when there is no connection. The connection is closed after an
+// error, so the next entry connects again. The entries of TCP and TLS are framed with their length
+func (s *Syslog) write(message []byte) error {
+	if s.conn == nil {
+		if err := s.connect(); err != nil {
+			return err
+		}
+	}
+
+	if s.network != SyslogUDP {
+		message = append([]byte(strconv.Itoa(len(message))+" "), message...)
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(syslogTimeout))
+	if _, err := s.conn.Write(message); err != nil {
+		s.conn.Close()
+		s.conn = nil
+		return err
+	}
+	return nil
+}
+
+// connect opens the connection to the endpoint, unless the last connection failed recently
+func (s *Syslog) connect() error {
+	if time.Since(s.lastFailed) < syslogRetry {
+		return fmt.Errorf("the syslog endpoint is not reachable")
+	}
+
+	var conn net.Conn
+	var err error
+	dialer := &net.Dialer{Timeout: syslogTimeout}
+	switch s.network {
+	case SyslogTLS:
+		conn, err = tls.DialWithDialer(dialer, "tcp", s.address, s.tlsConfig)
+	default:
+		conn, err = dialer.Dial(s.network, s.address)
+	}
+	if err != nil {
+		s.lastFailed = time.Now()
+		return err
+	}
+	s.conn = conn
+	return nil
+}
+
+// syslogName converts a header of the message to printable ASCII without spaces, as RFC 5424 requires
+func syslogName(name, empty string, max int) string {
+	name = strings.Map(func(r rune) rune {
+		if r < 33 || r > 126 {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) == 0 {
+		return empty
+	}
+	if len(name) > max {
+		name = name[:max]
+	}
+	return name
+}
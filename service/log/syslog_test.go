@@ -0,0 +1,152 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package log
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNewSyslogInvalid(t *testing.T) {
+	tests := []SyslogConfig{
+		{URL: "syslog.example.com:514"},
+		{URL: "http://syslog.example.com:514"},
+		{URL: "udp://syslog.example.com"},
+		{URL: "udp://syslog.example.com:514", Facility: "invalid"},
+	}
+	for _, cfg := range tests {
+		if _, err := NewSyslog(cfg); err == nil {
+			t.Errorf("NewSyslog(%v) expected an error", cfg)
+		}
+	}
+}
+
+func TestSyslogUDP(t *testing.T) {
+	captureOutput(t)
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("ListenPacket() error = %v", err)
+	}
+	defer conn.Close()
+
+	s, err := NewSyslog(SyslogConfig{URL: "udp://" + conn.LocalAddr().String(), Facility: "local0", AppName: "serial vault"})
+	if err != nil {
+		t.Fatalf("NewSyslog() error = %v", err)
+	}
+	SetSyslog(s)
+	defer SetSyslog(nil)
+
+	New("sign").With(FieldRequestID, "abc123").Warningf("Quota of %s", "System")
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+
+	// local0 (16) and warning (4) is the priority 132
+	want := `^<132>1 \d{4}-\d{2}-\d{2}T\d{2}:\d{2}:\d{2}\.\d{6}Z \S+ serial_vault \d+ sign - \d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}Z WARNING sign "Quota of System" request-id=abc123$`
+	if !regexp.MustCompile(want).Match(buf[:n]) {
+		t.Errorf("syslog message = %q", buf[:n])
+	}
+}
+
+func TestSyslogTCP(t *testing.T) {
+	captureOutput(t)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	defer listener.Close()
+
+	messages := make(chan string, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		r := bufio.NewReader(conn)
+		for {
+			length, err := r.ReadString(' ')
+			if err != nil {
+				return
+			}
+			n, _ := strconv.Atoi(strings.TrimSpace(length))
+			message := make([]byte, n)
+			if _, err := io.ReadFull(r, message); err != nil {
+				return
+			}
+			messages <- string(message)
+		}
+	}()
+
+	s, err := NewSyslog(SyslogConfig{URL: "tcp://" + listener.Addr().String()})
+	if err != nil {
+		t.Fatalf("NewSyslog() error = %v", err)
+	}
+	SetSyslog(s)
+	defer SetSyslog(nil)
+
+	// The entries below the level are not sent
+	Debugf("Not sent")
+	Errorf("First")
+	Infof("Second")
+
+	for _, want := range []string{`<27>1 `, `<30>1 `} {
+		select {
+		case m := <-messages:
+			if !strings.HasPrefix(m, want) || !strings.Contains(m, " serial-vault ") {
+				t.Errorf("syslog message = %q, want the prefix %q", m, want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("expected the syslog message %q", want)
+		}
+	}
+}
+
+func TestSyslogUnreachable(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Listen() error = %v", err)
+	}
+	address := listener.Addr().String()
+	listener.Close()
+
+	s, err := NewSyslog(SyslogConfig{URL: "tcp://" + address})
+	if err != nil {
+		t.Fatalf("NewSyslog() error = %v", err)
+	}
+
+	// The entries are dropped, without waiting for the endpoint
+	s.send(time.Now(), ERROR, "sign", []byte("entry\n"))
+	s.send(time.Now(), ERROR, "sign", []byte("entry\n"))
+	s.Close(5 * time.Second)
+	if s.Dropped() != 2 {
+		t.Errorf("Dropped() = %d, want 2", s.Dropped())
+	}
+}
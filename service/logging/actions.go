@@ -0,0 +1,202 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/logpolicy"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// maxRouteDuration is the longest time the verbosity of a route can be raised for
+const maxRouteDuration = 24 * time.Hour
+
+// PolicyRequest is the JSON request to change the logging policy
+type PolicyRequest struct {
+	MaskSerials bool           `json:"maskSerials"`
+	MaskKeyIDs  bool           `json:"maskKeyIDs"`
+	Routes      []RouteRequest `json:"routes"`
+}
+
+// RouteRequest raises the logging verbosity of the routes with the path prefix, for the duration e.g. "30m"
+type RouteRequest struct {
+	Prefix   string `json:"prefix"`
+	Duration string `json:"duration"`
+}
+
+// PolicyResponse is the JSON response from the API logging policy method
+type PolicyResponse struct {
+	Success      bool       `json:"success"`
+	ErrorCode    string     `json:"error_code"`
+	ErrorSubcode string     `json:"error_subcode"`
+	ErrorMessage string     `json:"message"`
+	Policy       log.Policy `json:"policy"`
+}
+
+// LevelRequest is the JSON request to change the logging level, e.g. "debug"
+type LevelRequest struct {
+	Level string `json:"level"`
+}
+
+// LevelResponse is the JSON response from the API logging level methods
+type LevelResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	Level        string `json:"level"`
+}
+
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	policy, err := logpolicy.Load(ctx, datastore.Environ.DB, datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error fetching the logging policy: %v", err)
+		response.FormatStandardResponse(false, "error-log-policy", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPolicyResponse(policy.Active(time.Now()), w)
+}
+
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, request PolicyRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	policy, err := request.toPolicy(time.Now())
+	if err != nil {
+		response.FormatStandardResponse(false, "error-log-policy", "", err.Error(), w)
+		return
+	}
+
+	if err = logpolicy.Store(ctx, datastore.Environ.DB, policy); err != nil {
+		log.Errorf("Error updating the logging policy: %v", err)
+		response.FormatStandardResponse(false, "error-log-policy", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatPolicyResponse(policy, w)
+}
+
+// toPolicy validates the request and converts the durations of the routes to their expiry
+func (request PolicyRequest) toPolicy(now time.Time) (log.Policy, error) {
+	policy := log.Policy{MaskSerials: request.MaskSerials, MaskKeyIDs: request.MaskKeyIDs, Routes: []log.RouteDebug{}}
+
+	for _, r := range request.Routes {
+		if !strings.HasPrefix(r.Prefix, "/") {
+			return policy, fmt.Errorf("the route prefix must start with '/': %s", r.Prefix)
+		}
+		duration, err := time.ParseDuration(r.Duration)
+		if err != nil {
+			return policy, fmt.Errorf("invalid duration for the route %s: %v", r.Prefix, err)
+		}
+		if duration <= 0 || duration > maxRouteDuration {
+			return policy, fmt.Errorf("the duration for the route %s must be positive and up to %s", r.Prefix, maxRouteDuration)
+		}
+		policy.Routes = append(policy.Routes, log.RouteDebug{Prefix: r.Prefix, Until: now.Add(duration).UTC()})
+	}
+	return policy, nil
+}
+
+func formatPolicyResponse(policy log.Policy, w http.ResponseWriter) error {
+	response := PolicyResponse{Success: true, Policy: policy}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the logging policy response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
+
+func getLevelHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatLevelResponse(log.GetLevel(), w)
+}
+
+func updateLevelHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, request LevelRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	level, err := log.ParseLevel(request.Level)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-log-level", "", err.Error(), w)
+		return
+	}
+
+	// The level is stored, so the signing services pick it up at their next refresh
+	if err = logpolicy.StoreLevel(ctx, datastore.Environ.DB, level); err != nil {
+		log.Errorf("Error updating the logging level: %v", err)
+		response.FormatStandardResponse(false, "error-log-level", "", err.Error(), w)
+		return
+	}
+	log.Infof("Logging level changed to %s by %s", level, user.Username)
+
+	w.WriteHeader(http.StatusOK)
+	formatLevelResponse(level, w)
+}
+
+func formatLevelResponse(level log.Level, w http.ResponseWriter) error {
+	response := LevelResponse{Success: true, Level: level.String()}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the logging level response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package logging_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/logging"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestLoggingSuite(t *testing.T) { check.TestingT(t) }
+
+type LoggingSuite struct{}
+
+var _ = check.Suite(&LoggingSuite{})
+
+func (s *LoggingSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *LoggingSuite) TearDownTest(c *check.C) {
+	log.SetPolicy(log.Policy{})
+	log.SetLevel(log.INFO)
+}
+
+func (s *LoggingSuite) TestLoggingHandler(c *check.C) {
+	valid, _ := json.Marshal(logging.PolicyRequest{MaskSerials: true, Routes: []logging.RouteRequest{{Prefix: "/v1/serial", Duration: "30m"}}})
+	noPrefix, _ := json.Marshal(logging.PolicyRequest{Routes: []logging.RouteRequest{{Prefix: "v1/serial", Duration: "30m"}}})
+	tooLong, _ := json.Marshal(logging.PolicyRequest{Routes: []logging.RouteRequest{{Prefix: "/v1/serial", Duration: "48h"}}})
+
+	tests := []struct {
+		method      string
+		data        []byte
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		routes      int
+	}{
+		{"GET", nil, 400, 0, false, false, 0},
+		{"GET", nil, 200, datastore.Superuser, true, true, 0},
+		{"PUT", valid, 200, datastore.Superuser, true, true, 1},
+		{"GET", nil, 200, datastore.Superuser, true, true, 1},
+		{"GET", nil, 400, datastore.Admin, true, false, 0},
+		{"PUT", valid, 400, datastore.Admin, true, false, 0},
+		{"PUT", noPrefix, 400, datastore.Superuser, true, false, 0},
+		{"PUT", tooLong, 400, datastore.Superuser, true, false, 0},
+		{"PUT", []byte("invalid"), 400, datastore.Superuser, true, false, 0},
+		{"PUT", nil, 400, datastore.Superuser, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.method, "/v1/logging", bytes.NewReader(t.data), t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := logging.PolicyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(len(result.Policy.Routes), check.Equals, t.routes)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+
+	// The policy applies to this service immediately
+	c.Assert(log.Verbose("/v1/serial"), check.Equals, true)
+	c.Assert(log.MaskSerial("R5000123"), check.Equals, "****0123")
+}
+
+func (s *LoggingSuite) TestLoggingHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = true
+
+	w := sendAdminRequest("GET", "/v1/logging", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+
+	result := logging.PolicyResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-log-policy")
+}
+
+func (s *LoggingSuite) TestLoggingLevelHandler(c *check.C) {
+	tests := []struct {
+		method      string
+		data        []byte
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		level       string
+	}{
+		{"GET", nil, 400, 0, false, false, ""},
+		{"GET", nil, 200, datastore.Superuser, true, true, "INFO"},
+		{"PUT", []byte(`{"level":"debug"}`), 200, datastore.Superuser, true, true, "DEBUG"},
+		{"GET", nil, 200, datastore.Superuser, true, true, "DEBUG"},
+		{"GET", nil, 400, datastore.Admin, true, false, ""},
+		{"PUT", []byte(`{"level":"error"}`), 400, datastore.Admin, true, false, ""},
+		{"PUT", []byte(`{"level":"verbose"}`), 400, datastore.Superuser, true, false, ""},
+		{"PUT", []byte("invalid"), 400, datastore.Superuser, true, false, ""},
+		{"PUT", nil, 400, datastore.Superuser, true, false, ""},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.method, "/v1/admin/loglevel", bytes.NewReader(t.data), t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := logging.LevelResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(result.Level, check.Equals, t.level)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+
+	// The level applies to this service immediately
+	c.Assert(log.GetLevel(), check.Equals, log.DEBUG)
+}
+
+func (s *LoggingSuite) TestLoggingLevelHandlerError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = true
+
+	w := sendAdminRequest("PUT", "/v1/admin/loglevel", bytes.NewReader([]byte(`{"level":"debug"}`)), datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+
+	result := logging.LevelResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-log-level")
+}
+
+func sendAdminRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
@@ -1,6 +1,7 @@
 package metric_test
 
 import (
+	"database/sql"
 	"net/http/httptest"
 	"strings"
 	"testing"
@@ -19,3 +20,15 @@ func TestMetricHandler(t *testing.T) {
 		t.Errorf("expected Content-Type: 'text/plain', got %s", w.Header().Get("Content-Type"))
 	}
 }
+
+func TestMetricHandlerDatabaseStats(t *testing.T) {
+	metric.DatabaseStats = func() sql.DBStats { return sql.DBStats{MaxOpenConnections: 50, OpenConnections: 3} }
+	defer func() { metric.DatabaseStats = nil }()
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/", nil)
+	metric.NewServer().ServeHTTP(w, r)
+	if !strings.Contains(w.Body.String(), "db_open_connections 3") {
+		t.Errorf("expected the database pool metrics, got %s", w.Body.String())
+	}
+}
@@ -1,6 +1,8 @@
 package metric
 
 import (
+	"database/sql"
+
 	"github.com/prometheus/client_golang/prometheus"
 )
 
@@ -41,10 +43,138 @@ var HTTPIncomingTimeoutsCounterVec = prometheus.NewCounterVec(
 	[]string{"method", "view"},
 )
 
+// KeystoreCanaryGauge is metric for the last check of the keystore canary: 1 when the keystore
+// secret decrypts the canary, 0 when it does not
+var KeystoreCanaryGauge = prometheus.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "keystore_canary_ok",
+		Help: "metric for the keystore secret decrypting the keystore canary",
+	},
+)
+
+// APIKeySourceDeniedCounterVec is metric for the uses of an API key from a source IP outside of
+// its allowed networks, for the alerts
+var APIKeySourceDeniedCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "apikey_source_denied",
+		Help: "metric for the API keys used from a source IP that is not allowed",
+	},
+	[]string{"authority", "apikey"},
+)
+
+// ModelSigningRefusedCounterVec is metric for the signings of a model that are refused by its
+// signing hours or its allowed networks, for the alerts
+var ModelSigningRefusedCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "model_signing_refused",
+		Help: "metric for the signings refused by the restrictions of the model",
+	},
+	[]string{"brand", "model", "reason"},
+)
+
+// DatabaseQueryLatencyHistogramVec is metric for the latency of the database queries, by the
+// statement and the table of the query
+var DatabaseQueryLatencyHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "db_query_latency",
+		Help:    "metric for the database queries latency",
+		Buckets: []float64{1, 2, 4, 8, 16, 32, 64, 128, 256, 512, 1024, 2048, 4096},
+	},
+	[]string{"query"},
+)
+
+// DatabaseQueryRowsHistogramVec is metric for the rows returned or changed by the database queries
+var DatabaseQueryRowsHistogramVec = prometheus.NewHistogramVec(
+	prometheus.HistogramOpts{
+		Name:    "db_query_rows",
+		Help:    "metric for the rows returned or changed by the database queries",
+		Buckets: []float64{0, 1, 10, 100, 1000, 10000, 100000},
+	},
+	[]string{"query"},
+)
+
+// DatabaseQueryErrorsCounterVec is metric for the database queries that fail
+var DatabaseQueryErrorsCounterVec = prometheus.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "db_query_errors",
+		Help: "metric for the database query errors",
+	},
+	[]string{"query"},
+)
+
+// DatabaseStats returns the statistics of the database connection pool, which is set when
+// the database is opened
+var DatabaseStats func() sql.DBStats
+
+// databaseStatsCollector is the collector of the statistics of the database connection pool,
+// which are read from the pool when the metrics are scraped
+type databaseStatsCollector struct {
+	maxOpen           *prometheus.Desc
+	open              *prometheus.Desc
+	inUse             *prometheus.Desc
+	idle              *prometheus.Desc
+	waitCount         *prometheus.Desc
+	waitDuration      *prometheus.Desc
+	maxIdleClosed     *prometheus.Desc
+	maxIdleTimeClosed *prometheus.Desc
+	maxLifetimeClosed *prometheus.Desc
+}
+
+// DatabaseStatsCollector is the metric for the database connection pool
+var DatabaseStatsCollector = &databaseStatsCollector{
+	maxOpen:           prometheus.NewDesc("db_max_open_connections", "metric for the maximum open connections to the database", nil, nil),
+	open:              prometheus.NewDesc("db_open_connections", "metric for the open connections to the database", nil, nil),
+	inUse:             prometheus.NewDesc("db_in_use_connections", "metric for the database connections in use", nil, nil),
+	idle:              prometheus.NewDesc("db_idle_connections", "metric for the idle database connections", nil, nil),
+	waitCount:         prometheus.NewDesc("db_wait_count", "metric for the waits for a database connection", nil, nil),
+	waitDuration:      prometheus.NewDesc("db_wait_seconds", "metric for the time waited for a database connection", nil, nil),
+	maxIdleClosed:     prometheus.NewDesc("db_max_idle_closed", "metric for the database connections closed due to the idle limit", nil, nil),
+	maxIdleTimeClosed: prometheus.NewDesc("db_max_idle_time_closed", "metric for the database connections closed due to the idle time", nil, nil),
+	maxLifetimeClosed: prometheus.NewDesc("db_max_lifetime_closed", "metric for the database connections closed due to the lifetime", nil, nil),
+}
+
+// Describe sends the descriptions of the database metrics
+func (c *databaseStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.maxOpen
+	ch <- c.open
+	ch <- c.inUse
+	ch <- c.idle
+	ch <- c.waitCount
+	ch <- c.waitDuration
+	ch <- c.maxIdleClosed
+	ch <- c.maxIdleTimeClosed
+	ch <- c.maxLifetimeClosed
+}
+
+// Collect sends the current statistics of the database connection pool
+func (c *databaseStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	if DatabaseStats == nil {
+		return
+	}
+	stats := DatabaseStats()
+
+	ch <- prometheus.MustNewConstMetric(c.maxOpen, prometheus.GaugeValue, float64(stats.MaxOpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.open, prometheus.GaugeValue, float64(stats.OpenConnections))
+	ch <- prometheus.MustNewConstMetric(c.inUse, prometheus.GaugeValue, float64(stats.InUse))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stats.Idle))
+	ch <- prometheus.MustNewConstMetric(c.waitCount, prometheus.CounterValue, float64(stats.WaitCount))
+	ch <- prometheus.MustNewConstMetric(c.waitDuration, prometheus.CounterValue, stats.WaitDuration.Seconds())
+	ch <- prometheus.MustNewConstMetric(c.maxIdleClosed, prometheus.CounterValue, float64(stats.MaxIdleClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxIdleTimeClosed, prometheus.CounterValue, float64(stats.MaxIdleTimeClosed))
+	ch <- prometheus.MustNewConstMetric(c.maxLifetimeClosed, prometheus.CounterValue, float64(stats.MaxLifetimeClosed))
+}
+
 // InitMetrics register all the metrics
 func InitMetrics() {
 	prometheus.MustRegister(HTTPIncomingRequestCounterVec)
 	prometheus.MustRegister(HTTPIncomingLatencyHistogramVec)
 	prometheus.MustRegister(HTTPIncomingErrorsCounterVec)
 	prometheus.MustRegister(HTTPIncomingTimeoutsCounterVec)
+	prometheus.MustRegister(KeystoreCanaryGauge)
+	prometheus.MustRegister(APIKeySourceDeniedCounterVec)
+	prometheus.MustRegister(ModelSigningRefusedCounterVec)
+	prometheus.MustRegister(DatabaseStatsCollector)
+	prometheus.MustRegister(DatabaseQueryLatencyHistogramVec)
+	prometheus.MustRegister(DatabaseQueryRowsHistogramVec)
+	prometheus.MustRegister(DatabaseQueryErrorsCounterVec)
 }
@@ -24,17 +24,57 @@ import (
 
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/csrf"
+	"github.com/gorilla/mux"
 )
 
+// maxRequestIDLength is the longest request ID from the client that is used for the logs
+const maxRequestIDLength = 64
+
 // Logger Handle logging for the web service
 func Logger(start time.Time, r *http.Request) {
-	log.Infof("%s %s %s", r.Method, r.URL.Path, time.Since(start))
+	log.FromContext(r.Context()).Infof("%s %s %s", r.Method, log.MaskPath(r.URL.Path, mux.Vars(r)), time.Since(start))
+}
+
+// requestID returns the request ID from the client, or a new ID, to correlate the logs of the request
+func requestID(r *http.Request) string {
+	id := r.Header.Get("X-Request-ID")
+	if len(id) == 0 || len(id) > maxRequestIDLength || strings.IndexFunc(id, func(c rune) bool { return c <= ' ' || c > '~' }) >= 0 {
+		return log.NewRequestID()
+	}
+	return id
+}
+
+// statusRecorder records the status and size of a response, for the verbose logging of a route
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	size   int
+}
+
+func (rec *statusRecorder) WriteHeader(status int) {
+	rec.status = status
+	rec.ResponseWriter.WriteHeader(status)
+}
+
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += n
+	return n, err
+}
+
+// Flush keeps the streamed responses working when the route is logged verbosely
+func (rec *statusRecorder) Flush() {
+	if f, ok := rec.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
 }
 
 // ErrorHandler is a standard error handler middleware that generates the error response
@@ -48,7 +88,7 @@ func ErrorHandler(f func(http.ResponseWriter, *http.Request) response.ErrorRespo
 
 			// Encode the response as JSON
 			if err := json.NewEncoder(w).Encode(e); err != nil {
-				log.Printf("Error forming the signing response: %v\n", err)
+				log.Errorf("Error forming the signing response: %v", err)
 			}
 		}
 	}
@@ -59,10 +99,21 @@ func Middleware(inner http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 
-		// Log the request
-		Logger(start, r)
+		// The logs of the request have the request ID, which is returned to the client
+		id := requestID(r)
+		w.Header().Set("X-Request-ID", id)
+		r = r.WithContext(log.NewContext(r.Context(), log.New("http").With(log.FieldRequestID, id)))
+
+		// Log the request, with the response details when the route is being debugged
+		if !log.Verbose(r.URL.Path) {
+			Logger(start, r)
+			inner.ServeHTTP(w, r)
+			return
+		}
 
-		inner.ServeHTTP(w, r)
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		inner.ServeHTTP(rec, r)
+		log.Request(r, mux.Vars(r), rec.status, rec.size, time.Since(start))
 	})
 }
 
@@ -81,5 +132,16 @@ var MiddlewareWithCSRF = func(inner http.Handler) http.Handler {
 		csrf.HttpOnly(csrfSecure),
 	)
 
-	return CSRF(Middleware(inner))
+	unprotected := Middleware(inner)
+	protected := CSRF(unprotected)
+
+	// The personal access tokens are sent in the authorization header by scripts, which
+	// cannot be forged by the browser, so the CSRF token is not needed
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if datastore.IsAccessToken(request.BearerToken(r)) {
+			unprotected.ServeHTTP(w, r)
+			return
+		}
+		protected.ServeHTTP(w, r)
+	})
 }
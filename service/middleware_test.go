@@ -0,0 +1,54 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package service
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestMiddlewareWithCSRFAccessToken(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{CSRFAuthKey: "2E6ZYnVYUfDLRLV/ne8M6v1jyB/376BL9ORnN3Kgb04uSFalr2ygReVsOt0PaGEIRuID10TePBje5xdjIOEzEw=="}}
+	handler := MiddlewareWithCSRF(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	tests := []struct {
+		authorization string
+		code          int
+	}{
+		{"", http.StatusForbidden},
+		{"Bearer ThisIsAJWT", http.StatusForbidden},
+		{"Bearer svpat_SteveToken", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("POST", "/v1/models", nil)
+		r.Header.Set("Authorization", tt.authorization)
+		handler.ServeHTTP(w, r)
+
+		if w.Code != tt.code {
+			t.Errorf("Authorization '%s': expected %d, got %d", tt.authorization, tt.code, w.Code)
+		}
+	}
+}
@@ -20,12 +20,17 @@
 package model
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
@@ -37,6 +42,7 @@ type ListResponse struct {
 	ErrorSubcode string            `json:"error_subcode"`
 	ErrorMessage string            `json:"message"`
 	Models       []datastore.Model `json:"models"`
+	NextOffset   int               `json:"next_offset,omitempty"`
 }
 
 // InstanceResponse is the JSON response from the API Get/Post Model method
@@ -48,8 +54,42 @@ type InstanceResponse struct {
 	Model        datastore.Model `json:"model"`
 }
 
-// listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+// BulkResponse is the response from a bulk import of models, with the result of each row
+type BulkResponse struct {
+	Success      bool                        `json:"success"`
+	ErrorCode    string                      `json:"error_code"`
+	ErrorSubcode string                      `json:"error_subcode"`
+	ErrorMessage string                      `json:"message"`
+	Results      []datastore.BulkModelResult `json:"results"`
+}
+
+// HistoryResponse is the JSON response from the API model history method
+type HistoryResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	Revisions    []datastore.ModelRevision `json:"revisions"`
+}
+
+// RotateRequest is the request to rotate the API key of a model, with the time that the previous
+// key stays valid, e.g. "72h". The grace period of the API keys is used when it is empty
+type RotateRequest struct {
+	Overlap string `json:"overlap"`
+}
+
+// APIKeyResponse is the JSON response from the API model API key rotation method
+type APIKeyResponse struct {
+	Success      bool                  `json:"success"`
+	ErrorCode    string                `json:"error_code"`
+	ErrorSubcode string                `json:"error_subcode"`
+	ErrorMessage string                `json:"message"`
+	APIKey       datastore.ModelAPIKey `json:"apikey"`
+}
+
+// listHandler is the API method to fetch a page of the filtered models, with the offset of the
+// next page when there is one
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, filter datastore.ModelFilter) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Standard, apiCall)
@@ -58,20 +98,54 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	dbModels, err := datastore.Environ.DB.ListAllowedModels(user)
+	// Fetch an extra model to check if there is a next page
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	dbModels, err := datastore.Environ.DB.ListAllowedModels(ctx, user, filter)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-fetch-models", "", err.Error(), w)
 		return
 	}
 
+	next := 0
+	if limit > 0 && len(dbModels) > limit {
+		dbModels = dbModels[:limit]
+		next = filter.Offset + limit
+	}
+
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
-	formatListResponse(dbModels, w)
+	formatListResponse(dbModels, next, w)
+}
+
+// deletedListHandler is the API method to fetch the deleted models, which can be restored
+func deletedListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	dbModels, err := datastore.Environ.DB.ListAllowedDeletedModels(ctx, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-fetch-models", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of models
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(dbModels, 0, w)
 }
 
 // getHandler is the API method to fetch the models
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -80,19 +154,43 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelI
 		return
 	}
 
-	model, err := datastore.Environ.DB.GetAllowedModel(modelID, user)
+	model, err := datastore.Environ.DB.GetAllowedModel(ctx, modelID, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-fetch-model", "", err.Error(), w)
 		return
 	}
 
 	// Return successful JSON response with the list of models
+	response.SetVersion(w, model.Version)
 	w.WriteHeader(http.StatusOK)
 	formatInstanceResponse(model, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, mdl datastore.Model) {
+// historyHandler is the API method to fetch the revisions of a model, with the fields changed
+// by each revision
+func historyHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	revisions, err := datastore.Environ.DB.ListAllowedModelHistory(ctx, modelID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-fetch-model-history", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the revisions of the model
+	w.WriteHeader(http.StatusOK)
+	formatHistoryResponse(revisions, w)
+}
+
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, mdl datastore.Model) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -106,19 +204,33 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mod
 		return
 	}
 
-	errorSubcode, err := datastore.Environ.DB.UpdateAllowedModel(mdl, user)
+	// The update must be based on a version of the model, so a concurrent change is not overwritten
+	if mdl.Version <= 0 {
+		response.FormatErrorResponse(response.ErrorVersionRequired, w)
+		return
+	}
+
+	// The model before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetAllowedModel(ctx, modelID, user)
+
+	errorSubcode, err := datastore.Environ.DB.UpdateAllowedModel(ctx, mdl, user)
+	if err == datastore.ErrVersionConflict {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-updating-model", errorSubcode, err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectModel, modelID, mdl.BrandID, before, mdl)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
+func deleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -127,20 +239,24 @@ func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mod
 		return
 	}
 
+	// The model before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetAllowedModel(ctx, modelID, user)
+
 	mdl := datastore.Model{ID: modelID}
-	errorSubcode, err := datastore.Environ.DB.DeleteAllowedModel(mdl, user)
+	errorSubcode, err := datastore.Environ.DB.DeleteAllowedModel(ctx, mdl, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-deleting-model", errorSubcode, err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectModel, modelID, before.BrandID, before, nil)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mdl datastore.Model) {
+func restoreHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -149,19 +265,221 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, mdl
 		return
 	}
 
-	allowedModel, errorSubcode, err := datastore.Environ.DB.CreateAllowedModel(mdl, user)
+	errorSubcode, err := datastore.Environ.DB.RestoreAllowedModel(ctx, modelID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-restoring-model", errorSubcode, err.Error(), w)
+		return
+	}
+
+	// The model after the change, for the audit log
+	after, _ := datastore.Environ.DB.GetAllowedModel(ctx, modelID, user)
+	audit.Record(ctx, user, audit.ActionRestore, audit.ObjectModel, modelID, after.BrandID, nil, after)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// apiKeyRotateHandler is the API method to issue a new API key for a model, keeping the previous
+// key valid for the overlap
+func apiKeyRotateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, req RotateRequest) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
 	if err != nil {
-		log.Println(err)
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	var overlap time.Duration
+	if len(req.Overlap) == 0 {
+		overlap, err = datastore.APIKeyGrace(datastore.Environ.Config)
+	} else {
+		overlap, err = datastore.ParseAPIKeyGrace(req.Overlap)
+	}
+	if err != nil {
+		response.FormatStandardResponse(false, "error-model-apikey", "", err.Error(), w)
+		return
+	}
+
+	k, err := datastore.Environ.DB.RotateAllowedModelAPIKey(ctx, modelID, overlap, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-model-apikey", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionRotate, audit.ObjectModel, modelID, k.BrandID, nil, k)
+
+	// The response includes the new key and the expiry of the previous key
+	w.WriteHeader(http.StatusOK)
+	formatAPIKeyResponse(k, w)
+}
+
+func createHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, mdl datastore.Model) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	allowedModel, errorSubcode, err := datastore.Environ.DB.CreateAllowedModel(ctx, mdl, user)
+	if err != nil {
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-model-json", errorSubcode, err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectModel, allowedModel.ID, allowedModel.BrandID, nil, allowedModel)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	formatInstanceResponse(allowedModel, w)
 }
 
-func assertionHeaders(w http.ResponseWriter, user datastore.User, apiCall bool, assert datastore.ModelAssertion) {
+// putHandler creates or updates the model of the brand and name, so a script does not have to find
+// the ID of the model first. The API key and the signing-keys of a model are kept when they are
+// not set, and a model that matches the request is not changed
+func putHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, brandID, name string, mdl datastore.Model) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if (len(mdl.BrandID) > 0 && mdl.BrandID != brandID) || (len(mdl.Name) > 0 && mdl.Name != name) {
+		response.FormatStandardResponse(false, "error-model-json", "", "The brand and the model names do not match", w)
+		return
+	}
+	mdl.BrandID = brandID
+	mdl.Name = name
+
+	existing, found, err := findAllowedModel(ctx, user, brandID, name)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-fetch-model", "", err.Error(), w)
+		return
+	}
+	if !found {
+		created, errorSubcode, err := datastore.Environ.DB.CreateAllowedModel(ctx, mdl, user)
+		if err == nil {
+			audit.Record(ctx, user, audit.ActionCreate, audit.ObjectModel, created.ID, created.BrandID, nil, created)
+
+			response.SetVersion(w, created.Version)
+			w.WriteHeader(http.StatusCreated)
+			formatInstanceResponse(created, w)
+			return
+		}
+
+		// The model may have been created by a concurrent request
+		if existing, found, _ = findAllowedModel(ctx, user, brandID, name); !found {
+			log.Error(err)
+			response.FormatStandardResponse(false, "error-model-json", errorSubcode, err.Error(), w)
+			return
+		}
+	}
+
+	// The version is only checked when the request is based on one
+	if mdl.Version > 0 && mdl.Version != existing.Version {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
+
+	after := existing
+	after.SerialFormat = mdl.SerialFormat
+	after.SerialHeaders = mdl.SerialHeaders
+	after.SigningHours = mdl.SigningHours
+	after.SigningTimezone = mdl.SigningTimezone
+	after.AllowedCIDRs = mdl.AllowedCIDRs
+	if mdl.KeypairID > 0 {
+		after.KeypairID = mdl.KeypairID
+	}
+	if mdl.KeypairIDUser > 0 {
+		after.KeypairIDUser = mdl.KeypairIDUser
+	}
+	if len(mdl.APIKey) > 0 {
+		after.APIKey = mdl.APIKey
+	}
+	if after.SerialFormat == existing.SerialFormat && after.SerialHeaders == existing.SerialHeaders &&
+		after.SigningHours == existing.SigningHours && after.SigningTimezone == existing.SigningTimezone &&
+		after.AllowedCIDRs == existing.AllowedCIDRs && after.KeypairID == existing.KeypairID &&
+		after.KeypairIDUser == existing.KeypairIDUser && after.APIKey == existing.APIKey {
+		response.SetVersion(w, existing.Version)
+		w.WriteHeader(http.StatusOK)
+		formatInstanceResponse(existing, w)
+		return
+	}
+
+	errorSubcode, err := datastore.Environ.DB.UpdateAllowedModel(ctx, after, user)
+	if err == datastore.ErrVersionConflict {
+		response.FormatErrorResponse(response.ErrorVersionConflict, w)
+		return
+	}
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-updating-model", errorSubcode, err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectModel, after.ID, after.BrandID, existing, after)
+
+	// The model is read again for the details of its signing-keys
+	if updated, err := datastore.Environ.DB.GetAllowedModel(ctx, after.ID, user); err == nil {
+		after = updated
+	}
+	response.SetVersion(w, after.Version)
+	w.WriteHeader(http.StatusOK)
+	formatInstanceResponse(after, w)
+}
+
+// findAllowedModel finds the model of the brand and name that the user can see
+func findAllowedModel(ctx context.Context, user datastore.User, brandID, name string) (datastore.Model, bool, error) {
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, user, datastore.ModelFilter{BrandID: brandID, Name: name})
+	if err != nil {
+		return datastore.Model{}, false, err
+	}
+	for _, m := range models {
+		if m.BrandID == brandID && m.Name == name {
+			return m, true, nil
+		}
+	}
+	return datastore.Model{}, false, nil
+}
+
+func bulkHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, rows []datastore.BulkModel) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(rows) == 0 || len(rows) > datastore.MaxBulkModels {
+		response.FormatStandardResponse(false, "error-model-data", "", fmt.Sprintf("Between 1 and %d models must be supplied", datastore.MaxBulkModels), w)
+		return
+	}
+
+	// The results are returned when the models are rejected, to report the invalid rows
+	results, err := datastore.Environ.DB.BulkCreateAllowedModels(ctx, rows, user)
+	if err != nil {
+		log.Error("error-bulk-models", err)
+		w.WriteHeader(http.StatusBadRequest)
+		formatBulkResponse(BulkResponse{ErrorCode: "error-bulk-models", ErrorMessage: err.Error(), Results: results}, w)
+		return
+	}
+
+	for _, r := range results {
+		audit.Record(ctx, user, audit.ActionCreate, audit.ObjectModel, r.ModelID, r.BrandID, nil, r)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatBulkResponse(BulkResponse{Success: true, Results: results}, w)
+}
+
+func assertionHeaders(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, assert datastore.ModelAssertion) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -171,41 +489,74 @@ func assertionHeaders(w http.ResponseWriter, user datastore.User, apiCall bool,
 	}
 
 	// Check that the user has permissions to access the model
-	_, err = datastore.Environ.DB.GetAllowedModel(assert.ModelID, user)
+	mdl, err := datastore.Environ.DB.GetAllowedModel(ctx, assert.ModelID, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-get-model", "", err.Error(), w)
 		return
 	}
 
-	err = datastore.Environ.DB.UpsertModelAssert(assert)
+	// Check the headers with the asserts package, so an assertion that cannot be signed is not stored
+	err = assertion.CheckModelAssertion(ctx, mdl, assert)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "create-assertion", "", err.Error(), w)
+		return
+	}
+
+	err = datastore.Environ.DB.UpsertModelAssert(ctx, assert)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "create-assertion", "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectModel, mdl.ID, mdl.BrandID, nil, assert)
 
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func formatListResponse(models []datastore.Model, w http.ResponseWriter) error {
-	response := ListResponse{Success: true, Models: models}
+func formatListResponse(models []datastore.Model, next int, w http.ResponseWriter) error {
+	response := ListResponse{Success: true, Models: models, NextOffset: next}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error forming the models response (%v).\n %v", response, err)
+		log.Errorf("Error forming the models response (%v).\n %v", response, err)
 		return err
 	}
 	return nil
 }
 
+func formatHistoryResponse(revisions []datastore.ModelRevision, w http.ResponseWriter) {
+	response := HistoryResponse{Success: true, Revisions: revisions}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the model history response (%v).\n %v", response, err)
+	}
+}
+
+func formatAPIKeyResponse(k datastore.ModelAPIKey, w http.ResponseWriter) {
+	response := APIKeyResponse{Success: true, APIKey: k}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the model API key response.\n %v", err)
+	}
+}
+
+func formatBulkResponse(result BulkResponse, w http.ResponseWriter) {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("error-model-response", err)
+	}
+}
+
 func formatInstanceResponse(model datastore.Model, w http.ResponseWriter) error {
 	response := InstanceResponse{Success: true, Model: model}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error forming the model response (%v).\n %v", response, err)
+		log.Errorf("Error forming the model response (%v).\n %v", response, err)
 		return err
 	}
 	return nil
@@ -22,6 +22,7 @@ package model
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
@@ -41,8 +42,27 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := GetModelFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-model-filter", "", err.Error(), w)
+		return
+	}
+
 	// Call the API with the user
-	listHandler(w, user, true)
+	listHandler(r.Context(), w, user, true, filter)
+}
+
+// APIListDeleted is the API method to fetch the deleted models
+func APIListDeleted(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	deletedListHandler(r.Context(), w, user, true)
 }
 
 // APIGet is the API method to fetch a model
@@ -62,7 +82,7 @@ func APIGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	getHandler(w, user, true, id)
+	getHandler(r.Context(), w, user, true, id)
 }
 
 // APIUpdate is the API method to update a model
@@ -96,8 +116,10 @@ func APIUpdate(w http.ResponseWriter, r *http.Request) {
 		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
 		return
 	}
+	// The update is based on the version of the model that was read
+	mdl.Version = request.Version(r, mdl.Version)
 
-	updateHandler(w, user, true, modelID, mdl)
+	updateHandler(r.Context(), w, user, true, modelID, mdl)
 }
 
 // APIDelete is the API method to delete a model
@@ -116,7 +138,69 @@ func APIDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, user, true, modelID)
+	deleteHandler(r.Context(), w, user, true, modelID)
+}
+
+// APIHistory is the API method to fetch the change history of a model
+func APIHistory(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	historyHandler(r.Context(), w, user, true, modelID)
+}
+
+// APIRotateAPIKey is the API method to issue a new API key for a model
+func APIRotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	req, ok := decodeRotateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	apiKeyRotateHandler(r.Context(), w, user, true, modelID, req)
+}
+
+// APIRestore is the API method to restore a deleted model
+func APIRestore(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	restoreHandler(r.Context(), w, user, true, modelID)
 }
 
 // APICreate is the API method to create a model
@@ -144,7 +228,25 @@ func APICreate(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, user, true, mdl)
+	createHandler(r.Context(), w, user, true, mdl)
+}
+
+// APIPut is the API method to create or update the model of a brand and name
+func APIPut(w http.ResponseWriter, r *http.Request) {
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	mdl, ok := decodePutRequest(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	putHandler(r.Context(), w, user, true, vars["brand"], vars["name"], mdl)
 }
 
 // APIAssertionHeaders is the API method to upsert the model assertion header details
@@ -172,5 +274,50 @@ func APIAssertionHeaders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHeaders(w, user, true, assert)
+	assertionHeaders(r.Context(), w, user, true, assert)
+}
+
+// GetModelFilter parses the filters and the page of the models list from the request. A page
+// has the default size, unless all the models are requested
+func GetModelFilter(r *http.Request) (datastore.ModelFilter, error) {
+	query := r.URL.Query()
+	filter := datastore.ModelFilter{
+		BrandID: query.Get("brand-id"),
+		Name:    query.Get("name"),
+		Limit:   datastore.ListModelsDefaultLimit,
+	}
+
+	if keypairID := query.Get("keypair-id"); keypairID != "" {
+		id, err := strconv.Atoi(keypairID)
+		if err != nil || id <= 0 {
+			return filter, fmt.Errorf("invalid keypair ID: %s", keypairID)
+		}
+		filter.KeypairID = id
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", offset)
+		}
+		filter.Offset = o
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return filter, fmt.Errorf("invalid page size: %s", limit)
+		}
+		if l > datastore.ListModelsMaxLimit {
+			l = datastore.ListModelsMaxLimit
+		}
+		filter.Limit = l
+	}
+
+	if query.Get("all") == "true" {
+		filter.Limit = 0 // Means no limit.
+		filter.Offset = 0
+	}
+
+	return filter, nil
 }
@@ -26,6 +26,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
@@ -82,6 +83,19 @@ func (s *ModelsSuite) TestAPICreateHandlerReturnModel(c *check.C) {
 	c.Assert(result.Model.Name, check.Equals, model.Name)
 }
 
+func (s *ModelsSuite) TestAPIPutHandler(c *check.C) {
+	w := sendAdminAPIRequest("PUT", "/api/models/system/yew", strings.NewReader(`{"keypair-id": 1}`), datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 201)
+
+	result, err := parseInstanceResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Model.Name, check.Equals, "yew")
+
+	w = sendAdminAPIRequest("PUT", "/api/models/system/alder", strings.NewReader(`{}`), datastore.Standard, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func sendAdminAPIRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
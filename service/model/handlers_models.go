@@ -20,18 +20,22 @@
 package model
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
 
-// List is the API method to fetch the users
+// List is the API method to fetch a page of the models, with the optional filters
 func List(w http.ResponseWriter, r *http.Request) {
 	authUser, err := auth.GetUserFromJWT(w, r)
 	if err != nil {
@@ -39,7 +43,24 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false)
+	filter, err := GetModelFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-model-filter", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false, filter)
+}
+
+// ListDeleted is the API method to fetch the deleted models
+func ListDeleted(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	deletedListHandler(r.Context(), w, authUser, false)
 }
 
 // Get is the API method to fetch a model
@@ -57,7 +78,7 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	getHandler(r.Context(), w, authUser, false, id)
 }
 
 // Update is the API method to update a model
@@ -90,8 +111,10 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
 		return
 	}
+	// The update is based on the version of the model that was read
+	mdl.Version = request.Version(r, mdl.Version)
 
-	updateHandler(w, authUser, false, modelID, mdl)
+	updateHandler(r.Context(), w, authUser, false, modelID, mdl)
 }
 
 // Delete is the API method to delete a model
@@ -109,7 +132,79 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, modelID)
+	deleteHandler(r.Context(), w, authUser, false, modelID)
+}
+
+// History is the API method to fetch the change history of a model
+func History(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	historyHandler(r.Context(), w, authUser, false, modelID)
+}
+
+// RotateAPIKey is the API method to issue a new API key for a model
+func RotateAPIKey(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	req, ok := decodeRotateRequest(w, r)
+	if !ok {
+		return
+	}
+
+	apiKeyRotateHandler(r.Context(), w, authUser, false, modelID, req)
+}
+
+// decodeRotateRequest decodes the request to rotate the API key of a model, which is optional
+func decodeRotateRequest(w http.ResponseWriter, r *http.Request) (RotateRequest, bool) {
+	defer r.Body.Close()
+
+	req := RotateRequest{}
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil && err != io.EOF {
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return req, false
+	}
+	return req, true
+}
+
+// Restore is the API method to restore a deleted model
+func Restore(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	restoreHandler(r.Context(), w, authUser, false, modelID)
 }
 
 // Create is the API method to create a model
@@ -136,7 +231,137 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, mdl)
+	createHandler(r.Context(), w, authUser, false, mdl)
+}
+
+// Put is the API method to create or update the model of a brand and name
+func Put(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	mdl, ok := decodePutRequest(w, r)
+	if !ok {
+		return
+	}
+
+	vars := mux.Vars(r)
+	putHandler(r.Context(), w, authUser, false, vars["brand"], vars["name"], mdl)
+}
+
+// decodePutRequest decodes the model of a put request, with the version of the If-Match header
+func decodePutRequest(w http.ResponseWriter, r *http.Request) (datastore.Model, bool) {
+	defer r.Body.Close()
+
+	mdl := datastore.Model{}
+	err := json.NewDecoder(r.Body).Decode(&mdl)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No model data supplied.", w)
+		return mdl, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return mdl, false
+	}
+
+	mdl.Version = request.Version(r, mdl.Version)
+	return mdl, true
+}
+
+// Bulk is the API method to import a list of models, e.g. for a brand onboarding its devices.
+// The models are sent as a JSON list, or as CSV with a header row naming the columns, and are
+// created in a single transaction
+func Bulk(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	var rows []datastore.BulkModel
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		rows, err = decodeBulkCSV(r.Body)
+	} else {
+		err = json.NewDecoder(r.Body).Decode(&rows)
+	}
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-model-data", "", "No model data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-models", "", err.Error(), w)
+		return
+	}
+
+	bulkHandler(r.Context(), w, authUser, false, rows)
+}
+
+// bulkColumns set the fields of a bulk import row from the CSV columns, which have the names of the JSON fields
+var bulkColumns = map[string]func(row *datastore.BulkModel, value string) error{
+	"brand-id":         func(row *datastore.BulkModel, value string) error { row.BrandID = value; return nil },
+	"model":            func(row *datastore.BulkModel, value string) error { row.Name = value; return nil },
+	"api-key":          func(row *datastore.BulkModel, value string) error { row.APIKey = value; return nil },
+	"keypair-id":       func(row *datastore.BulkModel, value string) error { return parseBulkID(&row.KeypairID, value) },
+	"keypair-id-user":  func(row *datastore.BulkModel, value string) error { return parseBulkID(&row.KeypairIDUser, value) },
+	"signing-key":      func(row *datastore.BulkModel, value string) error { row.SigningKey = value; return nil },
+	"system-user-key":  func(row *datastore.BulkModel, value string) error { row.SystemUserKey = value; return nil },
+	"serial-format":    func(row *datastore.BulkModel, value string) error { row.SerialFormat = value; return nil },
+	"serial-headers":   func(row *datastore.BulkModel, value string) error { row.SerialHeaders = value; return nil },
+	"signing-hours":    func(row *datastore.BulkModel, value string) error { row.SigningHours = value; return nil },
+	"signing-timezone": func(row *datastore.BulkModel, value string) error { row.SigningTimezone = value; return nil },
+	"allowed-cidrs":    func(row *datastore.BulkModel, value string) error { row.AllowedCIDRs = value; return nil },
+}
+
+// decodeBulkCSV reads the rows of a bulk import from CSV, with a header row
+func decodeBulkCSV(r io.Reader) ([]datastore.BulkModel, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range header {
+		if _, ok := bulkColumns[strings.TrimSpace(column)]; !ok {
+			return nil, fmt.Errorf("unknown column: %s", column)
+		}
+	}
+
+	rows := []datastore.BulkModel{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		row := datastore.BulkModel{}
+		for i, column := range header {
+			if err := bulkColumns[strings.TrimSpace(column)](&row, strings.TrimSpace(record[i])); err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s: %s", len(rows)+1, column, record[i])
+			}
+		}
+		rows = append(rows, row)
+	}
+}
+
+func parseBulkID(id *int, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	v, err := strconv.Atoi(value)
+	*id = v
+	return err
 }
 
 // AssertionHeaders is the API method to upsert the model assertion header details
@@ -163,5 +388,5 @@ func AssertionHeaders(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	assertionHeaders(w, authUser, false, assert)
+	assertionHeaders(r.Context(), w, authUser, false, assert)
 }
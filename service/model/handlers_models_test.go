@@ -28,6 +28,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -145,6 +146,211 @@ func (s *ModelsSuite) TestListHandler(c *check.C) {
 	}
 }
 
+func (s *ModelsSuite) TestListFilterHandler(c *check.C) {
+	tests := []SuiteTest{
+		{false, "GET", "/v1/models?brand-id=system", nil, 200, "application/json; charset=UTF-8", 0, false, true, 6},
+		{false, "GET", "/v1/models?brand-id=other", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{false, "GET", "/v1/models?keypair-id=1", nil, 200, "application/json; charset=UTF-8", 0, false, true, 6},
+		{false, "GET", "/v1/models?keypair-id=2", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{false, "GET", "/v1/models?name=MA", nil, 200, "application/json; charset=UTF-8", 0, false, true, 2},
+		{false, "GET", "/v1/models?all=true&offset=5", nil, 200, "application/json; charset=UTF-8", 0, false, true, 6},
+		{false, "GET", "/v1/models?keypair-id=abc", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "GET", "/v1/models?offset=-1", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "GET", "/v1/models?limit=0", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "GET", "/api/models?name=ash", nil, 200, "application/json; charset=UTF-8", datastore.Admin, false, true, 1},
+		{false, "GET", "/api/models?limit=abc", nil, 400, "application/json; charset=UTF-8", datastore.Admin, false, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+
+		var w *httptest.ResponseRecorder
+		if strings.Contains(t.URL, "api") {
+			w = sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		} else {
+			w = sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		}
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("URL %s", t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Models), check.Equals, t.List, check.Commentf("URL %s", t.URL))
+
+		datastore.Environ.Config.EnableUserAuth = true
+	}
+}
+
+func (s *ModelsSuite) TestListPagination(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = false
+	defer func() { datastore.Environ.Config.EnableUserAuth = true }()
+
+	pages := []int{}
+	url := "/v1/models?limit=4"
+
+	for {
+		w := sendAdminRequest("GET", url, nil, 0, c)
+		c.Assert(w.Code, check.Equals, 200)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, true)
+		pages = append(pages, len(result.Models))
+
+		if result.NextOffset == 0 {
+			break
+		}
+		url = fmt.Sprintf("/v1/models?limit=4&offset=%d", result.NextOffset)
+	}
+
+	c.Assert(pages, check.DeepEquals, []int{4, 2})
+}
+
+func (s *ModelsSuite) TestListDeletedHandler(c *check.C) {
+	tests := []SuiteTest{
+		{false, "GET", "/v1/models/deleted", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{false, "GET", "/v1/models/deleted", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{false, "GET", "/v1/models/deleted", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{true, "GET", "/v1/models/deleted", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "GET", "/api/models/deleted", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{false, "GET", "/api/models/deleted", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		var w *httptest.ResponseRecorder
+		if strings.Contains(t.URL, "api") {
+			w = sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		} else {
+			w = sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		}
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Models), check.Equals, t.List)
+		if t.List > 0 {
+			c.Assert(result.Models[0].Name, check.Equals, "walnut")
+			c.Assert(result.Models[0].DeletedAt, check.NotNil)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = true
+		if t.MockError {
+			datastore.Environ.DB = &datastore.MockDB{}
+		}
+	}
+}
+
+func (s *ModelsSuite) TestHistoryHandler(c *check.C) {
+	tests := []SuiteTest{
+		{false, "GET", "/v1/models/1/history", nil, 200, "application/json; charset=UTF-8", 0, false, true, 2},
+		{false, "GET", "/v1/models/1/history", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+		{false, "GET", "/v1/models/1/history", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "GET", "/v1/models/5/history", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "GET", "/v1/models/999999999999999999999999999999/history", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{true, "GET", "/v1/models/1/history", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "GET", "/api/models/1/history", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "GET", "/api/models/1/history", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+		{false, "GET", "/api/models/1/history", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		var w *httptest.ResponseRecorder
+		if strings.Contains(t.URL, "api") {
+			w = sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		} else {
+			w = sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		}
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := model.HistoryResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Revisions), check.Equals, t.List)
+		if t.List > 0 {
+			// The latest revision changed the signing key
+			c.Assert(result.Revisions[0].Action, check.Equals, datastore.ModelRevisionUpdate)
+			c.Assert(result.Revisions[0].Username, check.Equals, "sv")
+			c.Assert(result.Revisions[0].Changes, check.DeepEquals, []datastore.ModelChange{
+				{Field: "keypair-id", From: "2", To: "1"},
+				{Field: "key-id", From: "oldkey", To: "UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO"},
+			})
+		}
+
+		datastore.Environ.Config.EnableUserAuth = true
+		if t.MockError {
+			datastore.Environ.DB = &datastore.MockDB{}
+		}
+	}
+}
+
+func (s *ModelsSuite) TestRotateAPIKeyHandler(c *check.C) {
+	tests := []SuiteTest{
+		{false, "POST", "/v1/models/1/apikey", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{false, "POST", "/v1/models/1/apikey", []byte(`{"overlap": "72h"}`), 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 72},
+		{false, "POST", "/v1/models/1/apikey", []byte(`{"overlap": "0s"}`), 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{false, "POST", "/v1/models/1/apikey", []byte(`{"overlap": "1000h"}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/1/apikey", []byte(`{"overlap": "soon"}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/1/apikey", []byte("bad"), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/1/apikey", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "POST", "/v1/models/5/apikey", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{true, "POST", "/v1/models/1/apikey", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/1/apikey", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "POST", "/api/models/1/apikey", []byte(`{"overlap": "72h"}`), 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 72},
+		{false, "POST", "/api/models/1/apikey", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		var w *httptest.ResponseRecorder
+		if strings.HasPrefix(t.URL, "/api") {
+			w = sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		} else {
+			w = sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		}
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := model.APIKeyResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		if t.Success {
+			c.Assert(result.APIKey.ModelID, check.Equals, 1)
+			c.Assert(result.APIKey.APIKey, check.Equals, "RotatedModelAPIKey")
+		}
+
+		// The List of the test is the overlap in hours, when it is set in the request
+		if t.List > 0 {
+			c.Assert(result.APIKey.PreviousExpires, check.NotNil)
+			c.Assert(*result.APIKey.PreviousExpires, check.Equals, time.Date(2020, time.January, 1, t.List, 0, 0, 0, time.UTC))
+		}
+
+		datastore.Environ.Config.EnableUserAuth = true
+		if t.MockError {
+			datastore.Environ.DB = &datastore.MockDB{}
+		}
+	}
+}
+
 func (s *ModelsSuite) TestGetHandler(c *check.C) {
 	tests := []SuiteTest{
 		{false, "GET", "/v1/models/1", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
@@ -199,6 +405,7 @@ func (s *ModelsSuite) TestUpdateDeleteHandler(c *check.C) {
 		"id": 1,
 		"brand-id": "System",
 		"model":"the-model",
+		"version": 1,
 		"serial":"A1234-L",
 		"device-key":"ssh-rsa NNhqloxPyIYXiTP+3JTPWV/mNoBar2geWIf"
 	}`
@@ -207,6 +414,7 @@ func (s *ModelsSuite) TestUpdateDeleteHandler(c *check.C) {
 		"id": 5,
 		"brand-id": "System",
 		"model":"the-model",
+		"version": 1,
 		"serial":"A1234-L",
 		"device-key":"ssh-rsa NNhqloxPyIYXiTP+3JTPWV/mNoBar2geWIf"
 	}`
@@ -215,6 +423,7 @@ func (s *ModelsSuite) TestUpdateDeleteHandler(c *check.C) {
 		"id": 1,
 		"brand-id": "system",
 		"model":"ash",
+		"version": 1,
 		"serial":"A1234-L",
 		"device-key":"ssh-rsa NNhqloxPyIYXiTP+3JTPWV/mNoBar2geWIf"
 	}`
@@ -270,6 +479,16 @@ func (s *ModelsSuite) TestUpdateDeleteHandler(c *check.C) {
 		{false, "DELETE", "/api/models/999999999999999999999999999999", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{true, "DELETE", "/api/models/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 
+		{false, "POST", "/v1/models/7/restore", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{false, "POST", "/v1/models/7/restore", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{false, "POST", "/v1/models/7/restore", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "POST", "/v1/models/1/restore", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{true, "POST", "/v1/models/7/restore", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/7/restore", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{false, "POST", "/api/models/7/restore", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{false, "POST", "/api/models/7/restore", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "POST", "/api/models/1/restore", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+
 		{false, "POST", "/api/models", []byte(newData), 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{false, "POST", "/api/models", []byte(newData), 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{false, "POST", "/api/models", []byte(""), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
@@ -303,6 +522,81 @@ func (s *ModelsSuite) TestUpdateDeleteHandler(c *check.C) {
 	}
 }
 
+func (s *ModelsSuite) TestUpdateHandlerVersion(c *check.C) {
+	datastore.Environ.Config.EnableUserAuth = false
+
+	tests := []struct {
+		data    string
+		ifMatch string
+		code    int
+		errCode string
+	}{
+		{`{"id": 1, "brand-id": "System", "model": "the-model"}`, "", 428, "version-required"},
+		{`{"id": 1, "brand-id": "System", "model": "the-model"}`, `"1"`, 200, ""},
+		{`{"id": 1, "brand-id": "System", "model": "the-model"}`, `"invalid"`, 428, "version-required"},
+		{`{"id": 1, "brand-id": "System", "model": "the-model", "version": 1}`, "", 200, ""},
+		{`{"id": 1, "brand-id": "System", "model": "the-model", "version": 2}`, "", 409, "version-conflict"},
+		{`{"id": 1, "brand-id": "System", "model": "the-model", "version": 1}`, `"2"`, 409, "version-conflict"},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("PUT", "/v1/models/1", strings.NewReader(t.data))
+		if len(t.ifMatch) > 0 {
+			r.Header.Set("If-Match", t.ifMatch)
+		}
+		service.AdminRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.errCode)
+	}
+
+	// The version of the model is the entity tag of the response
+	w := sendAdminRequest("GET", "/v1/models/1", nil, 0, c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("ETag"), check.Equals, `"0"`)
+}
+
+func (s *ModelsSuite) TestPutHandler(c *check.C) {
+	tests := []struct {
+		url       string
+		data      string
+		perm      int
+		mockError bool
+		code      int
+		errCode   string
+		modelID   int
+	}{
+		{"/v1/models/system/alder", `{}`, datastore.Admin, false, 200, "", 1},
+		{"/v1/models/system/alder", `{"brand-id": "system", "model": "alder", "serial-format": "^A[0-9]+$"}`, datastore.Admin, false, 200, "", 1},
+		{"/v1/models/system/yew", `{"keypair-id": 1}`, datastore.Admin, false, 201, "", 7},
+		{"/v1/models/system/alder", `{"model": "ash"}`, datastore.Admin, false, 400, "error-model-json", 0},
+		{"/v1/models/system/alder", `{"serial-format": "^A[0-9]+$", "version": 2}`, datastore.Admin, false, 409, "version-conflict", 0},
+		{"/v1/models/system/alder", `invalid`, datastore.Admin, false, 400, "error-decode-json", 0},
+		{"/v1/models/system/alder", `{}`, datastore.Standard, false, 400, "error-auth", 0},
+		{"/v1/models/system/alder", `{}`, datastore.Admin, true, 400, "error-fetch-model", 0},
+	}
+
+	for _, t := range tests {
+		if t.mockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest("PUT", t.url, strings.NewReader(t.data), t.perm, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result, err := parseInstanceResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.errCode)
+		c.Assert(result.Model.ID, check.Equals, t.modelID)
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
 func (s *ModelsSuite) TestCreateHandlerReturnModel(c *check.C) {
 	model := datastore.Model{BrandID: "System", Name: "the-model", KeypairID: 1}
 	newData, _ := json.Marshal(model)
@@ -383,6 +677,153 @@ func (s *ModelsSuite) TestAssertionHandler(c *check.C) {
 	}
 }
 
+func (s *ModelsSuite) TestAssertionHandlerUC20(c *check.C) {
+	d := datastore.ModelAssertion{
+		ModelID: 1, KeypairID: 1,
+		Series: 16, Architecture: "amd64", Revision: 1,
+		Base: "core20", Store: "ubuntu", Grade: "signed", StorageSafety: "prefer-encrypted",
+		Snaps: []datastore.ModelSnap{
+			{Name: "pc", ID: "UqFziVZDHLSyO3TqSWgNBoAdHbLI4dAH", Type: "gadget", DefaultChannel: "20/stable"},
+			{Name: "pc-kernel", ID: "pYVQrBcKmBa0mZ4CCN7ExT6jH8rY1hza", Type: "kernel", DefaultChannel: "20/stable"},
+		},
+		ValidationSets: []datastore.ModelValidationSet{{AccountID: "system", Name: "base-set", Mode: "enforce"}},
+	}
+	data, _ := json.Marshal(d)
+
+	// The kernel is in the snaps list of a graded model
+	d.Kernel = "pc-kernel"
+	dataKernel, _ := json.Marshal(d)
+
+	// The snaps of a signed model need their ID
+	d.Kernel = ""
+	d.Snaps = []datastore.ModelSnap{{Name: "pc", Type: "gadget", DefaultChannel: "20/stable"}, {Name: "pc-kernel", Type: "kernel", DefaultChannel: "20/stable"}}
+	dataNoID, _ := json.Marshal(d)
+
+	// The snaps list is not valid without a grade
+	d.Grade = ""
+	d.StorageSafety = ""
+	d.ValidationSets = nil
+	d.Gadget, d.Kernel = "mygadget", "mykernel"
+	dataNoGrade, _ := json.Marshal(d)
+
+	tests := []SuiteTest{
+		{false, "POST", "/v1/models/assertion", data, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{false, "POST", "/v1/models/assertion", dataKernel, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/assertion", dataNoID, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/assertion", dataNoGrade, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/api/models/assertion", data, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{false, "POST", "/api/models/assertion", dataNoID, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+
+		var w *httptest.ResponseRecorder
+		if strings.HasPrefix(t.URL, "/api") {
+			w = sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		} else {
+			w = sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		}
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+	datastore.Environ.Config.EnableUserAuth = true
+}
+
+func (s *ModelsSuite) TestBulkHandler(c *check.C) {
+	data := []byte(`[
+		{"brand-id":"system", "model":"birch", "keypair-id":1, "keypair-id-user":1},
+		{"brand-id":"system", "model":"cedar", "signing-key":"UytTqTvREVhx0tSfYC6KkFHmLWllIIZbQ3NsEG7OARrWuaXSRJyey0vjIQkTEvMO", "system-user-key":"user-key"}
+	]`)
+	w := sendAdminRequest("POST", "/v1/models/bulk", bytes.NewReader(data), datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := model.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 2)
+	c.Assert(result.Results[0].ModelID, check.Equals, 700)
+	c.Assert(result.Results[1].Name, check.Equals, "cedar")
+	c.Assert(result.Results[1].KeypairID, check.Equals, 1)
+	c.Assert(result.Results[1].KeypairIDUser, check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestBulkHandlerCSV(c *check.C) {
+	data := "brand-id,model,keypair-id,system-user-key,serial-format\nsystem,birch,1,user-key,\nsystem,cedar,1,user-key,^R[0-9]+$\n"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/models/bulk", strings.NewReader(data))
+	r.Header.Set("Content-Type", "text/csv")
+	c.Assert(createJWTWithRole(r, datastore.Admin), check.IsNil)
+	service.AdminRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := model.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 2)
+	c.Assert(result.Results[1].Row, check.Equals, 2)
+	c.Assert(result.Results[1].KeypairIDUser, check.Equals, 1)
+}
+
+func (s *ModelsSuite) TestBulkHandlerInvalid(c *check.C) {
+	tests := []SuiteTest{
+		{false, "POST", "/v1/models/bulk", []byte(`[{"brand-id":"system", "model":"birch", "keypair-id":1, "keypair-id-user":1}]`), 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{false, "POST", "/v1/models/bulk", []byte(`[{"brand-id":"system", "model":"birch", "keypair-id":1, "keypair-id-user":1}, {"brand-id":"system", "model":"alder", "keypair-id":1, "keypair-id-user":1}]`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 2},
+		{false, "POST", "/v1/models/bulk", []byte(`[{"brand-id":"system", "model":"birch"}]`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 1},
+		{false, "POST", "/v1/models/bulk", []byte(`[]`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/bulk", []byte(`{"brand-id":"system"}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{false, "POST", "/v1/models/bulk", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{true, "POST", "/v1/models/bulk", []byte(`[{"brand-id":"system", "model":"birch", "keypair-id":1, "keypair-id-user":1}]`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s", t.Data))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := model.BulkResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.Results, check.HasLen, t.List)
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *ModelsSuite) TestBulkHandlerInvalidCSV(c *check.C) {
+	tests := []string{
+		"brand-id,model,keypair\nsystem,birch,1\n",
+		"brand-id,model,keypair-id\nsystem,birch,one\n",
+		"brand-id,model\nsystem,birch,1\n",
+		"",
+	}
+
+	for _, data := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/models/bulk", strings.NewReader(data))
+		r.Header.Set("Content-Type", "text/csv")
+		c.Assert(createJWTWithRole(r, datastore.Admin), check.IsNil)
+		service.AdminRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest, check.Commentf("%s", data))
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+	}
+}
+
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
@@ -21,8 +21,11 @@
 package pivot
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
@@ -31,9 +34,11 @@ import (
 	"github.com/CanonicalLtd/serial-vault/account"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	assert "github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/timestamp"
 	"github.com/snapcore/snapd/asserts"
 )
 
@@ -52,7 +57,7 @@ func Model(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 		return errResponse
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	substore, errResponse := findModelPivot(r.Context(), assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
@@ -71,7 +76,7 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 	}
 
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(assertion.HeaderString("brand-id"))
+	acc, err := datastore.Environ.DB.GetAccount(r.Context(), assertion.HeaderString("brand-id"))
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -79,26 +84,22 @@ func ModelAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespon
 		return response.ErrorResponse{Success: false, Code: "error-auth", Message: "This feature is not enabled for this account", StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	substore, errResponse := findModelPivot(r.Context(), assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
 
 	assertions := []asserts.Assertion{}
 
-	// Build the model assertion headers for the original model
-	assertionHeaders, keypair, err := assert.CreateModelAssertionHeaders(substore.FromModel)
+	// Build the model assertion headers for the original model, with the sub-store details
+	assertionHeaders, keypair, err := assert.CreatePivotModelAssertionHeaders(r.Context(), substore)
 	if err != nil {
 		svlog.Message("PIVOT", "create-assertion", err.Error())
 		return response.ErrorCreateModelAssertion
 	}
 
-	// Override the model assertion headers with the sub-store details
-	assertionHeaders["model"] = substore.ModelName
-	assertionHeaders["store"] = substore.Store
-
-	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.ModelType, assertionHeaders, []byte(""), substore.FromModel.BrandID, keypair.KeyID, keypair.SealedKey)
+	// Sign the assertion with the snapd assertions module, unless it is stored for the same headers
+	signedAssertion, err := assert.SignModelAssertion(r.Context(), substore.FromModel, substore.ID, assertionHeaders, keypair)
 	if err != nil {
 		svlog.Message("PIVOT", "signing-assertion", err.Error())
 		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
@@ -128,7 +129,7 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 	}
 
 	// Check that the reseller functionality is enabled for the brand
-	acc, err := datastore.Environ.DB.GetAccount(assertion.HeaderString("brand-id"))
+	acc, err := datastore.Environ.DB.GetAccount(r.Context(), assertion.HeaderString("brand-id"))
 	if err != nil {
 		return response.ErrorResponse{Success: false, Code: "error-account", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
@@ -136,7 +137,7 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 		return response.ErrorResponse{Success: false, Code: "error-auth", Message: "This feature is not enabled for this account", StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
+	substore, errResponse := findModelPivot(r.Context(), assertion.HeaderString("brand-id"), assertion.HeaderString("model"), assertion.HeaderString("serial"), r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
@@ -147,7 +148,13 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 	// Override the model assertion headers with the sub-store details
 	assertionHeaders := assertion.Headers()
 	assertionHeaders["model"] = substore.ModelName
-	assertionHeaders["timestamp"] = time.Now().Format(time.RFC3339)
+	substore.SetOriginalHeaders(assertionHeaders)
+	signingTime, _, err := timestamp.Now(datastore.Environ.Config)
+	if err != nil {
+		svlog.Message("PIVOT", response.ErrorTimestampSource.Code, err.Error())
+		return response.ErrorTimestampSource
+	}
+	assertionHeaders["timestamp"] = signingTime.Format(time.RFC3339)
 
 	// Sign the assertion with the snapd assertions module
 	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, assertionHeaders, assertion.Body(), substore.FromModel.BrandID, substore.FromModel.KeyID, substore.FromModel.SealedKey)
@@ -156,6 +163,9 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
+	// Record the pivot in the history of the device
+	recordPivot(r.Context(), substore, assertion.HeaderString("serial"), assertion.HeaderString("model"))
+
 	// Add the account assertion to the assertions list
 	fetchAssertionFromStore(&assertions, asserts.AccountType, []string{substore.FromModel.BrandID})
 
@@ -173,16 +183,24 @@ func SerialAssertion(w http.ResponseWriter, r *http.Request) response.ErrorRespo
 
 func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorResponse) {
 	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
+	k, err := request.CheckModelAPI(r)
 	if err != nil {
 		svlog.Message("PIVOT", "invalid-api-key", "Invalid API key used")
 		return nil, response.ErrorInvalidAPIKey
 	}
+	audit.DeprecatedAPIKey(r.Context(), k)
 
 	defer r.Body.Close()
 
+	// The body is kept to check the signature of the request
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		svlog.Message("PIVOT", "invalid-assertion", err.Error())
+		return nil, response.ErrorResponse{Success: false, Code: "decode-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
 	// Get the serial assertion from the body
-	dec := asserts.NewDecoder(r.Body)
+	dec := asserts.NewDecoder(bytes.NewReader(body))
 	assertion, err := dec.Decode()
 	if err == io.EOF {
 		svlog.Message("PIVOT", "invalid-assertion", "No data supplied for pivot")
@@ -199,28 +217,64 @@ func parseSerialAssertion(r *http.Request) (asserts.Assertion, response.ErrorRes
 		return nil, response.ErrorInvalidType
 	}
 
+	// Check the signature of the request, when the account signs its requests
+	if err := request.CheckSignature(r, body, assertion.HeaderString("brand-id")); err != nil {
+		return nil, response.ErrorInvalidSignature
+	}
+
 	return assertion, response.ErrorResponse{Success: true}
 }
 
-func findModelPivot(brand, modelName, serial, apiKey string) (datastore.Substore, response.ErrorResponse) {
+// findModelPivot finds the sub-store model that the device pivots to. The device can be on the original
+// model, or on a model that it was pivoted to, so the pivots of the original model are followed
+func findModelPivot(ctx context.Context, brand, modelName, serial, apiKey string) (datastore.Substore, response.ErrorResponse) {
 	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(brand, modelName, apiKey)
+	model, err := datastore.Environ.DB.FindModel(ctx, brand, modelName, apiKey, datastore.ScopeReseller)
 	if err != nil {
-		svlog.Message("PIVOT", "invalid-model", "Cannot find model with the matching brand and model")
-		return datastore.Substore{}, response.ErrorInvalidModel
+		// Check for the original model of a pivoted device
+		pivoted, errSub := datastore.Environ.DB.GetSubstoreModel(ctx, brand, modelName, serial)
+		if errSub != nil || (pivoted.FromModel.APIKey != apiKey && !datastore.Environ.DB.CheckAccountAPIKey(ctx, apiKey, brand, datastore.ScopeReseller)) {
+			svlog.Message("PIVOT", "invalid-model", "Cannot find model with the matching brand and model")
+			return datastore.Substore{}, response.ErrorInvalidModel
+		}
+		model = pivoted.FromModel
+	} else {
+		audit.DeprecatedModelKey(ctx, model)
 	}
 
 	// Check for a sub-store model for the pivot
-	substore, err := datastore.Environ.DB.GetSubstore(model.ID, serial)
+	substore, err := datastore.Environ.DB.GetSubstore(ctx, model.ID, serial)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		svlog.Message("PIVOT", "invalid-substore", "Cannot find sub-store mapping for the model")
 		return substore, response.ErrorInvalidSubstore
 	}
 
+	// The device is already on the last pivot of the model
+	if substore.ModelName == modelName {
+		svlog.Message("PIVOT", "invalid-substore", "The device has been pivoted to the sub-store model")
+		return substore, response.ErrorInvalidSubstore
+	}
+
 	return substore, response.ErrorResponse{Success: true}
 }
 
+// recordPivot records the pivot of a device from its current model to the sub-store model
+func recordPivot(ctx context.Context, substore datastore.Substore, serialNumber, currentModel string) {
+	p := datastore.SubstorePivot{
+		SubstoreID:   substore.ID,
+		FromModelID:  substore.FromModelID,
+		SerialNumber: serialNumber,
+		ModelName:    substore.ModelName,
+	}
+	if currentModel != substore.FromModel.Name {
+		p.FromModelName = currentModel
+	}
+	if err := datastore.Environ.DB.CreateSubstorePivot(ctx, p); err != nil {
+		log.Error(err)
+	}
+}
+
 func formatPivotResponse(success bool, message string, store datastore.Substore, w http.ResponseWriter) error {
 	response := Response{Success: success, ErrorMessage: message, Pivot: store}
 	return jsonEncode(response, w)
@@ -230,7 +284,7 @@ func jsonEncode(response interface{}, w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the JSON response.")
+		log.Error("Error forming the JSON response.")
 		return err
 	}
 	return nil
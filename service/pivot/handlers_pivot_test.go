@@ -21,11 +21,15 @@ package pivot_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
 
@@ -33,6 +37,8 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/pivot"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/snapcore/snapd/asserts"
 	check "gopkg.in/check.v1"
 )
@@ -157,6 +163,80 @@ func (s *PivotSuite) TestPivotSystemUserAssertionHandler(c *check.C) {
 	}
 }
 
+// signedMockDB mocks the database for the "generic" account that signs its reseller requests
+type signedMockDB struct {
+	datastore.MockDB
+	used map[string]bool
+}
+
+func (mdb *signedMockDB) GetAccountHMACSecret(ctx context.Context, authorityID string) (string, error) {
+	if authorityID != "generic" {
+		return mdb.MockDB.GetAccountHMACSecret(ctx, authorityID)
+	}
+	return "ResellerHMACSecret", nil
+}
+
+func (mdb *signedMockDB) UseRequestSignature(ctx context.Context, signature string, expires time.Time) error {
+	if mdb.used[signature] {
+		return errors.New("the request signature has already been used")
+	}
+	mdb.used[signature] = true
+	return nil
+}
+
+func (s *PivotSuite) TestPivotSignedRequest(c *check.C) {
+	datastore.Environ.DB = &signedMockDB{used: map[string]bool{}}
+	defer func() { datastore.Environ.DB = &datastore.MockDB{} }()
+
+	now := strconv.FormatInt(time.Now().Unix(), 10)
+	expired := strconv.FormatInt(time.Now().Add(-time.Hour).Unix(), 10)
+	body := []byte(pivot.SerialAssert)
+
+	tests := []struct {
+		url       string
+		secret    string
+		timestamp string
+		code      int
+	}{
+		{"/v1/pivot", "", "", 400},
+		{"/v1/pivot", "ResellerHMACSecret", now, 200},
+		{"/v1/pivot", "ResellerHMACSecret", now, 400},
+		{"/v1/pivot", "WrongHMACSecret", now, 400},
+		{"/v1/pivot", "ResellerHMACSecret", expired, 400},
+		{"/v1/pivotmodel", "ResellerHMACSecret", now, 200},
+		{"/v1/pivotserial", "ResellerHMACSecret", now, 200},
+		{"/v1/pivotserial", "ResellerHMACSecret", "", 400},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", t.url, bytes.NewReader(body))
+		r.Header.Set("api-key", "ValidAPIKey")
+		if len(t.secret) > 0 {
+			r.Header.Set(request.HeaderSignatureTimestamp, t.timestamp)
+			r.Header.Set(request.HeaderSignature, request.Signature(t.secret, "POST", t.url, t.timestamp, body))
+		}
+		service.SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s %s %s", t.url, t.secret, t.timestamp))
+
+		if t.code != 200 {
+			result := response.ErrorResponse{}
+			err := json.NewDecoder(w.Body).Decode(&result)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.Code, check.Equals, response.ErrorInvalidSignature.Code)
+		}
+	}
+
+	// The signature covers the path, so it cannot be used for another method
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/pivotmodel", bytes.NewReader(body))
+	r.Header.Set("api-key", "ValidAPIKey")
+	r.Header.Set(request.HeaderSignatureTimestamp, now)
+	r.Header.Set(request.HeaderSignature, request.Signature("ResellerHMACSecret", "POST", "/v1/pivotserial", now, body))
+	service.SigningRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func sendSigningRequest(method, url string, data io.Reader, apiKey string, c *check.C) *httptest.ResponseRecorder {
 	w := httptest.NewRecorder()
 	r, _ := http.NewRequest(method, url, data)
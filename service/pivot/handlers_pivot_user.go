@@ -21,12 +21,15 @@
 package pivot
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
@@ -36,15 +39,23 @@ import (
 // SystemUserAssertion is the API method to generate a system-user assertion for a pivoted model
 func SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
+	k, err := request.CheckModelAPI(r)
 	if err != nil {
 		log.Message("PIVOTUSER", "invalid-api-key", "Invalid API key used")
 		return response.ErrorInvalidAPIKey
 	}
+	audit.DeprecatedAPIKey(r.Context(), k)
+
+	// The body is kept to check the signature of the request
+	defer r.Body.Close()
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return response.ErrorResponse{Success: false, Code: "error-decode-json", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
 
 	// Decode the body
 	user := assertion.PivotSystemUserRequest{}
-	err = json.NewDecoder(r.Body).Decode(&user)
+	err = json.NewDecoder(bytes.NewReader(body)).Decode(&user)
 	switch {
 	// Check we have some data
 	case err == io.EOF:
@@ -54,7 +65,12 @@ func SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorR
 		return response.ErrorResponse{Success: false, Code: "error-decode-json", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
-	substore, errResponse := findModelPivot(user.Brand, user.ModelName, user.SerialNumber, r.Header.Get("api-key"))
+	// Check the signature of the request, when the account signs its requests
+	if err := request.CheckSignature(r, body, user.Brand); err != nil {
+		return response.ErrorInvalidSignature
+	}
+
+	substore, errResponse := findModelPivot(r.Context(), user.Brand, user.ModelName, user.SerialNumber, r.Header.Get("api-key"))
 	if !errResponse.Success {
 		return errResponse
 	}
@@ -64,7 +80,7 @@ func SystemUserAssertion(w http.ResponseWriter, r *http.Request) response.ErrorR
 	model.Name = substore.ModelName
 
 	// Generate the system-user assertion for the pivoted model
-	resp := assertion.GenerateSystemUserAssertion(user.SystemUserRequest, model)
+	resp := assertion.GenerateSystemUserAssertion(r.Context(), user.SystemUserRequest, model)
 	if !resp.Success {
 		return response.ErrorResponse{Success: false, Code: resp.ErrorCode, Message: resp.ErrorMessage, StatusCode: http.StatusBadRequest}
 	}
@@ -0,0 +1,63 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profile
+
+import (
+	"net/http"
+	"net/http/pprof"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// The pprof profiling handlers, for superusers only. The handlers are not on the default
+// mux of net/http, so they are only served on the admin service by these routes
+var (
+	// Index lists the profiles, and serves the named profiles e.g. /debug/pprof/heap
+	Index = superuserOnly(pprof.Index)
+	// Cmdline returns the command line of the service
+	Cmdline = superuserOnly(pprof.Cmdline)
+	// Profile captures a CPU profile for the duration in the seconds parameter
+	Profile = superuserOnly(pprof.Profile)
+	// Symbol looks up the program counters of a profile
+	Symbol = superuserOnly(pprof.Symbol)
+	// Trace captures an execution trace for the duration in the seconds parameter
+	Trace = superuserOnly(pprof.Trace)
+)
+
+// superuserOnly checks the JWT of the user is for a superuser before the handler is called
+func superuserOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		authUser, err := auth.GetUserFromJWT(w, r)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+			return
+		}
+
+		if err = auth.CheckUserPermissions(authUser, datastore.Superuser, false); err != nil {
+			response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+			return
+		}
+
+		handler(w, r)
+	}
+}
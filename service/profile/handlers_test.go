@@ -0,0 +1,104 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package profile_test
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestProfileSuite(t *testing.T) { check.TestingT(t) }
+
+type ProfileSuite struct{}
+
+var _ = check.Suite(&ProfileSuite{})
+
+func (s *ProfileSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+}
+
+func (s *ProfileSuite) TestProfileHandler(c *check.C) {
+	tests := []struct {
+		url         string
+		code        int
+		permissions int
+		enableAuth  bool
+		contains    string
+	}{
+		{"/debug/pprof/", 400, 0, false, "error-auth"},
+		{"/debug/pprof/", 400, datastore.Admin, true, "error-auth"},
+		{"/debug/pprof/heap?debug=1", 400, datastore.Admin, true, "error-auth"},
+		{"/debug/pprof/", 200, datastore.Superuser, true, "goroutine"},
+		{"/debug/pprof/goroutine?debug=1", 200, datastore.Superuser, true, "goroutine profile"},
+		{"/debug/pprof/cmdline", 200, datastore.Superuser, true, ""},
+		{"/debug/pprof/unknown", 404, datastore.Superuser, true, "Unknown profile"},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.EnableUserAuth = t.enableAuth
+
+		w := sendAdminRequest("GET", t.url, nil, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("URL: %s", t.url))
+		c.Assert(strings.Contains(w.Body.String(), t.contains), check.Equals, true)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func sendAdminRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
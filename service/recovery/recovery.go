@@ -0,0 +1,145 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package recovery
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"runtime/debug"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Event is the details of a panic, for the error reporting service
+type Event struct {
+	Time      time.Time
+	Message   string
+	Stack     string
+	Method    string
+	URL       string
+	RequestID string
+	TraceID   string
+}
+
+// Reporter forwards the panics to an error reporting service
+type Reporter interface {
+	Report(event Event)
+}
+
+// Middleware converts a panic of a handler into a 500 response, logging the stack trace with
+// the request ID and trace ID of the request, and forwarding it to the reporter, if there is one
+func Middleware(reporter Reporter) mux.MiddlewareFunc {
+	return func(inner http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			rw := &responseTracker{ResponseWriter: w}
+
+			defer func() {
+				p := recover()
+				if p == nil {
+					return
+				}
+				// Aborting the response is not an error, so keep the behaviour of the http server
+				if p == http.ErrAbortHandler {
+					panic(p)
+				}
+
+				// The serial numbers and key IDs of the request are masked, as for the request logs
+				path := log.MaskPath(r.URL.Path, mux.Vars(r))
+				url := path
+				if query := log.MaskQuery(r.URL.Query()); len(query) > 0 {
+					url += "?" + query
+				}
+
+				event := Event{
+					Time:    time.Now().UTC(),
+					Message: fmt.Sprintf("panic: %v", p),
+					Stack:   string(debug.Stack()),
+					Method:  r.Method,
+					URL:     url,
+					// The request ID is set on the response by the logging middleware of the route
+					RequestID: w.Header().Get("X-Request-ID"),
+				}
+				span := trace.SpanFromContext(r.Context())
+				if sc := span.SpanContext(); sc.HasTraceID() {
+					event.TraceID = sc.TraceID().String()
+				}
+				span.RecordError(fmt.Errorf("%s", event.Message))
+
+				fields := log.Fields{"method": event.Method, "path": path, "stack": event.Stack}
+				if len(event.RequestID) > 0 {
+					fields[log.FieldRequestID] = event.RequestID
+				}
+				if len(event.TraceID) > 0 {
+					fields["trace-id"] = event.TraceID
+				}
+				log.New("recovery").WithFields(fields).Errorf("Recovered from a %s", event.Message)
+
+				if reporter != nil {
+					reporter.Report(event)
+				}
+
+				// The response cannot be changed once it is started
+				if !rw.wroteHeader {
+					writeError(rw)
+				}
+			}()
+
+			inner.ServeHTTP(rw, r)
+		})
+	}
+}
+
+// writeError writes the 500 response in the format of the API errors
+func writeError(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	w.WriteHeader(response.ErrorInternal.StatusCode)
+	if err := json.NewEncoder(w).Encode(response.ErrorInternal); err != nil {
+		log.Errorf("Error forming the internal error response: %v", err)
+	}
+}
+
+// responseTracker records if the response has been started
+type responseTracker struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (rw *responseTracker) WriteHeader(status int) {
+	rw.wroteHeader = true
+	rw.ResponseWriter.WriteHeader(status)
+}
+
+func (rw *responseTracker) Write(b []byte) (int, error) {
+	rw.wroteHeader = true
+	return rw.ResponseWriter.Write(b)
+}
+
+// Flush keeps the streamed responses working
+func (rw *responseTracker) Flush() {
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
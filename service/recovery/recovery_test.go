@@ -0,0 +1,136 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package recovery
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+type mockReporter struct {
+	events []Event
+}
+
+func (m *mockReporter) Report(event Event) {
+	m.events = append(m.events, event)
+}
+
+func TestMiddlewarePanic(t *testing.T) {
+	reporter := &mockReporter{}
+	handler := Middleware(reporter)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Request-ID", "factory-42")
+		panic("nil model")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("POST", "/v1/serial", nil))
+
+	if w.Code != http.StatusInternalServerError {
+		t.Errorf("Expected code 500, got %d", w.Code)
+	}
+	result := response.ErrorResponse{}
+	if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+		t.Fatalf("Error decoding the response: %v", err)
+	}
+	if result.Success || result.Code != response.ErrorInternal.Code {
+		t.Errorf("Expected the internal error response, got: %v", result)
+	}
+
+	if len(reporter.events) != 1 {
+		t.Fatalf("Expected one reported event, got %d", len(reporter.events))
+	}
+	event := reporter.events[0]
+	if event.Message != "panic: nil model" || event.RequestID != "factory-42" || event.Method != "POST" || len(event.Stack) == 0 {
+		t.Errorf("Unexpected reported event: %v", event)
+	}
+}
+
+func TestMiddlewarePanicMasked(t *testing.T) {
+	saved := log.GetPolicy()
+	defer log.SetPolicy(saved)
+	log.SetPolicy(log.Policy{MaskSerials: true})
+
+	reporter := &mockReporter{}
+	router := mux.NewRouter()
+	router.Use(Middleware(reporter))
+	router.HandleFunc("/v1/devices/{serial}", func(w http.ResponseWriter, r *http.Request) {
+		panic("nil model")
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/devices/A123456L?serial=A123456L", nil))
+
+	if len(reporter.events) != 1 {
+		t.Fatalf("Expected one reported event, got %d", len(reporter.events))
+	}
+	if url := reporter.events[0].URL; url != "/v1/devices/****456L?serial=%2A%2A%2A%2A456L" {
+		t.Errorf("Expected the serial numbers to be masked, got: %s", url)
+	}
+}
+
+func TestMiddlewarePanicAfterResponse(t *testing.T) {
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		panic("late")
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/keypairs", nil))
+
+	// The started response is not changed
+	if w.Code != http.StatusAccepted {
+		t.Errorf("Expected code 202, got %d", w.Code)
+	}
+	if w.Body.Len() > 0 {
+		t.Errorf("Expected no error response, got: %s", w.Body.String())
+	}
+}
+
+func TestMiddlewareAbort(t *testing.T) {
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic(http.ErrAbortHandler)
+	}))
+
+	defer func() {
+		if p := recover(); p != http.ErrAbortHandler {
+			t.Errorf("Expected the abort to be re-raised, got: %v", p)
+		}
+	}()
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/v1/serial", nil))
+}
+
+func TestMiddlewareNoPanic(t *testing.T) {
+	handler := Middleware(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("OK"))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest("GET", "/v1/version", nil))
+
+	if w.Code != http.StatusOK || w.Body.String() != "OK" {
+		t.Errorf("Expected the handler response, got %d: %s", w.Code, w.Body.String())
+	}
+}
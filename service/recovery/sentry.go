@@ -0,0 +1,171 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package recovery
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// sentryTimeout is the longest time to send an event to Sentry
+const sentryTimeout = 10 * time.Second
+
+// Sentry reports the panics to Sentry, using the store API of the project in the DSN
+type Sentry struct {
+	storeURL string
+	auth     string
+	release  string
+	client   *http.Client
+}
+
+// sentryEvent is the JSON event of the Sentry store API
+type sentryEvent struct {
+	EventID    string            `json:"event_id"`
+	Timestamp  string            `json:"timestamp"`
+	Level      string            `json:"level"`
+	Platform   string            `json:"platform"`
+	Logger     string            `json:"logger"`
+	Release    string            `json:"release,omitempty"`
+	ServerName string            `json:"server_name,omitempty"`
+	Message    string            `json:"message"`
+	Request    sentryRequest     `json:"request"`
+	Tags       map[string]string `json:"tags,omitempty"`
+	Extra      map[string]string `json:"extra,omitempty"`
+}
+
+type sentryRequest struct {
+	Method string `json:"method"`
+	URL    string `json:"url"`
+}
+
+// NewReporter returns the reporter of the panics from the config settings, or nil when
+// the error reporting is not configured
+func NewReporter(settings config.Settings) (Reporter, error) {
+	if len(settings.SentryDSN) == 0 {
+		return nil, nil
+	}
+	s, err := NewSentry(settings.SentryDSN, settings.Version)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewSentry creates the Sentry reporter from the DSN e.g. https://public-key@sentry.example.com/42
+func NewSentry(dsn, release string) (*Sentry, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Sentry DSN: %v", err)
+	}
+	if u.User == nil || len(u.User.Username()) == 0 {
+		return nil, fmt.Errorf("the Sentry DSN must have the public key of the project")
+	}
+
+	// The project ID is the last element of the path
+	path := strings.TrimSuffix(u.Path, "/")
+	i := strings.LastIndex(path, "/")
+	if i < 0 || len(path[i+1:]) == 0 {
+		return nil, fmt.Errorf("the Sentry DSN must have the ID of the project")
+	}
+
+	auth := fmt.Sprintf("Sentry sentry_version=7, sentry_client=serial-vault/%s, sentry_key=%s", release, u.User.Username())
+	if secret, ok := u.User.Password(); ok {
+		auth += ", sentry_secret=" + secret
+	}
+
+	return &Sentry{
+		storeURL: fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, path[:i], path[i+1:]),
+		auth:     auth,
+		release:  release,
+		client:   &http.Client{Timeout: sentryTimeout},
+	}, nil
+}
+
+// Report sends the event to Sentry in the background, so the response is not delayed
+func (s *Sentry) Report(event Event) {
+	go func() {
+		if err := s.send(event); err != nil {
+			log.Errorf("Error reporting the panic to Sentry: %v", err)
+		}
+	}()
+}
+
+func (s *Sentry) send(event Event) error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	hostname, _ := os.Hostname()
+
+	e := sentryEvent{
+		EventID:    hex.EncodeToString(id),
+		Timestamp:  event.Time.Format("2006-01-02T15:04:05"),
+		Level:      "error",
+		Platform:   "go",
+		Logger:     "recovery",
+		Release:    s.release,
+		ServerName: hostname,
+		Message:    event.Message,
+		Request:    sentryRequest{Method: event.Method, URL: event.URL},
+		Tags:       map[string]string{},
+		Extra:      map[string]string{"stack": event.Stack},
+	}
+	if len(event.RequestID) > 0 {
+		e.Tags["request_id"] = event.RequestID
+	}
+	if len(event.TraceID) > 0 {
+		e.Tags["trace_id"] = event.TraceID
+	}
+
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", s.storeURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", s.auth)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("the event was not accepted by Sentry: %s", resp.Status)
+	}
+	return nil
+}
@@ -20,32 +20,200 @@
 package request
 
 import (
+	"context"
+	"database/sql"
 	"errors"
+	"fmt"
 	"net/http"
+	"strings"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/lockout"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
+	"github.com/CanonicalLtd/serial-vault/usso"
 )
 
-// CheckUserAPI validates the user and API key
+// CheckUserAPI validates the user and API key, or the bearer token of a service account
+// or the personal access token of a user
 func CheckUserAPI(r *http.Request) (datastore.User, error) {
 	// Get the user and API key from the header
 	username := r.Header.Get("user")
 	apiKey := r.Header.Get("api-key")
 
+	// Repeated failures lock out the source IP, and the username from the source IP
+	sourceIP := lockout.SourceIP(r)
+	if err := lockout.Check(r.Context(), username, sourceIP); err != nil {
+		return datastore.User{}, err
+	}
+
+	user, err := checkUserCredentials(r, username, apiKey)
+	if err != nil {
+		lockout.Failure(r.Context(), lockout.ObjectAPIKey, username, sourceIP)
+		return user, err
+	}
+	lockout.Success(r.Context(), username, sourceIP)
+	return user, nil
+}
+
+func checkUserCredentials(r *http.Request, username, apiKey string) (datastore.User, error) {
+	if len(apiKey) == 0 {
+		if token := BearerToken(r); datastore.IsAccessToken(token) {
+			return CheckAccessToken(r, token)
+		} else if len(token) > 0 {
+			return checkServiceToken(r, token)
+		}
+	}
+
 	// Find the user by API key
-	return datastore.Environ.DB.GetUserByAPIKey(apiKey, username)
+	return datastore.Environ.DB.GetUserByAPIKey(r.Context(), apiKey, username)
 }
 
-// CheckModelAPI the API key header to make sure it is an allowed header
-func CheckModelAPI(r *http.Request) (string, error) {
-	apiKey := r.Header.Get("api-key")
+// CheckModelAPI the API key header to make sure it is an allowed header. The key is returned
+// with the named API key of the account that it matched, if any
+func CheckModelAPI(r *http.Request) (datastore.APIKey, error) {
+	return CheckClientAPIKey(r.Context(), r.Header.Get("api-key"), lockout.SourceIP(r))
+}
+
+// CheckClientAPIKey checks the API key of a model from the source IP of the client. The invalid
+// keys from the source IP are locked out after repeated failures, but a valid key is always let
+// through, so a misconfigured device cannot stop the signing of the other devices behind the
+// same address. The lockout is only read after a failure, to keep it off the signing path. A
+// named API key of an account is only accepted from its allowed networks, and is returned with
+// the key
+func CheckClientAPIKey(ctx context.Context, apiKey, sourceIP string) (datastore.APIKey, error) {
+	if err := CheckModelAPIKey(ctx, apiKey); err != nil {
+		if errLocked := lockout.Check(ctx, "", sourceIP); errLocked != nil {
+			return datastore.APIKey{Key: apiKey}, errLocked
+		}
+		lockout.Failure(ctx, lockout.ObjectAPIKey, "", sourceIP)
+		return datastore.APIKey{Key: apiKey}, err
+	}
+	return checkAPIKeySource(ctx, apiKey, sourceIP)
+}
+
+// ErrSourceNotAllowed is returned when an API key is used from outside of its allowed networks
+var ErrSourceNotAllowed = errors.New("The API key cannot be used from this address")
+
+// checkAPIKeySource checks that a named API key of an account is used from one of its allowed
+// networks. The violations are logged and counted in the metrics, so they can be alerted on
+func checkAPIKeySource(ctx context.Context, apiKey, sourceIP string) (datastore.APIKey, error) {
+	k, err := datastore.Environ.DB.FindAccountAPIKey(ctx, apiKey)
+	if err == sql.ErrNoRows {
+		// The API key of a model has no allowed networks
+		return datastore.APIKey{Key: apiKey}, nil
+	}
+	k.Key = apiKey
+	if err != nil {
+		log.Message("APIKEY", "check-source", err.Error())
+		return k, ErrSourceNotAllowed
+	}
+
+	if !k.AllowsSource(sourceIP) {
+		log.Message("APIKEY", "source-not-allowed", fmt.Sprintf("API key '%s' of %s used from %s", k.Name, k.AuthorityID, sourceIP))
+		metric.APIKeySourceDeniedCounterVec.WithLabelValues(k.AuthorityID, k.Name).Inc()
+		return k, ErrSourceNotAllowed
+	}
+	return k, nil
+}
+
+// CheckModelAPIKey checks that the API key is one that is allowed for a model
+func CheckModelAPIKey(ctx context.Context, apiKey string) error {
 	if len(apiKey) == 0 {
-		return apiKey, errors.New("Blank API key used")
+		return errors.New("Blank API key used")
 	}
 
-	if ok := datastore.Environ.DB.CheckAPIKey(apiKey); !ok {
-		return apiKey, errors.New("Unauthorized API key used")
+	if ok := datastore.Environ.DB.CheckAPIKey(ctx, apiKey); !ok {
+		return errors.New("Unauthorized API key used")
 	}
 
-	return apiKey, nil
+	return nil
+}
+
+// checkServiceToken validates the token of a service account and its scope for the route,
+// and returns the user of the service account
+func checkServiceToken(r *http.Request, token string) (datastore.User, error) {
+	return serviceTokenUser(r.Context(), token, apiResource(r.URL.Path), r.Method)
+}
+
+// CheckAccessToken validates the personal access token of a user and its scope for the
+// route, and returns the user with the role from the database
+func CheckAccessToken(r *http.Request, token string) (datastore.User, error) {
+	return accessTokenUser(r.Context(), token, apiResource(r.URL.Path), r.Method)
+}
+
+// CheckBearerToken validates the token of a service account or the personal access token
+// of a user, and its scope for the resource and method, for the services that are not
+// called over HTTP routes. The method is the HTTP method of the equivalent route
+func CheckBearerToken(ctx context.Context, token, resource, method string) (datastore.User, error) {
+	if len(token) == 0 {
+		return datastore.User{}, errors.New("The bearer token must be provided")
+	}
+	if datastore.IsAccessToken(token) {
+		return accessTokenUser(ctx, token, resource, method)
+	}
+	return serviceTokenUser(ctx, token, resource, method)
+}
+
+func serviceTokenUser(ctx context.Context, token, resource, method string) (datastore.User, error) {
+	sa, err := usso.VerifyServiceToken(token)
+	if err != nil {
+		return datastore.User{}, err
+	}
+
+	// The service account is checked, so a disabled account cannot use its tokens
+	if !datastore.Environ.DB.CheckServiceAccount(ctx, sa.ClientID) {
+		return datastore.User{}, errors.New("The service account is not active")
+	}
+
+	if !datastore.AdminScopeAllows(sa.Scopes, resource, method) {
+		return datastore.User{}, errors.New("The service token does not have the scope of the API method")
+	}
+
+	return activeUser(ctx, sa.Username)
+}
+
+func accessTokenUser(ctx context.Context, token, resource, method string) (datastore.User, error) {
+	t, err := datastore.Environ.DB.AuthenticateAccessToken(ctx, token)
+	if err != nil {
+		return datastore.User{}, err
+	}
+
+	if !datastore.AdminScopeAllows(t.Scopes, resource, method) {
+		return datastore.User{}, errors.New("The access token does not have the scope of the API method")
+	}
+
+	return activeUser(ctx, t.Username)
+}
+
+// activeUser returns the user of a token, which is rejected when the user is deactivated
+func activeUser(ctx context.Context, username string) (datastore.User, error) {
+	user, err := datastore.Environ.DB.GetUserByUsername(ctx, username)
+	if err != nil {
+		return user, err
+	}
+	if !user.Active {
+		return datastore.User{}, errors.New("The user is not active")
+	}
+	return user, nil
+}
+
+// BearerToken returns the bearer token of the authorization header
+func BearerToken(r *http.Request) string {
+	authorization := r.Header.Get("Authorization")
+	if !strings.HasPrefix(authorization, "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+}
+
+// apiResource returns the resource of an admin API or web API route, e.g. models for
+// /api/models/1 or /v1/models/1. The SCIM routes are a single resource
+func apiResource(path string) string {
+	if strings.HasPrefix(path, "/scim/") {
+		return datastore.AdminScopeSCIM
+	}
+	path = strings.TrimPrefix(path, "/api/")
+	path = strings.TrimPrefix(path, "/v1/")
+	return strings.Split(path, "/")[0]
 }
@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2016-2017 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package request
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The headers of the signature of a reseller API request
+const (
+	HeaderSignature          = "X-Signature"
+	HeaderSignatureTimestamp = "X-Signature-Timestamp"
+)
+
+// signatureWindow is the time either side of the timestamp of a signed request that it is
+// accepted. The used signatures are kept for the window, so they cannot be replayed
+const signatureWindow = 5 * time.Minute
+
+// ErrInvalidSignature is returned when a reseller API request is not signed with the HMAC
+// secret of the account, or the signature has expired or already been used
+var ErrInvalidSignature = errors.New("The request signature is missing or invalid")
+
+// Signature returns the HMAC-SHA256 signature of a request, in hex. The signature covers the
+// method, the path, the Unix timestamp and the SHA-256 hash of the body, separated by newlines
+func Signature(secret, method, path, timestamp string, body []byte) string {
+	hash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s\n%s\n%s\n%s", method, path, timestamp, hex.EncodeToString(hash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// CheckSignature checks the signature of a reseller API request, when the account has an HMAC
+// secret. The request must be signed within the window of its timestamp, and only once
+func CheckSignature(r *http.Request, body []byte, authorityID string) error {
+	secret, err := datastore.Environ.DB.GetAccountHMACSecret(r.Context(), authorityID)
+	if err == sql.ErrNoRows {
+		// The requests of the account are authenticated by the API key only
+		return nil
+	}
+	if err != nil {
+		log.Message("SIGNATURE", "check-signature", err.Error())
+		return ErrInvalidSignature
+	}
+
+	timestamp := r.Header.Get(HeaderSignatureTimestamp)
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		log.Message("SIGNATURE", "invalid-signature", fmt.Sprintf("Missing or invalid signature timestamp for %s", authorityID))
+		return ErrInvalidSignature
+	}
+	signed := time.Unix(seconds, 0)
+	if age := time.Since(signed); age > signatureWindow || age < -signatureWindow {
+		log.Message("SIGNATURE", "invalid-signature", fmt.Sprintf("Expired signature timestamp for %s", authorityID))
+		return ErrInvalidSignature
+	}
+
+	signature := r.Header.Get(HeaderSignature)
+	expected := Signature(secret, r.Method, r.URL.Path, timestamp, body)
+	if !hmac.Equal([]byte(signature), []byte(expected)) {
+		log.Message("SIGNATURE", "invalid-signature", fmt.Sprintf("Invalid request signature for %s", authorityID))
+		return ErrInvalidSignature
+	}
+
+	// The signature is only accepted once, until its timestamp is outside of the window
+	if err := datastore.Environ.DB.UseRequestSignature(r.Context(), signature, signed.Add(signatureWindow)); err != nil {
+		log.Message("SIGNATURE", "replayed-signature", fmt.Sprintf("%s: %v", authorityID, err))
+		return ErrInvalidSignature
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package request
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Version returns the version of a record that an update is based on: the entity tag of the
+// If-Match header, or else the version of the record in the request body. Zero when there is
+// no valid version
+func Version(r *http.Request, bodyVersion int) int {
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if len(match) == 0 {
+		return bodyVersion
+	}
+
+	// The version is a strong entity tag, as set by the responses
+	version, err := strconv.Atoi(strings.Trim(match, `"`))
+	if err != nil || version < 0 {
+		return 0
+	}
+	return version
+}
@@ -56,13 +56,29 @@ var (
 	ErrorFetchKeypair              = ErrorResponse{false, "fetch-keypair", "", "Error fetching the signing-key", http.StatusBadRequest}
 	ErrorStoreKeypair              = ErrorResponse{false, "store-keypair", "", "Error string the signing-key", http.StatusBadRequest}
 	ErrorEmptySerial               = ErrorResponse{false, "create-assertion", "", "The serial number is missing from both the header and body", http.StatusBadRequest}
+	ErrorInvalidSerialFormat       = ErrorResponse{false, "invalid-serial-format", "", "The serial number does not match the serial number format of the model", http.StatusBadRequest}
 	ErrorCreateAssertion           = ErrorResponse{false, "create-assertion", "", "Error converting the serial-request to a serial assertion", http.StatusBadRequest}
+	ErrorTimestampSource           = ErrorResponse{false, "timestamp-source", "", "Error getting the assertion timestamp from the time source", http.StatusBadRequest}
 	ErrorDecodeAssertion           = ErrorResponse{false, "decode-assertion", "", "Error decoding the assertion", http.StatusBadRequest}
 	ErrorCheckAssertion            = ErrorResponse{false, "duplicate-assertion", "", "Error checking the serial-request. Please try again later", http.StatusBadRequest}
 	ErrorCreateModelAssertion      = ErrorResponse{false, "create-assertion", "", "Error with the model assertion headers", http.StatusBadRequest}
 	ErrorCreateSystemUserAssertion = ErrorResponse{false, "create-assertion", "", "Error with the system-user assertion", http.StatusBadRequest}
+	ErrorSystemUserBatch           = ErrorResponse{false, "system-user-batch", "", "Error with the batch of system-user assertions", http.StatusBadRequest}
+	ErrorCreateValidationSet       = ErrorResponse{false, "create-assertion", "", "Error with the validation-set assertion headers", http.StatusBadRequest}
+	ErrorInactiveKeypair           = ErrorResponse{false, "inactive-keypair", "", "The signing-key is not active", http.StatusBadRequest}
 	ErrorDuplicateAssertion        = ErrorResponse{false, "duplicate-assertion", "", "The serial number and/or device-key have already been used to sign a device", http.StatusBadRequest}
 	ErrorAccountAssertion          = ErrorResponse{false, "account-assertion", "", "Error retrieving the account assertion from the database", http.StatusBadRequest}
 	ErrorSignAssertion             = ErrorResponse{false, "signing-assertion", "", "Error signing the assertion", http.StatusBadRequest}
 	ErrorGenerateNonce             = ErrorResponse{false, "generate-nonce", "", "Error generating a nonce. Please try again later", http.StatusBadRequest}
+	ErrorCheckQuota                = ErrorResponse{false, "check-quota", "", "Error checking the signing quota of the account. Please try again later", http.StatusBadRequest}
+	ErrorQuotaExceeded             = ErrorResponse{false, "quota-exceeded", "", "The signing quota of the account has been exceeded", http.StatusBadRequest}
+	ErrorInternal                  = ErrorResponse{false, "internal-error", "", "An unexpected error occurred. Please try again later", http.StatusInternalServerError}
+	ErrorLockedOut                 = ErrorResponse{false, "locked-out", "", "Too many failed authentication attempts. Please try again later", http.StatusTooManyRequests}
+	ErrorSourceNotAllowed          = ErrorResponse{false, "source-not-allowed", "", "The API key cannot be used from this address", http.StatusForbidden}
+	ErrorOutsideSigningHours       = ErrorResponse{false, "outside-signing-hours", "", "The model cannot be signed at this time", http.StatusForbidden}
+	ErrorModelSourceNotAllowed     = ErrorResponse{false, "model-source-not-allowed", "", "The model cannot be signed from this address", http.StatusForbidden}
+	ErrorClientCertNotAllowed      = ErrorResponse{false, "client-cert-not-allowed", "", "The client certificate is not allowed for the account", http.StatusForbidden}
+	ErrorInvalidSignature          = ErrorResponse{false, "invalid-signature", "", "The request signature is missing or invalid", http.StatusBadRequest}
+	ErrorVersionRequired           = ErrorResponse{false, "version-required", "", "The version of the record must be given in the If-Match header or the request body", http.StatusPreconditionRequired}
+	ErrorVersionConflict           = ErrorResponse{false, "version-conflict", "", "The record has been changed since it was read. Reload it and try again", http.StatusConflict}
 )
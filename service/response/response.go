@@ -23,6 +23,7 @@ import (
 	"encoding/json"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
 )
@@ -49,12 +50,31 @@ func FormatStandardResponse(success bool, errorCode, errorSubcode, message strin
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error forming the boolean response (%v)\n. %v", response, err)
+		log.Errorf("Error forming the boolean response (%v)\n. %v", response, err)
 		return err
 	}
 	return nil
 }
 
+// FormatErrorResponse returns the JSON response of an error, with the status code of the error
+func FormatErrorResponse(e ErrorResponse, w http.ResponseWriter) error {
+	w.Header().Set("Content-Type", JSONHeader)
+	w.WriteHeader(e.StatusCode)
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(e); err != nil {
+		log.Errorf("Error forming the error response (%v)\n. %v", e, err)
+		return err
+	}
+	return nil
+}
+
+// SetVersion sets the version of a record as the entity tag of the response, so the record
+// can be updated with the If-Match header
+func SetVersion(w http.ResponseWriter, version int) {
+	w.Header().Set("ETag", strconv.Quote(strconv.Itoa(version)))
+}
+
 // ParseStandardResponse parses the response body and returns a standard response object
 func ParseStandardResponse(w *httptest.ResponseRecorder) (StandardResponse, error) {
 	// Check the JSON response
@@ -28,13 +28,23 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/account"
 	"github.com/CanonicalLtd/serial-vault/service/app"
 	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/core"
+	"github.com/CanonicalLtd/serial-vault/service/dashboard"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/service/jobs"
 	"github.com/CanonicalLtd/serial-vault/service/keypair"
+	"github.com/CanonicalLtd/serial-vault/service/logging"
 	"github.com/CanonicalLtd/serial-vault/service/metric"
 	"github.com/CanonicalLtd/serial-vault/service/model"
 	"github.com/CanonicalLtd/serial-vault/service/pivot"
+	"github.com/CanonicalLtd/serial-vault/service/profile"
+	"github.com/CanonicalLtd/serial-vault/service/scim"
+	"github.com/CanonicalLtd/serial-vault/service/search"
+	"github.com/CanonicalLtd/serial-vault/service/serviceaccount"
 	"github.com/CanonicalLtd/serial-vault/service/sign"
 	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	"github.com/CanonicalLtd/serial-vault/service/spec"
 	"github.com/CanonicalLtd/serial-vault/service/status"
 	"github.com/CanonicalLtd/serial-vault/service/store"
 	"github.com/CanonicalLtd/serial-vault/service/substore"
@@ -48,9 +58,11 @@ import (
 func SigningRouter() *mux.Router {
 	// Start the web service router
 	router := mux.NewRouter()
+	useMiddlewares(router)
 
 	router.Handle("/v1/version", Middleware(http.HandlerFunc(core.Version))).Methods("GET")
 	router.Handle("/v1/health", Middleware(http.HandlerFunc(core.Health))).Methods("GET")
+	router.Handle("/v1/spec", Middleware(spec.Handler(router, signingSpec()))).Methods("GET")
 
 	// API routes
 	router.Handle("/v1/serial", metric.CollectAPIStats("signSerial",
@@ -85,6 +97,8 @@ func SigningRouter() *mux.Router {
 	router.Handle("/_status/metrics", metric.NewServer()).Methods("GET")
 	// status endpoints
 	status.AddStatusEndpoints("/_status", router)
+	// liveness and readiness probes
+	status.AddProbeEndpoints(router)
 
 	return router
 }
@@ -93,9 +107,11 @@ func SigningRouter() *mux.Router {
 func AdminRouter() *mux.Router {
 	// Start the web service router
 	router := mux.NewRouter()
+	useMiddlewares(router)
 
 	router.Handle("/v1/version", Middleware(http.HandlerFunc(core.Version))).Methods("GET")
 	router.Handle("/v1/health", Middleware(http.HandlerFunc(core.Health))).Methods("GET")
+	router.Handle("/v1/spec", Middleware(spec.Handler(router, adminSpec()))).Methods("GET")
 
 	// API routes: csrf token and auth token
 	router.Handle("/v1/token", metric.CollectAPIStats("coreToken",
@@ -104,6 +120,9 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/authtoken", metric.CollectAPIStats("coreToken",
 		MiddlewareWithCSRF(http.HandlerFunc(core.Token)))).
 		Methods("GET")
+	router.Handle("/v1/token/refresh", metric.CollectAPIStats("coreTokenRefresh",
+		MiddlewareWithCSRF(http.HandlerFunc(core.TokenRefresh)))).
+		Methods("POST")
 
 	// API routes: models admin
 	router.Handle("/v1/models", metric.CollectAPIStats("modelList",
@@ -124,6 +143,24 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/models/{id:[0-9]+}", metric.CollectAPIStats("modelDelete",
 		MiddlewareWithCSRF(http.HandlerFunc(model.Delete)))).
 		Methods("DELETE")
+	router.Handle("/v1/models/{brand}/{name}", metric.CollectAPIStats("modelPut",
+		MiddlewareWithCSRF(http.HandlerFunc(model.Put)))).
+		Methods("PUT")
+	router.Handle("/v1/models/bulk", metric.CollectAPIStats("modelBulk",
+		MiddlewareWithCSRF(http.HandlerFunc(model.Bulk)))).
+		Methods("POST")
+	router.Handle("/v1/models/deleted", metric.CollectAPIStats("modelListDeleted",
+		MiddlewareWithCSRF(http.HandlerFunc(model.ListDeleted)))).
+		Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}/restore", metric.CollectAPIStats("modelRestore",
+		MiddlewareWithCSRF(http.HandlerFunc(model.Restore)))).
+		Methods("POST")
+	router.Handle("/v1/models/{id:[0-9]+}/history", metric.CollectAPIStats("modelHistory",
+		MiddlewareWithCSRF(http.HandlerFunc(model.History)))).
+		Methods("GET")
+	router.Handle("/v1/models/{id:[0-9]+}/apikey", metric.CollectAPIStats("modelRotateAPIKey",
+		MiddlewareWithCSRF(http.HandlerFunc(model.RotateAPIKey)))).
+		Methods("POST")
 
 	// API routes: signing-keys
 	router.Handle("/v1/keypairs", metric.CollectAPIStats("keypairList",
@@ -144,6 +181,9 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/keypairs/{id:[0-9]+}/enable", metric.CollectAPIStats("keypairEnable",
 		MiddlewareWithCSRF(http.HandlerFunc(keypair.Enable)))).
 		Methods("POST")
+	router.Handle("/v1/keypairs/card", metric.CollectAPIStats("keypairCard",
+		MiddlewareWithCSRF(http.HandlerFunc(keypair.Card)))).
+		Methods("POST")
 	router.Handle("/v1/keypairs/assertion", metric.CollectAPIStats("keypairAssertion",
 		MiddlewareWithCSRF(http.HandlerFunc(keypair.Assertion)))).
 		Methods("POST")
@@ -166,6 +206,45 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/signinglog", metric.CollectAPIStats("signinglogList",
 		MiddlewareWithCSRF(http.HandlerFunc(signinglog.List)))).
 		Methods("GET")
+	router.Handle("/v1/signinglog/search", metric.CollectAPIStats("signinglogSearch",
+		MiddlewareWithCSRF(http.HandlerFunc(signinglog.Search)))).
+		Methods("GET")
+	router.Handle("/v1/signinglog/export", metric.CollectAPIStats("signinglogExport",
+		MiddlewareWithCSRF(http.HandlerFunc(signinglog.Export)))).
+		Methods("GET")
+	router.Handle("/v1/signinglog/purges", metric.CollectAPIStats("signinglogPurges",
+		MiddlewareWithCSRF(http.HandlerFunc(signinglog.Purges)))).
+		Methods("GET")
+	router.Handle("/v1/logging", metric.CollectAPIStats("loggingGet",
+		MiddlewareWithCSRF(http.HandlerFunc(logging.Get)))).
+		Methods("GET")
+	router.Handle("/v1/logging", metric.CollectAPIStats("loggingUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(logging.Update)))).
+		Methods("PUT")
+	router.Handle("/v1/admin/loglevel", metric.CollectAPIStats("loggingGetLevel",
+		MiddlewareWithCSRF(http.HandlerFunc(logging.GetLevel)))).
+		Methods("GET")
+	router.Handle("/v1/admin/loglevel", metric.CollectAPIStats("loggingUpdateLevel",
+		MiddlewareWithCSRF(http.HandlerFunc(logging.UpdateLevel)))).
+		Methods("PUT")
+	router.Handle("/v1/jobs", metric.CollectAPIStats("jobsList",
+		MiddlewareWithCSRF(http.HandlerFunc(jobs.List)))).
+		Methods("GET")
+	router.Handle("/v1/audit/verify", metric.CollectAPIStats("auditVerify",
+		MiddlewareWithCSRF(http.HandlerFunc(audit.Verify)))).
+		Methods("GET")
+	router.Handle("/v1/auditlog", metric.CollectAPIStats("auditLog",
+		MiddlewareWithCSRF(http.HandlerFunc(audit.List)))).
+		Methods("GET")
+	router.Handle("/v1/dashboard", metric.CollectAPIStats("dashboard",
+		MiddlewareWithCSRF(http.HandlerFunc(dashboard.Get)))).
+		Methods("GET")
+	router.Handle("/v1/report/signings", metric.CollectAPIStats("reportSignings",
+		MiddlewareWithCSRF(http.HandlerFunc(signinglog.Report)))).
+		Methods("GET")
+	router.Handle("/v1/signinglog/fingerprint/{fingerprint}", metric.CollectAPIStats("signinglogListForFingerprint",
+		MiddlewareWithCSRF(http.HandlerFunc(signinglog.ListForFingerprint)))).
+		Methods("GET")
 	router.Handle("/v1/signinglog/account/{authorityID}", metric.CollectAPIStats("signinglogListForAccount",
 		MiddlewareWithCSRF(http.HandlerFunc(signinglog.ListForAccount)))).
 		Methods("GET")
@@ -173,6 +252,31 @@ func AdminRouter() *mux.Router {
 		MiddlewareWithCSRF(http.HandlerFunc(signinglog.ListFilters)))).
 		Methods("GET")
 
+	// API routes: device registry
+	router.Handle("/v1/devices", metric.CollectAPIStats("deviceList",
+		MiddlewareWithCSRF(http.HandlerFunc(device.List)))).
+		Methods("GET")
+	router.Handle("/v1/devices/bundle", metric.CollectAPIStats("deviceBundle",
+		MiddlewareWithCSRF(http.HandlerFunc(device.VerificationBundle)))).
+		Methods("POST")
+	router.Handle("/v1/devices/purge", metric.CollectAPIStats("devicePurge",
+		MiddlewareWithCSRF(http.HandlerFunc(device.Purge)))).
+		Methods("POST")
+	router.Handle("/v1/devices/purge", metric.CollectAPIStats("devicePurgeList",
+		MiddlewareWithCSRF(http.HandlerFunc(device.PurgeList)))).
+		Methods("GET")
+	router.Handle("/v1/devices/purge/verify", metric.CollectAPIStats("devicePurgeVerify",
+		MiddlewareWithCSRF(http.HandlerFunc(device.PurgeVerify)))).
+		Methods("POST")
+	router.Handle("/v1/devices/purge/publickey", metric.CollectAPIStats("devicePurgePublicKey",
+		MiddlewareWithCSRF(http.HandlerFunc(device.PurgePublicKey)))).
+		Methods("GET")
+
+	// API routes: global search
+	router.Handle("/v1/search", metric.CollectAPIStats("search",
+		MiddlewareWithCSRF(http.HandlerFunc(search.Search)))).
+		Methods("GET")
+
 	// API routes: account assertions
 	router.Handle("/v1/accounts", metric.CollectAPIStats("accountList",
 		MiddlewareWithCSRF(http.HandlerFunc(account.List)))).
@@ -186,9 +290,96 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/accounts/{id:[0-9]+}", metric.CollectAPIStats("accountGet",
 		MiddlewareWithCSRF(http.HandlerFunc(account.Get)))).
 		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}", metric.CollectAPIStats("accountDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(account.Delete)))).
+		Methods("DELETE")
+	router.Handle("/v1/accounts/{authorityID}", metric.CollectAPIStats("accountPut",
+		MiddlewareWithCSRF(http.HandlerFunc(account.Put)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/archives", metric.CollectAPIStats("accountArchiveList",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ArchiveList)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/archives/{id:[0-9]+}", metric.CollectAPIStats("accountArchiveDownload",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ArchiveDownload)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/quota", metric.CollectAPIStats("accountQuotaGet",
+		MiddlewareWithCSRF(http.HandlerFunc(account.QuotaGet)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/quota", metric.CollectAPIStats("accountQuotaUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.QuotaUpdate)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}/retention", metric.CollectAPIStats("accountRetentionGet",
+		MiddlewareWithCSRF(http.HandlerFunc(account.RetentionGet)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/retention", metric.CollectAPIStats("accountRetentionUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.RetentionUpdate)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}/keypair", metric.CollectAPIStats("accountDefaultKeypairUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.DefaultKeypairUpdate)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}/noncettl", metric.CollectAPIStats("accountNonceTTLGet",
+		MiddlewareWithCSRF(http.HandlerFunc(account.NonceTTLGet)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/noncettl", metric.CollectAPIStats("accountNonceTTLUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.NonceTTLUpdate)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}/apikeys", metric.CollectAPIStats("accountAPIKeyList",
+		MiddlewareWithCSRF(http.HandlerFunc(account.APIKeyList)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/apikeys", metric.CollectAPIStats("accountAPIKeyCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.APIKeyCreate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/apikey/rotate", metric.CollectAPIStats("accountAPIKeyRotate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.APIKeyRotate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/apikeys/{keyid:[0-9]+}", metric.CollectAPIStats("accountAPIKeyUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.APIKeyUpdate)))).
+		Methods("PUT")
+	router.Handle("/v1/accounts/{id:[0-9]+}/apikeys/{keyid:[0-9]+}", metric.CollectAPIStats("accountAPIKeyDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(account.APIKeyDelete)))).
+		Methods("DELETE")
+	router.Handle("/v1/accounts/{id:[0-9]+}/clientcerts", metric.CollectAPIStats("accountClientCertList",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ClientCertList)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/clientcerts", metric.CollectAPIStats("accountClientCertCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ClientCertCreate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/clientcerts/{certid:[0-9]+}", metric.CollectAPIStats("accountClientCertDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ClientCertDelete)))).
+		Methods("DELETE")
+	router.Handle("/v1/accounts/{id:[0-9]+}/hmac", metric.CollectAPIStats("accountHMACGet",
+		MiddlewareWithCSRF(http.HandlerFunc(account.HMACGet)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/hmac", metric.CollectAPIStats("accountHMACRotate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.HMACRotate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/hmac", metric.CollectAPIStats("accountHMACDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(account.HMACDelete)))).
+		Methods("DELETE")
+	router.Handle("/v1/accounts/{id:[0-9]+}/webhooks", metric.CollectAPIStats("accountWebhookList",
+		MiddlewareWithCSRF(http.HandlerFunc(account.WebhookList)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/webhooks", metric.CollectAPIStats("accountWebhookCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(account.WebhookCreate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/webhooks/{webhookid:[0-9]+}", metric.CollectAPIStats("accountWebhookDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(account.WebhookDelete)))).
+		Methods("DELETE")
+	router.Handle("/v1/accounts/{id:[0-9]+}/webhooks/{webhookid:[0-9]+}/deliveries", metric.CollectAPIStats("accountWebhookDeliveries",
+		MiddlewareWithCSRF(http.HandlerFunc(account.WebhookDeliveries)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/config", metric.CollectAPIStats("accountConfigExport",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ConfigExport)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/config", metric.CollectAPIStats("accountConfigApply",
+		MiddlewareWithCSRF(http.HandlerFunc(account.ConfigApply)))).
+		Methods("PUT")
 	router.Handle("/v1/accounts/upload", metric.CollectAPIStats("accountUpload",
 		MiddlewareWithCSRF(http.HandlerFunc(account.Upload)))).
 		Methods("POST")
+	router.Handle("/v1/accounts/refresh", metric.CollectAPIStats("accountRefresh",
+		MiddlewareWithCSRF(http.HandlerFunc(account.Refresh)))).
+		Methods("POST")
 	router.Handle("/v1/accounts/{id:[0-9]+}/stores", metric.CollectAPIStats("substoreList",
 		MiddlewareWithCSRF(http.HandlerFunc(substore.List)))).
 		Methods("GET")
@@ -198,14 +389,41 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/accounts/stores/{id:[0-9]+}", metric.CollectAPIStats("substoreDelete",
 		MiddlewareWithCSRF(http.HandlerFunc(substore.Delete)))).
 		Methods("DELETE")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/deleted", metric.CollectAPIStats("substoreListDeleted",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.ListDeleted)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/stores/{id:[0-9]+}/restore", metric.CollectAPIStats("substoreRestore",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.Restore)))).
+		Methods("POST")
 	router.Handle("/v1/accounts/stores", metric.CollectAPIStats("substoreCreate",
 		MiddlewareWithCSRF(http.HandlerFunc(substore.Create)))).
 		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/bulk", metric.CollectAPIStats("substoreBulk",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.Bulk)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/stores/{modelID:[0-9]+}/{serial}/pivots", metric.CollectAPIStats("substorePivots",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.Pivots)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/users", metric.CollectAPIStats("substoreUserList",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.UserList)))).
+		Methods("GET")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/users", metric.CollectAPIStats("substoreUserCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.UserCreate)))).
+		Methods("POST")
+	router.Handle("/v1/accounts/{id:[0-9]+}/stores/users/{userid:[0-9]+}", metric.CollectAPIStats("substoreUserDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(substore.UserDelete)))).
+		Methods("DELETE")
 
 	// API routes: system-user assertion
 	router.Handle("/v1/assertions", metric.CollectAPIStats("assertionSystemUserAssertion",
 		MiddlewareWithCSRF(http.HandlerFunc(assertion.SystemUserAssertion)))).
 		Methods("POST")
+	router.Handle("/v1/assertions/batch", metric.CollectAPIStats("assertionSystemUserBatch",
+		MiddlewareWithCSRF(http.HandlerFunc(assertion.SystemUserBatch)))).
+		Methods("POST")
+	router.Handle("/v1/assertions/validationset", metric.CollectAPIStats("assertionValidationSet",
+		MiddlewareWithCSRF(http.HandlerFunc(assertion.ValidationSet)))).
+		Methods("POST")
 
 	// API routes: users management
 	router.Handle("/v1/users", metric.CollectAPIStats("userList",
@@ -214,6 +432,12 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/users", metric.CollectAPIStats("userCreate",
 		MiddlewareWithCSRF(http.HandlerFunc(user.Create)))).
 		Methods("POST")
+	router.Handle("/v1/users/bulk", metric.CollectAPIStats("userBulk",
+		MiddlewareWithCSRF(http.HandlerFunc(user.Bulk)))).
+		Methods("POST")
+	router.Handle("/v1/users/invite", metric.CollectAPIStats("userInvite",
+		MiddlewareWithCSRF(http.HandlerFunc(user.Invite)))).
+		Methods("POST")
 	router.Handle("/v1/users/{id:[0-9]+}", metric.CollectAPIStats("userGet",
 		MiddlewareWithCSRF(http.HandlerFunc(user.Get)))).
 		Methods("GET")
@@ -223,9 +447,44 @@ func AdminRouter() *mux.Router {
 	router.Handle("/v1/users/{id:[0-9]+}", metric.CollectAPIStats("userDelete",
 		MiddlewareWithCSRF(http.HandlerFunc(user.Delete)))).
 		Methods("DELETE")
+	router.Handle("/v1/users/{id:[0-9]+}/deactivate", metric.CollectAPIStats("userDeactivate",
+		MiddlewareWithCSRF(http.HandlerFunc(user.Deactivate)))).
+		Methods("POST")
+	router.Handle("/v1/users/{id:[0-9]+}/activate", metric.CollectAPIStats("userActivate",
+		MiddlewareWithCSRF(http.HandlerFunc(user.Activate)))).
+		Methods("POST")
 	router.Handle("/v1/users/{id:[0-9]+}/otheraccounts", metric.CollectAPIStats("userGetOtherAccounts",
 		MiddlewareWithCSRF(http.HandlerFunc(user.GetOtherAccounts)))).
 		Methods("GET")
+	router.Handle("/v1/users/{id:[0-9]+}/tokens", metric.CollectAPIStats("userTokenList",
+		MiddlewareWithCSRF(http.HandlerFunc(user.TokenList)))).
+		Methods("GET")
+	router.Handle("/v1/users/{id:[0-9]+}/tokens", metric.CollectAPIStats("userTokenCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(user.TokenCreate)))).
+		Methods("POST")
+	router.Handle("/v1/users/{id:[0-9]+}/tokens/{tokenid:[0-9]+}", metric.CollectAPIStats("userTokenDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(user.TokenDelete)))).
+		Methods("DELETE")
+	router.Handle("/v1/users/{id:[0-9]+}/sessions/revoke", metric.CollectAPIStats("userRevokeSessions",
+		MiddlewareWithCSRF(http.HandlerFunc(user.RevokeSessions)))).
+		Methods("POST")
+
+	// API routes: service accounts management
+	router.Handle("/v1/serviceaccounts", metric.CollectAPIStats("serviceAccountList",
+		MiddlewareWithCSRF(http.HandlerFunc(serviceaccount.List)))).
+		Methods("GET")
+	router.Handle("/v1/serviceaccounts", metric.CollectAPIStats("serviceAccountCreate",
+		MiddlewareWithCSRF(http.HandlerFunc(serviceaccount.Create)))).
+		Methods("POST")
+	router.Handle("/v1/serviceaccounts/{id:[0-9]+}", metric.CollectAPIStats("serviceAccountUpdate",
+		MiddlewareWithCSRF(http.HandlerFunc(serviceaccount.Update)))).
+		Methods("PUT")
+	router.Handle("/v1/serviceaccounts/{id:[0-9]+}", metric.CollectAPIStats("serviceAccountDelete",
+		MiddlewareWithCSRF(http.HandlerFunc(serviceaccount.Delete)))).
+		Methods("DELETE")
+	router.Handle("/v1/serviceaccounts/{id:[0-9]+}/secret", metric.CollectAPIStats("serviceAccountResetSecret",
+		MiddlewareWithCSRF(http.HandlerFunc(serviceaccount.ResetSecret)))).
+		Methods("POST")
 
 	// OpenID routes: using Ubuntu SSO
 	router.Handle("/login", metric.CollectAPIStats("ussoLoginHandler",
@@ -249,12 +508,27 @@ func AdminRouter() *mux.Router {
 	router.Handle("/", MiddlewareWithCSRF(http.HandlerFunc(app.Index))).Methods("GET")
 
 	// Admin API routes
+	router.Handle("/api/token", metric.CollectAPIStats("serviceAccountToken",
+		Middleware(http.HandlerFunc(serviceaccount.Token)))).
+		Methods("POST")
 	router.Handle("/api/signinglog", metric.CollectAPIStats("signinglogAPIList",
 		Middleware(http.HandlerFunc(signinglog.APIList)))).
 		Methods("GET")
+	router.Handle("/api/signinglog/search", metric.CollectAPIStats("signinglogAPISearch",
+		Middleware(http.HandlerFunc(signinglog.APISearch)))).
+		Methods("GET")
 	router.Handle("/api/keypairs", metric.CollectAPIStats("keypairAPIList",
 		Middleware(http.HandlerFunc(keypair.APIList)))).
 		Methods("GET")
+	router.Handle("/api/keypairs", metric.CollectAPIStats("keypairAPICreate",
+		Middleware(http.HandlerFunc(keypair.APICreate)))).
+		Methods("POST")
+	router.Handle("/api/accounts/{id:[0-9]+}/config", metric.CollectAPIStats("accountAPIConfigExport",
+		Middleware(http.HandlerFunc(account.APIConfigExport)))).
+		Methods("GET")
+	router.Handle("/api/accounts/{id:[0-9]+}/config", metric.CollectAPIStats("accountAPIConfigApply",
+		Middleware(http.HandlerFunc(account.APIConfigApply)))).
+		Methods("PUT")
 	router.Handle("/api/accounts/{id:[0-9]+}/stores", metric.CollectAPIStats("substoreAPIList",
 		Middleware(http.HandlerFunc(substore.APIList)))).
 		Methods("GET")
@@ -264,18 +538,36 @@ func AdminRouter() *mux.Router {
 	router.Handle("/api/accounts/stores/{id:[0-9]+}", metric.CollectAPIStats("substoreAPIDelete",
 		Middleware(http.HandlerFunc(substore.APIDelete)))).
 		Methods("DELETE")
+	router.Handle("/api/accounts/{id:[0-9]+}/stores/deleted", metric.CollectAPIStats("substoreAPIListDeleted",
+		Middleware(http.HandlerFunc(substore.APIListDeleted)))).
+		Methods("GET")
+	router.Handle("/api/accounts/stores/{id:[0-9]+}/restore", metric.CollectAPIStats("substoreAPIRestore",
+		Middleware(http.HandlerFunc(substore.APIRestore)))).
+		Methods("POST")
 	router.Handle("/api/accounts/stores", metric.CollectAPIStats("substoreAPICreate",
 		Middleware(http.HandlerFunc(substore.APICreate)))).
 		Methods("POST")
+	router.Handle("/api/accounts/{id:[0-9]+}/stores/bulk", metric.CollectAPIStats("substoreAPIBulk",
+		Middleware(http.HandlerFunc(substore.APIBulk)))).
+		Methods("POST")
 	router.Handle("/api/accounts/stores/{modelID:[0-9]+}/{serial}", metric.CollectAPIStats("substoreAPIGet",
 		Middleware(http.HandlerFunc(substore.APIGet)))).
 		Methods("GET")
+	router.Handle("/api/accounts/stores/{modelID:[0-9]+}/{serial}/pivots", metric.CollectAPIStats("substoreAPIPivots",
+		Middleware(http.HandlerFunc(substore.APIPivots)))).
+		Methods("GET")
 	router.Handle("/api/assertions/checkserial", metric.CollectAPIStats("assertionAPIValidateSerial",
 		Middleware(http.HandlerFunc(assertion.APIValidateSerial)))).
 		Methods("POST")
 	router.Handle("/api/assertions", metric.CollectAPIStats("assertionAPISystemUser",
 		Middleware(http.HandlerFunc(assertion.APISystemUser)))).
 		Methods("POST")
+	router.Handle("/api/assertions/batch", metric.CollectAPIStats("assertionAPISystemUserBatch",
+		Middleware(http.HandlerFunc(assertion.APISystemUserBatch)))).
+		Methods("POST")
+	router.Handle("/api/assertions/validationset", metric.CollectAPIStats("assertionAPIValidationSet",
+		Middleware(http.HandlerFunc(assertion.APIValidationSet)))).
+		Methods("POST")
 	router.Handle("/api/models/{id:[0-9]+}", metric.CollectAPIStats("modelAPIGet",
 		Middleware(http.HandlerFunc(model.APIGet)))).
 		Methods("GET")
@@ -285,6 +577,21 @@ func AdminRouter() *mux.Router {
 	router.Handle("/api/models/{id:[0-9]+}", metric.CollectAPIStats("modelAPIDelete",
 		Middleware(http.HandlerFunc(model.APIDelete)))).
 		Methods("DELETE")
+	router.Handle("/api/models/{brand}/{name}", metric.CollectAPIStats("modelAPIPut",
+		Middleware(http.HandlerFunc(model.APIPut)))).
+		Methods("PUT")
+	router.Handle("/api/models/deleted", metric.CollectAPIStats("modelAPIListDeleted",
+		Middleware(http.HandlerFunc(model.APIListDeleted)))).
+		Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}/restore", metric.CollectAPIStats("modelAPIRestore",
+		Middleware(http.HandlerFunc(model.APIRestore)))).
+		Methods("POST")
+	router.Handle("/api/models/{id:[0-9]+}/history", metric.CollectAPIStats("modelAPIHistory",
+		Middleware(http.HandlerFunc(model.APIHistory)))).
+		Methods("GET")
+	router.Handle("/api/models/{id:[0-9]+}/apikey", metric.CollectAPIStats("modelAPIRotateAPIKey",
+		Middleware(http.HandlerFunc(model.APIRotateAPIKey)))).
+		Methods("POST")
 	router.Handle("/api/models", metric.CollectAPIStats("modelAPICreate",
 		Middleware(http.HandlerFunc(model.APICreate)))).
 		Methods("POST")
@@ -292,10 +599,39 @@ func AdminRouter() *mux.Router {
 		Middleware(http.HandlerFunc(model.APIAssertionHeaders)))).
 		Methods("POST")
 
+	// SCIM routes: user provisioning by an identity system
+	router.Handle("/scim/v2/ServiceProviderConfig", metric.CollectAPIStats("scimServiceProviderConfig",
+		Middleware(http.HandlerFunc(scim.ServiceProviderConfig)))).
+		Methods("GET")
+	router.Handle("/scim/v2/Users", metric.CollectAPIStats("scimUserList",
+		Middleware(http.HandlerFunc(scim.List)))).
+		Methods("GET")
+	router.Handle("/scim/v2/Users", metric.CollectAPIStats("scimUserCreate",
+		Middleware(http.HandlerFunc(scim.Create)))).
+		Methods("POST")
+	router.Handle("/scim/v2/Users/{id:[0-9]+}", metric.CollectAPIStats("scimUserGet",
+		Middleware(http.HandlerFunc(scim.Get)))).
+		Methods("GET")
+	router.Handle("/scim/v2/Users/{id:[0-9]+}", metric.CollectAPIStats("scimUserReplace",
+		Middleware(http.HandlerFunc(scim.Replace)))).
+		Methods("PUT")
+	router.Handle("/scim/v2/Users/{id:[0-9]+}", metric.CollectAPIStats("scimUserPatch",
+		Middleware(http.HandlerFunc(scim.Patch)))).
+		Methods("PATCH")
+	router.Handle("/scim/v2/Users/{id:[0-9]+}", metric.CollectAPIStats("scimUserDelete",
+		Middleware(http.HandlerFunc(scim.Delete)))).
+		Methods("DELETE")
+
 	// Sync API routes
 	router.Handle("/api/accounts", metric.CollectAPIStats("accountAPIList",
 		Middleware(http.HandlerFunc(account.APIList)))).
 		Methods("GET")
+	router.Handle("/api/accounts", metric.CollectAPIStats("accountAPICreate",
+		Middleware(http.HandlerFunc(account.APICreate)))).
+		Methods("POST")
+	router.Handle("/api/accounts/{authorityID}", metric.CollectAPIStats("accountAPIPut",
+		Middleware(http.HandlerFunc(account.APIPut)))).
+		Methods("PUT")
 	router.Handle("/api/keypairs/sync", metric.CollectAPIStats("keypairAPISyncKeypairs)",
 		Middleware(http.HandlerFunc(keypair.APISyncKeypairs)))).
 		Methods("POST")
@@ -315,10 +651,19 @@ func AdminRouter() *mux.Router {
 		Middleware(http.HandlerFunc(testlog.APISyncUpdateLog)))).
 		Methods("PUT")
 
+	// profiling endpoints, for superusers
+	router.Handle("/debug/pprof/cmdline", Middleware(profile.Cmdline)).Methods("GET")
+	router.Handle("/debug/pprof/profile", Middleware(profile.Profile)).Methods("GET")
+	router.Handle("/debug/pprof/symbol", Middleware(profile.Symbol)).Methods("GET", "POST")
+	router.Handle("/debug/pprof/trace", Middleware(profile.Trace)).Methods("GET")
+	router.PathPrefix("/debug/pprof/").Handler(Middleware(profile.Index)).Methods("GET")
+
 	// prometheus metrics endpoint
 	router.Handle("/_status/metrics", metric.NewServer()).Methods("GET")
 	// status endpoints
 	status.AddStatusEndpoints("/_status", router)
+	// liveness and readiness probes
+	status.AddProbeEndpoints(router)
 
 	return router
 }
@@ -0,0 +1,287 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/service/assertion"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/core"
+	"github.com/CanonicalLtd/serial-vault/service/dashboard"
+	"github.com/CanonicalLtd/serial-vault/service/device"
+	"github.com/CanonicalLtd/serial-vault/service/jobs"
+	"github.com/CanonicalLtd/serial-vault/service/keypair"
+	"github.com/CanonicalLtd/serial-vault/service/logging"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/service/pivot"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/search"
+	"github.com/CanonicalLtd/serial-vault/service/serviceaccount"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	"github.com/CanonicalLtd/serial-vault/service/spec"
+	"github.com/CanonicalLtd/serial-vault/service/substore"
+	"github.com/CanonicalLtd/serial-vault/service/testlog"
+	"github.com/CanonicalLtd/serial-vault/service/user"
+	"github.com/CanonicalLtd/serial-vault/store"
+)
+
+// Query parameters of the paged and filtered lists, and of the dry runs
+var (
+	modelQuery      = []string{"brand-id", "name", "keypair-id", "offset", "limit", "all"}
+	substoreQuery   = []string{"serial", "offset", "limit", "all"}
+	signingLogQuery = []string{"model", "serial", "fingerprint", "key-id", "from", "to", "token", "limit"}
+	configQuery     = []string{"dryrun"}
+)
+
+// securitySchemes are the methods of authentication of the services
+var securitySchemes = map[string]spec.SecurityScheme{
+	"apiKey": {Type: "apiKey", In: "header", Name: "api-key", Description: "The API key of the model or the account"},
+	"user":   {Type: "apiKey", In: "header", Name: "user", Description: "The username of the API key of the user"},
+	"bearer": {Type: "http", Scheme: "bearer", BearerFormat: "JWT", Description: "The JWT of a user or a service account, or a personal access token"},
+}
+
+// signingSpec describes the API of the signing service
+func signingSpec() spec.API {
+	return spec.API{
+		Title:           "Serial Vault Signing API",
+		Version:         datastore.Environ.Config.Version,
+		Schemes:         []spec.Scheme{{Prefix: "/v1/", Tag: "signing", Security: [][]string{{"apiKey"}}}},
+		SecuritySchemes: securitySchemes,
+		Operations:      apiOperations,
+		Default:         response.StandardResponse{},
+		Error:           response.ErrorResponse{},
+	}
+}
+
+// adminSpec describes the API of the admin service: the routes of the web application, which
+// use the JWT of the user, and the admin API routes
+func adminSpec() spec.API {
+	return spec.API{
+		Title:   "Serial Vault Admin API",
+		Version: datastore.Environ.Config.Version,
+		Schemes: []spec.Scheme{
+			{Prefix: "/v1/", Tag: "admin", Security: [][]string{{"bearer"}}},
+			{Prefix: "/api/", Tag: "admin-api", Security: [][]string{{"user", "apiKey"}, {"bearer"}}},
+		},
+		SecuritySchemes: securitySchemes,
+		Operations:      apiOperations,
+		Default:         response.StandardResponse{},
+		Error:           response.StandardResponse{},
+	}
+}
+
+// apiOperations documents the API routes of the services, keyed by the method and the path
+// template of the route. The IDs of the operations follow the names of the API metrics
+var apiOperations = map[string]spec.Operation{
+	// Common routes
+	"GET /v1/version": {ID: "coreVersion", Summary: "Get the version of the service", Tag: "core", Public: true, Response: core.VersionResponse{}},
+	"GET /v1/health":  {ID: "coreHealth", Summary: "Check the health of the service", Tag: "core", Public: true, Response: core.HealthResponse{}},
+	"GET /v1/spec":    {ID: "coreSpec", Summary: "Get the OpenAPI specification of the service", Tag: "core", Public: true, Response: spec.Document{}},
+
+	// Signing routes
+	"POST /v1/serial":     {ID: "signSerial", Summary: "Sign the serial assertion of a serial-request", Request: spec.Assertions, Response: spec.Assertions},
+	"POST /v1/request-id": {ID: "signRequestID", Summary: "Generate a nonce for a serial-request", Response: sign.RequestIDResponse{}},
+	"POST /v1/model":      {ID: "assertionModelAssertion", Summary: "Get the model assertion of a model", Request: assertion.ModelAssertionRequest{}, Response: spec.Assertions},
+
+	// Reseller routes of the signing service
+	"POST /v1/pivot":       {ID: "pivotModel", Summary: "Get the sub-store model of a device", Tag: "reseller", Request: spec.Assertions, Response: pivot.Response{}},
+	"POST /v1/pivotmodel":  {ID: "pivotModelAssertion", Summary: "Get the model assertions of the sub-store model of a device", Tag: "reseller", Request: spec.Assertions, Response: spec.Assertions},
+	"POST /v1/pivotserial": {ID: "pivotSerialAssertion", Summary: "Get the serial assertion of the sub-store model of a device", Tag: "reseller", Request: spec.Assertions, Response: spec.Assertions},
+	"POST /v1/pivotuser":   {ID: "pivotSystemUserAssertion", Summary: "Get a system-user assertion for the sub-store model of a device", Tag: "reseller", Request: assertion.PivotSystemUserRequest{}, Response: spec.Assertions},
+
+	// Admin routes: authentication
+	"GET /v1/token":          {ID: "coreToken", Summary: "Get the CSRF token and the authentication settings", Public: true, Response: core.TokenResponse{}},
+	"GET /v1/authtoken":      {ID: "coreAuthToken", Summary: "Get the CSRF token and the authentication settings", Public: true, Response: core.TokenResponse{}},
+	"POST /v1/token/refresh": {ID: "coreTokenRefresh", Summary: "Refresh the JWT of the session", Public: true, Response: core.TokenResponse{}},
+
+	// Admin routes: models
+	"GET /v1/models":                       {ID: "modelList", Summary: "List the models", Query: modelQuery, Response: model.ListResponse{}},
+	"POST /v1/models":                      {ID: "modelCreate", Summary: "Create a model", Request: datastore.Model{}, Response: model.InstanceResponse{}},
+	"POST /v1/models/assertion":            {ID: "modelAssertionHeaders", Summary: "Update the model assertion headers of a model", Request: datastore.ModelAssertion{}},
+	"GET /v1/models/{id:[0-9]+}":           {ID: "modelGet", Summary: "Get a model", Response: model.InstanceResponse{}},
+	"PUT /v1/models/{id:[0-9]+}":           {ID: "modelUpdate", Summary: "Update a model", Request: datastore.Model{}},
+	"DELETE /v1/models/{id:[0-9]+}":        {ID: "modelDelete", Summary: "Delete a model"},
+	"PUT /v1/models/{brand}/{name}":        {ID: "modelPut", Summary: "Create or update the model of a brand and name", Request: datastore.Model{}, Response: model.InstanceResponse{}},
+	"POST /v1/models/bulk":                 {ID: "modelBulk", Summary: "Create or update models in bulk", Request: []datastore.BulkModel{}, Response: model.BulkResponse{}},
+	"GET /v1/models/deleted":               {ID: "modelListDeleted", Summary: "List the deleted models", Response: model.ListResponse{}},
+	"POST /v1/models/{id:[0-9]+}/restore":  {ID: "modelRestore", Summary: "Restore a deleted model"},
+	"GET /v1/models/{id:[0-9]+}/history":   {ID: "modelHistory", Summary: "List the revisions of a model", Response: model.HistoryResponse{}},
+	"POST /v1/models/{id:[0-9]+}/apikey":   {ID: "modelRotateAPIKey", Summary: "Rotate the API key of a model", Request: model.RotateRequest{}, Response: model.APIKeyResponse{}},
+	"GET /api/models":                      {ID: "modelAPIList", Summary: "List the models", Query: modelQuery, Response: model.ListResponse{}},
+	"POST /api/models":                     {ID: "modelAPICreate", Summary: "Create a model", Request: datastore.Model{}, Response: model.InstanceResponse{}},
+	"POST /api/models/assertion":           {ID: "modelAPIAssertionHeaders", Summary: "Update the model assertion headers of a model", Request: datastore.ModelAssertion{}},
+	"GET /api/models/{id:[0-9]+}":          {ID: "modelAPIGet", Summary: "Get a model", Response: model.InstanceResponse{}},
+	"PUT /api/models/{id:[0-9]+}":          {ID: "modelAPIUpdate", Summary: "Update a model", Request: datastore.Model{}},
+	"DELETE /api/models/{id:[0-9]+}":       {ID: "modelAPIDelete", Summary: "Delete a model"},
+	"PUT /api/models/{brand}/{name}":       {ID: "modelAPIPut", Summary: "Create or update the model of a brand and name", Request: datastore.Model{}, Response: model.InstanceResponse{}},
+	"GET /api/models/deleted":              {ID: "modelAPIListDeleted", Summary: "List the deleted models", Response: model.ListResponse{}},
+	"POST /api/models/{id:[0-9]+}/restore": {ID: "modelAPIRestore", Summary: "Restore a deleted model"},
+	"GET /api/models/{id:[0-9]+}/history":  {ID: "modelAPIHistory", Summary: "List the revisions of a model", Response: model.HistoryResponse{}},
+	"POST /api/models/{id:[0-9]+}/apikey":  {ID: "modelAPIRotateAPIKey", Summary: "Rotate the API key of a model", Request: model.RotateRequest{}, Response: model.APIKeyResponse{}},
+
+	// Admin routes: signing-keys
+	"GET /v1/keypairs":                                {ID: "keypairList", Summary: "List the signing-keys", Response: keypair.ListResponse{}},
+	"POST /v1/keypairs":                               {ID: "keypairCreate", Summary: "Upload a signing-key", Request: keypair.WithPrivateKey{}},
+	"GET /v1/keypairs/{id:[0-9]+}":                    {ID: "keypairGet", Summary: "Get a signing-key", Response: keypair.GetResponse{}},
+	"PUT /v1/keypairs/{id:[0-9]+}":                    {ID: "keypairUpdate", Summary: "Update a signing-key", Request: datastore.Keypair{}},
+	"POST /v1/keypairs/{id:[0-9]+}/disable":           {ID: "keypairDisable", Summary: "Disable a signing-key"},
+	"POST /v1/keypairs/{id:[0-9]+}/enable":            {ID: "keypairEnable", Summary: "Enable a signing-key"},
+	"POST /v1/keypairs/card":                          {ID: "keypairCard", Summary: "Add a signing-key that is held on an OpenPGP card", Request: keypair.WithPublicKey{}},
+	"POST /v1/keypairs/assertion":                     {ID: "keypairAssertion", Summary: "Upload the account-key assertion of a signing-key", Request: keypair.AssertionRequest{}},
+	"POST /v1/keypairs/generate":                      {ID: "keypairGenerate", Summary: "Generate a signing-key", Request: keypair.WithPrivateKey{}},
+	"GET /v1/keypairs/status/{authorityID}/{keyName}": {ID: "keypairStatus", Summary: "Get the status of the generation of a signing-key"},
+	"GET /v1/keypairs/status":                         {ID: "keypairProgress", Summary: "List the signing-keys that are being generated", Response: keypair.ProgressResponse{}},
+	"POST /v1/keypairs/register":                      {ID: "storeKeyRegister", Summary: "Register a signing-key with the store", Request: store.KeyRegister{}},
+	"GET /api/keypairs":                               {ID: "keypairAPIList", Summary: "List the signing-keys", Response: keypair.ListResponse{}},
+	"POST /api/keypairs":                              {ID: "keypairAPICreate", Summary: "Upload a signing-key", Request: keypair.WithPrivateKey{}},
+	"POST /api/keypairs/sync":                         {ID: "keypairAPISyncKeypairs", Summary: "Get the encrypted signing-keys for a factory", Request: keypair.SyncRequest{}, Response: keypair.SyncResponse{}},
+
+	// Admin routes: signing log, logging and audit
+	"GET /v1/signinglog":                               {ID: "signinglogList", Summary: "List the signing log", Query: signingLogQuery, Response: signinglog.ListResponse{}},
+	"GET /v1/signinglog/search":                        {ID: "signinglogSearch", Summary: "Search the signing log", Query: []string{"q", "token", "limit"}, Response: signinglog.ListResponse{}},
+	"GET /v1/signinglog/export":                        {ID: "signinglogExport", Summary: "Export the signing log as CSV or newline-delimited JSON", Query: append([]string{"format"}, signingLogQuery...), Response: spec.Text("text/csv")},
+	"GET /v1/signinglog/purges":                        {ID: "signinglogPurges", Summary: "List the purges of the signing log", Response: signinglog.PurgesResponse{}},
+	"GET /v1/signinglog/fingerprint/{fingerprint}":     {ID: "signinglogListForFingerprint", Summary: "List the signing log of a device fingerprint", Response: signinglog.ListResponse{}},
+	"GET /v1/signinglog/account/{authorityID}":         {ID: "signinglogListForAccount", Summary: "List the signing log of an account", Response: signinglog.ListResponse{}},
+	"GET /v1/signinglog/account/{authorityID}/filters": {ID: "signinglogListFilters", Summary: "List the makes and models of the signing log of an account", Response: signinglog.FiltersResponse{}},
+	"GET /v1/report/signings":                          {ID: "reportSignings", Summary: "Report the signings of a period", Query: []string{"period", "from", "to"}, Response: signinglog.ReportResponse{}},
+	"GET /api/signinglog":                              {ID: "signinglogAPIList", Summary: "List the signing log", Query: signingLogQuery, Response: signinglog.ListResponse{}},
+	"POST /api/signinglog":                             {ID: "signinglogAPISyncLog", Summary: "Upload a signing log entry from a factory", Request: datastore.SigningLog{}},
+	"GET /api/signinglog/search":                       {ID: "signinglogAPISearch", Summary: "Search the signing log", Query: []string{"q", "token", "limit"}, Response: signinglog.ListResponse{}},
+	"GET /v1/logging":                                  {ID: "loggingGet", Summary: "Get the logging policy", Response: logging.PolicyResponse{}},
+	"PUT /v1/logging":                                  {ID: "loggingUpdate", Summary: "Update the logging policy", Request: logging.PolicyRequest{}},
+	"GET /v1/admin/loglevel":                           {ID: "loggingGetLevel", Summary: "Get the log level", Response: logging.LevelResponse{}},
+	"PUT /v1/admin/loglevel":                           {ID: "loggingUpdateLevel", Summary: "Update the log level", Request: logging.LevelRequest{}},
+	"GET /v1/jobs":                                     {ID: "jobsList", Summary: "List the status of the scheduled jobs", Response: jobs.ListResponse{}},
+	"GET /v1/audit/verify":                             {ID: "auditVerify", Summary: "Verify the integrity of the audit chain", Query: []string{"from", "to"}, Response: audit.VerifyResponse{}},
+	"GET /v1/auditlog":                                 {ID: "auditLog", Summary: "List the audit log", Query: []string{"username", "action", "object", "authorityID", "from", "to", "token", "limit"}, Response: audit.ListResponse{}},
+	"GET /v1/dashboard":                                {ID: "dashboard", Summary: "Get the dashboard of the accounts", Response: dashboard.Response{}},
+	"GET /v1/search":                                   {ID: "search", Summary: "Search the models, sub-stores, devices and signing-keys", Query: []string{"q"}, Response: search.Response{}},
+
+	// Admin routes: factory test logs
+	"GET /api/testlog":             {ID: "testlogAPIListLog", Summary: "List the test logs of a factory", Response: testlog.ListResponse{}},
+	"POST /api/testlog":            {ID: "testlogAPISyncLog", Summary: "Upload a test log from a factory", Request: datastore.TestLog{}},
+	"PUT /api/testlog/{id:[0-9]+}": {ID: "testlogAPISyncUpdateLog", Summary: "Mark a test log as synchronized"},
+
+	// Admin routes: devices
+	"GET /v1/devices":                 {ID: "deviceList", Summary: "List the registered devices", Query: []string{"serial"}, Response: device.ListResponse{}},
+	"POST /v1/devices/bundle":         {ID: "deviceBundle", Summary: "Get the verification bundle of a device", Request: spec.Assertions, Response: device.BundleResponse{}},
+	"POST /v1/devices/purge":          {ID: "devicePurge", Summary: "Purge the data of a device", Request: datastore.DataPurgeRequest{}, Response: device.PurgeResponse{}},
+	"GET /v1/devices/purge":           {ID: "devicePurgeList", Summary: "List the deletion certificates", Response: device.PurgeListResponse{}},
+	"POST /v1/devices/purge/verify":   {ID: "devicePurgeVerify", Summary: "Verify a deletion certificate", Request: device.VerifyPurgeRequest{}, Response: device.VerifyPurgeResponse{}},
+	"GET /v1/devices/purge/publickey": {ID: "devicePurgePublicKey", Summary: "Fetch the public key of the deletion certificates", Response: device.PurgePublicKeyResponse{}},
+
+	// Admin routes: accounts
+	"GET /v1/accounts":                                                    {ID: "accountList", Summary: "List the accounts", Response: account.ListResponse{}},
+	"POST /v1/accounts":                                                   {ID: "accountCreate", Summary: "Create an account", Request: datastore.Account{}},
+	"GET /v1/accounts/{id:[0-9]+}":                                        {ID: "accountGet", Summary: "Get an account", Response: account.GetResponse{}},
+	"PUT /v1/accounts/{id:[0-9]+}":                                        {ID: "accountUpdate", Summary: "Update an account", Request: datastore.Account{}},
+	"DELETE /v1/accounts/{id:[0-9]+}":                                     {ID: "accountDelete", Summary: "Delete an account, keeping an archive of its records", Query: []string{"confirm"}, Response: account.ArchiveResponse{}},
+	"PUT /v1/accounts/{authorityID}":                                      {ID: "accountPut", Summary: "Create or update the account of an authority ID", Request: datastore.Account{}, Response: account.GetResponse{}},
+	"GET /v1/accounts/archives":                                           {ID: "accountArchiveList", Summary: "List the archives of the deleted accounts", Response: account.ArchiveListResponse{}},
+	"GET /v1/accounts/archives/{id:[0-9]+}":                               {ID: "accountArchiveDownload", Summary: "Download the archive of a deleted account", Response: spec.Text("application/gzip")},
+	"GET /v1/accounts/{id:[0-9]+}/quota":                                  {ID: "accountQuotaGet", Summary: "Get the signing quota of an account", Response: account.QuotaResponse{}},
+	"PUT /v1/accounts/{id:[0-9]+}/quota":                                  {ID: "accountQuotaUpdate", Summary: "Update the signing quota of an account", Request: datastore.AccountQuota{}},
+	"GET /v1/accounts/{id:[0-9]+}/retention":                              {ID: "accountRetentionGet", Summary: "Get the retention of the signing log of an account", Response: account.RetentionResponse{}},
+	"PUT /v1/accounts/{id:[0-9]+}/retention":                              {ID: "accountRetentionUpdate", Summary: "Update the retention of the signing log of an account", Request: datastore.AccountRetention{}},
+	"PUT /v1/accounts/{id:[0-9]+}/keypair":                                {ID: "accountDefaultKeypairUpdate", Summary: "Update the default signing-key of an account", Request: account.DefaultKeypairRequest{}},
+	"GET /v1/accounts/{id:[0-9]+}/noncettl":                               {ID: "accountNonceTTLGet", Summary: "Get the lifetime of the nonces of an account", Response: account.NonceTTLResponse{}},
+	"PUT /v1/accounts/{id:[0-9]+}/noncettl":                               {ID: "accountNonceTTLUpdate", Summary: "Update the lifetime of the nonces of an account", Request: datastore.AccountNonceTTL{}},
+	"GET /v1/accounts/{id:[0-9]+}/apikeys":                                {ID: "accountAPIKeyList", Summary: "List the API keys of an account", Response: account.APIKeysResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/apikeys":                               {ID: "accountAPIKeyCreate", Summary: "Create an API key of an account", Request: datastore.APIKey{}, Response: account.APIKeyResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/apikey/rotate":                         {ID: "accountAPIKeyRotate", Summary: "Rotate an API key of an account", Request: datastore.APIKey{}, Response: account.APIKeyResponse{}},
+	"PUT /v1/accounts/{id:[0-9]+}/apikeys/{keyid:[0-9]+}":                 {ID: "accountAPIKeyUpdate", Summary: "Update an API key of an account", Request: datastore.APIKey{}},
+	"DELETE /v1/accounts/{id:[0-9]+}/apikeys/{keyid:[0-9]+}":              {ID: "accountAPIKeyDelete", Summary: "Delete an API key of an account"},
+	"GET /v1/accounts/{id:[0-9]+}/clientcerts":                            {ID: "accountClientCertList", Summary: "List the client certificates of an account", Response: account.ClientCertsResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/clientcerts":                           {ID: "accountClientCertCreate", Summary: "Add a client certificate of an account", Request: datastore.ClientCert{}, Response: account.ClientCertResponse{}},
+	"DELETE /v1/accounts/{id:[0-9]+}/clientcerts/{certid:[0-9]+}":         {ID: "accountClientCertDelete", Summary: "Delete a client certificate of an account"},
+	"GET /v1/accounts/{id:[0-9]+}/hmac":                                   {ID: "accountHMACGet", Summary: "Get the HMAC secret of an account", Response: account.HMACResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/hmac":                                  {ID: "accountHMACRotate", Summary: "Rotate the HMAC secret of an account", Response: account.HMACResponse{}},
+	"DELETE /v1/accounts/{id:[0-9]+}/hmac":                                {ID: "accountHMACDelete", Summary: "Delete the HMAC secret of an account"},
+	"GET /v1/accounts/{id:[0-9]+}/webhooks":                               {ID: "accountWebhookList", Summary: "List the webhooks of an account", Response: account.WebhooksResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/webhooks":                              {ID: "accountWebhookCreate", Summary: "Register a webhook of an account", Request: datastore.Webhook{}, Response: account.WebhookResponse{}},
+	"DELETE /v1/accounts/{id:[0-9]+}/webhooks/{webhookid:[0-9]+}":         {ID: "accountWebhookDelete", Summary: "Delete a webhook of an account"},
+	"GET /v1/accounts/{id:[0-9]+}/webhooks/{webhookid:[0-9]+}/deliveries": {ID: "accountWebhookDeliveries", Summary: "List the deliveries of a webhook", Query: []string{"status"}, Response: account.WebhookDeliveriesResponse{}},
+	"GET /v1/accounts/{id:[0-9]+}/config":                                 {ID: "accountConfigExport", Summary: "Export the configuration of an account as YAML"},
+	"PUT /v1/accounts/{id:[0-9]+}/config":                                 {ID: "accountConfigApply", Summary: "Apply a YAML configuration to an account", Query: configQuery, Request: account.AccountConfig{}, Response: account.ConfigResponse{}},
+	"POST /v1/accounts/upload":                                            {ID: "accountUpload", Summary: "Upload an account assertion", Request: account.AssertionRequest{}},
+	"POST /v1/accounts/refresh":                                           {ID: "accountRefresh", Summary: "Refresh the assertions of the accounts from the store", Response: account.RefreshResponse{}},
+	"GET /api/accounts":                                                   {ID: "accountAPIList", Summary: "List the accounts", Response: account.ListResponse{}},
+	"POST /api/accounts":                                                  {ID: "accountAPICreate", Summary: "Create an account", Request: datastore.Account{}},
+	"PUT /api/accounts/{authorityID}":                                     {ID: "accountAPIPut", Summary: "Create or update the account of an authority ID", Request: datastore.Account{}, Response: account.GetResponse{}},
+
+	// Admin routes: sub-store models
+	"GET /v1/accounts/{id:[0-9]+}/stores":                          {ID: "substoreList", Summary: "List the sub-store models of an account", Tag: "reseller", Query: substoreQuery, Response: substore.ListResponse{}},
+	"POST /v1/accounts/stores":                                     {ID: "substoreCreate", Summary: "Create a sub-store model", Tag: "reseller", Request: datastore.Substore{}, Response: substore.InstanceResponse{}},
+	"PUT /v1/accounts/stores/{id:[0-9]+}":                          {ID: "substoreUpdate", Summary: "Update a sub-store model", Tag: "reseller", Request: datastore.Substore{}},
+	"DELETE /v1/accounts/stores/{id:[0-9]+}":                       {ID: "substoreDelete", Summary: "Delete a sub-store model", Tag: "reseller"},
+	"GET /v1/accounts/{id:[0-9]+}/stores/deleted":                  {ID: "substoreListDeleted", Summary: "List the deleted sub-store models of an account", Tag: "reseller", Response: substore.ListResponse{}},
+	"POST /v1/accounts/stores/{id:[0-9]+}/restore":                 {ID: "substoreRestore", Summary: "Restore a deleted sub-store model", Tag: "reseller"},
+	"POST /v1/accounts/{id:[0-9]+}/stores/bulk":                    {ID: "substoreBulk", Summary: "Create or update sub-store models in bulk", Tag: "reseller", Request: []datastore.Substore{}, Response: substore.BulkResponse{}},
+	"GET /v1/accounts/stores/{modelID:[0-9]+}/{serial}/pivots":     {ID: "substorePivots", Summary: "List the pivots of a device", Tag: "reseller", Response: substore.PivotsResponse{}},
+	"GET /v1/accounts/{id:[0-9]+}/stores/users":                    {ID: "substoreUserList", Summary: "List the sub-store admins of an account", Tag: "reseller", Response: substore.UserListResponse{}},
+	"POST /v1/accounts/{id:[0-9]+}/stores/users":                   {ID: "substoreUserCreate", Summary: "Create a sub-store admin of an account", Tag: "reseller", Request: datastore.SubstoreUser{}, Response: substore.UserCreateResponse{}},
+	"DELETE /v1/accounts/{id:[0-9]+}/stores/users/{userid:[0-9]+}": {ID: "substoreUserDelete", Summary: "Delete a sub-store admin of an account", Tag: "reseller"},
+	"GET /api/accounts/{id:[0-9]+}/config":                         {ID: "accountAPIConfigExport", Summary: "Export the configuration of an account as YAML"},
+	"PUT /api/accounts/{id:[0-9]+}/config":                         {ID: "accountAPIConfigApply", Summary: "Apply a YAML configuration to an account", Query: configQuery, Request: account.AccountConfig{}, Response: account.ConfigResponse{}},
+	"GET /api/accounts/{id:[0-9]+}/stores":                         {ID: "substoreAPIList", Summary: "List the sub-store models of an account", Tag: "reseller", Query: substoreQuery, Response: substore.ListResponse{}},
+	"POST /api/accounts/stores":                                    {ID: "substoreAPICreate", Summary: "Create a sub-store model", Tag: "reseller", Request: datastore.Substore{}, Response: substore.InstanceResponse{}},
+	"PUT /api/accounts/stores/{id:[0-9]+}":                         {ID: "substoreAPIUpdate", Summary: "Update a sub-store model", Tag: "reseller", Request: datastore.Substore{}},
+	"DELETE /api/accounts/stores/{id:[0-9]+}":                      {ID: "substoreAPIDelete", Summary: "Delete a sub-store model", Tag: "reseller"},
+	"GET /api/accounts/{id:[0-9]+}/stores/deleted":                 {ID: "substoreAPIListDeleted", Summary: "List the deleted sub-store models of an account", Tag: "reseller", Response: substore.ListResponse{}},
+	"POST /api/accounts/stores/{id:[0-9]+}/restore":                {ID: "substoreAPIRestore", Summary: "Restore a deleted sub-store model", Tag: "reseller"},
+	"POST /api/accounts/{id:[0-9]+}/stores/bulk":                   {ID: "substoreAPIBulk", Summary: "Create or update sub-store models in bulk", Tag: "reseller", Request: []datastore.Substore{}, Response: substore.BulkResponse{}},
+	"GET /api/accounts/stores/{modelID:[0-9]+}/{serial}":           {ID: "substoreAPIGet", Summary: "Get the sub-store model of a device", Tag: "reseller", Response: substore.InstanceResponse{}},
+	"GET /api/accounts/stores/{modelID:[0-9]+}/{serial}/pivots":    {ID: "substoreAPIPivots", Summary: "List the pivots of a device", Tag: "reseller", Response: substore.PivotsResponse{}},
+
+	// Admin routes: assertions
+	"POST /v1/assertions":                {ID: "assertionSystemUserAssertion", Summary: "Create a system-user assertion", Request: assertion.SystemUserRequest{}, Response: assertion.SystemUserResponse{}},
+	"POST /v1/assertions/batch":          {ID: "assertionSystemUserBatch", Summary: "Create system-user assertions in bulk", Request: assertion.SystemUserBatchRequest{}, Response: assertion.SystemUserBatchResponse{}},
+	"POST /v1/assertions/validationset":  {ID: "assertionValidationSet", Summary: "Sign a validation-set assertion", Request: assertion.ValidationSetRequest{}, Response: spec.Assertions},
+	"POST /api/assertions":               {ID: "assertionAPISystemUser", Summary: "Create a system-user assertion", Request: assertion.SystemUserRequest{}, Response: assertion.SystemUserResponse{}},
+	"POST /api/assertions/batch":         {ID: "assertionAPISystemUserBatch", Summary: "Create system-user assertions in bulk", Request: assertion.SystemUserBatchRequest{}, Response: assertion.SystemUserBatchResponse{}},
+	"POST /api/assertions/validationset": {ID: "assertionAPIValidationSet", Summary: "Sign a validation-set assertion", Request: assertion.ValidationSetRequest{}, Response: spec.Assertions},
+	"POST /api/assertions/checkserial":   {ID: "assertionAPIValidateSerial", Summary: "Check that a serial assertion belongs to a model or a sub-store model", Request: spec.Assertions},
+
+	// Admin routes: users and service accounts
+	"GET /v1/users":                                        {ID: "userList", Summary: "List the users", Response: user.ListResponse{}},
+	"POST /v1/users":                                       {ID: "userCreate", Summary: "Create a user", Request: user.Request{}},
+	"POST /v1/users/bulk":                                  {ID: "userBulk", Summary: "Create users in bulk", Request: []user.Request{}, Response: user.BulkResponse{}},
+	"POST /v1/users/invite":                                {ID: "userInvite", Summary: "Invite a user by email", Request: user.Request{}, Response: user.InviteResponse{}},
+	"GET /v1/users/{id:[0-9]+}":                            {ID: "userGet", Summary: "Get a user", Response: user.GetResponse{}},
+	"PUT /v1/users/{id:[0-9]+}":                            {ID: "userUpdate", Summary: "Update a user", Request: user.Request{}},
+	"DELETE /v1/users/{id:[0-9]+}":                         {ID: "userDelete", Summary: "Delete a user"},
+	"POST /v1/users/{id:[0-9]+}/deactivate":                {ID: "userDeactivate", Summary: "Deactivate a user"},
+	"POST /v1/users/{id:[0-9]+}/activate":                  {ID: "userActivate", Summary: "Activate a user"},
+	"GET /v1/users/{id:[0-9]+}/otheraccounts":              {ID: "userGetOtherAccounts", Summary: "List the accounts that are not assigned to a user", Response: user.AccountsResponse{}},
+	"GET /v1/users/{id:[0-9]+}/tokens":                     {ID: "userTokenList", Summary: "List the personal access tokens of a user", Response: user.TokenListResponse{}},
+	"POST /v1/users/{id:[0-9]+}/tokens":                    {ID: "userTokenCreate", Summary: "Create a personal access token of a user", Request: datastore.AccessToken{}, Response: user.TokenResponse{}},
+	"DELETE /v1/users/{id:[0-9]+}/tokens/{tokenid:[0-9]+}": {ID: "userTokenDelete", Summary: "Delete a personal access token of a user"},
+	"POST /v1/users/{id:[0-9]+}/sessions/revoke":           {ID: "userRevokeSessions", Summary: "Revoke the sessions of a user"},
+	"GET /v1/serviceaccounts":                              {ID: "serviceAccountList", Summary: "List the service accounts", Response: serviceaccount.ListResponse{}},
+	"POST /v1/serviceaccounts":                             {ID: "serviceAccountCreate", Summary: "Create a service account", Request: datastore.ServiceAccount{}, Response: serviceaccount.GetResponse{}},
+	"PUT /v1/serviceaccounts/{id:[0-9]+}":                  {ID: "serviceAccountUpdate", Summary: "Update a service account", Request: datastore.ServiceAccount{}},
+	"DELETE /v1/serviceaccounts/{id:[0-9]+}":               {ID: "serviceAccountDelete", Summary: "Delete a service account"},
+	"POST /v1/serviceaccounts/{id:[0-9]+}/secret":          {ID: "serviceAccountResetSecret", Summary: "Reset the secret of a service account", Response: serviceaccount.GetResponse{}},
+	"POST /api/token":                                      {ID: "serviceAccountToken", Summary: "Get the JWT of a service account with the client credentials", Public: true, Request: spec.Text("application/x-www-form-urlencoded"), Response: serviceaccount.TokenResponse{}},
+}
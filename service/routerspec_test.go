@@ -0,0 +1,98 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package service
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/spec"
+	"github.com/gorilla/mux"
+)
+
+func TestRouterSpecDocumentsRoutes(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{Version: "2.9"}}
+
+	used := map[string]bool{}
+	routers := []struct {
+		router *mux.Router
+		api    spec.API
+	}{
+		{SigningRouter(), signingSpec()},
+		{AdminRouter(), adminSpec()},
+	}
+
+	for _, r := range routers {
+		doc, err := spec.Generate(r.router, r.api)
+		if err != nil {
+			t.Fatalf("Error generating the spec of %s: %v", r.api.Title, err)
+		}
+		for _, item := range doc.Paths {
+			for _, op := range item {
+				used[op.OperationID] = true
+			}
+		}
+	}
+
+	// Every documented operation must be a registered route
+	for key, op := range apiOperations {
+		if !used[op.ID] {
+			t.Errorf("The operation %s (%s) is not a registered route", op.ID, key)
+		}
+	}
+}
+
+func TestRouterSpecEndpoint(t *testing.T) {
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config.Settings{Version: "2.9"}}
+
+	tests := []struct {
+		router *mux.Router
+		title  string
+		path   string
+	}{
+		{SigningRouter(), "Serial Vault Signing API", "/v1/pivotserial"},
+		{AdminRouter(), "Serial Vault Admin API", "/api/accounts/stores/{modelID}/{serial}"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", "/v1/spec", nil)
+		tt.router.ServeHTTP(w, r)
+
+		if w.Code != 200 {
+			t.Fatalf("%s: expected 200, got %d: %s", tt.title, w.Code, w.Body.String())
+		}
+
+		doc := spec.Document{}
+		if err := json.NewDecoder(w.Body).Decode(&doc); err != nil {
+			t.Fatalf("%s: error decoding the spec: %v", tt.title, err)
+		}
+		if doc.OpenAPI != "3.0.3" || doc.Info.Title != tt.title || doc.Info.Version != "2.9" {
+			t.Errorf("%s: unexpected document info: %s %v", tt.title, doc.OpenAPI, doc.Info)
+		}
+		if _, ok := doc.Paths[tt.path]; !ok {
+			t.Errorf("%s: expected the path %s", tt.title, tt.path)
+		}
+	}
+}
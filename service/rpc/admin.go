@@ -0,0 +1,397 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rpc
+
+import (
+	"context"
+	"encoding/base64"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/lockout"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+// AdminService implements the gRPC admin service, with the same permissions and
+// audit log as the account, keypair and model methods of the admin API
+type AdminService struct {
+	UnimplementedAdminServer
+}
+
+// NewAdminServer creates the gRPC server with the admin service registered
+func NewAdminServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, logger))
+	srv := grpc.NewServer(opts...)
+	RegisterAdminServer(srv, &AdminService{})
+	return srv
+}
+
+// ListAccounts lists the accounts of the user
+func (s *AdminService) ListAccounts(ctx context.Context, req *ListAccountsRequest) (*ListAccountsResponse, error) {
+	user, err := authorize(ctx, datastore.AdminScopeAccounts, "GET", datastore.SyncUser)
+	if err != nil {
+		return nil, err
+	}
+
+	accounts, err := datastore.Environ.DB.ListAllowedAccounts(ctx, user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-fetch-accounts", Message: err.Error()})
+	}
+
+	resp := &ListAccountsResponse{}
+	for _, a := range accounts {
+		resp.Accounts = append(resp.Accounts, accountToProto(a))
+	}
+	return resp, nil
+}
+
+// CreateAccount creates an account
+func (s *AdminService) CreateAccount(ctx context.Context, req *CreateAccountRequest) (*Account, error) {
+	user, err := authorize(ctx, datastore.AdminScopeAccounts, "POST", datastore.Superuser)
+	if err != nil {
+		return nil, err
+	}
+
+	acct := datastore.Account{AuthorityID: strings.TrimSpace(req.GetAuthorityId()), ResellerAPI: req.GetResellerApi()}
+	if len(acct.AuthorityID) == 0 {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidAccount.Code, Message: "The authority-id is mandatory"})
+	}
+
+	if err := datastore.Environ.DB.CreateAccount(ctx, acct); err != nil {
+		log.FromContext(ctx).Errorf("Error creating the account: %v", err)
+		return nil, formatError(response.ErrorResponse{Code: "error-creating-account", Message: "Error creating the account in the database"})
+	}
+
+	acct, err = datastore.Environ.DB.GetAccount(ctx, acct.AuthorityID)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidAccount.Code, Message: err.Error()})
+	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectAccount, acct.ID, acct.AuthorityID, nil, acct)
+
+	return accountToProto(acct), nil
+}
+
+// ListKeypairs lists the signing-keys of the accounts of the user
+func (s *AdminService) ListKeypairs(ctx context.Context, req *ListKeypairsRequest) (*ListKeypairsResponse, error) {
+	user, err := authorize(ctx, datastore.AdminScopeKeypairs, "GET", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(ctx, user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorFetchKeypairs.Code, Message: err.Error()})
+	}
+
+	resp := &ListKeypairsResponse{}
+	for _, k := range keypairs {
+		resp.Keypairs = append(resp.Keypairs, keypairToProto(k))
+	}
+	return resp, nil
+}
+
+// CreateKeypair imports a signing-key into the keystore of the service
+func (s *AdminService) CreateKeypair(ctx context.Context, req *CreateKeypairRequest) (*Keypair, error) {
+	user, err := authorize(ctx, datastore.AdminScopeKeypairs, "POST", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	authorityID := strings.TrimSpace(req.GetAuthorityId())
+	keyName := strings.TrimSpace(req.GetKeyName())
+	switch {
+	case len(authorityID) == 0:
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidKeypair.Code, Message: "The authority-id is mandatory"})
+	case len(keyName) == 0:
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidKeypair.Code, Message: "The key name must be supplied"})
+	case !datastore.Environ.DB.CheckUserInAccount(ctx, user.Username, authorityID):
+		return nil, formatError(response.ErrorAuth)
+	case datastore.Environ.DB.CheckKeypairKeynameExists(ctx, authorityID, keyName):
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidKeypair.Code, Message: "A key with this name already exists for this Signing Authority"})
+	}
+
+	// Store the signing-key in the keypair store, which takes the encoded key of the admin API
+	privateKey, sealedPrivateKey, err := datastore.Environ.KeypairDB.ImportSigningKey(authorityID, base64.StdEncoding.EncodeToString(req.GetPrivateKey()))
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorStoreKeypair.Code, Message: err.Error()})
+	}
+
+	keypair := datastore.Keypair{
+		AuthorityID: authorityID,
+		KeyID:       privateKey.PublicKey().ID(),
+		SealedKey:   sealedPrivateKey,
+		KeyName:     keyName,
+	}
+	errorCode, err := datastore.Environ.DB.PutKeypair(ctx, keypair)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: errorCode, Message: err.Error()})
+	}
+
+	keypair, err = datastore.Environ.DB.GetKeypairByName(ctx, authorityID, keyName)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorFetchKeypair.Code, Message: err.Error()})
+	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectKeypair, keypair.ID, keypair.AuthorityID, nil, keypair)
+	return keypairToProto(keypair), nil
+}
+
+// ListModels lists a page of the models of the accounts of the user
+func (s *AdminService) ListModels(ctx context.Context, req *ListModelsRequest) (*ListModelsResponse, error) {
+	user, err := authorize(ctx, datastore.AdminScopeModels, "GET", datastore.Standard)
+	if err != nil {
+		return nil, err
+	}
+
+	if req.GetOffset() < 0 || req.GetLimit() < 0 || req.GetKeypairId() < 0 {
+		return nil, formatError(response.ErrorResponse{Code: "error-fetch-models", Message: "The offset, page size and keypair ID must not be negative"})
+	}
+	filter := datastore.ModelFilter{
+		BrandID:   req.GetBrandId(),
+		Name:      req.GetName(),
+		KeypairID: int(req.GetKeypairId()),
+		Offset:    int(req.GetOffset()),
+		Limit:     int(req.GetLimit()),
+	}
+	if filter.Limit == 0 {
+		filter.Limit = datastore.ListModelsDefaultLimit
+	}
+	if filter.Limit > datastore.ListModelsMaxLimit {
+		filter.Limit = datastore.ListModelsMaxLimit
+	}
+
+	// Fetch an extra model to check if there is a next page
+	limit := filter.Limit
+	filter.Limit++
+
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, user, filter)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-fetch-models", Message: err.Error()})
+	}
+
+	resp := &ListModelsResponse{}
+	if len(models) > limit {
+		models = models[:limit]
+		resp.NextOffset = int32(filter.Offset + limit)
+	}
+	for _, m := range models {
+		resp.Models = append(resp.Models, modelToProto(m))
+	}
+	return resp, nil
+}
+
+// GetModel gets a model
+func (s *AdminService) GetModel(ctx context.Context, req *GetModelRequest) (*Model, error) {
+	user, err := authorize(ctx, datastore.AdminScopeModels, "GET", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	model, err := datastore.Environ.DB.GetAllowedModel(ctx, int(req.GetId()), user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidModelID.Code, Message: err.Error()})
+	}
+	return modelToProto(model), nil
+}
+
+// CreateModel creates a model
+func (s *AdminService) CreateModel(ctx context.Context, req *CreateModelRequest) (*Model, error) {
+	user, err := authorize(ctx, datastore.AdminScopeModels, "POST", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetModel() == nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-model-data", Message: "The model must be supplied"})
+	}
+
+	model, errorSubcode, err := datastore.Environ.DB.CreateAllowedModel(ctx, modelFromProto(req.GetModel()), user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-model-data", SubCode: errorSubcode, Message: err.Error()})
+	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectModel, model.ID, model.BrandID, nil, model)
+
+	return modelToProto(model), nil
+}
+
+// UpdateModel updates a model from the version that was read
+func (s *AdminService) UpdateModel(ctx context.Context, req *UpdateModelRequest) (*Model, error) {
+	user, err := authorize(ctx, datastore.AdminScopeModels, "PUT", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+	if req.GetModel() == nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-model-data", Message: "The model must be supplied"})
+	}
+
+	// The update must be based on a version of the model, so a concurrent change is not overwritten
+	mdl := modelFromProto(req.GetModel())
+	if mdl.Version <= 0 {
+		return nil, formatError(response.ErrorVersionRequired)
+	}
+
+	// The model before the change, for the audit log
+	before, err := datastore.Environ.DB.GetAllowedModel(ctx, mdl.ID, user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidModelID.Code, Message: err.Error()})
+	}
+
+	errorSubcode, err := datastore.Environ.DB.UpdateAllowedModel(ctx, mdl, user)
+	if err == datastore.ErrVersionConflict {
+		return nil, formatError(response.ErrorVersionConflict)
+	}
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-updating-model", SubCode: errorSubcode, Message: err.Error()})
+	}
+	audit.Record(ctx, user, audit.ActionUpdate, audit.ObjectModel, mdl.ID, mdl.BrandID, before, mdl)
+
+	model, err := datastore.Environ.DB.GetAllowedModel(ctx, mdl.ID, user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidModelID.Code, Message: err.Error()})
+	}
+	return modelToProto(model), nil
+}
+
+// DeleteModel deletes a model, which can be restored from the admin API
+func (s *AdminService) DeleteModel(ctx context.Context, req *DeleteModelRequest) (*DeleteModelResponse, error) {
+	user, err := authorize(ctx, datastore.AdminScopeModels, "DELETE", datastore.Admin)
+	if err != nil {
+		return nil, err
+	}
+
+	// The model before the change, for the audit log
+	before, err := datastore.Environ.DB.GetAllowedModel(ctx, int(req.GetId()), user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: response.ErrorInvalidModelID.Code, Message: err.Error()})
+	}
+
+	errorSubcode, err := datastore.Environ.DB.DeleteAllowedModel(ctx, datastore.Model{ID: before.ID}, user)
+	if err != nil {
+		return nil, formatError(response.ErrorResponse{Code: "error-deleting-model", SubCode: errorSubcode, Message: err.Error()})
+	}
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectModel, before.ID, before.BrandID, before, nil)
+
+	return &DeleteModelResponse{}, nil
+}
+
+// authorize authenticates the bearer token of the call for the resource and the HTTP method of
+// the equivalent admin API route, and checks the role of its user. The failed authentications
+// lock out the source IP, in the same way as the admin API
+func authorize(ctx context.Context, resource, method string, role int) (datastore.User, error) {
+	sourceIP := clientFromContext(ctx).SourceIP
+	if err := lockout.Check(ctx, "", sourceIP); err != nil {
+		return datastore.User{}, formatError(response.ErrorLockedOut)
+	}
+
+	user, err := request.CheckBearerToken(ctx, bearerTokenFromContext(ctx), resource, method)
+	if err != nil {
+		log.FromContext(ctx).Warningf("Error authenticating the gRPC call: %v", err)
+		lockout.Failure(ctx, lockout.ObjectAPIKey, "", sourceIP)
+		return user, formatError(response.ErrorResponse{Code: response.ErrorInvalidAPIKey.Code, Message: "The bearer token is invalid or does not have the scope of the method"})
+	}
+
+	if err := auth.CheckUserPermissions(user, role, true); err != nil {
+		return user, formatError(response.ErrorResponse{Code: response.ErrorAuth.Code, Message: err.Error()})
+	}
+	return user, nil
+}
+
+// bearerTokenFromContext gets the bearer token from the authorization metadata of the call
+func bearerTokenFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("authorization")
+	if len(values) == 0 || !strings.HasPrefix(values[0], "Bearer ") {
+		return ""
+	}
+	return strings.TrimSpace(strings.TrimPrefix(values[0], "Bearer "))
+}
+
+func accountToProto(a datastore.Account) *Account {
+	return &Account{
+		Id:               int64(a.ID),
+		AuthorityId:      a.AuthorityID,
+		ResellerApi:      a.ResellerAPI,
+		DefaultKeypairId: int64(a.DefaultKeypairID),
+		Version:          int64(a.Version),
+	}
+}
+
+func keypairToProto(k datastore.Keypair) *Keypair {
+	return &Keypair{
+		Id:          int64(k.ID),
+		AuthorityId: k.AuthorityID,
+		KeyId:       k.KeyID,
+		KeyName:     k.KeyName,
+		Active:      k.Active,
+		Version:     int64(k.Version),
+	}
+}
+
+func modelToProto(m datastore.Model) *Model {
+	return &Model{
+		Id:              int64(m.ID),
+		BrandId:         m.BrandID,
+		Name:            m.Name,
+		KeypairId:       int64(m.KeypairID),
+		KeypairIdUser:   int64(m.KeypairIDUser),
+		ApiKey:          m.APIKey,
+		SerialFormat:    m.SerialFormat,
+		SerialHeaders:   m.SerialHeaders,
+		SigningHours:    m.SigningHours,
+		SigningTimezone: m.SigningTimezone,
+		AllowedCidrs:    m.AllowedCIDRs,
+		AuthorityId:     m.AuthorityID,
+		KeyId:           m.KeyID,
+		KeyActive:       m.KeyActive,
+		AuthorityIdUser: m.AuthorityIDUser,
+		KeyIdUser:       m.KeyIDUser,
+		KeyActiveUser:   m.KeyActiveUser,
+		Version:         int64(m.Version),
+	}
+}
+
+// modelFromProto converts the fields of a model that can be changed, as the keys of the model
+// are set from the keypairs
+func modelFromProto(m *Model) datastore.Model {
+	return datastore.Model{
+		ID:              int(m.GetId()),
+		BrandID:         m.GetBrandId(),
+		Name:            m.GetName(),
+		KeypairID:       int(m.GetKeypairId()),
+		KeypairIDUser:   int(m.GetKeypairIdUser()),
+		APIKey:          m.GetApiKey(),
+		SerialFormat:    m.GetSerialFormat(),
+		SerialHeaders:   m.GetSerialHeaders(),
+		SigningHours:    m.GetSigningHours(),
+		SigningTimezone: m.GetSigningTimezone(),
+		AllowedCIDRs:    m.GetAllowedCidrs(),
+		Version:         int(m.GetVersion()),
+	}
+}
@@ -0,0 +1,217 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rpc_test
+
+import (
+	"context"
+	"io/ioutil"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/rpc"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	check "gopkg.in/check.v1"
+)
+
+type AdminSuite struct{}
+
+var _ = check.Suite(&AdminSuite{})
+
+func (s *AdminSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "memory", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.Environ.KeypairDB, _ = datastore.GetMemoryKeyStore(config)
+}
+
+// contextWithToken returns the context of a call with the service token of the user
+func contextWithToken(c *check.C, username string, scopes ...string) context.Context {
+	token, err := usso.NewServiceToken(datastore.ServiceAccount{Username: username, ClientID: "sv-client"}, scopes, time.Hour)
+	c.Assert(err, check.IsNil)
+	return contextWithBearer(token)
+}
+
+func contextWithBearer(token string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("authorization", "Bearer "+token))
+}
+
+func (s *AdminSuite) TestAuthentication(c *check.C) {
+	tests := []struct {
+		Context context.Context
+		Code    codes.Code
+	}{
+		{context.Background(), codes.Unauthenticated},
+		{contextWithBearer("invalid"), codes.Unauthenticated},
+		{contextWithBearer("svpat_SteveToken"), codes.OK},
+		{contextWithBearer("svpat_InvalidToken"), codes.Unauthenticated},
+		{contextWithToken(c, "sv", datastore.AdminScopeModels), codes.OK},
+		{contextWithToken(c, "sv", datastore.AdminScopeModels+datastore.AdminScopeReadSuffix), codes.OK},
+		{contextWithToken(c, "sv", datastore.AdminScopeKeypairs), codes.Unauthenticated},
+		{contextWithToken(c, "invalid", datastore.AdminScopeModels), codes.Unauthenticated},
+	}
+
+	srv := &rpc.AdminService{}
+
+	for _, t := range tests {
+		resp, err := srv.ListModels(t.Context, &rpc.ListModelsRequest{})
+		c.Assert(status.Code(err), check.Equals, t.Code)
+		if t.Code == codes.OK {
+			c.Assert(resp.GetModels(), check.HasLen, 3)
+		}
+	}
+}
+
+func (s *AdminSuite) TestAccounts(c *check.C) {
+	srv := &rpc.AdminService{}
+
+	list, err := srv.ListAccounts(contextWithToken(c, "sv", datastore.AdminScopeAccounts), &rpc.ListAccountsRequest{})
+	c.Assert(err, check.IsNil)
+	c.Assert(list.GetAccounts(), check.HasLen, 3)
+	c.Assert(list.GetAccounts()[0].GetAuthorityId(), check.Equals, "system")
+
+	// The accounts are created by a superuser
+	_, err = srv.CreateAccount(contextWithToken(c, "sv", datastore.AdminScopeAccounts), &rpc.CreateAccountRequest{AuthorityId: "system"})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+
+	_, err = srv.CreateAccount(contextWithToken(c, "root", datastore.AdminScopeAccounts), &rpc.CreateAccountRequest{})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+
+	acct, err := srv.CreateAccount(contextWithToken(c, "root", datastore.AdminScopeAccounts), &rpc.CreateAccountRequest{AuthorityId: "system", ResellerApi: true})
+	c.Assert(err, check.IsNil)
+	c.Assert(acct.GetId(), check.Equals, int64(1))
+	c.Assert(acct.GetResellerApi(), check.Equals, true)
+}
+
+func (s *AdminSuite) TestKeypairs(c *check.C) {
+	srv := &rpc.AdminService{}
+
+	list, err := srv.ListKeypairs(contextWithBearer("svpat_SteveToken"), &rpc.ListKeypairsRequest{})
+	c.Assert(err, check.IsNil)
+	c.Assert(list.GetKeypairs(), check.HasLen, 2)
+
+	// The access token is limited to reading the signing-keys
+	signingKey, err := ioutil.ReadFile("../../keystore/TestKey.asc")
+	c.Assert(err, check.IsNil)
+	_, err = srv.CreateKeypair(contextWithBearer("svpat_SteveToken"), &rpc.CreateKeypairRequest{AuthorityId: "system", KeyName: "serial-key", PrivateKey: signingKey})
+	c.Assert(status.Code(err), check.Equals, codes.Unauthenticated)
+
+	ctx := contextWithToken(c, "sv", datastore.AdminScopeKeypairs)
+	tests := []struct {
+		Request *rpc.CreateKeypairRequest
+		Code    codes.Code
+	}{
+		{&rpc.CreateKeypairRequest{AuthorityId: "system", KeyName: "serial-key", PrivateKey: signingKey}, codes.OK},
+		{&rpc.CreateKeypairRequest{KeyName: "serial-key", PrivateKey: signingKey}, codes.InvalidArgument},
+		{&rpc.CreateKeypairRequest{AuthorityId: "system", PrivateKey: signingKey}, codes.InvalidArgument},
+		{&rpc.CreateKeypairRequest{AuthorityId: "system", KeyName: "invalid", PrivateKey: signingKey}, codes.InvalidArgument},
+		{&rpc.CreateKeypairRequest{AuthorityId: "system", KeyName: "serial-key", PrivateKey: []byte("invalid")}, codes.InvalidArgument},
+	}
+
+	for _, t := range tests {
+		keypair, err := srv.CreateKeypair(ctx, t.Request)
+		c.Assert(status.Code(err), check.Equals, t.Code)
+		if t.Code == codes.OK {
+			c.Assert(keypair.GetAuthorityId(), check.Equals, "system")
+		}
+	}
+}
+
+func (s *AdminSuite) TestModels(c *check.C) {
+	srv := &rpc.AdminService{}
+	ctx := contextWithToken(c, "sv", datastore.AdminScopeModels)
+
+	// The page has the offset of the next page, when there are more models
+	list, err := srv.ListModels(ctx, &rpc.ListModelsRequest{Limit: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(list.GetModels(), check.HasLen, 2)
+	c.Assert(list.GetNextOffset(), check.Equals, int32(2))
+
+	list, err = srv.ListModels(ctx, &rpc.ListModelsRequest{Offset: 2, Limit: 2})
+	c.Assert(err, check.IsNil)
+	c.Assert(list.GetModels(), check.HasLen, 1)
+	c.Assert(list.GetNextOffset(), check.Equals, int32(0))
+
+	_, err = srv.ListModels(ctx, &rpc.ListModelsRequest{Offset: -1})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+
+	model, err := srv.GetModel(ctx, &rpc.GetModelRequest{Id: 1})
+	c.Assert(err, check.IsNil)
+	c.Assert(model.GetName(), check.Equals, "alder")
+	c.Assert(model.GetKeyActive(), check.Equals, true)
+
+	_, err = srv.GetModel(ctx, &rpc.GetModelRequest{Id: 99})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+
+	model, err = srv.CreateModel(ctx, &rpc.CreateModelRequest{Model: &rpc.Model{BrandId: "system", Name: "the-model", KeypairId: 1, KeypairIdUser: 1}})
+	c.Assert(err, check.IsNil)
+	c.Assert(model.GetId(), check.Equals, int64(7))
+
+	_, err = srv.CreateModel(ctx, &rpc.CreateModelRequest{})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+
+	// A standard user can list the models, but not change them
+	_, err = srv.CreateModel(contextWithToken(c, "user1", datastore.AdminScopeModels), &rpc.CreateModelRequest{Model: &rpc.Model{BrandId: "system", Name: "the-model"}})
+	c.Assert(status.Code(err), check.Equals, codes.PermissionDenied)
+
+	_, err = srv.DeleteModel(ctx, &rpc.DeleteModelRequest{Id: 1})
+	c.Assert(err, check.IsNil)
+
+	_, err = srv.DeleteModel(ctx, &rpc.DeleteModelRequest{Id: 99})
+	c.Assert(status.Code(err), check.Equals, codes.InvalidArgument)
+}
+
+func (s *AdminSuite) TestUpdateModel(c *check.C) {
+	tests := []struct {
+		Model *rpc.Model
+		Code  codes.Code
+	}{
+		{&rpc.Model{Id: 1, BrandId: "system", Name: "alder", KeypairId: 1, KeypairIdUser: 1, Version: 1}, codes.OK},
+		{&rpc.Model{Id: 1, BrandId: "system", Name: "alder", KeypairId: 1, KeypairIdUser: 1}, codes.FailedPrecondition},
+		{&rpc.Model{Id: 1, BrandId: "system", Name: "alder", KeypairId: 1, KeypairIdUser: 1, Version: 2}, codes.Aborted},
+		{&rpc.Model{Id: 1, BrandId: "system", Name: "ash", KeypairId: 1, KeypairIdUser: 1, Version: 1}, codes.InvalidArgument},
+		{&rpc.Model{Id: 99, BrandId: "system", Name: "alder", Version: 1}, codes.InvalidArgument},
+	}
+
+	srv := &rpc.AdminService{}
+	ctx := contextWithToken(c, "sv", datastore.AdminScopeModels)
+
+	for _, t := range tests {
+		model, err := srv.UpdateModel(ctx, &rpc.UpdateModelRequest{Model: t.Model})
+		c.Assert(status.Code(err), check.Equals, t.Code)
+		if t.Code == codes.OK {
+			c.Assert(model.GetId(), check.Equals, int64(1))
+		}
+	}
+}
+
+func (s *AdminSuite) TestErrors(c *check.C) {
+	srv := &rpc.AdminService{}
+	ctx := contextWithToken(c, "sv", datastore.AdminScopeModels)
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	// The user of the token cannot be found
+	_, err := srv.ListModels(ctx, &rpc.ListModelsRequest{})
+	c.Assert(status.Code(err), check.Equals, codes.Unauthenticated)
+}
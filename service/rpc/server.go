@@ -0,0 +1,157 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rpc
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative signing.proto admin.proto
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/sign"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+	"github.com/snapcore/snapd/asserts"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// maxRequestIDLength is the longest request ID from the client that is used for the logs
+const maxRequestIDLength = 64
+
+// SigningService implements the gRPC signing service, using the same
+// datastore and keystore as the REST signing API
+type SigningService struct {
+	UnimplementedSigningServer
+}
+
+// NewServer creates the gRPC server with the signing service registered
+func NewServer(opts ...grpc.ServerOption) *grpc.Server {
+	opts = append(opts, grpc.ChainUnaryInterceptor(tracing.UnaryServerInterceptor, logger))
+	srv := grpc.NewServer(opts...)
+	RegisterSigningServer(srv, &SigningService{})
+	return srv
+}
+
+// RequestID generates a nonce for the device serial-request
+func (s *SigningService) RequestID(ctx context.Context, req *RequestIDRequest) (*RequestIDResponse, error) {
+	nonce, errResponse := sign.GenerateRequestID(ctx, apiKeyFromContext(ctx), clientFromContext(ctx))
+	if !errResponse.Success {
+		return nil, formatError(errResponse)
+	}
+
+	return &RequestIDResponse{RequestId: nonce.Nonce}, nil
+}
+
+// Serial signs the serial-request assertion from the device
+func (s *SigningService) Serial(ctx context.Context, req *SerialRequest) (*SerialResponse, error) {
+	signedAssertion, errResponse := sign.SignSerial(ctx, apiKeyFromContext(ctx), clientFromContext(ctx), bytes.NewReader(req.GetAssertions()))
+	if !errResponse.Success {
+		return nil, formatError(errResponse)
+	}
+
+	return &SerialResponse{Serial: asserts.Encode(signedAssertion)}, nil
+}
+
+// apiKeyFromContext gets the model API key from the metadata of the call
+func apiKeyFromContext(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	values := md.Get("api-key")
+	if len(values) == 0 {
+		return ""
+	}
+	return values[0]
+}
+
+// clientFromContext gets the address, user agent and client certificate of the caller
+func clientFromContext(ctx context.Context) sign.Client {
+	client := sign.Client{}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		client.SourceIP = p.Addr.String()
+		if host, _, err := net.SplitHostPort(client.SourceIP); err == nil {
+			client.SourceIP = host
+		}
+		if info, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(info.State.VerifiedChains) > 0 && len(info.State.VerifiedChains[0]) > 0 {
+			client.Certificate = info.State.VerifiedChains[0][0]
+		}
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("user-agent"); len(values) > 0 {
+			client.UserAgent = values[0]
+		}
+	}
+	return client
+}
+
+// formatError converts the standard error response to a gRPC status error
+func formatError(e response.ErrorResponse) error {
+	code := codes.InvalidArgument
+	switch e.Code {
+	case response.ErrorInvalidAPIKey.Code:
+		code = codes.Unauthenticated
+	case response.ErrorLockedOut.Code:
+		code = codes.ResourceExhausted
+	case response.ErrorSourceNotAllowed.Code, response.ErrorClientCertNotAllowed.Code,
+		response.ErrorOutsideSigningHours.Code, response.ErrorModelSourceNotAllowed.Code, response.ErrorAuth.Code:
+		code = codes.PermissionDenied
+	case response.ErrorVersionRequired.Code:
+		code = codes.FailedPrecondition
+	case response.ErrorVersionConflict.Code:
+		code = codes.Aborted
+	}
+	return status.Error(code, fmt.Sprintf("%s: %s", e.Code, e.Message))
+}
+
+// logger handles logging for the gRPC service, in the same way as the web service.
+// The logs of the call have the request ID from the metadata, or a new ID, which is returned in the header
+func logger(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+
+	id := requestIDFromContext(ctx)
+	grpc.SetHeader(ctx, metadata.Pairs("x-request-id", id))
+	l := log.New("grpc").With(log.FieldRequestID, id)
+
+	resp, err := handler(log.NewContext(ctx, l), req)
+	l.Infof("%s %s %s", "GRPC", info.FullMethod, time.Since(start))
+	return resp, err
+}
+
+// requestIDFromContext gets the request ID from the metadata of the call, or generates one
+func requestIDFromContext(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if values := md.Get("x-request-id"); len(values) > 0 && len(values[0]) > 0 && len(values[0]) <= maxRequestIDLength {
+			return values[0]
+		}
+	}
+	return log.NewRequestID()
+}
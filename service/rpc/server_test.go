@@ -0,0 +1,153 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package rpc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"io/ioutil"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/crypt"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/rpc"
+	"github.com/snapcore/snapd/asserts"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	check "gopkg.in/check.v1"
+)
+
+func TestRPCSuite(t *testing.T) { check.TestingT(t) }
+
+type RPCSuite struct{}
+
+type SuiteTest struct {
+	MockError bool
+	Data      []byte
+	APIKey    string
+	Code      codes.Code
+}
+
+var _ = check.Suite(&RPCSuite{})
+
+func (s *RPCSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+}
+
+func contextWithAPIKey(apiKey string) context.Context {
+	return metadata.NewIncomingContext(context.Background(), metadata.Pairs("api-key", apiKey))
+}
+
+func (s *RPCSuite) TestRequestID(c *check.C) {
+	tests := []SuiteTest{
+		{false, nil, "InbuiltAPIKey", codes.OK},
+		{false, nil, "InvalidAPIKey", codes.Unauthenticated},
+		{false, nil, "", codes.Unauthenticated},
+		{true, nil, "InbuiltAPIKey", codes.InvalidArgument},
+	}
+
+	srv := &rpc.SigningService{}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		resp, err := srv.RequestID(contextWithAPIKey(t.APIKey), &rpc.RequestIDRequest{})
+		c.Assert(status.Code(err), check.Equals, t.Code)
+		if t.Code == codes.OK {
+			c.Assert(resp.GetRequestId(), check.Not(check.Equals), "")
+		}
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func (s *RPCSuite) TestSerial(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L")
+	c.Assert(err, check.IsNil)
+	assertFakeModel, err := generateSerialRequestAssertion("invalid", "A123456L")
+	c.Assert(err, check.IsNil)
+
+	tests := []SuiteTest{
+		{false, assert, "ValidAPIKey", codes.OK},
+		{false, assertFakeModel, "ValidAPIKey", codes.InvalidArgument},
+		{false, nil, "ValidAPIKey", codes.InvalidArgument},
+		{false, assert, "InvalidAPIKey", codes.Unauthenticated},
+		{true, assert, "ValidAPIKey", codes.InvalidArgument},
+	}
+
+	srv := &rpc.SigningService{}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		resp, err := srv.Serial(contextWithAPIKey(t.APIKey), &rpc.SerialRequest{Assertions: t.Data})
+		c.Assert(status.Code(err), check.Equals, t.Code)
+		if t.Code == codes.OK {
+			serial, err := asserts.Decode(resp.GetSerial())
+			c.Assert(err, check.IsNil)
+			c.Assert(serial.Type(), check.Equals, asserts.SerialType)
+			c.Assert(serial.HeaderString("serial"), check.Equals, "A123456L")
+		}
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+}
+
+func generateSerialRequestAssertion(model, serial string) ([]byte, error) {
+	signingKey, err := ioutil.ReadFile("../../keystore/TestDeviceKey.asc")
+	if err != nil {
+		return nil, err
+	}
+
+	privateKey, _, err := crypt.DeserializePrivateKey(base64.StdEncoding.EncodeToString(signingKey))
+	if err != nil {
+		return nil, err
+	}
+
+	encodedPubKey, err := asserts.EncodePublicKey(privateKey.PublicKey())
+	if err != nil {
+		return nil, err
+	}
+
+	headers := map[string]interface{}{
+		"brand-id":   "system",
+		"device-key": string(encodedPubKey),
+		"request-id": "REQID",
+		"model":      model,
+		"serial":     serial,
+	}
+
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, nil, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return asserts.Encode(sreq), nil
+}
@@ -0,0 +1,360 @@
+// -*- Mode: protobuf; indent-tabs-mode: nil -*-
+
+//
+// Copyright (C) 2020 Canonical Ltd
+// License granted by Canonical Limited
+//
+// This program is free software: you can redistribute it and/or modify
+// it under the terms of the GNU General Public License version 3 as
+// published by the Free Software Foundation.
+//
+// This program is distributed in the hope that it will be useful,
+// but WITHOUT ANY WARRANTY; without even the implied warranty of
+// MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+// GNU General Public License for more details.
+//
+// You should have received a copy of the GNU General Public License
+// along with this program.  If not, see <http://www.gnu.org/licenses/>.
+//
+
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.31.0
+// 	protoc        v3.21.12
+// source: signing.proto
+
+package rpc
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type RequestIDRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+}
+
+func (x *RequestIDRequest) Reset() {
+	*x = RequestIDRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_signing_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RequestIDRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestIDRequest) ProtoMessage() {}
+
+func (x *RequestIDRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signing_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestIDRequest.ProtoReflect.Descriptor instead.
+func (*RequestIDRequest) Descriptor() ([]byte, []int) {
+	return file_signing_proto_rawDescGZIP(), []int{0}
+}
+
+type RequestIDResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	RequestId string `protobuf:"bytes,1,opt,name=request_id,json=requestId,proto3" json:"request_id,omitempty"`
+}
+
+func (x *RequestIDResponse) Reset() {
+	*x = RequestIDResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_signing_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *RequestIDResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*RequestIDResponse) ProtoMessage() {}
+
+func (x *RequestIDResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signing_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use RequestIDResponse.ProtoReflect.Descriptor instead.
+func (*RequestIDResponse) Descriptor() ([]byte, []int) {
+	return file_signing_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *RequestIDResponse) GetRequestId() string {
+	if x != nil {
+		return x.RequestId
+	}
+	return ""
+}
+
+type SerialRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The encoded serial-request assertion, optionally followed by the model
+	// assertion and, for remodeling, the current serial assertion
+	Assertions []byte `protobuf:"bytes,1,opt,name=assertions,proto3" json:"assertions,omitempty"`
+}
+
+func (x *SerialRequest) Reset() {
+	*x = SerialRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_signing_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SerialRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SerialRequest) ProtoMessage() {}
+
+func (x *SerialRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_signing_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SerialRequest.ProtoReflect.Descriptor instead.
+func (*SerialRequest) Descriptor() ([]byte, []int) {
+	return file_signing_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *SerialRequest) GetAssertions() []byte {
+	if x != nil {
+		return x.Assertions
+	}
+	return nil
+}
+
+type SerialResponse struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	// The encoded, signed serial assertion
+	Serial []byte `protobuf:"bytes,1,opt,name=serial,proto3" json:"serial,omitempty"`
+}
+
+func (x *SerialResponse) Reset() {
+	*x = SerialResponse{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_signing_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *SerialResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*SerialResponse) ProtoMessage() {}
+
+func (x *SerialResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_signing_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use SerialResponse.ProtoReflect.Descriptor instead.
+func (*SerialResponse) Descriptor() ([]byte, []int) {
+	return file_signing_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *SerialResponse) GetSerial() []byte {
+	if x != nil {
+		return x.Serial
+	}
+	return nil
+}
+
+var File_signing_proto protoreflect.FileDescriptor
+
+var file_signing_proto_rawDesc = []byte{
+	0x0a, 0x0d, 0x73, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12,
+	0x0e, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x2e, 0x76, 0x31, 0x22,
+	0x12, 0x0a, 0x10, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x44, 0x52, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x22, 0x32, 0x0a, 0x11, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x44,
+	0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x1d, 0x0a, 0x0a, 0x72, 0x65, 0x71, 0x75,
+	0x65, 0x73, 0x74, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x72, 0x65,
+	0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x64, 0x22, 0x2f, 0x0a, 0x0d, 0x53, 0x65, 0x72, 0x69, 0x61,
+	0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1e, 0x0a, 0x0a, 0x61, 0x73, 0x73, 0x65,
+	0x72, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x0a, 0x61, 0x73,
+	0x73, 0x65, 0x72, 0x74, 0x69, 0x6f, 0x6e, 0x73, 0x22, 0x28, 0x0a, 0x0e, 0x53, 0x65, 0x72, 0x69,
+	0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x65,
+	0x72, 0x69, 0x61, 0x6c, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0c, 0x52, 0x06, 0x73, 0x65, 0x72, 0x69,
+	0x61, 0x6c, 0x32, 0xa8, 0x01, 0x0a, 0x07, 0x53, 0x69, 0x67, 0x6e, 0x69, 0x6e, 0x67, 0x12, 0x52,
+	0x0a, 0x09, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x44, 0x12, 0x20, 0x2e, 0x73, 0x65,
+	0x72, 0x69, 0x61, 0x6c, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x52, 0x65, 0x71,
+	0x75, 0x65, 0x73, 0x74, 0x49, 0x44, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x21, 0x2e,
+	0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x52,
+	0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x49, 0x44, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65,
+	0x22, 0x00, 0x12, 0x49, 0x0a, 0x06, 0x53, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x12, 0x1d, 0x2e, 0x73,
+	0x65, 0x72, 0x69, 0x61, 0x6c, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65,
+	0x72, 0x69, 0x61, 0x6c, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x1e, 0x2e, 0x73, 0x65,
+	0x72, 0x69, 0x61, 0x6c, 0x76, 0x61, 0x75, 0x6c, 0x74, 0x2e, 0x76, 0x31, 0x2e, 0x53, 0x65, 0x72,
+	0x69, 0x61, 0x6c, 0x52, 0x65, 0x73, 0x70, 0x6f, 0x6e, 0x73, 0x65, 0x22, 0x00, 0x42, 0x32, 0x5a,
+	0x30, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f, 0x43, 0x61, 0x6e, 0x6f,
+	0x6e, 0x69, 0x63, 0x61, 0x6c, 0x4c, 0x74, 0x64, 0x2f, 0x73, 0x65, 0x72, 0x69, 0x61, 0x6c, 0x2d,
+	0x76, 0x61, 0x75, 0x6c, 0x74, 0x2f, 0x73, 0x65, 0x72, 0x76, 0x69, 0x63, 0x65, 0x2f, 0x72, 0x70,
+	0x63, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_signing_proto_rawDescOnce sync.Once
+	file_signing_proto_rawDescData = file_signing_proto_rawDesc
+)
+
+func file_signing_proto_rawDescGZIP() []byte {
+	file_signing_proto_rawDescOnce.Do(func() {
+		file_signing_proto_rawDescData = protoimpl.X.CompressGZIP(file_signing_proto_rawDescData)
+	})
+	return file_signing_proto_rawDescData
+}
+
+var file_signing_proto_msgTypes = make([]protoimpl.MessageInfo, 4)
+var file_signing_proto_goTypes = []interface{}{
+	(*RequestIDRequest)(nil),  // 0: serialvault.v1.RequestIDRequest
+	(*RequestIDResponse)(nil), // 1: serialvault.v1.RequestIDResponse
+	(*SerialRequest)(nil),     // 2: serialvault.v1.SerialRequest
+	(*SerialResponse)(nil),    // 3: serialvault.v1.SerialResponse
+}
+var file_signing_proto_depIdxs = []int32{
+	0, // 0: serialvault.v1.Signing.RequestID:input_type -> serialvault.v1.RequestIDRequest
+	2, // 1: serialvault.v1.Signing.Serial:input_type -> serialvault.v1.SerialRequest
+	1, // 2: serialvault.v1.Signing.RequestID:output_type -> serialvault.v1.RequestIDResponse
+	3, // 3: serialvault.v1.Signing.Serial:output_type -> serialvault.v1.SerialResponse
+	2, // [2:4] is the sub-list for method output_type
+	0, // [0:2] is the sub-list for method input_type
+	0, // [0:0] is the sub-list for extension type_name
+	0, // [0:0] is the sub-list for extension extendee
+	0, // [0:0] is the sub-list for field type_name
+}
+
+func init() { file_signing_proto_init() }
+func file_signing_proto_init() {
+	if File_signing_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_signing_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RequestIDRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_signing_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*RequestIDResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_signing_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SerialRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_signing_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*SerialResponse); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_signing_proto_rawDesc,
+			NumEnums:      0,
+			NumMessages:   4,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_signing_proto_goTypes,
+		DependencyIndexes: file_signing_proto_depIdxs,
+		MessageInfos:      file_signing_proto_msgTypes,
+	}.Build()
+	File_signing_proto = out.File
+	file_signing_proto_rawDesc = nil
+	file_signing_proto_goTypes = nil
+	file_signing_proto_depIdxs = nil
+}
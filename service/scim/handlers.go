@@ -0,0 +1,393 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package scim
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/request"
+	"github.com/gorilla/mux"
+)
+
+// ServiceProviderConfig is the SCIM method that describes the features of the server
+func ServiceProviderConfig(w http.ResponseWriter, r *http.Request) {
+	unsupported := map[string]bool{"supported": false}
+	formatResponse(http.StatusOK, map[string]interface{}{
+		"schemas":        []string{SchemaServiceProviderConfig},
+		"patch":          map[string]bool{"supported": true},
+		"bulk":           map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":         map[string]interface{}{"supported": true, "maxResults": maxResults},
+		"changePassword": unsupported,
+		"sort":           unsupported,
+		"etag":           unsupported,
+		"authenticationSchemes": []map[string]string{{
+			"type":        "oauthbearertoken",
+			"name":        "OAuth Bearer Token",
+			"description": "The token of a service account or the personal access token of a superuser",
+		}},
+	}, w)
+}
+
+// List is the SCIM method to query the users, optionally filtered by username
+func List(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticate(w, r); !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	startIndex, count := pagination(query.Get("startIndex"), query.Get("count"))
+
+	var users []datastore.User
+	if filter := query.Get("filter"); len(filter) > 0 {
+		username, err := parseFilter(filter)
+		if err != nil {
+			formatError(http.StatusBadRequest, scimTypeInvalidFilter, err.Error(), w)
+			return
+		}
+		// A user that is not found is an empty result
+		if u, err := datastore.Environ.DB.GetUserByUsername(r.Context(), username); err == nil {
+			users = append(users, u)
+		}
+	} else {
+		var err error
+		users, err = datastore.Environ.DB.ListUsers(r.Context())
+		if err != nil {
+			formatError(http.StatusInternalServerError, "", err.Error(), w)
+			return
+		}
+	}
+
+	result := ListResponse{
+		Schemas:      []string{SchemaListResponse},
+		TotalResults: len(users),
+		StartIndex:   startIndex,
+		Resources:    []User{},
+	}
+	for i := startIndex - 1; i < len(users) && len(result.Resources) < count; i++ {
+		result.Resources = append(result.Resources, userResource(users[i]))
+	}
+	result.ItemsPerPage = len(result.Resources)
+
+	formatResponse(http.StatusOK, result, w)
+}
+
+// Get is the SCIM method to fetch a user
+func Get(w http.ResponseWriter, r *http.Request) {
+	if _, ok := authenticate(w, r); !ok {
+		return
+	}
+
+	u, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+	formatResponse(http.StatusOK, userResource(u), w)
+}
+
+// Create is the SCIM method to provision a user. The user gets the standard role and no
+// accounts, which are granted in the vault
+func Create(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	resource := User{}
+	if !decodeBody(w, r, &resource) {
+		return
+	}
+
+	if _, err := datastore.Environ.DB.GetUserByUsername(r.Context(), resource.UserName); err == nil {
+		formatError(http.StatusConflict, scimTypeUniqueness, fmt.Sprintf("The user %s already exists", resource.UserName), w)
+		return
+	}
+
+	user := datastore.User{Role: datastore.Standard, Active: true}
+	resource.apply(&user)
+
+	var err error
+	user.ID, err = datastore.Environ.DB.CreateUser(r.Context(), user)
+	if err != nil {
+		log.Error("error-creating-user", err)
+		formatError(http.StatusBadRequest, scimTypeInvalidValue, err.Error(), w)
+		return
+	}
+	audit.Record(r.Context(), authUser, audit.ActionCreate, audit.ObjectUser, user.ID, "", nil, user)
+
+	// A user can be provisioned as inactive, e.g. before the start date of an employee
+	if !user.Active {
+		if err := datastore.Environ.DB.SetUserActive(r.Context(), user.ID, false); err != nil {
+			formatError(http.StatusInternalServerError, "", err.Error(), w)
+			return
+		}
+		audit.Record(r.Context(), authUser, audit.ActionDisable, audit.ObjectUser, user.ID, "", nil, user)
+	}
+
+	formatResponse(http.StatusCreated, userResource(user), w)
+}
+
+// Replace is the SCIM method to update all the provisioned fields of a user
+func Replace(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	before, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	resource := User{}
+	if !decodeBody(w, r, &resource) {
+		return
+	}
+
+	after := before
+	resource.apply(&after)
+	updateUser(r.Context(), w, authUser, before, after)
+}
+
+// Patch is the SCIM method to change some of the provisioned fields of a user, which is
+// how most identity systems deactivate a user
+func Patch(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	before, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	patch := PatchRequest{}
+	if !decodeBody(w, r, &patch) {
+		return
+	}
+
+	after := before
+	for _, op := range patch.Operations {
+		if err := applyOperation(&after, op); err != nil {
+			formatError(http.StatusBadRequest, scimTypeInvalidValue, err.Error(), w)
+			return
+		}
+	}
+	updateUser(r.Context(), w, authUser, before, after)
+}
+
+// Delete is the SCIM method to deprovision a user. The user is deactivated rather than
+// deleted, so the account links and the audit trail are kept
+func Delete(w http.ResponseWriter, r *http.Request) {
+	authUser, ok := authenticate(w, r)
+	if !ok {
+		return
+	}
+
+	before, ok := getUser(w, r)
+	if !ok {
+		return
+	}
+
+	after := before
+	after.Active = false
+	if !setActive(r.Context(), w, authUser, before, after) {
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// authenticate checks the bearer token or the API key of the identity system, which
+// must be of a superuser
+func authenticate(w http.ResponseWriter, r *http.Request) (datastore.User, bool) {
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		formatError(http.StatusUnauthorized, "", err.Error(), w)
+		return user, false
+	}
+
+	if err := auth.CheckUserPermissions(user, datastore.Superuser, true); err != nil {
+		formatError(http.StatusForbidden, "", err.Error(), w)
+		return user, false
+	}
+	return user, true
+}
+
+// getUser fetches the user of the route
+func getUser(w http.ResponseWriter, r *http.Request) (datastore.User, bool) {
+	userID, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		formatError(http.StatusNotFound, "", "Cannot find the user", w)
+		return datastore.User{}, false
+	}
+
+	u, err := datastore.Environ.DB.GetUser(r.Context(), userID)
+	if err != nil {
+		formatError(http.StatusNotFound, "", "Cannot find the user", w)
+		return u, false
+	}
+	return u, true
+}
+
+// decodeBody decodes the JSON body of the request
+func decodeBody(w http.ResponseWriter, r *http.Request, value interface{}) bool {
+	defer r.Body.Close()
+
+	err := json.NewDecoder(r.Body).Decode(value)
+	switch {
+	case err == io.EOF:
+		formatError(http.StatusBadRequest, scimTypeInvalidSyntax, "No user data supplied", w)
+		return false
+	case err != nil:
+		formatError(http.StatusBadRequest, scimTypeInvalidSyntax, err.Error(), w)
+		return false
+	}
+	return true
+}
+
+// updateUser saves the provisioned fields of the user, keeping the role, the accounts and
+// the API key, and then the status of the user when it has changed
+func updateUser(ctx context.Context, w http.ResponseWriter, authUser datastore.User, before, after datastore.User) {
+	if after.Username != before.Username {
+		if _, err := datastore.Environ.DB.GetUserByUsername(ctx, after.Username); err == nil {
+			formatError(http.StatusConflict, scimTypeUniqueness, fmt.Sprintf("The user %s already exists", after.Username), w)
+			return
+		}
+	}
+
+	if after.Username != before.Username || after.Name != before.Name || after.Email != before.Email {
+		if err := datastore.Environ.DB.UpdateUser(ctx, after); err != nil {
+			log.Error("error-updating-user", err)
+			formatError(http.StatusBadRequest, scimTypeInvalidValue, err.Error(), w)
+			return
+		}
+		audit.Record(ctx, authUser, audit.ActionUpdate, audit.ObjectUser, after.ID, "", before, after)
+	}
+
+	if after.Active != before.Active && !setActive(ctx, w, authUser, before, after) {
+		return
+	}
+
+	formatResponse(http.StatusOK, userResource(after), w)
+}
+
+// setActive deactivates or reactivates the user
+func setActive(ctx context.Context, w http.ResponseWriter, authUser, before, after datastore.User) bool {
+	// The identity system cannot lock itself out
+	if !after.Active && before.Username == authUser.Username {
+		formatError(http.StatusBadRequest, scimTypeMutability, "You cannot deactivate your own user", w)
+		return false
+	}
+
+	if err := datastore.Environ.DB.SetUserActive(ctx, before.ID, after.Active); err != nil {
+		formatError(http.StatusInternalServerError, "", err.Error(), w)
+		return false
+	}
+
+	action := audit.ActionDisable
+	if after.Active {
+		action = audit.ActionEnable
+	}
+	audit.Record(ctx, authUser, action, audit.ObjectUser, before.ID, "", before, after)
+	return true
+}
+
+// applyOperation applies an add or replace operation of a patch request to the user. An
+// operation without a path has an object with the attributes as its value
+func applyOperation(u *datastore.User, op PatchOperation) error {
+	if !strings.EqualFold(op.Op, "add") && !strings.EqualFold(op.Op, "replace") {
+		return fmt.Errorf("Unsupported operation: %s", op.Op)
+	}
+
+	if len(op.Path) > 0 {
+		return applyAttribute(u, op.Path, op.Value)
+	}
+
+	attributes := map[string]json.RawMessage{}
+	if err := json.Unmarshal(op.Value, &attributes); err != nil {
+		return fmt.Errorf("Invalid operation value: %v", err)
+	}
+	for path, value := range attributes {
+		if err := applyAttribute(u, path, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// applyAttribute sets a provisioned attribute of the user. The other attributes are ignored
+func applyAttribute(u *datastore.User, path string, value json.RawMessage) error {
+	var err error
+	switch {
+	case strings.EqualFold(path, "active"):
+		u.Active, err = parseBool(value)
+	case strings.EqualFold(path, "userName"):
+		u.Username, err = parseString(value)
+	case strings.EqualFold(path, "displayName"), strings.EqualFold(path, "name.formatted"):
+		u.Name, err = parseString(value)
+	case strings.EqualFold(path, "name"):
+		name := Name{}
+		if err = json.Unmarshal(value, &name); err == nil {
+			if n := (User{Name: &name}).fullName(); len(n) > 0 {
+				u.Name = n
+			}
+		}
+	case strings.EqualFold(path, "emails"):
+		emails := []Email{}
+		if err = json.Unmarshal(value, &emails); err == nil {
+			if e := (User{Emails: emails}).primaryEmail(); len(e) > 0 {
+				u.Email = e
+			}
+		}
+	case strings.HasPrefix(strings.ToLower(path), "emails["):
+		// e.g. emails[type eq "work"].value, as the user has a single email address
+		u.Email, err = parseString(value)
+	}
+	return err
+}
+
+// pagination returns the 1-based start index and the page size of a query
+func pagination(startIndex, count string) (int, int) {
+	start, err := strconv.Atoi(startIndex)
+	if err != nil || start < 1 {
+		start = 1
+	}
+
+	size, err := strconv.Atoi(count)
+	if err != nil || size > maxResults {
+		size = maxResults
+	}
+	if size < 0 {
+		size = 0
+	}
+	return start, size
+}
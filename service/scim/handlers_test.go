@@ -0,0 +1,218 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package scim_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/scim"
+	check "gopkg.in/check.v1"
+)
+
+func TestSCIMSuite(t *testing.T) { check.TestingT(t) }
+
+type SCIMSuite struct{}
+
+type SCIMTest struct {
+	Method      string
+	URL         string
+	Data        string
+	Code        int
+	Permissions int
+	Count       int
+}
+
+var _ = check.Suite(&SCIMSuite{})
+
+func (s *SCIMSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+}
+
+func (s *SCIMSuite) TestServiceProviderConfig(c *check.C) {
+	w := sendSCIMRequest("GET", "/scim/v2/ServiceProviderConfig", nil, 0)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, scim.ContentType)
+}
+
+func (s *SCIMSuite) TestListHandler(c *check.C) {
+	tests := []SCIMTest{
+		{"GET", "/scim/v2/Users", "", 401, 0, 0},
+		{"GET", "/scim/v2/Users", "", 403, datastore.Standard, 0},
+		{"GET", "/scim/v2/Users", "", 200, datastore.Superuser, 6},
+		{"GET", "/scim/v2/Users?startIndex=2&count=2", "", 200, datastore.Superuser, 2},
+		{"GET", "/scim/v2/Users?startIndex=6&count=2", "", 200, datastore.Superuser, 1},
+		{"GET", `/scim/v2/Users?filter=userName+eq+"user1"`, "", 200, datastore.Superuser, 1},
+		{"GET", `/scim/v2/Users?filter=userName+eq+"unknown"`, "", 200, datastore.Superuser, 0},
+		{"GET", `/scim/v2/Users?filter=emails+co+"example"`, "", 400, datastore.Superuser, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSCIMRequest(t.Method, t.URL, nil, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, scim.ContentType)
+
+		if t.Code != 200 {
+			continue
+		}
+		result := scim.ListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Resources, check.HasLen, t.Count)
+		c.Assert(result.ItemsPerPage, check.Equals, t.Count)
+	}
+}
+
+func (s *SCIMSuite) TestGetHandler(c *check.C) {
+	w := sendSCIMRequest("GET", "/scim/v2/Users/1", nil, datastore.Superuser)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result := parseUser(w, c)
+	c.Assert(result.ID, check.Equals, "1")
+	c.Assert(result.UserName, check.Equals, "user1")
+	c.Assert(result.DisplayName, check.Equals, "Rigoberto Picaporte")
+	c.Assert(result.Emails[0].Value, check.Equals, "rigoberto.picaporte@ubuntu.com")
+	c.Assert(*result.Active, check.Equals, true)
+
+	w = sendSCIMRequest("GET", "/scim/v2/Users/999", nil, datastore.Superuser)
+	c.Assert(w.Code, check.Equals, 404)
+	result2 := parseError(w, c)
+	c.Assert(result2.Status, check.Equals, "404")
+}
+
+func (s *SCIMSuite) TestCreateHandler(c *check.C) {
+	tests := []SCIMTest{
+		{"POST", "/scim/v2/Users", `{"schemas":["urn:ietf:params:scim:schemas:core:2.0:User"],"userName":"jdoe","name":{"givenName":"Jane","familyName":"Doe"},"emails":[{"value":"jane@example.com","primary":true}]}`, 201, datastore.Superuser, 0},
+		{"POST", "/scim/v2/Users", `{"userName":"user1","displayName":"Jane Doe","emails":[{"value":"jane@example.com"}]}`, 409, datastore.Superuser, 0},
+		{"POST", "/scim/v2/Users", `{"userName":`, 400, datastore.Superuser, 0},
+		{"POST", "/scim/v2/Users", "", 400, datastore.Superuser, 0},
+		{"POST", "/scim/v2/Users", `{"userName":"jdoe"}`, 403, datastore.Admin, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSCIMRequest(t.Method, t.URL, []byte(t.Data), t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.Data))
+	}
+
+	w := sendSCIMRequest("POST", "/scim/v2/Users", []byte(tests[0].Data), datastore.Superuser)
+	result := parseUser(w, c)
+	c.Assert(result.ID, check.Equals, "740")
+	c.Assert(result.DisplayName, check.Equals, "Jane Doe")
+	c.Assert(result.Emails[0].Value, check.Equals, "jane@example.com")
+}
+
+func (s *SCIMSuite) TestReplaceHandler(c *check.C) {
+	tests := []SCIMTest{
+		{"PUT", "/scim/v2/Users/1", `{"userName":"user1","displayName":"Rigo Picaporte","emails":[{"value":"rigo@example.com"}],"active":true}`, 200, datastore.Superuser, 0},
+		{"PUT", "/scim/v2/Users/1", `{"userName":"user2","displayName":"Rigo Picaporte"}`, 409, datastore.Superuser, 0},
+		{"PUT", "/scim/v2/Users/999", `{"userName":"user1"}`, 404, datastore.Superuser, 0},
+		{"PUT", "/scim/v2/Users/5", `{"userName":"root","active":false}`, 400, datastore.Superuser, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSCIMRequest(t.Method, t.URL, []byte(t.Data), t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.Data))
+	}
+}
+
+func (s *SCIMSuite) TestPatchHandler(c *check.C) {
+	tests := []SCIMTest{
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"replace","path":"active","value":false}]}`, 200, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"Replace","path":"active","value":"False"}]}`, 200, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"replace","value":{"active":false,"displayName":"Rigo"}}]}`, 200, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"replace","path":"emails[type eq \"work\"].value","value":"rigo@example.com"}]}`, 200, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"remove","path":"emails"}]}`, 400, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/1", `{"Operations":[{"op":"replace","path":"active","value":"maybe"}]}`, 400, datastore.Superuser, 0},
+		{"PATCH", "/scim/v2/Users/5", `{"Operations":[{"op":"replace","path":"active","value":false}]}`, 400, datastore.Superuser, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSCIMRequest(t.Method, t.URL, []byte(t.Data), t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.Data))
+	}
+
+	w := sendSCIMRequest("PATCH", "/scim/v2/Users/1", []byte(tests[2].Data), datastore.Superuser)
+	result := parseUser(w, c)
+	c.Assert(*result.Active, check.Equals, false)
+	c.Assert(result.DisplayName, check.Equals, "Rigo")
+}
+
+func (s *SCIMSuite) TestDeleteHandler(c *check.C) {
+	tests := []SCIMTest{
+		{"DELETE", "/scim/v2/Users/1", "", 204, datastore.Superuser, 0},
+		{"DELETE", "/scim/v2/Users/5", "", 400, datastore.Superuser, 0},
+		{"DELETE", "/scim/v2/Users/999", "", 404, datastore.Superuser, 0},
+		{"DELETE", "/scim/v2/Users/1", "", 401, 0, 0},
+	}
+
+	for _, t := range tests {
+		w := sendSCIMRequest(t.Method, t.URL, nil, t.Permissions)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf(t.URL))
+	}
+}
+
+func sendSCIMRequest(method, url string, data []byte, permissions int) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	var body io.Reader
+	if data != nil {
+		body = bytes.NewReader(data)
+	}
+	r, _ := http.NewRequest(method, url, body)
+	r.Header.Set("Content-Type", scim.ContentType)
+
+	switch permissions {
+	case datastore.Superuser:
+		r.Header.Set("user", "root")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Admin:
+		r.Header.Set("user", "sv")
+		r.Header.Set("api-key", "ValidAPIKey")
+	case datastore.Standard:
+		r.Header.Set("user", "user1")
+		r.Header.Set("api-key", "ValidAPIKey")
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+	return w
+}
+
+func parseUser(w *httptest.ResponseRecorder, c *check.C) scim.User {
+	result := scim.User{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
+
+func parseError(w *httptest.ResponseRecorder, c *check.C) scim.Error {
+	result := scim.Error{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	return result
+}
@@ -0,0 +1,238 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package scim is a SCIM 2.0 server (RFC 7643 and RFC 7644) for the users of the vault, so
+// an enterprise identity system can create, update and deactivate them automatically
+package scim
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// The schemas of the SCIM resources and messages
+const (
+	SchemaUser                  = "urn:ietf:params:scim:schemas:core:2.0:User"
+	SchemaListResponse          = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	SchemaPatchOp               = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	SchemaError                 = "urn:ietf:params:scim:api:messages:2.0:Error"
+	SchemaServiceProviderConfig = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// ContentType is the media type of the SCIM requests and responses
+const ContentType = "application/scim+json"
+
+// maxResults is the largest page of users that is returned
+const maxResults = 200
+
+// The SCIM error types of the bad requests
+const (
+	scimTypeInvalidFilter = "invalidFilter"
+	scimTypeInvalidSyntax = "invalidSyntax"
+	scimTypeInvalidValue  = "invalidValue"
+	scimTypeUniqueness    = "uniqueness"
+	scimTypeMutability    = "mutability"
+)
+
+// Name is the name of a SCIM user
+type Name struct {
+	Formatted  string `json:"formatted,omitempty"`
+	GivenName  string `json:"givenName,omitempty"`
+	FamilyName string `json:"familyName,omitempty"`
+}
+
+// Email is an email address of a SCIM user
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// Meta is the metadata of a SCIM resource
+type Meta struct {
+	ResourceType string `json:"resourceType"`
+	Location     string `json:"location,omitempty"`
+}
+
+// User is the SCIM resource of a vault user. The role and the accounts of the user are
+// not provisioned, they are managed in the vault
+type User struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id,omitempty"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	UserName    string   `json:"userName"`
+	Name        *Name    `json:"name,omitempty"`
+	DisplayName string   `json:"displayName,omitempty"`
+	Emails      []Email  `json:"emails,omitempty"`
+	Active      *bool    `json:"active,omitempty"`
+	Meta        *Meta    `json:"meta,omitempty"`
+}
+
+// ListResponse is the SCIM response of a query of the users
+type ListResponse struct {
+	Schemas      []string `json:"schemas"`
+	TotalResults int      `json:"totalResults"`
+	StartIndex   int      `json:"startIndex"`
+	ItemsPerPage int      `json:"itemsPerPage"`
+	Resources    []User   `json:"Resources"`
+}
+
+// PatchOperation is an operation of a SCIM patch request
+type PatchOperation struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path"`
+	Value json.RawMessage `json:"value"`
+}
+
+// PatchRequest is the SCIM patch request of a user
+type PatchRequest struct {
+	Schemas    []string         `json:"schemas"`
+	Operations []PatchOperation `json:"Operations"`
+}
+
+// Error is the SCIM error response. The status is the HTTP status code, as a string
+type Error struct {
+	Schemas  []string `json:"schemas"`
+	Status   string   `json:"status"`
+	ScimType string   `json:"scimType,omitempty"`
+	Detail   string   `json:"detail,omitempty"`
+}
+
+// userResource converts a vault user to the SCIM resource
+func userResource(u datastore.User) User {
+	active := u.Active
+	resource := User{
+		Schemas:     []string{SchemaUser},
+		ID:          strconv.Itoa(u.ID),
+		UserName:    u.Username,
+		Name:        &Name{Formatted: u.Name},
+		DisplayName: u.Name,
+		Active:      &active,
+		Meta:        &Meta{ResourceType: "User", Location: "/scim/v2/Users/" + strconv.Itoa(u.ID)},
+	}
+	if len(u.Email) > 0 {
+		resource.Emails = []Email{{Value: u.Email, Type: "work", Primary: true}}
+	}
+	return resource
+}
+
+// apply sets the provisioned fields of the SCIM resource on the vault user
+func (resource User) apply(u *datastore.User) {
+	u.Username = resource.UserName
+	if name := resource.fullName(); len(name) > 0 {
+		u.Name = name
+	}
+	if email := resource.primaryEmail(); len(email) > 0 {
+		u.Email = email
+	}
+	if resource.Active != nil {
+		u.Active = *resource.Active
+	}
+}
+
+// fullName returns the display name, or the name of the user
+func (resource User) fullName() string {
+	if len(resource.DisplayName) > 0 {
+		return resource.DisplayName
+	}
+	if resource.Name == nil {
+		return ""
+	}
+	if len(resource.Name.Formatted) > 0 {
+		return resource.Name.Formatted
+	}
+	return strings.TrimSpace(resource.Name.GivenName + " " + resource.Name.FamilyName)
+}
+
+// primaryEmail returns the primary email address, or the first one
+func (resource User) primaryEmail() string {
+	for _, e := range resource.Emails {
+		if e.Primary {
+			return e.Value
+		}
+	}
+	if len(resource.Emails) > 0 {
+		return resource.Emails[0].Value
+	}
+	return ""
+}
+
+// parseFilter parses the only filter that is supported, which is the one the identity
+// systems use to find a user before creating it: userName eq "value"
+func parseFilter(filter string) (string, error) {
+	parts := strings.SplitN(strings.TrimSpace(filter), " ", 3)
+	if len(parts) != 3 || !strings.EqualFold(parts[0], "userName") || !strings.EqualFold(parts[1], "eq") {
+		return "", fmt.Errorf("Unsupported filter: %s", filter)
+	}
+
+	value, err := strconv.Unquote(parts[2])
+	if err != nil {
+		return "", fmt.Errorf("Invalid filter value: %s", parts[2])
+	}
+	return value, nil
+}
+
+// parseBool parses a boolean value of a patch operation. Some identity systems send it as a string
+func parseBool(value json.RawMessage) (bool, error) {
+	var b bool
+	if err := json.Unmarshal(value, &b); err == nil {
+		return b, nil
+	}
+
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return false, fmt.Errorf("Invalid boolean value: %s", string(value))
+	}
+	return strconv.ParseBool(strings.ToLower(s))
+}
+
+// parseString parses a string value of a patch operation
+func parseString(value json.RawMessage) (string, error) {
+	var s string
+	if err := json.Unmarshal(value, &s); err != nil {
+		return "", fmt.Errorf("Invalid string value: %s", string(value))
+	}
+	return s, nil
+}
+
+// formatResponse writes a SCIM response with the status code
+func formatResponse(status int, value interface{}, w http.ResponseWriter) {
+	w.Header().Set("Content-Type", ContentType)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		log.Errorf("Error forming the SCIM response: %v", err)
+	}
+}
+
+// formatError writes a SCIM error response
+func formatError(status int, scimType, detail string, w http.ResponseWriter) {
+	formatResponse(status, Error{
+		Schemas:  []string{SchemaError},
+		Status:   strconv.Itoa(status),
+		ScimType: scimType,
+		Detail:   detail,
+	}, w)
+}
@@ -0,0 +1,222 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package search
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Types of the search results
+const (
+	TypeAccount = "account"
+	TypeModel   = "model"
+	TypeKeypair = "keypair"
+	TypeDevice  = "device"
+	TypeUser    = "user"
+)
+
+// maxResults is the maximum number of results for each type of object
+const maxResults = 20
+
+// Result is a typed search result, with the ID of the object to navigate to it
+type Result struct {
+	Type        string `json:"type"`
+	ID          int    `json:"id"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// Response is the JSON response from the API search method
+type Response struct {
+	Success      bool     `json:"success"`
+	ErrorCode    string   `json:"error_code"`
+	ErrorSubcode string   `json:"error_subcode"`
+	ErrorMessage string   `json:"message"`
+	Results      []Result `json:"results"`
+}
+
+// searcher finds the objects of one type that match the query
+type searcher func(ctx context.Context, query string, user datastore.User) ([]Result, error)
+
+var searchers = []searcher{searchAccounts, searchModels, searchKeypairs, searchDevices, searchFingerprints, searchUsers}
+
+// searchHandler is the API method to search across the objects that the user is authorized to see
+func searchHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, query string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	query = strings.TrimSpace(query)
+	if len(query) == 0 {
+		response.FormatStandardResponse(false, "error-invalid-query", "", "The search query must be provided", w)
+		return
+	}
+
+	results := []Result{}
+	for _, s := range searchers {
+		r, err := s(ctx, query, user)
+		if err != nil {
+			log.Error(err)
+			response.FormatStandardResponse(false, "error-search", "", err.Error(), w)
+			return
+		}
+		results = append(results, r...)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatResponse(results, w)
+}
+
+func searchAccounts(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	accounts, err := datastore.Environ.DB.ListAllowedAccounts(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, a := range accounts {
+		if matches(query, a.AuthorityID) {
+			results = append(results, Result{Type: TypeAccount, ID: a.ID, Name: a.AuthorityID})
+		}
+	}
+	return limit(results), nil
+}
+
+func searchModels(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	models, err := datastore.Environ.DB.ListAllowedModels(ctx, user, datastore.ModelFilter{})
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, m := range models {
+		if matches(query, m.Name, m.BrandID) {
+			results = append(results, Result{Type: TypeModel, ID: m.ID, Name: m.Name, Description: m.BrandID})
+		}
+	}
+	return limit(results), nil
+}
+
+func searchKeypairs(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	keypairs, err := datastore.Environ.DB.ListAllowedKeypairs(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, k := range keypairs {
+		if !matches(query, k.KeyID, k.KeyName) {
+			continue
+		}
+		name := k.KeyName
+		if len(name) == 0 {
+			name = k.KeyID
+		}
+		results = append(results, Result{Type: TypeKeypair, ID: k.ID, Name: name, Description: k.AuthorityID + "/" + k.KeyID})
+	}
+	return limit(results), nil
+}
+
+// searchDevices finds the devices with the serial number, so the query must be the full serial number
+func searchDevices(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	devices, err := datastore.Environ.DB.ListAllowedDevices(ctx, query, user)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, d := range devices {
+		results = append(results, Result{Type: TypeDevice, Name: d.SerialNumber, Description: d.Brand + "/" + d.Model})
+	}
+	return limit(results), nil
+}
+
+// searchFingerprints finds the devices that were signed with the device-key, so the query must be the full fingerprint
+func searchFingerprints(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	devices, err := datastore.Environ.DB.ListAllowedDevicesForFingerprint(ctx, user, query, maxResults)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, d := range devices {
+		results = append(results, Result{Type: TypeDevice, Name: d.SerialNumber, Description: d.Brand + "/" + d.Model})
+	}
+	return results, nil
+}
+
+// searchUsers finds the users, which are only visible to a superuser
+func searchUsers(ctx context.Context, query string, user datastore.User) ([]Result, error) {
+	if user.Role != datastore.Invalid && user.Role != datastore.Superuser {
+		return []Result{}, nil
+	}
+
+	users, err := datastore.Environ.DB.FindUsers(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	results := []Result{}
+	for _, u := range users {
+		results = append(results, Result{Type: TypeUser, ID: u.ID, Name: u.Username, Description: u.Name})
+	}
+	return limit(results), nil
+}
+
+// matches checks if any of the fields contains the query, ignoring the case
+func matches(query string, fields ...string) bool {
+	query = strings.ToLower(query)
+	for _, f := range fields {
+		if strings.Contains(strings.ToLower(f), query) {
+			return true
+		}
+	}
+	return false
+}
+
+func limit(results []Result) []Result {
+	if len(results) > maxResults {
+		return results[:maxResults]
+	}
+	return results
+}
+
+func formatResponse(results []Result, w http.ResponseWriter) error {
+	response := Response{Success: true, Results: results}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the search response.")
+		return err
+	}
+	return nil
+}
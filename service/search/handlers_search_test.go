@@ -0,0 +1,146 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package search_test
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/search"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func TestSearchSuite(t *testing.T) { check.TestingT(t) }
+
+type SearchSuite struct{}
+
+var _ = check.Suite(&SearchSuite{})
+
+type SearchTest struct {
+	URL         string
+	Code        int
+	Permissions int
+	EnableAuth  bool
+	Success     bool
+	Types       map[string]int
+}
+
+func (s *SearchSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *SearchSuite) TestSearchHandler(c *check.C) {
+	tests := []SearchTest{
+		{"/v1/search?q=alder", 200, 0, false, true, map[string]int{search.TypeModel: 1}},
+		{"/v1/search?q=VENDOR", 200, 0, false, true, map[string]int{search.TypeAccount: 1}},
+		{"/v1/search?q=inactiveone", 200, 0, false, true, map[string]int{search.TypeKeypair: 1}},
+		{"/v1/search?q=A1", 200, 0, false, true, map[string]int{search.TypeDevice: 1}},
+		{"/v1/search?q=a3", 200, 0, false, true, map[string]int{search.TypeDevice: 1}},
+		{"/v1/search?q=user1", 200, 0, false, true, map[string]int{search.TypeUser: 1}},
+		{"/v1/search?q=alder", 200, datastore.Admin, true, true, map[string]int{search.TypeModel: 1}},
+		{"/v1/search?q=user1", 200, datastore.Admin, true, true, map[string]int{}},
+		{"/v1/search?q=user1", 200, datastore.Superuser, true, true, map[string]int{search.TypeUser: 1}},
+		{"/v1/search?q=A1", 200, datastore.SubstoreAdmin, true, true, map[string]int{search.TypeDevice: 1}},
+		{"/v1/search?q=alder", 400, datastore.Standard, true, false, map[string]int{}},
+		{"/v1/search?q=", 400, datastore.Admin, true, false, map[string]int{}},
+		{"/v1/search", 400, 0, false, false, map[string]int{}},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.URL, t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result, err := parseResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		types := map[string]int{}
+		for _, r := range result.Results {
+			types[r.Type]++
+		}
+		c.Assert(types, check.DeepEquals, t.Types, check.Commentf("search %s", t.URL))
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SearchSuite) TestSearchErrorHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("/v1/search?q=alder", 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result, err := parseResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-search")
+}
+
+func parseResponse(w *httptest.ResponseRecorder) (search.Response, error) {
+	// Check the JSON response
+	result := search.Response{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
+func sendAdminRequest(url string, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+
+	if permissions > 0 {
+		// Create a JWT and add it to the request
+		err := createJWTWithRole(r, permissions)
+		c.Assert(err, check.IsNil)
+	}
+
+	service.AdminRouter().ServeHTTP(w, r)
+
+	return w
+}
+
+func createJWTWithRole(r *http.Request, role int) error {
+	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, role)
+	if err != nil {
+		return fmt.Errorf("Error creating a JWT: %v", err)
+	}
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	return nil
+}
@@ -0,0 +1,245 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package serviceaccount
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
+)
+
+// ListResponse is the JSON response from the service account list method
+type ListResponse struct {
+	Success         bool                       `json:"success"`
+	ErrorCode       string                     `json:"error_code"`
+	ErrorSubcode    string                     `json:"error_subcode"`
+	ErrorMessage    string                     `json:"message"`
+	ServiceAccounts []datastore.ServiceAccount `json:"serviceAccounts"`
+}
+
+// GetResponse is the JSON response from the service account creation and secret reset methods
+type GetResponse struct {
+	Success        bool                     `json:"success"`
+	ErrorCode      string                   `json:"error_code"`
+	ErrorSubcode   string                   `json:"error_subcode"`
+	ErrorMessage   string                   `json:"message"`
+	ServiceAccount datastore.ServiceAccount `json:"serviceAccount"`
+}
+
+// TokenResponse is the JSON response of the client-credentials grant
+type TokenResponse struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	ExpiresIn   int    `json:"expires_in"`
+	Scope       string `json:"scope"`
+}
+
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	accounts, err := datastore.Environ.DB.ListServiceAccounts(ctx)
+	if err != nil {
+		log.Errorf("Error fetching the service accounts: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeResponse(ListResponse{Success: true, ServiceAccounts: accounts}, w)
+}
+
+func createHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, sa datastore.ServiceAccount) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	sa.Active = true
+	sa, err = datastore.Environ.DB.CreateServiceAccount(ctx, sa)
+	if err != nil {
+		log.Errorf("Error creating the service account: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionCreate, audit.ObjectServiceAccount, sa.ID, "", nil, sa)
+
+	w.WriteHeader(http.StatusOK)
+	encodeResponse(GetResponse{Success: true, ServiceAccount: sa}, w)
+}
+
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, sa datastore.ServiceAccount) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// The service account before the change, for the audit log
+	before, err := datastore.Environ.DB.GetServiceAccount(ctx, sa.ID)
+	if err != nil {
+		log.Errorf("Error fetching the service account: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+
+	if err = datastore.Environ.DB.UpdateServiceAccount(ctx, sa); err != nil {
+		log.Errorf("Error updating the service account: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+
+	action := audit.ActionUpdate
+	if before.Active != sa.Active {
+		action = audit.ActionDisable
+		if sa.Active {
+			action = audit.ActionEnable
+		}
+	}
+	audit.Record(ctx, user, action, audit.ObjectServiceAccount, sa.ID, "", before, sa)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func resetSecretHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, serviceAccountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	sa, err := datastore.Environ.DB.ResetServiceAccountSecret(ctx, serviceAccountID)
+	if err != nil {
+		log.Errorf("Error resetting the service account secret: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionRotate, audit.ObjectServiceAccount, sa.ID, "", nil, sa)
+
+	w.WriteHeader(http.StatusOK)
+	encodeResponse(GetResponse{Success: true, ServiceAccount: sa}, w)
+}
+
+func deleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, serviceAccountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	before, err := datastore.Environ.DB.GetServiceAccount(ctx, serviceAccountID)
+	if err != nil {
+		log.Errorf("Error fetching the service account: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+
+	if err = datastore.Environ.DB.DeleteServiceAccount(ctx, serviceAccountID); err != nil {
+		log.Errorf("Error deleting the service account: %v", err)
+		response.FormatStandardResponse(false, "error-serviceaccount", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectServiceAccount, serviceAccountID, "", before, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func tokenHandler(ctx context.Context, w http.ResponseWriter, clientID, secret string, scopes []string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.Header().Set("Cache-Control", "no-store")
+
+	sa, err := datastore.Environ.DB.AuthenticateServiceAccount(ctx, clientID, secret)
+	if err != nil {
+		log.Errorf("Invalid client credentials for the service account %s", clientID)
+		response.FormatStandardResponse(false, "invalid_client", "", "Invalid client credentials", w)
+		return
+	}
+
+	// The requested scopes must be granted to the service account: a read-only scope is
+	// granted by the scope of the resource
+	if len(scopes) == 0 {
+		scopes = sa.Scopes
+	}
+	for _, s := range scopes {
+		if !scopeGranted(sa.Scopes, s) {
+			response.FormatStandardResponse(false, "invalid_scope", "", "The scope '"+s+"' is not granted to the service account", w)
+			return
+		}
+	}
+
+	ttl, err := usso.ServiceTokenTTL(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error creating the service token: %v", err)
+		response.FormatStandardResponse(false, "server_error", "", err.Error(), w)
+		return
+	}
+
+	token, err := usso.NewServiceToken(sa, scopes, ttl)
+	if err != nil {
+		log.Errorf("Error creating the service token: %v", err)
+		response.FormatStandardResponse(false, "server_error", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	encodeResponse(TokenResponse{AccessToken: token, TokenType: "Bearer", ExpiresIn: int(ttl.Seconds()), Scope: strings.Join(scopes, " ")}, w)
+}
+
+// scopeGranted checks that the requested scope is one of the scopes of the service account
+func scopeGranted(granted []string, scope string) bool {
+	method := "POST"
+	if strings.HasSuffix(scope, datastore.AdminScopeReadSuffix) {
+		method = "GET"
+	}
+	return datastore.AdminScopeAllows(granted, strings.TrimSuffix(scope, datastore.AdminScopeReadSuffix), method)
+}
+
+func encodeResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the service account response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package serviceaccount
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/gorilla/mux"
+)
+
+// List is the API method to fetch the service accounts
+func List(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false)
+}
+
+// Create is the API method to create a service account for a user. The response has
+// the client secret, which cannot be fetched afterwards
+func Create(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	sa, ok := decodeServiceAccount(w, r)
+	if !ok {
+		return
+	}
+
+	createHandler(r.Context(), w, authUser, false, sa)
+}
+
+// Update is the API method to update the name, scopes and status of a service account
+func Update(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	sa, ok := decodeServiceAccount(w, r)
+	if !ok {
+		return
+	}
+	sa.ID = id
+
+	updateHandler(r.Context(), w, authUser, false, sa)
+}
+
+// ResetSecret is the API method to generate a new client secret for a service account
+func ResetSecret(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	resetSecretHandler(r.Context(), w, authUser, false, id)
+}
+
+// Delete is the API method to delete a service account
+func Delete(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	id, ok := serviceAccountID(w, r)
+	if !ok {
+		return
+	}
+
+	deleteHandler(r.Context(), w, authUser, false, id)
+}
+
+// Token is the client-credentials grant of the service accounts. The client ID and secret
+// are sent as form values or with basic authentication, and the response has a JWT for the
+// admin API with the requested scopes, or all the scopes of the service account
+func Token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		response.FormatStandardResponse(false, "invalid_request", "", err.Error(), w)
+		return
+	}
+
+	if r.Form.Get("grant_type") != "client_credentials" {
+		response.FormatStandardResponse(false, "unsupported_grant_type", "", "The grant type must be client_credentials", w)
+		return
+	}
+
+	clientID, secret, ok := r.BasicAuth()
+	if !ok {
+		clientID = r.Form.Get("client_id")
+		secret = r.Form.Get("client_secret")
+	}
+
+	tokenHandler(r.Context(), w, clientID, secret, strings.Fields(r.Form.Get("scope")))
+}
+
+func serviceAccountID(w http.ResponseWriter, r *http.Request) (int, bool) {
+	vars := mux.Vars(r)
+	id, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-serviceaccount", "", err.Error(), w)
+		return 0, false
+	}
+	return id, true
+}
+
+func decodeServiceAccount(w http.ResponseWriter, r *http.Request) (datastore.ServiceAccount, bool) {
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	sa := datastore.ServiceAccount{}
+	err := json.NewDecoder(r.Body).Decode(&sa)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-serviceaccount-data", "", "No service account data supplied", w)
+		return sa, false
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return sa, false
+	}
+	return sa, true
+}
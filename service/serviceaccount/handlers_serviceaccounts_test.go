@@ -0,0 +1,248 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package serviceaccount_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/serviceaccount"
+	"github.com/CanonicalLtd/serial-vault/usso"
+	"github.com/juju/usso/openid"
+	check "gopkg.in/check.v1"
+)
+
+func Test(t *testing.T) { check.TestingT(t) }
+
+type ServiceAccountSuite struct{}
+
+type ServiceAccountTest struct {
+	Method      string
+	URL         string
+	Data        []byte
+	Code        int
+	Permissions int
+	Success     bool
+}
+
+var _ = check.Suite(&ServiceAccountSuite{})
+
+func (s *ServiceAccountSuite) SetUpTest(c *check.C) {
+	// Mock the database
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+	datastore.OpenKeyStore(config)
+
+	datastore.Environ.Config.EnableUserAuth = true
+
+	// Disable CSRF for tests as we do not have a secure connection
+	service.MiddlewareWithCSRF = service.Middleware
+}
+
+func (s *ServiceAccountSuite) TestListHandler(c *check.C) {
+	w := sendAdminRequest("GET", "/v1/serviceaccounts", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := serviceaccount.ListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.ServiceAccounts, check.HasLen, 1)
+	c.Assert(result.ServiceAccounts[0].ClientID, check.Equals, "sv-client")
+	c.Assert(result.ServiceAccounts[0].Username, check.Equals, "sv")
+}
+
+func (s *ServiceAccountSuite) TestCreateHandler(c *check.C) {
+	data := []byte(`{"username":"sv", "name":"Factory sync", "scopes":["models","keypairs:read"]}`)
+	w := sendAdminRequest("POST", "/v1/serviceaccounts", bytes.NewReader(data), datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := serviceaccount.GetResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.ServiceAccount.ClientID, check.Equals, "sv-generated")
+	c.Assert(result.ServiceAccount.Secret, check.Equals, "GeneratedSecret")
+	c.Assert(result.ServiceAccount.Active, check.Equals, true)
+}
+
+func (s *ServiceAccountSuite) TestResetSecretHandler(c *check.C) {
+	w := sendAdminRequest("POST", "/v1/serviceaccounts/1/secret", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := serviceaccount.GetResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.ServiceAccount.Secret, check.Equals, "ResetSecret")
+}
+
+func (s *ServiceAccountSuite) TestHandlers(c *check.C) {
+	tests := []ServiceAccountTest{
+		{"GET", "/v1/serviceaccounts", nil, 400, datastore.Admin, false},
+		{"POST", "/v1/serviceaccounts", []byte(`{"username":"sv", "name":"Factory sync", "scopes":["models"]}`), 400, datastore.Admin, false},
+		{"POST", "/v1/serviceaccounts", []byte(`{"username":"unknown", "name":"Factory sync", "scopes":["models"]}`), 400, datastore.Superuser, false},
+		{"POST", "/v1/serviceaccounts", []byte(`{"username":"sv", "name":"Factory sync", "scopes":["invalid"]}`), 400, datastore.Superuser, false},
+		{"POST", "/v1/serviceaccounts", []byte(`{"username":"sv", "name":"Factory sync", "scopes":[]}`), 400, datastore.Superuser, false},
+		{"POST", "/v1/serviceaccounts", []byte(`invalid`), 400, datastore.Superuser, false},
+		{"POST", "/v1/serviceaccounts", nil, 400, datastore.Superuser, false},
+		{"PUT", "/v1/serviceaccounts/1", []byte(`{"name":"CI pipeline", "scopes":["models"], "active":false}`), 200, datastore.Superuser, true},
+		{"PUT", "/v1/serviceaccounts/1", []byte(`{"name":"", "scopes":["models"]}`), 400, datastore.Superuser, false},
+		{"PUT", "/v1/serviceaccounts/99", []byte(`{"name":"CI pipeline", "scopes":["models"]}`), 400, datastore.Superuser, false},
+		{"PUT", "/v1/serviceaccounts/1", []byte(`{"name":"CI pipeline", "scopes":["models"]}`), 400, datastore.Admin, false},
+		{"POST", "/v1/serviceaccounts/99/secret", nil, 400, datastore.Superuser, false},
+		{"POST", "/v1/serviceaccounts/1/secret", nil, 400, datastore.Admin, false},
+		{"DELETE", "/v1/serviceaccounts/1", nil, 200, datastore.Superuser, true},
+		{"DELETE", "/v1/serviceaccounts/99", nil, 400, datastore.Superuser, false},
+		{"DELETE", "/v1/serviceaccounts/1", nil, 400, datastore.Admin, false},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s %s %s", t.Method, t.URL, t.Data))
+
+		result := serviceaccount.GetResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *ServiceAccountSuite) TestHandlersWithError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("GET", "/v1/serviceaccounts", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+	w = sendTokenRequest(url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}}, c)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *ServiceAccountSuite) TestTokenHandler(c *check.C) {
+	w := sendTokenRequest(url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}}, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(w.Header().Get("Cache-Control"), check.Equals, "no-store")
+
+	result := serviceaccount.TokenResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.TokenType, check.Equals, "Bearer")
+	c.Assert(result.ExpiresIn, check.Equals, 3600)
+	c.Assert(result.Scope, check.Equals, "models keypairs:read")
+
+	sa, err := usso.VerifyServiceToken(result.AccessToken)
+	c.Assert(err, check.IsNil)
+	c.Assert(sa.ClientID, check.Equals, "sv-client")
+	c.Assert(sa.Scopes, check.DeepEquals, []string{"models", "keypairs:read"})
+}
+
+func (s *ServiceAccountSuite) TestTokenHandlerBasicAuth(c *check.C) {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/token", strings.NewReader(url.Values{"grant_type": {"client_credentials"}, "scope": {"models:read"}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.SetBasicAuth("sv-client", "ClientSecret")
+	service.AdminRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := serviceaccount.TokenResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Scope, check.Equals, "models:read")
+}
+
+func (s *ServiceAccountSuite) TestTokenHandlerInvalid(c *check.C) {
+	tests := []struct {
+		form url.Values
+		code string
+	}{
+		{url.Values{"grant_type": {"password"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}}, "unsupported_grant_type"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"invalid"}}, "invalid_client"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"unknown"}, "client_secret": {"ClientSecret"}}, "invalid_client"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}, "scope": {"keypairs"}}, "invalid_scope"},
+		{url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}, "scope": {"models signinglog:read"}}, "invalid_scope"},
+	}
+
+	for _, t := range tests {
+		w := sendTokenRequest(t.form, c)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+
+		result := serviceaccount.GetResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.ErrorCode, check.Equals, t.code)
+	}
+}
+
+func (s *ServiceAccountSuite) TestServiceTokenAdminAPI(c *check.C) {
+	w := sendTokenRequest(url.Values{"grant_type": {"client_credentials"}, "client_id": {"sv-client"}, "client_secret": {"ClientSecret"}}, c)
+	result := serviceaccount.TokenResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		method string
+		url    string
+		code   int
+	}{
+		{"GET", "/api/keypairs", http.StatusOK},
+		{"GET", "/api/models", http.StatusOK},
+		{"GET", "/api/signinglog", http.StatusBadRequest},
+		{"POST", "/api/keypairs/sync", http.StatusBadRequest},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(t.method, t.url, nil)
+		r.Header.Set("Authorization", "Bearer "+result.AccessToken)
+		service.AdminRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s %s", t.method, t.url))
+	}
+}
+
+func sendTokenRequest(form url.Values, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/api/token", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	service.AdminRouter().ServeHTTP(w, r)
+	return w
+}
+
+func sendAdminRequest(method, url string, data io.Reader, permissions int, c *check.C) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(method, url, data)
+
+	sreg := map[string]string{"nickname": "root", "fullname": "Root User", "email": "the_root_user@thisdb.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, permissions)
+	c.Assert(err, check.IsNil)
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+
+	service.AdminRouter().ServeHTTP(w, r)
+	return w
+}
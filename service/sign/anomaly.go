@@ -0,0 +1,97 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/webhook"
+)
+
+// anomalyWindow is the period over which the signing anomalies of a brand are aggregated, so a
+// factory that keeps retrying a refused signing does not flood the webhooks and the chat channels
+const anomalyWindow = 10 * time.Minute
+
+// anomalyEvent is the webhook data of a signing anomaly, with the number of anomalies of the
+// brand and code that it stands for
+type anomalyEvent struct {
+	datastore.SigningError
+	Count int `json:"count"`
+}
+
+// anomalyCount is the window of the last published anomaly of a brand and code, with the
+// anomalies that have been held back since
+type anomalyCount struct {
+	start    time.Time
+	withheld int
+}
+
+// anomalyCounts aggregates the signing anomalies per brand and code
+type anomalyCounts struct {
+	lock    sync.Mutex
+	windows map[string]*anomalyCount
+}
+
+var anomalies = &anomalyCounts{windows: map[string]*anomalyCount{}}
+
+// add counts an anomaly of the brand and code. The first anomaly of a window is published, with
+// the number of anomalies since the last one that was published
+func (a *anomalyCounts) add(brand, code string, now time.Time) (int, bool) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	key := brand + "/" + code
+	w, ok := a.windows[key]
+	if ok && now.Sub(w.start) < anomalyWindow {
+		w.withheld++
+		return 0, false
+	}
+
+	count := 1
+	if ok {
+		count += w.withheld
+	}
+	a.prune(now)
+	a.windows[key] = &anomalyCount{start: now}
+	return count, true
+}
+
+// prune removes the windows that have expired without any anomaly held back
+func (a *anomalyCounts) prune(now time.Time) {
+	for key, w := range a.windows {
+		if w.withheld == 0 && now.Sub(w.start) >= anomalyWindow {
+			delete(a.windows, key)
+		}
+	}
+}
+
+// publishAnomaly publishes the signing anomaly to the webhooks of the brand, at most once per
+// code in the window
+func publishAnomaly(ctx context.Context, signingError datastore.SigningError) {
+	count, ok := anomalies.add(signingError.Brand, signingError.Code, signingError.Created)
+	if !ok {
+		return
+	}
+	webhook.Publish(ctx, signingError.Brand, datastore.WebhookEventSigningAnomaly, signingError.Code, anomalyEvent{SigningError: signingError, Count: count})
+}
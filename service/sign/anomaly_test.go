@@ -0,0 +1,72 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sign
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestAnomalyCountsAdd(t *testing.T) {
+	a := &anomalyCounts{windows: map[string]*anomalyCount{}}
+	now := time.Now().UTC()
+
+	if count, ok := a.add("system", "duplicate", now); !ok || count != 1 {
+		t.Errorf("add() first = %d, %v", count, ok)
+	}
+	for i := 1; i <= 3; i++ {
+		if _, ok := a.add("system", "duplicate", now.Add(time.Duration(i)*time.Minute)); ok {
+			t.Errorf("add() in the window expected to be held back")
+		}
+	}
+	if count, ok := a.add("system", "invalid-nonce", now); !ok || count != 1 {
+		t.Errorf("add() of another code = %d, %v", count, ok)
+	}
+	if count, ok := a.add("system", "duplicate", now.Add(anomalyWindow)); !ok || count != 4 {
+		t.Errorf("add() after the window = %d, %v", count, ok)
+	}
+	if count, ok := a.add("system", "invalid-nonce", now.Add(2*anomalyWindow)); !ok || count != 1 {
+		t.Errorf("add() after an expired window = %d, %v", count, ok)
+	}
+}
+
+func TestPublishAnomaly(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+	anomalies = &anomalyCounts{windows: map[string]*anomalyCount{}}
+
+	now := time.Now().UTC()
+	for i := 0; i < 5; i++ {
+		publishAnomaly(context.Background(), datastore.SigningError{Brand: "system", Model: "alder", Code: "duplicate", Created: now})
+	}
+
+	deliveries, _ := db.ClaimWebhookDeliveries(context.Background(), time.Now(), 10)
+	if len(deliveries) != 1 {
+		t.Fatalf("publishAnomaly() webhook deliveries = %d, want 1", len(deliveries))
+	}
+	if d := deliveries[0]; d.Event != datastore.WebhookEventSigningAnomaly || !strings.Contains(d.Payload, `"count":1`) {
+		t.Errorf("publishAnomaly() webhook delivery = %v", d)
+	}
+}
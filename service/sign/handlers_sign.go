@@ -21,6 +21,9 @@
 package sign
 
 import (
+	"context"
+	"crypto/x509"
+	"database/sql"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -29,13 +32,20 @@ import (
 	"strings"
 	"time"
 
-	"github.com/CanonicalLtd/serial-vault/service/log"
-
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/notify"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/lockout"
 	svlog "github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/metric"
 	"github.com/CanonicalLtd/serial-vault/service/request"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+	"github.com/CanonicalLtd/serial-vault/timestamp"
+	"github.com/CanonicalLtd/serial-vault/tlscert"
 	"github.com/snapcore/snapd/asserts"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
 	"gopkg.in/yaml.v2"
 )
 
@@ -49,48 +59,79 @@ type RequestIDResponse struct {
 // RequestID is the API method to generate a nonce
 func RequestID(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	w.Header().Set("Content-Type", response.JSONHeader)
-	// Check that we have an authorised API key header
-	_, err := request.CheckModelAPI(r)
-	if err != nil {
-		svlog.Message("REQUESTID", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
-		return response.ErrorInvalidAPIKey
+
+	nonce, errResponse := GenerateRequestID(r.Context(), r.Header.Get("api-key"), ClientFromRequest(r))
+	if !errResponse.Success {
+		return errResponse
+	}
+
+	// Return successful JSON response with the nonce
+	formatRequestIDResponse(nonce, w)
+	return response.ErrorResponse{Success: true}
+}
+
+// GenerateRequestID checks the model API key, or the client certificate, and generates a nonce for a device
+func GenerateRequestID(ctx context.Context, apiKey string, client Client) (datastore.DeviceNonce, response.ErrorResponse) {
+	// Check that we have an authorised API key or client certificate
+	certAccount, errResponse := checkClient(ctx, apiKey, client)
+	if !errResponse.Success {
+		svlog.Message("REQUESTID", errResponse.Code, errResponse.Message)
+		return datastore.DeviceNonce{}, errResponse
 	}
 
-	err = datastore.Environ.DB.DeleteExpiredDeviceNonces()
+	// The nonce is valid for the validity of the account of the model, or the default of the service
+	ttl, err := datastore.NonceTTL(datastore.Environ.Config)
+	if err == nil {
+		if len(apiKey) == 0 {
+			ttl, err = accountNonceTTL(ctx, certAccount, ttl)
+		} else {
+			ttl, err = datastore.Environ.DB.DeviceNonceTTL(ctx, apiKey, ttl)
+		}
+	}
 	if err != nil {
-		svlog.Message("REQUESTID", "delete-expired-nonces", err.Error())
-		return response.ErrorGenerateNonce
+		svlog.Message("REQUESTID", "generate-request-id", err.Error())
+		return datastore.DeviceNonce{}, response.ErrorGenerateNonce
 	}
 
-	nonce, err := datastore.Environ.DB.CreateDeviceNonce()
+	nonce, err := datastore.Environ.DB.CreateDeviceNonce(ctx, ttl)
 	if err != nil {
 		svlog.Message("REQUESTID", "generate-request-id", err.Error())
-		return response.ErrorGenerateNonce
+		return datastore.DeviceNonce{}, response.ErrorGenerateNonce
 	}
 
-	// Return successful JSON response with the nonce
-	formatRequestIDResponse(nonce, w)
-	return response.ErrorResponse{Success: true}
+	return nonce, response.ErrorResponse{Success: true}
 }
 
-func parseAssertionStream(r *http.Request) (map[string]asserts.Assertion, response.ErrorResponse) {
-	defer r.Body.Close()
+// accountNonceTTL returns the nonce validity of the account of a client certificate
+func accountNonceTTL(ctx context.Context, authorityID string, defaultTTL time.Duration) (time.Duration, error) {
+	account, err := datastore.Environ.DB.FindAccount(ctx, authorityID)
+	if err != nil {
+		return 0, err
+	}
+	ttl, err := datastore.Environ.DB.GetAllowedAccountNonceTTL(ctx, account.ID, datastore.User{})
+	if err != nil || !ttl.Override {
+		return defaultTTL, err
+	}
+	return time.Duration(ttl.Seconds) * time.Second, nil
+}
+
+func parseAssertionStream(logger *svlog.Logger, stream io.Reader) (map[string]asserts.Assertion, response.ErrorResponse) {
 	assertions := make(map[string]asserts.Assertion)
 
 	// Use snapd assertion module to decode the assertions in the request stream
-	dec := asserts.NewDecoder(r.Body)
+	dec := asserts.NewDecoder(stream)
 	serialRequestAssertion, err := dec.Decode()
 	if err == io.EOF {
-		svlog.Message("SIGN", "invalid-assertion", response.ErrorEmptyData.Message)
+		logger.Message("SIGN", "invalid-assertion", response.ErrorEmptyData.Message)
 		return nil, response.ErrorEmptyData
 	}
 	if err != nil {
-		svlog.Message("SIGN", "invalid-assertion", err.Error())
+		logger.Message("SIGN", "invalid-assertion", err.Error())
 		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 	// Check that we have a serial-request assertion (the details will have been validated by Decode call)
 	if serialRequestAssertion.Type() != asserts.SerialRequestType {
-		svlog.Message("SIGN", response.ErrorInvalidType.Code, "The assertion type must be 'serial-request'")
+		logger.Message("SIGN", response.ErrorInvalidType.Code, "The assertion type must be 'serial-request'")
 		return nil, response.ErrorInvalidType
 	}
 	assertions["serial-request"] = serialRequestAssertion
@@ -98,12 +139,12 @@ func parseAssertionStream(r *http.Request) (map[string]asserts.Assertion, respon
 	// Decode the optional model
 	modelAssert, err := dec.Decode()
 	if err != nil && err != io.EOF {
-		svlog.Message("SIGN", "invalid-assertion", err.Error())
+		logger.Message("SIGN", "invalid-assertion", err.Error())
 		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 	if modelAssert != nil {
 		if modelAssert.Type() != asserts.ModelType {
-			svlog.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
+			logger.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
 			return nil, response.ErrorInvalidSecondType
 		}
 		assertions["model"] = modelAssert
@@ -112,12 +153,12 @@ func parseAssertionStream(r *http.Request) (map[string]asserts.Assertion, respon
 	// Decode the optional serial for remodeling
 	serialAssertion, err := dec.Decode()
 	if err != nil && err != io.EOF {
-		svlog.Message("SIGN", "invalid-assertion", err.Error())
+		logger.Message("SIGN", "invalid-assertion", err.Error())
 		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 	if serialAssertion != nil {
 		if serialAssertion.Type() != asserts.SerialType {
-			svlog.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
+			logger.Message("SIGN", response.ErrorInvalidSecondType.Code, response.ErrorInvalidSecondType.Message)
 			return nil, response.ErrorInvalidSecondType
 		}
 		assertions["serial"] = serialAssertion
@@ -129,7 +170,7 @@ func parseAssertionStream(r *http.Request) (map[string]asserts.Assertion, respon
 		if err == nil {
 			err = fmt.Errorf("unexpected assertion in the request stream")
 		}
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, err.Error())
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, err.Error())
 		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
@@ -152,31 +193,138 @@ func isRemodelingSerialRequest(assertion *asserts.SerialRequest) bool {
 
 // Serial is the API method to sign serial assertions from the device
 func Serial(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
+	defer r.Body.Close()
 
-	// Check that we have an authorised API key header
-	apiKey, err := request.CheckModelAPI(r)
+	signedAssertion, errResponse := SignSerial(r.Context(), r.Header.Get("api-key"), ClientFromRequest(r), r.Body)
+	if !errResponse.Success {
+		return errResponse
+	}
+
+	// Return successful JSON response with the signed text
+	formatSignResponse(signedAssertion, w)
+	return response.ErrorResponse{Success: true}
+}
+
+// Client holds the details of the factory host that requested the signing. The certificate
+// is the verified client certificate of the mutual TLS, if one was presented
+type Client struct {
+	SourceIP    string
+	UserAgent   string
+	Certificate *x509.Certificate
+}
+
+// ClientFromRequest gets the client details from the HTTP request. The X-Forwarded-For header
+// is used when the service is behind a trusted proxy
+func ClientFromRequest(r *http.Request) Client {
+	client := Client{SourceIP: lockout.SourceIP(r), UserAgent: r.UserAgent()}
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0 {
+		client.Certificate = r.TLS.VerifiedChains[0][0]
+	}
+	return client
+}
+
+// checkClient checks the client certificate and the model API key of the client. The authority ID of
+// the account of the certificate is returned, when the client presented a certificate. A mapped
+// certificate is accepted without an API key, unless the client certificates are required, when
+// the certificate only restricts the API key to the account
+func checkClient(ctx context.Context, apiKey string, client Client) (string, response.ErrorResponse) {
+	if client.Certificate == nil {
+		return "", checkAPIKey(ctx, apiKey, client)
+	}
+
+	subject, sans := datastore.ClientCertIdentity(client.Certificate)
+	cert, err := datastore.Environ.DB.FindClientCert(ctx, subject, sans)
 	if err != nil {
-		svlog.Message("SIGN", response.ErrorInvalidAPIKey.Code, response.ErrorInvalidAPIKey.Message)
+		return "", response.ErrorClientCertNotAllowed
+	}
+	if len(apiKey) == 0 && datastore.Environ.Config.TLSClientAuth != tlscert.ClientAuthRequired {
+		return cert.AuthorityID, response.ErrorResponse{Success: true}
+	}
+	return cert.AuthorityID, checkAPIKey(ctx, apiKey, client)
+}
+
+// checkAPIKey checks the model API key of the client, which is locked out after repeated failures
+// and can only be used from the allowed networks of the key. A named API key of an account needs
+// the serial-signing scope. The use of the previous key of a rotated API key is audited
+func checkAPIKey(ctx context.Context, apiKey string, client Client) response.ErrorResponse {
+	k, err := request.CheckClientAPIKey(ctx, apiKey, client.SourceIP)
+	if err == lockout.ErrLockedOut {
+		return response.ErrorLockedOut
+	}
+	if err == request.ErrSourceNotAllowed {
+		return response.ErrorSourceNotAllowed
+	}
+	if err != nil {
+		return response.ErrorInvalidAPIKey
+	}
+	if k.ID > 0 && !k.HasScope(datastore.ScopeSerialSigning) {
 		return response.ErrorInvalidAPIKey
 	}
+	audit.DeprecatedAPIKey(ctx, k)
+	return response.ErrorResponse{Success: true}
+}
+
+// SignSerial checks the model API key, validates the serial-request assertion
+// stream and returns the signed serial assertion. The failures are recorded for
+// the brand once the serial-request is validated, for the dashboard. The steps are
+// traced as the children of the span in the context
+func SignSerial(ctx context.Context, apiKey string, client Client, stream io.Reader) (signed asserts.Assertion, errResponse response.ErrorResponse) {
+	ctx, span := tracing.Start(ctx, "sign.SignSerial")
+	logger := svlog.FromContext(ctx).With(svlog.FieldComponent, "sign")
+	var brand, modelName, certAccount string
+	var quotaCounted, brandConfirmed bool
+	defer func() {
+		span.SetAttributes(attribute.String("brand", brand), attribute.String("model", modelName))
+		if !errResponse.Success {
+			span.SetStatus(codes.Error, errResponse.Code)
+		}
+		span.End()
+
+		// The device that is not signed is released from the quota of the brand
+		if !errResponse.Success && quotaCounted {
+			if err := datastore.Environ.DB.ReleaseAccountQuota(ctx, brand); err != nil {
+				logger.Errorf("%v", err)
+			}
+		}
+
+		// The brand of the serial-request is only trusted once it is the brand of the client
+		if !errResponse.Success && len(brand) > 0 && (brandConfirmed || clientBrand(ctx, brand, modelName, apiKey, certAccount)) {
+			recordError(ctx, logger, brand, modelName, errResponse)
+		}
+	}()
 
-	assertions, errResponse := parseAssertionStream(r)
+	// Check that we have an authorised API key or client certificate
+	certAccount, errResponse = checkClient(ctx, apiKey, client)
 	if !errResponse.Success {
-		return errResponse
+		logger.Message("SIGN", errResponse.Code, errResponse.Message)
+		return nil, errResponse
+	}
+
+	assertions, errResponse := parseAssertionStream(logger, stream)
+	if !errResponse.Success {
+		return nil, errResponse
 	}
 
 	serialReq, ok := assertions["serial-request"].(*asserts.SerialRequest)
 	if !ok {
 		msg := fmt.Sprintf("expected serial-request, got type %q", serialReq.Type().Name)
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
-	err = asserts.SignatureCheck(serialReq, serialReq.DeviceKey())
+	err := asserts.SignatureCheck(serialReq, serialReq.DeviceKey())
 	if err != nil {
 		msg := fmt.Sprintf("could not validate serial-request self-signature (%s)", err)
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+	}
+	brand, modelName = serialReq.HeaderString("brand-id"), serialReq.HeaderString("model")
+	logger = logger.WithFields(svlog.Fields{svlog.FieldAccount: brand, "model": modelName})
+
+	// The client certificate can only request the serials of its account
+	if len(certAccount) > 0 && certAccount != brand {
+		logger.Message("SIGN", response.ErrorClientCertNotAllowed.Code, response.ErrorClientCertNotAllowed.Message)
+		return nil, response.ErrorClientCertNotAllowed
 	}
 
 	// Double check the model assertion if present
@@ -184,77 +332,243 @@ func Serial(w http.ResponseWriter, r *http.Request) response.ErrorResponse {
 	if ok {
 		if modelAssert.HeaderString("brand-id") != serialReq.HeaderString("brand-id") || modelAssert.HeaderString("model") != serialReq.HeaderString("model") {
 			const msg = "Model and serial-request assertion do not match"
-			svlog.Message("SIGN", "mismatched-model", msg)
-			return response.ErrorResponse{Success: false, Code: "mismatched-model", Message: msg, StatusCode: http.StatusBadRequest}
+			logger.Message("SIGN", "mismatched-model", msg)
+			return nil, response.ErrorResponse{Success: false, Code: "mismatched-model", Message: msg, StatusCode: http.StatusBadRequest}
 		}
 
 		// TODO: ideally check the signature of model, need access
 		// to the brand public key(s) for models
 	}
 
+	// The sub-store model of a remodeling request, so its pivot is recorded
+	var pivot *datastore.Substore
 	if isRemodelingSerialRequest(serialReq) {
 		serialAssert := assertions["serial"]
-		errResponse := checkRemodelingRequest(serialReq, modelAssert, serialAssert, apiKey)
+		substore, errResponse := checkRemodelingRequest(ctx, logger, serialReq, modelAssert, serialAssert, apiKey, certAccount)
 		if !errResponse.Success {
-			return errResponse
+			return nil, errResponse
 		}
+		pivot = &substore
 	} else {
 		// Check the serial assertion
 		if _, ok := assertions["serial"]; ok {
 			const msg = "unexpected assertion in the request stream"
-			svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-			return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+			logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+			return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 		}
 	}
 
 	// Verify that the nonce is valid and has not expired
-	err = datastore.Environ.DB.ValidateDeviceNonce(serialReq.HeaderString("request-id"))
+	_, nonceSpan := tracing.Start(ctx, "datastore.ValidateDeviceNonce")
+	err = datastore.Environ.DB.ValidateDeviceNonce(ctx, serialReq.HeaderString("request-id"))
+	tracing.End(nonceSpan, err)
 	if err != nil {
-		svlog.Message("SIGN", response.ErrorInvalidNonce.Code, response.ErrorInvalidNonce.Message)
-		return response.ErrorInvalidNonce
+		logger.Message("SIGN", response.ErrorInvalidNonce.Code, err.Error())
+		return nil, response.ErrorResponse{Success: false, Code: response.ErrorInvalidNonce.Code, Message: err.Error(), StatusCode: response.ErrorInvalidNonce.StatusCode}
 	}
 
 	// Validate the model by checking that it exists on the database
-	model, errResponse := findModel(serialReq.HeaderString("brand-id"), serialReq.HeaderString("model"), serialReq.HeaderString("serial"), apiKey)
+	_, modelSpan := tracing.Start(ctx, "datastore.FindModel")
+	model, errResponse := findModel(ctx, logger, serialReq.HeaderString("brand-id"), serialReq.HeaderString("model"), serialReq.HeaderString("serial"), apiKey, certAccount)
+	modelSpan.End()
 	if !errResponse.Success {
-		return errResponse
+		return nil, errResponse
 	}
+	brandConfirmed = true
 
 	// Check that the model has an active keypair
 	if !model.KeyActive {
-		svlog.Message("SIGN", response.ErrorInactiveModel.Code, response.ErrorInactiveModel.Message)
-		return response.ErrorInactiveModel
+		logger.Message("SIGN", response.ErrorInactiveModel.Code, response.ErrorInactiveModel.Message)
+		return nil, response.ErrorInactiveModel
 	}
 
-	// Create a basic signing log entry (without the serial number)
-	signingLog := datastore.SigningLog{Make: serialReq.HeaderString("brand-id"), Model: serialReq.HeaderString("model"), Fingerprint: serialReq.SignKeyID()}
+	// Check that the brand has not used up its signing quota, and count the device in the quota
+	_, quotaSpan := tracing.Start(ctx, "datastore.CheckAccountQuota")
+	errResponse = checkQuota(ctx, logger, serialReq.HeaderString("brand-id"))
+	quotaSpan.End()
+	if !errResponse.Success {
+		return nil, errResponse
+	}
+	quotaCounted = true
+
+	// Get the assertion timestamp from the configured time source
+	stamp, err := timestamp.Stamp(datastore.Environ.Config)
+	if err != nil {
+		logger.Message("SIGN", response.ErrorTimestampSource.Code, err.Error())
+		return nil, response.ErrorTimestampSource
+	}
+	signingTime := stamp.Time
+
+	// Check that the model can be signed at this time and from this address
+	errResponse = checkModelRestrictions(logger, model, client.SourceIP, signingTime)
+	if !errResponse.Success {
+		return nil, errResponse
+	}
+
+	// Create a basic signing log entry (without the serial number), with the token that proves the time
+	signingLog := datastore.SigningLog{Make: serialReq.HeaderString("brand-id"), Model: serialReq.HeaderString("model"), Fingerprint: serialReq.SignKeyID(), TimestampSource: stamp.Source,
+		SourceIP: client.SourceIP, APIKeyID: datastore.APIKeyID(apiKey), RequestID: serialReq.HeaderString("request-id"), UserAgent: client.UserAgent, TimestampToken: stamp.Token}
 
 	// Convert the serial-request headers into a serial assertion
-	serialAssertion, err := serialRequestToSerial(serialReq, &signingLog)
+	serialAssertion, err := serialRequestToSerial(ctx, logger, serialReq, &signingLog, signingTime, model.SerialHeaderList())
 	if err != nil {
-		svlog.Message("SIGN", response.ErrorCreateAssertion.Code, err.Error())
-		return response.ErrorCreateAssertion
+		logger.Message("SIGN", response.ErrorCreateAssertion.Code, err.Error())
+		return nil, response.ErrorCreateAssertion
+	}
+
+	// Check that the serial number has the format that is expected for the model
+	if !model.MatchesSerialFormat(serialAssertion.HeaderString("serial")) {
+		logger.Message("SIGN", response.ErrorInvalidSerialFormat.Code, response.ErrorInvalidSerialFormat.Message)
+		return nil, response.ErrorInvalidSerialFormat
+	}
+
+	// Embed the original brand and model in the serial assertion of the pivoted model
+	serialHeaders := serialAssertion.Headers()
+	if pivot != nil {
+		pivot.SetOriginalHeaders(serialHeaders)
 	}
 
 	// Sign the assertion with the snapd assertions module
-	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, serialAssertion.Headers(), serialAssertion.Body(), model.AuthorityID, model.KeyID, model.SealedKey)
+	_, signSpan := tracing.Start(ctx, "keystore.SignAssertion", attribute.String("keystore", datastore.Environ.Config.KeyStoreType))
+	signedAssertion, err := datastore.Environ.KeypairDB.SignAssertion(asserts.SerialType, serialHeaders, serialAssertion.Body(), model.AuthorityID, model.KeyID, model.SealedKey)
+	tracing.End(signSpan, err)
 	if err != nil {
-		svlog.Message("SIGN", "signing-assertion", err.Error())
-		return response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "signing-assertion", err.Error())
+		return nil, response.ErrorResponse{Success: false, Code: "signing-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
 	}
 
 	// Store the serial number and device-key fingerprint in the database
-	err = datastore.Environ.DB.CreateSigningLog(signingLog)
+	_, logSpan := tracing.Start(ctx, "datastore.CreateSigningLog")
+	err = datastore.Environ.DB.CreateSigningLog(ctx, signingLog)
+	tracing.End(logSpan, err)
 	if err != nil {
-		svlog.Message("SIGN", "logging-assertion", err.Error())
-		return response.ErrorResponse{Success: false, Code: "logging-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "logging-assertion", err.Error())
+		return nil, response.ErrorResponse{Success: false, Code: "logging-assertion", Message: err.Error(), StatusCode: http.StatusBadRequest}
+	}
+
+	// Record the pivot in the history of the device, which does not fail the signed request
+	if pivot != nil {
+		recordPivot(ctx, logger, *pivot, CleanHeader(serialReq.HeaderString("original-serial")), serialReq.HeaderString("original-model"))
+	}
+
+	return signedAssertion, response.ErrorResponse{Success: true}
+}
+
+// recordPivot records the pivot of a device from its current model to the sub-store model
+func recordPivot(ctx context.Context, logger *svlog.Logger, substore datastore.Substore, serialNumber, currentModel string) {
+	p := datastore.SubstorePivot{
+		SubstoreID:   substore.ID,
+		FromModelID:  substore.FromModelID,
+		SerialNumber: serialNumber,
+		ModelName:    substore.ModelName,
+	}
+	if currentModel != substore.FromModel.Name {
+		p.FromModelName = currentModel
+	}
+	if err := datastore.Environ.DB.CreateSubstorePivot(ctx, p); err != nil {
+		logger.Errorf("%v", err)
+	}
+}
+
+// recordError records the failed signing request of the brand
+func recordError(ctx context.Context, logger *svlog.Logger, brand, modelName string, errResponse response.ErrorResponse) {
+	signingError := datastore.SigningError{Brand: brand, Model: modelName, Code: errResponse.Code, Message: errResponse.Message, Created: time.Now().UTC()}
+	err := datastore.Environ.DB.CreateSigningError(ctx, signingError)
+	if err != nil {
+		logger.Errorf("%v", err)
+	}
+
+	// The refused signings that may be an abuse of the account are published to its webhooks
+	if anomalyCodes[errResponse.Code] && errResponse != response.ErrorCheckAssertion {
+		publishAnomaly(ctx, signingError)
+	}
+}
+
+// clientBrand checks that the brand of a serial-request is the brand of the client, from its
+// client certificate, or from its API key for a model or the account of the brand
+func clientBrand(ctx context.Context, brand, modelName, apiKey, certAccount string) bool {
+	if len(certAccount) > 0 {
+		return certAccount == brand
+	}
+	if len(apiKey) == 0 {
+		return false
+	}
+	if datastore.Environ.DB.CheckAccountAPIKey(ctx, apiKey, brand, datastore.ScopeSerialSigning) {
+		return true
+	}
+	_, err := datastore.Environ.DB.FindModel(ctx, brand, modelName, apiKey, datastore.ScopeSerialSigning)
+	return err == nil
+}
+
+// anomalyCodes are the codes of the refused signings that are signing anomalies of the account
+var anomalyCodes = map[string]bool{
+	response.ErrorDuplicateAssertion.Code:    true,
+	response.ErrorInvalidNonce.Code:          true,
+	response.ErrorInvalidSerialFormat.Code:   true,
+	response.ErrorInactiveKeypair.Code:       true,
+	response.ErrorQuotaExceeded.Code:         true,
+	response.ErrorSourceNotAllowed.Code:      true,
+	response.ErrorOutsideSigningHours.Code:   true,
+	response.ErrorModelSourceNotAllowed.Code: true,
+	response.ErrorClientCertNotAllowed.Code:  true,
+}
+
+// checkModelRestrictions checks the signing hours and the allowed networks of the model. The
+// refused signings are counted in the metrics, so they can be alerted on
+func checkModelRestrictions(logger *svlog.Logger, model datastore.Model, sourceIP string, signingTime time.Time) response.ErrorResponse {
+	errResponse := response.ErrorResponse{Success: true}
+	switch {
+	case !model.AllowsSigningTime(signingTime):
+		errResponse = response.ErrorOutsideSigningHours
+	case !model.AllowsSource(sourceIP):
+		errResponse = response.ErrorModelSourceNotAllowed
+	default:
+		return errResponse
+	}
+
+	logger.Message("SIGN", errResponse.Code, fmt.Sprintf("%s: %s/%s from %s at %s", errResponse.Message, model.BrandID, model.Name, sourceIP, signingTime.Format(time.RFC3339)))
+	metric.ModelSigningRefusedCounterVec.WithLabelValues(model.BrandID, model.Name, errResponse.Code).Inc()
+	return errResponse
+}
+
+// checkQuota checks the signing quota of the brand account. The warning level and the
+// grace overage are only notified, so a production run is not stopped by a tight quota
+func checkQuota(ctx context.Context, logger *svlog.Logger, brandID string) response.ErrorResponse {
+	check, err := datastore.Environ.DB.CheckAccountQuota(ctx, brandID)
+	if err != nil {
+		logger.Message("SIGN", response.ErrorCheckQuota.Code, err.Error())
+		return response.ErrorCheckQuota
+	}
+
+	quota := check.Quota
+	if check.Notify {
+		logger.Warningf("The signing quota of the account %s has reached the warning level: %d of %d signed (limit %d, grace %d)",
+			brandID, quota.Used, quota.Warning, quota.Limit, quota.Grace)
+		if check.State != datastore.QuotaExceeded {
+			notify.Send(ctx, notify.EventQuotaWarning, brandID, quotaFields(quota))
+		}
+	}
+
+	switch check.State {
+	case datastore.QuotaGrace:
+		logger.Warningf("The signing quota of the account %s is in the grace overage: %d signed (limit %d, grace %d)",
+			brandID, quota.Used, quota.Limit, quota.Grace)
+	case datastore.QuotaExceeded:
+		logger.Message("SIGN", response.ErrorQuotaExceeded.Code, fmt.Sprintf("%s: %s", response.ErrorQuotaExceeded.Message, brandID))
+
+		// The exceeded quota is notified once, until the quota is changed
+		notify.SendOnce(ctx, notify.EventQuotaExceeded, fmt.Sprintf("%s/%d/%d", brandID, quota.Limit, quota.Grace), brandID, quotaFields(quota))
+		return response.ErrorQuotaExceeded
 	}
 
-	// Return successful JSON response with the signed text
-	formatSignResponse(signedAssertion, w)
 	return response.ErrorResponse{Success: true}
 }
 
+// quotaFields returns the fields of the notifications of the quota of an account
+func quotaFields(quota datastore.AccountQuota) map[string]interface{} {
+	return map[string]interface{}{"Used": quota.Used, "Warning": quota.Warning, "Limit": quota.Limit, "Grace": quota.Grace}
+}
+
 // CleanHeader removes single quotes and leading and trailing white spaces from the header
 func CleanHeader(header string) string {
 	header = strings.Replace(header, "'", "", -1)
@@ -262,123 +576,134 @@ func CleanHeader(header string) string {
 	return header
 }
 
-func checkRemodelingRequest(serialReq *asserts.SerialRequest, modelAssert, serialAssert asserts.Assertion, apiKey string) response.ErrorResponse {
+func checkRemodelingRequest(ctx context.Context, logger *svlog.Logger, serialReq *asserts.SerialRequest, modelAssert, serialAssert asserts.Assertion, apiKey, certAccount string) (datastore.Substore, response.ErrorResponse) {
 	originalBrandID := serialReq.HeaderString("original-brand-id")
 	originalModel := serialReq.HeaderString("original-model")
 	originalSerial := CleanHeader(serialReq.HeaderString("original-serial"))
 
 	if modelAssert == nil {
 		const msg = "Model assertion can't be empty for a remodeling request"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	// Double check the serial assertion
 	if serialAssert == nil {
 		const msg = "The current serial assertion can't be empty for a remodeling request"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	// Validate the original model by checking that it exists on the database
-	originalModelAssert, errResponse := findModel(originalBrandID, originalModel, originalSerial, apiKey)
+	originalModelAssert, errResponse := findModel(ctx, logger, originalBrandID, originalModel, originalSerial, apiKey, certAccount)
 	if !errResponse.Success {
-		svlog.Message("SIGN", "invalid-assertion", "original model is not valid")
-		return errResponse
+		logger.Message("SIGN", "invalid-assertion", "original model is not valid")
+		return datastore.Substore{}, errResponse
 	}
 
 	// Validate the new model: it must be defind in the sub-store of the orignal model
-	substore, err := datastore.Environ.DB.GetSubstore(originalModelAssert.ID, originalSerial)
+	substore, err := datastore.Environ.DB.GetSubstore(ctx, originalModelAssert.ID, originalSerial)
 	if err != nil {
-		svlog.Message("PIVOT", "invalid-substore", "Cannot find sub-store mapping for the model")
-		return response.ErrorInvalidSubstore
+		logger.Message("PIVOT", "invalid-substore", "Cannot find sub-store mapping for the model")
+		return datastore.Substore{}, response.ErrorInvalidSubstore
 	}
 
 	// Check if find model maches requested model
 	if serialReq.HeaderString("model") != substore.ModelName {
 		const msg = "Requested model is invalid"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	// Check that original-* fields are matching old serial
 	if serialAssert.HeaderString("model") != originalModel {
 		const msg = "Original model is invalid"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 	if serialAssert.HeaderString("serial") != originalSerial {
 		const msg = "Original serial number is invalid"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 	if serialAssert.HeaderString("brand-id") != originalBrandID {
 		const msg = "Original brand-id is invalid"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	// Check that the device key is the same between serial-request and old serial
 	if serialAssert.HeaderString("device-key") != serialReq.HeaderString("device-key") {
 		const msg = "Device-key is invalid"
-		svlog.Message("SIGN", "invalid-assertion", msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", "invalid-assertion", msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	keyID := substore.FromModel.KeyID
 	if keyID != serialAssert.HeaderString("sign-key-sha3-384") {
 		msg := fmt.Sprintf("public key id for the model is invalid")
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	oldModelPublicKey, err := datastore.Environ.KeypairDB.PublicKey(keyID)
 	if err != nil {
 		msg := fmt.Sprintf("could not find public key for the model (%s)", err)
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
 	err = asserts.SignatureCheck(serialAssert, oldModelPublicKey)
 	if err != nil {
 		msg := fmt.Sprintf("could not validate serial-request self-signature (%s)", err)
-		svlog.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
-		return response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
+		logger.Message("SIGN", response.ErrorInvalidAssertion.Code, msg)
+		return datastore.Substore{}, response.ErrorResponse{Success: false, Code: response.ErrorInvalidAssertion.Code, Message: msg, StatusCode: http.StatusBadRequest}
 	}
 
-	return response.ErrorResponse{Success: true}
+	return substore, response.ErrorResponse{Success: true}
 }
 
-// findModel finds the model by checking that there is an original or pivoted model
-func findModel(brandID, modelName, serialNumer, apiKey string) (datastore.Model, response.ErrorResponse) {
+// findModel finds the model by checking that there is an original or pivoted model. The models
+// of the account of the client certificate are found without an API key
+func findModel(ctx context.Context, logger *svlog.Logger, brandID, modelName, serialNumer, apiKey, certAccount string) (datastore.Model, response.ErrorResponse) {
+	certBrand := len(certAccount) > 0 && certAccount == brandID
+
 	// Assume this is an original (non-pivoted) serial assertion
 	// Validate the model by checking that it exists on the database
-	model, err := datastore.Environ.DB.FindModel(brandID, modelName, apiKey)
+	var model datastore.Model
+	var err error
+	if len(apiKey) == 0 && certBrand {
+		model, err = datastore.Environ.DB.FindAccountModel(ctx, brandID, modelName)
+	} else {
+		model, err = datastore.Environ.DB.FindModel(ctx, brandID, modelName, apiKey, datastore.ScopeSerialSigning)
+	}
 	if err != nil {
-		svlog.Message("SIGN", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
+		logger.Message("SIGN", response.ErrorInvalidModel.Code, response.ErrorInvalidModel.Message)
 	} else {
 		// Found the model, so return it
+		audit.DeprecatedModelKey(ctx, model)
 		return model, response.ErrorResponse{Success: true}
 	}
 
 	// Assume that this is a pivoted serial assertion
 	// Check for a sub-store model for the pivot
-	substore, err := datastore.Environ.DB.GetSubstoreModel(brandID, modelName, serialNumer)
+	substore, err := datastore.Environ.DB.GetSubstoreModel(ctx, brandID, modelName, serialNumer)
 	if err != nil {
-		log.Println(err)
-		svlog.Message("CHECK", response.ErrorInvalidModelSubstore.Code, response.ErrorInvalidModelSubstore.Message)
+		logger.Errorf("%v", err)
+		logger.Message("CHECK", response.ErrorInvalidModelSubstore.Code, response.ErrorInvalidModelSubstore.Message)
 		return model, response.ErrorInvalidModelSubstore
 	}
 
-	if substore.FromModel.APIKey != apiKey {
+	if !certBrand && substore.FromModel.APIKey != apiKey && !datastore.Environ.DB.CheckAccountAPIKey(ctx, apiKey, brandID, datastore.ScopeSerialSigning) {
 		return substore.FromModel, response.ErrorInvalidModelSubstore
 	}
 
 	return substore.FromModel, response.ErrorResponse{Success: true}
 }
 
-// serialRequestToSerial converts a serial-request to a serial assertion
-func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.SigningLog) (asserts.Assertion, error) {
+// serialRequestToSerial converts a serial-request to a serial assertion.
+// The approved headers of the model are copied from the serial-request, when they are present
+func serialRequestToSerial(ctx context.Context, logger *svlog.Logger, assertion asserts.Assertion, signingLog *datastore.SigningLog, signingTime time.Time, passthroughHeaders []string) (asserts.Assertion, error) {
 
 	// Create the serial assertion header from the serial-request headers
 	serialHeaders := assertion.Headers()
@@ -391,7 +716,13 @@ func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.Si
 		"sign-key-sha3-384":   serialHeaders["sign-key-sha3-384"],
 		"device-key-sha3-384": serialHeaders["sign-key-sha3-384"],
 		"model":               serialHeaders["model"],
-		"timestamp":           time.Now().Format(time.RFC3339),
+		"timestamp":           signingTime.Format(time.RFC3339),
+	}
+
+	for _, h := range passthroughHeaders {
+		if value, ok := serialHeaders[h]; ok {
+			headers[h] = value
+		}
 	}
 
 	// Get the serial-number from the header, but fallback to the body if it is not there
@@ -406,19 +737,36 @@ func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.Si
 
 	// Check that we have a serial
 	if headers["serial"] == nil {
-		svlog.Message("SIGN", "create-assertion", response.ErrorEmptySerial.Message)
+		logger.Message("SIGN", "create-assertion", response.ErrorEmptySerial.Message)
 		return nil, errors.New(response.ErrorEmptySerial.Message)
 	}
 
-	// Check that we have not already signed this device, and get the max. revision number for the serial number
-	signingLog.SerialNumber = headers["serial"].(string)
-	duplicateExists, maxRevision, err := datastore.Environ.DB.CheckForDuplicate(signingLog)
+	// Brands that do not keep the serial numbers of their devices log the hash of the
+	// serial number, which still detects the duplicates
+	serialNumbers, err := storedSerialNumbers(ctx, signingLog.Make, headers["serial"].(string))
 	if err != nil {
-		svlog.Message("SIGN", "duplicate-assertion", err.Error())
-		return nil, errors.New(response.ErrorDuplicateAssertion.Message)
+		logger.Message("SIGN", "hash-serial", err.Error())
+		return nil, err
+	}
+
+	// Check that we have not already signed this device, and get the max. revision number for the serial number.
+	// The logs that were signed before the hashing was changed for the brand hold the other value
+	duplicateExists, maxRevision := false, 0
+	for _, serialNumber := range serialNumbers {
+		signingLog.SerialNumber = serialNumber
+		duplicate, revision, err := datastore.Environ.DB.CheckForDuplicate(ctx, signingLog)
+		if err != nil {
+			logger.Message("SIGN", "duplicate-assertion", err.Error())
+			return nil, errors.New(response.ErrorDuplicateAssertion.Message)
+		}
+		duplicateExists = duplicateExists || duplicate
+		if revision > maxRevision {
+			maxRevision = revision
+		}
 	}
+	signingLog.SerialNumber = serialNumbers[0]
 	if duplicateExists {
-		svlog.Message("SIGN", "duplicate-assertion", "The serial number and/or device-key have already been used to sign a device")
+		logger.Message("SIGN", "duplicate-assertion", "The serial number and/or device-key have already been used to sign a device")
 	}
 
 	// Set the revision number, incrementing the previously used one
@@ -435,6 +783,21 @@ func serialRequestToSerial(assertion asserts.Assertion, signingLog *datastore.Si
 	return asserts.Assemble(headers, assertion.Body(), content, signature)
 }
 
+// storedSerialNumbers returns the values of the serial number in the signing logs of the brand,
+// starting with the value that is logged. Brands without an account keep the serial numbers in
+// clear, but any other error fails the signing, so the serial number is never logged in clear
+// for a brand that hashes them
+func storedSerialNumbers(ctx context.Context, brandID, serialNumber string) ([]string, error) {
+	account, err := datastore.Environ.DB.FindAccount(ctx, brandID)
+	if err == sql.ErrNoRows {
+		return []string{serialNumber}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error finding the account of the brand: %v", err)
+	}
+	return datastore.Environ.LookupSerialNumbers(account, serialNumber)
+}
+
 func formatSignResponse(assertion asserts.Assertion, w http.ResponseWriter) error {
 	w.Header().Set("Content-Type", asserts.MediaType)
 	w.WriteHeader(http.StatusOK)
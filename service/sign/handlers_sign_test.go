@@ -22,12 +22,19 @@ package sign_test
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/crypt"
@@ -36,6 +43,10 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/CanonicalLtd/serial-vault/service/sign"
 	"github.com/snapcore/snapd/asserts"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
 	check "gopkg.in/check.v1"
 )
 
@@ -100,6 +111,10 @@ func (s *SignSuite) TestSerial(c *check.C) {
 	c.Assert(err, check.IsNil)
 	assertSigningLogError, err := generateSerialRequestAssertion("alder", "AsigninglogError", "")
 	c.Assert(err, check.IsNil)
+	assertSerialFormat, err := generateSerialRequestAssertion("birch", "B123456", "")
+	c.Assert(err, check.IsNil)
+	assertBadSerialFormat, err := generateSerialRequestAssertion("birch", "A123456L", "")
+	c.Assert(err, check.IsNil)
 
 	assertionsWithSerial := append(assertSPlusM, []byte("\n"+serial)...)
 
@@ -114,12 +129,15 @@ func (s *SignSuite) TestSerial(c *check.C) {
 		{false, "POST", "/v1/serial", assertNoSerial, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertFakeModel, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assert, 400, response.JSONHeader, "NoModelForApiKey"},
+		{false, "POST", "/v1/serial", assert, 400, response.JSONHeader, "ReadOnlyAPIKey"},
 		{false, "POST", "/v1/serial", assertSigningLogError, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertDuplicate, 200, asserts.MediaType, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", nil, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", []byte(""), 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assert, 400, response.JSONHeader, "InvalidAPIKey"},
 		{false, "POST", "/v1/serial", assertInactive, 400, response.JSONHeader, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", assertSerialFormat, 200, asserts.MediaType, "ValidAPIKey"},
+		{false, "POST", "/v1/serial", assertBadSerialFormat, 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", []byte(badSerialRequest), 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", []byte(assertionWrongType), 400, response.JSONHeader, "ValidAPIKey"},
 		{false, "POST", "/v1/serial", assertionsWithSerial, 400, response.JSONHeader, "ValidAPIKey"},
@@ -140,10 +158,406 @@ func (s *SignSuite) TestSerial(c *check.C) {
 	}
 }
 
+func (s *SignSuite) TestSerialPassthroughHeaders(c *check.C) {
+	privateKey, _ := generatePrivateKey()
+	encodedPubKey, _ := asserts.EncodePublicKey(privateKey.PublicKey())
+	headers := map[string]interface{}{
+		"brand-id":     "system",
+		"device-key":   string(encodedPubKey),
+		"request-id":   "REQID",
+		"model":        "birch",
+		"serial":       "B123456",
+		"factory-line": "line-7",
+		"other-header": "not copied",
+	}
+	sreq, err := asserts.SignWithoutAuthority(asserts.SerialRequestType, headers, nil, privateKey)
+	c.Assert(err, check.IsNil)
+
+	w := sendRequest("POST", "/v1/serial", bytes.NewReader(asserts.Encode(sreq)), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 200)
+
+	serial, err := asserts.Decode(w.Body.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Assert(serial.HeaderString("factory-line"), check.Equals, "line-7")
+	c.Assert(serial.Header("hardware-revision"), check.IsNil)
+	c.Assert(serial.Header("other-header"), check.IsNil)
+}
+
+func (s *SignSuite) TestSerialTimestampSource(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	// The serial is not signed when the time source cannot be used
+	datastore.Environ.Config.TimestampSource = "ntp"
+	w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, response.ErrorTimestampSource.Code)
+
+	datastore.Environ.Config.TimestampSource = "system"
+	w = sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, asserts.MediaType)
+}
+
+// quotaMockDB mocks the database with a fixed quota state for the brand
+type quotaMockDB struct {
+	datastore.MockDB
+	state  string
+	errors []datastore.SigningError
+}
+
+func (mdb *quotaMockDB) CreateSigningError(ctx context.Context, e datastore.SigningError) error {
+	mdb.errors = append(mdb.errors, e)
+	return nil
+}
+
+func (mdb *quotaMockDB) CheckAccountQuota(ctx context.Context, authorityID string) (datastore.QuotaCheck, error) {
+	quota := datastore.AccountQuota{AccountID: 1, Warning: 80, Limit: 100, Grace: 10, Used: 105}
+	return datastore.QuotaCheck{Quota: quota, State: mdb.state, Notify: true}, nil
+}
+
+func (s *SignSuite) TestSerialQuota(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		state string
+		code  int
+	}{
+		{datastore.QuotaWarning, 200},
+		{datastore.QuotaGrace, 200},
+		{datastore.QuotaExceeded, 400},
+	}
+
+	for _, t := range tests {
+		db := &quotaMockDB{state: t.state}
+		datastore.Environ.DB = db
+
+		w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+		c.Assert(w.Code, check.Equals, t.code)
+
+		if t.code != 200 {
+			result, err := response.ParseStandardResponse(w)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.ErrorCode, check.Equals, response.ErrorQuotaExceeded.Code)
+
+			// The failure is recorded for the brand
+			c.Assert(db.errors, check.HasLen, 1)
+			c.Assert(db.errors[0].Brand, check.Equals, "system")
+			c.Assert(db.errors[0].Model, check.Equals, "alder")
+			c.Assert(db.errors[0].Code, check.Equals, response.ErrorQuotaExceeded.Code)
+		} else {
+			c.Assert(db.errors, check.HasLen, 0)
+		}
+	}
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+// invalidNonceMockDB mocks the database with a nonce that is not valid
+type invalidNonceMockDB struct {
+	datastore.MockDB
+	errors []datastore.SigningError
+}
+
+func (mdb *invalidNonceMockDB) ValidateDeviceNonce(ctx context.Context, nonce string) error {
+	return fmt.Errorf("the nonce is not valid")
+}
+
+func (mdb *invalidNonceMockDB) CreateSigningError(ctx context.Context, e datastore.SigningError) error {
+	mdb.errors = append(mdb.errors, e)
+	return nil
+}
+
+func (s *SignSuite) TestSerialErrorBrandConfirmed(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		apiKey string
+		errors int
+	}{
+		{"ValidAPIKey", 1},
+		// The API key is not for a model of the brand of the serial-request
+		{"NoModelForApiKey", 0},
+	}
+
+	for _, t := range tests {
+		db := &invalidNonceMockDB{}
+		datastore.Environ.DB = db
+
+		w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), t.apiKey, c)
+		c.Assert(w.Code, check.Equals, response.ErrorInvalidNonce.StatusCode)
+		c.Assert(db.errors, check.HasLen, t.errors, check.Commentf(t.apiKey))
+	}
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+// restrictedModelMockDB mocks the database with the signing restrictions on the model
+type restrictedModelMockDB struct {
+	datastore.MockDB
+	signingHours string
+	allowedCIDRs string
+	errors       []datastore.SigningError
+}
+
+func (mdb *restrictedModelMockDB) FindModel(ctx context.Context, brandID, modelName, apiKey string, scopes ...string) (datastore.Model, error) {
+	model, err := mdb.MockDB.FindModel(ctx, brandID, modelName, apiKey, scopes...)
+	model.SigningHours = mdb.signingHours
+	model.AllowedCIDRs = mdb.allowedCIDRs
+	return model, err
+}
+
+func (mdb *restrictedModelMockDB) CreateSigningError(ctx context.Context, e datastore.SigningError) error {
+	mdb.errors = append(mdb.errors, e)
+	return nil
+}
+
+func (s *SignSuite) TestSerialModelRestrictions(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	// Signing hours that exclude the current time
+	now := time.Now().UTC()
+	closed := fmt.Sprintf("%s-%s", now.Add(2*time.Hour).Format("15:04"), now.Add(3*time.Hour).Format("15:04"))
+
+	tests := []struct {
+		signingHours string
+		allowedCIDRs string
+		code         int
+		errorCode    string
+	}{
+		{"", "", 200, ""},
+		{"00:00-24:00", "192.0.2.0/24", 200, ""},
+		{closed, "", 403, response.ErrorOutsideSigningHours.Code},
+		{"", "10.0.0.0/8,198.51.100.1", 403, response.ErrorModelSourceNotAllowed.Code},
+	}
+
+	for _, t := range tests {
+		db := &restrictedModelMockDB{signingHours: t.signingHours, allowedCIDRs: t.allowedCIDRs}
+		datastore.Environ.DB = db
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/serial", bytes.NewReader(assert))
+		r.Header.Set("api-key", "ValidAPIKey")
+		r.RemoteAddr = "192.0.2.10:41234"
+		service.SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s %s", t.signingHours, t.allowedCIDRs))
+
+		if t.code != 200 {
+			result, err := response.ParseStandardResponse(w)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.ErrorCode, check.Equals, t.errorCode)
+
+			// The refused signing is recorded for the brand
+			c.Assert(db.errors, check.HasLen, 1)
+			c.Assert(db.errors[0].Code, check.Equals, t.errorCode)
+		} else {
+			c.Assert(db.errors, check.HasLen, 0)
+		}
+	}
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *SignSuite) TestSerialModelRestrictionsForwarded(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		remoteAddr string
+		code       int
+	}{
+		// The forwarded address of a client is not trusted, so it cannot match the allowed networks
+		{"198.51.100.7:41234", 403},
+		{"10.0.0.5:41234", 200},
+	}
+
+	datastore.Environ.Config.TrustedProxies = []string{"10.0.0.0/24"}
+	defer func() { datastore.Environ.Config.TrustedProxies = nil }()
+
+	for _, t := range tests {
+		datastore.Environ.DB = &restrictedModelMockDB{allowedCIDRs: "192.0.2.0/24"}
+
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/serial", bytes.NewReader(assert))
+		r.Header.Set("api-key", "ValidAPIKey")
+		r.Header.Set("X-Forwarded-For", "192.0.2.10")
+		r.RemoteAddr = t.remoteAddr
+		service.SigningRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s", t.remoteAddr))
+	}
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+// hashSerialMockDB mocks the database for an account that hashes the serial numbers
+type hashSerialMockDB struct {
+	datastore.MockDB
+	logged datastore.SigningLog
+}
+
+func (mdb *hashSerialMockDB) FindAccount(ctx context.Context, authorityID string) (datastore.Account, error) {
+	return datastore.Account{ID: 1, AuthorityID: authorityID, HashSerial: true}, nil
+}
+
+func (mdb *hashSerialMockDB) CreateSigningLog(ctx context.Context, signLog datastore.SigningLog) error {
+	mdb.logged = signLog
+	return nil
+}
+
+func (s *SignSuite) TestSerialHashSerial(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+	db := &hashSerialMockDB{}
+	datastore.Environ.DB = db
+
+	// The serial is not signed when the serial number cannot be hashed
+	w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	datastore.Environ.Config.SerialHashSalt = "SomeSaltValue"
+	w = sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 200)
+
+	// The serial assertion has the serial number, but the log has the hash
+	serial, err := asserts.Decode(w.Body.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Assert(serial.HeaderString("serial"), check.Equals, "A123456L")
+	c.Assert(db.logged.SerialNumber, check.Equals, datastore.HMACSerialHasher{Salt: []byte("SomeSaltValue")}.Hash("system", "A123456L"))
+
+	datastore.Environ.Config.SerialHashSalt = ""
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+// accountErrorMockDB mocks the database failing to find the account of the brand
+type accountErrorMockDB struct {
+	hashSerialMockDB
+}
+
+func (mdb *accountErrorMockDB) FindAccount(ctx context.Context, authorityID string) (datastore.Account, error) {
+	return datastore.Account{}, errors.New("MOCK error finding the account")
+}
+
+func (s *SignSuite) TestSerialHashSerialLookup(c *check.C) {
+	datastore.Environ.Config.SerialHashSalt = "SomeSaltValue"
+
+	// The log of the device signed before the hashing holds the serial number in clear
+	assert, err := generateSerialRequestAssertion("alder", "Aduplicate", "")
+	c.Assert(err, check.IsNil)
+	db := &hashSerialMockDB{}
+	datastore.Environ.DB = db
+	w := sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 200)
+	serial, err := asserts.Decode(w.Body.Bytes())
+	c.Assert(err, check.IsNil)
+	c.Assert(serial.Revision(), check.Equals, 4)
+	c.Assert(db.logged.SerialNumber, check.Equals, datastore.HMACSerialHasher{Salt: []byte("SomeSaltValue")}.Hash("system", "Aduplicate"))
+
+	// The serial number is not logged in clear when the account cannot be found
+	errDB := &accountErrorMockDB{}
+	datastore.Environ.DB = errDB
+	w = sendRequest("POST", "/v1/serial", bytes.NewReader(assert), "ValidAPIKey", c)
+	c.Assert(w.Code, check.Equals, 400)
+	c.Assert(errDB.logged.SerialNumber, check.Equals, "")
+
+	datastore.Environ.Config.SerialHashSalt = ""
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *SignSuite) TestSerialRequestMetadata(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+	db := &hashSerialMockDB{}
+	datastore.Environ.DB = db
+	datastore.Environ.Config.SerialHashSalt = "SomeSaltValue"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/serial", bytes.NewReader(assert))
+	r.Header.Set("api-key", "ValidAPIKey")
+	r.Header.Set("User-Agent", "factory-tool/1.0")
+	r.Header.Set("X-Request-ID", "factory-42")
+	r.RemoteAddr = "10.0.0.5:41234"
+	service.SigningRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("X-Request-ID"), check.Equals, "factory-42")
+
+	c.Assert(db.logged.SourceIP, check.Equals, "10.0.0.5")
+	c.Assert(db.logged.APIKeyID, check.Equals, datastore.APIKeyID("ValidAPIKey"))
+	c.Assert(db.logged.RequestID, check.Equals, "REQID")
+	c.Assert(db.logged.UserAgent, check.Equals, "factory-tool/1.0")
+
+	datastore.Environ.Config.SerialHashSalt = ""
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
+func (s *SignSuite) TestSerialTracing(c *check.C) {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	defer otel.SetTracerProvider(sdktrace.NewTracerProvider())
+
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/serial", bytes.NewReader(assert))
+	r.Header.Set("api-key", "ValidAPIKey")
+	r.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	service.SigningRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, 200)
+	c.Assert(w.Header().Get("X-Request-ID"), check.HasLen, 16)
+
+	// The steps of the signing are children of the request, in the trace of the client
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, span := range recorder.Ended() {
+		c.Assert(span.SpanContext().TraceID().String(), check.Equals, "4bf92f3577b34da6a3ce929d0e0e4736")
+		spans[span.Name()] = span
+	}
+	c.Assert(spans["POST /v1/serial"], check.NotNil)
+	c.Assert(spans["sign.SignSerial"].Parent().SpanID(), check.Equals, spans["POST /v1/serial"].SpanContext().SpanID())
+	for _, name := range []string{"datastore.ValidateDeviceNonce", "datastore.FindModel", "datastore.CheckAccountQuota", "keystore.SignAssertion", "datastore.CreateSigningLog"} {
+		c.Assert(spans[name], check.NotNil, check.Commentf(name))
+		c.Assert(spans[name].Parent().SpanID(), check.Equals, spans["sign.SignSerial"].SpanContext().SpanID())
+	}
+}
+
+func (s *SignSuite) TestClientFromRequest(c *check.C) {
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		sourceIP   string
+	}{
+		{"10.0.0.5:41234", "", "10.0.0.5"},
+		{"[2001:db8::1]:41234", "", "2001:db8::1"},
+		{"10.0.0.5", "", "10.0.0.5"},
+		{"10.0.0.5:41234", "192.168.1.20, 10.0.0.1", "192.168.1.20"},
+		{"203.0.113.7:41234", "192.168.1.20", "203.0.113.7"},
+	}
+
+	// The X-Forwarded-For header is used for the requests from the trusted proxies
+	datastore.Environ.Config.TrustedProxies = []string{"10.0.0.0/24"}
+	defer func() { datastore.Environ.Config.TrustedProxies = nil }()
+
+	for _, t := range tests {
+		r, _ := http.NewRequest("POST", "/v1/serial", nil)
+		r.RemoteAddr = t.remoteAddr
+		r.Header.Set("User-Agent", "factory-tool/1.0")
+		if len(t.forwarded) > 0 {
+			r.Header.Set("X-Forwarded-For", t.forwarded)
+		}
+
+		client := sign.ClientFromRequest(r)
+		c.Assert(client.SourceIP, check.Equals, t.sourceIP)
+		c.Assert(client.UserAgent, check.Equals, "factory-tool/1.0")
+	}
+}
+
 func (s *SignSuite) TestRequestIDHandler(c *check.C) {
 	tests := []SuiteTest{
 		{false, "POST", "/v1/request-id", nil, 200, response.JSONHeader, "InbuiltAPIKey"},
 		{false, "POST", "/v1/request-id", nil, 400, response.JSONHeader, "InvalidAPIKey"},
+		{false, "POST", "/v1/request-id", nil, 400, response.JSONHeader, "ReadOnlyAPIKey"},
 		{true, "POST", "/v1/request-id", nil, 400, response.JSONHeader, "InbuiltAPIKey"},
 	}
 
@@ -160,6 +574,112 @@ func (s *SignSuite) TestRequestIDHandler(c *check.C) {
 	}
 }
 
+func (s *SignSuite) TestRequestIDLockedOut(c *check.C) {
+	// The mock database has the source IP locked out
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/request-id", nil)
+	r.Header.Set("api-key", "InvalidAPIKey")
+	r.RemoteAddr = "192.0.2.66:41234"
+	service.SigningRouter().ServeHTTP(w, r)
+
+	c.Assert(w.Code, check.Equals, http.StatusTooManyRequests)
+	result := response.ErrorResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Code, check.Equals, response.ErrorLockedOut.Code)
+
+	// A valid API key is let through from the locked out source IP
+	w = httptest.NewRecorder()
+	r, _ = http.NewRequest("POST", "/v1/request-id", nil)
+	r.Header.Set("api-key", "InbuiltAPIKey")
+	r.RemoteAddr = "192.0.2.66:41234"
+	service.SigningRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+}
+
+func (s *SignSuite) TestRequestIDSourceNotAllowed(c *check.C) {
+	tests := []struct {
+		sourceIP string
+		code     int
+	}{
+		{"192.0.2.10", http.StatusOK},
+		{"198.51.100.1", http.StatusForbidden},
+	}
+
+	// The mock database has the API key restricted to 192.0.2.0/24
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/request-id", nil)
+		r.Header.Set("api-key", "RestrictedAPIKey")
+		r.RemoteAddr = t.sourceIP + ":41234"
+		service.SigningRouter().ServeHTTP(w, r)
+
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s", t.sourceIP))
+	}
+}
+
+// otherAccountCertMockDB mocks the database with the client certificates mapped to another account
+type otherAccountCertMockDB struct {
+	datastore.MockDB
+}
+
+func (mdb *otherAccountCertMockDB) FindClientCert(ctx context.Context, fingerprint string, sans []string) (datastore.ClientCert, error) {
+	return datastore.ClientCert{ID: 1, AccountID: 2, Name: "factory-1", AuthorityID: "vendor"}, nil
+}
+
+// sendCertRequest sends a request with the verified client certificate of the mutual TLS
+func sendCertRequest(url string, data []byte, apiKey, san string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", url, bytes.NewReader(data))
+	r.Header.Set("api-key", apiKey)
+	r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Raw: []byte(san), DNSNames: []string{san}}}}}
+
+	service.SigningRouter().ServeHTTP(w, r)
+	return w
+}
+
+func (s *SignSuite) TestSerialClientCert(c *check.C) {
+	assert, err := generateSerialRequestAssertion("alder", "A123456L", "")
+	c.Assert(err, check.IsNil)
+
+	tests := []struct {
+		url        string
+		data       []byte
+		apiKey     string
+		san        string
+		clientAuth string
+		code       int
+	}{
+		{"/v1/request-id", nil, "", "factory.example.com", "", 200},
+		{"/v1/request-id", nil, "", "unknown.example.com", "", 403},
+		{"/v1/request-id", nil, "", "factory.example.com", "required", 400},
+		{"/v1/request-id", nil, "InbuiltAPIKey", "factory.example.com", "required", 200},
+		{"/v1/serial", assert, "", "factory.example.com", "", 200},
+		{"/v1/serial", assert, "ValidAPIKey", "factory.example.com", "", 200},
+		{"/v1/serial", assert, "InvalidAPIKey", "factory.example.com", "", 400},
+		{"/v1/serial", assert, "ValidAPIKey", "unknown.example.com", "", 403},
+		{"/v1/serial", assert, "", "factory.example.com", "required", 400},
+		{"/v1/serial", assert, "ValidAPIKey", "factory.example.com", "required", 200},
+	}
+
+	for _, t := range tests {
+		datastore.Environ.Config.TLSClientAuth = t.clientAuth
+		w := sendCertRequest(t.url, t.data, t.apiKey, t.san)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s %s %s %s", t.url, t.apiKey, t.san, t.clientAuth))
+	}
+	datastore.Environ.Config.TLSClientAuth = ""
+
+	// The client certificate cannot sign the serials of another account
+	datastore.Environ.DB = &otherAccountCertMockDB{}
+	w := sendCertRequest("/v1/serial", assert, "", "factory.example.com")
+	c.Assert(w.Code, check.Equals, http.StatusForbidden)
+	result := response.ErrorResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Code, check.Equals, response.ErrorClientCertNotAllowed.Code)
+	datastore.Environ.DB = &datastore.MockDB{}
+}
+
 func generatePrivateKey() (asserts.PrivateKey, error) {
 	signingKey, err := ioutil.ReadFile("../../keystore/TestDeviceKey.asc")
 	if err != nil {
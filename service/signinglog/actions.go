@@ -20,6 +20,7 @@
 package signinglog
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -38,6 +39,7 @@ type ListResponse struct {
 	ErrorMessage string                 `json:"message"`
 	SigningLog   []datastore.SigningLog `json:"logs"`
 	Total        int                    `json:"total_count"`
+	NextToken    string                 `json:"next_token,omitempty"`
 }
 
 // FiltersResponse is the JSON response from the API Signing Log Filters method
@@ -49,17 +51,17 @@ type FiltersResponse struct {
 	Filters      datastore.SigningLogFilters `json:"filters"`
 }
 
-// listHandler is the API method to fetch the log records from signing
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+// listHandler is the API method to fetch a page of the filtered log records from signing
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, filter datastore.SigningLogFilter, page datastore.SigningLogPage) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedSigningLog(user)
+	logs, next, err := datastore.Environ.DB.ListAllowedSigningLog(ctx, user, filter, page)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -67,20 +69,20 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
-	formatListResponse(true, "", "", "", logs, w)
+	formatListResponse(true, "", "", "", logs, next, w)
 }
 
 // listForAccountHandler is the API method to fetch the log records from signing for an account
-func listForAccountHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, params *datastore.SigningLogParams) {
+func listForAccountHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string, params *datastore.SigningLogParams) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedSigningLogForAccount(user, authorityID, params)
+	logs, err := datastore.Environ.DB.ListAllowedSigningLogForAccount(ctx, user, authorityID, params)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -88,20 +90,41 @@ func listForAccountHandler(w http.ResponseWriter, user datastore.User, apiCall b
 
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
-	formatListResponse(true, "", "", "", logs, w)
+	formatListResponse(true, "", "", "", logs, "", w)
+}
+
+// listForFingerprintHandler is the API method to fetch the signing logs of a device-key fingerprint across the models,
+// to find the devices that requested a serial assertion with the same device-key
+func listForFingerprintHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, fingerprint string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	logs, err := datastore.Environ.DB.ListAllowedSigningLogForFingerprint(ctx, user, fingerprint)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(true, "", "", "", logs, "", w)
 }
 
 // listFiltersHandler is the API method to fetch the log filter values
-func listFiltersHandler(w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
+func listFiltersHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, authorityID string) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", "", w)
 		return
 	}
 
-	filters, err := datastore.Environ.DB.AllowedSigningLogFilterValues(user, authorityID)
+	filters, err := datastore.Environ.DB.AllowedSigningLogFilterValues(ctx, user, authorityID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -112,8 +135,8 @@ func listFiltersHandler(w http.ResponseWriter, user datastore.User, apiCall bool
 	formatFiltersResponse(true, "", "", "", filters, w)
 }
 
-func formatListResponse(success bool, errorCode, errorSubcode, message string, logs []datastore.SigningLog, w http.ResponseWriter) error {
-	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, SigningLog: logs}
+func formatListResponse(success bool, errorCode, errorSubcode, message string, logs []datastore.SigningLog, next string, w http.ResponseWriter) error {
+	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, SigningLog: logs, NextToken: next}
 
 	if len(logs) > 0 {
 		response.Total = logs[0].Total
@@ -121,7 +144,7 @@ func formatListResponse(success bool, errorCode, errorSubcode, message string, l
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the signing log response.")
+		log.Error("Error forming the signing log response.")
 		return err
 	}
 	return nil
@@ -132,7 +155,7 @@ func formatFiltersResponse(success bool, errorCode, errorSubcode, message string
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the signing log response.")
+		log.Error("Error forming the signing log response.")
 		return err
 	}
 	return nil
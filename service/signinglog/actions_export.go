@@ -0,0 +1,231 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// Export formats of the signing log
+const (
+	ExportCSV    = "csv"
+	ExportNDJSON = "ndjson"
+)
+
+// The export is flushed to the client after this number of records
+const exportFlushRecords = 1000
+
+var csvHeader = []string{"id", "make", "model", "serialnumber", "fingerprint", "created", "revision", "timestampsource"}
+
+// exportEncoder writes the records of a signing log export in one of the export formats
+type exportEncoder interface {
+	ContentType() string
+	Begin() error
+	Encode(l datastore.SigningLog) error
+	Flush() error
+}
+
+func newExportEncoder(format string, w io.Writer) exportEncoder {
+	switch format {
+	case ExportCSV:
+		return &csvEncoder{writer: csv.NewWriter(w)}
+	case ExportNDJSON:
+		return &ndjsonEncoder{encoder: json.NewEncoder(w)}
+	default:
+		return nil
+	}
+}
+
+// exportHandler streams the filtered log records from signing, writing each record as it is
+// read from the database so the export is never held in memory
+func exportHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, format string, filter datastore.SigningLogFilter) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	encoder := newExportEncoder(format, w)
+	if encoder == nil {
+		response.FormatStandardResponse(false, "error-signinglog-format", "", fmt.Sprintf("Invalid export format '%s'", format), w)
+		return
+	}
+
+	// The headers are only sent with the first record, so a failed query is still reported as JSON
+	started := false
+	begin := func() error {
+		started = true
+		filename := fmt.Sprintf("signinglog-%s.%s", time.Now().UTC().Format("20060102-150405"), format)
+		w.Header().Set("Content-Type", encoder.ContentType())
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+		w.WriteHeader(http.StatusOK)
+		return encoder.Begin()
+	}
+
+	count := 0
+	err = datastore.Environ.DB.StreamAllowedSigningLog(ctx, user, filter, func(l datastore.SigningLog) error {
+		if !started {
+			if err := begin(); err != nil {
+				return err
+			}
+		}
+		if err := encoder.Encode(l); err != nil {
+			return err
+		}
+
+		count++
+		if count%exportFlushRecords == 0 {
+			return flushExport(w, encoder)
+		}
+		return nil
+	})
+	if err != nil && !started {
+		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
+		return
+	}
+	if err != nil {
+		// The response has started, so an error can only end the stream
+		log.Errorf("Error writing the signing log export: %v", err)
+		return
+	}
+
+	if !started {
+		if err = begin(); err != nil {
+			log.Errorf("Error writing the signing log export: %v", err)
+			return
+		}
+	}
+	if err = flushExport(w, encoder); err != nil {
+		log.Errorf("Error writing the signing log export: %v", err)
+	}
+}
+
+func flushExport(w http.ResponseWriter, encoder exportEncoder) error {
+	if err := encoder.Flush(); err != nil {
+		return err
+	}
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+	return nil
+}
+
+// csvEncoder writes the export as CSV, with a header row
+type csvEncoder struct {
+	writer *csv.Writer
+}
+
+func (e *csvEncoder) ContentType() string {
+	return "text/csv; charset=UTF-8"
+}
+
+func (e *csvEncoder) Begin() error {
+	return e.writer.Write(csvHeader)
+}
+
+func (e *csvEncoder) Encode(l datastore.SigningLog) error {
+	return e.writer.Write(csvRecord(l))
+}
+
+func (e *csvEncoder) Flush() error {
+	e.writer.Flush()
+	return e.writer.Error()
+}
+
+// ndjsonRecord is a record of the NDJSON export, with the fields of the CSV export
+type ndjsonRecord struct {
+	ID              int       `json:"id"`
+	Make            string    `json:"make"`
+	Model           string    `json:"model"`
+	SerialNumber    string    `json:"serialnumber"`
+	Fingerprint     string    `json:"fingerprint"`
+	Created         time.Time `json:"created"`
+	Revision        int       `json:"revision"`
+	TimestampSource string    `json:"timestampsource"`
+}
+
+// ndjsonEncoder writes the export as newline-delimited JSON, one record per line
+type ndjsonEncoder struct {
+	encoder *json.Encoder
+}
+
+func (e *ndjsonEncoder) ContentType() string {
+	return "application/x-ndjson"
+}
+
+func (e *ndjsonEncoder) Begin() error {
+	return nil
+}
+
+func (e *ndjsonEncoder) Encode(l datastore.SigningLog) error {
+	return e.encoder.Encode(ndjsonRecord{
+		ID:              l.ID,
+		Make:            l.Make,
+		Model:           l.Model,
+		SerialNumber:    l.SerialNumber,
+		Fingerprint:     l.Fingerprint,
+		Created:         l.Created.UTC(),
+		Revision:        l.Revision,
+		TimestampSource: l.TimestampSource,
+	})
+}
+
+func (e *ndjsonEncoder) Flush() error {
+	return nil
+}
+
+func csvRecord(l datastore.SigningLog) []string {
+	return []string{
+		strconv.Itoa(l.ID),
+		csvSafe(l.Make),
+		csvSafe(l.Model),
+		csvSafe(l.SerialNumber),
+		csvSafe(l.Fingerprint),
+		l.Created.UTC().Format(time.RFC3339),
+		strconv.Itoa(l.Revision),
+		csvSafe(l.TimestampSource),
+	}
+}
+
+// csvSafe stops spreadsheets from evaluating a value from the device as a formula,
+// by quoting it with a leading apostrophe. A leading minus is kept, so the serial
+// numbers and the negative values are exported as they are
+func csvSafe(value string) string {
+	if len(value) > 0 && strings.ContainsAny(value[:1], "=+@\t\r") {
+		return "'" + value
+	}
+	return value
+}
@@ -0,0 +1,47 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import "testing"
+
+func TestCSVSafe(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"A123456L", "A123456L"},
+		{"", ""},
+		{"=HYPERLINK(\"http://example.com\")", "'=HYPERLINK(\"http://example.com\")"},
+		{"+1", "'+1"},
+		{"-1", "-1"},
+		{"-A123", "-A123"},
+		{"\t=1", "'\t=1"},
+		{"\r=1", "'\r=1"},
+		{"@SUM(A1)", "'@SUM(A1)"},
+		{"A,B\"C", "A,B\"C"},
+	}
+
+	for _, tt := range tests {
+		if got := csvSafe(tt.value); got != tt.want {
+			t.Errorf("csvSafe(%q) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
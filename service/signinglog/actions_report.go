@@ -0,0 +1,82 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// reportDefaultDays is the date range of the report when the start is not given
+const reportDefaultDays = 30
+
+// ReportResponse is the JSON response from the API Signing Report method
+type ReportResponse struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorSubcode string                  `json:"error_subcode"`
+	ErrorMessage string                  `json:"message"`
+	Report       datastore.SigningReport `json:"report"`
+}
+
+// reportHandler is the API method to fetch the number of devices signed in a date range,
+// grouped by model, signing-key and period
+func reportHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, filter datastore.SigningLogFilter, period string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	report, err := datastore.Environ.DB.AllowedSigningReport(ctx, user, reportRange(filter, time.Now().UTC()), period)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-report", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	response := ReportResponse{Success: true, Report: report}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the signing report response.")
+	}
+}
+
+// reportRange defaults the date range of the report to the last days, including today
+func reportRange(filter datastore.SigningLogFilter, now time.Time) datastore.SigningLogFilter {
+	if filter.To.IsZero() {
+		filter.To = time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, 1)
+	}
+	if filter.From.IsZero() {
+		filter.From = filter.To.AddDate(0, 0, -reportDefaultDays)
+	}
+	return filter
+}
@@ -0,0 +1,55 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog
+
+import (
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestReportRange(t *testing.T) {
+	now := time.Date(2020, time.June, 15, 10, 30, 0, 0, time.UTC)
+	from := time.Date(2020, time.May, 1, 0, 0, 0, 0, time.UTC)
+	to := time.Date(2020, time.May, 10, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name     string
+		filter   datastore.SigningLogFilter
+		wantFrom time.Time
+		wantTo   time.Time
+	}{
+		{"default", datastore.SigningLogFilter{}, time.Date(2020, time.May, 17, 0, 0, 0, 0, time.UTC), time.Date(2020, time.June, 16, 0, 0, 0, 0, time.UTC)},
+		{"from", datastore.SigningLogFilter{From: from}, from, time.Date(2020, time.June, 16, 0, 0, 0, 0, time.UTC)},
+		{"to", datastore.SigningLogFilter{To: to}, time.Date(2020, time.April, 10, 0, 0, 0, 0, time.UTC), to},
+		{"range", datastore.SigningLogFilter{From: from, To: to}, from, to},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := reportRange(tt.filter, now)
+			if !got.From.Equal(tt.wantFrom) || !got.To.Equal(tt.wantTo) {
+				t.Errorf("reportRange() = %v - %v, want %v - %v", got.From, got.To, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
@@ -21,6 +21,7 @@
 package signinglog
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -28,7 +29,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
-func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, signLog datastore.SigningLog) {
+func syncLogHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, signLog datastore.SigningLog) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -37,16 +38,27 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, si
 		return
 	}
 
-	// Create the the signing-log if it does not exist
-	exists, err := datastore.Environ.DB.CheckForMatching(signLog)
+	// The signing-log has been sync-ed when the device-key is logged for the serial number
+	duplicate, _, err := datastore.Environ.DB.CheckForDuplicate(ctx, &signLog)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-signinglog-match", "", err.Error(), w)
 		return
 	}
 
-	if !exists {
+	if !duplicate {
+		// The serial number and revision must not be logged for another device-key, e.g. by another factory
+		exists, err := datastore.Environ.DB.CheckForMatching(ctx, signLog)
+		if err != nil {
+			response.FormatStandardResponse(false, "error-signinglog-match", "", err.Error(), w)
+			return
+		}
+		if exists {
+			response.FormatStandardResponse(false, "error-signinglog-conflict", "", "The serial number and revision are logged for another device-key", w)
+			return
+		}
+
 		// The signing log has not been sync-ed, so create it (keep the same create timestamp)
-		err = datastore.Environ.DB.CreateSigningLogSync(signLog)
+		err = datastore.Environ.DB.CreateSigningLogSync(ctx, signLog)
 		if err != nil {
 			response.FormatStandardResponse(false, "error-signinglog-create", "", err.Error(), w)
 			return
@@ -21,10 +21,12 @@ package signinglog
 
 import (
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/request"
@@ -42,8 +44,47 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := GetSigningLogFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-filter", "", err.Error(), w)
+		return
+	}
+
+	page, err := GetSigningLogPage(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-page", "", err.Error(), w)
+		return
+	}
+
 	// Call the API with the user
-	listHandler(w, user, true)
+	listHandler(r.Context(), w, user, true, filter, page)
+}
+
+// APISearch is the API method to search the log records from signing by serial number or fingerprint
+func APISearch(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	filter, err := GetSigningLogSearch(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-search", "", err.Error(), w)
+		return
+	}
+
+	page, err := GetSigningLogPage(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-page", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	listHandler(r.Context(), w, user, true, filter, page)
 }
 
 // APISyncLog is the API method to sync a factory log to the cloud
@@ -69,7 +110,7 @@ func APISyncLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncLogHandler(w, user, true, request)
+	syncLogHandler(r.Context(), w, user, true, request)
 }
 
 // GetSigningLogParams parse and set defaults for the search parameters from the request
@@ -96,3 +137,75 @@ func GetSigningLogParams(r *http.Request) *datastore.SigningLogParams {
 
 	return params
 }
+
+// GetSigningLogPage parses the keyset pagination parameters from the request
+func GetSigningLogPage(r *http.Request) (datastore.SigningLogPage, error) {
+	query := r.URL.Query()
+	page := datastore.SigningLogPage{Token: query.Get("token")}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return page, fmt.Errorf("invalid page size: %s", limit)
+		}
+		page.Limit = l
+	}
+
+	return page, nil
+}
+
+// GetSigningLogFilter parses the signing log filters from the request.
+// The dates are in RFC3339 format or a date (YYYY-MM-DD), and the 'to' date is inclusive
+func GetSigningLogFilter(r *http.Request) (datastore.SigningLogFilter, error) {
+	query := r.URL.Query()
+	filter := datastore.SigningLogFilter{
+		Model:       query.Get("model"),
+		Serial:      query.Get("serial"),
+		Fingerprint: query.Get("fingerprint"),
+		KeyID:       query.Get("key-id"),
+	}
+
+	var err error
+	if from := query.Get("from"); from != "" {
+		if filter.From, _, err = parseFilterTime(from); err != nil {
+			return filter, fmt.Errorf("invalid 'from' date: %s", from)
+		}
+	}
+
+	if to := query.Get("to"); to != "" {
+		var isDate bool
+		if filter.To, isDate, err = parseFilterTime(to); err != nil {
+			return filter, fmt.Errorf("invalid 'to' date: %s", to)
+		}
+		// Include the logs for the whole day
+		if isDate {
+			filter.To = filter.To.AddDate(0, 0, 1)
+		}
+	}
+
+	return filter, nil
+}
+
+// GetSigningLogSearch parses the search text and the signing log filters from the request.
+// The text is matched against the serial numbers and the device-key fingerprints
+func GetSigningLogSearch(r *http.Request) (datastore.SigningLogFilter, error) {
+	filter, err := GetSigningLogFilter(r)
+	if err != nil {
+		return filter, err
+	}
+
+	filter.Search = strings.TrimSpace(r.URL.Query().Get("q"))
+	if len(filter.Search) < datastore.SigningLogSearchMinLength {
+		return filter, fmt.Errorf("the search must be at least %d characters", datastore.SigningLogSearchMinLength)
+	}
+	return filter, nil
+}
+
+// parseFilterTime parses a timestamp or a date, returning true when the value is a date
+func parseFilterTime(value string) (time.Time, bool, error) {
+	if t, err := time.Parse("2006-01-02", value); err == nil {
+		return t, true, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	return t, false, err
+}
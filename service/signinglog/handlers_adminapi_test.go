@@ -37,16 +37,25 @@ import (
 func (s *SigningLogSuite) TestAPISigningLogHandler(c *check.C) {
 	log1 := datastore.SigningLog{ID: 1, Make: "system", Model: "alder", SerialNumber: "abcd1234", Fingerprint: "aaaabbbbccccdddd", Revision: 1, Created: time.Now()}
 	l1, _ := json.Marshal(log1)
+	log2 := datastore.SigningLog{ID: 2, Make: "system", Model: "alder", SerialNumber: "Aduplicate", Fingerprint: "aaaabbbbccccdddd", Revision: 1, Created: time.Now()}
+	l2, _ := json.Marshal(log2)
+	log3 := datastore.SigningLog{ID: 3, Make: "system", Model: "alder", SerialNumber: "Aconflict", Fingerprint: "aaaabbbbccccdddd", Revision: 1, Created: time.Now()}
+	l3, _ := json.Marshal(log3)
 
 	tests := []SigningLogTest{
 		{"GET", "/api/signinglog", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"GET", "/api/signinglog", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 4},
 		{"GET", "/api/signinglog", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"GET", "/api/signinglog", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
+		{"GET", "/api/signinglog/search?q=A10", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"GET", "/api/signinglog/search?q=a3", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"GET", "/api/signinglog/search?q=A10", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"POST", "/api/signinglog", l1, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"POST", "/api/signinglog", l1, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{"POST", "/api/signinglog", l1, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"POST", "/api/signinglog", l1, 400, "application/json; charset=UTF-8", 0, true, false, 0},
+		{"POST", "/api/signinglog", l2, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"POST", "/api/signinglog", l3, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 	}
 
 	for _, t := range tests {
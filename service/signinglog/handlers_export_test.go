@@ -0,0 +1,121 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"strings"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	check "gopkg.in/check.v1"
+)
+
+func (s *SigningLogSuite) TestSigningLogExport(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog/export", nil, 200, "text/csv; charset=UTF-8", 0, false, true, 10},
+		{"GET", "/v1/signinglog/export?format=csv", nil, 200, "text/csv; charset=UTF-8", 0, false, true, 10},
+		{"GET", "/v1/signinglog/export?format=csv&serial=1", nil, 200, "text/csv; charset=UTF-8", 0, false, true, 2},
+		{"GET", "/v1/signinglog/export?format=csv", nil, 200, "text/csv; charset=UTF-8", datastore.SubstoreAdmin, true, true, 4},
+		{"GET", "/v1/signinglog/export?format=csv", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"GET", "/v1/signinglog/export?format=xls", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/export?to=invalid", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		if t.Success {
+			c.Assert(strings.HasPrefix(w.Header().Get("Content-Disposition"), "attachment; filename=\"signinglog-"), check.Equals, true)
+
+			records, err := csv.NewReader(w.Body).ReadAll()
+			c.Assert(err, check.IsNil)
+			c.Assert(records[0][3], check.Equals, "serialnumber")
+			c.Assert(len(records), check.Equals, t.List+1, check.Commentf("URL %s", t.URL))
+		} else {
+			result, err := response.ParseStandardResponse(w)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.Success, check.Equals, false)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogExportError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("GET", "/v1/signinglog/export", nil, 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+	c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+	result, err := response.ParseStandardResponse(w)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-fetch-signinglog")
+}
+
+func (s *SigningLogSuite) TestSigningLogExportNDJSON(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog/export?format=ndjson", nil, 200, "application/x-ndjson", 0, false, true, 10},
+		{"GET", "/v1/signinglog/export?format=ndjson&serial=1", nil, 200, "application/x-ndjson", 0, false, true, 2},
+		{"GET", "/v1/signinglog/export?format=ndjson&serial=invalid", nil, 200, "application/x-ndjson", 0, false, true, 0},
+		{"GET", "/v1/signinglog/export?format=ndjson", nil, 200, "application/x-ndjson", datastore.SubstoreAdmin, true, true, 4},
+		{"GET", "/v1/signinglog/export?format=ndjson", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		if t.Success {
+			c.Assert(strings.HasSuffix(w.Header().Get("Content-Disposition"), ".ndjson\""), check.Equals, true)
+
+			count := 0
+			decoder := json.NewDecoder(w.Body)
+			for decoder.More() {
+				record := datastore.SigningLog{}
+				c.Assert(decoder.Decode(&record), check.IsNil)
+				c.Assert(record.SerialNumber, check.Not(check.Equals), "")
+				count++
+			}
+			c.Assert(count, check.Equals, t.List, check.Commentf("URL %s", t.URL))
+		} else {
+			result, err := response.ParseStandardResponse(w)
+			c.Assert(err, check.IsNil)
+			c.Assert(result.Success, check.Equals, false)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
@@ -0,0 +1,70 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	check "gopkg.in/check.v1"
+)
+
+func (s *SigningLogSuite) TestSigningLogPurges(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog/purges", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/purges", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 1},
+		{"GET", "/v1/signinglog/purges", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := signinglog.PurgesResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Purges), check.Equals, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogPurgesError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	datastore.Environ.Config.EnableUserAuth = true
+
+	w := sendAdminRequest("GET", "/v1/signinglog/purges", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, 400)
+	datastore.Environ.Config.EnableUserAuth = false
+
+	result := signinglog.PurgesResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-fetch-purges")
+}
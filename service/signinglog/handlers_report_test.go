@@ -0,0 +1,79 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package signinglog_test
+
+import (
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/signinglog"
+	check "gopkg.in/check.v1"
+)
+
+func (s *SigningLogSuite) TestSigningReport(c *check.C) {
+	tests := []struct {
+		url         string
+		code        int
+		permissions int
+		enableAuth  bool
+		success     bool
+		total       int
+	}{
+		{"/v1/report/signings", 200, 0, false, true, 10},
+		{"/v1/report/signings?period=week&from=2020-06-01", 200, 0, false, true, 10},
+		{"/v1/report/signings", 200, datastore.Admin, true, true, 4},
+		{"/v1/report/signings", 200, datastore.SubstoreAdmin, true, true, 4},
+		{"/v1/report/signings", 400, datastore.Standard, true, false, 0},
+		{"/v1/report/signings?period=month", 400, 0, false, false, 0},
+		{"/v1/report/signings?from=invalid", 400, 0, false, false, 0},
+		{"/v1/report/signings?from=2020-06-02&to=2020-05-01", 400, 0, false, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.enableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest("GET", t.url, nil, t.permissions, c)
+		c.Assert(w.Code, check.Equals, t.code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := signinglog.ReportResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(result.Report.Total, check.Equals, t.total)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SigningLogSuite) TestSigningReportError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("GET", "/v1/report/signings", nil, 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+
+	result := signinglog.ReportResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.ErrorCode, check.Equals, "error-fetch-report")
+}
@@ -22,12 +22,14 @@ package signinglog
 import (
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
 
-// List is the API method to fetch the log records from signing
+// List is the API method to fetch a page of the log records from signing.
+// The next page is requested with the token from the response
 func List(w http.ResponseWriter, r *http.Request) {
 	authUser, err := auth.GetUserFromJWT(w, r)
 	if err != nil {
@@ -35,7 +37,99 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false)
+	filter, err := GetSigningLogFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-filter", "", err.Error(), w)
+		return
+	}
+
+	page, err := GetSigningLogPage(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-page", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false, filter, page)
+}
+
+// Search is the API method to fetch a page of the log records from signing that match the
+// search text, in the serial number or the device-key fingerprint
+func Search(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	filter, err := GetSigningLogSearch(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-search", "", err.Error(), w)
+		return
+	}
+
+	page, err := GetSigningLogPage(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-page", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false, filter, page)
+}
+
+// Export is the API method to download the filtered log records from signing
+func Export(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	filter, err := GetSigningLogFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-filter", "", err.Error(), w)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = ExportCSV
+	}
+
+	exportHandler(r.Context(), w, authUser, false, format, filter)
+}
+
+// Report is the API method to fetch the number of devices signed by model, signing-key and
+// day or week, for a date range. The range defaults to the last 30 days
+func Report(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	filter, err := GetSigningLogFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-signinglog-filter", "", err.Error(), w)
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if len(period) == 0 {
+		period = datastore.ReportPeriodDay
+	}
+
+	reportHandler(r.Context(), w, authUser, false, filter, period)
+}
+
+// Purges is the API method to fetch the audit records of the signing log purges
+func Purges(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	purgesHandler(r.Context(), w, authUser, false)
 }
 
 // ListForAccount is the API method to fetch the log records from signing for an account
@@ -49,7 +143,20 @@ func ListForAccount(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	params := GetSigningLogParams(r)
 
-	listForAccountHandler(w, authUser, false, vars["authorityID"], params)
+	listForAccountHandler(r.Context(), w, authUser, false, vars["authorityID"], params)
+}
+
+// ListForFingerprint is the API method to fetch the log records from signing for a device-key fingerprint
+func ListForFingerprint(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	listForFingerprintHandler(r.Context(), w, authUser, false, vars["fingerprint"])
 }
 
 // ListFilters is the API method to fetch the log filter values
@@ -62,5 +169,5 @@ func ListFilters(w http.ResponseWriter, r *http.Request) {
 
 	vars := mux.Vars(r)
 
-	listFiltersHandler(w, authUser, false, vars["authorityID"])
+	listFiltersHandler(r.Context(), w, authUser, false, vars["authorityID"])
 }
@@ -27,6 +27,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -69,12 +70,19 @@ func (s *SigningLogSuite) TestSigningLogHandler(c *check.C) {
 	tests := []SigningLogTest{
 		{"GET", "/v1/signinglog", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
 		{"GET", "/v1/signinglog", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 4},
+		{"GET", "/v1/signinglog", nil, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 4},
 		{"GET", "/v1/signinglog", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"GET", "/v1/signinglog", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
 		{"GET", "/v1/signinglog/account/system", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 4},
+		{"GET", "/v1/signinglog/account/system", nil, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 4},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
+		{"GET", "/v1/signinglog/fingerprint/a3", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/signinglog/fingerprint/a3", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{"GET", "/v1/signinglog/fingerprint/a3", nil, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 1},
+		{"GET", "/v1/signinglog/fingerprint/a9", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"GET", "/v1/signinglog/fingerprint/a3", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 	}
 
 	for _, t := range tests {
@@ -95,6 +103,114 @@ func (s *SigningLogSuite) TestSigningLogHandler(c *check.C) {
 	}
 }
 
+func (s *SigningLogSuite) TestSigningLogPagination(c *check.C) {
+	pages := []int{}
+	url := "/v1/signinglog?limit=3"
+
+	for {
+		w := sendAdminRequest("GET", url, nil, 0, c)
+		c.Assert(w.Code, check.Equals, 200)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, true)
+		pages = append(pages, len(result.SigningLog))
+
+		if result.NextToken == "" {
+			break
+		}
+		url = "/v1/signinglog?limit=3&token=" + result.NextToken
+	}
+
+	c.Assert(pages, check.DeepEquals, []int{3, 3, 3, 1})
+}
+
+func (s *SigningLogSuite) TestSigningLogPaginationInvalid(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog?limit=abc", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog?limit=-1", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog?token=invalid!", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog?limit=5000", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.SigningLog), check.Equals, t.List)
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogFilters(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog?serial=1", nil, 200, "application/json; charset=UTF-8", 0, false, true, 2},
+		{"GET", "/v1/signinglog?fingerprint=a3", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/signinglog?model=Router%203400", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
+		{"GET", "/v1/signinglog?model=invalid", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"GET", "/v1/signinglog?from=2020-01-01&to=2020-01-31", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
+		{"GET", "/v1/signinglog?from=2020-01-01T10:00:00Z", nil, 200, "application/json; charset=UTF-8", 0, false, true, 10},
+		{"GET", "/v1/signinglog?from=01/01/2020", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog?to=invalid", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.SigningLog), check.Equals, t.List, check.Commentf("URL %s", t.URL))
+	}
+}
+
+func (s *SigningLogSuite) TestSigningLogSearch(c *check.C) {
+	tests := []SigningLogTest{
+		{"GET", "/v1/signinglog/search?q=a10", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/signinglog/search?q=A10", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/signinglog/search?q=a10&model=invalid", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"GET", "/v1/signinglog/search?q=xyz", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"GET", "/v1/signinglog/search?q=a1", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/search?q=%20a1%20", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/search", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/search?q=a10&limit=abc", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/v1/signinglog/search?q=a10", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"GET", "/v1/signinglog/search?q=a10", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("URL %s", t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.SigningLog), check.Equals, t.List, check.Commentf("URL %s", t.URL))
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SigningLogSuite) TestGetSigningLogFilter(c *check.C) {
+	r, _ := http.NewRequest("GET", "/v1/signinglog?from=2020-01-01&to=2020-01-31&key-id=key1", nil)
+
+	filter, err := signinglog.GetSigningLogFilter(r)
+	c.Assert(err, check.IsNil)
+	c.Assert(filter.From, check.Equals, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(filter.To, check.Equals, time.Date(2020, 2, 1, 0, 0, 0, 0, time.UTC))
+	c.Assert(filter.KeyID, check.Equals, "key1")
+}
+
 func (s *SigningLogSuite) TestSigningLogErrorHandler(c *check.C) {
 	datastore.Environ.DB = &datastore.ErrorMockDB{}
 	tests := []SigningLogTest{
@@ -102,6 +218,7 @@ func (s *SigningLogSuite) TestSigningLogErrorHandler(c *check.C) {
 		{"GET", "/v1/signinglog", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"GET", "/v1/signinglog/account/system", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"GET", "/v1/signinglog/fingerprint/a3", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 	}
 
 	for _, t := range tests {
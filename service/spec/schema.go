@@ -0,0 +1,165 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package spec
+
+import (
+	"encoding/json"
+	"path"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// jsonMediaType is the media type of the JSON bodies
+const jsonMediaType = "application/json"
+
+// Schema is the JSON schema of a type
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+// schemaBuilder builds the schemas of the Go types from their JSON encoding. The named structs
+// are components of the document, referenced by the package and the name of the type
+type schemaBuilder struct {
+	components map[string]*Schema
+}
+
+func newSchemaBuilder(components map[string]*Schema) *schemaBuilder {
+	return &schemaBuilder{components: components}
+}
+
+// content returns the content of a body, from a value of its type or from its media type
+func (b *schemaBuilder) content(body interface{}) map[string]MediaType {
+	if body == nil {
+		return nil
+	}
+	if text, ok := body.(Text); ok {
+		return map[string]MediaType{string(text): {Schema: &Schema{Type: "string"}}}
+	}
+	return map[string]MediaType{jsonMediaType: {Schema: b.schema(reflect.TypeOf(body))}}
+}
+
+// schema returns the schema of a type
+func (b *schemaBuilder) schema(t reflect.Type) *Schema {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return &Schema{Type: "string", Format: "date-time"}
+	case t.Implements(marshalerType) || reflect.PtrTo(t).Implements(marshalerType):
+		// The encoding is custom, so the schema is not known
+		return &Schema{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return &Schema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &Schema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &Schema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &Schema{Type: "number"}
+	case reflect.String:
+		return &Schema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &Schema{Type: "string", Format: "byte"}
+		}
+		return &Schema{Type: "array", Items: b.schema(t.Elem())}
+	case reflect.Map:
+		return &Schema{Type: "object", AdditionalProperties: b.schema(t.Elem())}
+	case reflect.Struct:
+		return b.structSchema(t)
+	default:
+		return &Schema{}
+	}
+}
+
+// structSchema returns the reference to the component of a named struct, or the schema of an
+// anonymous struct. The component is added before its fields, so recursive types terminate
+func (b *schemaBuilder) structSchema(t reflect.Type) *Schema {
+	if len(t.Name()) == 0 {
+		s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+		b.addFields(s, t)
+		return s
+	}
+
+	name := path.Base(t.PkgPath()) + "." + t.Name()
+	ref := &Schema{Ref: "#/components/schemas/" + name}
+	if _, ok := b.components[name]; ok {
+		return ref
+	}
+
+	s := &Schema{Type: "object", Properties: map[string]*Schema{}}
+	b.components[name] = s
+	b.addFields(s, t)
+	return ref
+}
+
+// addFields adds the properties of the fields of a struct, following the rules of the JSON
+// encoding: the embedded structs are flattened, and the unexported fields are skipped
+func (b *schemaBuilder) addFields(s *Schema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				b.addFields(s, ft)
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+
+		if len(name) == 0 {
+			name = f.Name
+		}
+		if strings.Contains(tag, ",string") {
+			s.Properties[name] = &Schema{Type: "string"}
+			continue
+		}
+		s.Properties[name] = b.schema(f.Type)
+	}
+}
@@ -0,0 +1,299 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package spec
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+// openAPIVersion is the version of the OpenAPI specification of the documents
+const openAPIVersion = "3.0.3"
+
+// Text is the media type of a body that is not JSON, e.g. the assertions
+type Text string
+
+// Assertions is the body of a stream of signed assertions
+const Assertions Text = "application/x.ubuntu.assertion"
+
+// Operation documents a route of the API. The request and the response are values of the
+// types of the JSON bodies, or the media type of a text body
+type Operation struct {
+	ID       string
+	Summary  string
+	Tag      string
+	Public   bool
+	Query    []string
+	Request  interface{}
+	Response interface{}
+}
+
+// Scheme is the authentication and the tag of the routes under a path prefix. The security
+// is a list of alternatives, each holding the names of the security schemes that are required
+type Scheme struct {
+	Prefix   string
+	Tag      string
+	Security [][]string
+}
+
+// API is the description of the routes of a router. The routes under the prefixes of the
+// schemes must be documented by an operation, keyed by the method and the path template of
+// the route, e.g. "GET /v1/models/{id:[0-9]+}". The other routes are not part of the API
+type API struct {
+	Title           string
+	Version         string
+	Schemes         []Scheme
+	SecuritySchemes map[string]SecurityScheme
+	Operations      map[string]Operation
+	Default         interface{}
+	Error           interface{}
+}
+
+// Document is the OpenAPI document of an API
+type Document struct {
+	OpenAPI    string              `json:"openapi"`
+	Info       Info                `json:"info"`
+	Paths      map[string]PathItem `json:"paths"`
+	Components Components          `json:"components"`
+}
+
+// Info is the title and the version of the API
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem holds the operations of a path, keyed by the method in lower case
+type PathItem map[string]*OperationObject
+
+// OperationObject is an operation of a path
+type OperationObject struct {
+	OperationID string                `json:"operationId"`
+	Summary     string                `json:"summary"`
+	Tags        []string              `json:"tags"`
+	Parameters  []Parameter           `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]Response   `json:"responses"`
+	Security    []map[string][]string `json:"security"`
+}
+
+// Parameter is a path or query parameter of an operation
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required"`
+	Schema   *Schema `json:"schema"`
+}
+
+// RequestBody is the body of the request of an operation
+type RequestBody struct {
+	Required bool                 `json:"required"`
+	Content  map[string]MediaType `json:"content"`
+}
+
+// Response is a response of an operation
+type Response struct {
+	Description string               `json:"description"`
+	Content     map[string]MediaType `json:"content,omitempty"`
+}
+
+// MediaType is the schema of a body
+type MediaType struct {
+	Schema *Schema `json:"schema"`
+}
+
+// Components holds the schemas of the types and the security schemes that are referenced
+// by the operations
+type Components struct {
+	Schemas         map[string]*Schema        `json:"schemas"`
+	SecuritySchemes map[string]SecurityScheme `json:"securitySchemes,omitempty"`
+}
+
+// SecurityScheme is a method of authentication of the API
+type SecurityScheme struct {
+	Type         string `json:"type"`
+	Description  string `json:"description,omitempty"`
+	Scheme       string `json:"scheme,omitempty"`
+	BearerFormat string `json:"bearerFormat,omitempty"`
+	In           string `json:"in,omitempty"`
+	Name         string `json:"name,omitempty"`
+}
+
+// Generate builds the OpenAPI document of the registered routes of a router. An error is
+// returned when a route of the API is not documented, so the document cannot drift from
+// the routes
+func Generate(router *mux.Router, api API) (Document, error) {
+	doc := Document{
+		OpenAPI:    openAPIVersion,
+		Info:       Info{Title: api.Title, Version: api.Version},
+		Paths:      map[string]PathItem{},
+		Components: Components{Schemas: map[string]*Schema{}, SecuritySchemes: api.SecuritySchemes},
+	}
+	schemas := newSchemaBuilder(doc.Components.Schemas)
+	ids := map[string]string{}
+
+	err := router.Walk(func(route *mux.Route, router *mux.Router, ancestors []*mux.Route) error {
+		template, err := route.GetPathTemplate()
+		if err != nil {
+			return nil
+		}
+		scheme, ok := api.scheme(template)
+		if !ok {
+			return nil
+		}
+
+		methods, err := route.GetMethods()
+		if err != nil || len(methods) == 0 {
+			return fmt.Errorf("the route %s has no methods", template)
+		}
+
+		path, params := pathParameters(template)
+		for _, method := range methods {
+			key := method + " " + template
+			op, ok := api.Operations[key]
+			if !ok {
+				return fmt.Errorf("the route %s is not documented", key)
+			}
+			if len(op.ID) == 0 {
+				return fmt.Errorf("the route %s has no operation ID", key)
+			}
+			if other, ok := ids[op.ID]; ok {
+				return fmt.Errorf("the routes %s and %s have the same operation ID '%s'", other, key, op.ID)
+			}
+			ids[op.ID] = key
+
+			if doc.Paths[path] == nil {
+				doc.Paths[path] = PathItem{}
+			}
+			doc.Paths[path][strings.ToLower(method)] = api.operation(op, scheme, params, schemas)
+		}
+		return nil
+	})
+	return doc, err
+}
+
+// Handler returns the OpenAPI document of the routes of a router. The document is generated
+// for each request, as the routes are registered after the handler
+func Handler(router *mux.Router, api API) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+		doc, err := Generate(router, api)
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			json.NewEncoder(w).Encode(map[string]string{"message": err.Error()})
+			return
+		}
+
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.Encode(doc)
+	})
+}
+
+// scheme returns the scheme of a path template, using the longest prefix that matches
+func (api API) scheme(template string) (Scheme, bool) {
+	var found Scheme
+	ok := false
+	for _, s := range api.Schemes {
+		if strings.HasPrefix(template, s.Prefix) && len(s.Prefix) >= len(found.Prefix) {
+			found, ok = s, true
+		}
+	}
+	return found, ok
+}
+
+// operation builds the operation object of a documented route
+func (api API) operation(op Operation, scheme Scheme, params []Parameter, schemas *schemaBuilder) *OperationObject {
+	o := &OperationObject{
+		OperationID: op.ID,
+		Summary:     op.Summary,
+		Tags:        []string{scheme.Tag},
+		Parameters:  params,
+		Responses:   map[string]Response{},
+		Security:    []map[string][]string{},
+	}
+	if len(op.Tag) > 0 {
+		o.Tags = []string{op.Tag}
+	}
+
+	if !op.Public {
+		for _, names := range scheme.Security {
+			requirement := map[string][]string{}
+			for _, n := range names {
+				requirement[n] = []string{}
+			}
+			o.Security = append(o.Security, requirement)
+		}
+	}
+
+	for _, q := range op.Query {
+		o.Parameters = append(o.Parameters, Parameter{Name: q, In: "query", Schema: &Schema{Type: "string"}})
+	}
+
+	if op.Request != nil {
+		o.RequestBody = &RequestBody{Required: true, Content: schemas.content(op.Request)}
+	}
+
+	resp := op.Response
+	if resp == nil {
+		resp = api.Default
+	}
+	o.Responses["200"] = Response{Description: "Success", Content: schemas.content(resp)}
+	if api.Error != nil {
+		o.Responses["default"] = Response{Description: "Error", Content: schemas.content(api.Error)}
+	}
+	return o
+}
+
+// pathParameters converts a path template of a route to an OpenAPI path, and returns the
+// parameters of the path. The variables that match digits are integers
+func pathParameters(template string) (string, []Parameter) {
+	params := []Parameter{}
+	parts := strings.Split(template, "/")
+
+	for i, p := range parts {
+		if !strings.HasPrefix(p, "{") || !strings.HasSuffix(p, "}") {
+			continue
+		}
+
+		name := p[1 : len(p)-1]
+		schema := &Schema{Type: "string"}
+		if n := strings.Index(name, ":"); n >= 0 {
+			pattern := name[n+1:]
+			name = name[:n]
+			if pattern == "[0-9]+" {
+				schema = &Schema{Type: "integer"}
+			} else {
+				schema.Pattern = "^" + pattern + "$"
+			}
+		}
+
+		parts[i] = "{" + name + "}"
+		params = append(params, Parameter{Name: name, In: "path", Required: true, Schema: schema})
+	}
+
+	return strings.Join(parts, "/"), params
+}
@@ -0,0 +1,192 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package spec_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/service/spec"
+	"github.com/gorilla/mux"
+	check "gopkg.in/check.v1"
+)
+
+func TestSpecSuite(t *testing.T) { check.TestingT(t) }
+
+type SpecSuite struct{}
+
+var _ = check.Suite(&SpecSuite{})
+
+type widget struct {
+	ID       int               `json:"id"`
+	Name     string            `json:"name,omitempty"`
+	Secret   string            `json:"-"`
+	Count    int64             `json:"count,string"`
+	Created  time.Time         `json:"created"`
+	Labels   map[string]string `json:"labels"`
+	Children []widget          `json:"children"`
+	internal string
+	embedded
+}
+
+type embedded struct {
+	Owner string `json:"owner"`
+}
+
+type widgetResponse struct {
+	Success bool   `json:"success"`
+	Widget  widget `json:"widget"`
+}
+
+func handler(w http.ResponseWriter, r *http.Request) {}
+
+func (s *SpecSuite) api(operations map[string]spec.Operation) spec.API {
+	return spec.API{
+		Title:   "Widgets",
+		Version: "1.0",
+		Schemes: []spec.Scheme{
+			{Prefix: "/v1/", Tag: "widgets", Security: [][]string{{"apiKey"}}},
+			{Prefix: "/v1/admin/", Tag: "admin", Security: [][]string{{"user", "apiKey"}, {"bearer"}}},
+		},
+		Operations: operations,
+		Error:      widgetResponse{},
+	}
+}
+
+func (s *SpecSuite) router() *mux.Router {
+	router := mux.NewRouter()
+	router.HandleFunc("/v1/widgets/{id:[0-9]+}", handler).Methods("GET", "PUT")
+	router.HandleFunc("/v1/widgets/{name}/sign", handler).Methods("POST")
+	router.HandleFunc("/v1/admin/widgets", handler).Methods("GET")
+	router.HandleFunc("/healthz", handler).Methods("GET")
+	return router
+}
+
+func (s *SpecSuite) operations() map[string]spec.Operation {
+	return map[string]spec.Operation{
+		"GET /v1/widgets/{id:[0-9]+}":  {ID: "widgetGet", Summary: "Get a widget", Response: widgetResponse{}},
+		"PUT /v1/widgets/{id:[0-9]+}":  {ID: "widgetUpdate", Summary: "Update a widget", Request: widget{}},
+		"POST /v1/widgets/{name}/sign": {ID: "widgetSign", Summary: "Sign a widget", Public: true, Request: spec.Assertions, Response: spec.Assertions},
+		"GET /v1/admin/widgets":        {ID: "widgetList", Summary: "List the widgets", Query: []string{"offset"}, Response: []widget{}},
+	}
+}
+
+func (s *SpecSuite) TestGenerate(c *check.C) {
+	doc, err := spec.Generate(s.router(), s.api(s.operations()))
+	c.Assert(err, check.IsNil)
+
+	c.Assert(doc.Info.Title, check.Equals, "Widgets")
+	c.Assert(doc.Paths, check.HasLen, 3)
+
+	// The routes outside the prefixes are not part of the API
+	_, ok := doc.Paths["/healthz"]
+	c.Assert(ok, check.Equals, false)
+
+	get := doc.Paths["/v1/widgets/{id}"]["get"]
+	c.Assert(get, check.NotNil)
+	c.Assert(get.OperationID, check.Equals, "widgetGet")
+	c.Assert(get.Tags, check.DeepEquals, []string{"widgets"})
+	c.Assert(get.Parameters, check.HasLen, 1)
+	c.Assert(get.Parameters[0].Name, check.Equals, "id")
+	c.Assert(get.Parameters[0].In, check.Equals, "path")
+	c.Assert(get.Parameters[0].Schema.Type, check.Equals, "integer")
+	c.Assert(get.Security, check.DeepEquals, []map[string][]string{{"apiKey": {}}})
+	c.Assert(get.Responses["200"].Content["application/json"].Schema.Ref, check.Equals, "#/components/schemas/spec_test.widgetResponse")
+	c.Assert(get.Responses["default"].Content["application/json"].Schema.Ref, check.Equals, "#/components/schemas/spec_test.widgetResponse")
+
+	put := doc.Paths["/v1/widgets/{id}"]["put"]
+	c.Assert(put, check.NotNil)
+	c.Assert(put.RequestBody.Content["application/json"].Schema.Ref, check.Equals, "#/components/schemas/spec_test.widget")
+
+	sign := doc.Paths["/v1/widgets/{name}/sign"]["post"]
+	c.Assert(sign, check.NotNil)
+	c.Assert(sign.Parameters[0].Schema.Type, check.Equals, "string")
+	c.Assert(sign.Security, check.HasLen, 0)
+	c.Assert(sign.RequestBody.Content[string(spec.Assertions)].Schema.Type, check.Equals, "string")
+
+	// The longest prefix sets the tag and the security
+	list := doc.Paths["/v1/admin/widgets"]["get"]
+	c.Assert(list, check.NotNil)
+	c.Assert(list.Tags, check.DeepEquals, []string{"admin"})
+	c.Assert(list.Security, check.HasLen, 2)
+	c.Assert(list.Parameters[0].In, check.Equals, "query")
+	c.Assert(list.Responses["200"].Content["application/json"].Schema.Type, check.Equals, "array")
+}
+
+func (s *SpecSuite) TestGenerateSchemas(c *check.C) {
+	doc, err := spec.Generate(s.router(), s.api(s.operations()))
+	c.Assert(err, check.IsNil)
+
+	w := doc.Components.Schemas["spec_test.widget"]
+	c.Assert(w, check.NotNil)
+	c.Assert(w.Properties, check.HasLen, 7)
+	c.Assert(w.Properties["id"].Type, check.Equals, "integer")
+	c.Assert(w.Properties["name"].Type, check.Equals, "string")
+	c.Assert(w.Properties["count"].Type, check.Equals, "string")
+	c.Assert(w.Properties["created"].Format, check.Equals, "date-time")
+	c.Assert(w.Properties["labels"].AdditionalProperties.Type, check.Equals, "string")
+	c.Assert(w.Properties["children"].Items.Ref, check.Equals, "#/components/schemas/spec_test.widget")
+	c.Assert(w.Properties["owner"].Type, check.Equals, "string")
+}
+
+func (s *SpecSuite) TestGenerateErrors(c *check.C) {
+	// An undocumented route
+	operations := s.operations()
+	delete(operations, "PUT /v1/widgets/{id:[0-9]+}")
+	_, err := spec.Generate(s.router(), s.api(operations))
+	c.Assert(err, check.ErrorMatches, "the route PUT /v1/widgets/{id:\\[0-9\\]\\+} is not documented")
+
+	// A duplicate operation ID
+	operations = s.operations()
+	operations["PUT /v1/widgets/{id:[0-9]+}"] = spec.Operation{ID: "widgetGet"}
+	_, err = spec.Generate(s.router(), s.api(operations))
+	c.Assert(err, check.ErrorMatches, ".*have the same operation ID 'widgetGet'")
+
+	// A route without methods
+	router := s.router()
+	router.HandleFunc("/v1/any", handler)
+	_, err = spec.Generate(router, s.api(s.operations()))
+	c.Assert(err, check.ErrorMatches, "the route /v1/any has no methods")
+}
+
+func (s *SpecSuite) TestHandler(c *check.C) {
+	router := s.router()
+	router.Handle("/v1/spec", spec.Handler(router, s.api(s.operations()))).Methods("GET")
+
+	// The spec route must be documented too
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/v1/spec", nil)
+	router.ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusInternalServerError)
+
+	operations := s.operations()
+	operations["GET /v1/spec"] = spec.Operation{ID: "spec", Summary: "Get the spec", Public: true}
+	router = s.router()
+	router.Handle("/v1/spec", spec.Handler(router, s.api(operations))).Methods("GET")
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+	c.Assert(strings.Contains(w.Body.String(), `"operationId": "widgetSign"`), check.Equals, true)
+}
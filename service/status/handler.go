@@ -1,10 +1,16 @@
 package status
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 
+	"github.com/CanonicalLtd/serial-vault/canary"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/gorilla/mux"
 )
@@ -17,6 +23,19 @@ func AddStatusEndpoints(prefix string, r *mux.Router) {
 		Methods("GET")
 	s.HandleFunc("/check", DatabasePingHandler).
 		Methods("GET")
+	s.HandleFunc("/keystore", KeystoreCheckHandler).
+		Methods("GET")
+	s.HandleFunc("/sync", SyncStatusHandler).
+		Methods("GET")
+}
+
+// AddProbeEndpoints adds the liveness and readiness endpoints for Kubernetes and load balancers.
+// They are not wrapped in the logging middleware, as they are called every few seconds
+func AddProbeEndpoints(r *mux.Router) {
+	r.HandleFunc("/healthz", LivenessHandler).
+		Methods("GET")
+	r.HandleFunc("/readyz", ReadinessHandler).
+		Methods("GET")
 }
 
 // PingHandler returns 200 OK response with version of the service in the body
@@ -32,7 +51,7 @@ func DatabasePingHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", response.JSONHeader)
 	status := "OK"
 
-	err := datastore.Environ.DB.HealthCheck()
+	err := datastore.Environ.DB.HealthCheck(r.Context())
 	if err != nil {
 		status = err.Error()
 		w.WriteHeader(500)
@@ -40,3 +59,131 @@ func DatabasePingHandler(w http.ResponseWriter, r *http.Request) {
 
 	json.NewEncoder(w).Encode(map[string]string{"database": status})
 }
+
+// KeystoreCheckHandler will return a json data with the last check of the keystore canary
+// 200: { "keystore": "OK" } or
+// 500: { "keystore": "the keystore secret cannot decrypt the keystore canary: ..." }
+func KeystoreCheckHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	status := "OK"
+
+	err := keystoreCheck(r.Context())
+	if err != nil {
+		status = err.Error()
+		w.WriteHeader(500)
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{"keystore": status})
+}
+
+// SyncStatusHandler will return a json data with the last sync of each resource of the factory
+// 404: { "sync": "disabled" }, when the signing service does not run the sync, or
+// 200: { "sync": "OK", "resources": [{ "resource": "models", "lastRun": ..., "conflicts": 0, ... }] } or
+// 500: { "sync": "the sync of the models failed", "resources": [...] }
+// The messages of the failed syncs are only returned to a superuser, as the status endpoints are not authenticated
+func SyncStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	if !datastore.Environ.Config.SyncMode {
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"sync": "disabled"})
+		return
+	}
+	status := "OK"
+
+	resources, err := datastore.Environ.DB.ListSyncStatus(r.Context())
+	if err != nil {
+		log.Errorf("Error retrieving the sync status: %v", err)
+		status = "error retrieving the sync status"
+		resources = []datastore.SyncStatus{}
+	} else if len(resources) == 0 {
+		status = "the factory has not been synced"
+	}
+
+	details := syncStatusDetails(w, r)
+	for i, s := range resources {
+		if len(s.Message) == 0 {
+			continue
+		}
+		if !details {
+			resources[i].Message = ""
+		}
+		if status == "OK" {
+			status = fmt.Sprintf("the sync of the %s failed", s.Resource)
+			if details {
+				status += ": " + s.Message
+			}
+		}
+	}
+
+	if status != "OK" {
+		w.WriteHeader(500)
+	}
+
+	json.NewEncoder(w).Encode(map[string]interface{}{"sync": status, "resources": resources})
+}
+
+// syncStatusDetails checks if the messages of the failed syncs are returned, to an authenticated superuser
+func syncStatusDetails(w http.ResponseWriter, r *http.Request) bool {
+	if !datastore.Environ.Config.EnableUserAuth {
+		return false
+	}
+	user, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		return false
+	}
+	return auth.CheckUserPermissions(user, datastore.Superuser, false) == nil
+}
+
+// LivenessHandler will return 200: { "status": "OK" } while the process is serving requests.
+// The dependencies are not checked, so an outage of the database does not restart the instance
+func LivenessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+	json.NewEncoder(w).Encode(map[string]string{"status": "OK"})
+}
+
+// ReadinessHandler will return a json data with the checks of the dependencies
+// 200: { "status": "OK", "database": "OK", "keystore": "OK", "migrations": "OK" } or
+// 503: { "status": "not ready", "database": "OK", "keystore": "OK", "migrations": "the database schema is at version ..." }
+func ReadinessHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", response.JSONHeader)
+
+	checks := map[string]error{
+		"database":   datastore.Environ.DB.HealthCheck(r.Context()),
+		"keystore":   keystoreReady(r.Context()),
+		"migrations": datastore.CheckSchemaVersion(r.Context()),
+	}
+
+	result := map[string]string{"status": "OK"}
+	for name, err := range checks {
+		if err != nil {
+			result[name] = err.Error()
+			result["status"] = "not ready"
+		} else {
+			result[name] = "OK"
+		}
+	}
+
+	if result["status"] != "OK" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+
+	json.NewEncoder(w).Encode(result)
+}
+
+// keystoreReady returns an error when the keystore is not open or the keystore check failed
+func keystoreReady(ctx context.Context) error {
+	if datastore.Environ.KeypairDB == nil {
+		return errors.New("the keystore is not open")
+	}
+	return keystoreCheck(ctx)
+}
+
+// keystoreCheck returns the last check of the keystore canary, checking the keystore now
+// if it has not been checked yet
+func keystoreCheck(ctx context.Context) error {
+	checked, err := canary.Status()
+	if checked.IsZero() {
+		err = canary.Check(ctx)
+	}
+	return err
+}
@@ -1,13 +1,18 @@
 package status
 
 import (
+	"context"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/usso"
 	"github.com/gorilla/mux"
+	"github.com/juju/usso/openid"
 )
 
 const version = "1.2.3"
@@ -85,3 +90,175 @@ func TestAddStatusEndpointsDBPingOK(t *testing.T) {
 		t.Errorf("expected body %s, got %s", expected, got)
 	}
 }
+
+func TestAddStatusEndpointsKeystoreCheck(t *testing.T) {
+	// The filesystem keystore does not use the keystore secret
+	config := config.Settings{Version: version, KeyStoreType: "filesystem"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	// run the test
+	router := mux.NewRouter()
+	AddStatusEndpoints("/_status", router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/_status/keystore", nil)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("expected code 200, got %d", w.Code)
+	}
+
+	expected := `{"keystore":"OK"}`
+	got := strings.TrimSpace(w.Body.String())
+	if expected != got {
+		t.Errorf("expected body %s, got %s", expected, got)
+	}
+}
+
+func TestAddStatusEndpointsSync(t *testing.T) {
+	config := config.Settings{Version: version, SyncMode: true, EnableUserAuth: true, JwtSecret: "SomeTestSecretValue"}
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db, Config: config}
+
+	router := mux.NewRouter()
+	AddStatusEndpoints("/_status", router)
+
+	// The factory has not been synced
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/_status/sync", nil))
+	if w.Code != 500 {
+		t.Errorf("expected code 500, got %d", w.Code)
+	}
+
+	run := time.Date(2026, 10, 1, 10, 0, 0, 0, time.UTC)
+	db.PutSyncStatus(context.Background(), datastore.SyncStatus{Resource: "models", LastRun: run, Synced: 2, Conflicts: 1})
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/_status/sync", nil))
+	if w.Code != 200 {
+		t.Errorf("expected code 200, got %d", w.Code)
+	}
+	expected := `{"resources":[{"resource":"models","lastRun":"2026-10-01T10:00:00Z","lastSuccess":"2026-10-01T10:00:00Z","synced":2,"conflicts":1,"message":""}],"sync":"OK"}`
+	got := strings.TrimSpace(w.Body.String())
+	if expected != got {
+		t.Errorf("expected body %s, got %s", expected, got)
+	}
+
+	// The failed sync of a resource is reported, with its message only to a superuser
+	db.PutSyncStatus(context.Background(), datastore.SyncStatus{Resource: "models", LastRun: run.Add(time.Hour), Message: "connection refused"})
+
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/_status/sync", nil))
+	if w.Code != 500 {
+		t.Errorf("expected code 500, got %d", w.Code)
+	}
+	if !strings.Contains(w.Body.String(), `"sync":"the sync of the models failed"`) || strings.Contains(w.Body.String(), "connection refused") {
+		t.Errorf("expected the failed sync without its message, got %s", w.Body.String())
+	}
+
+	for _, tt := range []struct {
+		role    int
+		message bool
+	}{{datastore.Admin, false}, {datastore.Superuser, true}} {
+		r := httptest.NewRequest("GET", "/_status/sync", nil)
+		resp := openid.Response{ID: "identity", Teams: []string{}, SReg: map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}}
+		jwtToken, err := usso.NewJWTToken(&resp, tt.role)
+		if err != nil {
+			t.Fatalf("Error creating a JWT: %v", err)
+		}
+		r.Header.Set("Authorization", "Bearer "+jwtToken)
+
+		w = httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		if w.Code != 500 {
+			t.Errorf("expected code 500, got %d", w.Code)
+		}
+		if got := strings.Contains(w.Body.String(), `"sync":"the sync of the models failed: connection refused"`); got != tt.message {
+			t.Errorf("role %d: expected the message %v, got %s", tt.role, tt.message, w.Body.String())
+		}
+	}
+
+	// The sync status is not found when the signing service does not run the sync
+	datastore.Environ.Config.SyncMode = false
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest("GET", "/_status/sync", nil))
+	if w.Code != 404 || strings.TrimSpace(w.Body.String()) != `{"sync":"disabled"}` {
+		t.Errorf("expected the disabled sync, got %d %s", w.Code, w.Body.String())
+	}
+}
+
+func TestAddProbeEndpointsLiveness(t *testing.T) {
+	// The liveness probe does not check the database
+	config := config.Settings{Version: version}
+	datastore.Environ = &datastore.Env{DB: &datastore.ErrorMockDB{}, Config: config}
+
+	// run the test
+	router := mux.NewRouter()
+	AddProbeEndpoints(router)
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/healthz", nil)
+
+	router.ServeHTTP(w, r)
+
+	if w.Code != 200 {
+		t.Errorf("expected code 200, got %d", w.Code)
+	}
+
+	expected := `{"status":"OK"}`
+	got := strings.TrimSpace(w.Body.String())
+	if expected != got {
+		t.Errorf("expected body %s, got %s", expected, got)
+	}
+}
+
+func TestAddProbeEndpointsReadiness(t *testing.T) {
+	config := config.Settings{Version: version, KeyStoreType: "filesystem", KeyStorePath: "../../keystore"}
+
+	tests := []struct {
+		name     string
+		db       datastore.Datastore
+		open     bool
+		schema   string
+		code     int
+		expected string
+	}{
+		{"ready", &datastore.MockDB{}, true, "", 200, `{"database":"OK","keystore":"OK","migrations":"OK","status":"OK"}`},
+		{"pending-migrations", &datastore.MockDB{}, true, "0", 503, `{"database":"OK","keystore":"OK","migrations":"the database schema is at version 0, expected ` + strconv.Itoa(datastore.SchemaVersion) + `: run the database update","status":"not ready"}`},
+		{"keystore-closed", &datastore.MockDB{}, false, "", 503, `{"database":"OK","keystore":"the keystore is not open","migrations":"OK","status":"not ready"}`},
+		{"database-down", &datastore.ErrorMockDB{}, true, "", 503, `{"database":"Health check failed","keystore":"OK","migrations":"invalid database schema version 'schema-version'","status":"not ready"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			datastore.Environ = &datastore.Env{DB: tt.db, Config: config}
+			if tt.open {
+				if err := datastore.OpenKeyStore(config); err != nil {
+					t.Fatalf("error opening the keystore: %v", err)
+				}
+			}
+			if len(tt.schema) > 0 {
+				tt.db.PutSetting(context.Background(), datastore.Setting{Code: datastore.SettingSchemaVersion, Data: tt.schema})
+			}
+
+			// run the test
+			router := mux.NewRouter()
+			AddProbeEndpoints(router)
+
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest("GET", "/readyz", nil)
+
+			router.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("expected code %d, got %d", tt.code, w.Code)
+			}
+
+			got := strings.TrimSpace(w.Body.String())
+			if tt.expected != got {
+				t.Errorf("expected body %s, got %s", tt.expected, got)
+			}
+		})
+	}
+}
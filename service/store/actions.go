@@ -20,16 +20,18 @@
 package store
 
 import (
+	"context"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
+	keypairs "github.com/CanonicalLtd/serial-vault/service/keypair"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/CanonicalLtd/serial-vault/store"
 )
 
-func keyRegisterHandler(w http.ResponseWriter, user datastore.User, apiCall bool, keyAuth store.KeyRegister) {
+func keyRegisterHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, keyAuth store.KeyRegister) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -39,20 +41,24 @@ func keyRegisterHandler(w http.ResponseWriter, user datastore.User, apiCall bool
 	}
 
 	// Check that the user has permissions to this authority-id
-	if !datastore.Environ.DB.CheckUserInAccount(user.Username, keyAuth.AuthorityID) {
+	if !datastore.Environ.DB.CheckUserInAccount(ctx, user.Username, keyAuth.AuthorityID) {
 		response.FormatStandardResponse(false, response.ErrorAuth.Code, "", response.ErrorAuth.Message, w)
 		return
 	}
 
-	keypair, err := datastore.Environ.DB.GetKeypairByName(keyAuth.AuthorityID, keyAuth.KeyName)
+	keypair, err := datastore.Environ.DB.GetKeypairByName(ctx, keyAuth.AuthorityID, keyAuth.KeyName)
 	if err != nil {
 		log.Message("KEYPAIR", response.ErrorFetchKeypair.Code, err.Error())
 		response.FormatStandardResponse(false, response.ErrorFetchKeypair.Code, "", "Cannot find the signing key", w)
 		return
 	}
 
-	// Register the account key with the store
-	err = store.RegisterKey(keyAuth, keypair)
+	// Register the account key with the store, as the user or with the configured store credentials
+	if len(keyAuth.Email) > 0 {
+		err = store.RegisterKey(keyAuth, keypair)
+	} else {
+		err = keypairs.RegisterWithStore(ctx, user, keypair)
+	}
 	if err != nil {
 		log.Message("KEYPAIR", response.ErrorStoreKeypair.Code, err.Error())
 		response.FormatStandardResponse(false, response.ErrorStoreKeypair.Code, "", err.Error(), w)
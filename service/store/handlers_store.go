@@ -64,10 +64,10 @@ func KeyRegister(w http.ResponseWriter, r *http.Request) {
 	keyAuth := store.KeyRegister{}
 	err = json.NewDecoder(r.Body).Decode(&keyAuth)
 	if err != nil {
-		log.Printf("Error in store key request: %v", err)
+		log.Errorf("Error in store key request: %v", err)
 		response.FormatStandardResponse(false, "error-decode-json", "", "", w)
 		return
 	}
 
-	keyRegisterHandler(w, authUser, false, keyAuth)
+	keyRegisterHandler(r.Context(), w, authUser, false, keyAuth)
 }
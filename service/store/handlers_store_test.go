@@ -62,6 +62,7 @@ func (s *StoreSuite) SetUpTest(c *check.C) {
 
 	// Mocks the submission of the key to the store
 	store.RegisterKey = mockRegisterKey
+	store.RegisterKeyWithCredentials = mockRegisterKeyWithCredentials
 
 	// Disable CSRF for tests as we do not have a secure connection
 	service.MiddlewareWithCSRF = service.Middleware
@@ -112,6 +113,33 @@ func (s *StoreSuite) TestStoreHandler(c *check.C) {
 	}
 }
 
+func (s *StoreSuite) TestStoreHandlerCredentials(c *check.C) {
+	tests := []StoreSuiteTest{
+		{credentialsKeyRegister(), 200, false, false, datastore.Admin, true, false},
+		{credentialsKeyRegister(), 400, false, true, datastore.Admin, true, false},
+		{credentialsKeyRegister(), 400, true, false, datastore.Admin, true, false},
+	}
+
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+		if t.MockRegError {
+			store.RegisterKeyWithCredentials = func(keypair datastore.Keypair) (string, error) {
+				return "", store.ErrNoCredentials
+			}
+		}
+		datastore.Environ.Config.EnableUserAuth = t.EnableAuth
+
+		w := sendAdminRequest("POST", "/v1/keypairs/register", bytes.NewReader(t.Data), t.Permissions, t.SkipJWT, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+
+		datastore.Environ.DB = &datastore.MockDB{}
+		store.RegisterKeyWithCredentials = mockRegisterKeyWithCredentials
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
 func validKeyRegister() []byte {
 	a := store.KeyRegister{
 		Auth:        store.Auth{Email: "john@example.com", Password: "password", OTP: ""},
@@ -122,6 +150,15 @@ func validKeyRegister() []byte {
 	return d
 }
 
+func credentialsKeyRegister() []byte {
+	d, _ := json.Marshal(store.KeyRegister{AuthorityID: "system", KeyName: "system"})
+	return d
+}
+
+func mockRegisterKeyWithCredentials(keypair datastore.Keypair) (string, error) {
+	return "account-key assertion\n", nil
+}
+
 func mockRegisterKey(keyAuth store.KeyRegister, keypair datastore.Keypair) error {
 	return nil
 }
@@ -20,6 +20,7 @@
 package substore
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -27,6 +28,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
@@ -47,32 +49,64 @@ type ListResponse struct {
 	ErrorSubcode string               `json:"error_subcode"`
 	ErrorMessage string               `json:"message"`
 	Substores    []datastore.Substore `json:"substores"`
+	NextOffset   int                  `json:"next_offset,omitempty"`
 }
 
-// listHandler is the API method to fetch the list sub-stores
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+// BulkResponse is the response from a bulk upload of sub-store models, with the result of each row
+type BulkResponse struct {
+	Success      bool                           `json:"success"`
+	ErrorCode    string                         `json:"error_code"`
+	ErrorSubcode string                         `json:"error_subcode"`
+	ErrorMessage string                         `json:"message"`
+	Results      []datastore.BulkSubstoreResult `json:"results"`
+}
+
+// PivotsResponse is the JSON response from the API method of the pivots of a device
+type PivotsResponse struct {
+	Success      bool                      `json:"success"`
+	ErrorCode    string                    `json:"error_code"`
+	ErrorSubcode string                    `json:"error_subcode"`
+	ErrorMessage string                    `json:"message"`
+	Pivots       []datastore.SubstorePivot `json:"pivots"`
+}
+
+// listHandler is the API method to fetch a page of the sub-stores, with the offset of the next page
+// when there is one
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, filter datastore.SubstoreFilter) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
-	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
 		return
 	}
 
-	// logs, err := datastore.Environ.DB.ListAllowedSigningLog(user)
-	stores, err := datastore.Environ.DB.ListSubstores(accountID, user)
+	// Fetch an extra sub-store to check if there is a next page
+	limit := filter.Limit
+	if limit > 0 {
+		filter.Limit++
+	}
+
+	stores, err := datastore.Environ.DB.ListSubstores(ctx, accountID, user, filter)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-stores-json", "", err.Error(), w)
 		return
 	}
 
+	next := 0
+	if limit > 0 && len(stores) > limit {
+		stores = stores[:limit]
+		next = filter.Offset + limit
+	}
+
 	// Return successful JSON response with the list of models
 	w.WriteHeader(http.StatusOK)
-	formatListResponse(true, "", "", "", stores, w)
+	formatListResponse(true, "", "", "", stores, next, w)
 }
 
-func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int, store datastore.Substore) {
+// deletedListHandler is the API method to fetch the deleted sub-stores, which can be restored
+func deletedListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -81,24 +115,49 @@ func updateHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 		return
 	}
 
+	stores, err := datastore.Environ.DB.ListDeletedSubstores(ctx, accountID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-stores-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the list of models
+	w.WriteHeader(http.StatusOK)
+	formatListResponse(true, "", "", "", stores, 0, w)
+}
+
+func updateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, storeID int, store datastore.Substore) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
 	if storeID != store.ID {
 		response.FormatStandardResponse(false, "error-stores-json", "", fmt.Sprintf("The store IDs do not match: expected %d, actual store ID %d", storeID, store.ID), w)
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateAllowedSubstore(store, user)
+	// The sub-store before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetSubstoreByID(ctx, storeID)
+
+	err = datastore.Environ.DB.UpdateAllowedSubstore(ctx, store, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-stores-substore", "", err.Error(), w)
 		return
 	}
+	record(ctx, user, audit.ActionUpdate, audit.ObjectSubstore, store.ID, store.AccountID, before, store)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, store datastore.Substore) {
+func createHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, store datastore.Substore) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -107,24 +166,65 @@ func createHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 		return
 	}
 
-	allowedSubstore, err := datastore.Environ.DB.CreateAllowedSubstore(store, user)
+	allowedSubstore, err := datastore.Environ.DB.CreateAllowedSubstore(ctx, store, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-stores-json", "", err.Error(), w)
 		return
 	}
+	record(ctx, user, audit.ActionCreate, audit.ObjectSubstore, allowedSubstore.ID, allowedSubstore.AccountID, nil, allowedSubstore)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	formatInstanceResponse(allowedSubstore, w)
 }
 
-func formatListResponse(success bool, errorCode, errorSubcode, message string, stores []datastore.Substore, w http.ResponseWriter) error {
-	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Substores: stores}
+// bulkHandler creates the sub-store models of a list of serial numbers, which are all created or
+// none of them
+func bulkHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, stores []datastore.Substore) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(stores) == 0 || len(stores) > datastore.MaxBulkSubstores {
+		response.FormatStandardResponse(false, "error-store-data", "", fmt.Sprintf("Between 1 and %d sub-store models must be supplied", datastore.MaxBulkSubstores), w)
+		return
+	}
+
+	// The results are returned when the sub-store models are rejected, to report the invalid rows
+	results, err := datastore.Environ.DB.BulkCreateAllowedSubstores(ctx, accountID, stores, user)
+	if err != nil {
+		log.Error("error-bulk-stores", err)
+		w.WriteHeader(http.StatusBadRequest)
+		formatBulkResponse(BulkResponse{ErrorCode: "error-bulk-stores", ErrorMessage: err.Error(), Results: results}, w)
+		return
+	}
+	for i, r := range results {
+		store := stores[i]
+		store.ID = r.ID
+		record(ctx, user, audit.ActionCreate, audit.ObjectSubstore, r.ID, accountID, nil, store)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatBulkResponse(BulkResponse{Success: true, Results: results}, w)
+}
+
+func formatBulkResponse(result BulkResponse, w http.ResponseWriter) {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("error-stores-response", err)
+	}
+}
+
+func formatListResponse(success bool, errorCode, errorSubcode, message string, stores []datastore.Substore, next int, w http.ResponseWriter) error {
+	response := ListResponse{Success: success, ErrorCode: errorCode, ErrorSubcode: errorSubcode, ErrorMessage: message, Substores: stores, NextOffset: next}
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error forming the sub-stores response (%v).\n %v", response, err)
+		log.Errorf("Error forming the sub-stores response (%v).\n %v", response, err)
 		return err
 	}
 	return nil
@@ -135,13 +235,13 @@ func formatInstanceResponse(store datastore.Substore, w http.ResponseWriter) err
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Printf("Error forming the sub-store response (%v).\n %v", response, err)
+		log.Errorf("Error forming the sub-store response (%v).\n %v", response, err)
 		return err
 	}
 	return nil
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, storeID int) {
+func deleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, storeID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -150,20 +250,23 @@ func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, sto
 		return
 	}
 
-	errorSubcode, err := datastore.Environ.DB.DeleteAllowedSubstore(storeID, user)
+	// The sub-store before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetSubstoreByID(ctx, storeID)
+
+	errorSubcode, err := datastore.Environ.DB.DeleteAllowedSubstore(ctx, storeID, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-deleting-store", errorSubcode, err.Error(), w)
 		return
 	}
+	record(ctx, user, audit.ActionDelete, audit.ObjectSubstore, storeID, before.AccountID, before, nil)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-// getHandler is the API method to get a substore given FromModelID and SerialNumber
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, serial string) {
+func restoreHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, storeID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
@@ -172,9 +275,42 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelI
 		return
 	}
 
-	store, err := datastore.Environ.DB.GetAllowedSubstore(modelID, serial, user)
+	errorSubcode, err := datastore.Environ.DB.RestoreAllowedSubstore(ctx, storeID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-restoring-store", errorSubcode, err.Error(), w)
+		return
+	}
+	after, _ := datastore.Environ.DB.GetSubstoreByID(ctx, storeID)
+	record(ctx, user, audit.ActionRestore, audit.ObjectSubstore, storeID, after.AccountID, nil, after)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// record logs the change of a sub-store or a sub-store admin in the audit log, under the authority of its account
+func record(ctx context.Context, user datastore.User, action, object string, id, accountID int, before, after interface{}) {
+	account, err := datastore.Environ.DB.GetAccountByID(ctx, accountID, user)
+	if err != nil {
+		log.Errorf("Error fetching the account %d of the audit log of the %s %s %d: %v", accountID, action, object, id, err)
+	}
+	audit.Record(ctx, user, action, object, id, account.AuthorityID, before, after)
+}
+
+// getHandler is the API method to get a substore given FromModelID and SerialNumber
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, serial string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	store, err := datastore.Environ.DB.GetAllowedSubstore(ctx, modelID, serial, user)
 	if err != nil {
-		log.Println(err)
+		log.Error(err)
 		response.FormatStandardResponse(false, "error-stores-json", "", err.Error(), w)
 		return
 	}
@@ -183,3 +319,37 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, modelI
 	w.WriteHeader(http.StatusOK)
 	formatInstanceResponse(store, w)
 }
+
+// pivotsHandler is the API method to get the history of the pivots of a device, given the original
+// model and its serial number
+func pivotsHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, modelID int, serial string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.SubstoreAdmin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	pivots, err := datastore.Environ.DB.ListAllowedSubstorePivots(ctx, modelID, serial, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-pivots-json", "", err.Error(), w)
+		return
+	}
+
+	// Return successful JSON response with the pivots
+	w.WriteHeader(http.StatusOK)
+	formatPivotsResponse(pivots, w)
+}
+
+func formatPivotsResponse(pivots []datastore.SubstorePivot, w http.ResponseWriter) error {
+	response := PivotsResponse{Success: true, Pivots: pivots}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the pivots response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package substore
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// UserListResponse is the JSON response from the API sub-store users method
+type UserListResponse struct {
+	Success      bool                     `json:"success"`
+	ErrorCode    string                   `json:"error_code"`
+	ErrorSubcode string                   `json:"error_subcode"`
+	ErrorMessage string                   `json:"message"`
+	Users        []datastore.SubstoreUser `json:"users"`
+}
+
+// UserCreateResponse is the JSON response from the API create sub-store user method
+type UserCreateResponse struct {
+	Success      bool   `json:"success"`
+	ErrorCode    string `json:"error_code"`
+	ErrorSubcode string `json:"error_subcode"`
+	ErrorMessage string `json:"message"`
+	ID           int    `json:"id"`
+}
+
+// userListHandler is the API method to fetch the sub-store admins of an account
+func userListHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	users, err := datastore.Environ.DB.ListAllowedSubstoreUsers(ctx, accountID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-store-users-json", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatUserListResponse(users, w)
+}
+
+// userCreateHandler is the API method to create a sub-store admin for an account
+func userCreateHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID int, storeUser datastore.SubstoreUser) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	userID, err := datastore.Environ.DB.CreateAllowedSubstoreUser(ctx, accountID, storeUser, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-creating-store-user", "", err.Error(), w)
+		return
+	}
+	record(ctx, user, audit.ActionCreate, audit.ObjectSubstoreUser, userID, accountID, nil, storeUser)
+
+	w.WriteHeader(http.StatusOK)
+	formatUserCreateResponse(userID, w)
+}
+
+// userDeleteHandler is the API method to delete a sub-store admin of an account
+func userDeleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, accountID, userID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(user, datastore.Admin, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	err = datastore.Environ.DB.DeleteAllowedSubstoreUser(ctx, accountID, userID, user)
+	if err != nil {
+		log.Error(err)
+		response.FormatStandardResponse(false, "error-deleting-store-user", "", err.Error(), w)
+		return
+	}
+	record(ctx, user, audit.ActionDelete, audit.ObjectSubstoreUser, userID, accountID, nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func formatUserListResponse(users []datastore.SubstoreUser, w http.ResponseWriter) error {
+	response := UserListResponse{Success: true, Users: users}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the sub-store users response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
+
+func formatUserCreateResponse(userID int, w http.ResponseWriter) error {
+	response := UserCreateResponse{Success: true, ID: userID}
+
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Errorf("Error forming the sub-store user response (%v).\n %v", response, err)
+		return err
+	}
+	return nil
+}
@@ -49,8 +49,36 @@ func APIList(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	filter, err := getSubstoreFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-substore-filter", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	listHandler(r.Context(), w, user, true, accountID, filter)
+}
+
+// APIListDeleted is the API method to fetch the deleted sub-store models
+func APIListDeleted(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
 	// Call the API with the user
-	listHandler(w, user, true, accountID)
+	deletedListHandler(r.Context(), w, user, true, accountID)
 }
 
 // APIUpdate is the API method to update a sub-store model
@@ -86,7 +114,7 @@ func APIUpdate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	updateHandler(w, user, true, storeID, store)
+	updateHandler(r.Context(), w, user, true, storeID, store)
 }
 
 // APICreate is the API method to create a sub-store model
@@ -115,7 +143,41 @@ func APICreate(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	createHandler(w, user, true, store)
+	createHandler(r.Context(), w, user, true, store)
+}
+
+// APIBulk is the API method to upload a list of serial numbers to a sub-store model
+func APIBulk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	stores, err := decodeBulk(r)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-store-data", "", "No sub-store data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-stores", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	bulkHandler(r.Context(), w, user, true, accountID, stores)
 }
 
 // APIDelete is the API method to delete a sub-store model
@@ -137,7 +199,29 @@ func APIDelete(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	deleteHandler(w, user, true, storeID)
+	deleteHandler(r.Context(), w, user, true, storeID)
+}
+
+// APIRestore is the API method to restore a deleted sub-store model
+func APIRestore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	storeID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-store", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	restoreHandler(r.Context(), w, user, true, storeID)
 }
 
 // APIGet is the API method to get a particular instance of a substore
@@ -160,5 +244,27 @@ func APIGet(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	getHandler(w, user, true, modelID, serial)
+	getHandler(r.Context(), w, user, true, modelID, serial)
+}
+
+// APIPivots is the API method to get the history of the pivots of a device
+func APIPivots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// Validate the user and API key
+	user, err := request.CheckUserAPI(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["modelID"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	// Call the API with the user
+	pivotsHandler(r.Context(), w, user, true, modelID, vars["serial"])
 }
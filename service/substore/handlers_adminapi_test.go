@@ -21,6 +21,7 @@ package substore_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -29,6 +30,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/substore"
 	check "gopkg.in/check.v1"
 )
 
@@ -77,6 +79,10 @@ func (s *SubstoreSuite) TestAPICreateUpdateDeleteHandler(c *check.C) {
 		{"DELETE", "/api/accounts/stores/1", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
 		{"DELETE", "/api/accounts/stores/1", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{"DELETE", "/api/accounts/stores/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"POST", "/api/accounts/stores/3/restore", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"POST", "/api/accounts/stores/3/restore", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"POST", "/api/accounts/stores/1/restore", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/api/accounts/stores/3/restore", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 	}
 
 	for _, t := range tests {
@@ -117,6 +123,22 @@ func (s *SubstoreSuite) TestAPICreateHandlerReturnSubstore(c *check.C) {
 	c.Assert(result.Substore.SerialNumber, check.Equals, substoreNew.SerialNumber)
 }
 
+func (s *SubstoreSuite) TestAPIBulkHandler(c *check.C) {
+	data := []byte(`[{"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"}]`)
+
+	w := sendAdminAPIRequest("POST", "/api/accounts/1/stores/bulk", bytes.NewReader(data), datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, 200)
+
+	result := substore.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 1)
+
+	w = sendAdminAPIRequest("POST", "/api/accounts/1/stores/bulk", bytes.NewReader(data), 0, c)
+	c.Assert(w.Code, check.Equals, 400)
+}
+
 func (s *SubstoreSuite) TestAPIGetHandler(c *check.C) {
 	tests := []SubstoreTest{
 		{"GET", "/api/accounts/stores/1/12345", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
@@ -126,7 +148,7 @@ func (s *SubstoreSuite) TestAPIGetHandler(c *check.C) {
 		{"GET", "/api/accounts/stores/1/12345", nil, 400, "application/json; charset=UTF-8", 0, true, false, 0},
 	}
 
-	expectedStore, _ := datastore.Environ.DB.GetSubstore(1, "12345")
+	expectedStore, _ := datastore.Environ.DB.GetSubstore(context.Background(), 1, "12345")
 	for _, t := range tests {
 		if t.EnableAuth {
 			datastore.Environ.Config.EnableUserAuth = true
@@ -140,7 +162,7 @@ func (s *SubstoreSuite) TestAPIGetHandler(c *check.C) {
 		c.Assert(err, check.IsNil)
 		c.Assert(result.Success, check.Equals, t.Success)
 		if t.Success {
-			c.Assert(result.Substore, check.Equals, expectedStore)
+			c.Assert(result.Substore, check.DeepEquals, expectedStore)
 		}
 
 		datastore.Environ.Config.EnableUserAuth = false
@@ -163,7 +185,33 @@ func (s *SubstoreSuite) TestErrorAPIGetHandler(c *check.C) {
 		result, err := parseInstanceResponse(w)
 		c.Assert(err, check.IsNil)
 		c.Assert(result.Success, check.Equals, t.Success)
-		c.Assert(result.Substore, check.Equals, datastore.Substore{})
+		c.Assert(result.Substore, check.DeepEquals, datastore.Substore{})
+	}
+}
+
+func (s *SubstoreSuite) TestAPIPivotsHandler(c *check.C) {
+	tests := []SubstoreTest{
+		{"GET", "/api/accounts/stores/1/12345/pivots", nil, 400, "application/json; charset=UTF-8", 0, false, false, 0},
+		{"GET", "/api/accounts/stores/1/12345/pivots", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+		{"GET", "/api/accounts/stores/1/12345/pivots", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 2},
+		{"GET", "/api/accounts/stores/1/12345/pivots", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminAPIRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parsePivotsResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Pivots), check.Equals, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
 	}
 }
 
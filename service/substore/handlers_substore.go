@@ -20,10 +20,13 @@
 package substore
 
 import (
+	"encoding/csv"
 	"encoding/json"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
@@ -48,7 +51,69 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false, accountID)
+	filter, err := getSubstoreFilter(r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-substore-filter", "", err.Error(), w)
+		return
+	}
+
+	listHandler(r.Context(), w, authUser, false, accountID, filter)
+}
+
+// getSubstoreFilter parses the serial number search and the page of the sub-store models list from the
+// request. A page has the default size, unless all the sub-store models are requested
+func getSubstoreFilter(r *http.Request) (datastore.SubstoreFilter, error) {
+	query := r.URL.Query()
+	filter := datastore.SubstoreFilter{
+		Serial: query.Get("serial"),
+		Limit:  datastore.ListSubstoresDefaultLimit,
+	}
+
+	if offset := query.Get("offset"); offset != "" {
+		o, err := strconv.Atoi(offset)
+		if err != nil || o < 0 {
+			return filter, fmt.Errorf("invalid offset: %s", offset)
+		}
+		filter.Offset = o
+	}
+
+	if limit := query.Get("limit"); limit != "" {
+		l, err := strconv.Atoi(limit)
+		if err != nil || l <= 0 {
+			return filter, fmt.Errorf("invalid page size: %s", limit)
+		}
+		if l > datastore.ListSubstoresMaxLimit {
+			l = datastore.ListSubstoresMaxLimit
+		}
+		filter.Limit = l
+	}
+
+	if query.Get("all") == "true" {
+		filter.Limit = 0 // Means no limit.
+		filter.Offset = 0
+	}
+
+	return filter, nil
+}
+
+// ListDeleted is the API method to fetch the deleted sub-store models
+func ListDeleted(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	deletedListHandler(r.Context(), w, authUser, false, accountID)
 }
 
 // Update is the API method to update a sub-store model
@@ -84,7 +149,7 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateHandler(w, authUser, false, storeID, store)
+	updateHandler(r.Context(), w, authUser, false, storeID, store)
 }
 
 // Create is the API method to create a sub-store model
@@ -113,7 +178,125 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	createHandler(w, authUser, false, store)
+	createHandler(r.Context(), w, authUser, false, store)
+}
+
+// Bulk is the API method to upload a list of serial numbers to a sub-store model. The sub-store
+// models are sent as a JSON list, or as CSV with a header row naming the columns, and are created
+// in a single transaction
+func Bulk(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	accountID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-account", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	stores, err := decodeBulk(r)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-store-data", "", "No sub-store data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-stores", "", err.Error(), w)
+		return
+	}
+
+	bulkHandler(r.Context(), w, authUser, false, accountID, stores)
+}
+
+// decodeBulk reads the sub-store models of a bulk upload, from CSV or JSON
+func decodeBulk(r *http.Request) ([]datastore.Substore, error) {
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "text/csv") {
+		return decodeBulkCSV(r.Body)
+	}
+
+	var stores []datastore.Substore
+	err := json.NewDecoder(r.Body).Decode(&stores)
+	return stores, err
+}
+
+// bulkColumns set the fields of a bulk upload row from the CSV columns, which have the names of the JSON fields
+var bulkColumns = map[string]func(store *datastore.Substore, value string) error{
+	"fromModelID":     func(store *datastore.Substore, value string) error { return parseBulkID(&store.FromModelID, value) },
+	"store":           func(store *datastore.Substore, value string) error { store.Store = value; return nil },
+	"serialnumber":    func(store *datastore.Substore, value string) error { store.SerialNumber = value; return nil },
+	"modelname":       func(store *datastore.Substore, value string) error { store.ModelName = value; return nil },
+	"matchtype":       func(store *datastore.Substore, value string) error { store.MatchType = value; return nil },
+	"serialnumberend": func(store *datastore.Substore, value string) error { store.SerialNumberEnd = value; return nil },
+	"frommodelname":   func(store *datastore.Substore, value string) error { store.FromModelName = value; return nil },
+	"originalheaders": func(store *datastore.Substore, value string) error {
+		return parseBulkBool(&store.OriginalHeaders, value)
+	},
+}
+
+// decodeBulkCSV reads the rows of a bulk upload from CSV, with a header row
+func decodeBulkCSV(r io.Reader) ([]datastore.Substore, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	for _, column := range header {
+		if _, ok := bulkColumns[strings.TrimSpace(column)]; !ok {
+			return nil, fmt.Errorf("unknown column: %s", column)
+		}
+	}
+
+	stores := []datastore.Substore{}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			return stores, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		store := datastore.Substore{}
+		for i, column := range header {
+			if err := bulkColumns[strings.TrimSpace(column)](&store, strings.TrimSpace(record[i])); err != nil {
+				return nil, fmt.Errorf("row %d: invalid %s: %s", len(stores)+1, column, record[i])
+			}
+		}
+		stores = append(stores, store)
+	}
+}
+
+func parseBulkID(id *int, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	v, err := strconv.Atoi(value)
+	*id = v
+	return err
+}
+
+// parseBulkBool parses a boolean column of a bulk upload, where an empty value is false
+func parseBulkBool(b *bool, value string) error {
+	if len(value) == 0 {
+		return nil
+	}
+	v, err := strconv.ParseBool(value)
+	if err != nil {
+		return fmt.Errorf("invalid boolean: %s", value)
+	}
+	*b = v
+	return nil
 }
 
 // Delete is the API method to delete a sub-store model
@@ -133,5 +316,45 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, storeID)
+	deleteHandler(r.Context(), w, authUser, false, storeID)
+}
+
+// Restore is the API method to restore a deleted sub-store model
+func Restore(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	storeID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-store", "", err.Error(), w)
+		return
+	}
+
+	restoreHandler(r.Context(), w, authUser, false, storeID)
+}
+
+// Pivots is the API method to get the history of the pivots of a device
+func Pivots(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	modelID, err := strconv.Atoi(vars["modelID"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-model", "", err.Error(), w)
+		return
+	}
+
+	pivotsHandler(r.Context(), w, authUser, false, modelID, vars["serial"])
 }
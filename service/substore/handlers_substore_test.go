@@ -26,11 +26,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/response"
 	"github.com/CanonicalLtd/serial-vault/service/substore"
 	"github.com/CanonicalLtd/serial-vault/usso"
@@ -105,11 +107,22 @@ func parseListResponse(w *httptest.ResponseRecorder) (substore.ListResponse, err
 	return result, err
 }
 
+func parsePivotsResponse(w *httptest.ResponseRecorder) (substore.PivotsResponse, error) {
+	// Check the JSON response
+	result := substore.PivotsResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	return result, err
+}
+
 func (s *SubstoreSuite) TestSubstoresHandler(c *check.C) {
 	tests := []SubstoreTest{
 		{"GET", "/v1/accounts/1/stores", nil, 200, "application/json; charset=UTF-8", 0, false, true, 2},
 		{"GET", "/v1/accounts/1/stores", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+		{"GET", "/v1/accounts/1/stores", nil, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 2},
 		{"GET", "/v1/accounts/1/stores", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"GET", "/v1/accounts/1/stores/deleted", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/accounts/1/stores/deleted", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{"GET", "/v1/accounts/1/stores/deleted", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
 	}
 
 	for _, t := range tests {
@@ -130,6 +143,35 @@ func (s *SubstoreSuite) TestSubstoresHandler(c *check.C) {
 	}
 }
 
+func (s *SubstoreSuite) TestSubstoresPageHandler(c *check.C) {
+	tests := []struct {
+		url     string
+		code    int
+		success bool
+		list    int
+		next    int
+	}{
+		{"/v1/accounts/1/stores?limit=1", 200, true, 1, 1},
+		{"/v1/accounts/1/stores?limit=1&offset=1", 200, true, 1, 0},
+		{"/v1/accounts/1/stores?limit=1&all=true", 200, true, 2, 0},
+		{"/v1/accounts/1/stores?serial=5678", 200, true, 1, 0},
+		{"/v1/accounts/1/stores?serial=invalid", 200, true, 0, 0},
+		{"/v1/accounts/1/stores?limit=0", 400, false, 0, 0},
+		{"/v1/accounts/1/stores?offset=-1", 400, false, 0, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest("GET", t.url, nil, 0, c)
+		c.Assert(w.Code, check.Equals, t.code)
+
+		result, err := parseListResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.success)
+		c.Assert(len(result.Substores), check.Equals, t.list)
+		c.Assert(result.NextOffset, check.Equals, t.next)
+	}
+}
+
 func (s *SubstoreSuite) TestSubstoresCreateUpdateDeleteHandler(c *check.C) {
 	substoreNew := datastore.Substore{AccountID: 1, FromModelID: 1, Store: "mybrand", SerialNumber: "a11112222", ModelName: "alder-mybrand"}
 	ssn, _ := json.Marshal(substoreNew)
@@ -141,15 +183,23 @@ func (s *SubstoreSuite) TestSubstoresCreateUpdateDeleteHandler(c *check.C) {
 		{"POST", "/v1/accounts/stores", ssn, 200, "application/json; charset=UTF-8", 0, false, true, 0},
 		{"POST", "/v1/accounts/stores", ssn, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{"POST", "/v1/accounts/stores", ssn, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"POST", "/v1/accounts/stores", ssn, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
 		{"POST", "/v1/accounts/stores", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{"PUT", "/v1/accounts/stores/1", ss, 200, "application/json; charset=UTF-8", 0, false, true, 0},
 		{"PUT", "/v1/accounts/stores/1", ss, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"PUT", "/v1/accounts/stores/1", ss, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 0},
 		{"PUT", "/v1/accounts/stores/99", ss, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{"PUT", "/v1/accounts/stores/1", ss, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
 		{"PUT", "/v1/accounts/stores/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
 		{"DELETE", "/v1/accounts/stores/1", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
 		{"DELETE", "/v1/accounts/stores/1", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
 		{"DELETE", "/v1/accounts/stores/1", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"DELETE", "/v1/accounts/stores/1", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
+		{"POST", "/v1/accounts/stores/3/restore", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"POST", "/v1/accounts/stores/3/restore", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"POST", "/v1/accounts/stores/1/restore", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/accounts/stores/3/restore", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"POST", "/v1/accounts/stores/3/restore", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
 	}
 
 	for _, t := range tests {
@@ -170,6 +220,28 @@ func (s *SubstoreSuite) TestSubstoresCreateUpdateDeleteHandler(c *check.C) {
 	}
 }
 
+func (s *SubstoreSuite) TestSubstoresAudit(c *check.C) {
+	substore := datastore.Substore{ID: 1, AccountID: 1, FromModelID: 1, Store: "mybrand", SerialNumber: "a11112222", ModelName: "alder-mybrand"}
+	ss, _ := json.Marshal(substore)
+
+	sendAdminRequest("POST", "/v1/accounts/stores", bytes.NewReader(ss), 0, c)
+	sendAdminRequest("PUT", "/v1/accounts/stores/1", bytes.NewReader(ss), 0, c)
+	sendAdminRequest("DELETE", "/v1/accounts/stores/1", nil, 0, c)
+	sendAdminRequest("POST", "/v1/accounts/stores/3/restore", nil, 0, c)
+
+	logs := datastore.Environ.DB.(*datastore.MockDB).AuditLogs()
+	c.Assert(logs, check.HasLen, 4)
+	for i, action := range []string{audit.ActionCreate, audit.ActionUpdate, audit.ActionDelete, audit.ActionRestore} {
+		c.Assert(logs[i].Action, check.Equals, action)
+		c.Assert(logs[i].Object, check.Equals, audit.ObjectSubstore)
+	}
+
+	// The mock does not return the restored sub-store, so only the others have the account
+	for _, l := range logs[:3] {
+		c.Assert(l.AuthorityID, check.Equals, "system")
+	}
+}
+
 func (s *SubstoreSuite) TestSubstoresCreateHandlerReturnSubstore(c *check.C) {
 	substoreNew := datastore.Substore{AccountID: 1, FromModelID: 1, Store: "mybrand", SerialNumber: "a11112222", ModelName: "alder-mybrand"}
 	ssn, _ := json.Marshal(substoreNew)
@@ -256,6 +328,102 @@ func (s *SubstoreSuite) TestSubstoresUpdateErrorHandler(c *check.C) {
 	}
 }
 
+func (s *SubstoreSuite) TestSubstoresBulkHandler(c *check.C) {
+	data := []byte(`[
+		{"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"},
+		{"fromModelID":1, "store":"mybrand", "serialnumber":"A2", "modelname":"alder-mybrand"}
+	]`)
+	w := sendAdminRequest("POST", "/v1/accounts/1/stores/bulk", bytes.NewReader(data), datastore.Admin, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := substore.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 2)
+	c.Assert(result.Results[0].ID, check.Equals, 100)
+	c.Assert(result.Results[1].SerialNumber, check.Equals, "A2")
+}
+
+func (s *SubstoreSuite) TestSubstoresBulkHandlerCSV(c *check.C) {
+	data := "fromModelID,store,serialnumber,modelname\n1,mybrand,A1,alder-mybrand\n1,mybrand,A2,alder-mybrand\n1,mybrand,A3,alder-mybrand\n"
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("POST", "/v1/accounts/1/stores/bulk", strings.NewReader(data))
+	r.Header.Set("Content-Type", "text/csv")
+	c.Assert(createJWTWithRole(r, datastore.Admin), check.IsNil)
+	service.AdminRouter().ServeHTTP(w, r)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := substore.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 3)
+	c.Assert(result.Results[2].Row, check.Equals, 3)
+	c.Assert(result.Results[2].SerialNumber, check.Equals, "A3")
+}
+
+func (s *SubstoreSuite) TestSubstoresBulkHandlerInvalid(c *check.C) {
+	type bulkTest struct {
+		MockError   bool
+		Data        []byte
+		Permissions int
+		Results     int
+	}
+	tests := []bulkTest{
+		{false, []byte(`[{"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"}]`), datastore.Standard, 0},
+		{false, []byte(`[{"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"}, {"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"}]`), datastore.Admin, 2},
+		{false, []byte(`[{"fromModelID":1, "store":"mybrand", "modelname":"alder-mybrand"}]`), datastore.Admin, 1},
+		{false, []byte(`[]`), datastore.Admin, 0},
+		{false, []byte(`{"fromModelID":1}`), datastore.Admin, 0},
+		{false, nil, datastore.Admin, 0},
+		{true, []byte(`[{"fromModelID":1, "store":"mybrand", "serialnumber":"A1", "modelname":"alder-mybrand"}]`), datastore.Admin, 0},
+	}
+
+	datastore.Environ.Config.EnableUserAuth = true
+	for _, t := range tests {
+		if t.MockError {
+			datastore.Environ.DB = &datastore.ErrorMockDB{}
+		}
+
+		w := sendAdminRequest("POST", "/v1/accounts/1/stores/bulk", bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest, check.Commentf("%s", t.Data))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, "application/json; charset=UTF-8")
+
+		result := substore.BulkResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+		c.Assert(result.Results, check.HasLen, t.Results)
+
+		datastore.Environ.DB = &datastore.MockDB{}
+	}
+	datastore.Environ.Config.EnableUserAuth = false
+}
+
+func (s *SubstoreSuite) TestSubstoresBulkHandlerInvalidCSV(c *check.C) {
+	tests := []string{
+		"fromModelID,store,serial\n1,mybrand,A1\n",
+		"fromModelID,store,serialnumber,modelname\none,mybrand,A1,alder-mybrand\n",
+		"fromModelID,store\n1,mybrand,A1\n",
+		"",
+	}
+
+	for _, data := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest("POST", "/v1/accounts/1/stores/bulk", strings.NewReader(data))
+		r.Header.Set("Content-Type", "text/csv")
+		c.Assert(createJWTWithRole(r, datastore.Admin), check.IsNil)
+		service.AdminRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, http.StatusBadRequest, check.Commentf("%s", data))
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, false)
+	}
+}
+
 func createJWTWithRole(r *http.Request, role int) error {
 	sreg := map[string]string{"nickname": "sv", "fullname": "Steven Vault", "email": "sv@example.com"}
 	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
@@ -266,3 +434,33 @@ func createJWTWithRole(r *http.Request, role int) error {
 	r.Header.Set("Authorization", "Bearer "+jwtToken)
 	return nil
 }
+
+func (s *SubstoreSuite) TestSubstoresPivotsHandler(c *check.C) {
+	tests := []SubstoreTest{
+		{"GET", "/v1/accounts/stores/1/12345/pivots", nil, 200, "application/json; charset=UTF-8", 0, false, true, 2},
+		{"GET", "/v1/accounts/stores/1/12345/pivots", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 2},
+		{"GET", "/v1/accounts/stores/1/12345/pivots", nil, 200, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, true, 2},
+		{"GET", "/v1/accounts/stores/1/12345/pivots", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"GET", "/v1/accounts/stores/1/invalid/pivots", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result, err := parsePivotsResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Pivots), check.Equals, t.List)
+		if t.Success {
+			c.Assert(result.Pivots[1].FromModelName, check.Equals, result.Pivots[0].ModelName)
+		}
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
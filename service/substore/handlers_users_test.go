@@ -0,0 +1,120 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package substore_test
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/service/substore"
+	check "gopkg.in/check.v1"
+)
+
+func (s *SubstoreSuite) TestSubstoreUsersHandler(c *check.C) {
+	tests := []SubstoreTest{
+		{"GET", "/v1/accounts/1/stores/users", nil, 200, "application/json; charset=UTF-8", 0, false, true, 1},
+		{"GET", "/v1/accounts/1/stores/users", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 1},
+		{"GET", "/v1/accounts/1/stores/users", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
+		{"GET", "/v1/accounts/1/stores/users", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := substore.UserListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Users), check.Equals, t.List)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SubstoreSuite) TestSubstoreUsersCreateDeleteHandler(c *check.C) {
+	user := datastore.SubstoreUser{User: datastore.User{Username: "mybrand-admin", Name: "Sub-store Admin", Email: "mybrand@example.com"}, Stores: []string{"mybrand"}}
+	u, _ := json.Marshal(user)
+
+	tests := []SubstoreTest{
+		{"POST", "/v1/accounts/1/stores/users", u, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"POST", "/v1/accounts/1/stores/users", u, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"POST", "/v1/accounts/1/stores/users", u, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
+		{"POST", "/v1/accounts/1/stores/users", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"DELETE", "/v1/accounts/1/stores/users/4", nil, 200, "application/json; charset=UTF-8", 0, false, true, 0},
+		{"DELETE", "/v1/accounts/1/stores/users/4", nil, 200, "application/json; charset=UTF-8", datastore.Admin, true, true, 0},
+		{"DELETE", "/v1/accounts/1/stores/users/4", nil, 400, "application/json; charset=UTF-8", datastore.SubstoreAdmin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
+
+func (s *SubstoreSuite) TestSubstoreUsersErrorHandler(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	user := datastore.SubstoreUser{User: datastore.User{Username: "mybrand-admin", Name: "Sub-store Admin", Email: "mybrand@example.com"}, Stores: []string{"mybrand"}}
+	u, _ := json.Marshal(user)
+
+	tests := []SubstoreTest{
+		{"GET", "/v1/accounts/1/stores/users", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/accounts/1/stores/users", u, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"DELETE", "/v1/accounts/1/stores/users/4", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		if t.EnableAuth {
+			datastore.Environ.Config.EnableUserAuth = true
+		}
+
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+
+		result, err := response.ParseStandardResponse(w)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+
+		c.Assert(w.Code, check.Equals, t.Code)
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		datastore.Environ.Config.EnableUserAuth = false
+	}
+}
@@ -21,6 +21,7 @@
 package testlog
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
 
@@ -41,7 +42,7 @@ type ListResponse struct {
 }
 
 // listHandler is the API method to fetch the log records from signing
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -50,7 +51,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	logs, err := datastore.Environ.DB.ListAllowedTestLog(user)
+	logs, err := datastore.Environ.DB.ListAllowedTestLog(ctx, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-signinglog", "", err.Error(), w)
 		return
@@ -66,7 +67,7 @@ func formatListResponse(success bool, errorCode, errorSubcode, message string, l
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the signing log response.")
+		log.Error("Error forming the signing log response.")
 		return err
 	}
 	return nil
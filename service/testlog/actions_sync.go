@@ -21,6 +21,7 @@
 package testlog
 
 import (
+	"context"
 	"encoding/base64"
 	"net/http"
 
@@ -29,7 +30,7 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
-func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, testLog datastore.TestLog) {
+func syncLogHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, testLog datastore.TestLog) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -51,7 +52,7 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, te
 	}
 
 	// Create the test log record
-	err = datastore.Environ.DB.CreateTestLog(testLog)
+	err = datastore.Environ.DB.CreateTestLog(ctx, testLog)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-testlog-create", "", err.Error(), w)
 		return
@@ -62,7 +63,7 @@ func syncLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, te
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func syncUpdateLogHandler(w http.ResponseWriter, user datastore.User, apiCall bool, logID int) {
+func syncUpdateLogHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, logID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.SyncUser, apiCall)
@@ -72,7 +73,7 @@ func syncUpdateLogHandler(w http.ResponseWriter, user datastore.User, apiCall bo
 	}
 
 	// Update the test log record to indicate that it's been synced
-	err = datastore.Environ.DB.UpdateAllowedTestLog(logID, user)
+	err = datastore.Environ.DB.UpdateAllowedTestLog(ctx, logID, user)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-testlog-update", "", err.Error(), w)
 		return
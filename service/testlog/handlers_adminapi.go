@@ -55,7 +55,7 @@ func APISyncLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncLogHandler(w, user, true, request)
+	syncLogHandler(r.Context(), w, user, true, request)
 }
 
 // APIListLog is the API method to list the unsync-ed factory test logs
@@ -68,7 +68,7 @@ func APIListLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	listHandler(w, user, true)
+	listHandler(r.Context(), w, user, true)
 }
 
 // APISyncUpdateLog is the API method to marka test log as synced
@@ -88,5 +88,5 @@ func APISyncUpdateLog(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Call the API with the user
-	syncUpdateLogHandler(w, user, true, logID)
+	syncUpdateLogHandler(r.Context(), w, user, true, logID)
 }
@@ -72,7 +72,7 @@ const paramsEnvVar = "SNAP_DATA"
 func Index(w http.ResponseWriter, r *http.Request) {
 	t, err := template.New("testlog").Parse(tpl)
 	if err != nil {
-		log.Printf("Error loading the application template: %v\n", err)
+		log.Errorf("Error loading the application template: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
@@ -91,7 +91,7 @@ func Submit(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Check that the model exists
-	if found := datastore.Environ.DB.CheckModelExists(r.FormValue("brand"), r.FormValue("model")); !found {
+	if found := datastore.Environ.DB.CheckModelExists(r.Context(), r.FormValue("brand"), r.FormValue("model")); !found {
 		formatUploadResponse(w, http.StatusBadRequest, "The model does not exist")
 		return
 	}
@@ -108,7 +108,7 @@ func Submit(w http.ResponseWriter, r *http.Request) {
 		Brand: r.FormValue("brand"), Model: r.FormValue("model"),
 		Filename: filename, Data: base64File,
 	}
-	err = datastore.Environ.DB.CreateTestLog(t)
+	err = datastore.Environ.DB.CreateTestLog(r.Context(), t)
 	if err != nil {
 		formatUploadResponse(w, http.StatusBadRequest, err.Error())
 		return
@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const (
+	tracerName         = "github.com/CanonicalLtd/serial-vault"
+	defaultServiceName = "serial-vault"
+)
+
+// provider is the tracer provider that exports the spans, when tracing is configured
+var provider *sdktrace.TracerProvider
+
+// Config is the tracing configuration from the settings
+type Config struct {
+	Endpoint    string
+	Insecure    bool
+	ServiceName string
+	SampleRatio float64
+}
+
+// NewConfig validates the tracing settings. All the traces are sampled by default, and none
+// when the ratio is 0
+func NewConfig(settings config.Settings) (Config, error) {
+	cfg := Config{
+		Endpoint:    settings.TracingEndpoint,
+		Insecure:    settings.TracingInsecure,
+		ServiceName: settings.TracingServiceName,
+		SampleRatio: 1,
+	}
+
+	if len(cfg.ServiceName) == 0 {
+		cfg.ServiceName = defaultServiceName
+	}
+	if settings.TracingSampleRatio != nil {
+		cfg.SampleRatio = *settings.TracingSampleRatio
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return cfg, fmt.Errorf("the tracing sample ratio must be between 0 and 1: %v", cfg.SampleRatio)
+	}
+	return cfg, nil
+}
+
+// Enabled is true when the OTLP collector is configured
+func (cfg Config) Enabled() bool {
+	return len(cfg.Endpoint) > 0
+}
+
+// Init exports the spans to the OTLP collector. The spans are not recorded until it is called,
+// and the trace context of the requests is still propagated
+func Init(cfg Config) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	if !cfg.Enabled() {
+		return nil
+	}
+
+	options := []otlptracegrpc.Option{otlptracegrpc.WithEndpoint(cfg.Endpoint)}
+	if cfg.Insecure {
+		options = append(options, otlptracegrpc.WithInsecure())
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background(), options...)
+	if err != nil {
+		return fmt.Errorf("error creating the OTLP trace exporter: %v", err)
+	}
+
+	provider = sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", cfg.ServiceName))),
+		sdktrace.WithSampler(sampler(cfg.SampleRatio)),
+	)
+	otel.SetTracerProvider(provider)
+	return nil
+}
+
+// sampler samples the ratio of the traces, following the decision of the client. No trace is
+// sampled with a ratio of 0, even when the client samples it
+func sampler(ratio float64) sdktrace.Sampler {
+	if ratio == 0 {
+		return sdktrace.NeverSample()
+	}
+	return sdktrace.ParentBased(sdktrace.TraceIDRatioBased(ratio))
+}
+
+// Shutdown exports the remaining spans
+func Shutdown(ctx context.Context) error {
+	if provider == nil {
+		return nil
+	}
+	return provider.Shutdown(ctx)
+}
+
+// Start creates a span that is a child of the span in the context
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// End ends the span, recording the error of the operation
+func End(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
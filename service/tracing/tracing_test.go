@@ -0,0 +1,230 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package tracing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/gorilla/mux"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const traceparent = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+
+func recordSpans(t *testing.T) *tracetest.SpanRecorder {
+	recorder := tracetest.NewSpanRecorder()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	t.Cleanup(func() { otel.SetTracerProvider(sdktrace.NewTracerProvider()) })
+	return recorder
+}
+
+func TestNewConfig(t *testing.T) {
+	ratio := func(r float64) *float64 { return &r }
+
+	tests := []struct {
+		name     string
+		settings config.Settings
+		want     Config
+		enabled  bool
+		wantErr  bool
+	}{
+		{"disabled", config.Settings{}, Config{ServiceName: "serial-vault", SampleRatio: 1}, false, false},
+		{"enabled", config.Settings{TracingEndpoint: "collector:4317", TracingInsecure: true, TracingServiceName: "vault", TracingSampleRatio: ratio(0.1)},
+			Config{Endpoint: "collector:4317", Insecure: true, ServiceName: "vault", SampleRatio: 0.1}, true, false},
+		{"no sampling", config.Settings{TracingEndpoint: "collector:4317", TracingSampleRatio: ratio(0)},
+			Config{Endpoint: "collector:4317", ServiceName: "serial-vault", SampleRatio: 0}, true, false},
+		{"invalid ratio", config.Settings{TracingEndpoint: "collector:4317", TracingSampleRatio: ratio(1.5)}, Config{}, true, true},
+		{"negative ratio", config.Settings{TracingSampleRatio: ratio(-1)}, Config{}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewConfig(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("NewConfig() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+			if got != tt.want {
+				t.Errorf("NewConfig() = %v, want %v", got, tt.want)
+			}
+			if got.Enabled() != tt.enabled {
+				t.Errorf("Enabled() = %v, want %v", got.Enabled(), tt.enabled)
+			}
+		})
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	recorder := recordSpans(t)
+
+	router := mux.NewRouter()
+	router.Use(Middleware)
+	router.HandleFunc("/v1/models/{id}", func(w http.ResponseWriter, r *http.Request) {
+		_, span := Start(r.Context(), "child")
+		End(span, errors.New("MOCK error"))
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	r := httptest.NewRequest("GET", "/v1/models/1", nil)
+	r.Header.Set("traceparent", traceparent)
+	router.ServeHTTP(httptest.NewRecorder(), r)
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Middleware() spans = %d, want 2", len(spans))
+	}
+	child, server := spans[0], spans[1]
+	if server.Name() != "GET /v1/models/{id}" {
+		t.Errorf("Middleware() span name = %v", server.Name())
+	}
+	if server.SpanContext().TraceID().String() != "4bf92f3577b34da6a3ce929d0e0e4736" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("Middleware() did not continue the trace of the client: %v", server.Parent())
+	}
+	if server.Status().Code != codes.Error {
+		t.Errorf("Middleware() status = %v", server.Status())
+	}
+	if child.Parent().SpanID() != server.SpanContext().SpanID() || child.Status().Code != codes.Error || len(child.Events()) != 1 {
+		t.Errorf("End() child span = %v, %v", child.Parent(), child.Status())
+	}
+}
+
+func TestUnaryServerInterceptor(t *testing.T) {
+	recorder := recordSpans(t)
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs("traceparent", traceparent))
+	info := &grpc.UnaryServerInfo{FullMethod: "/rpc.Signing/Serial"}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		_, span := Start(ctx, "child")
+		span.End()
+		return "ok", nil
+	}
+
+	resp, err := UnaryServerInterceptor(ctx, nil, info, handler)
+	if err != nil || resp != "ok" {
+		t.Fatalf("UnaryServerInterceptor() = %v, %v", resp, err)
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("UnaryServerInterceptor() spans = %d, want 2", len(spans))
+	}
+	server := spans[1]
+	if server.Name() != "/rpc.Signing/Serial" || server.Parent().SpanID().String() != "00f067aa0ba902b7" {
+		t.Errorf("UnaryServerInterceptor() span = %v, parent %v", server.Name(), server.Parent())
+	}
+	if spans[0].Parent().SpanID() != server.SpanContext().SpanID() {
+		t.Errorf("UnaryServerInterceptor() the child span is not in the call context")
+	}
+}
+
+func TestSampler(t *testing.T) {
+	parent := trace.ContextWithSpanContext(context.Background(), trace.NewSpanContext(trace.SpanContextConfig{
+		TraceID:    trace.TraceID{1},
+		SpanID:     trace.SpanID{1},
+		TraceFlags: trace.FlagsSampled,
+	}))
+
+	tests := []struct {
+		ratio float64
+		want  bool
+	}{
+		{0, false},
+		{1, true},
+	}
+	for _, tt := range tests {
+		provider := sdktrace.NewTracerProvider(sdktrace.WithSampler(sampler(tt.ratio)))
+		_, span := provider.Tracer("test").Start(parent, "child")
+		if span.SpanContext().IsSampled() != tt.want {
+			t.Errorf("sampler(%v) sampled = %v, want %v", tt.ratio, span.SpanContext().IsSampled(), tt.want)
+		}
+		span.End()
+	}
+}
+
+func TestTransport(t *testing.T) {
+	recorder := recordSpans(t)
+
+	var header string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	ctx, parent := Start(context.Background(), "parent")
+	r, _ := http.NewRequestWithContext(ctx, "GET", server.URL, nil)
+	client := &http.Client{Transport: Transport(http.DefaultTransport)}
+	resp, err := client.Do(r)
+	if err != nil {
+		t.Fatalf("Transport() error = %v", err)
+	}
+	resp.Body.Close()
+	parent.End()
+
+	if len(r.Header.Get("traceparent")) > 0 {
+		t.Error("Transport() must not change the request")
+	}
+
+	spans := recorder.Ended()
+	if len(spans) != 2 {
+		t.Fatalf("Transport() spans = %d, want 2", len(spans))
+	}
+	span := spans[0]
+	if span.Name() != "HTTP GET" || span.Parent().SpanID() != parent.SpanContext().SpanID() || span.Status().Code != codes.Error {
+		t.Errorf("Transport() span = %v, parent %v, status %v", span.Name(), span.Parent(), span.Status())
+	}
+	if !strings.Contains(header, span.SpanContext().SpanID().String()) {
+		t.Errorf("Transport() traceparent = %q, want the client span %v", header, span.SpanContext().SpanID())
+	}
+}
+
+func TestDetach(t *testing.T) {
+	recordSpans(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ctx, span := Start(ctx, "request")
+	defer span.End()
+	cancel()
+
+	detached := Detach(ctx)
+	if detached.Err() != nil {
+		t.Errorf("Detach() context is cancelled with the request: %v", detached.Err())
+	}
+	if trace.SpanContextFromContext(detached).SpanID() != span.SpanContext().SpanID() {
+		t.Error("Detach() did not keep the trace of the request")
+	}
+}
@@ -20,13 +20,18 @@
 package user
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/mailer"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
 	"github.com/CanonicalLtd/serial-vault/service/auth"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 	"github.com/CanonicalLtd/serial-vault/service/response"
+	"github.com/CanonicalLtd/serial-vault/usso"
 )
 
 // ListResponse is the response from a user list request
@@ -47,6 +52,36 @@ type GetResponse struct {
 	User         datastore.User `json:"user"`
 }
 
+// BulkResponse is the response from a bulk provisioning of users, with the result of each row
+type BulkResponse struct {
+	Success      bool                       `json:"success"`
+	ErrorCode    string                     `json:"error_code"`
+	ErrorSubcode string                     `json:"error_subcode"`
+	ErrorMessage string                     `json:"message"`
+	Results      []datastore.BulkUserResult `json:"results"`
+}
+
+// InviteResponse is the response from a user invitation. The invitation link is only
+// returned when it could not be emailed to the user
+type InviteResponse struct {
+	Success      bool                 `json:"success"`
+	ErrorCode    string               `json:"error_code"`
+	ErrorSubcode string               `json:"error_subcode"`
+	ErrorMessage string               `json:"message"`
+	Invitation   datastore.Invitation `json:"invitation"`
+	Emailed      bool                 `json:"emailed"`
+	Link         string               `json:"link,omitempty"`
+}
+
+// invitationBody is the email of a user invitation
+const invitationBody = `You have been invited by %s to the Serial Vault at %s.
+
+Log in with your Ubuntu SSO account using the link below to accept the invitation.
+The link can only be used once, and expires on %s.
+
+%s
+`
+
 // AccountsResponse is the JSON response from the API Accounts method
 type AccountsResponse struct {
 	Success      bool                `json:"success"`
@@ -57,7 +92,7 @@ type AccountsResponse struct {
 }
 
 // listHandler is the API method to fetch the user records
-func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
+func listHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -66,7 +101,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 		return
 	}
 
-	users, err := datastore.Environ.DB.ListUsers()
+	users, err := datastore.Environ.DB.ListUsers(ctx)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-users", "", err.Error(), w)
 		return
@@ -78,7 +113,7 @@ func listHandler(w http.ResponseWriter, user datastore.User, apiCall bool) {
 }
 
 // getHandler is the API method to fetch the log records from signing
-func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
+func getHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -87,7 +122,7 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID
 		return
 	}
 
-	u, err := datastore.Environ.DB.GetUser(userID)
+	u, err := datastore.Environ.DB.GetUser(ctx, userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-fetch-users", "", err.Error(), w)
 		return
@@ -98,7 +133,7 @@ func getHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID
 	formatUserResponse(u, w)
 }
 
-func createHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
+func createHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
@@ -107,18 +142,117 @@ func createHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool,
 		return
 	}
 
-	user.ID, err = datastore.Environ.DB.CreateUser(user)
+	user.ID, err = datastore.Environ.DB.CreateUser(ctx, user)
 	if err != nil {
 		log.Error("error-creating-user", err)
 		response.FormatStandardResponse(false, "error-creating-user", "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, authUser, audit.ActionCreate, audit.ObjectUser, user.ID, "", nil, user)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
+func bulkHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, users []datastore.User) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if len(users) == 0 || len(users) > datastore.MaxBulkUsers {
+		response.FormatStandardResponse(false, "error-user-data", "", fmt.Sprintf("Between 1 and %d users must be supplied", datastore.MaxBulkUsers), w)
+		return
+	}
+
+	// The results are returned when the users are rejected, to report the invalid rows
+	results, err := datastore.Environ.DB.BulkProvisionUsers(ctx, users)
+	if err != nil {
+		log.Error("error-bulk-users", err)
+		w.WriteHeader(http.StatusBadRequest)
+		formatBulkResponse(BulkResponse{ErrorCode: "error-bulk-users", ErrorMessage: err.Error(), Results: results}, w)
+		return
+	}
+
+	for _, r := range results {
+		action := audit.ActionCreate
+		if r.Action == datastore.BulkUserLink {
+			action = audit.ActionUpdate
+		}
+		audit.Record(ctx, authUser, action, audit.ObjectUser, r.UserID, "", nil, r)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatBulkResponse(BulkResponse{Success: true, Results: results}, w)
+}
+
+func formatBulkResponse(result BulkResponse, w http.ResponseWriter) {
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Error("error-user-response", err)
+	}
+}
+
+func inviteHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, email string, user datastore.User) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	// Without the user authentication, the invitation is made by the vault
+	invitedBy := authUser.Username
+	if len(invitedBy) == 0 {
+		invitedBy = "serial-vault"
+	}
+
+	inv, err := datastore.Environ.DB.CreateInvitation(ctx, datastore.Invitation{Email: email, InvitedBy: invitedBy}, user)
+	if err != nil {
+		log.Error("error-inviting-user", err)
+		response.FormatStandardResponse(false, "error-inviting-user", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, authUser, audit.ActionInvite, audit.ObjectUser, inv.UserID, "", nil, inv)
+
+	token, err := usso.NewInvitationToken(inv)
+	if err != nil {
+		log.Error("error-inviting-user", err)
+		response.FormatStandardResponse(false, "error-inviting-user", "", err.Error(), w)
+		return
+	}
+	link := usso.InvitationLink(token)
+
+	// The link is returned when the email cannot be sent, so it can be given to the user
+	result := InviteResponse{Success: true, Invitation: inv}
+	if err = sendInvitation(inv, link); err != nil {
+		log.Errorf("Error sending the invitation email to %s: %v", inv.Email, err)
+		result.Link = link
+	} else {
+		result.Emailed = true
+	}
+
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Errorf("Error forming the invitation response: %v", err)
+	}
+}
+
+// sendInvitation emails the invitation link to the invited user
+func sendInvitation(inv datastore.Invitation, link string) error {
+	cfg, err := mailer.NewConfig(datastore.Environ.Config)
+	if err != nil {
+		return err
+	}
+
+	body := fmt.Sprintf(invitationBody, inv.InvitedBy, datastore.Environ.Config.URLHost, inv.Expires.Format("2 January 2006"), link)
+	return mailer.Send(cfg, inv.Email, "Invitation to the Serial Vault", body)
+}
+
 func formatListResponse(users []datastore.User, w http.ResponseWriter) error {
 	response := ListResponse{Success: true, Users: users}
 
@@ -141,7 +275,7 @@ func formatUserResponse(user datastore.User, w http.ResponseWriter) error {
 	return nil
 }
 
-func updateHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
+func updateHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, user datastore.User) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
@@ -150,19 +284,23 @@ func updateHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool,
 		return
 	}
 
-	err = datastore.Environ.DB.UpdateUser(user)
+	// The user before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetUser(ctx, user.ID)
+
+	err = datastore.Environ.DB.UpdateUser(ctx, user)
 	if err != nil {
-		log.Println("Error updating the store:", err)
+		log.Errorf("Error updating the store: %v", err)
 		response.FormatStandardResponse(false, "error-stores-substore", "", "Error updating the store", w)
 		return
 	}
+	audit.Record(ctx, authUser, audit.ActionUpdate, audit.ObjectUser, user.ID, "", before, user)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
+func deleteHandler(ctx context.Context, w http.ResponseWriter, user datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(user, datastore.Superuser, apiCall)
@@ -171,18 +309,86 @@ func deleteHandler(w http.ResponseWriter, user datastore.User, apiCall bool, use
 		return
 	}
 
-	err = datastore.Environ.DB.DeleteUser(userID)
+	// The user before the change, for the audit log
+	before, _ := datastore.Environ.DB.GetUser(ctx, userID)
+
+	err = datastore.Environ.DB.DeleteUser(ctx, userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-deleting-user", "", err.Error(), w)
 		return
 	}
+	audit.Record(ctx, user, audit.ActionDelete, audit.ObjectUser, userID, "", before, nil)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// setActiveHandler deactivates or reactivates a user. A deactivated user keeps the account
+// links and the audit trail, but is rejected at login and by the APIs
+func setActiveHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int, active bool) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	before, err := datastore.Environ.DB.GetUser(ctx, userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-get-user", "", err.Error(), w)
+		return
+	}
+
+	// A superuser cannot lock themselves out
+	if !active && before.Username == authUser.Username {
+		response.FormatStandardResponse(false, "error-deactivate-user", "", "You cannot deactivate your own user", w)
+		return
+	}
+
+	err = datastore.Environ.DB.SetUserActive(ctx, userID, active)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-deactivate-user", "", err.Error(), w)
+		return
+	}
+
+	after := before
+	after.Active = active
+	action := audit.ActionDisable
+	if active {
+		action = audit.ActionEnable
+	}
+	audit.Record(ctx, authUser, action, audit.ObjectUser, userID, "", before, after)
+
+	// Return successful JSON response
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+func revokeSessionsHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	// The sessions are revoked by the user, or by a superuser
+	u, err := checkTokenOwner(ctx, authUser, apiCall, userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err := usso.RevokeUserSessions(ctx, u.Username); err != nil {
+		log.Error("error-revoke-sessions", err)
+		response.FormatStandardResponse(false, "error-revoke-sessions", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, authUser, audit.ActionRevoke, audit.ObjectUser, userID, "", nil, nil)
 
 	// Return successful JSON response
 	w.WriteHeader(http.StatusOK)
 	response.FormatStandardResponse(true, "", "", "", w)
 }
 
-func getOtherAccountsHandler(w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
+func getOtherAccountsHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 
 	err := auth.CheckUserPermissions(authUser, datastore.Superuser, apiCall)
@@ -191,13 +397,13 @@ func getOtherAccountsHandler(w http.ResponseWriter, authUser datastore.User, api
 		return
 	}
 
-	user, err := datastore.Environ.DB.GetUser(userID)
+	user, err := datastore.Environ.DB.GetUser(ctx, userID)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-get-user", "", err.Error(), w)
 		return
 	}
 
-	accounts, err := datastore.Environ.DB.ListNotUserAccounts(user.Username)
+	accounts, err := datastore.Environ.DB.ListNotUserAccounts(ctx, user.Username)
 	if err != nil {
 		response.FormatStandardResponse(false, "error-get-non-user-accounts", "", err.Error(), w)
 		return
@@ -213,7 +419,7 @@ func formatAccountsResponse(accounts []datastore.Account, w http.ResponseWriter)
 
 	// Encode the response as JSON
 	if err := json.NewEncoder(w).Encode(response); err != nil {
-		log.Println("Error forming the accounts response.")
+		log.Error("Error forming the accounts response.")
 		return err
 	}
 	return nil
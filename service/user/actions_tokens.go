@@ -0,0 +1,138 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/audit"
+	"github.com/CanonicalLtd/serial-vault/service/auth"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/response"
+)
+
+// TokenListResponse is the response from a personal access token list request
+type TokenListResponse struct {
+	Success      bool                    `json:"success"`
+	ErrorCode    string                  `json:"error_code"`
+	ErrorSubcode string                  `json:"error_subcode"`
+	ErrorMessage string                  `json:"message"`
+	Tokens       []datastore.AccessToken `json:"tokens"`
+}
+
+// TokenResponse is the response from a personal access token creation
+type TokenResponse struct {
+	Success      bool                  `json:"success"`
+	ErrorCode    string                `json:"error_code"`
+	ErrorSubcode string                `json:"error_subcode"`
+	ErrorMessage string                `json:"message"`
+	Token        datastore.AccessToken `json:"token"`
+}
+
+func tokenListHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if _, err := checkTokenOwner(ctx, authUser, apiCall, userID); err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	tokens, err := datastore.Environ.DB.ListAccessTokens(ctx, userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-fetch-tokens", "", err.Error(), w)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	formatTokenResponse(TokenListResponse{Success: true, Tokens: tokens}, w)
+}
+
+func tokenCreateHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID int, t datastore.AccessToken) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	u, err := checkTokenOwner(ctx, authUser, apiCall, userID)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	t.UserID = u.ID
+	t.Username = u.Username
+	t, err = datastore.Environ.DB.CreateAccessToken(ctx, t)
+	if err != nil {
+		log.Error("error-creating-token", err)
+		response.FormatStandardResponse(false, "error-creating-token", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, authUser, audit.ActionCreate, audit.ObjectAccessToken, t.ID, "", nil, t)
+
+	w.WriteHeader(http.StatusOK)
+	formatTokenResponse(TokenResponse{Success: true, Token: t}, w)
+}
+
+func tokenDeleteHandler(ctx context.Context, w http.ResponseWriter, authUser datastore.User, apiCall bool, userID, tokenID int) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+
+	if _, err := checkTokenOwner(ctx, authUser, apiCall, userID); err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", "", w)
+		return
+	}
+
+	if err := datastore.Environ.DB.DeleteAccessToken(ctx, userID, tokenID); err != nil {
+		log.Error("error-deleting-token", err)
+		response.FormatStandardResponse(false, "error-deleting-token", "", err.Error(), w)
+		return
+	}
+	audit.Record(ctx, authUser, audit.ActionDelete, audit.ObjectAccessToken, tokenID, "", nil, nil)
+
+	w.WriteHeader(http.StatusOK)
+	response.FormatStandardResponse(true, "", "", "", w)
+}
+
+// checkTokenOwner checks that the personal access tokens of the user are managed by the user,
+// or by a superuser, and returns the user
+func checkTokenOwner(ctx context.Context, authUser datastore.User, apiCall bool, userID int) (datastore.User, error) {
+	if err := auth.CheckUserPermissions(authUser, datastore.Standard, apiCall); err != nil {
+		return datastore.User{}, err
+	}
+
+	u, err := datastore.Environ.DB.GetUser(ctx, userID)
+	if err != nil {
+		return u, err
+	}
+
+	if datastore.Environ.Config.EnableUserAuth && authUser.Role < datastore.Superuser && authUser.Username != u.Username {
+		return u, errors.New("The user is not authorized")
+	}
+	return u, nil
+}
+
+func formatTokenResponse(response interface{}, w http.ResponseWriter) error {
+	// Encode the response as JSON
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		log.Error("Error forming the access token response.")
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2017-2018 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package user_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service"
+	"github.com/CanonicalLtd/serial-vault/service/user"
+	check "gopkg.in/check.v1"
+)
+
+func (s *ServiceSuite) TestTokenListHandler(c *check.C) {
+	w := sendAdminRequest("GET", "/v1/users/3/tokens", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := user.TokenListResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Tokens, check.HasLen, 1)
+	c.Assert(result.Tokens[0].Prefix, check.Equals, "svpat_Stev")
+	c.Assert(result.Tokens[0].Token, check.Equals, "")
+}
+
+func (s *ServiceSuite) TestTokenCreateHandler(c *check.C) {
+	data := []byte(`{"name":"Release script", "scopes":["models"]}`)
+
+	// The JWT of the tests is for the root user
+	w := sendAdminRequest("POST", "/v1/users/5/tokens", bytes.NewReader(data), datastore.Standard, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := user.TokenResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Token.Token, check.Equals, "svpat_Generated")
+	c.Assert(result.Token.Username, check.Equals, "root")
+	c.Assert(result.Token.Expires.IsZero(), check.Equals, false)
+}
+
+func (s *ServiceSuite) TestTokenHandlers(c *check.C) {
+	tests := []UserTest{
+		{"GET", "/v1/users/5/tokens", nil, 200, "application/json; charset=UTF-8", datastore.Standard, true, true, 0},
+		{"GET", "/v1/users/3/tokens", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"GET", "/v1/users/99/tokens", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`{"name":"Release script", "scopes":["models"]}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`{"name":"Release script", "scopes":["users"]}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`{"name":"", "scopes":["models"]}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`{"name":"Release script", "scopes":["models"], "expires":"2000-01-01T00:00:00Z"}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`{"name":"Release script", "scopes":["models"], "expires":"2999-01-01T00:00:00Z"}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", []byte(`invalid`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/3/tokens", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"DELETE", "/v1/users/3/tokens/1", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"DELETE", "/v1/users/3/tokens/2", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"DELETE", "/v1/users/3/tokens/1", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s %s %s", t.Method, t.URL, t.Data))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := user.TokenListResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(len(result.Tokens), check.Equals, t.List)
+	}
+}
+
+func (s *ServiceSuite) TestTokenHandlersWithError(c *check.C) {
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+
+	w := sendAdminRequest("GET", "/v1/users/3/tokens", nil, datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusBadRequest)
+}
+
+func (s *ServiceSuite) TestAccessTokenAuth(c *check.C) {
+	tests := []struct {
+		method string
+		url    string
+		token  string
+		code   int
+	}{
+		{"GET", "/v1/models", "svpat_SteveToken", http.StatusOK},
+		{"GET", "/v1/keypairs", "svpat_SteveToken", http.StatusOK},
+		{"GET", "/v1/signinglog", "svpat_SteveToken", http.StatusBadRequest},
+		{"GET", "/v1/users", "svpat_SteveToken", http.StatusBadRequest},
+		{"GET", "/v1/users/3/tokens", "svpat_SteveToken", http.StatusBadRequest},
+		{"GET", "/v1/models", "svpat_Invalid", http.StatusBadRequest},
+		{"GET", "/api/keypairs", "svpat_SteveToken", http.StatusOK},
+		{"POST", "/api/keypairs/sync", "svpat_SteveToken", http.StatusBadRequest},
+	}
+
+	for _, t := range tests {
+		w := httptest.NewRecorder()
+		r, _ := http.NewRequest(t.method, t.url, nil)
+		r.Header.Set("Authorization", "Bearer "+t.token)
+		service.AdminRouter().ServeHTTP(w, r)
+		c.Assert(w.Code, check.Equals, t.code, check.Commentf("%s %s", t.method, t.url))
+	}
+}
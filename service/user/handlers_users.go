@@ -39,6 +39,9 @@ type Request struct {
 	APIKey   string   `json:"api_key"`
 	Role     int      `json:"role"`
 	Accounts []string `json:"accounts"`
+
+	// AccountRoles overrides the role for some of the accounts, keyed by authority ID
+	AccountRoles map[string]int `json:"account_roles"`
 }
 
 // List is the API method to fetch the users
@@ -49,7 +52,7 @@ func List(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	listHandler(w, authUser, false)
+	listHandler(r.Context(), w, authUser, false)
 }
 
 // Get is the API method to fetch a user
@@ -67,7 +70,7 @@ func Get(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getHandler(w, authUser, false, id)
+	getHandler(r.Context(), w, authUser, false, id)
 }
 
 // Create is the API method to create a sub-store model
@@ -102,9 +105,90 @@ func Create(w http.ResponseWriter, r *http.Request) {
 		Role:     userRequest.Role,
 		APIKey:   userRequest.APIKey,
 		Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
+
+		AccountRoles: userRequest.AccountRoles,
 	}
 
-	createHandler(w, authUser, false, user)
+	createHandler(r.Context(), w, authUser, false, user)
+}
+
+// Bulk is the API method to provision a list of users with their accounts, e.g. to onboard
+// the team of a new brand. The users are provisioned in a single transaction
+func Bulk(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	userRequests := []Request{}
+	err = json.NewDecoder(r.Body).Decode(&userRequests)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-user-data", "", "No user data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	users := []datastore.User{}
+	for _, userRequest := range userRequests {
+		users = append(users, datastore.User{
+			Username: userRequest.Username,
+			Name:     userRequest.Name,
+			Email:    userRequest.Email,
+			Role:     userRequest.Role,
+			APIKey:   userRequest.APIKey,
+			Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
+
+			AccountRoles: userRequest.AccountRoles,
+		})
+	}
+
+	bulkHandler(r.Context(), w, authUser, false, users)
+}
+
+// Invite is the API method to invite a user by email, with the role and the accounts of the
+// user. The invited user gets the username of the first login with the invitation link
+func Invite(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	defer r.Body.Close()
+
+	// Decode the JSON body
+	userRequest := Request{}
+	err = json.NewDecoder(r.Body).Decode(&userRequest)
+	switch {
+	// Check we have some data
+	case err == io.EOF:
+		response.FormatStandardResponse(false, "error-user-data", "", "No user data supplied.", w)
+		return
+		// Check for parsing errors
+	case err != nil:
+		response.FormatStandardResponse(false, "error-decode-json", "", err.Error(), w)
+		return
+	}
+
+	// The pending user, without the username
+	user := datastore.User{
+		Name:     userRequest.Name,
+		Role:     userRequest.Role,
+		Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
+
+		AccountRoles: userRequest.AccountRoles,
+	}
+
+	inviteHandler(r.Context(), w, authUser, false, userRequest.Email, user)
 }
 
 // Update is the API method to update a user
@@ -149,9 +233,11 @@ func Update(w http.ResponseWriter, r *http.Request) {
 		Role:     userRequest.Role,
 		APIKey:   userRequest.APIKey,
 		Accounts: datastore.BuildAccountsFromAuthorityIDs(userRequest.Accounts),
+
+		AccountRoles: userRequest.AccountRoles,
 	}
 
-	updateHandler(w, authUser, false, user)
+	updateHandler(r.Context(), w, authUser, false, user)
 }
 
 // Delete is the API method to delete a user
@@ -171,7 +257,53 @@ func Delete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	deleteHandler(w, authUser, false, userID)
+	deleteHandler(r.Context(), w, authUser, false, userID)
+}
+
+// Deactivate is the API method to deactivate a user, instead of deleting it
+func Deactivate(w http.ResponseWriter, r *http.Request) {
+	setActive(w, r, false)
+}
+
+// Activate is the API method to reactivate a deactivated user
+func Activate(w http.ResponseWriter, r *http.Request) {
+	setActive(w, r, true)
+}
+
+func setActive(w http.ResponseWriter, r *http.Request, active bool) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-user", "", err.Error(), w)
+		return
+	}
+
+	setActiveHandler(r.Context(), w, authUser, false, userID, active)
+}
+
+// RevokeSessions is the API method to revoke all the sessions of a user, so any JWT or
+// refresh token that has been issued to the user is rejected before it expires
+func RevokeSessions(w http.ResponseWriter, r *http.Request) {
+	authUser, err := auth.GetUserFromJWT(w, r)
+	if err != nil {
+		response.FormatStandardResponse(false, "error-auth", "", err.Error(), w)
+		return
+	}
+
+	vars := mux.Vars(r)
+	userID, err := strconv.Atoi(vars["id"])
+	if err != nil {
+		response.FormatStandardResponse(false, "error-invalid-user", "", err.Error(), w)
+		return
+	}
+
+	revokeSessionsHandler(r.Context(), w, authUser, false, userID)
 }
 
 // GetOtherAccounts is the API method to retrieve accounts not belonging to the user
@@ -193,5 +325,5 @@ func GetOtherAccounts(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	getOtherAccountsHandler(w, authUser, false, id)
+	getOtherAccountsHandler(r.Context(), w, authUser, false, id)
 }
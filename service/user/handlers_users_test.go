@@ -26,6 +26,7 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/CanonicalLtd/serial-vault/service/log"
@@ -59,7 +60,7 @@ var _ = check.Suite(&ServiceSuite{})
 
 func (s *ServiceSuite) SetUpTest(c *check.C) {
 	// Mock the database
-	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue"}
+	config := config.Settings{KeyStoreType: "filesystem", KeyStorePath: "../keystore", JwtSecret: "SomeTestSecretValue", URLScheme: "http", URLHost: "vault.example.com"}
 	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
 	datastore.OpenKeyStore(config)
 
@@ -436,3 +437,147 @@ func createJWTWithRole(r *http.Request, role int) error {
 	r.Header.Set("Authorization", "Bearer "+jwtToken)
 	return nil
 }
+
+func (s *ServiceSuite) TestInviteUserHandler(c *check.C) {
+	data := []byte(`{"email":"jane@example.com", "role":100, "accounts":["authority1"]}`)
+	w := sendAdminRequest("POST", "/v1/users/invite", bytes.NewReader(data), datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := user.InviteResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Invitation.Email, check.Equals, "jane@example.com")
+	c.Assert(result.Invitation.InvitedBy, check.Equals, "root")
+
+	// The SMTP server is not configured, so the link is returned
+	c.Assert(result.Emailed, check.Equals, false)
+	invitationID, err := usso.VerifyInvitationToken(strings.TrimPrefix(result.Link, "http://vault.example.com/login?invite="))
+	c.Assert(err, check.IsNil)
+	c.Assert(invitationID, check.Equals, result.Invitation.ID)
+}
+
+func (s *ServiceSuite) TestInviteUserHandlerInvalid(c *check.C) {
+	tests := []UserTest{
+		{"POST", "/v1/users/invite", []byte(`{"email":"jane@example.com", "role":100}`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/users/invite", []byte(`{"email":"invalid", "role":100}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/invite", []byte(`{"email":"jane@example.com", "role":1}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/invite", []byte(`{"email":"jane@example.com", "role":100, "account_roles":{"authority1":200}}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/invite", []byte(`invalid`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/invite", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s", t.Data))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := user.InviteResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *ServiceSuite) TestBulkUserHandler(c *check.C) {
+	data := []byte(`[
+		{"username":"jdoe", "name":"Jane Doe", "email":"jane@example.com", "role":200, "accounts":["system", "vendor"], "account_roles":{"vendor":100}},
+		{"username":"user1", "accounts":["generic"]}
+	]`)
+	w := sendAdminRequest("POST", "/v1/users/bulk", bytes.NewReader(data), datastore.Superuser, c)
+	c.Assert(w.Code, check.Equals, http.StatusOK)
+
+	result := user.BulkResponse{}
+	err := json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Results, check.HasLen, 2)
+	c.Assert(result.Results[0].Action, check.Equals, datastore.BulkUserCreate)
+	c.Assert(result.Results[0].UserID, check.Equals, 740)
+	c.Assert(result.Results[0].Accounts, check.DeepEquals, []string{"system", "vendor"})
+	c.Assert(result.Results[1].Action, check.Equals, datastore.BulkUserLink)
+	c.Assert(result.Results[1].UserID, check.Equals, 1)
+}
+
+func (s *ServiceSuite) TestBulkUserHandlerInvalid(c *check.C) {
+	tests := []UserTest{
+		{"POST", "/v1/users/bulk", []byte(`[{"username":"jdoe", "name":"Jane Doe", "email":"jane@example.com", "role":100}]`), 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+		{"POST", "/v1/users/bulk", []byte(`[{"username":"jdoe", "name":"Jane Doe", "email":"jane@example.com", "role":100}, {"username":"jsmith", "name":"John Smith", "email":"invalid", "role":100}]`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 2},
+		{"POST", "/v1/users/bulk", []byte(`[{"username":"jdoe", "name":"Jane Doe", "email":"jane@example.com", "role":100, "accounts":["unknown"]}]`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 1},
+		{"POST", "/v1/users/bulk", []byte(`[]`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/bulk", []byte(`{"username":"jdoe"}`), 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/bulk", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s", t.Data))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := user.BulkResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+		c.Assert(result.Results, check.HasLen, t.List)
+	}
+}
+
+func (s *ServiceSuite) TestDeactivateUserHandler(c *check.C) {
+	tests := []UserTest{
+		{"POST", "/v1/users/2/deactivate", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"POST", "/v1/users/2/activate", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"POST", "/v1/users/5/deactivate", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/99/deactivate", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+		{"POST", "/v1/users/2/deactivate", nil, 400, "application/json; charset=UTF-8", datastore.Admin, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s", t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := user.GetResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *ServiceSuite) TestRevokeSessionsHandler(c *check.C) {
+	tests := []UserTest{
+		{"POST", "/v1/users/2/sessions/revoke", nil, 200, "application/json; charset=UTF-8", datastore.Superuser, true, true, 0},
+		{"POST", "/v1/users/5/sessions/revoke", nil, 200, "application/json; charset=UTF-8", datastore.Standard, true, true, 0},
+		{"POST", "/v1/users/2/sessions/revoke", nil, 400, "application/json; charset=UTF-8", datastore.Standard, true, false, 0},
+		{"POST", "/v1/users/99/sessions/revoke", nil, 400, "application/json; charset=UTF-8", datastore.Superuser, true, false, 0},
+	}
+
+	for _, t := range tests {
+		w := sendAdminRequest(t.Method, t.URL, bytes.NewReader(t.Data), t.Permissions, c)
+		c.Assert(w.Code, check.Equals, t.Code, check.Commentf("%s", t.URL))
+		c.Assert(w.Header().Get("Content-Type"), check.Equals, t.Type)
+
+		result := user.GetResponse{}
+		err := json.NewDecoder(w.Body).Decode(&result)
+		c.Assert(err, check.IsNil)
+		c.Assert(result.Success, check.Equals, t.Success)
+	}
+}
+
+func (s *ServiceSuite) TestInactiveUserJWT(c *check.C) {
+	// The JWT is for a user that is not active in the database
+	sreg := map[string]string{"nickname": "unknown", "fullname": "Unknown User", "email": "unknown@example.com"}
+	resp := openid.Response{ID: "identity", Teams: []string{}, SReg: sreg}
+	jwtToken, err := usso.NewJWTToken(&resp, datastore.Superuser)
+	c.Assert(err, check.IsNil)
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", "/v1/users", nil)
+	r.Header.Set("Authorization", "Bearer "+jwtToken)
+	service.AdminRouter().ServeHTTP(w, r)
+
+	result := user.ListResponse{}
+	err = json.NewDecoder(w.Body).Decode(&result)
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, false)
+	c.Assert(result.ErrorCode, check.Equals, "error-auth")
+}
@@ -0,0 +1,67 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package shutdown
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"google.golang.org/grpc"
+)
+
+// defaultTimeout is the time to wait for the requests in progress when it is not configured
+const defaultTimeout = 30 * time.Second
+
+// Timeout returns the time to wait for the requests in progress from the config settings
+func Timeout(settings config.Settings) (time.Duration, error) {
+	if len(settings.ShutdownTimeout) == 0 {
+		return defaultTimeout, nil
+	}
+
+	timeout, err := time.ParseDuration(settings.ShutdownTimeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid shutdown timeout: %v", err)
+	}
+	if timeout <= 0 {
+		return 0, fmt.Errorf("the shutdown timeout must be positive")
+	}
+	return timeout, nil
+}
+
+// StopGRPC stops the gRPC service from accepting connections and waits for the requests in progress.
+// The remaining requests are cancelled when the context is done
+func StopGRPC(ctx context.Context, srv *grpc.Server) error {
+	stopped := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,75 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package shutdown
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"google.golang.org/grpc"
+)
+
+func TestTimeout(t *testing.T) {
+	tests := []struct {
+		name     string
+		timeout  string
+		expected time.Duration
+		wantErr  bool
+	}{
+		{"default", "", defaultTimeout, false},
+		{"valid", "1m", time.Minute, false},
+		{"invalid", "soon", 0, true},
+		{"zero", "0s", 0, true},
+		{"negative", "-5s", 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Timeout(config.Settings{ShutdownTimeout: tt.timeout})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Timeout() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.expected {
+				t.Errorf("Timeout() = %v, expected %v", got, tt.expected)
+			}
+		})
+	}
+}
+
+func TestStopGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error listening: %v", err)
+	}
+
+	srv := grpc.NewServer()
+	go srv.Serve(lis)
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := StopGRPC(ctx, srv); err != nil {
+		t.Errorf("Expected the gRPC service to stop, got: %v", err)
+	}
+}
@@ -24,6 +24,8 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"time"
 
@@ -31,6 +33,7 @@ import (
 
 	"gopkg.in/macaroon.v1"
 
+	"github.com/CanonicalLtd/serial-vault/account"
 	"github.com/CanonicalLtd/serial-vault/datastore"
 
 	"github.com/snapcore/snapd/asserts"
@@ -64,6 +67,9 @@ type Auth struct {
 	OTP      string `json:"otp"`
 }
 
+// ErrNoCredentials is returned when the store credentials are not configured
+var ErrNoCredentials = errors.New("The store credentials are not configured")
+
 // KeyRegister is the request to submit a signing-key to the store
 type KeyRegister struct {
 	Auth
@@ -79,7 +85,7 @@ var RegisterKey = func(keyAuth KeyRegister, keypair datastore.Keypair) error {
 	permissions := []string{"edit_account", "modify_account_key"}
 	m, discharge, err := LoginUser(keyAuth.Email, keyAuth.Password, keyAuth.OTP, permissions)
 	if err != nil {
-		log.Println("Error logging in to store", err)
+		log.Errorf("Error logging in to store %v", err)
 		return errors.New("Error logging in to store")
 	}
 
@@ -95,13 +101,60 @@ var RegisterKey = func(keyAuth KeyRegister, keypair datastore.Keypair) error {
 		return err
 	}
 
+	return submitAccountKeyRequest(authHeader, assert)
+}
+
+// HasCredentials checks whether the store credentials are configured, so the generated keys are
+// registered with the store
+func HasCredentials() bool {
+	return len(datastore.Environ.Config.StoreMacaroon) > 0 && len(datastore.Environ.Config.StoreDischarge) > 0
+}
+
+// RegisterKeyWithCredentials registers the account-key-request of a keypair with the store, using
+// the configured store credentials, and returns the account-key assertion issued by the store
+var RegisterKeyWithCredentials = func(keypair datastore.Keypair) (string, error) {
+	if !HasCredentials() {
+		return "", ErrNoCredentials
+	}
+
+	// Generate the authorization header from the configured macaroons
+	authHeader, err := AuthorizationHeader(datastore.Environ.Config.StoreMacaroon, datastore.Environ.Config.StoreDischarge)
+	if err != nil {
+		return "", fmt.Errorf("Error in the store credentials: %v", err)
+	}
+
+	keyName := keypair.KeyName
+	if len(keyName) == 0 {
+		keyName = keypair.AuthorityID
+	}
+	assert, err := generateAccountKeyRequest(KeyRegister{AuthorityID: keypair.AuthorityID, KeyName: keyName}, keypair)
+	if err != nil {
+		return "", err
+	}
+
+	if err = submitAccountKeyRequest(authHeader, assert); err != nil {
+		return "", err
+	}
+
+	// The store issues the account-key assertion, which is fetched from the assertions service
+	accountKey, err := account.FetchAssertionFromStore(asserts.AccountKeyType, []string{keypair.KeyID})
+	if err != nil {
+		log.Errorf("Error fetching the account-key assertion: %v", err)
+		return "", fmt.Errorf("The key is registered, but the account-key assertion cannot be fetched: %v", err)
+	}
+
+	return string(asserts.Encode(accountKey)), nil
+}
+
+// submitAccountKeyRequest submits the account-key-request assertion to the store
+func submitAccountKeyRequest(authHeader, assert string) error {
 	// The assertion needs to be sent as JSON (undocumented)
 	data := map[string]string{
-		"account_key_request": string(assert),
+		"account_key_request": assert,
 	}
 	d, err := json.Marshal(data)
 	if err != nil {
-		log.Printf("Error marshalling account-key assertion: %v", err)
+		log.Errorf("Error marshalling account-key assertion: %v", err)
 		return err
 	}
 
@@ -111,11 +164,18 @@ var RegisterKey = func(keyAuth KeyRegister, keypair datastore.Keypair) error {
 		"Content-Type":  "application/json",
 		"Accept":        "application/json",
 	}
-	_, err = submitPOSTRequest(storeBaseURL+"account/account-key", headers, d)
+	resp, err := submitPOSTRequest(storeBaseURL+"account/account-key", headers, d)
 	if err != nil {
-		log.Printf("Error submitting the account-key assertion: %v", err)
+		log.Errorf("Error submitting the account-key assertion: %v", err)
 		return err
 	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		log.Errorf("Error registering the account-key with the store: %s: %s", resp.Status, body)
+		return fmt.Errorf("The store rejected the account-key request: %s", resp.Status)
+	}
 
 	return nil
 }
@@ -128,20 +188,20 @@ func LoginUser(username, password, otp string, permissions []string) (string, st
 	}
 	deserializedMacaroon, err := auth.MacaroonDeserialize(macaroon)
 	if err != nil {
-		log.Println("Error deserializing macaroon:", err)
+		log.Errorf("Error deserializing macaroon: %v", err)
 		return "", "", err
 	}
 
 	// get SSO 3rd party caveat, and request discharge
 	loginCaveat, err := loginCaveatID(deserializedMacaroon)
 	if err != nil {
-		log.Println("Error with login caveat:", err)
+		log.Errorf("Error with login caveat: %v", err)
 		return "", "", err
 	}
 
 	discharge, err := dischargeAuthCaveat(loginCaveat, username, password, otp)
 	if err != nil {
-		log.Println("Error with discharge:", err)
+		log.Errorf("Error with discharge: %v", err)
 		return "", "", err
 	}
 
@@ -171,25 +231,25 @@ func generateAccountKeyRequest(keyAuth KeyRegister, keypair datastore.Keypair) (
 	// Loads the keypair into the memory keystore
 	err := datastore.Environ.KeypairDB.LoadKeypair(keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
-		log.Println("Error loading the keypair", err)
+		log.Errorf("Error loading the keypair %v", err)
 		return "", err
 	}
 
 	// Get the public key as it is the body of the assertion
 	publicKey, err := datastore.Environ.KeypairDB.PublicKey(keypair.KeyID)
 	if err != nil {
-		log.Println("Error fetching the public key", err)
+		log.Errorf("Error fetching the public key %v", err)
 		return "", err
 	}
 	pubKeyEncoded, err := asserts.EncodePublicKey(publicKey)
 	if err != nil {
-		log.Println("Error encoding the public key", err)
+		log.Errorf("Error encoding the public key %v", err)
 		return "", err
 	}
 
 	accountKey, err := datastore.Environ.KeypairDB.SignAssertion(asserts.AccountKeyRequestType, headers, pubKeyEncoded, keypair.AuthorityID, keypair.KeyID, keypair.SealedKey)
 	if err != nil {
-		log.Printf("Error creating account-key assertion: %v", err)
+		log.Errorf("Error creating account-key assertion: %v", err)
 		return "", err
 	}
 
@@ -202,7 +262,7 @@ func requestStoreMacaroon(permissions []string) (string, error) {
 	perm := Permissions{Permissions: permissions}
 	macaroonJSONData, err := json.Marshal(perm)
 	if err != nil {
-		log.Println("Error marshalling the macaroon", err)
+		log.Errorf("Error marshalling the macaroon %v", err)
 		return "", err
 	}
 
@@ -212,7 +272,7 @@ func requestStoreMacaroon(permissions []string) (string, error) {
 	}
 	r, err := submitPOSTRequest(storeBaseURL+"acl/", headers, macaroonJSONData)
 	if err != nil {
-		log.Printf("Error submitting the ACL request: %v", err)
+		log.Errorf("Error submitting the ACL request: %v", err)
 		return "", err
 	}
 
@@ -221,7 +281,7 @@ func requestStoreMacaroon(permissions []string) (string, error) {
 	acl := ACL{}
 	err = json.NewDecoder(r.Body).Decode(&acl)
 	if err != nil {
-		log.Printf("Error decoding the ACL request: %v", err)
+		log.Errorf("Error decoding the ACL request: %v", err)
 		return "", err
 	}
 
@@ -296,7 +356,7 @@ func requestDischargeMacaroon(endpoint string, data map[string]string) (string,
 func postRequestDecodeJSON(url string, data []byte) (*http.Response, error) {
 	resp, err := http.Post(url, "application/json", bytes.NewBuffer(data))
 	if err != nil {
-		log.Printf("Error sending request: %v", err)
+		log.Errorf("Error sending request: %v", err)
 	}
 	return resp, err
 }
@@ -307,13 +367,13 @@ func AuthorizationHeader(macaroon, discharge string) (string, error) {
 
 	root, err := auth.MacaroonDeserialize(macaroon)
 	if err != nil {
-		log.Printf("Error deserializing macaroon: %v", err)
+		log.Errorf("Error deserializing macaroon: %v", err)
 		return "", err
 	}
 
 	dischargeMacaroon, err := auth.MacaroonDeserialize(discharge)
 	if err != nil {
-		log.Printf("Error deserializing discharge: %v", err)
+		log.Errorf("Error deserializing discharge: %v", err)
 		return "", err
 	}
 
@@ -321,12 +381,12 @@ func AuthorizationHeader(macaroon, discharge string) (string, error) {
 
 	serializedMacaroon, err := auth.MacaroonSerialize(root)
 	if err != nil {
-		log.Printf("Error serializing root macaroon: %v", err)
+		log.Errorf("Error serializing root macaroon: %v", err)
 		return "", err
 	}
 	serializedDischarge, err := auth.MacaroonSerialize(dischargeMacaroon)
 	if err != nil {
-		log.Printf("Error serializing discharge macaroon: %v", err)
+		log.Errorf("Error serializing discharge macaroon: %v", err)
 		return "", err
 	}
 
@@ -21,8 +21,10 @@
 package sync
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"net/http"
 
 	"github.com/CanonicalLtd/serial-vault/crypt"
@@ -33,7 +35,7 @@ import (
 
 // Client is the sync interface for the serial vault
 type Client interface {
-	Accounts() error
+	Accounts(ctx context.Context) error
 }
 
 // FactoryClient is the implementation of the factory sync for the serial vault
@@ -41,20 +43,30 @@ type FactoryClient struct {
 	URL      string
 	Username string
 	APIKey   string
+
+	// The records that were synced and the conflicts, of the resource that is synced
+	synced    int
+	conflicts []Conflict
 }
 
 // NewFactoryClient creates a factory client to sync data with the cloud serial-vault
 func NewFactoryClient(url, username, apiKey string) *FactoryClient {
-	hclient = http.Client{}
+	hclient = http.Client{Timeout: requestTimeout}
 	return &FactoryClient{
 		URL: url, Username: username, APIKey: apiKey,
 	}
 }
 
+// conflict records a record that was not synced, so the sync goes on with the other records.
+// The conflicts are logged, so the serial numbers and key IDs of the keys are masked by the logging policy
+func (c *FactoryClient) conflict(resource, key, message string) {
+	c.conflicts = append(c.conflicts, Conflict{Resource: resource, Key: key, Message: message})
+}
+
 // Accounts synchronizes the account details to the factory instance
-func (c *FactoryClient) Accounts() error {
+func (c *FactoryClient) Accounts(ctx context.Context) error {
 	// Fetch the accounts from the serial-vault
-	result, err := FetchAccounts(c.URL, c.Username, c.APIKey)
+	result, err := FetchAccounts(ctx, c.URL, c.Username, c.APIKey)
 	if err != nil {
 		log.Errorf("Error parsing accounts: %v", err)
 		return err
@@ -66,17 +78,18 @@ func (c *FactoryClient) Accounts() error {
 
 	// Update the factory database with the accounts
 	for _, a := range result.Accounts {
-		if err = datastore.Environ.DB.SyncAccount(a); err != nil {
-			log.Errorf("Error updating accounts: %v", err)
-			return err
+		if err = datastore.Environ.DB.SyncAccount(ctx, a); err != nil {
+			c.conflict(ResourceAccounts, a.AuthorityID, fmt.Sprintf("error updating the account: %v", err))
+			continue
 		}
+		c.synced++
 	}
 
 	return nil
 }
 
 // SigningKeys synchronizes the signing-keys to the factory instance
-func (c *FactoryClient) SigningKeys() error {
+func (c *FactoryClient) SigningKeys(ctx context.Context) error {
 	// Get the signing keys by sending our keystore secret
 	req := keypair.SyncRequest{Secret: datastore.Environ.Config.KeyStoreSecret}
 	data, err := json.Marshal(req)
@@ -86,7 +99,7 @@ func (c *FactoryClient) SigningKeys() error {
 	}
 
 	// Fetch the signing-keys from the cloud serial-vault
-	result, err := FetchSigningKeys(c.URL, c.Username, c.APIKey, data)
+	result, err := FetchSigningKeys(ctx, c.URL, c.Username, c.APIKey, data)
 	if err != nil {
 		log.Errorf("Error parsing signing-keys: %v", err)
 		return err
@@ -100,36 +113,37 @@ func (c *FactoryClient) SigningKeys() error {
 	for _, k := range result.Keypairs {
 
 		// Check if we've already sync-ed the keypair
-		_, err = GetKeypairByPublicID(k.AuthorityID, k.KeyID)
+		_, err = GetKeypairByPublicID(ctx, k.AuthorityID, k.KeyID)
 		if err == nil {
 			// Already have the keypair, so no need to store it again
 			// This is important as we get a new encryption key and sealed key each time
 			continue
 		}
 
-		err = datastore.Environ.DB.SyncKeypair(k)
+		err = datastore.Environ.DB.SyncKeypair(ctx, k)
 		if err != nil {
-			log.Errorf("Error updating keypairs: %v", err)
-			return err
+			c.conflict(ResourceSigningKeys, k.AuthorityID+"/"+log.MaskKeyID(k.KeyID), fmt.Sprintf("error updating the keypair: %v", err))
+			continue
 		}
 
-		err = datastore.Environ.DB.PutSetting(
+		err = datastore.Environ.DB.PutSetting(ctx,
 			datastore.Setting{
 				Code: crypt.GenerateAuthKey(k.AuthorityID, k.KeyID),
 				Data: k.AuthKeyHash})
 		if err != nil {
-			log.Errorf("Error saving keypair auth: %v", err)
-			return err
+			c.conflict(ResourceSigningKeys, k.AuthorityID+"/"+log.MaskKeyID(k.KeyID), fmt.Sprintf("error saving the keypair auth: %v", err))
+			continue
 		}
+		c.synced++
 	}
 
 	return nil
 }
 
 // Models synchronizes the model details to the factory instance
-func (c *FactoryClient) Models() error {
+func (c *FactoryClient) Models(ctx context.Context) error {
 	// Fetch the accounts from the serial-vault
-	result, err := FetchModels(c.URL, c.Username, c.APIKey)
+	result, err := FetchModels(ctx, c.URL, c.Username, c.APIKey)
 	if err != nil {
 		log.Errorf("Error parsing models: %v", err)
 		return err
@@ -139,74 +153,103 @@ func (c *FactoryClient) Models() error {
 		return errors.New(result.ErrorMessage)
 	}
 
-	// Update the factory database with the accounts
+	// Update the factory database with the models
 	for _, m := range result.Models {
-		err = datastore.Environ.DB.SyncModel(m)
+		err = datastore.Environ.DB.SyncModel(ctx, m)
 		if err != nil {
-			log.Errorf("Error updating models: %v", err)
-			return err
+			c.conflict(ResourceModels, m.BrandID+"/"+m.Name, fmt.Sprintf("error updating the model: %v", err))
+			continue
 		}
-
+		c.synced++
 	}
 
 	return nil
 }
 
 // SigningLogs sends signing logs to the cloud from the factory
-func (c *FactoryClient) SigningLogs() error {
+func (c *FactoryClient) SigningLogs(ctx context.Context) error {
 	// Fetch the signing logs that have not been synced
-	logs, err := datastore.Environ.DB.SyncSigningLog()
+	logs, err := datastore.Environ.DB.SyncSigningLog(ctx)
 	if err != nil {
 		log.Errorf("Error fetching unsynced signing logs: %v", err)
 		return err
 	}
 
 	// Send each signing log to the cloud
+	failed := 0
 	for _, l := range logs {
-		success, err := SendSigningLog(c.URL, c.Username, c.APIKey, l)
+		// The sync is cancelled when the scheduler is stopped
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		success, err := SendSigningLog(ctx, c.URL, c.Username, c.APIKey, l)
+		if err == ErrConflict {
+			// The cloud has the serial number for another device-key, so the log is not sent again
+			c.conflict(ResourceSigningLogs, fmt.Sprintf("%s/%s/%s/%d", l.Make, l.Model, log.MaskSerial(l.SerialNumber), l.Revision), err.Error())
+			if err = datastore.Environ.DB.SyncConflictSigningLog(ctx, l.ID); err != nil {
+				log.Errorf("Error marking signing logs: %v", err)
+			}
+			continue
+		}
 		if err != nil || !success {
 			// Leave this one till the next sync
+			failed++
 			continue
 		}
 
 		// Mark the sync as done
-		err = datastore.Environ.DB.SyncUpdateSigningLog(l.ID)
+		err = datastore.Environ.DB.SyncUpdateSigningLog(ctx, l.ID)
 		if err != nil {
 			log.Errorf("Error marking signing logs: %v", err)
 		}
+		c.synced++
 	}
 
+	if failed > 0 {
+		return fmt.Errorf("%d signing logs are not synced, they are sent at the next sync", failed)
+	}
 	return nil
 }
 
 // TestLogs sends logs to the cloud from the factory
-func (c *FactoryClient) TestLogs() error {
+func (c *FactoryClient) TestLogs(ctx context.Context) error {
 	// Fetch the test logs that have not been synced
-	logs, err := datastore.Environ.DB.SyncListTestLogs()
+	logs, err := datastore.Environ.DB.SyncListTestLogs(ctx)
 	if err != nil {
 		log.Errorf("Error fetching unsynced test logs: %v", err)
 		return err
 	}
 
-	// Send each signing log to the cloud
+	// Send each test log to the cloud
+	failed := 0
 	for _, l := range logs {
-		success, err := SendTestLog(c.URL, c.Username, c.APIKey, l)
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		success, err := SendTestLog(ctx, c.URL, c.Username, c.APIKey, l)
 		if err != nil || !success {
 			// Leave this one till the next sync
+			failed++
 			continue
 		}
 
 		// Delete the factory test log
-		err = datastore.Environ.DB.SyncDeleteTestLog(l.ID)
+		err = datastore.Environ.DB.SyncDeleteTestLog(ctx, l.ID)
 		if err != nil {
 			log.Errorf("Error deleting test log: %v", err)
 		}
+		c.synced++
 	}
 
+	if failed > 0 {
+		return fmt.Errorf("%d test logs are not synced, they are sent at the next sync", failed)
+	}
 	return nil
 }
 
 // GetKeypairByPublicID is the mockable call to the database function
-var GetKeypairByPublicID = func(authorityID, keyID string) (datastore.Keypair, error) {
-	return datastore.Environ.DB.GetKeypairByPublicID(authorityID, keyID)
+var GetKeypairByPublicID = func(ctx context.Context, authorityID, keyID string) (datastore.Keypair, error) {
+	return datastore.Environ.DB.GetKeypairByPublicID(ctx, authorityID, keyID)
 }
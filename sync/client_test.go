@@ -22,6 +22,7 @@ package sync_test
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
@@ -115,15 +116,15 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 
 		switch t.Args[0] {
 		case "account":
-			err = client.Accounts()
+			err = client.Accounts(context.Background())
 		case "signingkey":
-			err = client.SigningKeys()
+			err = client.SigningKeys(context.Background())
 		case "model":
-			err = client.Models()
+			err = client.Models(context.Background())
 		case "signinglog":
-			err = client.SigningLogs()
+			err = client.SigningLogs(context.Background())
 		case "testlog":
-			err = client.TestLogs()
+			err = client.TestLogs(context.Background())
 		}
 
 		if len(t.ErrorMessage) == 0 {
@@ -143,58 +144,58 @@ func (s *startSuite) TestStartUnit(c *check.C) {
 
 }
 
-func mockFetchAccounts(url, username, apikey string) (account.ListResponse, error) {
+func mockFetchAccounts(ctx context.Context, url, username, apikey string) (account.ListResponse, error) {
 	w := sendSyncAPIRequest("GET", "/api/accounts", nil)
 	return parseListResponse(w)
 }
 
-func mockFetchAccountsError(url, username, apikey string) (account.ListResponse, error) {
+func mockFetchAccountsError(ctx context.Context, url, username, apikey string) (account.ListResponse, error) {
 	return account.ListResponse{}, errors.New("MOCK error fetching accounts")
 }
 
-func mockFetchAccountsFail(url, username, apikey string) (account.ListResponse, error) {
+func mockFetchAccountsFail(ctx context.Context, url, username, apikey string) (account.ListResponse, error) {
 	return account.ListResponse{Success: false, ErrorMessage: "MOCK fail fetching accounts"}, nil
 }
 
-func mockFetchSigningKeys(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeys(ctx context.Context, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	w := sendSyncAPIRequest("POST", "/api/keypairs/sync", bytes.NewReader(data))
 	return parseKeysResponse(w)
 }
 
-func mockFetchSigningKeysError(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeysError(ctx context.Context, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	return keypair.SyncResponse{}, errors.New("MOCK error fetching signing keys")
 }
 
-func mockFetchSigningKeysFail(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+func mockFetchSigningKeysFail(ctx context.Context, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
 	return keypair.SyncResponse{Success: false}, nil
 }
 
-func mockFetchModels(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModels(ctx context.Context, url, username, apikey string) (model.ListResponse, error) {
 	w := sendSyncAPIRequest("GET", "/api/models", nil)
 	return parseModelResponse(w)
 }
 
-func mockFetchModelsError(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModelsError(ctx context.Context, url, username, apikey string) (model.ListResponse, error) {
 	return model.ListResponse{}, errors.New("MOCK error fetching models")
 }
 
-func mockFetchModelsFail(url, username, apikey string) (model.ListResponse, error) {
+func mockFetchModelsFail(ctx context.Context, url, username, apikey string) (model.ListResponse, error) {
 	return model.ListResponse{Success: false, ErrorMessage: "MOCK fail fetching models"}, nil
 }
 
-func mockSendSigningLog(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+func mockSendSigningLog(ctx context.Context, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 	return true, nil
 }
 
-func mockSendSigningLogError(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+func mockSendSigningLogError(ctx context.Context, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 	return false, errors.New("MOCK error syncing signing log")
 }
 
-func mockSendTestLog(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+func mockSendTestLog(ctx context.Context, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	return true, nil
 }
 
-func mockSendTestLogError(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+func mockSendTestLogError(ctx context.Context, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	return false, errors.New("MOCK error syncing test log")
 }
 
@@ -231,11 +232,11 @@ func parseModelResponse(w *httptest.ResponseRecorder) (model.ListResponse, error
 	return result, err
 }
 
-func mockReEncryptKeypair(keypair datastore.Keypair, newSecret string) (string, string, error) {
+func mockReEncryptKeypair(ctx context.Context, keypair datastore.Keypair, newSecret string) (string, string, error) {
 	return "Base64SealedKey", "Base64SAuthKey", nil
 }
 
 // mockGetKeypairByPublicID error mock for the database
-func mockGetKeypairByPublicID(auth, keyID string) (datastore.Keypair, error) {
+func mockGetKeypairByPublicID(ctx context.Context, auth, keyID string) (datastore.Keypair, error) {
 	return datastore.Keypair{}, errors.New("MOCK Error fetching from the database")
 }
@@ -22,8 +22,12 @@ package sync
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/CanonicalLtd/serial-vault/service/account"
@@ -33,12 +37,22 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/response"
 )
 
+// requestTimeout is the timeout of a request to the cloud serial vault
+const requestTimeout = time.Minute
+
+// conflictCode is the error code of a signing log that the cloud has for another device-key
+const conflictCode = "error-signinglog-conflict"
+
+// ErrConflict is returned when the cloud has the serial number and revision of a signing log
+// for another device-key, e.g. as it was signed in another factory
+var ErrConflict = errors.New("the serial number and revision are logged for another device-key")
+
 var hclient http.Client
 
-// SendRequest sends the request to the serial vault
-var SendRequest = func(method, url, endpoint, username, apikey string, data []byte) (*http.Response, error) {
+// SendRequest sends the request to the serial vault. The request is cancelled with the context
+var SendRequest = func(ctx context.Context, method, url, endpoint, username, apikey string, data []byte) (*http.Response, error) {
 	log.Infof("Call the cloud %s", url+endpoint)
-	r, _ := http.NewRequest(method, url+endpoint, bytes.NewReader(data))
+	r, _ := http.NewRequestWithContext(ctx, method, url+endpoint, bytes.NewReader(data))
 	r.Header.Set("user", username)
 	r.Header.Set("api-key", apikey)
 
@@ -46,8 +60,8 @@ var SendRequest = func(method, url, endpoint, username, apikey string, data []by
 }
 
 // FetchAccounts fetches the accounts from the cloud serial vault
-var FetchAccounts = func(url, username, apikey string) (account.ListResponse, error) {
-	w, err := SendRequest("GET", url, "accounts", username, apikey, nil)
+var FetchAccounts = func(ctx context.Context, url, username, apikey string) (account.ListResponse, error) {
+	w, err := SendRequest(ctx, "GET", url, "accounts", username, apikey, nil)
 	if err != nil {
 		log.Errorf("Error fetching accounts: %v", err)
 		return account.ListResponse{}, err
@@ -59,8 +73,8 @@ var FetchAccounts = func(url, username, apikey string) (account.ListResponse, er
 
 // FetchSigningKeys fetches the signing-keys from the cloud serial vault
 // Send our keystore secret to the cloud and get back the keys encrypted using our secret
-var FetchSigningKeys = func(url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
-	w, err := SendRequest("POST", url, "keypairs/sync", username, apikey, data)
+var FetchSigningKeys = func(ctx context.Context, url, username, apikey string, data []byte) (keypair.SyncResponse, error) {
+	w, err := SendRequest(ctx, "POST", url, "keypairs/sync", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error fetching accounts: %v", err)
 		return keypair.SyncResponse{}, err
@@ -70,20 +84,36 @@ var FetchSigningKeys = func(url, username, apikey string, data []byte) (keypair.
 	return parseSigningKeyResponse(w)
 }
 
-// FetchModels fetches the models from the cloud serial vault
-var FetchModels = func(url, username, apikey string) (model.ListResponse, error) {
-	w, err := SendRequest("GET", url, "models", username, apikey, nil)
-	if err != nil {
-		log.Errorf("Error fetching models: %v", err)
-		return model.ListResponse{}, err
+// FetchModels fetches the models from the cloud serial vault, a page at a time until the
+// last page. A cloud without the pages returns all the models in the first page
+var FetchModels = func(ctx context.Context, url, username, apikey string) (model.ListResponse, error) {
+	result := model.ListResponse{Success: true, Models: []datastore.Model{}}
+	offset := 0
+	for {
+		endpoint := fmt.Sprintf("models?limit=%d&offset=%d", datastore.ListModelsMaxLimit, offset)
+		w, err := SendRequest(ctx, "GET", url, endpoint, username, apikey, nil)
+		if err != nil {
+			log.Errorf("Error fetching models: %v", err)
+			return model.ListResponse{}, err
+		}
+
+		// Parse the response from the cloud
+		page, err := parseModelResponse(w)
+		w.Body.Close()
+		if err != nil || !page.Success {
+			return page, err
+		}
+		result.Models = append(result.Models, page.Models...)
+
+		if page.NextOffset <= offset {
+			return result, nil
+		}
+		offset = page.NextOffset
 	}
-
-	// Parse the response from the cloud
-	return parseModelResponse(w)
 }
 
 // SendSigningLog sends a signing log to the cloud serial vault
-var SendSigningLog = func(url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+var SendSigningLog = func(ctx context.Context, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
 
 	data, err := json.Marshal(signLog)
 	if err != nil {
@@ -91,7 +121,7 @@ var SendSigningLog = func(url, username, apikey string, signLog datastore.Signin
 		return false, err
 	}
 
-	w, err := SendRequest("POST", url, "signinglog", username, apikey, data)
+	w, err := SendRequest(ctx, "POST", url, "signinglog", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error syncing signing log: %v", err)
 		return false, err
@@ -104,6 +134,9 @@ var SendSigningLog = func(url, username, apikey string, signLog datastore.Signin
 		return false, err
 	}
 	if !result.Success {
+		if result.ErrorCode == conflictCode {
+			return false, ErrConflict
+		}
 		log.Errorf("Error syncing signing log: %v", result.ErrorMessage)
 		return false, err
 	}
@@ -112,14 +145,14 @@ var SendSigningLog = func(url, username, apikey string, signLog datastore.Signin
 }
 
 // SendTestLog sends a test log to the cloud serial vault
-var SendTestLog = func(url, username, apikey string, testLog datastore.TestLog) (bool, error) {
+var SendTestLog = func(ctx context.Context, url, username, apikey string, testLog datastore.TestLog) (bool, error) {
 	data, err := json.Marshal(testLog)
 	if err != nil {
 		log.Errorf("Error marshalling test log: %v", err)
 		return false, err
 	}
 
-	w, err := SendRequest("POST", url, "testlog", username, apikey, data)
+	w, err := SendRequest(ctx, "POST", url, "testlog", username, apikey, data)
 	if err != nil {
 		log.Errorf("Error syncing test log: %v", err)
 		return false, err
@@ -0,0 +1,87 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync_test
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/model"
+	"github.com/CanonicalLtd/serial-vault/sync"
+	check "gopkg.in/check.v1"
+)
+
+// fetchModels is the request of the models, which the suites mock
+var fetchModels = sync.FetchModels
+
+func (s *startSuite) TestFetchModelsPages(c *check.C) {
+	models := []datastore.Model{}
+	for i := 1; i <= 120; i++ {
+		models = append(models, datastore.Model{ID: i, BrandID: "alder", Name: fmt.Sprintf("alder-%d", i)})
+	}
+
+	// The cloud returns a page of the models, 50 by default and at most 30 in this test
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Check(r.URL.Path, check.Equals, "/api/models")
+		limit, offset := 50, 0
+		if l, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil {
+			limit = l
+		}
+		if o, err := strconv.Atoi(r.URL.Query().Get("offset")); err == nil {
+			offset = o
+		}
+		if limit > 30 {
+			limit = 30
+		}
+
+		result := model.ListResponse{Success: true, Models: models[offset:]}
+		if offset+limit < len(models) {
+			result.Models = models[offset : offset+limit]
+			result.NextOffset = offset + limit
+		}
+		json.NewEncoder(w).Encode(result)
+	}))
+	defer server.Close()
+
+	result, err := fetchModels(context.Background(), server.URL+"/api/", "sync", "key")
+	c.Assert(err, check.IsNil)
+	c.Assert(result.Success, check.Equals, true)
+	c.Assert(result.Models, check.HasLen, 120)
+	c.Assert(result.Models[119].Name, check.Equals, "alder-120")
+}
+
+func (s *startSuite) TestFetchModelsCancelled(c *check.C) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Error("Expected the cancelled request not to be sent")
+	}))
+	defer server.Close()
+
+	// The request is cancelled with the context of the sync
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fetchModels(ctx, server.URL+"/api/", "sync", "key")
+	c.Assert(err, check.NotNil)
+}
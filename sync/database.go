@@ -20,6 +20,7 @@
 package sync
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/CanonicalLtd/serial-vault/manage"
@@ -34,7 +35,7 @@ func (cmd DatabaseCommand) Execute(args []string) error {
 
 	openDatabase()
 
-	manage.UpdateDatabase()
+	manage.UpdateDatabase(context.Background())
 
 	return nil
 }
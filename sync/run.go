@@ -0,0 +1,162 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// defaultInterval is the interval of the sync when it is not configured
+const defaultInterval = time.Hour
+
+// Resources of the factory sync, with their status in the database
+const (
+	ResourceAccounts    = "accounts"
+	ResourceSigningKeys = "signingkeys"
+	ResourceModels      = "models"
+	ResourceSigningLogs = "signinglogs"
+	ResourceTestLogs    = "testlogs"
+)
+
+// Config is the sync of the factory with the cloud serial vault from the config settings
+type Config struct {
+	URL      string        // URL of the API of the cloud serial vault
+	Username string        // user of the sync, with the syncuser role in the accounts
+	APIKey   string        // API key of the user
+	Interval time.Duration // interval between the syncs
+	Mode     bool          // sync from the signing service of the factory
+}
+
+// Report holds the status of the resources of a sync, and the conflicts that were not synced
+type Report struct {
+	Resources []datastore.SyncStatus `json:"resources"`
+	Conflicts []Conflict             `json:"conflicts"`
+}
+
+// Conflict is a record that was not synced, e.g. a model that the factory database rejects or a
+// signing log that the cloud has for another device-key
+type Conflict struct {
+	Resource string `json:"resource"`
+	Key      string `json:"key"`
+	Message  string `json:"message"`
+}
+
+// NewConfig creates the sync config from the config settings. The cloud serial vault must be
+// configured in sync mode
+func NewConfig(settings config.Settings) (Config, error) {
+	cfg := Config{
+		URL:      settings.SyncURL,
+		Username: settings.SyncUser,
+		APIKey:   settings.SyncAPIKey,
+		Interval: defaultInterval,
+		Mode:     settings.SyncMode,
+	}
+
+	if len(settings.SyncInterval) > 0 {
+		interval, err := time.ParseDuration(settings.SyncInterval)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid sync interval: %v", err)
+		}
+		if interval <= 0 {
+			return Config{}, fmt.Errorf("the sync interval must be positive")
+		}
+		cfg.Interval = interval
+	}
+
+	if cfg.Mode && (len(cfg.URL) == 0 || len(cfg.Username) == 0 || len(cfg.APIKey) == 0) {
+		return Config{}, fmt.Errorf("the sync mode needs the URL, the username and the API key of the cloud serial vault")
+	}
+	return cfg, nil
+}
+
+// Enabled returns whether the signing service runs the sync
+func (cfg Config) Enabled() bool {
+	return cfg.Mode
+}
+
+// Job runs the sync when the scheduler starts, and then at the interval of the config
+func Job(cfg Config) scheduler.Job {
+	return scheduler.Job{Name: "factory-sync", Interval: cfg.Interval, RunAtStart: true, Run: func(ctx context.Context) error {
+		_, err := Run(ctx, datastore.Environ.DB, NewFactoryClient(cfg.URL, cfg.Username, cfg.APIKey))
+		return err
+	}}
+}
+
+// Run pulls the accounts, the signing-keys and the models from the cloud serial vault, and pushes
+// the signing logs and the test logs to it. A resource that fails does not stop the sync of the
+// others, and the status of each resource is recorded for the status endpoint. The records that
+// cannot be synced are reported as conflicts, and the sync goes on with the other records. The
+// sync stops when the context is cancelled, e.g. when the scheduler is stopped
+func Run(ctx context.Context, db datastore.Datastore, client *FactoryClient) (Report, error) {
+	steps := []struct {
+		resource string
+		message  string
+		sync     func(context.Context) error
+	}{
+		{ResourceAccounts, "Sync the accounts from the cloud", client.Accounts},
+		{ResourceSigningKeys, "Sync the signing-keys from the cloud", client.SigningKeys},
+		{ResourceModels, "Sync the models from the cloud", client.Models},
+		{ResourceSigningLogs, "Sync the signing logs to the cloud", client.SigningLogs},
+		{ResourceTestLogs, "Sync the test logs to the cloud", client.TestLogs},
+	}
+
+	report := Report{Resources: []datastore.SyncStatus{}, Conflicts: []Conflict{}}
+	withErrors := false
+	for _, s := range steps {
+		if err := ctx.Err(); err != nil {
+			return report, err
+		}
+
+		log.Info(s.message)
+		client.synced, client.conflicts = 0, nil
+
+		status := datastore.SyncStatus{Resource: s.resource, LastRun: time.Now().UTC()}
+		if err := s.sync(ctx); err != nil {
+			withErrors = true
+			status.Message = err.Error()
+		}
+		status.Synced = client.synced
+		status.Conflicts = len(client.conflicts)
+
+		if err := db.PutSyncStatus(ctx, status); err != nil {
+			log.Errorf("Error in the factory sync: %v", err)
+		}
+		report.Resources = append(report.Resources, status)
+		report.Conflicts = append(report.Conflicts, client.conflicts...)
+	}
+
+	for _, c := range report.Conflicts {
+		log.Warningf("Sync conflict: %s '%s': %s", c.Resource, c.Key, c.Message)
+	}
+	if withErrors {
+		log.Error("Sync completed with errors")
+		return report, errors.New("Sync completed with errors")
+	}
+	return report, nil
+}
@@ -0,0 +1,133 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package sync_test
+
+import (
+	"context"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/account"
+	"github.com/CanonicalLtd/serial-vault/sync"
+	check "gopkg.in/check.v1"
+)
+
+func (s *startSuite) TestNewConfig(c *check.C) {
+	tests := []struct {
+		settings config.Settings
+		interval time.Duration
+		enabled  bool
+		err      string
+	}{
+		{config.Settings{}, time.Hour, false, ""},
+		{config.Settings{SyncInterval: "15m"}, 15 * time.Minute, false, ""},
+		{config.Settings{SyncMode: true, SyncURL: "https://vault/api/", SyncUser: "sync", SyncAPIKey: "key"}, time.Hour, true, ""},
+		{config.Settings{SyncMode: true, SyncURL: "https://vault/api/"}, 0, false, "the sync mode needs the URL.*"},
+		{config.Settings{SyncInterval: "soon"}, 0, false, "invalid sync interval.*"},
+		{config.Settings{SyncInterval: "-1h"}, 0, false, "the sync interval must be positive"},
+	}
+
+	for _, t := range tests {
+		cfg, err := sync.NewConfig(t.settings)
+		if len(t.err) > 0 {
+			c.Assert(err, check.ErrorMatches, t.err)
+			continue
+		}
+		c.Assert(err, check.IsNil)
+		c.Assert(cfg.Interval, check.Equals, t.interval)
+		c.Assert(cfg.Enabled(), check.Equals, t.enabled)
+	}
+}
+
+func (s *startSuite) TestRun(c *check.C) {
+	sync.GetKeypairByPublicID = mockGetKeypairByPublicID
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey")
+
+	report, err := sync.Run(context.Background(), datastore.Environ.DB, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Resources, check.HasLen, 5)
+	c.Assert(report.Conflicts, check.HasLen, 0)
+
+	statuses, err := datastore.Environ.DB.ListSyncStatus(context.Background())
+	c.Assert(err, check.IsNil)
+	c.Assert(statuses, check.HasLen, 5)
+	for _, status := range statuses {
+		c.Assert(status.Message, check.Equals, "")
+		c.Assert(status.LastSuccess, check.NotNil)
+		if status.Resource == sync.ResourceSigningLogs {
+			c.Assert(status.Synced, check.Equals, 4)
+		}
+	}
+}
+
+func (s *startSuite) TestRunConflict(c *check.C) {
+	sync.GetKeypairByPublicID = mockGetKeypairByPublicID
+	sync.SendSigningLog = mockSendSigningLogConflict
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey")
+
+	// A conflict is not an error of the sync, as the log is not sent again
+	report, err := sync.Run(context.Background(), datastore.Environ.DB, client)
+	c.Assert(err, check.IsNil)
+	c.Assert(report.Conflicts, check.DeepEquals, []sync.Conflict{
+		{Resource: sync.ResourceSigningLogs, Key: "system/alder/A2/0", Message: sync.ErrConflict.Error()},
+	})
+	c.Assert(report.Resources[3].Resource, check.Equals, sync.ResourceSigningLogs)
+	c.Assert(report.Resources[3].Synced, check.Equals, 3)
+	c.Assert(report.Resources[3].Conflicts, check.Equals, 1)
+}
+
+func (s *startSuite) TestRunErrors(c *check.C) {
+	sync.FetchModels = mockFetchModelsError
+	sync.SendSigningLog = mockSendSigningLogError
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey")
+
+	// The other resources are synced when a resource fails
+	report, err := sync.Run(context.Background(), datastore.Environ.DB, client)
+	c.Assert(err, check.ErrorMatches, "Sync completed with errors")
+	c.Assert(report.Resources, check.HasLen, 5)
+	c.Assert(report.Resources[0].Message, check.Equals, "")
+	c.Assert(report.Resources[2].Message, check.Equals, "MOCK error fetching models")
+	c.Assert(report.Resources[3].Message, check.Equals, "4 signing logs are not synced, they are sent at the next sync")
+	c.Assert(report.Resources[4].Message, check.Equals, "")
+}
+
+func (s *startSuite) TestRunCancelled(c *check.C) {
+	ctx, cancel := context.WithCancel(context.Background())
+	sync.FetchAccounts = func(ctx context.Context, url, username, apikey string) (account.ListResponse, error) {
+		// The scheduler is stopped while the accounts are fetched
+		cancel()
+		return account.ListResponse{}, ctx.Err()
+	}
+	client := sync.NewFactoryClient("/api/", "sync", "ValidAPIKey")
+
+	report, err := sync.Run(ctx, datastore.Environ.DB, client)
+	c.Assert(err, check.Equals, context.Canceled)
+	c.Assert(report.Resources, check.HasLen, 1)
+	c.Assert(report.Resources[0].Message, check.Equals, context.Canceled.Error())
+}
+
+func mockSendSigningLogConflict(ctx context.Context, url, username, apikey string, signLog datastore.SigningLog) (bool, error) {
+	if signLog.SerialNumber == "A2" {
+		return false, sync.ErrConflict
+	}
+	return true, nil
+}
@@ -21,6 +21,7 @@
 package sync
 
 import (
+	"context"
 	"errors"
 	"time"
 
@@ -28,8 +29,6 @@ import (
 	"github.com/CanonicalLtd/serial-vault/service/log"
 )
 
-const sleepHours = 1
-
 // StartCommand starts the sync process
 type StartCommand struct {
 	URL      string `short:"s" long:"svurl" description:"Sync URL for the cloud serial-vault" default:"https://serial-vault-partners.canonical.com/api/"`
@@ -40,9 +39,6 @@ type StartCommand struct {
 
 // Execute the sync for the factory
 func (cmd StartCommand) Execute(args []string) error {
-	withErrors := false
-	repeat := true
-
 	// Open the connection to the factory database
 	openDatabase()
 
@@ -50,67 +46,31 @@ func (cmd StartCommand) Execute(args []string) error {
 	if err := cmd.verifyParameters(); err != nil {
 		return err
 	}
+	cfg, err := NewConfig(datastore.Environ.Config)
+	if err != nil {
+		return err
+	}
 
-	for repeat {
-		withErrors = false
+	// In sync mode, the signing service runs the scheduled sync
+	if cmd.Daemon && cfg.Enabled() {
+		log.Info("The signing service syncs with the cloud in sync mode, so the sync daemon is not started")
+		return nil
+	}
 
+	for {
 		// Initialize the factory client
-		client := NewFactoryClient(
-			datastore.Environ.Config.SyncURL, datastore.Environ.Config.SyncUser, datastore.Environ.Config.SyncAPIKey)
-
-		// Sync the accounts
-		log.Info("Sync the accounts from the cloud")
-		err := client.Accounts()
-		if err != nil {
-			withErrors = true
-		}
-
-		// Sync the signing-keys
-		log.Info("Sync the signing-keys from the cloud")
-		err = client.SigningKeys()
-		if err != nil {
-			withErrors = true
-		}
-
-		// Sync the models
-		log.Info("Sync the models from the cloud")
-		err = client.Models()
-		if err != nil {
-			withErrors = true
-		}
+		client := NewFactoryClient(cfg.URL, cfg.Username, cfg.APIKey)
 
-		// Sync the signing logs
-		log.Info("Sync the signing logs to the cloud")
-		err = client.SigningLogs()
-		if err != nil {
-			withErrors = true
-		}
+		_, err = Run(context.Background(), datastore.Environ.DB, client)
 
-		// Sync the test logs
-		log.Info("Sync the test logs to the cloud")
-		err = client.TestLogs()
-		if err != nil {
-			withErrors = true
+		// For command mode, not need to repeat
+		if !cmd.Daemon {
+			return err
 		}
 
-		if withErrors {
-			log.Error("Sync completed with errors")
-		}
-
-		if cmd.Daemon {
-			// For daemon mode, wait before re-running the sync
-			time.Sleep(sleepHours * time.Hour)
-		} else {
-			// For command mode, not need to repeat
-			repeat = false
-		}
+		// For daemon mode, wait before re-running the sync
+		time.Sleep(cfg.Interval)
 	}
-
-	if withErrors {
-		return errors.New("Sync completed with errors")
-	}
-
-	return nil
 }
 
 func (cmd StartCommand) verifyParameters() error {
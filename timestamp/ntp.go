@@ -0,0 +1,122 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timestamp
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	ntpPort        = "123"
+	ntpPacketSize  = 48
+	ntpEpochOffset = 2208988800 // seconds from 1900-01-01 to 1970-01-01
+	ntpClientMode  = 3
+	ntpServerMode  = 4
+	ntpVersion     = 4
+	ntpNotSynced   = 3 // leap indicator of a server that is not synchronized
+)
+
+// ntpTime gets the time from an NTP server, using the SNTP protocol (RFC 4330)
+type ntpTime struct {
+	server string
+}
+
+func (s *ntpTime) Name() string {
+	return fmt.Sprintf("%s:%s", NTPSource, s.server)
+}
+
+// Now queries the NTP server and corrects the time for the network delay
+func (s *ntpTime) Now() (time.Time, error) {
+	address := s.server
+	if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, ntpPort)
+	}
+
+	conn, err := net.DialTimeout("udp", address, timeout)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	// The transmit time of the request is echoed back by the server as the origin time
+	request := make([]byte, ntpPacketSize)
+	request[0] = ntpVersion<<3 | ntpClientMode
+	sent := time.Now()
+	binary.BigEndian.PutUint64(request[40:], toNTPTime(sent))
+
+	if _, err = conn.Write(request); err != nil {
+		return time.Time{}, err
+	}
+
+	response := make([]byte, ntpPacketSize)
+	if _, err = conn.Read(response); err != nil {
+		return time.Time{}, err
+	}
+	received := time.Now()
+
+	if err = validateNTPResponse(request, response); err != nil {
+		return time.Time{}, err
+	}
+
+	// Offset of the server clock: ((receive - sent) + (transmit - received)) / 2
+	serverReceive := fromNTPTime(binary.BigEndian.Uint64(response[32:]))
+	serverTransmit := fromNTPTime(binary.BigEndian.Uint64(response[40:]))
+	offset := (serverReceive.Sub(sent) + serverTransmit.Sub(received)) / 2
+
+	return received.Add(offset), nil
+}
+
+// validateNTPResponse checks that the response is from a synchronized server and is for our request
+func validateNTPResponse(request, response []byte) error {
+	if response[0]&0x07 != ntpServerMode {
+		return errors.New("the NTP response is not from a server")
+	}
+	if response[0]>>6 == ntpNotSynced {
+		return errors.New("the NTP server clock is not synchronized")
+	}
+	if stratum := response[1]; stratum == 0 || stratum > 15 {
+		return fmt.Errorf("the NTP server has an invalid stratum: %d", stratum)
+	}
+	if binary.BigEndian.Uint64(response[24:]) != binary.BigEndian.Uint64(request[40:]) {
+		return errors.New("the NTP response does not match the request")
+	}
+	return nil
+}
+
+// toNTPTime converts the time to the 64-bit NTP timestamp format:
+// seconds since 1900 in the upper 32-bits and the fraction in the lower 32-bits
+func toNTPTime(t time.Time) uint64 {
+	seconds := uint64(t.Unix() + ntpEpochOffset)
+	fraction := uint64(t.Nanosecond()) << 32 / uint64(time.Second)
+	return seconds<<32 | fraction
+}
+
+// fromNTPTime converts a 64-bit NTP timestamp to the time
+func fromNTPTime(ntp uint64) time.Time {
+	seconds := int64(ntp>>32) - ntpEpochOffset
+	nanoseconds := int64((ntp & 0xffffffff) * uint64(time.Second) >> 32)
+	return time.Unix(seconds, nanoseconds)
+}
@@ -0,0 +1,118 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timestamp
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// Types of time source that can be configured
+const (
+	SystemSource = "system"
+	NTPSource    = "ntp"
+	TSASource    = "tsa"
+)
+
+// timeout is the maximum time to wait for a response from the time server
+const timeout = 10 * time.Second
+
+// Source is the interface for the time sources used for the assertion timestamps
+type Source interface {
+	Now() (time.Time, error)
+	Name() string
+}
+
+// Timestamp is the time of an assertion, with the name of its time source. The DER encoded
+// time-stamp token of a TSA is the proof of the time, that is kept with the signing log
+type Timestamp struct {
+	Time   time.Time
+	Source string
+	Token  []byte
+}
+
+// tokenSource is a time source that proves the time with a signed token
+type tokenSource interface {
+	Stamp() (time.Time, []byte, error)
+}
+
+// NewSource creates the time source from the config settings
+func NewSource(settings config.Settings) (Source, error) {
+	switch settings.TimestampSource {
+	case "", SystemSource:
+		return &systemTime{}, nil
+	case NTPSource:
+		if len(settings.TimestampServer) == 0 {
+			return nil, fmt.Errorf("the NTP server must be set for the '%s' timestamp source", NTPSource)
+		}
+		return &ntpTime{server: settings.TimestampServer}, nil
+	case TSASource:
+		if len(settings.TimestampServer) == 0 {
+			return nil, fmt.Errorf("the TSA URL must be set for the '%s' timestamp source", TSASource)
+		}
+		if len(settings.TimestampCAFile) == 0 {
+			return nil, fmt.Errorf("the TSA CA file must be set for the '%s' timestamp source", TSASource)
+		}
+		return newTSATime(settings.TimestampServer, settings.TimestampCAFile)
+	default:
+		return nil, fmt.Errorf("invalid timestamp source '%s'", settings.TimestampSource)
+	}
+}
+
+// Now gets the current time from the configured time source.
+// Returns the time and the name of the time source that was used
+func Now(settings config.Settings) (time.Time, string, error) {
+	ts, err := Stamp(settings)
+	return ts.Time, ts.Source, err
+}
+
+// Stamp gets the current time from the configured time source, with the token of the
+// time when the source is a TSA
+func Stamp(settings config.Settings) (Timestamp, error) {
+	source, err := NewSource(settings)
+	if err != nil {
+		return Timestamp{}, err
+	}
+
+	ts := Timestamp{Source: source.Name()}
+	if s, ok := source.(tokenSource); ok {
+		ts.Time, ts.Token, err = s.Stamp()
+	} else {
+		ts.Time, err = source.Now()
+	}
+	if err != nil {
+		return Timestamp{Source: source.Name()}, fmt.Errorf("error getting the time from %s: %v", source.Name(), err)
+	}
+	return ts, nil
+}
+
+// systemTime uses the clock of the server
+type systemTime struct{}
+
+func (s *systemTime) Now() (time.Time, error) {
+	return time.Now(), nil
+}
+
+func (s *systemTime) Name() string {
+	return SystemSource
+}
@@ -0,0 +1,368 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timestamp
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"go.mozilla.org/pkcs7"
+)
+
+var serverTime = time.Date(2020, 3, 14, 15, 9, 26, 0, time.UTC)
+
+func TestNewSource(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+
+	tests := []struct {
+		source string
+		server string
+		caFile string
+		name   string
+		valid  bool
+	}{
+		{"", "", "", "system", true},
+		{"system", "", "", "system", true},
+		{"ntp", "ntp.example.com", "", "ntp:ntp.example.com", true},
+		{"tsa", "https://tsa.example.com", tsa.caFile, "tsa:https://tsa.example.com", true},
+		{"ntp", "", "", "", false},
+		{"tsa", "", tsa.caFile, "", false},
+		{"tsa", "https://tsa.example.com", "", "", false},
+		{"tsa", "https://tsa.example.com", "/does/not/exist.pem", "", false},
+		{"invalid", "", "", "", false},
+	}
+
+	for _, tt := range tests {
+		source, err := NewSource(config.Settings{TimestampSource: tt.source, TimestampServer: tt.server, TimestampCAFile: tt.caFile})
+		if (err == nil) != tt.valid {
+			t.Errorf("Timestamp source %q: expected valid %v, got error: %v", tt.source, tt.valid, err)
+			continue
+		}
+		if tt.valid && source.Name() != tt.name {
+			t.Errorf("Expected source name %q, got %q", tt.name, source.Name())
+		}
+	}
+}
+
+func TestNTPTimeConversion(t *testing.T) {
+	now := time.Unix(1584198566, 500000000)
+	converted := fromNTPTime(toNTPTime(now))
+	if diff := converted.Sub(now); diff < -time.Microsecond || diff > time.Microsecond {
+		t.Errorf("Expected %v, got %v", now, converted)
+	}
+}
+
+func TestNTPNow(t *testing.T) {
+	address := startNTPServer(t, 1)
+
+	ts, source, err := Now(config.Settings{TimestampSource: "ntp", TimestampServer: address})
+	if err != nil {
+		t.Fatalf("Error getting the time from the NTP server: %v", err)
+	}
+	if source != "ntp:"+address {
+		t.Errorf("Unexpected time source: %s", source)
+	}
+	if diff := ts.Sub(serverTime); diff < -time.Second || diff > time.Second {
+		t.Errorf("Expected the NTP server time %v, got %v", serverTime, ts)
+	}
+}
+
+func TestNTPNowInvalidStratum(t *testing.T) {
+	address := startNTPServer(t, 0)
+
+	_, _, err := Now(config.Settings{TimestampSource: "ntp", TimestampServer: address})
+	if err == nil {
+		t.Error("Expected an error with an unsynchronized NTP server, got success")
+	}
+}
+
+func TestTSANow(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	srv := httptest.NewServer(http.HandlerFunc(tsaHandler(t, tsa, false)))
+	defer srv.Close()
+
+	ts, source, err := Now(config.Settings{TimestampSource: "tsa", TimestampServer: srv.URL, TimestampCAFile: tsa.caFile})
+	if err != nil {
+		t.Fatalf("Error getting the time from the TSA: %v", err)
+	}
+	if source != "tsa:"+srv.URL {
+		t.Errorf("Unexpected time source: %s", source)
+	}
+	if !ts.Equal(serverTime) {
+		t.Errorf("Expected the TSA time %v, got %v", serverTime, ts)
+	}
+}
+
+func TestTSAStamp(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	srv := httptest.NewServer(http.HandlerFunc(tsaHandler(t, tsa, false)))
+	defer srv.Close()
+
+	ts, err := Stamp(config.Settings{TimestampSource: "tsa", TimestampServer: srv.URL, TimestampCAFile: tsa.caFile})
+	if err != nil {
+		t.Fatalf("Error getting the time from the TSA: %v", err)
+	}
+	if !ts.Time.Equal(serverTime) || ts.Source != "tsa:"+srv.URL {
+		t.Errorf("Unexpected timestamp: %v", ts)
+	}
+
+	// The token is the signed token of the response
+	p7, err := pkcs7.Parse(ts.Token)
+	if err != nil {
+		t.Fatalf("Error decoding the time-stamp token: %v", err)
+	}
+	if signer := p7.GetOnlySigner(); signer == nil || !bytes.Equal(signer.Raw, tsa.cert.Raw) {
+		t.Error("Expected the token signed by the TSA")
+	}
+
+	// The other time sources have no token
+	ts, err = Stamp(config.Settings{})
+	if err != nil || ts.Source != SystemSource || ts.Token != nil {
+		t.Errorf("Unexpected system timestamp: %v, %v", ts, err)
+	}
+}
+
+func TestTSANowWrongNonce(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	srv := httptest.NewServer(http.HandlerFunc(tsaHandler(t, tsa, true)))
+	defer srv.Close()
+
+	_, _, err := Now(config.Settings{TimestampSource: "tsa", TimestampServer: srv.URL, TimestampCAFile: tsa.caFile})
+	if err == nil {
+		t.Error("Expected an error with the wrong nonce, got success")
+	}
+}
+
+func TestTSANowUntrustedSigner(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	other := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+	notTimeStamping := newTestTSA(t, x509.ExtKeyUsageServerAuth)
+
+	tests := []struct {
+		name   string
+		signer *testTSA
+		caFile string
+	}{
+		{"other CA", other, tsa.caFile},
+		{"not for time-stamping", notTimeStamping, notTimeStamping.caFile},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(tsaHandler(t, tt.signer, false)))
+		_, _, err := Now(config.Settings{TimestampSource: "tsa", TimestampServer: srv.URL, TimestampCAFile: tt.caFile})
+		if err == nil {
+			t.Errorf("%s: expected an error with the untrusted signer, got success", tt.name)
+		}
+		srv.Close()
+	}
+}
+
+func TestTSANowErrors(t *testing.T) {
+	tsa := newTestTSA(t, x509.ExtKeyUsageTimeStamping)
+
+	tests := []struct {
+		status int
+		body   []byte
+	}{
+		{http.StatusInternalServerError, nil},
+		{http.StatusOK, []byte("invalid")},
+		{http.StatusOK, marshalTimeStampResponse(t, tsa, 2, tstInfo{})},
+	}
+
+	for _, tt := range tests {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(tt.status)
+			w.Write(tt.body)
+		}))
+
+		_, _, err := Now(config.Settings{TimestampSource: "tsa", TimestampServer: srv.URL, TimestampCAFile: tsa.caFile})
+		if err == nil {
+			t.Errorf("Expected an error with TSA status %d, got success", tt.status)
+		}
+		srv.Close()
+	}
+}
+
+// startNTPServer runs a mock NTP server that responds to a single request
+func startNTPServer(t *testing.T, stratum byte) string {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Error starting the NTP server: %v", err)
+	}
+
+	go func() {
+		defer conn.Close()
+		request := make([]byte, ntpPacketSize)
+		_, addr, err := conn.ReadFrom(request)
+		if err != nil {
+			return
+		}
+
+		response := make([]byte, ntpPacketSize)
+		response[0] = ntpVersion<<3 | ntpServerMode
+		response[1] = stratum
+		copy(response[24:32], request[40:48])
+		binary.BigEndian.PutUint64(response[32:], toNTPTime(serverTime))
+		binary.BigEndian.PutUint64(response[40:], toNTPTime(serverTime))
+		conn.WriteTo(response, addr)
+	}()
+
+	return conn.LocalAddr().String()
+}
+
+// testTSA is a mock Time-Stamping Authority, with the file of the CA of its certificate
+type testTSA struct {
+	cert   *x509.Certificate
+	key    *ecdsa.PrivateKey
+	caFile string
+}
+
+// newTestTSA creates the certificate of a TSA, for the key usage, issued by a new CA
+func newTestTSA(t *testing.T, usage x509.ExtKeyUsage) *testTSA {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating the CA key: %v", err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test TSA CA"},
+		NotBefore:             serverTime.Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating the CA certificate: %v", err)
+	}
+	ca, _ := x509.ParseCertificate(caDER)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating the TSA key: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test TSA"},
+		NotBefore:    serverTime.Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("Error creating the TSA certificate: %v", err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+
+	caFile := filepath.Join(t.TempDir(), "tsa-ca.pem")
+	if err := os.WriteFile(caFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: caDER}), 0600); err != nil {
+		t.Fatalf("Error writing the CA file: %v", err)
+	}
+	return &testTSA{cert: cert, key: key, caFile: caFile}
+}
+
+// tsaHandler mocks a TSA, responding with a token for the request
+func tsaHandler(t *testing.T, tsa *testTSA, wrongNonce bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+
+		var req timeStampReq
+		if _, err := asn1.Unmarshal(body, &req); err != nil {
+			t.Errorf("Error decoding the time-stamp request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if !req.CertReq {
+			t.Error("Expected the certificate of the TSA to be requested")
+		}
+
+		nonce := req.Nonce
+		if wrongNonce {
+			nonce = new(big.Int).Add(nonce, big.NewInt(1))
+		}
+
+		info := tstInfo{
+			Version:        1,
+			Policy:         asn1.ObjectIdentifier{1, 2, 3},
+			MessageImprint: req.MessageImprint,
+			SerialNumber:   big.NewInt(1),
+			GenTime:        serverTime,
+			Nonce:          nonce,
+		}
+		w.Header().Set("Content-Type", "application/timestamp-reply")
+		w.Write(marshalTimeStampResponse(t, tsa, tsaGranted, info))
+	}
+}
+
+// marshalTimeStampResponse encodes the response with the token info, signed by the TSA
+func marshalTimeStampResponse(t *testing.T, tsa *testTSA, status int, info tstInfo) []byte {
+	if info.SerialNumber == nil {
+		imprint := messageImprint{HashAlgorithm: algorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: []byte("hash")}
+		info = tstInfo{Version: 1, Policy: asn1.ObjectIdentifier{1, 2, 3}, MessageImprint: imprint, SerialNumber: big.NewInt(1), GenTime: serverTime, Nonce: big.NewInt(1)}
+	}
+
+	infoBytes, err := asn1.Marshal(info)
+	if err != nil {
+		t.Fatalf("Error encoding the token info: %v", err)
+	}
+
+	signed, err := pkcs7.NewSignedData(infoBytes)
+	if err != nil {
+		t.Fatalf("Error encoding the signed data: %v", err)
+	}
+	signed.SetDigestAlgorithm(pkcs7.OIDDigestAlgorithmSHA256)
+	signed.GetSignedData().ContentInfo.ContentType = oidTSTInfo
+	if err := signed.AddSigner(tsa.cert, tsa.key, pkcs7.SignerInfoConfig{}); err != nil {
+		t.Fatalf("Error signing the token: %v", err)
+	}
+	token, err := signed.Finish()
+	if err != nil {
+		t.Fatalf("Error encoding the token: %v", err)
+	}
+
+	resp, err := asn1.Marshal(timeStampResp{
+		Status:         pkiStatusInfo{Status: status},
+		TimeStampToken: asn1.RawValue{FullBytes: token},
+	})
+	if err != nil {
+		t.Fatalf("Error encoding the time-stamp response: %v", err)
+	}
+	return resp
+}
@@ -0,0 +1,263 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package timestamp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"os"
+	"time"
+
+	"go.mozilla.org/pkcs7"
+)
+
+var (
+	oidSHA256     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidTSTInfo    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+)
+
+// PKI status values of a time-stamp response that include a token
+const (
+	tsaGranted         = 0
+	tsaGrantedWithMods = 1
+)
+
+// ASN.1 structures of the Time-Stamp Protocol (RFC 3161)
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type messageImprint struct {
+	HashAlgorithm algorithmIdentifier
+	HashedMessage []byte
+}
+
+type timeStampReq struct {
+	Version        int
+	MessageImprint messageImprint
+	Nonce          *big.Int
+	CertReq        bool `asn1:"optional"`
+}
+
+type pkiStatusInfo struct {
+	Status int
+}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type timeStampResp struct {
+	Status         pkiStatusInfo
+	TimeStampToken asn1.RawValue `asn1:"optional"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	EncapContentInfo encapsulatedContentInfo
+}
+
+type accuracy struct {
+	Seconds int `asn1:"optional"`
+	Millis  int `asn1:"optional,tag:0"`
+	Micros  int `asn1:"optional,tag:1"`
+}
+
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+	Accuracy       accuracy  `asn1:"optional"`
+	Ordering       bool      `asn1:"optional"`
+	Nonce          *big.Int  `asn1:"optional"`
+}
+
+// tsaTime gets the time from a Time-Stamping Authority (RFC 3161). The tokens
+// must be signed by a certificate for time-stamping from one of the roots
+type tsaTime struct {
+	url   string
+	roots *x509.CertPool
+}
+
+// newTSATime creates the TSA time source, with the trusted CAs of the TSA from the PEM file
+func newTSATime(url, caFile string) (*tsaTime, error) {
+	pem, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("error reading the TSA CA: %v", err)
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in the TSA CA: %s", caFile)
+	}
+	return &tsaTime{url: url, roots: roots}, nil
+}
+
+func (s *tsaTime) Name() string {
+	return fmt.Sprintf("%s:%s", TSASource, s.url)
+}
+
+// Now requests a time-stamp token from the TSA and returns its time
+func (s *tsaTime) Now() (time.Time, error) {
+	t, _, err := s.Stamp()
+	return t, err
+}
+
+// Stamp requests a time-stamp token from the TSA and returns its time, with the DER
+// encoded token. The token is for the hash of a random message. The signature of the
+// token is verified, and its nonce and message imprint are checked so that the token
+// is for this request
+func (s *tsaTime) Stamp() (time.Time, []byte, error) {
+	message := make([]byte, 32)
+	if _, err := rand.Read(message); err != nil {
+		return time.Time{}, nil, err
+	}
+	nonce, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 64))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	hash := sha256.Sum256(message)
+	imprint := messageImprint{HashAlgorithm: algorithmIdentifier{Algorithm: oidSHA256}, HashedMessage: hash[:]}
+
+	// The certificate of the TSA is requested, so the signature of the token can be verified
+	request, err := asn1.Marshal(timeStampReq{Version: 1, MessageImprint: imprint, Nonce: nonce, CertReq: true})
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	client := &http.Client{Timeout: timeout}
+	resp, err := client.Post(s.url, "application/timestamp-query", bytes.NewReader(request))
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return time.Time{}, nil, fmt.Errorf("the TSA returned the status: %s", resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	info, token, err := parseTimeStampResponse(body)
+	if err != nil {
+		return time.Time{}, nil, err
+	}
+
+	if info.Nonce == nil || info.Nonce.Cmp(nonce) != 0 {
+		return time.Time{}, nil, errors.New("the time-stamp token does not match the request nonce")
+	}
+	if !info.MessageImprint.HashAlgorithm.Algorithm.Equal(oidSHA256) || !bytes.Equal(info.MessageImprint.HashedMessage, hash[:]) {
+		return time.Time{}, nil, errors.New("the time-stamp token does not match the request message")
+	}
+	if err := verifyToken(token, s.roots, info.GenTime); err != nil {
+		return time.Time{}, nil, err
+	}
+
+	return info.GenTime, token, nil
+}
+
+// parseTimeStampResponse gets the time-stamp token info from the signed data of the response,
+// with the DER encoded token
+func parseTimeStampResponse(body []byte) (*tstInfo, []byte, error) {
+	var resp timeStampResp
+	if _, err := asn1.Unmarshal(body, &resp); err != nil {
+		return nil, nil, fmt.Errorf("error decoding the time-stamp response: %v", err)
+	}
+
+	if resp.Status.Status != tsaGranted && resp.Status.Status != tsaGrantedWithMods {
+		return nil, nil, fmt.Errorf("the time-stamp request was rejected with status %d", resp.Status.Status)
+	}
+
+	var token contentInfo
+	if _, err := asn1.Unmarshal(resp.TimeStampToken.FullBytes, &token); err != nil {
+		return nil, nil, fmt.Errorf("error decoding the time-stamp token: %v", err)
+	}
+	if !token.ContentType.Equal(oidSignedData) {
+		return nil, nil, errors.New("the time-stamp token is not signed data")
+	}
+
+	var signed signedData
+	if _, err := asn1.Unmarshal(token.Content.Bytes, &signed); err != nil {
+		return nil, nil, fmt.Errorf("error decoding the time-stamp token: %v", err)
+	}
+	if !signed.EncapContentInfo.EContentType.Equal(oidTSTInfo) {
+		return nil, nil, errors.New("the time-stamp token does not contain the token info")
+	}
+
+	info := &tstInfo{}
+	if _, err := asn1.Unmarshal(signed.EncapContentInfo.EContent, info); err != nil {
+		return nil, nil, fmt.Errorf("error decoding the time-stamp token info: %v", err)
+	}
+	return info, resp.TimeStampToken.FullBytes, nil
+}
+
+// verifyToken checks the signature of the time-stamp token, and that the certificate of the
+// signer is issued for time-stamping by one of the roots
+func verifyToken(token []byte, roots *x509.CertPool, genTime time.Time) error {
+	p7, err := pkcs7.Parse(token)
+	if err != nil {
+		return fmt.Errorf("error decoding the time-stamp token: %v", err)
+	}
+	if err := p7.Verify(); err != nil {
+		return fmt.Errorf("invalid signature of the time-stamp token: %v", err)
+	}
+
+	signer := p7.GetOnlySigner()
+	if signer == nil {
+		return errors.New("the time-stamp token must have one signer, with its certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range p7.Certificates {
+		intermediates.AddCert(cert)
+	}
+	_, err = signer.Verify(x509.VerifyOptions{
+		Roots:         roots,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageTimeStamping},
+		CurrentTime:   genTime,
+	})
+	if err != nil {
+		return fmt.Errorf("the time-stamp token is not signed by a trusted TSA: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,170 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package tlscert
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// checkInterval is the interval of the check for changes of the certificate files
+const checkInterval = 30 * time.Second
+
+// Enabled checks if the service terminates TLS itself, with the certificate and key in the config settings
+func Enabled(settings config.Settings) (bool, error) {
+	hasCert, hasKey := len(settings.TLSCertFile) > 0, len(settings.TLSKeyFile) > 0
+	if hasCert != hasKey {
+		return false, fmt.Errorf("both the TLS certificate and key files must be set")
+	}
+	return hasCert, nil
+}
+
+// The modes of the client authentication of the signing service
+const (
+	ClientAuthOptional = "optional"
+	ClientAuthRequired = "required"
+)
+
+// ClientAuth returns the verification of the client certificates and the pool of the trusted CA,
+// for the mutual TLS of the signing service. The pool is nil when mutual TLS is not configured
+func ClientAuth(settings config.Settings) (tls.ClientAuthType, *x509.CertPool, error) {
+	if len(settings.TLSClientCAFile) == 0 {
+		return tls.NoClientCert, nil, nil
+	}
+
+	var authType tls.ClientAuthType
+	switch settings.TLSClientAuth {
+	case "", ClientAuthOptional:
+		authType = tls.VerifyClientCertIfGiven
+	case ClientAuthRequired:
+		authType = tls.RequireAndVerifyClientCert
+	default:
+		return tls.NoClientCert, nil, fmt.Errorf("invalid TLS client auth: %s", settings.TLSClientAuth)
+	}
+
+	pem, err := os.ReadFile(settings.TLSClientCAFile)
+	if err != nil {
+		return tls.NoClientCert, nil, fmt.Errorf("error reading the TLS client CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pem) {
+		return tls.NoClientCert, nil, fmt.Errorf("no certificates in the TLS client CA: %s", settings.TLSClientCAFile)
+	}
+	return authType, pool, nil
+}
+
+// Reloader serves the TLS certificate, reloading it when the certificate or key file changes,
+// so a renewed certificate is used without restarting the service
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	lock     sync.RWMutex
+	cert     *tls.Certificate
+	modified time.Time
+}
+
+// NewReloader loads the TLS certificate and key
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Reload loads the certificate and key when the files have changed since they were loaded.
+// The current certificate is kept when the new files are invalid e.g. half-written
+func (r *Reloader) Reload() (bool, error) {
+	modified, err := r.lastModified()
+	if err != nil {
+		return false, err
+	}
+
+	r.lock.RLock()
+	unchanged := r.cert != nil && modified.Equal(r.modified)
+	r.lock.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading the TLS certificate: %v", err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.cert = &cert
+	r.modified = modified
+	return true, nil
+}
+
+// lastModified returns the latest modification time of the certificate and key files
+func (r *Reloader) lastModified() (time.Time, error) {
+	var modified time.Time
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return modified, fmt.Errorf("error checking the TLS certificate: %v", err)
+		}
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+	}
+	return modified, nil
+}
+
+// GetCertificate returns the current certificate, for the TLS config of the servers
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.cert, nil
+}
+
+// TLSConfig returns the TLS config of the servers, with the current certificate
+func (r *Reloader) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: r.GetCertificate,
+	}
+}
+
+// Job checks the certificate files for changes at the interval. The current certificate
+// is used until the new files can be loaded
+func (r *Reloader) Job() scheduler.Job {
+	return scheduler.Job{Name: "tls-reload", Interval: checkInterval, Local: true, Run: func(context.Context) error {
+		reloaded, err := r.Reload()
+		if reloaded {
+			log.Infof("Reloaded the TLS certificate from %s", r.certFile)
+		}
+		return err
+	}}
+}
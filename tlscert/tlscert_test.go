@@ -0,0 +1,188 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package tlscert
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+)
+
+// writeCertificate writes a self-signed certificate for the common name, with the modification time
+func writeCertificate(t *testing.T, dir, name string, modified time.Time) (string, string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Error generating the key: %v", err)
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Error creating the certificate: %v", err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Error encoding the key: %v", err)
+	}
+
+	certFile, keyFile := filepath.Join(dir, "tls.crt"), filepath.Join(dir, "tls.key")
+	os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600)
+	os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600)
+	os.Chtimes(certFile, modified, modified)
+	os.Chtimes(keyFile, modified, modified)
+	return certFile, keyFile
+}
+
+func commonName(t *testing.T, r *Reloader) string {
+	cert, err := r.GetCertificate(nil)
+	if err != nil {
+		t.Fatalf("Error getting the certificate: %v", err)
+	}
+	parsed, err := x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatalf("Error parsing the certificate: %v", err)
+	}
+	return parsed.Subject.CommonName
+}
+
+func TestReloader(t *testing.T) {
+	dir := t.TempDir()
+	loaded := time.Now().Add(-time.Minute)
+	certFile, keyFile := writeCertificate(t, dir, "first", loaded)
+
+	r, err := NewReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("Error loading the certificate: %v", err)
+	}
+	if got := commonName(t, r); got != "first" {
+		t.Errorf("Expected the first certificate, got: %s", got)
+	}
+
+	// The unchanged files are not reloaded
+	if reloaded, err := r.Reload(); reloaded || err != nil {
+		t.Errorf("Expected no reload of the unchanged files, got %v, %v", reloaded, err)
+	}
+
+	// The renewed certificate is reloaded
+	writeCertificate(t, dir, "renewed", time.Now())
+	if reloaded, err := r.Reload(); !reloaded || err != nil {
+		t.Errorf("Expected the renewed certificate to be reloaded, got %v, %v", reloaded, err)
+	}
+	if got := commonName(t, r); got != "renewed" {
+		t.Errorf("Expected the renewed certificate, got: %s", got)
+	}
+
+	// An invalid certificate keeps the current certificate
+	os.WriteFile(certFile, []byte("invalid"), 0600)
+	later := time.Now().Add(time.Minute)
+	os.Chtimes(certFile, later, later)
+	if _, err := r.Reload(); err == nil {
+		t.Error("Expected an error reloading an invalid certificate")
+	}
+	if got := commonName(t, r); got != "renewed" {
+		t.Errorf("Expected the current certificate to be kept, got: %s", got)
+	}
+}
+
+func TestNewReloaderInvalid(t *testing.T) {
+	if _, err := NewReloader("does-not-exist.crt", "does-not-exist.key"); err == nil {
+		t.Error("Expected an error with missing certificate files")
+	}
+}
+
+func TestEnabled(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Settings
+		enabled  bool
+		wantErr  bool
+	}{
+		{"disabled", config.Settings{}, false, false},
+		{"enabled", config.Settings{TLSCertFile: "tls.crt", TLSKeyFile: "tls.key"}, true, false},
+		{"no-key", config.Settings{TLSCertFile: "tls.crt"}, false, true},
+		{"no-cert", config.Settings{TLSKeyFile: "tls.key"}, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			enabled, err := Enabled(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Enabled() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if enabled != tt.enabled {
+				t.Errorf("Enabled() = %v, expected %v", enabled, tt.enabled)
+			}
+		})
+	}
+}
+
+func TestClientAuth(t *testing.T) {
+	dir := t.TempDir()
+	caFile, _ := writeCertificate(t, dir, "factory-ca", time.Now())
+	invalidFile := filepath.Join(dir, "invalid.crt")
+	os.WriteFile(invalidFile, []byte("invalid"), 0600)
+
+	tests := []struct {
+		name     string
+		settings config.Settings
+		authType tls.ClientAuthType
+		hasPool  bool
+		wantErr  bool
+	}{
+		{"disabled", config.Settings{}, tls.NoClientCert, false, false},
+		{"optional-default", config.Settings{TLSClientCAFile: caFile}, tls.VerifyClientCertIfGiven, true, false},
+		{"optional", config.Settings{TLSClientCAFile: caFile, TLSClientAuth: "optional"}, tls.VerifyClientCertIfGiven, true, false},
+		{"required", config.Settings{TLSClientCAFile: caFile, TLSClientAuth: "required"}, tls.RequireAndVerifyClientCert, true, false},
+		{"invalid-mode", config.Settings{TLSClientCAFile: caFile, TLSClientAuth: "sometimes"}, tls.NoClientCert, false, true},
+		{"invalid-ca", config.Settings{TLSClientCAFile: invalidFile}, tls.NoClientCert, false, true},
+		{"missing-ca", config.Settings{TLSClientCAFile: "does-not-exist.crt"}, tls.NoClientCert, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			authType, pool, err := ClientAuth(tt.settings)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ClientAuth() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if authType != tt.authType {
+				t.Errorf("ClientAuth() = %v, expected %v", authType, tt.authType)
+			}
+			if (pool != nil) != tt.hasPool {
+				t.Errorf("ClientAuth() pool = %v, expected a pool %v", pool, tt.hasPool)
+			}
+		})
+	}
+}
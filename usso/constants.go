@@ -32,7 +32,26 @@ const (
 	ClaimsName             = "name"
 	ClaimsRole             = "role"
 	StandardClaimExpiresAt = "exp"
+	StandardClaimID        = "jti"
+	StandardClaimIssuedAt  = "iat"
+	ClaimsType             = "type"
+	ClaimsScopes           = "scopes"
+	ClaimsClientID         = "client"
+	ClaimsInvitation       = "invitation"
+)
+
+// Type claims of the tokens that are not a JWT of the admin UI
+const (
+	TokenTypeRefresh = "refresh" // exchanged for a new JWT of the admin UI
+	TokenTypeService = "service" // scoped token of a service account, for the admin API
+	TokenTypeInvite  = "invite"  // signed link of a user invitation, for the first login
 )
 
 // JWTCookie is the name of the cookie used to store the JWT
 const JWTCookie = "X-Auth-Token"
+
+// RefreshCookie is the name of the cookie used to store the refresh token
+const RefreshCookie = "X-Refresh-Token"
+
+// RefreshCookiePath restricts the refresh token cookie to the refresh endpoint
+const RefreshCookiePath = "/v1/token/refresh"
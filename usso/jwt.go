@@ -20,13 +20,19 @@
 package usso
 
 import (
+	"context"
 	"errors"
+	"fmt"
 	"strings"
 	"time"
 
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/fips"
+	"github.com/CanonicalLtd/serial-vault/random"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"net/http"
+	"net/url"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
 	"github.com/dgrijalva/jwt-go"
@@ -43,6 +49,9 @@ func createJWT(username, name, email, identity string, role int, expires int64)
 	claims[ClaimsIdentity] = identity
 	claims[ClaimsRole] = role
 	claims[StandardClaimExpiresAt] = expires
+	if err := addSessionClaims(claims); err != nil {
+		return "", err
+	}
 
 	jwtSecret := datastore.Environ.Config.JwtSecret
 	if len(jwtSecret) == 0 {
@@ -51,14 +60,318 @@ func createJWT(username, name, email, identity string, role int, expires int64)
 
 	tokenString, err := token.SignedString([]byte(jwtSecret))
 	if err != nil {
-		log.Printf("Error signing the JWT: %v", err.Error())
+		log.Errorf("Error signing the JWT: %v", err.Error())
 	}
 	return tokenString, err
 }
 
+// jwtTTL is the validity of the JWT of the admin UI
+const jwtTTL = 24 * time.Hour
+
 // NewJWTToken creates a new JWT from the verified OpenID response
 func NewJWTToken(resp *openid.Response, role int) (string, error) {
-	return createJWT(resp.SReg["nickname"], resp.SReg["fullname"], resp.SReg["email"], resp.ID, role, time.Now().Add(time.Hour*24).Unix())
+	return createJWT(resp.SReg["nickname"], resp.SReg["fullname"], resp.SReg["email"], resp.ID, role, time.Now().Add(jwtTTL).Unix())
+}
+
+// defaultRefreshTokenTTL is the validity of a refresh token, when it is not configured
+const defaultRefreshTokenTTL = 7 * 24 * time.Hour
+
+// maxRefreshTokenTTL is the longest validity of a refresh token
+const maxRefreshTokenTTL = 30 * 24 * time.Hour
+
+// RefreshTokenTTL returns the validity of the refresh tokens, from the config settings
+func RefreshTokenTTL(settings config.Settings) (time.Duration, error) {
+	if len(settings.RefreshTokenTTL) == 0 {
+		return defaultRefreshTokenTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(settings.RefreshTokenTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid refresh token TTL: %v", err)
+	}
+	if ttl <= 0 || ttl > maxRefreshTokenTTL {
+		return 0, fmt.Errorf("the refresh token TTL must be more than 0s and at most %v", maxRefreshTokenTTL)
+	}
+	return ttl, nil
+}
+
+// NewRefreshToken creates a refresh token for the user, which is exchanged for a new JWT
+// when the JWT expires. It only holds the identity of the user: the role is read from
+// the database when the token is refreshed
+func NewRefreshToken(username, identity string) (string, error) {
+	ttl, err := RefreshTokenTTL(datastore.Environ.Config)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims[ClaimsUsername] = username
+	claims[ClaimsIdentity] = identity
+	claims[ClaimsType] = TokenTypeRefresh
+	claims[StandardClaimExpiresAt] = time.Now().Add(ttl).Unix()
+	if err := addSessionClaims(claims); err != nil {
+		return "", err
+	}
+
+	jwtSecret := datastore.Environ.Config.JwtSecret
+	if len(jwtSecret) == 0 {
+		return "", errors.New("JWT secret empty value. Please configure it properly")
+	}
+
+	tokenString, err := token.SignedString([]byte(jwtSecret))
+	if err != nil {
+		log.Errorf("Error signing the refresh token: %v", err.Error())
+	}
+	return tokenString, err
+}
+
+// RefreshJWTToken verifies the refresh token and creates a new JWT for the user, with the
+// role read again from the database. A new refresh token is also returned, so the session
+// slides for as long as the user keeps it active. A refresh token is used once: when it is
+// presented again, it may have been stolen, so all the sessions of the user are revoked
+func RefreshJWTToken(ctx context.Context, refreshToken string) (string, string, error) {
+	token, err := VerifyJWT(refreshToken)
+	if err != nil || !token.Valid || !IsRefreshToken(token) || IsTokenRevoked(ctx, token) {
+		return "", "", errors.New("The refresh token is invalid")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	username, _ := claims[ClaimsUsername].(string)
+	identity, _ := claims[ClaimsIdentity].(string)
+
+	_, tokenID, _, expires := sessionClaims(token)
+	if len(tokenID) == 0 {
+		return "", "", errors.New("The refresh token is invalid")
+	}
+	unused, err := datastore.Environ.DB.UseRefreshToken(ctx, username, tokenID, expires)
+	if err != nil {
+		log.Errorf("Error using the refresh token of user %v: %v", username, err)
+		return "", "", errors.New("The refresh token is invalid")
+	}
+	if !unused {
+		log.Warningf("Refresh token of user %v used again: revoking the sessions of the user", username)
+		if err = RevokeUserSessions(ctx, username); err != nil {
+			log.Errorf("Error revoking the sessions of user %v: %v", username, err)
+		}
+		return "", "", errors.New("The refresh token is invalid")
+	}
+
+	user, err := datastore.Environ.DB.GetUserByUsername(ctx, username)
+	if err != nil {
+		log.Errorf("Error retrieving user from datastore: %v", err)
+		return "", "", errors.New("The refresh token is invalid")
+	}
+	if !isValidLoginRole(user.Role) {
+		log.Warningf("Role obtained from database for user %v has not a valid value: %v", username, user.Role)
+		return "", "", errors.New("The refresh token is invalid")
+	}
+	if !user.Active {
+		log.Warningf("Refresh token of the inactive user %v", username)
+		return "", "", errors.New("The refresh token is invalid")
+	}
+
+	jwtToken, err := createJWT(user.Username, user.Name, user.Email, identity, user.Role, time.Now().Add(jwtTTL).Unix())
+	if err != nil {
+		return "", "", err
+	}
+
+	newRefreshToken, err := NewRefreshToken(user.Username, identity)
+	if err != nil {
+		return "", "", err
+	}
+	return jwtToken, newRefreshToken, nil
+}
+
+// addSessionClaims sets the ID and the issue time of a session token, so it can be revoked
+// on its own or with all the sessions of the user
+func addSessionClaims(claims jwt.MapClaims) error {
+	tokenID, err := random.GenerateRandomString(24)
+	if err != nil {
+		log.Errorf("Error generating the token ID: %v", err.Error())
+		return err
+	}
+	claims[StandardClaimID] = tokenID
+	claims[StandardClaimIssuedAt] = time.Now().Unix()
+	return nil
+}
+
+// sessionClaims returns the username, the ID, the issue time and the expiry of a session token.
+// The tokens issued before they had an ID are only revoked with all the sessions of the user
+func sessionClaims(token *jwt.Token) (string, string, time.Time, time.Time) {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return "", "", time.Time{}, time.Time{}
+	}
+	username, _ := claims[ClaimsUsername].(string)
+	tokenID, _ := claims[StandardClaimID].(string)
+	issued, _ := claims[StandardClaimIssuedAt].(float64)
+	expires, _ := claims[StandardClaimExpiresAt].(float64)
+	return username, tokenID, time.Unix(int64(issued), 0), time.Unix(int64(expires), 0)
+}
+
+// IsTokenRevoked checks the denylist for the JWT or the refresh token
+func IsTokenRevoked(ctx context.Context, token *jwt.Token) bool {
+	username, tokenID, issued, _ := sessionClaims(token)
+	return datastore.Environ.DB.IsTokenRevoked(ctx, username, tokenID, issued)
+}
+
+// RevokeToken adds the JWT or the refresh token to the denylist, until it expires
+func RevokeToken(ctx context.Context, token *jwt.Token) error {
+	username, tokenID, _, expires := sessionClaims(token)
+	if len(tokenID) == 0 || !expires.After(time.Now()) {
+		// Nothing to revoke: the token cannot be identified or has expired
+		return nil
+	}
+	return datastore.Environ.DB.RevokeToken(ctx, username, tokenID, expires)
+}
+
+// RevokeUserSessions revokes all the JWTs and refresh tokens that have been issued to the
+// user, so every session of the user has to log in again
+func RevokeUserSessions(ctx context.Context, username string) error {
+	ttl, err := RefreshTokenTTL(datastore.Environ.Config)
+	if err != nil {
+		ttl = maxRefreshTokenTTL
+	}
+
+	// The revocation is kept until the longest lived of the tokens has expired
+	if ttl < jwtTTL {
+		ttl = jwtTTL
+	}
+	return datastore.Environ.DB.RevokeUserTokens(ctx, username, time.Now().Add(ttl))
+}
+
+// IsRefreshToken checks if the token is a refresh token, which cannot be used as a JWT
+func IsRefreshToken(token *jwt.Token) bool {
+	return TokenType(token) == TokenTypeRefresh
+}
+
+// TokenType returns the type claim of the token, which is empty for a JWT of the admin UI
+func TokenType(token *jwt.Token) string {
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return ""
+	}
+	tokenType, _ := claims[ClaimsType].(string)
+	return tokenType
+}
+
+// defaultServiceTokenTTL is the validity of a token of a service account, when it is not configured
+const defaultServiceTokenTTL = time.Hour
+
+// maxServiceTokenTTL is the longest validity of a token of a service account
+const maxServiceTokenTTL = 24 * time.Hour
+
+// ServiceTokenTTL returns the validity of the tokens of the service accounts, from the config settings
+func ServiceTokenTTL(settings config.Settings) (time.Duration, error) {
+	if len(settings.ServiceTokenTTL) == 0 {
+		return defaultServiceTokenTTL, nil
+	}
+
+	ttl, err := time.ParseDuration(settings.ServiceTokenTTL)
+	if err != nil {
+		return 0, fmt.Errorf("invalid service token TTL: %v", err)
+	}
+	if ttl <= 0 || ttl > maxServiceTokenTTL {
+		return 0, fmt.Errorf("the service token TTL must be more than 0s and at most %v", maxServiceTokenTTL)
+	}
+	return ttl, nil
+}
+
+// NewServiceToken creates the token of a service account for the admin API, with the scopes
+// that were granted. The role of the user is read from the database when the token is used
+func NewServiceToken(sa datastore.ServiceAccount, scopes []string, ttl time.Duration) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims[ClaimsUsername] = sa.Username
+	claims[ClaimsClientID] = sa.ClientID
+	claims[ClaimsScopes] = scopes
+	claims[ClaimsType] = TokenTypeService
+	claims[StandardClaimExpiresAt] = time.Now().Add(ttl).Unix()
+
+	jwtSecret := datastore.Environ.Config.JwtSecret
+	if len(jwtSecret) == 0 {
+		return "", errors.New("JWT secret empty value. Please configure it properly")
+	}
+
+	tokenString, err := token.SignedString([]byte(jwtSecret))
+	if err != nil {
+		log.Errorf("Error signing the service token: %v", err.Error())
+	}
+	return tokenString, err
+}
+
+// VerifyServiceToken checks that the token is a valid token of a service account, and returns
+// the service account with the scopes of the token
+func VerifyServiceToken(tokenString string) (datastore.ServiceAccount, error) {
+	token, err := VerifyJWT(tokenString)
+	if err != nil || !token.Valid || TokenType(token) != TokenTypeService {
+		return datastore.ServiceAccount{}, errors.New("The service token is invalid")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	sa := datastore.ServiceAccount{Scopes: []string{}}
+	sa.Username, _ = claims[ClaimsUsername].(string)
+	sa.ClientID, _ = claims[ClaimsClientID].(string)
+	scopes, _ := claims[ClaimsScopes].([]interface{})
+	for _, s := range scopes {
+		if scope, ok := s.(string); ok {
+			sa.Scopes = append(sa.Scopes, scope)
+		}
+	}
+	return sa, nil
+}
+
+// NewInvitationToken creates the signed token of the link of a user invitation, which
+// expires with the invitation
+func NewInvitationToken(inv datastore.Invitation) (string, error) {
+	token := jwt.New(jwt.SigningMethodHS256)
+
+	claims := token.Claims.(jwt.MapClaims)
+	claims[ClaimsInvitation] = inv.ID
+	claims[ClaimsEmail] = inv.Email
+	claims[ClaimsType] = TokenTypeInvite
+	claims[StandardClaimExpiresAt] = inv.Expires.Unix()
+
+	jwtSecret := datastore.Environ.Config.JwtSecret
+	if len(jwtSecret) == 0 {
+		return "", errors.New("JWT secret empty value. Please configure it properly")
+	}
+
+	tokenString, err := token.SignedString([]byte(jwtSecret))
+	if err != nil {
+		log.Errorf("Error signing the invitation token: %v", err.Error())
+	}
+	return tokenString, err
+}
+
+// VerifyInvitationToken checks the signed token of an invitation link, and returns the
+// ID of the invitation
+func VerifyInvitationToken(tokenString string) (int, error) {
+	token, err := VerifyJWT(tokenString)
+	if err != nil || !token.Valid || TokenType(token) != TokenTypeInvite {
+		return 0, errors.New("The invitation is invalid or has expired")
+	}
+
+	claims := token.Claims.(jwt.MapClaims)
+	id, ok := claims[ClaimsInvitation].(float64)
+	if !ok {
+		return 0, errors.New("The invitation is invalid or has expired")
+	}
+	return int(id), nil
+}
+
+// InvitationLink returns the login URL of the vault with the invitation token
+func InvitationLink(token string) string {
+	u := url.URL{
+		Scheme:   datastore.Environ.Config.URLScheme,
+		Host:     datastore.Environ.Config.URLHost,
+		Path:     "/login",
+		RawQuery: url.Values{"invite": {token}}.Encode(),
+	}
+	return u.String()
 }
 
 func keyFunc(token *jwt.Token) (interface{}, error) {
@@ -66,6 +379,9 @@ func keyFunc(token *jwt.Token) (interface{}, error) {
 	if len(jwtSecret) == 0 {
 		return []byte{}, errors.New("JWT secret empty value. Please configure it properly")
 	}
+	if fips.Enabled(datastore.Environ.Config) && token.Method != jwt.SigningMethodHS256 {
+		return []byte{}, fmt.Errorf("FIPS mode: the JWT signing method %v is not approved", token.Header["alg"])
+	}
 	return []byte(jwtSecret), nil
 }
 
@@ -89,6 +405,18 @@ func AddJWTCookie(jwtToken string, w http.ResponseWriter) {
 	http.SetCookie(w, &cookie)
 }
 
+// AddRefreshCookie sets the refresh token as a cookie, which is only sent to the refresh endpoint
+func AddRefreshCookie(refreshToken string, w http.ResponseWriter) error {
+	ttl, err := RefreshTokenTTL(datastore.Environ.Config)
+	if err != nil {
+		return err
+	}
+
+	cookie := http.Cookie{Name: RefreshCookie, Value: refreshToken, Path: RefreshCookiePath, Expires: time.Now().Add(ttl), HttpOnly: true}
+	http.SetCookie(w, &cookie)
+	return nil
+}
+
 // JWTExtractor extracts the JWT from a request and returns the token string.
 // The token is not verified.
 func JWTExtractor(r *http.Request) (string, error) {
@@ -106,7 +434,7 @@ func JWTExtractor(r *http.Request) (string, error) {
 	if jwtToken == "" {
 		cookie, err := r.Cookie(JWTCookie)
 		if err != nil {
-			log.Println("Cannot find the JWT")
+			log.Error("Cannot find the JWT")
 			return "", errors.New("Cannot find the JWT")
 		}
 		jwtToken = cookie.Value
@@ -20,9 +20,11 @@
 package usso
 
 import (
+	"context"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 
 	"github.com/CanonicalLtd/serial-vault/config"
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -117,3 +119,250 @@ func TestAddJWTCookie(t *testing.T) {
 		t.Errorf("Expected 'ThisShouldBeAJWT', got '%v'", jwtToken)
 	}
 }
+
+func TestRefreshTokenTTL(t *testing.T) {
+	tests := []struct {
+		ttl      string
+		expected time.Duration
+		fails    bool
+	}{
+		{"", 7 * 24 * time.Hour, false},
+		{"12h", 12 * time.Hour, false},
+		{"invalid", 0, true},
+		{"0s", 0, true},
+		{"1000h", 0, true},
+	}
+
+	for _, tt := range tests {
+		ttl, err := RefreshTokenTTL(config.Settings{RefreshTokenTTL: tt.ttl})
+		if (err != nil) != tt.fails {
+			t.Errorf("Refresh token TTL '%s': expected failure %v, got %v", tt.ttl, tt.fails, err)
+		}
+		if ttl != tt.expected {
+			t.Errorf("Refresh token TTL '%s': expected %v, got %v", tt.ttl, tt.expected, ttl)
+		}
+	}
+}
+
+func TestRefreshJWTToken(t *testing.T) {
+	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	refreshToken, err := NewRefreshToken("user1", "id")
+	if err != nil {
+		t.Fatalf("Error creating the refresh token: %v", err)
+	}
+
+	jwtToken, newRefreshToken, err := RefreshJWTToken(context.Background(), refreshToken)
+	if err != nil {
+		t.Fatalf("Error refreshing the JWT: %v", err)
+	}
+	if len(newRefreshToken) == 0 {
+		t.Error("Expected a new refresh token")
+	}
+
+	// The new JWT has the role from the database and cannot be used as a refresh token
+	token, err := VerifyJWT(jwtToken)
+	if err != nil || !token.Valid {
+		t.Fatalf("Expected a valid JWT, got %v", err)
+	}
+	if IsRefreshToken(token) {
+		t.Error("Expected a JWT, got a refresh token")
+	}
+	claims := token.Claims.(jwt.MapClaims)
+	if claims[ClaimsUsername] != "user1" || int(claims[ClaimsRole].(float64)) != datastore.Standard {
+		t.Errorf("Unexpected claims in the refreshed JWT: %v", claims)
+	}
+	if _, _, err := RefreshJWTToken(context.Background(), jwtToken); err == nil {
+		t.Error("Expected an error refreshing with a JWT")
+	}
+
+	// Unknown user
+	refreshToken, _ = NewRefreshToken("unknown", "id")
+	if _, _, err := RefreshJWTToken(context.Background(), refreshToken); err == nil {
+		t.Error("Expected an error refreshing for an unknown user")
+	}
+
+	// Signed with another secret
+	datastore.Environ.Config.JwtSecret = "AnotherSecretValue"
+	if _, _, err := RefreshJWTToken(context.Background(), newRefreshToken); err == nil {
+		t.Error("Expected an error refreshing with an invalid signature")
+	}
+}
+
+// reuseMockDB records the revocation of the sessions of a user
+type reuseMockDB struct {
+	datastore.MockDB
+	revoked string
+}
+
+func (mdb *reuseMockDB) RevokeUserTokens(ctx context.Context, username string, expires time.Time) error {
+	mdb.revoked = username
+	return nil
+}
+
+func TestRefreshJWTTokenReuse(t *testing.T) {
+	db := &reuseMockDB{}
+	datastore.Environ = &datastore.Env{DB: db, Config: config.Settings{JwtSecret: "SomeTestSecretValue"}}
+
+	// The mock has used the refresh token with ID "used", so the sessions of the user are revoked
+	used := signTestToken(jwt.MapClaims{
+		ClaimsUsername:         "user1",
+		ClaimsType:             TokenTypeRefresh,
+		StandardClaimID:        "used",
+		StandardClaimIssuedAt:  time.Now().Unix(),
+		StandardClaimExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	if _, _, err := RefreshJWTToken(context.Background(), used); err == nil {
+		t.Error("Expected an error refreshing with a used refresh token")
+	}
+	if db.revoked != "user1" {
+		t.Errorf("Expected the sessions of the user to be revoked, got %q", db.revoked)
+	}
+
+	// The use of the refresh token cannot be recorded
+	refreshToken, _ := NewRefreshToken("user1", "id")
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	if _, _, err := RefreshJWTToken(context.Background(), refreshToken); err == nil {
+		t.Error("Expected an error refreshing when the use cannot be recorded")
+	}
+}
+
+func TestAddRefreshCookie(t *testing.T) {
+	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{Config: config}
+
+	w := httptest.NewRecorder()
+	if err := AddRefreshCookie("ThisShouldBeARefreshToken", w); err != nil {
+		t.Fatalf("Error setting the refresh cookie: %v", err)
+	}
+
+	request := &http.Request{Header: http.Header{"Cookie": w.HeaderMap["Set-Cookie"]}}
+	cookie, err := request.Cookie(RefreshCookie)
+	if err != nil {
+		t.Fatalf("Error getting the refresh cookie: %v", err)
+	}
+	if cookie.Value != "ThisShouldBeARefreshToken" {
+		t.Errorf("Expected 'ThisShouldBeARefreshToken', got '%v'", cookie.Value)
+	}
+}
+
+func TestVerifyServiceToken(t *testing.T) {
+	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	sa := datastore.ServiceAccount{Username: "sv", ClientID: "sv-client"}
+	token, err := NewServiceToken(sa, []string{datastore.AdminScopeModels}, time.Hour)
+	if err != nil {
+		t.Fatalf("Error creating the service token: %v", err)
+	}
+
+	result, err := VerifyServiceToken(token)
+	if err != nil {
+		t.Fatalf("Error verifying the service token: %v", err)
+	}
+	if result.Username != "sv" || result.ClientID != "sv-client" || len(result.Scopes) != 1 || result.Scopes[0] != datastore.AdminScopeModels {
+		t.Errorf("Unexpected service account from the token: %v", result)
+	}
+
+	// A login JWT is not a service token
+	resp := openid.Response{ID: "id", SReg: map[string]string{"nickname": "sv"}}
+	jwtToken, _ := NewJWTToken(&resp, datastore.Superuser)
+	if _, err := VerifyServiceToken(jwtToken); err == nil {
+		t.Error("Expected an error verifying a JWT as a service token")
+	}
+
+	// Expired token
+	token, _ = NewServiceToken(sa, []string{datastore.AdminScopeModels}, -time.Minute)
+	if _, err := VerifyServiceToken(token); err == nil {
+		t.Error("Expected an error verifying an expired service token")
+	}
+}
+
+func TestVerifyInvitationToken(t *testing.T) {
+	config := config.Settings{JwtSecret: "SomeTestSecretValue", URLScheme: "https", URLHost: "vault.example.com"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	token, err := NewInvitationToken(datastore.Invitation{ID: 42, Email: "jane@example.com", Expires: time.Now().Add(time.Hour)})
+	if err != nil {
+		t.Fatalf("Error creating the invitation token: %v", err)
+	}
+
+	id, err := VerifyInvitationToken(token)
+	if err != nil {
+		t.Fatalf("Error verifying the invitation token: %v", err)
+	}
+	if id != 42 {
+		t.Errorf("Expected the invitation 42, got %d", id)
+	}
+
+	if link := InvitationLink(token); link != "https://vault.example.com/login?invite="+token {
+		t.Errorf("Unexpected invitation link: %s", link)
+	}
+
+	// Expired invitation
+	token, _ = NewInvitationToken(datastore.Invitation{ID: 42, Email: "jane@example.com", Expires: time.Now().Add(-time.Minute)})
+	if _, err := VerifyInvitationToken(token); err == nil {
+		t.Error("Expected an error verifying an expired invitation")
+	}
+
+	// A refresh token is not an invitation
+	token, _ = NewRefreshToken("user1", "id")
+	if _, err := VerifyInvitationToken(token); err == nil {
+		t.Error("Expected an error verifying a refresh token as an invitation")
+	}
+}
+
+func signTestToken(claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	tokenString, _ := token.SignedString([]byte(datastore.Environ.Config.JwtSecret))
+	return tokenString
+}
+
+func TestRevokeToken(t *testing.T) {
+	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}, Config: config}
+
+	// The session tokens have an ID and an issue time
+	refreshToken, _ := NewRefreshToken("user1", "id")
+	token, err := VerifyJWT(refreshToken)
+	if err != nil || !token.Valid {
+		t.Fatalf("Expected a valid refresh token, got %v", err)
+	}
+	username, tokenID, issued, expires := sessionClaims(token)
+	if username != "user1" || len(tokenID) == 0 || issued.IsZero() || !expires.After(issued) {
+		t.Errorf("Unexpected session claims: %s %s %v %v", username, tokenID, issued, expires)
+	}
+	if IsTokenRevoked(context.Background(), token) {
+		t.Error("Expected the refresh token not to be revoked")
+	}
+	if err := RevokeToken(context.Background(), token); err != nil {
+		t.Errorf("Error revoking the refresh token: %v", err)
+	}
+
+	// The mock denylist has the token with ID "revoked"
+	revoked := signTestToken(jwt.MapClaims{
+		ClaimsUsername:         "user1",
+		ClaimsType:             TokenTypeRefresh,
+		StandardClaimID:        "revoked",
+		StandardClaimIssuedAt:  time.Now().Unix(),
+		StandardClaimExpiresAt: time.Now().Add(time.Hour).Unix(),
+	})
+	token, _ = VerifyJWT(revoked)
+	if !IsTokenRevoked(context.Background(), token) {
+		t.Error("Expected the token to be revoked")
+	}
+	if _, _, err := RefreshJWTToken(context.Background(), revoked); err == nil {
+		t.Error("Expected an error refreshing with a revoked refresh token")
+	}
+
+	if err := RevokeUserSessions(context.Background(), "user1"); err != nil {
+		t.Errorf("Error revoking the sessions of the user: %v", err)
+	}
+
+	// Error revoking the sessions
+	datastore.Environ.DB = &datastore.ErrorMockDB{}
+	if err := RevokeUserSessions(context.Background(), "user1"); err == nil {
+		t.Error("Expected an error revoking the sessions of the user")
+	}
+}
@@ -20,11 +20,13 @@
 package usso
 
 import (
+	"context"
 	"html/template"
 	"net/http"
 	"strings"
 	"time"
 
+	"github.com/CanonicalLtd/serial-vault/service/lockout"
 	"github.com/CanonicalLtd/serial-vault/service/log"
 
 	"github.com/CanonicalLtd/serial-vault/datastore"
@@ -74,9 +76,17 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Repeated failed logins lock out the source IP, and the username from the source IP once it is verified
+	sourceIP := lockout.SourceIP(r)
+	if err := lockout.Check(r.Context(), "", sourceIP); err != nil {
+		replyHTTPError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
 	resp, err := verify(url.String())
 	if err != nil {
 		// A mangled OpenID response is suspicious, so leave a nasty response
+		lockout.Failure(r.Context(), lockout.ObjectLogin, "", sourceIP)
 		replyHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
@@ -85,22 +95,42 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	username := r.Form.Get("openid.sreg.nickname")
 	fullname := r.Form.Get("openid.sreg.fullname")
 	if len(username) == 0 || len(fullname) == 0 {
-		log.Println("Some params are missing from the OpenID response")
+		log.Warning("Some params are missing from the OpenID response")
 		http.Redirect(w, r, "/notfound", http.StatusTemporaryRedirect)
 		return
 	}
 
-	User, err := datastore.Environ.DB.GetUserByUsername(username)
+	if err := lockout.Check(r.Context(), username, sourceIP); err != nil {
+		replyHTTPError(w, http.StatusTooManyRequests, err)
+		return
+	}
+
+	// The first login with an invitation link activates the invited user
+	if invite := r.Form.Get("invite"); len(invite) > 0 {
+		acceptInvitation(r.Context(), invite, username, fullname, r.Form.Get("openid.sreg.email"))
+	}
+
+	User, err := datastore.Environ.DB.GetUserByUsername(r.Context(), username)
 	if err != nil {
 		// Cannot find the user, so redirect to the login page
-		log.Printf("Error retrieving user from datastore: %v\n", err)
+		log.Errorf("Error retrieving user from datastore: %v", err)
+		lockout.Failure(r.Context(), lockout.ObjectLogin, username, sourceIP)
+		http.Redirect(w, r, "/notfound", http.StatusTemporaryRedirect)
+		return
+	}
+
+	// a deactivated user cannot log in
+	if !User.Active {
+		log.Warningf("Login of the inactive user %v", username)
+		lockout.Failure(r.Context(), lockout.ObjectLogin, username, sourceIP)
 		http.Redirect(w, r, "/notfound", http.StatusTemporaryRedirect)
 		return
 	}
 
 	// verify role value is valid
-	if User.Role != datastore.Standard && User.Role != datastore.Admin && User.Role != datastore.Superuser {
-		log.Printf("Role obtained from database for user %v has not a valid value: %v\n", username, User.Role)
+	if !isValidLoginRole(User.Role) {
+		log.Warningf("Role obtained from database for user %v has not a valid value: %v", username, User.Role)
+		lockout.Failure(r.Context(), lockout.ObjectLogin, username, sourceIP)
 		http.Redirect(w, r, "/notfound", http.StatusTemporaryRedirect)
 		return
 	}
@@ -109,18 +139,51 @@ func LoginHandler(w http.ResponseWriter, r *http.Request) {
 	jwtToken, err := NewJWTToken(resp, User.Role)
 	if err != nil {
 		// Unexpected that this should occur, so leave the detailed response
-		log.Printf("Error creating the JWT: %v", err)
+		log.Errorf("Error creating the JWT: %v", err)
 		replyHTTPError(w, http.StatusBadRequest, err)
 		return
 	}
 
+	lockout.Success(r.Context(), username, sourceIP)
+
 	// Set a cookie with the JWT
 	AddJWTCookie(jwtToken, w)
 
+	// Set a cookie with the refresh token, so the JWT can be renewed without logging in again
+	refreshToken, err := NewRefreshToken(User.Username, resp.ID)
+	if err == nil {
+		err = AddRefreshCookie(refreshToken, w)
+	}
+	if err != nil {
+		log.Errorf("Error creating the refresh token: %v", err)
+	}
+
 	// Redirect to the homepage with the JWT
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
 
+// acceptInvitation activates the pending user of the invitation with the details of the
+// OpenID login. An invitation that cannot be accepted, e.g. one that was already accepted,
+// is only logged, as the login continues with the existing user
+func acceptInvitation(ctx context.Context, invite, username, fullname, email string) {
+	invitationID, err := VerifyInvitationToken(invite)
+	if err != nil {
+		log.Errorf("Error accepting the invitation of user %v: %v", username, err)
+		return
+	}
+
+	user := datastore.User{Username: username, Name: fullname, Email: email}
+	if _, err = datastore.Environ.DB.AcceptInvitation(ctx, invitationID, user); err != nil {
+		log.Errorf("Error accepting the invitation %d of user %v: %v", invitationID, username, err)
+		return
+	}
+	log.Infof("User %v accepted the invitation %d", username, invitationID)
+}
+
+func isValidLoginRole(role int) bool {
+	return role == datastore.Standard || role == datastore.SubstoreAdmin || role == datastore.Admin || role == datastore.Superuser
+}
+
 func isComma(c rune) bool {
 	return c == ','
 }
@@ -134,19 +197,22 @@ var errorTemplate = template.Must(template.New("failure").Parse(`<html>
 // LogoutHandler logs the user out by removing the cookie and the JWT authorization header
 func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 
+	// Revoke the JWT and the refresh token of the session, as they are valid until they expire
+	revokeSession(r)
+
 	// Remove the authorization header with contains the bearer token
 	w.Header().Del("Authorization")
 
 	// Create a new invalid token with an unauthorized user
 	jwtToken, err := createJWT("INVALID", "Not Logged-In", "", "", 0, 0)
 	if err != nil {
-		log.Println("Error logging out:", err.Error())
+		log.Errorf("Error logging out: %v", err.Error())
 	}
 
 	// Update the cookie with the invalid token and expired date
 	c, err := r.Cookie(JWTCookie)
 	if err != nil {
-		log.Println("Error logging out:", err.Error())
+		log.Errorf("Error logging out: %v", err.Error())
 	}
 	c.Value = jwtToken
 	c.Expires = time.Now().AddDate(0, 0, -1)
@@ -154,5 +220,29 @@ func LogoutHandler(w http.ResponseWriter, r *http.Request) {
 	// Set the bearer token and the cookie
 	http.SetCookie(w, c)
 
+	// Expire the refresh token, so the session cannot be renewed
+	http.SetCookie(w, &http.Cookie{Name: RefreshCookie, Value: "", Path: RefreshCookiePath, Expires: time.Now().AddDate(0, 0, -1), MaxAge: -1, HttpOnly: true})
+
 	http.Redirect(w, r, "/", http.StatusTemporaryRedirect)
 }
+
+// revokeSession adds the valid JWT and refresh token of the request to the denylist
+func revokeSession(r *http.Request) {
+	tokens := []string{}
+	if jwtToken, err := JWTExtractor(r); err == nil {
+		tokens = append(tokens, jwtToken)
+	}
+	if c, err := r.Cookie(RefreshCookie); err == nil {
+		tokens = append(tokens, c.Value)
+	}
+
+	for _, t := range tokens {
+		token, err := VerifyJWT(t)
+		if err != nil || !token.Valid {
+			continue
+		}
+		if err := RevokeToken(r.Context(), token); err != nil {
+			log.Errorf("Error revoking the session token: %v", err.Error())
+		}
+	}
+}
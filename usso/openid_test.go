@@ -20,6 +20,7 @@
 package usso
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"net/http/httptest"
@@ -93,7 +94,7 @@ func TestLoginHandlerReturn(t *testing.T) {
 	response, _ := verifySuccess(url)
 
 	// Get User Role
-	user, err := datastore.Environ.DB.GetUserByUsername(response.SReg["nickname"])
+	user, err := datastore.Environ.DB.GetUserByUsername(context.Background(), response.SReg["nickname"])
 
 	if err != nil {
 		t.Errorf("Could not get datastore user %v: %v\n", response.SReg["nickname"], err)
@@ -102,6 +103,52 @@ func TestLoginHandlerReturn(t *testing.T) {
 	expectedToken(t, jwtToken, response, response.SReg["nickname"], response.SReg["email"], response.SReg["fullname"], user.Role)
 }
 
+// substoreAdminMockDB returns the users as delegated sub-store admins
+type substoreAdminMockDB struct {
+	datastore.MockDB
+}
+
+func (mdb *substoreAdminMockDB) GetUserByUsername(ctx context.Context, username string) (datastore.User, error) {
+	user, err := mdb.MockDB.GetUserByUsername(ctx, username)
+	user.Role = datastore.SubstoreAdmin
+	return user, err
+}
+
+func TestLoginHandlerSubstoreAdmin(t *testing.T) {
+	// Response parameters from OpenID login
+	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname=a"
+
+	// Mock the database and OpenID verification
+	config := config.Settings{JwtSecret: "SomeTestSecretValue"}
+	datastore.Environ = &datastore.Env{DB: &substoreAdminMockDB{}, Config: config}
+	verify = verifySuccess
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+
+	if w.Code != http.StatusTemporaryRedirect || w.Header().Get("Location") != "/" {
+		t.Fatalf("Expected a redirect to the homepage, got: %v %v", w.Code, w.Header().Get("Location"))
+	}
+
+	request := &http.Request{Header: w.Header()}
+	jwtToken, err := JWTExtractor(request)
+	if err != nil {
+		t.Fatalf("Error getting the JWT cookie: %v", err)
+	}
+	response, _ := verifySuccess(url)
+	expectedToken(t, jwtToken, response, "a", "a@example.org", "A", datastore.SubstoreAdmin)
+
+	// The sub-store admin can refresh the session
+	refreshToken, err := NewRefreshToken("a", "id")
+	if err != nil {
+		t.Fatalf("Error creating the refresh token: %v", err)
+	}
+	if _, _, err := RefreshJWTToken(context.Background(), refreshToken); err != nil {
+		t.Errorf("Error refreshing the JWT of a sub-store admin: %v", err)
+	}
+}
+
 func TestLoginHandlerBadUser(t *testing.T) {
 	// Response parameters from OpenID login
 	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.op_endpoint=https://login.ubuntu.com/%2Bopenid&openid.claimed_id=https://login.ubuntu.com/%2Bid/AAAAAA&openid.identity=https://login.ubuntu.com/%2Bid/AAAAAA&openid.return_to=http://return.to&openid.response_nonce=2005-05-15T17:11:51ZUNIQUE&openid.assoc_handle=1&openid.signed=op_endpoint,return_to,response_nonce,assoc_handle,claimed_id,identity,sreg.email,sreg.fullname&openid.sig=AAAA&openid.ns.sreg=http://openid.net/extensions/sreg/1.1&openid.sreg.email=a@example.org&openid.sreg.fullname=A&openid.sreg.nickname=a"
@@ -161,6 +208,23 @@ func TestLoginHandlerReturnFail(t *testing.T) {
 	}
 }
 
+func TestLoginHandlerLockedOut(t *testing.T) {
+	const url = "/login?openid.ns=http://specs.openid.net/auth/2.0&openid.mode=id_res&openid.sreg.fullname=A&openid.sreg.nickname=a"
+
+	// The mock database has the source IP locked out
+	datastore.Environ = &datastore.Env{DB: &datastore.MockDB{}}
+	verify = verifySuccess
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest("GET", url, nil)
+	r.RemoteAddr = "192.0.2.66:41234"
+	http.HandlerFunc(LoginHandler).ServeHTTP(w, r)
+
+	if w.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected HTTP status '429', got: %v", w.Code)
+	}
+}
+
 func TestLogoutHandler(t *testing.T) {
 
 	// Mock the database
@@ -57,6 +57,12 @@ class AccountEdit extends Component {
         this.setState({account: a});
     }
 
+    handleChangeHashSerial = (e) => {
+        var a = this.state.account;
+        a.HashSerial = e.target.checked;
+        this.setState({account: a});
+    }
+
     handleSaveClick = (e) => {
         e.preventDefault();
 
@@ -106,6 +112,9 @@ class AccountEdit extends Component {
                                 <label htmlFor="reseller">{T('reseller-features')}
                                     <input className="visible" type="checkbox" id="reseller" onChange={this.handleChangeReseller} checked={this.state.account.ResellerAPI} />
                                 </label>
+                                <label htmlFor="hashserial">{T('hash-serial-numbers')}
+                                    <input className="visible" type="checkbox" id="hashserial" onChange={this.handleChangeHashSerial} checked={this.state.account.HashSerial} />
+                                </label>
                             </fieldset>
                         </form>
                         <div>
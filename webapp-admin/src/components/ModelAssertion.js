@@ -39,6 +39,8 @@ class ModelAssertion extends Component {
             error: null,
             keypairs: [],
             assertion: assertion,
+            snaps: assertion.snaps ? JSON.stringify(assertion.snaps, null, 2) : '',
+            validationSets: assertion.validation_sets ? JSON.stringify(assertion.validation_sets, null, 2) : '',
         }
 
         this.getKeypairs();
@@ -144,13 +146,50 @@ class ModelAssertion extends Component {
         this.setState({assertion: assertion});
     }
 
+    handleChangeGrade = (e) => {
+        var assertion = this.state.assertion;
+        assertion['grade'] = e.target.value;
+        this.setState({assertion: assertion});
+    }
+
+    handleChangeStorageSafety = (e) => {
+        var assertion = this.state.assertion;
+        assertion['storage_safety'] = e.target.value;
+        this.setState({assertion: assertion});
+    }
+
+    handleChangeModelSnaps = (e) => {
+        this.setState({snaps: e.target.value});
+    }
+
+    handleChangeValidationSets = (e) => {
+        this.setState({validationSets: e.target.value});
+    }
+
+    // parseList decodes a JSON list of the Ubuntu Core 20 headers, which is empty when there is no text
+    parseList(text) {
+        if (text.trim().length === 0) {
+            return []
+        }
+        return JSON.parse(text)
+    }
+
     handleSave = (e) => {
         e.preventDefault()
         if (!isUserAdmin(this.props.token)) {
             window.location = '/models';
         }
 
-        Models.assertion(this.state.assertion).then((response) => {
+        var assertion = this.state.assertion;
+        try {
+            assertion['snaps'] = this.parseList(this.state.snaps);
+            assertion['validation_sets'] = this.parseList(this.state.validationSets);
+        } catch (err) {
+            this.setState({error: T('error-decode-json') + ': ' + err.message});
+            return
+        }
+
+        Models.assertion(assertion).then((response) => {
             var data = JSON.parse(response.body);
             if (response.statusCode >= 300) {
                 this.setState({error: formatError(data)});
@@ -199,6 +238,18 @@ class ModelAssertion extends Component {
                             <input type="text" id="display_name" placeholder={T('display_name-description')}
                                 value={ma['display_name']} onChange={this.handleChangeDisplayName} />
                         </label>
+                        <label htmlFor="grade">{T('grade')}:
+                            <select value={ma['grade']} id="grade" onChange={this.handleChangeGrade}>
+                                <option></option>
+                                <option value="dangerous">dangerous</option>
+                                <option value="signed">signed</option>
+                                <option value="secured">secured</option>
+                            </select>
+                        </label>
+                        <label htmlFor="storage-safety">{T('storage-safety')}:
+                            <input type="text" id="storage-safety" placeholder={T('storage-safety-description')}
+                                value={ma['storage_safety']} onChange={this.handleChangeStorageSafety} />
+                        </label>
                         <label htmlFor="architecture">{T('architecture')}:
                             <input type="text" id="architecture" placeholder={T('architecture-description')}
                                 value={ma['architecture']} onChange={this.handleChangeArchitecture} />
@@ -223,6 +274,14 @@ class ModelAssertion extends Component {
                             <textarea onChange={this.handleChangeSnaps} defaultValue={ma['required_snaps']} name="required-snaps"
                                 placeholder={T('required-snaps-description')} />
                         </label>
+                        <label htmlFor="model-snaps">{T('model-snaps')}:
+                            <textarea onChange={this.handleChangeModelSnaps} defaultValue={this.state.snaps} name="model-snaps" rows="6"
+                                placeholder={T('model-snaps-description')} />
+                        </label>
+                        <label htmlFor="validation-sets">{T('validation-sets')}:
+                            <textarea onChange={this.handleChangeValidationSets} defaultValue={this.state.validationSets} name="validation-sets"
+                                placeholder={T('validation-sets-description')} />
+                        </label>
                     </fieldset>
                     {isUserAdmin(this.props.token) ?
                       <span>
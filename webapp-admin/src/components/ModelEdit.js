@@ -87,6 +87,36 @@ class ModelEdit extends Component {
         this.setState({model: model});
     }
 
+    handleChangeSerialFormat = (e) => {
+        var model = this.state.model;
+        model['serial-format'] = e.target.value;
+        this.setState({model: model});
+    }
+
+    handleChangeSerialHeaders = (e) => {
+        var model = this.state.model;
+        model['serial-headers'] = e.target.value;
+        this.setState({model: model});
+    }
+
+    handleChangeSigningHours = (e) => {
+        var model = this.state.model;
+        model['signing-hours'] = e.target.value;
+        this.setState({model: model});
+    }
+
+    handleChangeSigningTimezone = (e) => {
+        var model = this.state.model;
+        model['signing-timezone'] = e.target.value;
+        this.setState({model: model});
+    }
+
+    handleChangeAllowedCIDRs = (e) => {
+        var model = this.state.model;
+        model['allowed-cidrs'] = e.target.value;
+        this.setState({model: model});
+    }
+
     handleChangePrivateKey = (e) => {
         var model = this.state.model;
         model['keypair-id'] = parseInt(e.target.value, 10);
@@ -173,6 +203,26 @@ class ModelEdit extends Component {
                                     <input type="text" id="api-key" placeholder={T('api-key-description')}
                                         value={this.state.model['api-key']} onChange={this.handleChangeAPIKey}/>
                                 </label>
+                                <label htmlFor="serial-format">{T('serial-format')}:
+                                    <input type="text" id="serial-format" placeholder={T('serial-format-description')}
+                                        value={this.state.model['serial-format']} onChange={this.handleChangeSerialFormat}/>
+                                </label>
+                                <label htmlFor="serial-headers">{T('serial-headers')}:
+                                    <input type="text" id="serial-headers" placeholder={T('serial-headers-description')}
+                                        value={this.state.model['serial-headers']} onChange={this.handleChangeSerialHeaders}/>
+                                </label>
+                                <label htmlFor="signing-hours">{T('signing-hours')}:
+                                    <input type="text" id="signing-hours" placeholder={T('signing-hours-description')}
+                                        value={this.state.model['signing-hours']} onChange={this.handleChangeSigningHours}/>
+                                </label>
+                                <label htmlFor="signing-timezone">{T('signing-timezone')}:
+                                    <input type="text" id="signing-timezone" placeholder={T('signing-timezone-description')}
+                                        value={this.state.model['signing-timezone']} onChange={this.handleChangeSigningTimezone}/>
+                                </label>
+                                <label htmlFor="allowed-cidrs">{T('allowed-cidrs')}:
+                                    <input type="text" id="allowed-cidrs" placeholder={T('allowed-cidrs-description')}
+                                        value={this.state.model['allowed-cidrs']} onChange={this.handleChangeAllowedCIDRs}/>
+                                </label>
                                 <label htmlFor="keypair">{T('private-key')}:
                                     <select value={this.state.model['keypair-id']} id="keypair" onChange={this.handleChangePrivateKey}>
                                         <option />
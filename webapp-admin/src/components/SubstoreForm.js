@@ -25,6 +25,16 @@ class SubstoreForm extends Component {
         this.props.onChange('serialnumber', e.target.value)
     }
 
+    handleChangeMatchType = (e) => {
+        e.preventDefault()
+        this.props.onChange('matchtype', e.target.value)
+    }
+
+    handleChangeSerialEnd = (e) => {
+        e.preventDefault()
+        this.props.onChange('serialnumberend', e.target.value)
+    }
+
     handleChangeSubstore = (e) => {
         e.preventDefault()
         this.props.onChange('store', e.target.value)
@@ -35,11 +45,20 @@ class SubstoreForm extends Component {
         this.props.onChange('fromModelID', parseInt(e.target.value, 10))
     }
 
+    handleChangeFromModelName = (e) => {
+        e.preventDefault()
+        this.props.onChange('frommodelname', e.target.value)
+    }
+
     handleChangeModelName = (e) => {
         e.preventDefault()
         this.props.onChange('modelname', e.target.value)
     }
 
+    handleChangeOriginalHeaders = (e) => {
+        this.props.onChange('originalheaders', e.target.checked)
+    }
+
     render() {
 
         var b = this.props.substore
@@ -58,11 +77,33 @@ class SubstoreForm extends Component {
                                 </select>
                             </label>
 
-                            <label htmlFor="serial">{T('serial-number')}:
+                            <label htmlFor="frommodelname">{T('pivot-from-model')}:
+                                <input type="text" id="frommodelname" placeholder={T('pivot-from-model-description')}
+                                    value={b.frommodelname} onChange={this.handleChangeFromModelName} />
+                            </label>
+
+                            <label htmlFor="matchtype">{T('serial-match')}:
+                                <select value={b.matchtype || 'exact'} id="matchtype" onChange={this.handleChangeMatchType}>
+                                    <option value="exact">{T('serial-match-exact')}</option>
+                                    <option value="range">{T('serial-match-range')}</option>
+                                    <option value="glob">{T('serial-match-glob')}</option>
+                                    <option value="regex">{T('serial-match-regex')}</option>
+                                </select>
+                            </label>
+
+                            <label htmlFor="serial">{b.matchtype === 'glob' || b.matchtype === 'regex' ? T('serial-pattern') : T('serial-number')}:
                                 <input type="text" id="serial" placeholder={T('serial-number-description')}
                                     value={b.serialnumber} onChange={this.handleChangeSerial} />
                             </label>
 
+                            {b.matchtype === 'range' ?
+                                <label htmlFor="serialend">{T('serial-number-end')}:
+                                    <input type="text" id="serialend" placeholder={T('serial-number-end-description')}
+                                        value={b.serialnumberend} onChange={this.handleChangeSerialEnd} />
+                                </label>
+                                : ''
+                            }
+
                             <label htmlFor="substore">{T('substore')}:
                                 <input type="text" id="substore" placeholder={T('substore-description')}
                                     value={b.store} onChange={this.handleChangeSubstore} />
@@ -72,6 +113,11 @@ class SubstoreForm extends Component {
                                 <input type="text" id="modelname" placeholder={T('modelname-description')}
                                     value={b.modelname} onChange={this.handleChangeModelName} />
                             </label>
+
+                            <label htmlFor="originalheaders">{T('original-headers')}
+                                <input className="visible" type="checkbox" id="originalheaders"
+                                    checked={b.originalheaders || false} onChange={this.handleChangeOriginalHeaders} />
+                            </label>
                         </fieldset>
                         {isUserAdmin(this.props.token) ?
                           <span>
@@ -150,8 +150,8 @@ class SubstoreList extends Component {
                 <td>
                     {this.renderActions(b)}
                 </td>
-                <td className="overflow" title={b.fromModel.model}>{b.fromModel.model}</td>
-                <td className="overflow" title={b.serialnumber}>{b.serialnumber}</td>
+                <td className="overflow" title={b.fromModel.model}>{b.frommodelname ? b.fromModel.model + ' / ' + b.frommodelname : b.fromModel.model}</td>
+                <td className="overflow" title={b.serialnumber}>{b.matchtype === 'range' ? b.serialnumber + ' - ' + b.serialnumberend : b.serialnumber}</td>
                 <td className="overflow" title={b.store}>{b.store}</td>
                 <td className="overflow" title={b.modelname}>{b.modelname}</td>
             </tr>
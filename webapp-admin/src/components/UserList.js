@@ -81,14 +81,23 @@ class UserList extends Component {
       return;
     }
 
-    Users.delete(users[0]).then((response) => {
-      var data = JSON.parse(response.body);
-      if ((response.statusCode >= 300) || (!data.success)) {
-        this.setState({message: this.formatError(data)});
-      } else {
-        window.location = '/users';
-      }
-    });
+    // Users are deactivated rather than deleted, to keep the audit trail
+    Users.deactivate(users[0]).then(this.handleStatusResponse);
+  }
+
+  handleActivate = (e) => {
+    e.preventDefault();
+    var userId = parseInt(e.target.getAttribute('data-key'), 10);
+    Users.activate({ID: userId}).then(this.handleStatusResponse);
+  }
+
+  handleStatusResponse = (response) => {
+    var data = JSON.parse(response.body);
+    if ((response.statusCode >= 300) || (!data.success)) {
+      this.setState({message: this.formatError(data), confirmDelete: null});
+    } else {
+      window.location = '/users';
+    }
   }
 
   handleDeleteUserCancel = (e) => {
@@ -109,7 +118,7 @@ class UserList extends Component {
           <tbody>
             {this.state.users.map((user) => {
               return (
-                <UserRow key={user.ID} user={user} delete={this.handleDelete} confirmDelete={this.state.confirmDelete}
+                <UserRow key={user.ID} user={user} delete={this.handleDelete} activate={this.handleActivate} confirmDelete={this.state.confirmDelete}
                   deleteUser={this.handleDeleteUser} cancelDelete={this.handleDeleteUserCancel} />
               );
             })}
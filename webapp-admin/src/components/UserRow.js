@@ -26,13 +26,18 @@ class UserRow extends Component {
 				<div>
 					<a href={'/users/'.concat(this.props.user.ID, '/edit')} className="p-button--brand" title={T('edit-user')}><i className="fa fa-pencil"></i></a>
 					&nbsp;
-					<a href="" onClick={this.props.delete} data-key={this.props.user.ID} className="p-button--neutral" title={T('delete-user')}>
-						<i className="fa fa-trash" data-key={this.props.user.ID}></i></a>
+					{this.props.user.Active ?
+						<a href="" onClick={this.props.delete} data-key={this.props.user.ID} className="p-button--neutral" title={T('deactivate-user')}>
+							<i className="fa fa-ban" data-key={this.props.user.ID}></i></a>
+						:
+						<a href="" onClick={this.props.activate} data-key={this.props.user.ID} className="p-button--neutral" title={T('activate-user')}>
+							<i className="fa fa-check" data-key={this.props.user.ID}></i></a>
+					}
 				</div>
 			);
 		} else {
 			return (
-				<DialogBox message={T('confirm-user-delete')} handleYesClick={this.props.deleteUser} handleCancelClick={this.props.cancelDelete} />
+				<DialogBox message={T('confirm-user-deactivate')} handleYesClick={this.props.deleteUser} handleCancelClick={this.props.cancelDelete} />
 			);
 		}
 	}
@@ -43,7 +48,7 @@ class UserRow extends Component {
 				<td>
 					{this.renderActions()}
 				</td>
-				<td className="overflow" title={this.props.user.Username}>{this.props.user.Username}</td>
+				<td className="overflow" title={this.props.user.Username}>{this.props.user.Username}{this.props.user.Active ? '' : ' (' + T('inactive') + ')'}</td>
 				<td className="overflow" title={this.props.user.Name}>{this.props.user.Name}</td>
 				<td className="overflow" title={this.props.user.Email}>{this.props.user.Email}</td>
 				<td className="overflow" title={roleAsString(this.props.user.Role)}>{roleAsString(this.props.user.Role)}</td>
@@ -87,6 +87,28 @@ export function getAuthToken(callback) {
         var jwt = resp.headers.authorization
 
         if (!jwt) {
+            // The JWT has expired, so try to renew it with the refresh token
+            refreshAuthToken(callback)
+            return
+        }
+        var token = jwtDecode(jwt)
+
+        if (!token) {
+            callback({})
+            return
+        }
+        callback(token)
+    })
+
+}
+
+export function refreshAuthToken(callback) {
+
+    // Exchange the refresh token cookie for a new JWT, without logging in again
+    Ajax.refreshAuthToken().then((resp) => {
+        var jwt = resp.headers.authorization
+
+        if ((resp.statusCode >= 300) || (!jwt)) {
             callback({})
             return
         }
@@ -132,6 +154,9 @@ export function roleAsString(role) {
         case Role.Standard:
             str = "Standard"	
             break;
+        case Role.SubstoreAdmin:
+            str = "Sub-store Admin"
+            break;
         case Role.SyncUser:
             str = "Sync API"
             break;
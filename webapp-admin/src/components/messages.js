@@ -51,6 +51,7 @@ var intlData = {
       "confirm-log-delete": "Remove this log?",
       "confirm-model-delete": "Remove this model?",
       "confirm-store-delete": "Remove this sub-store model?",
+      "confirm-user-deactivate": "Deactivate this user? The user will not be able to log in or use the API.",
       "confirm-user-delete": "Remove this user?",
       "copy-api-key": "Copy API key to clipboard",
       "create-assertion": "Error creating the assertion",
@@ -59,6 +60,8 @@ var intlData = {
       "deactivate": "Deactivate",
       "delete-log": "Delete log",
       "delete-model": "Delete model",
+      "deactivate-user": "Deactivate user",
+      "activate-user": "Reactivate user",
       "delete-user": "Delete user",
       "description": "The Serial Vault is a web service that generates cryptographically-signed serial assertions.",
       "display_name": "Display Name",
@@ -169,15 +172,44 @@ var intlData = {
       "remove": "Remove",
       "required-snaps": "Required Snaps",
       "required-snaps-description": "(optional) List of required snaps - enter a comma-separated list",
+      "grade": "Grade",
+      "storage-safety": "Storage Safety",
+      "storage-safety-description": "(optional, Ubuntu Core 20) prefer-unencrypted, prefer-encrypted or encrypted",
+      "model-snaps": "Snaps",
+      "model-snaps-description": "(Ubuntu Core 20) JSON list of snaps e.g. [{\"name\": \"pc\", \"id\": \"...\", \"type\": \"gadget\", \"default-channel\": \"20/stable\"}]",
+      "validation-sets": "Validation Sets",
+      "validation-sets-description": "(optional, Ubuntu Core 20) JSON list of validation sets e.g. [{\"account-id\": \"...\", \"name\": \"...\", \"mode\": \"enforce\"}]",
       "reseller": "Reseller",
       "reseller-features": "Enable Reseller Features",
+      "hash-serial-numbers": "Store Serial Numbers as Hashes",
       "revision": "Revision",
       "revision-description": "Revision of the assertion",
       "role": "Role",
       "save": "Save",
       "select-accounts": "Select below the accounts this user belongs to:",
+      "serial-format": "Serial Number Format",
+      "serial-format-description": "(optional) Regular expression that the serial numbers of the devices must match e.g. A[0-9]{6}L",
+      "serial-headers": "Serial Headers",
+      "serial-headers-description": "(optional) Comma-separated list of serial-request headers that are copied to the serial assertion e.g. factory-line,hardware-revision",
+      "signing-hours": "Signing Hours",
+      "signing-hours-description": "(optional) Comma-separated ranges of the hours that the model can be signed e.g. 06:00-14:00,22:00-06:00",
+      "signing-timezone": "Signing Time Zone",
+      "signing-timezone-description": "(optional) Time zone of the signing hours e.g. Europe/London. Defaults to UTC",
+      "allowed-cidrs": "Allowed Networks",
+      "allowed-cidrs-description": "(optional) Comma-separated list of IP addresses or CIDR ranges that the model can be signed from e.g. 192.0.2.0/24",
+      "original-headers": "Embed the original brand and model in the pivoted assertions",
+      "pivot-from-model": "Pivoted Model",
+      "pivot-from-model-description": "(optional) Pivoted model that the devices are pivoted from again e.g. alder-switch",
       "serial-number-description": "Serial Number of the device",
       "serial-number": "Serial Number",
+      "serial-number-end": "Last Serial Number",
+      "serial-number-end-description": "Last serial number of the range e.g. A0999",
+      "serial-pattern": "Serial Number Pattern",
+      "serial-match": "Serial Number Match",
+      "serial-match-exact": "Exact serial number",
+      "serial-match-range": "Range of serial numbers",
+      "serial-match-glob": "Glob pattern e.g. LINE1-*",
+      "serial-match-regex": "Regular expression",
       "series": "Series",
       "series-description": "Snap namespace series",
       "signing-key": "Signing Key",
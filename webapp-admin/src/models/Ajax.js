@@ -28,6 +28,10 @@ var Ajax = {
 		return this.get('authtoken')
 	},
 
+	refreshAuthToken: function() {
+		return this.post('token/refresh')
+	},
+
 	get: function(url, qs) {
 			if (!qs) {
 				qs = {};
@@ -39,8 +39,57 @@ var Account = {
         return Ajax.post(this.url + '/upload', {assertion: assertion});
     },
 
+    refresh() {
+        return Ajax.post(this.url + '/refresh', {});
+    },
+
+    quota(id) {
+        return Ajax.get(this.url + '/' + id + '/quota');
+    },
+
+    quotaUpdate(id, quota) {
+        return Ajax.put(this.url + '/' + id + '/quota', quota);
+    },
+
+    retention(id) {
+        return Ajax.get(this.url + '/' + id + '/retention');
+    },
+
+    retentionUpdate(id, retention) {
+        return Ajax.put(this.url + '/' + id + '/retention', retention);
+    },
+
+    nonceTTL(id) {
+        return Ajax.get(this.url + '/' + id + '/noncettl');
+    },
+
+    nonceTTLUpdate(id, nonceTTL) {
+        return Ajax.put(this.url + '/' + id + '/noncettl', nonceTTL);
+    },
+
+    apiKeys(id) {
+        return Ajax.get(this.url + '/' + id + '/apikeys');
+    },
+
+    apiKeyCreate(id, apiKey) {
+        return Ajax.post(this.url + '/' + id + '/apikeys', apiKey);
+    },
+
+    apiKeyUpdate(id, apiKey) {
+        return Ajax.put(this.url + '/' + id + '/apikeys/' + apiKey.id, apiKey);
+    },
+
+    apiKeyRotate(id, apiKey) {
+        return Ajax.post(this.url + '/' + id + '/apikey/rotate', {id: apiKey.id});
+    },
+
+    apiKeyDelete(id, apiKey) {
+        return Ajax.delete(this.url + '/' + id + '/apikeys/' + apiKey.id, {});
+    },
+
+    // The sub-store list of the UI is not paged, so it fetches all the sub-store models
     stores(id) {
-        return Ajax.get(this.url + '/' + id + '/stores');
+        return Ajax.get(this.url + '/' + id + '/stores', {all: true});
     },
 
     storeNew(accountID, store) {
@@ -0,0 +1,41 @@
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+import Ajax from './Ajax';
+
+var Logging = {
+	url: 'logging',
+
+	get: function() {
+		return Ajax.get(this.url);
+	},
+
+	// The routes are logged verbosely for a duration e.g. {prefix: '/v1/serial', duration: '30m'}
+	update: function(maskSerials, maskKeyIDs, routes) {
+		return Ajax.put(this.url, {maskSerials: maskSerials, maskKeyIDs: maskKeyIDs, routes: routes || []});
+	},
+
+	getLevel: function() {
+		return Ajax.get('admin/loglevel');
+	},
+
+	// The level is one of debug, info, warning, error or fatal
+	updateLevel: function(level) {
+		return Ajax.put('admin/loglevel', {level: level});
+	}
+}
+
+export default Logging;
@@ -19,8 +19,9 @@ import Ajax from './Ajax';
 var Models = {
 	url: 'models',
 
+	// The model list of the UI is not paged, so it fetches all the models
 	list: function () {
-		return Ajax.get(this.url);
+		return Ajax.get(this.url, {all: true});
 	},
 
 	get: function(modelId) {
@@ -19,10 +19,13 @@ import Ajax from './Ajax';
 var SigningLog = {
     url: 'signinglog',
 
-	list: function (fromID) {
+	list: function (token, limit) {
 		var data = {};
-		if (fromID) {
-			data.fromID = fromID;
+		if (token) {
+			data.token = token;
+		}
+		if (limit) {
+			data.limit = limit;
 		}
 		return Ajax.get(this.url, data);
 	},
@@ -35,10 +38,34 @@ var SigningLog = {
 		});
 	},
 
+	listForFingerprint: function(fingerprint) {
+		return Ajax.get(this.url + '/fingerprint/' + encodeURIComponent(fingerprint));
+	},
+
 	filters: function(authorityID) {
 		return Ajax.get(this.url + '/account/' + authorityID + '/filters');
 	},
 
+	// The export is streamed by the server, so it is downloaded from a link to the URL
+	exportURL: function(format, filter) {
+		var params = Object.assign({format: format || 'csv'}, filter);
+		var query = Object.keys(params).filter(function(k) {
+			return params[k];
+		}).map(function(k) {
+			return encodeURIComponent(k) + '=' + encodeURIComponent(params[k]);
+		}).join('&');
+		return '/v1/' + this.url + '/export?' + query;
+	},
+
+	purges: function() {
+		return Ajax.get(this.url + '/purges');
+	},
+
+	// The number of devices signed by model and signing-key, for each day or week of the range
+	report: function(period, filter) {
+		return Ajax.get('report/signings', Object.assign({period: period || 'day'}, filter));
+	},
+
 	download: function(authorityID, filter, serialnumber) {
 		Ajax.get(this.url + '/account/' + authorityID , {
 			all: true,
@@ -39,6 +39,14 @@ var Users = {
 		return Ajax.delete(this.url + '/' + user.ID, {});
 	},
 
+	deactivate:  function(user) {
+		return Ajax.post(this.url + '/' + user.ID + '/deactivate', {});
+	},
+
+	activate:  function(user) {
+		return Ajax.post(this.url + '/' + user.ID + '/activate', {});
+	},
+
 	create:  function(user) {
 		return Ajax.post(this.url, user);
 	}
@@ -0,0 +1,264 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+)
+
+// EventMigration is the event of the deployment when the database schema is updated. It is only
+// posted to the chat channels of the deployment
+const EventMigration = "migration"
+
+// The actions of the keypair events for the generation of a signing-key, which completes in the
+// background after it is requested
+const (
+	ActionGenerated      = "generated"
+	ActionGenerateFailed = "generate-failed"
+)
+
+// defaultChatEvents are the events that are posted to the chat channels of the deployment, when
+// they are not configured
+var defaultChatEvents = []string{datastore.WebhookEventKeypair, datastore.WebhookEventSigningAnomaly, EventMigration}
+
+var validChatEvents = []string{datastore.WebhookEventKeypair, datastore.WebhookEventAccount, datastore.WebhookEventSigningAnomaly, EventMigration}
+
+// ChatChannel is the incoming webhook of a Slack or Microsoft Teams channel
+type ChatChannel struct {
+	Format string
+	URL    string
+}
+
+// ChatConfig is the chat channels of the deployment from the config settings, which are posted the
+// events of all the accounts
+type ChatConfig struct {
+	Channels []ChatChannel
+	Events   []string
+}
+
+// NewChatConfig returns the chat channels of the deployment from the config settings
+func NewChatConfig(settings config.Settings) (ChatConfig, error) {
+	cfg := ChatConfig{Events: defaultChatEvents}
+
+	for _, c := range []ChatChannel{{datastore.WebhookFormatSlack, settings.SlackWebhookURL}, {datastore.WebhookFormatTeams, settings.TeamsWebhookURL}} {
+		if len(c.URL) == 0 {
+			continue
+		}
+		u, err := url.Parse(c.URL)
+		if err != nil || u.Scheme != "https" || len(u.Host) == 0 {
+			return ChatConfig{}, fmt.Errorf("the %s webhook must be an https URL", c.Format)
+		}
+		cfg.Channels = append(cfg.Channels, c)
+	}
+
+	if len(settings.ChatEvents) > 0 {
+		cfg.Events = nil
+		for _, e := range settings.ChatEvents {
+			if !inList(validChatEvents, e) {
+				return ChatConfig{}, fmt.Errorf("invalid chat event '%s', expected one of: %s", e, strings.Join(validChatEvents, ", "))
+			}
+			cfg.Events = append(cfg.Events, e)
+		}
+	}
+	return cfg, nil
+}
+
+// Enabled checks whether the event is posted to a chat channel of the deployment
+func (c ChatConfig) Enabled(event string) bool {
+	return len(c.Channels) > 0 && inList(c.Events, event)
+}
+
+// PostChat posts the event to the chat channels of the deployment that are sent the event. The
+// event is not retried, and the channels that fail are returned as an error
+func PostChat(ctx context.Context, cfg ChatConfig, e Event) error {
+	if !cfg.Enabled(e.Event) {
+		return nil
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	failed := []string{}
+	for _, c := range cfg.Channels {
+		message, err := ChatMessage(c.Format, payload)
+		if err == nil {
+			err = postMessage(ctx, c.URL, message)
+		}
+		if err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", c.Format, err))
+		}
+	}
+	if len(failed) > 0 {
+		return errors.New(strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// PublishDeployment posts an event of the deployment, which has no account, to the chat channels
+// of the deployment. An error is logged, as the change of the event has already been completed
+func PublishDeployment(ctx context.Context, event, action string, data interface{}) {
+	cfg, err := NewChatConfig(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error in the chat config: %v", err)
+		return
+	}
+
+	e := Event{Event: event, Action: action, Timestamp: time.Now().UTC(), Data: data}
+	if err := PostChat(ctx, cfg, e); err != nil {
+		log.Errorf("Error posting the %s event to the chat channels: %v", event, err)
+	}
+}
+
+// publishChat posts the event of an account to the chat channels of the deployment in the background
+func publishChat(ctx context.Context, e Event) {
+	cfg, err := NewChatConfig(datastore.Environ.Config)
+	if err != nil {
+		log.Errorf("Error in the chat config: %v", err)
+		return
+	}
+	if !cfg.Enabled(e.Event) {
+		return
+	}
+
+	datastore.RunBackgroundJob(ctx, "chat "+e.Event, func(ctx context.Context) {
+		ctx, cancel := context.WithTimeout(ctx, deliveryTimeout)
+		defer cancel()
+		if err := PostChat(ctx, cfg, e); err != nil {
+			log.Errorf("Error posting the %s event of %s to the chat channels: %v", e.Event, e.AuthorityID, err)
+		}
+	})
+}
+
+// ChatMessage converts the JSON payload of an event to the message of a Slack or a Microsoft Teams
+// incoming webhook
+func ChatMessage(format string, payload []byte) ([]byte, error) {
+	e := struct {
+		Event       string                 `json:"event"`
+		Action      string                 `json:"action"`
+		AuthorityID string                 `json:"authorityID"`
+		Data        map[string]interface{} `json:"data"`
+	}{}
+	if err := json.Unmarshal(payload, &e); err != nil {
+		return nil, fmt.Errorf("invalid event: %v", err)
+	}
+	text := chatText(e.Event, e.Action, e.AuthorityID, e.Data)
+
+	switch format {
+	case datastore.WebhookFormatSlack:
+		return json.Marshal(map[string]string{"text": text})
+	case datastore.WebhookFormatTeams:
+		return json.Marshal(map[string]string{
+			"@type":    "MessageCard",
+			"@context": "https://schema.org/extensions",
+			"summary":  fmt.Sprintf("Serial Vault %s event", e.Event),
+			"text":     text,
+		})
+	default:
+		return nil, fmt.Errorf("invalid chat format '%s'", format)
+	}
+}
+
+// chatText is the text of the chat message of an event
+func chatText(event, action, authorityID string, data map[string]interface{}) string {
+	switch event {
+	case datastore.WebhookEventKeypair:
+		if name := objectField(data, "KeyName"); len(name) > 0 {
+			return fmt.Sprintf("Serial Vault: signing-key %s of %s: %s", name, authorityID, action)
+		}
+		return fmt.Sprintf("Serial Vault: signing-key of %s: %s", authorityID, action)
+	case datastore.WebhookEventSigningAnomaly:
+		return fmt.Sprintf("Serial Vault: signing anomaly of %s/%s: %s (%s)", authorityID, field(data, "model"), action, field(data, "message"))
+	case EventMigration:
+		return fmt.Sprintf("Serial Vault: the database schema is updated from version %s to %s", field(data, "from"), field(data, "version"))
+	default:
+		if object := field(data, "object"); len(object) > 0 {
+			return fmt.Sprintf("Serial Vault: %s %s of %s: %s", event, object, authorityID, action)
+		}
+		return fmt.Sprintf("Serial Vault: %s of %s: %s", event, authorityID, action)
+	}
+}
+
+// field returns a field of the data of an event as text
+func field(data map[string]interface{}, name string) string {
+	value, ok := data[name]
+	if !ok || value == nil {
+		return ""
+	}
+	return fmt.Sprint(value)
+}
+
+// objectField returns a field of the object of an audited event, after or before the change
+func objectField(data map[string]interface{}, name string) string {
+	for _, key := range []string{"after", "before"} {
+		if object, ok := data[key].(map[string]interface{}); ok {
+			if value := field(object, name); len(value) > 0 {
+				return value
+			}
+		}
+	}
+	return field(data, name)
+}
+
+// postMessage posts a message to the incoming webhook of a chat channel
+func postMessage(ctx context.Context, address string, message []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, address, bytes.NewReader(message))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the chat webhook responded with HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+func inList(items []string, item string) bool {
+	for _, i := range items {
+		if i == item {
+			return true
+		}
+	}
+	return false
+}
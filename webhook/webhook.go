@@ -0,0 +1,312 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+
+// Package webhook queues the events of the accounts for their webhooks, and delivers them in
+// the background with retries. The deliveries are signed with the secret of the webhook, and
+// the events are also posted to the Slack or Microsoft Teams channels of the deployment
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+	"github.com/CanonicalLtd/serial-vault/scheduler"
+	"github.com/CanonicalLtd/serial-vault/service/log"
+	"github.com/CanonicalLtd/serial-vault/service/tracing"
+)
+
+// The headers of a webhook delivery
+const (
+	HeaderEvent     = "X-Serial-Vault-Event"
+	HeaderDelivery  = "X-Serial-Vault-Delivery"
+	HeaderTimestamp = "X-Serial-Vault-Timestamp"
+	HeaderSignature = "X-Serial-Vault-Signature"
+)
+
+// defaultInterval is the interval of the deliveries when it is not configured
+const defaultInterval = 30 * time.Second
+
+// deliveryTimeout is the longest time to deliver an event to a webhook
+const deliveryTimeout = 10 * time.Second
+
+// batchSize is the number of due deliveries that are attempted on each run
+const batchSize = 100
+
+// maxAttempts is the number of attempts of a delivery, after which it has failed
+const maxAttempts = 8
+
+// The backoff of the retries, which is doubled after each attempt up to the longest wait
+const (
+	firstRetry = time.Minute
+	maxRetry   = 6 * time.Hour
+)
+
+// maxResponseSize is the size of the response of a webhook that is read, to reuse the connection
+const maxResponseSize = 64 * 1024
+
+// The deliveries are not sent through a proxy and do not follow the redirects, so the address of
+// every connection is checked when it is dialed
+var client = &http.Client{
+	Timeout: deliveryTimeout,
+	Transport: tracing.Transport(&http.Transport{
+		DialContext:         (&net.Dialer{Timeout: deliveryTimeout, Control: dialControl}).DialContext,
+		TLSHandshakeTimeout: deliveryTimeout,
+		MaxIdleConns:        10,
+		IdleConnTimeout:     90 * time.Second,
+	}),
+	CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	},
+}
+
+// reservedNetworks are the networks that are not public, besides the loopback, private and
+// link-local addresses
+var reservedNetworks = parseNetworks("0.0.0.0/8", "100.64.0.0/10", "192.0.0.0/24", "198.18.0.0/15", "240.0.0.0/4", "64:ff9b::/96")
+
+// allowAddress checks the address of a connection to a webhook. The webhooks must resolve to a
+// public address, so the vault cannot be used to reach the internal services
+var allowAddress = publicAddress
+
+// dialControl refuses the connections to the addresses that are not allowed. The address is
+// checked after the host name is resolved, so a name cannot be changed to resolve elsewhere
+func dialControl(network, address string, c syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !allowAddress(ip) {
+		return fmt.Errorf("the webhook address %s is not a public address", host)
+	}
+	return nil
+}
+
+// publicAddress checks that an address is not a loopback, private, link-local or reserved address
+func publicAddress(ip net.IP) bool {
+	if ip.IsLoopback() || ip.IsPrivate() || ip.IsUnspecified() || ip.IsMulticast() ||
+		ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() || ip.IsInterfaceLocalMulticast() {
+		return false
+	}
+	for _, n := range reservedNetworks {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+func parseNetworks(cidrs ...string) []*net.IPNet {
+	networks := []*net.IPNet{}
+	for _, c := range cidrs {
+		_, n, err := net.ParseCIDR(c)
+		if err != nil {
+			panic(err)
+		}
+		networks = append(networks, n)
+	}
+	return networks
+}
+
+// Event is the JSON payload of a webhook delivery
+type Event struct {
+	Event       string      `json:"event"`
+	Action      string      `json:"action"`
+	AuthorityID string      `json:"authorityID"`
+	Timestamp   time.Time   `json:"timestamp"`
+	Data        interface{} `json:"data,omitempty"`
+}
+
+// Interval returns the interval of the webhook deliveries from the config settings
+func Interval(settings config.Settings) (time.Duration, error) {
+	if len(settings.WebhookDeliveryInterval) == 0 {
+		return defaultInterval, nil
+	}
+
+	interval, err := time.ParseDuration(settings.WebhookDeliveryInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid webhook delivery interval: %v", err)
+	}
+	if interval <= 0 {
+		return 0, fmt.Errorf("the webhook delivery interval must be positive")
+	}
+	return interval, nil
+}
+
+// Job delivers the queued events when the scheduler starts, and then at the interval
+func Job(interval time.Duration) scheduler.Job {
+	return scheduler.Job{Name: "webhook-delivery", Interval: interval, RunAtStart: true, Run: func(ctx context.Context) error {
+		_, err := Deliver(ctx, datastore.Environ.DB)
+		return err
+	}}
+}
+
+// Publish queues an event of the account for its webhooks that are registered for the event,
+// and posts it to the chat channels of the deployment. An error is logged, as the change of the
+// event has already been completed
+func Publish(ctx context.Context, authorityID, event, action string, data interface{}) {
+	if len(authorityID) == 0 {
+		return
+	}
+
+	e := Event{Event: event, Action: action, AuthorityID: authorityID, Timestamp: time.Now().UTC(), Data: data}
+	payload, err := json.Marshal(e)
+	if err != nil {
+		log.Errorf("Error converting the %s webhook event: %v", event, err)
+		return
+	}
+
+	if _, err := datastore.Environ.DB.QueueWebhookDeliveries(ctx, authorityID, event, string(payload)); err != nil {
+		log.Errorf("Error queueing the %s webhook event of %s: %v", event, authorityID, err)
+	}
+	publishChat(ctx, e)
+}
+
+// Deliver attempts the deliveries that are due, and purges the deliveries after their retention.
+// It returns the number of events that were delivered
+func Deliver(ctx context.Context, db datastore.Datastore) (int, error) {
+	deliveries, err := db.ClaimWebhookDeliveries(ctx, time.Now(), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	delivered, failed := 0, 0
+	for _, d := range deliveries {
+		if err := ctx.Err(); err != nil {
+			return delivered, err
+		}
+
+		d = attempt(ctx, d, time.Now().UTC())
+		if err := db.UpdateWebhookDelivery(ctx, d); err != nil {
+			return delivered, err
+		}
+		switch d.Status {
+		case datastore.WebhookDeliveryDelivered:
+			delivered++
+		case datastore.WebhookDeliveryFailed:
+			log.Warningf("Webhook delivery %d of webhook %d failed after %d attempts: %s", d.ID, d.WebhookID, d.Attempts, d.LastError)
+			failed++
+		}
+	}
+	if delivered > 0 || failed > 0 {
+		log.Infof("Webhooks: %d events delivered, %d failed", delivered, failed)
+	}
+
+	if _, err := db.PurgeWebhookDeliveries(ctx, time.Now()); err != nil {
+		return delivered, err
+	}
+	return delivered, nil
+}
+
+// Signature returns the HMAC-SHA256 signature of a delivery, in hex. The signature covers the
+// Unix timestamp and the payload, separated by a dot
+func Signature(secret, timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%s.", timestamp)
+	mac.Write(payload)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// attempt sends the event of a delivery to its webhook, and returns the delivery with the result
+// of the attempt. A failed attempt is retried with a backoff, until the last attempt
+func attempt(ctx context.Context, d datastore.WebhookDelivery, now time.Time) datastore.WebhookDelivery {
+	d.Attempts++
+	d.ResponseCode = 0
+
+	err := send(ctx, &d, now)
+	if err == nil {
+		d.Status = datastore.WebhookDeliveryDelivered
+		d.LastError = ""
+		d.Delivered = &now
+		return d
+	}
+
+	d.LastError = err.Error()
+	if d.Attempts >= maxAttempts {
+		d.Status = datastore.WebhookDeliveryFailed
+		return d
+	}
+	d.NextAttempt = now.Add(backoff(d.Attempts))
+	return d
+}
+
+// send posts the payload of the delivery to the URL of the webhook, recording the response code.
+// The payload is converted to a message for the webhooks of the chat channels. The webhook must
+// accept the event with a 2xx response
+func send(ctx context.Context, d *datastore.WebhookDelivery, now time.Time) error {
+	payload := []byte(d.Payload)
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+
+	if d.Format == datastore.WebhookFormatSlack || d.Format == datastore.WebhookFormatTeams {
+		message, err := ChatMessage(d.Format, payload)
+		if err != nil {
+			return err
+		}
+		payload = message
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, d.Event)
+	req.Header.Set(HeaderDelivery, strconv.Itoa(d.ID))
+	req.Header.Set(HeaderTimestamp, timestamp)
+	req.Header.Set(HeaderSignature, Signature(d.Secret, timestamp, payload))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxResponseSize))
+
+	d.ResponseCode = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("the webhook responded with HTTP %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff returns the wait before the next attempt of a delivery, after the attempts
+func backoff(attempts int) time.Duration {
+	wait := firstRetry
+	for i := 1; i < attempts && wait < maxRetry; i++ {
+		wait *= 2
+	}
+	if wait > maxRetry {
+		wait = maxRetry
+	}
+	return wait
+}
@@ -0,0 +1,317 @@
+// -*- Mode: Go; indent-tabs-mode: t -*-
+
+/*
+ * Copyright (C) 2020 Canonical Ltd
+ * License granted by Canonical Limited
+ *
+ * This program is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License version 3 as
+ * published by the Free Software Foundation.
+ *
+ * This program is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with this program.  If not, see <http://www.gnu.org/licenses/>.
+ *
+ */
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/CanonicalLtd/serial-vault/config"
+	"github.com/CanonicalLtd/serial-vault/datastore"
+)
+
+func TestInterval(t *testing.T) {
+	tests := []struct {
+		interval string
+		want     time.Duration
+		valid    bool
+	}{
+		{"", defaultInterval, true},
+		{"1m", time.Minute, true},
+		{"invalid", 0, false},
+		{"0s", 0, false},
+		{"-1m", 0, false},
+	}
+
+	for _, tt := range tests {
+		interval, err := Interval(config.Settings{WebhookDeliveryInterval: tt.interval})
+		if (err == nil) != tt.valid {
+			t.Errorf("Interval %q: expected valid %v, got error: %v", tt.interval, tt.valid, err)
+			continue
+		}
+		if interval != tt.want {
+			t.Errorf("Interval %q: expected %v, got %v", tt.interval, tt.want, interval)
+		}
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	tests := []struct {
+		attempts int
+		want     time.Duration
+	}{
+		{1, time.Minute},
+		{2, 2 * time.Minute},
+		{4, 8 * time.Minute},
+		{20, maxRetry},
+	}
+
+	for _, tt := range tests {
+		if wait := backoff(tt.attempts); wait != tt.want {
+			t.Errorf("Backoff %d: expected %v, got %v", tt.attempts, tt.want, wait)
+		}
+	}
+}
+
+func TestAttempt(t *testing.T) {
+	var received http.Header
+	var body []byte
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.Header
+		body, _ = ioutil.ReadAll(r.Body)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	now := time.Now().UTC()
+	d := datastore.WebhookDelivery{ID: 7, WebhookID: 1, Event: datastore.WebhookEventKeypair, Payload: `{"event":"keypair"}`,
+		Status: datastore.WebhookDeliveryPending, URL: server.URL, Secret: "secret"}
+
+	// The test server is on the loopback address, which is refused
+	result := attempt(context.Background(), d, now)
+	if result.Status != datastore.WebhookDeliveryPending || !strings.Contains(result.LastError, "not a public address") || body != nil {
+		t.Fatalf("Attempt: expected the loopback address to be refused: %v", result)
+	}
+	allowLocalAddresses(t)
+
+	result = attempt(context.Background(), d, now)
+	if result.Status != datastore.WebhookDeliveryDelivered || result.Attempts != 1 || result.ResponseCode != http.StatusOK || result.Delivered == nil {
+		t.Fatalf("Attempt: unexpected delivery: %v", result)
+	}
+	if string(body) != d.Payload || received.Get(HeaderEvent) != "keypair" || received.Get(HeaderDelivery) != "7" {
+		t.Errorf("Attempt: unexpected request: %v %s", received, body)
+	}
+	if received.Get(HeaderSignature) != Signature("secret", received.Get(HeaderTimestamp), body) {
+		t.Errorf("Attempt: invalid signature: %s", received.Get(HeaderSignature))
+	}
+
+	// A failed attempt is retried with the backoff
+	status = http.StatusInternalServerError
+	result = attempt(context.Background(), d, now)
+	if result.Status != datastore.WebhookDeliveryPending || result.ResponseCode != http.StatusInternalServerError || len(result.LastError) == 0 {
+		t.Fatalf("Attempt: unexpected delivery: %v", result)
+	}
+	if !result.NextAttempt.Equal(now.Add(firstRetry)) {
+		t.Errorf("Attempt: expected the next attempt at %v, got %v", now.Add(firstRetry), result.NextAttempt)
+	}
+
+	// The last attempt fails the delivery
+	d.Attempts = maxAttempts - 1
+	result = attempt(context.Background(), d, now)
+	if result.Status != datastore.WebhookDeliveryFailed || result.Attempts != maxAttempts {
+		t.Errorf("Attempt: unexpected delivery: %v", result)
+	}
+}
+
+func TestPublicAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		public  bool
+	}{
+		{"93.184.216.34", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"127.0.0.1", false},
+		{"::1", false},
+		{"10.1.2.3", false},
+		{"172.16.0.1", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"fe80::1", false},
+		{"fd00::1", false},
+		{"0.0.0.0", false},
+		{"100.64.0.1", false},
+		{"::ffff:127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := publicAddress(net.ParseIP(tt.address)); got != tt.public {
+			t.Errorf("publicAddress(%s) = %v, want %v", tt.address, got, tt.public)
+		}
+	}
+}
+
+func TestPublishDeliver(t *testing.T) {
+	db := &datastore.MockDB{}
+	datastore.Environ = &datastore.Env{DB: db}
+
+	Publish(context.Background(), "system", datastore.WebhookEventAccount, "create", map[string]string{"authorityID": "system"})
+	Publish(context.Background(), "", datastore.WebhookEventAccount, "create", nil)
+
+	deliveries, err := db.ClaimWebhookDeliveries(context.Background(), time.Now(), batchSize)
+	if err != nil || len(deliveries) != 1 {
+		t.Fatalf("Publish: expected 1 queued delivery, got %v: %v", deliveries, err)
+	}
+	event := Event{}
+	if err = json.Unmarshal([]byte(deliveries[0].Payload), &event); err != nil {
+		t.Fatalf("Publish: invalid payload: %v", err)
+	}
+	if event.Event != datastore.WebhookEventAccount || event.Action != "create" || event.AuthorityID != "system" {
+		t.Errorf("Publish: unexpected event: %v", event)
+	}
+
+	// The mock delivery has no URL, so the attempt fails and is retried
+	delivered, err := Deliver(context.Background(), db)
+	if err != nil || delivered != 0 {
+		t.Fatalf("Deliver: expected no delivered events, got %d: %v", delivered, err)
+	}
+	deliveries, _ = db.ListAllowedWebhookDeliveries(context.Background(), 1, 1, "", datastore.User{})
+	if len(deliveries) != 1 || deliveries[0].Attempts != 1 || len(deliveries[0].LastError) == 0 {
+		t.Errorf("Deliver: unexpected deliveries: %v", deliveries)
+	}
+
+	if _, err = Deliver(context.Background(), &datastore.ErrorMockDB{}); err == nil {
+		t.Error("Deliver: expected an error")
+	}
+}
+
+func TestNewChatConfig(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings config.Settings
+		channels int
+		events   []string
+		valid    bool
+	}{
+		{"no channels", config.Settings{}, 0, defaultChatEvents, true},
+		{"slack and teams", config.Settings{SlackWebhookURL: "https://hooks.slack.com/services/T/B/X", TeamsWebhookURL: "https://example.webhook.office.com/webhookb2/X"}, 2, defaultChatEvents, true},
+		{"events", config.Settings{SlackWebhookURL: "https://hooks.slack.com/services/T/B/X", ChatEvents: []string{EventMigration}}, 1, []string{EventMigration}, true},
+		{"http URL", config.Settings{SlackWebhookURL: "http://hooks.slack.com/services/T/B/X"}, 0, nil, false},
+		{"invalid event", config.Settings{ChatEvents: []string{"invalid"}}, 0, nil, false},
+	}
+
+	for _, tt := range tests {
+		cfg, err := NewChatConfig(tt.settings)
+		if (err == nil) != tt.valid {
+			t.Errorf("%s: expected valid %v, got error: %v", tt.name, tt.valid, err)
+			continue
+		}
+		if !tt.valid {
+			continue
+		}
+		if len(cfg.Channels) != tt.channels || strings.Join(cfg.Events, ",") != strings.Join(tt.events, ",") {
+			t.Errorf("%s: unexpected config: %v", tt.name, cfg)
+		}
+	}
+}
+
+func TestChatMessage(t *testing.T) {
+	tests := []struct {
+		payload string
+		text    string
+	}{
+		{`{"event":"keypair","action":"generated","authorityID":"acme","data":{"object":"keypair","after":{"KeyName":"serial"}}}`, "signing-key serial of acme: generated"},
+		{`{"event":"signing-anomaly","action":"duplicate-assertion","authorityID":"acme","data":{"model":"alder","message":"duplicate"}}`, "signing anomaly of acme/alder: duplicate-assertion (duplicate)"},
+		{`{"event":"migration","action":"Update","data":{"version":29,"from":27}}`, "updated from version 27 to 29"},
+		{`{"event":"account","action":"rotate","authorityID":"acme","data":{"object":"apikey"}}`, "account apikey of acme: rotate"},
+	}
+
+	for _, tt := range tests {
+		message, err := ChatMessage(datastore.WebhookFormatSlack, []byte(tt.payload))
+		if err != nil {
+			t.Fatalf("ChatMessage: unexpected error: %v", err)
+		}
+		slack := map[string]string{}
+		if err := json.Unmarshal(message, &slack); err != nil || !strings.Contains(slack["text"], tt.text) {
+			t.Errorf("ChatMessage: expected '%s' in the Slack message, got: %s", tt.text, message)
+		}
+
+		message, err = ChatMessage(datastore.WebhookFormatTeams, []byte(tt.payload))
+		if err != nil {
+			t.Fatalf("ChatMessage: unexpected error: %v", err)
+		}
+		teams := map[string]string{}
+		if err := json.Unmarshal(message, &teams); err != nil || teams["@type"] != "MessageCard" || !strings.Contains(teams["text"], tt.text) {
+			t.Errorf("ChatMessage: expected '%s' in the Teams message, got: %s", tt.text, message)
+		}
+	}
+
+	if _, err := ChatMessage(datastore.WebhookFormatJSON, []byte(`{"event":"keypair"}`)); err == nil {
+		t.Error("ChatMessage: expected an error for the JSON format")
+	}
+	if _, err := ChatMessage(datastore.WebhookFormatSlack, []byte(`invalid`)); err == nil {
+		t.Error("ChatMessage: expected an error for an invalid payload")
+	}
+}
+
+func TestPostChat(t *testing.T) {
+	var bodies []string
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+	allowLocalAddresses(t)
+
+	cfg := ChatConfig{Channels: []ChatChannel{{datastore.WebhookFormatSlack, server.URL}, {datastore.WebhookFormatTeams, server.URL}}, Events: defaultChatEvents}
+	e := Event{Event: EventMigration, Action: "Update", Timestamp: time.Now().UTC(), Data: map[string]int{"version": 29, "from": 28}}
+	if err := PostChat(context.Background(), cfg, e); err != nil {
+		t.Fatalf("PostChat: unexpected error: %v", err)
+	}
+	if len(bodies) != 2 || !strings.Contains(bodies[0], `"text"`) || !strings.Contains(bodies[1], "MessageCard") {
+		t.Errorf("PostChat: unexpected messages: %v", bodies)
+	}
+
+	// The events that are not enabled are not posted
+	bodies = nil
+	if err := PostChat(context.Background(), cfg, Event{Event: datastore.WebhookEventAccount}); err != nil || len(bodies) != 0 {
+		t.Errorf("PostChat: expected no messages, got %v: %v", bodies, err)
+	}
+
+	status = http.StatusForbidden
+	if err := PostChat(context.Background(), cfg, e); err == nil {
+		t.Error("PostChat: expected an error")
+	}
+}
+
+func TestAttemptChatFormat(t *testing.T) {
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ = ioutil.ReadAll(r.Body)
+	}))
+	defer server.Close()
+	allowLocalAddresses(t)
+
+	d := datastore.WebhookDelivery{ID: 8, WebhookID: 1, Event: datastore.WebhookEventKeypair, Status: datastore.WebhookDeliveryPending,
+		Payload: `{"event":"keypair","action":"create","authorityID":"acme"}`, URL: server.URL, Secret: "secret", Format: datastore.WebhookFormatSlack}
+
+	result := attempt(context.Background(), d, time.Now().UTC())
+	if result.Status != datastore.WebhookDeliveryDelivered {
+		t.Fatalf("Attempt: unexpected delivery: %v", result)
+	}
+	if !strings.Contains(string(body), `"text":"Serial Vault: signing-key of acme: create"`) {
+		t.Errorf("Attempt: expected a Slack message, got: %s", body)
+	}
+}
+
+// allowLocalAddresses allows the connections to the test servers on the loopback address
+func allowLocalAddresses(t *testing.T) {
+	saved := allowAddress
+	allowAddress = func(net.IP) bool { return true }
+	t.Cleanup(func() { allowAddress = saved })
+}